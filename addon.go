@@ -18,6 +18,7 @@ package nvr
 import (
 	"context"
 	stdLog "log"
+	"nvr/pkg/log"
 	"nvr/pkg/monitor"
 	"nvr/pkg/storage"
 	"nvr/pkg/web"
@@ -26,8 +27,11 @@ import (
 
 type appRunHook func(context.Context, *App) error
 
+type authHook func(auth.Authenticator, storage.ConfigEnv, *log.Logger) (auth.Authenticator, error)
+
 type hookList struct {
 	newAuthenticator    auth.NewAuthenticatorFunc
+	auth                []authHook
 	onAppRun            []appRunHook
 	template            []web.TemplateHook
 	templateSub         []web.TemplateHook
@@ -38,6 +42,9 @@ type hookList struct {
 	monitorRecSave      []monitor.RecSaveHook
 	monitorRecSaved     []monitor.RecSavedHook
 	migrationMonitor    []monitor.MigationHook
+	monitorInputState   []monitor.InputStateHook
+	monitorInputSource  []monitor.InputSourceHook
+	monitorValidate     []monitor.ValidateHook
 	logSource           []string
 }
 
@@ -51,6 +58,12 @@ func SetAuthenticator(a auth.NewAuthenticatorFunc) {
 	hooks.newAuthenticator = a
 }
 
+// RegisterAuthHook registers hook used to wrap the authenticator,
+// for example to add a login method alongside the user store.
+func RegisterAuthHook(h authHook) {
+	hooks.auth = append(hooks.auth, h)
+}
+
 // RegisterAppRunHook registers hook that's called when app runs.
 func RegisterAppRunHook(h appRunHook) {
 	hooks.onAppRun = append(hooks.onAppRun, h)
@@ -103,6 +116,24 @@ func RegisterMigrationMonitorHook(h monitor.MigationHook) {
 	hooks.migrationMonitor = append(hooks.migrationMonitor, h)
 }
 
+// RegisterMonitorInputStateHook registers hook that's called
+// when the health state of a monitor input process changes.
+func RegisterMonitorInputStateHook(h monitor.InputStateHook) {
+	hooks.monitorInputState = append(hooks.monitorInputState, h)
+}
+
+// RegisterMonitorInputSourceHook registers hook that's called when
+// a monitor input process fails over to a backup source or back.
+func RegisterMonitorInputSourceHook(h monitor.InputSourceHook) {
+	hooks.monitorInputSource = append(hooks.monitorInputSource, h)
+}
+
+// RegisterMonitorValidateHook registers hook that's called when the
+// monitor configs are checked. Used to validate addon settings.
+func RegisterMonitorValidateHook(h monitor.ValidateHook) {
+	hooks.monitorValidate = append(hooks.monitorValidate, h)
+}
+
 // RegisterLogSource adds log source.
 func RegisterLogSource(s []string) {
 	hooks.logSource = append(hooks.logSource, s...)
@@ -177,12 +208,35 @@ func (h *hookList) monitor() *monitor.Hooks {
 		return nil
 	}
 
+	inputStateHook := func(i *monitor.InputProcess, health monitor.InputHealth) {
+		for _, hook := range h.monitorInputState {
+			hook(i, health)
+		}
+	}
+
+	inputSourceHook := func(i *monitor.InputProcess, source monitor.InputSource) {
+		for _, hook := range h.monitorInputSource {
+			hook(i, source)
+		}
+	}
+
+	validateHook := func(c monitor.Config) []error {
+		var errs []error
+		for _, hook := range h.monitorValidate {
+			errs = append(errs, hook(c)...)
+		}
+		return errs
+	}
+
 	return &monitor.Hooks{
-		Start:      startHook,
-		StartInput: startInputHook,
-		Event:      eventHook,
-		RecSave:    recSaveHook,
-		RecSaved:   recSavedHook,
-		Migrate:    migrateHook,
+		Start:       startHook,
+		StartInput:  startInputHook,
+		Event:       eventHook,
+		RecSave:     recSaveHook,
+		RecSaved:    recSavedHook,
+		Migrate:     migrateHook,
+		InputState:  inputStateHook,
+		InputSource: inputSourceHook,
+		Validate:    validateHook,
 	}
 }
@@ -0,0 +1,137 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package audiodetector
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"nvr"
+	"nvr/addons/detector"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"audiodetector"})
+	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvr.RegisterTplHook(modifyTemplates)
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+//go:embed worker.py
+var workerScript string
+
+// The classifier is shared by all monitors.
+var (
+	classifierMu sync.Mutex
+	classifier   *detector.Worker
+)
+
+// ErrNoClassifier the classifier hasn't been loaded.
+var ErrNoClassifier = errors.New("audio classifier not loaded")
+
+func getClassifier() (*detector.Worker, error) {
+	classifierMu.Lock()
+	defer classifierMu.Unlock()
+	if classifier == nil {
+		return nil, ErrNoClassifier
+	}
+	return classifier, nil
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("audiodetector: config: %w", err)
+	}
+	app.Router.Handle("/audiodetector.mjs", app.Auth.Admin(serveAudioDetectorMjs(config.Classes)))
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "audiodetector",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	// The monitors log an error until the files exist.
+	if _, err := os.Stat(config.ModelPath); err != nil {
+		logf(log.LevelError, "model: %v", err)
+		return nil
+	}
+	labels, err := readClassMap(config.ClassMapPath, config.Classes)
+	if err != nil {
+		logf(log.LevelError, "class map: %v", err)
+		return nil
+	}
+
+	scriptPath, err := writeScript(app.Env.TempDir)
+	if err != nil {
+		return fmt.Errorf("audiodetector: %w", err)
+	}
+
+	w := newClassifier(*config, labels, scriptPath, logf)
+	classifierMu.Lock()
+	classifier = w
+	classifierMu.Unlock()
+
+	app.WG.Add(1)
+	go func() {
+		defer app.WG.Done()
+		<-ctx.Done()
+		w.Close()
+	}()
+	return nil
+}
+
+func newClassifier(
+	c Config,
+	labels []string,
+	scriptPath string,
+	logf log.Func,
+) *detector.Worker {
+	return detector.NewWorker(detector.WorkerConfig{
+		Name:      "yamnet",
+		FrameSize: windowSize,
+		Labels:    labels,
+		Bin:       c.PythonBin,
+		Args: []string{
+			scriptPath,
+			"--model", c.ModelPath,
+			"--samples", strconv.Itoa(windowSamples),
+			"--min-score", strconv.FormatFloat(c.MinScore/100, 'f', -1, 64),
+		},
+		Logf: logf,
+	})
+}
+
+func writeScript(tempDir string) (string, error) {
+	dir := filepath.Join(tempDir, "audiodetector")
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("make temporary directory: %v: %w", dir, err)
+	}
+
+	path := filepath.Join(dir, "worker.py")
+	if err := os.WriteFile(path, []byte(workerScript), 0o600); err != nil {
+		return "", fmt.Errorf("write worker script: %w", err)
+	}
+	return path, nil
+}
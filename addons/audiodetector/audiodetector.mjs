@@ -0,0 +1,261 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import { uniqueID } from "./static/scripts/libs/common.mjs";
+import { newForm, fieldTemplate } from "./static/scripts/components/form.mjs";
+import { newModal } from "./static/scripts/components/modal.mjs";
+
+const Classes = JSON.parse(`$classesJSON`);
+
+export function audioDetection() {
+	return _audioDetection(Classes);
+}
+
+function _audioDetection(classes) {
+	const fields = {
+		enable: fieldTemplate.toggle("Enable audio detection", "false"),
+		thresholds: thresholds(classes),
+		duration: fieldTemplate.integer("Trigger duration (sec)", "", "120"),
+		schedule: fieldTemplate.text("Schedule", "always", ""),
+	};
+
+	const form = newForm(fields);
+	const modal = newModal("Audio detection", form.html());
+
+	let value = {};
+
+	let isRendered = false;
+	const render = (element) => {
+		if (isRendered) {
+			return;
+		}
+		element.insertAdjacentHTML("beforeend", modal.html);
+		element.querySelector(".js-modal").style.maxWidth = "12rem";
+
+		const $modalContent = modal.init(element);
+		form.init($modalContent);
+
+		modal.onClose(() => {
+			// Get value.
+			for (const key of Object.keys(form.fields)) {
+				value[key] = form.fields[key].value();
+			}
+		});
+
+		isRendered = true;
+	};
+
+	const update = () => {
+		// Set value.
+		for (const key of Object.keys(form.fields)) {
+			if (form.fields[key] && form.fields[key].set) {
+				if (value[key]) {
+					form.fields[key].set(value[key], fields);
+				} else {
+					form.fields[key].set("", fields);
+				}
+			}
+		}
+	};
+
+	const id = uniqueID();
+
+	return {
+		html: `
+				<li id="${id}" class="form-field" style="display:flex;">
+					<label class="form-field-label">Audio detection</label>
+					<div>
+						<button class="form-field-edit-btn" style="background: var(--color3);">
+							<img src="static/icons/feather/edit-3.svg"/>
+						</button>
+					</div>
+				</li> `,
+		value() {
+			return JSON.stringify(value);
+		},
+		set(input) {
+			value = input ? JSON.parse(input) : {};
+		},
+		validate() {
+			if (!isRendered) {
+				return "";
+			}
+			const err = form.validate();
+			if (err != "") {
+				return "Audio detection: " + err;
+			}
+			return "";
+		},
+		init($parent) {
+			const element = $parent.querySelector("#" + id);
+			element
+				.querySelector(".form-field-edit-btn")
+				.addEventListener("click", () => {
+					render(element);
+					update();
+					modal.open();
+				});
+		},
+	};
+}
+
+// Classes without a threshold never trigger events.
+const disabledThresh = -1;
+
+function thresholds(classes) {
+	const newField = (label, val) => {
+		const id = uniqueID();
+		return {
+			html: `
+				<li class="audiodetector-label-wrapper">
+					<label for="${id}" class="audiodetector-label">${label}</label>
+					<input
+						id="${id}"
+						class="audiodetector-threshold"
+						type="number"
+						value="${val}"
+					/>
+				</li>`,
+			value() {
+				return document.querySelector(`#${id}`).value;
+			},
+			label() {
+				return label;
+			},
+			validate(input) {
+				if (input == disabledThresh) {
+					return "";
+				} else if (0 > input) {
+					return "min value: 0";
+				} else if (input > 100) {
+					return "max value: 100";
+				} else {
+					return "";
+				}
+			},
+		};
+	};
+
+	let value, modal, fields, $modalContent, validateErr;
+	let isRendered = false;
+	const render = (element) => {
+		if (isRendered) {
+			return;
+		}
+		modal = newModal("Thresholds");
+		element.insertAdjacentHTML("beforeend", modal.html);
+		$modalContent = modal.init(element);
+
+		modal.onClose(() => {
+			// Get value.
+			value = {};
+			for (const field of fields) {
+				value[field.label()] = Number(field.value());
+			}
+
+			// Validate fields.
+			validateErr = "";
+			for (const field of fields) {
+				const err = field.validate(field.value());
+				if (err != "") {
+					validateErr = `"Thresholds": "${field.label()}": ${err}`;
+					break;
+				}
+			}
+		});
+		isRendered = true;
+	};
+
+	const setValue = () => {
+		fields = [];
+		for (const name of classes) {
+			const val = value[name] !== undefined ? value[name] : disabledThresh;
+			fields.push(newField(name, val));
+		}
+
+		// Render fields.
+		let html = "";
+		for (const field of fields) {
+			html += field.html;
+		}
+		$modalContent.innerHTML = html;
+	};
+
+	const id = uniqueID();
+
+	return {
+		html: `
+			<li
+				id="${id}"
+				class="form-field"
+				style="display:flex; padding-bottom:0.25rem;"
+			>
+				<label class="form-field-label">Thresholds</label>
+				<div style="width:auto">
+					<button class="form-field-edit-btn color2">
+						<img src="static/icons/feather/edit-3.svg"/>
+					</button>
+				</div>
+			</li> `,
+		value() {
+			return JSON.stringify(value);
+		},
+		set(input) {
+			value = input ? JSON.parse(input) : {};
+			validateErr = "";
+		},
+		validate() {
+			return validateErr;
+		},
+		init($parent) {
+			const element = $parent.querySelector("#" + id);
+			element
+				.querySelector(".form-field-edit-btn")
+				.addEventListener("click", () => {
+					render(element);
+					setValue();
+					modal.open();
+				});
+		},
+	};
+}
+
+// CSS.
+let $style = document.createElement("style");
+$style.innerHTML = `
+	.audiodetector-label-wrapper {
+		display: flex;
+		padding: 0.1rem;
+		border-top-style: solid;
+		border-color: var(--color1);
+		border-width: 0.03rem;
+		align-items: center;
+	}
+	.audiodetector-label-wrapper:first-child {
+		border-top-style: none;
+	}
+	.audiodetector-label {
+		font-size: 0.7rem;
+		color: var(--color-text);
+	}
+	.audiodetector-threshold {
+		margin-left: auto;
+		font-size: 0.6rem;
+		text-align: center;
+		width: 1.4rem;
+		height: 100%;
+	}`;
+
+document.querySelector("head").append($style);
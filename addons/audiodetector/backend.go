@@ -0,0 +1,303 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package audiodetector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nvr/addons/detector"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// YAMNet classifies 0.975 second windows of 16 kHz mono audio.
+const (
+	sampleRate    = 16000
+	windowSamples = 15600
+	windowSize    = windowSamples * 2 // s16le.
+
+	windowDuration = time.Duration(windowSamples) * time.Second / sampleRate
+)
+
+// ErrNoAudio the monitor doesn't have a audio track.
+var ErrNoAudio = errors.New("stream has no audio track")
+
+func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
+	// The sub stream may not include audio.
+	if i.IsSubInput() {
+		return
+	}
+
+	id := i.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		i.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "audiodetector",
+			MonitorID: id,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+
+	config, enable, err := parseConfig(i.Config)
+	if err != nil {
+		logf(log.LevelError, "could not parse config: %v", err)
+		return
+	}
+	if !enable {
+		return
+	}
+
+	i.WG.Add(1)
+	go start(ctx, i, *config, logf)
+}
+
+func start(
+	ctx context.Context,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) {
+	defer i.WG.Done()
+
+	// Wait for the monitor to start.
+	select {
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		err := run(ctx, i, config, logf)
+		if errors.Is(err, ErrNoAudio) {
+			logf(log.LevelError, "%v", err)
+			return
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logf(log.LevelError, "%v", err)
+		}
+
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func run(
+	parentCtx context.Context,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) error {
+	c, err := getClassifier()
+	if err != nil {
+		return err
+	}
+
+	infoCtx, infoCancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer infoCancel()
+	streamInfo, err := i.StreamInfo(infoCtx)
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+	if !streamInfo.AudioTrackExist {
+		return ErrNoAudio
+	}
+
+	args := generateFFmpegArgs(config, i.RTSPprotocol(), i.RTSPaddress())
+	cmd := exec.Command(i.Env.FFmpegBin, args...)
+
+	processLogFunc := func(msg string) {
+		logf(log.FFmpegLevel(config.logLevel), "process: %v", msg)
+	}
+	process := ffmpeg.NewProcess(cmd).
+		StderrLogger(processLogFunc)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("stdout: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	inst := newInstance(c, config, i.SendEvent, logf)
+	i.WG.Add(1)
+	go func() {
+		defer i.WG.Done()
+		err := inst.run(ctx, stdout)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+			logf(log.LevelError, "instance: %v", err)
+		}
+		cancel()
+	}()
+
+	logf(log.LevelInfo, "starting process: %v", cmd)
+
+	if err := process.Start(ctx); err != nil {
+		return fmt.Errorf("process crashed: %w", err)
+	}
+	return nil
+}
+
+func generateFFmpegArgs(c config, rtspProtocol string, rtspAddress string) []string {
+	// Output.
+	//	ffmpeg -y -threads 1 -loglevel error -rtsp_transport tcp -i rtsp://ip
+	//    -vn -ac 1 -ar 16000 -f s16le -
+	return []string{
+		"-y", "-threads", "1", "-loglevel", c.logLevel,
+		"-rtsp_transport", rtspProtocol, "-i", rtspAddress,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(sampleRate), "-f", "s16le", "-",
+	}
+}
+
+// Time limit of a single classification.
+const classifyTimeout = 10 * time.Second
+
+type window struct {
+	data []byte
+	time time.Time
+}
+
+type instance struct {
+	classifier detector.Detector
+	c          config
+	sendEvent  monitor.SendEventFunc
+	logf       log.Func
+}
+
+func newInstance(
+	classifier detector.Detector,
+	c config,
+	sendEvent monitor.SendEventFunc,
+	logf log.Func,
+) *instance {
+	return &instance{
+		classifier: classifier,
+		c:          c,
+		sendEvent:  sendEvent,
+		logf:       logf,
+	}
+}
+
+// run reads audio windows until the process exits. Only the
+// latest window is kept if the classifier is busy.
+func (i *instance) run(ctx context.Context, stdout io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	latest := make(chan window, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- i.readWindows(ctx, stdout, latest)
+	}()
+
+	for {
+		select {
+		case err := <-readErr:
+			return err
+		case w := <-latest:
+			if err := i.classify(ctx, w); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (i *instance) readWindows(ctx context.Context, stdout io.Reader, latest chan window) error {
+	for {
+		buf := make([]byte, windowSize)
+		if _, err := io.ReadFull(stdout, buf); err != nil {
+			return fmt.Errorf("read stdout: %w", err)
+		}
+		// The timestamp is the start of the window.
+		w := window{
+			data: buf,
+			time: time.Now().Add(-windowDuration - i.c.timestampOffset),
+		}
+
+		select {
+		case latest <- w:
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// Replace the waiting window.
+			select {
+			case <-latest:
+				i.logf(log.LevelDebug, "classifier is busy, dropped audio window")
+			default:
+			}
+			latest <- w
+		}
+	}
+}
+
+func (i *instance) classify(ctx context.Context, w window) error {
+	if !i.c.schedule.Active(w.time) {
+		return nil
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, classifyTimeout)
+	defer cancel()
+
+	detections, err := i.classifier.Detect(ctx2, w.data)
+	if err != nil {
+		return fmt.Errorf("classify: %w", err)
+	}
+
+	parsed := i.parseDetections(detections)
+	if len(parsed) == 0 {
+		return nil
+	}
+
+	i.logf(log.LevelDebug, "trigger: class:%v score:%.1f",
+		parsed[0].Label, parsed[0].Score)
+
+	err = i.sendEvent(storage.Event{
+		Time:        w.time,
+		Detections:  parsed,
+		Duration:    windowDuration,
+		RecDuration: i.c.recDuration,
+	})
+	if err != nil {
+		return fmt.Errorf("send event: %w", err)
+	}
+	return nil
+}
+
+// parseDetections drops classes below the threshold. Audio
+// detections don't have a region.
+func (i *instance) parseDetections(detections []detector.Detection) []storage.Detection {
+	var parsed []storage.Detection
+	for _, d := range detections {
+		threshold, exist := i.c.thresholds[d.Label]
+		if !exist || d.Score < threshold {
+			continue
+		}
+		parsed = append(parsed, storage.Detection{
+			Label: d.Label,
+			Score: d.Score,
+		})
+	}
+	return parsed
+}
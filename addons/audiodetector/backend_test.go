@@ -0,0 +1,129 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package audiodetector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/addons/detector"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubClassifier struct {
+	classifyFunc func(context.Context, []byte) ([]detector.Detection, error)
+}
+
+func (stubClassifier) Name() string     { return "stub" }
+func (stubClassifier) Size() (int, int) { return 0, 0 }
+func (stubClassifier) Labels() []string { return nil }
+func (c stubClassifier) Detect(ctx context.Context, w []byte) ([]detector.Detection, error) {
+	return c.classifyFunc(ctx, w)
+}
+
+func newTestInstance(
+	c detector.Detector,
+	sendEvent func(storage.Event) error,
+) *instance {
+	conf := config{
+		thresholds:  thresholds{"Glass": 50},
+		recDuration: 3 * time.Second,
+	}
+	logf := func(log.Level, string, ...interface{}) {}
+	return newInstance(c, conf, sendEvent, logf)
+}
+
+func TestGenerateFFmpegArgs(t *testing.T) {
+	args := generateFFmpegArgs(config{logLevel: "error"}, "tcp", "rtsp://x")
+	expected := []string{
+		"-y", "-threads", "1", "-loglevel", "error",
+		"-rtsp_transport", "tcp", "-i", "rtsp://x",
+		"-vn", "-ac", "1", "-ar", "16000", "-f", "s16le", "-",
+	}
+	require.Equal(t, expected, args)
+}
+
+func TestParseDetections(t *testing.T) {
+	i := newTestInstance(nil, nil)
+	detections := []detector.Detection{
+		{Label: "Glass", Score: 60},
+		{Label: "Glass", Score: 40},
+		{Label: "Speech", Score: 90},
+	}
+	expected := []storage.Detection{{Label: "Glass", Score: 60}}
+	require.Equal(t, expected, i.parseDetections(detections))
+}
+
+func TestInstance(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var windows int
+		c := stubClassifier{
+			classifyFunc: func(_ context.Context, w []byte) ([]detector.Detection, error) {
+				require.Len(t, w, windowSize)
+				windows++
+				if windows == 1 {
+					return []detector.Detection{{Label: "Speech", Score: 90}}, nil
+				}
+				return []detector.Detection{{Label: "Glass", Score: 70}}, nil
+			},
+		}
+		var events []storage.Event
+		i := newTestInstance(c, func(e storage.Event) error {
+			events = append(events, e)
+			return nil
+		})
+
+		// The second window is classified after the first is done.
+		stdout, w := io.Pipe()
+		done := make(chan error)
+		go func() { done <- i.run(context.Background(), stdout) }()
+		for n := 0; n < 2; n++ {
+			_, err := w.Write(make([]byte, windowSize))
+			require.NoError(t, err)
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.Close()
+		require.ErrorIs(t, <-done, io.EOF)
+
+		require.Equal(t, 2, windows)
+		require.Len(t, events, 1)
+		require.Equal(t, []storage.Detection{{Label: "Glass", Score: 70}}, events[0].Detections)
+		require.Equal(t, windowDuration, events[0].Duration)
+		require.Equal(t, 3*time.Second, events[0].RecDuration)
+	})
+	t.Run("classifyErr", func(t *testing.T) {
+		errMock := errors.New("mock")
+		c := stubClassifier{
+			classifyFunc: func(context.Context, []byte) ([]detector.Detection, error) {
+				return nil, errMock
+			},
+		}
+		i := newTestInstance(c, nil)
+		err := i.run(context.Background(), bytes.NewReader(make([]byte, windowSize)))
+		require.ErrorIs(t, err, errMock)
+	})
+	t.Run("partialWindow", func(t *testing.T) {
+		i := newTestInstance(nil, nil)
+		err := i.run(context.Background(), bytes.NewReader(make([]byte, 10)))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
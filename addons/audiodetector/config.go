@@ -0,0 +1,247 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package audiodetector
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Config global addon config.
+type Config struct {
+	// Python interpreter with tflite_runtime and numpy installed.
+	PythonBin string `json:"pythonBin"`
+
+	// YAMNet TFLite model.
+	ModelPath string `json:"modelPath"`
+
+	// YAMNet class map CSV with the columns index, mid and display_name.
+	ClassMapPath string `json:"classMapPath"`
+
+	// Scores below this are dropped by the worker, 0-100.
+	MinScore float64 `json:"minScore"`
+
+	// Sound classes that can be selected in the monitor settings.
+	Classes []string `json:"classes"`
+}
+
+// Config errors.
+var (
+	ErrNoModelPath  = errors.New("model path is empty")
+	ErrNoClasses    = errors.New("no classes")
+	ErrUnknownClass = errors.New("unknown class")
+)
+
+func (c Config) validate() error {
+	if c.ModelPath == "" {
+		return ErrNoModelPath
+	}
+	if len(c.Classes) == 0 {
+		return ErrNoClasses
+	}
+	return nil
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "audiodetector.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		data, _ := json.MarshalIndent(defaultConfig(configDir), "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func defaultConfig(configDir string) Config {
+	modelDir := filepath.Join(configDir, "audiodetector")
+	return Config{
+		PythonBin:    "python3",
+		ModelPath:    filepath.Join(modelDir, "yamnet.tflite"),
+		ClassMapPath: filepath.Join(modelDir, "yamnet_class_map.csv"),
+		MinScore:     10,
+		Classes: []string{
+			"Glass",
+			"Shatter",
+			"Alarm",
+			"Smoke detector, smoke alarm",
+			"Siren",
+			"Dog",
+			"Bark",
+			"Screaming",
+			"Gunshot, gunfire",
+			"Baby cry, infant cry",
+		},
+	}
+}
+
+// readClassMap returns the display names in class order. The
+// configured classes must exist since the model can't detect others.
+func readClassMap(path string, classes []string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse class map: %w", err)
+	}
+
+	var labels []string
+	known := make(map[string]bool)
+	for i, record := range records {
+		if len(record) < 3 {
+			return nil, fmt.Errorf("parse class map: line %v: expected 3 columns", i+1)
+		}
+		id, err := strconv.Atoi(record[0])
+		if err != nil {
+			// Header.
+			continue
+		}
+		for len(labels) <= id {
+			labels = append(labels, "")
+		}
+		labels[id] = record[2]
+		known[record[2]] = true
+	}
+
+	for _, class := range classes {
+		if !known[class] {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownClass, class)
+		}
+	}
+	return labels, nil
+}
+
+type config struct {
+	monitorID       string
+	logLevel        string
+	timestampOffset time.Duration
+	thresholds      thresholds
+	recDuration     time.Duration
+	schedule        schedule.Schedule
+}
+
+// thresholds minimum score for each class. Classes
+// without a threshold never trigger events.
+type thresholds map[string]float64
+
+type rawConfigV0 struct {
+	Enable     string `json:"enable"`
+	Thresholds string `json:"thresholds"`
+	Duration   string `json:"duration"`
+	Schedule   string `json:"schedule"`
+}
+
+// Monitor config errors.
+var (
+	ErrInvalidDuration = errors.New("invalid duration")
+	ErrInvalidScore    = errors.New("invalid threshold")
+)
+
+const defaultRecDuration = 120 * time.Second
+
+func parseConfig(c monitor.Config) (*config, bool, error) {
+	rawDetector := c.Get("audioDetection")
+	if rawDetector == "" {
+		return nil, false, nil
+	}
+
+	var rawConf rawConfigV0
+	if err := json.Unmarshal([]byte(rawDetector), &rawConf); err != nil {
+		return nil, false, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if rawConf.Enable != "true" {
+		return nil, false, nil
+	}
+
+	timestampOffset, err := ffmpeg.ParseTimestampOffset(c.TimestampOffset())
+	if err != nil {
+		return nil, false, err
+	}
+
+	thresholds, err := parseThresholds(rawConf.Thresholds)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sched, err := schedule.Parse(rawConf.Schedule)
+	if err != nil {
+		return nil, false, err
+	}
+
+	recDuration := defaultRecDuration
+	if rawConf.Duration != "" {
+		seconds, err := strconv.ParseFloat(rawConf.Duration, 64)
+		if err != nil || seconds < 0 {
+			return nil, false, fmt.Errorf("%w: %q", ErrInvalidDuration, rawConf.Duration)
+		}
+		recDuration = time.Duration(seconds * float64(time.Second))
+	}
+
+	return &config{
+		monitorID:       c.ID(),
+		logLevel:        c.LogLevel(),
+		timestampOffset: timestampOffset,
+		thresholds:      thresholds,
+		recDuration:     recDuration,
+		schedule:        sched,
+	}, true, nil
+}
+
+// parseThresholds a threshold of -1 disables the class.
+func parseThresholds(raw string) (thresholds, error) {
+	t := thresholds{}
+	if raw == "" {
+		return t, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, fmt.Errorf("unmarshal thresholds: %w", err)
+	}
+	for class, score := range t {
+		if score == -1 {
+			delete(t, class)
+			continue
+		}
+		if score < 0 || score > 100 {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidScore, class, score)
+		}
+	}
+	return t, nil
+}
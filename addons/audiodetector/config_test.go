@@ -0,0 +1,129 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package audiodetector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		configDir := t.TempDir()
+		config, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig(configDir), *config)
+	})
+	t.Run("noClasses", func(t *testing.T) {
+		configDir := t.TempDir()
+		raw := `{"modelPath":"x","classes":[]}`
+		err := os.WriteFile(filepath.Join(configDir, "audiodetector.json"), []byte(raw), 0o600)
+		require.NoError(t, err)
+
+		_, err = readConfig(configDir)
+		require.ErrorIs(t, err, ErrNoClasses)
+	})
+}
+
+func TestReadClassMap(t *testing.T) {
+	const classMap = "index,mid,display_name\n" +
+		"0,/m/09x0r,Speech\n" +
+		"2,/m/05zppz,\"Smoke detector, smoke alarm\"\n"
+
+	path := filepath.Join(t.TempDir(), "class_map.csv")
+	require.NoError(t, os.WriteFile(path, []byte(classMap), 0o600))
+
+	labels, err := readClassMap(path, []string{"Smoke detector, smoke alarm"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Speech", "", "Smoke detector, smoke alarm"}, labels)
+
+	_, err = readClassMap(path, []string{"Glass"})
+	require.ErrorIs(t, err, ErrUnknownClass)
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		raw := `
+		{
+			"enable":     "true",
+			"thresholds": "{\"Glass\":50,\"Dog\":-1}",
+			"duration":   "60"
+		}`
+		c := monitor.NewConfig(monitor.RawConfig{
+			"id":              "1",
+			"logLevel":        "2",
+			"timestampOffset": "3",
+			"audioDetection":  raw,
+		})
+		actual, enable, err := parseConfig(c)
+		require.NoError(t, err)
+		require.True(t, enable)
+
+		expected := config{
+			monitorID:       "1",
+			logLevel:        "2",
+			timestampOffset: 3 * time.Millisecond,
+			thresholds:      thresholds{"Glass": 50},
+			recDuration:     60 * time.Second,
+		}
+		require.Equal(t, expected, *actual)
+	})
+	t.Run("defaults", func(t *testing.T) {
+		c := monitor.NewConfig(monitor.RawConfig{
+			"audioDetection": `{"enable":"true"}`,
+		})
+		actual, enable, err := parseConfig(c)
+		require.NoError(t, err)
+		require.True(t, enable)
+		require.Equal(t, defaultRecDuration, actual.recDuration)
+		require.Equal(t, thresholds{}, actual.thresholds)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		for _, raw := range []string{"", `{"enable":"false"}`} {
+			c := monitor.NewConfig(monitor.RawConfig{"audioDetection": raw})
+			_, enable, err := parseConfig(c)
+			require.NoError(t, err)
+			require.False(t, enable)
+		}
+	})
+	errorCases := map[string]struct {
+		raw         string
+		expectedErr error
+	}{
+		"duration": {
+			`{"enable":"true","duration":"-1"}`, ErrInvalidDuration,
+		},
+		"threshold": {
+			`{"enable":"true","thresholds":"{\"Glass\":101}"}`, ErrInvalidScore,
+		},
+		"schedule": {
+			`{"enable":"true","schedule":"[{\"days\":[\"x\"]}]"}`, schedule.ErrInvalidDay,
+		},
+	}
+	for name, tc := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			c := monitor.NewConfig(monitor.RawConfig{"audioDetection": tc.raw})
+			_, _, err := parseConfig(c)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
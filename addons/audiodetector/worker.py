@@ -0,0 +1,90 @@
+#!/usr/bin/env python3
+#
+# Copyright 2020-2022 The OS-NVR Authors.
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation; either version 2 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+# YAMNet worker started by the audiodetector addon, shared by all monitors.
+#
+# Reads windows of 16 kHz mono s16le samples from stdin and writes
+# one JSON line for each window to stdout. The box is unused.
+#
+#   {"detections": [{"class": 0, "score": 0.9, "box": [0, 0, 0, 0]}]}
+#   {"error": "message"}
+
+import argparse
+import json
+import sys
+
+import numpy as np
+from tflite_runtime.interpreter import Interpreter
+
+
+def parse_args():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--model", required=True)
+    parser.add_argument("--samples", required=True, type=int)
+    parser.add_argument("--min-score", default=0.1, type=float)
+    return parser.parse_args()
+
+
+def new_interpreter(args):
+    interpreter = Interpreter(model_path=args.model)
+    inp = interpreter.get_input_details()[0]
+    interpreter.resize_tensor_input(inp["index"], [args.samples], strict=False)
+    interpreter.allocate_tensors()
+    return interpreter
+
+
+def classify(interpreter, args, waveform):
+    inp = interpreter.get_input_details()[0]
+    interpreter.set_tensor(inp["index"], waveform)
+    interpreter.invoke()
+
+    # Scores for each 0.48 second frame, the highest is used.
+    out = interpreter.get_output_details()[0]
+    scores = interpreter.get_tensor(out["index"]).reshape(-1, out["shape"][-1]).max(axis=0)
+
+    detections = []
+    for cls in np.flatnonzero(scores >= args.min_score):
+        detections.append({
+            "class": int(cls),
+            "score": float(scores[cls]),
+            "box": [0, 0, 0, 0],
+        })
+    return detections
+
+
+def main():
+    args = parse_args()
+    interpreter = new_interpreter(args)
+    print("loaded model: " + args.model, file=sys.stderr, flush=True)
+
+    window_size = args.samples * 2
+    stdin = sys.stdin.buffer
+    while True:
+        data = stdin.read(window_size)
+        if len(data) < window_size:
+            return
+        waveform = np.frombuffer(data, dtype="<i2").astype(np.float32) / 32768
+        try:
+            response = {"detections": classify(interpreter, args, waveform)}
+        except Exception as e:  # pylint: disable=broad-except
+            response = {"error": str(e)}
+        sys.stdout.write(json.dumps(response) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()
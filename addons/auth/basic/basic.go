@@ -30,6 +30,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -44,6 +45,16 @@ type Authenticator struct {
 	accounts  map[string]auth.Account
 	authCache map[string]auth.ValidateResponse
 
+	// Cached requests of two-factor users expire
+	// and are only valid from the same IP.
+	cacheExpiry map[string]twoFactorCache
+
+	// Pending two-factor secrets by user id.
+	enrollments map[string]string
+
+	// Last used two-factor time step by user id, codes can only be used once.
+	totpSteps map[string]uint64
+
 	hashCost int
 
 	logger *log.Logger
@@ -54,6 +65,11 @@ type Authenticator struct {
 	mu       sync.Mutex
 }
 
+type twoFactorCache struct {
+	ip      string
+	expires time.Time
+}
+
 // NewBasicAuthenticator creates basic authenticator.
 func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Authenticator, error) {
 	path := filepath.Join(env.ConfigDir, "users.json")
@@ -62,6 +78,10 @@ func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Auth
 		accounts:  make(map[string]auth.Account),
 		authCache: make(map[string]auth.ValidateResponse),
 
+		cacheExpiry: make(map[string]twoFactorCache),
+		enrollments: make(map[string]string),
+		totpSteps:   make(map[string]uint64),
+
 		hashCost: auth.DefaultBcryptHashCost,
 		logger:   logger,
 	}
@@ -86,16 +106,31 @@ func NewBasicAuthenticator(env storage.ConfigEnv, logger *log.Logger) (auth.Auth
 	return &a, nil
 }
 
+// totpCacheDuration time that the request of a user with two-factor
+// authentication is cached, the TOTP period. The code can't be replayed
+// after that, the session of the browser keeps the user logged in.
+const totpCacheDuration = 30 * time.Second
+
 // ValidateRequest Should always take the same amount of
 // time to run, even when username or password is invalid.
+// Users with two-factor authentication append the code
+// or a recovery code to the password.
 func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 	req := r.Header.Get("Authorization")
+	ip := auth.ClientIP(r)
+	now := time.Now()
 
 	a.mu.Lock()
-	if _, reqExistInCache := a.authCache[req]; reqExistInCache {
-		res := a.authCache[req]
-		a.mu.Unlock()
-		return res
+	if res, reqExistInCache := a.authCache[req]; reqExistInCache {
+		cache, hasExpiry := a.cacheExpiry[req]
+		if !hasExpiry {
+			a.mu.Unlock()
+			return res
+		}
+		if cache.ip == ip && now.Before(cache.expires) {
+			a.mu.Unlock()
+			return res
+		}
 	}
 
 	name, pass := parseBasicAuth(req)
@@ -111,6 +146,20 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 		bcrypt.GenerateFromPassword([]byte(name), a.hashCost) //nolint:errcheck
 		return auth.ValidateResponse{}
 	}
+	if user.TOTPSecret != "" {
+		sessionUser, hasSession := auth.SessionUser(r)
+		granted := hasSession && sessionUser == user.ID
+		if !a.validateTwoFactor(user, pass, granted) {
+			return auth.ValidateResponse{}
+		}
+		a.mu.Lock()
+		res := auth.ValidateResponse{IsValid: true, User: a.accounts[user.ID]}
+		a.pruneCacheUnsafe(now)
+		a.authCache[req] = res
+		a.cacheExpiry[req] = twoFactorCache{ip: ip, expires: now.Add(totpCacheDuration)}
+		a.mu.Unlock()
+		return res
+	}
 	if passwordsMatch(user.Password, pass) {
 		a.mu.Lock()
 		res := auth.ValidateResponse{IsValid: true, User: user}
@@ -121,6 +170,78 @@ func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
 	return auth.ValidateResponse{}
 }
 
+// pruneCacheUnsafe removes expired requests of two-factor users.
+func (a *Authenticator) pruneCacheUnsafe(now time.Time) {
+	for req, cache := range a.cacheExpiry {
+		if !now.Before(cache.expires) {
+			delete(a.authCache, req)
+			delete(a.cacheExpiry, req)
+		}
+	}
+}
+
+// splitTwoFactor splits the password from the appended code. Recovery
+// codes have a dash before the last 4 characters, "1a2b-3c4d", the
+// same position is a digit in a TOTP code.
+func splitTwoFactor(pass string) (string, string, bool) {
+	const codeLength = 6
+	n := len(pass) - auth.RecoveryCodeLength
+	if n > 0 && pass[len(pass)-5] == '-' {
+		return pass[:n], pass[n:], true
+	}
+	if len(pass) > codeLength {
+		n = len(pass) - codeLength
+		return pass[:n], pass[n:], false
+	}
+	return pass, "", false
+}
+
+// validateTwoFactor validates a password followed by a code or a recovery
+// code. Used recovery codes are removed. The code isn't checked if the
+// request has a session of the user. The password is only compared once,
+// so it takes the same time as other logins. Must be called with hashLock held.
+func (a *Authenticator) validateTwoFactor(user auth.Account, pass string, granted bool) bool {
+	password, code, isRecoveryCode := splitTwoFactor(pass)
+	if !passwordsMatch(user.Password, password) || code == "" {
+		return false
+	}
+	if granted {
+		return true
+	}
+
+	if !isRecoveryCode {
+		step, valid := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+		if !valid {
+			return false
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if step <= a.totpSteps[user.ID] {
+			return false
+		}
+		a.totpSteps[user.ID] = step
+		return true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	account := a.accounts[user.ID]
+	remaining, valid := auth.UseRecoveryCode(account.RecoveryCodes, code)
+	if !valid {
+		return false
+	}
+	account.RecoveryCodes = remaining
+	a.accounts[user.ID] = account
+	if err := a.saveToFile(); err != nil {
+		a.logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "auth",
+			Msg:   fmt.Sprintf("save used recovery code: %v", err),
+		})
+	}
+	return true
+}
+
 func passwordsMatch(hash []byte, plaintext string) bool {
 	if err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext)); err != nil {
 		return false
@@ -183,6 +304,11 @@ func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
 			ID:       user.ID,
 			Username: user.Username,
 			IsAdmin:  user.IsAdmin,
+			Role:     user.Role,
+			Monitors: user.Monitors,
+			Groups:   user.Groups,
+
+			TOTPEnabled: user.TOTPSecret != "",
 		}
 	}
 	return list
@@ -220,6 +346,9 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user.ID = req.ID
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
+	user.Role = req.Role
+	user.Monitors = req.Monitors
+	user.Groups = req.Groups
 	if req.PlainPassword != "" {
 		hashedNewPassword, err := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
 		if err != nil {
@@ -261,6 +390,81 @@ func (a *Authenticator) UserDelete(id string) error {
 	return nil
 }
 
+// TOTPEnroll starts the two-factor enrollment of the user.
+func (a *Authenticator) TOTPEnroll(id string) (auth.TOTPEnrollment, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, exists := a.accounts[id]
+	if !exists {
+		return auth.TOTPEnrollment{}, ErrUserNotExist
+	}
+	if user.TOTPSecret != "" {
+		return auth.TOTPEnrollment{}, auth.ErrTOTPEnabled
+	}
+	enrollment := auth.NewTOTPEnrollment(user.Username)
+	a.enrollments[id] = enrollment.Secret
+	return enrollment, nil
+}
+
+// TOTPConfirm enables two-factor authentication if the code is valid.
+func (a *Authenticator) TOTPConfirm(id string, code string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, exists := a.accounts[id]
+	if !exists {
+		return nil, ErrUserNotExist
+	}
+	secret, exists := a.enrollments[id]
+	if !exists {
+		return nil, auth.ErrTOTPNotEnrolled
+	}
+	step, valid := auth.ValidateTOTP(secret, code, time.Now())
+	if !valid {
+		return nil, auth.ErrTOTPInvalidCode
+	}
+
+	codes, hashes := auth.GenRecoveryCodes()
+	user.TOTPSecret = secret
+	user.RecoveryCodes = hashes
+	a.accounts[id] = user
+	delete(a.enrollments, id)
+	a.totpSteps[id] = step
+
+	// Reset cache.
+	a.authCache = make(map[string]auth.ValidateResponse)
+	a.cacheExpiry = make(map[string]twoFactorCache)
+
+	if err := a.saveToFile(); err != nil {
+		return nil, fmt.Errorf("save users to file: %w", err)
+	}
+	return codes, nil
+}
+
+// TOTPDisable disables two-factor authentication for the user.
+func (a *Authenticator) TOTPDisable(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, exists := a.accounts[id]
+	if !exists {
+		return ErrUserNotExist
+	}
+	if user.TOTPSecret == "" {
+		return auth.ErrTOTPNotEnabled
+	}
+	user.TOTPSecret = ""
+	user.RecoveryCodes = nil
+	a.accounts[id] = user
+
+	// Reset cache.
+	a.authCache = make(map[string]auth.ValidateResponse)
+	a.cacheExpiry = make(map[string]twoFactorCache)
+
+	if err := a.saveToFile(); err != nil {
+		return fmt.Errorf("save users to file: %w", err)
+	}
+	return nil
+}
+
 func (a *Authenticator) saveToFile() error {
 	users, err := json.MarshalIndent(a.accounts, "", "  ")
 	if err != nil {
@@ -21,7 +21,9 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
@@ -71,6 +73,10 @@ func newTestAuth(t *testing.T) (string, *Authenticator, func()) {
 		accounts:  users,
 		authCache: make(map[string]auth.ValidateResponse),
 
+		cacheExpiry: make(map[string]twoFactorCache),
+		enrollments: make(map[string]string),
+		totpSteps:   make(map[string]uint64),
+
 		hashCost: bcrypt.MinCost,
 		logger:   &log.Logger{},
 	}
@@ -352,3 +358,108 @@ func TestBasicAuthenticator(t *testing.T) {
 		require.True(t, response2.IsValid)
 	})
 }
+
+func TestTOTP(t *testing.T) {
+	_, a, cancel := newTestAuth(t)
+	defer cancel()
+
+	validate := func(pass string) bool {
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		require.NoError(t, err)
+		r.SetBasicAuth("user", pass)
+		return a.ValidateRequest(r).IsValid
+	}
+
+	_, err := a.TOTPConfirm("2", "000000")
+	require.ErrorIs(t, err, auth.ErrTOTPNotEnrolled)
+	_, err = a.TOTPEnroll("x")
+	require.ErrorIs(t, err, ErrUserNotExist)
+
+	enrollment, err := a.TOTPEnroll("2")
+	require.NoError(t, err)
+	require.Contains(t, enrollment.URI, "otpauth://totp/OS-NVR:user?")
+
+	_, err = a.TOTPConfirm("2", "x")
+	require.ErrorIs(t, err, auth.ErrTOTPInvalidCode)
+
+	now := time.Now()
+	code, err := auth.TOTPCode(enrollment.Secret, now)
+	require.NoError(t, err)
+	recoveryCodes, err := a.TOTPConfirm("2", code)
+	require.NoError(t, err)
+	require.Len(t, recoveryCodes, 10)
+	require.True(t, a.UsersList()["2"].TOTPEnabled)
+
+	_, err = a.TOTPEnroll("2")
+	require.ErrorIs(t, err, auth.ErrTOTPEnabled)
+
+	// The password alone and reused codes are rejected.
+	require.False(t, validate("pass2"))
+	require.False(t, validate("pass2"+code))
+
+	next, err := auth.TOTPCode(enrollment.Secret, now.Add(30*time.Second))
+	require.NoError(t, err)
+	require.True(t, validate("pass2"+next))
+	require.False(t, validate("wrong"+next))
+
+	// Recovery codes can only be used once.
+	require.True(t, validate("pass2"+recoveryCodes[0]))
+	require.False(t, validate("pass2"+recoveryCodes[0]+" "))
+	require.Len(t, a.accounts["2"].RecoveryCodes, 9)
+
+	// Cached requests are only valid from the same IP.
+	r, err := http.NewRequest(http.MethodGet, "", nil)
+	require.NoError(t, err)
+	r.SetBasicAuth("user", "pass2"+next)
+	r.RemoteAddr = "1.2.3.4:1234"
+	require.False(t, a.ValidateRequest(r).IsValid)
+
+	// Cached requests expire.
+	for req := range a.cacheExpiry {
+		a.cacheExpiry[req] = twoFactorCache{}
+	}
+	require.False(t, validate("pass2"+next))
+
+	// The code doesn't have to be repeated within a session.
+	sessions, err := auth.NewSessionStore(
+		filepath.Join(t.TempDir(), "sessions.json"), time.Hour, 0)
+	require.NoError(t, err)
+	raw, err := sessions.Create(a.accounts["2"], "", "")
+	require.NoError(t, err)
+	withSessions := auth.WithSessions(a, sessions)
+
+	r, err = http.NewRequest(http.MethodGet, "", nil)
+	require.NoError(t, err)
+	r.SetBasicAuth("user", "pass2"+next)
+	require.False(t, withSessions.ValidateRequest(r).IsValid)
+	r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: raw})
+	require.True(t, withSessions.ValidateRequest(r).IsValid)
+	r.SetBasicAuth("user", "wrong"+next)
+	require.False(t, withSessions.ValidateRequest(r).IsValid)
+
+	require.NoError(t, a.TOTPDisable("2"))
+	require.ErrorIs(t, a.TOTPDisable("2"), auth.ErrTOTPNotEnabled)
+	require.True(t, validate("pass2"))
+}
+
+func TestSplitTwoFactor(t *testing.T) {
+	cases := map[string]struct {
+		pass           string
+		password       string
+		code           string
+		isRecoveryCode bool
+	}{
+		"totp":         {"pass123456", "pass", "123456", false},
+		"recoveryCode": {"pass1a2b-3c4d", "pass", "1a2b-3c4d", true},
+		"dashPassword": {"pa-ss123456", "pa-ss", "123456", false},
+		"short":        {"123456", "123456", "", false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			password, code, isRecoveryCode := splitTwoFactor(tc.pass)
+			require.Equal(t, tc.password, password)
+			require.Equal(t, tc.code, code)
+			require.Equal(t, tc.isRecoveryCode, isRecoveryCode)
+		})
+	}
+}
@@ -113,6 +113,9 @@ func (a *Authenticator) UsersList() map[string]auth.AccountObfuscated {
 			ID:       user.ID,
 			Username: user.Username,
 			IsAdmin:  user.IsAdmin,
+			Role:     user.Role,
+			Monitors: user.Monitors,
+			Groups:   user.Groups,
 		}
 	}
 	return list
@@ -150,6 +153,9 @@ func (a *Authenticator) UserSet(req auth.SetUserRequest) error {
 	user.ID = req.ID
 	user.Username = req.Username
 	user.IsAdmin = req.IsAdmin
+	user.Role = req.Role
+	user.Monitors = req.Monitors
+	user.Groups = req.Groups
 	if req.PlainPassword != "" {
 		hashedNewPassword, _ := bcrypt.GenerateFromPassword([]byte(req.PlainPassword), a.hashCost)
 		user.Password = hashedNewPassword
@@ -181,6 +187,21 @@ func (a *Authenticator) UserDelete(id string) error {
 	return nil
 }
 
+// TOTPEnroll two-factor authentication is not supported without authentication.
+func (a *Authenticator) TOTPEnroll(string) (auth.TOTPEnrollment, error) {
+	return auth.TOTPEnrollment{}, auth.ErrTOTPNotSupported
+}
+
+// TOTPConfirm is not supported.
+func (a *Authenticator) TOTPConfirm(string, string) ([]string, error) {
+	return nil, auth.ErrTOTPNotSupported
+}
+
+// TOTPDisable is not supported.
+func (a *Authenticator) TOTPDisable(string) error {
+	return auth.ErrTOTPNotSupported
+}
+
 // User allows all requests.
 func (a *Authenticator) User(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
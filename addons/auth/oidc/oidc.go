@@ -0,0 +1,544 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/web/auth"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"oidc"})
+	nvr.RegisterAuthHook(wrapAuthenticator)
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+var addon struct {
+	a  *Authenticator
+	mu sync.Mutex
+}
+
+func wrapAuthenticator(
+	a auth.Authenticator,
+	env storage.ConfigEnv,
+	logger *log.Logger,
+) (auth.Authenticator, error) {
+	config, err := readConfig(env.ConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: config: %w", err)
+	}
+	if config.Issuer == "" {
+		return a, nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	o := newAuthenticator(a, *config, newProvider(*config, client), logger)
+
+	addon.mu.Lock()
+	addon.a = o
+	addon.mu.Unlock()
+	return o, nil
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	addon.mu.Lock()
+	a := addon.a
+	addon.mu.Unlock()
+	if a == nil {
+		return nil
+	}
+	app.Router.Handle("/oidc/login", a.Login())
+	app.Router.Handle("/oidc/callback", a.Callback())
+	return nil
+}
+
+// Config oidc.json.
+type Config struct {
+	// Empty disables the addon.
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+
+	// Must end with "/oidc/callback".
+	RedirectURL string   `json:"redirectUrl"`
+	Scopes      []string `json:"scopes"`
+
+	UsernameClaim string `json:"usernameClaim"`
+	GroupsClaim   string `json:"groupsClaim"`
+
+	// The first mapping with a group of the user is used.
+	Roles []RoleMapping `json:"roles"`
+
+	// Hours until the user has to log in again.
+	SessionDuration int `json:"sessionDuration"`
+}
+
+// RoleMapping maps a provider group to a account role.
+type RoleMapping struct {
+	// "*" matches all users.
+	Group    string    `json:"group"`
+	Admin    bool      `json:"admin"`
+	Role     auth.Role `json:"role"`
+	Monitors []string  `json:"monitors"`
+
+	// Monitor groups of the account, not provider groups.
+	MonitorGroups []string `json:"monitorGroups"`
+}
+
+const callbackPath = "/oidc/callback"
+
+// Config errors.
+var (
+	ErrClientIDMissing    = errors.New("clientId missing")
+	ErrInvalidRedirectURL = errors.New("redirectUrl must end with " + callbackPath)
+	ErrGroupMissing       = errors.New("role mapping group missing")
+	ErrInvalidDuration    = errors.New("sessionDuration must be positive")
+)
+
+func defaultConfig() Config {
+	return Config{
+		Scopes:          []string{"openid", "profile", "email", "groups"},
+		UsernameClaim:   "preferred_username",
+		GroupsClaim:     "groups",
+		Roles:           []RoleMapping{},
+		SessionDuration: 24 * 7,
+	}
+}
+
+func (c Config) validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	if c.ClientID == "" {
+		return ErrClientIDMissing
+	}
+	if _, err := url.Parse(c.RedirectURL); err != nil || !strings.HasSuffix(c.RedirectURL, callbackPath) {
+		return fmt.Errorf("%w: %q", ErrInvalidRedirectURL, c.RedirectURL)
+	}
+	if c.SessionDuration <= 0 {
+		return ErrInvalidDuration
+	}
+	for i, m := range c.Roles {
+		if m.Group == "" {
+			return fmt.Errorf("%w: %d", ErrGroupMissing, i)
+		}
+		if err := m.Role.Validate(); err != nil {
+			return fmt.Errorf("role mapping %q: %w", m.Group, err)
+		}
+	}
+	return nil
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "oidc.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		data, _ := json.MarshalIndent(defaultConfig(), "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	config := defaultConfig()
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// mapAccount returns the account of the first role mapping
+// that matches one of the groups, false if none match.
+func (c Config) mapAccount(groups []string) (auth.Account, bool) {
+	for _, m := range c.Roles {
+		if m.Group == "*" || contains(groups, m.Group) {
+			return auth.Account{
+				IsAdmin:  m.Admin,
+				Role:     m.Role,
+				Monitors: m.Monitors,
+				Groups:   m.MonitorGroups,
+			}, true
+		}
+	}
+	return auth.Account{}, false
+}
+
+const (
+	sessionCookie = "oidc_session"
+	stateCookie   = "oidc_state"
+
+	// Time the user has to complete the login at the provider.
+	loginTimeout = 10 * time.Minute
+)
+
+type session struct {
+	account auth.Account
+	idToken string // Used as logout hint.
+	expires time.Time
+}
+
+type pendingLogin struct {
+	nonce    string
+	verifier string // PKCE code verifier.
+	redirect string
+	created  time.Time
+}
+
+// Authenticator wraps a authenticator and adds OpenID Connect
+// logins. Requests with a valid session cookie are authenticated
+// by the session, other requests are passed to the wrapped
+// authenticator. Browsers without credentials are redirected
+// to the provider. Sessions are kept in memory.
+type Authenticator struct {
+	auth.Authenticator
+	config   Config
+	provider *provider
+	logger   *log.Logger
+	baseURL  string
+	secure   bool
+
+	sessions map[string]session
+	logins   map[string]pendingLogin
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+func newAuthenticator(
+	a auth.Authenticator,
+	config Config,
+	p *provider,
+	logger *log.Logger,
+) *Authenticator {
+	return &Authenticator{
+		Authenticator: a,
+		config:        config,
+		provider:      p,
+		logger:        logger,
+		baseURL:       strings.TrimSuffix(config.RedirectURL, callbackPath),
+		secure:        strings.HasPrefix(config.RedirectURL, "https://"),
+
+		sessions: make(map[string]session),
+		logins:   make(map[string]pendingLogin),
+		now:      time.Now,
+	}
+}
+
+func (a *Authenticator) logf(level log.Level, format string, v ...interface{}) {
+	a.logger.Log(log.Entry{
+		Level: level,
+		Src:   "oidc",
+		Msg:   fmt.Sprintf(format, v...),
+	})
+}
+
+// session returns the session of the request cookie.
+func (a *Authenticator) session(r *http.Request) (session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, exist := a.sessions[cookie.Value]
+	if !exist {
+		return session{}, false
+	}
+	if a.now().After(s.expires) {
+		delete(a.sessions, cookie.Value)
+		return session{}, false
+	}
+	return s, true
+}
+
+// ValidateRequest validates the session or the wrapped authenticator.
+func (a *Authenticator) ValidateRequest(r *http.Request) auth.ValidateResponse {
+	if s, ok := a.session(r); ok {
+		return auth.ValidateResponse{IsValid: true, User: s.account}
+	}
+	return a.Authenticator.ValidateRequest(r)
+}
+
+// isBrowserNavigation returns true for page requests
+// from browsers that don't have credentials.
+func isBrowserNavigation(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Authorization") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (a *Authenticator) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{"redirect": {r.URL.RequestURI()}}
+	http.Redirect(w, r, a.baseURL+"/oidc/login?"+query.Encode(), http.StatusFound)
+}
+
+func (a *Authenticator) wrap(
+	next http.Handler,
+	fallback func(http.Handler) http.Handler,
+	admin bool,
+) http.Handler {
+	fallbackHandler := fallback(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := a.session(r)
+		switch {
+		case ok && admin && !s.account.IsAdmin:
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		case ok:
+			next.ServeHTTP(w, r)
+		case isBrowserNavigation(r):
+			a.redirectToLogin(w, r)
+		default:
+			fallbackHandler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// User blocks unauthenticated requests.
+func (a *Authenticator) User(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.User, false)
+}
+
+// Admin only allows authenticated requests from admins.
+func (a *Authenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.Admin, true)
+}
+
+// CSRF checks the token of the session or the wrapped authenticator.
+func (a *Authenticator) CSRF(next http.Handler) http.Handler {
+	fallback := a.Authenticator.CSRF(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := a.session(r)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("X-CSRF-TOKEN") != s.account.Token {
+			http.Error(w, "Invalid CSRF-token.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MyToken returns the CSRF token of the session or the wrapped authenticator.
+func (a *Authenticator) MyToken() http.Handler {
+	fallback := a.Authenticator.MyToken()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := a.session(r)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if _, err := io.WriteString(w, s.account.Token); err != nil {
+			http.Error(w, "could not write", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Logout ends the session and redirects to the logout
+// endpoint of the provider if it has one.
+func (a *Authenticator) Logout() http.Handler {
+	fallback := a.Authenticator.Logout()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := a.session(r)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		cookie, _ := r.Cookie(sessionCookie)
+		a.mu.Lock()
+		delete(a.sessions, cookie.Value)
+		a.mu.Unlock()
+		a.setCookie(w, sessionCookie, "", -1)
+
+		target := a.baseURL + "/live"
+		m, err := a.provider.discover(r.Context())
+		if err == nil && m.EndSessionEndpoint != "" {
+			query := url.Values{
+				"id_token_hint":            {s.idToken},
+				"client_id":                {a.config.ClientID},
+				"post_logout_redirect_uri": {a.baseURL + "/live"},
+			}
+			target = m.EndSessionEndpoint + "?" + query.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+func (a *Authenticator) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeRedirect only allows local paths to prevent open redirects.
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") ||
+		strings.HasPrefix(redirect, "//") ||
+		strings.HasPrefix(redirect, "/\\") {
+		return "/live"
+	}
+	return redirect
+}
+
+// Login redirects to the authorization endpoint of the provider.
+func (a *Authenticator) Login() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		m, err := a.provider.discover(r.Context())
+		if err != nil {
+			a.logf(log.LevelError, "login: %v", err)
+			http.Error(w, "could not reach identity provider", http.StatusBadGateway)
+			return
+		}
+
+		state, nonce, verifier := auth.GenToken(), auth.GenToken(), auth.GenToken()
+		now := a.now()
+
+		a.mu.Lock()
+		for key, login := range a.logins {
+			if now.Sub(login.created) > loginTimeout {
+				delete(a.logins, key)
+			}
+		}
+		a.logins[state] = pendingLogin{
+			nonce:    nonce,
+			verifier: verifier,
+			redirect: safeRedirect(r.URL.Query().Get("redirect")),
+			created:  now,
+		}
+		a.mu.Unlock()
+
+		a.setCookie(w, stateCookie, state, int(loginTimeout.Seconds()))
+		authURL := a.provider.authURL(m, a.config.Scopes, state, nonce, verifier)
+		http.Redirect(w, r, authURL, http.StatusFound)
+	})
+}
+
+// Callback completes the login and creates the session.
+func (a *Authenticator) Callback() http.Handler { //nolint:funlen
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		if e := query.Get("error"); e != "" {
+			http.Error(w, "login failed: "+e+" "+query.Get("error_description"), http.StatusUnauthorized)
+			return
+		}
+
+		state := query.Get("state")
+		cookie, err := r.Cookie(stateCookie)
+		if err != nil || state == "" || cookie.Value != state {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		a.mu.Lock()
+		login, exist := a.logins[state]
+		delete(a.logins, state)
+		a.mu.Unlock()
+		if !exist || a.now().Sub(login.created) > loginTimeout {
+			http.Error(w, "login expired", http.StatusBadRequest)
+			return
+		}
+		a.setCookie(w, stateCookie, "", -1)
+
+		m, err := a.provider.discover(r.Context())
+		if err != nil {
+			a.logf(log.LevelError, "callback: %v", err)
+			http.Error(w, "could not reach identity provider", http.StatusBadGateway)
+			return
+		}
+		idToken, err := a.provider.exchange(r.Context(), m, query.Get("code"), login.verifier)
+		if err != nil {
+			a.logf(log.LevelError, "callback: %v", err)
+			http.Error(w, "could not exchange code", http.StatusBadGateway)
+			return
+		}
+		claims, err := a.provider.verify(r.Context(), m, idToken, login.nonce, a.now())
+		if err != nil {
+			a.logf(log.LevelError, "callback: verify id token: %v", err)
+			http.Error(w, "invalid id token", http.StatusUnauthorized)
+			return
+		}
+
+		username := claims.string(a.config.UsernameClaim)
+		if username == "" {
+			username = claims.string("email")
+		}
+		if username == "" {
+			username = claims.string("sub")
+		}
+		account, ok := a.config.mapAccount(claims.strings(a.config.GroupsClaim))
+		if !ok {
+			auth.LogFailedLogin(a.logger, r, username)
+			http.Error(w, "user is not in a allowed group", http.StatusForbidden)
+			return
+		}
+		account.ID = "oidc_" + claims.string("sub")
+		account.Username = username
+		account.Token = auth.GenToken()
+
+		id := auth.GenToken()
+		duration := time.Duration(a.config.SessionDuration) * time.Hour
+		a.mu.Lock()
+		for key, s := range a.sessions {
+			if a.now().After(s.expires) {
+				delete(a.sessions, key)
+			}
+		}
+		a.sessions[id] = session{
+			account: account,
+			idToken: idToken,
+			expires: a.now().Add(duration),
+		}
+		a.mu.Unlock()
+
+		a.setCookie(w, sessionCookie, id, int(duration.Seconds()))
+		a.logf(log.LevelInfo, "login: %v", username)
+		http.Redirect(w, r, a.baseURL+login.redirect, http.StatusFound)
+	})
+}
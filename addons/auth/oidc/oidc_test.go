@@ -0,0 +1,238 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, defaultConfig(), *config)
+	require.FileExists(t, filepath.Join(dir, "oidc.json"))
+
+	valid := func() Config {
+		c := defaultConfig()
+		c.Issuer = "https://auth.example.com"
+		c.ClientID = "nvr"
+		c.RedirectURL = "https://nvr.example.com/oidc/callback"
+		c.Roles = []RoleMapping{{Group: "family", Role: auth.RoleFull}}
+		return c
+	}
+	cases := map[string]struct {
+		modify func(*Config)
+		err    error
+	}{
+		"ok":          {func(*Config) {}, nil},
+		"clientID":    {func(c *Config) { c.ClientID = "" }, ErrClientIDMissing},
+		"redirectURL": {func(c *Config) { c.RedirectURL = "https://nvr.example.com" }, ErrInvalidRedirectURL},
+		"group":       {func(c *Config) { c.Roles[0].Group = "" }, ErrGroupMissing},
+		"role":        {func(c *Config) { c.Roles[0].Role = "x" }, auth.ErrInvalidRole},
+		"duration":    {func(c *Config) { c.SessionDuration = 0 }, ErrInvalidDuration},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := valid()
+			tc.modify(&c)
+			require.ErrorIs(t, c.validate(), tc.err)
+		})
+	}
+}
+
+func TestMapAccount(t *testing.T) {
+	c := Config{Roles: []RoleMapping{
+		{Group: "admins", Admin: true},
+		{Group: "guests", Role: auth.RoleLive, Monitors: []string{"door"}},
+		{Group: "*", Role: auth.RoleRecordings},
+	}}
+	a, ok := c.mapAccount([]string{"guests", "admins"})
+	require.True(t, ok)
+	require.True(t, a.IsAdmin)
+
+	a, ok = c.mapAccount([]string{"guests"})
+	require.True(t, ok)
+	require.Equal(t, auth.Account{Role: auth.RoleLive, Monitors: []string{"door"}}, a)
+
+	a, ok = c.mapAccount(nil)
+	require.True(t, ok)
+	require.Equal(t, auth.RoleRecordings, a.Role)
+
+	_, ok = Config{}.mapAccount([]string{"x"})
+	require.False(t, ok)
+}
+
+type stubAuthenticator struct {
+	auth.Authenticator
+}
+
+func (stubAuthenticator) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{}
+}
+
+func (stubAuthenticator) User(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+func (stubAuthenticator) Admin(http.Handler) http.Handler {
+	return stubAuthenticator{}.User(nil)
+}
+
+func (stubAuthenticator) CSRF(http.Handler) http.Handler {
+	return stubAuthenticator{}.User(nil)
+}
+
+func (stubAuthenticator) MyToken() http.Handler {
+	return stubAuthenticator{}.User(nil)
+}
+
+func (stubAuthenticator) Logout() http.Handler {
+	return stubAuthenticator{}.User(nil)
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func cookieValue(t *testing.T, w *httptest.ResponseRecorder, name string) string {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	t.Fatalf("cookie %v not set", name)
+	return ""
+}
+
+func TestLogin(t *testing.T) { //nolint:funlen
+	fp := newFakeProvider(t)
+	config := defaultConfig()
+	config.Issuer = fp.URL
+	config.ClientID = "nvr"
+	config.ClientSecret = "secret"
+	config.RedirectURL = "https://nvr.example.com/sub/oidc/callback"
+	config.Roles = []RoleMapping{{Group: "family", Role: auth.RoleLive}}
+
+	a := newAuthenticator(stubAuthenticator{}, config, newProvider(config, fp.Client()), newTestLogger())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	user := a.User(ok)
+
+	// Scripts without credentials get the fallback response.
+	r := httptest.NewRequest(http.MethodGet, "/api/monitor/list", nil)
+	require.Equal(t, http.StatusUnauthorized, serve(user, r).Code)
+
+	// Browsers are redirected to the login.
+	r = httptest.NewRequest(http.MethodGet, "/live", nil)
+	r.Header.Set("Accept", "text/html")
+	w := serve(user, r)
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://nvr.example.com/sub/oidc/login?redirect=%2Flive", w.Header().Get("Location"))
+
+	r = httptest.NewRequest(http.MethodGet, "/oidc/login?redirect=/live", nil)
+	w = serve(a.Login(), r)
+	require.Equal(t, http.StatusFound, w.Code)
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(authURL.String(), fp.URL+"/authorize?"))
+	query := authURL.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.Equal(t, config.RedirectURL, query.Get("redirect_uri"))
+	state := query.Get("state")
+	require.Equal(t, state, cookieValue(t, w, stateCookie))
+	fp.nonce = query.Get("nonce")
+
+	callback := func(state, cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=code1&state="+state, nil)
+		r.AddCookie(&http.Cookie{Name: stateCookie, Value: cookie})
+		return serve(a.Callback(), r)
+	}
+	require.Equal(t, http.StatusBadRequest, callback(state, "x").Code)
+
+	w = callback(state, state)
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://nvr.example.com/sub/live", w.Header().Get("Location"))
+	sessionID := cookieValue(t, w, sessionCookie)
+
+	// The state can only be used once.
+	require.Equal(t, http.StatusBadRequest, callback(state, state).Code)
+
+	withSession := func(method, path string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sessionID})
+		return r
+	}
+	require.Equal(t, http.StatusOK, serve(user, withSession(http.MethodGet, "/live")).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(a.Admin(ok), withSession(http.MethodGet, "/logs")).Code)
+
+	res := a.ValidateRequest(withSession(http.MethodGet, "/"))
+	require.True(t, res.IsValid)
+	require.Equal(t, "oidc_123", res.User.ID)
+	require.Equal(t, "alice", res.User.Username)
+	require.Equal(t, auth.RoleLive, res.User.Role)
+
+	// CSRF.
+	w = serve(a.MyToken(), withSession(http.MethodGet, "/"))
+	require.Equal(t, res.User.Token, w.Body.String())
+	r = withSession(http.MethodPut, "/")
+	r.Header.Set("X-CSRF-TOKEN", res.User.Token)
+	require.Equal(t, http.StatusOK, serve(a.CSRF(ok), r).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(a.CSRF(ok), withSession(http.MethodPut, "/")).Code)
+
+	// Logout.
+	w = serve(a.Logout(), withSession(http.MethodGet, "/logout"))
+	require.Equal(t, http.StatusFound, w.Code)
+	require.False(t, a.ValidateRequest(withSession(http.MethodGet, "/")).IsValid)
+}
+
+func TestLoginNoGroup(t *testing.T) {
+	fp := newFakeProvider(t)
+	fp.group = "other"
+	config := defaultConfig()
+	config.Issuer = fp.URL
+	config.ClientID = "nvr"
+	config.ClientSecret = "secret"
+	config.RedirectURL = "https://nvr.example.com/oidc/callback"
+	config.Roles = []RoleMapping{{Group: "family"}}
+
+	logger := newTestLogger()
+	a := newAuthenticator(stubAuthenticator{}, config, newProvider(config, fp.Client()), logger)
+
+	w := serve(a.Login(), httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	state := authURL.Query().Get("state")
+	fp.nonce = authURL.Query().Get("nonce")
+
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=code1&state="+state, nil)
+	r.AddCookie(&http.Cookie{Name: stateCookie, Value: state})
+	require.Equal(t, http.StatusForbidden, serve(a.Callback(), r).Code)
+}
+
+func TestSafeRedirect(t *testing.T) {
+	require.Equal(t, "/recordings", safeRedirect("/recordings"))
+	require.Equal(t, "/live", safeRedirect("https://evil.com"))
+	require.Equal(t, "/live", safeRedirect("//evil.com"))
+	require.Equal(t, "/live", safeRedirect("/\\evil.com"))
+	require.Equal(t, "/live", safeRedirect(""))
+}
+
+// newTestLogger returns a logger that discards all logs.
+func newTestLogger() *log.Logger {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return &log.Logger{Ctx: ctx}
+}
@@ -0,0 +1,390 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors.
+var (
+	ErrIssuerMismatch  = errors.New("issuer mismatch")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrUnsupportedAlg  = errors.New("unsupported signing algorithm")
+	ErrKeyNotFound     = errors.New("signing key not found")
+	ErrInvalidSig      = errors.New("invalid signature")
+	ErrTokenExpired    = errors.New("token expired")
+	ErrAudienceInvalid = errors.New("token audience does not include client id")
+	ErrNonceMismatch   = errors.New("nonce mismatch")
+	ErrUnexpectedCode  = errors.New("unexpected status code")
+)
+
+// providerMetadata subset of the OpenID provider metadata.
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// keyRefreshInterval limits how often the keys
+// are fetched when a token has a unknown key id.
+const keyRefreshInterval = time.Minute
+
+// provider OpenID provider. The metadata and keys are
+// fetched on the first login and cached, the startup
+// doesn't depend on the provider being reachable.
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	metadata    *providerMetadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	mu          sync.Mutex
+}
+
+func newProvider(c Config, client *http.Client) *provider {
+	return &provider{
+		issuer:       strings.TrimSuffix(c.Issuer, "/"),
+		clientID:     c.ClientID,
+		clientSecret: c.ClientSecret,
+		redirectURL:  c.RedirectURL,
+		client:       client,
+	}
+}
+
+func (p *provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v %v", ErrUnexpectedCode, u, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover returns the cached metadata or fetches it
+// from the ".well-known/openid-configuration" endpoint.
+func (p *provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m providerMetadata
+	err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("%w: %q", ErrIssuerMismatch, m.Issuer)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// authURL returns the authorization request url of the code flow.
+func (p *provider) authURL(m *providerMetadata, scopes []string, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + query.Encode()
+}
+
+// exchange exchanges the authorization code for the raw ID token.
+func (p *provider) exchange(ctx context.Context, m *providerMetadata, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("read token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: %w: %v %s", ErrUnexpectedCode, res.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("unmarshal token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrInvalidToken)
+	}
+	return tokens.IDToken, nil
+}
+
+// key returns the signing key by id. The keys are fetched
+// again if the id is unknown, the provider may have rotated them.
+func (p *provider) key(ctx context.Context, m *providerMetadata, kid string, now time.Time) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, exist := p.keys[kid]; exist {
+		return key, nil
+	}
+	if now.Sub(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+	}
+
+	var set jwks
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	p.keys = set.publicKeys()
+	p.keysFetched = now
+
+	key, exist := p.keys[kid]
+	if !exist {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKeys returns the RSA and P-256 signing keys, others are skipped.
+func (s jwks) publicKeys() map[string]crypto.PublicKey {
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys
+}
+
+// claims ID token claims.
+type claims map[string]interface{}
+
+func (c claims) string(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// strings returns the claim as a list, a single string is
+// a list with one value. Used for the audience and groups.
+func (c claims) strings(key string) []string {
+	switch v := c[key].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// verify verifies the signature and the standard claims of the ID token.
+func (p *provider) verify(
+	ctx context.Context,
+	m *providerMetadata,
+	raw string,
+	nonce string,
+	now time.Time,
+) (claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := p.key(ctx, m, header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, digest[:], sig); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if strings.TrimSuffix(c.string("iss"), "/") != p.issuer {
+		return nil, fmt.Errorf("%w: %q", ErrIssuerMismatch, c.string("iss"))
+	}
+	if !contains(c.strings("aud"), p.clientID) {
+		return nil, ErrAudienceInvalid
+	}
+	exp, _ := c["exp"].(float64)
+	if now.Unix() >= int64(exp) {
+		return nil, ErrTokenExpired
+	}
+	if c.string("nonce") != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return c, nil
+}
+
+// verifySignature only accepts RS256 and ES256, never "none".
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type for %v", ErrInvalidSig, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSig, err)
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return fmt.Errorf("%w: key type for %v", ErrInvalidSig, alg)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSig
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
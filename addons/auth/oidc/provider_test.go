@@ -0,0 +1,143 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signToken(t *testing.T, key *rsa.PrivateKey, alg, kid string, c claims) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(c)
+	require.NoError(t, err)
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// fakeProvider serves the discovery, keys and token endpoints.
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	nonce string // Nonce of the next ID token.
+	sub   string
+	group string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{key: key, sub: "123", group: "family"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{ //nolint:errcheck
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks{Keys: []jwk{{ //nolint:errcheck
+			Kty: "RSA",
+			Kid: "k1",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "nvr" || secret != "secret" || r.FormValue("code") != "code1" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		token := signToken(t, key, "RS256", "k1", claims{
+			"iss":                p.URL,
+			"aud":                "nvr",
+			"sub":                p.sub,
+			"exp":                float64(time.Now().Add(time.Hour).Unix()),
+			"nonce":              p.nonce,
+			"preferred_username": "alice",
+			"groups":             []string{p.group},
+		})
+		json.NewEncoder(w).Encode(map[string]string{"id_token": token}) //nolint:errcheck
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestProviderVerify(t *testing.T) {
+	fp := newFakeProvider(t)
+	p := newProvider(Config{Issuer: fp.URL + "/", ClientID: "nvr"}, fp.Client())
+	ctx := context.Background()
+	m, err := p.discover(ctx)
+	require.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	valid := func() claims {
+		return claims{"iss": fp.URL, "aud": []string{"x", "nvr"}, "exp": float64(2000), "nonce": "n"}
+	}
+
+	cases := map[string]struct {
+		alg    string
+		kid    string
+		modify func(claims)
+		err    error
+	}{
+		"ok":          {"RS256", "k1", func(claims) {}, nil},
+		"none":        {"none", "k1", func(claims) {}, ErrUnsupportedAlg},
+		"unknownKey":  {"RS256", "k2", func(claims) {}, ErrKeyNotFound},
+		"issuer":      {"RS256", "k1", func(c claims) { c["iss"] = "x" }, ErrIssuerMismatch},
+		"audience":    {"RS256", "k1", func(c claims) { c["aud"] = "x" }, ErrAudienceInvalid},
+		"expired":     {"RS256", "k1", func(c claims) { c["exp"] = float64(1000) }, ErrTokenExpired},
+		"nonce":       {"RS256", "k1", func(c claims) { c["nonce"] = "x" }, ErrNonceMismatch},
+		"wrongKeyAlg": {"ES256", "k1", func(claims) {}, ErrInvalidSig},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := valid()
+			tc.modify(c)
+			raw := signToken(t, fp.key, tc.alg, tc.kid, c)
+			_, err := p.verify(ctx, m, raw, "n", now)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+	t.Run("tampered", func(t *testing.T) {
+		raw := signToken(t, fp.key, "RS256", "k1", valid())
+		c := valid()
+		c["aud"] = "nvr"
+		payload, _ := json.Marshal(c)
+		parts := strings.Split(raw, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		_, err := p.verify(ctx, m, parts[0]+"."+parts[1]+"."+parts[2], "n", now)
+		require.ErrorIs(t, err, ErrInvalidSig)
+	})
+}
+
+func TestClaimsStrings(t *testing.T) {
+	c := claims{"a": "x", "b": []interface{}{"y", 1, "z"}}
+	require.Equal(t, []string{"x"}, c.strings("a"))
+	require.Equal(t, []string{"y", "z"}, c.strings("b"))
+	require.Nil(t, c.strings("c"))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"context"
+	"fmt"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"path/filepath"
+)
+
+var addon struct {
+	exporter *exporter
+}
+
+func init() {
+	nvr.RegisterLogSource([]string{"autoexport"})
+	nvr.RegisterAppRunHook(onAppRun)
+	nvr.RegisterMonitorRecSavedHook(onRecSaved)
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("autoexport: config: %w", err)
+	}
+	if len(config.Rules) == 0 {
+		app.Logger.Log(log.Entry{
+			Level: log.LevelWarning,
+			Src:   "autoexport",
+			Msg: fmt.Sprintf("no rules in %v, exports are disabled",
+				filepath.Join(app.Env.ConfigDir, "autoexport.json")),
+		})
+		return nil
+	}
+
+	e, err := newExporter(*config, app.Env.Crypt, app.Logger)
+	if err != nil {
+		return fmt.Errorf("autoexport: %w", err)
+	}
+	addon.exporter = e
+	app.WG.Add(1)
+	go func() {
+		e.run(ctx)
+		app.WG.Done()
+	}()
+	return nil
+}
+
+func onRecSaved(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
+	if addon.exporter == nil {
+		return
+	}
+	addon.exporter.onRecSaved(r.Config.ID(), recPath, recData)
+}
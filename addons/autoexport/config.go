@@ -0,0 +1,230 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"nvr/pkg/s3client"
+	"nvr/pkg/schedule"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config global addon config.
+type Config struct {
+	Targets []TargetConfig `json:"targets"`
+	Rules   []Rule         `json:"rules"`
+
+	// Number of retries before a export is abandoned, the
+	// delay starts at 30 seconds and doubles each attempt.
+	MaxRetries int `json:"maxRetries"`
+}
+
+// Target types.
+const (
+	targetFolder  = "folder"
+	targetS3      = "s3"
+	targetWebhook = "webhook"
+)
+
+// TargetConfig export destination, the fields
+// that are used depend on the type.
+type TargetConfig struct {
+	Name string `json:"name"`
+
+	// "folder", "s3" or "webhook".
+	Type string `json:"type"`
+
+	// Folder. Absolute path of the export directory.
+	Path string `json:"path"`
+
+	// S3.
+	Endpoint     string `json:"endpoint"`
+	Region       string `json:"region"`
+	Bucket       string `json:"bucket"`
+	AccessKey    string `json:"accessKey"`
+	SecretKey    string `json:"secretKey"`
+	PathStyle    bool   `json:"pathStyle"`
+	Prefix       string `json:"prefix"`
+	StorageClass string `json:"storageClass"`
+
+	// Webhook. The clip is posted as a multipart form.
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Rule exports recordings with at least one matching event.
+type Rule struct {
+	Name     string   `json:"name"`
+	Monitors []string `json:"monitors"`
+	Labels   []string `json:"labels"`
+
+	// At least one detection matching the labels must have this score.
+	MinScore float64 `json:"minScore"`
+
+	// Weekly time ranges in local time that the event must start
+	// within, for example only at night. Always active if empty.
+	Schedule schedule.Schedule `json:"schedule"`
+
+	// Names of the targets.
+	Targets []string `json:"targets"`
+}
+
+// Config errors.
+var (
+	ErrInvalidTarget     = errors.New("invalid target")
+	ErrInvalidTargetType = errors.New("invalid target type")
+	ErrInvalidRule       = errors.New("invalid rule")
+	ErrUnknownTarget     = errors.New("unknown target")
+)
+
+const defaultMaxRetries = 5
+
+func (c *Config) setDefaults() {
+	if c.Targets == nil {
+		c.Targets = []TargetConfig{}
+	}
+	if c.Rules == nil {
+		c.Rules = []Rule{}
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+	for i, t := range c.Targets {
+		if t.Type != targetS3 {
+			continue
+		}
+		if t.Region == "" {
+			t.Region = "us-east-1"
+		}
+		if t.Endpoint == "" {
+			t.Endpoint = "https://s3." + t.Region + ".amazonaws.com"
+		}
+		if t.Prefix != "" && !strings.HasSuffix(t.Prefix, "/") {
+			t.Prefix += "/"
+		}
+		c.Targets[i] = t
+	}
+}
+
+func (c Config) validate() error {
+	names := make(map[string]struct{}, len(c.Targets))
+	for _, t := range c.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("%w: missing name", ErrInvalidTarget)
+		}
+		if _, exist := names[t.Name]; exist {
+			return fmt.Errorf("%w: duplicate name: %q", ErrInvalidTarget, t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+	for _, r := range c.Rules {
+		if err := r.validate(names); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t TargetConfig) validate() error {
+	switch t.Type {
+	case targetFolder:
+		if !filepath.IsAbs(t.Path) {
+			return fmt.Errorf("%w: path must be absolute: %q", ErrInvalidTarget, t.Path)
+		}
+	case targetS3:
+		if t.Bucket == "" {
+			return fmt.Errorf("%w: missing bucket", ErrInvalidTarget)
+		}
+		if _, err := s3client.New(t.clientConfig()); err != nil {
+			return err
+		}
+	case targetWebhook:
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: invalid url: %q", ErrInvalidTarget, t.URL)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidTargetType, t.Type)
+	}
+	return nil
+}
+
+func (t TargetConfig) clientConfig() s3client.Config {
+	return s3client.Config{
+		Endpoint:  t.Endpoint,
+		Region:    t.Region,
+		Bucket:    t.Bucket,
+		AccessKey: t.AccessKey,
+		SecretKey: t.SecretKey,
+		PathStyle: t.PathStyle,
+	}
+}
+
+func (r Rule) validate(targets map[string]struct{}) error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidRule)
+	}
+	if r.MinScore < 0 || r.MinScore > 100 {
+		return fmt.Errorf("%w: min score must be between 0 and 100: %v",
+			ErrInvalidRule, r.MinScore)
+	}
+	if err := r.Schedule.Validate(); err != nil {
+		return err
+	}
+	if len(r.Targets) == 0 {
+		return fmt.Errorf("%w: no targets", ErrInvalidRule)
+	}
+	for _, name := range r.Targets {
+		if _, exist := targets[name]; !exist {
+			return fmt.Errorf("%w: %q", ErrUnknownTarget, name)
+		}
+	}
+	return nil
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "autoexport.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		defaultConfig := Config{}
+		defaultConfig.setDefaults()
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nvr/pkg/s3client"
+	"nvr/pkg/schedule"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("setDefaults", func(t *testing.T) {
+		c := Config{Targets: []TargetConfig{
+			{Type: targetS3, Region: "eu-north-1", Prefix: "evidence"},
+			{Type: targetFolder},
+		}}
+		c.setDefaults()
+		require.Equal(t, Config{
+			Targets: []TargetConfig{
+				{
+					Type:     targetS3,
+					Endpoint: "https://s3.eu-north-1.amazonaws.com",
+					Region:   "eu-north-1",
+					Prefix:   "evidence/",
+				},
+				{Type: targetFolder},
+			},
+			Rules:      []Rule{},
+			MaxRetries: 5,
+		}, c)
+	})
+	t.Run("validate", func(t *testing.T) {
+		folder := TargetConfig{Name: "a", Type: targetFolder, Path: "/export"}
+		rule := Rule{Name: "r", Targets: []string{"a"}}
+		cases := map[string]struct {
+			targets  []TargetConfig
+			rules    []Rule
+			expected error
+		}{
+			"ok": {
+				[]TargetConfig{
+					folder,
+					{Name: "b", Type: targetS3, Bucket: "x", Endpoint: "https://s3"},
+					{Name: "c", Type: targetWebhook, URL: "http://archive/upload"},
+				},
+				[]Rule{{Name: "r", Targets: []string{"a", "b", "c"}}},
+				nil,
+			},
+			"missingName": {
+				[]TargetConfig{{Type: targetFolder, Path: "/x"}}, nil, ErrInvalidTarget,
+			},
+			"duplicateName": {
+				[]TargetConfig{folder, folder}, nil, ErrInvalidTarget,
+			},
+			"invalidType": {
+				[]TargetConfig{{Name: "a", Type: "ftp"}}, nil, ErrInvalidTargetType,
+			},
+			"relativePath": {
+				[]TargetConfig{{Name: "a", Type: targetFolder, Path: "x"}}, nil, ErrInvalidTarget,
+			},
+			"missingBucket": {
+				[]TargetConfig{{Name: "a", Type: targetS3, Endpoint: "https://s3"}},
+				nil,
+				ErrInvalidTarget,
+			},
+			"invalidEndpoint": {
+				[]TargetConfig{{Name: "a", Type: targetS3, Bucket: "x", Endpoint: "s3"}},
+				nil,
+				s3client.ErrInvalidEndpoint,
+			},
+			"invalidURL": {
+				[]TargetConfig{{Name: "a", Type: targetWebhook, URL: "archive"}},
+				nil,
+				ErrInvalidTarget,
+			},
+			"ruleName": {
+				[]TargetConfig{folder}, []Rule{{Targets: []string{"a"}}}, ErrInvalidRule,
+			},
+			"ruleScore": {
+				[]TargetConfig{folder},
+				[]Rule{{Name: "r", MinScore: 101, Targets: []string{"a"}}},
+				ErrInvalidRule,
+			},
+			"ruleSchedule": {
+				[]TargetConfig{folder},
+				[]Rule{{
+					Name:     "r",
+					Schedule: schedule.Schedule{{Start: "25:00", End: "06:00"}},
+					Targets:  []string{"a"},
+				}},
+				schedule.ErrInvalidTime,
+			},
+			"noTargets": {
+				[]TargetConfig{folder}, []Rule{{Name: "r"}}, ErrInvalidRule,
+			},
+			"unknownTarget": {
+				nil, []Rule{rule}, ErrUnknownTarget,
+			},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				c := Config{Targets: tc.targets, Rules: tc.rules}
+				c.setDefaults()
+				require.ErrorIs(t, c.validate(), tc.expected)
+			})
+		}
+	})
+	t.Run("generate", func(t *testing.T) {
+		configDir := t.TempDir()
+		c, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, Config{
+			Targets:    []TargetConfig{},
+			Rules:      []Rule{},
+			MaxRetries: 5,
+		}, *c)
+
+		info, err := os.Stat(filepath.Join(configDir, "autoexport.json"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+	t.Run("read", func(t *testing.T) {
+		configDir := t.TempDir()
+		raw := `{
+			"targets": [{"name": "archive", "type": "folder", "path": "/archive"}],
+			"rules": [{
+				"name": "night",
+				"labels": ["person"],
+				"schedule": [{"start": "22:00", "end": "06:00"}],
+				"targets": ["archive"]
+			}]
+		}`
+		path := filepath.Join(configDir, "autoexport.json")
+		require.NoError(t, os.WriteFile(path, []byte(raw), 0o600))
+
+		c, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, []Rule{{
+			Name:     "night",
+			Labels:   []string{"person"},
+			Schedule: schedule.Schedule{{Start: "22:00", End: "06:00"}},
+			Targets:  []string{"archive"},
+		}}, c.Rules)
+
+		require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{}]}`), 0o600))
+		_, err = readConfig(configDir)
+		require.ErrorIs(t, err, ErrInvalidRule)
+
+		require.NoError(t, os.WriteFile(path, []byte("nil"), 0o600))
+		_, err = readConfig(configDir)
+		var e *json.SyntaxError
+		require.ErrorAs(t, err, &e)
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"context"
+	"fmt"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"time"
+)
+
+type exportJob struct {
+	clip    clip
+	target  string
+	attempt int
+}
+
+// exporter exports matching recordings one at a time. Each target
+// is retried separately with exponential backoff, the queue is
+// kept in memory and is lost if the NVR is restarted.
+type exporter struct {
+	rules      []Rule
+	targets    map[string]target
+	maxRetries int
+	crypt      *storage.Crypt
+	logger     log.ILogger
+
+	queue      chan exportJob
+	retryDelay time.Duration
+}
+
+const (
+	exportQueueSize = 100
+
+	// Time limit of a single export.
+	exportTimeout = 10 * time.Minute
+)
+
+func newExporter(config Config, crypt *storage.Crypt, logger log.ILogger) (*exporter, error) {
+	targets := make(map[string]target, len(config.Targets))
+	for _, c := range config.Targets {
+		t, err := newTarget(c)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", c.Name, err)
+		}
+		targets[c.Name] = t
+	}
+	return &exporter{
+		rules:      config.Rules,
+		targets:    targets,
+		maxRetries: config.MaxRetries,
+		crypt:      crypt,
+		logger:     logger,
+		queue:      make(chan exportJob, exportQueueSize),
+		retryDelay: 30 * time.Second,
+	}, nil
+}
+
+func (e *exporter) logf(monitorID string, level log.Level, format string, a ...interface{}) {
+	e.logger.Log(log.Entry{
+		Level:     level,
+		Src:       "autoexport",
+		MonitorID: monitorID,
+		Msg:       fmt.Sprintf(format, a...),
+	})
+}
+
+// onRecSaved queues the recording for every target of the matching rules.
+func (e *exporter) onRecSaved(monitorID string, recPath string, data storage.RecordingData) {
+	rules, targets := matchRules(e.rules, monitorID, data.Events)
+	if len(targets) == 0 {
+		return
+	}
+	c := clip{
+		monitorID: monitorID,
+		recPath:   recPath,
+		rules:     rules,
+		data:      data,
+		crypt:     e.crypt,
+	}
+	for _, target := range targets {
+		e.push(exportJob{clip: c, target: target})
+	}
+}
+
+func (e *exporter) push(job exportJob) {
+	select {
+	case e.queue <- job:
+	default:
+		e.logf(job.clip.monitorID, log.LevelError,
+			"export queue is full, skipping: %v to %v", job.clip.id(), job.target)
+	}
+}
+
+func (e *exporter) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-e.queue:
+			e.process(ctx, job)
+		}
+	}
+}
+
+func (e *exporter) process(ctx context.Context, job exportJob) {
+	exportCtx, cancel := context.WithTimeout(ctx, exportTimeout)
+	err := e.targets[job.target].export(exportCtx, job.clip)
+	cancel()
+
+	name := job.clip.id()
+	if err == nil {
+		e.logf(job.clip.monitorID, log.LevelInfo, "exported %v to %v", name, job.target)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	job.attempt++
+	if job.attempt > e.maxRetries {
+		e.logf(job.clip.monitorID, log.LevelError,
+			"giving up on %v to %v: %v", name, job.target, err)
+		return
+	}
+	delay := e.retryDelay << (job.attempt - 1)
+	e.logf(job.clip.monitorID, log.LevelWarning,
+		"export failed, retrying in %v: %v to %v: %v", delay, name, job.target, err)
+	time.AfterFunc(delay, func() {
+		if ctx.Err() == nil {
+			e.push(job)
+		}
+	})
+}
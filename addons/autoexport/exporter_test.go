@@ -0,0 +1,292 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testMeta = []byte{
+	0,    // Version.
+	0, 7, // Video sps size.
+	103, 0, 0, 0, 172, 217, 0, // Video sps.
+	0, 3, // Video pps size.
+	2, 3, 4, // Video pps.
+	0, 4, // Audio config size.
+	20, 10, 0, 0, // Audio Config.
+	0, 0, 0, 0, 0, 0, 0, 0, // Start time.
+
+	// Sample.
+	0,                        // Flags.
+	0, 0, 0, 0, 0, 0, 0, 0x0, // PTS.
+	0, 0, 0, 0, 0, 0, 0, 0, // DTS.
+	0, 0, 0, 0, 0, 0, 0, 0, // Next dts.
+	0, 0, 0, 0, // Offset.
+	0, 0, 0, 0, // Size.
+}
+
+const testRecID = "2000-01-01_00-00-00_m1"
+
+func writeTestRecording(t *testing.T) string {
+	t.Helper()
+	recPath := filepath.Join(t.TempDir(), "2000/01/01/m1", testRecID)
+	require.NoError(t, os.MkdirAll(filepath.Dir(recPath), 0o700))
+	require.NoError(t, os.WriteFile(recPath+".meta", testMeta, 0o600))
+	require.NoError(t, os.WriteFile(recPath+".mdat", []byte{0, 0, 0, 0}, 0o600))
+	require.NoError(t, os.WriteFile(recPath+".jpeg", []byte{1, 2}, 0o600))
+	return recPath
+}
+
+func newTestClip(t *testing.T) clip {
+	t.Helper()
+	return clip{
+		monitorID: "m1",
+		recPath:   writeTestRecording(t),
+		rules:     []string{"night"},
+		data: storage.RecordingData{
+			Events: []storage.Event{{
+				Detections: []storage.Detection{{Label: "person", Score: 90}},
+			}},
+		},
+	}
+}
+
+func TestFolderTarget(t *testing.T) {
+	exportDir := t.TempDir()
+	target, err := newTarget(TargetConfig{Type: targetFolder, Path: exportDir})
+	require.NoError(t, err)
+
+	require.NoError(t, target.export(context.Background(), newTestClip(t)))
+
+	base := filepath.Join(exportDir, "m1", testRecID)
+	info, err := os.Stat(base + ".mp4")
+	require.NoError(t, err)
+	require.Greater(t, info.Size(), int64(1000))
+
+	raw, err := os.ReadFile(base + ".json")
+	require.NoError(t, err)
+	var data clipInfo
+	require.NoError(t, json.Unmarshal(raw, &data))
+	require.Equal(t, testRecID, data.RecordingID)
+	require.Equal(t, "m1", data.MonitorID)
+	require.Equal(t, []string{"night"}, data.Rules)
+	require.Equal(t, "person", data.Events[0].Detections[0].Label)
+
+	thumbnail, err := os.ReadFile(base + ".jpeg")
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2}, thumbnail)
+
+	entries, err := os.ReadDir(filepath.Join(exportDir, "m1"))
+	require.NoError(t, err)
+	require.Len(t, entries, 3, "temporary files should be removed")
+}
+
+type testBucket struct {
+	objects map[string]int
+	fail    int
+	mu      sync.Mutex
+}
+
+func (b *testBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail > 0 {
+		b.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	b.objects[r.URL.Path] = len(body)
+}
+
+func newTestS3Target(t *testing.T, bucket *testBucket) target {
+	t.Helper()
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+
+	c := Config{Targets: []TargetConfig{{
+		Name:      "s3",
+		Type:      targetS3,
+		Endpoint:  server.URL,
+		Bucket:    "nvr",
+		PathStyle: true,
+		Prefix:    "evidence",
+	}}}
+	c.setDefaults()
+	require.NoError(t, c.validate())
+
+	target, err := newTarget(c.Targets[0])
+	require.NoError(t, err)
+	return target
+}
+
+func TestS3Target(t *testing.T) {
+	bucket := &testBucket{objects: map[string]int{}}
+	target := newTestS3Target(t, bucket)
+
+	require.NoError(t, target.export(context.Background(), newTestClip(t)))
+
+	const key = "/nvr/evidence/m1/" + testRecID
+	require.Len(t, bucket.objects, 3)
+	require.Greater(t, bucket.objects[key+".mp4"], 1000)
+	require.Greater(t, bucket.objects[key+".json"], 10)
+	require.Equal(t, 2, bucket.objects[key+".jpeg"])
+}
+
+func TestWebhookTarget(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		type request struct {
+			auth      string
+			data      clipInfo
+			filename  string
+			videoType string
+			videoSize int
+		}
+		requests := make(chan request, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req request
+			req.auth = r.Header.Get("Authorization")
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.Unmarshal([]byte(r.FormValue("data")), &req.data) //nolint:errcheck
+			file, header, err := r.FormFile("video")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			video, _ := io.ReadAll(file)
+			req.filename = header.Filename
+			req.videoType = header.Header.Get("Content-Type")
+			req.videoSize = len(video)
+			requests <- req
+		}))
+		defer server.Close()
+
+		target, err := newTarget(TargetConfig{
+			Type:    targetWebhook,
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer x"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, target.export(context.Background(), newTestClip(t)))
+
+		req := <-requests
+		require.Equal(t, "Bearer x", req.auth)
+		require.Equal(t, testRecID, req.data.RecordingID)
+		require.Equal(t, []string{"night"}, req.data.Rules)
+		require.Equal(t, testRecID+".mp4", req.filename)
+		require.Equal(t, "video/mp4", req.videoType)
+		require.Greater(t, req.videoSize, 1000)
+	})
+	t.Run("status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		target, err := newTarget(TargetConfig{Type: targetWebhook, URL: server.URL})
+		require.NoError(t, err)
+		err = target.export(context.Background(), newTestClip(t))
+		require.ErrorIs(t, err, ErrUnexpectedStatus)
+	})
+}
+
+func TestExporter(t *testing.T) {
+	newTestExporter := func(t *testing.T, bucket *testBucket) (*exporter, string) {
+		t.Helper()
+		exportDir := t.TempDir()
+		e := &exporter{
+			rules: []Rule{
+				{Name: "people", Labels: []string{"person"}, Targets: []string{"folder", "s3"}},
+				{Name: "cars", Labels: []string{"car"}, Targets: []string{"s3"}},
+			},
+			targets: map[string]target{
+				"folder": &folderTarget{path: exportDir},
+				"s3":     newTestS3Target(t, bucket),
+			},
+			maxRetries: 1,
+			logger:     log.NewDummyLogger(),
+			queue:      make(chan exportJob, exportQueueSize),
+			retryDelay: time.Millisecond,
+		}
+		return e, exportDir
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}}
+		e, exportDir := newTestExporter(t, bucket)
+		c := newTestClip(t)
+
+		e.onRecSaved("m1", c.recPath, c.data)
+		require.Len(t, e.queue, 2)
+
+		job := <-e.queue
+		require.Equal(t, "folder", job.target)
+		require.Equal(t, []string{"people"}, job.clip.rules)
+		e.process(context.Background(), job)
+		_, err := os.Stat(filepath.Join(exportDir, "m1", testRecID+".mp4"))
+		require.NoError(t, err)
+
+		job = <-e.queue
+		require.Equal(t, "s3", job.target)
+		e.process(context.Background(), job)
+		require.Len(t, bucket.objects, 3)
+	})
+	t.Run("noMatch", func(t *testing.T) {
+		e, _ := newTestExporter(t, &testBucket{})
+		e.onRecSaved("m1", "x", storage.RecordingData{})
+		require.Empty(t, e.queue)
+	})
+	t.Run("retry", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}, fail: 1}
+		e, _ := newTestExporter(t, bucket)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		e.process(ctx, exportJob{clip: newTestClip(t), target: "s3"})
+		require.Empty(t, bucket.objects)
+
+		job := <-e.queue
+		require.Equal(t, 1, job.attempt)
+		e.process(ctx, job)
+		require.Len(t, bucket.objects, 3)
+	})
+	t.Run("giveUp", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}, fail: 10}
+		e, _ := newTestExporter(t, bucket)
+
+		e.process(context.Background(), exportJob{clip: newTestClip(t), target: "s3", attempt: 1})
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, e.queue)
+	})
+}
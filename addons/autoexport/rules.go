@@ -0,0 +1,73 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"nvr/pkg/storage"
+	"time"
+)
+
+// match returns true if the monitor and at least one event match the rule.
+func (r Rule) match(monitorID string, events []storage.Event) bool {
+	if len(r.Monitors) != 0 && !contains(r.Monitors, monitorID) {
+		return false
+	}
+	for _, e := range events {
+		if r.Schedule.Active(e.Time.In(time.Local)) && r.matchDetections(e.Detections) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchDetections(detections []storage.Detection) bool {
+	for _, d := range detections {
+		if len(r.Labels) != 0 && !contains(r.Labels, d.Label) {
+			continue
+		}
+		if d.Score >= r.MinScore {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRules returns the names of the matching rules
+// and the deduplicated names of their targets.
+func matchRules(rules []Rule, monitorID string, events []storage.Event) ([]string, []string) {
+	var matched []string
+	var targets []string
+	for _, r := range rules {
+		if !r.match(monitorID, events) {
+			continue
+		}
+		matched = append(matched, r.Name)
+		for _, t := range r.Targets {
+			if !contains(targets, t) {
+				targets = append(targets, t)
+			}
+		}
+	}
+	return matched, targets
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/schedule"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleMatch(t *testing.T) {
+	night := schedule.Schedule{{Start: "22:00", End: "06:00"}}
+	at := func(hour int) time.Time {
+		return time.Date(2000, 1, 1, hour, 0, 0, 0, time.Local)
+	}
+	event := func(hour int, label string, score float64) storage.Event {
+		return storage.Event{
+			Time:       at(hour),
+			Detections: []storage.Detection{{Label: label, Score: score}},
+		}
+	}
+
+	cases := map[string]struct {
+		rule     Rule
+		events   []storage.Event
+		expected bool
+	}{
+		"any": {
+			Rule{},
+			[]storage.Event{event(12, "car", 10)},
+			true,
+		},
+		"noEvents": {
+			Rule{},
+			nil,
+			false,
+		},
+		"monitor": {
+			Rule{Monitors: []string{"m2"}},
+			[]storage.Event{event(12, "car", 10)},
+			false,
+		},
+		"label": {
+			Rule{Labels: []string{"person"}},
+			[]storage.Event{event(12, "car", 90), event(13, "person", 50)},
+			true,
+		},
+		"wrongLabel": {
+			Rule{Labels: []string{"person"}},
+			[]storage.Event{event(12, "car", 90)},
+			false,
+		},
+		"score": {
+			Rule{Labels: []string{"person"}, MinScore: 60},
+			[]storage.Event{event(12, "person", 50)},
+			false,
+		},
+		"night": {
+			Rule{Labels: []string{"person"}, Schedule: night},
+			[]storage.Event{event(12, "person", 90), event(23, "person", 90)},
+			true,
+		},
+		"day": {
+			Rule{Labels: []string{"person"}, Schedule: night},
+			[]storage.Event{event(12, "person", 90), event(23, "car", 90)},
+			false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.rule.match("m1", tc.events))
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	rules := []Rule{
+		{Name: "a", Labels: []string{"person"}, Targets: []string{"x", "y"}},
+		{Name: "b", Labels: []string{"car"}, Targets: []string{"z"}},
+		{Name: "c", Targets: []string{"y", "z"}},
+	}
+	events := []storage.Event{{
+		Detections: []storage.Detection{{Label: "person"}},
+	}}
+	matched, targets := matchRules(rules, "m1", events)
+	require.Equal(t, []string{"a", "c"}, matched)
+	require.Equal(t, []string{"x", "y", "z"}, targets)
+
+	matched, targets = matchRules(rules, "m1", nil)
+	require.Nil(t, matched)
+	require.Nil(t, targets)
+}
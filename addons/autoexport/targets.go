@@ -0,0 +1,302 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package autoexport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"nvr/pkg/s3client"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+)
+
+// clip recording that matched at least one rule.
+type clip struct {
+	monitorID string
+	recPath   string
+	rules     []string
+	data      storage.RecordingData
+	crypt     *storage.Crypt
+}
+
+func (c clip) id() string {
+	return filepath.Base(c.recPath)
+}
+
+// clipInfo exported clip metadata.
+type clipInfo struct {
+	RecordingID string   `json:"recordingId"`
+	MonitorID   string   `json:"monitorId"`
+	Rules       []string `json:"rules"`
+	storage.RecordingData
+}
+
+func (c clip) info() ([]byte, error) {
+	return json.MarshalIndent(clipInfo{
+		RecordingID:   c.id(),
+		MonitorID:     c.monitorID,
+		Rules:         c.rules,
+		RecordingData: c.data,
+	}, "", "    ")
+}
+
+type videoFile interface {
+	io.ReadCloser
+	Size() int64
+}
+
+// openVideo returns the video as a regular MP4 or the decrypted
+// Matroska file, together with the file extension and content type.
+func (c clip) openVideo() (videoFile, string, string, error) {
+	if storage.IsMKVRecording(c.recPath) {
+		file, err := storage.OpenRecordingFile(c.recPath+".mkv", c.crypt)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return file, ".mkv", "video/x-matroska", nil
+	}
+	video, err := storage.NewVideoReader(c.recPath, nil, c.crypt)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("video reader: %w", err)
+	}
+	return video, ".mp4", "video/mp4", nil
+}
+
+type target interface {
+	export(context.Context, clip) error
+}
+
+func newTarget(c TargetConfig) (target, error) {
+	switch c.Type {
+	case targetFolder:
+		return &folderTarget{path: c.Path}, nil
+	case targetS3:
+		client, err := s3client.New(c.clientConfig())
+		if err != nil {
+			return nil, err
+		}
+		return &s3Target{
+			client:       client,
+			prefix:       c.Prefix,
+			storageClass: c.StorageClass,
+		}, nil
+	case targetWebhook:
+		return &webhookTarget{
+			url:     c.URL,
+			headers: c.Headers,
+			client:  &http.Client{},
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrInvalidTargetType, c.Type)
+}
+
+// folderTarget copies the clips to "<path>/<monitorID>/<recordingID>.<ext>".
+type folderTarget struct {
+	path string
+}
+
+func (t *folderTarget) export(_ context.Context, c clip) error {
+	dir := filepath.Join(t.path, c.monitorID)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, c.id())
+
+	video, ext, _, err := c.openVideo()
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+	if err := writeFileAtomic(base+ext, video); err != nil {
+		return fmt.Errorf("write video: %w", err)
+	}
+
+	info, err := c.info()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(base+".json", bytes.NewReader(info)); err != nil {
+		return fmt.Errorf("write data: %w", err)
+	}
+
+	thumbnail, err := os.Open(c.recPath + ".jpeg")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer thumbnail.Close()
+	if err := writeFileAtomic(base+".jpeg", thumbnail); err != nil {
+		return fmt.Errorf("write thumbnail: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file that is renamed when
+// complete, incomplete files are never visible in the export folder.
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".autoexport-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// s3Target uploads the clips to "<prefix><monitorID>/<recordingID>.<ext>".
+type s3Target struct {
+	client       *s3client.Client
+	prefix       string
+	storageClass string
+}
+
+func (t *s3Target) export(ctx context.Context, c clip) error {
+	key := t.prefix + c.monitorID + "/" + c.id()
+
+	video, ext, contentType, err := c.openVideo()
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+	err = t.client.PutObject(ctx, key+ext, video, video.Size(), contentType, t.storageClass)
+	if err != nil {
+		return fmt.Errorf("upload video: %w", err)
+	}
+
+	info, err := c.info()
+	if err != nil {
+		return err
+	}
+	err = t.client.PutObject(ctx, key+".json", bytes.NewReader(info),
+		int64(len(info)), "application/json", t.storageClass)
+	if err != nil {
+		return fmt.Errorf("upload data: %w", err)
+	}
+
+	thumbnail, err := os.ReadFile(c.recPath + ".jpeg")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = t.client.PutObject(ctx, key+".jpeg", bytes.NewReader(thumbnail),
+		int64(len(thumbnail)), "image/jpeg", t.storageClass)
+	if err != nil {
+		return fmt.Errorf("upload thumbnail: %w", err)
+	}
+	return nil
+}
+
+// ErrUnexpectedStatus the webhook responded with a non 2xx status.
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+// webhookTarget posts the clips as a multipart form with
+// a "data" field containing the clip metadata as JSON
+// and a "video" file, the video is streamed from disk.
+type webhookTarget struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (t *webhookTarget) export(ctx context.Context, c clip) error {
+	info, err := c.info()
+	if err != nil {
+		return err
+	}
+	video, ext, contentType, err := c.openVideo()
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		pw.CloseWithError(writeForm(form, info, video, c.id()+ext, contentType))
+		close(done)
+	}()
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%w: %v", ErrUnexpectedStatus, res.Status)
+	}
+	return nil
+}
+
+func writeForm(
+	form *multipart.Writer,
+	info []byte,
+	video io.Reader,
+	filename string,
+	contentType string,
+) error {
+	data, err := form.CreateFormField("data")
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(info); err != nil {
+		return err
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="video"; filename=%q`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, video); err != nil {
+		return err
+	}
+	return form.Close()
+}
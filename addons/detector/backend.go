@@ -0,0 +1,542 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"nvr"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
+	nvr.RegisterLogSource([]string{"detector"})
+
+	nvr.RegisterTplHook(modifyTemplates)
+	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
+		app.Router.Handle("/detector.mjs", app.Auth.Admin(serveDetectorMjs()))
+		registry.mu.Lock()
+		registry.appRunning = true
+		registry.mu.Unlock()
+		return nil
+	})
+	nvr.RegisterMonitorValidateHook(validateConfig)
+}
+
+// validateConfig is used by the config check. The
+// detector is only checked if the app is running.
+func validateConfig(c monitor.Config) []error {
+	config, enable, err := parseConfig(c)
+	if err != nil {
+		return []error{fmt.Errorf("object detection: %w", err)}
+	}
+	if !enable {
+		return nil
+	}
+
+	registry.mu.Lock()
+	appRunning := registry.appRunning
+	registry.mu.Unlock()
+	if !appRunning {
+		return nil
+	}
+	if _, err := detectorByName(config.detectorName); err != nil {
+		return []error{fmt.Errorf("object detection: %w", err)}
+	}
+	return nil
+}
+
+func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
+	id := i.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		i.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "detector",
+			MonitorID: id,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+
+	config, enable, err := parseConfig(i.Config)
+	if err != nil {
+		logf(log.LevelError, "could not parse config: %v", err)
+		return
+	}
+	if !enable || config.useSubStream != i.IsSubInput() {
+		return
+	}
+
+	i.WG.Add(1)
+	go start(ctx, i, *config, logf)
+}
+
+func start(
+	ctx context.Context,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) {
+	defer i.WG.Done()
+
+	// Wait for the monitor to start.
+	select {
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		if err := run(ctx, i, config, logf); err != nil && !errors.Is(err, context.Canceled) {
+			logf(log.LevelError, "%v", err)
+		}
+
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func run(
+	parentCtx context.Context,
+	i *monitor.InputProcess,
+	config config,
+	logf log.Func,
+) error {
+	d, err := detectorByName(config.detectorName)
+	if err != nil {
+		return err
+	}
+
+	infoCtx, infoCancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer infoCancel()
+	streamInfo, err := i.StreamInfo(infoCtx)
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+
+	width, height := d.Size()
+	l, err := newLetterbox(streamInfo.VideoWidth, streamInfo.VideoHeight, width, height)
+	if err != nil {
+		return err
+	}
+
+	args := generateFFmpegArgs(config, l, i.RTSPprotocol(), i.RTSPaddress())
+	cmd := exec.Command(i.Env.FFmpegBin, args...)
+
+	processLogFunc := func(msg string) {
+		logf(log.FFmpegLevel(config.logLevel), "process: %v", msg)
+	}
+	process := ffmpeg.NewProcess(cmd).
+		StderrLogger(processLogFunc)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("stdout: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	inst := newInstance(d, config, l, i.SendEvent, logf)
+	i.WG.Add(1)
+	go func() {
+		defer i.WG.Done()
+		err := inst.run(ctx, stdout)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+			logf(log.LevelError, "instance: %v", err)
+		}
+		cancel()
+	}()
+
+	logf(log.LevelInfo, "starting process: %v", cmd)
+
+	if err := process.Start(ctx); err != nil {
+		return fmt.Errorf("process crashed: %w", err)
+	}
+	return nil
+}
+
+// ErrInvalidSize invalid frame size.
+var ErrInvalidSize = errors.New("invalid size")
+
+// letterbox scales the input to fit inside the detector
+// frame while keeping the aspect ratio. The frame is
+// padded on the bottom and right sides.
+type letterbox struct {
+	width        int
+	height       int
+	scaledWidth  int
+	scaledHeight int
+}
+
+func newLetterbox(inputWidth, inputHeight, width, height int) (letterbox, error) {
+	if inputWidth <= 0 || inputHeight <= 0 {
+		return letterbox{}, fmt.Errorf("%w: input %vx%v", ErrInvalidSize, inputWidth, inputHeight)
+	}
+	if width <= 0 || height <= 0 {
+		return letterbox{}, fmt.Errorf("%w: detector %vx%v", ErrInvalidSize, width, height)
+	}
+
+	scale := math.Min(
+		float64(width)/float64(inputWidth),
+		float64(height)/float64(inputHeight),
+	)
+	clamp := func(v float64, limit int) int {
+		if int(v) < 1 {
+			return 1
+		}
+		if int(v) > limit {
+			return limit
+		}
+		return int(v)
+	}
+
+	return letterbox{
+		width:        width,
+		height:       height,
+		scaledWidth:  clamp(float64(inputWidth)*scale, width),
+		scaledHeight: clamp(float64(inputHeight)*scale, height),
+	}, nil
+}
+
+func (l letterbox) frameSize() int {
+	return l.width * l.height * 3
+}
+
+// toPercent converts frame coordinates to input percentages.
+func (l letterbox) toPercent(d Detection) ffmpeg.Rect {
+	convert := func(v float64, padded int, scaled int) int {
+		percent := int(v * float64(padded) / float64(scaled) * 100)
+		if percent < 0 {
+			return 0
+		}
+		if percent > 100 {
+			return 100
+		}
+		return percent
+	}
+	return ffmpeg.Rect{
+		convert(d.Top, l.height, l.scaledHeight),
+		convert(d.Left, l.width, l.scaledWidth),
+		convert(d.Bottom, l.height, l.scaledHeight),
+		convert(d.Right, l.width, l.scaledWidth),
+	}
+}
+
+func generateFFmpegArgs(
+	c config,
+	l letterbox,
+	rtspProtocol string,
+	rtspAddress string,
+) []string {
+	// Output.
+	//	ffmpeg -y -threads 1 -loglevel error -hwaccel x -rtsp_transport tcp
+	//    -i rtsp://ip -vf "fps=fps=2,scale=640:360,pad=640:640:0:0"
+	//    -f rawvideo -pix_fmt rgb24 -
+
+	var args []string
+
+	args = append(args, "-y", "-threads", "1", "-loglevel", c.logLevel)
+
+	if c.hwaccel != "" {
+		args = append(args, ffmpeg.ParseArgs(c.hwaccel)...)
+	}
+
+	args = append(args, "-rtsp_transport", rtspProtocol, "-i", rtspAddress)
+
+	fps := strconv.FormatFloat(c.feedRate, 'f', -1, 64)
+	scale := strconv.Itoa(l.scaledWidth) + ":" + strconv.Itoa(l.scaledHeight)
+	pad := strconv.Itoa(l.width) + ":" + strconv.Itoa(l.height) + ":0:0"
+	args = append(args, "-vf", "fps=fps="+fps+",scale="+scale+",pad="+pad)
+	args = append(args, "-f", "rawvideo", "-pix_fmt", "rgb24", "-")
+
+	return args
+}
+
+// Time limit of a single detection.
+const detectTimeout = 10 * time.Second
+
+// Number of frame buffers, one is read while
+// another waits and the third is being detected.
+const frameBuffers = 3
+
+// Frames older than this number of frame intervals are
+// skipped, the detector is shared with too many monitors.
+const maxFrameAge = 2
+
+// Interval between the dropped frame warnings.
+const dropReportInterval = time.Minute
+
+type frame struct {
+	data     []byte
+	time     time.Time
+	received time.Time
+}
+
+type instance struct {
+	detector  Detector
+	c         config
+	l         letterbox
+	sendEvent monitor.SendEventFunc
+	logf      log.Func
+
+	eventDuration time.Duration
+	tracker       *tracker
+
+	// Frame counters since the last report, dropped
+	// is also incremented by the frame reader.
+	frames     int64
+	dropped    int64
+	lastReport time.Time
+}
+
+func newInstance(
+	d Detector,
+	c config,
+	l letterbox,
+	sendEvent monitor.SendEventFunc,
+	logf log.Func,
+) *instance {
+	return &instance{
+		detector:  d,
+		c:         c,
+		l:         l,
+		sendEvent: sendEvent,
+		logf:      logf,
+
+		eventDuration: ffmpeg.FeedRateToDuration(c.feedRate),
+		tracker:       newTracker(),
+		lastReport:    time.Now(),
+	}
+}
+
+// run reads frames from the process until it exits. Only the latest
+// frame is kept if the detector is slower than the feed rate and
+// frames that waited too long for the detector are skipped.
+func (i *instance) run(ctx context.Context, stdout io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	latest := make(chan frame, 1)
+	free := make(chan []byte, frameBuffers)
+	for n := 0; n < frameBuffers; n++ {
+		free <- make([]byte, i.l.frameSize())
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- i.readFrames(ctx, stdout, latest, free)
+	}()
+
+	for {
+		select {
+		case err := <-readErr:
+			return err
+		case f := <-latest:
+			var err error
+			if i.stale(f, time.Now()) {
+				atomic.AddInt64(&i.dropped, 1)
+			} else {
+				err = i.detect(ctx, f)
+			}
+			free <- f.data
+			i.reportDropped(time.Now())
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (i *instance) readFrames(
+	ctx context.Context,
+	stdout io.Reader,
+	latest chan frame,
+	free chan []byte,
+) error {
+	for {
+		var buf []byte
+		select {
+		case buf = <-free:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if _, err := io.ReadFull(stdout, buf); err != nil {
+			return fmt.Errorf("read stdout: %w", err)
+		}
+		now := time.Now()
+		f := frame{
+			data:     buf,
+			time:     now.Add(-i.c.timestampOffset),
+			received: now,
+		}
+		atomic.AddInt64(&i.frames, 1)
+
+		select {
+		case latest <- f:
+		default:
+			// Replace the waiting frame.
+			select {
+			case old := <-latest:
+				free <- old.data
+				atomic.AddInt64(&i.dropped, 1)
+			default:
+			}
+			latest <- f
+		}
+	}
+}
+
+// stale if the frame waited longer than maxFrameAge frame intervals.
+func (i *instance) stale(f frame, now time.Time) bool {
+	maxAge := time.Duration(maxFrameAge / i.c.feedRate * float64(time.Second))
+	return now.Sub(f.received) > maxAge
+}
+
+// reportDropped logs the share of dropped frames at most
+// once per dropReportInterval, the counters are reset.
+func (i *instance) reportDropped(now time.Time) {
+	if now.Sub(i.lastReport) < dropReportInterval {
+		return
+	}
+	i.lastReport = now
+	frames := atomic.SwapInt64(&i.frames, 0)
+	dropped := atomic.SwapInt64(&i.dropped, 0)
+	if dropped == 0 || frames == 0 {
+		return
+	}
+	i.logf(log.LevelWarning,
+		"detector can't keep up, dropped %v of %v frames (%.0f%%),"+
+			" lower the feed rate or add detector instances",
+		dropped, frames, float64(dropped)/float64(frames)*100)
+}
+
+func (i *instance) detect(ctx context.Context, f frame) error {
+	if !i.c.schedule.Active(f.time) {
+		return nil
+	}
+
+	ctx2, cancel := context.WithTimeout(ctx, detectTimeout)
+	defer cancel()
+
+	detections, err := i.detector.Detect(ctx2, f.data)
+	if err != nil {
+		return fmt.Errorf("detect: %w", err)
+	}
+
+	parsed := i.applyTracking(i.parseDetections(detections), f.time)
+	if len(parsed) == 0 {
+		return nil
+	}
+
+	i.logf(log.LevelDebug, "trigger: label:%v score:%.1f",
+		parsed[0].Label, parsed[0].Score)
+
+	err = i.sendEvent(storage.Event{
+		Time:        f.time,
+		Detections:  parsed,
+		Duration:    i.eventDuration,
+		RecDuration: i.c.recDuration,
+	})
+	if err != nil {
+		return fmt.Errorf("send event: %w", err)
+	}
+	return nil
+}
+
+// parseDetections drops detections below the label
+// threshold and converts the rest to events.
+func (i *instance) parseDetections(detections []Detection) []storage.Detection {
+	var parsed []storage.Detection
+	for _, d := range detections {
+		threshold, exist := i.c.thresholds[d.Label]
+		if !exist || d.Score < threshold {
+			continue
+		}
+		rect := i.l.toPercent(d)
+		parsed = append(parsed, storage.Detection{
+			Label: d.Label,
+			Score: d.Score,
+			Region: &storage.Region{
+				Rect: &rect,
+			},
+		})
+	}
+	return parsed
+}
+
+// applyTracking labels with a line or loitering rule only trigger
+// through the rules. Other labels trigger on every detection, or
+// once per reportInterval for each object if tracking is enabled.
+func (i *instance) applyTracking(detections []storage.Detection, now time.Time) []storage.Detection {
+	if !i.c.tracking && len(i.c.lines) == 0 && len(i.c.loitering) == 0 {
+		return detections
+	}
+	ruleLabels := make(map[string]bool)
+	for _, l := range i.c.lines {
+		ruleLabels[l.Label] = true
+	}
+	for _, r := range i.c.loitering {
+		ruleLabels[r.Label] = true
+	}
+
+	var passed, tracked []storage.Detection
+	for _, d := range detections {
+		if i.c.tracking || ruleLabels[d.Label] {
+			tracked = append(tracked, d)
+		} else {
+			passed = append(passed, d)
+		}
+	}
+
+	objects := i.tracker.update(tracked, now)
+	passed = append(passed, checkLines(i.c.lines, objects)...)
+	passed = append(passed, checkLoitering(i.c.loitering, objects, now)...)
+	if !i.c.tracking {
+		return passed
+	}
+
+	// Keep the recording active while the object is visible.
+	reportInterval := i.c.recDuration / 2
+	if reportInterval < i.eventDuration {
+		reportInterval = i.eventDuration
+	}
+	for _, obj := range objects {
+		tr := obj.track
+		if ruleLabels[obj.label] || !tr.confirmed(i.tracker.minHits) {
+			continue
+		}
+		if !tr.reported.IsZero() && now.Sub(tr.reported) < reportInterval {
+			continue
+		}
+		tr.reported = now
+		passed = append(passed, obj.detection(obj.label))
+	}
+	return passed
+}
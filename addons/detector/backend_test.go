@@ -0,0 +1,239 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/schedule"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLetterbox(t *testing.T) {
+	cases := map[string]struct {
+		inputWidth  int
+		inputHeight int
+		expected    letterbox
+	}{
+		"wide": {1920, 1080, letterbox{640, 640, 640, 360}},
+		"tall": {1080, 1920, letterbox{640, 640, 360, 640}},
+		"same": {320, 320, letterbox{640, 640, 640, 640}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			l, err := newLetterbox(tc.inputWidth, tc.inputHeight, 640, 640)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, l)
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		_, err := newLetterbox(0, 1080, 640, 640)
+		require.ErrorIs(t, err, ErrInvalidSize)
+		_, err = newLetterbox(1920, 1080, 640, 0)
+		require.ErrorIs(t, err, ErrInvalidSize)
+	})
+}
+
+func TestLetterboxToPercent(t *testing.T) {
+	l := letterbox{width: 640, height: 640, scaledWidth: 640, scaledHeight: 320}
+	d := Detection{Top: 0.25, Left: 0.1, Bottom: 0.75, Right: 0.2}
+	require.Equal(t, ffmpeg.Rect{50, 10, 100, 20}, l.toPercent(d))
+}
+
+func TestGenerateFFmpegArgs(t *testing.T) {
+	c := config{
+		hwaccel:  "-hwaccel 1",
+		logLevel: "2",
+		feedRate: 0.5,
+	}
+	l := letterbox{width: 640, height: 640, scaledWidth: 640, scaledHeight: 360}
+	args := generateFFmpegArgs(c, l, "tcp", "rtsp://x")
+	expected := []string{
+		"-y", "-threads", "1", "-loglevel", "2", "-hwaccel", "1",
+		"-rtsp_transport", "tcp", "-i", "rtsp://x",
+		"-vf", "fps=fps=0.5,scale=640:360,pad=640:640:0:0",
+		"-f", "rawvideo", "-pix_fmt", "rgb24", "-",
+	}
+	require.Equal(t, expected, args)
+}
+
+func TestParseDetections(t *testing.T) {
+	i := &instance{
+		c: config{thresholds: thresholds{"person": 50, "car": 70}},
+		l: letterbox{width: 10, height: 10, scaledWidth: 10, scaledHeight: 10},
+	}
+	detections := []Detection{
+		{Label: "person", Score: 60, Top: 0.1, Left: 0.2, Bottom: 0.3, Right: 0.4},
+		{Label: "car", Score: 60},
+		{Label: "dog", Score: 99},
+	}
+	expected := []storage.Detection{{
+		Label: "person",
+		Score: 60,
+		Region: &storage.Region{
+			Rect: &ffmpeg.Rect{10, 20, 30, 40},
+		},
+	}}
+	require.Equal(t, expected, i.parseDetections(detections))
+}
+
+func newTestInstance(
+	d Detector,
+	sendEvent func(storage.Event) error,
+) *instance {
+	c := config{
+		thresholds:  thresholds{"a": 50},
+		feedRate:    1,
+		recDuration: 3 * time.Second,
+	}
+	l := letterbox{width: 2, height: 1, scaledWidth: 2, scaledHeight: 1}
+	logf := func(log.Level, string, ...interface{}) {}
+	return newInstance(d, c, l, sendEvent, logf)
+}
+
+func TestInstance(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var frames [][]byte
+		d := &stubDetector{
+			detectFunc: func(_ context.Context, frame []byte) ([]Detection, error) {
+				frames = append(frames, append([]byte{}, frame...))
+				return []Detection{{Label: "a", Score: 60, Bottom: 1, Right: 1}}, nil
+			},
+		}
+		var events []storage.Event
+		i := newTestInstance(d, func(e storage.Event) error {
+			events = append(events, e)
+			return nil
+		})
+
+		stdout := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6})
+		err := i.run(context.Background(), stdout)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, [][]byte{{1, 2, 3, 4, 5, 6}}, frames)
+
+		require.Len(t, events, 1)
+		require.Equal(t, time.Second, events[0].Duration)
+		require.Equal(t, 3*time.Second, events[0].RecDuration)
+		require.Equal(t, &ffmpeg.Rect{0, 0, 100, 100}, events[0].Detections[0].Region.Rect)
+	})
+	t.Run("dropFrames", func(t *testing.T) {
+		stdout, w := io.Pipe()
+		detecting := make(chan struct{})
+		release := make(chan struct{})
+		detected := make(chan struct{})
+		var frames [][]byte
+		d := &stubDetector{
+			detectFunc: func(_ context.Context, frame []byte) ([]Detection, error) {
+				frames = append(frames, append([]byte{}, frame...))
+				if len(frames) == 1 {
+					close(detecting)
+					<-release
+				} else {
+					close(detected)
+				}
+				return nil, nil
+			},
+		}
+		i := newTestInstance(d, nil)
+
+		done := make(chan error)
+		go func() { done <- i.run(context.Background(), stdout) }()
+
+		frame := func(v byte) []byte { return bytes.Repeat([]byte{v}, 6) }
+		_, err := w.Write(frame(1))
+		require.NoError(t, err)
+		<-detecting
+
+		// Only the latest frame is kept while detecting.
+		for _, v := range []byte{2, 3, 4} {
+			_, err = w.Write(frame(v))
+			require.NoError(t, err)
+		}
+		for atomic.LoadInt64(&i.dropped) != 2 {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+
+		<-detected
+		w.Close()
+		require.ErrorIs(t, <-done, io.EOF)
+		require.Equal(t, [][]byte{frame(1), frame(4)}, frames)
+	})
+	t.Run("stale", func(t *testing.T) {
+		i := newTestInstance(nil, nil)
+		now := time.Now()
+		require.False(t, i.stale(frame{received: now.Add(-time.Second)}, now))
+		require.True(t, i.stale(frame{received: now.Add(-3 * time.Second)}, now))
+	})
+	t.Run("schedule", func(t *testing.T) {
+		d := &stubDetector{
+			detectFunc: func(context.Context, []byte) ([]Detection, error) {
+				t.Fatal("detector called outside schedule")
+				return nil, nil
+			},
+		}
+		i := newTestInstance(d, nil)
+		i.c.schedule = schedule.Schedule{{Days: []string{"mon"}, Start: "00:00", End: "00:01"}}
+		err := i.detect(context.Background(), frame{time: time.Date(2022, 1, 4, 12, 0, 0, 0, time.Local)})
+		require.NoError(t, err)
+	})
+	t.Run("detectErr", func(t *testing.T) {
+		errMock := errors.New("mock")
+		d := &stubDetector{
+			detectFunc: func(context.Context, []byte) ([]Detection, error) {
+				return nil, errMock
+			},
+		}
+		i := newTestInstance(d, nil)
+		err := i.run(context.Background(), bytes.NewReader(make([]byte, 6)))
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+func TestReportDropped(t *testing.T) {
+	i := newTestInstance(nil, nil)
+	var msgs []string
+	i.logf = func(_ log.Level, format string, a ...interface{}) {
+		msgs = append(msgs, fmt.Sprintf(format, a...))
+	}
+	start := i.lastReport
+	i.frames, i.dropped = 10, 5
+
+	i.reportDropped(start.Add(time.Second))
+	require.Empty(t, msgs)
+
+	i.reportDropped(start.Add(dropReportInterval))
+	require.Equal(t, []string{
+		"detector can't keep up, dropped 5 of 10 frames (50%)," +
+			" lower the feed rate or add detector instances",
+	}, msgs)
+	require.Equal(t, int64(0), i.frames)
+	require.Equal(t, int64(0), i.dropped)
+
+	i.frames = 10
+	i.reportDropped(start.Add(2 * dropReportInterval))
+	require.Len(t, msgs, 1)
+}
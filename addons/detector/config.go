@@ -0,0 +1,180 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
+	"strconv"
+	"time"
+)
+
+type config struct {
+	monitorID       string
+	hwaccel         string
+	logLevel        string
+	timestampOffset time.Duration
+	detectorName    string
+	thresholds      thresholds
+	feedRate        float64
+	recDuration     time.Duration
+	useSubStream    bool
+	tracking        bool
+	lines           []lineRule
+	loitering       []loiterRule
+	schedule        schedule.Schedule
+}
+
+// thresholds minimum score for each label. Labels
+// without a threshold never trigger events.
+type thresholds map[string]float64
+
+type rawConfigV0 struct {
+	Enable       string `json:"enable"`
+	DetectorName string `json:"detectorName"`
+	Thresholds   string `json:"thresholds"`
+	FeedRate     string `json:"feedRate"`
+	Duration     string `json:"duration"`
+	UseSubStream string `json:"useSubStream"`
+	Tracking     string `json:"tracking"`
+	Lines        string `json:"lines"`
+	Loitering    string `json:"loitering"`
+	Schedule     string `json:"schedule"`
+}
+
+// Config errors.
+var (
+	ErrNoDetector      = errors.New("no detector selected")
+	ErrInvalidFeedRate = errors.New("invalid feed rate")
+	ErrInvalidDuration = errors.New("invalid duration")
+	ErrInvalidScore    = errors.New("invalid threshold")
+)
+
+const (
+	defaultFeedRate    = 2
+	defaultRecDuration = 120 * time.Second
+)
+
+func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
+	rawDetector := c.Get("objectDetection")
+	if rawDetector == "" {
+		return nil, false, nil
+	}
+
+	var rawConf rawConfigV0
+	if err := json.Unmarshal([]byte(rawDetector), &rawConf); err != nil {
+		return nil, false, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if rawConf.Enable != "true" {
+		return nil, false, nil
+	}
+	if rawConf.DetectorName == "" {
+		return nil, false, ErrNoDetector
+	}
+
+	timestampOffset, err := ffmpeg.ParseTimestampOffset(c.TimestampOffset())
+	if err != nil {
+		return nil, false, err
+	}
+
+	thresholds, err := parseThresholds(rawConf.Thresholds)
+	if err != nil {
+		return nil, false, err
+	}
+
+	feedRate := float64(defaultFeedRate)
+	if rawConf.FeedRate != "" {
+		feedRate, err = strconv.ParseFloat(rawConf.FeedRate, 64)
+		if err != nil || feedRate <= 0 {
+			return nil, false, fmt.Errorf("%w: %q", ErrInvalidFeedRate, rawConf.FeedRate)
+		}
+	}
+
+	recDuration := defaultRecDuration
+	if rawConf.Duration != "" {
+		seconds, err := strconv.ParseFloat(rawConf.Duration, 64)
+		if err != nil || seconds < 0 {
+			return nil, false, fmt.Errorf("%w: %q", ErrInvalidDuration, rawConf.Duration)
+		}
+		recDuration = time.Duration(seconds * float64(time.Second))
+	}
+
+	lines, err := parseLines(rawConf.Lines)
+	if err != nil {
+		return nil, false, err
+	}
+
+	loitering, err := parseLoitering(rawConf.Loitering)
+	if err != nil {
+		return nil, false, err
+	}
+
+	sched, err := schedule.Parse(rawConf.Schedule)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hw, err := c.HardwareAccel()
+	if err != nil {
+		return nil, false, fmt.Errorf("hwaccel: %w", err)
+	}
+
+	// Follow the monitor detect stream if unset.
+	useSubStream := c.DetectSubInput()
+	if rawConf.UseSubStream != "" {
+		useSubStream = c.SubInputEnabled() && rawConf.UseSubStream == "true"
+	}
+
+	return &config{
+		monitorID:       c.ID(),
+		hwaccel:         hw.DecodeArgs(),
+		logLevel:        c.LogLevel(),
+		timestampOffset: timestampOffset,
+		detectorName:    rawConf.DetectorName,
+		thresholds:      thresholds,
+		feedRate:        feedRate,
+		recDuration:     recDuration,
+		useSubStream:    useSubStream,
+		tracking:        rawConf.Tracking == "true",
+		lines:           lines,
+		loitering:       loitering,
+		schedule:        sched,
+	}, true, nil
+}
+
+// parseThresholds a threshold of -1 disables the label.
+func parseThresholds(raw string) (thresholds, error) {
+	t := thresholds{}
+	if raw == "" {
+		return t, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, fmt.Errorf("unmarshal thresholds: %w", err)
+	}
+	for label, score := range t {
+		if score == -1 {
+			delete(t, label)
+			continue
+		}
+		if score < 0 || score > 100 {
+			return nil, fmt.Errorf("%w: %v: %v", ErrInvalidScore, label, score)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		raw := `
+		{
+			"enable":       "true",
+			"detectorName": "onnx_yolov8n",
+			"thresholds":   "{\"person\":50,\"car\":-1}",
+			"feedRate":     "3",
+			"duration":     "60",
+			"useSubStream": "true",
+			"tracking":     "true"
+		}`
+		c := monitor.NewConfig(monitor.RawConfig{
+			"id":              "1",
+			"hwaccel":         "2",
+			"logLevel":        "3",
+			"timestampOffset": "4",
+			"subInput":        "x",
+			"objectDetection": raw,
+		})
+		actual, enable, err := parseConfig(c)
+		require.NoError(t, err)
+		require.True(t, enable)
+
+		expected := config{
+			monitorID:       "1",
+			hwaccel:         "-hwaccel 2",
+			logLevel:        "3",
+			timestampOffset: 4 * time.Millisecond,
+			detectorName:    "onnx_yolov8n",
+			thresholds:      thresholds{"person": 50},
+			feedRate:        3,
+			recDuration:     60 * time.Second,
+			useSubStream:    true,
+			tracking:        true,
+		}
+		require.Equal(t, expected, *actual)
+	})
+	t.Run("defaults", func(t *testing.T) {
+		c := monitor.NewConfig(monitor.RawConfig{
+			"objectDetection": `{"enable":"true","detectorName":"x"}`,
+		})
+		actual, enable, err := parseConfig(c)
+		require.NoError(t, err)
+		require.True(t, enable)
+		require.Equal(t, float64(defaultFeedRate), actual.feedRate)
+		require.Equal(t, defaultRecDuration, actual.recDuration)
+		require.Equal(t, thresholds{}, actual.thresholds)
+		require.False(t, actual.useSubStream)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		for _, raw := range []string{"", `{"enable":"false"}`} {
+			c := monitor.NewConfig(monitor.RawConfig{"objectDetection": raw})
+			_, enable, err := parseConfig(c)
+			require.NoError(t, err)
+			require.False(t, enable)
+		}
+	})
+	errorCases := map[string]struct {
+		raw         string
+		expectedErr error
+	}{
+		"noDetector": {
+			`{"enable":"true"}`, ErrNoDetector,
+		},
+		"feedRate": {
+			`{"enable":"true","detectorName":"x","feedRate":"0"}`, ErrInvalidFeedRate,
+		},
+		"duration": {
+			`{"enable":"true","detectorName":"x","duration":"-1"}`, ErrInvalidDuration,
+		},
+		"threshold": {
+			`{"enable":"true","detectorName":"x","thresholds":"{\"a\":101}"}`, ErrInvalidScore,
+		},
+		"lines": {
+			`{"enable":"true","detectorName":"x","lines":"[{}]"}`, ErrInvalidLine,
+		},
+		"schedule": {
+			`{"enable":"true","detectorName":"x","schedule":"[{\"start\":\"x\"}]"}`, schedule.ErrInvalidTime,
+		},
+		"loitering": {
+			`{"enable":"true","detectorName":"x","loitering":"[{}]"}`, ErrInvalidLoiterRule,
+		},
+	}
+	for name, tc := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			c := monitor.NewConfig(monitor.RawConfig{"objectDetection": tc.raw})
+			_, _, err := parseConfig(c)
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
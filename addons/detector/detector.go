@@ -0,0 +1,118 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Detector object detection backend. Backend addons
+// register their detectors when the app starts.
+type Detector interface {
+	// Name unique name shown in the monitor settings.
+	Name() string
+
+	// Size of the frames passed to Detect.
+	Size() (width int, height int)
+
+	// Labels that the detector can detect.
+	Labels() []string
+
+	// Detect objects in a RGB24 frame. Only one call
+	// is made at a time for each monitor.
+	Detect(ctx context.Context, frame []byte) ([]Detection, error)
+}
+
+// Detection detected object. The coordinates are
+// relative to the frame and range from 0 to 1.
+type Detection struct {
+	Label  string
+	Score  float64 // 0-100.
+	Top    float64
+	Left   float64
+	Bottom float64
+	Right  float64
+}
+
+// Registry errors.
+var (
+	ErrDetectorExist    = errors.New("detector already exists")
+	ErrDetectorNotExist = errors.New("detector does not exist")
+)
+
+var registry = struct {
+	detectors map[string]Detector
+	// Backends register their detectors when the app starts.
+	appRunning bool
+	mu         sync.Mutex
+}{
+	detectors: make(map[string]Detector),
+}
+
+// Register makes the detector selectable in the monitor settings.
+func Register(d Detector) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, exist := registry.detectors[d.Name()]; exist {
+		return fmt.Errorf("%w: %v", ErrDetectorExist, d.Name())
+	}
+	registry.detectors[d.Name()] = d
+	return nil
+}
+
+func detectorByName(name string) (Detector, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	d, exist := registry.detectors[name]
+	if !exist {
+		return nil, fmt.Errorf("%w: %q", ErrDetectorNotExist, name)
+	}
+	return d, nil
+}
+
+// detectorInfo is used by the monitor settings.
+type detectorInfo struct {
+	Name   string   `json:"name"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Labels []string `json:"labels"`
+}
+
+// detectorInfos returns the registered detectors sorted by name.
+func detectorInfos() []detectorInfo {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	infos := make([]detectorInfo, 0, len(registry.detectors))
+	for _, d := range registry.detectors {
+		width, height := d.Size()
+		infos = append(infos, detectorInfo{
+			Name:   d.Name(),
+			Width:  width,
+			Height: height,
+			Labels: d.Labels(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
@@ -0,0 +1,71 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubDetector struct {
+	name       string
+	width      int
+	height     int
+	labels     []string
+	detectFunc func(context.Context, []byte) ([]Detection, error)
+}
+
+func (d *stubDetector) Name() string {
+	return d.name
+}
+
+func (d *stubDetector) Size() (int, int) {
+	return d.width, d.height
+}
+
+func (d *stubDetector) Labels() []string {
+	return d.labels
+}
+
+func (d *stubDetector) Detect(ctx context.Context, frame []byte) ([]Detection, error) {
+	return d.detectFunc(ctx, frame)
+}
+
+func TestRegister(t *testing.T) {
+	defer func() {
+		registry.detectors = make(map[string]Detector)
+	}()
+
+	b := &stubDetector{name: "b", width: 1, height: 2, labels: []string{"x"}}
+	a := &stubDetector{name: "a", width: 3, height: 4}
+	require.NoError(t, Register(b))
+	require.NoError(t, Register(a))
+	require.ErrorIs(t, Register(a), ErrDetectorExist)
+
+	d, err := detectorByName("a")
+	require.NoError(t, err)
+	require.Equal(t, a, d)
+
+	_, err = detectorByName("c")
+	require.ErrorIs(t, err, ErrDetectorNotExist)
+
+	expected := []detectorInfo{
+		{Name: "a", Width: 3, Height: 4},
+		{Name: "b", Width: 1, Height: 2, Labels: []string{"x"}},
+	}
+	require.Equal(t, expected, detectorInfos())
+}
@@ -0,0 +1,138 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/storage"
+)
+
+// lineRule triggers when a object with the label crosses the line in
+// the direction. The direction is the side the object moves towards,
+// looking from the first point to the second.
+type lineRule struct {
+	Name      string        `json:"name"`
+	Label     string        `json:"label"`
+	Line      [2][2]float64 `json:"line"`
+	Direction string        `json:"direction"`
+}
+
+// Line directions.
+const (
+	directionBoth  = "both"
+	directionLeft  = "left"
+	directionRight = "right"
+)
+
+// ErrInvalidLine invalid line rule.
+var ErrInvalidLine = errors.New("invalid line")
+
+func parseLines(raw string) ([]lineRule, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var lines []lineRule
+	if err := json.Unmarshal([]byte(raw), &lines); err != nil {
+		return nil, fmt.Errorf("unmarshal lines: %w", err)
+	}
+	for i, l := range lines {
+		if l.Direction == "" {
+			lines[i].Direction = directionBoth
+		}
+		if err := lines[i].validate(); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidLine, l.Name, err)
+		}
+	}
+	return lines, nil
+}
+
+func (l lineRule) validate() error {
+	if l.Name == "" {
+		return errors.New("name is empty")
+	}
+	if l.Label == "" {
+		return errors.New("label is empty")
+	}
+	for _, p := range l.Line {
+		if p[0] < 0 || p[0] > 100 || p[1] < 0 || p[1] > 100 {
+			return fmt.Errorf("point out of range: %v", p)
+		}
+	}
+	if l.Line[0] == l.Line[1] {
+		return errors.New("points are equal")
+	}
+	switch l.Direction {
+	case directionBoth, directionLeft, directionRight:
+	default:
+		return fmt.Errorf("unknown direction: %q", l.Direction)
+	}
+	return nil
+}
+
+// side of the point relative to the line, positive is right. The
+// y axis points down so the sign is flipped from the usual convention.
+func (l lineRule) side(p point) float64 {
+	a := point{l.Line[0][0], l.Line[0][1]}
+	b := point{l.Line[1][0], l.Line[1][1]}
+	return cross(a, b, p)
+}
+
+func cross(a, b, p point) float64 {
+	return (b.x-a.x)*(p.y-a.y) - (b.y-a.y)*(p.x-a.x)
+}
+
+// crossed if the path from prev to cur crosses the line segment
+// in the rule direction. Points on the line don't count.
+func (l lineRule) crossed(prev point, cur point) bool {
+	sidePrev, sideCur := l.side(prev), l.side(cur)
+	if sidePrev == 0 || sidePrev*sideCur >= 0 {
+		return false
+	}
+
+	// The path must intersect the segment, not only the infinite line.
+	a := point{l.Line[0][0], l.Line[0][1]}
+	b := point{l.Line[1][0], l.Line[1][1]}
+	if cross(prev, cur, a)*cross(prev, cur, b) > 0 {
+		return false
+	}
+
+	switch l.Direction {
+	case directionLeft:
+		return sideCur < 0
+	case directionRight:
+		return sideCur > 0
+	}
+	return true
+}
+
+// checkLines returns a detection named after the rule for each object
+// that crossed a line. New objects don't have a path and are skipped.
+func checkLines(lines []lineRule, objects []trackedObject) []storage.Detection {
+	var detections []storage.Detection
+	for _, obj := range objects {
+		if obj.prev == nil {
+			continue
+		}
+		cur := center(obj.rect)
+		for _, l := range lines {
+			if l.Label == obj.label && l.crossed(*obj.prev, cur) {
+				detections = append(detections, obj.detection(l.Name))
+			}
+		}
+	}
+	return detections
+}
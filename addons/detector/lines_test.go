@@ -0,0 +1,123 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLines(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		raw := `[{"name":"entering","label":"car","line":[[0,50],[100,50]]}]`
+		lines, err := parseLines(raw)
+		require.NoError(t, err)
+		expected := []lineRule{{
+			Name:      "entering",
+			Label:     "car",
+			Line:      [2][2]float64{{0, 50}, {100, 50}},
+			Direction: directionBoth,
+		}}
+		require.Equal(t, expected, lines)
+	})
+	t.Run("empty", func(t *testing.T) {
+		lines, err := parseLines("")
+		require.NoError(t, err)
+		require.Nil(t, lines)
+	})
+	invalidCases := map[string]string{
+		"name":      `[{"label":"car","line":[[0,0],[1,1]]}]`,
+		"label":     `[{"name":"a","line":[[0,0],[1,1]]}]`,
+		"range":     `[{"name":"a","label":"car","line":[[0,0],[101,1]]}]`,
+		"equal":     `[{"name":"a","label":"car","line":[[1,1],[1,1]]}]`,
+		"direction": `[{"name":"a","label":"car","line":[[0,0],[1,1]],"direction":"up"}]`,
+	}
+	for name, raw := range invalidCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseLines(raw)
+			require.ErrorIs(t, err, ErrInvalidLine)
+		})
+	}
+}
+
+func TestLineCrossed(t *testing.T) {
+	// Horizontal line from left to right, the right side is down.
+	line := [2][2]float64{{20, 50}, {80, 50}}
+	cases := map[string]struct {
+		direction string
+		prev      point
+		cur       point
+		expected  bool
+	}{
+		"down":       {directionRight, point{50, 40}, point{50, 60}, true},
+		"downLeft":   {directionLeft, point{50, 40}, point{50, 60}, false},
+		"up":         {directionLeft, point{50, 60}, point{50, 40}, true},
+		"upBoth":     {directionBoth, point{50, 60}, point{50, 40}, true},
+		"sameSide":   {directionBoth, point{50, 40}, point{60, 45}, false},
+		"outside":    {directionBoth, point{10, 40}, point{10, 60}, false},
+		"onLine":     {directionBoth, point{50, 50}, point{50, 60}, false},
+		"toLine":     {directionBoth, point{50, 40}, point{50, 50}, false},
+		"endOfLine":  {directionBoth, point{80, 40}, point{80, 60}, true},
+		"diagonal":   {directionRight, point{10, 30}, point{30, 70}, true},
+		"notReached": {directionBoth, point{90, 30}, point{85, 70}, false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			l := lineRule{Line: line, Direction: tc.direction}
+			require.Equal(t, tc.expected, l.crossed(tc.prev, tc.cur))
+		})
+	}
+}
+
+func TestApplyLines(t *testing.T) {
+	now := time.Unix(1, 0)
+	i := &instance{
+		c: config{lines: []lineRule{{
+			Name:      "car entering",
+			Label:     "car",
+			Line:      [2][2]float64{{0, 50}, {100, 50}},
+			Direction: directionRight,
+		}}},
+		tracker: newTracker(),
+	}
+
+	person := rectDetection("person", ffmpeg.Rect{0, 0, 10, 10})
+	detections := i.applyTracking([]storage.Detection{
+		person,
+		rectDetection("car", ffmpeg.Rect{30, 40, 40, 50}),
+	}, now)
+	require.Equal(t, []storage.Detection{person}, detections)
+
+	// Moving down crosses the line.
+	detections = i.applyTracking([]storage.Detection{
+		rectDetection("car", ffmpeg.Rect{50, 40, 60, 50}),
+	}, now)
+	require.Equal(t, []storage.Detection{
+		rectDetection("car entering", ffmpeg.Rect{50, 40, 60, 50}),
+	}, detections)
+
+	// Leaving doesn't trigger.
+	i.tracker = newTracker()
+	for _, r := range []ffmpeg.Rect{{50, 40, 60, 50}, {30, 40, 40, 50}} {
+		detections = i.applyTracking([]storage.Detection{rectDetection("car", r)}, now)
+		require.Empty(t, detections)
+	}
+	require.Len(t, i.tracker.tracks, 1)
+}
@@ -0,0 +1,123 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+	"time"
+)
+
+// loiterRule triggers when a object with the label stays inside
+// the zone for longer than the duration. Once per visit.
+type loiterRule struct {
+	Name  string         `json:"name"`
+	Label string         `json:"label"`
+	Zone  ffmpeg.Polygon `json:"zone"`
+
+	// Seconds.
+	Duration float64 `json:"duration"`
+}
+
+// ErrInvalidLoiterRule invalid loitering rule.
+var ErrInvalidLoiterRule = errors.New("invalid loitering rule")
+
+func parseLoitering(raw string) ([]loiterRule, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var rules []loiterRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("unmarshal loitering: %w", err)
+	}
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidLoiterRule, r.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r loiterRule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is empty")
+	}
+	if r.Label == "" {
+		return errors.New("label is empty")
+	}
+	if len(r.Zone) < 3 {
+		return errors.New("zone must have at least 3 points")
+	}
+	for _, p := range r.Zone {
+		if p[0] < 0 || p[0] > 100 || p[1] < 0 || p[1] > 100 {
+			return fmt.Errorf("point out of range: %v", p)
+		}
+	}
+	if r.Duration <= 0 {
+		return fmt.Errorf("invalid duration: %v", r.Duration)
+	}
+	return nil
+}
+
+func (r loiterRule) duration() time.Duration {
+	return time.Duration(r.Duration * float64(time.Second))
+}
+
+func (r loiterRule) contains(p point) bool {
+	return r.Zone.Contains(int(math.Round(p.x)), int(math.Round(p.y)))
+}
+
+// zoneVisit time the object entered the zone of a loitering rule.
+type zoneVisit struct {
+	entered   time.Time
+	triggered bool
+}
+
+// checkLoitering returns a detection named after the rule for each
+// object that has been inside the zone long enough. The visit ends
+// when the center of the object leaves the zone.
+func checkLoitering(rules []loiterRule, objects []trackedObject, now time.Time) []storage.Detection {
+	var detections []storage.Detection
+	for _, obj := range objects {
+		tr := obj.track
+		c := center(obj.rect)
+		for n, r := range rules {
+			if r.Label != obj.label {
+				continue
+			}
+			if !r.contains(c) {
+				delete(tr.visits, n)
+				continue
+			}
+			if tr.visits == nil {
+				tr.visits = make(map[int]*zoneVisit)
+			}
+			visit, exist := tr.visits[n]
+			if !exist {
+				visit = &zoneVisit{entered: now}
+				tr.visits[n] = visit
+			}
+			if !visit.triggered && now.Sub(visit.entered) >= r.duration() {
+				visit.triggered = true
+				detections = append(detections, obj.detection(r.Name))
+			}
+		}
+	}
+	return detections
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoitering(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		raw := `[{"name":"loitering","label":"person","zone":[[0,0],[50,0],[50,50]],"duration":30}]`
+		rules, err := parseLoitering(raw)
+		require.NoError(t, err)
+		expected := []loiterRule{{
+			Name:     "loitering",
+			Label:    "person",
+			Zone:     ffmpeg.Polygon{{0, 0}, {50, 0}, {50, 50}},
+			Duration: 30,
+		}}
+		require.Equal(t, expected, rules)
+		require.Equal(t, 30*time.Second, rules[0].duration())
+	})
+	invalidCases := map[string]string{
+		"name":     `[{"label":"a","zone":[[0,0],[1,0],[1,1]],"duration":1}]`,
+		"label":    `[{"name":"a","zone":[[0,0],[1,0],[1,1]],"duration":1}]`,
+		"zone":     `[{"name":"a","label":"a","zone":[[0,0],[1,0]],"duration":1}]`,
+		"range":    `[{"name":"a","label":"a","zone":[[0,0],[101,0],[1,1]],"duration":1}]`,
+		"duration": `[{"name":"a","label":"a","zone":[[0,0],[1,0],[1,1]]}]`,
+	}
+	for name, raw := range invalidCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseLoitering(raw)
+			require.ErrorIs(t, err, ErrInvalidLoiterRule)
+		})
+	}
+}
+
+func TestCheckLoitering(t *testing.T) {
+	rules := []loiterRule{{
+		Name:     "loitering",
+		Label:    "person",
+		Zone:     ffmpeg.Polygon{{0, 0}, {50, 0}, {50, 50}, {0, 50}},
+		Duration: 10,
+	}}
+	now := time.Unix(0, 0)
+	inside := rectDetection("person", ffmpeg.Rect{10, 10, 20, 20})
+	outside := rectDetection("person", ffmpeg.Rect{70, 70, 80, 80})
+
+	tr := newTracker()
+	check := func(d storage.Detection, seconds int) []storage.Detection {
+		at := now.Add(time.Duration(seconds) * time.Second)
+		return checkLoitering(rules, tr.update([]storage.Detection{d}, at), at)
+	}
+
+	require.Empty(t, check(inside, 0))
+	require.Empty(t, check(inside, 9))
+	require.Equal(t, []storage.Detection{
+		rectDetection("loitering", ffmpeg.Rect{10, 10, 20, 20}),
+	}, check(inside, 10))
+
+	// Once per visit.
+	require.Empty(t, check(inside, 20))
+
+	// The object is tracked but the visit restarts after leaving.
+	tr.maxDistance = 100
+	require.Empty(t, check(outside, 21))
+	require.Empty(t, check(inside, 22))
+	require.Len(t, check(inside, 32), 1)
+
+	// Other labels are ignored.
+	tr = newTracker()
+	require.Empty(t, check(rectDetection("car", ffmpeg.Rect{10, 10, 20, 20}), 0))
+	require.Empty(t, check(rectDetection("car", ffmpeg.Rect{10, 10, 20, 20}), 100))
+}
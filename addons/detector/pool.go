@@ -0,0 +1,92 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"context"
+	"sync"
+)
+
+// pool hands out a fixed number of slots in the order they were
+// requested. Monitors only wait with one frame at a time, so a
+// busy monitor can't starve the others by detecting repeatedly.
+type pool struct {
+	mu      sync.Mutex
+	free    []int
+	waiters []chan int
+}
+
+func newPool(size int) *pool {
+	free := make([]int, size)
+	for i := range free {
+		free[i] = i
+	}
+	return &pool{free: free}
+}
+
+// acquire waits for a free slot.
+func (p *pool) acquire(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	if len(p.free) != 0 && len(p.waiters) == 0 {
+		// Rotate the slots to spread the load.
+		slot := p.free[0]
+		p.free = p.free[1:]
+		p.mu.Unlock()
+		return slot, nil
+	}
+	wait := make(chan int, 1)
+	p.waiters = append(p.waiters, wait)
+	p.mu.Unlock()
+
+	select {
+	case slot := <-wait:
+		return slot, nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	for i, w := range p.waiters {
+		if w == wait {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.mu.Unlock()
+			return 0, ctx.Err()
+		}
+	}
+	p.mu.Unlock()
+
+	// The slot was handed over before the waiter was removed.
+	p.release(<-wait)
+	return 0, ctx.Err()
+}
+
+// release gives the slot to the longest waiting caller.
+func (p *pool) release(slot int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiters) != 0 {
+		wait := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		wait <- slot
+		return
+	}
+	p.free = append(p.free, slot)
+}
+
+// size returns the number of free slots and waiting callers.
+func (p *pool) size() (free int, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.free), len(p.waiters)
+}
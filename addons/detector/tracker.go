@@ -0,0 +1,269 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"math"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+	"sort"
+	"time"
+)
+
+type point struct {
+	x float64
+	y float64
+}
+
+// box top, left, bottom and right in percent of the frame.
+type box [4]float64
+
+func newBox(r ffmpeg.Rect) box {
+	return box{float64(r[0]), float64(r[1]), float64(r[2]), float64(r[3])}
+}
+
+func (b box) center() point {
+	return point{x: (b[1] + b[3]) / 2, y: (b[0] + b[2]) / 2}
+}
+
+func (b box) area() float64 {
+	return math.Max(0, b[2]-b[0]) * math.Max(0, b[3]-b[1])
+}
+
+func (b box) iou(b2 box) float64 {
+	intersection := box{
+		math.Max(b[0], b2[0]),
+		math.Max(b[1], b2[1]),
+		math.Min(b[2], b2[2]),
+		math.Min(b[3], b2[3]),
+	}.area()
+	union := b.area() + b2.area() - intersection
+	if union <= 0 {
+		return 0
+	}
+	return intersection / union
+}
+
+func distance(a, b point) float64 {
+	return math.Hypot(a.x-b.x, a.y-b.y)
+}
+
+func center(r ffmpeg.Rect) point {
+	return newBox(r).center()
+}
+
+// trackedObject detection with the stable id of the object. prev is
+// the center of the object in the previous frame, nil if it's new.
+type trackedObject struct {
+	id    int
+	label string
+	score float64
+	rect  ffmpeg.Rect
+	prev  *point
+
+	track *track
+}
+
+func (o trackedObject) detection(label string) storage.Detection {
+	rect := o.rect
+	return storage.Detection{
+		Label:  label,
+		Score:  o.score,
+		Region: &storage.Region{Rect: &rect},
+	}
+}
+
+type track struct {
+	id    int
+	label string
+	box   box
+
+	// Change per frame, estimated from the previous matches.
+	velocity box
+
+	hits   int
+	missed int
+
+	firstSeen time.Time
+	lastSeen  time.Time
+
+	// Time of the last event of the object.
+	reported time.Time
+
+	// Loitering zone visits by rule index.
+	visits map[int]*zoneVisit
+}
+
+// predict the box in the next frame assuming constant velocity.
+func (t *track) predict() box {
+	frames := float64(t.missed + 1)
+	var b box
+	for n := range b {
+		b[n] = t.box[n] + t.velocity[n]*frames
+	}
+	return b
+}
+
+func (t *track) confirmed(minHits int) bool {
+	return t.hits >= minHits
+}
+
+// dwell time since the object was first seen.
+func (t *track) dwell() time.Duration {
+	return t.lastSeen.Sub(t.firstSeen)
+}
+
+// tracker a SORT-style tracker. The tracks are predicted using a
+// constant velocity model and matched to the detections of the same
+// label by overlap. Low feed rates rarely overlap, the distance
+// between the centers is used as a fallback.
+type tracker struct {
+	tracks []*track
+	nextID int
+
+	// Minimum overlap of the predicted box, 0-1.
+	iouThreshold float64
+
+	// Maximum distance between the predicted and detected centers
+	// in percent if the boxes don't overlap enough.
+	maxDistance float64
+
+	// Number of frames a track is kept without a matching detection.
+	maxMissed int
+
+	// Matches before the track is confirmed.
+	minHits int
+}
+
+const (
+	defaultIOUThreshold = 0.3
+	defaultMaxDistance  = 20
+	defaultMaxMissed    = 2
+	defaultMinHits      = 2
+)
+
+func newTracker() *tracker {
+	return &tracker{
+		iouThreshold: defaultIOUThreshold,
+		maxDistance:  defaultMaxDistance,
+		maxMissed:    defaultMaxMissed,
+		minHits:      defaultMinHits,
+	}
+}
+
+type match struct {
+	track     *track
+	detection int
+	cost      float64
+}
+
+// update matches the detections in a frame to the tracks. Detections
+// without a region are ignored. Returns a object for each detection.
+func (t *tracker) update(detections []storage.Detection, now time.Time) []trackedObject {
+	boxes := make([]box, len(detections))
+	valid := make([]bool, len(detections))
+	for n, d := range detections {
+		if d.Region != nil && d.Region.Rect != nil {
+			boxes[n] = newBox(*d.Region.Rect)
+			valid[n] = true
+		}
+	}
+
+	// Greedy assignment, the best matches first.
+	var matches []match
+	for _, tr := range t.tracks {
+		predicted := tr.predict()
+		for n, d := range detections {
+			if !valid[n] || d.Label != tr.label {
+				continue
+			}
+			iou := predicted.iou(boxes[n])
+			dist := distance(predicted.center(), boxes[n].center())
+			if iou < t.iouThreshold && dist > t.maxDistance {
+				continue
+			}
+			matches = append(matches, match{
+				track:     tr,
+				detection: n,
+				cost:      1 - iou + dist/100,
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].cost < matches[j].cost
+	})
+
+	trackOf := make([]*track, len(detections))
+	matched := make(map[*track]bool)
+	for _, m := range matches {
+		if matched[m.track] || trackOf[m.detection] != nil {
+			continue
+		}
+		matched[m.track] = true
+		trackOf[m.detection] = m.track
+	}
+
+	objects := make([]trackedObject, 0, len(detections))
+	for n, d := range detections {
+		if !valid[n] {
+			continue
+		}
+		obj := trackedObject{label: d.Label, score: d.Score, rect: *d.Region.Rect}
+
+		tr := trackOf[n]
+		if tr == nil {
+			tr = &track{
+				id:        t.nextID,
+				label:     d.Label,
+				box:       boxes[n],
+				firstSeen: now,
+			}
+			t.nextID++
+			t.tracks = append(t.tracks, tr)
+			matched[tr] = true
+		} else {
+			prev := tr.box.center()
+			obj.prev = &prev
+
+			frames := float64(tr.missed + 1)
+			for i := range tr.velocity {
+				observed := (boxes[n][i] - tr.box[i]) / frames
+				tr.velocity[i] = (tr.velocity[i] + observed) / 2
+			}
+			tr.box = boxes[n]
+		}
+		tr.hits++
+		tr.missed = 0
+		tr.lastSeen = now
+
+		obj.id = tr.id
+		obj.track = tr
+		objects = append(objects, obj)
+	}
+
+	// Forget old tracks.
+	tracks := t.tracks[:0]
+	for _, tr := range t.tracks {
+		if !matched[tr] {
+			tr.missed++
+		}
+		if tr.missed <= t.maxMissed {
+			tracks = append(tracks, tr)
+		}
+	}
+	t.tracks = tracks
+
+	return objects
+}
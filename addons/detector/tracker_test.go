@@ -0,0 +1,144 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rectDetection(label string, r ffmpeg.Rect) storage.Detection {
+	return storage.Detection{Label: label, Score: 50, Region: &storage.Region{Rect: &r}}
+}
+
+func TestBoxIOU(t *testing.T) {
+	a := box{0, 0, 10, 10}
+	require.Equal(t, 1.0, a.iou(a))
+	require.Equal(t, 0.0, a.iou(box{20, 20, 30, 30}))
+	require.InDelta(t, 50.0/150, a.iou(box{0, 5, 10, 15}), 0.0001)
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Unix(1, 0)
+	t.Run("associate", func(t *testing.T) {
+		tr := newTracker()
+		objects := tr.update([]storage.Detection{
+			rectDetection("car", ffmpeg.Rect{0, 0, 10, 10}),
+			rectDetection("car", ffmpeg.Rect{50, 50, 60, 60}),
+		}, now)
+		require.Len(t, objects, 2)
+		require.Nil(t, objects[0].prev)
+		require.Nil(t, objects[1].prev)
+
+		objects = tr.update([]storage.Detection{
+			rectDetection("car", ffmpeg.Rect{52, 52, 62, 62}),
+			rectDetection("car", ffmpeg.Rect{2, 2, 12, 12}),
+		}, now)
+		require.Equal(t, 1, objects[0].id)
+		require.Equal(t, &point{55, 55}, objects[0].prev)
+		require.Equal(t, 0, objects[1].id)
+		require.Equal(t, &point{5, 5}, objects[1].prev)
+		require.True(t, objects[0].track.confirmed(tr.minHits))
+	})
+	t.Run("bestMatch", func(t *testing.T) {
+		tr := newTracker()
+		tr.update([]storage.Detection{rectDetection("car", ffmpeg.Rect{0, 0, 10, 10})}, now)
+
+		// The closer detection gets the track.
+		objects := tr.update([]storage.Detection{
+			rectDetection("car", ffmpeg.Rect{5, 5, 15, 15}),
+			rectDetection("car", ffmpeg.Rect{1, 1, 11, 11}),
+		}, now)
+		require.Equal(t, 1, objects[0].id)
+		require.Equal(t, 0, objects[1].id)
+	})
+	t.Run("label", func(t *testing.T) {
+		tr := newTracker()
+		tr.update([]storage.Detection{rectDetection("car", ffmpeg.Rect{0, 0, 10, 10})}, now)
+		objects := tr.update([]storage.Detection{
+			rectDetection("person", ffmpeg.Rect{0, 0, 10, 10}),
+		}, now)
+		require.Equal(t, 1, objects[0].id)
+		require.Nil(t, objects[0].prev)
+	})
+	t.Run("distance", func(t *testing.T) {
+		tr := newTracker()
+		tr.update([]storage.Detection{rectDetection("car", ffmpeg.Rect{0, 0, 10, 10})}, now)
+		objects := tr.update([]storage.Detection{
+			rectDetection("car", ffmpeg.Rect{50, 50, 60, 60}),
+		}, now)
+		require.Nil(t, objects[0].prev)
+	})
+	t.Run("predict", func(t *testing.T) {
+		// The object moves 15% per frame, the third frame is too far
+		// from the last position but close to the predicted position.
+		tr := newTracker()
+		for n, x := range []int{0, 15, 38} {
+			objects := tr.update([]storage.Detection{
+				rectDetection("car", ffmpeg.Rect{0, x, 10, x + 10}),
+			}, now)
+			require.Equal(t, 0, objects[0].id, n)
+		}
+	})
+	t.Run("missed", func(t *testing.T) {
+		tr := newTracker()
+		tr.update([]storage.Detection{rectDetection("car", ffmpeg.Rect{0, 0, 10, 10})}, now)
+		for n := 0; n < defaultMaxMissed; n++ {
+			tr.update(nil, now)
+		}
+		objects := tr.update([]storage.Detection{
+			rectDetection("car", ffmpeg.Rect{0, 0, 10, 10}),
+		}, now)
+		require.Equal(t, 0, objects[0].id)
+
+		for n := 0; n <= defaultMaxMissed; n++ {
+			tr.update(nil, now)
+		}
+		require.Empty(t, tr.tracks)
+	})
+	t.Run("dwell", func(t *testing.T) {
+		tr := newTracker()
+		d := rectDetection("person", ffmpeg.Rect{0, 0, 10, 10})
+		tr.update([]storage.Detection{d}, now)
+		objects := tr.update([]storage.Detection{d}, now.Add(5*time.Second))
+		require.Equal(t, 5*time.Second, objects[0].track.dwell())
+	})
+}
+
+func TestApplyTracking(t *testing.T) {
+	now := time.Unix(1, 0)
+	i := &instance{
+		c:             config{tracking: true, recDuration: 10 * time.Second},
+		eventDuration: time.Second,
+		tracker:       newTracker(),
+	}
+	person := rectDetection("person", ffmpeg.Rect{0, 0, 10, 10})
+
+	// Reported once confirmed and then once per half recording duration.
+	var reported []int
+	for n := 0; n < 8; n++ {
+		detections := i.applyTracking([]storage.Detection{person}, now.Add(time.Duration(n)*2*time.Second))
+		if len(detections) != 0 {
+			require.Equal(t, []storage.Detection{person}, detections)
+			reported = append(reported, n)
+		}
+	}
+	require.Equal(t, []int{1, 4, 7}, reported)
+}
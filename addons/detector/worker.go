@@ -0,0 +1,338 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"os/exec"
+	"strconv"
+)
+
+// Worker detector that runs in a separate process, usually a
+// Python script. A frame is written to stdin for each detection
+// and the process responds with a single JSON line on stdout.
+//
+//	{"detections": [{"class": 0, "score": 0.9, "box": [0.1, 0.2, 0.3, 0.4]}]}
+//	{"error": "message"}
+//
+// Scores range from 0 to 1. The box is top, left, bottom, right
+// relative to the frame size. The processes are started on the
+// first detection, or by Start, and are restarted after any error.
+type Worker struct {
+	name      string
+	width     int
+	height    int
+	frameSize int
+	labels    []string
+
+	bin  string
+	args []string
+	logf log.Func
+
+	startProcess startProcessFunc
+
+	// Each process handles one frame at a time, other monitors
+	// wait in turn until the context is canceled. The
+	// connection of a slot is only used by its holder.
+	pool  *pool
+	conns []*workerConn
+}
+
+// WorkerConfig worker detector config.
+type WorkerConfig struct {
+	Name   string
+	Width  int
+	Height int
+
+	// Size of each frame in bytes, defaults to a RGB24
+	// frame. Audio classifiers read PCM samples instead.
+	FrameSize int
+
+	// Labels in class order.
+	Labels []string
+
+	// Number of processes shared by the monitors, defaults to 1.
+	Instances int
+
+	Bin  string
+	Args []string
+	Logf log.Func
+}
+
+// NewWorker creates a worker detector, the process isn't started.
+func NewWorker(c WorkerConfig) *Worker {
+	frameSize := c.FrameSize
+	if frameSize == 0 {
+		frameSize = c.Width * c.Height * 3
+	}
+	instances := c.Instances
+	if instances < 1 {
+		instances = 1
+	}
+	return &Worker{
+		name:      c.Name,
+		width:     c.Width,
+		height:    c.Height,
+		frameSize: frameSize,
+		labels:    c.Labels,
+
+		bin:  c.Bin,
+		args: c.Args,
+		logf: c.Logf,
+
+		startProcess: startProcess,
+
+		pool:  newPool(instances),
+		conns: make([]*workerConn, instances),
+	}
+}
+
+// Name implements Detector.
+func (w *Worker) Name() string {
+	return w.name
+}
+
+// Size implements Detector.
+func (w *Worker) Size() (int, int) {
+	return w.width, w.height
+}
+
+// Labels implements Detector. Empty labels are
+// placeholders for unused classes and are skipped.
+func (w *Worker) Labels() []string {
+	labels := make([]string, 0, len(w.labels))
+	for _, label := range w.labels {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// Worker errors.
+var (
+	ErrFrameSize = errors.New("invalid frame size")
+	ErrWorker    = errors.New("worker")
+)
+
+// Detect implements Detector.
+func (w *Worker) Detect(ctx context.Context, frame []byte) ([]Detection, error) {
+	if len(frame) != w.frameSize {
+		return nil, fmt.Errorf("%w: %v", ErrFrameSize, len(frame))
+	}
+
+	slot, err := w.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.pool.release(slot)
+
+	conn, err := w.conn(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		res *response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := conn.request(frame)
+		done <- result{res, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			w.stop(slot)
+			return nil, r.err
+		}
+		if r.res.Error != "" {
+			return nil, fmt.Errorf("%w: %v", ErrWorker, r.res.Error)
+		}
+		return w.parseResponse(*r.res), nil
+	case <-ctx.Done():
+		// The response would be read by the next request.
+		w.stop(slot)
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+func (w *Worker) parseResponse(res response) []Detection {
+	detections := make([]Detection, 0, len(res.Detections))
+	for _, d := range res.Detections {
+		label := strconv.Itoa(d.Class)
+		if d.Class >= 0 && d.Class < len(w.labels) && w.labels[d.Class] != "" {
+			label = w.labels[d.Class]
+		}
+		detections = append(detections, Detection{
+			Label:  label,
+			Score:  d.Score * 100,
+			Top:    d.Box[0],
+			Left:   d.Box[1],
+			Bottom: d.Box[2],
+			Right:  d.Box[3],
+		})
+	}
+	return detections
+}
+
+// conn returns the connection of the slot and
+// starts the process if needed, must hold the slot.
+func (w *Worker) conn(slot int) (*workerConn, error) {
+	if w.conns[slot] == nil {
+		conn, err := w.startProcess(w.bin, w.args, w.logf)
+		if err != nil {
+			return nil, fmt.Errorf("start worker: %w", err)
+		}
+		w.conns[slot] = conn
+	}
+	return w.conns[slot], nil
+}
+
+// stop kills the process, must hold the slot.
+func (w *Worker) stop(slot int) {
+	if w.conns[slot] != nil {
+		w.conns[slot].close()
+		w.conns[slot] = nil
+	}
+}
+
+// Start starts all the processes so the models are loaded before the
+// first detection. Loading large models can otherwise take longer
+// than the detection timeout.
+func (w *Worker) Start(ctx context.Context) error {
+	slots, err := w.acquireAll(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.releaseAll(slots)
+
+	for _, slot := range slots {
+		if _, err := w.conn(slot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the processes once the current detections are done.
+func (w *Worker) Close() {
+	slots, _ := w.acquireAll(context.Background())
+	for _, slot := range slots {
+		w.stop(slot)
+	}
+	w.releaseAll(slots)
+}
+
+func (w *Worker) acquireAll(ctx context.Context) ([]int, error) {
+	slots := make([]int, 0, len(w.conns))
+	for range w.conns {
+		slot, err := w.pool.acquire(ctx)
+		if err != nil {
+			w.releaseAll(slots)
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+func (w *Worker) releaseAll(slots []int) {
+	for _, slot := range slots {
+		w.pool.release(slot)
+	}
+}
+
+type response struct {
+	Detections []struct {
+		Class int        `json:"class"`
+		Score float64    `json:"score"`
+		Box   [4]float64 `json:"box"`
+	} `json:"detections"`
+	Error string `json:"error"`
+}
+
+type workerConn struct {
+	stdin  io.Writer
+	stdout *bufio.Reader
+	close  func()
+}
+
+// request writes the frame and reads a single response line.
+func (c *workerConn) request(frame []byte) (*response, error) {
+	if _, err := c.stdin.Write(frame); err != nil {
+		return nil, fmt.Errorf("write frame: %w", err)
+	}
+	line, err := c.stdout.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	var res response
+	if err := json.Unmarshal(line, &res); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return &res, nil
+}
+
+type startProcessFunc func(bin string, args []string, logf log.Func) (*workerConn, error)
+
+func startProcess(bin string, args []string, logf log.Func) (*workerConn, error) {
+	cmd := exec.Command(bin, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout: %w", err)
+	}
+
+	processLogFunc := func(msg string) {
+		logf(log.LevelInfo, "worker: %v", msg)
+	}
+	process := ffmpeg.NewProcess(cmd).
+		StderrLogger(processLogFunc)
+
+	logf(log.LevelInfo, "starting worker: %v", cmd)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		err := process.Start(ctx)
+		if err != nil && ctx.Err() == nil {
+			logf(log.LevelError, "worker stopped: %v", err)
+		}
+		close(done)
+	}()
+
+	return &workerConn{
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		close: func() {
+			cancel()
+			<-done
+		},
+	}, nil
+}
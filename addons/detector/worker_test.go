@@ -0,0 +1,235 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package detector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWorker replies to each frame with the next response.
+func fakeWorker(responses ...string) startProcessFunc {
+	return func(string, []string, log.Func) (*workerConn, error) {
+		stdinR, stdinW := io.Pipe()
+		stdoutR, stdoutW := io.Pipe()
+		go func() {
+			frame := make([]byte, 3)
+			for _, res := range responses {
+				if _, err := io.ReadFull(stdinR, frame); err != nil {
+					return
+				}
+				if res == "" {
+					// Never respond.
+					io.Copy(io.Discard, stdinR) //nolint:errcheck
+					return
+				}
+				stdoutW.Write([]byte(res + "\n")) //nolint:errcheck
+			}
+			stdinR.Close()
+			stdoutW.Close()
+		}()
+		return &workerConn{
+			stdin:  stdinW,
+			stdout: bufio.NewReader(stdoutR),
+			close: func() {
+				stdinR.Close()
+				stdoutW.Close()
+			},
+		}, nil
+	}
+}
+
+func newTestWorker(start startProcessFunc) *Worker {
+	return &Worker{
+		width:        1,
+		height:       1,
+		frameSize:    3,
+		labels:       []string{"a", "b"},
+		startProcess: start,
+		pool:         newPool(1),
+		conns:        make([]*workerConn, 1),
+	}
+}
+
+func TestWorker(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		w := newTestWorker(fakeWorker(
+			`{"detections":[{"class":1,"score":0.5,"box":[0.1,0.2,0.3,0.4]},`+
+				`{"class":5,"score":1,"box":[0,0,1,1]}]}`,
+			`{"detections":[]}`,
+		))
+		detections, err := w.Detect(context.Background(), []byte{1, 2, 3})
+		require.NoError(t, err)
+		expected := []Detection{
+			{Label: "b", Score: 50, Top: 0.1, Left: 0.2, Bottom: 0.3, Right: 0.4},
+			{Label: "5", Score: 100, Bottom: 1, Right: 1},
+		}
+		require.Equal(t, expected, detections)
+
+		detections, err = w.Detect(context.Background(), []byte{1, 2, 3})
+		require.NoError(t, err)
+		require.Empty(t, detections)
+	})
+	t.Run("frameSize", func(t *testing.T) {
+		w := newTestWorker(fakeWorker())
+		_, err := w.Detect(context.Background(), []byte{1})
+		require.ErrorIs(t, err, ErrFrameSize)
+	})
+	t.Run("workerErr", func(t *testing.T) {
+		w := newTestWorker(fakeWorker(`{"error":"x"}`))
+		_, err := w.Detect(context.Background(), []byte{1, 2, 3})
+		require.ErrorIs(t, err, ErrWorker)
+		require.NotNil(t, w.conns[0])
+	})
+	t.Run("restart", func(t *testing.T) {
+		starts := 0
+		fake := fakeWorker(`{"detections":[]}`)
+		w := newTestWorker(func(bin string, args []string, logf log.Func) (*workerConn, error) {
+			starts++
+			return fake(bin, args, logf)
+		})
+		_, err := w.Detect(context.Background(), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		// The process exits after the first response.
+		_, err = w.Detect(context.Background(), []byte{1, 2, 3})
+		require.ErrorIs(t, err, io.ErrClosedPipe)
+		require.Nil(t, w.conns[0])
+
+		_, err = w.Detect(context.Background(), []byte{1, 2, 3})
+		require.NoError(t, err)
+		require.Equal(t, 2, starts)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		w := newTestWorker(fakeWorker(""))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := w.Detect(ctx, []byte{1, 2, 3})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, w.conns[0])
+	})
+	t.Run("busy", func(t *testing.T) {
+		w := newTestWorker(fakeWorker())
+		_, err := w.pool.acquire(context.Background())
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = w.Detect(ctx, []byte{1, 2, 3})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("instances", func(t *testing.T) {
+		starts := 0
+		fake := fakeWorker(`{"detections":[]}`, `{"detections":[]}`)
+		w := newTestWorker(func(bin string, args []string, logf log.Func) (*workerConn, error) {
+			starts++
+			return fake(bin, args, logf)
+		})
+		w.pool = newPool(2)
+		w.conns = make([]*workerConn, 2)
+
+		require.NoError(t, w.Start(context.Background()))
+		require.Equal(t, 2, starts)
+
+		for n := 0; n < 3; n++ {
+			_, err := w.Detect(context.Background(), []byte{1, 2, 3})
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, starts)
+
+		w.Close()
+		require.Nil(t, w.conns[0])
+		require.Nil(t, w.conns[1])
+	})
+}
+
+func TestPool(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		p := newPool(1)
+		slot, err := p.acquire(context.Background())
+		require.NoError(t, err)
+
+		order := make(chan int, 3)
+		for n := 0; n < 3; n++ {
+			n := n
+			go func() {
+				slot, err := p.acquire(context.Background())
+				if err != nil {
+					return
+				}
+				order <- n
+				p.release(slot)
+			}()
+			// Wait for the caller to be queued.
+			for {
+				if _, waiting := p.size(); waiting == n+1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		p.release(slot)
+		require.Equal(t, 0, <-order)
+		require.Equal(t, 1, <-order)
+		require.Equal(t, 2, <-order)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		p := newPool(1)
+		slot, err := p.acquire(context.Background())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = p.acquire(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+
+		p.release(slot)
+		free, waiting := p.size()
+		require.Equal(t, 1, free)
+		require.Equal(t, 0, waiting)
+	})
+}
+
+func TestWorkerLabels(t *testing.T) {
+	w := NewWorker(WorkerConfig{Labels: []string{"a", "", "c"}})
+	require.Equal(t, []string{"a", "c"}, w.Labels())
+
+	var res response
+	err := json.Unmarshal([]byte(`{"detections":[{"class":1},{"class":2}]}`), &res)
+	require.NoError(t, err)
+	detections := w.parseResponse(res)
+	require.Equal(t, "1", detections[0].Label)
+	require.Equal(t, "c", detections[1].Label)
+}
+
+func TestWorkerFrameSize(t *testing.T) {
+	w := NewWorker(WorkerConfig{Width: 2, Height: 3})
+	require.Equal(t, 18, w.frameSize)
+
+	w = NewWorker(WorkerConfig{FrameSize: 100})
+	require.Equal(t, 100, w.frameSize)
+	require.Len(t, w.conns, 1)
+
+	w = NewWorker(WorkerConfig{Instances: 3})
+	require.Len(t, w.conns, 3)
+}
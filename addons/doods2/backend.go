@@ -338,7 +338,7 @@ func generateFFmpegArgs(
 	args = append(args, "-y", "-threads", "1", "-loglevel", c.ffmpegLogLevel)
 
 	if c.hwaccel != "" {
-		args = append(args, ffmpeg.ParseArgs("-hwaccel "+c.hwaccel)...)
+		args = append(args, ffmpeg.ParseArgs(c.hwaccel)...)
 	}
 
 	args = append(args, "-rtsp_transport", rtspProtocol, "-i", rtspAddress)
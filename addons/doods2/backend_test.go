@@ -190,7 +190,7 @@ func TestGenerateArgs(t *testing.T) {
 		c := config{
 			grayMode:       true,
 			ffmpegLogLevel: "1",
-			hwaccel:        "2",
+			hwaccel:        "-hwaccel 2",
 			feedRate:       6,
 		}
 		outputs := outputs{
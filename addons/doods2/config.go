@@ -110,11 +110,20 @@ func parseConfig(c monitor.Config) (*config, bool, error) { //nolint:funlen
 		return nil, false, err
 	}
 
-	useSubStream := c.SubInputEnabled() && rawConf.UseSubStream == "true"
+	hw, err := c.HardwareAccel()
+	if err != nil {
+		return nil, false, fmt.Errorf("hwaccel: %w", err)
+	}
+
+	// Follow the monitor detect stream if unset.
+	useSubStream := c.DetectSubInput()
+	if rawConf.UseSubStream != "" {
+		useSubStream = c.SubInputEnabled() && rawConf.UseSubStream == "true"
+	}
 
 	return &config{
 		monitorID:       c.ID(),
-		hwaccel:         c.Hwaccel(),
+		hwaccel:         hw.DecodeArgs(),
 		ffmpegLogLevel:  c.LogLevel(),
 		timestampOffset: timestampOffset,
 		thresholds:      thresholds,
@@ -224,6 +233,30 @@ func (c *config) validate() error {
 
 func init() {
 	nvr.RegisterMigrationMonitorHook(migrate)
+	nvr.RegisterMonitorValidateHook(validateConfig)
+}
+
+// validateConfig is used by the config check. The detectors are
+// fetched from the DOODS server when the app starts, they're
+// only checked if the app is running.
+func validateConfig(c monitor.Config) []error {
+	config, enable, err := parseConfig(c)
+	if err != nil {
+		return []error{fmt.Errorf("doods: %w", err)}
+	}
+	if !enable {
+		return nil
+	}
+	if err := config.validate(); err != nil {
+		return []error{fmt.Errorf("doods: %w", err)}
+	}
+	if addon.detectorList == nil {
+		return nil
+	}
+	if _, err := detectorByName(config.detectorName); err != nil {
+		return []error{fmt.Errorf("doods: detector: %w", err)}
+	}
+	return nil
 }
 
 const currentConfigVersion = 1
@@ -54,7 +54,7 @@ func TestParseConfig(t *testing.T) {
 
 		expected := config{
 			monitorID:       "1",
-			hwaccel:         "2",
+			hwaccel:         "-hwaccel 2",
 			ffmpegLogLevel:  "3",
 			timestampOffset: 4000000,
 			thresholds:      thresholds{"5": 6},
@@ -0,0 +1,143 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package edgetpu
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"nvr"
+	"nvr/addons/detector"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"edgetpu"})
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+//go:embed worker.py
+var workerScript string
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("edgetpu: config: %w", err)
+	}
+
+	scriptPath, err := writeScript(app.Env.TempDir)
+	if err != nil {
+		return fmt.Errorf("edgetpu: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "edgetpu",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	workers := registerModels(*config, scriptPath, logf)
+	if config.Preload {
+		for _, w := range workers {
+			app.WG.Add(1)
+			go func(w *detector.Worker) {
+				defer app.WG.Done()
+				if err := w.Start(ctx); err != nil && ctx.Err() == nil {
+					logf(log.LevelError, "preload %v: %v", w.Name(), err)
+				}
+			}(w)
+		}
+	}
+
+	app.WG.Add(1)
+	go func() {
+		defer app.WG.Done()
+		<-ctx.Done()
+		for _, w := range workers {
+			w.Close()
+		}
+	}()
+	return nil
+}
+
+// registerModels registers a detector for each model. Models
+// with missing files are skipped so the others still work.
+func registerModels(c Config, scriptPath string, logf log.Func) []*detector.Worker {
+	var workers []*detector.Worker
+	for _, m := range c.Models {
+		if _, err := os.Stat(m.Path); err != nil {
+			logf(log.LevelError, "model %v: %v", m.Name, err)
+			continue
+		}
+		labels, err := readLabels(m.LabelsPath)
+		if err != nil {
+			logf(log.LevelError, "model %v: labels: %v", m.Name, err)
+			continue
+		}
+
+		w := newWorker(c, m, labels, scriptPath, logf)
+		if err := detector.Register(w); err != nil {
+			logf(log.LevelError, "model %v: %v", m.Name, err)
+			continue
+		}
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+func newWorker(
+	c Config,
+	m ModelConfig,
+	labels []string,
+	scriptPath string,
+	logf log.Func,
+) *detector.Worker {
+	return detector.NewWorker(detector.WorkerConfig{
+		Name:   "edgetpu_" + m.Name,
+		Width:  m.Width,
+		Height: m.Height,
+		Labels: labels,
+		Bin:    c.PythonBin,
+		Args: []string{
+			scriptPath,
+			"--model", m.Path,
+			"--width", strconv.Itoa(m.Width),
+			"--height", strconv.Itoa(m.Height),
+			"--device", m.Device,
+			"--min-score", strconv.FormatFloat(c.MinScore/100, 'f', -1, 64),
+		},
+		Logf: logf,
+	})
+}
+
+func writeScript(tempDir string) (string, error) {
+	dir := filepath.Join(tempDir, "edgetpu")
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("make temporary directory: %v: %w", dir, err)
+	}
+
+	path := filepath.Join(dir, "worker.py")
+	if err := os.WriteFile(path, []byte(workerScript), 0o600); err != nil {
+		return "", fmt.Errorf("write worker script: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package edgetpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterModels(t *testing.T) {
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "a.tflite")
+	labelsPath := filepath.Join(dir, "labels.txt")
+	require.NoError(t, os.WriteFile(modelPath, nil, 0o600))
+	require.NoError(t, os.WriteFile(labelsPath, []byte("x\ny\n"), 0o600))
+
+	var logs []string
+	logf := func(_ log.Level, format string, a ...interface{}) {
+		logs = append(logs, format)
+	}
+
+	c := Config{
+		PythonBin: "python3",
+		Models: []ModelConfig{
+			{
+				Name:       "registerTest",
+				Path:       modelPath,
+				Width:      320,
+				Height:     240,
+				LabelsPath: labelsPath,
+			},
+			{
+				Name:       "missingModel",
+				Path:       filepath.Join(dir, "nil.tflite"),
+				LabelsPath: labelsPath,
+			},
+			{
+				Name:       "missingLabels",
+				Path:       modelPath,
+				LabelsPath: filepath.Join(dir, "nil.txt"),
+			},
+		},
+	}
+	workers := registerModels(c, "worker.py", logf)
+	require.Len(t, workers, 1)
+	require.Len(t, logs, 2)
+
+	w := workers[0]
+	require.Equal(t, "edgetpu_registerTest", w.Name())
+	width, height := w.Size()
+	require.Equal(t, [2]int{320, 240}, [2]int{width, height})
+	require.Equal(t, []string{"x", "y"}, w.Labels())
+}
@@ -0,0 +1,167 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package edgetpu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Config global addon config.
+type Config struct {
+	// Python interpreter with tflite_runtime and numpy installed.
+	PythonBin string `json:"pythonBin"`
+
+	// Detections below this score are dropped by the worker, 0-100.
+	MinScore float64 `json:"minScore"`
+
+	// Start the workers when the app starts instead of on the first
+	// detection, the first frames may time out while the model loads.
+	Preload bool `json:"preload"`
+
+	Models []ModelConfig `json:"models"`
+}
+
+// ModelConfig EdgeTPU compiled TFLite model.
+type ModelConfig struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Input size.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Text file with one label per line, optionally prefixed by the class id.
+	LabelsPath string `json:"labelsPath"`
+
+	// EdgeTPU device, "usb", "pci", or with a index like "usb:1".
+	// Empty for the first available device.
+	Device string `json:"device"`
+}
+
+// Config errors.
+var (
+	ErrNoModelName   = errors.New("model name is empty")
+	ErrInvalidSize   = errors.New("invalid model size")
+	ErrInvalidDevice = errors.New("invalid device")
+	ErrNoLabels      = errors.New("no labels")
+)
+
+var deviceRegex = regexp.MustCompile(`^(usb|pci)(:[0-9]+)?$`)
+
+func (c Config) validate() error {
+	for _, m := range c.Models {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("model %q: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m ModelConfig) validate() error {
+	if m.Name == "" {
+		return ErrNoModelName
+	}
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("%w: %vx%v", ErrInvalidSize, m.Width, m.Height)
+	}
+	if m.Device != "" && !deviceRegex.MatchString(m.Device) {
+		return fmt.Errorf("%w: %q", ErrInvalidDevice, m.Device)
+	}
+	return nil
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "edgetpu.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		if err := genConfig(configPath, configDir); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func defaultConfig(configDir string) Config {
+	modelDir := filepath.Join(configDir, "edgetpu")
+	return Config{
+		PythonBin: "python3",
+		MinScore:  10,
+		Preload:   true,
+		Models: []ModelConfig{{
+			Name:       "ssd_mobilenet_v2",
+			Path:       filepath.Join(modelDir, "ssd_mobilenet_v2_coco_quant_postprocess_edgetpu.tflite"),
+			Width:      300,
+			Height:     300,
+			LabelsPath: filepath.Join(modelDir, "coco_labels.txt"),
+		}},
+	}
+}
+
+func genConfig(configPath string, configDir string) error {
+	data, _ := json.MarshalIndent(defaultConfig(configDir), "", "    ")
+	return os.WriteFile(configPath, data, 0o600)
+}
+
+// readLabels reads plain label files and the Coral format where
+// each line is prefixed by the class id. Missing ids are empty.
+func readLabels(path string) ([]string, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	for _, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		rawID, label, found := strings.Cut(line, " ")
+		id, err := strconv.Atoi(rawID)
+		if !found || err != nil || id < 0 {
+			labels = append(labels, line)
+			continue
+		}
+		for len(labels) <= id {
+			labels = append(labels, "")
+		}
+		labels[id] = strings.TrimSpace(label)
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoLabels, path)
+	}
+	return labels, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package edgetpu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		configDir := t.TempDir()
+		config, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig(configDir), *config)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		configDir := t.TempDir()
+		raw := `{"models":[{"name":"a","width":1,"height":1,"device":"x"}]}`
+		err := os.WriteFile(filepath.Join(configDir, "edgetpu.json"), []byte(raw), 0o600)
+		require.NoError(t, err)
+
+		_, err = readConfig(configDir)
+		require.ErrorIs(t, err, ErrInvalidDevice)
+	})
+}
+
+func TestValidateModel(t *testing.T) {
+	cases := map[string]struct {
+		model       ModelConfig
+		expectedErr error
+	}{
+		"ok":       {ModelConfig{Name: "a", Width: 1, Height: 1}, nil},
+		"usb":      {ModelConfig{Name: "a", Width: 1, Height: 1, Device: "usb"}, nil},
+		"pci":      {ModelConfig{Name: "a", Width: 1, Height: 1, Device: "pci:1"}, nil},
+		"device":   {ModelConfig{Name: "a", Width: 1, Height: 1, Device: "usb:x"}, ErrInvalidDevice},
+		"name":     {ModelConfig{Width: 1, Height: 1}, ErrNoModelName},
+		"size":     {ModelConfig{Name: "a", Width: 1}, ErrInvalidSize},
+		"negative": {ModelConfig{Name: "a", Width: -1, Height: 1}, ErrInvalidSize},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, tc.model.validate(), tc.expectedErr)
+		})
+	}
+}
+
+func TestReadLabels(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected []string
+	}{
+		"plain": {"person\nbicycle\n", []string{"person", "bicycle"}},
+		"ids":   {"0  person\n2  car\n", []string{"person", "", "car"}},
+		"space": {"traffic light\n", []string{"traffic light"}},
+		"idsWithSpace": {
+			"0  person\n1  traffic light\n",
+			[]string{"person", "traffic light"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "labels.txt")
+			require.NoError(t, os.WriteFile(path, []byte(tc.input), 0o600))
+			labels, err := readLabels(path)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, labels)
+		})
+	}
+	t.Run("empty", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "labels.txt")
+		require.NoError(t, os.WriteFile(path, []byte("\n"), 0o600))
+		_, err := readLabels(path)
+		require.ErrorIs(t, err, ErrNoLabels)
+	})
+}
@@ -0,0 +1,107 @@
+#!/usr/bin/env python3
+#
+# Copyright 2020-2022 The OS-NVR Authors.
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation; either version 2 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+# EdgeTPU worker started by the edgetpu addon, one per model.
+#
+# Reads RGB24 frames of width*height*3 bytes from stdin and writes
+# one JSON line for each frame to stdout. Box coordinates are
+# [top, left, bottom, right] relative to the frame size.
+#
+#   {"detections": [{"class": 0, "score": 0.9, "box": [0.1, 0.2, 0.3, 0.4]}]}
+#   {"error": "message"}
+
+import argparse
+import json
+import sys
+
+import numpy as np
+from tflite_runtime.interpreter import Interpreter, load_delegate
+
+
+def parse_args():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--model", required=True)
+    parser.add_argument("--width", required=True, type=int)
+    parser.add_argument("--height", required=True, type=int)
+    parser.add_argument("--device", default="")
+    parser.add_argument("--min-score", default=0.1, type=float)
+    return parser.parse_args()
+
+
+def new_interpreter(args):
+    options = {"device": args.device} if args.device else {}
+    delegate = load_delegate("libedgetpu.so.1", options)
+    interpreter = Interpreter(model_path=args.model, experimental_delegates=[delegate])
+    interpreter.allocate_tensors()
+    return interpreter
+
+
+def output_tensors(interpreter):
+    outputs = [interpreter.get_tensor(o["index"]) for o in interpreter.get_output_details()]
+    # SSD models output boxes, classes, scores and count. EfficientDet
+    # models output scores, boxes, count and classes.
+    if outputs[3].size == 1:
+        boxes, classes, scores, count = outputs
+    else:
+        scores, boxes, count, classes = outputs
+    n = int(count.flatten()[0])
+    return boxes[0][:n], classes[0][:n], scores[0][:n]
+
+
+def detect(interpreter, args, frame):
+    inp = interpreter.get_input_details()[0]
+    tensor = frame[None]
+    if inp["dtype"] == np.int8:
+        tensor = (tensor.astype(np.int16) - 128).astype(np.int8)
+    interpreter.set_tensor(inp["index"], tensor)
+    interpreter.invoke()
+
+    detections = []
+    for box, cls, score in zip(*output_tensors(interpreter)):
+        if score < args.min_score:
+            continue
+        top, left, bottom, right = (float(v) for v in box)
+        detections.append({
+            "class": int(cls),
+            "score": float(score),
+            "box": [top, left, bottom, right],
+        })
+    return detections
+
+
+def main():
+    args = parse_args()
+    interpreter = new_interpreter(args)
+    print("loaded model: " + args.model, file=sys.stderr, flush=True)
+
+    frame_size = args.width * args.height * 3
+    stdin = sys.stdin.buffer
+    while True:
+        data = stdin.read(frame_size)
+        if len(data) < frame_size:
+            return
+        frame = np.frombuffer(data, dtype=np.uint8).reshape(args.height, args.width, 3)
+        try:
+            response = {"detections": detect(interpreter, args, frame)}
+        except Exception as e:  # pylint: disable=broad-except
+            response = {"error": str(e)}
+        sys.stdout.write(json.dumps(response) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()
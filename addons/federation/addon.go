@@ -0,0 +1,72 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/web"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"federation"})
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+func onAppRun(_ context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("federation: config: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "federation",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	nodes := make([]*node, 0, len(config.Nodes))
+	nodesByID := make(map[string]*node, len(config.Nodes))
+	for _, c := range config.Nodes {
+		n, err := newNode(c, app.Env.BasePath, logf)
+		if err != nil {
+			return fmt.Errorf("federation: node %q: %w", c.ID, err)
+		}
+		nodes = append(nodes, n)
+		nodesByID[c.ID] = n
+	}
+
+	app.API.Handle("/api/federation/nodes", app.Auth.User(serveNodes(nodes, app.Auth)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/federation/nodes",
+			Summary: "Remote nodes and their monitors.",
+		},
+	)
+	app.API.Handle(ProxyPrefix, app.Auth.User(serveProxy(nodesByID, app.Auth)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/federation/node/{id}/{path}",
+			Summary:  "Proxy a request to the remote node.",
+			Response: "*/*",
+		},
+	)
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config global addon config.
+type Config struct {
+	Nodes []NodeConfig `json:"nodes"`
+}
+
+// NodeConfig remote OS-NVR instance.
+type NodeConfig struct {
+	// Used in the proxy path, "/api/federation/node/<id>/".
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Base url of the remote instance including
+	// the base path, for example "https://cabin:2020/nvr".
+	URL string `json:"url"`
+
+	// API token created on the remote instance, the
+	// scopes of the token limit what can be proxied.
+	Token string `json:"token"`
+}
+
+// Config errors.
+var (
+	ErrInvalidNodeID = errors.New("invalid node id")
+	ErrInvalidURL    = errors.New("invalid url")
+	ErrMissingToken  = errors.New("missing token")
+)
+
+func (c *Config) setDefaults() {
+	if c.Nodes == nil {
+		c.Nodes = []NodeConfig{}
+	}
+	for i, n := range c.Nodes {
+		if n.Name == "" {
+			n.Name = n.ID
+		}
+		n.URL = strings.TrimSuffix(n.URL, "/")
+		c.Nodes[i] = n
+	}
+}
+
+func (c Config) validate() error {
+	ids := make(map[string]struct{}, len(c.Nodes))
+	for _, n := range c.Nodes {
+		if !validID(n.ID) {
+			return fmt.Errorf("%w: %q", ErrInvalidNodeID, n.ID)
+		}
+		if _, exist := ids[n.ID]; exist {
+			return fmt.Errorf("%w: duplicate: %q", ErrInvalidNodeID, n.ID)
+		}
+		ids[n.ID] = struct{}{}
+
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+			u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("node %q: %w: %q", n.ID, ErrInvalidURL, n.URL)
+		}
+		if n.Token == "" {
+			return fmt.Errorf("node %q: %w", n.ID, ErrMissingToken)
+		}
+	}
+	return nil
+}
+
+// validID returns true if the id only contains [a-zA-Z0-9_-].
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r == '_', r == '-', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "federation.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		defaultConfig := Config{}
+		defaultConfig.setDefaults()
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
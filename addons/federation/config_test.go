@@ -0,0 +1,79 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package federation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("setDefaults", func(t *testing.T) {
+		c := Config{Nodes: []NodeConfig{{ID: "cabin", URL: "https://cabin/nvr/"}}}
+		c.setDefaults()
+		require.Equal(t, []NodeConfig{{
+			ID:   "cabin",
+			Name: "cabin",
+			URL:  "https://cabin/nvr",
+		}}, c.Nodes)
+	})
+	t.Run("validate", func(t *testing.T) {
+		cases := map[string]struct {
+			nodes    []NodeConfig
+			expected error
+		}{
+			"ok": {
+				[]NodeConfig{
+					{ID: "a", URL: "https://a:2020", Token: "x"},
+					{ID: "b-2", URL: "http://b/nvr", Token: "x"},
+				},
+				nil,
+			},
+			"missingID": {[]NodeConfig{{URL: "https://a", Token: "x"}}, ErrInvalidNodeID},
+			"invalidID": {[]NodeConfig{{ID: "a/b", URL: "https://a", Token: "x"}}, ErrInvalidNodeID},
+			"duplicateID": {
+				[]NodeConfig{
+					{ID: "a", URL: "https://a", Token: "x"},
+					{ID: "a", URL: "https://b", Token: "x"},
+				},
+				ErrInvalidNodeID,
+			},
+			"scheme":  {[]NodeConfig{{ID: "a", URL: "ftp://a", Token: "x"}}, ErrInvalidURL},
+			"host":    {[]NodeConfig{{ID: "a", URL: "https://", Token: "x"}}, ErrInvalidURL},
+			"query":   {[]NodeConfig{{ID: "a", URL: "https://a?x=y", Token: "x"}}, ErrInvalidURL},
+			"noToken": {[]NodeConfig{{ID: "a", URL: "https://a"}}, ErrMissingToken},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				c := Config{Nodes: tc.nodes}
+				c.setDefaults()
+				require.ErrorIs(t, c.validate(), tc.expected)
+			})
+		}
+	})
+	t.Run("generate", func(t *testing.T) {
+		configDir := t.TempDir()
+		c, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, Config{Nodes: []NodeConfig{}}, *c)
+
+		info, err := os.Stat(filepath.Join(configDir, "federation.json"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+}
@@ -0,0 +1,252 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyPrefix path prefix of the proxied requests,
+// "/api/federation/node/<id>/<remote path>".
+const ProxyPrefix = "/api/federation/node/"
+
+// Time limit of the monitor list request to each node.
+const statusTimeout = 5 * time.Second
+
+// Node errors.
+var (
+	ErrUnexpectedStatus = errors.New("unexpected status")
+	ErrInvalidResponse  = errors.New("invalid response")
+)
+
+type node struct {
+	config NodeConfig
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+	client *http.Client
+}
+
+// newNode basePath is the base path of the local instance.
+func newNode(c NodeConfig, basePath string, logf log.Func) (*node, error) {
+	target, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	n := &node{
+		config: c,
+		target: target,
+		client: &http.Client{Timeout: statusTimeout},
+	}
+	localPrefix := basePath + ProxyPrefix + c.ID
+	n.proxy = &httputil.ReverseProxy{
+		Director: n.direct,
+		ModifyResponse: func(res *http.Response) error {
+			modifyResponse(res, target.Path, localPrefix)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() == nil {
+				logf(log.LevelError, "node %v: proxy: %v", c.ID, err)
+			}
+			http.Error(w, "remote node is unavailable", http.StatusBadGateway)
+		},
+		// Live streams and the event stream must not be buffered.
+		FlushInterval: -1,
+	}
+	return n, nil
+}
+
+// direct rewrites the request path, which is relative to the
+// base path of the remote node, and replaces the credentials
+// of the local session with the API token of the node.
+func (n *node) direct(r *http.Request) {
+	r.URL.Scheme = n.target.Scheme
+	r.URL.Host = n.target.Host
+	r.URL.Path = n.target.Path + r.URL.Path
+	r.URL.RawPath = ""
+	r.Host = n.target.Host
+
+	r.Header.Del("Cookie")
+	r.Header.Del("X-CSRF-TOKEN")
+	r.Header.Set("Authorization", "Bearer "+n.config.Token)
+}
+
+// modifyResponse rewrites absolute redirects and Location headers,
+// "<remote base path>/whep/x" becomes "<local prefix>/whep/x".
+func modifyResponse(res *http.Response, remoteBasePath string, localPrefix string) {
+	res.Header.Del("Set-Cookie")
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, remoteBasePath+"/") {
+		return
+	}
+	res.Header.Set("Location", localPrefix+strings.TrimPrefix(location, remoteBasePath))
+}
+
+// nodeStatus node and the monitor list of the node.
+type nodeStatus struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Online bool   `json:"online"`
+	Error  string `json:"error,omitempty"`
+
+	// Response of "/api/monitor/list" on the node.
+	Monitors json.RawMessage `json:"monitors,omitempty"`
+}
+
+func (n *node) status(ctx context.Context) nodeStatus {
+	s := nodeStatus{ID: n.config.ID, Name: n.config.Name}
+	monitors, err := n.monitors(ctx)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.Online = true
+	s.Monitors = monitors
+	return s
+}
+
+func (n *node) monitors(ctx context.Context) (json.RawMessage, error) {
+	u := n.target.String() + "/api/monitor/list"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.config.Token)
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %v", ErrUnexpectedStatus, res.Status)
+	}
+
+	// 10 MB should be enough for everyone.
+	body, err := io.ReadAll(io.LimitReader(res.Body, 10000000))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(body) {
+		return nil, ErrInvalidResponse
+	}
+	return body, nil
+}
+
+// userScope returns true if accounts that aren't admins
+// can proxy requests that require the scope. Everything
+// that changes the remote node requires a admin.
+func userScope(scope auth.Scope) bool {
+	switch scope {
+	case "",
+		auth.ScopeLiveView,
+		auth.ScopeRecordingsRead,
+		auth.ScopeEventsRead,
+		auth.ScopeMonitorsRead:
+		return true
+	}
+	return false
+}
+
+// checkAccount writes the error response and returns false
+// if the account isn't allowed to access remote nodes. Accounts
+// limited to specific monitors only have access to local monitors.
+func checkAccount(w http.ResponseWriter, r *http.Request, a auth.Authenticator) bool {
+	if a.AuthDisabled() {
+		return true
+	}
+	account := a.ValidateRequest(r).User
+	if account.Limited() {
+		http.Error(w, "remote nodes are not allowed for accounts"+
+			" limited to specific monitors", http.StatusForbidden)
+		return false
+	}
+	if !account.IsAdmin && !userScope(auth.RequiredScope(r)) {
+		http.Error(w, "remote request requires admin", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func serveProxy(nodes map[string]*node, a auth.Authenticator) http.Handler {
+	csrf := a.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyRequest(w, r, nodes)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkAccount(w, r, a) {
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			proxyRequest(w, r, nodes)
+			return
+		}
+		csrf.ServeHTTP(w, r)
+	})
+}
+
+func proxyRequest(w http.ResponseWriter, r *http.Request, nodes map[string]*node) {
+	id, remotePath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, ProxyPrefix), "/")
+	n, exist := nodes[id]
+	if !exist {
+		http.Error(w, "node does not exist", http.StatusNotFound)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/" + remotePath
+	r2.URL.RawPath = ""
+	n.proxy.ServeHTTP(w, r2)
+}
+
+// serveNodes responds with the status and monitors of every node.
+func serveNodes(nodes []*node, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkAccount(w, r, a) {
+			return
+		}
+
+		statuses := make([]nodeStatus, len(nodes))
+		var wg sync.WaitGroup
+		for i, n := range nodes {
+			wg.Add(1)
+			go func(i int, n *node) {
+				statuses[i] = n.status(r.Context())
+				wg.Done()
+			}(i, n)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
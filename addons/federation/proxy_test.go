@@ -0,0 +1,241 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package federation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/web/auth"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubAuthenticator struct {
+	auth.Authenticator
+	account  auth.Account
+	disabled bool
+}
+
+func (a stubAuthenticator) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{IsValid: true, User: a.account}
+}
+
+func (a stubAuthenticator) AuthDisabled() bool {
+	return a.disabled
+}
+
+func (a stubAuthenticator) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-CSRF-TOKEN") != "csrf" {
+			http.Error(w, "invalid csrf token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newTestRemote returns a remote node that echoes the method,
+// path and Authorization header, and a monitor list.
+func newTestRemote(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nvr/api/monitor/list":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"m1":{"id":"m1","name":"Door"}}`)) //nolint:errcheck
+		case "/nvr/whep/m1":
+			w.Header().Set("Location", "/nvr/whep/m1/session1")
+			w.Header().Set("Set-Cookie", "session=x")
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Write([]byte(r.Method + " " + r.URL.String() + " " + //nolint:errcheck
+				r.Header.Get("Authorization") + r.Header.Get("Cookie") + r.Header.Get("X-CSRF-TOKEN")))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestNodes(t *testing.T) map[string]*node {
+	t.Helper()
+	remote := newTestRemote(t)
+	c := Config{Nodes: []NodeConfig{
+		{ID: "cabin", URL: remote.URL + "/nvr/", Token: "secret"},
+		{ID: "offline", URL: "http://127.0.0.1:1/", Token: "x"},
+	}}
+	c.setDefaults()
+	require.NoError(t, c.validate())
+
+	nodes := make(map[string]*node)
+	for _, nc := range c.Nodes {
+		n, err := newNode(nc, "/local", func(log.Level, string, ...interface{}) {})
+		require.NoError(t, err)
+		nodes[nc.ID] = n
+	}
+	return nodes
+}
+
+func TestProxy(t *testing.T) {
+	admin := auth.Account{IsAdmin: true}
+	cases := map[string]struct {
+		account    auth.Account
+		method     string
+		path       string
+		csrf       string
+		expected   string
+		statusCode int
+	}{
+		"ok": {
+			admin,
+			http.MethodGet,
+			"/api/federation/node/cabin/api/recording/query?limit=1",
+			"",
+			"GET /nvr/api/recording/query?limit=1 Bearer secret",
+			http.StatusOK,
+		},
+		"user": {
+			auth.Account{},
+			http.MethodGet,
+			"/api/federation/node/cabin/hls/m1/index.m3u8",
+			"",
+			"GET /nvr/hls/m1/index.m3u8 Bearer secret",
+			http.StatusOK,
+		},
+		"userAdminPath": {
+			auth.Account{},
+			http.MethodGet,
+			"/api/federation/node/cabin/api/users",
+			"",
+			"remote request requires admin\n",
+			http.StatusForbidden,
+		},
+		"userWrite": {
+			auth.Account{},
+			http.MethodDelete,
+			"/api/federation/node/cabin/api/recording/delete/x",
+			"csrf",
+			"remote request requires admin\n",
+			http.StatusForbidden,
+		},
+		"limited": {
+			auth.Account{Monitors: []string{"m1"}},
+			http.MethodGet,
+			"/api/federation/node/cabin/hls/m1/index.m3u8",
+			"",
+			"remote nodes are not allowed for accounts limited to specific monitors\n",
+			http.StatusForbidden,
+		},
+		"write": {
+			admin,
+			http.MethodDelete,
+			"/api/federation/node/cabin/api/recording/delete/x",
+			"csrf",
+			"DELETE /nvr/api/recording/delete/x Bearer secret",
+			http.StatusOK,
+		},
+		"writeNoCSRF": {
+			admin,
+			http.MethodDelete,
+			"/api/federation/node/cabin/api/recording/delete/x",
+			"",
+			"invalid csrf token\n",
+			http.StatusUnauthorized,
+		},
+		"unknownNode": {
+			admin,
+			http.MethodGet,
+			"/api/federation/node/x/api/monitor/list",
+			"",
+			"node does not exist\n",
+			http.StatusNotFound,
+		},
+		"offline": {
+			admin,
+			http.MethodGet,
+			"/api/federation/node/offline/api/monitor/list",
+			"",
+			"remote node is unavailable\n",
+			http.StatusBadGateway,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := stubAuthenticator{account: tc.account}
+			handler := serveProxy(newTestNodes(t), a)
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Header.Set("Cookie", "session=local")
+			if tc.csrf != "" {
+				r.Header.Set("X-CSRF-TOKEN", tc.csrf)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			body, err := io.ReadAll(w.Result().Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.statusCode, w.Code)
+			require.Equal(t, tc.expected, string(body))
+		})
+	}
+	t.Run("location", func(t *testing.T) {
+		handler := serveProxy(newTestNodes(t), stubAuthenticator{disabled: true})
+
+		r := httptest.NewRequest(http.MethodPost, "/api/federation/node/cabin/whep/m1", nil)
+		r.Header.Set("X-CSRF-TOKEN", "csrf")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Equal(t, "/local/api/federation/node/cabin/whep/m1/session1", w.Header().Get("Location"))
+		require.Empty(t, w.Header().Get("Set-Cookie"))
+	})
+}
+
+func TestServeNodes(t *testing.T) {
+	nodes := newTestNodes(t)
+	handler := serveNodes([]*node{nodes["cabin"], nodes["offline"]}, stubAuthenticator{})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/federation/nodes", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var statuses []nodeStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	require.Len(t, statuses, 2)
+
+	require.Equal(t, "cabin", statuses[0].ID)
+	require.True(t, statuses[0].Online)
+	require.JSONEq(t, `{"m1":{"id":"m1","name":"Door"}}`, string(statuses[0].Monitors))
+
+	require.Equal(t, "offline", statuses[1].ID)
+	require.False(t, statuses[1].Online)
+	require.NotEmpty(t, statuses[1].Error)
+	require.Nil(t, statuses[1].Monitors)
+
+	t.Run("limited", func(t *testing.T) {
+		a := stubAuthenticator{account: auth.Account{Groups: []string{"g1"}}}
+		w := httptest.NewRecorder()
+		serveNodes(nil, a).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/federation/nodes", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"fmt"
+	"nvr"
+	"nvr/pkg/log"
+	"os"
+	"time"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"logship"})
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("logship: config: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "logship",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+	if !config.enabled() {
+		logf(log.LevelInfo, "no destination configured")
+		return nil
+	}
+
+	shippers, err := newShippers(*config, logf)
+	if err != nil {
+		return fmt.Errorf("logship: %w", err)
+	}
+	level, _ := log.ParseLevel(config.Level)
+
+	for _, s := range shippers {
+		s := s
+		app.WG.Add(1)
+		go func() {
+			s.run(ctx)
+			app.WG.Done()
+		}()
+	}
+
+	feed, cancel := app.Logger.Subscribe()
+	app.WG.Add(1)
+	go func() {
+		defer app.WG.Done()
+		defer cancel()
+		for {
+			select {
+			case e := <-feed:
+				if e.Level > level {
+					continue
+				}
+				for _, s := range shippers {
+					s.push(e)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Syslog messages are sent individually, the batch only limits the number of writes.
+const syslogBatchWait = 100 * time.Millisecond
+
+func newShippers(c Config, logf log.Func) ([]*shipper, error) {
+	var shippers []*shipper
+	if c.Syslog.Address != "" {
+		hostname, _ := os.Hostname()
+		s, err := newSyslogSink(c.Syslog, hostname)
+		if err != nil {
+			return nil, err
+		}
+		shippers = append(shippers, newShipper(s, defaultBatchSize, syslogBatchWait, logf))
+	}
+	if c.Loki.URL != "" {
+		s := newLokiSink(c.Loki)
+		batchWait := time.Duration(c.Loki.BatchWait * float64(time.Second))
+		shippers = append(shippers, newShipper(s, c.Loki.BatchSize, batchWait, logf))
+	}
+	return shippers, nil
+}
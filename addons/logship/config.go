@@ -0,0 +1,184 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config global addon config.
+type Config struct {
+	// Entries more verbose than the level aren't shipped.
+	Level string `json:"level"`
+
+	Syslog SyslogConfig `json:"syslog"`
+	Loki   LokiConfig   `json:"loki"`
+}
+
+// SyslogConfig RFC 5424 syslog server.
+type SyslogConfig struct {
+	// Server address, for example "udp://192.168.1.2:514",
+	// "tcp://syslog:514" or "tls://syslog:6514". Disabled if empty.
+	Address string `json:"address"`
+
+	// APP-NAME of the messages.
+	Tag string `json:"tag"`
+
+	// Facility name, "daemon" or "local0" to "local7".
+	Facility string `json:"facility"`
+}
+
+// LokiConfig Grafana Loki push API.
+type LokiConfig struct {
+	// Loki url, for example "http://loki:3100". Disabled if empty.
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Sent as the X-Scope-OrgID header if set.
+	TenantID string `json:"tenantId"`
+
+	// Added to all streams, for example {"host": "nvr1"}.
+	Labels map[string]string `json:"labels"`
+
+	// Entries are pushed when BatchSize entries are
+	// buffered or BatchWait seconds have passed.
+	BatchSize int     `json:"batchSize"`
+	BatchWait float64 `json:"batchWait"`
+}
+
+// Default config values.
+const (
+	defaultLevel     = "info"
+	defaultTag       = "os-nvr"
+	defaultFacility  = "daemon"
+	defaultBatchSize = 100
+	defaultBatchWait = 1
+)
+
+// Config errors.
+var (
+	ErrInvalidAddress  = errors.New("invalid syslog address")
+	ErrInvalidFacility = errors.New("invalid syslog facility")
+	ErrInvalidURL      = errors.New("invalid loki url")
+	ErrInvalidLabel    = errors.New("invalid loki label")
+	ErrInvalidBatch    = errors.New("invalid batch size or wait")
+)
+
+func (c *Config) setDefaults() {
+	if c.Level == "" {
+		c.Level = defaultLevel
+	}
+	if c.Syslog.Tag == "" {
+		c.Syslog.Tag = defaultTag
+	}
+	if c.Syslog.Facility == "" {
+		c.Syslog.Facility = defaultFacility
+	}
+	if c.Loki.Labels == nil {
+		c.Loki.Labels = map[string]string{}
+	}
+	if c.Loki.BatchSize == 0 {
+		c.Loki.BatchSize = defaultBatchSize
+	}
+	if c.Loki.BatchWait == 0 {
+		c.Loki.BatchWait = defaultBatchWait
+	}
+}
+
+func (c Config) validate() error {
+	if _, err := log.ParseLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Syslog.Address != "" {
+		if _, _, err := syslogAddress(c.Syslog.Address); err != nil {
+			return err
+		}
+	}
+	if _, err := facilityCode(c.Syslog.Facility); err != nil {
+		return err
+	}
+	if c.Loki.URL != "" {
+		u, err := url.Parse(c.Loki.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidURL, c.Loki.URL)
+		}
+	}
+	for name := range c.Loki.Labels {
+		if !validLabelName(name) || reservedLabel(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidLabel, name)
+		}
+	}
+	if c.Loki.BatchSize < 1 || c.Loki.BatchWait <= 0 {
+		return ErrInvalidBatch
+	}
+	return nil
+}
+
+// enabled returns false if no destination is configured.
+func (c Config) enabled() bool {
+	return c.Syslog.Address != "" || c.Loki.URL != ""
+}
+
+// validLabelName returns true if the name matches [a-zA-Z_][a-zA-Z0-9_]*
+// and doesn't start with "__", those names are reserved by Loki.
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "logship.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		defaultConfig := Config{}
+		defaultConfig.setDefaults()
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{
+		Syslog: SyslogConfig{Address: "udp://localhost"},
+		Loki:   LokiConfig{URL: "http://loki:3100"},
+	}
+	valid.setDefaults()
+	cases := map[string]struct {
+		modify func(*Config)
+		err    error
+	}{
+		"ok":           {func(*Config) {}, nil},
+		"disabled":     {func(c *Config) { c.Syslog.Address = ""; c.Loki.URL = "" }, nil},
+		"tls":          {func(c *Config) { c.Syslog.Address = "tls://syslog:6514" }, nil},
+		"level":        {func(c *Config) { c.Level = "x" }, log.ErrInvalidLevel},
+		"scheme":       {func(c *Config) { c.Syslog.Address = "http://localhost" }, ErrInvalidAddress},
+		"noHost":       {func(c *Config) { c.Syslog.Address = "udp://" }, ErrInvalidAddress},
+		"facility":     {func(c *Config) { c.Syslog.Facility = "x" }, ErrInvalidFacility},
+		"lokiScheme":   {func(c *Config) { c.Loki.URL = "ftp://loki" }, ErrInvalidURL},
+		"label":        {func(c *Config) { c.Loki.Labels = map[string]string{"1a": ""} }, ErrInvalidLabel},
+		"labelPrefix":  {func(c *Config) { c.Loki.Labels = map[string]string{"__a": ""} }, ErrInvalidLabel},
+		"labelReserve": {func(c *Config) { c.Loki.Labels = map[string]string{"monitor": ""} }, ErrInvalidLabel},
+		"batchSize":    {func(c *Config) { c.Loki.BatchSize = -1 }, ErrInvalidBatch},
+		"batchWait":    {func(c *Config) { c.Loki.BatchWait = -1 }, ErrInvalidBatch},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			tc.modify(&c)
+			require.ErrorIs(t, c.validate(), tc.err)
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		Level: "info",
+		Syslog: SyslogConfig{
+			Tag:      "os-nvr",
+			Facility: "daemon",
+		},
+		Loki: LokiConfig{
+			Labels:    map[string]string{},
+			BatchSize: 100,
+			BatchWait: 1,
+		},
+	}, *config)
+	require.False(t, config.enabled())
+	require.FileExists(t, filepath.Join(dir, "logship.json"))
+
+	err = os.WriteFile(filepath.Join(dir, "logship.json"), []byte(`{"loki": {"url": "http://x"}}`), 0o600)
+	require.NoError(t, err)
+	config, err = readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "http://x", config.Loki.URL)
+	require.Equal(t, 100, config.Loki.BatchSize)
+	require.True(t, config.enabled())
+}
@@ -0,0 +1,157 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr/pkg/log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels set by the shipper for each entry.
+const (
+	labelSrc     = "src"
+	labelLevel   = "level"
+	labelMonitor = "monitor"
+)
+
+func reservedLabel(name string) bool {
+	return name == labelSrc || name == labelLevel || name == labelMonitor
+}
+
+const lokiPushTimeout = 10 * time.Second
+
+// ErrPushFailed push request was rejected.
+var ErrPushFailed = errors.New("loki push failed")
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiSink pushes the entries to Loki, grouped into streams
+// by source, level and monitor plus the configured labels.
+type lokiSink struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+	tenantID string
+	labels   map[string]string
+}
+
+func newLokiSink(c LokiConfig) *lokiSink {
+	return &lokiSink{
+		client:   &http.Client{Timeout: lokiPushTimeout},
+		url:      strings.TrimSuffix(c.URL, "/") + "/loki/api/v1/push",
+		username: c.Username,
+		password: c.Password,
+		tenantID: c.TenantID,
+		labels:   c.Labels,
+	}
+}
+
+func (s *lokiSink) name() string {
+	return "loki"
+}
+
+func (s *lokiSink) streamLabels(e log.Entry) map[string]string {
+	labels := make(map[string]string, len(s.labels)+3)
+	for k, v := range s.labels {
+		labels[k] = v
+	}
+	labels[labelSrc] = e.Src
+	labels[labelLevel] = e.Level.String()
+	if e.MonitorID != "" {
+		labels[labelMonitor] = e.MonitorID
+	}
+	return labels
+}
+
+// encode groups the entries into streams in order of first appearance.
+func (s *lokiSink) encode(entries []log.Entry) lokiPush {
+	// Loki requires the entries of a stream to be in order.
+	sorted := make([]log.Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	var push lokiPush
+	index := make(map[string]int)
+	for _, e := range sorted {
+		key := e.Src + "\x00" + e.Level.String() + "\x00" + e.MonitorID
+		i, exist := index[key]
+		if !exist {
+			i = len(push.Streams)
+			index[key] = i
+			push.Streams = append(push.Streams, lokiStream{
+				Stream: s.streamLabels(e),
+			})
+		}
+		ts := strconv.FormatInt(e.GetTime().UnixNano(), 10)
+		push.Streams[i].Values = append(push.Streams[i].Values, [2]string{ts, e.Msg})
+	}
+	return push
+}
+
+func (s *lokiSink) send(ctx context.Context, entries []log.Entry) error {
+	body, err := json.Marshal(s.encode(entries))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	if s.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.tenantID)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return fmt.Errorf("%w: %v: %s", ErrPushFailed, res.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	return nil
+}
+
+func (s *lokiSink) close() {
+	s.client.CloseIdleConnections()
+}
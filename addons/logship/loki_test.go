@@ -0,0 +1,90 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLokiSink(t *testing.T) {
+	var (
+		gotPush   lokiPush
+		gotHeader http.Header
+		gotPath   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		gotPath = r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotPush))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := newLokiSink(LokiConfig{
+		URL:      server.URL + "/",
+		Username: "user",
+		Password: "pass",
+		TenantID: "tenant",
+		Labels:   map[string]string{"host": "nvr1"},
+	})
+	defer s.close()
+
+	err := s.send(context.Background(), []log.Entry{
+		{Level: log.LevelInfo, Src: "app", Msg: "b", Time: 2000},
+		{Level: log.LevelError, Src: "recorder", MonitorID: "m1", Msg: "c", Time: 3000},
+		{Level: log.LevelInfo, Src: "app", Msg: "a", Time: 1000},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "/loki/api/v1/push", gotPath)
+	require.Equal(t, "application/json", gotHeader.Get("Content-Type"))
+	require.Equal(t, "tenant", gotHeader.Get("X-Scope-OrgID"))
+	require.Equal(t, "Basic dXNlcjpwYXNz", gotHeader.Get("Authorization"))
+
+	want := lokiPush{
+		Streams: []lokiStream{
+			{
+				Stream: map[string]string{"host": "nvr1", "src": "app", "level": "info"},
+				Values: [][2]string{{"1000000", "a"}, {"2000000", "b"}},
+			},
+			{
+				Stream: map[string]string{
+					"host": "nvr1", "src": "recorder", "level": "error", "monitor": "m1",
+				},
+				Values: [][2]string{{"3000000", "c"}},
+			},
+		},
+	}
+	require.Equal(t, want, gotPush)
+}
+
+func TestLokiSinkErr(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := newLokiSink(LokiConfig{URL: server.URL})
+	err := s.send(context.Background(), []log.Entry{{Src: "app", Msg: "a"}})
+	require.ErrorIs(t, err, ErrPushFailed)
+	require.Contains(t, err.Error(), "entry too far behind")
+}
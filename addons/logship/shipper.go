@@ -0,0 +1,125 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"nvr/pkg/log"
+	"sync/atomic"
+	"time"
+)
+
+type sink interface {
+	name() string
+	send(context.Context, []log.Entry) error
+	close()
+}
+
+const (
+	queueSize = 1000
+
+	// Time allowed to ship the remaining entries on shutdown.
+	flushTimeout = 3 * time.Second
+)
+
+// shipper buffers entries and sends them to the sink in batches. Entries
+// are dropped if the queue is full or the sink fails to accept them,
+// the logger must never be blocked by a slow or unreachable server.
+type shipper struct {
+	sink      sink
+	batchSize int
+	batchWait time.Duration
+	logf      log.Func
+
+	queue   chan log.Entry
+	dropped int64
+	failing bool
+}
+
+func newShipper(s sink, batchSize int, batchWait time.Duration, logf log.Func) *shipper {
+	return &shipper{
+		sink:      s,
+		batchSize: batchSize,
+		batchWait: batchWait,
+		logf:      logf,
+		queue:     make(chan log.Entry, queueSize),
+	}
+}
+
+// push adds the entry to the queue without blocking.
+func (s *shipper) push(e log.Entry) {
+	select {
+	case s.queue <- e:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+func (s *shipper) run(ctx context.Context) {
+	defer s.sink.close()
+
+	ticker := time.NewTicker(s.batchWait)
+	defer ticker.Stop()
+
+	batch := make([]log.Entry, 0, s.batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		s.send(ctx, batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			for len(s.queue) > 0 {
+				batch = append(batch, <-s.queue)
+			}
+			ctx2, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			flush(ctx2)
+			cancel()
+			return
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) >= s.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// send logs a single warning when the sink starts failing
+// and how many entries were lost when it recovers.
+func (s *shipper) send(ctx context.Context, batch []log.Entry) {
+	err := s.sink.send(ctx, batch)
+	if err != nil {
+		atomic.AddInt64(&s.dropped, int64(len(batch)))
+		if !s.failing && ctx.Err() == nil {
+			s.failing = true
+			s.logf(log.LevelWarning, "%v: %v", s.sink.name(), err)
+		}
+		return
+	}
+	dropped := atomic.SwapInt64(&s.dropped, 0)
+	if s.failing {
+		s.failing = false
+		s.logf(log.LevelInfo, "%v: recovered, %v entries dropped", s.sink.name(), dropped)
+	} else if dropped != 0 {
+		s.logf(log.LevelWarning, "%v: queue full, %v entries dropped", s.sink.name(), dropped)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"errors"
+	"nvr/pkg/log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockSink struct {
+	mu      sync.Mutex
+	err     error
+	batches [][]log.Entry
+	sent    chan struct{}
+}
+
+func (s *mockSink) name() string { return "mock" }
+
+func (s *mockSink) send(_ context.Context, entries []log.Entry) error {
+	s.mu.Lock()
+	defer func() {
+		s.mu.Unlock()
+		s.sent <- struct{}{}
+	}()
+	if s.err != nil {
+		return s.err
+	}
+	batch := make([]log.Entry, len(entries))
+	copy(batch, entries)
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *mockSink) close() {}
+
+func TestShipper(t *testing.T) {
+	t.Run("batchSize", func(t *testing.T) {
+		sink := &mockSink{sent: make(chan struct{}, 10)}
+		s := newShipper(sink, 2, time.Hour, func(log.Level, string, ...interface{}) {})
+		for _, msg := range []string{"1", "2", "3"} {
+			s.push(log.Entry{Msg: msg})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.run(ctx)
+			close(done)
+		}()
+		<-sink.sent
+		cancel()
+		<-done
+
+		require.Equal(t, [][]log.Entry{
+			{{Msg: "1"}, {Msg: "2"}},
+			{{Msg: "3"}},
+		}, sink.batches)
+	})
+	t.Run("queueFull", func(t *testing.T) {
+		s := newShipper(&mockSink{}, 1, time.Hour, nil)
+		for i := 0; i < queueSize+3; i++ {
+			s.push(log.Entry{})
+		}
+		require.Equal(t, int64(3), s.dropped)
+	})
+	t.Run("recover", func(t *testing.T) {
+		sink := &mockSink{sent: make(chan struct{}, 10), err: errors.New("mock")}
+		var logs []string
+		logf := func(_ log.Level, format string, a ...interface{}) {
+			logs = append(logs, format)
+		}
+		s := newShipper(sink, 1, time.Hour, logf)
+		ctx := context.Background()
+
+		s.send(ctx, []log.Entry{{}, {}})
+		s.send(ctx, []log.Entry{{}})
+		require.Equal(t, []string{"%v: %v"}, logs)
+
+		sink.err = nil
+		s.send(ctx, []log.Entry{{}})
+		require.Equal(t, []string{"%v: %v", "%v: recovered, %v entries dropped"}, logs)
+		require.Equal(t, int64(0), s.dropped)
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"nvr/pkg/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var facilities = map[string]int{
+	"user":   1,
+	"daemon": 3,
+	"local0": 16,
+	"local1": 17,
+	"local2": 18,
+	"local3": 19,
+	"local4": 20,
+	"local5": 21,
+	"local6": 22,
+	"local7": 23,
+}
+
+func facilityCode(name string) (int, error) {
+	code, exist := facilities[name]
+	if !exist {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidFacility, name)
+	}
+	return code, nil
+}
+
+// syslogAddress returns the network and address of the
+// server url. The default port is 514, or 6514 for TLS.
+func syslogAddress(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidAddress, raw)
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "udp", "tcp":
+		if port == "" {
+			port = "514"
+		}
+	case "tls":
+		if port == "" {
+			port = "6514"
+		}
+	default:
+		return "", "", fmt.Errorf("%w: scheme must be udp, tcp or tls: %q", ErrInvalidAddress, raw)
+	}
+	return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// severity returns the syslog severity of the level.
+func severity(level log.Level) int {
+	switch level {
+	case log.LevelError:
+		return 3
+	case log.LevelWarning:
+		return 4
+	case log.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// Structured data ID from the documentation range of RFC 5424.
+const syslogSDID = "nvr@32473"
+
+// formatSyslog formats the entry as a RFC 5424 message. The source
+// is the MSGID and the monitor ID is added as structured data.
+func formatSyslog(e log.Entry, facility int, hostname, tag string) string {
+	sd := "-"
+	if e.MonitorID != "" {
+		sd = "[" + syslogSDID + ` monitor="` + escapeSDParam(e.MonitorID) + `"]`
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - %s %s %s",
+		facility*8+severity(e.Level),
+		e.GetTime().UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(hostname),
+		syslogField(tag),
+		syslogField(e.Src),
+		sd,
+		e.Msg,
+	)
+}
+
+// syslogField replaces the characters that aren't allowed in the header.
+func syslogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func escapeSDParam(s string) string {
+	return sdParamEscaper.Replace(s)
+}
+
+const (
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 10 * time.Second
+)
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// syslogSink sends the entries over UDP, one message per
+// datagram, or TCP and TLS with octet counting framing.
+type syslogSink struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	tag      string
+	dial     dialFunc
+
+	conn net.Conn
+}
+
+func newSyslogSink(c SyslogConfig, hostname string) (*syslogSink, error) {
+	network, address, err := syslogAddress(c.Address)
+	if err != nil {
+		return nil, err
+	}
+	facility, err := facilityCode(c.Facility)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{
+		network:  network,
+		address:  address,
+		facility: facility,
+		hostname: hostname,
+		tag:      c.Tag,
+		dial:     dialSyslog,
+	}, nil
+}
+
+func dialSyslog(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "tls" {
+		d := tls.Dialer{NetDialer: &net.Dialer{}}
+		return d.DialContext(ctx, "tcp", address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func (s *syslogSink) name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) send(ctx context.Context, entries []log.Entry) error {
+	if s.conn == nil {
+		ctx2, cancel := context.WithTimeout(ctx, syslogDialTimeout)
+		defer cancel()
+		conn, err := s.dial(ctx2, s.network, s.address)
+		if err != nil {
+			return fmt.Errorf("dial: %w", err)
+		}
+		s.conn = conn
+	}
+
+	for _, e := range entries {
+		msg := formatSyslog(e, s.facility, s.hostname, s.tag)
+		if s.network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)) //nolint:errcheck
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			// Reconnect on the next send.
+			s.close()
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
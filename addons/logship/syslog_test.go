@@ -0,0 +1,129 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package logship
+
+import (
+	"context"
+	"net"
+	"nvr/pkg/log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyslogAddress(t *testing.T) {
+	cases := map[string]struct {
+		input   string
+		network string
+		address string
+		err     error
+	}{
+		"udp":    {"udp://host", "udp", "host:514", nil},
+		"tcp":    {"tcp://host:1514", "tcp", "host:1514", nil},
+		"tls":    {"tls://host", "tls", "host:6514", nil},
+		"ipv6":   {"udp://[::1]", "udp", "[::1]:514", nil},
+		"scheme": {"http://host", "", "", ErrInvalidAddress},
+		"empty":  {"udp://", "", "", ErrInvalidAddress},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			network, address, err := syslogAddress(tc.input)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.network, network)
+			require.Equal(t, tc.address, address)
+		})
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	ts := time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC)
+	e := log.Entry{
+		Level: log.LevelWarning,
+		Src:   "recorder",
+		Msg:   "a b",
+		Time:  log.UnixMicro(ts.UnixNano() / 1000),
+	}
+	require.Equal(t,
+		"<28>1 2022-01-02T03:04:05.000006Z nvr1 os-nvr - recorder - a b",
+		formatSyslog(e, 3, "nvr1", "os-nvr"),
+	)
+
+	e.MonitorID = `x"]`
+	e.Level = log.LevelError
+	require.Equal(t,
+		`<131>1 2022-01-02T03:04:05.000006Z - my_tag - recorder [nvr@32473 monitor="x\"\]"] a b`,
+		formatSyslog(e, 16, "", "my tag"),
+	)
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := newSyslogSink(SyslogConfig{
+		Address:  "udp://" + conn.LocalAddr().String(),
+		Tag:      "os-nvr",
+		Facility: "daemon",
+	}, "nvr1")
+	require.NoError(t, err)
+	defer s.close()
+
+	err = s.send(context.Background(), []log.Entry{
+		{Level: log.LevelInfo, Src: "app", Msg: "1"},
+		{Level: log.LevelInfo, Src: "app", Msg: "2"},
+	})
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	for _, want := range []string{"1", "2"} {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Regexp(t, `^<30>1 \S+ nvr1 os-nvr - app - `+want+`$`, string(buf[:n]))
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	s, err := newSyslogSink(SyslogConfig{
+		Address:  "tcp://" + ln.Addr().String(),
+		Tag:      "os-nvr",
+		Facility: "daemon",
+	}, "nvr1")
+	require.NoError(t, err)
+	defer s.close()
+
+	err = s.send(context.Background(), []log.Entry{{Level: log.LevelInfo, Src: "app", Msg: "1"}})
+	require.NoError(t, err)
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	size, msg, _ := strings.Cut(string(buf[:n]), " ")
+	require.Equal(t, strconv.Itoa(len(msg)), size)
+	require.Regexp(t, `^<30>1 \S+ nvr1 os-nvr - app - 1$`, msg)
+}
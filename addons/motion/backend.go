@@ -40,10 +40,19 @@ func init() {
 		app.Router.Handle("/motion.mjs", app.Auth.Admin(serveMotionMjs()))
 		return nil
 	})
+	nvr.RegisterMonitorValidateHook(validateConfig)
+}
+
+// validateConfig checks the settings and zones, used by the config check.
+func validateConfig(c monitor.Config) []error {
+	if _, _, err := parseConfig(c); err != nil {
+		return []error{fmt.Errorf("motion: %w", err)}
+	}
+	return nil
 }
 
 func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
-	if i.Config.SubInputEnabled() != i.IsSubInput() {
+	if !i.IsDetectInput() {
 		return
 	}
 
@@ -166,7 +175,7 @@ func generateFFmpegArgs(
 	args = append(args, "-y", "-threads", "1", "-loglevel", c.logLevel)
 
 	if c.hwaccel != "" {
-		args = append(args, ffmpeg.ParseArgs("-hwaccel "+c.hwaccel)...)
+		args = append(args, ffmpeg.ParseArgs(c.hwaccel)...)
 	}
 
 	args = append(args, "-rtsp_transport", rtspProtocol, "-i", rtspAddress)
@@ -246,11 +255,14 @@ func (d detector) runFrameReader(stdout io.Reader) error {
 	diffBuf := make([]uint8, d.frameSize)
 
 	onActive := func(zone int, score float64) {
-		d.logf(log.LevelDebug, "detection: zone:%v score:%.2f", zone, score)
 		t := time.Now().Add(-d.config.timestampOffset)
+		if !d.config.zones[zone].Schedule.Active(t) {
+			return
+		}
+		d.logf(log.LevelDebug, "detection: zone:%v score:%.2f", zone, score)
 		d.sendEvent(storage.Event{ //nolint:errcheck
 			Detections: []storage.Detection{
-				{Score: score},
+				d.config.zones[zone].detection(score),
 			},
 			Time:        t,
 			Duration:    d.config.duration,
@@ -27,7 +27,7 @@ func TestGenerateFFmpegArgs(t *testing.T) {
 	t.Run("maximal", func(t *testing.T) {
 		c := config{
 			logLevel: "2",
-			hwaccel:  "3",
+			hwaccel:  "-hwaccel 3",
 			feedRate: "6",
 			scale:    7,
 		}
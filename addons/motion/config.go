@@ -2,9 +2,11 @@ package motion
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
 	"strconv"
 	"strings"
 	"time"
@@ -58,6 +60,11 @@ func parseConfig(c monitor.Config) (*config, bool, error) {
 	}
 	duration := ffmpeg.FeedRateToDuration(feedRateFloat)
 
+	hw, err := c.HardwareAccel()
+	if err != nil {
+		return nil, false, fmt.Errorf("hwaccel: %w", err)
+	}
+
 	scale := parseScale(rawConf.FrameScale)
 
 	durationInt, err := strconv.Atoi(rawConf.Duration)
@@ -66,10 +73,19 @@ func parseConfig(c monitor.Config) (*config, bool, error) {
 	}
 	recDuration := time.Duration(durationInt) * time.Second
 
+	for i, zone := range rawConf.Zones {
+		if !zone.Enable {
+			continue
+		}
+		if err := zone.validate(); err != nil {
+			return nil, false, fmt.Errorf("zone %v: %w", i, err)
+		}
+	}
+
 	return &config{
 		monitorID:       c.ID(),
 		logLevel:        c.LogLevel(),
-		hwaccel:         c.Hwaccel(),
+		hwaccel:         hw.DecodeArgs(),
 		timestampOffset: timestampOffset,
 		feedRate:        rawConf.FeedRate,
 		duration:        duration,
@@ -117,4 +133,50 @@ type zoneConfig struct {
 	ThresholdMin float64 `json:"thresholdMin"`
 	ThresholdMax float64 `json:"thresholdMax"`
 	Area         area    `json:"area"`
+
+	// Label of the events from this zone, "motion" if empty.
+	Label string `json:"label"`
+
+	// The zone is only active during the schedule, always if empty.
+	Schedule schedule.Schedule `json:"schedule"`
+}
+
+// defaultLabel event label of zones without a label.
+const defaultLabel = "motion"
+
+func (z zoneConfig) label() string {
+	if z.Label == "" {
+		return defaultLabel
+	}
+	return z.Label
+}
+
+// Zone config errors.
+var (
+	errZoneArea        = errors.New("area must have at least 3 points")
+	errZonePoint       = errors.New("point must be within 0-100")
+	errZoneSensitivity = errors.New("sensitivity must be within 0-100")
+	errZoneThreshold   = errors.New("threshold min must be below max")
+)
+
+// validate checks the zone. The area is a polygon in percent of the frame.
+func (z zoneConfig) validate() error {
+	if len(z.Area) < 3 {
+		return errZoneArea
+	}
+	for _, p := range z.Area {
+		if p[0] < 0 || p[0] > 100 || p[1] < 0 || p[1] > 100 {
+			return fmt.Errorf("%w: %v", errZonePoint, p)
+		}
+	}
+	if z.Sensitivity < 0 || z.Sensitivity > 100 {
+		return fmt.Errorf("%w: %v", errZoneSensitivity, z.Sensitivity)
+	}
+	if z.ThresholdMin >= z.ThresholdMax {
+		return fmt.Errorf("%w: %v-%v", errZoneThreshold, z.ThresholdMin, z.ThresholdMax)
+	}
+	if err := z.Schedule.Validate(); err != nil {
+		return fmt.Errorf("schedule: %w", err)
+	}
+	return nil
 }
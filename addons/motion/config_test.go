@@ -21,6 +21,7 @@ import (
 
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/monitor"
+	"nvr/pkg/schedule"
 
 	"github.com/stretchr/testify/require"
 )
@@ -39,7 +40,8 @@ func TestParseConfig(t *testing.T) {
 					"sensitivity": 7,
 					"thresholdMin": 8,
 					"thresholdMax": 9,
-					"area":[[10,11],[12,13],[14,15]]
+					"area":[[10,11],[12,13],[14,15]],
+					"label": "door"
 				}
 			]
 		}`
@@ -58,7 +60,7 @@ func TestParseConfig(t *testing.T) {
 		expected := config{
 			monitorID:       "1",
 			logLevel:        "2",
-			hwaccel:         "3",
+			hwaccel:         "-hwaccel 3",
 			timestampOffset: 4000000,
 			feedRate:        "5",
 			duration:        200 * time.Millisecond,
@@ -70,6 +72,7 @@ func TestParseConfig(t *testing.T) {
 				ThresholdMin: 8,
 				ThresholdMax: 9,
 				Area:         []ffmpeg.Point{{10, 11}, {12, 13}, {14, 15}},
+				Label:        "door",
 			}},
 		}
 		require.Equal(t, expected, *actual)
@@ -110,6 +113,10 @@ func TestParseConfig(t *testing.T) {
 		"durationErr": {
 			"motion": `{"enable": "true", "feedRate":"0", "duration":"nil"}`,
 		},
+		"zoneErr": {
+			"motion": `{"enable": "true", "feedRate":"0", "duration":"0",` +
+				`"zones":[{"enable":true, "thresholdMax":100, "area":[[0,0],[1,1]]}]}`,
+		},
 	}
 	for name, conf := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -119,3 +126,47 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateZone(t *testing.T) {
+	valid := func() zoneConfig {
+		return zoneConfig{
+			Sensitivity:  8,
+			ThresholdMax: 100,
+			Area:         area{{0, 0}, {100, 0}, {50, 100}, {0, 50}},
+		}
+	}
+	require.NoError(t, valid().validate())
+
+	cases := map[string]struct {
+		modify      func(*zoneConfig)
+		expectedErr error
+	}{
+		"area": {
+			func(z *zoneConfig) { z.Area = z.Area[:2] },
+			errZoneArea,
+		},
+		"point": {
+			func(z *zoneConfig) { z.Area[1] = ffmpeg.Point{101, 0} },
+			errZonePoint,
+		},
+		"sensitivity": {
+			func(z *zoneConfig) { z.Sensitivity = -1 },
+			errZoneSensitivity,
+		},
+		"threshold": {
+			func(z *zoneConfig) { z.ThresholdMin = 100 },
+			errZoneThreshold,
+		},
+		"schedule": {
+			func(z *zoneConfig) { z.Schedule = schedule.Schedule{{Start: "x", End: "06:00"}} },
+			schedule.ErrInvalidTime,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			z := valid()
+			tc.modify(&z)
+			require.ErrorIs(t, z.validate(), tc.expectedErr)
+		})
+	}
+}
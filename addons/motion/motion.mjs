@@ -122,6 +122,8 @@ function zones(hls) {
 	let modal,
 		$modalContent,
 		$enable,
+		$label,
+		$schedule,
 		$sensitivity,
 		$thresholdMin,
 		$thresholdMax,
@@ -158,6 +160,24 @@ function zones(hls) {
 					</select>
 				</div>
 			</li>
+			<li class="form-field">
+				<label for="motion-modal-label" class="form-field-label">Label</label>
+				<input
+					id="motion-modal-label"
+					class="js-label settings-input-text"
+					type="text"
+					placeholder="motion"
+				/>
+			</li>
+			<li class="form-field">
+				<label for="motion-modal-schedule" class="form-field-label">Schedule</label>
+				<input
+					id="motion-modal-schedule"
+					class="js-schedule settings-input-text"
+					type="text"
+					placeholder="always"
+				/>
+			</li>
 			<li class="form-field">
 				<label for="motion-modal-sensitivity" class="form-field-label">Sensitivity</label>
 				<input
@@ -220,7 +240,32 @@ function zones(hls) {
 			selectedZone.enable = $enable.value === "true";
 		});
 
+		$label = $modalContent.querySelector(".js-label");
+		$label.addEventListener("change", () => {
+			selectedZone.label = $label.value.trim();
+		});
+
+		$schedule = $modalContent.querySelector(".js-schedule");
+		$schedule.addEventListener("change", () => {
+			const raw = $schedule.value.trim();
+			if (raw === "") {
+				delete selectedZone.schedule;
+				return;
+			}
+			try {
+				selectedZone.schedule = JSON.parse(raw);
+			} catch (error) {
+				alert("invalid schedule: " + error);
+			}
+		});
+
 		$sensitivity = $modalContent.querySelector(".js-sensitivity");
+		$sensitivity.addEventListener("change", () => {
+			const sensitivity = Number.parseFloat($sensitivity.value);
+			if (sensitivity >= 0 && sensitivity <= 100) {
+				selectedZone.sensitivity = sensitivity;
+			}
+		});
 
 		$thresholdMin = $modalContent.querySelector(".js-threshold-min");
 		$thresholdMin.addEventListener("change", () => {
@@ -279,10 +324,14 @@ function zones(hls) {
 	let $zoneSelect, selectedZone;
 
 	const loadZone = () => {
-		const zoneIndex = $zoneSelect.value.slice(5, 6);
+		const zoneIndex = $zoneSelect.value.slice(5);
 		selectedZone = zones[zoneIndex];
 
 		$enable.value = selectedZone.enable.toString();
+		$label.value = selectedZone.label || "";
+		$schedule.value = selectedZone.schedule
+			? JSON.stringify(selectedZone.schedule)
+			: "";
 		$sensitivity.value = selectedZone.sensitivity.toString();
 		$thresholdMin.value = selectedZone.thresholdMin.toString();
 		$thresholdMax.value = selectedZone.thresholdMax.toString();
@@ -408,6 +457,7 @@ function zones(hls) {
 		return {
 			enable: true,
 			preview: true,
+			label: "",
 			sensitivity: 8,
 			thresholdMin: 10,
 			thresholdMax: 100,
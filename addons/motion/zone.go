@@ -4,6 +4,7 @@ import (
 	"image"
 	"math"
 	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
 )
 
 type zones []*zone
@@ -99,6 +100,20 @@ func (z zone) checkDiff(diff []uint8) (float64, bool) {
 	return percentChanged, isActive
 }
 
+// detection returns the event detection of the zone, the
+// region is the zone area in percent of the frame.
+func (z zoneConfig) detection(score float64) storage.Detection {
+	polygon := make(ffmpeg.Polygon, len(z.Area))
+	for i, p := range z.Area {
+		polygon[i] = p
+	}
+	return storage.Detection{
+		Label:  z.label(),
+		Score:  score,
+		Region: &storage.Region{Polygon: &polygon},
+	}
+}
+
 func abs(x, y uint8) uint8 {
 	if x < y {
 		return y - x
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"testing"
 
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -171,3 +174,17 @@ func BenchmarkDetector(b *testing.B) {
 	}
 	_, _, _ = zone, score, active
 }
+
+func TestZoneDetection(t *testing.T) {
+	z := zoneConfig{Area: area{{0, 0}, {100, 0}, {50, 100}}}
+	polygon := ffmpeg.Polygon{{0, 0}, {100, 0}, {50, 100}}
+	expected := storage.Detection{
+		Label:  "motion",
+		Score:  12,
+		Region: &storage.Region{Polygon: &polygon},
+	}
+	require.Equal(t, expected, z.detection(12))
+
+	z.Label = "door"
+	require.Equal(t, "door", z.detection(12).Label)
+}
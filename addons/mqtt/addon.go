@@ -0,0 +1,102 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"nvr"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"sync"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"mqtt"})
+	nvr.RegisterAppRunHook(onAppRun)
+	nvr.RegisterMonitorEventHook(onEvent)
+	nvr.RegisterMonitorRecSavedHook(onRecSaved)
+	nvr.RegisterMonitorInputStateHook(onInputState)
+}
+
+var addon struct {
+	b  *bridge
+	mu sync.Mutex
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("mqtt: config: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "mqtt",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+	if config.Broker == "" {
+		logf(log.LevelInfo, "no broker configured")
+		return nil
+	}
+
+	opts := config.connectOptions(config.TopicPrefix)
+	dialBroker := func(ctx context.Context) (*client, error) {
+		return dial(ctx, config.Broker, opts)
+	}
+	b := newBridge(*config, dialBroker, logf, app.MonitorsInfo, app.MonitorEnable, app.TriggerEvent, app.Arming)
+
+	addon.mu.Lock()
+	addon.b = b
+	addon.mu.Unlock()
+
+	app.WG.Add(1)
+	go func() {
+		b.run(ctx)
+		app.WG.Done()
+	}()
+	return nil
+}
+
+func getBridge() *bridge {
+	addon.mu.Lock()
+	defer addon.mu.Unlock()
+	return addon.b
+}
+
+func onEvent(r *monitor.Recorder, event *storage.Event) {
+	if b := getBridge(); b != nil {
+		b.onEvent(r.Config.ID(), *event, r.ArmAction() == arming.ActionAlert)
+	}
+}
+
+func onRecSaved(r *monitor.Recorder, filePath string, data storage.RecordingData) {
+	if b := getBridge(); b != nil {
+		b.onRecordingSaved(r.Config.ID(), filePath, data)
+	}
+}
+
+func onInputState(i *monitor.InputProcess, health monitor.InputHealth) {
+	if i.IsSubInput() {
+		return
+	}
+	if b := getBridge(); b != nil {
+		b.onInputState(i.Config.ID(), health)
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payloads.
+const (
+	statusOnline  = "online"
+	statusOffline = "offline"
+	payloadOn     = "ON"
+	payloadOff    = "OFF"
+
+	// State of disabled monitors.
+	stateDisabled = "disabled"
+)
+
+// Reconnect delays, the delay doubles after each failed attempt.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// Number of messages that can wait to be published,
+// newer messages are dropped if the broker is too slow.
+const publishQueueSize = 100
+
+// Duration of recordings triggered by commands if the payload is empty.
+const defaultTriggerDuration = 30 * time.Second
+
+// Label of the detection of triggered events.
+const triggerLabel = "mqtt"
+
+type dialFunc func(context.Context) (*client, error)
+
+// bridge publishes monitor state and events
+// to the broker and handles the commands.
+type bridge struct {
+	prefix string
+	dial   dialFunc
+	logf   log.Func
+
+	// Home Assistant discovery, nil if disabled.
+	ha     *HomeAssistantConfig
+	nodeID string
+
+	monitorsInfo  func() monitor.RawConfigs
+	monitorEnable func(string, bool) error
+	triggerEvent  func(string, storage.Event) error
+
+	queue chan message
+
+	// Signals the session to publish the state again.
+	republish chan struct{}
+
+	arming *arming.Manager
+
+	// Timers that turn off the binary sensors, by state topic.
+	sensorTimers map[string]*time.Timer
+
+	mu sync.Mutex
+}
+
+func newBridge(
+	config Config,
+	dial dialFunc,
+	logf log.Func,
+	monitorsInfo func() monitor.RawConfigs,
+	monitorEnable func(string, bool) error,
+	triggerEvent func(string, storage.Event) error,
+	arm *arming.Manager,
+) *bridge {
+	var ha *HomeAssistantConfig
+	if config.HomeAssistant.Enable {
+		ha = &config.HomeAssistant
+	}
+	b := &bridge{
+		prefix:        config.TopicPrefix,
+		dial:          dial,
+		ha:            ha,
+		nodeID:        objectID(config.ClientID),
+		logf:          logf,
+		monitorsInfo:  monitorsInfo,
+		monitorEnable: monitorEnable,
+		triggerEvent:  triggerEvent,
+		queue:         make(chan message, publishQueueSize),
+		republish:     make(chan struct{}, 1),
+		arming:        arm,
+		sensorTimers:  make(map[string]*time.Timer),
+	}
+	if arm != nil {
+		arm.Subscribe(b.onArming)
+	}
+	return b
+}
+
+func (b *bridge) topic(parts ...string) string {
+	return b.prefix + "/" + strings.Join(parts, "/")
+}
+
+// publish queues the message, it's sent when the client is connected.
+func (b *bridge) publish(topic string, payload []byte, retain bool) {
+	select {
+	case b.queue <- message{topic: topic, payload: payload, retain: retain}:
+	default:
+		b.logf(log.LevelWarning, "publish queue full, dropping message: %v", topic)
+	}
+}
+
+func (b *bridge) publishJSON(topic string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		b.logf(log.LevelError, "marshal %v: %v", topic, err)
+		return
+	}
+	b.publish(topic, payload, false)
+}
+
+// run connects to the broker and reconnects until the context is canceled.
+func (b *bridge) run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		connected, err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = minReconnectDelay
+		}
+		b.logf(log.LevelError, "connection lost: %v, reconnecting in %v", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// session runs a single connection, returns true if the connection was established.
+func (b *bridge) session(ctx context.Context) (bool, error) {
+	c, err := b.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	b.logf(log.LevelInfo, "connected")
+
+	err = c.subscribe(b.commandTopics()...)
+	if err != nil {
+		c.close()
+		return true, fmt.Errorf("subscribe: %w", err)
+	}
+	// The state is published directly since it may not fit in the queue.
+	publishState := func() error {
+		for _, m := range b.stateMessages() {
+			if err := c.publish(m); err != nil {
+				return fmt.Errorf("publish state: %w", err)
+			}
+		}
+		return nil
+	}
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.read(b.onMessage) }()
+
+	fail := func(err error) (bool, error) {
+		c.conn.Close()
+		<-readErr
+		return true, err
+	}
+	if err := publishState(); err != nil {
+		return fail(err)
+	}
+
+	ping := time.NewTicker(c.keepAlive)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.publish(message{ //nolint:errcheck
+				topic:   b.topic("status"),
+				payload: []byte(statusOffline),
+				retain:  true,
+			})
+			c.close()
+			<-readErr
+			return true, ctx.Err()
+		case err := <-readErr:
+			c.conn.Close()
+			return true, err
+		case m := <-b.queue:
+			if err := c.publish(m); err != nil {
+				return fail(fmt.Errorf("publish: %w", err))
+			}
+		case <-b.republish:
+			if err := publishState(); err != nil {
+				return fail(err)
+			}
+		case <-ping.C:
+			if err := c.ping(); err != nil {
+				return fail(fmt.Errorf("ping: %w", err))
+			}
+		}
+	}
+}
+
+func (b *bridge) commandTopics() []string {
+	topics := []string{
+		b.topic("armed", "set"),
+		b.topic("arming", "set"),
+		b.topic("+", "arming", "set"),
+		b.topic("+", "enabled", "set"),
+		b.topic("+", "trigger"),
+	}
+	if b.ha != nil {
+		topics = append(topics, b.ha.statusTopic())
+	}
+	return topics
+}
+
+// stateMessages returns the retained state topics.
+func (b *bridge) stateMessages() []message {
+	monitors := b.monitorsInfo()
+	messages := []message{
+		{topic: b.topic("status"), payload: []byte(statusOnline), retain: true},
+	}
+	messages = append(messages, b.armingMessages(b.arming.State(), monitors)...)
+
+	if b.ha != nil {
+		messages = append(messages, b.discoveryMessages(monitors)...)
+	}
+	for id, info := range monitors {
+		enabled := info["enable"] == "true"
+		messages = append(messages, message{
+			topic: b.topic(id, "enabled"), payload: onOff(enabled), retain: true,
+		})
+
+		state := info["state"]
+		if !enabled {
+			state = stateDisabled
+		}
+		if state != "" {
+			messages = append(messages, message{
+				topic: b.topic(id, "state"), payload: []byte(state), retain: true,
+			})
+		}
+		if b.ha != nil {
+			messages = append(messages, b.sensorResetMessages(id)...)
+		}
+	}
+	return messages
+}
+
+// armingMessages returns the retained arming topics.
+func (b *bridge) armingMessages(state arming.State, monitors monitor.RawConfigs) []message {
+	messages := []message{
+		{topic: b.topic("armed"), payload: onOff(state.Mode != arming.Disarmed), retain: true},
+		{topic: b.topic("arming"), payload: []byte(state.Mode), retain: true},
+	}
+	for id := range monitors {
+		messages = append(messages, message{
+			topic:   b.topic(id, "arming"),
+			payload: []byte(state.MonitorMode(id)),
+			retain:  true,
+		})
+	}
+	return messages
+}
+
+func (b *bridge) onArming(state arming.State) {
+	for _, m := range b.armingMessages(state, b.monitorsInfo()) {
+		b.publish(m.topic, m.payload, m.retain)
+	}
+}
+
+func onOff(v bool) []byte {
+	if v {
+		return []byte(payloadOn)
+	}
+	return []byte(payloadOff)
+}
+
+// ErrInvalidPayload invalid command payload.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+func parseOnOff(payload []byte) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case "ON", "TRUE", "1":
+		return true, nil
+	case "OFF", "FALSE", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %q", ErrInvalidPayload, payload)
+}
+
+func (b *bridge) onMessage(m message) {
+	if err := b.handleCommand(m); err != nil {
+		b.logf(log.LevelError, "command %v: %v", m.topic, err)
+	}
+}
+
+// ErrUnknownCommand unknown command topic.
+var ErrUnknownCommand = errors.New("unknown command")
+
+func (b *bridge) handleCommand(m message) error {
+	// Home Assistant publishes "online" after restarting.
+	if b.ha != nil && m.topic == b.ha.statusTopic() {
+		if string(m.payload) == statusOnline {
+			select {
+			case b.republish <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(m.topic, b.prefix+"/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "armed" && parts[1] == "set":
+		armed, err := parseOnOff(m.payload)
+		if err != nil {
+			return err
+		}
+		mode := arming.Away
+		if !armed {
+			mode = arming.Disarmed
+		}
+		return b.arming.SetMode(mode, "mqtt")
+
+	case len(parts) == 2 && parts[0] == "arming" && parts[1] == "set":
+		mode, err := parseMode(m.payload)
+		if err != nil {
+			return err
+		}
+		return b.arming.SetMode(mode, "mqtt")
+
+	case len(parts) == 3 && parts[1] == "arming" && parts[2] == "set":
+		id := parts[0]
+		if _, exist := b.monitorsInfo()[id]; !exist {
+			return fmt.Errorf("%w: %v", monitor.ErrMonitorNotExist, id)
+		}
+		// Empty payload removes the override.
+		if len(strings.TrimSpace(string(m.payload))) == 0 {
+			return b.arming.SetMonitorMode(id, "", "mqtt")
+		}
+		mode, err := parseMode(m.payload)
+		if err != nil {
+			return err
+		}
+		return b.arming.SetMonitorMode(id, mode, "mqtt")
+
+	case len(parts) == 3 && parts[1] == "enabled" && parts[2] == "set":
+		enable, err := parseOnOff(m.payload)
+		if err != nil {
+			return err
+		}
+		id := parts[0]
+		if err := b.monitorEnable(id, enable); err != nil {
+			return err
+		}
+		b.logf(log.LevelInfo, "monitor %v enabled: %v", id, enable)
+		b.publish(b.topic(id, "enabled"), onOff(enable), true)
+		if !enable {
+			b.publish(b.topic(id, "state"), []byte(stateDisabled), true)
+		}
+		return nil
+
+	case len(parts) == 2 && parts[1] == "trigger":
+		duration, err := parseTriggerDuration(m.payload)
+		if err != nil {
+			return err
+		}
+		return b.triggerEvent(parts[0], storage.Event{
+			Time:        time.Now(),
+			Detections:  []storage.Detection{{Label: triggerLabel}},
+			Duration:    duration,
+			RecDuration: duration,
+		})
+	}
+	return fmt.Errorf("%w: %v", ErrUnknownCommand, m.topic)
+}
+
+func parseMode(payload []byte) (arming.Mode, error) {
+	mode, err := arming.ParseMode(strings.ToLower(strings.TrimSpace(string(payload))))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return mode, nil
+}
+
+// parseTriggerDuration parses the recording duration in seconds.
+func parseTriggerDuration(payload []byte) (time.Duration, error) {
+	raw := strings.TrimSpace(string(payload))
+	if raw == "" {
+		return defaultTriggerDuration, nil
+	}
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("%w: duration: %q", ErrInvalidPayload, raw)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+type eventPayload struct {
+	MonitorID string `json:"monitorId"`
+	storage.Event
+}
+
+// onEvent updates the sensors, the event is only published if alert is set.
+func (b *bridge) onEvent(monitorID string, event storage.Event, alert bool) {
+	// Continuous recording triggers.
+	if len(event.Detections) == 0 {
+		return
+	}
+	if b.ha != nil {
+		b.onDetections(monitorID, event.Detections)
+	}
+	if !alert {
+		return
+	}
+	b.publishJSON(b.topic(monitorID, "event"), eventPayload{
+		MonitorID: monitorID,
+		Event:     event,
+	})
+}
+
+type recordingPayload struct {
+	MonitorID string    `json:"monitorId"`
+	ID        string    `json:"id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+func (b *bridge) onRecordingSaved(monitorID string, filePath string, data storage.RecordingData) {
+	b.publishJSON(b.topic(monitorID, "recording"), recordingPayload{
+		MonitorID: monitorID,
+		ID:        filepath.Base(filePath),
+		Start:     data.Start,
+		End:       data.End,
+	})
+
+	thumbnail, err := os.ReadFile(filePath + ".jpeg")
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			b.logf(log.LevelError, "read thumbnail: %v", err)
+		}
+		return
+	}
+	b.publish(b.topic(monitorID, "thumbnail"), thumbnail, true)
+}
+
+func (b *bridge) onInputState(monitorID string, health monitor.InputHealth) {
+	b.publish(b.topic(monitorID, "state"), []byte(health.State), true)
+}
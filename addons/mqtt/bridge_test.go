@@ -0,0 +1,291 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBridge(t *testing.T) *bridge {
+	return newBridge(
+		Config{ClientID: "os-nvr", TopicPrefix: "nvr"},
+		nil,
+		func(log.Level, string, ...interface{}) {},
+		func() monitor.RawConfigs {
+			return monitor.RawConfigs{
+				"a": {"enable": "true", "state": "online"},
+				"b": {"enable": "false"},
+			}
+		},
+		func(string, bool) error { return nil },
+		func(string, storage.Event) error { return nil },
+		newTestArming(t),
+	)
+}
+
+func newTestArming(t *testing.T) *arming.Manager {
+	m, err := arming.NewManager(filepath.Join(t.TempDir(), "arming.json"), log.NewDummyLogger())
+	require.NoError(t, err)
+	return m
+}
+
+func readQueue(b *bridge) map[string]string {
+	messages := make(map[string]string)
+	for {
+		select {
+		case m := <-b.queue:
+			messages[m.topic] = string(m.payload)
+		default:
+			return messages
+		}
+	}
+}
+
+func TestStateMessages(t *testing.T) {
+	b := newTestBridge(t)
+	messages := make(map[string]string)
+	for _, m := range b.stateMessages() {
+		require.True(t, m.retain)
+		messages[m.topic] = string(m.payload)
+	}
+	expected := map[string]string{
+		"nvr/status":    "online",
+		"nvr/armed":     "ON",
+		"nvr/arming":    "away",
+		"nvr/a/arming":  "away",
+		"nvr/a/enabled": "ON",
+		"nvr/a/state":   "online",
+		"nvr/b/arming":  "away",
+		"nvr/b/enabled": "OFF",
+		"nvr/b/state":   "disabled",
+	}
+	require.Equal(t, expected, messages)
+}
+
+func TestHandleCommand(t *testing.T) {
+	t.Run("armed", func(t *testing.T) {
+		b := newTestBridge(t)
+		err := b.handleCommand(message{topic: "nvr/armed/set", payload: []byte("off")})
+		require.NoError(t, err)
+		require.Equal(t, arming.Disarmed, b.arming.Mode())
+
+		expected := map[string]string{
+			"nvr/armed":    "OFF",
+			"nvr/arming":   "disarmed",
+			"nvr/a/arming": "disarmed",
+			"nvr/b/arming": "disarmed",
+		}
+		require.Equal(t, expected, readQueue(b))
+
+		err = b.handleCommand(message{topic: "nvr/armed/set", payload: []byte("on")})
+		require.NoError(t, err)
+		require.Equal(t, arming.Away, b.arming.Mode())
+	})
+	t.Run("arming", func(t *testing.T) {
+		b := newTestBridge(t)
+		err := b.handleCommand(message{topic: "nvr/arming/set", payload: []byte("Home")})
+		require.NoError(t, err)
+		require.Equal(t, arming.Home, b.arming.Mode())
+		require.Equal(t, "ON", readQueue(b)["nvr/armed"])
+	})
+	t.Run("monitorArming", func(t *testing.T) {
+		b := newTestBridge(t)
+		err := b.handleCommand(message{topic: "nvr/a/arming/set", payload: []byte("disarmed")})
+		require.NoError(t, err)
+		require.Equal(t, arming.Disarmed, b.arming.MonitorMode("a"))
+
+		messages := readQueue(b)
+		require.Equal(t, "disarmed", messages["nvr/a/arming"])
+		require.Equal(t, "away", messages["nvr/b/arming"])
+
+		err = b.handleCommand(message{topic: "nvr/a/arming/set"})
+		require.NoError(t, err)
+		require.Equal(t, arming.Away, b.arming.MonitorMode("a"))
+
+		err = b.handleCommand(message{topic: "nvr/x/arming/set", payload: []byte("home")})
+		require.ErrorIs(t, err, monitor.ErrMonitorNotExist)
+	})
+	t.Run("enable", func(t *testing.T) {
+		b := newTestBridge(t)
+		var id string
+		var enable bool
+		b.monitorEnable = func(i string, e bool) error {
+			id, enable = i, e
+			return nil
+		}
+		err := b.handleCommand(message{topic: "nvr/a/enabled/set", payload: []byte("OFF")})
+		require.NoError(t, err)
+		require.Equal(t, "a", id)
+		require.False(t, enable)
+
+		expected := map[string]string{
+			"nvr/a/enabled": "OFF",
+			"nvr/a/state":   "disabled",
+		}
+		require.Equal(t, expected, readQueue(b))
+	})
+	t.Run("enableErr", func(t *testing.T) {
+		b := newTestBridge(t)
+		b.monitorEnable = func(string, bool) error { return monitor.ErrMonitorNotExist }
+		err := b.handleCommand(message{topic: "nvr/x/enabled/set", payload: []byte("ON")})
+		require.ErrorIs(t, err, monitor.ErrMonitorNotExist)
+		require.Empty(t, readQueue(b))
+	})
+	t.Run("trigger", func(t *testing.T) {
+		b := newTestBridge(t)
+		var id string
+		var event storage.Event
+		b.triggerEvent = func(i string, e storage.Event) error {
+			id, event = i, e
+			return nil
+		}
+		err := b.handleCommand(message{topic: "nvr/a/trigger", payload: []byte("5")})
+		require.NoError(t, err)
+		require.Equal(t, "a", id)
+		require.Equal(t, 5*time.Second, event.RecDuration)
+		require.Equal(t, []storage.Detection{{Label: "mqtt"}}, event.Detections)
+
+		err = b.handleCommand(message{topic: "nvr/a/trigger"})
+		require.NoError(t, err)
+		require.Equal(t, defaultTriggerDuration, event.RecDuration)
+	})
+	t.Run("invalidPayload", func(t *testing.T) {
+		b := newTestBridge(t)
+		err := b.handleCommand(message{topic: "nvr/armed/set", payload: []byte("x")})
+		require.ErrorIs(t, err, ErrInvalidPayload)
+		err = b.handleCommand(message{topic: "nvr/arming/set", payload: []byte("x")})
+		require.ErrorIs(t, err, ErrInvalidPayload)
+		err = b.handleCommand(message{topic: "nvr/a/trigger", payload: []byte("-1")})
+		require.ErrorIs(t, err, ErrInvalidPayload)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		err := newTestBridge(t).handleCommand(message{topic: "nvr/a/b/c/d"})
+		require.ErrorIs(t, err, ErrUnknownCommand)
+	})
+}
+
+func TestOnEvent(t *testing.T) {
+	b := newTestBridge(t)
+	b.onEvent("a", storage.Event{RecDuration: time.Hour}, true)
+	require.Empty(t, readQueue(b))
+
+	event := storage.Event{
+		Time:       time.Unix(1, 0).UTC(),
+		Detections: []storage.Detection{{Label: "person", Score: 90}},
+	}
+	b.onEvent("a", event, false)
+	require.Empty(t, readQueue(b))
+
+	b.onEvent("a", event, true)
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(readQueue(b)["nvr/a/event"]), &payload))
+	require.Equal(t, "a", payload["monitorId"])
+	require.Equal(t, "1970-01-01T00:00:01Z", payload["time"])
+}
+
+// fakeBroker accepts a single connection.
+type fakeBroker struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (f *fakeBroker) read() *packet {
+	f.t.Helper()
+	require.NoError(f.t, f.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	p, err := readPacket(f.r)
+	require.NoError(f.t, err)
+	return p
+}
+
+func (f *fakeBroker) write(kind byte, flags byte, body []byte) {
+	f.t.Helper()
+	require.NoError(f.t, writePacket(f.conn, kind, flags, body))
+}
+
+func TestSession(t *testing.T) {
+	brokerConn, clientConn := net.Pipe()
+	defer brokerConn.Close()
+	broker := &fakeBroker{t: t, conn: brokerConn, r: bufio.NewReader(brokerConn)}
+
+	b := newTestBridge(t)
+	enabled := make(chan string, 1)
+	b.monitorEnable = func(id string, _ bool) error {
+		enabled <- id
+		return nil
+	}
+	b.dial = func(ctx context.Context) (*client, error) {
+		c := newClient(clientConn, time.Minute)
+		return c, c.connect(connectOptions{clientID: "x", keepAlive: time.Minute})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := b.session(ctx)
+		done <- err
+	}()
+
+	require.Equal(t, byte(packetConnect), broker.read().kind)
+	broker.write(packetConnack, 0, []byte{0, 0})
+
+	p := broker.read()
+	require.Equal(t, byte(packetSubscribe), p.kind)
+	require.Equal(t, subscribeBody(1, []string{
+		"nvr/armed/set", "nvr/arming/set", "nvr/+/arming/set",
+		"nvr/+/enabled/set", "nvr/+/trigger",
+	}), p.body)
+	broker.write(packetSuback, 0, []byte{0, 1, 0, 0, 0, 0, 0})
+
+	published := make(map[string]string)
+	for len(published) < 9 {
+		p := broker.read()
+		require.Equal(t, byte(packetPublish), p.kind)
+		m, _, err := parsePublish(p)
+		require.NoError(t, err)
+		require.True(t, m.retain)
+		published[m.topic] = string(m.payload)
+	}
+	require.Equal(t, "online", published["nvr/status"])
+
+	_, body := publishBody(message{topic: "nvr/b/enabled/set", payload: []byte("ON")})
+	broker.write(packetPublish, 0, body)
+	require.Equal(t, "b", <-enabled)
+
+	p = broker.read()
+	m, _, err := parsePublish(p)
+	require.NoError(t, err)
+	require.Equal(t, message{topic: "nvr/b/enabled", payload: []byte("ON"), retain: true}, m)
+
+	cancel()
+	m, _, err = parsePublish(broker.read())
+	require.NoError(t, err)
+	require.Equal(t, message{topic: "nvr/status", payload: []byte("offline"), retain: true}, m)
+	require.Equal(t, byte(packetDisconnect), broker.read().kind)
+	brokerConn.Close()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
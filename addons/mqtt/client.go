@@ -0,0 +1,209 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+type connectOptions struct {
+	clientID  string
+	username  string
+	password  string
+	keepAlive time.Duration
+
+	// Published by the broker if the connection is lost.
+	will *message
+}
+
+// Timeout for the connection handshake and each write.
+const writeTimeout = 10 * time.Second
+
+// client minimal MQTT 3.1.1 client. Messages are published and
+// subscribed with QoS 0, there is no session state to restore.
+type client struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+
+	mu sync.Mutex // Write lock.
+}
+
+// ErrInvalidBroker invalid broker url.
+var ErrInvalidBroker = errors.New("invalid broker url")
+
+// brokerAddress returns the host:port of the broker and if TLS is used.
+func brokerAddress(broker string) (string, bool, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Hostname() == "" {
+		return "", false, fmt.Errorf("%w: %q", ErrInvalidBroker, broker)
+	}
+	var port string
+	var useTLS bool
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		port = "1883"
+	case "ssl", "tls", "mqtts":
+		port, useTLS = "8883", true
+	default:
+		return "", false, fmt.Errorf("%w: unsupported scheme: %q", ErrInvalidBroker, u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+func dial(ctx context.Context, broker string, opts connectOptions) (*client, error) {
+	address, useTLS, err := brokerAddress(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c := newClient(conn, opts.keepAlive)
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(conn net.Conn, keepAlive time.Duration) *client {
+	return &client{
+		conn:      conn,
+		r:         bufio.NewReader(conn),
+		keepAlive: keepAlive,
+	}
+}
+
+func (c *client) connect(opts connectOptions) error {
+	if err := c.conn.SetDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if err := writePacket(c.conn, packetConnect, 0, connectBody(opts)); err != nil {
+		return fmt.Errorf("write connect: %w", err)
+	}
+	p, err := readPacket(c.r)
+	if err != nil {
+		return fmt.Errorf("read connack: %w", err)
+	}
+	if err := parseConnack(p); err != nil {
+		return err
+	}
+	return c.conn.SetDeadline(time.Time{})
+}
+
+func (c *client) write(kind byte, flags byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return writePacket(c.conn, kind, flags, body)
+}
+
+func (c *client) publish(m message) error {
+	flags, body := publishBody(m)
+	return c.write(packetPublish, flags, body)
+}
+
+// subscribe sends a subscribe request, the
+// acknowledgement is checked by the read loop.
+func (c *client) subscribe(filters ...string) error {
+	const packetID = 1
+	return c.write(packetSubscribe, 0x02, subscribeBody(packetID, filters))
+}
+
+func (c *client) ping() error {
+	return c.write(packetPingreq, 0, nil)
+}
+
+// read reads packets until the connection fails or is closed. The
+// broker must respond to pings, the connection is considered lost if
+// nothing is received within one and a half keep alive intervals.
+func (c *client) read(onMessage func(message)) error {
+	for {
+		deadline := time.Now().Add(c.keepAlive * 3 / 2)
+		if err := c.conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		p, err := readPacket(c.r)
+		if err != nil {
+			return err
+		}
+
+		switch p.kind {
+		case packetPublish:
+			m, id, err := parsePublish(p)
+			if err != nil {
+				return fmt.Errorf("publish: %w", err)
+			}
+			// The subscriptions are QoS 0, the broker may
+			// still deliver QoS 1 for retained messages.
+			if id != 0 {
+				if err := c.write(packetPuback, 0, appendUint16(nil, id)); err != nil {
+					return err
+				}
+			}
+			onMessage(m)
+		case packetSuback:
+			if err := parseSuback(p); err != nil {
+				return err
+			}
+		case packetPingresp:
+		default:
+			return fmt.Errorf("%w: unexpected packet type: %v", ErrMalformed, p.kind)
+		}
+	}
+}
+
+// close sends a disconnect packet and closes the
+// connection, the broker discards the will message.
+func (c *client) close() {
+	c.write(packetDisconnect, 0, nil) //nolint:errcheck
+	c.conn.Close()
+}
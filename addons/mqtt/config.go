@@ -0,0 +1,164 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config global addon config.
+type Config struct {
+	// Broker url, for example "tcp://localhost:1883" or
+	// "tls://broker:8883". The addon is disabled if empty.
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientId"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// All topics start with the prefix.
+	TopicPrefix string `json:"topicPrefix"`
+
+	// Seconds between pings.
+	KeepAlive int `json:"keepAlive"`
+
+	HomeAssistant HomeAssistantConfig `json:"homeAssistant"`
+}
+
+// HomeAssistantConfig Home Assistant MQTT discovery.
+type HomeAssistantConfig struct {
+	Enable          bool   `json:"enable"`
+	DiscoveryPrefix string `json:"discoveryPrefix"`
+
+	// Seconds the motion and occupancy sensors
+	// stay on after the last detection.
+	MotionTimeout float64 `json:"motionTimeout"`
+
+	// Detection labels that turn on the occupancy sensor.
+	OccupancyLabels []string `json:"occupancyLabels"`
+}
+
+// Default config values.
+const (
+	defaultClientID    = "os-nvr"
+	defaultTopicPrefix = "os-nvr"
+	defaultKeepAlive   = 60
+
+	defaultDiscoveryPrefix = "homeassistant"
+	defaultMotionTimeout   = 30
+)
+
+var defaultOccupancyLabels = []string{"person"}
+
+// Config errors.
+var (
+	ErrInvalidPrefix    = errors.New("invalid topic prefix")
+	ErrInvalidKeepAlive = errors.New("invalid keep alive")
+	ErrInvalidTimeout   = errors.New("invalid motion timeout")
+)
+
+func (c *Config) setDefaults() {
+	if c.ClientID == "" {
+		c.ClientID = defaultClientID
+	}
+	if c.TopicPrefix == "" {
+		c.TopicPrefix = defaultTopicPrefix
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = defaultKeepAlive
+	}
+
+	ha := &c.HomeAssistant
+	if ha.DiscoveryPrefix == "" {
+		ha.DiscoveryPrefix = defaultDiscoveryPrefix
+	}
+	if ha.MotionTimeout == 0 {
+		ha.MotionTimeout = defaultMotionTimeout
+	}
+	if ha.OccupancyLabels == nil {
+		ha.OccupancyLabels = defaultOccupancyLabels
+	}
+}
+
+func (c Config) validate() error {
+	if c.Broker != "" {
+		if _, _, err := brokerAddress(c.Broker); err != nil {
+			return err
+		}
+	}
+	if !validPrefix(c.TopicPrefix) {
+		return fmt.Errorf("%w: %q", ErrInvalidPrefix, c.TopicPrefix)
+	}
+	if c.KeepAlive < 1 || c.KeepAlive > 65535 {
+		return fmt.Errorf("%w: %v", ErrInvalidKeepAlive, c.KeepAlive)
+	}
+	if !validPrefix(c.HomeAssistant.DiscoveryPrefix) {
+		return fmt.Errorf("%w: discovery: %q", ErrInvalidPrefix, c.HomeAssistant.DiscoveryPrefix)
+	}
+	if c.HomeAssistant.MotionTimeout < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTimeout, c.HomeAssistant.MotionTimeout)
+	}
+	return nil
+}
+
+func validPrefix(prefix string) bool {
+	return !strings.ContainsAny(prefix, "+#") && !strings.HasSuffix(prefix, "/")
+}
+
+func (c Config) connectOptions(prefix string) connectOptions {
+	return connectOptions{
+		clientID:  c.ClientID,
+		username:  c.Username,
+		password:  c.Password,
+		keepAlive: time.Duration(c.KeepAlive) * time.Second,
+		will: &message{
+			topic:   prefix + "/status",
+			payload: []byte(statusOffline),
+			retain:  true,
+		},
+	}
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "mqtt.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		defaultConfig := Config{}
+		defaultConfig.setDefaults()
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Broker: "tcp://localhost"}
+	valid.setDefaults()
+	cases := map[string]struct {
+		modify func(*Config)
+		err    error
+	}{
+		"ok":        {func(*Config) {}, nil},
+		"disabled":  {func(c *Config) { c.Broker = "" }, nil},
+		"tls":       {func(c *Config) { c.Broker = "tls://broker:8884" }, nil},
+		"scheme":    {func(c *Config) { c.Broker = "http://localhost" }, ErrInvalidBroker},
+		"noHost":    {func(c *Config) { c.Broker = "tcp://" }, ErrInvalidBroker},
+		"wildcard":  {func(c *Config) { c.TopicPrefix = "a/#" }, ErrInvalidPrefix},
+		"slash":     {func(c *Config) { c.TopicPrefix = "a/" }, ErrInvalidPrefix},
+		"keepAlive": {func(c *Config) { c.KeepAlive = 70000 }, ErrInvalidKeepAlive},
+		"discovery": {func(c *Config) { c.HomeAssistant.DiscoveryPrefix = "+" }, ErrInvalidPrefix},
+		"timeout":   {func(c *Config) { c.HomeAssistant.MotionTimeout = -1 }, ErrInvalidTimeout},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			tc.modify(&c)
+			require.ErrorIs(t, c.validate(), tc.err)
+		})
+	}
+}
+
+func TestBrokerAddress(t *testing.T) {
+	address, useTLS, err := brokerAddress("mqtt://localhost")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:1883", address)
+	require.False(t, useTLS)
+
+	address, useTLS, err = brokerAddress("mqtts://localhost")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:8883", address)
+	require.True(t, useTLS)
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		ClientID:    "os-nvr",
+		TopicPrefix: "os-nvr",
+		KeepAlive:   60,
+		HomeAssistant: HomeAssistantConfig{
+			DiscoveryPrefix: "homeassistant",
+			MotionTimeout:   30,
+			OccupancyLabels: []string{"person"},
+		},
+	}, *config)
+	require.FileExists(t, filepath.Join(dir, "mqtt.json"))
+
+	err = os.WriteFile(filepath.Join(dir, "mqtt.json"), []byte(`{"broker": "tcp://x"}`), 0o600)
+	require.NoError(t, err)
+	config, err = readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, "tcp://x", config.Broker)
+	require.Equal(t, "os-nvr", config.TopicPrefix)
+}
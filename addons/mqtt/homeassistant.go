@@ -0,0 +1,245 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"encoding/json"
+	"nvr/pkg/arming"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Home Assistant MQTT discovery. Each monitor is a device with a camera that
+// shows the latest recording thumbnail, motion and occupancy sensors, a state
+// sensor, a enable switch and a trigger button. The NVR itself is a device
+// with the armed switch and a alarm control panel for the arming mode.
+// https://www.home-assistant.io/integrations/mqtt
+
+func (c HomeAssistantConfig) statusTopic() string {
+	return c.DiscoveryPrefix + "/status"
+}
+
+func (c HomeAssistantConfig) motionTimeout() time.Duration {
+	return time.Duration(c.MotionTimeout * float64(time.Second))
+}
+
+// objectID replaces characters that aren't allowed in discovery topics.
+func objectID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+	ViaDevice    string   `json:"via_device,omitempty"`
+}
+
+type haEntity struct {
+	Name     string   `json:"name"`
+	UniqueID string   `json:"unique_id"`
+	Device   haDevice `json:"device"`
+
+	AvailabilityTopic   string `json:"availability_topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+
+	StateTopic   string `json:"state_topic,omitempty"`
+	CommandTopic string `json:"command_topic,omitempty"`
+	Topic        string `json:"topic,omitempty"` // Camera image.
+
+	DeviceClass  string `json:"device_class,omitempty"`
+	Icon         string `json:"icon,omitempty"`
+	PayloadOn    string `json:"payload_on,omitempty"`
+	PayloadOff   string `json:"payload_off,omitempty"`
+	PayloadPress string `json:"payload_press,omitempty"`
+
+	// Alarm control panel.
+	ValueTemplate     string   `json:"value_template,omitempty"`
+	PayloadDisarm     string   `json:"payload_disarm,omitempty"`
+	PayloadArmHome    string   `json:"payload_arm_home,omitempty"`
+	PayloadArmAway    string   `json:"payload_arm_away,omitempty"`
+	PayloadArmNight   string   `json:"payload_arm_night,omitempty"`
+	SupportedFeatures []string `json:"supported_features,omitempty"`
+	CodeArmRequired   *bool    `json:"code_arm_required,omitempty"`
+}
+
+func (b *bridge) discoveryTopic(component string, objectID string) string {
+	return b.ha.DiscoveryPrefix + "/" + component + "/" + b.nodeID + "/" + objectID + "/config"
+}
+
+func (b *bridge) newEntity(device haDevice, objectID string, name string) haEntity {
+	return haEntity{
+		Name:                name,
+		UniqueID:            b.nodeID + "_" + objectID,
+		Device:              device,
+		AvailabilityTopic:   b.topic("status"),
+		PayloadAvailable:    statusOnline,
+		PayloadNotAvailable: statusOffline,
+	}
+}
+
+func (b *bridge) discoveryMessages(monitors monitor.RawConfigs) []message {
+	var messages []message
+	add := func(component string, objectID string, e haEntity) {
+		payload, _ := json.Marshal(e) // Strings only.
+		messages = append(messages, message{
+			topic:   b.discoveryTopic(component, objectID),
+			payload: payload,
+			retain:  true,
+		})
+	}
+
+	nvrDevice := haDevice{
+		Identifiers:  []string{b.nodeID},
+		Name:         "OS-NVR",
+		Manufacturer: "OS-NVR",
+	}
+	armed := b.newEntity(nvrDevice, "armed", "Armed")
+	armed.StateTopic = b.topic("armed")
+	armed.CommandTopic = b.topic("armed", "set")
+	armed.Icon = "mdi:shield-home"
+	add("switch", "armed", armed)
+
+	noCode := false
+	panel := b.newEntity(nvrDevice, "arming", "Arming")
+	panel.StateTopic = b.topic("arming")
+	panel.CommandTopic = b.topic("arming", "set")
+	panel.ValueTemplate = "{{ 'disarmed' if value == 'disarmed' else 'armed_' + value }}"
+	panel.PayloadDisarm = string(arming.Disarmed)
+	panel.PayloadArmHome = string(arming.Home)
+	panel.PayloadArmAway = string(arming.Away)
+	panel.PayloadArmNight = string(arming.Night)
+	panel.SupportedFeatures = []string{"arm_home", "arm_away", "arm_night"}
+	panel.CodeArmRequired = &noCode
+	add("alarm_control_panel", "arming", panel)
+
+	for id, info := range monitors {
+		oid := objectID(id)
+		device := haDevice{
+			Identifiers:  []string{b.nodeID + "_" + oid},
+			Name:         info["name"],
+			Manufacturer: "OS-NVR",
+			Model:        "Monitor",
+			ViaDevice:    b.nodeID,
+		}
+
+		camera := b.newEntity(device, oid+"_camera", "Last event")
+		camera.Topic = b.topic(id, "thumbnail")
+		add("camera", oid+"_camera", camera)
+
+		motion := b.newEntity(device, oid+"_motion", "Motion")
+		motion.StateTopic = b.topic(id, "motion")
+		motion.DeviceClass = "motion"
+		add("binary_sensor", oid+"_motion", motion)
+
+		if len(b.ha.OccupancyLabels) != 0 {
+			occupancy := b.newEntity(device, oid+"_occupancy", "Occupancy")
+			occupancy.StateTopic = b.topic(id, "occupancy")
+			occupancy.DeviceClass = "occupancy"
+			add("binary_sensor", oid+"_occupancy", occupancy)
+		}
+
+		state := b.newEntity(device, oid+"_state", "State")
+		state.StateTopic = b.topic(id, "state")
+		state.Icon = "mdi:cctv"
+		add("sensor", oid+"_state", state)
+
+		enabled := b.newEntity(device, oid+"_enabled", "Enabled")
+		enabled.StateTopic = b.topic(id, "enabled")
+		enabled.CommandTopic = b.topic(id, "enabled", "set")
+		enabled.Icon = "mdi:video"
+		add("switch", oid+"_enabled", enabled)
+
+		trigger := b.newEntity(device, oid+"_trigger", "Trigger recording")
+		trigger.CommandTopic = b.topic(id, "trigger")
+		trigger.PayloadPress = strconv.Itoa(int(defaultTriggerDuration.Seconds()))
+		trigger.Icon = "mdi:record-rec"
+		add("button", oid+"_trigger", trigger)
+	}
+	return messages
+}
+
+// sensorResetMessages turns off the binary sensors of the monitor that aren't on.
+func (b *bridge) sensorResetMessages(monitorID string) []message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []message
+	for _, sensor := range []string{"motion", "occupancy"} {
+		topic := b.topic(monitorID, sensor)
+		if _, on := b.sensorTimers[topic]; !on {
+			messages = append(messages, message{
+				topic: topic, payload: []byte(payloadOff), retain: true,
+			})
+		}
+	}
+	return messages
+}
+
+func (b *bridge) onDetections(monitorID string, detections []storage.Detection) {
+	b.setSensor(b.topic(monitorID, "motion"))
+	for _, d := range detections {
+		if contains(b.ha.OccupancyLabels, d.Label) {
+			b.setSensor(b.topic(monitorID, "occupancy"))
+			return
+		}
+	}
+}
+
+// setSensor turns on the binary sensor, it's turned off
+// if there are no detections within the motion timeout.
+func (b *bridge) setSensor(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if timer, on := b.sensorTimers[topic]; on {
+		timer.Stop()
+	} else {
+		b.publish(topic, []byte(payloadOn), true)
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(b.ha.motionTimeout(), func() {
+		b.mu.Lock()
+		if b.sensorTimers[topic] != timer {
+			b.mu.Unlock()
+			return
+		}
+		delete(b.sensorTimers, topic)
+		b.mu.Unlock()
+		b.publish(topic, []byte(payloadOff), true)
+	})
+	b.sensorTimers[topic] = timer
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"encoding/json"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHABridge(t *testing.T, timeout float64) *bridge {
+	config := Config{
+		ClientID:    "os nvr",
+		TopicPrefix: "nvr",
+		HomeAssistant: HomeAssistantConfig{
+			Enable:        true,
+			MotionTimeout: timeout,
+		},
+	}
+	config.setDefaults()
+	return newBridge(
+		config,
+		nil,
+		func(log.Level, string, ...interface{}) {},
+		func() monitor.RawConfigs {
+			return monitor.RawConfigs{"a.b": {"name": "A", "enable": "true"}}
+		},
+		func(string, bool) error { return nil },
+		func(string, storage.Event) error { return nil },
+		newTestArming(t),
+	)
+}
+
+func TestObjectID(t *testing.T) {
+	require.Equal(t, "a_b-c_D1", objectID("a.b-c D1"))
+}
+
+func TestDiscoveryMessages(t *testing.T) {
+	b := newTestHABridge(t, 30)
+	configs := make(map[string]haEntity)
+	for _, m := range b.discoveryMessages(b.monitorsInfo()) {
+		require.True(t, m.retain)
+		var e haEntity
+		require.NoError(t, json.Unmarshal(m.payload, &e))
+		configs[m.topic] = e
+	}
+	require.Len(t, configs, 8)
+
+	motion := configs["homeassistant/binary_sensor/os_nvr/a_b_motion/config"]
+	require.Equal(t, haEntity{
+		Name:     "Motion",
+		UniqueID: "os_nvr_a_b_motion",
+		Device: haDevice{
+			Identifiers:  []string{"os_nvr_a_b"},
+			Name:         "A",
+			Manufacturer: "OS-NVR",
+			Model:        "Monitor",
+			ViaDevice:    "os_nvr",
+		},
+		AvailabilityTopic:   "nvr/status",
+		PayloadAvailable:    "online",
+		PayloadNotAvailable: "offline",
+		StateTopic:          "nvr/a.b/motion",
+		DeviceClass:         "motion",
+	}, motion)
+
+	enabled := configs["homeassistant/switch/os_nvr/a_b_enabled/config"]
+	require.Equal(t, "nvr/a.b/enabled/set", enabled.CommandTopic)
+
+	trigger := configs["homeassistant/button/os_nvr/a_b_trigger/config"]
+	require.Equal(t, "30", trigger.PayloadPress)
+
+	armed := configs["homeassistant/switch/os_nvr/armed/config"]
+	require.Equal(t, "nvr/armed/set", armed.CommandTopic)
+
+	panel := configs["homeassistant/alarm_control_panel/os_nvr/arming/config"]
+	require.Equal(t, "nvr/arming", panel.StateTopic)
+	require.Equal(t, "nvr/arming/set", panel.CommandTopic)
+	require.Equal(t, "night", panel.PayloadArmNight)
+	require.Equal(t, []string{"arm_home", "arm_away", "arm_night"}, panel.SupportedFeatures)
+}
+
+func TestSensors(t *testing.T) {
+	b := newTestHABridge(t, 0.2)
+	b.onEvent("a", storage.Event{Detections: []storage.Detection{{Label: "car"}}}, true)
+	b.onEvent("a", storage.Event{Detections: []storage.Detection{{Label: "person"}}}, true)
+
+	on := readQueue(b)
+	require.Equal(t, "ON", on["nvr/a/motion"])
+	require.Equal(t, "ON", on["nvr/a/occupancy"])
+	require.Empty(t, b.sensorResetMessages("a"))
+
+	var messages []message
+	for len(messages) < 2 {
+		messages = append(messages, <-b.queue)
+	}
+	for _, m := range messages {
+		require.Equal(t, "OFF", string(m.payload))
+	}
+	require.Len(t, b.sensorResetMessages("a"), 2)
+}
+
+func TestHomeAssistantStatus(t *testing.T) {
+	b := newTestHABridge(t, 30)
+	require.Contains(t, b.commandTopics(), "homeassistant/status")
+
+	err := b.handleCommand(message{topic: "homeassistant/status", payload: []byte("online")})
+	require.NoError(t, err)
+	err = b.handleCommand(message{topic: "homeassistant/status", payload: []byte("online")})
+	require.NoError(t, err)
+	require.Len(t, b.republish, 1)
+}
+
+func TestRecordingThumbnail(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "2025-12-28_23-00-00_a")
+	require.NoError(t, os.WriteFile(filePath+".jpeg", []byte("jpeg"), 0o600))
+
+	b := newTestBridge(t)
+	b.onRecordingSaved("a", filePath, storage.RecordingData{
+		Start: time.Unix(1, 0).UTC(),
+		End:   time.Unix(2, 0).UTC(),
+	})
+	messages := readQueue(b)
+	require.Equal(t, "jpeg", messages["nvr/a/thumbnail"])
+	require.JSONEq(t, `{
+		"monitorId": "a",
+		"id": "2025-12-28_23-00-00_a",
+		"start": "1970-01-01T00:00:01Z",
+		"end": "1970-01-01T00:00:02Z"
+	}`, messages["nvr/a/recording"])
+}
@@ -0,0 +1,262 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetSubscribe  = 8
+	packetSuback     = 9
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// Larger packets from the broker are rejected.
+const maxPacketSize = 1 << 20
+
+// Packet errors.
+var (
+	ErrPacketTooLarge = errors.New("packet too large")
+	ErrMalformed      = errors.New("malformed packet")
+)
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func writePacket(w io.Writer, kind byte, flags byte, body []byte) error {
+	header := []byte{kind<<4 | flags&0x0f}
+	header = appendLength(header, len(body))
+	if _, err := w.Write(append(header, body...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// appendLength appends the variable length encoding of the remaining length.
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, fmt.Errorf("%w: remaining length", ErrMalformed)
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("%w: %v", ErrPacketTooLarge, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{
+		kind:  header >> 4,
+		flags: header & 0x0f,
+		body:  body,
+	}, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readUint16(b []byte) (uint16, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, ErrMalformed
+	}
+	return binary.BigEndian.Uint16(b), b[2:], nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	n, b, err := readUint16(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(b) < int(n) {
+		return "", nil, ErrMalformed
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+// Connect flags.
+const (
+	connectCleanSession = 0x02
+	connectWill         = 0x04
+	connectWillRetain   = 0x20
+	connectPassword     = 0x40
+	connectUsername     = 0x80
+)
+
+func connectBody(opts connectOptions) []byte {
+	var flags byte = connectCleanSession
+	if opts.will != nil {
+		flags |= connectWill
+		if opts.will.retain {
+			flags |= connectWillRetain
+		}
+	}
+	if opts.username != "" {
+		flags |= connectUsername
+		if opts.password != "" {
+			flags |= connectPassword
+		}
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags) // Protocol level 4 is MQTT 3.1.1.
+	b = appendUint16(b, uint16(opts.keepAlive.Seconds()))
+	b = appendString(b, opts.clientID)
+	if opts.will != nil {
+		b = appendString(b, opts.will.topic)
+		b = appendUint16(b, uint16(len(opts.will.payload)))
+		b = append(b, opts.will.payload...)
+	}
+	if flags&connectUsername != 0 {
+		b = appendString(b, opts.username)
+	}
+	if flags&connectPassword != 0 {
+		b = appendString(b, opts.password)
+	}
+	return b
+}
+
+// Connack errors by return code.
+var connackErrors = map[byte]error{
+	1: errors.New("unacceptable protocol version"),
+	2: errors.New("identifier rejected"),
+	3: errors.New("server unavailable"),
+	4: errors.New("bad user name or password"),
+	5: errors.New("not authorized"),
+}
+
+// ErrConnectionRefused the broker refused the connection.
+var ErrConnectionRefused = errors.New("connection refused")
+
+func parseConnack(p *packet) error {
+	if p.kind != packetConnack || len(p.body) != 2 {
+		return fmt.Errorf("%w: expected connack", ErrMalformed)
+	}
+	code := p.body[1]
+	if code == 0 {
+		return nil
+	}
+	if err, exist := connackErrors[code]; exist {
+		return fmt.Errorf("%w: %v", ErrConnectionRefused, err)
+	}
+	return fmt.Errorf("%w: return code %v", ErrConnectionRefused, code)
+}
+
+// Publish packets are always sent with QoS 0.
+func publishBody(m message) (byte, []byte) {
+	var flags byte
+	if m.retain {
+		flags |= 0x01
+	}
+	b := appendString(nil, m.topic)
+	return flags, append(b, m.payload...)
+}
+
+// parsePublish returns the message and the packet
+// identifier, the identifier is zero for QoS 0.
+func parsePublish(p *packet) (message, uint16, error) {
+	topic, b, err := readString(p.body)
+	if err != nil {
+		return message{}, 0, err
+	}
+	var id uint16
+	if qos := (p.flags >> 1) & 0x03; qos > 0 {
+		if id, b, err = readUint16(b); err != nil {
+			return message{}, 0, err
+		}
+	}
+	return message{
+		topic:   topic,
+		payload: b,
+		retain:  p.flags&0x01 != 0,
+	}, id, nil
+}
+
+// Topic filters are subscribed with QoS 0.
+func subscribeBody(id uint16, filters []string) []byte {
+	b := appendUint16(nil, id)
+	for _, f := range filters {
+		b = appendString(b, f)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// ErrSubscribeFailed the broker rejected a subscription.
+var ErrSubscribeFailed = errors.New("subscribe failed")
+
+func parseSuback(p *packet) error {
+	_, codes, err := readUint16(p.body)
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if code == 0x80 {
+			return ErrSubscribeFailed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, maxPacketSize} {
+		var buf bytes.Buffer
+		body := make([]byte, n)
+		require.NoError(t, writePacket(&buf, packetPublish, 0x01, body))
+
+		p, err := readPacket(bufio.NewReader(&buf))
+		require.NoError(t, err)
+		require.Equal(t, byte(packetPublish), p.kind)
+		require.Equal(t, byte(0x01), p.flags)
+		require.Len(t, p.body, n)
+	}
+}
+
+func TestReadPacketErrors(t *testing.T) {
+	cases := map[string]struct {
+		input []byte
+		err   error
+	}{
+		"length": {[]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, ErrMalformed},
+		"size":   {[]byte{0x30, 0xff, 0xff, 0xff, 0x7f}, ErrPacketTooLarge},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := readPacket(bufio.NewReader(bytes.NewReader(tc.input)))
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestConnectBody(t *testing.T) {
+	body := connectBody(connectOptions{
+		clientID:  "id",
+		username:  "u",
+		password:  "p",
+		keepAlive: 60 * time.Second,
+		will:      &message{topic: "t", payload: []byte("x"), retain: true},
+	})
+	expected := []byte{
+		0, 4, 'M', 'Q', 'T', 'T',
+		4,    // Protocol level.
+		0xe6, // Flags.
+		0, 60,
+		0, 2, 'i', 'd',
+		0, 1, 't',
+		0, 1, 'x',
+		0, 1, 'u',
+		0, 1, 'p',
+	}
+	require.Equal(t, expected, body)
+}
+
+func TestParseConnack(t *testing.T) {
+	cases := map[string]struct {
+		body []byte
+		err  error
+	}{
+		"ok":       {[]byte{0, 0}, nil},
+		"refused":  {[]byte{0, 4}, ErrConnectionRefused},
+		"unknown":  {[]byte{0, 9}, ErrConnectionRefused},
+		"tooShort": {[]byte{0}, ErrMalformed},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := parseConnack(&packet{kind: packetConnack, body: tc.body})
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	t.Run("qos0", func(t *testing.T) {
+		flags, body := publishBody(message{topic: "a/b", payload: []byte("on"), retain: true})
+		m, id, err := parsePublish(&packet{kind: packetPublish, flags: flags, body: body})
+		require.NoError(t, err)
+		require.Equal(t, message{topic: "a/b", payload: []byte("on"), retain: true}, m)
+		require.Equal(t, uint16(0), id)
+	})
+	t.Run("qos1", func(t *testing.T) {
+		body := []byte{0, 1, 'a', 0, 7, 'x'}
+		m, id, err := parsePublish(&packet{kind: packetPublish, flags: 0x02, body: body})
+		require.NoError(t, err)
+		require.Equal(t, "a", m.topic)
+		require.Equal(t, []byte("x"), m.payload)
+		require.Equal(t, uint16(7), id)
+	})
+	t.Run("malformed", func(t *testing.T) {
+		_, _, err := parsePublish(&packet{kind: packetPublish, body: []byte{0, 5, 'a'}})
+		require.ErrorIs(t, err, ErrMalformed)
+	})
+}
+
+func TestParseSuback(t *testing.T) {
+	require.NoError(t, parseSuback(&packet{body: []byte{0, 1, 0, 0}}))
+	require.ErrorIs(t, parseSuback(&packet{body: []byte{0, 1, 0, 0x80}}), ErrSubscribeFailed)
+}
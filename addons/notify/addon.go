@@ -0,0 +1,190 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"nvr"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/web"
+	"sync"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"notify"})
+	nvr.RegisterAppRunHook(onAppRun)
+	nvr.RegisterMonitorEventHook(onEvent)
+	nvr.RegisterMonitorRecSavedHook(onRecSaved)
+}
+
+var addon struct {
+	d       *dispatcher
+	baseURL string
+	mu      sync.Mutex
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("notify: config: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "notify",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	rules, err := newRules(*config, app.Env.Crypt)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	t, err := newThrottle(*config)
+	if err != nil {
+		return fmt.Errorf("notify: %w", err)
+	}
+	d := newDispatcher(rules, t, logf)
+	d.run(ctx, app.WG)
+
+	bots := newTelegramBots(config.Telegram, app.Arming, app.MonitorsInfo, app.Snapshot, logf)
+	for _, bot := range bots {
+		app.WG.Add(1)
+		go func(bot *telegramBot) {
+			defer app.WG.Done()
+			bot.run(ctx)
+		}(bot)
+	}
+
+	addon.mu.Lock()
+	addon.d = d
+	addon.baseURL = config.BaseURL
+	addon.mu.Unlock()
+
+	app.API.Handle("/api/notify/deliveries", app.Auth.Admin(serveDeliveries(d)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/notify/deliveries",
+			Summary: "Latest notification deliveries.",
+			Admin:   true,
+		},
+	)
+	return nil
+}
+
+func newRules(c Config, crypt *storage.Crypt) ([]*rule, error) {
+	var rules []*rule
+	for _, w := range c.Webhooks {
+		s, err := newWebhook(w)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %q: %w", w.Name, err)
+		}
+		rules = append(rules, newRule(w.Name, "webhook", w.Filter, s))
+	}
+	for _, n := range c.Ntfy {
+		s, err := newNtfy(n)
+		if err != nil {
+			return nil, fmt.Errorf("ntfy %q: %w", n.Name, err)
+		}
+		rules = append(rules, newRule(n.Name, "ntfy", n.Filter, s))
+	}
+	for _, g := range c.Gotify {
+		s, err := newGotify(g)
+		if err != nil {
+			return nil, fmt.Errorf("gotify %q: %w", g.Name, err)
+		}
+		rules = append(rules, newRule(g.Name, "gotify", g.Filter, s))
+	}
+	for _, p := range c.Pushover {
+		s, err := newPushover(p)
+		if err != nil {
+			return nil, fmt.Errorf("pushover %q: %w", p.Name, err)
+		}
+		rules = append(rules, newRule(p.Name, "pushover", p.Filter, s))
+	}
+	for _, m := range c.SMTP {
+		s, err := newSMTP(m, crypt)
+		if err != nil {
+			return nil, fmt.Errorf("smtp %q: %w", m.Name, err)
+		}
+		rules = append(rules, newRule(m.Name, "smtp", m.Filter, s))
+	}
+	for _, t := range c.Telegram {
+		s, err := newTelegram(t, crypt)
+		if err != nil {
+			return nil, fmt.Errorf("telegram %q: %w", t.Name, err)
+		}
+		rules = append(rules, newRule(t.Name, "telegram", t.Filter, s))
+	}
+	return rules, nil
+}
+
+func onEvent(r *monitor.Recorder, event *storage.Event) {
+	addon.mu.Lock()
+	d := addon.d
+	addon.mu.Unlock()
+	if d == nil {
+		return
+	}
+	if r.ArmAction() != arming.ActionAlert {
+		return
+	}
+	d.onEvent(newNotification(r.Config.ID(), r.Config.Name(), *event))
+}
+
+func onRecSaved(r *monitor.Recorder, filePath string, data storage.RecordingData) {
+	addon.mu.Lock()
+	d, baseURL := addon.d, addon.baseURL
+	addon.mu.Unlock()
+	if d == nil {
+		return
+	}
+	// Continuous recordings.
+	if !hasDetections(data.Events) || r.ArmAction() != arming.ActionAlert {
+		return
+	}
+	d.onRecording(newRecordingNotification(
+		r.Config.ID(), r.Config.Name(), filePath, data, baseURL))
+}
+
+func hasDetections(events []storage.Event) bool {
+	for _, e := range events {
+		if len(e.Detections) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func serveDeliveries(d *dispatcher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.recentDeliveries()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
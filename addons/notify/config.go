@@ -0,0 +1,498 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"nvr/pkg/schedule"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// Config global addon config.
+type Config struct {
+	// Public url of the NVR, used for the links
+	// to the recordings. No links if empty.
+	BaseURL string `json:"baseUrl"`
+
+	Webhooks []WebhookConfig  `json:"webhooks"`
+	Ntfy     []NtfyConfig     `json:"ntfy"`
+	Gotify   []GotifyConfig   `json:"gotify"`
+	Pushover []PushoverConfig `json:"pushover"`
+	SMTP     []SMTPConfig     `json:"smtp"`
+	Telegram []TelegramConfig `json:"telegram"`
+
+	// Notifications are dropped during quiet hours.
+	QuietHours []QuietHours `json:"quietHours"`
+
+	// Maximum number of notifications within a hour for all
+	// rules of a kind, for example {"ntfy": 20}. Zero is unlimited.
+	ChannelLimits map[string]int `json:"channelLimits"`
+}
+
+// QuietHours period when notifications are dropped.
+type QuietHours struct {
+	schedule.Range
+
+	// Rule names, all rules if empty.
+	Rules []string `json:"rules"`
+}
+
+// Filter selects the events that trigger a notification.
+// Empty lists match all monitors and labels.
+type Filter struct {
+	Monitors []string `json:"monitors"`
+	Labels   []string `json:"labels"`
+
+	// At least one detection matching the labels must have this score.
+	MinScore float64 `json:"minScore"`
+
+	// Seconds between notifications for each monitor.
+	Cooldown float64 `json:"cooldown"`
+
+	// Number of retries after a failed delivery, the
+	// delay starts at 1 second and doubles each time.
+	Retries int `json:"retries"`
+
+	// Send the notification after the recording is saved instead
+	// of on the first event, the thumbnail and links are included.
+	AfterRecording bool `json:"afterRecording"`
+
+	// Maximum number of notifications within a hour
+	// for all monitors, zero is unlimited.
+	MaxPerHour int `json:"maxPerHour"`
+}
+
+// WebhookConfig sends a HTTP request for each notification.
+type WebhookConfig struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+
+	// Go template of the request body, the
+	// default JSON payload is sent if empty.
+	Template string `json:"template"`
+
+	Filter
+}
+
+// PushText Go templates of the push notification text.
+// Defaults to the monitor name and the best detection.
+type PushText struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// NtfyConfig publishes to a ntfy topic.
+type NtfyConfig struct {
+	Name string `json:"name"`
+
+	// Defaults to "https://ntfy.sh".
+	Server string `json:"server"`
+	Topic  string `json:"topic"`
+
+	// Access token, optional.
+	Token string `json:"token"`
+
+	// 1-5, the server default is used if zero.
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags"`
+
+	PushText
+	Filter
+}
+
+// GotifyConfig sends a message to a Gotify server.
+type GotifyConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Application token.
+	Token    string `json:"token"`
+	Priority int    `json:"priority"`
+
+	PushText
+	Filter
+}
+
+// PushoverConfig sends a message with the Pushover API.
+type PushoverConfig struct {
+	Name string `json:"name"`
+
+	// Application token.
+	Token string `json:"token"`
+
+	// User or group key.
+	User string `json:"user"`
+
+	// Device names, all devices if empty.
+	Devices []string `json:"devices"`
+
+	// -2 to 2, emergency messages are repeated
+	// every minute for an hour until acknowledged.
+	Priority int    `json:"priority"`
+	Sound    string `json:"sound"`
+
+	PushText
+	Filter
+}
+
+// SMTPConfig sends a email for each notification.
+type SMTPConfig struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+
+	// Defaults to 587 for STARTTLS, 465 for TLS and 25 without encryption.
+	Port int `json:"port"`
+
+	// "starttls", "tls" or "none". Defaults to "starttls".
+	Security string `json:"security"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	From string   `json:"from"`
+	To   []string `json:"to"`
+
+	// Go templates, defaults to the push notification text.
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+
+	// Attach the recording if it's smaller than max clip size.
+	AttachClip bool `json:"attachClip"`
+
+	// Megabytes, defaults to 10.
+	MaxClipSize float64 `json:"maxClipSize"`
+
+	Filter
+}
+
+// TelegramConfig sends messages with a Telegram bot.
+type TelegramConfig struct {
+	Name string `json:"name"`
+
+	// Bot token from BotFather.
+	Token string `json:"token"`
+
+	// Chat, group or channel ID.
+	ChatID string `json:"chatId"`
+
+	// Bot API server, defaults to "https://api.telegram.org".
+	Server string `json:"server"`
+
+	// Send the recording instead of the thumbnail
+	// if it's smaller than max clip size.
+	AttachClip bool `json:"attachClip"`
+
+	// Megabytes, defaults to 10. Bots can't upload more than 50.
+	MaxClipSize float64 `json:"maxClipSize"`
+
+	// Accept commands from the chat, for example "/snapshot garage".
+	Commands bool `json:"commands"`
+
+	PushText
+	Filter
+}
+
+// SMTP security modes.
+const (
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"
+	smtpNone     = "none"
+)
+
+// Config errors.
+var (
+	ErrNoName       = errors.New("name is empty")
+	ErrDuplicate    = errors.New("duplicate name")
+	ErrInvalidURL   = errors.New("invalid url")
+	ErrInvalidScore = errors.New("invalid min score")
+	ErrInvalidValue = errors.New("invalid value")
+	ErrMissingValue = errors.New("missing value")
+)
+
+func (c Config) validate() error {
+	if c.BaseURL != "" {
+		if err := validateURL(c.BaseURL); err != nil {
+			return fmt.Errorf("base url: %w", err)
+		}
+	}
+
+	names := make(map[string]struct{})
+	check := func(kind string, name string, validate func() error) error {
+		if _, exist := names[name]; exist {
+			return fmt.Errorf("%v: %w: %q", kind, ErrDuplicate, name)
+		}
+		names[name] = struct{}{}
+		if name == "" {
+			return fmt.Errorf("%v: %w", kind, ErrNoName)
+		}
+		if err := validate(); err != nil {
+			return fmt.Errorf("%v %q: %w", kind, name, err)
+		}
+		return nil
+	}
+	for _, w := range c.Webhooks {
+		if err := check("webhook", w.Name, w.validate); err != nil {
+			return err
+		}
+	}
+	for _, n := range c.Ntfy {
+		if err := check("ntfy", n.Name, n.validate); err != nil {
+			return err
+		}
+	}
+	for _, g := range c.Gotify {
+		if err := check("gotify", g.Name, g.validate); err != nil {
+			return err
+		}
+	}
+	for _, p := range c.Pushover {
+		if err := check("pushover", p.Name, p.validate); err != nil {
+			return err
+		}
+	}
+	for _, m := range c.SMTP {
+		if err := check("smtp", m.Name, m.validate); err != nil {
+			return err
+		}
+	}
+	for _, t := range c.Telegram {
+		if err := check("telegram", t.Name, t.validate); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range c.QuietHours {
+		if _, err := newQuietPeriod(q); err != nil {
+			return fmt.Errorf("quiet hours: %w", err)
+		}
+		for _, name := range q.Rules {
+			if _, exist := names[name]; !exist {
+				return fmt.Errorf("quiet hours: %w: rule: %q", ErrInvalidValue, name)
+			}
+		}
+	}
+	for channel, max := range c.ChannelLimits {
+		if !contains(channels, channel) {
+			return fmt.Errorf("channel limits: %w: channel: %q", ErrInvalidValue, channel)
+		}
+		if max < 0 {
+			return fmt.Errorf("channel limits: %w: %v: %v", ErrInvalidValue, channel, max)
+		}
+	}
+	return nil
+}
+
+// Destination kinds.
+var channels = []string{"webhook", "ntfy", "gotify", "pushover", "smtp", "telegram"}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidURL, rawURL)
+	}
+	return nil
+}
+
+func (w WebhookConfig) validate() error {
+	if err := validateURL(w.URL); err != nil {
+		return err
+	}
+	if w.Template != "" {
+		if _, err := parseTemplate(w.Name, w.Template); err != nil {
+			return err
+		}
+	}
+	return w.Filter.validate()
+}
+
+func (n NtfyConfig) validate() error {
+	if n.Server != "" {
+		if err := validateURL(n.Server); err != nil {
+			return err
+		}
+	}
+	if n.Topic == "" {
+		return fmt.Errorf("%w: topic", ErrMissingValue)
+	}
+	if n.Priority < 0 || n.Priority > 5 {
+		return fmt.Errorf("%w: priority: %v", ErrInvalidValue, n.Priority)
+	}
+	if _, err := newPushText(n.Name, n.PushText); err != nil {
+		return err
+	}
+	return n.Filter.validate()
+}
+
+func (g GotifyConfig) validate() error {
+	if err := validateURL(g.URL); err != nil {
+		return err
+	}
+	if g.Token == "" {
+		return fmt.Errorf("%w: token", ErrMissingValue)
+	}
+	if g.Priority < 0 {
+		return fmt.Errorf("%w: priority: %v", ErrInvalidValue, g.Priority)
+	}
+	if _, err := newPushText(g.Name, g.PushText); err != nil {
+		return err
+	}
+	return g.Filter.validate()
+}
+
+func (p PushoverConfig) validate() error {
+	if p.Token == "" {
+		return fmt.Errorf("%w: token", ErrMissingValue)
+	}
+	if p.User == "" {
+		return fmt.Errorf("%w: user", ErrMissingValue)
+	}
+	if p.Priority < -2 || p.Priority > 2 {
+		return fmt.Errorf("%w: priority: %v", ErrInvalidValue, p.Priority)
+	}
+	if _, err := newPushText(p.Name, p.PushText); err != nil {
+		return err
+	}
+	return p.Filter.validate()
+}
+
+func (m SMTPConfig) validate() error {
+	if m.Host == "" {
+		return fmt.Errorf("%w: host", ErrMissingValue)
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("%w: port: %v", ErrInvalidValue, m.Port)
+	}
+	switch m.Security {
+	case "", smtpStartTLS, smtpTLS, smtpNone:
+	default:
+		return fmt.Errorf("%w: security: %q", ErrInvalidValue, m.Security)
+	}
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("%w: from: %q", ErrInvalidValue, m.From)
+	}
+	if len(m.To) == 0 {
+		return fmt.Errorf("%w: to", ErrMissingValue)
+	}
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("%w: to: %q", ErrInvalidValue, to)
+		}
+	}
+	if m.MaxClipSize < 0 {
+		return fmt.Errorf("%w: max clip size: %v", ErrInvalidValue, m.MaxClipSize)
+	}
+	if _, err := newPushText(m.Name, PushText{Title: m.Subject, Message: m.Body}); err != nil {
+		return err
+	}
+	return m.Filter.validate()
+}
+
+func (t TelegramConfig) validate() error {
+	if t.Token == "" {
+		return fmt.Errorf("%w: token", ErrMissingValue)
+	}
+	if t.ChatID == "" {
+		return fmt.Errorf("%w: chat id", ErrMissingValue)
+	}
+	if t.Server != "" {
+		if err := validateURL(t.Server); err != nil {
+			return err
+		}
+	}
+	if t.MaxClipSize < 0 || t.MaxClipSize > telegramMaxUpload/1000000 {
+		return fmt.Errorf("%w: max clip size: %v", ErrInvalidValue, t.MaxClipSize)
+	}
+	if _, err := newPushText(t.Name, t.PushText); err != nil {
+		return err
+	}
+	return t.Filter.validate()
+}
+
+func (f Filter) validate() error {
+	if f.MinScore < 0 || f.MinScore > 100 {
+		return fmt.Errorf("%w: %v", ErrInvalidScore, f.MinScore)
+	}
+	if f.Cooldown < 0 {
+		return fmt.Errorf("%w: cooldown: %v", ErrInvalidValue, f.Cooldown)
+	}
+	if f.Retries < 0 {
+		return fmt.Errorf("%w: retries: %v", ErrInvalidValue, f.Retries)
+	}
+	if f.MaxPerHour < 0 {
+		return fmt.Errorf("%w: max per hour: %v", ErrInvalidValue, f.MaxPerHour)
+	}
+	return nil
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	tpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse template: %w", err)
+	}
+	return tpl, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes the value, strings are quoted and escaped.
+	"json": func(v interface{}) (string, error) {
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	},
+}
+
+func defaultConfig() Config {
+	return Config{
+		Webhooks: []WebhookConfig{},
+		Ntfy:     []NtfyConfig{},
+		Gotify:   []GotifyConfig{},
+		Pushover: []PushoverConfig{},
+		SMTP:     []SMTPConfig{},
+		Telegram: []TelegramConfig{},
+
+		QuietHours:    []QuietHours{},
+		ChannelLimits: map[string]int{},
+	}
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "notify.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		data, _ := json.MarshalIndent(defaultConfig(), "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nvr/pkg/schedule"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]struct {
+		webhook     func(*WebhookConfig)
+		expectedErr error
+	}{
+		"ok":       {func(w *WebhookConfig) {}, nil},
+		"name":     {func(w *WebhookConfig) { w.Name = "" }, ErrNoName},
+		"url":      {func(w *WebhookConfig) { w.URL = "ftp://x" }, ErrInvalidURL},
+		"noHost":   {func(w *WebhookConfig) { w.URL = "http://" }, ErrInvalidURL},
+		"score":    {func(w *WebhookConfig) { w.MinScore = 101 }, ErrInvalidScore},
+		"cooldown": {func(w *WebhookConfig) { w.Cooldown = -1 }, ErrInvalidValue},
+		"retries":  {func(w *WebhookConfig) { w.Retries = -1 }, ErrInvalidValue},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := WebhookConfig{Name: "a", URL: "https://x/hook"}
+			tc.webhook(&w)
+			err := Config{Webhooks: []WebhookConfig{w}}.validate()
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+	t.Run("template", func(t *testing.T) {
+		w := WebhookConfig{Name: "a", URL: "https://x", Template: "{{"}
+		require.Error(t, Config{Webhooks: []WebhookConfig{w}}.validate())
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		w := WebhookConfig{Name: "a", URL: "https://x"}
+		err := Config{Webhooks: []WebhookConfig{w, w}}.validate()
+		require.ErrorIs(t, err, ErrDuplicate)
+	})
+	t.Run("duplicateKinds", func(t *testing.T) {
+		err := Config{
+			Webhooks: []WebhookConfig{{Name: "a", URL: "https://x"}},
+			Ntfy:     []NtfyConfig{{Name: "a", Topic: "x"}},
+		}.validate()
+		require.ErrorIs(t, err, ErrDuplicate)
+	})
+	t.Run("baseURL", func(t *testing.T) {
+		err := Config{BaseURL: "nvr.local"}.validate()
+		require.ErrorIs(t, err, ErrInvalidURL)
+	})
+}
+
+func TestPushConfigValidate(t *testing.T) {
+	cases := map[string]struct {
+		config      Config
+		expectedErr error
+	}{
+		"ntfy":             {Config{Ntfy: []NtfyConfig{{Name: "a", Topic: "x"}}}, nil},
+		"ntfyServer":       {Config{Ntfy: []NtfyConfig{{Name: "a", Topic: "x", Server: "x"}}}, ErrInvalidURL},
+		"ntfyTopic":        {Config{Ntfy: []NtfyConfig{{Name: "a"}}}, ErrMissingValue},
+		"ntfyPriority":     {Config{Ntfy: []NtfyConfig{{Name: "a", Topic: "x", Priority: 6}}}, ErrInvalidValue},
+		"ntfyName":         {Config{Ntfy: []NtfyConfig{{Topic: "x"}}}, ErrNoName},
+		"gotify":           {Config{Gotify: []GotifyConfig{{Name: "a", URL: "http://x", Token: "t"}}}, nil},
+		"gotifyURL":        {Config{Gotify: []GotifyConfig{{Name: "a", Token: "t"}}}, ErrInvalidURL},
+		"gotifyToken":      {Config{Gotify: []GotifyConfig{{Name: "a", URL: "http://x"}}}, ErrMissingValue},
+		"pushover":         {Config{Pushover: []PushoverConfig{{Name: "a", Token: "t", User: "u"}}}, nil},
+		"pushoverUser":     {Config{Pushover: []PushoverConfig{{Name: "a", Token: "t"}}}, ErrMissingValue},
+		"pushoverPriority": {Config{Pushover: []PushoverConfig{{Name: "a", Token: "t", User: "u", Priority: 3}}}, ErrInvalidValue},
+		"smtp":             {Config{SMTP: []SMTPConfig{{Name: "a", Host: "h", From: "a@b", To: []string{"c@d"}}}}, nil},
+		"smtpHost":         {Config{SMTP: []SMTPConfig{{Name: "a", From: "a@b", To: []string{"c@d"}}}}, ErrMissingValue},
+		"smtpFrom":         {Config{SMTP: []SMTPConfig{{Name: "a", Host: "h", From: "x", To: []string{"c@d"}}}}, ErrInvalidValue},
+		"smtpTo":           {Config{SMTP: []SMTPConfig{{Name: "a", Host: "h", From: "a@b"}}}, ErrMissingValue},
+		"smtpSecurity":     {Config{SMTP: []SMTPConfig{{Name: "a", Host: "h", From: "a@b", To: []string{"c@d"}, Security: "x"}}}, ErrInvalidValue},
+		"maxPerHour":       {Config{Ntfy: []NtfyConfig{{Name: "a", Topic: "x", Filter: Filter{MaxPerHour: -1}}}}, ErrInvalidValue},
+		"telegram":         {Config{Telegram: []TelegramConfig{{Name: "a", Token: "t", ChatID: "1"}}}, nil},
+		"telegramToken":    {Config{Telegram: []TelegramConfig{{Name: "a", ChatID: "1"}}}, ErrMissingValue},
+		"telegramChatID":   {Config{Telegram: []TelegramConfig{{Name: "a", Token: "t"}}}, ErrMissingValue},
+		"telegramServer":   {Config{Telegram: []TelegramConfig{{Name: "a", Token: "t", ChatID: "1", Server: "x"}}}, ErrInvalidURL},
+		"telegramClipSize": {Config{Telegram: []TelegramConfig{{Name: "a", Token: "t", ChatID: "1", MaxClipSize: 51}}}, ErrInvalidValue},
+		"quietHours": {
+			Config{QuietHours: []QuietHours{{Range: schedule.Range{Start: "22:00", End: "07:00", Days: []string{"mon"}}}}}, nil,
+		},
+		"quietHoursTime": {
+			Config{QuietHours: []QuietHours{{Range: schedule.Range{Start: "25:00", End: "07:00"}}}}, schedule.ErrInvalidTime,
+		},
+		"quietHoursDay": {
+			Config{QuietHours: []QuietHours{{Range: schedule.Range{Start: "22:00", End: "07:00", Days: []string{"x"}}}}}, schedule.ErrInvalidDay,
+		},
+		"quietHoursRule": {
+			Config{QuietHours: []QuietHours{{Range: schedule.Range{Start: "22:00", End: "07:00"}, Rules: []string{"x"}}}}, ErrInvalidValue,
+		},
+		"channelLimits":         {Config{ChannelLimits: map[string]int{"ntfy": 10}}, nil},
+		"channelLimitsKind":     {Config{ChannelLimits: map[string]int{"x": 10}}, ErrInvalidValue},
+		"channelLimitsNegative": {Config{ChannelLimits: map[string]int{"ntfy": -1}}, ErrInvalidValue},
+		"pushoverFilter": {
+			Config{Pushover: []PushoverConfig{{Name: "a", Token: "t", User: "u", Filter: Filter{MinScore: -1}}}},
+			ErrInvalidScore,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, tc.config.validate(), tc.expectedErr)
+		})
+	}
+	t.Run("template", func(t *testing.T) {
+		n := NtfyConfig{Name: "a", Topic: "x", PushText: PushText{Message: "{{"}}
+		require.Error(t, Config{Ntfy: []NtfyConfig{n}}.validate())
+	})
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, defaultConfig(), *config)
+
+	data := `{"webhooks": [{"name": "a", "url": "http://x", "labels": ["person"], "retries": 2}]}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notify.json"), []byte(data), 0o600))
+	config, err = readConfig(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"person"}, config.Webhooks[0].Labels)
+	require.Equal(t, 2, config.Webhooks[0].Retries)
+}
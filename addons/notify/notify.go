@@ -0,0 +1,418 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification template data.
+type Notification struct {
+	MonitorID   string              `json:"monitorId"`
+	MonitorName string              `json:"monitorName"`
+	Time        time.Time           `json:"time"`
+	Label       string              `json:"label"` // Best detection.
+	Score       float64             `json:"score"`
+	Detections  []storage.Detection `json:"detections"`
+
+	// Only set for notifications sent after the recording is saved.
+	RecordingID  string `json:"recordingId,omitempty"`
+	ClipURL      string `json:"clipUrl,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+
+	// Number of notifications that were dropped by quiet
+	// hours or rate limits since the previous notification.
+	Suppressed int `json:"suppressed,omitempty"`
+
+	// Path of the recording without extension.
+	recordingPath string
+}
+
+func newNotification(monitorID string, monitorName string, e storage.Event) Notification {
+	best := bestDetection(e.Detections)
+	return Notification{
+		MonitorID:   monitorID,
+		MonitorName: monitorName,
+		Time:        e.Time,
+		Label:       best.Label,
+		Score:       best.Score,
+		Detections:  e.Detections,
+	}
+}
+
+// newRecordingNotification creates a notification for a saved recording
+// from the detections of all the events. The urls are empty if the base
+// url isn't set.
+func newRecordingNotification(
+	monitorID string,
+	monitorName string,
+	recordingPath string,
+	data storage.RecordingData,
+	baseURL string,
+) Notification {
+	var detections []storage.Detection
+	for _, e := range data.Events {
+		detections = append(detections, e.Detections...)
+	}
+	best := bestDetection(detections)
+
+	recordingID := filepath.Base(recordingPath)
+	n := Notification{
+		MonitorID:     monitorID,
+		MonitorName:   monitorName,
+		Time:          data.Start,
+		Label:         best.Label,
+		Score:         best.Score,
+		Detections:    detections,
+		RecordingID:   recordingID,
+		recordingPath: recordingPath,
+	}
+	if baseURL != "" {
+		baseURL = strings.TrimSuffix(baseURL, "/")
+		n.ClipURL = baseURL + "/api/recording/video/" + recordingID
+		n.ThumbnailURL = baseURL + "/api/recording/thumbnail/" + recordingID
+	}
+	return n
+}
+
+func bestDetection(detections []storage.Detection) storage.Detection {
+	var best storage.Detection
+	for _, d := range detections {
+		if d.Score > best.Score || best.Label == "" {
+			best = d
+		}
+	}
+	return best
+}
+
+// thumbnail returns the JPEG thumbnail of the recording,
+// nil if the notification isn't for a recording.
+func (n Notification) thumbnail() ([]byte, error) {
+	if n.recordingPath == "" {
+		return nil, nil
+	}
+	thumbnail, err := os.ReadFile(n.recordingPath + ".jpeg")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return thumbnail, err
+}
+
+type clip interface {
+	io.Reader
+	Size() int64
+	Close() error
+}
+
+// openClip opens the recording as a mp4 or Matroska file
+// and returns it with the file name and content type.
+func openClip(n Notification, crypt *storage.Crypt) (clip, string, string, error) {
+	var video clip
+	var filename, contentType string
+	var err error
+	if storage.IsMKVRecording(n.recordingPath) {
+		video, err = storage.OpenRecordingFile(n.recordingPath+".mkv", crypt)
+		filename, contentType = n.RecordingID+".mkv", "video/x-matroska"
+	} else {
+		video, err = storage.NewVideoReader(n.recordingPath, nil, crypt)
+		filename, contentType = n.RecordingID+".mp4", "video/mp4"
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%w: open clip: %v", ErrRejected, err)
+	}
+	return video, filename, contentType, nil
+}
+
+// sender delivers notifications to a single destination.
+type sender interface {
+	send(ctx context.Context, n Notification) error
+}
+
+// ErrRejected the destination rejected the notification, it isn't retried.
+var ErrRejected = errors.New("rejected")
+
+// Retry delays, the delay doubles after each attempt.
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+// Number of notifications that can wait for each rule.
+const queueSize = 100
+
+type rule struct {
+	name   string
+	kind   string
+	filter Filter
+	sender sender
+
+	queue chan Notification
+
+	// Time of the previous notification by monitor ID.
+	prev map[string]time.Time
+	mu   sync.Mutex
+
+	// Protected by the throttle mutex.
+	limit      *rateLimit
+	suppressed int
+}
+
+func newRule(name string, kind string, filter Filter, s sender) *rule {
+	return &rule{
+		name:   name,
+		kind:   kind,
+		filter: filter,
+		sender: s,
+		queue:  make(chan Notification, queueSize),
+		prev:   make(map[string]time.Time),
+		limit:  newRateLimit(filter.MaxPerHour),
+	}
+}
+
+// match returns true if the event matches the filter and
+// the monitor cooldown has passed, the cooldown is reset.
+func (r *rule) match(n Notification, now time.Time) bool {
+	f := r.filter
+	if len(f.Monitors) != 0 && !contains(f.Monitors, n.MonitorID) {
+		return false
+	}
+	if !f.matchDetections(n.Detections) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cooldown := time.Duration(f.Cooldown * float64(time.Second))
+	if prev, exist := r.prev[n.MonitorID]; exist && now.Sub(prev) < cooldown {
+		return false
+	}
+	r.prev[n.MonitorID] = now
+	return true
+}
+
+func (f Filter) matchDetections(detections []storage.Detection) bool {
+	if len(f.Labels) == 0 && f.MinScore == 0 {
+		return true
+	}
+	for _, d := range detections {
+		if len(f.Labels) != 0 && !contains(f.Labels, d.Label) {
+			continue
+		}
+		if d.Score >= f.MinScore {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery result of a notification.
+type Delivery struct {
+	Time      time.Time `json:"time"`
+	Rule      string    `json:"rule"`
+	Kind      string    `json:"kind"`
+	MonitorID string    `json:"monitorId"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Number of deliveries kept for the deliveries API.
+const deliveryLogSize = 100
+
+type dispatcher struct {
+	rules    []*rule
+	throttle *throttle
+	logf     log.Func
+	sleep    func(context.Context, time.Duration) bool
+
+	deliveries []Delivery
+	mu         sync.Mutex
+}
+
+func newDispatcher(rules []*rule, t *throttle, logf log.Func) *dispatcher {
+	return &dispatcher{
+		rules:    rules,
+		throttle: t,
+		logf:     logf,
+		sleep:    sleep,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run delivers the queued notifications until the context is canceled.
+func (d *dispatcher) run(ctx context.Context, wg *sync.WaitGroup) {
+	for _, r := range d.rules {
+		wg.Add(1)
+		go func(r *rule) {
+			defer wg.Done()
+			for {
+				select {
+				case n := <-r.queue:
+					d.deliver(ctx, r, n)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(r)
+	}
+}
+
+// onEvent queues the notification for the matching rules.
+func (d *dispatcher) onEvent(n Notification) {
+	d.dispatch(n, false)
+}
+
+// onRecording queues the notification for the
+// matching rules that wait for the recording.
+func (d *dispatcher) onRecording(n Notification) {
+	d.dispatch(n, true)
+}
+
+func (d *dispatcher) dispatch(n Notification, afterRecording bool) {
+	now := time.Now()
+	for _, r := range d.rules {
+		if r.filter.AfterRecording != afterRecording || !r.match(n, now) {
+			continue
+		}
+		suppressed, reason := d.throttle.allow(r, now)
+		if reason != "" {
+			d.logf(log.LevelDebug, "%v: %v, dropped notification", r.name, reason)
+			continue
+		}
+		n := n
+		n.Suppressed = suppressed
+		select {
+		case r.queue <- n:
+		default:
+			d.logf(log.LevelError, "%v: queue is full, dropped notification", r.name)
+		}
+	}
+}
+
+// deliver sends the notification and retries with a exponential
+// backoff until it succeeds, is rejected or the retries run out.
+func (d *dispatcher) deliver(ctx context.Context, r *rule, n Notification) {
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := r.sender.send(ctx, n)
+		if err == nil {
+			d.logf(log.LevelDebug, "%v: delivered notification: monitor:%v label:%v",
+				r.name, n.MonitorID, n.Label)
+			d.addDelivery(r, n, attempt, nil)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrRejected) || attempt > r.filter.Retries {
+			d.logf(log.LevelError, "%v: delivery failed after %v attempts: %v", r.name, attempt, err)
+			d.addDelivery(r, n, attempt, err)
+			return
+		}
+
+		d.logf(log.LevelWarning, "%v: delivery failed, retrying in %v: %v", r.name, delay, err)
+		if !d.sleep(ctx, delay) {
+			return
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (d *dispatcher) addDelivery(r *rule, n Notification, attempts int, err error) {
+	delivery := Delivery{
+		Time:      time.Now(),
+		Rule:      r.name,
+		Kind:      r.kind,
+		MonitorID: n.MonitorID,
+		Attempts:  attempts,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deliveries = append(d.deliveries, delivery)
+	if len(d.deliveries) > deliveryLogSize {
+		d.deliveries = d.deliveries[len(d.deliveries)-deliveryLogSize:]
+	}
+}
+
+// recentDeliveries returns the latest deliveries, newest first.
+func (d *dispatcher) recentDeliveries() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := make([]Delivery, 0, len(d.deliveries))
+	for i := len(d.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, d.deliveries[i])
+	}
+	return deliveries
+}
+
+// ErrStatus unexpected response status.
+var ErrStatus = errors.New("unexpected status")
+
+// statusError client errors other than too many requests are rejected.
+func statusError(status int) error {
+	if status >= 400 && status < 500 && status != 429 {
+		return fmt.Errorf("%w: status %v", ErrRejected, status)
+	}
+	return fmt.Errorf("%w: %v", ErrStatus, status)
+}
+
+// do sends the request and checks the response status.
+func do(client *http.Client, req *http.Request) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096)) //nolint:errcheck
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return statusError(res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNotification(t *testing.T) {
+	e := storage.Event{
+		Time: time.Unix(1, 0),
+		Detections: []storage.Detection{
+			{Label: "car", Score: 40},
+			{Label: "person", Score: 80},
+		},
+	}
+	n := newNotification("m1", "Door", e)
+	require.Equal(t, "person", n.Label)
+	require.Equal(t, float64(80), n.Score)
+	require.Equal(t, "Door", n.MonitorName)
+}
+
+func TestNewRecordingNotification(t *testing.T) {
+	data := storage.RecordingData{
+		Start: time.Unix(1, 0),
+		Events: []storage.Event{
+			{Detections: []storage.Detection{{Label: "car", Score: 40}}},
+			{Detections: []storage.Detection{{Label: "person", Score: 80}}},
+		},
+	}
+	n := newRecordingNotification("m1", "Door", "/rec/2025/12/28/m1/2025-12-28_23-00-00_m1", data, "https://nvr/")
+	require.Equal(t, "person", n.Label)
+	require.Len(t, n.Detections, 2)
+	require.Equal(t, time.Unix(1, 0), n.Time)
+	require.Equal(t, "2025-12-28_23-00-00_m1", n.RecordingID)
+	require.Equal(t, "https://nvr/api/recording/video/2025-12-28_23-00-00_m1", n.ClipURL)
+	require.Equal(t, "https://nvr/api/recording/thumbnail/2025-12-28_23-00-00_m1", n.ThumbnailURL)
+
+	n = newRecordingNotification("m1", "Door", "/rec/x", data, "")
+	require.Empty(t, n.ClipURL)
+}
+
+func TestRuleMatch(t *testing.T) {
+	person := Notification{
+		MonitorID:  "m1",
+		Detections: []storage.Detection{{Label: "person", Score: 60}},
+	}
+	cases := map[string]struct {
+		filter   Filter
+		expected bool
+	}{
+		"all":          {Filter{}, true},
+		"monitor":      {Filter{Monitors: []string{"m1"}}, true},
+		"otherMonitor": {Filter{Monitors: []string{"m2"}}, false},
+		"label":        {Filter{Labels: []string{"person"}}, true},
+		"otherLabel":   {Filter{Labels: []string{"car"}}, false},
+		"score":        {Filter{Labels: []string{"person"}, MinScore: 60}, true},
+		"lowScore":     {Filter{MinScore: 70}, false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := newRule("a", "webhook", tc.filter, nil)
+			require.Equal(t, tc.expected, r.match(person, time.Now()))
+		})
+	}
+	t.Run("cooldown", func(t *testing.T) {
+		r := newRule("a", "webhook", Filter{Cooldown: 10}, nil)
+		now := time.Now()
+		require.True(t, r.match(person, now))
+		require.False(t, r.match(person, now.Add(5*time.Second)))
+
+		other := person
+		other.MonitorID = "m2"
+		require.True(t, r.match(other, now.Add(5*time.Second)))
+		require.True(t, r.match(person, now.Add(10*time.Second)))
+	})
+}
+
+type stubSender struct {
+	errs  []error
+	calls int
+}
+
+func (s *stubSender) send(context.Context, Notification) error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func newTestDispatcher(r *rule) (*dispatcher, *[]time.Duration) {
+	d := newDispatcher([]*rule{r}, &throttle{}, func(log.Level, string, ...interface{}) {})
+	var delays []time.Duration
+	d.sleep = func(_ context.Context, delay time.Duration) bool {
+		delays = append(delays, delay)
+		return true
+	}
+	return d, &delays
+}
+
+func TestDeliver(t *testing.T) {
+	errMock := errors.New("mock")
+	n := Notification{MonitorID: "m1"}
+
+	t.Run("retry", func(t *testing.T) {
+		s := &stubSender{errs: []error{errMock, errMock}}
+		r := newRule("a", "webhook", Filter{Retries: 3}, s)
+		d, delays := newTestDispatcher(r)
+
+		d.deliver(context.Background(), r, n)
+		require.Equal(t, 3, s.calls)
+		require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+
+		deliveries := d.recentDeliveries()
+		require.Len(t, deliveries, 1)
+		require.Equal(t, 3, deliveries[0].Attempts)
+		require.Empty(t, deliveries[0].Error)
+	})
+	t.Run("retriesExhausted", func(t *testing.T) {
+		s := &stubSender{errs: []error{errMock, errMock, errMock}}
+		r := newRule("a", "webhook", Filter{Retries: 1}, s)
+		d, _ := newTestDispatcher(r)
+
+		d.deliver(context.Background(), r, n)
+		require.Equal(t, 2, s.calls)
+		require.Equal(t, "mock", d.recentDeliveries()[0].Error)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		s := &stubSender{errs: []error{statusError(400)}}
+		r := newRule("a", "webhook", Filter{Retries: 3}, s)
+		d, _ := newTestDispatcher(r)
+
+		d.deliver(context.Background(), r, n)
+		require.Equal(t, 1, s.calls)
+	})
+	t.Run("deliveryLog", func(t *testing.T) {
+		r := newRule("a", "webhook", Filter{}, &stubSender{})
+		d, _ := newTestDispatcher(r)
+		for i := 0; i < deliveryLogSize+5; i++ {
+			d.deliver(context.Background(), r, Notification{MonitorID: string(rune('a' + i%26))})
+		}
+		deliveries := d.recentDeliveries()
+		require.Len(t, deliveries, deliveryLogSize)
+		require.Equal(t, string(rune('a'+(deliveryLogSize+4)%26)), deliveries[0].MonitorID)
+	})
+}
+
+func TestStatusError(t *testing.T) {
+	require.ErrorIs(t, statusError(404), ErrRejected)
+	require.NotErrorIs(t, statusError(429), ErrRejected)
+	require.NotErrorIs(t, statusError(500), ErrRejected)
+	require.ErrorIs(t, statusError(500), ErrStatus)
+}
+
+func TestDispatcherRun(t *testing.T) {
+	sent := make(chan Notification)
+	r := newRule("a", "webhook", Filter{}, senderFunc(func(_ context.Context, n Notification) error {
+		sent <- n
+		return nil
+	}))
+	d := newDispatcher([]*rule{r}, &throttle{}, func(log.Level, string, ...interface{}) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	d.run(ctx, &wg)
+
+	d.onEvent(Notification{MonitorID: "m1"})
+	require.Equal(t, "m1", (<-sent).MonitorID)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestDispatchAfterRecording(t *testing.T) {
+	onEvent := newRule("a", "webhook", Filter{}, nil)
+	onRecording := newRule("b", "webhook", Filter{AfterRecording: true}, nil)
+	d := newDispatcher([]*rule{onEvent, onRecording}, &throttle{}, func(log.Level, string, ...interface{}) {})
+
+	d.onEvent(Notification{MonitorID: "m1"})
+	require.Len(t, onEvent.queue, 1)
+	require.Len(t, onRecording.queue, 0)
+
+	d.onRecording(Notification{MonitorID: "m1"})
+	require.Len(t, onEvent.queue, 1)
+	require.Len(t, onRecording.queue, 1)
+}
+
+type senderFunc func(context.Context, Notification) error
+
+func (f senderFunc) send(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
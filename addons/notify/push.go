@@ -0,0 +1,318 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Default push notification text.
+const (
+	defaultTitle   = "{{.MonitorName}}"
+	defaultMessage = `{{if .Label}}Detected {{.Label}} ({{printf "%.0f" .Score}}%)` +
+		`{{else}}Event{{end}} at {{.Time.Format "15:04:05"}}`
+)
+
+type pushText struct {
+	title   *template.Template
+	message *template.Template
+}
+
+func newPushText(name string, t PushText) (*pushText, error) {
+	if t.Title == "" {
+		t.Title = defaultTitle
+	}
+	if t.Message == "" {
+		t.Message = defaultMessage
+	}
+	title, err := parseTemplate(name, t.Title)
+	if err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+	message, err := parseTemplate(name, t.Message)
+	if err != nil {
+		return nil, fmt.Errorf("message: %w", err)
+	}
+	return &pushText{title: title, message: message}, nil
+}
+
+func (p *pushText) render(n Notification) (string, string, error) {
+	var title, message strings.Builder
+	if err := p.title.Execute(&title, n); err != nil {
+		return "", "", fmt.Errorf("%w: execute title template: %v", ErrRejected, err)
+	}
+	if err := p.message.Execute(&message, n); err != nil {
+		return "", "", fmt.Errorf("%w: execute message template: %v", ErrRejected, err)
+	}
+	return title.String(), message.String(), nil
+}
+
+func readThumbnail(n Notification) ([]byte, error) {
+	thumbnail, err := n.thumbnail()
+	if err != nil {
+		return nil, fmt.Errorf("%w: read thumbnail: %v", ErrRejected, err)
+	}
+	return thumbnail, nil
+}
+
+type ntfy struct {
+	server   string
+	topic    string
+	token    string
+	priority int
+	tags     []string
+	text     *pushText
+	client   *http.Client
+}
+
+func newNtfy(c NtfyConfig) (*ntfy, error) {
+	text, err := newPushText(c.Name, c.PushText)
+	if err != nil {
+		return nil, err
+	}
+	server := c.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	return &ntfy{
+		server:   strings.TrimSuffix(server, "/"),
+		topic:    c.Topic,
+		token:    c.Token,
+		priority: c.Priority,
+		tags:     c.Tags,
+		text:     text,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// send publishes the message as JSON, messages with
+// a thumbnail are uploaded with the message in headers.
+func (s *ntfy) send(ctx context.Context, n Notification) error {
+	title, message, err := s.text.render(n)
+	if err != nil {
+		return err
+	}
+	thumbnail, err := readThumbnail(n)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if thumbnail == nil {
+		body, _ := json.Marshal(struct {
+			Topic    string   `json:"topic"`
+			Title    string   `json:"title"`
+			Message  string   `json:"message"`
+			Priority int      `json:"priority,omitempty"`
+			Tags     []string `json:"tags,omitempty"`
+			Click    string   `json:"click,omitempty"`
+		}{s.topic, title, message, s.priority, s.tags, n.ClipURL})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, s.server, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		url := s.server + "/" + s.topic
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(thumbnail))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+		// Non-ASCII values are RFC 2047 encoded, newlines are escaped.
+		header := func(key string, value string) {
+			if value != "" {
+				req.Header.Set(key, mime.BEncoding.Encode("utf-8", value))
+			}
+		}
+		header("X-Filename", n.RecordingID+".jpeg")
+		header("X-Title", title)
+		header("X-Message", strings.ReplaceAll(message, "\n", `\n`))
+		header("X-Tags", strings.Join(s.tags, ","))
+		header("X-Click", n.ClipURL)
+		if s.priority != 0 {
+			req.Header.Set("X-Priority", strconv.Itoa(s.priority))
+		}
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return do(s.client, req)
+}
+
+type gotify struct {
+	url      string
+	token    string
+	priority int
+	text     *pushText
+	client   *http.Client
+}
+
+func newGotify(c GotifyConfig) (*gotify, error) {
+	text, err := newPushText(c.Name, c.PushText)
+	if err != nil {
+		return nil, err
+	}
+	return &gotify{
+		url:      strings.TrimSuffix(c.URL, "/") + "/message",
+		token:    c.Token,
+		priority: c.Priority,
+		text:     text,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+// send sends the message. Gotify doesn't support attachments, the
+// clients show the thumbnail from the url if it's accessible.
+func (s *gotify) send(ctx context.Context, n Notification) error {
+	title, message, err := s.text.render(n)
+	if err != nil {
+		return err
+	}
+
+	type click struct {
+		URL string `json:"url"`
+	}
+	type clientNotification struct {
+		Click       *click `json:"click,omitempty"`
+		BigImageURL string `json:"bigImageUrl,omitempty"`
+	}
+	payload := struct {
+		Title    string                        `json:"title"`
+		Message  string                        `json:"message"`
+		Priority int                           `json:"priority"`
+		Extras   map[string]clientNotification `json:"extras,omitempty"`
+	}{Title: title, Message: message, Priority: s.priority}
+	if n.ClipURL != "" {
+		payload.Extras = map[string]clientNotification{
+			"client::notification": {
+				Click:       &click{URL: n.ClipURL},
+				BigImageURL: n.ThumbnailURL,
+			},
+		}
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", s.token)
+	return do(s.client, req)
+}
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Larger thumbnails aren't attached.
+const pushoverMaxAttachment = 2500000
+
+// Emergency priority retry interval and expiration in seconds.
+const (
+	pushoverRetry  = 60
+	pushoverExpire = 3600
+)
+
+type pushover struct {
+	url      string
+	token    string
+	user     string
+	devices  []string
+	priority int
+	sound    string
+	text     *pushText
+	client   *http.Client
+}
+
+func newPushover(c PushoverConfig) (*pushover, error) {
+	text, err := newPushText(c.Name, c.PushText)
+	if err != nil {
+		return nil, err
+	}
+	return &pushover{
+		url:      pushoverURL,
+		token:    c.Token,
+		user:     c.User,
+		devices:  c.Devices,
+		priority: c.Priority,
+		sound:    c.Sound,
+		text:     text,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (s *pushover) send(ctx context.Context, n Notification) error {
+	title, message, err := s.text.render(n)
+	if err != nil {
+		return err
+	}
+	thumbnail, err := readThumbnail(n)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fields := [][2]string{
+		{"token", s.token},
+		{"user", s.user},
+		{"title", title},
+		{"message", message},
+		{"timestamp", strconv.FormatInt(n.Time.Unix(), 10)},
+		{"priority", strconv.Itoa(s.priority)},
+	}
+	if len(s.devices) != 0 {
+		fields = append(fields, [2]string{"device", strings.Join(s.devices, ",")})
+	}
+	if s.sound != "" {
+		fields = append(fields, [2]string{"sound", s.sound})
+	}
+	if s.priority == 2 {
+		fields = append(fields,
+			[2]string{"retry", strconv.Itoa(pushoverRetry)},
+			[2]string{"expire", strconv.Itoa(pushoverExpire)})
+	}
+	if n.ClipURL != "" {
+		fields = append(fields, [2]string{"url", n.ClipURL}, [2]string{"url_title", "Recording"})
+	}
+	for _, f := range fields {
+		w.WriteField(f[0], f[1]) //nolint:errcheck
+	}
+	if thumbnail != nil && len(thumbnail) <= pushoverMaxAttachment {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition",
+			`form-data; name="attachment"; filename="`+n.RecordingID+`.jpeg"`)
+		h.Set("Content-Type", "image/jpeg")
+		part, _ := w.CreatePart(h)
+		part.Write(thumbnail) //nolint:errcheck
+	}
+	w.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return do(s.client, req)
+}
@@ -0,0 +1,200 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newPushServer(t *testing.T, status int) (*httptest.Server, chan pushRequest) {
+	t.Helper()
+	requests := make(chan pushRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushRequest{r.Method, r.URL.Path, r.Header, body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func testNotification() Notification {
+	return Notification{
+		MonitorID:   "m1",
+		MonitorName: "Door",
+		Time:        time.Date(2025, 12, 28, 23, 0, 0, 0, time.UTC),
+		Label:       "person",
+		Score:       90.4,
+	}
+}
+
+// testRecordingNotification returns a notification with a thumbnail.
+func testRecordingNotification(t *testing.T) Notification {
+	t.Helper()
+	recordingPath := filepath.Join(t.TempDir(), "2025-12-28_23-00-00_m1")
+	require.NoError(t, os.WriteFile(recordingPath+".jpeg", []byte("jpeg"), 0o600))
+
+	n := testNotification()
+	n.RecordingID = filepath.Base(recordingPath)
+	n.ClipURL = "https://nvr/clip"
+	n.ThumbnailURL = "https://nvr/thumb"
+	n.recordingPath = recordingPath
+	return n
+}
+
+func TestPushText(t *testing.T) {
+	text, err := newPushText("a", PushText{})
+	require.NoError(t, err)
+	title, message, err := text.render(testNotification())
+	require.NoError(t, err)
+	require.Equal(t, "Door", title)
+	require.Equal(t, "Detected person (90%) at 23:00:00", message)
+
+	text, err = newPushText("a", PushText{Title: "{{.MonitorID}}", Message: "{{.Label}}"})
+	require.NoError(t, err)
+	title, message, err = text.render(testNotification())
+	require.NoError(t, err)
+	require.Equal(t, "m1", title)
+	require.Equal(t, "person", message)
+}
+
+func TestNtfy(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		server, requests := newPushServer(t, http.StatusOK)
+		s, err := newNtfy(NtfyConfig{
+			Name: "a", Server: server.URL, Topic: "nvr", Token: "tk", Priority: 4, Tags: []string{"cctv"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.send(context.Background(), testNotification()))
+
+		req := <-requests
+		require.Equal(t, http.MethodPost, req.method)
+		require.Equal(t, "Bearer tk", req.header.Get("Authorization"))
+		require.JSONEq(t, `{
+			"topic": "nvr",
+			"title": "Door",
+			"message": "Detected person (90%) at 23:00:00",
+			"priority": 4,
+			"tags": ["cctv"]
+		}`, string(req.body))
+	})
+	t.Run("attachment", func(t *testing.T) {
+		server, requests := newPushServer(t, http.StatusOK)
+		s, err := newNtfy(NtfyConfig{
+			Name: "a", Server: server.URL, Topic: "nvr", PushText: PushText{Title: "Dörr"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.send(context.Background(), testRecordingNotification(t)))
+
+		req := <-requests
+		require.Equal(t, http.MethodPut, req.method)
+		require.Equal(t, "/nvr", req.path)
+		require.Equal(t, "jpeg", string(req.body))
+		require.Equal(t, "2025-12-28_23-00-00_m1.jpeg", req.header.Get("X-Filename"))
+		require.Equal(t, "https://nvr/clip", req.header.Get("X-Click"))
+
+		title, err := new(mime.WordDecoder).DecodeHeader(req.header.Get("X-Title"))
+		require.NoError(t, err)
+		require.Equal(t, "Dörr", title)
+	})
+	t.Run("rejected", func(t *testing.T) {
+		server, _ := newPushServer(t, http.StatusForbidden)
+		s, err := newNtfy(NtfyConfig{Name: "a", Server: server.URL, Topic: "nvr"})
+		require.NoError(t, err)
+		require.ErrorIs(t, s.send(context.Background(), testNotification()), ErrRejected)
+	})
+}
+
+func TestGotify(t *testing.T) {
+	server, requests := newPushServer(t, http.StatusOK)
+	s, err := newGotify(GotifyConfig{Name: "a", URL: server.URL + "/", Token: "tk", Priority: 5})
+	require.NoError(t, err)
+	require.NoError(t, s.send(context.Background(), testRecordingNotification(t)))
+
+	req := <-requests
+	require.Equal(t, "/message", req.path)
+	require.Equal(t, "tk", req.header.Get("X-Gotify-Key"))
+	require.JSONEq(t, `{
+		"title": "Door",
+		"message": "Detected person (90%) at 23:00:00",
+		"priority": 5,
+		"extras": {
+			"client::notification": {
+				"click": {"url": "https://nvr/clip"},
+				"bigImageUrl": "https://nvr/thumb"
+			}
+		}
+	}`, string(req.body))
+}
+
+func TestPushover(t *testing.T) {
+	server, requests := newPushServer(t, http.StatusOK)
+	s, err := newPushover(PushoverConfig{
+		Name: "a", Token: "tk", User: "u", Devices: []string{"a", "b"}, Priority: 2,
+	})
+	require.NoError(t, err)
+	s.url = server.URL
+	require.NoError(t, s.send(context.Background(), testRecordingNotification(t)))
+
+	req := <-requests
+	_, params, err := mime.ParseMediaType(req.header.Get("Content-Type"))
+	require.NoError(t, err)
+	form, err := multipart.NewReader(bytes.NewReader(req.body), params["boundary"]).ReadForm(1 << 20)
+	require.NoError(t, err)
+
+	fields := make(map[string]string)
+	for key, values := range form.Value {
+		fields[key] = values[0]
+	}
+	require.Equal(t, map[string]string{
+		"token":     "tk",
+		"user":      "u",
+		"title":     "Door",
+		"message":   "Detected person (90%) at 23:00:00",
+		"timestamp": "1766962800",
+		"priority":  "2",
+		"device":    "a,b",
+		"retry":     "60",
+		"expire":    "3600",
+		"url":       "https://nvr/clip",
+		"url_title": "Recording",
+	}, fields)
+
+	file := form.File["attachment"][0]
+	require.Equal(t, "2025-12-28_23-00-00_m1.jpeg", file.Filename)
+	f, err := file.Open()
+	require.NoError(t, err)
+	attachment, _ := io.ReadAll(f)
+	require.Equal(t, "jpeg", string(attachment))
+}
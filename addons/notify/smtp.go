@@ -0,0 +1,275 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"nvr/pkg/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time limit of a single email including the attachments.
+const smtpTimeout = time.Minute
+
+// Default max clip size in megabytes.
+const defaultMaxClipSize = 10
+
+type smtpSender struct {
+	host        string
+	address     string
+	security    string
+	username    string
+	password    string
+	from        string
+	to          []string
+	text        *pushText
+	attachClip  bool
+	maxClipSize int64
+	crypt       *storage.Crypt
+}
+
+func newSMTP(c SMTPConfig, crypt *storage.Crypt) (*smtpSender, error) {
+	text, err := newPushText(c.Name, PushText{Title: c.Subject, Message: c.Body})
+	if err != nil {
+		return nil, err
+	}
+	security := c.Security
+	if security == "" {
+		security = smtpStartTLS
+	}
+	port := c.Port
+	if port == 0 {
+		switch security {
+		case smtpTLS:
+			port = 465
+		case smtpNone:
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	maxClipSize := c.MaxClipSize
+	if maxClipSize == 0 {
+		maxClipSize = defaultMaxClipSize
+	}
+	return &smtpSender{
+		host:        c.Host,
+		address:     net.JoinHostPort(c.Host, strconv.Itoa(port)),
+		security:    security,
+		username:    c.Username,
+		password:    c.Password,
+		from:        c.From,
+		to:          c.To,
+		text:        text,
+		attachClip:  c.AttachClip,
+		maxClipSize: int64(maxClipSize * 1000000),
+		crypt:       crypt,
+	}, nil
+}
+
+func (s *smtpSender) send(ctx context.Context, n Notification) error {
+	msg, err := s.message(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}
+	if s.security == smtpTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer c.Close()
+
+	if s.security == smtpStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", smtpError(err))
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("auth: %w", smtpError(err))
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return fmt.Errorf("mail: %w", smtpError(err))
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("rcpt: %w", smtpError(err))
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("data: %w", smtpError(err))
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("data: %w", smtpError(err))
+	}
+	return c.Quit()
+}
+
+// smtpError permanent negative replies are rejected.
+func smtpError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
+
+// message returns the email. The body is HTML with the
+// thumbnail embedded, the clip is attached if enabled.
+func (s *smtpSender) message(n Notification) ([]byte, error) {
+	subject, body, err := s.text.render(n)
+	if err != nil {
+		return nil, err
+	}
+	if n.Suppressed != 0 {
+		body += fmt.Sprintf("\n\n%v notifications were suppressed by quiet hours or rate limits.", n.Suppressed)
+	}
+	thumbnail, err := readThumbnail(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := func(key string, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", s.from)
+	header("To", strings.Join(s.to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID())
+	header("MIME-Version", "1.0")
+
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+
+	relatedBuf := &bytes.Buffer{}
+	related := multipart.NewWriter(relatedBuf)
+	relatedPart, _ := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/related; boundary=" + related.Boundary()},
+	})
+
+	htmlBody := "<p>" + strings.ReplaceAll(html.EscapeString(body), "\n", "<br>") + "</p>"
+	if thumbnail != nil {
+		htmlBody += `<p><img src="cid:thumbnail" alt="thumbnail"></p>`
+	}
+	if n.ClipURL != "" {
+		htmlBody += `<p><a href="` + html.EscapeString(n.ClipURL) + `">Open recording</a></p>`
+	}
+	writeBase64Part(related, textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=utf-8"},
+	}, []byte("<html><body>"+htmlBody+"</body></html>"))
+	if thumbnail != nil {
+		writeBase64Part(related, textproto.MIMEHeader{
+			"Content-Type":        {"image/jpeg"},
+			"Content-ID":          {"<thumbnail>"},
+			"Content-Disposition": {`inline; filename="` + n.RecordingID + `.jpeg"`},
+		}, thumbnail)
+	}
+	related.Close()
+	relatedPart.Write(relatedBuf.Bytes()) //nolint:errcheck
+
+	if s.attachClip {
+		if err := s.writeClip(mixed, n); err != nil {
+			return nil, err
+		}
+	}
+	mixed.Close()
+	return buf.Bytes(), nil
+}
+
+// writeClip attaches the recording if it's smaller than the max size.
+func (s *smtpSender) writeClip(w *multipart.Writer, n Notification) error {
+	if n.recordingPath == "" {
+		return nil
+	}
+	video, filename, contentType, err := openClip(n, s.crypt)
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+
+	if video.Size() > s.maxClipSize {
+		return nil
+	}
+	data, err := io.ReadAll(video)
+	if err != nil {
+		return fmt.Errorf("read clip: %w", err)
+	}
+	writeBase64Part(w, textproto.MIMEHeader{
+		"Content-Type":        {contentType},
+		"Content-Disposition": {`attachment; filename="` + filename + `"`},
+	}, data)
+	return nil
+}
+
+func writeBase64Part(w *multipart.Writer, h textproto.MIMEHeader, data []byte) {
+	h.Set("Content-Transfer-Encoding", "base64")
+	part, _ := w.CreatePart(h)
+
+	// Lines are limited to 76 characters.
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(part, encoded[:76]+"\r\n") //nolint:errcheck
+		encoded = encoded[76:]
+	}
+	io.WriteString(part, encoded+"\r\n") //nolint:errcheck
+}
+
+func messageID() string {
+	b := make([]byte, 12)
+	rand.Read(b) //nolint:errcheck
+	return "<" + hex.EncodeToString(b) + "@os-nvr>"
+}
@@ -0,0 +1,202 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer accepts a single session and returns the message data.
+func fakeSMTPServer(t *testing.T, rcptReply string) (int, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			conn.Write([]byte(s + "\r\n")) //nolint:errcheck
+		}
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line)[0])
+			switch cmd {
+			case "EHLO", "HELO", "MAIL":
+				reply("250 OK")
+			case "RCPT":
+				reply(rcptReply)
+			case "DATA":
+				reply("354 Go ahead")
+				var msg strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					msg.WriteString(line)
+				}
+				data <- msg.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("500 Unknown")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, data
+}
+
+func newTestSMTP(t *testing.T, port int, attachClip bool) *smtpSender {
+	t.Helper()
+	s, err := newSMTP(SMTPConfig{
+		Name:       "a",
+		Host:       "127.0.0.1",
+		Port:       port,
+		Security:   "none",
+		From:       "nvr@example.com",
+		To:         []string{"a@example.com", "b@example.com"},
+		Subject:    "{{.MonitorName}}: {{.Label}}",
+		AttachClip: attachClip,
+	}, nil)
+	require.NoError(t, err)
+	return s
+}
+
+func readParts(t *testing.T, r io.Reader, contentType string) map[string][]byte {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(mediaType, "multipart/"))
+
+	parts := make(map[string][]byte)
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		require.NoError(t, err)
+		partType := p.Header.Get("Content-Type")
+		if strings.HasPrefix(partType, "multipart/") {
+			for k, v := range readParts(t, p, partType) {
+				parts[k] = v
+			}
+			continue
+		}
+		data, err := io.ReadAll(p)
+		require.NoError(t, err)
+		mediaType, _, _ := mime.ParseMediaType(partType)
+		parts[mediaType] = data
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		port, data := fakeSMTPServer(t, "250 OK")
+		s := newTestSMTP(t, port, true)
+
+		n := testRecordingNotification(t)
+		n.MonitorName = "Dörr"
+		n.Suppressed = 3
+		require.NoError(t, os.WriteFile(n.recordingPath+".mkv", []byte("mkv"), 0o600))
+		require.NoError(t, s.send(context.Background(), n))
+
+		msg, err := mail.ReadMessage(strings.NewReader(<-data))
+		require.NoError(t, err)
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		require.NoError(t, err)
+		require.Equal(t, "Dörr: person", subject)
+		require.Equal(t, "a@example.com, b@example.com", msg.Header.Get("To"))
+
+		parts := readParts(t, msg.Body, msg.Header.Get("Content-Type"))
+		body := string(decodeBase64(t, parts["text/html"]))
+		require.Contains(t, body, `<img src="cid:thumbnail"`)
+		require.Contains(t, body, `<a href="https://nvr/clip">`)
+		require.Contains(t, body, "3 notifications were suppressed")
+		require.Equal(t, "jpeg", string(decodeBase64(t, parts["image/jpeg"])))
+		require.Equal(t, "mkv", string(decodeBase64(t, parts["video/x-matroska"])))
+	})
+	t.Run("clipTooLarge", func(t *testing.T) {
+		port, data := fakeSMTPServer(t, "250 OK")
+		s := newTestSMTP(t, port, true)
+		s.maxClipSize = 2
+
+		n := testRecordingNotification(t)
+		require.NoError(t, os.WriteFile(n.recordingPath+".mkv", []byte("mkv"), 0o600))
+		require.NoError(t, s.send(context.Background(), n))
+
+		msg, err := mail.ReadMessage(strings.NewReader(<-data))
+		require.NoError(t, err)
+		parts := readParts(t, msg.Body, msg.Header.Get("Content-Type"))
+		require.NotContains(t, parts, "video/x-matroska")
+	})
+	t.Run("rejected", func(t *testing.T) {
+		port, _ := fakeSMTPServer(t, "550 No such user")
+		s := newTestSMTP(t, port, false)
+		require.ErrorIs(t, s.send(context.Background(), testNotification()), ErrRejected)
+	})
+	t.Run("temporary", func(t *testing.T) {
+		port, _ := fakeSMTPServer(t, "451 Try again later")
+		s := newTestSMTP(t, port, false)
+		err := s.send(context.Background(), testNotification())
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrRejected)
+	})
+}
+
+func TestSMTPDefaultPort(t *testing.T) {
+	for security, port := range map[string]int{"": 587, "starttls": 587, "tls": 465, "none": 25} {
+		s, err := newSMTP(SMTPConfig{Name: "a", Host: "mail", Security: security}, nil)
+		require.NoError(t, err)
+		require.Equal(t, "mail:"+strconv.Itoa(port), s.address)
+	}
+}
+
+func decodeBase64(t *testing.T, data []byte) []byte {
+	t.Helper()
+	decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(data)))
+	require.NoError(t, err)
+	return decoded
+}
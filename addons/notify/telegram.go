@@ -0,0 +1,489 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const telegramURL = "https://api.telegram.org"
+
+// Time limit of a single API request including uploads.
+const telegramTimeout = time.Minute
+
+// Bot API upload limit.
+const telegramMaxUpload = 50000000
+
+// Captions are limited to 1024 characters.
+const telegramMaxCaption = 1024
+
+type telegramAPI struct {
+	url    string
+	client *http.Client
+}
+
+func newTelegramAPI(server string, token string, timeout time.Duration) *telegramAPI {
+	if server == "" {
+		server = telegramURL
+	}
+	return &telegramAPI{
+		url:    strings.TrimSuffix(server, "/") + "/bot" + token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// call sends the request body to the API method
+// and decodes the result if result isn't nil.
+func (a *telegramAPI) call(
+	ctx context.Context,
+	method string,
+	contentType string,
+	body io.Reader,
+	result interface{},
+) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/"+method, body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		// Remove the url, it includes the token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%v: %w", method, err)
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&response)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%v: %w: %v", method, statusError(res.StatusCode), response.Description)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("%v: decode response: %w", method, decodeErr)
+	}
+	if !response.OK {
+		return fmt.Errorf("%v: %w: %v", method, ErrRejected, response.Description)
+	}
+	if result != nil {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("%v: unmarshal result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+func (a *telegramAPI) sendMessage(ctx context.Context, chatID string, text string) error {
+	body, _ := json.Marshal(struct {
+		ChatID    string `json:"chat_id"`
+		Text      string `json:"text"`
+		ParseMode string `json:"parse_mode"`
+	}{chatID, text, "HTML"})
+	return a.call(ctx, "sendMessage", "application/json", bytes.NewReader(body), nil)
+}
+
+// sendFile uploads the file with the caption, method is
+// "sendPhoto", "sendVideo" or "sendDocument" and field
+// is the matching "photo", "video" or "document".
+func (a *telegramAPI) sendFile(
+	ctx context.Context,
+	method string,
+	field string,
+	chatID string,
+	caption string,
+	filename string,
+	contentType string,
+	file io.Reader,
+) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("chat_id", chatID) //nolint:errcheck
+	if caption != "" {
+		w.WriteField("caption", caption)   //nolint:errcheck
+		w.WriteField("parse_mode", "HTML") //nolint:errcheck
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition",
+		`form-data; name="`+field+`"; filename="`+filename+`"`)
+	h.Set("Content-Type", contentType)
+	part, _ := w.CreatePart(h)
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("read %v: %w", field, err)
+	}
+	w.Close()
+
+	return a.call(ctx, method, w.FormDataContentType(), &body, nil)
+}
+
+type telegram struct {
+	api         *telegramAPI
+	chatID      string
+	text        *pushText
+	attachClip  bool
+	maxClipSize int64
+	crypt       *storage.Crypt
+}
+
+func newTelegram(c TelegramConfig, crypt *storage.Crypt) (*telegram, error) {
+	text, err := newPushText(c.Name, c.PushText)
+	if err != nil {
+		return nil, err
+	}
+	maxClipSize := c.MaxClipSize
+	if maxClipSize == 0 {
+		maxClipSize = defaultMaxClipSize
+	}
+	return &telegram{
+		api:         newTelegramAPI(c.Server, c.Token, telegramTimeout),
+		chatID:      c.ChatID,
+		text:        text,
+		attachClip:  c.AttachClip,
+		maxClipSize: int64(maxClipSize * 1000000),
+		crypt:       crypt,
+	}, nil
+}
+
+// send sends the clip if enabled and it's small enough, otherwise
+// the thumbnail or only the text if there is no recording.
+func (s *telegram) send(ctx context.Context, n Notification) error {
+	title, message, err := s.text.render(n)
+	if err != nil {
+		return err
+	}
+	caption := "<b>" + html.EscapeString(title) + "</b>\n" + html.EscapeString(message)
+	if n.ClipURL != "" {
+		caption += "\n" + `<a href="` + html.EscapeString(n.ClipURL) + `">Open recording</a>`
+	}
+
+	if s.attachClip && n.recordingPath != "" {
+		sent, err := s.sendClip(ctx, n, caption)
+		if sent || err != nil {
+			return err
+		}
+	}
+
+	thumbnail, err := readThumbnail(n)
+	if err != nil {
+		return err
+	}
+	if thumbnail == nil {
+		return s.api.sendMessage(ctx, s.chatID, caption)
+	}
+	if len(caption) > telegramMaxCaption {
+		caption = ""
+	}
+	return s.api.sendFile(ctx, "sendPhoto", "photo", s.chatID,
+		caption, n.RecordingID+".jpeg", "image/jpeg", bytes.NewReader(thumbnail))
+}
+
+// sendClip returns false if the clip is too large. Matroska
+// files are sent as documents, Telegram can't play them.
+func (s *telegram) sendClip(ctx context.Context, n Notification, caption string) (bool, error) {
+	video, filename, contentType, err := openClip(n, s.crypt)
+	if err != nil {
+		return false, err
+	}
+	defer video.Close()
+
+	if video.Size() > s.maxClipSize || video.Size() > telegramMaxUpload {
+		return false, nil
+	}
+	if len(caption) > telegramMaxCaption {
+		caption = ""
+	}
+	method, field := "sendVideo", "video"
+	if contentType != "video/mp4" {
+		method, field = "sendDocument", "document"
+	}
+	err = s.api.sendFile(ctx, method, field, s.chatID, caption, filename, contentType, video)
+	return true, err
+}
+
+// Seconds the server holds each getUpdates request open.
+const telegramPollTimeout = 50
+
+// Delay after a failed getUpdates request.
+const telegramRetryDelay = 10 * time.Second
+
+type (
+	monitorsInfoFunc func() monitor.RawConfigs
+	snapshotFunc     func(context.Context, string) ([]byte, error)
+)
+
+// telegramBot accepts commands from the configured chats.
+type telegramBot struct {
+	api      *telegramAPI
+	chats    map[string]struct{}
+	arming   *arming.Manager
+	monitors monitorsInfoFunc
+	snapshot snapshotFunc
+	logf     log.Func
+	sleep    func(context.Context, time.Duration) bool
+}
+
+// newTelegramBots returns a bot for each token with commands enabled.
+func newTelegramBots(
+	configs []TelegramConfig,
+	arm *arming.Manager,
+	monitors monitorsInfoFunc,
+	snapshot snapshotFunc,
+	logf log.Func,
+) []*telegramBot {
+	var bots []*telegramBot
+	byToken := make(map[string]*telegramBot)
+	for _, c := range configs {
+		if !c.Commands {
+			continue
+		}
+		bot, exist := byToken[c.Token]
+		if !exist {
+			bot = &telegramBot{
+				api: newTelegramAPI(
+					c.Server, c.Token, telegramPollTimeout*time.Second+telegramTimeout),
+				chats:    make(map[string]struct{}),
+				arming:   arm,
+				monitors: monitors,
+				snapshot: snapshot,
+				logf:     logf,
+				sleep:    sleep,
+			}
+			byToken[c.Token] = bot
+			bots = append(bots, bot)
+		}
+		bot.chats[c.ChatID] = struct{}{}
+	}
+	return bots
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// run polls for updates until the context is canceled.
+func (b *telegramBot) run(ctx context.Context) {
+	var offset int64
+	for {
+		updates, err := b.getUpdates(ctx, offset)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.logf(log.LevelError, "telegram: get updates: %v", err)
+			if !b.sleep(ctx, telegramRetryDelay) {
+				return
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handleMessage(ctx, *u.Message)
+			}
+		}
+	}
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	body, _ := json.Marshal(struct {
+		Offset         int64    `json:"offset"`
+		Timeout        int      `json:"timeout"`
+		AllowedUpdates []string `json:"allowed_updates"`
+	}{offset, telegramPollTimeout, []string{"message"}})
+
+	var updates []telegramUpdate
+	err := b.api.call(ctx, "getUpdates", "application/json", bytes.NewReader(body), &updates)
+	return updates, err
+}
+
+func (b *telegramBot) handleMessage(ctx context.Context, msg telegramMessage) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if _, allowed := b.chats[chatID]; !allowed {
+		b.logf(log.LevelWarning, "telegram: ignored message from unknown chat: %v", chatID)
+		return
+	}
+	command, arg := parseCommand(msg.Text)
+	if command == "" {
+		return
+	}
+	b.logf(log.LevelInfo, "telegram: command: %v %v", command, arg)
+
+	text, photo := b.command(ctx, command, arg)
+
+	var err error
+	if photo != nil {
+		err = b.api.sendFile(ctx, "sendPhoto", "photo", chatID,
+			html.EscapeString(text), "snapshot.jpeg", "image/jpeg", bytes.NewReader(photo))
+	} else {
+		err = b.api.sendMessage(ctx, chatID, html.EscapeString(text))
+	}
+	if err != nil {
+		b.logf(log.LevelError, "telegram: reply: %v", err)
+	}
+}
+
+// parseCommand returns the command without the bot name and the
+// argument. For example "/snapshot@bot garage" returns "snapshot"
+// and "garage". The command is empty if the text isn't a command.
+func parseCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	command, arg, _ := strings.Cut(text[1:], " ")
+	command, _, _ = strings.Cut(command, "@")
+	return strings.ToLower(command), strings.TrimSpace(arg)
+}
+
+const telegramHelp = "/snapshot <monitor> - Current image of a monitor.\n" +
+	"/arm [away|home|night] - Arm the NVR, defaults to away.\n" +
+	"/disarm - Disarm the NVR.\n" +
+	"/status - Arming mode and monitor states."
+
+// command executes the command and returns the
+// reply text and photo, the photo may be nil.
+func (b *telegramBot) command(ctx context.Context, command string, arg string) (string, []byte) {
+	switch command {
+	case "start", "help":
+		return telegramHelp, nil
+	case "arm":
+		mode := arming.Away
+		if arg != "" {
+			mode = arming.Mode(strings.ToLower(arg))
+		}
+		if mode == arming.Disarmed {
+			return "Use /disarm to disarm.", nil
+		}
+		return b.setMode(mode)
+	case "disarm":
+		return b.setMode(arming.Disarmed)
+	case "status":
+		return b.status(), nil
+	case "snapshot":
+		return b.snapshotCommand(ctx, arg)
+	}
+	return "Unknown command, see /help.", nil
+}
+
+func (b *telegramBot) setMode(mode arming.Mode) (string, []byte) {
+	if err := b.arming.SetMode(mode, "telegram"); err != nil {
+		return "Could not set mode: " + err.Error(), nil
+	}
+	return "Mode: " + string(mode), nil
+}
+
+// status returns the global mode and the state of each
+// monitor, monitor modes are shown if they are overridden.
+func (b *telegramBot) status() string {
+	state := b.arming.State()
+	status := "Mode: " + string(state.Mode)
+	for _, m := range sortedMonitors(b.monitors()) {
+		inputState := m["state"]
+		if m["enable"] != "true" {
+			inputState = "disabled"
+		}
+		if inputState == "" {
+			inputState = "unknown"
+		}
+		status += "\n" + m["name"] + ": " + inputState
+		if mode, exist := state.Monitors[m["id"]]; exist {
+			status += ", " + string(mode)
+		}
+	}
+	return status
+}
+
+func (b *telegramBot) snapshotCommand(ctx context.Context, arg string) (string, []byte) {
+	monitors := sortedMonitors(b.monitors())
+	if arg == "" {
+		names := make([]string, 0, len(monitors))
+		for _, m := range monitors {
+			names = append(names, m["name"])
+		}
+		return "Usage: /snapshot <monitor>\nMonitors: " + strings.Join(names, ", "), nil
+	}
+
+	m := findMonitor(monitors, arg)
+	if m == nil {
+		return "Unknown monitor: " + arg, nil
+	}
+	snapshot, err := b.snapshot(ctx, m["id"])
+	if err != nil {
+		b.logf(log.LevelError, "telegram: snapshot: %v: %v", m["id"], err)
+		return "Snapshot failed: " + err.Error(), nil
+	}
+	return m["name"], snapshot
+}
+
+// sortedMonitors returns the monitors sorted by name.
+func sortedMonitors(configs monitor.RawConfigs) []monitor.RawConfig {
+	monitors := make([]monitor.RawConfig, 0, len(configs))
+	for _, c := range configs {
+		monitors = append(monitors, c)
+	}
+	sort.Slice(monitors, func(i, j int) bool {
+		return monitors[i]["name"] < monitors[j]["name"]
+	})
+	return monitors
+}
+
+// findMonitor returns the monitor with the ID or case
+// insensitive name. Nil if the monitor doesn't exist.
+func findMonitor(monitors []monitor.RawConfig, nameOrID string) monitor.RawConfig {
+	for _, m := range monitors {
+		if m["id"] == nameOrID {
+			return m
+		}
+	}
+	for _, m := range monitors {
+		if strings.EqualFold(m["name"], nameOrID) {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/arming"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type telegramRequest struct {
+	path   string
+	fields map[string]string
+	files  map[string][]byte
+}
+
+// newTelegramServer responds with the result to all requests.
+func newTelegramServer(
+	t *testing.T, status int, result string,
+) (*httptest.Server, chan telegramRequest) {
+	t.Helper()
+	requests := make(chan telegramRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := telegramRequest{
+			path:   r.URL.Path,
+			fields: make(map[string]string),
+			files:  make(map[string][]byte),
+		}
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				data, _ := io.ReadAll(part)
+				if part.FileName() != "" {
+					req.files[part.FormName()] = data
+				} else {
+					req.fields[part.FormName()] = string(data)
+				}
+			}
+		} else {
+			json.NewDecoder(r.Body).Decode(&req.fields) //nolint:errcheck
+		}
+		requests <- req
+
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			io.WriteString(w, `{"ok":true,"result":`+result+`}`) //nolint:errcheck
+		} else {
+			io.WriteString(w, `{"ok":false,"description":"Bad Request: chat not found"}`) //nolint:errcheck
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newTestTelegram(t *testing.T, server string, c TelegramConfig) *telegram {
+	t.Helper()
+	c.Name = "a"
+	c.Token = "token"
+	c.ChatID = "123"
+	c.Server = server
+	s, err := newTelegram(c, nil)
+	require.NoError(t, err)
+	return s
+}
+
+func TestTelegram(t *testing.T) {
+	t.Run("message", func(t *testing.T) {
+		server, requests := newTelegramServer(t, http.StatusOK, "{}")
+		s := newTestTelegram(t, server.URL, TelegramConfig{})
+
+		require.NoError(t, s.send(context.Background(), testNotification()))
+		req := <-requests
+		require.Equal(t, "/bottoken/sendMessage", req.path)
+		require.Equal(t, "123", req.fields["chat_id"])
+		require.Equal(t, "HTML", req.fields["parse_mode"])
+		require.Equal(t, "<b>Door</b>\nDetected person (90%) at 23:00:00", req.fields["text"])
+	})
+	t.Run("photo", func(t *testing.T) {
+		server, requests := newTelegramServer(t, http.StatusOK, "{}")
+		s := newTestTelegram(t, server.URL, TelegramConfig{})
+
+		require.NoError(t, s.send(context.Background(), testRecordingNotification(t)))
+		req := <-requests
+		require.Equal(t, "/bottoken/sendPhoto", req.path)
+		require.Equal(t, "123", req.fields["chat_id"])
+		require.Equal(t, "<b>Door</b>\nDetected person (90%) at 23:00:00\n"+
+			`<a href="https://nvr/clip">Open recording</a>`, req.fields["caption"])
+		require.Equal(t, []byte("jpeg"), req.files["photo"])
+	})
+	t.Run("rejected", func(t *testing.T) {
+		server, _ := newTelegramServer(t, http.StatusBadRequest, "")
+		s := newTestTelegram(t, server.URL, TelegramConfig{})
+
+		err := s.send(context.Background(), testNotification())
+		require.ErrorIs(t, err, ErrRejected)
+		require.Contains(t, err.Error(), "chat not found")
+	})
+	t.Run("retry", func(t *testing.T) {
+		server, _ := newTelegramServer(t, http.StatusTooManyRequests, "")
+		s := newTestTelegram(t, server.URL, TelegramConfig{})
+
+		err := s.send(context.Background(), testNotification())
+		require.ErrorIs(t, err, ErrStatus)
+		require.NotErrorIs(t, err, ErrRejected)
+	})
+	t.Run("tokenNotLogged", func(t *testing.T) {
+		s := newTestTelegram(t, "http://127.0.0.1:1", TelegramConfig{})
+		err := s.send(context.Background(), testNotification())
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), "token")
+	})
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := map[string]struct {
+		input   string
+		command string
+		arg     string
+	}{
+		"empty":   {"", "", ""},
+		"text":    {"hello", "", ""},
+		"command": {"/arm", "arm", ""},
+		"arg":     {"/snapshot garage", "snapshot", "garage"},
+		"spaces":  {" /snapshot  front door ", "snapshot", "front door"},
+		"botName": {"/Snapshot@nvr_bot garage", "snapshot", "garage"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			command, arg := parseCommand(tc.input)
+			require.Equal(t, tc.command, command)
+			require.Equal(t, tc.arg, arg)
+		})
+	}
+}
+
+func newTestBot(t *testing.T, server string) *telegramBot {
+	t.Helper()
+	arm, err := arming.NewManager(filepath.Join(t.TempDir(), "arming.json"), log.NewDummyLogger())
+	require.NoError(t, err)
+	return &telegramBot{
+		api:    newTelegramAPI(server, "token", telegramTimeout),
+		chats:  map[string]struct{}{"123": {}},
+		arming: arm,
+		monitors: func() monitor.RawConfigs {
+			return monitor.RawConfigs{
+				"m1": {"id": "m1", "name": "Garage", "enable": "true", "state": "online"},
+				"m2": {"id": "m2", "name": "Door", "enable": "false"},
+			}
+		},
+		snapshot: func(_ context.Context, id string) ([]byte, error) {
+			if id == "m2" {
+				return nil, monitor.ErrMonitorNotRunning
+			}
+			return []byte("snapshot " + id), nil
+		},
+		logf: func(log.Level, string, ...interface{}) {},
+	}
+}
+
+func TestTelegramCommand(t *testing.T) {
+	cases := map[string]struct {
+		command string
+		arg     string
+		text    string
+		photo   []byte
+	}{
+		"help":       {"help", "", telegramHelp, nil},
+		"unknown":    {"x", "", "Unknown command, see /help.", nil},
+		"status":     {"status", "", "Mode: away\nDoor: disabled\nGarage: online", nil},
+		"snapshot":   {"snapshot", "garage", "Garage", []byte("snapshot m1")},
+		"snapshotID": {"snapshot", "m1", "Garage", []byte("snapshot m1")},
+		"snapshotUsage": {
+			"snapshot", "", "Usage: /snapshot <monitor>\nMonitors: Door, Garage", nil,
+		},
+		"snapshotUnknown": {"snapshot", "x", "Unknown monitor: x", nil},
+		"snapshotErr": {
+			"snapshot", "door", "Snapshot failed: " + monitor.ErrMonitorNotRunning.Error(), nil,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			text, photo := newTestBot(t, "").command(context.Background(), tc.command, tc.arg)
+			require.Equal(t, tc.text, text)
+			require.Equal(t, tc.photo, photo)
+		})
+	}
+	t.Run("arm", func(t *testing.T) {
+		bot := newTestBot(t, "")
+		text, _ := bot.command(context.Background(), "disarm", "")
+		require.Equal(t, "Mode: disarmed", text)
+		require.Equal(t, arming.Disarmed, bot.arming.Mode())
+
+		text, _ = bot.command(context.Background(), "arm", "Night")
+		require.Equal(t, "Mode: night", text)
+		require.Equal(t, arming.Night, bot.arming.Mode())
+
+		text, _ = bot.command(context.Background(), "arm", "")
+		require.Equal(t, "Mode: away", text)
+		require.Equal(t, arming.Away, bot.arming.Mode())
+
+		text, _ = bot.command(context.Background(), "arm", "x")
+		require.True(t, strings.HasPrefix(text, "Could not set mode: invalid arming mode"))
+
+		text, _ = bot.command(context.Background(), "arm", "disarmed")
+		require.Equal(t, "Use /disarm to disarm.", text)
+		require.Equal(t, arming.Away, bot.arming.Mode())
+	})
+	t.Run("statusOverride", func(t *testing.T) {
+		bot := newTestBot(t, "")
+		require.NoError(t, bot.arming.SetMonitorMode("m1", arming.Disarmed, "test"))
+		text, _ := bot.command(context.Background(), "status", "")
+		require.Equal(t, "Mode: away\nDoor: disabled\nGarage: online, disarmed", text)
+	})
+}
+
+func TestTelegramHandleMessage(t *testing.T) {
+	newMessage := func(chatID int64, text string) telegramMessage {
+		var msg telegramMessage
+		msg.Chat.ID = chatID
+		msg.Text = text
+		return msg
+	}
+	t.Run("text", func(t *testing.T) {
+		server, requests := newTelegramServer(t, http.StatusOK, "{}")
+		newTestBot(t, server.URL).handleMessage(context.Background(), newMessage(123, "/arm"))
+		req := <-requests
+		require.Equal(t, "/bottoken/sendMessage", req.path)
+		require.Equal(t, "123", req.fields["chat_id"])
+		require.Equal(t, "Mode: away", req.fields["text"])
+	})
+	t.Run("photo", func(t *testing.T) {
+		server, requests := newTelegramServer(t, http.StatusOK, "{}")
+		bot := newTestBot(t, server.URL)
+		bot.handleMessage(context.Background(), newMessage(123, "/snapshot garage"))
+		req := <-requests
+		require.Equal(t, "/bottoken/sendPhoto", req.path)
+		require.Equal(t, "Garage", req.fields["caption"])
+		require.Equal(t, []byte("snapshot m1"), req.files["photo"])
+	})
+	t.Run("unknownChat", func(t *testing.T) {
+		server, requests := newTelegramServer(t, http.StatusOK, "{}")
+		bot := newTestBot(t, server.URL)
+		bot.handleMessage(context.Background(), newMessage(456, "/disarm"))
+		require.Equal(t, arming.Away, bot.arming.Mode())
+		require.Len(t, requests, 0)
+	})
+}
+
+func TestTelegramBotRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan telegramRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Offset int64 `json:"offset"`
+		}
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		requests <- telegramRequest{path: r.URL.Path}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getUpdates") && body.Offset == 0:
+			io.WriteString(w, `{"ok":true,"result":[`+ //nolint:errcheck
+				`{"update_id":7,"message":{"chat":{"id":123},"text":"/disarm"}}]}`)
+		case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+			require.Equal(t, int64(8), body.Offset)
+			cancel()
+			io.WriteString(w, `{"ok":true,"result":[]}`) //nolint:errcheck
+		default:
+			io.WriteString(w, `{"ok":true,"result":{}}`) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	bot := newTestBot(t, server.URL)
+	bot.sleep = func(context.Context, time.Duration) bool {
+		return false
+	}
+	bot.run(ctx)
+
+	require.Equal(t, arming.Disarmed, bot.arming.Mode())
+	require.Equal(t, "/bottoken/getUpdates", (<-requests).path)
+	require.Equal(t, "/bottoken/sendMessage", (<-requests).path)
+	require.Equal(t, "/bottoken/getUpdates", (<-requests).path)
+}
+
+func TestNewTelegramBots(t *testing.T) {
+	configs := []TelegramConfig{
+		{Name: "a", Token: "t1", ChatID: "1", Commands: true},
+		{Name: "b", Token: "t1", ChatID: "2", Commands: true},
+		{Name: "c", Token: "t2", ChatID: "3"},
+	}
+	bots := newTelegramBots(configs, nil, nil, nil, nil)
+	require.Len(t, bots, 1)
+	require.Equal(t, map[string]struct{}{"1": {}, "2": {}}, bots[0].chats)
+}
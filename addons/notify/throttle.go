@@ -0,0 +1,144 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"fmt"
+	"nvr/pkg/schedule"
+	"sync"
+	"time"
+)
+
+// Rate limit window.
+const rateLimitPeriod = time.Hour
+
+// rateLimit allows max notifications within rateLimitPeriod,
+// zero is unlimited. The methods accept a nil limit.
+type rateLimit struct {
+	max int
+
+	// Times of the notifications within the last period.
+	sent []time.Time
+}
+
+func newRateLimit(max int) *rateLimit {
+	if max == 0 {
+		return nil
+	}
+	return &rateLimit{max: max}
+}
+
+func (l *rateLimit) full(now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	for len(l.sent) != 0 && now.Sub(l.sent[0]) >= rateLimitPeriod {
+		l.sent = l.sent[1:]
+	}
+	return len(l.sent) >= l.max
+}
+
+func (l *rateLimit) add(now time.Time) {
+	if l != nil {
+		l.sent = append(l.sent, now)
+	}
+}
+
+type quietPeriod struct {
+	schedule schedule.Schedule
+	rules    []string
+}
+
+func newQuietPeriod(c QuietHours) (*quietPeriod, error) {
+	s := schedule.Schedule{c.Range}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &quietPeriod{schedule: s, rules: c.Rules}, nil
+}
+
+func (p *quietPeriod) active(ruleName string, t time.Time) bool {
+	if len(p.rules) != 0 && !contains(p.rules, ruleName) {
+		return false
+	}
+	return p.schedule.Active(t)
+}
+
+// throttle drops notifications during quiet hours and when the rate
+// limit of the rule or the channel is reached. Channels are the
+// destination kinds, for example all ntfy rules share a limit.
+type throttle struct {
+	quietHours []*quietPeriod
+	channels   map[string]*rateLimit
+
+	mu sync.Mutex
+}
+
+func newThrottle(c Config) (*throttle, error) {
+	t := &throttle{channels: make(map[string]*rateLimit)}
+	for _, q := range c.QuietHours {
+		period, err := newQuietPeriod(q)
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours: %w", err)
+		}
+		t.quietHours = append(t.quietHours, period)
+	}
+	for channel, max := range c.ChannelLimits {
+		t.channels[channel] = newRateLimit(max)
+	}
+	return t, nil
+}
+
+// Reasons for dropping a notification.
+const (
+	reasonQuietHours   = "quiet hours"
+	reasonRateLimit    = "rate limit reached"
+	reasonChannelLimit = "channel rate limit reached"
+)
+
+// allow returns the reason if the notification should be dropped.
+// The number of dropped notifications since the previous allowed
+// notification is returned and reset when it's allowed.
+func (t *throttle) allow(r *rule, now time.Time) (int, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reason := t.reason(r, now)
+	if reason != "" {
+		r.suppressed++
+		return 0, reason
+	}
+	r.limit.add(now)
+	t.channels[r.kind].add(now)
+
+	suppressed := r.suppressed
+	r.suppressed = 0
+	return suppressed, ""
+}
+
+func (t *throttle) reason(r *rule, now time.Time) string {
+	for _, period := range t.quietHours {
+		if period.active(r.name, now) {
+			return reasonQuietHours
+		}
+	}
+	if r.limit.full(now) {
+		return reasonRateLimit
+	}
+	if t.channels[r.kind].full(now) {
+		return reasonChannelLimit
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"nvr/pkg/schedule"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThrottle(t *testing.T) {
+	now := time.Date(2025, 12, 28, 12, 0, 0, 0, time.UTC)
+	t.Run("ruleLimit", func(t *testing.T) {
+		th := &throttle{}
+		r := newRule("a", "smtp", Filter{MaxPerHour: 2}, nil)
+		suppressed, reason := th.allow(r, now)
+		require.Empty(t, reason)
+		require.Zero(t, suppressed)
+		_, reason = th.allow(r, now.Add(time.Minute))
+		require.Empty(t, reason)
+		_, reason = th.allow(r, now.Add(2*time.Minute))
+		require.Equal(t, reasonRateLimit, reason)
+		_, reason = th.allow(r, now.Add(3*time.Minute))
+		require.Equal(t, reasonRateLimit, reason)
+
+		suppressed, reason = th.allow(r, now.Add(time.Hour))
+		require.Empty(t, reason)
+		require.Equal(t, 2, suppressed)
+	})
+	t.Run("unlimited", func(t *testing.T) {
+		th := &throttle{}
+		r := newRule("a", "smtp", Filter{}, nil)
+		for i := 0; i < 100; i++ {
+			_, reason := th.allow(r, now)
+			require.Empty(t, reason)
+		}
+	})
+	t.Run("channelLimit", func(t *testing.T) {
+		th, err := newThrottle(Config{ChannelLimits: map[string]int{"ntfy": 1}})
+		require.NoError(t, err)
+		a := newRule("a", "ntfy", Filter{}, nil)
+		b := newRule("b", "ntfy", Filter{}, nil)
+		c := newRule("c", "smtp", Filter{}, nil)
+
+		_, reason := th.allow(a, now)
+		require.Empty(t, reason)
+		_, reason = th.allow(b, now)
+		require.Equal(t, reasonChannelLimit, reason)
+		_, reason = th.allow(c, now)
+		require.Empty(t, reason)
+
+		suppressed, reason := th.allow(b, now.Add(time.Hour))
+		require.Empty(t, reason)
+		require.Equal(t, 1, suppressed)
+	})
+	t.Run("quietHours", func(t *testing.T) {
+		th, err := newThrottle(Config{QuietHours: []QuietHours{
+			{Range: schedule.Range{Start: "11:00", End: "13:00"}, Rules: []string{"a"}},
+		}})
+		require.NoError(t, err)
+		a := newRule("a", "ntfy", Filter{MaxPerHour: 1}, nil)
+		b := newRule("b", "ntfy", Filter{}, nil)
+
+		_, reason := th.allow(a, now)
+		require.Equal(t, reasonQuietHours, reason)
+		_, reason = th.allow(b, now)
+		require.Empty(t, reason)
+
+		// Dropped notifications don't count towards the rate limit.
+		suppressed, reason := th.allow(a, now.Add(time.Hour))
+		require.Empty(t, reason)
+		require.Equal(t, 1, suppressed)
+	})
+	t.Run("invalidErr", func(t *testing.T) {
+		_, err := newThrottle(Config{QuietHours: []QuietHours{{Range: schedule.Range{Start: "x", End: "07:00"}}}})
+		require.ErrorIs(t, err, schedule.ErrInvalidTime)
+	})
+}
+
+func TestQuietPeriodActive(t *testing.T) {
+	at := func(clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2025, 12, 28, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	overnight := schedule.Range{Start: "22:00", End: "07:00"}
+	cases := map[string]struct {
+		config   QuietHours
+		time     time.Time
+		expected bool
+	}{
+		"inside":    {QuietHours{Range: overnight}, at("06:00"), true},
+		"outside":   {QuietHours{Range: overnight}, at("12:00"), false},
+		"rule":      {QuietHours{Range: overnight, Rules: []string{"a"}}, at("06:00"), true},
+		"otherRule": {QuietHours{Range: overnight, Rules: []string{"b"}}, at("06:00"), false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			period, err := newQuietPeriod(tc.config)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, period.active("a", tc.time))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// Time limit of a single request.
+const requestTimeout = 10 * time.Second
+
+type webhook struct {
+	url     string
+	method  string
+	headers map[string]string
+	tpl     *template.Template
+	client  *http.Client
+}
+
+func newWebhook(c WebhookConfig) (*webhook, error) {
+	w := &webhook{
+		url:     c.URL,
+		method:  c.Method,
+		headers: c.Headers,
+		client:  &http.Client{Timeout: requestTimeout},
+	}
+	if w.method == "" {
+		w.method = http.MethodPost
+	}
+	if c.Template != "" {
+		tpl, err := parseTemplate(c.Name, c.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.tpl = tpl
+	}
+	return w, nil
+}
+
+// body executes the template or marshals the default payload.
+func (w *webhook) body(n Notification) ([]byte, error) {
+	if w.tpl == nil {
+		return json.Marshal(n)
+	}
+	var buf bytes.Buffer
+	if err := w.tpl.Execute(&buf, n); err != nil {
+		return nil, fmt.Errorf("%w: execute template: %v", ErrRejected, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *webhook) send(ctx context.Context, n Notification) error {
+	body, err := w.body(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+
+	return do(w.client, req)
+}
@@ -0,0 +1,101 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	n := Notification{
+		MonitorID:   "m1",
+		MonitorName: "Front \"door\"",
+		Time:        time.Unix(0, 0).UTC(),
+		Label:       "person",
+		Score:       90,
+		Detections:  []storage.Detection{{Label: "person", Score: 90}},
+	}
+
+	type request struct {
+		method string
+		header http.Header
+		body   string
+	}
+	newServer := func(status int) (*httptest.Server, chan request) {
+		requests := make(chan request, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- request{r.Method, r.Header, string(body)}
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(server.Close)
+		return server, requests
+	}
+
+	t.Run("default", func(t *testing.T) {
+		server, requests := newServer(http.StatusOK)
+		w, err := newWebhook(WebhookConfig{
+			Name:    "a",
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer x"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.send(context.Background(), n))
+
+		req := <-requests
+		require.Equal(t, http.MethodPost, req.method)
+		require.Equal(t, "Bearer x", req.header.Get("Authorization"))
+
+		var actual Notification
+		require.NoError(t, json.Unmarshal([]byte(req.body), &actual))
+		require.Equal(t, n, actual)
+	})
+	t.Run("template", func(t *testing.T) {
+		server, requests := newServer(http.StatusNoContent)
+		w, err := newWebhook(WebhookConfig{
+			Name:     "a",
+			URL:      server.URL,
+			Method:   http.MethodPut,
+			Template: `{"text": {{ json (printf "%v: %v %.0f%%" .MonitorName .Label .Score) }}}`,
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.send(context.Background(), n))
+
+		req := <-requests
+		require.Equal(t, http.MethodPut, req.method)
+		require.Equal(t, `{"text": "Front \"door\": person 90%"}`, req.body)
+	})
+	t.Run("status", func(t *testing.T) {
+		server, _ := newServer(http.StatusBadRequest)
+		w, err := newWebhook(WebhookConfig{Name: "a", URL: server.URL})
+		require.NoError(t, err)
+		require.ErrorIs(t, w.send(context.Background(), n), ErrRejected)
+	})
+	t.Run("templateErr", func(t *testing.T) {
+		w, err := newWebhook(WebhookConfig{Name: "a", URL: "http://x", Template: "{{ .X }}"})
+		require.NoError(t, err)
+		require.ErrorIs(t, w.send(context.Background(), n), ErrRejected)
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package onnx
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config global addon config.
+type Config struct {
+	// Python interpreter with onnxruntime and numpy installed.
+	PythonBin string `json:"pythonBin"`
+
+	// Default execution provider, "cpu", "cuda", "tensorrt" or "openvino".
+	Provider string `json:"provider"`
+
+	// Detections below this score are dropped by the worker, 0-100.
+	MinScore float64 `json:"minScore"`
+
+	// Start the workers when the app starts instead of on the first
+	// detection, the first frames may time out while the model loads.
+	Preload bool `json:"preload"`
+
+	Models []ModelConfig `json:"models"`
+}
+
+// ModelConfig ONNX model.
+type ModelConfig struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// Output format, "yolov5", "yolov8" or "yolonas".
+	Type string `json:"type"`
+
+	// Input size.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Text file with one label per line in class order.
+	LabelsPath string `json:"labelsPath"`
+
+	// Overrides the default execution provider.
+	Provider string `json:"provider"`
+
+	// Number of worker processes shared by the monitors, defaults to 1.
+	Instances int `json:"instances"`
+}
+
+// ONNX Runtime execution providers. The CPU
+// provider is always used as a fallback.
+var providers = map[string]string{
+	"cpu":      "CPUExecutionProvider",
+	"cuda":     "CUDAExecutionProvider",
+	"tensorrt": "TensorrtExecutionProvider",
+	"openvino": "OpenVINOExecutionProvider",
+}
+
+var modelTypes = map[string]struct{}{
+	"yolov5":  {},
+	"yolov8":  {},
+	"yolonas": {},
+}
+
+// Config errors.
+var (
+	ErrUnknownProvider  = errors.New("unknown execution provider")
+	ErrUnknownType      = errors.New("unknown model type")
+	ErrNoModelName      = errors.New("model name is empty")
+	ErrInvalidSize      = errors.New("invalid model size")
+	ErrNoLabels         = errors.New("no labels")
+	ErrInvalidInstances = errors.New("invalid number of instances")
+)
+
+func (c Config) validate() error {
+	if _, exist := providers[c.Provider]; !exist {
+		return fmt.Errorf("%w: %q", ErrUnknownProvider, c.Provider)
+	}
+	for _, m := range c.Models {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("model %q: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m ModelConfig) validate() error {
+	if m.Name == "" {
+		return ErrNoModelName
+	}
+	if _, exist := modelTypes[m.Type]; !exist {
+		return fmt.Errorf("%w: %q", ErrUnknownType, m.Type)
+	}
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("%w: %vx%v", ErrInvalidSize, m.Width, m.Height)
+	}
+	if _, exist := providers[m.Provider]; m.Provider != "" && !exist {
+		return fmt.Errorf("%w: %q", ErrUnknownProvider, m.Provider)
+	}
+	if m.Instances < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidInstances, m.Instances)
+	}
+	return nil
+}
+
+// providerList returns the ONNX Runtime providers in order of preference.
+func (c Config) providerList(m ModelConfig) string {
+	provider := c.Provider
+	if m.Provider != "" {
+		provider = m.Provider
+	}
+	if provider == "cpu" {
+		return providers["cpu"]
+	}
+	return providers[provider] + "," + providers["cpu"]
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "onnx.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		if err := genConfig(configDir); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+//go:embed coco.txt
+var cocoLabels string
+
+func defaultConfig(configDir string) Config {
+	modelDir := filepath.Join(configDir, "onnx")
+	return Config{
+		PythonBin: "python3",
+		Provider:  "cpu",
+		MinScore:  10,
+		Preload:   true,
+		Models: []ModelConfig{{
+			Name:       "yolov8n",
+			Path:       filepath.Join(modelDir, "yolov8n.onnx"),
+			Type:       "yolov8",
+			Width:      640,
+			Height:     640,
+			LabelsPath: filepath.Join(modelDir, "coco.txt"),
+			Instances:  1,
+		}},
+	}
+}
+
+// genConfig writes the default config and the COCO labels.
+func genConfig(configDir string) error {
+	modelDir := filepath.Join(configDir, "onnx")
+	if err := os.MkdirAll(modelDir, 0o700); err != nil {
+		return err
+	}
+	labelsPath := filepath.Join(modelDir, "coco.txt")
+	if err := os.WriteFile(labelsPath, []byte(cocoLabels), 0o600); err != nil {
+		return err
+	}
+
+	data, _ := json.MarshalIndent(defaultConfig(configDir), "", "    ")
+	return os.WriteFile(filepath.Join(configDir, "onnx.json"), data, 0o600)
+}
+
+func readLabels(path string) ([]string, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, line := range strings.Split(string(file), "\n") {
+		if label := strings.TrimSpace(line); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoLabels, path)
+	}
+	return labels, nil
+}
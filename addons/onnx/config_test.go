@@ -0,0 +1,93 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package onnx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		configDir := t.TempDir()
+		config, err := readConfig(configDir)
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig(configDir), *config)
+
+		labels, err := readLabels(config.Models[0].LabelsPath)
+		require.NoError(t, err)
+		require.Len(t, labels, 80)
+		require.Equal(t, "person", labels[0])
+	})
+	t.Run("invalid", func(t *testing.T) {
+		configDir := t.TempDir()
+		raw := `{"provider":"cpu","models":[{"name":"a","type":"x","width":1,"height":1}]}`
+		err := os.WriteFile(filepath.Join(configDir, "onnx.json"), []byte(raw), 0o600)
+		require.NoError(t, err)
+
+		_, err = readConfig(configDir)
+		require.ErrorIs(t, err, ErrUnknownType)
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	valid := ModelConfig{Name: "a", Type: "yolov8", Width: 640, Height: 640}
+	cases := map[string]struct {
+		provider    string
+		model       func(*ModelConfig)
+		expectedErr error
+	}{
+		"ok":            {"cuda", func(m *ModelConfig) {}, nil},
+		"provider":      {"x", func(m *ModelConfig) {}, ErrUnknownProvider},
+		"modelProvider": {"cpu", func(m *ModelConfig) { m.Provider = "x" }, ErrUnknownProvider},
+		"instances":     {"cpu", func(m *ModelConfig) { m.Instances = -1 }, ErrInvalidInstances},
+		"name":          {"cpu", func(m *ModelConfig) { m.Name = "" }, ErrNoModelName},
+		"type":          {"cpu", func(m *ModelConfig) { m.Type = "x" }, ErrUnknownType},
+		"size":          {"cpu", func(m *ModelConfig) { m.Height = 0 }, ErrInvalidSize},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := valid
+			tc.model(&m)
+			c := Config{Provider: tc.provider, Models: []ModelConfig{m}}
+			require.ErrorIs(t, c.validate(), tc.expectedErr)
+		})
+	}
+}
+
+func TestProviderList(t *testing.T) {
+	c := Config{Provider: "cuda"}
+	require.Equal(t,
+		"CUDAExecutionProvider,CPUExecutionProvider",
+		c.providerList(ModelConfig{}))
+	require.Equal(t,
+		"CPUExecutionProvider",
+		c.providerList(ModelConfig{Provider: "cpu"}))
+}
+
+func TestReadLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "labels.txt")
+	require.NoError(t, os.WriteFile(path, []byte("a\n b \n\n"), 0o600))
+	labels, err := readLabels(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, labels)
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0o600))
+	_, err = readLabels(path)
+	require.ErrorIs(t, err, ErrNoLabels)
+}
@@ -0,0 +1,157 @@
+#!/usr/bin/env python3
+#
+# Copyright 2020-2022 The OS-NVR Authors.
+#
+# This program is free software: you can redistribute it and/or modify
+# it under the terms of the GNU General Public License as published by
+# the Free Software Foundation; either version 2 of the License, or
+# (at your option) any later version.
+#
+# This program is distributed in the hope that it will be useful,
+# but WITHOUT ANY WARRANTY; without even the implied warranty of
+# MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+# GNU General Public License for more details.
+#
+# You should have received a copy of the GNU General Public License
+# along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+# ONNX Runtime worker started by the onnx addon, one per model.
+#
+# Reads RGB24 frames of width*height*3 bytes from stdin and writes
+# one JSON line for each frame to stdout. Box coordinates are
+# [top, left, bottom, right] relative to the frame size.
+#
+#   {"detections": [{"class": 0, "score": 0.9, "box": [0.1, 0.2, 0.3, 0.4]}]}
+#   {"error": "message"}
+
+import argparse
+import json
+import sys
+
+import numpy as np
+import onnxruntime as ort
+
+
+def parse_args():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("--model", required=True)
+    parser.add_argument("--type", required=True, choices=["yolov5", "yolov8", "yolonas"])
+    parser.add_argument("--width", required=True, type=int)
+    parser.add_argument("--height", required=True, type=int)
+    parser.add_argument("--providers", required=True)
+    parser.add_argument("--min-score", default=0.1, type=float)
+    parser.add_argument("--iou", default=0.45, type=float)
+    return parser.parse_args()
+
+
+def nms(boxes, scores, classes, iou_threshold):
+    """Non-maximum suppression for each class. Boxes are x1, y1, x2, y2."""
+    # Offset the boxes by class so that different classes never overlap.
+    offset = classes[:, None] * (boxes.max() + 1)
+    b = boxes + offset
+    x1, y1, x2, y2 = b[:, 0], b[:, 1], b[:, 2], b[:, 3]
+    areas = (x2 - x1) * (y2 - y1)
+
+    keep = []
+    order = scores.argsort()[::-1]
+    while order.size > 0:
+        i = order[0]
+        keep.append(i)
+        xx1 = np.maximum(x1[i], x1[order[1:]])
+        yy1 = np.maximum(y1[i], y1[order[1:]])
+        xx2 = np.minimum(x2[i], x2[order[1:]])
+        yy2 = np.minimum(y2[i], y2[order[1:]])
+        inter = np.maximum(0, xx2 - xx1) * np.maximum(0, yy2 - yy1)
+        iou = inter / (areas[i] + areas[order[1:]] - inter + 1e-9)
+        order = order[1:][iou <= iou_threshold]
+    return keep
+
+
+def center_to_corners(boxes):
+    cx, cy, w, h = boxes[:, 0], boxes[:, 1], boxes[:, 2], boxes[:, 3]
+    return np.stack([cx - w / 2, cy - h / 2, cx + w / 2, cy + h / 2], axis=1)
+
+
+def postprocess_yolov5(outputs):
+    # (1, N, 5+classes) center boxes, objectness and class scores.
+    out = outputs[0][0]
+    class_scores = out[:, 5:] * out[:, 4:5]
+    return center_to_corners(out[:, :4]), class_scores
+
+
+def postprocess_yolov8(outputs):
+    # (1, 4+classes, N) center boxes and class scores.
+    out = outputs[0][0].T
+    return center_to_corners(out[:, :4]), out[:, 4:]
+
+
+def postprocess_yolonas(outputs):
+    # (1, N, 4) corner boxes and (1, N, classes) class scores.
+    return outputs[0][0], outputs[1][0]
+
+
+POSTPROCESS = {
+    "yolov5": postprocess_yolov5,
+    "yolov8": postprocess_yolov8,
+    "yolonas": postprocess_yolonas,
+}
+
+
+def detect(session, args, frame):
+    inp = session.get_inputs()[0]
+    tensor = frame.transpose(2, 0, 1)[None]
+    if "uint8" in inp.type:
+        tensor = np.ascontiguousarray(tensor)
+    elif "float16" in inp.type:
+        tensor = tensor.astype(np.float16) / 255
+    else:
+        tensor = tensor.astype(np.float32) / 255
+
+    outputs = session.run(None, {inp.name: tensor})
+    boxes, class_scores = POSTPROCESS[args.type](outputs)
+
+    classes = class_scores.argmax(axis=1)
+    scores = class_scores[np.arange(len(classes)), classes]
+    mask = scores >= args.min_score
+    boxes, scores, classes = boxes[mask], scores[mask], classes[mask]
+    if len(scores) == 0:
+        return []
+
+    detections = []
+    for i in nms(boxes, scores, classes, args.iou):
+        x1, y1, x2, y2 = boxes[i]
+        detections.append({
+            "class": int(classes[i]),
+            "score": float(scores[i]),
+            "box": [
+                float(y1) / args.height,
+                float(x1) / args.width,
+                float(y2) / args.height,
+                float(x2) / args.width,
+            ],
+        })
+    return detections
+
+
+def main():
+    args = parse_args()
+    session = ort.InferenceSession(args.model, providers=args.providers.split(","))
+    print("providers: " + ",".join(session.get_providers()), file=sys.stderr, flush=True)
+
+    frame_size = args.width * args.height * 3
+    stdin = sys.stdin.buffer
+    while True:
+        data = stdin.read(frame_size)
+        if len(data) < frame_size:
+            return
+        frame = np.frombuffer(data, dtype=np.uint8).reshape(args.height, args.width, 3)
+        try:
+            response = {"detections": detect(session, args, frame)}
+        except Exception as e:  # pylint: disable=broad-except
+            response = {"error": str(e)}
+        sys.stdout.write(json.dumps(response) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,176 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"context"
+	"fmt"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/onvif"
+	"nvr/pkg/storage"
+	"time"
+)
+
+func init() {
+	nvr.RegisterMonitorStartHook(onMonitorStart)
+	nvr.RegisterLogSource([]string{"onvif"})
+	nvr.RegisterTplHook(modifyTemplates)
+}
+
+const (
+	// Subscriptions expire if they aren't renewed, this
+	// removes them from the camera if the NVR crashes.
+	subscriptionTermination = 60 * time.Second
+	subscriptionRenewal     = 30 * time.Second
+
+	pullTimeout  = 5 * time.Second
+	pullLimit    = 10
+	retryTimeout = 10 * time.Second
+)
+
+func onMonitorStart(ctx context.Context, m *monitor.Monitor) {
+	id := m.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		m.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "onvif",
+			MonitorID: id,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+
+	config, enable, err := parseConfig(m.Config)
+	if err != nil {
+		logf(log.LevelError, "could not parse config: %v", err)
+		return
+	}
+	if !enable {
+		return
+	}
+
+	s := &subscriber{
+		config:    *config,
+		sendEvent: m.SendEvent,
+		logf:      logf,
+
+		pullTimeout:  pullTimeout,
+		retryTimeout: retryTimeout,
+	}
+
+	m.WG.Add(1)
+	go func() {
+		defer m.WG.Done()
+		s.run(ctx)
+	}()
+}
+
+type subscriber struct {
+	config    config
+	sendEvent monitor.SendEventFunc
+	logf      log.Func
+
+	pullTimeout  time.Duration
+	retryTimeout time.Duration
+}
+
+// run subscribes to the camera events until the context is canceled.
+func (s *subscriber) run(ctx context.Context) {
+	for {
+		err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logf(log.LevelError, "%v", err)
+
+		select {
+		case <-time.After(s.retryTimeout):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *subscriber) session(ctx context.Context) error {
+	c, err := onvif.NewClient(s.config.address, s.config.username, s.config.password)
+	if err != nil {
+		return err
+	}
+	// Pull requests are held open by the camera.
+	c.HTTPClient.Timeout = s.pullTimeout + 10*time.Second
+
+	// Not all devices support this, the offset is optional.
+	c.SyncTime(ctx) //nolint:errcheck
+
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if caps.Events == "" {
+		return onvif.ErrNoEventService
+	}
+
+	sub, err := c.CreatePullPointSubscription(ctx, caps.Events, subscriptionTermination)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx2, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sub.Unsubscribe(ctx2) //nolint:errcheck
+	}()
+	s.logf(log.LevelInfo, "subscribed to events")
+
+	renewAt := time.Now().Add(subscriptionRenewal)
+	for {
+		notifications, err := sub.PullMessages(ctx, s.pullTimeout, pullLimit)
+		if err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			s.handle(n)
+		}
+
+		if time.Now().After(renewAt) {
+			if err := sub.Renew(ctx, subscriptionTermination); err != nil {
+				return err
+			}
+			renewAt = time.Now().Add(subscriptionRenewal)
+		}
+	}
+}
+
+func (s *subscriber) handle(n onvif.Notification) {
+	label, active := classify(s.config.eventTypes, n)
+	if !active {
+		return
+	}
+	s.logf(log.LevelDebug, "event: %v %v", label, n.Topic)
+
+	// The camera clock may be wrong, the local time is used instead.
+	err := s.sendEvent(storage.Event{
+		Time: time.Now(),
+		Detections: []storage.Detection{{
+			Label: label,
+			Score: 100,
+		}},
+		RecDuration: s.config.recDuration,
+	})
+	if err != nil {
+		s.logf(log.LevelError, "could not send event: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCamera(t *testing.T, unsubscribed chan struct{}) *httptest.Server {
+	t.Helper()
+	pulls := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := string(body)
+
+		var res string
+		switch {
+		case strings.Contains(req, "<GetCapabilities"):
+			res = `<GetCapabilitiesResponse><Capabilities>` +
+				`<Events><XAddr>` + server.URL + `/events</XAddr></Events>` +
+				`</Capabilities></GetCapabilitiesResponse>`
+		case strings.Contains(req, "<CreatePullPointSubscription"):
+			res = `<CreatePullPointSubscriptionResponse><SubscriptionReference>` +
+				`<Address>` + server.URL + `/subscription</Address>` +
+				`</SubscriptionReference></CreatePullPointSubscriptionResponse>`
+		case strings.Contains(req, "<PullMessages"):
+			pulls++
+			if pulls == 1 {
+				res = `<PullMessagesResponse><NotificationMessage>` +
+					`<Topic>tns1:RuleEngine/CellMotionDetector/Motion</Topic>` +
+					`<Message><Message PropertyOperation="Changed">` +
+					`<Data><SimpleItem Name="IsMotion" Value="true"/></Data>` +
+					`</Message></Message>` +
+					`</NotificationMessage></PullMessagesResponse>`
+			} else {
+				time.Sleep(10 * time.Millisecond)
+				res = `<PullMessagesResponse/>`
+			}
+		case strings.Contains(req, "<Unsubscribe"):
+			close(unsubscribed)
+			res = `<UnsubscribeResponse/>`
+		}
+		w.Write([]byte(`<Envelope><Body>` + res + `</Body></Envelope>`)) //nolint:errcheck
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSubscriber(t *testing.T) {
+	unsubscribed := make(chan struct{})
+	camera := newTestCamera(t, unsubscribed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan storage.Event)
+	s := &subscriber{
+		config: config{
+			address:     camera.URL + "/onvif/device_service",
+			recDuration: time.Minute,
+			eventTypes:  eventTypes,
+		},
+		sendEvent: func(e storage.Event) error {
+			events <- e
+			return nil
+		},
+		logf:         func(log.Level, string, ...interface{}) {},
+		pullTimeout:  time.Second,
+		retryTimeout: time.Second,
+	}
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	event := <-events
+	require.Equal(t, []storage.Detection{{Label: "motion", Score: 100}}, event.Detections)
+	require.Equal(t, time.Minute, event.RecDuration)
+	require.NoError(t, event.Validate())
+
+	cancel()
+	<-unsubscribed
+	<-done
+}
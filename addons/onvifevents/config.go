@@ -0,0 +1,83 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/monitor"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config errors.
+var (
+	ErrAddressMissing   = errors.New("onvif address missing")
+	ErrUnknownEventType = errors.New("unknown event type")
+)
+
+const defaultRecDuration = 120 * time.Second
+
+type config struct {
+	monitorID   string
+	address     string
+	username    string
+	password    string
+	recDuration time.Duration
+	eventTypes  []eventType
+}
+
+func parseConfig(c monitor.Config) (*config, bool, error) {
+	if c.Get("onvifEvents") != "true" {
+		return nil, false, nil
+	}
+
+	address := c.Get("onvifAddress")
+	if address == "" {
+		return nil, false, ErrAddressMissing
+	}
+
+	recDuration := defaultRecDuration
+	if raw := c.Get("onvifRecDuration"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, false, fmt.Errorf("parse trigger duration: %w", err)
+		}
+		recDuration = time.Duration(seconds) * time.Second
+	}
+
+	types := eventTypes
+	if raw := c.Get("onvifEventTypes"); raw != "" {
+		types = nil
+		for _, label := range strings.Split(raw, ",") {
+			t, exist := eventTypeByLabel(strings.TrimSpace(label))
+			if !exist {
+				return nil, false, fmt.Errorf("%w: %q", ErrUnknownEventType, label)
+			}
+			types = append(types, t)
+		}
+	}
+
+	return &config{
+		monitorID:   c.ID(),
+		address:     address,
+		username:    c.Get("onvifUsername"),
+		password:    c.Get("onvifPassword"),
+		recDuration: recDuration,
+		eventTypes:  types,
+	}, true, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"nvr/pkg/monitor"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		_, enable, err := parseConfig(monitor.NewConfig(monitor.RawConfig{}))
+		require.NoError(t, err)
+		require.False(t, enable)
+	})
+	t.Run("defaults", func(t *testing.T) {
+		c, enable, err := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"id":            "1",
+			"onvifEvents":   "true",
+			"onvifAddress":  "http://x",
+			"onvifUsername": "a",
+			"onvifPassword": "b",
+		}))
+		require.NoError(t, err)
+		require.True(t, enable)
+
+		expected := &config{
+			monitorID:   "1",
+			address:     "http://x",
+			username:    "a",
+			password:    "b",
+			recDuration: defaultRecDuration,
+			eventTypes:  eventTypes,
+		}
+		require.Equal(t, expected, c)
+	})
+	t.Run("eventTypes", func(t *testing.T) {
+		c, _, err := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"onvifEvents":      "true",
+			"onvifAddress":     "http://x",
+			"onvifEventTypes":  "tamper, motion",
+			"onvifRecDuration": "30",
+		}))
+		require.NoError(t, err)
+		require.Len(t, c.eventTypes, 2)
+		require.Equal(t, "tamper", c.eventTypes[0].label)
+		require.Equal(t, "motion", c.eventTypes[1].label)
+		require.Equal(t, 30*time.Second, c.recDuration)
+	})
+	t.Run("unknownEventType", func(t *testing.T) {
+		_, _, err := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"onvifEvents":     "true",
+			"onvifAddress":    "http://x",
+			"onvifEventTypes": "nil",
+		}))
+		require.ErrorIs(t, err, ErrUnknownEventType)
+	})
+	t.Run("addressMissing", func(t *testing.T) {
+		_, _, err := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"onvifEvents": "true",
+		}))
+		require.ErrorIs(t, err, ErrAddressMissing)
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"nvr/pkg/onvif"
+	"strings"
+)
+
+// eventType maps ONVIF topics to a detection label.
+type eventType struct {
+	label  string
+	topics []string
+
+	// Data items that are "true" when the event is active.
+	stateKeys []string
+
+	// The event has no state, every message is a detection.
+	pulse bool
+}
+
+// Topics used by Profile S and T cameras. Topics may have
+// vendor specific sub topics, so they're matched by prefix.
+var eventTypes = []eventType{
+	{
+		label: "motion",
+		topics: []string{
+			"VideoSource/MotionAlarm",
+			"RuleEngine/CellMotionDetector/Motion",
+			"RuleEngine/MotionRegionDetector/Motion",
+		},
+		stateKeys: []string{"State", "IsMotion"},
+	},
+	{
+		label: "tamper",
+		topics: []string{
+			"VideoSource/GlobalSceneChange",
+			"RuleEngine/TamperDetector/Tamper",
+		},
+		stateKeys: []string{"State", "IsTamper"},
+	},
+	{
+		label:  "line-crossing",
+		topics: []string{"RuleEngine/LineDetector/Crossed"},
+		pulse:  true,
+	},
+	{
+		label:     "intrusion",
+		topics:    []string{"RuleEngine/FieldDetector/ObjectsInside"},
+		stateKeys: []string{"IsInside"},
+	},
+}
+
+func eventTypeByLabel(label string) (eventType, bool) {
+	for _, t := range eventTypes {
+		if t.label == label {
+			return t, true
+		}
+	}
+	return eventType{}, false
+}
+
+// classify returns the label of an active event, or false if
+// the notification is unknown or reports an inactive state.
+func classify(types []eventType, n onvif.Notification) (string, bool) {
+	for _, t := range types {
+		if !t.matchTopic(n.Topic) {
+			continue
+		}
+		if t.pulse {
+			return t.label, n.Operation != "Initialized"
+		}
+		for _, key := range t.stateKeys {
+			if strings.EqualFold(n.Data[key], "true") {
+				return t.label, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+func (t eventType) matchTopic(topic string) bool {
+	for _, prefix := range t.topics {
+		if strings.HasPrefix(topic, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"nvr/pkg/onvif"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	cases := map[string]struct {
+		notification onvif.Notification
+		label        string
+		active       bool
+	}{
+		"cellMotion": {
+			onvif.Notification{
+				Topic: "RuleEngine/CellMotionDetector/Motion",
+				Data:  map[string]string{"IsMotion": "true"},
+			},
+			"motion", true,
+		},
+		"motionInactive": {
+			onvif.Notification{
+				Topic: "RuleEngine/CellMotionDetector/Motion",
+				Data:  map[string]string{"IsMotion": "false"},
+			},
+			"", false,
+		},
+		"motionAlarm": {
+			onvif.Notification{
+				Topic: "VideoSource/MotionAlarm",
+				Data:  map[string]string{"State": "True"},
+			},
+			"motion", true,
+		},
+		"tamper": {
+			onvif.Notification{
+				Topic: "RuleEngine/TamperDetector/Tamper",
+				Data:  map[string]string{"IsTamper": "true"},
+			},
+			"tamper", true,
+		},
+		"lineCrossing": {
+			onvif.Notification{
+				Topic:     "RuleEngine/LineDetector/Crossed",
+				Operation: "Changed",
+				Data:      map[string]string{"ObjectId": "1"},
+			},
+			"line-crossing", true,
+		},
+		"lineCrossingInitialized": {
+			onvif.Notification{
+				Topic:     "RuleEngine/LineDetector/Crossed",
+				Operation: "Initialized",
+			},
+			"line-crossing", false,
+		},
+		"subTopic": {
+			onvif.Notification{
+				Topic: "RuleEngine/FieldDetector/ObjectsInside/Vendor",
+				Data:  map[string]string{"IsInside": "true"},
+			},
+			"intrusion", true,
+		},
+		"unknown": {
+			onvif.Notification{
+				Topic: "Device/Trigger/DigitalInput",
+				Data:  map[string]string{"LogicalState": "true"},
+			},
+			"", false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			label, active := classify(eventTypes, tc.notification)
+			require.Equal(t, tc.active, active)
+			if active {
+				require.Equal(t, tc.label, label)
+			}
+		})
+	}
+
+	t.Run("disabledType", func(t *testing.T) {
+		motion, _ := eventTypeByLabel("motion")
+		_, active := classify([]eventType{motion}, onvif.Notification{
+			Topic: "RuleEngine/TamperDetector/Tamper",
+			Data:  map[string]string{"IsTamper": "true"},
+		})
+		require.False(t, active)
+	})
+}
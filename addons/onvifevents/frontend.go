@@ -0,0 +1,93 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvifevents
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func modifyTemplates(pageFiles map[string]string) error {
+	js, exists := pageFiles["settings.js"]
+	if !exists {
+		return fmt.Errorf("onvif: settings.js: %w", os.ErrNotExist)
+	}
+
+	pageFiles["settings.js"] = modifySettingsjs(js)
+	return nil
+}
+
+func modifySettingsjs(tpl string) string {
+	const target = "logLevel: fieldTemplate.select("
+
+	const javascript = `
+		onvifEvents: fieldTemplate.toggle("ONVIF events", "false"),
+		onvifAddress: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "ONVIF address",
+				placeholder: "http://x.x.x.x/onvif/device_service",
+			}
+		),
+		onvifUsername: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "ONVIF username",
+			}
+		),
+		onvifPassword: newField(
+			[],
+			{
+				input: "password",
+			},
+			{
+				label: "ONVIF password",
+			}
+		),
+		onvifEventTypes: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "text",
+			},
+			{
+				label: "ONVIF event types",
+				placeholder: "motion,tamper,line-crossing,intrusion",
+			}
+		),
+		onvifRecDuration: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "1",
+				step: "1",
+			},
+			{
+				label: "ONVIF trigger duration (sec)",
+				placeholder: "120",
+			}
+		),`
+
+	return strings.ReplaceAll(tpl, target, javascript+target)
+}
@@ -0,0 +1,217 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package preview
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"preview"})
+	nvr.RegisterMonitorRecSavedHook(onRecSaved)
+	nvr.RegisterTplHook(modifyTemplates)
+
+	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+		go runWorker(ctx, queue)
+		app.Router.Handle(
+			"/api/recording/preview/",
+			app.Auth.User(handlePreview(app.Env.RecordingsDirs())),
+		)
+		return nil
+	})
+}
+
+// Recordings waiting for a preview. Previews are generated
+// one at a time to limit the load during event bursts.
+var queue = make(chan job, queueSize)
+
+const queueSize = 100
+
+type job struct {
+	recPath    string
+	ffmpegBin  string
+	logLevel   string
+	crypt      *storage.Crypt
+	config     config
+	newProcess ffmpeg.NewProcessFunc
+	logf       log.Func
+}
+
+func onRecSaved(r *monitor.Recorder, recPath string, _ storage.RecordingData) {
+	id := r.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		r.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "preview",
+			MonitorID: id,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+
+	j := job{
+		recPath:    recPath,
+		ffmpegBin:  r.Env.FFmpegBin,
+		logLevel:   r.Config.LogLevel(),
+		crypt:      r.Env.Crypt,
+		config:     parseConfig(r.Config),
+		newProcess: r.NewProcess,
+		logf:       logf,
+	}
+	select {
+	case queue <- j:
+	default:
+		logf(log.LevelWarning, "queue full, skipping: %v", filepath.Base(recPath))
+	}
+}
+
+func runWorker(ctx context.Context, queue <-chan job) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-queue:
+			if err := generate(ctx, j); err != nil {
+				j.logf(log.LevelError, "%v", err)
+			}
+		}
+	}
+}
+
+// Maximum time to wait for FFmpeg.
+const generateTimeout = time.Minute
+
+func generate(ctx context.Context, j job) error {
+	var video io.ReadCloser
+	var err error
+	if storage.IsMKVRecording(j.recPath) {
+		video, err = storage.OpenRecordingFile(j.recPath+".mkv", j.crypt)
+	} else {
+		video, err = storage.NewVideoReader(j.recPath, nil, j.crypt)
+	}
+	if err != nil {
+		return fmt.Errorf("video reader: %w", err)
+	}
+	defer video.Close()
+
+	tempPath := j.recPath + ".webp_tmp"
+	previewPath := j.recPath + ".webp"
+
+	args := genArgs(j.logLevel, tempPath, j.config)
+	cmd := exec.Command(j.ffmpegBin, args...)
+	cmd.Stdin = video
+
+	logFunc := func(msg string) {
+		j.logf(log.FFmpegLevel(j.logLevel), "process: %v", msg)
+	}
+	process := j.newProcess(cmd).
+		StdoutLogger(logFunc).
+		StderrLogger(logFunc)
+
+	ctx, cancel := context.WithTimeout(ctx, generateTimeout)
+	defer cancel()
+	if err := process.Start(ctx); err != nil {
+		os.Remove(tempPath) //nolint:errcheck
+		return fmt.Errorf("could not generate preview: %w %v", err, args)
+	}
+
+	if err := os.Rename(tempPath, previewPath); err != nil {
+		return fmt.Errorf("could not rename temp file: %w", err)
+	}
+	j.logf(log.LevelDebug, "done: %v", filepath.Base(previewPath))
+	return nil
+}
+
+func genArgs(logLevel string, outputPath string, c config) []string {
+	fps := strconv.FormatFloat(c.frameRate, 'f', -1, 64)
+	duration := strconv.FormatFloat(c.duration, 'f', -1, 64)
+	return []string{
+		"-n", "-loglevel", logLevel, "-threads", "1",
+		"-i", "-", "-an", "-t", duration,
+		"-vf", "fps=" + fps + ",scale=" + strconv.Itoa(c.width) + ":-2",
+		"-c:v", "libwebp", "-lossless", "0", "-q:v", quality,
+		"-loop", "0", "-f", "webp", outputPath,
+	}
+}
+
+// Default values.
+const (
+	defaultFrameRate = 5
+	defaultWidth     = 320
+	defaultDuration  = 5
+)
+
+// WebP quality, 0-100.
+const quality = "50"
+
+type config struct {
+	frameRate float64
+	width     int
+	duration  float64
+}
+
+// parseConfig invalid and empty values are replaced by the defaults.
+func parseConfig(c monitor.Config) config {
+	conf := config{
+		frameRate: defaultFrameRate,
+		width:     defaultWidth,
+		duration:  defaultDuration,
+	}
+	if v, err := strconv.ParseFloat(c.Get("previewFrameRate"), 64); err == nil && v > 0 && v <= 30 {
+		conf.frameRate = v
+	}
+	if v, err := strconv.Atoi(c.Get("previewWidth")); err == nil && v > 0 && v <= 1920 {
+		conf.width = v
+	}
+	if v, err := strconv.ParseFloat(c.Get("previewDuration"), 64); err == nil && v > 0 && v <= 60 {
+		conf.duration = v
+	}
+	return conf
+}
+
+func handlePreview(recordingsDirs []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := r.URL.Path[23:] // Trim "/api/recording/preview/"
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, recPath)
+		path := filepath.Join(recordingsDir, recPath+".webp")
+
+		// ServeFile will sanitize ".."
+		http.ServeFile(w, r, path)
+	})
+}
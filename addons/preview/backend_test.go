@@ -0,0 +1,107 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package preview
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/ffmpeg/ffmock"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenArgs(t *testing.T) {
+	actual := genArgs("error", "out", config{
+		frameRate: 2.5,
+		width:     160,
+		duration:  10,
+	})
+	expected := []string{
+		"-n", "-loglevel", "error", "-threads", "1",
+		"-i", "-", "-an", "-t", "10",
+		"-vf", "fps=2.5,scale=160:-2",
+		"-c:v", "libwebp", "-lossless", "0", "-q:v", "50",
+		"-loop", "0", "-f", "webp", "out",
+	}
+	require.Equal(t, expected, actual)
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		actual := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"previewFrameRate": "x",
+			"previewWidth":     "0",
+		}))
+		expected := config{
+			frameRate: defaultFrameRate,
+			width:     defaultWidth,
+			duration:  defaultDuration,
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("custom", func(t *testing.T) {
+		actual := parseConfig(monitor.NewConfig(monitor.RawConfig{
+			"previewFrameRate": "10",
+			"previewWidth":     "640",
+			"previewDuration":  "3",
+		}))
+		require.Equal(t, config{frameRate: 10, width: 640, duration: 3}, actual)
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	newJob := func(t *testing.T, newProcess ffmpeg.NewProcessFunc) job {
+		recPath := filepath.Join(t.TempDir(), "rec")
+		require.NoError(t, os.WriteFile(recPath+".mkv", []byte("video"), 0o600))
+		return job{
+			recPath:    recPath,
+			ffmpegBin:  "ffmpeg",
+			logLevel:   "error",
+			config:     config{frameRate: 1, width: 1, duration: 1},
+			newProcess: newProcess,
+			logf:       func(log.Level, string, ...interface{}) {},
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		newProcess := func(cmd *exec.Cmd) ffmpeg.Process {
+			output := cmd.Args[len(cmd.Args)-1]
+			require.True(t, strings.HasSuffix(output, ".webp_tmp"))
+			require.NoError(t, os.WriteFile(output, []byte("webp"), 0o600))
+			return ffmock.NewProcess(cmd)
+		}
+		j := newJob(t, newProcess)
+		require.NoError(t, generate(context.Background(), j))
+
+		preview, err := os.ReadFile(j.recPath + ".webp")
+		require.NoError(t, err)
+		require.Equal(t, []byte("webp"), preview)
+	})
+	t.Run("processErr", func(t *testing.T) {
+		j := newJob(t, ffmock.NewProcessErr)
+		require.ErrorIs(t, generate(context.Background(), j), ffmock.ErrMock)
+		_, err := os.Stat(j.recPath + ".webp")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
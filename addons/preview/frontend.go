@@ -0,0 +1,82 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package preview
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func modifyTemplates(pageFiles map[string]string) error {
+	js, exists := pageFiles["settings.js"]
+	if !exists {
+		return fmt.Errorf("preview: settings.js: %w", os.ErrNotExist)
+	}
+	pageFiles["settings.js"] = modifySettingsjs(js)
+
+	tpl, exists := pageFiles["recordings.tpl"]
+	if !exists {
+		return fmt.Errorf("preview: recordings.tpl: %w", os.ErrNotExist)
+	}
+	pageFiles["recordings.tpl"] = modifyRecordingsTpl(tpl)
+	return nil
+}
+
+func modifySettingsjs(tpl string) string {
+	const target = "timestampOffset: fieldTemplate.integer("
+
+	const javascript = `
+		previewFrameRate: fieldTemplate.text("Preview frame rate", "5", ""),
+		previewWidth: fieldTemplate.integer("Preview width (px)", "320", ""),
+		previewDuration: fieldTemplate.text("Preview duration (sec)", "5", ""),`
+
+	return strings.ReplaceAll(tpl, target, javascript+target)
+}
+
+// modifyRecordingsTpl shows the animated preview while
+// the mouse is over a thumbnail. The thumbnail is kept
+// if the recording doesn't have a preview.
+func modifyRecordingsTpl(tpl string) string {
+	const target = "</body>"
+
+	const javascript = `
+	<script>
+		const $grid = document.querySelector("#content-grid");
+		$grid.addEventListener("mouseover", (e) => {
+			const $img = e.target;
+			if ($img.tagName !== "IMG" || !$img.src.includes("api/recording/thumbnail/")) {
+				return;
+			}
+			if ($img.dataset.noPreview) {
+				return;
+			}
+			const thumbSrc = $img.src;
+			$img.onerror = () => {
+				$img.dataset.noPreview = true;
+				$img.onerror = undefined;
+				$img.src = thumbSrc;
+			};
+			$img.src = thumbSrc.replace("api/recording/thumbnail/", "api/recording/preview/");
+			$img.addEventListener("mouseout", () => {
+				$img.onerror = undefined;
+				$img.src = thumbSrc;
+			}, { once: true });
+		});
+	</script>`
+
+	return strings.ReplaceAll(tpl, target, javascript+target)
+}
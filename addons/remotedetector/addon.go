@@ -0,0 +1,172 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr"
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"remotedetector"})
+	nvr.RegisterAppRunHook(onAppRun)
+}
+
+// Config global addon config.
+type Config struct {
+	Servers []ServerConfig `json:"servers"`
+
+	// Seconds between health checks.
+	HealthInterval int `json:"healthInterval"`
+}
+
+// ServerConfig remote detection server.
+type ServerConfig struct {
+	// Prefix of the detector names.
+	Name string `json:"name"`
+
+	// Host and port, for example "192.168.1.10:50051".
+	Address string `json:"address"`
+
+	// Use TLS instead of plain HTTP/2.
+	TLS bool `json:"tls"`
+
+	// Frame encoding, "rgb24" or "jpeg".
+	Encoding string `json:"encoding"`
+
+	// Maximum number of frames without a response.
+	MaxInFlight int `json:"maxInFlight"`
+}
+
+const defaultHealthInterval = 10
+
+// Config errors.
+var (
+	ErrNoServerName    = errors.New("server name is empty")
+	ErrNoAddress       = errors.New("server address is empty")
+	ErrUnknownEncoding = errors.New("unknown encoding")
+	ErrInvalidInFlight = errors.New("invalid max in flight")
+)
+
+func (c *Config) fillMissing() {
+	if c.HealthInterval <= 0 {
+		c.HealthInterval = defaultHealthInterval
+	}
+	for i := range c.Servers {
+		if c.Servers[i].Encoding == "" {
+			c.Servers[i].Encoding = defaultEncoding
+		}
+		if c.Servers[i].MaxInFlight == 0 {
+			c.Servers[i].MaxInFlight = defaultInFlight
+		}
+	}
+}
+
+func (c Config) validate() error {
+	for _, s := range c.Servers {
+		if s.Name == "" {
+			return ErrNoServerName
+		}
+		if s.Address == "" {
+			return fmt.Errorf("server %q: %w", s.Name, ErrNoAddress)
+		}
+		if s.Encoding != "rgb24" && s.Encoding != "jpeg" {
+			return fmt.Errorf("server %q: %w: %q", s.Name, ErrUnknownEncoding, s.Encoding)
+		}
+		if s.MaxInFlight < 1 {
+			return fmt.Errorf("server %q: %w: %v", s.Name, ErrInvalidInFlight, s.MaxInFlight)
+		}
+	}
+	return nil
+}
+
+func readConfig(configDir string) (*Config, error) {
+	configPath := filepath.Join(configDir, "remotedetector.json")
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+		if err := os.WriteFile(configPath, data, 0o600); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	config.fillMissing()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+var defaultConfig = Config{
+	Servers: []ServerConfig{{
+		Name:        "remote",
+		Address:     "127.0.0.1:50051",
+		Encoding:    defaultEncoding,
+		MaxInFlight: defaultInFlight,
+	}},
+	HealthInterval: defaultHealthInterval,
+}
+
+func onAppRun(ctx context.Context, app *nvr.App) error {
+	config, err := readConfig(app.Env.ConfigDir)
+	if err != nil {
+		return fmt.Errorf("remotedetector: config: %w", err)
+	}
+
+	logf := func(level log.Level, format string, a ...interface{}) {
+		app.Logger.Log(log.Entry{
+			Level: level,
+			Src:   "remotedetector",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+
+	healthInterval := time.Duration(config.HealthInterval) * time.Second
+	var servers []*server
+	for _, c := range config.Servers {
+		s := newServer(c, logf)
+		servers = append(servers, s)
+		app.WG.Add(1)
+		go func() {
+			defer app.WG.Done()
+			s.run(ctx, healthInterval)
+		}()
+	}
+
+	app.Health.Register(func() map[string]health.Component {
+		components := make(map[string]health.Component)
+		for _, s := range servers {
+			components["detector/"+s.name] = s.component()
+		}
+		return components
+	})
+	return nil
+}
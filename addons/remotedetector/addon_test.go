@@ -0,0 +1,69 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		config, err := readConfig(t.TempDir())
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig, *config)
+	})
+	t.Run("fillMissing", func(t *testing.T) {
+		configDir := t.TempDir()
+		raw := `{"servers":[{"name":"a","address":"b:1"}]}`
+		err := os.WriteFile(filepath.Join(configDir, "remotedetector.json"), []byte(raw), 0o600)
+		require.NoError(t, err)
+
+		config, err := readConfig(configDir)
+		require.NoError(t, err)
+		expected := Config{
+			Servers: []ServerConfig{{
+				Name:        "a",
+				Address:     "b:1",
+				Encoding:    "rgb24",
+				MaxInFlight: defaultInFlight,
+			}},
+			HealthInterval: defaultHealthInterval,
+		}
+		require.Equal(t, expected, *config)
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := map[string]struct {
+		server      ServerConfig
+		expectedErr error
+	}{
+		"ok":       {ServerConfig{Name: "a", Address: "b", Encoding: "jpeg", MaxInFlight: 1}, nil},
+		"name":     {ServerConfig{Address: "b", Encoding: "jpeg", MaxInFlight: 1}, ErrNoServerName},
+		"address":  {ServerConfig{Name: "a", Encoding: "jpeg", MaxInFlight: 1}, ErrNoAddress},
+		"encoding": {ServerConfig{Name: "a", Address: "b", Encoding: "png", MaxInFlight: 1}, ErrUnknownEncoding},
+		"inFlight": {ServerConfig{Name: "a", Address: "b", Encoding: "jpeg", MaxInFlight: -1}, ErrInvalidInFlight},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := Config{Servers: []ServerConfig{tc.server}}
+			require.ErrorIs(t, c.validate(), tc.expectedErr)
+		})
+	}
+}
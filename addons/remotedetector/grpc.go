@@ -0,0 +1,221 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// Minimal gRPC client over HTTP/2, supports unary
+// and bidirectional streaming calls without compression.
+
+// Frames are much larger than the usual gRPC messages.
+const maxMessageSize = 64 * 1024 * 1024
+
+// gRPC errors.
+var (
+	ErrGRPCStatus      = errors.New("grpc status")
+	ErrHTTPStatus      = errors.New("http status")
+	ErrMessageTooLarge = errors.New("message too large")
+	ErrCompressed      = errors.New("compressed messages are not supported")
+)
+
+type grpcClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// newGRPCClient uses HTTP/2 without TLS, h2c, unless useTLS is set.
+func newGRPCClient(address string, useTLS bool) *grpcClient {
+	if useTLS {
+		return &grpcClient{
+			baseURL: "https://" + address,
+			httpClient: &http.Client{
+				Transport: &http2.Transport{},
+			},
+		}
+	}
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(
+			ctx context.Context, network, addr string, _ *tls.Config,
+		) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	return &grpcClient{
+		baseURL:    "http://" + address,
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// unary sends a single request and returns the response.
+func (c *grpcClient) unary(ctx context.Context, method string, req []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := c.newStream(ctx, method)
+	defer s.close()
+
+	if err := s.send(req); err != nil {
+		return nil, err
+	}
+	if err := s.closeSend(); err != nil {
+		return nil, err
+	}
+	return s.recv()
+}
+
+// stream bidirectional gRPC stream. Messages can be sent while
+// receiving, but only one goroutine may send at a time.
+type stream struct {
+	w *io.PipeWriter
+
+	cancel   context.CancelFunc
+	respDone chan struct{}
+	resp     *http.Response
+	respErr  error
+	body     *bufio.Reader
+
+	closeOnce sync.Once
+}
+
+// newStream starts the call in the background because servers
+// may wait for the first request before sending the headers.
+func (c *grpcClient) newStream(ctx context.Context, method string) *stream {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	s := &stream{
+		w:        pw,
+		cancel:   cancel,
+		respDone: make(chan struct{}),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+method, pr)
+	if err != nil {
+		s.respErr = err
+		close(s.respDone)
+		return s
+	}
+	req.Header.Set("content-type", "application/grpc")
+	req.Header.Set("te", "trailers")
+
+	go func() {
+		defer close(s.respDone)
+		resp, err := c.httpClient.Do(req) //nolint:bodyclose
+		if err != nil {
+			// Unblock pending sends.
+			pr.CloseWithError(err)
+			s.respErr = err
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			s.respErr = fmt.Errorf("%w: %v", ErrHTTPStatus, resp.StatusCode)
+			return
+		}
+		// Trailers-only response.
+		if err := statusErr(resp.Header); err != nil {
+			resp.Body.Close()
+			s.respErr = err
+			return
+		}
+		s.resp = resp
+		s.body = bufio.NewReader(resp.Body)
+	}()
+	return s
+}
+
+// send writes a length-prefixed message.
+func (s *stream) send(msg []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	if _, err := s.w.Write(append(header, msg...)); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	return nil
+}
+
+// closeSend ends the request stream.
+func (s *stream) closeSend() error {
+	return s.w.Close()
+}
+
+// recv reads the next message, io.EOF if the stream ended successfully.
+func (s *stream) recv() ([]byte, error) {
+	<-s.respDone
+	if s.respErr != nil {
+		return nil, s.respErr
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(s.body, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			if err := statusErr(s.resp.Trailer); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("recv: %w", err)
+	}
+	if header[0] != 0 {
+		return nil, ErrCompressed
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("%w: %v", ErrMessageTooLarge, size)
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(s.body, msg); err != nil {
+		return nil, fmt.Errorf("recv: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *stream) close() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.w.CloseWithError(context.Canceled)
+		<-s.respDone
+		if s.resp != nil {
+			s.resp.Body.Close()
+		}
+	})
+}
+
+// statusErr returns nil if the status is OK or missing.
+func statusErr(h http.Header) error {
+	rawStatus := h.Get("grpc-status")
+	if rawStatus == "" || rawStatus == "0" {
+		return nil
+	}
+	code, _ := strconv.Atoi(rawStatus)
+	return fmt.Errorf("%w: %v %v", ErrGRPCStatus, code, h.Get("grpc-message"))
+}
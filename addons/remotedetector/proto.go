@@ -0,0 +1,350 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"nvr/addons/detector"
+)
+
+// Minimal protocol buffers encoding of the messages in detector.proto,
+// only the features used by the messages are supported.
+
+// Wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoEncoder struct {
+	buf []byte
+}
+
+func (e *protoEncoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.buf = append(e.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *protoEncoder) tag(field int, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// Default values are omitted like proto3.
+
+func (e *protoEncoder) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+func (e *protoEncoder) bytes(field int, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+func (e *protoEncoder) float(field int, v float32) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+// ErrInvalidProto invalid protocol buffers message.
+var ErrInvalidProto = errors.New("invalid protobuf message")
+
+type protoDecoder struct {
+	buf []byte
+}
+
+func (d *protoDecoder) done() bool {
+	return len(d.buf) == 0
+}
+
+func (d *protoDecoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: varint", ErrInvalidProto)
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+func (d *protoDecoder) tag() (int, int, error) {
+	v, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 7), nil
+}
+
+func (d *protoDecoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.buf)) {
+		return nil, fmt.Errorf("%w: length", ErrInvalidProto)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *protoDecoder) fixed32() (uint32, error) {
+	if len(d.buf) < 4 {
+		return 0, fmt.Errorf("%w: fixed32", ErrInvalidProto)
+	}
+	v := binary.LittleEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v, nil
+}
+
+func (d *protoDecoder) float() (float32, error) {
+	v, err := d.fixed32()
+	return math.Float32frombits(v), err
+}
+
+// skip unknown fields for forward compatibility.
+func (d *protoDecoder) skip(wire int) error {
+	switch wire {
+	case wireVarint:
+		_, err := d.varint()
+		return err
+	case wireFixed64:
+		if len(d.buf) < 8 {
+			return fmt.Errorf("%w: fixed64", ErrInvalidProto)
+		}
+		d.buf = d.buf[8:]
+		return nil
+	case wireBytes:
+		_, err := d.bytes()
+		return err
+	case wireFixed32:
+		_, err := d.fixed32()
+		return err
+	default:
+		return fmt.Errorf("%w: wire type %v", ErrInvalidProto, wire)
+	}
+}
+
+// decodeFields calls fn for each field, fn returns false for unknown fields.
+func decodeFields(b []byte, fn func(d *protoDecoder, field int, wire int) (bool, error)) error {
+	d := &protoDecoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.tag()
+		if err != nil {
+			return err
+		}
+		known, err := fn(d, field, wire)
+		if err != nil {
+			return fmt.Errorf("field %v: %w", field, err)
+		}
+		if !known {
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type detectorInfo struct {
+	name   string
+	width  int
+	height int
+	labels []string
+}
+
+func decodeDetectorsResponse(b []byte) ([]detectorInfo, error) {
+	var infos []detectorInfo
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		if field != 1 || wire != wireBytes {
+			return false, nil
+		}
+		raw, err := d.bytes()
+		if err != nil {
+			return true, err
+		}
+		info, err := decodeDetectorInfo(raw)
+		if err != nil {
+			return true, err
+		}
+		infos = append(infos, info)
+		return true, nil
+	})
+	return infos, err
+}
+
+func decodeDetectorInfo(b []byte) (detectorInfo, error) {
+	var info detectorInfo
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		switch {
+		case field == 1 && wire == wireBytes:
+			v, err := d.bytes()
+			info.name = string(v)
+			return true, err
+		case field == 2 && wire == wireVarint:
+			v, err := d.varint()
+			info.width = int(int32(v))
+			return true, err
+		case field == 3 && wire == wireVarint:
+			v, err := d.varint()
+			info.height = int(int32(v))
+			return true, err
+		case field == 4 && wire == wireBytes:
+			v, err := d.bytes()
+			info.labels = append(info.labels, string(v))
+			return true, err
+		}
+		return false, nil
+	})
+	return info, err
+}
+
+type detectRequest struct {
+	id       uint64
+	detector string
+	width    int
+	height   int
+	rgb24    []byte
+	jpeg     []byte
+}
+
+func (r detectRequest) marshal() []byte {
+	var e protoEncoder
+	e.uint(1, r.id)
+	e.string(2, r.detector)
+	e.uint(3, uint64(r.width))
+	e.uint(4, uint64(r.height))
+	e.bytes(5, r.rgb24)
+	e.bytes(6, r.jpeg)
+	return e.buf
+}
+
+type detectResponse struct {
+	id         uint64
+	detections []detector.Detection
+	err        string
+}
+
+func decodeDetectResponse(b []byte) (*detectResponse, error) {
+	var res detectResponse
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		switch {
+		case field == 1 && wire == wireVarint:
+			v, err := d.varint()
+			res.id = v
+			return true, err
+		case field == 2 && wire == wireBytes:
+			raw, err := d.bytes()
+			if err != nil {
+				return true, err
+			}
+			detection, err := decodeDetection(raw)
+			res.detections = append(res.detections, detection)
+			return true, err
+		case field == 3 && wire == wireBytes:
+			v, err := d.bytes()
+			res.err = string(v)
+			return true, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func decodeDetection(b []byte) (detector.Detection, error) {
+	var det detector.Detection
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		if field == 1 && wire == wireBytes {
+			v, err := d.bytes()
+			det.Label = string(v)
+			return true, err
+		}
+		if wire != wireFixed32 {
+			return false, nil
+		}
+		var dst *float64
+		switch field {
+		case 2:
+			dst = &det.Score
+		case 3:
+			dst = &det.Top
+		case 4:
+			dst = &det.Left
+		case 5:
+			dst = &det.Bottom
+		case 6:
+			dst = &det.Right
+		default:
+			return false, nil
+		}
+		v, err := d.float()
+		*dst = float64(v)
+		return true, err
+	})
+	det.Score *= 100
+	return det, err
+}
+
+// Health check statuses, grpc.health.v1.HealthCheckResponse.ServingStatus.
+const (
+	healthUnknown    = 0
+	healthServing    = 1
+	healthNotServing = 2
+)
+
+func encodeHealthCheckRequest(service string) []byte {
+	var e protoEncoder
+	e.string(1, service)
+	return e.buf
+}
+
+func decodeHealthCheckResponse(b []byte) (int, error) {
+	status := healthUnknown
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		if field != 1 || wire != wireVarint {
+			return false, nil
+		}
+		v, err := d.varint()
+		status = int(v)
+		return true, err
+	})
+	return status, err
+}
@@ -0,0 +1,149 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"testing"
+
+	"nvr/addons/detector"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Server side encoding used by the tests.
+
+func encodeDetectorsResponse(infos []detectorInfo) []byte {
+	var e protoEncoder
+	for _, info := range infos {
+		var ie protoEncoder
+		ie.string(1, info.name)
+		ie.uint(2, uint64(info.width))
+		ie.uint(3, uint64(info.height))
+		for _, label := range info.labels {
+			ie.string(4, label)
+		}
+		e.bytes(1, ie.buf)
+	}
+	return e.buf
+}
+
+func encodeDetectResponse(res detectResponse) []byte {
+	var e protoEncoder
+	e.uint(1, res.id)
+	for _, d := range res.detections {
+		var de protoEncoder
+		de.string(1, d.Label)
+		de.float(2, float32(d.Score/100))
+		de.float(3, float32(d.Top))
+		de.float(4, float32(d.Left))
+		de.float(5, float32(d.Bottom))
+		de.float(6, float32(d.Right))
+		e.bytes(2, de.buf)
+	}
+	e.string(3, res.err)
+	return e.buf
+}
+
+func decodeDetectRequest(b []byte) (detectRequest, error) {
+	var req detectRequest
+	err := decodeFields(b, func(d *protoDecoder, field int, wire int) (bool, error) {
+		switch {
+		case field == 1 && wire == wireVarint:
+			v, err := d.varint()
+			req.id = v
+			return true, err
+		case field == 2 && wire == wireBytes:
+			v, err := d.bytes()
+			req.detector = string(v)
+			return true, err
+		case field == 3 && wire == wireVarint:
+			v, err := d.varint()
+			req.width = int(v)
+			return true, err
+		case field == 4 && wire == wireVarint:
+			v, err := d.varint()
+			req.height = int(v)
+			return true, err
+		case field == 5 && wire == wireBytes:
+			v, err := d.bytes()
+			req.rgb24 = v
+			return true, err
+		case field == 6 && wire == wireBytes:
+			v, err := d.bytes()
+			req.jpeg = v
+			return true, err
+		}
+		return false, nil
+	})
+	return req, err
+}
+
+func TestProto(t *testing.T) {
+	t.Run("detectors", func(t *testing.T) {
+		infos := []detectorInfo{
+			{name: "a", width: 640, height: 480, labels: []string{"x", "y"}},
+			{name: "b", width: 1, height: 2},
+		}
+		actual, err := decodeDetectorsResponse(encodeDetectorsResponse(infos))
+		require.NoError(t, err)
+		require.Equal(t, infos, actual)
+	})
+	t.Run("detectRequest", func(t *testing.T) {
+		req := detectRequest{
+			id:       300,
+			detector: "a",
+			width:    2,
+			height:   1,
+			rgb24:    []byte{1, 2, 3, 4, 5, 6},
+		}
+		actual, err := decodeDetectRequest(req.marshal())
+		require.NoError(t, err)
+		require.Equal(t, req, actual)
+	})
+	t.Run("detectResponse", func(t *testing.T) {
+		res := detectResponse{
+			id: 1,
+			detections: []detector.Detection{
+				{Label: "a", Score: 50, Top: 0.25, Left: 0.5, Bottom: 0.75, Right: 1},
+			},
+			err: "x",
+		}
+		actual, err := decodeDetectResponse(encodeDetectResponse(res))
+		require.NoError(t, err)
+		require.Equal(t, res, *actual)
+	})
+	t.Run("unknownFields", func(t *testing.T) {
+		var e protoEncoder
+		e.uint(1, 7)
+		e.uint(9, 1)
+		e.string(10, "x")
+		e.float(11, 1)
+		actual, err := decodeDetectResponse(e.buf)
+		require.NoError(t, err)
+		require.Equal(t, uint64(7), actual.id)
+	})
+	t.Run("health", func(t *testing.T) {
+		req := encodeHealthCheckRequest("a")
+		require.Equal(t, []byte{0x0a, 0x01, 'a'}, req)
+
+		status, err := decodeHealthCheckResponse([]byte{0x08, 0x01})
+		require.NoError(t, err)
+		require.Equal(t, healthServing, status)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := decodeDetectResponse([]byte{0x12, 0x05, 0x01})
+		require.ErrorIs(t, err, ErrInvalidProto)
+	})
+}
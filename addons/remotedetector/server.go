@@ -0,0 +1,395 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"nvr/addons/detector"
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+	"sync"
+	"time"
+)
+
+const (
+	detectorService  = "osnvr.detector.v1.Detector"
+	detectorsMethod  = "/osnvr.detector.v1.Detector/Detectors"
+	detectMethod     = "/osnvr.detector.v1.Detector/Detect"
+	healthMethod     = "/grpc.health.v1.Health/Check"
+	healthTimeout    = 5 * time.Second
+	jpegQuality      = 90
+	defaultInFlight  = 4
+	defaultEncoding  = "rgb24"
+	registerInterval = 10 * time.Second
+)
+
+// Server errors.
+var (
+	ErrUnhealthy    = errors.New("server is unhealthy")
+	ErrRemote       = errors.New("remote")
+	ErrStreamClosed = errors.New("stream closed")
+)
+
+// server connection to a remote detection server. Frames from
+// all monitors are sent on a single stream, the number of
+// frames without a response is limited by the slots.
+type server struct {
+	name     string
+	grpc     *grpcClient
+	encoding string
+	logf     log.Func
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	stream  *detectStream
+	healthy bool
+	// Error of the latest failed health check.
+	healthErr error
+}
+
+func newServer(c ServerConfig, logf log.Func) *server {
+	return &server{
+		name:     c.Name,
+		grpc:     newGRPCClient(c.Address, c.TLS),
+		encoding: c.Encoding,
+		logf:     logf,
+		slots:    make(chan struct{}, c.MaxInFlight),
+	}
+}
+
+type detectResult struct {
+	res *detectResponse
+	err error
+}
+
+type detectStream struct {
+	s *stream
+
+	// Only one goroutine may send at a time.
+	sendMu sync.Mutex
+
+	// Protected by server.mu.
+	nextID  uint64
+	pending map[uint64]chan detectResult
+}
+
+// register lists the remote detectors and registers them. The
+// detector names are prefixed by the server name.
+func (s *server) register(ctx context.Context) error {
+	res, err := s.grpc.unary(ctx, detectorsMethod, nil)
+	if err != nil {
+		return fmt.Errorf("list detectors: %w", err)
+	}
+	infos, err := decodeDetectorsResponse(res)
+	if err != nil {
+		return fmt.Errorf("list detectors: %w", err)
+	}
+
+	s.mu.Lock()
+	s.healthy = true
+	s.mu.Unlock()
+
+	for _, info := range infos {
+		d := &remoteDetector{
+			server:     s,
+			name:       s.name + "_" + info.name,
+			remoteName: info.name,
+			width:      info.width,
+			height:     info.height,
+			labels:     info.labels,
+		}
+		if err := detector.Register(d); err != nil {
+			s.logf(log.LevelError, "%v", err)
+			continue
+		}
+		s.logf(log.LevelInfo, "registered detector: %v", d.name)
+	}
+	return nil
+}
+
+// run registers the detectors and checks the server health until canceled.
+func (s *server) run(ctx context.Context, healthInterval time.Duration) {
+	defer s.close()
+
+	for {
+		err := s.register(ctx)
+		if err == nil {
+			break
+		}
+		s.logf(log.LevelError, "%v: %v, retrying", s.name, err)
+
+		select {
+		case <-time.After(registerInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-time.After(healthInterval):
+		case <-ctx.Done():
+			return
+		}
+		s.checkHealth(ctx)
+	}
+}
+
+// checkHealth uses the standard gRPC health service. Pending
+// frames are dropped if the server becomes unhealthy.
+func (s *server) checkHealth(ctx context.Context) {
+	ctx2, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	err := s.healthCheck(ctx2)
+	healthy := err == nil
+
+	s.mu.Lock()
+	changed := s.healthy != healthy
+	s.healthy = healthy
+	s.healthErr = err
+	stream := s.stream
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		s.logf(log.LevelInfo, "%v: healthy", s.name)
+		return
+	}
+	s.logf(log.LevelError, "%v: unhealthy: %v", s.name, err)
+	if stream != nil {
+		s.closeStream(stream, ErrUnhealthy)
+	}
+}
+
+func (s *server) healthCheck(ctx context.Context) error {
+	res, err := s.grpc.unary(ctx, healthMethod, encodeHealthCheckRequest(detectorService))
+	if err != nil {
+		return err
+	}
+	status, err := decodeHealthCheckResponse(res)
+	if err != nil {
+		return err
+	}
+	if status != healthServing {
+		return fmt.Errorf("%w: status %v", ErrUnhealthy, status)
+	}
+	return nil
+}
+
+// component returns the health component of the server.
+func (s *server) component() health.Component {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.healthy:
+		return health.Component{Status: health.StatusOK}
+	case s.healthErr != nil:
+		return health.Component{Status: health.StatusFailed, Reason: s.healthErr.Error()}
+	}
+	return health.Component{Status: health.StatusDegraded, Reason: "not checked yet"}
+}
+
+func (s *server) isHealthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthy
+}
+
+// detect waits for a free slot, this is the backpressure. The
+// slot is released when the response arrives or the stream closes.
+func (s *server) detect(ctx context.Context, req detectRequest) (*detectResponse, error) {
+	if !s.isHealthy() {
+		return nil, ErrUnhealthy
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	resCh, err := s.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-resCh:
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.res.err != "" {
+			return nil, fmt.Errorf("%w: %v", ErrRemote, r.res.err)
+		}
+		return r.res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send must hold a slot, it's released on error.
+func (s *server) send(req detectRequest) (chan detectResult, error) {
+	s.mu.Lock()
+	if s.stream == nil {
+		s.stream = &detectStream{
+			s:       s.grpc.newStream(context.Background(), detectMethod),
+			pending: make(map[uint64]chan detectResult),
+		}
+		go s.recvLoop(s.stream)
+	}
+	stream := s.stream
+	stream.nextID++
+	req.id = stream.nextID
+	resCh := make(chan detectResult, 1)
+	stream.pending[req.id] = resCh
+	s.mu.Unlock()
+
+	stream.sendMu.Lock()
+	err := stream.s.send(req.marshal())
+	stream.sendMu.Unlock()
+	if err != nil {
+		s.closeStream(stream, err)
+		return nil, err
+	}
+	return resCh, nil
+}
+
+func (s *server) recvLoop(stream *detectStream) {
+	for {
+		msg, err := stream.s.recv()
+		if err != nil {
+			s.closeStream(stream, err)
+			return
+		}
+		res, err := decodeDetectResponse(msg)
+		if err != nil {
+			s.closeStream(stream, err)
+			return
+		}
+
+		s.mu.Lock()
+		resCh, exist := stream.pending[res.id]
+		delete(stream.pending, res.id)
+		s.mu.Unlock()
+
+		if exist {
+			resCh <- detectResult{res: res}
+			<-s.slots
+		}
+	}
+}
+
+// closeStream fails all pending frames and releases their slots.
+func (s *server) closeStream(stream *detectStream, err error) {
+	s.mu.Lock()
+	if s.stream == stream {
+		s.stream = nil
+	}
+	pending := stream.pending
+	stream.pending = make(map[uint64]chan detectResult)
+	s.mu.Unlock()
+
+	for _, resCh := range pending {
+		resCh <- detectResult{err: fmt.Errorf("%w: %v", ErrStreamClosed, err)}
+		<-s.slots
+	}
+	go stream.s.close()
+}
+
+func (s *server) close() {
+	s.mu.Lock()
+	stream := s.stream
+	s.mu.Unlock()
+	if stream != nil {
+		s.closeStream(stream, context.Canceled)
+	}
+}
+
+// remoteDetector detector on a remote server.
+type remoteDetector struct {
+	server     *server
+	name       string
+	remoteName string
+	width      int
+	height     int
+	labels     []string
+}
+
+// Name implements detector.Detector.
+func (d *remoteDetector) Name() string {
+	return d.name
+}
+
+// Size implements detector.Detector.
+func (d *remoteDetector) Size() (int, int) {
+	return d.width, d.height
+}
+
+// Labels implements detector.Detector.
+func (d *remoteDetector) Labels() []string {
+	return d.labels
+}
+
+// Detect implements detector.Detector.
+func (d *remoteDetector) Detect(ctx context.Context, frame []byte) ([]detector.Detection, error) {
+	req := detectRequest{
+		detector: d.remoteName,
+		width:    d.width,
+		height:   d.height,
+	}
+	if d.server.encoding == "jpeg" {
+		img, err := encodeJPEG(frame, d.width, d.height)
+		if err != nil {
+			return nil, fmt.Errorf("encode jpeg: %w", err)
+		}
+		req.jpeg = img
+	} else {
+		req.rgb24 = frame
+	}
+
+	res, err := d.server.detect(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return res.detections, nil
+}
+
+func encodeJPEG(frame []byte, width int, height int) ([]byte, error) {
+	if len(frame) != width*height*3 {
+		return nil, fmt.Errorf("%w: %v", detector.ErrInvalidSize, len(frame))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, j := 0, 0; i < len(frame); i, j = i+3, j+4 {
+		img.Pix[j] = frame[i]
+		img.Pix[j+1] = frame[i+1]
+		img.Pix[j+2] = frame[i+2]
+		img.Pix[j+3] = 255
+	}
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,261 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+package remotedetector
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/addons/detector"
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func readMsg(r io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func writeMsg(w http.ResponseWriter, msg []byte) {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	w.Write(append(header, msg...)) //nolint:errcheck
+	w.(http.Flusher).Flush()
+}
+
+type fakeServer struct {
+	healthStatus int
+
+	// Called for each frame, the response is sent when it returns.
+	onDetect func(detectRequest) detectResponse
+
+	mu       sync.Mutex
+	requests []detectRequest
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/grpc")
+	w.Header().Set("Trailer", "grpc-status")
+	defer func() { w.Header().Set("grpc-status", "0") }()
+
+	switch r.URL.Path {
+	case detectorsMethod:
+		readMsg(r.Body) //nolint:errcheck
+		writeMsg(w, encodeDetectorsResponse([]detectorInfo{
+			{name: "a", width: 2, height: 1, labels: []string{"x"}},
+		}))
+	case healthMethod:
+		req, _ := readMsg(r.Body)
+		if !strings.Contains(string(req), detectorService) {
+			w.Header().Set("grpc-status", "5")
+			return
+		}
+		var e protoEncoder
+		e.uint(1, uint64(f.healthStatus))
+		writeMsg(w, e.buf)
+	case detectMethod:
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		var wg sync.WaitGroup
+		var writeMu sync.Mutex
+		for {
+			msg, err := readMsg(r.Body)
+			if err != nil {
+				break
+			}
+			req, err := decodeDetectRequest(msg)
+			if err != nil {
+				break
+			}
+			f.mu.Lock()
+			f.requests = append(f.requests, req)
+			f.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := f.onDetect(req)
+				writeMu.Lock()
+				writeMsg(w, encodeDetectResponse(res))
+				writeMu.Unlock()
+			}()
+		}
+		wg.Wait()
+	default:
+		w.Header().Set("grpc-status", "12")
+	}
+}
+
+func newTestServer(t *testing.T, f *fakeServer, maxInFlight int) *server {
+	srv := httptest.NewServer(h2c.NewHandler(f, &http2.Server{}))
+	t.Cleanup(srv.Close)
+
+	c := ServerConfig{
+		Name:        "test",
+		Address:     strings.TrimPrefix(srv.URL, "http://"),
+		Encoding:    "rgb24",
+		MaxInFlight: maxInFlight,
+	}
+	s := newServer(c, func(log.Level, string, ...interface{}) {})
+	t.Cleanup(s.close)
+	return s
+}
+
+func TestServer(t *testing.T) {
+	t.Run("register", func(t *testing.T) {
+		s := newTestServer(t, &fakeServer{}, 1)
+		s.name = "registerTest"
+		require.NoError(t, s.register(context.Background()))
+		require.True(t, s.isHealthy())
+
+		err := detector.Register(&remoteDetector{name: "registerTest_a"})
+		require.ErrorIs(t, err, detector.ErrDetectorExist)
+	})
+	t.Run("detect", func(t *testing.T) {
+		f := &fakeServer{
+			onDetect: func(req detectRequest) detectResponse {
+				return detectResponse{
+					id: req.id,
+					detections: []detector.Detection{
+						{Label: req.detector, Score: 50, Bottom: 1, Right: 1},
+					},
+				}
+			},
+		}
+		s := newTestServer(t, f, 2)
+		s.healthy = true
+		d := &remoteDetector{server: s, remoteName: "a", width: 2, height: 1}
+
+		for i := 0; i < 3; i++ {
+			detections, err := d.Detect(context.Background(), []byte{1, 2, 3, 4, 5, 6})
+			require.NoError(t, err)
+			expected := []detector.Detection{{Label: "a", Score: 50, Bottom: 1, Right: 1}}
+			require.Equal(t, expected, detections)
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		require.Len(t, f.requests, 3)
+		require.Equal(t, uint64(3), f.requests[2].id)
+		require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, f.requests[0].rgb24)
+		require.Equal(t, 2, f.requests[0].width)
+		require.Len(t, s.slots, 0)
+	})
+	t.Run("outOfOrder", func(t *testing.T) {
+		release := make(chan struct{})
+		f := &fakeServer{
+			onDetect: func(req detectRequest) detectResponse {
+				if req.id == 1 {
+					<-release
+				}
+				return detectResponse{id: req.id, err: req.detector}
+			},
+		}
+		s := newTestServer(t, f, 2)
+		s.healthy = true
+
+		first := make(chan error)
+		go func() {
+			_, err := s.detect(context.Background(), detectRequest{detector: "1"})
+			first <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		_, err := s.detect(context.Background(), detectRequest{detector: "2"})
+		require.ErrorIs(t, err, ErrRemote)
+		require.Contains(t, err.Error(), "2")
+
+		close(release)
+		err = <-first
+		require.ErrorIs(t, err, ErrRemote)
+		require.Contains(t, err.Error(), "1")
+	})
+	t.Run("backpressure", func(t *testing.T) {
+		release := make(chan struct{})
+		f := &fakeServer{
+			onDetect: func(req detectRequest) detectResponse {
+				<-release
+				return detectResponse{id: req.id}
+			},
+		}
+		s := newTestServer(t, f, 1)
+		s.healthy = true
+
+		// The first frame times out but keeps the slot.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := s.detect(ctx, detectRequest{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Len(t, s.slots, 1)
+
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel2()
+		_, err = s.detect(ctx2, detectRequest{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		f.mu.Lock()
+		require.Len(t, f.requests, 1)
+		f.mu.Unlock()
+
+		// The slot is released by the late response.
+		close(release)
+		_, err = s.detect(context.Background(), detectRequest{})
+		require.NoError(t, err)
+	})
+	t.Run("health", func(t *testing.T) {
+		f := &fakeServer{healthStatus: healthServing}
+		s := newTestServer(t, f, 1)
+
+		_, err := s.detect(context.Background(), detectRequest{})
+		require.ErrorIs(t, err, ErrUnhealthy)
+
+		s.checkHealth(context.Background())
+		require.True(t, s.isHealthy())
+
+		f.healthStatus = healthNotServing
+		s.checkHealth(context.Background())
+		require.False(t, s.isHealthy())
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		s := newServer(ServerConfig{Address: "127.0.0.1:1", MaxInFlight: 1}, nil)
+		s.checkHealth(context.Background())
+		require.False(t, s.isHealthy())
+		require.Error(t, s.register(context.Background()))
+	})
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	img, err := encodeJPEG(make([]byte, 2*2*3), 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xff, 0xd8}, img[:2])
+
+	_, err = encodeJPEG(make([]byte, 5), 2, 2)
+	require.ErrorIs(t, err, detector.ErrInvalidSize)
+}
@@ -0,0 +1,240 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/s3client"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var addon struct {
+	uploader *uploader
+}
+
+func init() {
+	nvr.RegisterLogSource([]string{"s3"})
+	nvr.RegisterTplHook(modifyTemplates)
+
+	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+		configPath := filepath.Join(app.Env.ConfigDir, "s3.json")
+		config, err := readConfig(configPath)
+		if err != nil {
+			return fmt.Errorf("s3: config: %w %v", err, configPath)
+		}
+		if config.Bucket == "" {
+			app.Logger.Log(log.Entry{
+				Level: log.LevelWarning,
+				Src:   "s3",
+				Msg:   fmt.Sprintf("no bucket in %v, uploads are disabled", configPath),
+			})
+			return nil
+		}
+
+		client, err := s3client.New(config.clientConfig())
+		if err != nil {
+			return fmt.Errorf("s3: %w", err)
+		}
+
+		addon.uploader = newUploader(client, *config, app.Env.RecordingsDir(), app.Env.Crypt, app.Logger)
+		app.WG.Add(1)
+		go func() {
+			addon.uploader.run(ctx)
+			app.WG.Done()
+		}()
+		return nil
+	})
+
+	nvr.RegisterMonitorRecSavedHook(onRecSaved)
+}
+
+func onRecSaved(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
+	if addon.uploader == nil {
+		return
+	}
+
+	mode := r.Config.Get("s3Upload")
+	switch {
+	case mode == uploadAll:
+	case mode == uploadEvents && len(recData.Events) != 0:
+	default:
+		return
+	}
+
+	addon.uploader.enqueue(r.Config.ID(), recPath)
+}
+
+// Upload modes.
+const (
+	uploadAll    = "all"
+	uploadEvents = "events"
+)
+
+// Local deletion policies.
+const (
+	deleteNever       = "never"
+	deleteAfterUpload = "afterUpload"
+)
+
+// Config s3 global configuration.
+type Config struct {
+	// For example "https://s3.eu-north-1.amazonaws.com".
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+
+	// Use "endpoint/bucket/key" urls instead
+	// of "bucket.endpoint/key", required by MinIO.
+	PathStyle bool `json:"pathStyle"`
+
+	// Prefix of the object keys, for example "nvr/".
+	Prefix       string `json:"prefix"`
+	StorageClass string `json:"storageClass"`
+
+	// Lifecycle rules of the prefix. The bucket lifecycle
+	// configuration is replaced if any of them are set.
+	ExpireDays             int    `json:"expireDays"`
+	TransitionDays         int    `json:"transitionDays"`
+	TransitionStorageClass string `json:"transitionStorageClass"`
+
+	// "never" or "afterUpload".
+	LocalDeletion string `json:"localDeletion"`
+
+	MaxRetries int `json:"maxRetries"`
+}
+
+// Config errors.
+var (
+	ErrInvalidEndpoint      = s3client.ErrInvalidEndpoint
+	ErrInvalidLocalDeletion = errors.New("invalid local deletion policy")
+	ErrInvalidTransition    = errors.New("transition requires days and storage class")
+)
+
+func readConfig(configPath string) (*Config, error) {
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		if err := genConfig(configPath); err != nil {
+			return nil, fmt.Errorf("generate config: %w", err)
+		}
+	}
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if err := config.fillMissing(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+var defaultConfig = Config{
+	Endpoint:      "https://s3.amazonaws.com",
+	Region:        "us-east-1",
+	LocalDeletion: deleteNever,
+	MaxRetries:    5,
+}
+
+func genConfig(path string) error {
+	data, _ := json.MarshalIndent(defaultConfig, "", "    ")
+	return os.WriteFile(path, data, 0o600)
+}
+
+func (c *Config) fillMissing() error {
+	if c.Region == "" {
+		c.Region = defaultConfig.Region
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	if c.Prefix != "" && !strings.HasSuffix(c.Prefix, "/") {
+		c.Prefix += "/"
+	}
+	switch c.LocalDeletion {
+	case "":
+		c.LocalDeletion = deleteNever
+	case deleteNever, deleteAfterUpload:
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidLocalDeletion, c.LocalDeletion)
+	}
+	if (c.TransitionDays == 0) != (c.TransitionStorageClass == "") {
+		return ErrInvalidTransition
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultConfig.MaxRetries
+	}
+	return nil
+}
+
+func (c Config) clientConfig() s3client.Config {
+	return s3client.Config{
+		Endpoint:  c.Endpoint,
+		Region:    c.Region,
+		Bucket:    c.Bucket,
+		AccessKey: c.AccessKey,
+		SecretKey: c.SecretKey,
+		PathStyle: c.PathStyle,
+	}
+}
+
+// lifecycleXML returns the bucket lifecycle configuration
+// or nil if no lifecycle rules are configured.
+func (c Config) lifecycleXML() []byte {
+	if c.ExpireDays == 0 && c.TransitionDays == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	b.WriteString(`<Rule><ID>os-nvr</ID><Filter><Prefix>`)
+	b.WriteString(xmlEscape(c.Prefix))
+	b.WriteString(`</Prefix></Filter><Status>Enabled</Status>`)
+	if c.TransitionDays != 0 {
+		b.WriteString(`<Transition><Days>` + strconv.Itoa(c.TransitionDays) + `</Days>`)
+		b.WriteString(`<StorageClass>` + xmlEscape(c.TransitionStorageClass) + `</StorageClass></Transition>`)
+	}
+	if c.ExpireDays != 0 {
+		b.WriteString(`<Expiration><Days>` + strconv.Itoa(c.ExpireDays) + `</Days></Expiration>`)
+	}
+	b.WriteString(`</Rule></LifecycleConfiguration>`)
+	return []byte(b.String())
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		">", "&gt;",
+		`"`, "&quot;",
+		"'", "&apos;",
+	).Replace(s)
+}
@@ -0,0 +1,196 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"nvr/pkg/log"
+	"nvr/pkg/s3client"
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type uploadJob struct {
+	monitorID string
+	recPath   string
+	attempt   int
+}
+
+// uploader uploads finalized recordings one at a time. Failed
+// uploads are retried with exponential backoff, the queue is
+// kept in memory and is lost if the NVR is restarted.
+type uploader struct {
+	client        *s3client.Client
+	config        Config
+	recordingsDir string
+	crypt         *storage.Crypt
+	logger        log.ILogger
+
+	queue      chan uploadJob
+	retryDelay time.Duration
+}
+
+const uploadQueueSize = 100
+
+func newUploader(
+	client *s3client.Client,
+	config Config,
+	recordingsDir string,
+	crypt *storage.Crypt,
+	logger log.ILogger,
+) *uploader {
+	return &uploader{
+		client:        client,
+		config:        config,
+		recordingsDir: recordingsDir,
+		crypt:         crypt,
+		logger:        logger,
+		queue:         make(chan uploadJob, uploadQueueSize),
+		retryDelay:    30 * time.Second,
+	}
+}
+
+func (u *uploader) logf(monitorID string, level log.Level, format string, a ...interface{}) {
+	u.logger.Log(log.Entry{
+		Level:     level,
+		Src:       "s3",
+		MonitorID: monitorID,
+		Msg:       fmt.Sprintf(format, a...),
+	})
+}
+
+func (u *uploader) enqueue(monitorID string, recPath string) {
+	u.push(uploadJob{monitorID: monitorID, recPath: recPath})
+}
+
+func (u *uploader) push(job uploadJob) {
+	select {
+	case u.queue <- job:
+	default:
+		u.logf(job.monitorID, log.LevelError,
+			"upload queue is full, skipping: %v", filepath.Base(job.recPath))
+	}
+}
+
+func (u *uploader) run(ctx context.Context) {
+	if lifecycle := u.config.lifecycleXML(); lifecycle != nil {
+		if err := u.client.PutLifecycle(ctx, lifecycle); err != nil {
+			u.logf("", log.LevelError, "could not set lifecycle rules: %v", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-u.queue:
+			u.process(ctx, job)
+		}
+	}
+}
+
+func (u *uploader) process(ctx context.Context, job uploadJob) {
+	name := filepath.Base(job.recPath)
+	err := u.upload(ctx, job.recPath)
+	if err == nil {
+		u.logf(job.monitorID, log.LevelInfo, "uploaded: %v", name)
+		if u.config.LocalDeletion == deleteAfterUpload {
+			if err := storage.DeleteRecording(u.recordingsDir, name); err != nil {
+				u.logf(job.monitorID, log.LevelError, "could not delete local recording: %v", err)
+			}
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	job.attempt++
+	if job.attempt > u.config.MaxRetries {
+		u.logf(job.monitorID, log.LevelError, "giving up on %v: %v", name, err)
+		return
+	}
+	delay := u.retryDelay << (job.attempt - 1)
+	u.logf(job.monitorID, log.LevelWarning,
+		"upload failed, retrying in %v: %v: %v", delay, name, err)
+	time.AfterFunc(delay, func() {
+		if ctx.Err() == nil {
+			u.push(job)
+		}
+	})
+}
+
+// upload uploads the video, data and thumbnail of the recording.
+func (u *uploader) upload(ctx context.Context, recPath string) error {
+	rel, err := filepath.Rel(u.recordingsDir, recPath)
+	if err != nil {
+		return err
+	}
+	key := u.config.Prefix + filepath.ToSlash(rel)
+
+	if storage.IsMKVRecording(recPath) {
+		err = u.uploadMKV(ctx, recPath, key)
+	} else {
+		err = u.uploadMP4(ctx, recPath, key)
+	}
+	if err != nil {
+		return fmt.Errorf("upload video: %w", err)
+	}
+	if err := u.uploadFile(ctx, recPath+".json", key+".json", "application/json"); err != nil {
+		return fmt.Errorf("upload data: %w", err)
+	}
+	err = u.uploadFile(ctx, recPath+".jpeg", key+".jpeg", "image/jpeg")
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("upload thumbnail: %w", err)
+	}
+	return nil
+}
+
+func (u *uploader) uploadMP4(ctx context.Context, recPath string, key string) error {
+	video, err := storage.NewVideoReader(recPath, nil, u.crypt)
+	if err != nil {
+		return fmt.Errorf("video reader: %w", err)
+	}
+	defer video.Close()
+	return u.client.PutObject(ctx, key+".mp4", video, video.Size(), "video/mp4", u.config.StorageClass)
+}
+
+// uploadMKV uploads the decrypted Matroska file.
+func (u *uploader) uploadMKV(ctx context.Context, recPath string, key string) error {
+	file, err := storage.OpenRecordingFile(recPath+".mkv", u.crypt)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return u.client.PutObject(ctx, key+".mkv", file, file.Size(), "video/x-matroska", u.config.StorageClass)
+}
+
+func (u *uploader) uploadFile(ctx context.Context, path string, key string, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return u.client.PutObject(ctx, key, file, info.Size(), contentType, u.config.StorageClass)
+}
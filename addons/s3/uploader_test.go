@@ -0,0 +1,194 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/s3client"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testMeta = []byte{
+	0,    // Version.
+	0, 7, // Video sps size.
+	103, 0, 0, 0, 172, 217, 0, // Video sps.
+	0, 3, // Video pps size.
+	2, 3, 4, // Video pps.
+	0, 4, // Audio config size.
+	20, 10, 0, 0, // Audio Config.
+	0, 0, 0, 0, 0, 0, 0, 0, // Start time.
+
+	// Sample.
+	0,                        // Flags.
+	0, 0, 0, 0, 0, 0, 0, 0x0, // PTS.
+	0, 0, 0, 0, 0, 0, 0, 0, // DTS.
+	0, 0, 0, 0, 0, 0, 0, 0, // Next dts.
+	0, 0, 0, 0, // Offset.
+	0, 0, 0, 0, // Size.
+}
+
+func writeTestRecording(t *testing.T, recordingsDir string) string {
+	t.Helper()
+	recPath := filepath.Join(recordingsDir, "2000/01/01/m1/2000-01-01_00-00-00_m1")
+	require.NoError(t, os.MkdirAll(filepath.Dir(recPath), 0o700))
+	require.NoError(t, os.WriteFile(recPath+".meta", testMeta, 0o600))
+	require.NoError(t, os.WriteFile(recPath+".mdat", []byte{0, 0, 0, 0}, 0o600))
+	require.NoError(t, os.WriteFile(recPath+".json", []byte("{}"), 0o600))
+	return recPath
+}
+
+type testBucket struct {
+	objects map[string]int
+	fail    int
+	mu      sync.Mutex
+}
+
+func (b *testBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail > 0 {
+		b.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	b.objects[r.URL.Path] = len(body)
+}
+
+func newTestUploader(t *testing.T, bucket *testBucket, config Config) (*uploader, string) {
+	t.Helper()
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	config.Bucket = "nvr"
+	config.PathStyle = true
+	require.NoError(t, config.fillMissing())
+
+	c, err := s3client.New(config.clientConfig())
+	require.NoError(t, err)
+	recordingsDir := t.TempDir()
+	return newUploader(c, config, recordingsDir, nil, log.NewDummyLogger()), recordingsDir
+}
+
+func TestUploader(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}}
+		u, recordingsDir := newTestUploader(t, bucket, Config{Prefix: "backup"})
+		recPath := writeTestRecording(t, recordingsDir)
+
+		u.process(context.Background(), uploadJob{monitorID: "m1", recPath: recPath})
+
+		const key = "/nvr/backup/2000/01/01/m1/2000-01-01_00-00-00_m1"
+		require.Len(t, bucket.objects, 2)
+		require.Greater(t, bucket.objects[key+".mp4"], 1000)
+		require.Equal(t, 2, bucket.objects[key+".json"])
+
+		_, err := os.Stat(recPath + ".meta")
+		require.NoError(t, err)
+	})
+	t.Run("retry", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}, fail: 1}
+		u, recordingsDir := newTestUploader(t, bucket, Config{
+			LocalDeletion: deleteAfterUpload,
+		})
+		u.retryDelay = time.Millisecond
+		recPath := writeTestRecording(t, recordingsDir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		u.process(ctx, uploadJob{monitorID: "m1", recPath: recPath})
+		require.Empty(t, bucket.objects)
+
+		job := <-u.queue
+		require.Equal(t, 1, job.attempt)
+		u.process(ctx, job)
+		require.Len(t, bucket.objects, 2)
+
+		// Deleted after upload.
+		_, err := os.Stat(recPath + ".meta")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("giveUp", func(t *testing.T) {
+		bucket := &testBucket{objects: map[string]int{}, fail: 10}
+		u, recordingsDir := newTestUploader(t, bucket, Config{MaxRetries: 1})
+		u.retryDelay = time.Millisecond
+		recPath := writeTestRecording(t, recordingsDir)
+
+		u.process(context.Background(), uploadJob{monitorID: "m1", recPath: recPath, attempt: 1})
+		time.Sleep(10 * time.Millisecond)
+		require.Empty(t, u.queue)
+	})
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("fillMissing", func(t *testing.T) {
+		c := Config{Region: "eu-north-1", Prefix: "nvr"}
+		require.NoError(t, c.fillMissing())
+		require.Equal(t, Config{
+			Endpoint:      "https://s3.eu-north-1.amazonaws.com",
+			Region:        "eu-north-1",
+			Prefix:        "nvr/",
+			LocalDeletion: deleteNever,
+			MaxRetries:    5,
+		}, c)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		c := Config{LocalDeletion: "x"}
+		require.ErrorIs(t, c.fillMissing(), ErrInvalidLocalDeletion)
+		c = Config{TransitionDays: 30}
+		require.ErrorIs(t, c.fillMissing(), ErrInvalidTransition)
+	})
+	t.Run("lifecycle", func(t *testing.T) {
+		require.Nil(t, Config{}.lifecycleXML())
+
+		c := Config{
+			Prefix:                 "nvr/",
+			ExpireDays:             90,
+			TransitionDays:         30,
+			TransitionStorageClass: "GLACIER",
+		}
+		expected := `<?xml version="1.0" encoding="UTF-8"?>` +
+			`<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+			`<Rule><ID>os-nvr</ID><Filter><Prefix>nvr/</Prefix></Filter><Status>Enabled</Status>` +
+			`<Transition><Days>30</Days><StorageClass>GLACIER</StorageClass></Transition>` +
+			`<Expiration><Days>90</Days></Expiration>` +
+			`</Rule></LifecycleConfiguration>`
+		require.Equal(t, expected, string(c.lifecycleXML()))
+	})
+	t.Run("generate", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "s3.json")
+		c, err := readConfig(path)
+		require.NoError(t, err)
+		require.Equal(t, defaultConfig, *c)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+}
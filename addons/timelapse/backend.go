@@ -0,0 +1,418 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package timelapse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	nvr.RegisterLogSource([]string{"timelapse"})
+
+	nvr.RegisterTplSubHook(modifySubTemplates)
+	nvr.RegisterTplHook(modifyTemplates)
+
+	nvr.RegisterAppRunHook(func(ctx context.Context, app *nvr.App) error {
+		timelapsesDir := app.Env.TimelapsesDir()
+		app.Router.Handle(
+			"/api/timelapse/list",
+			app.Auth.User(handleList(timelapsesDir)),
+		)
+		app.Router.Handle(
+			"/api/timelapse/video/",
+			app.Auth.User(handleVideo(timelapsesDir)),
+		)
+		app.Router.Handle(
+			"/timelapse",
+			app.Auth.User(app.Templater.Render("timelapse.tpl")),
+		)
+
+		g := &generator{
+			timelapsesDir:  timelapsesDir,
+			segmentsDirs:   app.Env.SegmentsDirs(),
+			crypt:          app.Env.Crypt,
+			ffmpegBin:      app.Env.FFmpegBin,
+			index:          app.Index,
+			monitorConfigs: app.MonitorConfigs,
+			logger:         app.Logger,
+			newProcess:     ffmpeg.NewProcess,
+		}
+		app.WG.Add(1)
+		go func() {
+			g.run(ctx, checkInterval)
+			app.WG.Done()
+		}()
+		return nil
+	})
+}
+
+// checkInterval how often the schedules are checked.
+const checkInterval = 15 * time.Minute
+
+type generator struct {
+	timelapsesDir  string
+	segmentsDirs   []string
+	crypt          *storage.Crypt
+	ffmpegBin      string
+	index          *storage.Index
+	monitorConfigs func() monitor.RawConfigs
+	logger         *log.Logger
+	newProcess     ffmpeg.NewProcessFunc
+}
+
+func (g *generator) run(ctx context.Context, interval time.Duration) {
+	for {
+		g.generateAll(ctx, time.Now())
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// generateAll generates the timelapses of the last
+// completed period for every monitor that has one missing.
+func (g *generator) generateAll(ctx context.Context, now time.Time) {
+	for id, rawConf := range g.monitorConfigs() {
+		if ctx.Err() != nil {
+			return
+		}
+		conf := monitor.NewConfig(rawConf)
+		logf := func(level log.Level, format string, a ...interface{}) {
+			g.logger.Log(log.Entry{
+				Level:     level,
+				Src:       "timelapse",
+				MonitorID: id,
+				Msg:       fmt.Sprintf(format, a...),
+			})
+		}
+
+		c, err := parseConfig(conf)
+		if err != nil {
+			logf(log.LevelError, "could not parse config: %v", err)
+			continue
+		}
+		if c.schedule == scheduleOff {
+			continue
+		}
+
+		if err := g.generate(ctx, logf, conf, *c, now); err != nil {
+			logf(log.LevelError, err.Error())
+		}
+	}
+}
+
+func (g *generator) generate(
+	ctx context.Context,
+	logf log.Func,
+	conf monitor.Config,
+	c config,
+	now time.Time,
+) error {
+	start, end := lastPeriod(c.schedule, now)
+	name := timelapseName(start, c.schedule)
+	path := filepath.Join(g.timelapsesDir, conf.ID(), name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	segments := g.index.Query(conf.ID(), start, end)
+	if len(segments) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	tempPath := path + ".tmp"
+
+	logLevel := conf.LogLevel()
+	if logLevel == "" {
+		logLevel = "error"
+	}
+	args := genArgs(logLevel, tempPath, c.speed)
+
+	logf(log.LevelInfo, "generating: %v", strings.Join(args, " "))
+	cmd := exec.Command(g.ffmpegBin, args...)
+
+	r, w := io.Pipe()
+	cmd.Stdin = r
+
+	exportErr := make(chan error, 1)
+	go func() {
+		err := storage.ExportSegments(w, g.segmentsDirs, g.crypt, segments, start, end)
+		// The timelapse ends where the export stopped.
+		w.Close()
+		exportErr <- err
+	}()
+
+	logFunc := func(msg string) {
+		logf(log.FFmpegLevel(conf.LogLevel()), "process: %v", msg)
+	}
+	process := g.newProcess(cmd).
+		StdoutLogger(logFunc).
+		StderrLogger(logFunc)
+
+	err := process.Start(ctx)
+	r.Close()
+	if err2 := <-exportErr; err2 != nil && !errors.Is(err2, io.ErrClosedPipe) {
+		logf(log.LevelWarning, "export: %v", err2)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("could not generate timelapse: %w %v", err, args)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("could not rename temp file: %w", err)
+	}
+	logf(log.LevelInfo, "done: %v", name)
+	return nil
+}
+
+// Timelapse schedules.
+const (
+	scheduleOff    = ""
+	scheduleDaily  = "daily"
+	scheduleWeekly = "weekly"
+)
+
+// lastPeriod returns the start and end of the last completed
+// period before now. Weeks start on Monday at midnight.
+func lastPeriod(schedule string, now time.Time) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if schedule == scheduleWeekly {
+		daysSinceMonday := (int(today.Weekday()) + 6) % 7
+		weekStart := today.AddDate(0, 0, -daysSinceMonday)
+		return weekStart.AddDate(0, 0, -7), weekStart
+	}
+	return today.AddDate(0, 0, -1), today
+}
+
+const timelapseDateLayout = "2006-01-02"
+
+// timelapseName returns the file name, for example "2006-01-02_daily.mp4".
+func timelapseName(start time.Time, schedule string) string {
+	return start.Format(timelapseDateLayout) + "_" + schedule + ".mp4"
+}
+
+// ErrInvalidName invalid timelapse name.
+var ErrInvalidName = errors.New("invalid timelapse name")
+
+func parseName(name string) (time.Time, string, error) {
+	if !strings.HasSuffix(name, ".mp4") {
+		return time.Time{}, "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	date, schedule, found := strings.Cut(strings.TrimSuffix(name, ".mp4"), "_")
+	if !found || (schedule != scheduleDaily && schedule != scheduleWeekly) {
+		return time.Time{}, "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	start, err := time.ParseInLocation(timelapseDateLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return start, schedule, nil
+}
+
+// keyframeOnlySpeed speed-up factor at and above which only keyframes
+// are decoded. The skipped frames would be dropped anyway.
+const keyframeOnlySpeed = 100
+
+const outputFrameRate = "30"
+
+func genArgs(logLevel string, outputPath string, speed float64) []string {
+	args := []string{"-n", "-loglevel", logLevel, "-threads", "1"}
+	if speed >= keyframeOnlySpeed {
+		args = append(args, "-discard", "nokey")
+	}
+
+	filters := "setpts=PTS/" + strconv.FormatFloat(speed, 'f', -1, 64) +
+		",fps=" + outputFrameRate
+
+	return append(args,
+		"-i", "-", "-an",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "27",
+		"-vf", filters,
+		"-movflags", "+faststart",
+		"-f", "mp4", outputPath,
+	)
+}
+
+const defaultSpeed = 60
+
+type config struct {
+	schedule string
+	speed    float64
+}
+
+type rawConfigV1 struct {
+	Schedule string `json:"schedule"`
+	Speed    string `json:"speed"`
+}
+
+// Config errors.
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	ErrInvalidSpeed    = errors.New("invalid speed")
+)
+
+func parseConfig(conf monitor.Config) (*config, error) {
+	var rawConf rawConfigV1
+	rawTimelapse := conf.Get("timelapse")
+	if rawTimelapse != "" {
+		err := json.Unmarshal([]byte(rawTimelapse), &rawConf)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal timelapse: %w", err)
+		}
+	}
+
+	switch rawConf.Schedule {
+	case "", "off":
+		return &config{schedule: scheduleOff}, nil
+	case scheduleDaily, scheduleWeekly:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, rawConf.Schedule)
+	}
+
+	speed := float64(defaultSpeed)
+	if rawConf.Speed != "" {
+		var err error
+		speed, err = strconv.ParseFloat(rawConf.Speed, 64)
+		if err != nil || speed < 1 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSpeed, rawConf.Speed)
+		}
+	}
+
+	return &config{
+		schedule: rawConf.Schedule,
+		speed:    speed,
+	}, nil
+}
+
+type timelapse struct {
+	Name      string    `json:"name"`
+	MonitorID string    `json:"monitorId"`
+	Schedule  string    `json:"schedule"`
+	Start     time.Time `json:"start"`
+	Size      int64     `json:"size"`
+}
+
+// listTimelapses returns the timelapses of the monitor, or
+// every monitor if monitorID is empty. Newest first.
+func listTimelapses(timelapsesDir string, monitorID string) ([]timelapse, error) {
+	monitorDirs, err := os.ReadDir(timelapsesDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []timelapse{}, nil
+		}
+		return nil, err
+	}
+
+	timelapses := []timelapse{}
+	for _, dir := range monitorDirs {
+		if !dir.IsDir() || (monitorID != "" && dir.Name() != monitorID) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(timelapsesDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			start, schedule, err := parseName(file.Name())
+			if err != nil {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			timelapses = append(timelapses, timelapse{
+				Name:      dir.Name() + "/" + file.Name(),
+				MonitorID: dir.Name(),
+				Schedule:  schedule,
+				Start:     start,
+				Size:      info.Size(),
+			})
+		}
+	}
+
+	sort.SliceStable(timelapses, func(i, j int) bool {
+		return timelapses[i].Start.After(timelapses[j].Start)
+	})
+	return timelapses, nil
+}
+
+func handleList(timelapsesDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		timelapses, err := listTimelapses(timelapsesDir, r.URL.Query().Get("monitor"))
+		if err != nil {
+			http.Error(w, "could not list timelapses", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(timelapses); err != nil {
+			http.Error(w, "could not encode json", http.StatusInternalServerError)
+		}
+	})
+}
+
+func handleVideo(timelapsesDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Path[21:] // Trim "/api/timelapse/video/"
+		monitorID, file, found := strings.Cut(name, "/")
+		if !found || monitorID == "" || strings.Contains(file, "/") {
+			http.Error(w, "invalid timelapse name", http.StatusBadRequest)
+			return
+		}
+		if _, _, err := parseName(file); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// ServeFile will sanitize ".."
+		http.ServeFile(w, r, filepath.Join(timelapsesDir, monitorID, file))
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package timelapse
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/monitor"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenArgs(t *testing.T) {
+	t.Run("allFrames", func(t *testing.T) {
+		actual := genArgs("2", "4", 60)
+		expected := []string{
+			"-n", "-loglevel", "2", "-threads", "1",
+			"-i", "-", "-an",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "27",
+			"-vf", "setpts=PTS/60,fps=30",
+			"-movflags", "+faststart",
+			"-f", "mp4", "4",
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("keyframesOnly", func(t *testing.T) {
+		actual := genArgs("2", "4", 1000.5)
+		expected := []string{
+			"-n", "-loglevel", "2", "-threads", "1", "-discard", "nokey",
+			"-i", "-", "-an",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "27",
+			"-vf", "setpts=PTS/1000.5,fps=30",
+			"-movflags", "+faststart",
+			"-f", "mp4", "4",
+		}
+		require.Equal(t, expected, actual)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected *config
+		err      error
+	}{
+		"empty":    {"", &config{schedule: scheduleOff}, nil},
+		"off":      {`{"schedule":"off","speed":"5"}`, &config{schedule: scheduleOff}, nil},
+		"default":  {`{"schedule":"daily"}`, &config{schedule: "daily", speed: 60}, nil},
+		"weekly":   {`{"schedule":"weekly","speed":"600"}`, &config{schedule: "weekly", speed: 600}, nil},
+		"schedule": {`{"schedule":"monthly"}`, nil, ErrInvalidSchedule},
+		"speed":    {`{"schedule":"daily","speed":"0.5"}`, nil, ErrInvalidSpeed},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			conf := monitor.NewConfig(monitor.RawConfig{"timelapse": tc.input})
+			actual, err := parseConfig(conf)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestLastPeriod(t *testing.T) {
+	// Wednesday.
+	now := time.Date(2022, 6, 15, 13, 30, 0, 0, time.UTC)
+
+	start, end := lastPeriod(scheduleDaily, now)
+	require.Equal(t, time.Date(2022, 6, 14, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC), end)
+
+	start, end = lastPeriod(scheduleWeekly, now)
+	require.Equal(t, time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC), end)
+
+	// Monday.
+	start, end = lastPeriod(scheduleWeekly, time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2022, 6, 6, 0, 0, 0, 0, time.UTC), start)
+	require.Equal(t, time.Date(2022, 6, 13, 0, 0, 0, 0, time.UTC), end)
+}
+
+func TestListTimelapses(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("abc"), 0o600))
+	}
+	writeFile("m1/2022-06-14_daily.mp4")
+	writeFile("m1/2022-06-15_daily.mp4")
+	writeFile("m1/2022-06-15_daily.mp4.tmp")
+	writeFile("m2/2022-06-06_weekly.mp4")
+
+	timelapses, err := listTimelapses(dir, "")
+	require.NoError(t, err)
+	names := []string{}
+	for _, t := range timelapses {
+		names = append(names, t.Name)
+	}
+	require.Equal(t, []string{
+		"m1/2022-06-15_daily.mp4",
+		"m1/2022-06-14_daily.mp4",
+		"m2/2022-06-06_weekly.mp4",
+	}, names)
+	require.Equal(t, int64(3), timelapses[0].Size)
+	require.Equal(t, "daily", timelapses[0].Schedule)
+
+	timelapses, err = listTimelapses(dir, "m2")
+	require.NoError(t, err)
+	require.Len(t, timelapses, 1)
+	require.Equal(t, "m2", timelapses[0].MonitorID)
+
+	timelapses, err = listTimelapses(filepath.Join(dir, "nil"), "")
+	require.NoError(t, err)
+	require.Empty(t, timelapses)
+}
@@ -0,0 +1,167 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package timelapse
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func modifySubTemplates(subFiles map[string]string) error {
+	tpl, exists := subFiles["sidebar.tpl"]
+	if !exists {
+		return fmt.Errorf("timelapse: sidebar.tpl: %w", os.ErrNotExist)
+	}
+
+	subFiles["sidebar.tpl"] = modifySidebar(tpl)
+	return nil
+}
+
+func modifySidebar(tpl string) string {
+	target := `<a href="recordings" id="nav-link-recordings" class="nav-link">
+				<img class="icon" src="static/icons/feather/film.svg" />
+				<span class="nav-text">Recordings</span>
+			</a>`
+	timelapseButton := `<a href="timelapse" id="nav-link-timelapse" class="nav-link">
+				<img class="icon" src="static/icons/feather/video.svg" />
+				<span class="nav-text">Timelapse</span>
+			</a>`
+
+	return strings.ReplaceAll(tpl, target, target+timelapseButton)
+}
+
+//go:embed timelapse.tpl
+var timelapseTplFile string
+
+func modifyTemplates(pageFiles map[string]string) error {
+	js, exists := pageFiles["settings.js"]
+	if !exists {
+		return fmt.Errorf("timelapse: settings.js: %w", os.ErrNotExist)
+	}
+	pageFiles["settings.js"] = modifySettingsjs(js)
+
+	pageFiles["timelapse.tpl"] = timelapseTplFile
+	return nil
+}
+
+func modifySettingsjs(tpl string) string { //nolint:funlen
+	const target = "logLevel: fieldTemplate.select("
+
+	const javascript = `
+		timelapse: (() => {
+			const fields = {
+				schedule: fieldTemplate.select(
+					"Schedule",
+					["off", "daily", "weekly"],
+					"off",
+				),
+				speed: newField(
+					[inputRules.notEmpty, inputRules.noSpaces],
+					{
+						errorField: true,
+						input: "number",
+						min: "1",
+					},
+					{
+						label: "Speed",
+						placeholder: "60",
+						initial: 60,
+					}
+				),
+			};
+
+			const form = newForm(fields);
+			const modal = newModal("Timelapse", form.html());
+
+			let value = {};
+
+			let isRendered = false;
+			const render = (element) => {
+				if (isRendered) {
+					return;
+				}
+				element.insertAdjacentHTML("beforeend", modal.html);
+				element.querySelector(".js-modal").style.maxWidth = "12rem";
+
+				const $modalContent = modal.init(element);
+				form.init($modalContent);
+
+				modal.onClose(() => {
+					// Get value.
+					for (const key of Object.keys(form.fields)) {
+						value[key] = form.fields[key].value();
+					}
+				});
+
+				isRendered = true;
+			};
+
+			const update = () => {
+				// Set value.
+				for (const key of Object.keys(form.fields)) {
+					if (form.fields[key] && form.fields[key].set) {
+						if (value[key]) {
+							form.fields[key].set(value[key]);
+						} else {
+							form.fields[key].set("");
+						}
+					}
+				}
+			};
+
+			const id = uniqueID();
+
+			return {
+				html: ` + "`" + `
+					<li id="${id}" class="form-field" style="display:flex;">
+						<label class="form-field-label">Timelapse</label>
+						<div>
+							<button class="form-field-edit-btn" style="background: var(--color3);">
+								<img src="static/icons/feather/edit-3.svg"/>
+							</button>
+						</div>
+					</li> ` + "`" + `,
+				value() {
+					return JSON.stringify(value);
+				},
+				set(input) {
+					value = input ? JSON.parse(input) : {};
+				},
+				validate() {
+					if (!isRendered) {
+						return "";
+					}
+					const err = form.validate();
+					if (err != "") {
+						return "Timelapse: " + err;
+					}
+					return "";
+				},
+				init($parent) {
+					const element = $parent.querySelector("#" + id);
+					element.querySelector(".form-field-edit-btn").addEventListener("click", () => {
+						render(element);
+						update();
+						modal.open();
+					});
+				},
+			};
+		})(),`
+
+	return strings.ReplaceAll(tpl, target, javascript+target)
+}
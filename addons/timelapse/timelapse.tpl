@@ -0,0 +1,92 @@
+<!--
+Copyright 2020-2022 The OS-NVR Authors.
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation; either version 2 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+-->
+
+<!DOCTYPE html>
+{{ template "html" }}
+<head>
+	{{ template "meta" . }}
+	<script type="module" defer>
+		import { fetchGet } from "./static/scripts/libs/common.mjs";
+
+		const escape = (s) => {
+			const div = document.createElement("div");
+			div.textContent = s;
+			return div.innerHTML;
+		};
+
+		(async () => {
+			const timelapses = await fetchGet(
+				"api/timelapse/list",
+				"could not get timelapses",
+			);
+			if (!timelapses) {
+				return;
+			}
+
+			let html = "";
+			for (const t of timelapses) {
+				const date = new Date(t.start).toLocaleDateString();
+				html += `
+					<div class="timelapse">
+						<span class="timelapse-title">
+							${escape(t.monitorId)} ${escape(t.schedule)} ${date}
+						</span>
+						<video
+							controls
+							preload="none"
+							src="api/timelapse/video/${encodeURI(t.name)}"
+						></video>
+					</div>`;
+			}
+			if (html === "") {
+				html = `<span class="timelapse-title">No timelapses</span>`;
+			}
+			document.querySelector(".js-timelapses").innerHTML = html;
+		})();
+	</script>
+</head>
+<body>
+	{{ template "sidebar" . }}
+	<div id="content">
+		<div class="timelapses js-timelapses"></div>
+	</div>
+</body>
+<style>
+	#nav-link-timelapse {
+		background: var(--color1-hover);
+	}
+	.timelapses {
+		display: grid;
+		grid-template-columns: repeat(auto-fill, minmax(16rem, 1fr));
+		gap: 0.5rem;
+		padding: 0.5rem;
+	}
+	.timelapse {
+		display: flex;
+		flex-direction: column;
+	}
+	.timelapse video {
+		width: 100%;
+		background: var(--color3);
+	}
+	.timelapse-title {
+		padding: 0.2rem;
+		color: var(--color-text);
+		font-size: 0.6rem;
+	}
+</style>
+{{ template "html2" }}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"nvr"
 	"nvr/pkg/ffmpeg"
@@ -43,7 +44,7 @@ func init() {
 	nvr.RegisterAppRunHook(func(_ context.Context, app *nvr.App) error {
 		app.Router.Handle(
 			"/api/recording/timeline/",
-			app.Auth.User(handleTimeline(app.Env.RecordingsDir())),
+			app.Auth.User(handleTimeline(app.Env.RecordingsDirs())),
 		)
 		app.Router.Handle(
 			"/timeline",
@@ -57,7 +58,7 @@ func init() {
 	})
 }
 
-func handleTimeline(recordingsDir string) http.Handler {
+func handleTimeline(recordingsDirs []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -71,6 +72,7 @@ func handleTimeline(recordingsDir string) http.Handler {
 			return
 		}
 
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, timelinePath)
 		path := filepath.Join(recordingsDir, timelinePath+".timeline")
 
 		// ServeFile will sanitize ".."
@@ -106,7 +108,12 @@ func recSaved(
 		return fmt.Errorf("could not parse config: %w", err)
 	}
 
-	video, err := storage.NewVideoReader(recPath, nil)
+	var video io.ReadCloser
+	if storage.IsMKVRecording(recPath) {
+		video, err = storage.OpenRecordingFile(recPath+".mkv", r.Env.Crypt)
+	} else {
+		video, err = storage.NewVideoReader(recPath, nil, r.Env.Crypt)
+	}
 	if err != nil {
 		return fmt.Errorf("video reader: %w", err)
 	}
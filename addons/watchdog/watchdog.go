@@ -1,23 +1,52 @@
 package watchdog
 
-// Watchdog detects and restarts frozen processes.
-// Freeze is detected by polling the output HLS manifest for file updates.
+// Watchdog detects and restarts stalled monitors.
+// An input is stalled if the muxer stops receiving frames or finalizing
+// segments while the process is still running, the monitor shows a
+// frozen image without any error in that case.
 
 import (
 	"context"
 	"fmt"
 	"nvr"
+	"nvr/pkg/feed"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
+	"nvr/pkg/video/hls"
+	"sync"
 	"time"
 )
 
 func init() {
+	nvr.RegisterAppRunHook(onAppRun)
 	nvr.RegisterMonitorInputProcessHook(onInputProcessStart)
 	nvr.RegisterLogSource([]string{"watchdog"})
 }
 
-const defaultInterval = 15 * time.Second
+const (
+	defaultInterval       = time.Second
+	defaultFrameTimeout   = 15 * time.Second
+	defaultSegmentTimeout = 60 * time.Second
+)
+
+var addon struct {
+	app *nvr.App
+	mu  sync.Mutex
+}
+
+func onAppRun(_ context.Context, app *nvr.App) error {
+	addon.mu.Lock()
+	addon.app = app
+	addon.mu.Unlock()
+	return nil
+}
+
+// stallData feed message data.
+type stallData struct {
+	hls.Liveness
+	SubInput bool   `json:"subInput"`
+	Reason   string `json:"reason"`
+}
 
 func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]string) {
 	monitorID := i.Config.ID()
@@ -33,70 +62,103 @@ func onInputProcessStart(ctx context.Context, i *monitor.InputProcess, _ *[]stri
 		})
 	}
 
-	muxer := func() (muxer, error) {
-		return i.HLSMuxer()
+	liveness := func() (hls.Liveness, error) {
+		muxer, err := i.HLSMuxer()
+		if err != nil {
+			return hls.Liveness{}, err
+		}
+		return muxer.Liveness(), nil
+	}
+
+	addon.mu.Lock()
+	app := addon.app
+	addon.mu.Unlock()
+
+	onStall := func(l hls.Liveness, reason string) {
+		if app == nil {
+			i.Cancel()
+			return
+		}
+		app.Feed.Publish(feed.Message{
+			Type:      feed.TypeStall,
+			MonitorID: monitorID,
+			Data: stallData{
+				Liveness: l,
+				SubInput: i.IsSubInput(),
+				Reason:   reason,
+			},
+		})
+		if err := app.RestartMonitor(monitorID); err != nil {
+			logf(log.LevelError, "could not restart monitor: %v", err)
+		}
 	}
 
 	d := &watchdog{
-		muxer:    muxer,
-		interval: defaultInterval,
-		onFreeze: i.Cancel,
-		logf:     logf,
+		liveness:       liveness,
+		interval:       defaultInterval,
+		frameTimeout:   defaultFrameTimeout,
+		segmentTimeout: defaultSegmentTimeout,
+		onStall:        onStall,
+		logf:           logf,
+		now:            time.Now,
 	}
 	go d.start(ctx)
 }
 
-type muxer interface {
-	WaitForSegFinalized()
-}
-
 type watchdog struct {
-	muxer    func() (muxer, error)
-	interval time.Duration
-	onFreeze func()
-	logf     func(log.Level, string, ...interface{})
+	liveness       func() (hls.Liveness, error)
+	interval       time.Duration
+	frameTimeout   time.Duration
+	segmentTimeout time.Duration
+	onStall        func(hls.Liveness, string)
+	logf           log.Func
+	now            func() time.Time
 }
 
 func (d *watchdog) start(ctx context.Context) {
-	// Warmup.
-	select {
-	case <-time.After(d.interval):
-	case <-ctx.Done():
-		return
-	}
-
-	keepAlive := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-time.After(1 * time.Second):
-			case <-ctx.Done():
-				return
-			}
-
-			muxer, err := d.muxer()
-			if err != nil {
-				d.logf(log.LevelError, "could not get muxer")
-				continue
-			}
-
-			muxer.WaitForSegFinalized()
-			select {
-			case <-ctx.Done():
-				return
-			case keepAlive <- struct{}{}:
-			}
-		}
-	}()
+	// The timeouts are measured from the start
+	// of the process until the first frame.
+	started := d.now()
 
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.After(d.interval):
-			d.logf(log.LevelError, "possible freeze detected, restarting..")
-			d.onFreeze()
-		case <-keepAlive:
 		case <-ctx.Done():
 			return
+		case <-ticker.C:
+		}
+
+		// The muxer doesn't exist until the process publishes the stream.
+		l, _ := d.liveness()
+		reason := d.check(l, started)
+		if reason == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
 		}
+		d.logf(log.LevelError, "stall detected, %v, restarting monitor", reason)
+		d.onStall(l, reason)
+		return
+	}
+}
+
+// check returns the reason if the input is stalled.
+func (d *watchdog) check(l hls.Liveness, started time.Time) string {
+	now := d.now()
+	if since := now.Sub(latest(started, l.LastFrame)); since > d.frameTimeout {
+		return fmt.Sprintf("no frames for %v", since.Round(time.Second))
+	}
+	if since := now.Sub(latest(started, l.LastSegment)); since > d.segmentTimeout {
+		return fmt.Sprintf("no segments for %v", since.Round(time.Second))
+	}
+	return ""
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
 	}
+	return a
 }
@@ -7,51 +7,57 @@ import (
 	"time"
 
 	"nvr/pkg/log"
+	"nvr/pkg/video/hls"
 
 	"github.com/stretchr/testify/require"
 )
 
-type stubMuxer struct{}
-
-func (m *stubMuxer) WaitForSegFinalized() {}
-
-func newStubMuxer(err error) func() (muxer, error) {
-	return func() (muxer, error) {
-		return &stubMuxer{}, err
-	}
-}
-
-func newTestWatchdog(t *testing.T) (watchdog, chan string) {
+func newTestWatchdog(t *testing.T) (*watchdog, chan string) {
 	logs := make(chan string)
 	logFunc := func(_ log.Level, format string, a ...interface{}) {
 		logs <- fmt.Sprintf(format, a...)
 	}
 
-	d := watchdog{
-		interval: 10 * time.Millisecond,
-		muxer:    newStubMuxer(nil),
-		onFreeze: func() {},
-		logf:     logFunc,
+	d := &watchdog{
+		liveness: func() (hls.Liveness, error) {
+			return hls.Liveness{}, nil
+		},
+		interval:       time.Millisecond,
+		frameTimeout:   10 * time.Millisecond,
+		segmentTimeout: 10 * time.Millisecond,
+		onStall:        func(hls.Liveness, string) {},
+		logf:           logFunc,
+		now:            time.Now,
 	}
 
 	return d, logs
 }
 
 func TestWatchdog(t *testing.T) {
-	t.Run("freeze", func(t *testing.T) {
+	t.Run("stall", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
 		d, logs := newTestWatchdog(t)
+		d.now = func() time.Time { return time.Unix(0, 0) }
+		started := d.now()
+
+		lastFrame := started.Add(time.Hour)
+		d.liveness = func() (hls.Liveness, error) {
+			d.now = func() time.Time { return lastFrame.Add(16 * time.Second) }
+			return hls.Liveness{LastFrame: lastFrame}, nil
+		}
 
-		done := make(chan struct{})
-		d.onFreeze = func() {
-			close(done)
+		done := make(chan string)
+		d.onStall = func(l hls.Liveness, reason string) {
+			require.Equal(t, lastFrame, l.LastFrame)
+			done <- reason
 		}
 
+		d.frameTimeout = 15 * time.Second
 		go d.start(ctx)
-		require.Equal(t, "possible freeze detected, restarting..", <-logs)
-		<-done
+		require.Equal(t, "stall detected, no frames for 16s, restarting monitor", <-logs)
+		require.Equal(t, "no frames for 16s", <-done)
 	})
 	t.Run("canceled", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -61,3 +67,50 @@ func TestWatchdog(t *testing.T) {
 		d.start(ctx)
 	})
 }
+
+func TestWatchdogCheck(t *testing.T) {
+	started := time.Unix(1000, 0)
+	now := started.Add(30 * time.Second)
+	d := &watchdog{
+		frameTimeout:   15 * time.Second,
+		segmentTimeout: 60 * time.Second,
+		now:            func() time.Time { return now },
+	}
+
+	cases := map[string]struct {
+		liveness hls.Liveness
+		expected string
+	}{
+		"ok": {
+			hls.Liveness{LastFrame: now.Add(-time.Second), LastSegment: now.Add(-time.Second)},
+			"",
+		},
+		"noFrames": {
+			hls.Liveness{},
+			"no frames for 30s",
+		},
+		"frameStall": {
+			hls.Liveness{LastFrame: now.Add(-20 * time.Second), LastSegment: now},
+			"no frames for 20s",
+		},
+		"warmup": {
+			hls.Liveness{LastFrame: now},
+			"",
+		},
+		"segmentWarmup": {
+			hls.Liveness{LastFrame: now, LastSegment: started.Add(-time.Hour)},
+			"",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, d.check(tc.liveness, started))
+		})
+	}
+
+	t.Run("segmentTimeout", func(t *testing.T) {
+		now = started.Add(2 * time.Minute)
+		l := hls.Liveness{LastFrame: now, LastSegment: started.Add(time.Second)}
+		require.Equal(t, "no segments for 1m59s", d.check(l, started))
+	})
+}
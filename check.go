@@ -0,0 +1,137 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package nvr
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/storage"
+	"nvr/pkg/web"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrInvalidConfig the config check found problems.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// runConfigCheck prints the problems in the configuration, the app isn't started.
+func runConfigCheck(envPath string) error {
+	problems := checkConfig(envPath, hooks.monitor())
+	if len(problems) == 0 {
+		fmt.Println("configuration is valid")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Printf("%v: %v\n", p.Config, p.Error)
+	}
+	return fmt.Errorf("%w: %d problems", ErrInvalidConfig, len(problems))
+}
+
+// checkConfig validates env.yaml, general.json and the monitor
+// configs on disk without applying them. The environment has
+// to be valid before the other configs can be checked.
+func checkConfig(envPath string, monitorHooks *monitor.Hooks) []web.ConfigProblem {
+	problems := []web.ConfigProblem{}
+	add := func(config string, err error) {
+		problems = append(problems, web.ConfigProblem{Config: config, Error: err.Error()})
+	}
+
+	envName := filepath.Base(envPath)
+	envYAML, err := os.ReadFile(envPath)
+	if err != nil {
+		add(envName, err)
+		return problems
+	}
+	env, err := storage.NewConfigEnv(envPath, envYAML)
+	if err != nil {
+		add(envName, err)
+		return problems
+	}
+	for _, err := range env.CheckPaths() {
+		add(envName, err)
+	}
+	if _, err := web.ParseTrustedProxies(env.TrustedProxies); err != nil {
+		add(envName, fmt.Errorf("trustedProxies: %w", err))
+	}
+	logger := log.NewLogger(&sync.WaitGroup{}, hooks.logSource)
+	if err := applyLogLevels(logger, env.LogLevels); err != nil {
+		add(envName, err)
+	}
+
+	if err := storage.CheckConfigGeneral(env.ConfigDir); err != nil {
+		add("general.json", err)
+	}
+
+	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
+	if _, err := os.Stat(monitorConfigDir); errors.Is(err, os.ErrNotExist) {
+		return problems
+	}
+	monitorProblems, err := monitor.CheckConfigs(
+		monitorConfigDir, monitorHooks.Migrate, monitorHooks.Validate)
+	if err != nil {
+		add("monitors", err)
+		return problems
+	}
+	for _, p := range monitorProblems {
+		add(filepath.Join("monitors", p.File), p.Err)
+	}
+	return problems
+}
+
+// restoreConfig checks the backup before it replaces the config files,
+// the backup is only restored with problems if force is set. The files
+// are extracted next to the config directory to keep the default paths.
+func restoreConfig(
+	envPath string,
+	monitorHooks *monitor.Hooks,
+	backup *storage.ConfigBackup,
+	force bool,
+	reload func() (web.ConfigReloadResult, error),
+) (web.ConfigRestoreResult, error) {
+	configDir := filepath.Dir(envPath)
+	stagingDir, err := os.MkdirTemp(filepath.Dir(configDir), ".configs-restore-")
+	if err != nil {
+		return web.ConfigRestoreResult{}, fmt.Errorf("create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	if err := backup.Extract(stagingDir); err != nil {
+		return web.ConfigRestoreResult{}, fmt.Errorf("extract: %w", err)
+	}
+	result := web.ConfigRestoreResult{
+		Files:    backup.Files(),
+		Removed:  []string{},
+		Problems: checkConfig(filepath.Join(stagingDir, filepath.Base(envPath)), monitorHooks),
+	}
+	if len(result.Problems) != 0 && !force {
+		return result, nil
+	}
+
+	result.Removed, err = backup.Restore(configDir)
+	if err != nil {
+		return result, fmt.Errorf("restore: %w", err)
+	}
+	result.Restored = true
+
+	result.Reload, err = reload()
+	if err != nil {
+		return result, fmt.Errorf("reload: %w", err)
+	}
+	return result, nil
+}
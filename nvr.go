@@ -17,23 +17,33 @@ package nvr
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
+	"nvr/pkg/arming"
+	"nvr/pkg/feed"
 	"nvr/pkg/group"
+	"nvr/pkg/health"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
+	"nvr/pkg/onvif"
 	"nvr/pkg/storage"
 	"nvr/pkg/system"
+	"nvr/pkg/thumbnail"
 	"nvr/pkg/video"
 	"nvr/pkg/web"
 	"nvr/pkg/web/auth"
+	"nvr/pkg/web/certs"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -42,6 +52,7 @@ import (
 // Run .
 func Run() error {
 	envFlag := flag.String("env", "", "path to env.yaml")
+	checkFlag := flag.Bool("check-config", false, "validate the configuration and exit")
 	flag.Parse()
 
 	if *envFlag == "" {
@@ -54,6 +65,10 @@ func Run() error {
 		return fmt.Errorf("could not get absolute path of env.yaml: %w", err)
 	}
 
+	if *checkFlag {
+		return runConfigCheck(envPath)
+	}
+
 	wg := &sync.WaitGroup{}
 	app, err := newApp(envPath, wg, hooks)
 	if err != nil {
@@ -77,19 +92,74 @@ func Run() error {
 		app.logf(log.LevelInfo, "received %v, stopping", signal)
 	}
 
-	app.monitorManager.StopMonitors()
-	app.logf(log.LevelInfo, "Monitors stopped.")
+	// Ordered shutdown, new requests are rejected first and the live
+	// viewers are told that the streams ended before the monitors
+	// are stopped. Everything shares the same deadline.
+	shutdownTimeout := time.Duration(app.Env.ShutdownTimeout) * time.Second
+	ctx2, cancel2 := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel2()
+
+	serversStopped := app.shutdownServers(ctx2)
+	app.videoServer.EndStreams()
+
+	app.logf(log.LevelInfo, "finalizing recordings, timeout %v", shutdownTimeout)
+	if err := app.monitorManager.Shutdown(ctx2); err != nil {
+		app.logf(log.LevelError, "%v", err)
+	} else {
+		app.logf(log.LevelInfo, "Monitors stopped.")
+	}
+
+	if err := app.Index.Close(); err != nil {
+		app.logf(log.LevelError, "could not close segment index: %v", err)
+	}
+	if err := app.Events.Close(); err != nil {
+		app.logf(log.LevelError, "could not close event store: %v", err)
+	}
 
 	cancel()
 	wg.Wait()
 
-	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel2()
-
+	serversErr := <-serversStopped
 	if err != nil {
 		return err
 	}
-	return app.server.Shutdown(ctx2)
+	return serversErr
+}
+
+// shutdownServers stops the HTTP servers from accepting new connections
+// and waits for the active requests in the background. Connections
+// that are still active at the deadline are closed.
+func (app *App) shutdownServers(ctx context.Context) <-chan error {
+	var servers []*http.Server
+	for _, server := range []*http.Server{app.server, app.tlsServer} {
+		if server != nil {
+			servers = append(servers, server)
+		}
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		server := server
+		go func() {
+			err := server.Shutdown(ctx)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = server.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var firstErr error
+		for range servers {
+			if err := <-errs; err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		done <- firstErr
+	}()
+	return done
 }
 
 // App is the main application.
@@ -97,14 +167,24 @@ type App struct {
 	WG             *sync.WaitGroup
 	Logger         *log.Logger
 	logStore       *log.Store
+	logFile        *log.RotatingFile
 	Env            storage.ConfigEnv
 	monitorManager *monitor.Manager
+	general        *storage.ConfigGeneral
+	Arming         *arming.Manager
+	Feed           *feed.Feed
 	Auth           auth.Authenticator
 	Storage        *storage.Manager
+	DiskMonitor    *storage.DiskMonitor
+	Health         *health.Registry
+	Index          *storage.Index
+	Events         *storage.EventStore
 	videoServer    *video.Server
 	Templater      *web.Templater
 	Router         *http.ServeMux
+	API            *web.API
 	server         *http.Server
+	tlsServer      *http.Server
 }
 
 func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) { //nolint:funlen
@@ -118,6 +198,12 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not get environment config: %w", err)
 	}
+	if env.EncryptRecordings {
+		env.Crypt, err = storage.LoadCrypt(env.RecordingKeyPath())
+		if err != nil {
+			return nil, fmt.Errorf("could not load recording key: %w", err)
+		}
+	}
 
 	general, err := storage.NewConfigGeneral(env.ConfigDir)
 	if err != nil {
@@ -127,27 +213,111 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	// Logs.
 	logDir := filepath.Join(env.StorageDir, "logs")
 	logger := log.NewLogger(wg, hooks.logSource)
+	if err := applyLogLevels(logger, env.LogLevels); err != nil {
+		return nil, err
+	}
 	logStore, err := log.NewStore(logDir, wg, general.DiskSpace)
 	if err != nil {
 		return nil, fmt.Errorf("could not create log store: %w", err)
 	}
+	var logFile *log.RotatingFile
+	if env.LogFile != "" {
+		logFile, err = log.NewRotatingFile(
+			env.LogFile,
+			int64(env.LogFileMaxSize)*1000000,
+			time.Duration(env.LogFileMaxAge)*time.Hour,
+			env.LogFileBackups,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not open log file: %w", err)
+		}
+	}
 
 	// Video server.
 	videoServer := video.NewServer(logger, wg, *env)
 
+	// Segment index.
+	index, err := storage.OpenIndex(env.IndexPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not open segment index: %w", err)
+	}
+
+	// Event store.
+	events, err := storage.OpenEventStore(env.EventsPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not open event store: %w", err)
+	}
+
+	bookmarks, err := storage.NewBookmarkStore(env.BookmarksPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not open bookmarks: %w", err)
+	}
+
+	// Integrity ledger.
+	var signingKey ed25519.PrivateKey
+	if env.SignRecordings {
+		signingKey, err = storage.LoadSigningKey(env.IntegrityKeyPath())
+		if err != nil {
+			return nil, fmt.Errorf("could not load integrity signing key: %w", err)
+		}
+	}
+	ledger := storage.NewLedger(env.IntegrityDir(), signingKey)
+
+	thumbnailer, err := thumbnail.NewThumbnailer(
+		index.Query,
+		env.SegmentsDirs(),
+		env.Crypt,
+		thumbnail.NewFFmpegDecoder(env.FFmpegBin),
+		env.ThumbnailsDir(),
+		int64(env.ThumbnailCacheSize)*1000000,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create thumbnailer: %w", err)
+	}
+
+	prefetcher := storage.NewPrefetcher(
+		env.SegmentPrefetch, int64(env.SegmentPrefetchCacheSize)*1000000)
+
+	diskMonitor := storage.NewDiskMonitor(*env, logger)
+
+	// Arming.
+	armingManager, err := arming.NewManager(filepath.Join(env.ConfigDir, "arming.json"), logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create arming manager: %w", err)
+	}
+
+	liveFeed := feed.New()
+	armingManager.Subscribe(func(state arming.State) {
+		liveFeed.Publish(feed.Message{Type: feed.TypeArming, Data: state})
+	})
+
 	// Monitors.
 	monitorConfigDir := filepath.Join(env.ConfigDir, "monitors")
 	monitorManager, err := monitor.NewManager(
 		monitorConfigDir,
 		*env,
+		index,
+		ledger,
+		events,
+		diskMonitor,
+		armingManager,
 		logger,
 		videoServer,
-		hooks.monitor(),
+		feedHooks(hooks.monitor(), liveFeed),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not create monitor manager: %w", err)
 	}
 
+	healthChecks := health.NewRegistry()
+	healthChecks.Register(func() map[string]health.Component {
+		return map[string]health.Component{
+			"web": {Status: health.StatusOK, Critical: true},
+		}
+	})
+	healthChecks.Register(diskMonitor.HealthCheck)
+	healthChecks.Register(monitorManager.HealthCheck)
+
 	// Monitor groups.
 	groupConfigDir := filepath.Join(env.ConfigDir, "groups")
 	groupManager, err := group.NewManager(groupConfigDir)
@@ -165,10 +335,63 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not create authenticator: %w", err)
 	}
+	for _, hook := range hooks.auth {
+		a, err = hook(a, *env, logger)
+		if err != nil {
+			return nil, fmt.Errorf("auth hook: %w", err)
+		}
+	}
+
+	tokenStore, err := auth.NewTokenStore(filepath.Join(env.ConfigDir, "tokens.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not create token store: %w", err)
+	}
+	a = auth.WithTokens(auth.WithRoles(a, groupManager.Monitors), tokenStore, logger)
+
+	auditLog := auth.NewAuditLog(filepath.Join(env.StorageDir, "audit.log"))
+	guard := auth.NewGuard(auditLog, logger)
+	a = auth.WithGuard(a, guard)
+
+	sessionLifetime, sessionIdleTimeout := env.SessionDurations()
+	sessionStore, err := auth.NewSessionStore(
+		filepath.Join(env.StorageDir, "sessions.json"), sessionLifetime, sessionIdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not create session store: %w", err)
+	}
+	// Outside the guard, rejected sessions are not failed logins.
+	a = auth.WithSessions(a, sessionStore)
+
+	videoServer.SetRTSPAuth(func(r *http.Request) bool {
+		if _, locked := guard.Locked(r); locked {
+			return false
+		}
+		res := a.ValidateRequest(r)
+		if !res.IsValid {
+			if r.Header.Get("Authorization") != "" {
+				guard.Failed(r)
+			}
+			return false
+		}
+		guard.Succeeded(r, res.User.Username)
+		monitorID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		return res.User.MonitorAllowed(monitorID)
+	})
 
 	// Storage.
-	storageManager := storage.NewManager(env.StorageDir, general, logger)
-	crawler := storage.NewCrawler(os.DirFS(storageManager.RecordingsDir()))
+	storageManager := storage.NewManager(
+		env.StorageDir,
+		env.Archive(),
+		general,
+		index,
+		events,
+		monitorManager.RetentionPolicies,
+		logger,
+	)
+	var recordingsFS []fs.FS
+	for _, dir := range env.RecordingsDirs() {
+		recordingsFS = append(recordingsFS, os.DirFS(dir))
+	}
+	crawler := storage.NewCrawler(storage.NewTieredFS(recordingsFS...))
 
 	// Time zone.
 	timeZone, err := system.TimeZone()
@@ -194,7 +417,15 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 			data["groups"] = string(groups)
 		},
 		func(data template.FuncMap, page string) {
-			monitors, _ := json.Marshal(monitorManager.MonitorsInfo())
+			// The user is set by the templater before the data funcs.
+			user, _ := data["user"].(auth.Account)
+			info := monitorManager.MonitorsInfo()
+			for id := range info {
+				if !user.MonitorAllowed(id) {
+					delete(info, id)
+				}
+			}
+			monitors, _ := json.Marshal(info)
 			data["monitors"] = string(monitors)
 		},
 		func(data template.FuncMap, page string) {
@@ -215,75 +446,810 @@ func newApp(envPath string, wg *sync.WaitGroup, hooks *hookList) (*App, error) {
 
 	router.Handle("/static/", a.User(web.Static()))
 	router.Handle("/hls/", a.User(videoServer.HandleHLS()))
+	// The "application/sdp" content type requires a CORS preflight,
+	// a CSRF token isn't required so that WHEP players can be used.
+	router.Handle("/whep/", a.User(videoServer.HandleWHEP()))
+	// Edge devices are authenticated by the push stream key of
+	// the monitor instead of a account, like RTMP publishers.
+	router.Handle("/api/push/", videoServer.HandlePush())
 
-	router.Handle("/api/system/time-zone", a.User(web.TimeZone(timeZone)))
+	api := web.NewAPI(router)
+	api.SetBasePath(env.BasePath)
+	router.Handle(web.APIPrefix+"/openapi.json", a.User(api.OpenAPIHandler()))
+
+	api.Handle("/api/system/time-zone", a.User(web.TimeZone(timeZone)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/system/time-zone",
+			Summary: "Time zone of the server.",
+		},
+	)
+	api.Handle("/api/system/disk", a.User(web.DiskHealth(diskMonitor.Health)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/system/disk",
+			Summary: "Storage disk health.",
+		},
+	)
+
+	api.Handle("/api/general", a.Admin(web.General(general)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/general",
+			Summary: "General settings.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/general/set", a.Admin(a.CSRF(web.GeneralSet(general))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/general/set",
+			Summary:  "Set the general settings.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
 
-	router.Handle("/api/general", a.Admin(web.General(general)))
-	router.Handle("/api/general/set", a.Admin(a.CSRF(web.GeneralSet(general))))
+	api.Handle("/api/config/check", a.Admin(web.ConfigCheck(func() []web.ConfigProblem {
+		return checkConfig(envPath, hooks.monitor())
+	})),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/config/check",
+			Summary: "Validate the config files without applying them.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/config/export", a.Admin(web.ConfigExport(env.ConfigDir)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/config/export",
+			Summary:  "Download all config files as a tar.gz archive.",
+			Admin:    true,
+			Response: "file",
+		},
+	)
+	api.Handle("/api/config/restore", a.Admin(a.CSRF(web.ConfigRestore(
+		func(backup *storage.ConfigBackup, force bool) (web.ConfigRestoreResult, error) {
+			reload := func() (web.ConfigReloadResult, error) {
+				return reloadConfigs(general, monitorManager)
+			}
+			return restoreConfig(envPath, hooks.monitor(), backup, force, reload)
+		},
+	))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/config/restore",
+			Summary: "Restore a config archive from the export endpoint.",
+			Admin:   true,
+			CSRF:    true,
+			Query:   []string{"force"},
+		},
+	)
+	api.Handle("/api/config/reload", a.Admin(a.CSRF(web.ConfigReload(
+		func() (web.ConfigReloadResult, error) {
+			return reloadConfigs(general, monitorManager)
+		},
+	))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/config/reload",
+			Summary: "Apply changes to the monitor and general config files.",
+			Admin:   true,
+			CSRF:    true,
+		},
+	)
 
-	router.Handle("/api/users", a.Admin(web.Users(a)))
-	router.Handle("/api/user/set", a.Admin(a.CSRF(web.UserSet(a))))
-	router.Handle("/api/user/delete", a.Admin(a.CSRF(web.UserDelete(a))))
-	router.Handle("/api/user/my-token", a.Admin(a.MyToken()))
+	api.Handle("/api/users", a.Admin(web.Users(a)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/users",
+			Summary: "List users.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/user/set", a.Admin(a.CSRF(web.UserSet(a))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/user/set",
+			Summary:  "Create or update a user.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/user/delete", a.Admin(a.CSRF(web.UserDelete(a))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/user/delete",
+			Summary:  "Delete a user.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/user/my-token", a.User(a.MyToken()),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/user/my-token",
+			Summary:  "CSRF token of the current user.",
+			Response: "text/plain",
+		},
+	)
+	api.Handle("/api/user/totp/enroll", a.User(a.CSRF(web.TOTPEnroll(a))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/user/totp/enroll",
+			Summary: "Start the two-factor enrollment of the current user.",
+			CSRF:    true,
+		},
+	)
+	api.Handle("/api/user/totp/confirm", a.User(a.CSRF(web.TOTPConfirm(a))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/user/totp/confirm",
+			Summary: "Enable two-factor authentication with a code from the enrollment.",
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/user/totp/disable", a.User(a.CSRF(web.TOTPDisable(a))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/user/totp/disable",
+			Summary:  "Disable two-factor authentication, admins can disable other users.",
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/tokens", a.Admin(web.Tokens(tokenStore)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/tokens",
+			Summary: "List API tokens.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/token/set", a.Admin(a.CSRF(web.TokenSet(tokenStore))),
+		web.Endpoint{
+			Method:  http.MethodPut,
+			Path:    "/token/set",
+			Summary: "Create a API token or update its name and scopes.",
+			Admin:   true,
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/token/delete", a.Admin(a.CSRF(web.TokenDelete(tokenStore))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/token/delete",
+			Summary:  "Revoke a API token.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/sessions", a.User(web.Sessions(a, sessionStore)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/sessions",
+			Summary: "List login sessions, admins can list all users or one with the user query.",
+			Query:   []string{"user"},
+		},
+	)
+	api.Handle("/api/session/revoke", a.User(a.CSRF(web.SessionRevoke(a, sessionStore))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/session/revoke",
+			Summary:  "Revoke a login session, admins can revoke sessions of other users.",
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
 	router.Handle("/logout", a.Logout())
+	if env.Profiling {
+		web.RegisterProfiling(router, a.Admin)
+	}
+	router.Handle("/healthz", web.Health(healthChecks.Live, a))
+	router.Handle("/readyz", web.Health(healthChecks.Ready, a))
+
+	api.Handle("/api/monitor/configs", a.Admin(web.MonitorConfigs(monitorManager)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/configs",
+			Summary: "Monitor configurations.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/monitor/delete", a.Admin(a.CSRF(web.MonitorDelete(monitorManager))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/monitor/delete",
+			Summary:  "Delete a monitor.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/monitor/list", a.User(web.MonitorList(monitorManager.MonitorsInfo)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/list",
+			Summary: "Monitors with their state.",
+		},
+	)
+	api.Handle("/api/monitor/restart", a.Admin(a.CSRF(web.MonitorRestart(monitorManager))),
+		web.Endpoint{
+			Method:   http.MethodPost,
+			Path:     "/monitor/restart",
+			Summary:  "Restart a monitor.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/monitor/set", a.Admin(a.CSRF(web.MonitorSet(monitorManager))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/monitor/set",
+			Summary:  "Create or update a monitor.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/onvif/discover", a.Admin(web.OnvifDiscover(onvif.Discover)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/onvif/discover",
+			Summary: "Discover ONVIF devices on the local network.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/onvif/probe", a.Admin(a.CSRF(web.OnvifProbe(onvif.ProbeDevice))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/onvif/probe",
+			Summary: "Probe the profiles of a ONVIF device.",
+			Admin:   true,
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/onvif/provision", a.Admin(a.CSRF(web.OnvifProvision(onvif.ProbeDevice, monitorManager))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/onvif/provision",
+			Summary: "Create a monitor from a ONVIF profile.",
+			Admin:   true,
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/monitor/stats", a.User(web.MonitorStats(videoServer.PathStats)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/stats",
+			Summary: "Stream statistics of a monitor.",
+			Query:   []string{"id"},
+		},
+	)
+	api.Handle("/api/monitor/talk", a.User(web.MonitorTalk(monitorManager.BackchannelURL, dialBackchannel)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/monitor/talk",
+			Summary:  "Push-to-talk websocket.",
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/monitor/", a.User(web.MonitorPaths(map[string]http.Handler{
+		"mse":          videoServer.HandleMSE(),
+		"timeline":     web.MonitorTimeline(index.Query, index.QueryStatic, crawler.RecordingsInRange, logger),
+		"thumbnail":    web.MonitorThumbnail(thumbnailer.Thumbnail, logger),
+		"snapshot.jpg": web.MonitorSnapshot(monitorManager.Snapshot, logger),
+		"stats":        web.MonitorStatsHistory(videoServer.PathStatsHistory),
+		"viewers":      a.Admin(web.MonitorViewers(videoServer.PathViewerStats)),
+	})),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/monitor/{id}/mse",
+			Summary:  "Live stream websocket for Media Source Extensions.",
+			Response: "none",
+		},
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/{id}/timeline",
+			Summary: "Recording coverage and events of a monitor.",
+			Query:   []string{"start", "end"},
+		},
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/monitor/{id}/thumbnail",
+			Summary:  "Continuous recording keyframe near a time.",
+			Query:    []string{"time", "width"},
+			Response: "image/jpeg",
+		},
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/monitor/{id}/snapshot.jpg",
+			Summary:  "Current frame of a monitor.",
+			Response: "image/jpeg",
+		},
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/{id}/stats",
+			Summary: "Stream statistics of a monitor from the last hour.",
+		},
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/monitor/{id}/viewers",
+			Summary: "Playback statistics reported by the HLS viewers of a monitor.",
+			Admin:   true,
+		},
+	)
+
+	api.Handle("/api/arming", a.User(web.Arming(armingManager)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/arming",
+			Summary: "Arming state.",
+		},
+	)
+	api.Handle("/api/arming/set", a.Admin(a.CSRF(web.ArmingSet(armingManager))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/arming/set",
+			Summary:  "Set the global or monitor arming mode.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/arming/schedule", a.Admin(a.CSRF(web.ArmingSchedule(armingManager))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/arming/schedule",
+			Summary:  "Replace the arming schedule.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
+
+	api.Handle("/api/group/configs", a.User(web.GroupConfigs(groupManager)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/group/configs",
+			Summary: "Monitor group configurations.",
+		},
+	)
+	api.Handle("/api/group/set", a.Admin(a.CSRF(web.GroupSet(groupManager))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/group/set",
+			Summary:  "Create or update a group.",
+			Admin:    true,
+			CSRF:     true,
+			Body:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/group/delete", a.Admin(a.CSRF(web.GroupDelete(groupManager))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/group/delete",
+			Summary:  "Delete a group.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/group/monitors", a.Admin(a.CSRF(web.GroupMonitors(groupManager))),
+		web.Endpoint{
+			Method:  http.MethodPost,
+			Path:    "/group/monitors",
+			Summary: "Add or remove monitors from a group.",
+			Admin:   true,
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/group/layout", a.Admin(a.CSRF(web.GroupLayout(groupManager))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/group/layout",
+			Summary:  "Set the view layout of a group.",
+			Admin:    true,
+			CSRF:     true,
+			Query:    []string{"id"},
+			Body:     true,
+			Response: "none",
+		},
+	)
 
-	router.Handle("/api/monitor/configs", a.Admin(web.MonitorConfigs(monitorManager)))
-	router.Handle("/api/monitor/delete", a.Admin(a.CSRF(web.MonitorDelete(monitorManager))))
-	router.Handle("/api/monitor/list", a.User(web.MonitorList(monitorManager.MonitorsInfo)))
-	router.Handle("/api/monitor/restart", a.Admin(a.CSRF(web.MonitorRestart(monitorManager))))
-	router.Handle("/api/monitor/set", a.Admin(a.CSRF(web.MonitorSet(monitorManager))))
+	api.Handle("/api/recording/delete/", a.Admin(a.CSRF(web.RecordingDelete(env.RecordingsDirs()...))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/recording/delete/{id}",
+			Summary:  "Delete a recording.",
+			Admin:    true,
+			CSRF:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/recording/flag/", a.Admin(a.CSRF(web.RecordingFlag(env.RecordingsDirs()...))),
+		web.Endpoint{
+			Method:   http.MethodPut,
+			Path:     "/recording/flag/{id}",
+			Summary:  "Flag a recording, exempting it from retention.",
+			Admin:    true,
+			CSRF:     true,
+			Response: "none",
+		},
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/recording/flag/{id}",
+			Summary:  "Unflag a recording.",
+			Admin:    true,
+			CSRF:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/recording/thumbnail/", a.User(web.RecordingThumbnail(env.RecordingsDirs()...)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/recording/thumbnail/{id}",
+			Summary:  "Thumbnail of a recording.",
+			Response: "image/jpeg",
+		},
+	)
+	api.Handle("/api/recording/video/", a.User(web.RecordingVideo(logger, env.Crypt, env.RecordingsDirs()...)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/recording/video/{id}",
+			Summary:  "Video of a recording.",
+			Response: "video/mp4",
+		},
+		web.Endpoint{
+			Method:   http.MethodHead,
+			Path:     "/recording/video/{id}",
+			Summary:  "Size and ETag of the video of a recording.",
+			Response: "none",
+		},
+	)
+	api.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, logger)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/recording/query",
+			Summary: "Query recordings.",
+			Query:   []string{"limit", "time", "reverse", "monitors", "data"},
+		},
+	)
+	api.Handle("/api/recording/export", a.User(web.RecordingExport(index.Query, env.SegmentsDirs(), env.Crypt, prefetcher, logger)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/recording/export",
+			Summary:  "Export continuous recording as a MP4 file.",
+			Query:    []string{"monitor", "start", "end"},
+			Response: "video/mp4",
+		},
+	)
+	api.Handle("/api/recording/scan", a.User(web.RecordingScan(index.Query, env.SegmentsDirs(), env.Crypt, logger)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/recording/scan",
+			Summary:  "Fast-forward scan of continuous recording.",
+			Query:    []string{"monitor", "start", "end", "speed"},
+			Response: "video/mp4",
+		},
+	)
+	api.Handle("/api/recording/verify/", a.User(web.RecordingVerify(ledger, env.Crypt, env.RecordingsDirs()...)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/recording/verify/{id}",
+			Summary: "Verify the integrity of a recording.",
+		},
+	)
+	api.Handle("/api/segment/verify", a.User(web.SegmentVerify(ledger, index, env.Crypt, env.SegmentsDirs())),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/segment/verify",
+			Summary: "Verify the integrity of a continuous segment.",
+			Query:   []string{"monitor", "path"},
+		},
+	)
+	api.Handle("/api/integrity/key", a.User(web.IntegrityKey(ledger)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/integrity/key",
+			Summary: "Public key of the integrity ledger.",
+		},
+	)
 
-	router.Handle("/api/group/configs", a.User(web.GroupConfigs(groupManager)))
-	router.Handle("/api/group/set", a.Admin(a.CSRF(web.GroupSet(groupManager))))
-	router.Handle("/api/group/delete", a.Admin(a.CSRF(web.GroupDelete(groupManager))))
+	api.Handle("/api/events", a.User(web.Events(events, time.Local)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/events",
+			Summary: "Query or aggregate events.",
+			Query:   []string{"monitors", "labels", "minScore", "start", "end", "limit", "offset", "order", "aggregate"},
+		},
+	)
+	api.Handle("/api/events/stream", a.User(web.EventStream(liveFeed, a.ValidateRequest)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/events/stream",
+			Summary:  "Live event stream, websocket or server-sent events.",
+			Query:    []string{"types", "monitors"},
+			Response: "text/event-stream",
+		},
+	)
 
-	router.Handle("/api/recording/delete/", a.Admin(a.CSRF(web.RecordingDelete(env.RecordingsDir()))))
-	router.Handle("/api/recording/thumbnail/", a.User(web.RecordingThumbnail(env.RecordingsDir())))
-	router.Handle("/api/recording/video/", a.User(web.RecordingVideo(logger, env.RecordingsDir())))
-	router.Handle("/api/recording/query", a.User(web.RecordingQuery(crawler, logger)))
+	api.Handle("/api/bookmarks", a.User(web.Bookmarks(bookmarks)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/bookmarks",
+			Summary: "Search bookmarks.",
+			Query:   []string{"monitors", "tags", "q", "start", "end", "limit", "offset", "order"},
+		},
+	)
+	api.Handle("/api/bookmark/set", a.User(a.CSRF(web.BookmarkSet(bookmarks, a))),
+		web.Endpoint{
+			Method:  http.MethodPut,
+			Path:    "/bookmark/set",
+			Summary: "Create or update a bookmark.",
+			CSRF:    true,
+			Body:    true,
+		},
+	)
+	api.Handle("/api/bookmark/delete", a.User(a.CSRF(web.BookmarkDelete(bookmarks, a))),
+		web.Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/bookmark/delete",
+			Summary:  "Delete a bookmark.",
+			CSRF:     true,
+			Query:    []string{"id"},
+			Response: "none",
+		},
+	)
 
-	router.Handle("/api/log/feed", a.Admin(web.LogFeed(logger, a)))
-	router.Handle("/api/log/query", a.Admin(web.LogQuery(logStore)))
-	router.Handle("/api/log/sources", a.Admin(web.LogSources(logger)))
+	api.Handle("/api/log/feed", a.Admin(web.LogFeed(logger, a)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/log/feed",
+			Summary:  "Live log websocket.",
+			Admin:    true,
+			Query:    []string{"levels", "sources", "monitors"},
+			Response: "none",
+		},
+	)
+	api.Handle("/api/log/query", a.Admin(web.LogQuery(logStore)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/log/query",
+			Summary: "Query logs.",
+			Admin:   true,
+			Query:   []string{"levels", "sources", "monitors", "time", "limit"},
+		},
+	)
+	api.Handle("/api/audit", a.Admin(web.AuditQuery(auditLog)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/audit",
+			Summary: "Query the audit log of logins, lockouts and state changing API calls.",
+			Admin:   true,
+			Query:   []string{"types", "username", "start", "end", "limit"},
+		},
+	)
+	api.Handle("/api/log/sources", a.Admin(web.LogSources(logger)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/log/sources",
+			Summary: "Log sources.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/log/tail", a.Admin(web.LogTail(logStore)),
+		web.Endpoint{
+			Method:   http.MethodGet,
+			Path:     "/log/tail",
+			Summary:  "Most recent logs.",
+			Admin:    true,
+			Query:    []string{"monitors", "sources", "levels", "limit", "format"},
+			Response: "text/plain",
+		},
+	)
+	api.Handle("/api/log/levels", a.Admin(web.LogLevels(logger)),
+		web.Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/log/levels",
+			Summary: "Maximum level of each log source.",
+			Admin:   true,
+		},
+	)
+	api.Handle("/api/log/levels/set", a.Admin(a.CSRF(web.LogLevelsSet(logger))),
+		web.Endpoint{
+			Method:  http.MethodPut,
+			Path:    "/log/levels/set",
+			Summary: "Set the maximum level of log sources.",
+			Admin:   true,
+			CSRF:    true,
+			Body:    true,
+		},
+	)
 
 	return &App{
 		WG:             wg,
 		Logger:         logger,
 		logStore:       logStore,
+		logFile:        logFile,
 		Env:            *env,
 		monitorManager: monitorManager,
+		general:        general,
+		Arming:         armingManager,
+		Feed:           liveFeed,
 		Auth:           a,
 		Storage:        storageManager,
+		DiskMonitor:    diskMonitor,
+		Health:         healthChecks,
+		Index:          index,
+		Events:         events,
 		videoServer:    videoServer,
 		Templater:      t,
 		Router:         router,
+		API:            api,
 	}, nil
 }
 
+// eventData event stream data of monitor events.
+type eventData struct {
+	storage.Event
+	Action arming.Action `json:"action"`
+}
+
+// recordingData event stream data of saved recordings.
+type recordingData struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// monitorStateData event stream data of input state changes.
+type monitorStateData struct {
+	monitor.InputHealth
+	SubInput bool `json:"subInput"`
+}
+
+// failoverData event stream data of input source switches.
+type failoverData struct {
+	monitor.InputSource
+	SubInput bool `json:"subInput"`
+}
+
+// feedHooks publishes events, saved recordings and
+// input state and source changes to the feed before the addon hooks.
+func feedHooks(hooks *monitor.Hooks, f *feed.Feed) *monitor.Hooks {
+	eventHook := hooks.Event
+	hooks.Event = func(r *monitor.Recorder, event *storage.Event) {
+		// Continuous recording triggers.
+		if len(event.Detections) != 0 {
+			f.Publish(feed.Message{
+				Type:      feed.TypeEvent,
+				Time:      event.Time,
+				MonitorID: r.Config.ID(),
+				Data:      eventData{Event: *event, Action: r.ArmAction()},
+			})
+		}
+		eventHook(r, event)
+	}
+
+	recSavedHook := hooks.RecSaved
+	hooks.RecSaved = func(r *monitor.Recorder, recPath string, recData storage.RecordingData) {
+		f.Publish(feed.Message{
+			Type:      feed.TypeRecording,
+			MonitorID: r.Config.ID(),
+			Data: recordingData{
+				ID:    filepath.Base(recPath),
+				Start: recData.Start,
+				End:   recData.End,
+			},
+		})
+		recSavedHook(r, recPath, recData)
+	}
+
+	inputStateHook := hooks.InputState
+	hooks.InputState = func(i *monitor.InputProcess, health monitor.InputHealth) {
+		f.Publish(feed.Message{
+			Type:      feed.TypeMonitorState,
+			MonitorID: i.Config.ID(),
+			Data:      monitorStateData{InputHealth: health, SubInput: i.IsSubInput()},
+		})
+		inputStateHook(i, health)
+	}
+
+	inputSourceHook := hooks.InputSource
+	hooks.InputSource = func(i *monitor.InputProcess, source monitor.InputSource) {
+		f.Publish(feed.Message{
+			Type:      feed.TypeFailover,
+			MonitorID: i.Config.ID(),
+			Data:      failoverData{InputSource: source, SubInput: i.IsSubInput()},
+		})
+		inputSourceHook(i, source)
+	}
+	return hooks
+}
+
 func (app *App) run(ctx context.Context) error {
 	// Main server.
+	handler, err := web.NewProxy(app.Router, app.Env.BasePath, app.Env.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trustedProxies: %w", err)
+	}
 	address := ":" + strconv.Itoa(app.Env.Port)
-	app.server = &http.Server{Addr: address, Handler: app.Router}
+	app.server = &http.Server{
+		Addr:        address,
+		Handler:     handler,
+		ConnContext: video.ConnContext,
+	}
 
 	if err := app.Logger.Start(ctx); err != nil {
 		return fmt.Errorf("could not start logger: %w", err)
 	}
 
-	app.Logger.LogToWriter(ctx, os.Stdout)
+	logFormat := log.Format(app.Env.LogFormat)
+	app.Logger.LogToWriterFormat(ctx, os.Stdout, logFormat)
+	if app.logFile != nil {
+		app.Logger.LogToWriterFormat(ctx, app.logFile, logFormat)
+	}
 	app.logStore.SaveLogs(ctx, app.Logger)
 	app.logStore.PurgeLoop(ctx, app.Logger)
+	app.Feed.PublishNotices(ctx, app.WG, app.Logger)
 	time.Sleep(10 * time.Millisecond)
 
+	if app.Env.TLSPort != 0 {
+		if err := app.newTLSServer(ctx, handler); err != nil {
+			return fmt.Errorf("could not create https server: %w", err)
+		}
+	}
+
 	if err := hooks.appRun(ctx, app); err != nil {
 		return err
 	}
 
 	app.logf(log.LevelInfo, "Starting..")
+	if app.Env.Profiling {
+		app.logf(log.LevelWarning, "profiling is enabled, this has a small performance cost")
+	}
+	if n := app.Index.Dropped(); n != 0 {
+		app.logf(log.LevelWarning, "dropped %v corrupt segment index records", n)
+	}
+	if n := app.Events.Dropped(); n != 0 {
+		app.logf(log.LevelWarning, "dropped %v corrupt event store records", n)
+	}
 
 	if err := app.Env.PrepareEnvironment(); err != nil {
 		return fmt.Errorf("could not prepare environment: %w", err)
 	}
 
+	// Must run before the monitors start writing new files.
+	if err := app.Storage.RecoverRecordings(app.Env.Crypt); err != nil {
+		app.logf(log.LevelError, "could not recover recordings: %v", err)
+	}
+
 	if err := app.videoServer.Start(ctx); err != nil {
 		return fmt.Errorf("could not start video server: %w", err)
 	}
@@ -291,11 +1257,145 @@ func (app *App) run(ctx context.Context) error {
 	app.monitorManager.StartMonitors()
 
 	go app.Storage.PurgeLoop(ctx, 10*time.Minute)
+	go app.watchConfigs(ctx)
+	go app.DiskMonitor.Run(ctx, time.Minute)
+	app.Arming.Run(ctx, app.WG)
+	app.Health.SetReady()
+
+	if app.tlsServer != nil {
+		go func() {
+			app.logf(log.LevelInfo, "Serving app over HTTPS on port %v", app.Env.TLSPort)
+			err := app.tlsServer.ListenAndServeTLS("", "")
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				app.logf(log.LevelError, "https server: %v", err)
+			}
+		}()
+	}
 
 	app.logf(log.LevelInfo, "Serving app on port %v", app.Env.Port)
 	return app.server.ListenAndServe()
 }
 
+// newTLSServer creates the HTTPS server. The main server
+// answers the ACME HTTP-01 challenges if they're used.
+func (app *App) newTLSServer(ctx context.Context, handler http.Handler) error {
+	certManager, err := certs.NewManager(certs.Config{
+		CertFile:  app.Env.TLSCertFile,
+		KeyFile:   app.Env.TLSKeyFile,
+		Domains:   app.Env.ACMEDomains,
+		Email:     app.Env.ACMEEmail,
+		Directory: app.Env.ACMEDirectory,
+		DNSHook:   app.Env.ACMEDNSHook,
+		CacheDir:  app.Env.CertsDir(),
+	}, app.Logger)
+	if err != nil {
+		return err
+	}
+	app.server.Handler = certManager.HTTPHandler(handler)
+	app.tlsServer = &http.Server{
+		Addr:        ":" + strconv.Itoa(app.Env.TLSPort),
+		Handler:     handler,
+		TLSConfig:   certManager.TLSConfig(),
+		ConnContext: video.ConnContext,
+	}
+
+	app.WG.Add(1)
+	go func() {
+		certManager.Run(ctx)
+		app.WG.Done()
+	}()
+	return nil
+}
+
+// ReloadConfigs applies changes to the general and monitor config files.
+// Only the monitors with changed configs are restarted.
+// applyLogLevels sets the maximum level of the log sources by name.
+func applyLogLevels(logger *log.Logger, levels map[string]string) error {
+	for src, name := range levels {
+		level, err := log.ParseLevel(name)
+		if err == nil {
+			err = logger.SetLevel(src, level)
+		}
+		if err != nil {
+			return fmt.Errorf("logLevels: %w", err)
+		}
+	}
+	return nil
+}
+
+func (app *App) ReloadConfigs() (web.ConfigReloadResult, error) {
+	return reloadConfigs(app.general, app.monitorManager)
+}
+
+func reloadConfigs(
+	general *storage.ConfigGeneral,
+	monitorManager *monitor.Manager,
+) (web.ConfigReloadResult, error) {
+	generalChanged, err := general.Reload()
+	if err != nil {
+		return web.ConfigReloadResult{}, fmt.Errorf("general: %w", err)
+	}
+	monitors, err := monitorManager.Reload()
+	if err != nil {
+		return web.ConfigReloadResult{}, fmt.Errorf("monitors: %w", err)
+	}
+	return web.ConfigReloadResult{General: generalChanged, Monitors: monitors}, nil
+}
+
+// watchConfigs reloads the configs when the files are edited by hand.
+// Changes made through the API are already applied and won't restart anything.
+func (app *App) watchConfigs(ctx context.Context) {
+	paths := []string{
+		filepath.Join(app.Env.ConfigDir, "general.json"),
+		filepath.Join(app.Env.ConfigDir, "monitors"),
+	}
+	storage.WatchFiles(ctx, 2*time.Second, paths, func() {
+		result, err := app.ReloadConfigs()
+		if err != nil {
+			app.logf(log.LevelError, "could not reload configs: %v", err)
+			return
+		}
+		if result.General {
+			app.logf(log.LevelInfo, "reloaded general config")
+		}
+		m := result.Monitors
+		if !m.Empty() {
+			app.logf(log.LevelInfo, "reloaded monitor configs: added %v, changed %v, removed %v",
+				m.Added, m.Changed, m.Removed)
+		}
+	})
+}
+
+// MonitorConfigs returns the raw configurations of all monitors.
+func (app *App) MonitorConfigs() monitor.RawConfigs {
+	return app.monitorManager.MonitorConfigs()
+}
+
+// MonitorsInfo returns common information and the input state of the monitors.
+func (app *App) MonitorsInfo() monitor.RawConfigs {
+	return app.monitorManager.MonitorsInfo()
+}
+
+// MonitorEnable enables or disables a monitor and restarts it.
+func (app *App) MonitorEnable(id string, enable bool) error {
+	return app.monitorManager.MonitorEnable(id, enable)
+}
+
+// RestartMonitor restarts a monitor.
+func (app *App) RestartMonitor(id string) error {
+	return app.monitorManager.RestartMonitor(id)
+}
+
+// TriggerEvent sends a event to a running monitor.
+func (app *App) TriggerEvent(id string, event storage.Event) error {
+	return app.monitorManager.TriggerEvent(id, event)
+}
+
+// Snapshot returns the latest keyframe of a running monitor as a jpeg.
+func (app *App) Snapshot(ctx context.Context, id string) ([]byte, error) {
+	return app.monitorManager.Snapshot(ctx, id)
+}
+
 func (app *App) logf(level log.Level, format string, a ...interface{}) {
 	app.Logger.Log(log.Entry{
 		Level: level,
@@ -303,3 +1403,11 @@ func (app *App) logf(level log.Level, format string, a ...interface{}) {
 		Msg:   fmt.Sprintf(format, a...),
 	})
 }
+
+func dialBackchannel(ctx context.Context, url string) (web.Backchannel, error) {
+	b, err := onvif.DialBackchannel(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,382 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package arming global and per-monitor arming modes.
+package arming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"nvr/pkg/schedule"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mode arming mode.
+type Mode string
+
+// Arming modes.
+const (
+	Disarmed Mode = "disarmed"
+	Home     Mode = "home"
+	Away     Mode = "away"
+	Night    Mode = "night"
+)
+
+// Modes all arming modes.
+var Modes = []Mode{Disarmed, Home, Away, Night}
+
+// ErrInvalidMode invalid arming mode.
+var ErrInvalidMode = errors.New("invalid arming mode")
+
+// ParseMode returns ErrInvalidMode if the mode doesn't exist.
+func ParseMode(raw string) (Mode, error) {
+	for _, mode := range Modes {
+		if string(mode) == raw {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidMode, raw)
+}
+
+// Action what a monitor does with detections in a mode.
+type Action string
+
+// Actions.
+const (
+	// ActionIgnore detections don't trigger recordings or notifications.
+	ActionIgnore Action = "ignore"
+
+	// ActionRecord detections trigger recordings without notifications.
+	ActionRecord Action = "record"
+
+	// ActionAlert detections trigger recordings and notifications.
+	ActionAlert Action = "alert"
+)
+
+// ParseAction returns the default action of the mode if raw is empty or invalid.
+func ParseAction(raw string, mode Mode) Action {
+	switch Action(raw) {
+	case ActionIgnore, ActionRecord, ActionAlert:
+		return Action(raw)
+	}
+	return DefaultAction(mode)
+}
+
+// DefaultAction monitors alert when the NVR is armed away or for the
+// night and only record when someone is home or the NVR is disarmed.
+func DefaultAction(mode Mode) Action {
+	switch mode {
+	case Away, Night:
+		return ActionAlert
+	}
+	return ActionRecord
+}
+
+// Switch changes the global mode at the time on the selected days.
+//
+//	{"days": ["mon", "tue", "wed", "thu", "fri"], "time": "22:00", "mode": "night"}
+type Switch struct {
+	// Empty is every day.
+	Days []string `json:"days"`
+
+	// Local time, "15:04".
+	Time string `json:"time"`
+	Mode Mode   `json:"mode"`
+}
+
+// ErrInvalidTime invalid switch time.
+var ErrInvalidTime = errors.New("invalid time")
+
+// Validate checks the days, time and mode.
+func (s Switch) Validate() error {
+	for _, day := range s.Days {
+		if _, err := schedule.ParseWeekday(day); err != nil {
+			return err
+		}
+	}
+	if _, err := time.Parse("15:04", s.Time); err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidTime, s.Time)
+	}
+	if _, err := ParseMode(string(s.Mode)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// due returns true if the switch is at the minute of t.
+func (s Switch) due(t time.Time) bool {
+	if t.Format("15:04") != s.Time {
+		return false
+	}
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, day := range s.Days {
+		if weekday, _ := schedule.ParseWeekday(day); weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// State arming state.
+type State struct {
+	// Global mode.
+	Mode Mode `json:"mode"`
+
+	// Per-monitor modes by monitor ID, they override the global mode.
+	Monitors map[string]Mode `json:"monitors"`
+
+	Schedule []Switch `json:"schedule"`
+}
+
+func (s State) copy() State {
+	monitors := make(map[string]Mode, len(s.Monitors))
+	for id, mode := range s.Monitors {
+		monitors[id] = mode
+	}
+	return State{
+		Mode:     s.Mode,
+		Monitors: monitors,
+		Schedule: append([]Switch{}, s.Schedule...),
+	}
+}
+
+// MonitorMode returns the override of the monitor or the global mode.
+func (s State) MonitorMode(id string) Mode {
+	if mode, exist := s.Monitors[id]; exist {
+		return mode
+	}
+	return s.Mode
+}
+
+// Validate checks the modes and the schedule.
+func (s State) Validate() error {
+	if _, err := ParseMode(string(s.Mode)); err != nil {
+		return err
+	}
+	for id, mode := range s.Monitors {
+		if _, err := ParseMode(string(mode)); err != nil {
+			return fmt.Errorf("monitor %v: %w", id, err)
+		}
+	}
+	for _, s := range s.Schedule {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// Manager persists the arming state and runs the schedule.
+// A nil manager is always armed away.
+type Manager struct {
+	path        string
+	state       State
+	subscribers []func(State)
+	logf        log.Func
+
+	mu sync.Mutex
+}
+
+// NewManager reads the state from the file at path. The
+// file is created with the away mode if it doesn't exist.
+func NewManager(path string, logger log.ILogger) (*Manager, error) {
+	logf := func(level log.Level, format string, a ...interface{}) {
+		logger.Log(log.Entry{
+			Level: level,
+			Src:   "arming",
+			Msg:   fmt.Sprintf(format, a...),
+		})
+	}
+	m := &Manager{
+		path: path,
+		state: State{
+			Mode:     Away,
+			Monitors: make(map[string]Mode),
+			Schedule: []Switch{},
+		},
+		logf: logf,
+	}
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := m.save(m.state); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read arming state: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(file, &state); err != nil {
+		return nil, fmt.Errorf("unmarshal arming state: %w", err)
+	}
+	if err := state.Validate(); err != nil {
+		return nil, fmt.Errorf("arming state: %w", err)
+	}
+	m.state = state.copy()
+	return m, nil
+}
+
+func (m *Manager) save(state State) error {
+	raw, _ := json.MarshalIndent(state, "", "    ")
+	if err := os.WriteFile(m.path, raw, 0o600); err != nil {
+		return fmt.Errorf("write arming state: %w", err)
+	}
+	return nil
+}
+
+// State returns a copy of the state.
+func (m *Manager) State() State {
+	if m == nil {
+		return State{Mode: Away, Monitors: map[string]Mode{}, Schedule: []Switch{}}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.copy()
+}
+
+// Mode returns the global mode.
+func (m *Manager) Mode() Mode {
+	return m.State().Mode
+}
+
+// MonitorMode returns the mode of the monitor, the
+// global mode is used if the monitor isn't overridden.
+func (m *Manager) MonitorMode(id string) Mode {
+	if m == nil {
+		return Away
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.MonitorMode(id)
+}
+
+// SetMode sets the global mode, source is logged.
+func (m *Manager) SetMode(mode Mode, source string) error {
+	return m.update(source, func(s *State) error {
+		if _, err := ParseMode(string(mode)); err != nil {
+			return err
+		}
+		s.Mode = mode
+		return nil
+	})
+}
+
+// SetMonitorMode overrides the global mode for the
+// monitor, a empty mode removes the override.
+func (m *Manager) SetMonitorMode(id string, mode Mode, source string) error {
+	return m.update(source, func(s *State) error {
+		if mode == "" {
+			delete(s.Monitors, id)
+			return nil
+		}
+		if _, err := ParseMode(string(mode)); err != nil {
+			return err
+		}
+		s.Monitors[id] = mode
+		return nil
+	})
+}
+
+// SetSchedule replaces the schedule.
+func (m *Manager) SetSchedule(switches []Switch, source string) error {
+	return m.update(source, func(s *State) error {
+		for _, sw := range switches {
+			if err := sw.Validate(); err != nil {
+				return fmt.Errorf("schedule: %w", err)
+			}
+		}
+		s.Schedule = append([]Switch{}, switches...)
+		return nil
+	})
+}
+
+func (m *Manager) update(source string, fn func(*State) error) error {
+	m.mu.Lock()
+	state := m.state.copy()
+	if err := fn(&state); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := m.save(state); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.state = state
+	subscribers := m.subscribers
+	m.mu.Unlock()
+
+	m.logf(log.LevelInfo, "%v: mode: %v monitors: %v", source, state.Mode, state.Monitors)
+	for _, fn := range subscribers {
+		fn(state.copy())
+	}
+	return nil
+}
+
+// Subscribe calls fn with the new state after each change.
+// fn is called synchronously and must not block.
+func (m *Manager) Subscribe(fn func(State)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Run applies the schedule until the context is canceled.
+func (m *Manager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var prev string
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				// Each minute is only checked once.
+				if minute := now.Format("15:04"); minute != prev {
+					prev = minute
+					m.applySchedule(now)
+				}
+			}
+		}
+	}()
+}
+
+// applySchedule switches to the mode of the last due switch.
+func (m *Manager) applySchedule(now time.Time) {
+	var mode Mode
+	for _, s := range m.State().Schedule {
+		if s.due(now) {
+			mode = s.Mode
+		}
+	}
+	if mode == "" || mode == m.Mode() {
+		return
+	}
+	if err := m.SetMode(mode, "schedule"); err != nil {
+		m.logf(log.LevelError, "schedule: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package arming
+
+import (
+	"encoding/json"
+	"nvr/pkg/log"
+	"nvr/pkg/schedule"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(filepath.Join(t.TempDir(), "arming.json"), log.NewDummyLogger())
+	require.NoError(t, err)
+	return m
+}
+
+func readState(t *testing.T, m *Manager) State {
+	t.Helper()
+	raw, err := os.ReadFile(m.path)
+	require.NoError(t, err)
+	var state State
+	require.NoError(t, json.Unmarshal(raw, &state))
+	return state
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("night")
+	require.NoError(t, err)
+	require.Equal(t, Night, mode)
+
+	_, err = ParseMode("x")
+	require.ErrorIs(t, err, ErrInvalidMode)
+}
+
+func TestParseAction(t *testing.T) {
+	cases := map[string]struct {
+		raw      string
+		mode     Mode
+		expected Action
+	}{
+		"ignore":   {"ignore", Away, ActionIgnore},
+		"record":   {"record", Night, ActionRecord},
+		"disarmed": {"", Disarmed, ActionRecord},
+		"home":     {"", Home, ActionRecord},
+		"away":     {"", Away, ActionAlert},
+		"night":    {"", Night, ActionAlert},
+		"invalid":  {"x", Home, ActionRecord},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, ParseAction(tc.raw, tc.mode))
+		})
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		cases := map[string]struct {
+			input Switch
+			err   error
+		}{
+			"ok":      {Switch{Days: []string{"Mon"}, Time: "22:00", Mode: Night}, nil},
+			"noDays":  {Switch{Time: "08:00", Mode: Away}, nil},
+			"day":     {Switch{Days: []string{"x"}, Time: "08:00", Mode: Away}, schedule.ErrInvalidDay},
+			"time":    {Switch{Time: "25:00", Mode: Away}, ErrInvalidTime},
+			"mode":    {Switch{Time: "08:00", Mode: "x"}, ErrInvalidMode},
+			"noMode":  {Switch{Time: "08:00"}, ErrInvalidMode},
+			"noTime":  {Switch{Mode: Away}, ErrInvalidTime},
+			"seconds": {Switch{Time: "08:00:00", Mode: Away}, ErrInvalidTime},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				require.ErrorIs(t, tc.input.Validate(), tc.err)
+			})
+		}
+	})
+	t.Run("due", func(t *testing.T) {
+		// Monday.
+		now := time.Date(2025, 12, 29, 22, 0, 30, 0, time.Local)
+		require.True(t, Switch{Time: "22:00"}.due(now))
+		require.True(t, Switch{Days: []string{"sun", "mon"}, Time: "22:00"}.due(now))
+		require.False(t, Switch{Days: []string{"tue"}, Time: "22:00"}.due(now))
+		require.False(t, Switch{Time: "22:01"}.due(now))
+	})
+}
+
+func TestNewManager(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		m := newTestManager(t)
+		require.Equal(t, Away, m.Mode())
+		require.Equal(t, Away, readState(t, m).Mode)
+	})
+	t.Run("read", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "arming.json")
+		raw := `{"mode":"home","monitors":{"a":"disarmed"},"schedule":[]}`
+		require.NoError(t, os.WriteFile(path, []byte(raw), 0o600))
+
+		m, err := NewManager(path, log.NewDummyLogger())
+		require.NoError(t, err)
+		require.Equal(t, Home, m.Mode())
+		require.Equal(t, Disarmed, m.MonitorMode("a"))
+		require.Equal(t, Home, m.MonitorMode("b"))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "arming.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"mode":"x"}`), 0o600))
+
+		_, err := NewManager(path, log.NewDummyLogger())
+		require.ErrorIs(t, err, ErrInvalidMode)
+	})
+	t.Run("nil", func(t *testing.T) {
+		var m *Manager
+		require.Equal(t, Away, m.Mode())
+		require.Equal(t, Away, m.MonitorMode("a"))
+	})
+}
+
+func TestManager(t *testing.T) {
+	t.Run("setMode", func(t *testing.T) {
+		m := newTestManager(t)
+		var states []State
+		m.Subscribe(func(s State) { states = append(states, s) })
+
+		require.NoError(t, m.SetMode(Night, "test"))
+		require.Equal(t, Night, m.Mode())
+		require.Equal(t, Night, readState(t, m).Mode)
+		require.Len(t, states, 1)
+		require.Equal(t, Night, states[0].Mode)
+
+		require.ErrorIs(t, m.SetMode("x", "test"), ErrInvalidMode)
+		require.Equal(t, Night, m.Mode())
+		require.Len(t, states, 1)
+	})
+	t.Run("setMonitorMode", func(t *testing.T) {
+		m := newTestManager(t)
+		require.NoError(t, m.SetMonitorMode("a", Disarmed, "test"))
+		require.Equal(t, Disarmed, m.MonitorMode("a"))
+		require.Equal(t, map[string]Mode{"a": Disarmed}, readState(t, m).Monitors)
+
+		require.NoError(t, m.SetMonitorMode("a", "", "test"))
+		require.Equal(t, Away, m.MonitorMode("a"))
+		require.Empty(t, readState(t, m).Monitors)
+
+		require.ErrorIs(t, m.SetMonitorMode("a", "x", "test"), ErrInvalidMode)
+	})
+	t.Run("setSchedule", func(t *testing.T) {
+		m := newTestManager(t)
+		switches := []Switch{{Time: "08:00", Mode: Home}}
+		require.NoError(t, m.SetSchedule(switches, "test"))
+		require.Equal(t, switches, readState(t, m).Schedule)
+
+		err := m.SetSchedule([]Switch{{Time: "x", Mode: Home}}, "test")
+		require.ErrorIs(t, err, ErrInvalidTime)
+		require.Equal(t, switches, m.State().Schedule)
+	})
+	t.Run("stateCopy", func(t *testing.T) {
+		m := newTestManager(t)
+		state := m.State()
+		state.Monitors["a"] = Home
+		require.Equal(t, Away, m.MonitorMode("a"))
+	})
+}
+
+func TestApplySchedule(t *testing.T) {
+	m := newTestManager(t)
+	switches := []Switch{
+		{Time: "08:00", Mode: Home},
+		{Days: []string{"mon"}, Time: "08:00", Mode: Night},
+	}
+	require.NoError(t, m.SetSchedule(switches, "test"))
+
+	// Monday, the last due switch wins.
+	m.applySchedule(time.Date(2025, 12, 29, 8, 0, 0, 0, time.Local))
+	require.Equal(t, Night, m.Mode())
+
+	// Tuesday.
+	m.applySchedule(time.Date(2025, 12, 30, 8, 0, 0, 0, time.Local))
+	require.Equal(t, Home, m.Mode())
+
+	require.NoError(t, m.SetMode(Away, "test"))
+	m.applySchedule(time.Date(2025, 12, 30, 9, 0, 0, 0, time.Local))
+	require.Equal(t, Away, m.Mode())
+}
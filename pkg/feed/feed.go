@@ -0,0 +1,158 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package feed publishes live events, monitor states and system
+// notices to the event stream API.
+package feed
+
+import (
+	"context"
+	"nvr/pkg/log"
+	"sync"
+	"time"
+)
+
+// Message types.
+const (
+	TypeEvent        = "event"
+	TypeRecording    = "recording"
+	TypeMonitorState = "monitorState"
+	TypeArming       = "arming"
+	TypeNotice       = "notice"
+	TypeStall        = "stall"
+	TypeFailover     = "failover"
+)
+
+// Message feed message, Data depends on the type.
+type Message struct {
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	MonitorID string      `json:"monitorId,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+// subscriberBuffer messages are dropped if a
+// subscriber falls this far behind.
+const subscriberBuffer = 64
+
+// Feed broadcasts messages to all subscribers.
+// Publish never blocks, slow subscribers miss messages.
+type Feed struct {
+	subscribers map[chan Message]struct{}
+	mu          sync.Mutex
+}
+
+// New returns a feed without subscribers.
+func New() *Feed {
+	return &Feed{subscribers: make(map[chan Message]struct{})}
+}
+
+// Publish sends the message to all subscribers.
+// The time is set to now if it's zero.
+func (f *Feed) Publish(msg Message) {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// CancelFunc cancels a subscription.
+type CancelFunc func()
+
+// Subscribe returns a chan with new messages and a CancelFunc
+// that must be called when the subscriber is done.
+func (f *Feed) Subscribe() (<-chan Message, CancelFunc) {
+	ch := make(chan Message, subscriberBuffer)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.mu.Unlock()
+	}
+	return ch, cancel
+}
+
+// Notice system notice, a warning or error not tied to a monitor.
+type Notice struct {
+	Level log.Level `json:"level"`
+	Src   string    `json:"src"`
+	Msg   string    `json:"msg"`
+}
+
+// PublishNotices publishes warnings and errors from the logger
+// that don't belong to a monitor, for example disk warnings.
+func (f *Feed) PublishNotices(ctx context.Context, wg *sync.WaitGroup, logger *log.Logger) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		entries, cancel := logger.Subscribe()
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-entries:
+				if !ok {
+					return
+				}
+				if entry.MonitorID != "" || entry.Level > log.LevelWarning {
+					continue
+				}
+				f.Publish(Message{
+					Type: TypeNotice,
+					Time: entry.GetTime(),
+					Data: Notice{Level: entry.Level, Src: entry.Src, Msg: entry.Msg},
+				})
+			}
+		}
+	}()
+}
+
+// Filter selects messages by type and monitor, empty lists match all.
+type Filter struct {
+	Types    []string
+	Monitors []string
+}
+
+// Match returns true if the message passes the filter.
+// Messages without a monitor ID pass the monitor filter.
+func (f Filter) Match(msg Message) bool {
+	if len(f.Types) != 0 && !contains(f.Types, msg.Type) {
+		return false
+	}
+	if len(f.Monitors) != 0 && msg.MonitorID != "" && !contains(f.Monitors, msg.MonitorID) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package feed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeed(t *testing.T) {
+	t.Run("publish", func(t *testing.T) {
+		f := New()
+		a, cancelA := f.Subscribe()
+		defer cancelA()
+		b, cancelB := f.Subscribe()
+		defer cancelB()
+
+		f.Publish(Message{Type: TypeEvent, MonitorID: "x"})
+		for _, ch := range []<-chan Message{a, b} {
+			msg := <-ch
+			require.Equal(t, TypeEvent, msg.Type)
+			require.False(t, msg.Time.IsZero())
+		}
+	})
+	t.Run("time", func(t *testing.T) {
+		f := New()
+		ch, cancel := f.Subscribe()
+		defer cancel()
+
+		f.Publish(Message{Time: time.Unix(1, 0)})
+		require.Equal(t, time.Unix(1, 0), (<-ch).Time)
+	})
+	t.Run("slowSubscriber", func(t *testing.T) {
+		f := New()
+		ch, cancel := f.Subscribe()
+		defer cancel()
+
+		for i := 0; i < subscriberBuffer+10; i++ {
+			f.Publish(Message{})
+		}
+		require.Len(t, ch, subscriberBuffer)
+	})
+	t.Run("cancel", func(t *testing.T) {
+		f := New()
+		ch, cancel := f.Subscribe()
+		cancel()
+
+		f.Publish(Message{})
+		require.Empty(t, ch)
+		require.Empty(t, f.subscribers)
+	})
+}
+
+func TestFilter(t *testing.T) {
+	cases := map[string]struct {
+		filter   Filter
+		msg      Message
+		expected bool
+	}{
+		"empty":      {Filter{}, Message{Type: TypeEvent, MonitorID: "a"}, true},
+		"type":       {Filter{Types: []string{TypeEvent}}, Message{Type: TypeEvent}, true},
+		"otherType":  {Filter{Types: []string{TypeEvent}}, Message{Type: TypeNotice}, false},
+		"monitor":    {Filter{Monitors: []string{"a"}}, Message{MonitorID: "a"}, true},
+		"other":      {Filter{Monitors: []string{"a"}}, Message{MonitorID: "b"}, false},
+		"noMonitor":  {Filter{Monitors: []string{"a"}}, Message{Type: TypeArming}, true},
+		"typeAndMon": {Filter{Types: []string{TypeEvent}, Monitors: []string{"a"}}, Message{Type: TypeRecording, MonitorID: "a"}, false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.filter.Match(tc.msg))
+		})
+	}
+}
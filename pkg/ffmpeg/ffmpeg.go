@@ -212,6 +212,11 @@ func (p Polygon) ToAbs(w, h int) Polygon {
 	return polygon
 }
 
+// Contains if the point is inside the polygon.
+func (p Polygon) Contains(x, y int) bool {
+	return vertexInsidePoly(x, y, p)
+}
+
 // CreateMask creates an image mask from a polygon.
 // Pixels inside the polygon are masked.
 func CreateMask(w int, h int, poly Polygon) image.Image {
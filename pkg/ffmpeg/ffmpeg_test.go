@@ -154,6 +154,13 @@ func TestPolygonToAbs(t *testing.T) {
 	require.Equal(t, "[[20 20] [60 40] [100 60]]", actual)
 }
 
+func TestPolygonContains(t *testing.T) {
+	polygon := Polygon{{0, 0}, {10, 0}, {10, 10}, {0, 10}}
+	require.True(t, polygon.Contains(5, 5))
+	require.False(t, polygon.Contains(15, 5))
+	require.False(t, polygon.Contains(5, -1))
+}
+
 func TestCreateMask(t *testing.T) {
 	cases := map[string]struct {
 		input    Polygon
@@ -0,0 +1,127 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hardware acceleration types.
+const (
+	HWAccelNone    = ""
+	HWAccelVAAPI   = "vaapi"
+	HWAccelNVENC   = "nvenc"
+	HWAccelV4L2M2M = "v4l2m2m"
+)
+
+// Default hardware acceleration devices.
+const (
+	defaultVAAPIDevice = "/dev/dri/renderD128"
+	defaultNVENCDevice = "0"
+)
+
+// Hardware acceleration errors.
+var (
+	ErrHWAccelUnknown = errors.New("unknown hardware acceleration type")
+	ErrHWAccelDevice  = errors.New("invalid hardware acceleration device")
+)
+
+// HWAccel hardware decoding and encoding backend.
+type HWAccel struct {
+	Type string
+
+	// VAAPI render node or NVENC GPU index.
+	// V4L2 M2M devices are selected by FFmpeg.
+	Device string
+
+	// Value of the "-hwaccel" option, used if Type is empty.
+	Legacy string
+}
+
+// NewHWAccel validates the type and device. The default device is used
+// if the device is empty. The legacy value is the "-hwaccel" option.
+func NewHWAccel(typ string, device string, legacy string) (HWAccel, error) {
+	h := HWAccel{Type: typ, Device: device, Legacy: legacy}
+	switch typ {
+	case HWAccelNone, "none":
+		return HWAccel{Legacy: legacy}, nil
+	case HWAccelVAAPI:
+		if h.Device == "" {
+			h.Device = defaultVAAPIDevice
+		}
+		if !strings.HasPrefix(h.Device, "/dev/") || strings.ContainsAny(h.Device, " \t") {
+			return HWAccel{}, fmt.Errorf("%w: %q", ErrHWAccelDevice, h.Device)
+		}
+	case HWAccelNVENC:
+		if h.Device == "" {
+			h.Device = defaultNVENCDevice
+		}
+		if n, err := strconv.Atoi(h.Device); err != nil || n < 0 {
+			return HWAccel{}, fmt.Errorf("%w: %q", ErrHWAccelDevice, h.Device)
+		}
+	case HWAccelV4L2M2M:
+		h.Device = ""
+	default:
+		return HWAccel{}, fmt.Errorf("%w: %q", ErrHWAccelUnknown, typ)
+	}
+	return h, nil
+}
+
+// DecodeArgs returns the input options for hardware decoding. The
+// frames are downloaded to system memory so software filters work.
+// V4L2 M2M decoders are codec specific and aren't used.
+func (h HWAccel) DecodeArgs() string {
+	switch h.Type {
+	case HWAccelVAAPI:
+		return "-hwaccel vaapi -hwaccel_device " + h.Device
+	case HWAccelNVENC:
+		return "-hwaccel cuda -hwaccel_device " + h.Device
+	case HWAccelV4L2M2M:
+		return ""
+	}
+	if h.Legacy != "" {
+		return "-hwaccel " + h.Legacy
+	}
+	return ""
+}
+
+// TranscodeArgs returns the input options used with H264Encoder.
+// VAAPI frames stay in video memory if the decoder supports the
+// codec, otherwise they're uploaded by the encoder filter.
+func (h HWAccel) TranscodeArgs() string {
+	if h.Type == HWAccelVAAPI {
+		return "-hwaccel vaapi -hwaccel_device " + h.Device +
+			" -hwaccel_output_format vaapi -vaapi_device " + h.Device
+	}
+	return h.DecodeArgs()
+}
+
+// H264Encoder returns the hardware H264 encoder and its
+// output options. Empty if there isn't a hardware encoder.
+func (h HWAccel) H264Encoder() string {
+	switch h.Type {
+	case HWAccelVAAPI:
+		return "h264_vaapi -vf format=nv12|vaapi,hwupload"
+	case HWAccelNVENC:
+		return "h264_nvenc -gpu " + h.Device + " -preset fast -pix_fmt yuv420p"
+	case HWAccelV4L2M2M:
+		return "h264_v4l2m2m -pix_fmt yuv420p"
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHWAccel(t *testing.T) {
+	cases := map[string]struct {
+		typ       string
+		device    string
+		legacy    string
+		decode    string
+		transcode string
+		encoder   string
+		err       error
+	}{
+		"none": {},
+		"legacy": {
+			legacy:    "auto",
+			decode:    "-hwaccel auto",
+			transcode: "-hwaccel auto",
+		},
+		"vaapi": {
+			typ:    "vaapi",
+			decode: "-hwaccel vaapi -hwaccel_device /dev/dri/renderD128",
+			transcode: "-hwaccel vaapi -hwaccel_device /dev/dri/renderD128" +
+				" -hwaccel_output_format vaapi -vaapi_device /dev/dri/renderD128",
+			encoder: "h264_vaapi -vf format=nv12|vaapi,hwupload",
+		},
+		"nvenc": {
+			typ:       "nvenc",
+			device:    "1",
+			legacy:    "auto",
+			decode:    "-hwaccel cuda -hwaccel_device 1",
+			transcode: "-hwaccel cuda -hwaccel_device 1",
+			encoder:   "h264_nvenc -gpu 1 -preset fast -pix_fmt yuv420p",
+		},
+		"v4l2m2m": {
+			typ:     "v4l2m2m",
+			encoder: "h264_v4l2m2m -pix_fmt yuv420p",
+		},
+		"unknown":     {typ: "x", err: ErrHWAccelUnknown},
+		"vaapiDevice": {typ: "vaapi", device: "renderD128", err: ErrHWAccelDevice},
+		"nvencDevice": {typ: "nvenc", device: "/dev/x", err: ErrHWAccelDevice},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			h, err := NewHWAccel(tc.typ, tc.device, tc.legacy)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.decode, h.DecodeArgs())
+			require.Equal(t, tc.transcode, h.TranscodeArgs())
+			require.Equal(t, tc.encoder, h.H264Encoder())
+		})
+	}
+}
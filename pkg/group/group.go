@@ -81,26 +81,26 @@ func readConfigs(path string) ([][]byte, error) {
 func (m *Manager) GroupSet(id string, c Config) error {
 	defer m.mu.Unlock()
 	m.mu.Lock()
+	return m.set(id, c)
+}
 
+func (m *Manager) set(id string, c Config) error {
 	group, exist := m.Groups[id]
-	if exist {
-		group.mu.Lock()
-		group.Config = c
-		group.mu.Unlock()
-	} else {
+	if !exist {
 		group = m.newGroup(c)
 		m.Groups[id] = group
 	}
 
-	// Update file.
 	group.mu.Lock()
-	config, _ := json.MarshalIndent(group.Config, "", "    ")
+	defer group.mu.Unlock()
+	group.Config = c
 
+	// Update file.
+	config, _ := json.MarshalIndent(group.Config, "", "    ")
 	err := os.WriteFile(m.configPath(id), config, 0o600)
 	if err != nil {
 		return fmt.Errorf("write file: %w", err)
 	}
-	group.mu.Unlock()
 
 	return nil
 }
@@ -147,6 +147,93 @@ func (m *Manager) Configs() map[string]Config {
 	return configs
 }
 
+// UpdateMonitors adds and removes monitors from the group and returns
+// the new config. Removed monitors are also removed from the layout.
+func (m *Manager) UpdateMonitors(id string, add, remove []string) (Config, error) {
+	defer m.mu.Unlock()
+	m.mu.Lock()
+
+	c, err := m.config(id)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := c.Layout()
+	if err != nil {
+		return nil, err
+	}
+
+	var monitors []string
+	for _, monitorID := range append(c.Monitors(), add...) {
+		if !contains(remove, monitorID) && !contains(monitors, monitorID) {
+			monitors = append(monitors, monitorID)
+		}
+	}
+	c.setMonitors(monitors)
+
+	if c["layout"] != "" {
+		var tiles []Tile
+		for _, tile := range layout.Tiles {
+			if contains(monitors, tile.Monitor) {
+				tiles = append(tiles, tile)
+			}
+		}
+		layout.Tiles = tiles
+		c.setLayout(layout)
+	}
+
+	if err := m.set(id, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetLayout validates and sets the view layout of the group.
+func (m *Manager) SetLayout(id string, layout Layout) error {
+	defer m.mu.Unlock()
+	m.mu.Lock()
+
+	c, err := m.config(id)
+	if err != nil {
+		return err
+	}
+	if err := layout.validate(c.Monitors()); err != nil {
+		return err
+	}
+	c.setLayout(layout)
+
+	return m.set(id, c)
+}
+
+// Monitors returns the IDs of the monitors in the group,
+// nil if the group doesn't exist.
+func (m *Manager) Monitors(id string) []string {
+	defer m.mu.Unlock()
+	m.mu.Lock()
+
+	if _, exist := m.Groups[id]; !exist {
+		return nil
+	}
+	c, _ := m.config(id)
+	return c.Monitors()
+}
+
+// config returns a copy of the group config, the lock must be held.
+func (m *Manager) config(id string) (Config, error) {
+	group, exist := m.Groups[id]
+	if !exist {
+		return nil, ErrGroupNotExist
+	}
+
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	c := make(Config, len(group.Config))
+	for key, value := range group.Config {
+		c[key] = value
+	}
+	return c, nil
+}
+
 func (m *Manager) newGroup(config Config) *Group {
 	return &Group{
 		Config: config,
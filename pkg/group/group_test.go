@@ -189,3 +189,82 @@ func TestGroupConfigs(t *testing.T) {
 	expected := "map[1:map[id:1 monitors:[\"1\"] name:one] 2:map[id:2 monitors:[\"2\"] name:two]]"
 	require.Equal(t, actual, expected)
 }
+
+func TestUpdateMonitors(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		configDir, manager, cancel := newTestManager(t)
+		defer cancel()
+
+		err := manager.SetLayout("1", Layout{Tiles: []Tile{{"1", 2, 2}}})
+		require.NoError(t, err)
+
+		c, err := manager.UpdateMonitors("1", []string{"2", "3", "2"}, []string{"1"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"2", "3"}, c.Monitors())
+		require.Equal(t, c, manager.Groups["1"].Config)
+
+		layout, err := c.Layout()
+		require.NoError(t, err)
+		require.Equal(t, Layout{Tiles: []Tile{}}, layout)
+
+		// Check if changes were saved to file.
+		require.Equal(t, c, readConfig(t, configDir+"/1.json"))
+	})
+	t.Run("existErr", func(t *testing.T) {
+		_, manager, cancel := newTestManager(t)
+		defer cancel()
+
+		_, err := manager.UpdateMonitors("nil", nil, nil)
+		require.ErrorIs(t, err, ErrGroupNotExist)
+	})
+}
+
+func TestSetLayout(t *testing.T) {
+	cases := map[string]struct {
+		layout Layout
+		err    error
+	}{
+		"ok":        {Layout{Columns: 3, Tiles: []Tile{{"1", 2, 1}}}, nil},
+		"noColumns": {Layout{Tiles: []Tile{{"1", 8, 8}}}, nil},
+		"columns":   {Layout{Columns: 9}, ErrInvalidLayout},
+		"notMember": {Layout{Tiles: []Tile{{"2", 1, 1}}}, ErrMonitorNotMember},
+		"duplicate": {Layout{Tiles: []Tile{{"1", 1, 1}, {"1", 1, 1}}}, ErrInvalidLayout},
+		"width":     {Layout{Columns: 2, Tiles: []Tile{{"1", 3, 1}}}, ErrInvalidLayout},
+		"height":    {Layout{Tiles: []Tile{{"1", 1, 0}}}, ErrInvalidLayout},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, manager, cancel := newTestManager(t)
+			defer cancel()
+
+			err := manager.SetLayout("1", tc.layout)
+			require.ErrorIs(t, err, tc.err)
+			if tc.err != nil {
+				return
+			}
+			layout, err := manager.Groups["1"].Config.Layout()
+			require.NoError(t, err)
+			require.Equal(t, tc.layout, layout)
+		})
+	}
+	t.Run("existErr", func(t *testing.T) {
+		_, manager, cancel := newTestManager(t)
+		defer cancel()
+
+		err := manager.SetLayout("nil", Layout{})
+		require.ErrorIs(t, err, ErrGroupNotExist)
+	})
+}
+
+func TestLayoutUnmarshalErr(t *testing.T) {
+	_, err := Config{"layout": "{"}.Layout()
+	require.ErrorIs(t, err, ErrInvalidLayout)
+}
+
+func TestManagerMonitors(t *testing.T) {
+	_, manager, cancel := newTestManager(t)
+	defer cancel()
+
+	require.Equal(t, []string{"2"}, manager.Monitors("2"))
+	require.Nil(t, manager.Monitors("nil"))
+}
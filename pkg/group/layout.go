@@ -0,0 +1,111 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MaxLayoutColumns maximum number of columns in a layout.
+const MaxLayoutColumns = 8
+
+// Layout view layout of the group in the live grid.
+type Layout struct {
+	// Number of columns, zero keeps the grid size of the viewer.
+	Columns int `json:"columns"`
+
+	// The tiles are placed in order, monitors in the
+	// group without a tile are placed after them.
+	Tiles []Tile `json:"tiles"`
+}
+
+// Tile position and size of a monitor in the layout.
+// The tile spans Width columns and Height rows.
+type Tile struct {
+	Monitor string `json:"monitor"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+// Layout errors.
+var (
+	ErrInvalidLayout    = errors.New("invalid layout")
+	ErrMonitorNotMember = errors.New("monitor is not in the group")
+)
+
+func (l Layout) validate(monitors []string) error {
+	if l.Columns < 0 || l.Columns > MaxLayoutColumns {
+		return fmt.Errorf("%w: columns must be between 0 and %v",
+			ErrInvalidLayout, MaxLayoutColumns)
+	}
+	maxSize := l.Columns
+	if maxSize == 0 {
+		maxSize = MaxLayoutColumns
+	}
+	seen := make(map[string]struct{}, len(l.Tiles))
+	for _, tile := range l.Tiles {
+		if !contains(monitors, tile.Monitor) {
+			return fmt.Errorf("%w: %q", ErrMonitorNotMember, tile.Monitor)
+		}
+		if _, exist := seen[tile.Monitor]; exist {
+			return fmt.Errorf("%w: duplicate tile: %q", ErrInvalidLayout, tile.Monitor)
+		}
+		seen[tile.Monitor] = struct{}{}
+
+		if tile.Width < 1 || tile.Width > maxSize ||
+			tile.Height < 1 || tile.Height > maxSize {
+			return fmt.Errorf("%w: tile size must be between 1 and %v: %q",
+				ErrInvalidLayout, maxSize, tile.Monitor)
+		}
+	}
+	return nil
+}
+
+// Monitors returns the IDs of the monitors in the group.
+// The IDs are stored as a JSON list in the "monitors" key.
+func (c Config) Monitors() []string {
+	var monitors []string
+	json.Unmarshal([]byte(c["monitors"]), &monitors) //nolint:errcheck
+	return monitors
+}
+
+func (c Config) setMonitors(monitors []string) {
+	if monitors == nil {
+		monitors = []string{}
+	}
+	raw, _ := json.Marshal(monitors)
+	c["monitors"] = string(raw)
+}
+
+// Layout returns the validated view layout stored as JSON in the
+// "layout" key. Groups without a layout return a empty layout.
+func (c Config) Layout() (Layout, error) {
+	var layout Layout
+	if c["layout"] == "" {
+		return layout, nil
+	}
+	if err := json.Unmarshal([]byte(c["layout"]), &layout); err != nil {
+		return Layout{}, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+	}
+	if err := layout.validate(c.Monitors()); err != nil {
+		return Layout{}, err
+	}
+	return layout, nil
+}
+
+func (c Config) setLayout(layout Layout) {
+	if layout.Tiles == nil {
+		layout.Tiles = []Tile{}
+	}
+	raw, _ := json.Marshal(layout)
+	c["layout"] = string(raw)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
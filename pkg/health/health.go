@@ -0,0 +1,126 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package health collects the status of the subsystems
+// for the liveness and readiness endpoints.
+package health
+
+import (
+	"sync"
+)
+
+// Status of a component.
+type Status string
+
+// Component statuses.
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusFailed   Status = "failed"
+)
+
+// Component status of a subsystem and the reason if it isn't ok.
+// The app isn't alive if a critical component has failed.
+type Component struct {
+	Status   Status `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	Critical bool   `json:"critical,omitempty"`
+}
+
+// Check returns the status of one or more components keyed by name.
+type Check func() map[string]Component
+
+// Report overall status and the components.
+type Report struct {
+	Status     Status               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+// Registry of health checks.
+type Registry struct {
+	checks []Check
+	ready  bool
+	mu     sync.Mutex
+}
+
+// NewRegistry creates a empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check.
+func (r *Registry) Register(check Check) {
+	r.mu.Lock()
+	r.checks = append(r.checks, check)
+	r.mu.Unlock()
+}
+
+// SetReady is called when the app has started.
+func (r *Registry) SetReady() {
+	r.mu.Lock()
+	r.ready = true
+	r.mu.Unlock()
+}
+
+func (r *Registry) components() (map[string]Component, bool) {
+	r.mu.Lock()
+	checks := append([]Check{}, r.checks...)
+	ready := r.ready
+	r.mu.Unlock()
+
+	components := make(map[string]Component)
+	for _, check := range checks {
+		for name, c := range check() {
+			components[name] = c
+		}
+	}
+	return components, ready
+}
+
+// Live returns the liveness report. The status is failed
+// if a critical component has failed, and degraded if
+// any other component isn't ok.
+func (r *Registry) Live() Report {
+	components, _ := r.components()
+	status := StatusOK
+	for _, c := range components {
+		switch {
+		case c.Status == StatusFailed && c.Critical:
+			return Report{Status: StatusFailed, Components: components}
+		case c.Status != StatusOK:
+			status = StatusDegraded
+		}
+	}
+	return Report{Status: status, Components: components}
+}
+
+// Ready returns the readiness report. The status is failed
+// until the app has started and while any component has failed.
+func (r *Registry) Ready() Report {
+	components, ready := r.components()
+	if !ready {
+		components["app"] = Component{Status: StatusFailed, Reason: "starting"}
+	}
+	status := StatusOK
+	for _, c := range components {
+		switch c.Status {
+		case StatusFailed:
+			return Report{Status: StatusFailed, Components: components}
+		case StatusDegraded:
+			status = StatusDegraded
+		}
+	}
+	return Report{Status: status, Components: components}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package health
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	cases := map[string]struct {
+		components map[string]Component
+		ready      bool
+		live       Status
+		readiness  Status
+	}{
+		"ok": {
+			map[string]Component{"a": {Status: StatusOK}},
+			true, StatusOK, StatusOK,
+		},
+		"starting": {
+			map[string]Component{"a": {Status: StatusOK}},
+			false, StatusOK, StatusFailed,
+		},
+		"degraded": {
+			map[string]Component{"a": {Status: StatusDegraded}, "b": {Status: StatusOK}},
+			true, StatusDegraded, StatusDegraded,
+		},
+		"failed": {
+			map[string]Component{"a": {Status: StatusFailed}},
+			true, StatusDegraded, StatusFailed,
+		},
+		"criticalFailed": {
+			map[string]Component{"a": {Status: StatusFailed, Critical: true}},
+			true, StatusFailed, StatusFailed,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Register(func() map[string]Component { return tc.components })
+			if tc.ready {
+				r.SetReady()
+			}
+			live := r.Live()
+			require.Equal(t, tc.live, live.Status)
+			require.Equal(t, tc.components, live.Components)
+			require.Equal(t, tc.readiness, r.Ready().Status)
+		})
+	}
+}
+
+func TestRegistryStarting(t *testing.T) {
+	r := NewRegistry()
+	expected := map[string]Component{
+		"app": {Status: StatusFailed, Reason: "starting"},
+	}
+	require.Equal(t, expected, r.Ready().Components)
+
+	r.SetReady()
+	require.Empty(t, r.Ready().Components)
+}
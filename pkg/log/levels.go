@@ -0,0 +1,131 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Level names.
+var levelNames = map[Level]string{
+	LevelError:   "error",
+	LevelWarning: "warning",
+	LevelInfo:    "info",
+	LevelDebug:   "debug",
+}
+
+func (l Level) String() string {
+	if name, exist := levelNames[l]; exist {
+		return name
+	}
+	return fmt.Sprintf("%d", uint8(l))
+}
+
+// ErrInvalidLevel invalid level.
+var ErrInvalidLevel = errors.New("invalid log level")
+
+// ParseLevel parses "error", "warning", "info" or "debug".
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if s == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidLevel, s)
+}
+
+// ErrUnknownSource unknown log source.
+var ErrUnknownSource = errors.New("unknown log source")
+
+// SetLevel sets the maximum level of the source, more verbose
+// entries from the source are dropped. Applies immediately.
+func (l *Logger) SetLevel(src string, level Level) error {
+	if !StringInStrings(src, l.sources) || src == "" {
+		return fmt.Errorf("%w: %q", ErrUnknownSource, src)
+	}
+	if _, exist := levelNames[level]; !exist {
+		return fmt.Errorf("%w: %v", ErrInvalidLevel, level)
+	}
+	l.levelsMu.Lock()
+	l.levels[src] = level
+	l.levelsMu.Unlock()
+	return nil
+}
+
+// Levels returns the maximum level of every source.
+func (l *Logger) Levels() map[string]Level {
+	l.levelsMu.Lock()
+	defer l.levelsMu.Unlock()
+
+	levels := make(map[string]Level, len(l.sources))
+	for _, src := range l.sources {
+		levels[src] = LevelDebug
+		if level, exist := l.levels[src]; exist {
+			levels[src] = level
+		}
+	}
+	return levels
+}
+
+func (l *Logger) enabled(log Entry) bool {
+	l.levelsMu.Lock()
+	defer l.levelsMu.Unlock()
+
+	level, exist := l.levels[log.Src]
+	return !exist || log.Level <= level
+}
+
+// Format output format of LogToWriter.
+type Format string
+
+// Formats.
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// jsonEntry is one line of JSON output.
+type jsonEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Src       string `json:"src"`
+	MonitorID string `json:"monitorID,omitempty"`
+	Msg       string `json:"msg"`
+}
+
+// Encode writes the entry to the writer as a single line.
+func (f Format) Encode(w io.Writer, e Entry) error {
+	if f != FormatJSON {
+		_, err := fmt.Fprintln(w, e)
+		return err
+	}
+	line, err := json.Marshal(jsonEntry{
+		Time:      e.GetTime().UTC().Format(time.RFC3339Nano),
+		Level:     e.Level.String(),
+		Src:       e.Src,
+		MonitorID: e.MonitorID,
+		Msg:       e.Msg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warning")
+	require.NoError(t, err)
+	require.Equal(t, LevelWarning, level)
+	require.Equal(t, "warning", level.String())
+
+	_, err = ParseLevel("x")
+	require.ErrorIs(t, err, ErrInvalidLevel)
+}
+
+func TestSetLevel(t *testing.T) {
+	logger := NewLogger(&sync.WaitGroup{}, []string{"addon"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, logger.Start(ctx))
+
+	feed, cancel2 := logger.Subscribe()
+	defer cancel2()
+
+	require.NoError(t, logger.SetLevel("addon", LevelWarning))
+	require.ErrorIs(t, logger.SetLevel("nil", LevelInfo), ErrUnknownSource)
+	require.ErrorIs(t, logger.SetLevel("app", 1), ErrInvalidLevel)
+
+	levels := logger.Levels()
+	require.Equal(t, LevelWarning, levels["addon"])
+	require.Equal(t, LevelDebug, levels["app"])
+
+	go func() {
+		logger.Log(Entry{Level: LevelInfo, Src: "addon", Msg: "dropped"})
+		logger.Log(Entry{Level: LevelWarning, Src: "addon", Msg: "a"})
+		logger.Log(Entry{Level: LevelDebug, Src: "app", Msg: "b"})
+	}()
+	require.Equal(t, "a", (<-feed).Msg)
+	require.Equal(t, "b", (<-feed).Msg)
+}
+
+func TestFormatEncode(t *testing.T) {
+	entry := Entry{
+		Level:     LevelInfo,
+		Src:       "monitor",
+		MonitorID: "m1",
+		Msg:       "msg",
+		Time:      UnixMicro(time.Date(2000, 1, 2, 3, 4, 5, 6000, time.UTC).UnixMicro()),
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, FormatJSON.Encode(&b, entry))
+	expected := `{"time":"2000-01-02T03:04:05.000006Z","level":"info",` +
+		`"src":"monitor","monitorID":"m1","msg":"msg"}` + "\n"
+	require.Equal(t, expected, b.String())
+
+	b.Reset()
+	require.NoError(t, FormatText.Encode(&b, entry))
+	require.Equal(t, "[INFO] m1: Monitor: msg\n", b.String())
+}
@@ -115,9 +115,12 @@ type Logger struct {
 	wg      *sync.WaitGroup
 	Ctx     context.Context
 	sources []string
+
+	levels   map[string]Level // Maximum level by source.
+	levelsMu sync.Mutex
 }
 
-var defaultSources = []string{"app", "auth", "monitor", "recorder"}
+var defaultSources = []string{"app", "arming", "auth", "monitor", "recorder"}
 
 // NewLogger starts and returns Logger.
 func NewLogger(wg *sync.WaitGroup, addonSources []string) *Logger {
@@ -128,6 +131,7 @@ func NewLogger(wg *sync.WaitGroup, addonSources []string) *Logger {
 
 		wg:      wg,
 		sources: append(defaultSources, addonSources...),
+		levels:  make(map[string]Level),
 	}
 }
 
@@ -144,6 +148,10 @@ func (l *Logger) Log(log Entry) {
 		panic(fmt.Sprintf("log message cannot be empty: %v", log))
 	}
 
+	if !l.enabled(log) {
+		return
+	}
+
 	log.Time = UnixMicro(time.Now().UnixMicro())
 
 	select {
@@ -224,6 +232,11 @@ func (l *Logger) unSubscribe(feed chan Entry) {
 
 // LogToWriter prints log feed to writer.
 func (l *Logger) LogToWriter(ctx context.Context, out io.Writer) {
+	l.LogToWriterFormat(ctx, out, FormatText)
+}
+
+// LogToWriterFormat prints log feed to writer in the format.
+func (l *Logger) LogToWriterFormat(ctx context.Context, out io.Writer, format Format) {
 	l.wg.Add(1)
 	go func() {
 		feed, cancel := l.Subscribe()
@@ -232,7 +245,7 @@ func (l *Logger) LogToWriter(ctx context.Context, out io.Writer) {
 		for {
 			select {
 			case entry := <-feed:
-				fmt.Fprintln(out, entry)
+				format.Encode(out, entry) //nolint:errcheck
 			case <-ctx.Done():
 				l.wg.Done()
 				return
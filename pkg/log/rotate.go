@@ -0,0 +1,154 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a log file that is rotated when it exceeds the maximum
+// size or age. The previous files are renamed to "name.1", "name.2" and
+// so on, the oldest file is removed when there are more than backups.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	backups int
+
+	file    *os.File
+	size    int64
+	created time.Time
+	mu      sync.Mutex
+
+	now func() time.Time
+}
+
+// NewRotatingFile opens or creates the log file. Zero maxSize
+// or maxAge disables rotation by size or age.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, backups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		backups: backups,
+		now:     time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	f.created = f.now()
+	if f.size != 0 {
+		// The creation time isn't available, the age
+		// of existing files is counted from the last write.
+		f.created = info.ModTime()
+	}
+	return nil
+}
+
+// Write writes p to the file, the file is rotated first
+// if p doesn't fit or the file is older than maxAge.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.needsRotation(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) needsRotation(writeSize int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize != 0 && f.size+writeSize > f.maxSize {
+		return true
+	}
+	return f.maxAge != 0 && f.now().Sub(f.created) >= f.maxAge
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	f.file = nil
+
+	if f.backups == 0 {
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("remove log file: %w", err)
+		}
+		return f.open()
+	}
+
+	err := os.Remove(f.backupPath(f.backups))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove oldest log file: %w", err)
+	}
+	for i := f.backups - 1; i >= 1; i-- {
+		err := os.Rename(f.backupPath(i), f.backupPath(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rename log file: %w", err)
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return fmt.Errorf("rename log file: %w", err)
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) backupPath(n int) string {
+	return f.path + "." + strconv.Itoa(n)
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
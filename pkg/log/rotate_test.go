@@ -0,0 +1,99 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nvr.log")
+		f, err := NewRotatingFile(path, 5, 0, 2)
+		require.NoError(t, err)
+		defer f.Close()
+
+		for _, line := range []string{"aa\n", "b\n", "cc\n", "dd\n"} {
+			_, err := f.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.Equal(t, "dd\n", readFile(t, path))
+		require.Equal(t, "cc\n", readFile(t, path+".1"))
+		require.Equal(t, "aa\nb\n", readFile(t, path+".2"))
+		_, err = os.Stat(path + ".3")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("age", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nvr.log")
+		f, err := NewRotatingFile(path, 0, time.Hour, 1)
+		require.NoError(t, err)
+		defer f.Close()
+
+		now := time.Now()
+		f.now = func() time.Time { return now }
+
+		_, err = f.Write([]byte("a\n"))
+		require.NoError(t, err)
+
+		now = now.Add(2 * time.Hour)
+		_, err = f.Write([]byte("b\n"))
+		require.NoError(t, err)
+
+		require.Equal(t, "b\n", readFile(t, path))
+		require.Equal(t, "a\n", readFile(t, path+".1"))
+	})
+	t.Run("noBackups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nvr.log")
+		f, err := NewRotatingFile(path, 2, 0, 0)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write([]byte("a\n"))
+		require.NoError(t, err)
+		_, err = f.Write([]byte("b\n"))
+		require.NoError(t, err)
+
+		require.Equal(t, "b\n", readFile(t, path))
+		_, err = os.Stat(path + ".1")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("append", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nvr.log")
+		require.NoError(t, os.WriteFile(path, []byte("a\n"), 0o600))
+
+		f, err := NewRotatingFile(path, 10, 0, 1)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("b\n"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		require.Equal(t, "a\nb\n", readFile(t, path))
+		_, err = f.Write([]byte("c\n"))
+		require.ErrorIs(t, err, os.ErrClosed)
+	})
+}
@@ -15,6 +15,18 @@
 
 package monitor
 
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/arming"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/storage"
+	"nvr/pkg/video"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // RawConfigs map of RawConfig.
 type RawConfigs map[string]RawConfig
 
@@ -68,16 +80,165 @@ func (c Config) audioEnabled() bool {
 	return true
 }
 
+// recordAudio if audio should be included in recordings. Defaults to
+// true, the stream still has audio for live view if it's disabled.
+func (c Config) recordAudio() bool {
+	return c.v["recordAudio"] != "false"
+}
+
+// audioOnly if the monitor only has audio, for example a
+// microphone. The video track of the input is dropped.
+func (c Config) audioOnly() bool {
+	return c.v["audioOnly"] == "true"
+}
+
 // AudioEncoder returns the monitor audio encoder.
 func (c Config) AudioEncoder() string {
 	return c.v["audioEncoder"]
 }
 
+// AudioLanguages returns the languages of the audio tracks that should be
+// included in the stream. Comma separated, for example "eng,swe". Empty
+// if only the first audio track should be included.
+func (c Config) AudioLanguages() []string {
+	var langs []string
+	for _, lang := range strings.Split(c.v["audioLanguages"], ",") {
+		lang = strings.TrimSpace(lang)
+		if lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+// HLSPartDuration returns the target duration of the live
+// HLS parts in milliseconds. Empty for the default.
+func (c Config) HLSPartDuration() string {
+	return c.v["hlsPartDuration"]
+}
+
+// ErrInvalidHLSPartDuration invalid HLS part duration.
+var ErrInvalidHLSPartDuration = errors.New("invalid HLS part duration")
+
+func (c Config) hlsPartDuration() (time.Duration, error) {
+	d, err := parseDuration(c.HLSPartDuration(), time.Millisecond, ErrInvalidHLSPartDuration)
+	if err != nil {
+		return 0, err
+	}
+	if d != 0 && (d < video.MinHLSPartDuration || d > video.MaxHLSPartDuration) {
+		return 0, fmt.Errorf("%w: must be between %v and %v",
+			ErrInvalidHLSPartDuration, video.MinHLSPartDuration, video.MaxHLSPartDuration)
+	}
+	return d, nil
+}
+
 // VideoEncoder returns the monitor audio encoder.
 func (c Config) VideoEncoder() string {
 	return c.v["videoEncoder"]
 }
 
+// rtmpInput if the inputs are stream keys of RTMP
+// publishers instead of urls read by FFmpeg.
+func (c Config) rtmpInput() bool {
+	return c.v["inputSource"] == "rtmp"
+}
+
+// pushInput if the inputs are stream keys of HTTP
+// push publishers instead of urls read by FFmpeg.
+func (c Config) pushInput() bool {
+	return c.v["inputSource"] == "push"
+}
+
+// publishedInput if the stream is published to the
+// video server instead of being read by FFmpeg.
+func (c Config) publishedInput() bool {
+	return c.rtmpInput() || c.pushInput()
+}
+
+// srtInput if the inputs are SRT urls. The SRT
+// options are added to the urls by the monitor.
+func (c Config) srtInput() bool {
+	return c.v["inputSource"] == "srt"
+}
+
+// mjpegInput if the inputs are HTTP MJPEG urls.
+func (c Config) mjpegInput() bool {
+	return c.v["inputSource"] == "mjpeg"
+}
+
+// fileInput if the inputs are local video files that are looped.
+func (c Config) fileInput() bool {
+	return c.v["inputSource"] == "file"
+}
+
+// V4L2InputFormat returns the pixel format requested
+// from V4L2 devices, for example "mjpeg" or "h264".
+func (c Config) V4L2InputFormat() string {
+	return c.v["v4l2InputFormat"]
+}
+
+// V4L2Resolution returns the resolution requested from V4L2 devices.
+func (c Config) V4L2Resolution() string {
+	return c.v["v4l2Resolution"]
+}
+
+// V4L2FrameRate returns the frame rate requested from V4L2 devices.
+func (c Config) V4L2FrameRate() string {
+	return c.v["v4l2FrameRate"]
+}
+
+// SRTMode returns the SRT connection mode, "caller" or "listener".
+func (c Config) SRTMode() string {
+	return c.v["srtMode"]
+}
+
+// SRTPassphrase returns the SRT encryption passphrase.
+func (c Config) SRTPassphrase() string {
+	return c.v["srtPassphrase"]
+}
+
+// SRTLatency returns the SRT receiver latency in milliseconds.
+func (c Config) SRTLatency() string {
+	return c.v["srtLatency"]
+}
+
+// RTSPTransport returns the preferred RTSP transport,
+// "tcp", "udp" or "multicast". Empty for the FFmpeg default.
+func (c Config) RTSPTransport() string {
+	return c.v["rtspTransport"]
+}
+
+// RTSPSCA returns the path to the CA bundle used to verify RTSPS inputs.
+func (c Config) RTSPSCA() string {
+	return c.v["rtspsCA"]
+}
+
+// RTSPSFingerprint returns the pinned SHA-256 fingerprint of the RTSPS
+// certificate. The certificate chain isn't verified if set.
+func (c Config) RTSPSFingerprint() string {
+	return c.v["rtspsFingerprint"]
+}
+
+// ReconnectMinDelay returns the initial reconnect delay in seconds.
+func (c Config) ReconnectMinDelay() string {
+	return c.v["reconnectMinDelay"]
+}
+
+// ReconnectMaxDelay returns the maximum reconnect delay in seconds.
+func (c Config) ReconnectMaxDelay() string {
+	return c.v["reconnectMaxDelay"]
+}
+
+// ReconnectJitter returns the random reconnect delay variation in percent.
+func (c Config) ReconnectJitter() string {
+	return c.v["reconnectJitter"]
+}
+
+// BackchannelEnabled if two-way audio is enabled.
+func (c Config) BackchannelEnabled() bool {
+	return c.v["backchannel"] == "true"
+}
+
 // MainInput returns the main input url.
 func (c Config) MainInput() string {
 	return c.v["mainInput"]
@@ -93,15 +254,176 @@ func (c Config) SubInputEnabled() bool {
 	return c.SubInput() != ""
 }
 
+// DetectSubInput if detection and thumbnails should use the sub input.
+// "auto" and "sub" use the sub input if it's available, "main" always
+// uses the main input.
+func (c Config) DetectSubInput() bool {
+	if !c.SubInputEnabled() {
+		return false
+	}
+	return c.v["detectStream"] != "main"
+}
+
 // video length is seconds.
 func (c Config) videoLength() string {
 	return c.v["videoLength"]
 }
 
+// PreEventBuffer returns the number of seconds before
+// the event that are included in event recordings.
+func (c Config) PreEventBuffer() string {
+	return c.v["preEventBuffer"]
+}
+
+// ErrInvalidPreEventBuffer invalid pre-event buffer.
+var ErrInvalidPreEventBuffer = errors.New("invalid pre-event buffer")
+
+func (c Config) preEventBuffer() (time.Duration, error) {
+	return parseDuration(c.PreEventBuffer(), time.Second, ErrInvalidPreEventBuffer)
+}
+
+// EventCooldown returns the number of seconds the recording
+// continues after the last event. Events within the cooldown
+// extend the active recording instead of starting a new one.
+func (c Config) EventCooldown() string {
+	return c.v["eventCooldown"]
+}
+
+// MaxRecordingDuration returns the maximum number of minutes
+// a recording session can be extended by new events.
+func (c Config) MaxRecordingDuration() string {
+	return c.v["maxRecordingDuration"]
+}
+
+// Recorder setting errors.
+var (
+	ErrInvalidEventCooldown        = errors.New("invalid event cooldown")
+	ErrInvalidMaxRecordingDuration = errors.New("invalid max recording duration")
+)
+
+func (c Config) eventCooldown() (time.Duration, error) {
+	return parseDuration(c.EventCooldown(), time.Second, ErrInvalidEventCooldown)
+}
+
+func (c Config) maxRecordingDuration() (time.Duration, error) {
+	return parseDuration(c.MaxRecordingDuration(), time.Minute, ErrInvalidMaxRecordingDuration)
+}
+
+// mkvContainer if event recordings should be saved
+// as Matroska files instead of the custom format.
+func (c Config) mkvContainer() bool {
+	return c.v["recordingContainer"] == "mkv"
+}
+
+// parseDuration parses a number of units, empty is zero.
+func parseDuration(raw string, unit time.Duration, errInvalid error) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: %q", errInvalid, raw)
+	}
+	return time.Duration(value * float64(unit)), nil
+}
+
+// ArmAction returns what the monitor does with detections in the
+// arming mode. Set by "armDisarmed", "armHome", "armAway" and "armNight".
+func (c Config) ArmAction(mode arming.Mode) arming.Action {
+	var raw string
+	switch mode {
+	case arming.Disarmed:
+		raw = c.v["armDisarmed"]
+	case arming.Home:
+		raw = c.v["armHome"]
+	case arming.Away:
+		raw = c.v["armAway"]
+	case arming.Night:
+		raw = c.v["armNight"]
+	}
+	return arming.ParseAction(raw, mode)
+}
+
 func (c Config) alwaysRecord() bool {
 	return c.v["alwaysRecord"] == "true"
 }
 
+// ContinuousRecording if the main input should be continuously
+// recorded to fixed-length segments in the segment index.
+func (c Config) ContinuousRecording() bool {
+	return c.v["continuousRecording"] == "true"
+}
+
+// lowPriority if continuous recording should be paused
+// first when the storage disk is almost full.
+func (c Config) lowPriority() bool {
+	return c.v["recordingPriority"] == "low"
+}
+
+// skipStatic if continuous segments without any events should be
+// deleted. A static marker is kept in the index instead.
+func (c Config) skipStatic() bool {
+	return c.v["skipStaticSegments"] == "true"
+}
+
+// SegmentLength returns the length of continuous
+// recording segments in seconds. Empty for the default.
+func (c Config) SegmentLength() string {
+	return c.v["segmentLength"]
+}
+
+// EventRetention returns the number of days event recordings are kept.
+func (c Config) EventRetention() string {
+	return c.v["eventRetention"]
+}
+
+// ContinuousRetention returns the number of days
+// continuous recording segments are kept.
+func (c Config) ContinuousRetention() string {
+	return c.v["continuousRetention"]
+}
+
+// MaxDiskShare returns the maximum share of the disk space in percent.
+func (c Config) MaxDiskShare() string {
+	return c.v["maxDiskShare"]
+}
+
+// Retention setting errors.
+var (
+	ErrInvalidEventRetention      = errors.New("invalid event retention")
+	ErrInvalidContinuousRetention = errors.New("invalid continuous retention")
+	ErrInvalidMaxDiskShare        = errors.New("invalid max disk share")
+)
+
+// Retention returns the retention policy, empty values are unlimited.
+func (c Config) Retention() (storage.RetentionPolicy, error) {
+	const day = 24 * time.Hour
+	eventMaxAge, err := parseDuration(c.EventRetention(), day, ErrInvalidEventRetention)
+	if err != nil {
+		return storage.RetentionPolicy{}, err
+	}
+	continuousMaxAge, err := parseDuration(
+		c.ContinuousRetention(), day, ErrInvalidContinuousRetention)
+	if err != nil {
+		return storage.RetentionPolicy{}, err
+	}
+
+	var maxDiskShare float64
+	if c.MaxDiskShare() != "" {
+		maxDiskShare, err = strconv.ParseFloat(c.MaxDiskShare(), 64)
+		if err != nil || maxDiskShare < 0 || maxDiskShare > 100 {
+			return storage.RetentionPolicy{}, fmt.Errorf(
+				"%w: %q", ErrInvalidMaxDiskShare, c.MaxDiskShare())
+		}
+	}
+
+	return storage.RetentionPolicy{
+		EventMaxAge:      eventMaxAge,
+		ContinuousMaxAge: continuousMaxAge,
+		MaxDiskShare:     maxDiskShare,
+	}, nil
+}
+
 // TimestampOffset returns the timestamp offset.
 func (c Config) TimestampOffset() string {
 	return c.v["timestampOffset"]
@@ -116,3 +438,9 @@ func (c Config) LogLevel() string {
 func (c Config) Hwaccel() string {
 	return c.v["hwaccel"]
 }
+
+// HardwareAccel returns the hardware acceleration backend. The
+// "hwaccel" value is used as the FFmpeg option if the type is unset.
+func (c Config) HardwareAccel() (ffmpeg.HWAccel, error) {
+	return ffmpeg.NewHWAccel(c.v["hwaccelType"], c.v["hwaccelDevice"], c.Hwaccel())
+}
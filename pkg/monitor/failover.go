@@ -0,0 +1,206 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"nvr/pkg/log"
+)
+
+// InputSourceHook is called when a input process switches source.
+type InputSourceHook func(*InputProcess, InputSource)
+
+// InputSource active source of a input process.
+// The url isn't included since it may contain a password.
+type InputSource struct {
+	// Index of the source, zero is the primary input.
+	Index int `json:"index"`
+
+	// Number of sources.
+	Count int `json:"count"`
+
+	Reason string `json:"reason"`
+}
+
+// Consecutive crashes before switching to the next source.
+const failoverAttempts = 2
+
+const (
+	defaultFailbackInterval = 60 * time.Second
+	failbackProbeTimeout    = 3 * time.Second
+)
+
+// ErrInvalidFailbackInterval invalid fail-back interval.
+var ErrInvalidFailbackInterval = errors.New("invalid fail-back interval")
+
+// MainInputBackups returns the backup urls of the main input
+// in order of priority, separated by spaces or newlines.
+func (c Config) MainInputBackups() []string {
+	return strings.Fields(c.v["mainInputBackups"])
+}
+
+// SubInputBackups returns the backup urls of the sub input.
+func (c Config) SubInputBackups() []string {
+	return strings.Fields(c.v["subInputBackups"])
+}
+
+// FailbackInterval returns the number of seconds between
+// checking if the primary input is reachable again.
+func (c Config) FailbackInterval() string {
+	return c.v["failbackInterval"]
+}
+
+func (c Config) failbackInterval() (time.Duration, error) {
+	interval, err := parseDuration(c.FailbackInterval(), time.Second, ErrInvalidFailbackInterval)
+	if err != nil {
+		return 0, err
+	}
+	if interval == 0 {
+		return defaultFailbackInterval, nil
+	}
+	return interval, nil
+}
+
+// sources returns the primary input followed by the backups.
+// RTMP and push inputs are published to the server and have no backups.
+func (i *InputProcess) sources() []string {
+	if i.IsSubInput() {
+		if i.Config.publishedInput() {
+			return []string{i.Config.SubInput()}
+		}
+		return append([]string{i.Config.SubInput()}, i.Config.SubInputBackups()...)
+	}
+	if i.Config.publishedInput() {
+		return []string{i.Config.MainInput()}
+	}
+	return append([]string{i.Config.MainInput()}, i.Config.MainInputBackups()...)
+}
+
+func (i *InputProcess) input() string {
+	sources := i.sources()
+	if i.source < len(sources) {
+		return sources[i.source]
+	}
+	return sources[0]
+}
+
+// setSource switches the input to the source at index.
+func (i *InputProcess) setSource(index int, reason string) {
+	i.source = index
+	i.sourceAttempts = 0
+	source := InputSource{
+		Index:  index,
+		Count:  len(i.sources()),
+		Reason: reason,
+	}
+	if index == 0 {
+		i.logf(log.LevelInfo, "%v process: switched to primary source: %v",
+			i.ProcessName(), reason)
+	} else {
+		i.logf(log.LevelWarning, "%v process: switched to backup source %v/%v: %v",
+			i.ProcessName(), index, source.Count-1, reason)
+	}
+	if i.hooks.InputSource != nil {
+		i.hooks.InputSource(i, source)
+	}
+}
+
+// failover switches to the next source if the current source
+// crashed failoverAttempts times in a row. Returns true on switch.
+func (i *InputProcess) failover() bool {
+	count := len(i.sources())
+	if count < 2 {
+		return false
+	}
+	i.sourceAttempts++
+	if i.sourceAttempts < failoverAttempts {
+		return false
+	}
+	next := (i.source + 1) % count
+	if next == 0 {
+		i.setSource(next, "all backup sources failed")
+	} else {
+		i.setSource(next, "source failed")
+	}
+	return true
+}
+
+// waitForPrimary blocks until the primary source is reachable.
+// Returns false if the context is canceled first.
+func (i *InputProcess) waitForPrimary(ctx context.Context, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if i.probe(ctx, i.sources()[0]) == nil {
+			return ctx.Err() == nil
+		}
+	}
+}
+
+// probeSource checks if the host of the input accepts TCP connections.
+// Inputs that can't be probed are always considered reachable.
+func probeSource(ctx context.Context, input string) error {
+	address, ok := probeAddress(input)
+	if !ok {
+		return nil
+	}
+	ctx2, cancel := context.WithTimeout(ctx, failbackProbeTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx2, "tcp", address)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+var defaultProbePorts = map[string]string{
+	"rtsp":  "554",
+	"rtsps": "322",
+	"http":  "80",
+	"https": "443",
+	"rtmp":  "1935",
+}
+
+// probeAddress returns the TCP address of network inputs.
+func probeAddress(input string) (string, bool) {
+	u, err := url.Parse(input)
+	if err != nil || u.Hostname() == "" {
+		return "", false
+	}
+	defaultPort, exist := defaultProbePorts[strings.ToLower(u.Scheme)]
+	if !exist {
+		return "", false
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port), true
+}
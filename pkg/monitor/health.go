@@ -0,0 +1,200 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+	"strconv"
+	"time"
+)
+
+// InputState health state of a input process.
+type InputState string
+
+// Input states.
+const (
+	// The process is starting for the first time.
+	InputStateStarting InputState = "starting"
+
+	// The process has been running for inputStableDuration.
+	InputStateOnline InputState = "online"
+
+	// The process crashed and is being restarted.
+	InputStateReconnecting InputState = "reconnecting"
+
+	// The process has crashed inputOfflineAttempts times in a row.
+	InputStateOffline InputState = "offline"
+)
+
+const (
+	// How long the process must run before it's considered
+	// online and the reconnect delay is reset.
+	inputStableDuration = 15 * time.Second
+
+	// Consecutive crashes before the input is considered offline.
+	inputOfflineAttempts = 3
+)
+
+// InputHealth health of a input process.
+type InputHealth struct {
+	State InputState `json:"state"`
+
+	// Time of the state change. For the offline state
+	// this is the time of the first crash.
+	Since time.Time `json:"since"`
+
+	// Consecutive crashes.
+	Attempts int `json:"attempts"`
+
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Default reconnect values.
+const (
+	defaultReconnectMinDelay = 1 * time.Second
+	defaultReconnectMaxDelay = 60 * time.Second
+	defaultReconnectJitter   = 0.2
+)
+
+// Backoff errors.
+var (
+	ErrReconnectInvalidDelay  = errors.New("invalid reconnect delay")
+	ErrReconnectInvalidJitter = errors.New("reconnect jitter must be between 0 and 100")
+)
+
+// backoff calculates the delay before restarting a crashed process.
+// The delay doubles after every consecutive crash until maxDelay,
+// jitter randomly varies the delay to spread out the reconnects
+// when many cameras go offline at the same time.
+type backoff struct {
+	minDelay time.Duration
+	maxDelay time.Duration
+	jitter   float64
+
+	// Returns a number in [0.0,1.0).
+	random func() float64
+}
+
+func newBackoff(c Config) (backoff, error) {
+	b := backoff{
+		minDelay: defaultReconnectMinDelay,
+		maxDelay: defaultReconnectMaxDelay,
+		jitter:   defaultReconnectJitter,
+		random:   rand.Float64, //nolint:gosec
+	}
+
+	parseDelay := func(s string, d *time.Duration) error {
+		if s == "" {
+			return nil
+		}
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("%w: %q", ErrReconnectInvalidDelay, s)
+		}
+		*d = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+	if err := parseDelay(c.ReconnectMinDelay(), &b.minDelay); err != nil {
+		return b, err
+	}
+	if err := parseDelay(c.ReconnectMaxDelay(), &b.maxDelay); err != nil {
+		return b, err
+	}
+	if b.maxDelay < b.minDelay {
+		b.maxDelay = b.minDelay
+	}
+
+	if jitter := c.ReconnectJitter(); jitter != "" {
+		percent, err := strconv.ParseFloat(jitter, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return b, fmt.Errorf("%w: %q", ErrReconnectInvalidJitter, jitter)
+		}
+		b.jitter = percent / 100
+	}
+	return b, nil
+}
+
+// delay returns the delay before the next attempt, attempt starts at 1.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.minDelay
+	for i := 1; i < attempt && d < b.maxDelay; i++ {
+		d *= 2
+	}
+	if d > b.maxDelay {
+		d = b.maxDelay
+	}
+
+	// Random value between -jitter and +jitter.
+	variation := b.jitter * (2*b.random() - 1)
+	return time.Duration(float64(d) * (1 + variation))
+}
+
+// component returns the health component of the input.
+func (h InputHealth) component() health.Component {
+	switch h.State {
+	case InputStateOnline:
+		return health.Component{Status: health.StatusOK}
+	case InputStateReconnecting:
+		return health.Component{
+			Status: health.StatusDegraded,
+			Reason: fmt.Sprintf("reconnecting after %v crashes: %v", h.Attempts, h.LastError),
+		}
+	case InputStateOffline:
+		return health.Component{
+			Status: health.StatusFailed,
+			Reason: fmt.Sprintf("offline since %v: %v",
+				h.Since.Format(time.RFC3339), h.LastError),
+		}
+	}
+	return health.Component{Status: health.StatusDegraded, Reason: "starting"}
+}
+
+// Health returns the current health of the input process.
+func (i *InputProcess) Health() InputHealth {
+	i.healthMu.Lock()
+	defer i.healthMu.Unlock()
+	return i.health
+}
+
+// setHealth updates the health and calls the
+// state hook and logs if the state changed.
+func (i *InputProcess) setHealth(health InputHealth) {
+	i.healthMu.Lock()
+	prevState := i.health.State
+	i.health = health
+	i.healthMu.Unlock()
+
+	if health.State == prevState {
+		return
+	}
+
+	switch health.State {
+	case InputStateOffline:
+		i.logf(log.LevelWarning, "%v process: offline since %v",
+			i.ProcessName(), health.Since.Format("2006-01-02 15:04:05"))
+	case InputStateStarting:
+	default:
+		i.logf(log.LevelInfo, "%v process: %v", i.ProcessName(), health.State)
+	}
+
+	if i.hooks.InputState != nil {
+		i.hooks.InputState(i, health)
+	}
+}
@@ -16,12 +16,16 @@
 package monitor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/url"
+	"nvr/pkg/arming"
 	"nvr/pkg/ffmpeg"
+	"nvr/pkg/health"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/video"
@@ -29,6 +33,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,17 +55,23 @@ type RecSaveHook func(*Recorder, *string)
 // RecSavedHook is called after recording have been saved successfully.
 type RecSavedHook func(*Recorder, string, storage.RecordingData)
 
+// InputStateHook is called when the health state of a input process changes.
+type InputStateHook func(*InputProcess, InputHealth)
+
 // MigationHook is called when each monitor config is loaded.
 type MigationHook func(RawConfig) error
 
 // Hooks monitor hooks.
 type Hooks struct {
-	Start      StartHook
-	StartInput StartInputHook
-	Event      EventHook
-	RecSave    RecSaveHook
-	RecSaved   RecSavedHook
-	Migrate    MigationHook
+	Start       StartHook
+	StartInput  StartInputHook
+	Event       EventHook
+	RecSave     RecSaveHook
+	RecSaved    RecSavedHook
+	Migrate     MigationHook
+	InputState  InputStateHook
+	InputSource InputSourceHook
+	Validate    ValidateHook
 }
 
 // Manager for the monitors.
@@ -68,17 +80,30 @@ type Manager struct {
 	runningMonitors monitors
 
 	env         storage.ConfigEnv
+	index       *storage.Index
+	ledger      *storage.Ledger
+	events      *storage.EventStore
+	disk        *storage.DiskMonitor
+	arming      *arming.Manager
 	logger      log.ILogger
 	videoServer *video.Server
 	path        string
 	hooks       Hooks
 	mu          sync.Mutex
+
+	// Set by Shutdown, monitors are not started again.
+	stopped bool
 }
 
 // NewManager return new monitor manager.
 func NewManager(
 	configPath string,
 	env storage.ConfigEnv,
+	index *storage.Index,
+	ledger *storage.Ledger,
+	events *storage.EventStore,
+	disk *storage.DiskMonitor,
+	arming *arming.Manager,
 	logger log.ILogger,
 	videoServer *video.Server,
 	hooks *Hooks,
@@ -87,8 +112,32 @@ func NewManager(
 		return nil, fmt.Errorf("create monitors directory: %w", err)
 	}
 
-	configFS := os.DirFS(configPath)
-	configFiles, err := readConfigs(configFS)
+	rawConfigs, err := loadConfigs(configPath, hooks.Migrate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{
+		rawConfigs:      rawConfigs,
+		runningMonitors: make(monitors),
+
+		env:         env,
+		index:       index,
+		ledger:      ledger,
+		events:      events,
+		disk:        disk,
+		arming:      arming,
+		logger:      logger,
+		videoServer: videoServer,
+		path:        configPath,
+		hooks:       *hooks,
+	}, nil
+}
+
+// loadConfigs reads and migrates the config files in the directory.
+// Migrated configs are only written back if they changed.
+func loadConfigs(configPath string, migrate MigationHook) (RawConfigs, error) {
+	configFiles, err := readConfigs(os.DirFS(configPath))
 	if err != nil {
 		return nil, fmt.Errorf("read config files: %w", err)
 	}
@@ -99,32 +148,22 @@ func NewManager(
 		if err := json.Unmarshal(file, &rawConf); err != nil {
 			return nil, fmt.Errorf("unmarshal config: %w: %v", err, string(file))
 		}
-		if err := hooks.Migrate(rawConf); err != nil {
+		if err := migrate(rawConf); err != nil {
 			return nil, fmt.Errorf("migration failed: %w", err)
 		}
 
 		id := rawConf["id"]
-		configPath := monitorConfigPath(configPath, id)
-
 		jsonConf, _ := json.MarshalIndent(rawConf, "", "    ")
-		err := os.WriteFile(configPath, jsonConf, 0o600)
-		if err != nil {
-			return nil, fmt.Errorf("write migrated config: %w", err)
+		if !bytes.Equal(jsonConf, file) {
+			err := os.WriteFile(monitorConfigPath(configPath, id), jsonConf, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("write migrated config: %w", err)
+			}
 		}
 
 		rawConfigs[id] = rawConf
 	}
-
-	return &Manager{
-		rawConfigs:      rawConfigs,
-		runningMonitors: make(monitors),
-
-		env:         env,
-		logger:      logger,
-		videoServer: videoServer,
-		path:        configPath,
-		hooks:       *hooks,
-	}, nil
+	return rawConfigs, nil
 }
 
 func readConfigs(fileSystem fs.FS) ([][]byte, error) {
@@ -148,7 +187,17 @@ func readConfigs(fileSystem fs.FS) ([][]byte, error) {
 }
 
 func (m *Manager) unsafeStartMonitor(id string) {
-	rawConf := m.rawConfigs[id]
+	if m.stopped {
+		return
+	}
+	rawConf, err := resolveSecrets(m.rawConfigs[id])
+	if err != nil {
+		// The monitor is added without starting it, like a disabled monitor.
+		monitor := m.newMonitor(NewConfig(m.rawConfigs[id]))
+		monitor.logf(log.LevelError, "could not resolve secrets: %v", err)
+		m.runningMonitors[id] = monitor
+		return
+	}
 	monitor := m.newMonitor(NewConfig(rawConf))
 	monitor.start()
 	m.runningMonitors[id] = monitor
@@ -177,6 +226,44 @@ func (m *Manager) StopMonitors() {
 	m.mu.Unlock()
 }
 
+// ErrShutdownTimeout monitors did not stop before the deadline.
+var ErrShutdownTimeout = errors.New("monitors did not stop in time")
+
+// Shutdown stops all monitors in parallel and waits for the open
+// recordings to be finalized until the context is canceled.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+
+	stopped := make(chan string, len(m.runningMonitors))
+	stopping := make(map[string]struct{}, len(m.runningMonitors))
+	for id, monitor := range m.runningMonitors {
+		id, monitor := id, monitor
+		stopping[id] = struct{}{}
+		go func() {
+			monitor.stop()
+			stopped <- id
+		}()
+		delete(m.runningMonitors, id)
+	}
+
+	for len(stopping) != 0 {
+		select {
+		case id := <-stopped:
+			delete(stopping, id)
+		case <-ctx.Done():
+			ids := make([]string, 0, len(stopping))
+			for id := range stopping {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			return fmt.Errorf("%w: %v", ErrShutdownTimeout, strings.Join(ids, ", "))
+		}
+	}
+	return nil
+}
+
 // ErrMonitorNotExist monitor does not exist.
 var ErrMonitorNotExist = errors.New("monitor does not exist")
 
@@ -196,6 +283,58 @@ func (m *Manager) RestartMonitor(id string) error {
 	return nil
 }
 
+// MonitorEnable enables or disables the monitor,
+// the config is saved and the monitor is restarted.
+func (m *Manager) MonitorEnable(id string, enable bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldConf, exist := m.rawConfigs[id]
+	if !exist {
+		return ErrMonitorNotExist
+	}
+	rawConf := make(RawConfig, len(oldConf))
+	for k, v := range oldConf {
+		rawConf[k] = v
+	}
+	rawConf["enable"] = strconv.FormatBool(enable)
+
+	configJSON, err := json.MarshalIndent(rawConf, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal config file: %w", err)
+	}
+	err = os.WriteFile(m.configPath(id), configJSON, 0o600)
+	if err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	m.rawConfigs[id] = rawConf
+
+	if _, exist := m.runningMonitors[id]; exist {
+		m.unsafeStopMonitor(id)
+	}
+	m.unsafeStartMonitor(id)
+	return nil
+}
+
+// ErrMonitorNotRunning monitor is disabled.
+var ErrMonitorNotRunning = errors.New("monitor is not running")
+
+// TriggerEvent sends a event to a running monitor. A recording
+// is started or extended the same way as for detections.
+func (m *Manager) TriggerEvent(id string, event storage.Event) error {
+	m.mu.Lock()
+	monitor, exist := m.runningMonitors[id]
+	m.mu.Unlock()
+
+	if !exist {
+		return ErrMonitorNotExist
+	}
+	if monitor.ctx == nil {
+		return ErrMonitorNotRunning
+	}
+	return monitor.sendEvent(event)
+}
+
 // MonitorSet sets config for specified monitor.
 // Changes are not applied until the montior restarts.
 func (m *Manager) MonitorSet(id string, rawConf RawConfig) error {
@@ -265,17 +404,44 @@ func (m *Manager) MonitorsInfo() RawConfigs {
 			subInputEnabled = "true"
 		}
 
-		configs[c.ID()] = RawConfig{
+		info := RawConfig{
 			"id":              c.ID(),
 			"name":            c.Name(),
 			"enable":          enable,
 			"audioEnabled":    audioEnabled,
 			"subInputEnabled": subInputEnabled,
 		}
+		if monitor, running := m.runningMonitors[c.ID()]; running && monitor.mainInput != nil {
+			health := monitor.mainInput.Health()
+			if health.State != "" {
+				info["state"] = string(health.State)
+				info["stateSince"] = health.Since.Format(time.RFC3339)
+			}
+		}
+		configs[c.ID()] = info
 	}
 	return configs
 }
 
+// HealthCheck returns the health of the inputs of the
+// enabled monitors, "monitor/id" and "monitor/id_sub".
+func (m *Manager) HealthCheck() map[string]health.Component {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	components := make(map[string]health.Component)
+	for id, monitor := range m.runningMonitors {
+		if !monitor.Config.enabled() {
+			continue
+		}
+		components["monitor/"+id] = monitor.mainInput.Health().component()
+		if monitor.Config.SubInputEnabled() {
+			components["monitor/"+id+"_sub"] = monitor.subInput.Health().component()
+		}
+	}
+	return components
+}
+
 func (m *Manager) configPath(id string) string {
 	return monitorConfigPath(m.path, id)
 }
@@ -296,6 +462,59 @@ func (m *Manager) MonitorConfigs() RawConfigs {
 	return configs
 }
 
+// RetentionPolicies returns the retention policies of all monitors.
+// Monitors with invalid retention settings are logged and skipped.
+func (m *Manager) RetentionPolicies() map[string]storage.RetentionPolicy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policies := make(map[string]storage.RetentionPolicy)
+	for id, rawConf := range m.rawConfigs {
+		policy, err := NewConfig(rawConf).Retention()
+		if err != nil {
+			m.logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: id,
+				Msg:       fmt.Sprintf("retention policy: %v", err),
+			})
+			continue
+		}
+		policies[id] = policy
+	}
+	return policies
+}
+
+// Backchannel errors.
+var (
+	ErrBackchannelDisabled = errors.New("backchannel is disabled")
+	ErrBackchannelNotRTSP  = errors.New("backchannel requires a rtsp main input")
+)
+
+// BackchannelURL returns the main input url of a
+// monitor with the audio backchannel enabled.
+func (m *Manager) BackchannelURL(id string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rawConf, exist := m.rawConfigs[id]
+	if !exist {
+		return "", ErrMonitorNotExist
+	}
+	rawConf, err := resolveSecrets(rawConf)
+	if err != nil {
+		return "", err
+	}
+	c := NewConfig(rawConf)
+	if !c.BackchannelEnabled() {
+		return "", ErrBackchannelDisabled
+	}
+	if !strings.HasPrefix(strings.ToLower(c.MainInput()), "rtsp://") {
+		return "", ErrBackchannelNotRTSP
+	}
+	return c.MainInput(), nil
+}
+
 // monitors map.
 type monitors map[string]*Monitor
 
@@ -307,10 +526,17 @@ type Monitor struct {
 	Env         storage.ConfigEnv
 	Logger      log.ILogger
 	videoServer *video.Server
+	index       *storage.Index
+	ledger      *storage.Ledger
+	events      *storage.EventStore
+	disk        *storage.DiskMonitor
+	arming      *arming.Manager
 
 	mainInput *InputProcess
 	subInput  *InputProcess
 	recorder  *Recorder
+	segments  *segmentRecorder
+	snapshots *snapshotCache
 	Recorder
 	hooks      Hooks
 	NewProcess ffmpeg.NewProcessFunc
@@ -340,6 +566,11 @@ func (m *Manager) newMonitor(config Config) *Monitor {
 		Env:         m.env,
 		Logger:      m.logger,
 		videoServer: m.videoServer,
+		index:       m.index,
+		ledger:      m.ledger,
+		events:      m.events,
+		disk:        m.disk,
+		arming:      m.arming,
 
 		hooks:      m.hooks,
 		NewProcess: ffmpeg.NewProcess,
@@ -348,6 +579,8 @@ func (m *Manager) newMonitor(config Config) *Monitor {
 	monitor.mainInput = newInputProcess(monitor, false)
 	monitor.subInput = newInputProcess(monitor, true)
 	monitor.recorder = newRecorder(monitor)
+	monitor.segments = newSegmentRecorder(monitor)
+	monitor.snapshots = newSnapshotCache()
 
 	return monitor
 }
@@ -394,16 +627,37 @@ func (m *Monitor) start() {
 
 	m.WG.Add(1)
 	go m.recorder.start(m.ctx)
+
+	if m.Config.ContinuousRecording() && m.index != nil {
+		m.WG.Add(1)
+		go m.segments.start(m.ctx)
+	}
 }
 
 // SendEventFunc send event signature.
 type SendEventFunc func(storage.Event) error
 
-// SendEvent sends event to recorder.
+// SendEvent sends event to recorder. Detections
+// are ignored if the monitor is disarmed.
 func (m *Monitor) SendEvent(event storage.Event) error {
+	if len(event.Detections) != 0 && m.ArmAction() == arming.ActionIgnore {
+		return nil
+	}
+	return m.sendEvent(event)
+}
+
+// ArmAction returns the action of the current arming mode.
+func (m *Monitor) ArmAction() arming.Action {
+	return m.Config.ArmAction(m.arming.MonitorMode(m.Config.ID()))
+}
+
+func (m *Monitor) sendEvent(event storage.Event) error {
 	if m.ctx.Err() != nil {
 		return context.Canceled
 	}
+	if m.segments != nil {
+		m.segments.onEvent(event.Time)
+	}
 	return m.recorder.sendEvent(event)
 }
 
@@ -429,16 +683,34 @@ type InputProcess struct {
 	WG        *sync.WaitGroup
 	SendEvent SendEventFunc
 
+	// Input url rewritten to use the RTSPS proxy.
+	rtspsProxyInput string
+
+	health   InputHealth
+	healthMu sync.Mutex
+
+	// Set if the UDP or multicast transport failed. TCP
+	// is used until the monitor is restarted.
+	tcpFallback bool
+
+	// Index of the active source and the number of
+	// consecutive crashes of that source.
+	source         int
+	sourceAttempts int
+
 	logf               logFunc
 	newVideoServerPath newVideoServerPathFunc
 	runInputProcess    runInputProcessFunc
 	newProcess         ffmpeg.NewProcessFunc
+	probe              probeFunc
 }
 
 type newVideoServerPathFunc func(context.Context, string, video.PathConf) (*video.ServerPath, error)
 
 type runInputProcessFunc func(context.Context, *InputProcess) error
 
+type probeFunc func(context.Context, string) error
+
 func newInputProcess(m *Monitor, isSubInput bool) *InputProcess {
 	i := &InputProcess{
 		Config:     m.Config,
@@ -454,6 +726,7 @@ func newInputProcess(m *Monitor, isSubInput bool) *InputProcess {
 		newVideoServerPath: m.videoServer.NewPath,
 		runInputProcess:    runInputProcess,
 		newProcess:         ffmpeg.NewProcess,
+		probe:              probeSource,
 	}
 
 	return i
@@ -511,6 +784,12 @@ func (i *InputProcess) HLSMuxer() (video.IHLSMuxer, error) {
 	return i.serverPath.HLSMuxer()
 }
 
+// IsDetectInput if detectors should read this input. Detectors
+// should run on a single input to avoid duplicate events.
+func (i *InputProcess) IsDetectInput() bool {
+	return i.IsSubInput() == i.Config.DetectSubInput()
+}
+
 // ProcessName name of process "main" or "sub".
 func (i *InputProcess) ProcessName() string {
 	if i.isSubInput {
@@ -519,13 +798,6 @@ func (i *InputProcess) ProcessName() string {
 	return "main"
 }
 
-func (i *InputProcess) input() string {
-	if i.IsSubInput() {
-		return i.Config.SubInput()
-	}
-	return i.Config.MainInput()
-}
-
 func (i *InputProcess) rtspPathName() string {
 	if i.isSubInput {
 		return i.Config.ID() + "_sub"
@@ -539,6 +811,20 @@ func (i *InputProcess) Cancel() {
 }
 
 func (i *InputProcess) start(ctx context.Context) {
+	backoff, err := newBackoff(i.Config)
+	if err != nil {
+		i.logf(log.LevelError, "%v process: %v, using defaults", i.ProcessName(), err)
+	}
+
+	failbackInterval, err := i.Config.failbackInterval()
+	if err != nil {
+		i.logf(log.LevelError, "%v process: %v, using default", i.ProcessName(), err)
+		failbackInterval = defaultFailbackInterval
+	}
+
+	health := InputHealth{State: InputStateStarting, Since: time.Now()}
+	i.setHealth(health)
+
 	for {
 		if ctx.Err() != nil {
 			i.logf(log.LevelInfo, "%v process: stopped", i.ProcessName())
@@ -546,14 +832,72 @@ func (i *InputProcess) start(ctx context.Context) {
 			return
 		}
 
-		if err := i.runInputProcess(ctx, i); err != nil {
+		// The process is restarted on the primary source
+		// once it's reachable while running on a backup.
+		runCtx, cancelRun := context.WithCancel(ctx)
+		failback := make(chan bool, 1)
+		if i.source != 0 {
+			go func() {
+				reachable := i.waitForPrimary(runCtx, failbackInterval)
+				failback <- reachable
+				if reachable {
+					cancelRun()
+				}
+			}()
+		} else {
+			failback <- false
+		}
+
+		// The process is considered online if it doesn't crash
+		// within inputStableDuration of being started.
+		online := time.AfterFunc(inputStableDuration, func() {
+			i.setHealth(InputHealth{State: InputStateOnline, Since: time.Now()})
+		})
+		startTime := time.Now()
+		err := i.runInputProcess(runCtx, i)
+		online.Stop()
+		cancelRun()
+
+		if ctx.Err() != nil {
+			continue
+		}
+		if <-failback {
+			i.setSource(0, "primary source is reachable")
+			continue
+		}
+		if err != nil {
 			i.logf(log.LevelError, "%v process: crashed: %v", i.ProcessName(), err)
-			select {
-			case <-ctx.Done():
-			case <-time.After(1 * time.Second):
-			}
+		}
+
+		now := time.Now()
+		if now.Sub(startTime) >= inputStableDuration {
+			// Reset the backoff.
+			health.Attempts = 0
+			i.sourceAttempts = 0
+		}
+		if health.Attempts == 0 {
+			health.Since = now
+		}
+		health.Attempts++
+		health.State = InputStateReconnecting
+		if health.Attempts >= inputOfflineAttempts {
+			health.State = InputStateOffline
+		}
+		health.LastError = ""
+		if err != nil {
+			health.LastError = err.Error()
+		}
+		i.setHealth(health)
+
+		// The next source is started without delay.
+		if i.failover() {
 			continue
 		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff.delay(health.Attempts)):
+		}
 	}
 }
 
@@ -562,15 +906,63 @@ func runInputProcess(ctx context.Context, i *InputProcess) error {
 	i.cancel = cancel2
 	defer cancel2()
 
-	pathConf := video.PathConf{MonitorID: i.Config.ID(), IsSub: i.IsSubInput()}
+	partDuration, err := i.Config.hlsPartDuration()
+	if err != nil {
+		return err
+	}
+	pathConf := video.PathConf{
+		MonitorID:       i.Config.ID(),
+		IsSub:           i.IsSubInput(),
+		HLSPartDuration: partDuration,
+		AudioLanguages:  i.audioLanguages(),
+	}
+	if i.Config.rtmpInput() {
+		pathConf.RTMPKey = i.input()
+	}
+	if i.Config.pushInput() {
+		pathConf.PushKey = i.input()
+	}
+	if !i.IsSubInput() {
+		// Event recordings are read from the main input playlist.
+		preEventBuffer, err := i.Config.preEventBuffer()
+		if err != nil {
+			return err
+		}
+		pathConf.HLSBufferDuration = preEventBuffer
+	}
 	serverPath, err := i.newVideoServerPath(processCTX, i.rtspPathName(), pathConf)
 	if err != nil {
 		return fmt.Errorf("add path to RTSP server: %w", err)
 	}
 	i.serverPath = *serverPath
 
+	if i.Config.rtmpInput() {
+		// The stream is published by the RTMP client.
+		i.logf(log.LevelInfo, "%v process: waiting for RTMP publisher", i.ProcessName())
+		<-processCTX.Done()
+		return nil
+	}
+	if i.Config.pushInput() {
+		// The stream is uploaded by the HTTP push client.
+		i.logf(log.LevelInfo, "%v process: waiting for HTTP push publisher", i.ProcessName())
+		<-processCTX.Done()
+		return nil
+	}
+
+	i.rtspsProxyInput = ""
+	if isRTSPS(i.input()) {
+		i.rtspsProxyInput, err = i.startRTSPSProxy(processCTX)
+		if err != nil {
+			return fmt.Errorf("rtsps: %w", err)
+		}
+	}
+
 	logLevel := log.FFmpegLevel(i.Config.LogLevel())
-	args := ffmpeg.ParseArgs(i.generateArgs())
+	rawArgs, err := i.generateArgs()
+	if err != nil {
+		return fmt.Errorf("generate args: %w", err)
+	}
+	args := ffmpeg.ParseArgs(rawArgs)
 
 	i.hooks.StartInput(processCTX, i, &args)
 
@@ -589,13 +981,56 @@ func runInputProcess(ctx context.Context, i *InputProcess) error {
 
 	err = process.Start(processCTX) // Blocks until process exits.
 	if err != nil {
+		if i.udpTransport() && !i.tcpFallback && processCTX.Err() == nil {
+			i.tcpFallback = true
+			i.logf(log.LevelWarning, "%v process: %v transport failed, falling back to tcp",
+				i.ProcessName(), i.Config.RTSPTransport())
+		}
 		return fmt.Errorf("crashed: %w", err)
 	}
 
 	return nil
 }
 
-func (i *InputProcess) generateArgs() string {
+// rtspTransport returns the value of the FFmpeg "-rtsp_transport" option.
+// Empty if the input isn't RTSP or the option is set in the input options.
+func (i *InputProcess) rtspTransport() string {
+	if !strings.HasPrefix(strings.ToLower(i.input()), "rtsp://") ||
+		strings.Contains(i.Config.InputOpts(), "-rtsp_transport") {
+		return ""
+	}
+
+	switch i.Config.RTSPTransport() {
+	case "tcp":
+		return "tcp"
+	case "udp":
+		if i.tcpFallback {
+			return "tcp"
+		}
+		return "udp"
+	case "multicast":
+		if i.tcpFallback {
+			return "tcp"
+		}
+		return "udp_multicast"
+	}
+	return ""
+}
+
+func (i *InputProcess) udpTransport() bool {
+	transport := i.rtspTransport()
+	return transport == "udp" || transport == "udp_multicast"
+}
+
+// audioLanguages returns the audio languages if audio is enabled.
+func (i *InputProcess) audioLanguages() []string {
+	if !i.Config.audioEnabled() {
+		return nil
+	}
+	return i.Config.AudioLanguages()
+}
+
+func (i *InputProcess) generateArgs() (string, error) {
 	// OUTPUT
 	// -threads 1 -loglevel error -hwaccel x -i rtsp://x -c:a aac -c:v libx264
 	// -f rtsp -rtsp_transport tcp rtsp://127.0.0.1:2021/test
@@ -603,24 +1038,249 @@ func (i *InputProcess) generateArgs() string {
 	c := i.Config
 	var args string
 
+	hw, err := c.HardwareAccel()
+	if err != nil {
+		return "", fmt.Errorf("hwaccel: %w", err)
+	}
+	encoder, hwEncoder, err := i.videoEncoder(hw)
+	if err != nil {
+		return "", err
+	}
+
 	args += "-threads 1 -loglevel " + c.LogLevel()
-	if c.Hwaccel() != "" {
-		args += " -hwaccel " + c.Hwaccel()
+	if hwArgs := inputHWAccelArgs(hw, encoder, hwEncoder); hwArgs != "" {
+		args += " " + hwArgs
 	}
 
 	if c.InputOpts() != "" {
 		args += " " + c.InputOpts()
 	}
-	args += " -i " + i.input()
+	if transport := i.rtspTransport(); transport != "" {
+		args += " -rtsp_transport " + transport
+	}
+	// MJPEG frames don't have timestamps.
+	if c.mjpegInput() && !strings.Contains(c.InputOpts(), "-use_wallclock_as_timestamps") {
+		args += " -use_wallclock_as_timestamps 1"
+	}
+	input := i.input()
+	if i.rtspsProxyInput != "" {
+		input = i.rtspsProxyInput
+		// The proxy only supports interleaved TCP.
+		if !strings.Contains(c.InputOpts(), "-rtsp_transport") {
+			args += " -rtsp_transport tcp"
+		}
+	}
+	if c.srtInput() {
+		input, err = srtURL(input, c.SRTMode(), c.SRTPassphrase(), c.SRTLatency())
+		if err != nil {
+			return "", fmt.Errorf("srt: %w", err)
+		}
+	}
+	if c.fileInput() {
+		if err := checkFileInput(input); err != nil {
+			return "", fmt.Errorf("file: %w", err)
+		}
+		// Read at native frame rate and loop forever.
+		args += " -re -stream_loop -1"
+	}
+	if device, ok := v4l2Device(input); ok {
+		v4l2Args, err := v4l2InputArgs(device, c.V4L2InputFormat(), c.V4L2Resolution(), c.V4L2FrameRate())
+		if err != nil {
+			return "", fmt.Errorf("v4l2: %w", err)
+		}
+		args += v4l2Args
+		input = device
+	}
+	args += " -i " + input
 
-	if c.audioEnabled() {
+	_, isV4L2 := v4l2Device(i.input())
+	audio := c.audioEnabled() && !c.mjpegInput() && !isV4L2
+	if c.audioOnly() && !audio {
+		return "", ErrAudioOnlyNoAudio
+	}
+	if audio {
+		if langs := i.audioLanguages(); len(langs) != 0 && !c.audioOnly() {
+			args += " -map 0:v:0"
+			for n, lang := range langs {
+				index := strconv.Itoa(n)
+				args += " -map 0:a:" + index + "?"
+				args += " -metadata:s:a:" + index + " language=" + lang
+			}
+		}
 		args += " -c:a " + c.AudioEncoder()
 	} else {
 		args += " -an" // Skip audio.
 	}
 	//리스트리밍 항목, 필요없을 듯
-	args += " -c:v " + c.VideoEncoder()
+	if c.audioOnly() {
+		args += " -vn" // Skip video.
+	} else {
+		args += " -c:v " + encoder
+	}
 	args += " -f rtsp -rtsp_transport " + i.RTSPprotocol() + " " + i.RTSPaddress()
 	//args = ""
-	return args
+	return args, nil
+}
+
+// ErrAudioOnlyNoAudio audio only monitor without an audio encoder.
+var ErrAudioOnlyNoAudio = errors.New("audio only monitor requires an audio encoder")
+
+// Forces a keyframe every 2 seconds for the HLS segments.
+const forceKeyFrames = "-force_key_frames expr:gte(t,n_forced*2)"
+
+// Encoder used for inputs that must be transcoded if the video
+// encoder is "copy" and there isn't a hardware encoder. The pixel
+// format is converted since browsers can't decode 4:2:2 H264.
+const transcodeVideoEncoder = "libx264 -preset veryfast -tune zerolatency" +
+	" -pix_fmt yuv420p " + forceKeyFrames
+
+// ErrNoHWEncoder the "hardware" video encoder requires a hardware type.
+var ErrNoHWEncoder = errors.New("hardware encoder requires a hardware acceleration type")
+
+// videoEncoder returns the video encoder and if it's the hardware encoder.
+// MJPEG and raw V4L2 inputs must be transcoded since the RTSP server only
+// supports H264, the hardware encoder is preferred if it's available.
+func (i *InputProcess) videoEncoder(hw ffmpeg.HWAccel) (string, bool, error) {
+	encoder := i.Config.VideoEncoder()
+	hwEncoder := hw.H264Encoder()
+	if encoder == "hardware" {
+		if hwEncoder == "" {
+			return "", false, ErrNoHWEncoder
+		}
+		return hwEncoder + " " + forceKeyFrames, true, nil
+	}
+	if encoder != "" && encoder != "copy" {
+		return encoder, false, nil
+	}
+
+	_, isV4L2 := v4l2Device(i.input())
+	isV4L2Raw := isV4L2 && i.Config.V4L2InputFormat() != "h264"
+	if !i.Config.mjpegInput() && !isV4L2Raw {
+		return encoder, false, nil
+	}
+	if hwEncoder != "" {
+		return hwEncoder + " " + forceKeyFrames, true, nil
+	}
+	return transcodeVideoEncoder, false, nil
+}
+
+// inputHWAccelArgs returns the hardware acceleration input options.
+// The legacy "-hwaccel" option is always used, hardware decoding
+// is otherwise only used when the input is transcoded.
+func inputHWAccelArgs(hw ffmpeg.HWAccel, encoder string, hwEncoder bool) string {
+	switch {
+	case hw.Type == ffmpeg.HWAccelNone:
+		return hw.DecodeArgs()
+	case hwEncoder:
+		return hw.TranscodeArgs()
+	case encoder != "" && encoder != "copy":
+		return hw.DecodeArgs()
+	}
+	return ""
+}
+
+// ErrFileInputNotAbs file input path isn't absolute.
+var ErrFileInputNotAbs = errors.New("path must be absolute")
+
+func checkFileInput(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%w: %q", ErrFileInputNotAbs, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %q", fs.ErrInvalid, path)
+	}
+	return nil
+}
+
+// V4L2 errors.
+var (
+	ErrV4L2InvalidDevice     = errors.New("device must be in /dev/")
+	ErrV4L2InvalidResolution = errors.New("resolution must be WIDTHxHEIGHT")
+	ErrV4L2InvalidFrameRate  = errors.New("invalid frame rate")
+)
+
+// v4l2Device returns the device path of a "v4l2:/dev/video0" input.
+func v4l2Device(input string) (string, bool) {
+	if !strings.HasPrefix(input, "v4l2:") {
+		return "", false
+	}
+	return strings.TrimPrefix(input, "v4l2:"), true
+}
+
+// v4l2InputArgs returns the FFmpeg input options for a V4L2 device.
+func v4l2InputArgs(device string, format string, resolution string, frameRate string) (string, error) {
+	if !strings.HasPrefix(device, "/dev/") || strings.ContainsAny(device, " \t") {
+		return "", fmt.Errorf("%w: %q", ErrV4L2InvalidDevice, device)
+	}
+
+	args := " -f v4l2"
+	if format != "" {
+		args += " -input_format " + format
+	}
+	if resolution != "" {
+		var width, height int
+		_, err := fmt.Sscanf(resolution, "%dx%d", &width, &height)
+		if err != nil || width <= 0 || height <= 0 {
+			return "", fmt.Errorf("%w: %q", ErrV4L2InvalidResolution, resolution)
+		}
+		args += " -video_size " + strconv.Itoa(width) + "x" + strconv.Itoa(height)
+	}
+	if frameRate != "" {
+		fps, err := strconv.ParseFloat(frameRate, 64)
+		if err != nil || fps <= 0 {
+			return "", fmt.Errorf("%w: %q", ErrV4L2InvalidFrameRate, frameRate)
+		}
+		args += " -framerate " + frameRate
+	}
+	return args, nil
+}
+
+// SRT errors.
+var (
+	ErrSRTInvalidURL       = errors.New("invalid url")
+	ErrSRTInvalidMode      = errors.New("mode must be caller or listener")
+	ErrSRTPassphraseLength = errors.New("passphrase must be between 10 and 79 characters")
+	ErrSRTInvalidLatency   = errors.New("invalid latency")
+)
+
+// srtURL adds the mode, passphrase and latency to a SRT url.
+// The latency is in milliseconds. Options that are already
+// present in the url are overwritten if set.
+func srtURL(rawURL string, mode string, passphrase string, latency string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "srt" || u.Host == "" {
+		return "", fmt.Errorf("%w: %s", ErrSRTInvalidURL, rawURL)
+	}
+	query := u.Query()
+
+	switch mode {
+	case "":
+	case "caller", "listener":
+		query.Set("mode", mode)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrSRTInvalidMode, mode)
+	}
+
+	if passphrase != "" {
+		if len(passphrase) < 10 || len(passphrase) > 79 {
+			return "", ErrSRTPassphraseLength
+		}
+		query.Set("passphrase", passphrase)
+	}
+
+	if latency != "" {
+		ms, err := strconv.Atoi(latency)
+		if err != nil || ms < 0 {
+			return "", fmt.Errorf("%w: %s", ErrSRTInvalidLatency, latency)
+		}
+		// FFmpeg uses microseconds.
+		query.Set("latency", strconv.Itoa(ms*1000))
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
 }
@@ -27,7 +27,10 @@ import (
 	"testing"
 	"time"
 
+	"nvr/pkg/arming"
+	"nvr/pkg/ffmpeg"
 	"nvr/pkg/ffmpeg/ffmock"
+	"nvr/pkg/health"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/video"
@@ -82,6 +85,11 @@ func newTestManager(t *testing.T) (string, *Manager) {
 	manager, err := NewManager(
 		configDir,
 		storage.ConfigEnv{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 		log.NewDummyLogger(),
 		nil,
 		&Hooks{Migrate: func(RawConfig) error { return nil }},
@@ -127,6 +135,11 @@ func TestNewManager(t *testing.T) {
 		manager, err := NewManager(
 			configDir,
 			storage.ConfigEnv{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 			&log.Logger{},
 			&video.Server{},
 			&Hooks{Migrate: migrate},
@@ -146,13 +159,18 @@ func TestNewManager(t *testing.T) {
 		require.Equal(t, expected2, string(actual2))
 	})
 	t.Run("mkDirErr", func(t *testing.T) {
-		_, err := NewManager("/dev/null/nil", storage.ConfigEnv{}, nil, nil, nil)
+		_, err := NewManager("/dev/null/nil", storage.ConfigEnv{}, nil, nil, nil, nil, nil, nil, nil, nil)
 		require.Error(t, err)
 	})
 	t.Run("readFileErr", func(t *testing.T) {
 		_, err := NewManager(
 			"/dev/null/nil.json",
 			storage.ConfigEnv{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 			&log.Logger{},
 			&video.Server{},
 			&Hooks{Migrate: func(RawConfig) error { return nil }},
@@ -169,6 +187,11 @@ func TestNewManager(t *testing.T) {
 		_, err = NewManager(
 			configDir,
 			storage.ConfigEnv{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 			&log.Logger{},
 			&video.Server{},
 			&Hooks{Migrate: func(RawConfig) error { return nil }},
@@ -188,6 +211,11 @@ func TestNewManager(t *testing.T) {
 		_, err = NewManager(
 			configDir,
 			storage.ConfigEnv{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 			&log.Logger{},
 			&video.Server{},
 			&Hooks{Migrate: func(RawConfig) error { return stubErr }},
@@ -196,6 +224,59 @@ func TestNewManager(t *testing.T) {
 	})
 }
 
+func TestReload(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		configDir, manager := newTestManager(t)
+		manager.StartMonitors()
+		monitor2 := manager.runningMonitors["2"]
+
+		writeFile := func(name string, data string) {
+			err := os.WriteFile(filepath.Join(configDir, name), []byte(data), 0o600)
+			require.NoError(t, err)
+		}
+		writeFile("1.json", `{"id": "1", "name": "new", "enable": "false"}`)
+		writeFile("3.json", `{"id": "3", "enable": "false"}`)
+
+		result, err := manager.Reload()
+		require.NoError(t, err)
+
+		expected := ReloadResult{
+			Added:   []string{"3"},
+			Changed: []string{"1"},
+			Removed: []string{},
+		}
+		require.Equal(t, expected, result)
+		require.Equal(t, "new", manager.runningMonitors["1"].Config.Get("name"))
+		require.Contains(t, manager.runningMonitors, "3")
+		require.Same(t, monitor2, manager.runningMonitors["2"])
+
+		require.NoError(t, os.Remove(filepath.Join(configDir, "3.json")))
+		result, err = manager.Reload()
+		require.NoError(t, err)
+		require.Equal(t, []string{"3"}, result.Removed)
+		require.NotContains(t, manager.runningMonitors, "3")
+		require.NotContains(t, manager.rawConfigs, "3")
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		result, err := manager.Reload()
+		require.NoError(t, err)
+		require.True(t, result.Empty())
+	})
+	t.Run("unmarshalErr", func(t *testing.T) {
+		configDir, manager := newTestManager(t)
+		manager.StartMonitors()
+
+		err := os.WriteFile(filepath.Join(configDir, "1.json"), []byte("{"), 0o600)
+		require.NoError(t, err)
+
+		_, err = manager.Reload()
+		var e *json.SyntaxError
+		require.ErrorAs(t, err, &e)
+		require.Equal(t, "one", manager.rawConfigs["1"]["name"])
+	})
+}
+
 func TestMonitorSet(t *testing.T) {
 	t.Run("createNew", func(t *testing.T) {
 		configDir, manager := newTestManager(t)
@@ -328,6 +409,44 @@ func TestMonitorConfigs(t *testing.T) {
 	require.Equal(t, actual, expected)
 }
 
+func TestRetentionPolicies(t *testing.T) {
+	logger, logs := log.NewMockLogger()
+	manager := &Manager{
+		rawConfigs: RawConfigs{
+			"1": {
+				"eventRetention":      "90",
+				"continuousRetention": "0.5",
+				"maxDiskShare":        "25",
+			},
+			"2": {},
+			"3": {"maxDiskShare": "101"},
+		},
+		logger: logger,
+	}
+
+	policies := make(chan map[string]storage.RetentionPolicy)
+	go func() { policies <- manager.RetentionPolicies() }()
+	require.Equal(t, `retention policy: invalid max disk share: "101"`, <-logs)
+
+	actual := <-policies
+	expected := map[string]storage.RetentionPolicy{
+		"1": {
+			EventMaxAge:      90 * 24 * time.Hour,
+			ContinuousMaxAge: 12 * time.Hour,
+			MaxDiskShare:     25,
+		},
+		"2": {},
+	}
+	require.Equal(t, expected, actual)
+
+	_, err := NewConfig(RawConfig{"eventRetention": "x"}).Retention()
+	require.ErrorIs(t, err, ErrInvalidEventRetention)
+	_, err = NewConfig(RawConfig{"continuousRetention": "-1"}).Retention()
+	require.ErrorIs(t, err, ErrInvalidContinuousRetention)
+	_, err = NewConfig(RawConfig{"maxDiskShare": "101"}).Retention()
+	require.ErrorIs(t, err, ErrInvalidMaxDiskShare)
+}
+
 func TestStartAllMonitors(t *testing.T) {
 	_, manager := newTestManager(t)
 	manager.StartMonitors()
@@ -342,6 +461,37 @@ func TestStopAllMonitors(t *testing.T) {
 	require.Zero(t, len(m.runningMonitors))
 }
 
+func TestManagerShutdown(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		m := Manager{runningMonitors: map[string]*Monitor{
+			"1": {}, "2": {},
+		}}
+		require.NoError(t, m.Shutdown(context.Background()))
+		require.Zero(t, len(m.runningMonitors))
+
+		// Not started again after shutdown.
+		m.rawConfigs = RawConfigs{"1": {}}
+		require.NoError(t, m.RestartMonitor("1"))
+		require.Zero(t, len(m.runningMonitors))
+	})
+	t.Run("timeout", func(t *testing.T) {
+		busy := &Monitor{}
+		busy.WG.Add(1)
+		defer busy.WG.Done()
+
+		m := Manager{runningMonitors: map[string]*Monitor{
+			"1": {}, "2": busy,
+		}}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := m.Shutdown(ctx)
+		require.ErrorIs(t, err, ErrShutdownTimeout)
+		require.Equal(t, "monitors did not stop in time: 2", err.Error())
+		require.Zero(t, len(m.runningMonitors))
+	})
+}
+
 func TestRestartMonitor(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		_, manager := newTestManager(t)
@@ -357,6 +507,85 @@ func TestRestartMonitor(t *testing.T) {
 	})
 }
 
+func TestMonitorEnable(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		configDir, manager := newTestManager(t)
+		manager.rawConfigs["1"]["enable"] = "true"
+
+		err := manager.MonitorEnable("1", false)
+		require.NoError(t, err)
+
+		require.Equal(t, "false", manager.rawConfigs["1"]["enable"])
+		require.Equal(t, manager.rawConfigs["1"], readConfig(t, filepath.Join(configDir, "1.json")))
+		require.NotNil(t, manager.runningMonitors["1"])
+		manager.StopMonitors()
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		err := manager.MonitorEnable("x", true)
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+}
+
+func TestTriggerEvent(t *testing.T) {
+	t.Run("notExistErr", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		err := manager.TriggerEvent("x", storage.Event{})
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+	t.Run("notRunningErr", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		manager.runningMonitors["1"] = &Monitor{}
+		err := manager.TriggerEvent("1", storage.Event{})
+		require.ErrorIs(t, err, ErrMonitorNotRunning)
+	})
+}
+
+func TestIsDetectInput(t *testing.T) {
+	cases := map[string]struct {
+		config   RawConfig
+		expected [2]bool // Main, sub.
+	}{
+		"noSub":   {RawConfig{}, [2]bool{true, false}},
+		"auto":    {RawConfig{"subInput": "x"}, [2]bool{false, true}},
+		"main":    {RawConfig{"subInput": "x", "detectStream": "main"}, [2]bool{true, false}},
+		"sub":     {RawConfig{"subInput": "x", "detectStream": "sub"}, [2]bool{false, true}},
+		"subNone": {RawConfig{"detectStream": "sub"}, [2]bool{true, false}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			main := &InputProcess{Config: NewConfig(tc.config)}
+			sub := &InputProcess{Config: NewConfig(tc.config), isSubInput: true}
+			require.Equal(t, tc.expected, [2]bool{main.IsDetectInput(), sub.IsDetectInput()})
+		})
+	}
+}
+
+func TestBackchannelURL(t *testing.T) {
+	newManager := func(conf RawConfig) *Manager {
+		return &Manager{rawConfigs: RawConfigs{"1": conf}}
+	}
+	t.Run("ok", func(t *testing.T) {
+		m := newManager(RawConfig{"backchannel": "true", "mainInput": "rtsp://x/1"})
+		url, err := m.BackchannelURL("1")
+		require.NoError(t, err)
+		require.Equal(t, "rtsp://x/1", url)
+	})
+	t.Run("notExistErr", func(t *testing.T) {
+		_, err := newManager(RawConfig{}).BackchannelURL("x")
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+	t.Run("disabledErr", func(t *testing.T) {
+		_, err := newManager(RawConfig{"mainInput": "rtsp://x/1"}).BackchannelURL("1")
+		require.ErrorIs(t, err, ErrBackchannelDisabled)
+	})
+	t.Run("notRTSPErr", func(t *testing.T) {
+		m := newManager(RawConfig{"backchannel": "true", "mainInput": "rtmp://x/1"})
+		_, err := m.BackchannelURL("1")
+		require.ErrorIs(t, err, ErrBackchannelNotRTSP)
+	})
+}
+
 func stubNewVideoServerPath(
 	_ context.Context,
 	name string,
@@ -464,8 +693,131 @@ func TestStartInputProcess(t *testing.T) {
 		go input.start(ctx)
 
 		require.Equal(t, "main process: crashed: stub", <-logs)
+		require.Equal(t, "main process: reconnecting", <-logs)
 		cancel()
-		<-logs
+		require.Equal(t, "main process: stopped", <-logs)
+	})
+	t.Run("offline", func(t *testing.T) {
+		logs := make(chan string)
+		defer close(logs)
+
+		stubRunInputProcess := func(context.Context, *InputProcess) error {
+			return errors.New("stub")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		states := make(chan InputHealth, 10)
+		input := newTestInputProcess()
+		input.Config.v["reconnectMinDelay"] = "0.001"
+		input.runInputProcess = stubRunInputProcess
+		input.logf = func(level log.Level, format string, a ...interface{}) {
+			logs <- fmt.Sprintf(format, a...)
+		}
+		input.hooks.InputState = func(_ *InputProcess, health InputHealth) {
+			states <- health
+		}
+		input.WG.Add(1)
+		go input.start(ctx)
+
+		require.Equal(t, InputStateStarting, (<-states).State)
+		require.Equal(t, "main process: crashed: stub", <-logs)
+		require.Equal(t, "main process: reconnecting", <-logs)
+		require.Equal(t, "main process: crashed: stub", <-logs)
+		require.Equal(t, "main process: crashed: stub", <-logs)
+		require.Regexp(t, "^main process: offline since ", <-logs)
+
+		require.Equal(t, InputStateReconnecting, (<-states).State)
+		offline := <-states
+		require.Equal(t, InputStateOffline, offline.State)
+		require.Equal(t, 3, offline.Attempts)
+		require.Equal(t, "stub", offline.LastError)
+		require.Equal(t, offline, input.Health())
+
+		cancel()
+		for msg := range logs {
+			if msg == "main process: stopped" {
+				break
+			}
+		}
+	})
+}
+
+func TestInputHealthComponent(t *testing.T) {
+	since := time.Date(2025, 12, 28, 10, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		input    InputHealth
+		expected health.Component
+	}{
+		"starting": {
+			InputHealth{},
+			health.Component{Status: health.StatusDegraded, Reason: "starting"},
+		},
+		"online": {
+			InputHealth{State: InputStateOnline},
+			health.Component{Status: health.StatusOK},
+		},
+		"reconnecting": {
+			InputHealth{State: InputStateReconnecting, Attempts: 2, LastError: "a"},
+			health.Component{Status: health.StatusDegraded, Reason: "reconnecting after 2 crashes: a"},
+		},
+		"offline": {
+			InputHealth{State: InputStateOffline, Since: since, LastError: "b"},
+			health.Component{Status: health.StatusFailed, Reason: "offline since 2025-12-28T10:00:00Z: b"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.input.component())
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("delay", func(t *testing.T) {
+		b, err := newBackoff(NewConfig(RawConfig{
+			"reconnectMinDelay": "1",
+			"reconnectMaxDelay": "10",
+			"reconnectJitter":   "0",
+		}))
+		require.NoError(t, err)
+
+		var actual []time.Duration
+		for attempt := 1; attempt <= 6; attempt++ {
+			actual = append(actual, b.delay(attempt))
+		}
+		expected := []time.Duration{
+			1 * time.Second,
+			2 * time.Second,
+			4 * time.Second,
+			8 * time.Second,
+			10 * time.Second,
+			10 * time.Second,
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("jitter", func(t *testing.T) {
+		b, err := newBackoff(NewConfig(RawConfig{"reconnectJitter": "50"}))
+		require.NoError(t, err)
+
+		b.random = func() float64 { return 0 }
+		require.Equal(t, 500*time.Millisecond, b.delay(1))
+		b.random = func() float64 { return 0.5 }
+		require.Equal(t, 1*time.Second, b.delay(1))
+	})
+	t.Run("defaults", func(t *testing.T) {
+		b, err := newBackoff(NewConfig(RawConfig{}))
+		require.NoError(t, err)
+		require.Equal(t, defaultReconnectMinDelay, b.minDelay)
+		require.Equal(t, defaultReconnectMaxDelay, b.maxDelay)
+		require.Equal(t, defaultReconnectJitter, b.jitter)
+	})
+	t.Run("invalidDelay", func(t *testing.T) {
+		_, err := newBackoff(NewConfig(RawConfig{"reconnectMinDelay": "-1"}))
+		require.ErrorIs(t, err, ErrReconnectInvalidDelay)
+	})
+	t.Run("invalidJitter", func(t *testing.T) {
+		_, err := newBackoff(NewConfig(RawConfig{"reconnectJitter": "101"}))
+		require.ErrorIs(t, err, ErrReconnectInvalidJitter)
 	})
 }
 
@@ -482,6 +834,63 @@ func TestRunInputProcess(t *testing.T) {
 		err := runInputProcess(context.Background(), i)
 		require.ErrorIs(t, err, video.ErrEmptyPathName)
 	})
+	t.Run("tcpFallback", func(t *testing.T) {
+		i := newTestInputProcess()
+		i.Config.v["mainInput"] = "rtsp://x"
+		i.Config.v["rtspTransport"] = "multicast"
+		i.newProcess = ffmock.NewProcessErr
+
+		require.Equal(t, "udp_multicast", i.rtspTransport())
+		err := runInputProcess(context.Background(), i)
+		require.Error(t, err)
+		require.True(t, i.tcpFallback)
+		require.Equal(t, "tcp", i.rtspTransport())
+	})
+	t.Run("rtmp", func(t *testing.T) {
+		i := newTestInputProcess()
+		i.Config.v["inputSource"] = "rtmp"
+		i.Config.v["mainInput"] = "key"
+		i.newProcess = ffmock.NewProcessErr
+
+		var pathConf video.PathConf
+		i.newVideoServerPath = func(
+			_ context.Context,
+			_ string,
+			conf video.PathConf,
+		) (*video.ServerPath, error) {
+			pathConf = conf
+			return &video.ServerPath{}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := runInputProcess(ctx, i)
+		require.NoError(t, err)
+		require.Equal(t, "key", pathConf.RTMPKey)
+	})
+	t.Run("push", func(t *testing.T) {
+		i := newTestInputProcess()
+		i.Config.v["inputSource"] = "push"
+		i.Config.v["mainInput"] = "key"
+		i.newProcess = ffmock.NewProcessErr
+
+		var pathConf video.PathConf
+		i.newVideoServerPath = func(
+			_ context.Context,
+			_ string,
+			conf video.PathConf,
+		) (*video.ServerPath, error) {
+			pathConf = conf
+			return &video.ServerPath{}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := runInputProcess(ctx, i)
+		require.NoError(t, err)
+		require.Equal(t, "key", pathConf.PushKey)
+		require.Empty(t, pathConf.RTMPKey)
+	})
 }
 
 func TestGenInputArgs(t *testing.T) {
@@ -498,7 +907,8 @@ func TestGenInputArgs(t *testing.T) {
 				RtspAddress:  "5",
 			},
 		}
-		actual := i.generateArgs()
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
 		expected := "-threads 1 -loglevel 1 -i 2 -an -c:v 3 -f rtsp -rtsp_transport 4 5"
 		require.Equal(t, expected, actual)
 	})
@@ -519,10 +929,357 @@ func TestGenInputArgs(t *testing.T) {
 				RtspAddress:  "9",
 			},
 		}
-		actual := i.generateArgs()
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
 		expected := "-threads 1 -loglevel 1 -hwaccel 2 3 -i 4 -c:a 5 -c:v 6 -f rtsp -rtsp_transport 8 9"
 		require.Equal(t, expected, actual)
 	})
+	t.Run("audioLanguages", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":       "1",
+				"mainInput":      "2",
+				"audioEncoder":   "3",
+				"audioLanguages": "eng, swe",
+				"videoEncoder":   "4",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "5",
+				RtspAddress:  "6",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -i 2 -map 0:v:0" +
+			" -map 0:a:0? -metadata:s:a:0 language=eng" +
+			" -map 0:a:1? -metadata:s:a:1 language=swe" +
+			" -c:a 3 -c:v 4 -f rtsp -rtsp_transport 5 6"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("audioOnly", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":     "1",
+				"mainInput":    "2",
+				"audioEncoder": "3",
+				"audioOnly":    "true",
+				"videoEncoder": "4",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "5",
+				RtspAddress:  "6",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -i 2 -c:a 3 -vn -f rtsp -rtsp_transport 5 6"
+		require.Equal(t, expected, actual)
+
+		i.Config.v["audioEncoder"] = "none"
+		_, err = i.generateArgs()
+		require.ErrorIs(t, err, ErrAudioOnlyNoAudio)
+	})
+	t.Run("srt", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":     "1",
+				"inputSource":  "srt",
+				"mainInput":    "srt://0.0.0.0:9000",
+				"srtMode":      "listener",
+				"audioEncoder": "none",
+				"videoEncoder": "2",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "3",
+				RtspAddress:  "4",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -i srt://0.0.0.0:9000?mode=listener" +
+			" -an -c:v 2 -f rtsp -rtsp_transport 3 4"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("mjpeg", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":     "1",
+				"inputSource":  "mjpeg",
+				"mainInput":    "http://x/video.mjpg",
+				"audioEncoder": "aac",
+				"videoEncoder": "copy",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "2",
+				RtspAddress:  "3",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -use_wallclock_as_timestamps 1" +
+			" -i http://x/video.mjpg -an -c:v libx264 -preset veryfast" +
+			" -tune zerolatency -pix_fmt yuv420p -force_key_frames expr:gte(t,n_forced*2)" +
+			" -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+
+		// Custom encoder.
+		i.Config.v["videoEncoder"] = "h264_v4l2m2m"
+		actual, err = i.generateArgs()
+		require.NoError(t, err)
+		expected = "-threads 1 -loglevel 1 -use_wallclock_as_timestamps 1" +
+			" -i http://x/video.mjpg -an -c:v h264_v4l2m2m -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("v4l2", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":        "1",
+				"mainInput":       "v4l2:/dev/video0",
+				"v4l2InputFormat": "h264",
+				"audioEncoder":    "aac",
+				"videoEncoder":    "copy",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "2",
+				RtspAddress:  "3",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -f v4l2 -input_format h264 -i /dev/video0" +
+			" -an -c:v copy -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+
+		// Raw formats are transcoded.
+		i.Config.v["v4l2InputFormat"] = "yuyv422"
+		actual, err = i.generateArgs()
+		require.NoError(t, err)
+		expected = "-threads 1 -loglevel 1 -f v4l2 -input_format yuyv422 -i /dev/video0" +
+			" -an -c:v " + transcodeVideoEncoder + " -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "demo.mp4")
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":     "1",
+				"inputSource":  "file",
+				"mainInput":    path,
+				"audioEncoder": "none",
+				"videoEncoder": "copy",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "2",
+				RtspAddress:  "3",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -re -stream_loop -1 -i " + path +
+			" -an -c:v copy -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("fileErr", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"inputSource": "file",
+				"mainInput":   "demo.mp4",
+			}),
+		}
+		_, err := i.generateArgs()
+		require.ErrorIs(t, err, ErrFileInputNotAbs)
+
+		i.Config.v["mainInput"] = filepath.Join(t.TempDir(), "missing.mp4")
+		_, err = i.generateArgs()
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("hwaccel", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":      "1",
+				"inputSource":   "mjpeg",
+				"mainInput":     "http://x",
+				"hwaccelType":   "vaapi",
+				"hwaccelDevice": "/dev/dri/renderD129",
+				"audioEncoder":  "none",
+				"videoEncoder":  "copy",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "2",
+				RtspAddress:  "3",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -hwaccel vaapi -hwaccel_device /dev/dri/renderD129" +
+			" -hwaccel_output_format vaapi -vaapi_device /dev/dri/renderD129" +
+			" -use_wallclock_as_timestamps 1 -i http://x -an" +
+			" -c:v h264_vaapi -vf format=nv12|vaapi,hwupload " + forceKeyFrames +
+			" -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+
+		// Software encoder with hardware decoding.
+		i.Config.v["videoEncoder"] = "libx264"
+		actual, err = i.generateArgs()
+		require.NoError(t, err)
+		expected = "-threads 1 -loglevel 1 -hwaccel vaapi -hwaccel_device /dev/dri/renderD129" +
+			" -use_wallclock_as_timestamps 1 -i http://x -an -c:v libx264 -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+
+		// Not decoded.
+		i.Config.v["inputSource"] = ""
+		i.Config.v["videoEncoder"] = "copy"
+		actual, err = i.generateArgs()
+		require.NoError(t, err)
+		expected = "-threads 1 -loglevel 1 -i http://x -an -c:v copy -f rtsp -rtsp_transport 2 3"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("hwaccelErr", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{"hwaccelType": "x"}),
+		}
+		_, err := i.generateArgs()
+		require.ErrorIs(t, err, ffmpeg.ErrHWAccelUnknown)
+
+		i.Config.v["hwaccelType"] = ""
+		i.Config.v["videoEncoder"] = "hardware"
+		_, err = i.generateArgs()
+		require.ErrorIs(t, err, ErrNoHWEncoder)
+	})
+	t.Run("rtsps", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":     "1",
+				"mainInput":    "rtsps://x",
+				"audioEncoder": "none",
+				"videoEncoder": "2",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "3",
+				RtspAddress:  "4",
+			},
+			rtspsProxyInput: "rtsp://127.0.0.1:5",
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -rtsp_transport tcp -i rtsp://127.0.0.1:5" +
+			" -an -c:v 2 -f rtsp -rtsp_transport 3 4"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("rtspTransport", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"logLevel":      "1",
+				"mainInput":     "rtsp://x",
+				"rtspTransport": "udp",
+				"audioEncoder":  "none",
+				"videoEncoder":  "2",
+			}),
+			serverPath: video.ServerPath{
+				RtspProtocol: "3",
+				RtspAddress:  "4",
+			},
+		}
+		actual, err := i.generateArgs()
+		require.NoError(t, err)
+		expected := "-threads 1 -loglevel 1 -rtsp_transport udp -i rtsp://x" +
+			" -an -c:v 2 -f rtsp -rtsp_transport 3 4"
+		require.Equal(t, expected, actual)
+
+		// Input options takes priority.
+		i.Config.v["inputOptions"] = "-rtsp_transport tcp"
+		actual, err = i.generateArgs()
+		require.NoError(t, err)
+		expected = "-threads 1 -loglevel 1 -rtsp_transport tcp -i rtsp://x" +
+			" -an -c:v 2 -f rtsp -rtsp_transport 3 4"
+		require.Equal(t, expected, actual)
+	})
+	t.Run("srtErr", func(t *testing.T) {
+		i := &InputProcess{
+			Config: NewConfig(RawConfig{
+				"inputSource": "srt",
+				"mainInput":   "rtsp://x",
+			}),
+		}
+		_, err := i.generateArgs()
+		require.ErrorIs(t, err, ErrSRTInvalidURL)
+	})
+}
+
+func TestSRTURL(t *testing.T) {
+	cases := map[string]struct {
+		input      string
+		mode       string
+		passphrase string
+		latency    string
+		expected   string
+		err        error
+	}{
+		"minimal": {
+			input:    "srt://1.2.3.4:9000",
+			expected: "srt://1.2.3.4:9000",
+		},
+		"maximal": {
+			input:      "srt://1.2.3.4:9000?streamid=x",
+			mode:       "caller",
+			passphrase: "0123456789",
+			latency:    "200",
+			expected: "srt://1.2.3.4:9000?latency=200000" +
+				"&mode=caller&passphrase=0123456789&streamid=x",
+		},
+		"override": {
+			input:    "srt://1.2.3.4:9000?mode=caller",
+			mode:     "listener",
+			expected: "srt://1.2.3.4:9000?mode=listener",
+		},
+		"scheme":     {input: "udp://1.2.3.4:9000", err: ErrSRTInvalidURL},
+		"host":       {input: "srt://", err: ErrSRTInvalidURL},
+		"mode":       {input: "srt://x:1", mode: "rendezvous", err: ErrSRTInvalidMode},
+		"passphrase": {input: "srt://x:1", passphrase: "short", err: ErrSRTPassphraseLength},
+		"latency":    {input: "srt://x:1", latency: "-1", err: ErrSRTInvalidLatency},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := srtURL(tc.input, tc.mode, tc.passphrase, tc.latency)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestV4L2InputArgs(t *testing.T) {
+	cases := map[string]struct {
+		device     string
+		format     string
+		resolution string
+		frameRate  string
+		expected   string
+		err        error
+	}{
+		"minimal": {
+			device:   "/dev/video0",
+			expected: " -f v4l2",
+		},
+		"maximal": {
+			device:     "/dev/video0",
+			format:     "mjpeg",
+			resolution: "1280x720",
+			frameRate:  "30",
+			expected:   " -f v4l2 -input_format mjpeg -video_size 1280x720 -framerate 30",
+		},
+		"device":     {device: "video0", err: ErrV4L2InvalidDevice},
+		"resolution": {device: "/dev/video0", resolution: "720p", err: ErrV4L2InvalidResolution},
+		"frameRate":  {device: "/dev/video0", frameRate: "0", err: ErrV4L2InvalidFrameRate},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := v4l2InputArgs(tc.device, tc.format, tc.resolution, tc.frameRate)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
 }
 
 func TestInputStreamInfo(t *testing.T) {
@@ -580,6 +1337,18 @@ func TestSendEvent(t *testing.T) {
 		err := m.SendEvent(storage.Event{})
 		require.ErrorIs(t, err, context.Canceled)
 	})
+	t.Run("ignored", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		m := &Monitor{ctx: ctx, Config: NewConfig(RawConfig{"armAway": "ignore"})}
+
+		err := m.SendEvent(storage.Event{Detections: []storage.Detection{{Label: "x"}}})
+		require.NoError(t, err)
+
+		// Events without detections aren't affected by the arming mode.
+		err = m.SendEvent(storage.Event{})
+		require.ErrorIs(t, err, context.Canceled)
+	})
 	t.Run("missingTimeErr", func(t *testing.T) {
 		m := newTestMonitor(t)
 
@@ -609,3 +1378,11 @@ func TestSendEvent(t *testing.T) {
 		require.Equal(t, actual, expected)
 	})
 }
+
+func TestArmAction(t *testing.T) {
+	c := NewConfig(RawConfig{"armHome": "ignore", "armNight": "record"})
+	require.Equal(t, arming.ActionRecord, c.ArmAction(arming.Disarmed))
+	require.Equal(t, arming.ActionIgnore, c.ArmAction(arming.Home))
+	require.Equal(t, arming.ActionAlert, c.ArmAction(arming.Away))
+	require.Equal(t, arming.ActionRecord, c.ArmAction(arming.Night))
+}
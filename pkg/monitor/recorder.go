@@ -21,11 +21,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"nvr/pkg/arming"
 	"nvr/pkg/ffmpeg"
 	"nvr/pkg/log"
 	"nvr/pkg/storage"
 	"nvr/pkg/video/customformat"
 	"nvr/pkg/video/hls"
+	"nvr/pkg/video/mkv"
 	"nvr/pkg/video/mp4muxer"
 	"os"
 	"os/exec"
@@ -47,14 +50,26 @@ type Recorder struct {
 	runSession runRecordingFunc
 	NewProcess ffmpeg.NewProcessFunc
 
-	input  *InputProcess
+	input *InputProcess
+
+	// Thumbnails are generated from the sub input if detection uses it.
+	thumbInput *InputProcess
+
 	Env    storage.ConfigEnv
 	Logger log.ILogger
+	ledger *storage.Ledger
 	wg     *sync.WaitGroup
-	hooks  Hooks
+
+	// Events are also added to the event store for querying.
+	eventStore *storage.EventStore
+	hooks      Hooks
+	armAction  func() arming.Action
 
 	sleep   time.Duration
 	prevSeg uint64
+
+	// Time of the event that started the current session.
+	sessionTrigger time.Time
 }
 
 func newRecorder(m *Monitor) *Recorder {
@@ -67,6 +82,10 @@ func newRecorder(m *Monitor) *Recorder {
 			Msg:       fmt.Sprintf(format, a...),
 		})
 	}
+	thumbInput := m.mainInput
+	if m.Config.DetectSubInput() && !m.Config.audioOnly() {
+		thumbInput = m.subInput
+	}
 	return &Recorder{
 		Config: m.Config,
 
@@ -78,19 +97,44 @@ func newRecorder(m *Monitor) *Recorder {
 		runSession: runRecording,
 		NewProcess: ffmpeg.NewProcess,
 
-		input:  m.mainInput,
-		Env:    m.Env,
-		Logger: m.Logger,
-		wg:     &m.WG,
-		hooks:  m.hooks,
+		input:      m.mainInput,
+		thumbInput: thumbInput,
+		Env:        m.Env,
+		Logger:     m.Logger,
+		ledger:     m.ledger,
+		wg:         &m.WG,
+		eventStore: m.events,
+		hooks:      m.hooks,
+		armAction:  m.ArmAction,
 
 		sleep: 3 * time.Second,
 	}
 }
 
+// ArmAction returns the action of the current arming mode.
+// Hooks should only notify if the action is ActionAlert.
+func (r *Recorder) ArmAction() arming.Action {
+	if r.armAction == nil {
+		return arming.ActionAlert
+	}
+	return r.armAction()
+}
+
 func (r *Recorder) start(ctx context.Context) {
 	defer r.wg.Done()
 
+	cooldown, err := r.Config.eventCooldown()
+	if err != nil {
+		r.logf(log.LevelError, "%v", err)
+	}
+	maxDuration, err := r.Config.maxRecordingDuration()
+	if err != nil {
+		r.logf(log.LevelError, "%v", err)
+	}
+	if r.Config.alwaysRecord() {
+		maxDuration = 0
+	}
+
 	var sessionCtx context.Context
 	var cancelSession context.CancelFunc
 	isRecording := false
@@ -98,6 +142,7 @@ func (r *Recorder) start(ctx context.Context) {
 	onSessionExit := make(chan struct{})
 
 	var timerEnd time.Time
+	var sessionDeadline time.Time
 	for {
 		select {
 		case <-ctx.Done():
@@ -115,21 +160,28 @@ func (r *Recorder) start(ctx context.Context) {
 			r.eventsLock.Lock()
 			*r.events = append(*r.events, event)
 			r.eventsLock.Unlock()
+			r.storeEvent(event)
 
-			end := event.Time.Add(event.RecDuration)
+			// Events within the cooldown extend the active recording.
+			end := event.Time.Add(event.RecDuration).Add(cooldown)
 			if end.After(timerEnd) {
 				timerEnd = end
 			}
 
 			if isRecording {
 				r.logf(log.LevelDebug, "new event, already recording, updating timer")
-				triggerTimer = time.NewTimer(time.Until(timerEnd))
+				triggerTimer = time.NewTimer(time.Until(sessionStopTime(timerEnd, sessionDeadline)))
 				continue
 			}
 
 			r.logf(log.LevelDebug, "starting recording session")
 			isRecording = true
-			triggerTimer = time.NewTimer(time.Until(timerEnd))
+			r.sessionTrigger = event.Time
+			sessionDeadline = time.Time{}
+			if maxDuration != 0 {
+				sessionDeadline = time.Now().Add(maxDuration)
+			}
+			triggerTimer = time.NewTimer(time.Until(sessionStopTime(timerEnd, sessionDeadline)))
 			sessionCtx, cancelSession = context.WithCancel(ctx)
 			go func() {
 				r.runRecordingSession(sessionCtx)
@@ -148,6 +200,15 @@ func (r *Recorder) start(ctx context.Context) {
 	}
 }
 
+// sessionStopTime returns the end of the last event or
+// the session deadline if it's set and comes first.
+func sessionStopTime(timerEnd time.Time, deadline time.Time) time.Time {
+	if !deadline.IsZero() && deadline.Before(timerEnd) {
+		return deadline
+	}
+	return timerEnd
+}
+
 func (r *Recorder) runRecordingSession(ctx context.Context) {
 	defer r.logf(log.LevelDebug, "session stopped")
 	for {
@@ -187,7 +248,18 @@ func runRecording(ctx context.Context, r *Recorder) error {
 		return fmt.Errorf("get muxer: %w", err)
 	}
 
-	firstSegment, err := muxer.NextSegment(r.prevSeg)
+	preEventBuffer, err := r.Config.preEventBuffer()
+	if err != nil {
+		return err
+	}
+
+	// The pre-event buffer is ignored if the session is
+	// continued since the segment after prevSeg is newer.
+	var bufferStart time.Time
+	if preEventBuffer != 0 {
+		bufferStart = r.sessionTrigger.Add(-preEventBuffer)
+	}
+	firstSegment, err := findSegment(ctx, muxer.NextSegment, r.prevSeg, bufferStart)
 	if err != nil {
 		return fmt.Errorf("first segment: %w", err)
 	}
@@ -225,39 +297,97 @@ func runRecording(ctx context.Context, r *Recorder) error {
 		return fmt.Errorf("stream info: %w", err)
 	}
 
+	nextSegment := muxer.NextSegment
+	if !r.Config.recordAudio() {
+		info = withoutAudioTrack(*info)
+		firstSegment = withoutAudio(firstSegment)
+		nextSegment = func(prevID uint64) (*hls.Segment, error) {
+			seg, err := muxer.NextSegment(prevID)
+			if err != nil {
+				return nil, err
+			}
+			return withoutAudio(seg), nil
+		}
+	}
+	if !info.VideoTrackExist && !info.AudioTrackExist {
+		return ErrNoTracks
+	}
+
 	go r.generateThumbnail(filePath, firstSegment, *info)
 
-	prevSeg, endTime, err := generateVideo(
-		ctx, filePath, muxer.NextSegment, firstSegment, *info, videoLength)
+	generate := generateVideoFunc(generateVideo)
+	if r.Config.mkvContainer() {
+		generate = generateMKV
+	}
+	prevSeg, endTime, err := generate(
+		ctx, filePath, r.Env.Crypt, nextSegment, firstSegment, *info, videoLength)
 	if err != nil {
 		return fmt.Errorf("write video: %w", err)
 	}
 	r.prevSeg = prevSeg
 	r.logf(log.LevelInfo, "video generated: %v", basePath)
 
-	go r.saveRecording(filePath, startTime, *endTime)
+	// Tracked by the wait group, the monitor isn't
+	// stopped until the recording is saved.
+	r.wg.Add(1)
+	go func() {
+		r.saveRecording(filePath, startTime, *endTime)
+		r.wg.Done()
+	}()
 
 	return nil
 }
 
-// ErrSkippedSegment skipped segment.
-var ErrSkippedSegment = errors.New("skipped segment")
+// Recording errors.
+var (
+	ErrSkippedSegment = errors.New("skipped segment")
+	ErrNoTracks       = errors.New("nothing to record, stream has no video and audio is disabled")
+)
+
+func withoutAudioTrack(info hls.StreamInfo) *hls.StreamInfo {
+	info.AudioTrackExist = false
+	info.AudioTrackConfig = nil
+	info.AudioChannelCount = 0
+	info.AudioClockRate = 0
+	return &info
+}
+
+// withoutAudio returns a copy of the segment without audio samples.
+func withoutAudio(seg *hls.Segment) *hls.Segment {
+	parts := make([]*hls.MuxerPart, 0, len(seg.Parts))
+	for _, part := range seg.Parts {
+		parts = append(parts, &hls.MuxerPart{VideoSamples: part.VideoSamples})
+	}
+	return &hls.Segment{
+		ID:               seg.ID,
+		StartTime:        seg.StartTime,
+		RenderedDuration: seg.RenderedDuration,
+		Parts:            parts,
+	}
+}
 
 type nextSegmentFunc func(uint64) (*hls.Segment, error)
 
-func generateVideo( //nolint:funlen
+type generateVideoFunc func(
 	ctx context.Context,
 	filePath string,
+	crypt *storage.Crypt,
 	nextSegment nextSegmentFunc,
 	firstSegment *hls.Segment,
 	info hls.StreamInfo,
 	maxDuration time.Duration,
-) (uint64, *time.Time, error) {
-	prevSeg := firstSegment.ID
-	startTime := firstSegment.StartTime
-	stopTime := firstSegment.StartTime.Add(maxDuration)
-	endTime := startTime
+) (uint64, *time.Time, error)
 
+// generateVideo writes the recording in the custom format.
+func generateVideo(
+	ctx context.Context,
+	filePath string,
+	crypt *storage.Crypt,
+	nextSegment nextSegmentFunc,
+	firstSegment *hls.Segment,
+	info hls.StreamInfo,
+	maxDuration time.Duration,
+) (uint64, *time.Time, error) {
 	metaPath := filePath + ".meta"
 	mdatPath := filePath + ".mdat"
 
@@ -267,7 +397,8 @@ func generateVideo( //nolint:funlen
 	}
 	defer meta.Close()
 
-	mdat, err := os.OpenFile(mdatPath, os.O_CREATE|os.O_WRONLY, 0o600)
+	// Only the mdat file is encrypted, the meta file has no image data.
+	mdat, err := storage.CreateRecordingFile(mdatPath, crypt)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -277,7 +408,7 @@ func generateVideo( //nolint:funlen
 		VideoSPS:    info.VideoSPS,
 		VideoPPS:    info.VideoPPS,
 		AudioConfig: info.AudioTrackConfig,
-		StartTime:   startTime.UnixNano(),
+		StartTime:   firstSegment.StartTime.UnixNano(),
 	}
 
 	w, err := customformat.NewWriter(meta, mdat, header)
@@ -285,6 +416,71 @@ func generateVideo( //nolint:funlen
 		return 0, nil, err
 	}
 
+	prevSeg, endTime, err := writeSegments(ctx, w, nextSegment, firstSegment, maxDuration)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The final chunk of encrypted files is written on close.
+	if err := mdat.Close(); err != nil {
+		return 0, nil, fmt.Errorf("close mdat: %w", err)
+	}
+	return prevSeg, endTime, nil
+}
+
+// generateMKV writes the recording to a Matroska file.
+func generateMKV(
+	ctx context.Context,
+	filePath string,
+	crypt *storage.Crypt,
+	nextSegment nextSegmentFunc,
+	firstSegment *hls.Segment,
+	info hls.StreamInfo,
+	maxDuration time.Duration,
+) (uint64, *time.Time, error) {
+	file, err := storage.CreateRecordingFile(filePath+".mkv", crypt)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	// The cues can only be updated in place if the file isn't encrypted.
+	w, err := mkv.NewWriter(file, file.WriterAt(), info, firstSegment.StartTime.UnixNano())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	prevSeg, endTime, err := writeSegments(ctx, w, nextSegment, firstSegment, maxDuration)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, nil, fmt.Errorf("close mkv writer: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return 0, nil, fmt.Errorf("close mkv: %w", err)
+	}
+	return prevSeg, endTime, nil
+}
+
+type segmentWriter interface {
+	WriteSegment(*hls.Segment) error
+}
+
+// writeSegments writes segments until the context is canceled,
+// the muxer is closed or the max duration is reached.
+func writeSegments(
+	ctx context.Context,
+	w segmentWriter,
+	nextSegment nextSegmentFunc,
+	firstSegment *hls.Segment,
+	maxDuration time.Duration,
+) (uint64, *time.Time, error) {
+	prevSeg := firstSegment.ID
+	stopTime := firstSegment.StartTime.Add(maxDuration)
+	endTime := firstSegment.StartTime
+
 	writeSegment := func(seg *hls.Segment) error {
 		if err := w.WriteSegment(seg); err != nil {
 			return err
@@ -323,13 +519,87 @@ func generateVideo( //nolint:funlen
 	}
 }
 
+// Maximum time to wait for the sub input thumbnail segment.
+const subThumbnailTimeout = 30 * time.Second
+
+// subThumbnail returns the segment and stream info of
+// the thumbnail input at the start of the main segment.
+func (r *Recorder) subThumbnail(mainSegment *hls.Segment) (*hls.Segment, *hls.StreamInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), subThumbnailTimeout)
+	defer cancel()
+
+	info, err := r.thumbInput.StreamInfo(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stream info: %w", err)
+	}
+	muxer, err := r.thumbInput.HLSMuxer()
+	if err != nil {
+		return nil, nil, fmt.Errorf("get muxer: %w", err)
+	}
+	seg, err := findSegment(ctx, muxer.NextSegment, 0, mainSegment.StartTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	return seg, info, nil
+}
+
+// findSegment returns the first segment after prevID that ends
+// after t. Waits for the segment if it isn't finalized yet.
+func findSegment(
+	ctx context.Context,
+	nextSegment nextSegmentFunc,
+	prevID uint64,
+	t time.Time,
+) (*hls.Segment, error) {
+	type result struct {
+		seg *hls.Segment
+		err error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		for ctx.Err() == nil {
+			seg, err := nextSegment(prevID)
+			if err != nil {
+				resChan <- result{err: err}
+				return
+			}
+			if t.Before(seg.StartTime.Add(seg.RenderedDuration)) {
+				resChan <- result{seg: seg}
+				return
+			}
+			prevID = seg.ID
+		}
+	}()
+
+	select {
+	case res := <-resChan:
+		return res.seg, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // The first h264 frame in firstSegment is wrapped in a mp4
 // container and piped into FFmpeg and then converted to jpeg.
+// Recordings without video get a waveform of the first segment.
 func (r *Recorder) generateThumbnail(
 	filePath string,
 	firstSegment *hls.Segment,
 	info hls.StreamInfo,
 ) {
+	if !info.VideoTrackExist && info.AudioTrackExist {
+		r.generateWaveform(filePath, firstSegment, info)
+		return
+	}
+	if r.thumbInput != nil && r.thumbInput != r.input {
+		seg, subInfo, err := r.subThumbnail(firstSegment)
+		if err != nil {
+			r.logf(log.LevelWarning, "sub stream thumbnail: %v, using main stream", err)
+		} else {
+			firstSegment, info = seg, *subInfo
+		}
+	}
+
 	videoBuffer := &bytes.Buffer{}
 	err := mp4muxer.GenerateThumbnailVideo(videoBuffer, firstSegment, info)
 	if err != nil {
@@ -337,17 +607,62 @@ func (r *Recorder) generateThumbnail(
 		return
 	}
 
+	thumbPath := filePath + ".jpeg"
+	args := "-n -threads 1 -loglevel " + r.Config.LogLevel()
+	if hw, err := r.Config.HardwareAccel(); err == nil && hw.Type != ffmpeg.HWAccelNone {
+		if decodeArgs := hw.DecodeArgs(); decodeArgs != "" {
+			args += " " + decodeArgs
+		}
+	}
+	args += " -i -" + // Input.
+		" -frames:v 1 " + thumbPath // Output.
+
+	r.runThumbnailProcess(thumbPath, args, videoBuffer)
+}
+
+// generateWaveform wraps the audio of firstSegment in a fragmented
+// mp4 container that is converted to a waveform image by FFmpeg.
+func (r *Recorder) generateWaveform(
+	filePath string,
+	firstSegment *hls.Segment,
+	info hls.StreamInfo,
+) {
+	var audioSamples []*hls.AudioSample
+	for _, part := range firstSegment.Parts {
+		audioSamples = append(audioSamples, part.AudioSamples...)
+	}
+	if len(audioSamples) == 0 {
+		r.logf(log.LevelError, "generate waveform: %v", mp4muxer.ErrSampleMissing)
+		return
+	}
+
+	init, err := hls.GenerateInit(info)
+	if err != nil {
+		r.logf(log.LevelError, "generate waveform init: %v", err)
+		return
+	}
+	fragment, err := hls.GenerateFragment(audioSamples[0].PTS, info, nil, audioSamples)
+	if err != nil {
+		r.logf(log.LevelError, "generate waveform fragment: %v", err)
+		return
+	}
+	audioBuffer := bytes.NewBuffer(append(init, fragment...))
+
 	thumbPath := filePath + ".jpeg"
 	args := "-n -threads 1 -loglevel " + r.Config.LogLevel() +
 		" -i -" + // Input.
-		" -frames:v 1 " + thumbPath // Output.
+		" -filter_complex showwavespic=s=640x360 -frames:v 1 " + thumbPath // Output.
+
+	r.runThumbnailProcess(thumbPath, args, audioBuffer)
+}
 
+func (r *Recorder) runThumbnailProcess(thumbPath string, args string, input io.Reader) {
 	r.logf(log.LevelInfo, "generating thumbnail: %v", thumbPath)
 
 	r.hooks.RecSave(r, &args)
 
 	cmd := exec.Command(r.Env.FFmpegBin, ffmpeg.ParseArgs(args)...)
-	cmd.Stdin = videoBuffer
+	cmd.Stdin = input
 
 	ffLogLevel := log.FFmpegLevel(r.Config.LogLevel())
 	logFunc := func(msg string) {
@@ -394,11 +709,47 @@ func (r *Recorder) saveRecording(
 		return
 	}
 
+	// The hash must be added before the hooks that may upload or remove the files.
+	r.appendLedger(filePath)
+
+	if r.eventStore != nil {
+		err := r.eventStore.SetRecording(r.Config.ID(), startTime, endTime, filepath.Base(filePath))
+		if err != nil {
+			r.logf(log.LevelError, "set event recording: %v", err)
+		}
+	}
+
 	go r.hooks.RecSaved(r, filePath, data)
 
 	r.logf(log.LevelInfo, "recording saved: %v", filepath.Base(dataPath))
 }
 
+// appendLedger adds the hash of the recording to the integrity ledger.
+func (r *Recorder) appendLedger(filePath string) {
+	if r.ledger == nil {
+		return
+	}
+	hash, err := storage.HashRecording(filePath, r.Env.Crypt)
+	if err != nil {
+		r.logf(log.LevelError, "hash recording: %v", err)
+		return
+	}
+	err = r.ledger.Append(r.Config.ID(), storage.LedgerRecording, filepath.Base(filePath), hash)
+	if err != nil {
+		r.logf(log.LevelError, "append integrity ledger: %v", err)
+	}
+}
+
+// storeEvent adds the event to the event store.
+func (r *Recorder) storeEvent(event storage.Event) {
+	if r.eventStore == nil {
+		return
+	}
+	if _, err := r.eventStore.Add(r.Config.ID(), event); err != nil {
+		r.logf(log.LevelError, "store event: %v", err)
+	}
+}
+
 func (r *Recorder) sendEvent(event storage.Event) error {
 	if err := event.Validate(); err != nil {
 		return fmt.Errorf("invalid event: %w", err)
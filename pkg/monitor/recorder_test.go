@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,6 +33,7 @@ import (
 	"nvr/pkg/storage"
 	"nvr/pkg/video"
 	"nvr/pkg/video/hls"
+	"nvr/pkg/video/mkv"
 
 	"github.com/stretchr/testify/require"
 )
@@ -66,7 +68,8 @@ func newTestRecorder(t *testing.T) *Recorder {
 				HLSMuxer: newMockMuxerFunc(
 					&mockMuxer{
 						streamInfo: &hls.StreamInfo{
-							VideoSPS: []byte{0, 0, 0},
+							VideoTrackExist: true,
+							VideoSPS:        []byte{0, 0, 0},
 						},
 					}),
 			},
@@ -89,6 +92,8 @@ type mockMuxer struct {
 	streamInfo    *hls.StreamInfo
 	streamInfoErr error
 	segCount      int
+	latestPart    *hls.MuxerPart
+	latestPartErr error
 }
 
 func newMockMuxerFunc(muxer *mockMuxer) func() (video.IHLSMuxer, error) {
@@ -112,6 +117,14 @@ func (m *mockMuxer) NextSegment(prevID uint64) (*hls.Segment, error) {
 
 func (m *mockMuxer) WaitForSegFinalized() {}
 
+func (m *mockMuxer) LatestPart() (*hls.MuxerPart, error) {
+	return m.latestPart, m.latestPartErr
+}
+
+func (m *mockMuxer) Liveness() hls.Liveness {
+	return hls.Liveness{}
+}
+
 func TestStartRecorder(t *testing.T) {
 	t.Run("timeout", func(t *testing.T) {
 		onRunRecording := make(chan struct{})
@@ -213,6 +226,54 @@ func TestStartRecorder(t *testing.T) {
 			t.Fatal("the second trigger reset the timeout")
 		}
 	})
+	t.Run("maxDuration", func(t *testing.T) {
+		onCancel := make(chan struct{})
+		mockRunRecording := func(ctx context.Context, _ *Recorder) error {
+			<-ctx.Done()
+			close(onCancel)
+			return nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := newTestRecorder(t)
+		r.Config.v["maxRecordingDuration"] = "0.0001"
+		r.wg.Add(1)
+		r.runSession = mockRunRecording
+		go r.start(ctx)
+
+		r.eventChan <- storage.Event{Time: time.Now(), RecDuration: time.Hour}
+		select {
+		case <-time.After(time.Second):
+			t.Fatal("the session wasn't stopped at the max duration")
+		case <-onCancel:
+		}
+	})
+	t.Run("cooldown", func(t *testing.T) {
+		onCancel := make(chan struct{})
+		mockRunRecording := func(ctx context.Context, _ *Recorder) error {
+			<-ctx.Done()
+			close(onCancel)
+			return nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := newTestRecorder(t)
+		r.Config.v["eventCooldown"] = "1"
+		r.wg.Add(1)
+		r.runSession = mockRunRecording
+		go r.start(ctx)
+
+		r.eventChan <- storage.Event{Time: time.Now(), RecDuration: time.Millisecond}
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-onCancel:
+			t.Fatal("the session was stopped before the cooldown")
+		}
+	})
 	t.Run("normalExit", func(t *testing.T) {
 		onRunRecording := make(chan struct{})
 		exitProcess := make(chan error)
@@ -346,6 +407,13 @@ func TestRunRecording(t *testing.T) {
 		err := runRecording(context.Background(), r)
 		require.ErrorIs(t, err, strconv.ErrSyntax)
 	})
+	t.Run("preEventBufferErr", func(t *testing.T) {
+		r := newTestRecorder(t)
+		r.Config.v["preEventBuffer"] = "-1"
+
+		err := runRecording(context.Background(), r)
+		require.ErrorIs(t, err, ErrInvalidPreEventBuffer)
+	})
 	t.Run("parseOffsetErr", func(t *testing.T) {
 		r := newTestRecorder(t)
 		r.Config.v["timestampOffset"] = ""
@@ -417,6 +485,92 @@ func TestWriteThumbnail(t *testing.T) {
 	})
 }
 
+func TestFindSegment(t *testing.T) {
+	start := time.Unix(1000, 0)
+	segments := []*hls.Segment{
+		{ID: 1, StartTime: start, RenderedDuration: 2 * time.Second},
+		{ID: 2, StartTime: start.Add(2 * time.Second), RenderedDuration: 2 * time.Second},
+		{ID: 3, StartTime: start.Add(4 * time.Second), RenderedDuration: 2 * time.Second},
+	}
+	nextSegment := func(prevID uint64) (*hls.Segment, error) {
+		if int(prevID) >= len(segments) {
+			return nil, context.Canceled
+		}
+		return segments[prevID], nil
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		seg, err := findSegment(context.Background(), nextSegment, 0, start.Add(3*time.Second))
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), seg.ID)
+	})
+	t.Run("beforeOldest", func(t *testing.T) {
+		seg, err := findSegment(context.Background(), nextSegment, 0, start.Add(-time.Second))
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), seg.ID)
+	})
+	t.Run("afterPrevID", func(t *testing.T) {
+		seg, err := findSegment(context.Background(), nextSegment, 2, start)
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), seg.ID)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		_, err := findSegment(context.Background(), nextSegment, 0, start.Add(time.Minute))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestSessionStopTime(t *testing.T) {
+	timerEnd := time.Unix(100, 0)
+	require.Equal(t, timerEnd, sessionStopTime(timerEnd, time.Time{}))
+	require.Equal(t, timerEnd, sessionStopTime(timerEnd, time.Unix(200, 0)))
+	require.Equal(t, time.Unix(50, 0), sessionStopTime(timerEnd, time.Unix(50, 0)))
+}
+
+func TestRecorderDurations(t *testing.T) {
+	c := NewConfig(RawConfig{
+		"eventCooldown":        "30",
+		"maxRecordingDuration": "1.5",
+	})
+	cooldown, err := c.eventCooldown()
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, cooldown)
+
+	maxDuration, err := c.maxRecordingDuration()
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Second, maxDuration)
+
+	c = NewConfig(RawConfig{
+		"eventCooldown":        "x",
+		"maxRecordingDuration": "-1",
+	})
+	_, err = c.eventCooldown()
+	require.ErrorIs(t, err, ErrInvalidEventCooldown)
+	_, err = c.maxRecordingDuration()
+	require.ErrorIs(t, err, ErrInvalidMaxRecordingDuration)
+}
+
+func TestPreEventBuffer(t *testing.T) {
+	cases := map[string]struct {
+		input       string
+		expected    time.Duration
+		expectedErr error
+	}{
+		"empty":    {"", 0, nil},
+		"ok":       {"2.5", 2500 * time.Millisecond, nil},
+		"negative": {"-1", 0, ErrInvalidPreEventBuffer},
+		"invalid":  {"x", 0, ErrInvalidPreEventBuffer},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig(RawConfig{"preEventBuffer": tc.input})
+			actual, err := c.preEventBuffer()
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
 func TestSaveRecording(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		r := newTestRecorder(t)
@@ -468,3 +622,126 @@ func TestSaveRecording(t *testing.T) {
 		require.Equal(t, actual, expected)
 	})
 }
+
+func TestGenerateMKV(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rec")
+	start := time.Unix(1000, 0)
+	first := &hls.Segment{
+		ID:               1,
+		StartTime:        start,
+		RenderedDuration: time.Second,
+		Parts: []*hls.MuxerPart{{
+			VideoSamples: []*hls.VideoSample{{
+				PTS:        start.UnixNano(),
+				DTS:        start.UnixNano(),
+				AVCC:       []byte{1, 2, 3},
+				IdrPresent: true,
+			}},
+		}},
+	}
+	nextSegment := func(prevID uint64) (*hls.Segment, error) {
+		if prevID == 2 {
+			return nil, context.Canceled
+		}
+		return &hls.Segment{
+			ID:               prevID + 1,
+			StartTime:        start.Add(time.Duration(prevID) * time.Second),
+			RenderedDuration: time.Second,
+		}, nil
+	}
+	info := hls.StreamInfo{
+		VideoTrackExist: true,
+		VideoSPS:        []byte{103, 0, 0, 0, 172, 217, 0},
+		VideoPPS:        []byte{2, 3, 4},
+	}
+
+	prevSeg, endTime, err := generateMKV(
+		context.Background(), filePath, nil, nextSegment, first, info, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), prevSeg)
+	require.Equal(t, start.Add(2*time.Second), *endTime)
+
+	file, err := os.Open(filePath + ".mkv")
+	require.NoError(t, err)
+	defer file.Close()
+	stat, err := file.Stat()
+	require.NoError(t, err)
+
+	recStart, _, err := mkv.ReadTimes(file, stat.Size())
+	require.NoError(t, err)
+	require.True(t, start.Equal(recStart))
+}
+
+func TestMKVContainer(t *testing.T) {
+	require.False(t, NewConfig(RawConfig{}).mkvContainer())
+	require.False(t, NewConfig(RawConfig{"recordingContainer": "mp4"}).mkvContainer())
+	require.True(t, NewConfig(RawConfig{"recordingContainer": "mkv"}).mkvContainer())
+}
+
+func TestWithoutAudio(t *testing.T) {
+	seg := &hls.Segment{
+		ID:               1,
+		StartTime:        time.Unix(1, 0),
+		RenderedDuration: time.Second,
+		Parts: []*hls.MuxerPart{{
+			VideoSamples: []*hls.VideoSample{{PTS: 1}},
+			AudioSamples: []*hls.AudioSample{{PTS: 2}},
+		}},
+	}
+	actual := withoutAudio(seg)
+	require.Equal(t, uint64(1), actual.ID)
+	require.Equal(t, seg.StartTime, actual.StartTime)
+	require.Equal(t, seg.RenderedDuration, actual.RenderedDuration)
+	require.Equal(t, seg.Parts[0].VideoSamples, actual.Parts[0].VideoSamples)
+	require.Empty(t, actual.Parts[0].AudioSamples)
+	require.Len(t, seg.Parts[0].AudioSamples, 1, "the original should be unchanged")
+
+	info := withoutAudioTrack(hls.StreamInfo{
+		VideoTrackExist:  true,
+		AudioTrackExist:  true,
+		AudioTrackConfig: []byte{1},
+	})
+	require.True(t, info.VideoTrackExist)
+	require.False(t, info.AudioTrackExist)
+	require.Nil(t, info.AudioTrackConfig)
+
+	require.True(t, NewConfig(RawConfig{}).recordAudio())
+	require.False(t, NewConfig(RawConfig{"recordAudio": "false"}).recordAudio())
+}
+
+func TestRunRecordingNoTracks(t *testing.T) {
+	r := newTestRecorder(t)
+	r.Config.v["recordAudio"] = "false"
+	r.input.serverPath.HLSMuxer = newMockMuxerFunc(&mockMuxer{
+		streamInfo: &hls.StreamInfo{AudioTrackExist: true},
+	})
+
+	err := runRecording(context.Background(), r)
+	require.ErrorIs(t, err, ErrNoTracks)
+}
+
+func TestWriteWaveform(t *testing.T) {
+	r := newTestRecorder(t)
+	var args string
+	r.hooks.RecSave = func(_ *Recorder, a *string) {
+		args = *a
+	}
+
+	segment := &hls.Segment{
+		Parts: []*hls.MuxerPart{{
+			AudioSamples: []*hls.AudioSample{{
+				AU:      []byte{1, 2},
+				PTS:     0,
+				NextPTS: int64(20 * time.Millisecond),
+			}},
+		}},
+	}
+	info := hls.StreamInfo{
+		AudioTrackExist:   true,
+		AudioTrackConfig:  []byte{18, 16},
+		AudioChannelCount: 2,
+		AudioClockRate:    44100,
+	}
+	r.generateThumbnail(filepath.Join(t.TempDir(), "rec"), segment, info)
+	require.Contains(t, args, "showwavespic")
+}
@@ -0,0 +1,93 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ReloadResult lists the monitors that were affected by a reload.
+type ReloadResult struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// Empty returns true if nothing changed.
+func (r ReloadResult) Empty() bool {
+	return len(r.Added) == 0 && len(r.Changed) == 0 && len(r.Removed) == 0
+}
+
+// Reload reads the config files again and applies the difference.
+// Only added, changed and removed monitors are started or stopped,
+// the other monitors keep running. Nothing is applied if any of
+// the config files are invalid.
+func (m *Manager) Reload() (ReloadResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rawConfigs, err := loadConfigs(m.path, m.hooks.Migrate)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	result := ReloadResult{
+		Added:   []string{},
+		Changed: []string{},
+		Removed: []string{},
+	}
+	for id := range m.rawConfigs {
+		if _, exist := rawConfigs[id]; !exist {
+			result.Removed = append(result.Removed, id)
+		}
+	}
+	for id, rawConf := range rawConfigs {
+		oldConf, exist := m.rawConfigs[id]
+		if !exist {
+			result.Added = append(result.Added, id)
+			continue
+		}
+		if !reflect.DeepEqual(oldConf, rawConf) {
+			result.Changed = append(result.Changed, id)
+		}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Removed)
+
+	stop := func(id string) {
+		if _, running := m.runningMonitors[id]; running {
+			m.unsafeStopMonitor(id)
+		}
+	}
+	for _, id := range result.Removed {
+		stop(id)
+	}
+	for _, id := range result.Changed {
+		stop(id)
+	}
+
+	m.rawConfigs = rawConfigs
+
+	for _, id := range result.Changed {
+		m.unsafeStartMonitor(id)
+	}
+	for _, id := range result.Added {
+		m.unsafeStartMonitor(id)
+	}
+	return result, nil
+}
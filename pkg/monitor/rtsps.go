@@ -0,0 +1,224 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"nvr/pkg/log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RTSPS errors.
+var (
+	ErrRTSPSNoCerts             = errors.New("no certificates found in CA file")
+	ErrRTSPSInvalidFingerprint  = errors.New("fingerprint must be a hex encoded SHA-256 hash")
+	ErrRTSPSFingerprintMismatch = errors.New("certificate fingerprint mismatch")
+)
+
+const defaultRTSPSPort = "322"
+
+// newRTSPSTLSConfig returns the TLS config used to connect to a RTSPS server.
+// The certificate is verified against caFile, or the system roots if empty.
+// If fingerprint is set, the SHA-256 fingerprint of the leaf certificate must
+// match and the chain is not verified, this allows self-signed cameras.
+func newRTSPSTLSConfig(host string, caFile string, fingerprint string) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if fingerprint != "" {
+		pin, err := parseFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// The fingerprint replaces the chain verification.
+		conf.InsecureSkipVerify = true //nolint:gosec
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrRTSPSFingerprintMismatch
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("%w: %X", ErrRTSPSFingerprintMismatch, sum)
+			}
+			return nil
+		}
+		return conf, nil
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrRTSPSNoCerts, caFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+// parseFingerprint accepts hex with or without
+// colons, for example "AB:CD:.." or "abcd..".
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ":", "")
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		return nil, ErrRTSPSInvalidFingerprint
+	}
+	return b, nil
+}
+
+// rtspsProxy forwards local plain TCP connections to a RTSPS server.
+// FFmpeg connects to the proxy because it can't verify custom CA
+// bundles or pinned certificates. Only RTSP over TCP is supported.
+type rtspsProxy struct {
+	ln      net.Listener
+	target  string
+	tlsConf *tls.Config
+	logf    func(format string, a ...interface{})
+}
+
+func newRTSPSProxy(
+	target string,
+	tlsConf *tls.Config,
+	logf func(format string, a ...interface{}),
+) (*rtspsProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &rtspsProxy{
+		ln:      ln,
+		target:  target,
+		tlsConf: tlsConf,
+		logf:    logf,
+	}, nil
+}
+
+func (p *rtspsProxy) address() string {
+	return p.ln.Addr().String()
+}
+
+// run accepts connections until the context is canceled.
+func (p *rtspsProxy) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-ctx.Done()
+		p.ln.Close()
+	}()
+
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.forward(ctx, conn); err != nil {
+				p.logf("%v", err)
+			}
+		}()
+	}
+}
+
+func (p *rtspsProxy) forward(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config:    p.tlsConf,
+	}
+	upstream, err := dialer.DialContext(ctx, "tcp", p.target)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer upstream.Close()
+
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx2.Done()
+		conn.Close()
+		upstream.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, conn) //nolint:errcheck
+		cancel()
+		close(done)
+	}()
+	io.Copy(conn, upstream) //nolint:errcheck
+	cancel()
+	<-done
+	return nil
+}
+
+// startRTSPSProxy starts a proxy to the RTSPS input and returns the input
+// url rewritten to use the proxy. The credentials, path and query are kept.
+func (i *InputProcess) startRTSPSProxy(ctx context.Context) (string, error) {
+	u, err := url.Parse(i.input())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultRTSPSPort
+	}
+	target := net.JoinHostPort(u.Hostname(), port)
+
+	tlsConf, err := newRTSPSTLSConfig(u.Hostname(), i.Config.RTSPSCA(), i.Config.RTSPSFingerprint())
+	if err != nil {
+		return "", err
+	}
+
+	logf := func(format string, a ...interface{}) {
+		i.logf(log.LevelError, "%v process: rtsps proxy: %v", i.ProcessName(), fmt.Sprintf(format, a...))
+	}
+	proxy, err := newRTSPSProxy(target, tlsConf, logf)
+	if err != nil {
+		return "", err
+	}
+	go proxy.run(ctx)
+
+	u.Scheme = "rtsp"
+	u.Host = proxy.address()
+	return u.String(), nil
+}
+
+func isRTSPS(rawURL string) bool {
+	return strings.HasPrefix(strings.ToLower(rawURL), "rtsps://")
+}
@@ -0,0 +1,105 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"fmt"
+	"nvr/pkg/storage"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// resolveSecrets returns a copy of the config with the environment
+// overrides and secret references applied, the config files only
+// contain the references. The "mainInput" of monitor "garage" is
+// overridden by NVR_MONITOR_GARAGE_MAIN_INPUT or the _FILE variant.
+// Overrides of keys that aren't in the config are added in camel case.
+func resolveSecrets(rawConf RawConfig) (RawConfig, error) {
+	id := rawConf["id"]
+	resolved := make(RawConfig, len(rawConf))
+	overridden := make(map[string]struct{}, len(rawConf))
+	for key, value := range rawConf {
+		if key != "id" && id != "" {
+			name := storage.EnvVarName("monitor", id, key)
+			overridden[name] = struct{}{}
+			override, exist, err := storage.LookupSecret(name)
+			if err != nil {
+				return nil, err
+			}
+			if exist {
+				resolved[key] = override
+				continue
+			}
+		}
+		expanded, err := storage.ExpandSecrets(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", key, err)
+		}
+		resolved[key] = expanded
+	}
+	if id == "" {
+		return resolved, nil
+	}
+
+	prefix := storage.EnvVarName("monitor", id) + "_"
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = strings.TrimSuffix(name, "_FILE")
+		if _, exist := overridden[name]; exist {
+			continue
+		}
+		overridden[name] = struct{}{}
+
+		key := lowerCamelCase(strings.TrimPrefix(name, prefix))
+		if key == "" || key == "id" {
+			continue
+		}
+		if _, exist := resolved[key]; exist {
+			continue
+		}
+		override, exist, err := storage.LookupSecret(name)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			resolved[key] = override
+		}
+	}
+	return resolved, nil
+}
+
+// lowerCamelCase converts a upper snake case
+// name to camel case, "MAIN_INPUT" becomes "mainInput".
+func lowerCamelCase(s string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(strings.ToLower(s), "_") {
+		if word == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(word)
+			continue
+		}
+		runes := []rune(word)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	return b.String()
+}
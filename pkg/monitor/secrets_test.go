@@ -0,0 +1,88 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"nvr/pkg/storage"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("CAM_PASS", "secret")
+	t.Setenv("NVR_MONITOR_GARAGE_SUB_INPUT", "rtsp://override")
+
+	rawConf := RawConfig{
+		"id":        "garage",
+		"mainInput": "rtsp://admin:${CAM_PASS}@x",
+		"subInput":  "rtsp://x",
+	}
+	resolved, err := resolveSecrets(rawConf)
+	require.NoError(t, err)
+
+	expected := RawConfig{
+		"id":        "garage",
+		"mainInput": "rtsp://admin:secret@x",
+		"subInput":  "rtsp://override",
+	}
+	require.Equal(t, expected, resolved)
+	require.Equal(t, "rtsp://admin:${CAM_PASS}@x", rawConf["mainInput"])
+
+	_, err = resolveSecrets(RawConfig{"id": "x", "mainInput": "${NIL}"})
+	require.ErrorIs(t, err, storage.ErrSecretNotFound)
+}
+
+func TestResolveSecretsMissingKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub")
+	require.NoError(t, os.WriteFile(path, []byte("rtsp://file\n"), 0o600))
+	t.Setenv("NVR_MONITOR_GARAGE_SUB_INPUT_FILE", path)
+	t.Setenv("NVR_MONITOR_GARAGE_ID", "x")
+	t.Setenv("NVR_MONITOR_GARAGE_MAIN_INPUT", "rtsp://main")
+
+	rawConf := RawConfig{
+		"id":        "garage",
+		"mainInput": "rtsp://x",
+	}
+	resolved, err := resolveSecrets(rawConf)
+	require.NoError(t, err)
+
+	expected := RawConfig{
+		"id":        "garage",
+		"mainInput": "rtsp://main",
+		"subInput":  "rtsp://file",
+	}
+	require.Equal(t, expected, resolved)
+	require.Equal(t, RawConfig{"id": "garage", "mainInput": "rtsp://x"}, rawConf)
+}
+
+func TestLowerCamelCase(t *testing.T) {
+	cases := map[string]string{
+		"MAIN_INPUT":       "mainInput",
+		"HWACCEL":          "hwaccel",
+		"LOG_LEVEL_":       "logLevel",
+		"_SUB_INPUT":       "subInput",
+		"":                 "",
+		"TIMESTAMP_OFFSET": "timestampOffset",
+	}
+	for input, want := range cases {
+		t.Run(input, func(t *testing.T) {
+			require.Equal(t, want, lowerCamelCase(input))
+		})
+	}
+}
@@ -0,0 +1,491 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/video/hls"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// segmentRecorder continuously records the main input to
+// fixed-length fMP4 files that are added to the segment index.
+type segmentRecorder struct {
+	Config Config
+
+	input       *InputProcess
+	index       *storage.Index
+	segmentsDir string
+	crypt       *storage.Crypt
+	ledger      *storage.Ledger
+
+	// Returns true if low priority monitors should pause.
+	diskPaused func() bool
+
+	// Time of the latest event, used to detect static segments.
+	lastEvent   time.Time
+	lastEventMu sync.Mutex
+
+	logf logFunc
+	wg   *sync.WaitGroup
+
+	sleep   time.Duration
+	prevSeg uint64
+}
+
+func newSegmentRecorder(m *Monitor) *segmentRecorder {
+	monitorID := m.Config.ID()
+	logf := func(level log.Level, format string, a ...interface{}) {
+		m.Logger.Log(log.Entry{
+			Level:     level,
+			Src:       "recorder",
+			MonitorID: monitorID,
+			Msg:       fmt.Sprintf(format, a...),
+		})
+	}
+	return &segmentRecorder{
+		Config: m.Config,
+
+		input:       m.mainInput,
+		index:       m.index,
+		segmentsDir: m.Env.SegmentsDir(),
+		crypt:       m.Env.Crypt,
+		ledger:      m.ledger,
+		diskPaused:  m.disk.Paused,
+
+		logf: logf,
+		wg:   &m.WG,
+
+		sleep: 3 * time.Second,
+	}
+}
+
+func (s *segmentRecorder) start(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		err := s.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.logf(log.LevelError, "continuous recording crashed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.sleep):
+		}
+	}
+}
+
+// Default segment file length.
+const defaultSegmentLength = 60 * time.Second
+
+// ErrInvalidSegmentLength invalid segment length.
+var ErrInvalidSegmentLength = errors.New("invalid segment length")
+
+func (s *segmentRecorder) segmentLength() (time.Duration, error) {
+	return parseSegmentLength(s.Config.SegmentLength())
+}
+
+func parseSegmentLength(raw string) (time.Duration, error) {
+	if raw == "" {
+		return defaultSegmentLength, nil
+	}
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse segment length: %w", err)
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSegmentLength, raw)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (s *segmentRecorder) run(ctx context.Context) error {
+	length, err := s.segmentLength()
+	if err != nil {
+		return err
+	}
+	timestampOffsetInt, err := strconv.Atoi(s.Config.TimestampOffset())
+	if err != nil {
+		return fmt.Errorf("parse timestamp offset %w", err)
+	}
+	offset := time.Duration(timestampOffsetInt) * time.Millisecond
+
+	muxer, err := s.input.HLSMuxer()
+	if err != nil {
+		return fmt.Errorf("get muxer: %w", err)
+	}
+	info, err := s.input.StreamInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+
+	nextSegment := muxer.NextSegment
+	if !s.Config.recordAudio() {
+		info = withoutAudioTrack(*info)
+		nextSegment = func(prevID uint64) (*hls.Segment, error) {
+			seg, err := muxer.NextSegment(prevID)
+			if err != nil {
+				return nil, err
+			}
+			return withoutAudio(seg), nil
+		}
+	}
+	if !info.VideoTrackExist && !info.AudioTrackExist {
+		return ErrNoTracks
+	}
+
+	monitorID := s.Config.ID()
+	paused := false
+	for {
+		firstSegment, err := nextSegment(s.prevSeg)
+		if err != nil {
+			return fmt.Errorf("next segment: %w", err)
+		}
+
+		// Segments are skipped while paused to keep the disk from filling.
+		if s.Config.lowPriority() && s.diskPaused() {
+			if !paused {
+				s.logf(log.LevelWarning, "continuous recording paused, storage disk is almost full")
+				paused = true
+			}
+			s.prevSeg = firstSegment.ID
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+		if paused {
+			s.logf(log.LevelInfo, "continuous recording resumed")
+			paused = false
+		}
+
+		startTime := firstSegment.StartTime.Add(-offset)
+		relPath := filepath.Join(
+			startTime.Format("2006/01/02/")+monitorID,
+			startTime.Format("2006-01-02_15-04-05_")+monitorID+".mp4",
+		)
+		path := filepath.Join(s.segmentsDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("make directory for segment: %w", err)
+		}
+
+		prevSeg, file, err := writeSegmentFile(
+			ctx, path, s.crypt, nextSegment, firstSegment, *info, length)
+		if err != nil {
+			return fmt.Errorf("write segment: %w", err)
+		}
+		s.prevSeg = prevSeg
+
+		segStart, segEnd := file.start.Add(-offset), file.end.Add(-offset)
+		if s.Config.skipStatic() && s.isStatic(segStart) {
+			if err := s.saveStatic(path, relPath, segStart, segEnd); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+
+		err = s.index.Add(storage.SegmentInfo{
+			MonitorID: monitorID,
+			Path:      relPath,
+			Start:     segStart,
+			End:       segEnd,
+			Size:      file.size,
+			Keyframes: offsetKeyframes(file.keyframes, offset),
+		})
+		if err != nil {
+			return fmt.Errorf("index segment: %w", err)
+		}
+		if s.ledger != nil {
+			err := s.ledger.Append(monitorID, storage.LedgerSegment, relPath, file.sha256)
+			if err != nil {
+				s.logf(log.LevelError, "append integrity ledger: %v", err)
+			}
+		}
+		s.logf(log.LevelDebug, "segment saved: %v", filepath.Base(path))
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Events this long before the segment start still count as motion
+// in the segment since detectors report events with a delay.
+const staticEventMargin = 5 * time.Second
+
+// onEvent is called by the monitor for every event.
+func (s *segmentRecorder) onEvent(t time.Time) {
+	s.lastEventMu.Lock()
+	defer s.lastEventMu.Unlock()
+	if t.After(s.lastEvent) {
+		s.lastEvent = t
+	}
+}
+
+// isStatic returns true if there hasn't been any
+// events since the segment started.
+func (s *segmentRecorder) isStatic(segStart time.Time) bool {
+	s.lastEventMu.Lock()
+	defer s.lastEventMu.Unlock()
+	return s.lastEvent.Before(segStart.Add(-staticEventMargin))
+}
+
+// saveStatic deletes the segment file and stores a
+// static marker in the index so that the gap is shown.
+func (s *segmentRecorder) saveStatic(
+	path string,
+	relPath string,
+	start time.Time,
+	end time.Time,
+) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove static segment: %w", err)
+	}
+	err := s.index.Add(storage.SegmentInfo{
+		MonitorID: s.Config.ID(),
+		Path:      relPath,
+		Start:     start,
+		End:       end,
+		Static:    true,
+	})
+	if err != nil {
+		return fmt.Errorf("index static marker: %w", err)
+	}
+	s.logf(log.LevelDebug, "static segment skipped: %v", filepath.Base(path))
+	return nil
+}
+
+func offsetKeyframes(keyframes []storage.Keyframe, offset time.Duration) []storage.Keyframe {
+	for i := range keyframes {
+		keyframes[i].Time = keyframes[i].Time.Add(-offset)
+	}
+	return keyframes
+}
+
+// segmentFile information about a written segment file.
+type segmentFile struct {
+	start     time.Time
+	end       time.Time
+	size      int64
+	keyframes []storage.Keyframe
+
+	// Hex encoded SHA-256 of the plain text.
+	sha256 string
+}
+
+// writeSegmentFile writes HLS segments to a fMP4 file until the
+// length is reached. A new fragment is started at each keyframe
+// so that playback can start at any of the keyframe offsets.
+// The file is encrypted if crypt isn't nil, the offsets and
+// size are of the plain text.
+func writeSegmentFile(
+	ctx context.Context,
+	path string,
+	crypt *storage.Crypt,
+	nextSegment nextSegmentFunc,
+	firstSegment *hls.Segment,
+	info hls.StreamInfo,
+	length time.Duration,
+) (uint64, *segmentFile, error) {
+	file, err := storage.CreateRecordingFile(path, crypt)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	init, err := hls.GenerateInit(info)
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate init: %w", err)
+	}
+	hash := sha256.New()
+	out := io.MultiWriter(file, hash)
+	if _, err := out.Write(init); err != nil {
+		return 0, nil, err
+	}
+
+	w := &fragmentWriter{
+		file:     out,
+		info:     info,
+		size:     int64(len(init)),
+		baseTime: segmentBaseTime(firstSegment),
+	}
+
+	prevSeg := firstSegment.ID
+	stopTime := firstSegment.StartTime.Add(length)
+	seg := firstSegment
+	for {
+		if err := w.writeSegment(seg); err != nil {
+			return 0, nil, err
+		}
+		prevSeg = seg.ID
+
+		if ctx.Err() != nil || !seg.StartTime.Add(seg.RenderedDuration).Before(stopTime) {
+			break
+		}
+
+		seg, err = nextSegment(prevSeg)
+		if err != nil {
+			break
+		}
+		if seg.ID != prevSeg+1 {
+			return 0, nil, fmt.Errorf("%w: expected: %v got %v",
+				ErrSkippedSegment, prevSeg+1, seg.ID)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return 0, nil, err
+	}
+
+	return prevSeg, &segmentFile{
+		start:     time.Unix(0, w.baseTime),
+		end:       time.Unix(0, w.endTime),
+		size:      w.size,
+		keyframes: w.keyframes,
+		sha256:    hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// segmentBaseTime returns the earliest sample time of the segment.
+func segmentBaseTime(seg *hls.Segment) int64 {
+	for _, part := range seg.Parts {
+		var base int64
+		found := false
+		if len(part.VideoSamples) != 0 {
+			base = part.VideoSamples[0].DTS
+			found = true
+		}
+		if len(part.AudioSamples) != 0 {
+			if pts := part.AudioSamples[0].PTS; !found || pts < base {
+				base = pts
+				found = true
+			}
+		}
+		if found {
+			return base
+		}
+	}
+	return seg.StartTime.UnixNano()
+}
+
+type fragmentWriter struct {
+	file      io.Writer
+	info      hls.StreamInfo
+	size      int64
+	baseTime  int64
+	endTime   int64
+	keyframes []storage.Keyframe
+}
+
+func (w *fragmentWriter) writeSegment(seg *hls.Segment) error {
+	for _, part := range seg.Parts {
+		if err := w.writePart(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePart writes the part as one or more fragments, the
+// part is split at keyframes that aren't the first sample.
+func (w *fragmentWriter) writePart(part *hls.MuxerPart) error {
+	video := part.VideoSamples
+	audio := part.AudioSamples
+	for len(video) != 0 || len(audio) != 0 {
+		n := len(video)
+		for i := 1; i < len(video); i++ {
+			if video[i].IdrPresent {
+				n = i
+				break
+			}
+		}
+		fragVideo := video[:n]
+		fragAudio := audio
+		if n < len(video) {
+			splitDTS := video[n].DTS
+			a := 0
+			for a < len(audio) && audio[a].PTS < splitDTS {
+				a++
+			}
+			fragAudio = audio[:a]
+		}
+		video = video[n:]
+		audio = audio[len(fragAudio):]
+
+		if err := w.writeFragment(fragVideo, fragAudio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *fragmentWriter) writeFragment(video []*hls.VideoSample, audio []*hls.AudioSample) error {
+	if len(video) == 0 && len(audio) == 0 {
+		return nil
+	}
+	fragment, err := hls.GenerateFragment(w.baseTime, w.info, video, audio)
+	if err != nil {
+		return fmt.Errorf("generate fragment: %w", err)
+	}
+
+	if len(video) != 0 && video[0].IdrPresent {
+		w.keyframes = append(w.keyframes, storage.Keyframe{
+			Time:   time.Unix(0, video[0].PTS),
+			Offset: w.size,
+		})
+	}
+	// Every audio sample is a sync sample, each fragment can be played
+	// on its own if there is no video.
+	if !w.info.VideoTrackExist && len(audio) != 0 {
+		w.keyframes = append(w.keyframes, storage.Keyframe{
+			Time:   time.Unix(0, audio[0].PTS),
+			Offset: w.size,
+		})
+	}
+	if len(video) != 0 {
+		if end := video[len(video)-1].NextDTS; end > w.endTime {
+			w.endTime = end
+		}
+	}
+	if len(audio) != 0 {
+		if end := audio[len(audio)-1].NextPTS; end > w.endTime {
+			w.endTime = end
+		}
+	}
+
+	if _, err := w.file.Write(fragment); err != nil {
+		return err
+	}
+	w.size += int64(len(fragment))
+	return nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/storage"
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readBoxTypes returns the types of the top level mp4 boxes.
+func readBoxTypes(t *testing.T, buf []byte) []string {
+	t.Helper()
+	var types []string
+	for len(buf) != 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+		size := int(binary.BigEndian.Uint32(buf))
+		require.GreaterOrEqual(t, len(buf), size)
+		types = append(types, string(buf[4:8]))
+		buf = buf[size:]
+	}
+	return types
+}
+
+func testHLSSegment(id uint64, start int64, idrs ...bool) *hls.Segment {
+	const sampleDuration = int64(time.Second)
+	var samples []*hls.VideoSample
+	for i, idr := range idrs {
+		dts := start + int64(i)*sampleDuration
+		samples = append(samples, &hls.VideoSample{
+			PTS:        dts,
+			DTS:        dts,
+			AVCC:       []byte{0, 0, 0, 1, 5},
+			IdrPresent: idr,
+			NextDTS:    dts + sampleDuration,
+		})
+	}
+	return &hls.Segment{
+		ID:               id,
+		StartTime:        time.Unix(0, start),
+		RenderedDuration: time.Duration(len(idrs)) * time.Second,
+		Parts:            []*hls.MuxerPart{{VideoSamples: samples}},
+	}
+}
+
+func TestWriteSegmentFile(t *testing.T) {
+	info := hls.StreamInfo{
+		VideoTrackExist: true,
+		VideoSPS:        []byte{0, 0, 0},
+	}
+	t.Run("ok", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.mp4")
+
+		second := int64(time.Second)
+		first := testHLSSegment(1, 0, true, false, true)
+		segments := map[uint64]*hls.Segment{
+			1: testHLSSegment(2, 3*second, true, false),
+			2: testHLSSegment(3, 5*second, true),
+		}
+		nextSegment := func(prevID uint64) (*hls.Segment, error) {
+			return segments[prevID], nil
+		}
+
+		prevSeg, file, err := writeSegmentFile(
+			context.Background(), path, nil, nextSegment, first, info, 5*time.Second)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), prevSeg)
+
+		buf, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(buf)), file.size)
+
+		hash, err := storage.HashSegment(path, file.size, nil)
+		require.NoError(t, err)
+		require.Equal(t, hash, file.sha256)
+
+		// The first part is split at the second keyframe.
+		expected := []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat", "moof", "mdat"}
+		require.Equal(t, expected, readBoxTypes(t, buf))
+
+		require.Equal(t, time.Unix(0, 0), file.start)
+		require.Equal(t, time.Unix(5, 0), file.end)
+		require.Len(t, file.keyframes, 3)
+		require.Equal(t, time.Unix(2, 0), file.keyframes[1].Time)
+		for _, kf := range file.keyframes {
+			require.Equal(t, "moof", string(buf[kf.Offset+4:kf.Offset+8]))
+		}
+	})
+	t.Run("encrypted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.mp4")
+		crypt, err := storage.NewCrypt(make([]byte, 32))
+		require.NoError(t, err)
+
+		nextSegment := func(uint64) (*hls.Segment, error) {
+			return nil, context.Canceled
+		}
+		_, file, err := writeSegmentFile(
+			context.Background(),
+			path,
+			crypt,
+			nextSegment,
+			testHLSSegment(1, 0, true, false),
+			info,
+			time.Hour,
+		)
+		require.NoError(t, err)
+
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NotContains(t, string(raw), "moof")
+
+		// The offsets are of the plain text.
+		reader, err := storage.OpenRecordingFile(path, crypt)
+		require.NoError(t, err)
+		defer reader.Close()
+		require.Equal(t, file.size, reader.Size())
+
+		buf, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		expected := []string{"ftyp", "moov", "moof", "mdat"}
+		require.Equal(t, expected, readBoxTypes(t, buf))
+	})
+	t.Run("skippedSegment", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.mp4")
+		nextSegment := func(prevID uint64) (*hls.Segment, error) {
+			return testHLSSegment(prevID+2, 0, true), nil
+		}
+		_, _, err := writeSegmentFile(
+			context.Background(),
+			path,
+			nil,
+			nextSegment,
+			testHLSSegment(1, 0, true),
+			info,
+			time.Hour,
+		)
+		require.ErrorIs(t, err, ErrSkippedSegment)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.mp4")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		nextSegment := func(uint64) (*hls.Segment, error) {
+			t.Fatal("next segment should not be called")
+			return nil, nil
+		}
+		prevSeg, _, err := writeSegmentFile(
+			ctx, path, nil, nextSegment, testHLSSegment(1, 0, true), info, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), prevSeg)
+	})
+}
+
+func TestSegmentLength(t *testing.T) {
+	cases := map[string]struct {
+		input       string
+		expected    time.Duration
+		expectedErr error
+	}{
+		"default": {"", defaultSegmentLength, nil},
+		"ok":      {"10", 10 * time.Second, nil},
+		"zero":    {"0", 0, ErrInvalidSegmentLength},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := &segmentRecorder{
+				Config: NewConfig(RawConfig{"segmentLength": tc.input}),
+			}
+			actual, err := s.segmentLength()
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestOffsetKeyframes(t *testing.T) {
+	keyframes := []storage.Keyframe{{Time: time.Unix(10, 0), Offset: 1}}
+	actual := offsetKeyframes(keyframes, time.Second)
+	require.Equal(t, []storage.Keyframe{{Time: time.Unix(9, 0), Offset: 1}}, actual)
+}
+
+func TestStaticSegments(t *testing.T) {
+	index, err := storage.OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	defer index.Close()
+
+	s := &segmentRecorder{
+		Config: NewConfig(RawConfig{"id": "m1", "skipStaticSegments": "true"}),
+		index:  index,
+		logf:   func(log.Level, string, ...interface{}) {},
+	}
+	require.True(t, s.Config.skipStatic())
+
+	start := time.Unix(100, 0)
+	require.True(t, s.isStatic(start))
+
+	s.onEvent(start.Add(-staticEventMargin))
+	require.False(t, s.isStatic(start))
+
+	// Older events don't replace newer ones.
+	s.onEvent(start.Add(-time.Hour))
+	require.False(t, s.isStatic(start))
+	require.True(t, s.isStatic(start.Add(time.Minute)))
+
+	dir := t.TempDir()
+	relPath := "1970/01/01/m1/a.mp4"
+	path := filepath.Join(dir, relPath)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte("x"), 0o600))
+
+	end := start.Add(time.Minute)
+	require.NoError(t, s.saveStatic(path, relPath, start, end))
+	require.NoFileExists(t, path)
+	require.Empty(t, index.Query("m1", start, end))
+	require.Equal(t, []storage.SegmentInfo{{
+		MonitorID: "m1",
+		Path:      relPath,
+		Start:     start,
+		End:       end,
+		Static:    true,
+	}}, index.QueryStatic("m1", start, end))
+}
@@ -0,0 +1,201 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"nvr/pkg/ffmpeg"
+	"nvr/pkg/video"
+	"nvr/pkg/video/hls"
+	"nvr/pkg/video/mp4muxer"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Snapshot errors.
+var (
+	ErrInputNotOnline = errors.New("input is not online")
+	ErrNoVideoTrack   = errors.New("stream doesn't have a video track")
+	ErrNoKeyframe     = errors.New("part doesn't contain a keyframe")
+)
+
+// Maximum time to wait for the stream and decoder.
+const snapshotTimeout = 10 * time.Second
+
+// SnapshotMaxAge snapshots are reused for this duration, clients
+// polling the snapshot don't start a decoder for every request.
+const SnapshotMaxAge = 2 * time.Second
+
+// Snapshot returns the latest keyframe of a running monitor decoded to
+// jpeg. The main input is used to get the highest resolution.
+func (m *Manager) Snapshot(ctx context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	monitor, exist := m.runningMonitors[id]
+	m.mu.Unlock()
+
+	if !exist {
+		return nil, ErrMonitorNotExist
+	}
+	if monitor.ctx == nil {
+		return nil, ErrMonitorNotRunning
+	}
+	return monitor.snapshots.get(ctx, monitor.snapshot)
+}
+
+// snapshotCache caches the latest snapshot of a monitor. Concurrent
+// requests wait for the same snapshot instead of decoding their own.
+type snapshotCache struct {
+	// Held while generating a snapshot.
+	lock chan struct{}
+
+	jpeg    []byte
+	created time.Time
+	now     func() time.Time
+}
+
+func newSnapshotCache() *snapshotCache {
+	return &snapshotCache{
+		lock: make(chan struct{}, 1),
+		now:  time.Now,
+	}
+}
+
+type snapshotFunc func(context.Context) ([]byte, error)
+
+// get returns the cached snapshot if it's newer than
+// SnapshotMaxAge, otherwise a new snapshot is generated.
+// Errors aren't cached.
+func (c *snapshotCache) get(ctx context.Context, generate snapshotFunc) ([]byte, error) {
+	select {
+	case c.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.lock }()
+
+	if c.jpeg != nil && c.now().Sub(c.created) < SnapshotMaxAge {
+		return c.jpeg, nil
+	}
+	jpeg, err := generate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.jpeg = jpeg
+	c.created = c.now()
+	return jpeg, nil
+}
+
+func (m *Monitor) snapshot(ctx context.Context) ([]byte, error) {
+	input := m.mainInput
+	if input.Health().State != InputStateOnline {
+		return nil, ErrInputNotOnline
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	info, err := input.StreamInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("stream info: %w", err)
+	}
+	if !info.VideoTrackExist {
+		return nil, ErrNoVideoTrack
+	}
+	muxer, err := input.HLSMuxer()
+	if err != nil {
+		return nil, fmt.Errorf("get muxer: %w", err)
+	}
+	part, err := latestPart(ctx, muxer)
+	if err != nil {
+		return nil, fmt.Errorf("latest part: %w", err)
+	}
+	segment, err := keyframeSegment(part)
+	if err != nil {
+		return nil, err
+	}
+
+	video := &bytes.Buffer{}
+	if err := mp4muxer.GenerateThumbnailVideo(video, segment, *info); err != nil {
+		return nil, fmt.Errorf("generate snapshot video: %w", err)
+	}
+
+	args := "-threads 1 -loglevel error"
+	if hw, err := m.Config.HardwareAccel(); err == nil && hw.Type != ffmpeg.HWAccelNone {
+		if decodeArgs := hw.DecodeArgs(); decodeArgs != "" {
+			args += " " + decodeArgs
+		}
+	}
+	args += " -i -" + // Input.
+		" -frames:v 1 -f image2 -c:v mjpeg -" // Output.
+
+	return runSnapshotProcess(ctx, m.Env.FFmpegBin, args, video)
+}
+
+// latestPart returns the latest independent part. LatestPart
+// waits for the part and doesn't accept a context.
+func latestPart(ctx context.Context, muxer video.IHLSMuxer) (*hls.MuxerPart, error) {
+	type result struct {
+		part *hls.MuxerPart
+		err  error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		part, err := muxer.LatestPart()
+		resChan <- result{part: part, err: err}
+	}()
+
+	select {
+	case res := <-resChan:
+		return res.part, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// keyframeSegment returns a segment that starts with
+// the first keyframe in the part. GenerateThumbnailVideo
+// only reads the first sample of the first part.
+func keyframeSegment(part *hls.MuxerPart) (*hls.Segment, error) {
+	for i, sample := range part.VideoSamples {
+		if sample.IdrPresent {
+			return &hls.Segment{
+				Parts: []*hls.MuxerPart{{VideoSamples: part.VideoSamples[i:]}},
+			}, nil
+		}
+	}
+	return nil, ErrNoKeyframe
+}
+
+func runSnapshotProcess(
+	ctx context.Context,
+	ffmpegBin string,
+	args string,
+	input *bytes.Buffer,
+) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, ffmpegBin, ffmpeg.ParseArgs(args)...)
+	cmd.Stdin = input
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
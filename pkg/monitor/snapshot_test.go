@@ -0,0 +1,122 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("notExistErr", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		_, err := manager.Snapshot(context.Background(), "x")
+		require.ErrorIs(t, err, ErrMonitorNotExist)
+	})
+	t.Run("notRunningErr", func(t *testing.T) {
+		_, manager := newTestManager(t)
+		manager.runningMonitors["1"] = &Monitor{}
+		_, err := manager.Snapshot(context.Background(), "1")
+		require.ErrorIs(t, err, ErrMonitorNotRunning)
+	})
+	t.Run("offlineErr", func(t *testing.T) {
+		m := &Monitor{mainInput: &InputProcess{}}
+		m.mainInput.health.State = InputStateReconnecting
+		_, err := m.snapshot(context.Background())
+		require.ErrorIs(t, err, ErrInputNotOnline)
+	})
+}
+
+func TestSnapshotCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newSnapshotCache()
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	generate := func(context.Context) ([]byte, error) {
+		calls++
+		return []byte{byte(calls)}, nil
+	}
+	get := func() []byte {
+		jpeg, err := c.get(context.Background(), generate)
+		require.NoError(t, err)
+		return jpeg
+	}
+
+	require.Equal(t, []byte{1}, get())
+	now = now.Add(time.Second)
+	require.Equal(t, []byte{1}, get())
+	now = now.Add(SnapshotMaxAge)
+	require.Equal(t, []byte{2}, get())
+
+	t.Run("errNotCached", func(t *testing.T) {
+		errMock := errors.New("mock")
+		now = now.Add(SnapshotMaxAge)
+		_, err := c.get(context.Background(), func(context.Context) ([]byte, error) {
+			return nil, errMock
+		})
+		require.ErrorIs(t, err, errMock)
+		require.Equal(t, []byte{3}, get())
+	})
+	t.Run("canceled", func(t *testing.T) {
+		c.lock <- struct{}{}
+		defer func() { <-c.lock }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.get(ctx, generate)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestLatestPart(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		part := &hls.MuxerPart{}
+		actual, err := latestPart(context.Background(), &mockMuxer{latestPart: part})
+		require.NoError(t, err)
+		require.Equal(t, part, actual)
+	})
+	t.Run("err", func(t *testing.T) {
+		errMock := errors.New("mock")
+		_, err := latestPart(context.Background(), &mockMuxer{latestPartErr: errMock})
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+func TestKeyframeSegment(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		samples := []*hls.VideoSample{
+			{AVCC: []byte{1}},
+			{AVCC: []byte{2}, IdrPresent: true},
+			{AVCC: []byte{3}},
+		}
+		seg, err := keyframeSegment(&hls.MuxerPart{VideoSamples: samples})
+		require.NoError(t, err)
+		require.Len(t, seg.Parts, 1)
+		require.Equal(t, samples[1:], seg.Parts[0].VideoSamples)
+	})
+	t.Run("noKeyframeErr", func(t *testing.T) {
+		part := &hls.MuxerPart{VideoSamples: []*hls.VideoSample{{}}}
+		_, err := keyframeSegment(part)
+		require.ErrorIs(t, err, ErrNoKeyframe)
+	})
+}
@@ -0,0 +1,193 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ValidateHook checks the addon settings of a monitor config.
+type ValidateHook func(Config) []error
+
+// Validation errors.
+var (
+	ErrIDMissing        = errors.New("id missing")
+	ErrIDFileMismatch   = errors.New("id does not match file name")
+	ErrMainInputMissing = errors.New("main input missing")
+	ErrInvalidInputURL  = errors.New("invalid url, expected scheme://host/path")
+)
+
+// Validate checks the settings of the monitor and returns all
+// problems. Settings that are only used by addons aren't checked.
+func (c Config) Validate() []error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", key, err))
+		}
+	}
+
+	if c.ID() == "" {
+		errs = append(errs, ErrIDMissing)
+	}
+
+	if c.MainInput() == "" && c.enabled() {
+		check("mainInput", ErrMainInputMissing)
+	}
+	if c.MainInput() != "" {
+		check("mainInput", c.validateInput(c.MainInput()))
+	}
+	if c.SubInput() != "" {
+		check("subInput", c.validateInput(c.SubInput()))
+	}
+	for _, input := range c.MainInputBackups() {
+		check("mainInputBackups", c.validateInput(input))
+	}
+	for _, input := range c.SubInputBackups() {
+		check("subInputBackups", c.validateInput(input))
+	}
+
+	if c.enabled() {
+		_, err := strconv.Atoi(c.TimestampOffset())
+		check("timestampOffset", err)
+		_, err = strconv.ParseFloat(c.videoLength(), 64)
+		check("videoLength", err)
+	}
+
+	_, err := c.HardwareAccel()
+	check("hwaccel", err)
+	_, err = newBackoff(c)
+	check("reconnect", err)
+	_, err = c.failbackInterval()
+	check("failbackInterval", err)
+	_, err = c.hlsPartDuration()
+	check("hlsPartDuration", err)
+	_, err = c.preEventBuffer()
+	check("preEventBuffer", err)
+	_, err = c.eventCooldown()
+	check("eventCooldown", err)
+	_, err = c.maxRecordingDuration()
+	check("maxRecordingDuration", err)
+	_, err = parseSegmentLength(c.SegmentLength())
+	check("segmentLength", err)
+	_, err = c.Retention()
+	check("retention", err)
+
+	if c.RTSPSCA() != "" || c.RTSPSFingerprint() != "" {
+		_, err = newRTSPSTLSConfig("", c.RTSPSCA(), c.RTSPSFingerprint())
+		check("rtsps", err)
+	}
+	return errs
+}
+
+func (c Config) validateInput(input string) error {
+	switch {
+	case c.publishedInput():
+		return nil
+	case c.srtInput():
+		_, err := srtURL(input, c.SRTMode(), c.SRTPassphrase(), c.SRTLatency())
+		return err
+	case c.fileInput():
+		return checkFileInput(input)
+	}
+	if device, ok := v4l2Device(input); ok {
+		_, err := v4l2InputArgs(device, c.V4L2InputFormat(), c.V4L2Resolution(), c.V4L2FrameRate())
+		return err
+	}
+
+	// The url isn't included in the error since it may contain a password.
+	u, err := url.Parse(input)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ErrInvalidInputURL
+	}
+	return nil
+}
+
+// ConfigError problem with a monitor config file.
+type ConfigError struct {
+	File string
+	Err  error
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%v: %v", e.File, e.Err)
+}
+
+func (e ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// CheckConfigs validates the config files in the directory without
+// applying them, migrations are only applied in memory. The
+// validate hook is called for every config that can be parsed.
+func CheckConfigs(configPath string, migrate MigationHook, validate ValidateHook) ([]ConfigError, error) {
+	entries, err := os.ReadDir(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("read config directory: %w", err)
+	}
+
+	var problems []ConfigError
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		add := func(err error) {
+			problems = append(problems, ConfigError{File: name, Err: err})
+		}
+
+		file, err := os.ReadFile(filepath.Join(configPath, name))
+		if err != nil {
+			add(err)
+			continue
+		}
+		var rawConf RawConfig
+		if err := json.Unmarshal(file, &rawConf); err != nil {
+			add(err)
+			continue
+		}
+		if err := migrate(rawConf); err != nil {
+			add(fmt.Errorf("migration failed: %w", err))
+			continue
+		}
+
+		id := rawConf["id"]
+		if id != "" && id != strings.TrimSuffix(name, ".json") {
+			add(fmt.Errorf("%w: %q", ErrIDFileMismatch, id))
+		}
+
+		resolved, err := resolveSecrets(rawConf)
+		if err != nil {
+			add(err)
+			continue
+		}
+		c := NewConfig(resolved)
+		for _, err := range c.Validate() {
+			add(err)
+		}
+		for _, err := range validate(c) {
+			add(err)
+		}
+	}
+	return problems, nil
+}
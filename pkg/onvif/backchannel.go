@@ -0,0 +1,515 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	gourl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/headers"
+	"nvr/pkg/video/gortsplib/pkg/sdp"
+	"nvr/pkg/video/gortsplib/pkg/url"
+
+	"github.com/pion/rtp"
+)
+
+// Backchannel errors.
+var (
+	ErrNoBackchannel       = errors.New("camera has no audio backchannel")
+	ErrBackchannelCodec    = errors.New("unsupported backchannel codec, only PCMU and PCMA are supported")
+	ErrBackchannelStatus   = errors.New("unexpected rtsp status")
+	ErrBackchannelAuth     = errors.New("unsupported rtsp authentication")
+	ErrBackchannelNotRTSP  = errors.New("backchannel url must be rtsp")
+	ErrBackchannelNoHeader = errors.New("header missing")
+)
+
+// ONVIF streaming specification, section 5.3.
+const backchannelRequire = "www.onvif.org/ver20/backchannel"
+
+const (
+	// BackchannelSampleRate sample rate of the PCM samples written to the backchannel.
+	BackchannelSampleRate = 8000
+
+	// 20ms of audio per packet.
+	backchannelPacketSamples = 160
+
+	backchannelKeepAlive = 30 * time.Second
+)
+
+// Backchannel RTSP session that sends audio to a camera. The camera
+// announces the backchannel as a "sendonly" media in the SDP when the
+// DESCRIBE request requires "www.onvif.org/ver20/backchannel".
+type Backchannel struct {
+	nconn   net.Conn
+	conn    *conn.Conn
+	url     *url.URL
+	session string
+	auth    *rtspAuth
+
+	codec       string
+	payloadType uint8
+	channel     int
+
+	// Guards writes to the connection.
+	mu       sync.Mutex
+	cseq     int
+	seq      uint16
+	ts       uint32
+	ssrc     uint32
+	pending  []int16
+	writeBuf []byte
+
+	cancel func()
+	done   chan struct{}
+}
+
+// DialBackchannel connects to the camera and sets up the backchannel.
+func DialBackchannel(ctx context.Context, rawURL string) (*Backchannel, error) {
+	if !strings.HasPrefix(strings.ToLower(rawURL), "rtsp://") {
+		return nil, ErrBackchannelNotRTSP
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if (*gourl.URL)(u).Port() == "" {
+		host = net.JoinHostPort((*gourl.URL)(u).Hostname(), "554")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	nconn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	b := &Backchannel{
+		nconn: nconn,
+		conn:  conn.NewConn(nconn),
+		url:   u,
+		done:  make(chan struct{}),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		b.auth = &rtspAuth{username: u.User.Username(), password: password}
+	}
+	// Credentials are sent in the Authorization header.
+	b.url = u.CloneWithoutCredentials()
+
+	if err := b.setup(ctx); err != nil {
+		nconn.Close()
+		return nil, err
+	}
+
+	ctx2, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.run(ctx2)
+	return b, nil
+}
+
+func (b *Backchannel) setup(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		b.nconn.SetDeadline(deadline) //nolint:errcheck
+	} else {
+		b.nconn.SetDeadline(time.Now().Add(10 * time.Second)) //nolint:errcheck
+	}
+	defer b.nconn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	res, err := b.do(base.Describe, b.url, base.Header{
+		"Accept":  base.HeaderValue{"application/sdp"},
+		"Require": base.HeaderValue{backchannelRequire},
+	})
+	if err != nil {
+		return fmt.Errorf("describe: %w", err)
+	}
+
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(res.Body); err != nil {
+		return fmt.Errorf("unmarshal sdp: %w", err)
+	}
+	control, err := b.findBackchannel(&desc)
+	if err != nil {
+		return err
+	}
+
+	baseURL := b.url
+	if v, ok := res.Header["Content-Base"]; ok && len(v) == 1 {
+		if u, err := url.Parse(v[0]); err == nil {
+			baseURL = u.CloneWithoutCredentials()
+		}
+	}
+	setupURL, err := controlURL(baseURL, control)
+	if err != nil {
+		return err
+	}
+
+	transport := headers.Transport{InterleavedIDs: &[2]int{0, 1}}
+	res, err = b.do(base.Setup, setupURL, base.Header{
+		"Transport": transport.Marshal(),
+		"Require":   base.HeaderValue{backchannelRequire},
+	})
+	if err != nil {
+		return fmt.Errorf("setup: %w", err)
+	}
+
+	var session headers.Session
+	if err := session.Unmarshal(res.Header["Session"]); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	b.session = session.Session
+
+	var resTransport headers.Transport
+	if err := resTransport.Unmarshal(res.Header["Transport"]); err == nil &&
+		resTransport.InterleavedIDs != nil {
+		b.channel = resTransport.InterleavedIDs[0]
+	}
+
+	if _, err := b.do(base.Play, b.url, base.Header{
+		"Require": base.HeaderValue{backchannelRequire},
+	}); err != nil {
+		return fmt.Errorf("play: %w", err)
+	}
+
+	ssrc := make([]byte, 4)
+	rand.Read(ssrc) //nolint:errcheck
+	b.ssrc = binary.BigEndian.Uint32(ssrc)
+	return nil
+}
+
+// findBackchannel returns the control attribute of the backchannel media.
+func (b *Backchannel) findBackchannel(desc *sdp.SessionDescription) (string, error) {
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		if _, sendOnly := media.Attribute("sendonly"); !sendOnly {
+			continue
+		}
+		control, _ := media.Attribute("control")
+
+		for _, format := range media.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec := ""
+			for _, attr := range media.Attributes {
+				if attr.Key != "rtpmap" || !strings.HasPrefix(attr.Value, format+" ") {
+					continue
+				}
+				codec = strings.ToUpper(strings.TrimPrefix(attr.Value, format+" "))
+			}
+			// Static payload types may not have a rtpmap.
+			switch {
+			case codec == "" && pt == 0, codec == "PCMU/8000", codec == "PCMU/8000/1":
+				b.codec, b.payloadType = "PCMU", uint8(pt)
+				return control, nil
+			case codec == "" && pt == 8, codec == "PCMA/8000", codec == "PCMA/8000/1":
+				b.codec, b.payloadType = "PCMA", uint8(pt)
+				return control, nil
+			}
+		}
+		return "", ErrBackchannelCodec
+	}
+	return "", ErrNoBackchannel
+}
+
+func controlURL(baseURL *url.URL, control string) (*url.URL, error) {
+	if control == "" || control == "*" {
+		return baseURL, nil
+	}
+	if strings.HasPrefix(strings.ToLower(control), "rtsp://") {
+		u, err := url.Parse(control)
+		if err != nil {
+			return nil, err
+		}
+		return u.CloneWithoutCredentials(), nil
+	}
+	s := baseURL.String()
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return url.Parse(s + control)
+}
+
+// do writes a request and reads the response. Digest
+// authentication is retried once after a 401 response.
+func (b *Backchannel) do(method base.Method, u *url.URL, header base.Header) (*base.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req := b.newRequest(method, u, header)
+		if err := b.conn.WriteRequest(req); err != nil {
+			return nil, err
+		}
+		res, err := b.conn.ReadResponseIgnoreFrames()
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == base.StatusUnauthorized && b.auth != nil && attempt == 0 {
+			if err := b.auth.parse(res.Header["WWW-Authenticate"]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if res.StatusCode != base.StatusOK {
+			return nil, fmt.Errorf("%w: %d %v", ErrBackchannelStatus, res.StatusCode, res.StatusMessage)
+		}
+		return res, nil
+	}
+}
+
+func (b *Backchannel) newRequest(method base.Method, u *url.URL, header base.Header) *base.Request {
+	b.cseq++
+	h := base.Header{"CSeq": base.HeaderValue{strconv.Itoa(b.cseq)}}
+	for k, v := range header {
+		h[k] = v
+	}
+	if b.session != "" {
+		h["Session"] = base.HeaderValue{b.session}
+	}
+	if b.auth != nil {
+		if v := b.auth.header(method, u); v != "" {
+			h["Authorization"] = base.HeaderValue{v}
+		}
+	}
+	return &base.Request{Method: method, URL: u, Header: h}
+}
+
+// run discards the incoming data and sends keep alives.
+func (b *Backchannel) run(ctx context.Context) {
+	defer close(b.done)
+
+	go func() {
+		for {
+			if _, err := b.conn.ReadInterleavedFrameOrResponse(); err != nil {
+				b.cancel()
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(backchannelKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-keepAlive.C:
+			b.mu.Lock()
+			req := b.newRequest(base.GetParameter, b.url, nil)
+			err := b.conn.WriteRequest(req)
+			b.mu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Codec returns "PCMU" or "PCMA".
+func (b *Backchannel) Codec() string {
+	return b.codec
+}
+
+// WritePCM encodes and sends 16 bit mono PCM samples at BackchannelSampleRate.
+func (b *Backchannel) WritePCM(samples []int16) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, samples...)
+	for len(b.pending) >= backchannelPacketSamples {
+		if err := b.writePacket(b.pending[:backchannelPacketSamples]); err != nil {
+			return err
+		}
+		b.pending = b.pending[backchannelPacketSamples:]
+	}
+	return nil
+}
+
+func (b *Backchannel) writePacket(samples []int16) error {
+	payload := make([]byte, len(samples))
+	for i, s := range samples {
+		if b.codec == "PCMA" {
+			payload[i] = encodeALaw(s)
+		} else {
+			payload[i] = encodeMuLaw(s)
+		}
+	}
+
+	pkt := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    b.payloadType,
+			SequenceNumber: b.seq,
+			Timestamp:      b.ts,
+			SSRC:           b.ssrc,
+		},
+		Payload: payload,
+	}
+	b.seq++
+	b.ts += uint32(len(samples))
+
+	byts, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+	if len(b.writeBuf) < 4+len(byts) {
+		b.writeBuf = make([]byte, 4+len(byts))
+	}
+	return b.conn.WriteInterleavedFrame(&base.InterleavedFrame{
+		Channel: b.channel,
+		Payload: byts,
+	}, b.writeBuf)
+}
+
+// Close tears down the session and closes the connection.
+func (b *Backchannel) Close() error {
+	b.mu.Lock()
+	b.nconn.SetWriteDeadline(time.Now().Add(time.Second))        //nolint:errcheck
+	b.conn.WriteRequest(b.newRequest(base.Teardown, b.url, nil)) //nolint:errcheck
+	b.mu.Unlock()
+
+	b.cancel()
+	err := b.nconn.Close()
+	<-b.done
+	return err
+}
+
+// rtspAuth Basic and Digest authentication, RFC 2617.
+type rtspAuth struct {
+	username string
+	password string
+
+	method string
+	realm  string
+	nonce  string
+	opaque string
+	qop    string
+	nc     int
+}
+
+func (a *rtspAuth) parse(values base.HeaderValue) error {
+	// Prefer digest if the camera offers both.
+	for _, v := range values {
+		if strings.HasPrefix(v, "Digest ") {
+			params := parseAuthParams(strings.TrimPrefix(v, "Digest "))
+			a.method = "Digest"
+			a.realm = params["realm"]
+			a.nonce = params["nonce"]
+			a.opaque = params["opaque"]
+			a.qop = ""
+			for _, qop := range strings.Split(params["qop"], ",") {
+				if strings.TrimSpace(qop) == "auth" {
+					a.qop = "auth"
+				}
+			}
+			if a.nonce == "" {
+				return fmt.Errorf("%w: nonce", ErrBackchannelNoHeader)
+			}
+			return nil
+		}
+	}
+	for _, v := range values {
+		if strings.HasPrefix(v, "Basic") {
+			a.method = "Basic"
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrBackchannelAuth, values)
+}
+
+func (a *rtspAuth) header(method base.Method, u *url.URL) string {
+	switch a.method {
+	case "Basic":
+		creds := base64.StdEncoding.EncodeToString([]byte(a.username + ":" + a.password))
+		return "Basic " + creds
+	case "Digest":
+		uri := u.String()
+		ha1 := md5Hex(a.username + ":" + a.realm + ":" + a.password)
+		ha2 := md5Hex(string(method) + ":" + uri)
+
+		v := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+			a.username, a.realm, a.nonce, uri)
+		if a.qop == "auth" {
+			a.nc++
+			nc := fmt.Sprintf("%08x", a.nc)
+			cnonceBytes := make([]byte, 8)
+			rand.Read(cnonceBytes) //nolint:errcheck
+			cnonce := hex.EncodeToString(cnonceBytes)
+			response := md5Hex(ha1 + ":" + a.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+			v += fmt.Sprintf(`, response="%s", qop=auth, nc=%s, cnonce="%s"`, response, nc, cnonce)
+		} else {
+			v += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+a.nonce+":"+ha2))
+		}
+		if a.opaque != "" {
+			v += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+		}
+		return v
+	}
+	return ""
+}
+
+// parseAuthParams parses `realm="x", nonce="y"`.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end == -1 {
+				value, s = s, ""
+			} else {
+				value, s = s[:end], s[end:]
+			}
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s)) //nolint:gosec
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,175 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+const testBackchannelSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=Session\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=control:track1\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=recvonly\r\n" +
+	"m=audio 0 RTP/AVP 8\r\n" +
+	"a=control:track2\r\n" +
+	"a=rtpmap:8 PCMA/8000\r\n" +
+	"a=sendonly\r\n"
+
+// fakeCamera accepts a single backchannel session and returns
+// the methods it received and the first RTP packet.
+func fakeCamera(t *testing.T, sdp string) (string, chan []string, chan *rtp.Packet) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	methods := make(chan []string, 1)
+	packets := make(chan *rtp.Packet, 1)
+	go func() {
+		nconn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nconn.Close()
+		c := conn.NewConn(nconn)
+
+		var received []string
+		defer func() { methods <- received }()
+		for {
+			v, err := c.ReadInterleavedFrameOrRequest()
+			if err != nil {
+				return
+			}
+			if fr, ok := v.(*base.InterleavedFrame); ok {
+				var pkt rtp.Packet
+				if pkt.Unmarshal(fr.Payload) == nil {
+					select {
+					case packets <- &pkt:
+					default:
+					}
+				}
+				continue
+			}
+
+			req := v.(*base.Request)
+			received = append(received, string(req.Method))
+			res := &base.Response{
+				StatusCode: base.StatusOK,
+				Header:     base.Header{"CSeq": req.Header["CSeq"]},
+			}
+			switch req.Method {
+			case base.Describe:
+				if len(req.Header["Authorization"]) == 0 {
+					res.StatusCode = base.StatusUnauthorized
+					res.Header["WWW-Authenticate"] = base.HeaderValue{
+						`Digest realm="cam", nonce="abc", qop="auth"`,
+					}
+					break
+				}
+				require.True(t, strings.HasPrefix(req.Header["Authorization"][0], `Digest username="admin"`))
+				require.Equal(t, base.HeaderValue{backchannelRequire}, req.Header["Require"])
+				res.Header["Content-Base"] = base.HeaderValue{"rtsp://" + l.Addr().String() + "/stream/"}
+				res.Body = []byte(sdp)
+			case base.Setup:
+				require.Equal(t, "rtsp://"+l.Addr().String()+"/stream/track2", req.URL.String())
+				res.Header["Session"] = base.HeaderValue{"1234;timeout=60"}
+				res.Header["Transport"] = base.HeaderValue{"RTP/AVP/TCP;unicast;interleaved=2-3"}
+			case base.Teardown:
+				c.WriteResponse(res) //nolint:errcheck
+				return
+			}
+			if err := c.WriteResponse(res); err != nil {
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), methods, packets
+}
+
+func TestBackchannel(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		addr, methods, packets := fakeCamera(t, testBackchannelSDP)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b, err := DialBackchannel(ctx, "rtsp://admin:pass@"+addr+"/stream")
+		require.NoError(t, err)
+		require.Equal(t, "PCMA", b.Codec())
+		require.Equal(t, 2, b.channel)
+
+		// Less than a packet.
+		require.NoError(t, b.WritePCM(make([]int16, 100)))
+		require.NoError(t, b.WritePCM(make([]int16, 100)))
+
+		select {
+		case pkt := <-packets:
+			require.Equal(t, uint8(8), pkt.PayloadType)
+			require.Len(t, pkt.Payload, backchannelPacketSamples)
+			require.Equal(t, encodeALaw(0), pkt.Payload[0])
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		require.Len(t, b.pending, 40)
+
+		b.Close()
+		require.Equal(t,
+			[]string{"DESCRIBE", "DESCRIBE", "SETUP", "PLAY", "TEARDOWN"},
+			<-methods,
+		)
+	})
+	t.Run("noBackchannel", func(t *testing.T) {
+		sdp := strings.ReplaceAll(testBackchannelSDP, "a=sendonly", "a=recvonly")
+		addr, _, _ := fakeCamera(t, sdp)
+
+		_, err := DialBackchannel(context.Background(), "rtsp://admin:pass@"+addr+"/stream")
+		require.ErrorIs(t, err, ErrNoBackchannel)
+	})
+	t.Run("codec", func(t *testing.T) {
+		sdp := strings.ReplaceAll(testBackchannelSDP, "RTP/AVP 8", "RTP/AVP 97")
+		sdp = strings.ReplaceAll(sdp, "a=rtpmap:8 PCMA/8000", "a=rtpmap:97 MPEG4-GENERIC/16000")
+		addr, _, _ := fakeCamera(t, sdp)
+
+		_, err := DialBackchannel(context.Background(), "rtsp://admin:pass@"+addr+"/stream")
+		require.ErrorIs(t, err, ErrBackchannelCodec)
+	})
+	t.Run("notRTSP", func(t *testing.T) {
+		_, err := DialBackchannel(context.Background(), "http://x")
+		require.ErrorIs(t, err, ErrBackchannelNotRTSP)
+	})
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`realm="a, b", nonce="123", stale=FALSE`)
+	require.Equal(t, map[string]string{
+		"realm": "a, b",
+		"nonce": "123",
+		"stale": "FALSE",
+	}, params)
+}
@@ -0,0 +1,300 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Device errors.
+var (
+	ErrNoMediaService = errors.New("device has no media service")
+	ErrNoEventService = errors.New("device has no event service")
+	ErrNoH264Profile  = errors.New("device has no H264 profile")
+)
+
+// DeviceInfo device information.
+type DeviceInfo struct {
+	Manufacturer    string `xml:"Manufacturer" json:"manufacturer"`
+	Model           string `xml:"Model" json:"model"`
+	FirmwareVersion string `xml:"FirmwareVersion" json:"firmwareVersion"`
+	SerialNumber    string `xml:"SerialNumber" json:"serialNumber"`
+	HardwareID      string `xml:"HardwareId" json:"hardwareId"`
+}
+
+// GetDeviceInformation returns the device information.
+func (c *Client) GetDeviceInformation(ctx context.Context) (*DeviceInfo, error) {
+	const body = `<GetDeviceInformation xmlns="http://www.onvif.org/ver10/device/wsdl"/>`
+	var res struct {
+		DeviceInfo
+	}
+	if err := c.call(ctx, c.XAddr, body, &res); err != nil {
+		return nil, fmt.Errorf("get device information: %w", err)
+	}
+	return &res.DeviceInfo, nil
+}
+
+// SyncTime reads the device clock, the offset is used in the password
+// digest. The request isn't authenticated since the clock may be wrong.
+func (c *Client) SyncTime(ctx context.Context) error {
+	const body = `<GetSystemDateAndTime xmlns="http://www.onvif.org/ver10/device/wsdl"/>`
+	var res struct {
+		UTC struct {
+			Date struct {
+				Year  int `xml:"Year"`
+				Month int `xml:"Month"`
+				Day   int `xml:"Day"`
+			} `xml:"Date"`
+			Time struct {
+				Hour   int `xml:"Hour"`
+				Minute int `xml:"Minute"`
+				Second int `xml:"Second"`
+			} `xml:"Time"`
+		} `xml:"SystemDateAndTime>UTCDateTime"`
+	}
+
+	// Unauthenticated copy of the client.
+	anon := &Client{XAddr: c.XAddr, HTTPClient: c.HTTPClient}
+	if err := anon.call(ctx, c.XAddr, body, &res); err != nil {
+		return fmt.Errorf("get system date and time: %w", err)
+	}
+	d, t := res.UTC.Date, res.UTC.Time
+	if d.Year == 0 {
+		return nil
+	}
+	deviceTime := time.Date(d.Year, time.Month(d.Month), d.Day, t.Hour, t.Minute, t.Second, 0, time.UTC)
+	c.setTimeOffset(time.Until(deviceTime))
+	return nil
+}
+
+// Capabilities service addresses of a device. Empty if not supported.
+type Capabilities struct {
+	Media  string `json:"media"`
+	Events string `json:"events"`
+	PTZ    string `json:"ptz"`
+}
+
+// GetCapabilities returns the service addresses.
+func (c *Client) GetCapabilities(ctx context.Context) (*Capabilities, error) {
+	const body = `<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl">` +
+		`<Category>All</Category></GetCapabilities>`
+	var res struct {
+		Media  string `xml:"Capabilities>Media>XAddr"`
+		Events string `xml:"Capabilities>Events>XAddr"`
+		PTZ    string `xml:"Capabilities>PTZ>XAddr"`
+	}
+	if err := c.call(ctx, c.XAddr, body, &res); err != nil {
+		return nil, fmt.Errorf("get capabilities: %w", err)
+	}
+	return &Capabilities{
+		Media:  res.Media,
+		Events: res.Events,
+		PTZ:    res.PTZ,
+	}, nil
+}
+
+// Profile media profile.
+type Profile struct {
+	Token     string  `json:"token"`
+	Name      string  `json:"name"`
+	Encoding  string  `json:"encoding"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	FrameRate float64 `json:"frameRate"`
+
+	// RTSP url without credentials.
+	StreamURI string `json:"streamUri"`
+}
+
+// GetProfiles returns the media profiles without stream uris.
+func (c *Client) GetProfiles(ctx context.Context, mediaXAddr string) ([]Profile, error) {
+	const body = `<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`
+	var res struct {
+		Profiles []struct {
+			Token   string `xml:"token,attr"`
+			Name    string `xml:"Name"`
+			Encoder struct {
+				Encoding string `xml:"Encoding"`
+				Width    int    `xml:"Resolution>Width"`
+				Height   int    `xml:"Resolution>Height"`
+				FPS      string `xml:"RateControl>FrameRateLimit"`
+			} `xml:"VideoEncoderConfiguration"`
+		} `xml:"Profiles"`
+	}
+	if err := c.call(ctx, mediaXAddr, body, &res); err != nil {
+		return nil, fmt.Errorf("get profiles: %w", err)
+	}
+
+	profiles := make([]Profile, 0, len(res.Profiles))
+	for _, p := range res.Profiles {
+		var fps float64
+		fmt.Sscan(p.Encoder.FPS, &fps) //nolint:errcheck
+		profiles = append(profiles, Profile{
+			Token:     p.Token,
+			Name:      p.Name,
+			Encoding:  p.Encoder.Encoding,
+			Width:     p.Encoder.Width,
+			Height:    p.Encoder.Height,
+			FrameRate: fps,
+		})
+	}
+	return profiles, nil
+}
+
+// GetStreamURI returns the RTSP url of the profile.
+func (c *Client) GetStreamURI(ctx context.Context, mediaXAddr string, token string) (string, error) {
+	body := `<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl">` +
+		`<StreamSetup>` +
+		`<Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>` +
+		`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport>` +
+		`</StreamSetup>` +
+		`<ProfileToken>` + escape(token) + `</ProfileToken>` +
+		`</GetStreamUri>`
+	var res struct {
+		URI string `xml:"MediaUri>Uri"`
+	}
+	if err := c.call(ctx, mediaXAddr, body, &res); err != nil {
+		return "", fmt.Errorf("get stream uri: %w", err)
+	}
+	return res.URI, nil
+}
+
+// DeviceDetails everything needed to add the device as a monitor.
+type DeviceDetails struct {
+	XAddr        string       `json:"xaddr"`
+	Info         DeviceInfo   `json:"info"`
+	Capabilities Capabilities `json:"capabilities"`
+	Profiles     []Profile    `json:"profiles"`
+}
+
+// Probe fetches the device information, capabilities and
+// media profiles with stream uris. Profiles with the
+// highest resolution first.
+func (c *Client) Probe(ctx context.Context) (*DeviceDetails, error) {
+	// Not all devices support this, the offset is optional.
+	c.SyncTime(ctx) //nolint:errcheck
+
+	info, err := c.GetDeviceInformation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := c.GetCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caps.Media == "" {
+		return nil, ErrNoMediaService
+	}
+
+	profiles, err := c.GetProfiles(ctx, caps.Media)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range profiles {
+		uri, err := c.GetStreamURI(ctx, caps.Media, p.Token)
+		if err != nil {
+			return nil, fmt.Errorf("profile %v: %w", p.Token, err)
+		}
+		profiles[i].StreamURI = uri
+	}
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Width*profiles[i].Height > profiles[j].Width*profiles[j].Height
+	})
+
+	return &DeviceDetails{
+		XAddr:        c.XAddr,
+		Info:         *info,
+		Capabilities: *caps,
+		Profiles:     profiles,
+	}, nil
+}
+
+// ProbeDevice creates a client and probes the device.
+func ProbeDevice(ctx context.Context, xaddr string, username string, password string) (*DeviceDetails, error) {
+	c, err := NewClient(xaddr, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return c.Probe(ctx)
+}
+
+// MonitorConfig returns a monitor configuration for the device. The
+// H264 profile with the highest resolution is used as the main input
+// and the lowest as the sub input. The credentials are added to the
+// stream urls since they are read by FFmpeg.
+func (d DeviceDetails) MonitorConfig(
+	id string,
+	name string,
+	username string,
+	password string,
+) (map[string]string, error) {
+	var h264 []Profile
+	for _, p := range d.Profiles {
+		if p.Encoding == "H264" && p.StreamURI != "" {
+			h264 = append(h264, p)
+		}
+	}
+	if len(h264) == 0 {
+		return nil, ErrNoH264Profile
+	}
+
+	mainInput, err := withCredentials(h264[0].StreamURI, username, password)
+	if err != nil {
+		return nil, err
+	}
+	subInput := ""
+	if last := h264[len(h264)-1]; len(h264) > 1 && last.StreamURI != h264[0].StreamURI {
+		subInput, err = withCredentials(last.StreamURI, username, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]string{
+		"id":              id,
+		"name":            name,
+		"enable":          "true",
+		"mainInput":       mainInput,
+		"subInput":        subInput,
+		"inputOptions":    "-rtsp_transport tcp",
+		"videoEncoder":    "copy",
+		"audioEncoder":    "none",
+		"alwaysRecord":    "false",
+		"videoLength":     "15",
+		"timestampOffset": "500",
+		"logLevel":        "error",
+		"onvifAddress":    d.XAddr,
+		"onvifUsername":   username,
+		"onvifPassword":   password,
+	}, nil
+}
+
+func withCredentials(rawURL string, username string, password string) (string, error) {
+	if username == "" {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse stream uri: %w", err)
+	}
+	u.User = url.UserPassword(username, password)
+	return u.String(), nil
+}
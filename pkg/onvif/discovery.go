@@ -0,0 +1,200 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WS-Discovery multicast address.
+const discoveryAddress = "239.255.255.250:3702"
+
+// DefaultDiscoveryTimeout how long to wait for probe matches.
+const DefaultDiscoveryTimeout = 3 * time.Second
+
+// Device discovered device.
+type Device struct {
+	// Endpoint reference, usually "urn:uuid:...".
+	Address string `json:"address"`
+
+	// Device service addresses.
+	XAddrs []string `json:"xaddrs"`
+
+	Name     string   `json:"name"`
+	Hardware string   `json:"hardware"`
+	Scopes   []string `json:"scopes"`
+}
+
+// Discover multicasts a WS-Discovery probe for ONVIF devices
+// and returns the devices that responded within the timeout.
+func Discover(ctx context.Context, timeout time.Duration) ([]Device, error) {
+	dst, err := net.ResolveUDPAddr("udp4", discoveryAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer conn.Close()
+
+	return discover(ctx, conn, dst, timeout)
+}
+
+func discover(
+	ctx context.Context,
+	conn net.PacketConn,
+	dst net.Addr,
+	timeout time.Duration,
+) ([]Device, error) {
+	messageID := "uuid:" + newUUID()
+	if _, err := conn.WriteTo([]byte(probeMessage(messageID)), dst); err != nil {
+		return nil, fmt.Errorf("send probe: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string]Device)
+	buf := make([]byte, 64*1024)
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("read: %w", err)
+		}
+
+		matches, err := parseProbeMatches(buf[:n], messageID)
+		if err != nil {
+			// Ignore invalid and unrelated messages.
+			continue
+		}
+		for _, d := range matches {
+			devices[d.Address] = d
+		}
+	}
+
+	list := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Address < list[j].Address
+	})
+	return list, nil
+}
+
+func probeMessage(messageID string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+		` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+		`<s:Header>` +
+		`<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>` +
+		`<a:MessageID>` + messageID + `</a:MessageID>` +
+		`<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>` +
+		`<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>` +
+		`</s:Header>` +
+		`<s:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></s:Body>` +
+		`</s:Envelope>`
+}
+
+// ErrProbeMismatch response isn't a reply to the probe.
+var ErrProbeMismatch = errors.New("not a reply to the probe")
+
+func parseProbeMatches(msg []byte, messageID string) ([]Device, error) {
+	var env struct {
+		RelatesTo string `xml:"Header>RelatesTo"`
+		Matches   []struct {
+			Address string `xml:"EndpointReference>Address"`
+			Types   string `xml:"Types"`
+			Scopes  string `xml:"Scopes"`
+			XAddrs  string `xml:"XAddrs"`
+		} `xml:"Body>ProbeMatches>ProbeMatch"`
+	}
+	if err := xml.Unmarshal(msg, &env); err != nil {
+		return nil, err
+	}
+	if env.RelatesTo != messageID {
+		return nil, ErrProbeMismatch
+	}
+
+	devices := make([]Device, 0, len(env.Matches))
+	for _, m := range env.Matches {
+		d := Device{
+			Address: strings.TrimSpace(m.Address),
+			XAddrs:  strings.Fields(m.XAddrs),
+			Scopes:  strings.Fields(m.Scopes),
+		}
+		for _, scope := range d.Scopes {
+			if v, ok := scopeValue(scope, "name"); ok {
+				d.Name = v
+			}
+			if v, ok := scopeValue(scope, "hardware"); ok {
+				d.Hardware = v
+			}
+		}
+		if d.Address == "" && len(d.XAddrs) != 0 {
+			d.Address = d.XAddrs[0]
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// scopeValue returns the value of a ONVIF scope,
+// "onvif://www.onvif.org/name/Front%20Door" = "Front Door".
+func scopeValue(scope string, key string) (string, bool) {
+	prefix := "onvif://www.onvif.org/" + key + "/"
+	if !strings.HasPrefix(scope, prefix) {
+		return "", false
+	}
+	v, err := url.PathUnescape(scope[len(prefix):])
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b) //nolint:errcheck
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
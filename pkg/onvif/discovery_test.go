@@ -0,0 +1,109 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"encoding/xml"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func probeMatchesMessage(relatesTo string, address string, xaddrs string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope
+	xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope"
+	xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<SOAP-ENV:Header>
+	<wsa:RelatesTo>` + relatesTo + `</wsa:RelatesTo>
+</SOAP-ENV:Header>
+<SOAP-ENV:Body>
+	<d:ProbeMatches>
+		<d:ProbeMatch>
+			<wsa:EndpointReference><wsa:Address>` + address + `</wsa:Address></wsa:EndpointReference>
+			<d:Types>dn:NetworkVideoTransmitter</d:Types>
+			<d:Scopes>onvif://www.onvif.org/name/Front%20Door onvif://www.onvif.org/hardware/IPC-1</d:Scopes>
+			<d:XAddrs>` + xaddrs + `</d:XAddrs>
+		</d:ProbeMatch>
+	</d:ProbeMatches>
+</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+}
+
+func TestDiscover(t *testing.T) {
+	camera, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer camera.Close()
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		n, addr, err := camera.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var probe struct {
+			MessageID string `xml:"Header>MessageID"`
+		}
+		if err := xml.Unmarshal(buf[:n], &probe); err != nil {
+			return
+		}
+
+		reply := func(msg string) {
+			camera.WriteTo([]byte(msg), addr) //nolint:errcheck
+		}
+		reply("invalid")
+		reply(probeMatchesMessage("uuid:other", "urn:uuid:2", "http://x"))
+		reply(probeMatchesMessage(probe.MessageID, "urn:uuid:1",
+			"http://192.168.1.2/onvif/device_service http://[fe80::1]/onvif/device_service"))
+		// Duplicate.
+		reply(probeMatchesMessage(probe.MessageID, "urn:uuid:1", "http://192.168.1.2/onvif/device_service"))
+	}()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	devices, err := discover(context.Background(), conn, camera.LocalAddr(), 200*time.Millisecond)
+	require.NoError(t, err)
+
+	expected := []Device{{
+		Address:  "urn:uuid:1",
+		XAddrs:   []string{"http://192.168.1.2/onvif/device_service"},
+		Name:     "Front Door",
+		Hardware: "IPC-1",
+		Scopes: []string{
+			"onvif://www.onvif.org/name/Front%20Door",
+			"onvif://www.onvif.org/hardware/IPC-1",
+		},
+	}}
+	require.Equal(t, expected, devices)
+}
+
+func TestParseProbeMatches(t *testing.T) {
+	msg := probeMatchesMessage("uuid:1", "", "http://a http://b")
+	devices, err := parseProbeMatches([]byte(msg), "uuid:1")
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	require.Equal(t, "http://a", devices[0].Address)
+	require.Equal(t, []string{"http://a", "http://b"}, devices[0].XAddrs)
+
+	_, err = parseProbeMatches([]byte(msg), "uuid:2")
+	require.ErrorIs(t, err, ErrProbeMismatch)
+}
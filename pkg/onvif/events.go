@@ -0,0 +1,181 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoSubscription subscription address missing in response.
+var ErrNoSubscription = errors.New("no subscription address in response")
+
+// Event service actions.
+const (
+	actionCreatePullPoint = "http://www.onvif.org/ver10/events/wsdl/EventPortType/CreatePullPointSubscriptionRequest"
+	actionPullMessages    = "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesRequest"
+	actionRenew           = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/RenewRequest"
+	actionUnsubscribe     = "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/UnsubscribeRequest"
+)
+
+// Notification event message.
+type Notification struct {
+	// Topic without namespace prefix,
+	// for example "RuleEngine/CellMotionDetector/Motion".
+	Topic string
+
+	// "Initialized", "Changed" or "Deleted".
+	Operation string
+	Time      time.Time
+
+	Source map[string]string
+	Data   map[string]string
+}
+
+// PullPointSubscription pull point subscription on the event service.
+type PullPointSubscription struct {
+	client  *Client
+	address string
+}
+
+// CreatePullPointSubscription creates a pull point
+// subscription that expires after terminationTime.
+func (c *Client) CreatePullPointSubscription(
+	ctx context.Context,
+	eventsXAddr string,
+	terminationTime time.Duration,
+) (*PullPointSubscription, error) {
+	body := `<CreatePullPointSubscription xmlns="http://www.onvif.org/ver10/events/wsdl">` +
+		`<InitialTerminationTime>` + duration(terminationTime) + `</InitialTerminationTime>` +
+		`</CreatePullPointSubscription>`
+	var res struct {
+		Address string `xml:"SubscriptionReference>Address"`
+	}
+	err := c.callAction(ctx, eventsXAddr, actionCreatePullPoint, body, &res)
+	if err != nil {
+		return nil, fmt.Errorf("create pull point subscription: %w", err)
+	}
+	address := strings.TrimSpace(res.Address)
+	if address == "" {
+		return nil, ErrNoSubscription
+	}
+	return &PullPointSubscription{client: c, address: address}, nil
+}
+
+// Address returns the subscription address.
+func (s *PullPointSubscription) Address() string {
+	return s.address
+}
+
+// PullMessages waits up to timeout for messages. The HTTP
+// client timeout must be longer than the pull timeout.
+func (s *PullPointSubscription) PullMessages(
+	ctx context.Context,
+	timeout time.Duration,
+	limit int,
+) ([]Notification, error) {
+	body := `<PullMessages xmlns="http://www.onvif.org/ver10/events/wsdl">` +
+		`<Timeout>` + duration(timeout) + `</Timeout>` +
+		fmt.Sprintf(`<MessageLimit>%d</MessageLimit>`, limit) +
+		`</PullMessages>`
+
+	var res struct {
+		Messages []struct {
+			Topic   string `xml:"Topic"`
+			Message struct {
+				UTCTime   string      `xml:"UtcTime,attr"`
+				Operation string      `xml:"PropertyOperation,attr"`
+				Source    simpleItems `xml:"Source"`
+				Data      simpleItems `xml:"Data"`
+			} `xml:"Message>Message"`
+		} `xml:"NotificationMessage"`
+	}
+	err := s.client.callAction(ctx, s.address, actionPullMessages, body, &res)
+	if err != nil {
+		return nil, fmt.Errorf("pull messages: %w", err)
+	}
+
+	notifications := make([]Notification, 0, len(res.Messages))
+	for _, m := range res.Messages {
+		t, err := time.Parse(time.RFC3339Nano, m.Message.UTCTime)
+		if err != nil {
+			t = time.Now()
+		}
+		notifications = append(notifications, Notification{
+			Topic:     trimTopic(m.Topic),
+			Operation: m.Message.Operation,
+			Time:      t,
+			Source:    m.Message.Source.toMap(),
+			Data:      m.Message.Data.toMap(),
+		})
+	}
+	return notifications, nil
+}
+
+// Renew extends the subscription by terminationTime.
+func (s *PullPointSubscription) Renew(ctx context.Context, terminationTime time.Duration) error {
+	body := `<Renew xmlns="http://docs.oasis-open.org/wsn/b-2">` +
+		`<TerminationTime>` + duration(terminationTime) + `</TerminationTime>` +
+		`</Renew>`
+	if err := s.client.callAction(ctx, s.address, actionRenew, body, nil); err != nil {
+		return fmt.Errorf("renew: %w", err)
+	}
+	return nil
+}
+
+// Unsubscribe removes the subscription.
+func (s *PullPointSubscription) Unsubscribe(ctx context.Context) error {
+	const body = `<Unsubscribe xmlns="http://docs.oasis-open.org/wsn/b-2"/>`
+	if err := s.client.callAction(ctx, s.address, actionUnsubscribe, body, nil); err != nil {
+		return fmt.Errorf("unsubscribe: %w", err)
+	}
+	return nil
+}
+
+type simpleItems struct {
+	Items []struct {
+		Name  string `xml:"Name,attr"`
+		Value string `xml:"Value,attr"`
+	} `xml:"SimpleItem"`
+}
+
+func (s simpleItems) toMap() map[string]string {
+	m := make(map[string]string, len(s.Items))
+	for _, item := range s.Items {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+// trimTopic removes the namespace prefixes,
+// "tns1:RuleEngine/tns1:Motion" = "RuleEngine/Motion".
+func trimTopic(topic string) string {
+	parts := strings.Split(strings.TrimSpace(topic), "/")
+	for i, part := range parts {
+		if n := strings.LastIndex(part, ":"); n != -1 {
+			parts[i] = part[n+1:]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// duration formats a xs:duration, "PT60S".
+func duration(d time.Duration) string {
+	return fmt.Sprintf("PT%dS", int(d.Seconds()))
+}
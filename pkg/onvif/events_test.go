@@ -0,0 +1,109 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullPointSubscription(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var req struct {
+			Action string `xml:"Header>Action"`
+			To     string `xml:"Header>To"`
+		}
+		require.NoError(t, xml.Unmarshal(body, &req))
+		require.Equal(t, "http://"+r.Host+r.URL.RequestURI(), req.To)
+		actions = append(actions, req.Action)
+
+		var res string
+		switch req.Action {
+		case actionCreatePullPoint:
+			res = `<tev:CreatePullPointSubscriptionResponse>
+				<tev:SubscriptionReference>
+					<wsa5:Address>http://` + r.Host + `/onvif/subscription?id=1</wsa5:Address>
+				</tev:SubscriptionReference>
+			</tev:CreatePullPointSubscriptionResponse>`
+		case actionPullMessages:
+			res = `<tev:PullMessagesResponse>
+				<wsnt:NotificationMessage>
+					<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">` +
+				`tns1:RuleEngine/CellMotionDetector/Motion</wsnt:Topic>
+					<wsnt:Message>
+						<tt:Message UtcTime="2022-01-02T03:04:05Z" PropertyOperation="Changed">
+							<tt:Source><tt:SimpleItem Name="VideoSourceToken" Value="0"/></tt:Source>
+							<tt:Data><tt:SimpleItem Name="IsMotion" Value="true"/></tt:Data>
+						</tt:Message>
+					</wsnt:Message>
+				</wsnt:NotificationMessage>
+			</tev:PullMessagesResponse>`
+		case actionRenew:
+			res = `<wsnt:RenewResponse/>`
+		case actionUnsubscribe:
+			res = `<wsnt:UnsubscribeResponse/>`
+		}
+		w.Header().Set("Content-Type", soapContentType)
+		w.Write([]byte(strings.Replace(testEnvelope, "%s", res, 1))) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	c, err := NewClient(server.URL+"/onvif/device_service", "", "")
+	require.NoError(t, err)
+
+	sub, err := c.CreatePullPointSubscription(ctx, server.URL+"/onvif/events", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/onvif/subscription?id=1", sub.Address())
+
+	notifications, err := sub.PullMessages(ctx, 5*time.Second, 10)
+	require.NoError(t, err)
+	expected := []Notification{{
+		Topic:     "RuleEngine/CellMotionDetector/Motion",
+		Operation: "Changed",
+		Time:      time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		Source:    map[string]string{"VideoSourceToken": "0"},
+		Data:      map[string]string{"IsMotion": "true"},
+	}}
+	require.Equal(t, expected, notifications)
+
+	require.NoError(t, sub.Renew(ctx, time.Minute))
+	require.NoError(t, sub.Unsubscribe(ctx))
+
+	require.Equal(t, []string{
+		actionCreatePullPoint,
+		actionPullMessages,
+		actionRenew,
+		actionUnsubscribe,
+	}, actions)
+}
+
+func TestTrimTopic(t *testing.T) {
+	require.Equal(t, "RuleEngine/Motion", trimTopic(" tns1:RuleEngine/tns1:Motion "))
+	require.Equal(t, "VideoSource/MotionAlarm", trimTopic("VideoSource/MotionAlarm"))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+// G.711 encoders, ITU-T G.711 segment encoding of 16 bit linear PCM.
+
+const (
+	muLawBias = 0x84
+	muLawClip = 32635
+)
+
+// encodeMuLaw encodes a linear PCM sample with μ-law, PCMU.
+func encodeMuLaw(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > muLawClip {
+		s = muLawClip
+	}
+	s += muLawBias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// encodeALaw encodes a linear PCM sample with A-law, PCMA.
+func encodeALaw(sample int16) byte {
+	s := int(sample) >> 3
+	mask := 0xd5
+	if s < 0 {
+		mask = 0x55
+		s = -s - 1
+	}
+
+	segment := 0
+	for end := 0x1f; s > end && segment < 8; end = end<<1 | 1 {
+		segment++
+	}
+	if segment >= 8 {
+		return byte(0x7f ^ mask)
+	}
+
+	aval := segment << 4
+	if segment < 2 {
+		aval |= (s >> 1) & 0x0f
+	} else {
+		aval |= (s >> segment) & 0x0f
+	}
+	return byte(aval ^ mask)
+}
@@ -0,0 +1,50 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package onvif
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeMuLaw(t *testing.T) {
+	cases := map[int16]byte{
+		0:      0xff,
+		-1:     0x7f,
+		1000:   0xce,
+		-1000:  0x4e,
+		32767:  0x80,
+		-32768: 0x00,
+	}
+	for input, expected := range cases {
+		require.Equal(t, expected, encodeMuLaw(input), input)
+	}
+}
+
+func TestEncodeALaw(t *testing.T) {
+	cases := map[int16]byte{
+		0:      0xd5,
+		-8:     0x55,
+		1000:   0xfa,
+		-1000:  0x7a,
+		32767:  0xaa,
+		-32768: 0x2a,
+	}
+	for input, expected := range cases {
+		require.Equal(t, expected, encodeALaw(input), input)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package onvif implements the subset of ONVIF used by the NVR,
+// WS-Discovery, device information, media profiles and events.
+package onvif
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors.
+var (
+	ErrSOAPFault      = errors.New("soap fault")
+	ErrHTTPStatus     = errors.New("unexpected http status")
+	ErrInvalidAddress = errors.New("invalid device address")
+)
+
+const soapContentType = `application/soap+xml; charset=utf-8`
+
+// Client is a ONVIF SOAP client for a single device.
+type Client struct {
+	// Device service address, for example
+	// "http://192.168.1.2/onvif/device_service".
+	XAddr    string
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+
+	// Device time minus local time. The time in the password
+	// digest must match the device clock within a few seconds.
+	timeOffset   time.Duration
+	timeOffsetMu sync.Mutex
+}
+
+// NewClient returns a client for the device service address.
+func NewClient(xaddr string, username string, password string) (*Client, error) {
+	if !strings.HasPrefix(xaddr, "http://") && !strings.HasPrefix(xaddr, "https://") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, xaddr)
+	}
+	return &Client{
+		XAddr:      xaddr,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (c *Client) now() time.Time {
+	c.timeOffsetMu.Lock()
+	defer c.timeOffsetMu.Unlock()
+	return time.Now().Add(c.timeOffset)
+}
+
+func (c *Client) setTimeOffset(d time.Duration) {
+	c.timeOffsetMu.Lock()
+	c.timeOffset = d
+	c.timeOffsetMu.Unlock()
+}
+
+type envelope struct {
+	Body struct {
+		Fault *fault `xml:"Fault"`
+		Inner []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+type fault struct {
+	Code struct {
+		Value   string `xml:"Value"`
+		Subcode struct {
+			Value string `xml:"Value"`
+		} `xml:"Subcode"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+}
+
+func (f *fault) Error() string {
+	msg := f.Code.Value
+	if f.Code.Subcode.Value != "" {
+		msg += " " + f.Code.Subcode.Value
+	}
+	if f.Reason.Text != "" {
+		msg += ": " + f.Reason.Text
+	}
+	return msg
+}
+
+// call sends the request body to the service address and decodes
+// the response body into res. The request is authenticated with a
+// WS-Security UsernameToken if the client has a username.
+func (c *Client) call(ctx context.Context, xaddr string, body string, res interface{}) error {
+	return c.callAction(ctx, xaddr, "", body, res)
+}
+
+// callAction is call with WS-Addressing headers, the event
+// service requires them to route requests to subscriptions.
+func (c *Client) callAction(
+	ctx context.Context,
+	xaddr string,
+	action string,
+	body string,
+	res interface{},
+) error {
+	reqBody := c.envelope(xaddr, action, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, xaddr, strings.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", soapContentType)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	var env envelope
+	if err := xml.Unmarshal(respBody, &env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: %v", ErrHTTPStatus, resp.Status)
+		}
+		return fmt.Errorf("unmarshal envelope: %w", err)
+	}
+	if env.Body.Fault != nil {
+		return fmt.Errorf("%w: %v", ErrSOAPFault, env.Body.Fault)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v", ErrHTTPStatus, resp.Status)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(env.Body.Inner, res); err != nil {
+		return fmt.Errorf("unmarshal body: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) envelope(xaddr string, action string, body string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	b.WriteString(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"`)
+	b.WriteString(` xmlns:a="http://www.w3.org/2005/08/addressing">`)
+	b.WriteString(`<s:Header>`)
+	if c.Username != "" {
+		b.WriteString(c.security())
+	}
+	if action != "" {
+		b.WriteString(`<a:Action s:mustUnderstand="1">` + escape(action) + `</a:Action>`)
+		b.WriteString(`<a:MessageID>urn:uuid:` + newUUID() + `</a:MessageID>`)
+		b.WriteString(`<a:To s:mustUnderstand="1">` + escape(xaddr) + `</a:To>`)
+	}
+	b.WriteString(`</s:Header><s:Body>`)
+	b.WriteString(body)
+	b.WriteString(`</s:Body></s:Envelope>`)
+	return b.String()
+}
+
+// security returns a WS-Security header with a password digest.
+// Digest = Base64(SHA-1(nonce + created + password)).
+func (c *Client) security() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce) //nolint:errcheck
+	created := c.now().UTC().Format("2006-01-02T15:04:05.000Z")
+
+	h := sha1.New() //nolint:gosec
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(c.Password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken>` +
+		`<Username>` + escape(c.Username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` +
+		digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` +
+		base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` +
+		created + `</Created>` +
+		`</UsernameToken></Security>`
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s)) //nolint:errcheck
+	return b.String()
+}
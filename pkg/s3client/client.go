@@ -0,0 +1,262 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config bucket and credentials.
+type Config struct {
+	// For example "https://s3.eu-north-1.amazonaws.com".
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// Use "endpoint/bucket/key" urls instead
+	// of "bucket.endpoint/key", required by MinIO.
+	PathStyle bool
+}
+
+// Client minimal S3 client that signs requests with AWS Signature V4.
+type Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// Client errors.
+var (
+	ErrInvalidEndpoint  = errors.New("invalid endpoint")
+	ErrUnexpectedStatus = errors.New("unexpected status")
+)
+
+// New returns a client for the bucket.
+func New(c Config) (*Client, error) {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidEndpoint, c.Endpoint)
+	}
+	return &Client{
+		endpoint:   endpoint,
+		region:     c.Region,
+		bucket:     c.Bucket,
+		accessKey:  c.AccessKey,
+		secretKey:  c.SecretKey,
+		pathStyle:  c.PathStyle,
+		httpClient: &http.Client{},
+		now:        time.Now,
+	}, nil
+}
+
+// PutObject uploads the object. The payload isn't hashed since it's
+// streamed from disk, the transport should be https.
+func (c *Client) PutObject(
+	ctx context.Context,
+	key string,
+	body io.Reader,
+	size int64,
+	contentType string,
+	storageClass string,
+) error {
+	req, err := c.newRequest(ctx, http.MethodPut, key, "", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if storageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", storageClass)
+	}
+	c.sign(req, unsignedPayload)
+	return c.do(req)
+}
+
+// PutLifecycle replaces the lifecycle configuration of the bucket.
+func (c *Client) PutLifecycle(ctx context.Context, config []byte) error {
+	req, err := c.newRequest(ctx, http.MethodPut, "", "lifecycle=", bytes.NewReader(config))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(config))
+	sum := md5.Sum(config) //nolint:gosec
+	req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	req.Header.Set("Content-Type", "application/xml")
+	c.sign(req, hashHex(config))
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) error {
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%w: %v %v %s", ErrUnexpectedStatus, req.Method, res.Status, body)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(
+	ctx context.Context,
+	method string,
+	key string,
+	rawQuery string,
+	body io.Reader,
+) (*http.Request, error) {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = rawQuery
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+// sign adds the Signature V4 authorization header,
+// all headers that are set on the request are signed.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" +
+		now.Format(amzDateFormat) + "\n" +
+		scope + "\n" +
+		hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 "+
+		"Credential="+c.accessKey+"/"+scope+","+
+		"SignedHeaders="+signedHeaders+","+
+		"Signature="+signature)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes everything except the unreserved characters.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
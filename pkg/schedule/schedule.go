@@ -0,0 +1,165 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package schedule weekly schedules for detectors and zones.
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule weekly time ranges in local time. The
+// schedule is active if any range is active, an
+// empty schedule is always active.
+type Schedule []Range
+
+// Range time range on the selected days, for example
+//
+//	{"days": ["sat", "sun"], "start": "22:00", "end": "06:00"}
+//
+// Ranges where the end is before the start continue past midnight,
+// the days refer to the start. Empty days is every day.
+type Range struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// Schedule errors.
+var (
+	ErrInvalidDay  = errors.New("invalid day")
+	ErrInvalidTime = errors.New("invalid time")
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWeekday parses "mon" to "sun", case insensitive.
+func ParseWeekday(day string) (time.Weekday, error) {
+	weekday, exist := weekdays[strings.ToLower(day)]
+	if !exist {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDay, day)
+	}
+	return weekday, nil
+}
+
+// Parse JSON encoded schedule, empty is always active.
+func Parse(raw string) (Schedule, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var s Schedule
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, fmt.Errorf("unmarshal schedule: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks the days and times.
+func (s Schedule) Validate() error {
+	for _, r := range s {
+		for _, day := range r.Days {
+			if _, err := ParseWeekday(day); err != nil {
+				return err
+			}
+		}
+		if _, err := parseClock(r.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(r.End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Active if the time is within the schedule.
+func (s Schedule) Active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, r := range s {
+		if r.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Range) active(t time.Time) bool {
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return false
+	}
+
+	now := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if start < end {
+		return now >= start && now < end && r.onDay(t.Weekday())
+	}
+	// Past midnight, the early part belongs to the previous day.
+	if now >= start {
+		return r.onDay(t.Weekday())
+	}
+	if now < end {
+		return r.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (r Range) onDay(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "15:04" to the duration since midnight.
+// "24:00" is allowed as the end of the day.
+func parseClock(raw string) (time.Duration, error) {
+	if raw == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidTime, raw)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
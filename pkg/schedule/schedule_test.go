@@ -0,0 +1,88 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package schedule
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		s, err := Parse(`[{"days":["Mon","tue"],"start":"08:00","end":"17:30"}]`)
+		require.NoError(t, err)
+		expected := Schedule{{Days: []string{"Mon", "tue"}, Start: "08:00", End: "17:30"}}
+		require.Equal(t, expected, s)
+	})
+	t.Run("empty", func(t *testing.T) {
+		s, err := Parse("")
+		require.NoError(t, err)
+		require.Nil(t, s)
+	})
+	t.Run("day", func(t *testing.T) {
+		_, err := Parse(`[{"days":["x"],"start":"08:00","end":"17:00"}]`)
+		require.ErrorIs(t, err, ErrInvalidDay)
+	})
+	t.Run("time", func(t *testing.T) {
+		_, err := Parse(`[{"start":"8","end":"17:00"}]`)
+		require.ErrorIs(t, err, ErrInvalidTime)
+	})
+}
+
+func TestActive(t *testing.T) {
+	// 2022-01-03 is a monday.
+	at := func(day int, clock string) time.Time {
+		raw := fmt.Sprintf("2022-01-%02d %v", day, clock)
+		t, err := time.ParseInLocation("2006-01-02 15:04", raw, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	weekdays := Schedule{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "17:00"}}
+	nights := Schedule{{Start: "22:00", End: "06:00"}}
+	fridayNight := Schedule{{Days: []string{"fri"}, Start: "22:00", End: "06:00"}}
+	allDay := Schedule{{Days: []string{"sun"}, Start: "00:00", End: "24:00"}}
+
+	cases := map[string]struct {
+		schedule Schedule
+		time     time.Time
+		expected bool
+	}{
+		"empty":          {nil, at(3, "12:00"), true},
+		"weekday":        {weekdays, at(3, "12:00"), true},
+		"beforeStart":    {weekdays, at(3, "07:59"), false},
+		"end":            {weekdays, at(3, "17:00"), false},
+		"weekend":        {weekdays, at(8, "12:00"), false},
+		"night":          {nights, at(3, "23:00"), true},
+		"earlyMorning":   {nights, at(4, "05:59"), true},
+		"day":            {nights, at(4, "12:00"), false},
+		"fridayNight":    {fridayNight, at(7, "23:00"), true},
+		"saturdayMorn":   {fridayNight, at(8, "03:00"), true},
+		"fridayMorning":  {fridayNight, at(7, "03:00"), false},
+		"allDay":         {allDay, at(9, "23:59"), true},
+		"allDayMonday":   {allDay, at(3, "00:00"), false},
+		"multipleRanges": {append(weekdays, allDay...), at(9, "12:00"), true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.schedule.Active(tc.time))
+		})
+	}
+}
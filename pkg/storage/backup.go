@@ -0,0 +1,256 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config backup limits.
+const (
+	MaxConfigBackupSize  = 64 * 1024 * 1024
+	maxConfigBackupFiles = 10000
+)
+
+// configBackupManifest is the first file in the archive.
+const configBackupManifest = "backup.json"
+
+const configBackupVersion = 1
+
+type configBackupInfo struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// Config backup errors.
+var (
+	ErrNotConfigBackup         = errors.New("not a config backup")
+	ErrConfigBackupVersion     = errors.New("unsupported config backup version")
+	ErrConfigBackupTooLarge    = errors.New("config backup is too large")
+	ErrConfigBackupInvalidFile = errors.New("invalid file name")
+)
+
+// ExportConfig writes every file in the config directory to w as a
+// gzipped tar archive. The archive contains password hashes and keys.
+func ExportConfig(w io.Writer, configDir string, now time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	info, _ := json.MarshalIndent(configBackupInfo{
+		Version: configBackupVersion,
+		Time:    now.UTC(),
+	}, "", "    ")
+	if err := writeTarFile(tw, configBackupManifest, info, now); err != nil {
+		return err
+	}
+
+	walkFunc := func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(configDir, filePath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if name == configBackupManifest {
+			return nil
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return writeTarFile(tw, name, data, fileInfo.ModTime())
+	}
+	if err := filepath.WalkDir(configDir, walkFunc); err != nil {
+		return fmt.Errorf("walk config directory: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("write header: %v: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write file: %v: %w", name, err)
+	}
+	return nil
+}
+
+// ConfigBackup config files read from a backup archive.
+type ConfigBackup struct {
+	Time  time.Time
+	files map[string][]byte
+}
+
+// ReadConfigBackup reads and validates a archive created by ExportConfig.
+// Only regular files with relative paths inside the directory are allowed.
+func ReadConfigBackup(r io.Reader) (*ConfigBackup, error) {
+	gz, err := gzip.NewReader(io.LimitReader(r, MaxConfigBackupSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotConfigBackup, err)
+	}
+	tr := tar.NewReader(gz)
+
+	backup := &ConfigBackup{files: make(map[string][]byte)}
+	var total int64
+	hasManifest := false
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: not a regular file: %q", ErrConfigBackupInvalidFile, header.Name)
+		}
+		name := path.Clean(header.Name)
+		if name != header.Name || path.IsAbs(name) ||
+			name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%w: %q", ErrConfigBackupInvalidFile, header.Name)
+		}
+
+		total += header.Size
+		if total > MaxConfigBackupSize || len(backup.files) >= maxConfigBackupFiles {
+			return nil, ErrConfigBackupTooLarge
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read file: %v: %w", name, err)
+		}
+
+		if name == configBackupManifest {
+			var info configBackupInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrNotConfigBackup, err)
+			}
+			if info.Version != configBackupVersion {
+				return nil, fmt.Errorf("%w: %v", ErrConfigBackupVersion, info.Version)
+			}
+			backup.Time = info.Time
+			hasManifest = true
+			continue
+		}
+		backup.files[name] = data
+	}
+	if !hasManifest {
+		return nil, fmt.Errorf("%w: %v missing", ErrNotConfigBackup, configBackupManifest)
+	}
+	return backup, nil
+}
+
+// Files returns the file names in the backup sorted.
+func (b *ConfigBackup) Files() []string {
+	names := make([]string, 0, len(b.files))
+	for name := range b.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract writes the files to dir.
+func (b *ConfigBackup) Extract(dir string) error {
+	for _, name := range b.Files() {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, b.files[name], 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the files in the config directory with the backup.
+// Each file is replaced atomically. Monitor configs that aren't in the
+// backup are removed, other files are kept. Returns the removed files.
+func (b *ConfigBackup) Restore(configDir string) ([]string, error) {
+	for _, name := range b.Files() {
+		filePath := filepath.Join(configDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o700); err != nil {
+			return nil, err
+		}
+		tmpPath := filePath + ".restore"
+		if err := os.WriteFile(tmpPath, b.files[name], 0o600); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			return nil, err
+		}
+	}
+
+	removed := []string{}
+	entries, err := os.ReadDir(filepath.Join(configDir, "monitors"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := "monitors/" + entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		if _, exist := b.files[name]; exist {
+			continue
+		}
+		if err := os.Remove(filepath.Join(configDir, "monitors", entry.Name())); err != nil {
+			return nil, err
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// ConfigBackupName returns the file name of a backup created at t.
+func ConfigBackupName(t time.Time) string {
+	return "osnvr-config-" + t.Format("2006-01-02_15-04-05") + ".tar.gz"
+}
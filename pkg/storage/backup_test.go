@@ -0,0 +1,131 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	}
+}
+
+func TestConfigBackup(t *testing.T) {
+	srcDir := t.TempDir()
+	writeConfigFiles(t, srcDir, map[string]string{
+		"env.yaml":        "a",
+		"general.json":    "b",
+		"monitors/1.json": "c",
+	})
+	now := time.Unix(1, 0).UTC()
+
+	var b bytes.Buffer
+	require.NoError(t, ExportConfig(&b, srcDir, now))
+
+	backup, err := ReadConfigBackup(&b)
+	require.NoError(t, err)
+	require.Equal(t, now, backup.Time)
+	require.Equal(t, []string{"env.yaml", "general.json", "monitors/1.json"}, backup.Files())
+
+	dstDir := t.TempDir()
+	writeConfigFiles(t, dstDir, map[string]string{
+		"env.yaml":        "x",
+		"users.json":      "y",
+		"monitors/1.json": "x",
+		"monitors/2.json": "x",
+	})
+	removed, err := backup.Restore(dstDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"monitors/2.json"}, removed)
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dstDir, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, "a", read("env.yaml"))
+	require.Equal(t, "b", read("general.json"))
+	require.Equal(t, "c", read("monitors/1.json"))
+	require.Equal(t, "y", read("users.json"))
+	require.NoFileExists(t, filepath.Join(dstDir, "monitors", "2.json"))
+}
+
+func TestReadConfigBackup(t *testing.T) {
+	archive := func(headers ...*tar.Header) *bytes.Buffer {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gz)
+		for _, h := range headers {
+			require.NoError(t, tw.WriteHeader(h))
+			if h.Typeflag == tar.TypeReg {
+				_, err := tw.Write(make([]byte, h.Size))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+		return &b
+	}
+	manifest := func() *bytes.Buffer {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gz)
+		require.NoError(t, writeTarFile(tw, "backup.json", []byte(`{"version":2}`), time.Time{}))
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+		return &b
+	}
+
+	cases := map[string]struct {
+		input    *bytes.Buffer
+		expected error
+	}{
+		"notGzip":    {bytes.NewBufferString("a"), ErrNotConfigBackup},
+		"noManifest": {archive(), ErrNotConfigBackup},
+		"version":    {manifest(), ErrConfigBackupVersion},
+		"traversal": {
+			archive(&tar.Header{Name: "../a", Typeflag: tar.TypeReg}),
+			ErrConfigBackupInvalidFile,
+		},
+		"absolute": {
+			archive(&tar.Header{Name: "/a", Typeflag: tar.TypeReg}),
+			ErrConfigBackupInvalidFile,
+		},
+		"symlink": {
+			archive(&tar.Header{Name: "a", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}),
+			ErrConfigBackupInvalidFile,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadConfigBackup(tc.input)
+			require.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
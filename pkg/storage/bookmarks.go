@@ -0,0 +1,336 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Bookmark named timestamp with a note and tags, used to find
+// reviewed incidents again. Bookmarks aren't removed with the
+// recordings, the note remains after the video is gone.
+type Bookmark struct {
+	ID        uint64    `json:"id"`
+	MonitorID string    `json:"monitorId"`
+	Time      time.Time `json:"time"`
+
+	// Recording that contains the time, optional.
+	RecordingID string `json:"recordingId,omitempty"`
+
+	Name string   `json:"name"`
+	Note string   `json:"note"`
+	Tags []string `json:"tags"`
+
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Bookmark limits.
+const (
+	maxBookmarkName = 100
+	maxBookmarkNote = 4000
+	maxBookmarkTags = 20
+	maxBookmarkTag  = 32
+)
+
+// Bookmark errors.
+var (
+	ErrBookmarkNotExist = errors.New("bookmark does not exist")
+	ErrInvalidBookmark  = errors.New("invalid bookmark")
+)
+
+// normalize validates the bookmark and lowercases
+// the tags and removes duplicate tags.
+func (b *Bookmark) normalize() error {
+	b.Name = strings.TrimSpace(b.Name)
+	switch {
+	case b.MonitorID == "":
+		return fmt.Errorf("%w: monitor missing", ErrInvalidBookmark)
+	case b.Time.IsZero():
+		return fmt.Errorf("%w: time missing", ErrInvalidBookmark)
+	case b.Name == "":
+		return fmt.Errorf("%w: name missing", ErrInvalidBookmark)
+	case utf8.RuneCountInString(b.Name) > maxBookmarkName:
+		return fmt.Errorf("%w: name is longer than %v characters", ErrInvalidBookmark, maxBookmarkName)
+	case utf8.RuneCountInString(b.Note) > maxBookmarkNote:
+		return fmt.Errorf("%w: note is longer than %v characters", ErrInvalidBookmark, maxBookmarkNote)
+	}
+	if b.RecordingID != "" {
+		if _, err := RecordingIDToPath(b.RecordingID); err != nil {
+			return fmt.Errorf("%w: recording id: %v", ErrInvalidBookmark, err)
+		}
+		if b.RecordingID[20:] != b.MonitorID {
+			return fmt.Errorf("%w: recording belongs to another monitor", ErrInvalidBookmark)
+		}
+	}
+
+	tags := []string{}
+	for _, tag := range b.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || containsString(tags, tag) {
+			continue
+		}
+		if len(tag) > maxBookmarkTag || strings.ContainsAny(tag, " ,") {
+			return fmt.Errorf("%w: tag: %q", ErrInvalidBookmark, tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxBookmarkTags {
+		return fmt.Errorf("%w: more than %v tags", ErrInvalidBookmark, maxBookmarkTags)
+	}
+	b.Tags = tags
+	return nil
+}
+
+// BookmarkStore bookmarks of all monitors stored as a JSON file.
+type BookmarkStore struct {
+	path string
+
+	// Sorted by time.
+	bookmarks []Bookmark
+	nextID    uint64
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// NewBookmarkStore reads the bookmarks file, the
+// file is created on the first change.
+func NewBookmarkStore(path string) (*BookmarkStore, error) {
+	s := &BookmarkStore{
+		path:   path,
+		nextID: 1,
+		now:    time.Now,
+	}
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read bookmarks: %w", err)
+	}
+	if err := json.Unmarshal(file, &s.bookmarks); err != nil {
+		return nil, fmt.Errorf("unmarshal bookmarks: %w", err)
+	}
+	s.sort()
+	for _, b := range s.bookmarks {
+		if b.ID >= s.nextID {
+			s.nextID = b.ID + 1
+		}
+	}
+	return s, nil
+}
+
+func (s *BookmarkStore) sort() {
+	sort.SliceStable(s.bookmarks, func(i, j int) bool {
+		return s.bookmarks[i].Time.Before(s.bookmarks[j].Time)
+	})
+}
+
+// Set creates the bookmark if the ID is zero, otherwise the
+// bookmark with the ID is updated. The creator and creation
+// time of existing bookmarks are kept. Returns the saved bookmark.
+func (s *BookmarkStore) Set(b Bookmark) (Bookmark, error) {
+	if err := b.normalize(); err != nil {
+		return Bookmark{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UTC()
+	b.Updated = now
+	bookmarks := append([]Bookmark{}, s.bookmarks...)
+	nextID := s.nextID
+	if b.ID == 0 {
+		b.ID = nextID
+		b.Created = now
+		nextID++
+		bookmarks = append(bookmarks, b)
+	} else {
+		i := indexBookmark(bookmarks, b.ID)
+		if i == -1 {
+			return Bookmark{}, ErrBookmarkNotExist
+		}
+		b.CreatedBy = bookmarks[i].CreatedBy
+		b.Created = bookmarks[i].Created
+		bookmarks[i] = b
+	}
+
+	if err := s.save(bookmarks); err != nil {
+		return Bookmark{}, err
+	}
+	s.bookmarks = bookmarks
+	s.nextID = nextID
+	s.sort()
+	return b, nil
+}
+
+// Get returns the bookmark with the ID.
+func (s *BookmarkStore) Get(id uint64) (Bookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := indexBookmark(s.bookmarks, id)
+	if i == -1 {
+		return Bookmark{}, ErrBookmarkNotExist
+	}
+	return s.bookmarks[i], nil
+}
+
+// Delete removes the bookmark with the ID.
+func (s *BookmarkStore) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := indexBookmark(s.bookmarks, id)
+	if i == -1 {
+		return ErrBookmarkNotExist
+	}
+	bookmarks := append([]Bookmark{}, s.bookmarks[:i]...)
+	bookmarks = append(bookmarks, s.bookmarks[i+1:]...)
+	if err := s.save(bookmarks); err != nil {
+		return err
+	}
+	s.bookmarks = bookmarks
+	return nil
+}
+
+func indexBookmark(bookmarks []Bookmark, id uint64) int {
+	for i, b := range bookmarks {
+		if b.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *BookmarkStore) save(bookmarks []Bookmark) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("create bookmarks directory: %w", err)
+	}
+	raw, err := json.MarshalIndent(bookmarks, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal bookmarks: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0o600); err != nil {
+		return fmt.Errorf("write bookmarks: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace bookmarks: %w", err)
+	}
+	return nil
+}
+
+// BookmarkQuery bookmark filter. Zero values match all bookmarks.
+type BookmarkQuery struct {
+	Monitors []string
+
+	// Bookmarks must have all the tags.
+	Tags []string
+
+	// Case insensitive text in the name or note.
+	Text string
+
+	Start time.Time
+	End   time.Time
+
+	// Oldest bookmarks first instead of newest.
+	Reverse bool
+
+	Limit  int
+	Offset int
+}
+
+func (q BookmarkQuery) match(b Bookmark) bool {
+	if !q.Start.IsZero() && b.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && !b.Time.Before(q.End) {
+		return false
+	}
+	if len(q.Monitors) != 0 && !containsString(q.Monitors, b.MonitorID) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !containsString(b.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		if !strings.Contains(strings.ToLower(b.Name), text) &&
+			!strings.Contains(strings.ToLower(b.Note), text) {
+			return false
+		}
+	}
+	return true
+}
+
+// Query returns a page of the matching bookmarks and
+// the total number of matches. Newest first by default.
+func (s *BookmarkStore) Query(q BookmarkQuery) ([]Bookmark, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := []Bookmark{}
+	for _, b := range s.bookmarks {
+		if q.match(b) {
+			matches = append(matches, b)
+		}
+	}
+	total := len(matches)
+	if !q.Reverse {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	if q.Offset >= len(matches) {
+		return []Bookmark{}, total
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, total
+}
+
+// Tags returns all tags in use, sorted.
+func (s *BookmarkStore) Tags() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := []string{}
+	for _, b := range s.bookmarks {
+		for _, tag := range b.Tags {
+			if !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,177 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBookmarkStore(t *testing.T) *BookmarkStore {
+	t.Helper()
+	s, err := NewBookmarkStore(filepath.Join(t.TempDir(), "bookmarks.json"))
+	require.NoError(t, err)
+	s.now = func() time.Time { return time.Unix(100, 0) }
+	return s
+}
+
+func TestBookmarkStore(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		s := newTestBookmarkStore(t)
+		b, err := s.Set(Bookmark{
+			MonitorID:   "m1",
+			RecordingID: "2022-01-02_03-04-05_m1",
+			Time:        time.Unix(10, 0),
+			Name:        " Car ",
+			Tags:        []string{"Vehicle", "vehicle", " "},
+			CreatedBy:   "admin",
+		})
+		require.NoError(t, err)
+		expected := Bookmark{
+			ID:          1,
+			MonitorID:   "m1",
+			RecordingID: "2022-01-02_03-04-05_m1",
+			Time:        time.Unix(10, 0),
+			Name:        "Car",
+			Tags:        []string{"vehicle"},
+			CreatedBy:   "admin",
+			Created:     time.Unix(100, 0).UTC(),
+			Updated:     time.Unix(100, 0).UTC(),
+		}
+		require.Equal(t, expected, b)
+
+		// Update.
+		s.now = func() time.Time { return time.Unix(200, 0) }
+		b.Note = "red"
+		b.CreatedBy = "other"
+		b, err = s.Set(b)
+		require.NoError(t, err)
+		require.Equal(t, "admin", b.CreatedBy)
+		require.Equal(t, time.Unix(100, 0).UTC(), b.Created)
+		require.Equal(t, time.Unix(200, 0).UTC(), b.Updated)
+
+		// Reopen.
+		s2, err := NewBookmarkStore(s.path)
+		require.NoError(t, err)
+		b2, err := s2.Get(1)
+		require.NoError(t, err)
+		require.Equal(t, "red", b2.Note)
+
+		b3, err := s2.Set(Bookmark{MonitorID: "m1", Time: time.Unix(1, 0), Name: "x"})
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), b3.ID)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		s := newTestBookmarkStore(t)
+		_, err := s.Set(Bookmark{ID: 5, MonitorID: "m1", Time: time.Unix(1, 0), Name: "x"})
+		require.ErrorIs(t, err, ErrBookmarkNotExist)
+		require.ErrorIs(t, s.Delete(5), ErrBookmarkNotExist)
+	})
+	t.Run("delete", func(t *testing.T) {
+		s := newTestBookmarkStore(t)
+		b, err := s.Set(Bookmark{MonitorID: "m1", Time: time.Unix(1, 0), Name: "x"})
+		require.NoError(t, err)
+		require.NoError(t, s.Delete(b.ID))
+		_, err = s.Get(b.ID)
+		require.ErrorIs(t, err, ErrBookmarkNotExist)
+	})
+}
+
+func TestBookmarkValidate(t *testing.T) {
+	valid := func() Bookmark {
+		return Bookmark{MonitorID: "m1", Time: time.Unix(1, 0), Name: "x"}
+	}
+	cases := map[string]func(*Bookmark){
+		"noMonitor":    func(b *Bookmark) { b.MonitorID = "" },
+		"noTime":       func(b *Bookmark) { b.Time = time.Time{} },
+		"noName":       func(b *Bookmark) { b.Name = " " },
+		"longName":     func(b *Bookmark) { b.Name = string(make([]byte, 101)) },
+		"invalidRecID": func(b *Bookmark) { b.RecordingID = "x" },
+		"otherMonitor": func(b *Bookmark) { b.RecordingID = "2022-01-02_03-04-05_m2" },
+		"tagWithSpace": func(b *Bookmark) { b.Tags = []string{"a b"} },
+		"tagWithComma": func(b *Bookmark) { b.Tags = []string{"a,b"} },
+		"tooManyTags":  func(b *Bookmark) { b.Tags = make([]string, 21); fillTags(b.Tags) },
+		"tagTooLong":   func(b *Bookmark) { b.Tags = []string{string(make([]byte, 33))} },
+	}
+	for name, modify := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := valid()
+			modify(&b)
+			require.ErrorIs(t, b.normalize(), ErrInvalidBookmark)
+		})
+	}
+}
+
+func fillTags(tags []string) {
+	for i := range tags {
+		tags[i] = string(rune('a' + i))
+	}
+}
+
+func TestBookmarkQuery(t *testing.T) {
+	s := newTestBookmarkStore(t)
+	add := func(monitorID string, sec int64, name string, note string, tags ...string) {
+		_, err := s.Set(Bookmark{
+			MonitorID: monitorID,
+			Time:      time.Unix(sec, 0),
+			Name:      name,
+			Note:      note,
+			Tags:      tags,
+		})
+		require.NoError(t, err)
+	}
+	add("m1", 30, "Car", "red car in driveway", "vehicle")
+	add("m2", 10, "Person", "", "person", "reviewed")
+	add("m1", 20, "Delivery", "Package left", "person", "vehicle")
+
+	names := func(bookmarks []Bookmark) []string {
+		list := []string{}
+		for _, b := range bookmarks {
+			list = append(list, b.Name)
+		}
+		return list
+	}
+	cases := map[string]struct {
+		query    BookmarkQuery
+		expected []string
+		total    int
+	}{
+		"all":      {BookmarkQuery{}, []string{"Car", "Delivery", "Person"}, 3},
+		"reverse":  {BookmarkQuery{Reverse: true}, []string{"Person", "Delivery", "Car"}, 3},
+		"monitor":  {BookmarkQuery{Monitors: []string{"m2"}}, []string{"Person"}, 1},
+		"tags":     {BookmarkQuery{Tags: []string{"Person", "vehicle"}}, []string{"Delivery"}, 1},
+		"textNote": {BookmarkQuery{Text: "PACKAGE"}, []string{"Delivery"}, 1},
+		"textName": {BookmarkQuery{Text: "car"}, []string{"Car"}, 1},
+		"range": {
+			BookmarkQuery{Start: time.Unix(10, 0), End: time.Unix(30, 0)},
+			[]string{"Delivery", "Person"}, 2,
+		},
+		"page":   {BookmarkQuery{Limit: 1, Offset: 1}, []string{"Delivery"}, 3},
+		"offset": {BookmarkQuery{Offset: 5}, []string{}, 3},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			bookmarks, total := s.Query(tc.query)
+			require.Equal(t, tc.expected, names(bookmarks))
+			require.Equal(t, tc.total, total)
+		})
+	}
+
+	require.Equal(t, []string{"person", "reviewed", "vehicle"}, s.Tags())
+}
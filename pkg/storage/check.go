@@ -0,0 +1,116 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Path check errors.
+var (
+	ErrNotDirectory = errors.New("not a directory")
+	ErrNotWritable  = errors.New("not writable")
+)
+
+// CheckPaths checks that the storage directories can be created
+// and written to, and that the configured files exist. Directories
+// that doesn't exist yet are checked by their nearest parent.
+func (env ConfigEnv) CheckPaths() []error {
+	dirs := [][2]string{
+		{"storageDir", env.StorageDir},
+		{"hlsSpillDir", env.HLSSpillDir},
+		{"archiveDir", env.ArchiveDir},
+	}
+	if env.LogFile != "" {
+		dirs = append(dirs, [2]string{"logFile", filepath.Dir(env.LogFile)})
+	}
+	files := [][2]string{
+		{"tlsCertFile", env.TLSCertFile},
+		{"tlsKeyFile", env.TLSKeyFile},
+		{"acmeDNSHook", env.ACMEDNSHook},
+	}
+
+	var errs []error
+	for _, dir := range dirs {
+		if dir[1] == "" {
+			continue
+		}
+		if err := checkWritableDir(dir[1]); err != nil {
+			errs = append(errs, fmt.Errorf("%v '%v': %w", dir[0], dir[1], err))
+		}
+	}
+	for _, file := range files {
+		if file[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(file[1]); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", file[0], err))
+		}
+	}
+	return errs
+}
+
+const accessWrite = 0x2 // W_OK
+
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %v", ErrNotDirectory, dir)
+		}
+		if err := syscall.Access(dir, accessWrite); err != nil {
+			return fmt.Errorf("%w: %v: %v", ErrNotWritable, dir, err)
+		}
+		return nil
+	}
+}
+
+// CheckConfigGeneral validates the general config file in the
+// directory. A missing file is valid since it will be generated.
+func CheckConfigGeneral(configDir string) error {
+	file, err := os.ReadFile(filepath.Join(configDir, "general.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	general := ConfigGeneral{}
+	if err := json.Unmarshal(file, &general.Config); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	if _, err := general.DiskSpace(); err != nil {
+		return err
+	}
+	return nil
+}
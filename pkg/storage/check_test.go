@@ -0,0 +1,87 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPaths(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	t.Run("ok", func(t *testing.T) {
+		env := ConfigEnv{
+			StorageDir:  filepath.Join(tempDir, "a", "b"),
+			HLSSpillDir: tempDir,
+			TLSCertFile: file,
+		}
+		require.Empty(t, env.CheckPaths())
+	})
+	t.Run("errors", func(t *testing.T) {
+		env := ConfigEnv{
+			StorageDir:  filepath.Join(file, "storage"),
+			HLSSpillDir: tempDir,
+			TLSKeyFile:  filepath.Join(tempDir, "nil"),
+		}
+		errs := env.CheckPaths()
+		require.Len(t, errs, 2)
+		require.ErrorIs(t, errs[0], ErrNotDirectory)
+		require.ErrorIs(t, errs[1], os.ErrNotExist)
+	})
+}
+
+func TestCheckConfigGeneral(t *testing.T) {
+	cases := map[string]struct {
+		input string
+		valid bool
+	}{
+		"ok":           {`{"diskSpace": "5"}`, true},
+		"invalidJSON":  {"{", false},
+		"invalidSpace": {`{"diskSpace": "a"}`, false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			path := filepath.Join(tempDir, "general.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.input), 0o600))
+
+			err := CheckConfigGeneral(tempDir)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+	t.Run("missing", func(t *testing.T) {
+		require.NoError(t, CheckConfigGeneral(t.TempDir()))
+	})
+	t.Run("syntaxErr", func(t *testing.T) {
+		tempDir := t.TempDir()
+		path := filepath.Join(tempDir, "general.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+		var e *json.SyntaxError
+		require.ErrorAs(t, CheckConfigGeneral(tempDir), &e)
+	})
+}
@@ -109,9 +109,11 @@ func (c *Crawler) RecordingByQuery(q *CrawlerQuery) ([]Recording, error) {
 			return nil
 		}()
 
+		_, err := fs.Stat(c.fs, file.path+flagExt)
 		recordings = append(recordings, Recording{
-			ID:   filepath.Base(file.path),
-			Data: data,
+			ID:      filepath.Base(file.path),
+			Data:    data,
+			Flagged: err == nil,
 		})
 	}
 	return recordings, nil
@@ -0,0 +1,465 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Encrypted recording files start with a header of the magic bytes
+// and a random nonce prefix, followed by fixed-size AES-GCM chunks.
+// The nonce of each chunk is the prefix and the chunk index, the
+// last chunk is authenticated as final to detect truncation.
+//
+//	[magic 8][prefix 8][chunk 0: ciphertext 64KiB + tag 16]...[final chunk]
+const (
+	cryptMagic      = "NVRENC01"
+	cryptPrefixSize = 8
+	cryptHeaderSize = len(cryptMagic) + cryptPrefixSize
+	cryptChunkSize  = 64 * 1024
+	cryptTagSize    = 16
+	cryptKeySize    = 32
+)
+
+// Encryption errors.
+var (
+	ErrInvalidKey     = errors.New("invalid encryption key")
+	ErrDecrypt        = errors.New("could not decrypt file")
+	ErrEncryptedFile  = errors.New("file is encrypted but no key is configured")
+	ErrWriterIsClosed = errors.New("writer is closed")
+	ErrFileTooLarge   = errors.New("file is too large to encrypt")
+)
+
+// Crypt encrypts and decrypts recording files with a per-install key.
+type Crypt struct {
+	aead cipher.AEAD
+}
+
+// NewCrypt creates a crypt from a 32 byte AES-256 key.
+func NewCrypt(key []byte) (*Crypt, error) {
+	if len(key) != cryptKeySize {
+		return nil, fmt.Errorf("%w: expected %v bytes got %v",
+			ErrInvalidKey, cryptKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Crypt{aead: aead}, nil
+}
+
+// LoadCrypt reads the hex encoded key file, a new
+// key is generated if the file doesn't exist.
+func LoadCrypt(keyPath string) (*Crypt, error) {
+	key, err := loadKeyFile(keyPath, cryptKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return NewCrypt(key)
+}
+
+// loadKeyFile reads the hex encoded key file, a random
+// key of the size is generated if the file doesn't exist.
+func loadKeyFile(keyPath string, size int) ([]byte, error) {
+	raw, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateKeyFile(keyPath, size)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%w: expected %v bytes got %v", ErrInvalidKey, size, len(key))
+	}
+	return key, nil
+}
+
+func generateKeyFile(keyPath string, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+		return nil, err
+	}
+	// O_EXCL prevents overwriting a key that is already in use.
+	file, err := os.OpenFile(keyPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create key file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, fmt.Errorf("write key file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("sync key file: %w", err)
+	}
+	return key, nil
+}
+
+func (c *Crypt) nonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[cryptPrefixSize:], index)
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// RecordingWriter writes a recording file that is encrypted if a crypt
+// is configured. Close must be called to write the final chunk.
+type RecordingWriter struct {
+	file  *os.File
+	crypt *Crypt
+
+	prefix []byte
+	buf    []byte
+	index  uint32
+
+	closed bool
+}
+
+// CreateRecordingFile creates or truncates the file at path.
+// The file is written in plain text if crypt is nil.
+func CreateRecordingFile(path string, crypt *Crypt) (*RecordingWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := &RecordingWriter{file: file, crypt: crypt}
+	if crypt == nil {
+		return w, nil
+	}
+
+	w.prefix = make([]byte, cryptPrefixSize)
+	if _, err := rand.Read(w.prefix); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("generate nonce prefix: %w", err)
+	}
+	if _, err := file.Write(append([]byte(cryptMagic), w.prefix...)); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	w.buf = make([]byte, 0, cryptChunkSize)
+	return w, nil
+}
+
+// Write implements io.Writer. Encrypted data is buffered until a full
+// chunk is available, a chunk is only sealed once more data arrives
+// so that the last chunk can be marked as final by Close.
+func (w *RecordingWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWriterIsClosed
+	}
+	if w.crypt == nil {
+		return w.file.Write(p)
+	}
+
+	n := len(p)
+	for len(w.buf)+len(p) > cryptChunkSize {
+		fill := cryptChunkSize - len(w.buf)
+		w.buf = append(w.buf, p[:fill]...)
+		p = p[fill:]
+		if err := w.writeChunk(false); err != nil {
+			return 0, err
+		}
+	}
+	w.buf = append(w.buf, p...)
+	return n, nil
+}
+
+func (w *RecordingWriter) writeChunk(final bool) error {
+	if w.index == math.MaxUint32 {
+		return ErrFileTooLarge
+	}
+	sealed := w.crypt.aead.Seal(nil, w.crypt.nonce(w.prefix, w.index), w.buf, chunkAAD(final))
+	if _, err := w.file.Write(sealed); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.index++
+	return nil
+}
+
+// WriterAt returns the file if it's written in plain text so that
+// headers can be updated in place. Nil if the file is encrypted.
+func (w *RecordingWriter) WriterAt() io.WriterAt {
+	if w.crypt != nil {
+		return nil
+	}
+	return w.file
+}
+
+// Close writes the final chunk, syncs and closes
+// the file. Calling Close again is a no-op.
+func (w *RecordingWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.file.Close()
+
+	if w.crypt != nil {
+		if err := w.writeChunk(true); err != nil {
+			return fmt.Errorf("write final chunk: %w", err)
+		}
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// RecordingFile reads a recording file that may be encrypted. Plain
+// text files are read as is, so that recordings from before encryption
+// was enabled remain playable. Implements io.ReadSeekCloser and io.ReaderAt.
+type RecordingFile struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// OpenRecordingFile opens the file at path for reading.
+func OpenRecordingFile(path string, crypt *Crypt) (*RecordingFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := stat.Size()
+
+	header := make([]byte, cryptHeaderSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, err
+	}
+	if n < cryptHeaderSize || string(header[:len(cryptMagic)]) != cryptMagic {
+		return &RecordingFile{
+			SectionReader: io.NewSectionReader(file, 0, size),
+			file:          file,
+		}, nil
+	}
+	if crypt == nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %v", ErrEncryptedFile, path)
+	}
+
+	r, err := newDecryptReader(file, crypt, header[len(cryptMagic):], size)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return &RecordingFile{
+		SectionReader: io.NewSectionReader(r, 0, r.size),
+		file:          file,
+	}, nil
+}
+
+// Close implements io.Closer.
+func (f *RecordingFile) Close() error {
+	return f.file.Close()
+}
+
+// decryptReader decrypts the chunks on demand. The
+// most recently decrypted chunk is cached.
+type decryptReader struct {
+	file   io.ReaderAt
+	crypt  *Crypt
+	prefix []byte
+
+	// Plain text size.
+	size    int64
+	nChunks int64
+
+	mu         sync.Mutex
+	cacheIndex int64
+	cache      []byte
+}
+
+func newDecryptReader(
+	file io.ReaderAt,
+	crypt *Crypt,
+	prefix []byte,
+	fileSize int64,
+) (*decryptReader, error) {
+	const sealedChunkSize = cryptChunkSize + cryptTagSize
+	body := fileSize - int64(cryptHeaderSize)
+	nChunks := body / sealedChunkSize
+	size := nChunks * cryptChunkSize
+	if rem := body % sealedChunkSize; rem != 0 {
+		if rem < cryptTagSize {
+			return nil, fmt.Errorf("%w: truncated chunk", ErrDecrypt)
+		}
+		nChunks++
+		size += rem - cryptTagSize
+	}
+	if nChunks == 0 {
+		return nil, fmt.Errorf("%w: missing final chunk", ErrDecrypt)
+	}
+	return &decryptReader{
+		file:       file,
+		crypt:      crypt,
+		prefix:     append([]byte(nil), prefix...),
+		size:       size,
+		nChunks:    nChunks,
+		cacheIndex: -1,
+	}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *decryptReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < r.size {
+		index := off / cryptChunkSize
+		chunk, err := r.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], chunk[off%cryptChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *decryptReader) chunk(index int64) ([]byte, error) {
+	if index == r.cacheIndex {
+		return r.cache, nil
+	}
+	const sealedChunkSize = cryptChunkSize + cryptTagSize
+	final := index == r.nChunks-1
+	sealedSize := int64(sealedChunkSize)
+	if final {
+		sealedSize = r.size - index*cryptChunkSize + cryptTagSize
+	}
+
+	sealed := make([]byte, sealedSize)
+	pos := int64(cryptHeaderSize) + index*sealedChunkSize
+	if _, err := r.file.ReadAt(sealed, pos); err != nil {
+		return nil, fmt.Errorf("read chunk %v: %w", index, err)
+	}
+	nonce := r.crypt.nonce(r.prefix, uint32(index))
+	plain, err := r.crypt.aead.Open(sealed[:0], nonce, sealed, chunkAAD(final))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %v: %v", ErrDecrypt, index, err)
+	}
+	r.cacheIndex = index
+	r.cache = plain
+	return plain, nil
+}
+
+// FinalizeRecordingFile makes a encrypted file that was never closed
+// readable by sealing a empty final chunk after the last complete chunk,
+// the data of the incomplete chunk is lost. Plain text files and files
+// that already end with a final chunk are not modified.
+func FinalizeRecordingFile(path string, crypt *Crypt) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	header := make([]byte, cryptHeaderSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n < cryptHeaderSize || string(header[:len(cryptMagic)]) != cryptMagic {
+		return nil
+	}
+	if crypt == nil {
+		return fmt.Errorf("%w: %v", ErrEncryptedFile, path)
+	}
+	prefix := header[len(cryptMagic):]
+
+	r, err := newDecryptReader(file, crypt, prefix, size)
+	if err == nil {
+		if _, err := r.chunk(r.nChunks - 1); err == nil {
+			return nil
+		}
+	}
+
+	// The last complete chunk must be a valid non-final chunk,
+	// otherwise the file is corrupt or the key is wrong.
+	const sealedChunkSize = cryptChunkSize + cryptTagSize
+	complete := (size - int64(cryptHeaderSize)) / sealedChunkSize
+	if complete > 0 {
+		sealed := make([]byte, sealedChunkSize)
+		pos := int64(cryptHeaderSize) + (complete-1)*sealedChunkSize
+		if _, err := file.ReadAt(sealed, pos); err != nil {
+			return fmt.Errorf("read chunk: %w", err)
+		}
+		nonce := crypt.nonce(prefix, uint32(complete-1))
+		if _, err := crypt.aead.Open(nil, nonce, sealed, chunkAAD(false)); err != nil {
+			return fmt.Errorf("%w: chunk %v: %v", ErrDecrypt, complete-1, err)
+		}
+	}
+
+	end := int64(cryptHeaderSize) + complete*sealedChunkSize
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+	final := crypt.aead.Seal(nil, crypt.nonce(prefix, uint32(complete)), nil, chunkAAD(true))
+	if _, err := file.WriteAt(final, end); err != nil {
+		return fmt.Errorf("write final chunk: %w", err)
+	}
+	return file.Sync()
+}
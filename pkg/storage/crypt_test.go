@@ -0,0 +1,248 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCrypt(t *testing.T) *Crypt {
+	t.Helper()
+	crypt, err := NewCrypt(bytes.Repeat([]byte{1}, cryptKeySize))
+	require.NoError(t, err)
+	return crypt
+}
+
+func writeRecordingFile(t *testing.T, path string, crypt *Crypt, data []byte) {
+	t.Helper()
+	w, err := CreateRecordingFile(path, crypt)
+	require.NoError(t, err)
+	// Uneven writes to cross the chunk borders.
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := w.Write(data[:n])
+		require.NoError(t, err)
+		data = data[n:]
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+}
+
+func TestRecordingFile(t *testing.T) {
+	crypt := newTestCrypt(t)
+	sizes := []int{0, 1, cryptChunkSize, cryptChunkSize + 1, 3*cryptChunkSize + 5}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data) //nolint:gosec
+
+		path := filepath.Join(t.TempDir(), "test")
+		writeRecordingFile(t, path, crypt, data)
+
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		if size > cryptTagSize {
+			require.False(t, bytes.Contains(raw, data), "size %v", size)
+		}
+
+		file, err := OpenRecordingFile(path, crypt)
+		require.NoError(t, err)
+		require.Equal(t, int64(size), file.Size())
+
+		plain, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, data, plain, "size %v", size)
+
+		if size > 10 {
+			buf := make([]byte, 10)
+			_, err := file.ReadAt(buf, int64(size-10))
+			require.NoError(t, err)
+			require.Equal(t, data[size-10:], buf)
+
+			_, err = file.Seek(int64(size/2), io.SeekStart)
+			require.NoError(t, err)
+			_, err = io.ReadFull(file, buf)
+			require.NoError(t, err)
+			require.Equal(t, data[size/2:size/2+10], buf)
+		}
+		require.NoError(t, file.Close())
+	}
+}
+
+func TestRecordingFilePlain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	writeRecordingFile(t, path, nil, []byte("abc"))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), raw)
+
+	// Plain text files are readable with a key.
+	file, err := OpenRecordingFile(path, newTestCrypt(t))
+	require.NoError(t, err)
+	defer file.Close()
+
+	plain, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), plain)
+}
+
+func TestRecordingFileErrors(t *testing.T) {
+	crypt := newTestCrypt(t)
+	data := bytes.Repeat([]byte{2}, 2*cryptChunkSize+100)
+
+	readAll := func(path string, crypt *Crypt) error {
+		file, err := OpenRecordingFile(path, crypt)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.ReadAll(file)
+		return err
+	}
+
+	t.Run("noKey", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		writeRecordingFile(t, path, crypt, data)
+		require.ErrorIs(t, readAll(path, nil), ErrEncryptedFile)
+	})
+	t.Run("wrongKey", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		writeRecordingFile(t, path, crypt, data)
+
+		wrongCrypt, err := NewCrypt(bytes.Repeat([]byte{3}, cryptKeySize))
+		require.NoError(t, err)
+		require.ErrorIs(t, readAll(path, wrongCrypt), ErrDecrypt)
+	})
+	t.Run("modified", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		writeRecordingFile(t, path, crypt, data)
+
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		raw[cryptHeaderSize+10] ^= 1
+		require.NoError(t, os.WriteFile(path, raw, 0o600))
+		require.ErrorIs(t, readAll(path, crypt), ErrDecrypt)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		writeRecordingFile(t, path, crypt, data)
+
+		// Remove the final chunk.
+		sealedChunkSize := int64(cryptChunkSize + cryptTagSize)
+		err := os.Truncate(path, int64(cryptHeaderSize)+2*sealedChunkSize)
+		require.NoError(t, err)
+		require.ErrorIs(t, readAll(path, crypt), ErrDecrypt)
+	})
+}
+
+func TestLoadCrypt(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "configs", "recording.key")
+	crypt, err := LoadCrypt(keyPath)
+	require.NoError(t, err)
+
+	stat, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), stat.Mode().Perm())
+
+	path := filepath.Join(t.TempDir(), "test")
+	writeRecordingFile(t, path, crypt, []byte("abc"))
+
+	// The same key is loaded again.
+	crypt2, err := LoadCrypt(keyPath)
+	require.NoError(t, err)
+	file, err := OpenRecordingFile(path, crypt2)
+	require.NoError(t, err)
+	defer file.Close()
+
+	plain, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, []byte("abc"), plain)
+
+	t.Run("invalidKey", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "recording.key")
+		require.NoError(t, os.WriteFile(keyPath, []byte("abcd\n"), 0o600))
+		_, err := LoadCrypt(keyPath)
+		require.ErrorIs(t, err, ErrInvalidKey)
+	})
+}
+
+func TestFinalizeRecordingFile(t *testing.T) {
+	crypt := newTestCrypt(t)
+	data := bytes.Repeat([]byte{4}, 2*cryptChunkSize+100)
+
+	path := filepath.Join(t.TempDir(), "test")
+	w, err := CreateRecordingFile(path, crypt)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+
+	// Crash without writing the final chunk.
+	require.NoError(t, w.file.Close())
+	file, err := OpenRecordingFile(path, crypt)
+	require.NoError(t, err)
+	_, err = io.ReadAll(file)
+	require.ErrorIs(t, err, ErrDecrypt)
+	file.Close()
+
+	require.NoError(t, FinalizeRecordingFile(path, crypt))
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// The file is only modified once.
+	require.NoError(t, FinalizeRecordingFile(path, crypt))
+	stat2, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, stat.Size(), stat2.Size())
+
+	file, err = OpenRecordingFile(path, crypt)
+	require.NoError(t, err)
+	defer file.Close()
+	plain, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, data[:2*cryptChunkSize], plain)
+
+	t.Run("wrongKey", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		w, err := CreateRecordingFile(path, crypt)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.file.Close())
+
+		wrongCrypt, err := NewCrypt(bytes.Repeat([]byte{3}, cryptKeySize))
+		require.NoError(t, err)
+		require.ErrorIs(t, FinalizeRecordingFile(path, wrongCrypt), ErrDecrypt)
+	})
+	t.Run("plain", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test")
+		require.NoError(t, os.WriteFile(path, []byte("abc"), 0o600))
+		require.NoError(t, FinalizeRecordingFile(path, crypt))
+
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, []byte("abc"), raw)
+	})
+}
@@ -0,0 +1,527 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventRecord event in the event store.
+type EventRecord struct {
+	ID         uint64      `json:"id"`
+	MonitorID  string      `json:"monitorId"`
+	Start      time.Time   `json:"start"`
+	End        time.Time   `json:"end"`
+	Detections []Detection `json:"detections,omitempty"`
+
+	// Recording that contains the event, empty until the recording
+	// is saved. The clip and thumbnail are served by the recording
+	// video and thumbnail APIs.
+	RecordingID string `json:"recordingId,omitempty"`
+}
+
+const (
+	eventOpAdd       = "add"
+	eventOpRecording = "recording"
+	eventOpRemove    = "remove"
+)
+
+type eventStoreRecord struct {
+	Op    string       `json:"op"`
+	Event *EventRecord `json:"event,omitempty"`
+
+	// Set if the operation is recording.
+	IDs         []uint64 `json:"ids,omitempty"`
+	RecordingID string   `json:"recordingId,omitempty"`
+
+	// Set if the operation is remove.
+	MonitorID string    `json:"monitorId,omitempty"`
+	Before    time.Time `json:"before,omitempty"`
+}
+
+// EventStore is the embedded event database. Like the segment index,
+// it's an append-only log of JSON records that is replayed into memory
+// on open and compacted when most of the records are obsolete. Events
+// are removed with the recordings, so the replay cost is bounded by the
+// retention of the monitors.
+type EventStore struct {
+	path string
+	file *os.File
+	size int64 // Size of the valid records.
+
+	// Events of all monitors sorted by start time.
+	events  []EventRecord
+	nextID  uint64
+	records int
+	dropped int
+
+	mu sync.Mutex
+}
+
+// ErrEventStoreClosed event store is closed.
+var ErrEventStoreClosed = errors.New("event store is closed")
+
+// OpenEventStore opens or creates the event database. An incomplete
+// trailing record from an unclean shutdown is discarded. Corrupt
+// records are dropped and the log is rewritten without them.
+func OpenEventStore(path string) (*EventStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create event store directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open event store: %w", err)
+	}
+
+	s := &EventStore{
+		path:   path,
+		file:   file,
+		nextID: 1,
+	}
+	validSize, err := s.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("truncate event store: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("seek event store: %w", err)
+	}
+	s.size = validSize
+
+	if s.dropped != 0 || s.shouldCompact() {
+		if err := s.compact(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// replay applies all records and returns the size of the complete
+// records. Corrupt records in the middle of the log are skipped.
+func (s *EventStore) replay(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	var validSize int64
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Incomplete record.
+			return validSize, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read event store: %w", err)
+		}
+
+		var record eventStoreRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				// Partially written record.
+				return validSize, nil
+			}
+			s.dropped++
+			validSize += int64(len(raw))
+			continue
+		}
+		s.apply(record)
+		validSize += int64(len(raw))
+	}
+}
+
+func (s *EventStore) apply(record eventStoreRecord) {
+	s.records++
+	switch record.Op {
+	case eventOpAdd:
+		if record.Event != nil {
+			s.insert(*record.Event)
+		}
+	case eventOpRecording:
+		ids := make(map[uint64]struct{}, len(record.IDs))
+		for _, id := range record.IDs {
+			ids[id] = struct{}{}
+		}
+		for i := range s.events {
+			if _, exist := ids[s.events[i].ID]; exist {
+				s.events[i].RecordingID = record.RecordingID
+			}
+		}
+	case eventOpRemove:
+		kept := s.events[:0]
+		for _, e := range s.events {
+			if e.MonitorID != record.MonitorID || !e.Start.Before(record.Before) {
+				kept = append(kept, e)
+			}
+		}
+		s.events = kept
+	}
+}
+
+func (s *EventStore) insert(e EventRecord) {
+	n := sort.Search(len(s.events), func(i int) bool {
+		return s.events[i].Start.After(e.Start)
+	})
+	s.events = append(s.events, EventRecord{})
+	copy(s.events[n+1:], s.events[n:])
+	s.events[n] = e
+	if e.ID >= s.nextID {
+		s.nextID = e.ID + 1
+	}
+}
+
+// Minimum number of records before the log is compacted.
+const eventStoreCompactMinRecords = 1000
+
+func (s *EventStore) shouldCompact() bool {
+	return s.records > eventStoreCompactMinRecords && s.records > 2*len(s.events)
+}
+
+// compact rewrites the log with only the live events.
+func (s *EventStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create compacted event store: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for i := range s.events {
+		raw, err := marshalEventRecord(eventStoreRecord{Op: eventOpAdd, Event: &s.events[i]})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(raw); err != nil {
+			tmp.Close()
+			return fmt.Errorf("write compacted event store: %w", err)
+		}
+		size += int64(len(raw))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write compacted event store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync compacted event store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close compacted event store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace event store: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("reopen event store: %w", err)
+	}
+	s.file.Close()
+	s.file = file
+	s.size = size
+	s.records = len(s.events)
+	return nil
+}
+
+func marshalEventRecord(record eventStoreRecord) ([]byte, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event record: %w", err)
+	}
+	return append(raw, '\n'), nil
+}
+
+func (s *EventStore) write(record eventStoreRecord) error {
+	if s.file == nil {
+		return ErrEventStoreClosed
+	}
+	raw, err := marshalEventRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(raw); err != nil {
+		// Remove the partial record.
+		if err2 := s.rollback(); err2 != nil {
+			return fmt.Errorf("write event store: %w: %v", err, err2)
+		}
+		return fmt.Errorf("write event store: %w", err)
+	}
+	s.size += int64(len(raw))
+	s.apply(record)
+	return nil
+}
+
+// rollback truncates the log to the last valid record.
+func (s *EventStore) rollback() error {
+	if err := s.file.Truncate(s.size); err != nil {
+		return fmt.Errorf("truncate event store: %w", err)
+	}
+	if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+		return fmt.Errorf("seek event store: %w", err)
+	}
+	return nil
+}
+
+// Dropped returns the number of corrupt records that were dropped on open.
+func (s *EventStore) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Add adds a event of the monitor and returns its ID.
+func (s *EventStore) Add(monitorID string, e Event) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := EventRecord{
+		ID:         s.nextID,
+		MonitorID:  monitorID,
+		Start:      e.Time,
+		End:        e.Time.Add(e.Duration),
+		Detections: e.Detections,
+	}
+	if err := s.write(eventStoreRecord{Op: eventOpAdd, Event: &record}); err != nil {
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+// SetRecording sets the recording of the events of the monitor that
+// start within the recording and don't belong to another recording.
+func (s *EventStore) SetRecording(monitorID string, start time.Time, end time.Time, recordingID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []uint64
+	for _, e := range s.events {
+		if !e.Start.Before(end) {
+			break
+		}
+		if e.MonitorID == monitorID && e.RecordingID == "" && !e.Start.Before(start) {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.write(eventStoreRecord{Op: eventOpRecording, IDs: ids, RecordingID: recordingID})
+}
+
+// RemoveMonitorBefore removes the events of the monitor that start before t.
+func (s *EventStore) RemoveMonitorBefore(monitorID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exist := false
+	for _, e := range s.events {
+		if !e.Start.Before(t) {
+			break
+		}
+		if e.MonitorID == monitorID {
+			exist = true
+			break
+		}
+	}
+	if !exist {
+		return nil
+	}
+	err := s.write(eventStoreRecord{Op: eventOpRemove, MonitorID: monitorID, Before: t})
+	if err != nil {
+		return err
+	}
+	if s.shouldCompact() {
+		return s.compact()
+	}
+	return nil
+}
+
+// EventQuery event store filter. Zero values match all events.
+type EventQuery struct {
+	Monitors []string
+
+	// At least one detection must have one of the labels.
+	Labels []string
+
+	// At least one detection matching the labels must have this score.
+	MinScore float64
+
+	// Events that overlap the time range.
+	Start time.Time
+	End   time.Time
+
+	// Oldest events first instead of newest.
+	Reverse bool
+
+	Limit  int
+	Offset int
+}
+
+func (q EventQuery) match(e EventRecord) bool {
+	if !q.Start.IsZero() && e.End.Before(q.Start) {
+		return false
+	}
+	if len(q.Monitors) != 0 && !containsString(q.Monitors, e.MonitorID) {
+		return false
+	}
+	if len(q.Labels) == 0 && q.MinScore == 0 {
+		return true
+	}
+	for _, d := range e.Detections {
+		if len(q.Labels) != 0 && !containsString(q.Labels, d.Label) {
+			continue
+		}
+		if d.Score >= q.MinScore {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matching returns the events that match the query
+// ignoring the limit and offset, oldest first.
+func (s *EventStore) matching(q EventQuery) []EventRecord {
+	var result []EventRecord
+	for _, e := range s.events {
+		if !q.End.IsZero() && !e.Start.Before(q.End) {
+			break
+		}
+		if q.match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Query returns a page of the matching events and
+// the total number of matches. Newest first by default.
+func (s *EventStore) Query(q EventQuery) ([]EventRecord, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := s.matching(q)
+	total := len(matches)
+	if !q.Reverse {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	if q.Offset >= len(matches) {
+		return []EventRecord{}, total
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, total
+}
+
+// EventCount number of events that started within a interval.
+type EventCount struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+
+	// Number of events with each detected label.
+	Labels map[string]int `json:"labels"`
+}
+
+// Aggregation intervals.
+const (
+	AggregateHour = "hour"
+	AggregateDay  = "day"
+)
+
+// ErrInvalidInterval invalid aggregation interval.
+var ErrInvalidInterval = errors.New("invalid interval")
+
+// Aggregate counts the matching events per hour or day in the location,
+// oldest first. Intervals without events are omitted. The limit and
+// offset of the query are ignored.
+func (s *EventStore) Aggregate(q EventQuery, interval string, loc *time.Location) ([]EventCount, error) {
+	var truncate func(time.Time) time.Time
+	switch interval {
+	case AggregateHour:
+		truncate = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+	case AggregateDay:
+		truncate = func(t time.Time) time.Time {
+			t = t.In(loc)
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidInterval, interval)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := []EventCount{}
+	for _, e := range s.matching(q) {
+		t := truncate(e.Start)
+		if len(counts) == 0 || !counts[len(counts)-1].Time.Equal(t) {
+			counts = append(counts, EventCount{Time: t, Labels: make(map[string]int)})
+		}
+		c := &counts[len(counts)-1]
+		c.Count++
+
+		seen := make(map[string]struct{})
+		for _, d := range e.Detections {
+			if _, exist := seen[d.Label]; exist {
+				continue
+			}
+			seen[d.Label] = struct{}{}
+			c.Labels[d.Label]++
+		}
+	}
+	return counts, nil
+}
+
+// Close closes the database file.
+func (s *EventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,277 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(start int64, label string, score float64) Event {
+	return Event{
+		Time:        time.Unix(start, 0).UTC(),
+		Duration:    time.Second,
+		RecDuration: time.Minute,
+		Detections:  []Detection{{Label: label, Score: score}},
+	}
+}
+
+func newTestEventStore(t *testing.T) *EventStore {
+	t.Helper()
+	store, err := OpenEventStore(filepath.Join(t.TempDir(), "events.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	// Added out of order.
+	for _, e := range []struct {
+		monitorID string
+		event     Event
+	}{
+		{"m1", testEvent(3600, "car", 80)},
+		{"m1", testEvent(0, "person", 60)},
+		{"m2", testEvent(60, "person", 90)},
+		{"m1", testEvent(7200, "person", 40)},
+	} {
+		_, err := store.Add(e.monitorID, e.event)
+		require.NoError(t, err)
+	}
+	return store
+}
+
+func eventIDs(events []EventRecord) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestEventStore(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		store := newTestEventStore(t)
+		cases := map[string]struct {
+			query         EventQuery
+			expectedIDs   []uint64
+			expectedTotal int
+		}{
+			"all":      {EventQuery{}, []uint64{4, 1, 3, 2}, 4},
+			"reverse":  {EventQuery{Reverse: true}, []uint64{2, 3, 1, 4}, 4},
+			"monitor":  {EventQuery{Monitors: []string{"m2"}}, []uint64{3}, 1},
+			"label":    {EventQuery{Labels: []string{"person"}}, []uint64{4, 3, 2}, 3},
+			"minScore": {EventQuery{Labels: []string{"person"}, MinScore: 50}, []uint64{3, 2}, 2},
+			"range": {
+				EventQuery{Start: time.Unix(60, 0), End: time.Unix(7200, 0)},
+				[]uint64{1, 3}, 2,
+			},
+			"page":   {EventQuery{Limit: 2, Offset: 1}, []uint64{1, 3}, 4},
+			"offset": {EventQuery{Offset: 10}, []uint64{}, 4},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				events, total := store.Query(tc.query)
+				require.Equal(t, tc.expectedIDs, eventIDs(events))
+				require.Equal(t, tc.expectedTotal, total)
+			})
+		}
+	})
+	t.Run("aggregate", func(t *testing.T) {
+		store := newTestEventStore(t)
+		counts, err := store.Aggregate(EventQuery{}, AggregateHour, time.UTC)
+		require.NoError(t, err)
+		expected := []EventCount{
+			{Time: time.Unix(0, 0).UTC(), Count: 2, Labels: map[string]int{"person": 2}},
+			{Time: time.Unix(3600, 0).UTC(), Count: 1, Labels: map[string]int{"car": 1}},
+			{Time: time.Unix(7200, 0).UTC(), Count: 1, Labels: map[string]int{"person": 1}},
+		}
+		require.Equal(t, expected, counts)
+
+		counts, err = store.Aggregate(EventQuery{Monitors: []string{"m1"}}, AggregateDay, time.UTC)
+		require.NoError(t, err)
+		require.Equal(t, []EventCount{{
+			Time:   time.Unix(0, 0).UTC(),
+			Count:  3,
+			Labels: map[string]int{"person": 2, "car": 1},
+		}}, counts)
+
+		_, err = store.Aggregate(EventQuery{}, "week", time.UTC)
+		require.ErrorIs(t, err, ErrInvalidInterval)
+	})
+	t.Run("recording", func(t *testing.T) {
+		store := newTestEventStore(t)
+		err := store.SetRecording("m1", time.Unix(0, 0), time.Unix(3601, 0), "rec1")
+		require.NoError(t, err)
+		// Already set events are kept.
+		err = store.SetRecording("m1", time.Unix(3600, 0), time.Unix(9000, 0), "rec2")
+		require.NoError(t, err)
+
+		events, _ := store.Query(EventQuery{Reverse: true})
+		recordings := make(map[uint64]string)
+		for _, e := range events {
+			recordings[e.ID] = e.RecordingID
+		}
+		expected := map[uint64]string{1: "rec1", 2: "rec1", 3: "", 4: "rec2"}
+		require.Equal(t, expected, recordings)
+	})
+	t.Run("reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+
+		_, err = store.Add("m1", testEvent(0, "a", 50))
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(10, "b", 50))
+		require.NoError(t, err)
+		require.NoError(t, store.SetRecording("m1", time.Unix(0, 0), time.Unix(20, 0), "rec"))
+		require.NoError(t, store.RemoveMonitorBefore("m1", time.Unix(5, 0)))
+		require.NoError(t, store.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+
+		events, total := store.Query(EventQuery{})
+		require.Equal(t, 1, total)
+		require.Equal(t, EventRecord{
+			ID:          2,
+			MonitorID:   "m1",
+			Start:       time.Unix(10, 0).UTC(),
+			End:         time.Unix(11, 0).UTC(),
+			Detections:  []Detection{{Label: "b", Score: 50}},
+			RecordingID: "rec",
+		}, events[0])
+
+		// IDs aren't reused.
+		id, err := store.Add("m1", testEvent(20, "c", 50))
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), id)
+	})
+	t.Run("partialRecord", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(0, "a", 50))
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"op":"add","eve`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(10, "b", 50))
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		_, total := store.Query(EventQuery{})
+		require.Equal(t, 2, total)
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		require.NoError(t, os.WriteFile(path, []byte("x\n{}\n"), 0o600))
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		require.Equal(t, 1, store.Dropped())
+	})
+	t.Run("tornWrite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(0, "a", 50))
+		require.NoError(t, err)
+
+		// Simulate a partial write followed by more records.
+		_, err = store.file.WriteString(`{"op":"add","ev`)
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(10, "b", 50))
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(20, "c", 50))
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		require.Equal(t, 1, store.Dropped())
+		require.NoError(t, store.Close())
+
+		// The log was rewritten without the corrupt record.
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		require.Equal(t, 0, store.Dropped())
+		_, total := store.Query(EventQuery{})
+		require.Equal(t, 2, total)
+
+		id, err := store.Add("m1", testEvent(30, "d", 50))
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), id)
+	})
+	t.Run("writeRollback", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+		_, err = store.Add("m1", testEvent(0, "a", 50))
+		require.NoError(t, err)
+
+		_, err = store.file.WriteString(`{"op":"add","ev`)
+		require.NoError(t, err)
+		require.NoError(t, store.rollback())
+		_, err = store.Add("m1", testEvent(10, "b", 50))
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		require.Equal(t, 0, store.Dropped())
+		_, total := store.Query(EventQuery{})
+		require.Equal(t, 2, total)
+	})
+	t.Run("compact", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.db")
+		store, err := OpenEventStore(path)
+		require.NoError(t, err)
+		for i := 0; i < eventStoreCompactMinRecords+10; i++ {
+			_, err := store.Add("m1", testEvent(int64(i), "a", 50))
+			require.NoError(t, err)
+		}
+		require.NoError(t, store.RemoveMonitorBefore("m1", time.Unix(eventStoreCompactMinRecords, 0)))
+		require.Equal(t, 10, store.records)
+		require.NoError(t, store.Close())
+
+		store, err = OpenEventStore(path)
+		require.NoError(t, err)
+		defer store.Close()
+		_, total := store.Query(EventQuery{})
+		require.Equal(t, 10, total)
+	})
+	t.Run("closed", func(t *testing.T) {
+		store := newTestEventStore(t)
+		require.NoError(t, store.Close())
+		_, err := store.Add("m1", testEvent(0, "a", 50))
+		require.ErrorIs(t, err, ErrEventStoreClosed)
+	})
+}
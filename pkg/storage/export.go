@@ -0,0 +1,131 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Export errors.
+var (
+	ErrExportNoSegments    = errors.New("no segments in range")
+	ErrExportStreamChanged = errors.New("stream parameters changed")
+)
+
+// ExportSegments writes the segments between start and end to w as a
+// single fragmented MP4. The export starts at the last keyframe at or
+// before start and ends at the first keyframe at or after end. The
+// decode times are rewritten so that the export starts at zero.
+// The export is stopped if the stream parameters change. The
+// segment tier is the index of its directory in segmentsDirs.
+// Encrypted segments are decrypted with crypt.
+func ExportSegments(
+	w io.Writer,
+	segmentsDirs []string,
+	crypt *Crypt,
+	segments []SegmentInfo,
+	start time.Time,
+	end time.Time,
+) error {
+	return exportSegments(w, segmentsDirs, crypt, segments, start, end, nil)
+}
+
+func exportSegments( //nolint:funlen
+	w io.Writer,
+	segmentsDirs []string,
+	crypt *Crypt,
+	segments []SegmentInfo,
+	start time.Time,
+	end time.Time,
+	prefetcher *Prefetcher,
+) error {
+	var withKeyframes []SegmentInfo
+	for _, seg := range segments {
+		if len(seg.Keyframes) != 0 {
+			withKeyframes = append(withKeyframes, seg)
+		}
+	}
+	if len(withKeyframes) == 0 {
+		return ErrExportNoSegments
+	}
+
+	first := withKeyframes[0]
+	startKeyframe := first.Keyframes[0]
+	for _, kf := range first.Keyframes {
+		if kf.Time.After(start) {
+			break
+		}
+		startKeyframe = kf
+	}
+
+	queue := prefetcher.start(segmentsDirs, crypt, withKeyframes)
+	defer queue.close()
+
+	var firstInit []byte
+	var timescales map[uint32]uint32
+	for i, seg := range withKeyframes {
+		from := seg.Keyframes[0].Offset
+		if i == 0 {
+			from = startKeyframe.Offset
+		}
+		to := seg.Size
+		for _, kf := range seg.Keyframes {
+			if !kf.Time.Before(end) {
+				to = kf.Offset
+				break
+			}
+		}
+		if to <= from {
+			break
+		}
+
+		err := func() error {
+			file, err := queue.open(i)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			init := make([]byte, seg.Keyframes[0].Offset)
+			if _, err := file.ReadAt(init, 0); err != nil {
+				return fmt.Errorf("read init: %w", err)
+			}
+			if i == 0 {
+				firstInit = init
+				timescales, err = trackTimescales(init)
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(init); err != nil {
+					return err
+				}
+			} else if !bytes.Equal(init, firstInit) {
+				return fmt.Errorf("%w: %v", ErrExportStreamChanged, seg.Path)
+			}
+
+			offset := seg.Start.Sub(startKeyframe.Time).Nanoseconds()
+			return copyFragments(w, file, from, to, timescales, offset)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
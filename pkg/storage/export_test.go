@@ -0,0 +1,143 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testExportInfo = hls.StreamInfo{
+	VideoTrackExist: true,
+	VideoSPS:        []byte{0, 0, 0},
+}
+
+// writeTestSegment writes a segment file with one keyframe fragment per second.
+func writeTestSegment(t *testing.T, dir string, name string, start int64, seconds int) SegmentInfo {
+	t.Helper()
+	init, err := hls.GenerateInit(testExportInfo)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(init)
+	base := start * int64(time.Second)
+	var keyframes []Keyframe
+	for i := 0; i < seconds; i++ {
+		dts := base + int64(i)*int64(time.Second)
+		keyframes = append(keyframes, Keyframe{
+			Time:   time.Unix(0, dts),
+			Offset: int64(buf.Len()),
+		})
+		fragment, err := hls.GenerateFragment(base, testExportInfo, []*hls.VideoSample{{
+			PTS:        dts,
+			DTS:        dts,
+			AVCC:       []byte{byte(start), byte(i)},
+			IdrPresent: true,
+			NextDTS:    dts + int64(time.Second),
+		}}, nil)
+		require.NoError(t, err)
+		buf.Write(fragment)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o600))
+
+	return SegmentInfo{
+		MonitorID: "m1",
+		Path:      name,
+		Start:     time.Unix(start, 0),
+		End:       time.Unix(start+int64(seconds), 0),
+		Size:      int64(buf.Len()),
+		Keyframes: keyframes,
+	}
+}
+
+// exportedFragments returns the decode time and mdat payload of each fragment.
+func exportedFragments(t *testing.T, buf []byte) ([]uint64, [][]byte) {
+	t.Helper()
+	var times []uint64
+	var payloads [][]byte
+	err := walkBoxes(buf, func(typ string, body []byte) error {
+		switch typ {
+		case "moof":
+			return walkBoxes(body, func(typ string, traf []byte) error {
+				if typ != "traf" {
+					return nil
+				}
+				return walkBoxes(traf, func(typ string, body []byte) error {
+					if typ == "tfdt" {
+						times = append(times, binary.BigEndian.Uint64(body[4:]))
+					}
+					return nil
+				})
+			})
+		case "mdat":
+			payloads = append(payloads, body)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return times, payloads
+}
+
+func TestExportSegments(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		dir := t.TempDir()
+		segments := []SegmentInfo{
+			writeTestSegment(t, dir, "a.mp4", 100, 3),
+			writeTestSegment(t, dir, "b.mp4", 103, 3),
+		}
+
+		buf := &bytes.Buffer{}
+		err := ExportSegments(buf, []string{dir}, nil, segments, time.Unix(101, 5e8), time.Unix(104, 5e8))
+		require.NoError(t, err)
+
+		times, payloads := exportedFragments(t, buf.Bytes())
+		require.Equal(t, []uint64{0, 90000, 180000, 270000}, times)
+		expected := [][]byte{
+			{100, 1},
+			{100, 2},
+			{103, 0},
+			{103, 1},
+		}
+		require.Equal(t, expected, payloads)
+	})
+	t.Run("streamChanged", func(t *testing.T) {
+		dir := t.TempDir()
+		segments := []SegmentInfo{
+			writeTestSegment(t, dir, "a.mp4", 100, 1),
+			writeTestSegment(t, dir, "b.mp4", 101, 1),
+		}
+		// Modify the init section of the second segment.
+		path := filepath.Join(dir, "b.mp4")
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		raw[20]++
+		require.NoError(t, os.WriteFile(path, raw, 0o600))
+
+		err = ExportSegments(&bytes.Buffer{}, []string{dir}, nil, segments, time.Unix(100, 0), time.Unix(102, 0))
+		require.ErrorIs(t, err, ErrExportStreamChanged)
+	})
+	t.Run("noSegments", func(t *testing.T) {
+		err := ExportSegments(&bytes.Buffer{}, nil, nil, nil, time.Unix(0, 0), time.Unix(1, 0))
+		require.ErrorIs(t, err, ErrExportNoSegments)
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Flagged recordings are exempt from retention and pruning. The
+// flag is an empty file next to the recording with this extension.
+const flagExt = ".flag"
+
+// ErrRecordingFlagged recording is flagged.
+var ErrRecordingFlagged = errors.New("recording is flagged")
+
+// FlagRecording sets or clears the flag of the recording.
+// Returns os.ErrNotExist if the recording doesn't exist.
+func FlagRecording(recordingsDir string, recID string, flagged bool) error {
+	recPath, err := RecordingIDToPath(recID)
+	if err != nil {
+		return fmt.Errorf("recording id to path: %q %w", recID, err)
+	}
+	fullRecPath := filepath.Join(recordingsDir, recPath)
+	if !recordingExist(fullRecPath) {
+		return os.ErrNotExist
+	}
+
+	flagPath := fullRecPath + flagExt
+	if !flagged {
+		if err := os.Remove(flagPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove flag: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(flagPath, nil, 0o600); err != nil {
+		return fmt.Errorf("write flag: %w", err)
+	}
+	return nil
+}
+
+// IsRecordingFlagged returns true if the recording at the path, without
+// extension, is flagged.
+func IsRecordingFlagged(recPath string) bool {
+	_, err := os.Stat(recPath + flagExt)
+	return err == nil
+}
+
+// recordingExist returns true if the recording has a data or video file.
+func recordingExist(recPath string) bool {
+	for _, ext := range []string{".json", ".meta", ".mkv", ".mp4"} {
+		if _, err := os.Stat(recPath + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingIDFromFile returns the recording ID of a recording file name.
+func recordingIDFromFile(name string) string {
+	id, _, _ := strings.Cut(name, ".")
+	return id
+}
+
+// flaggedIDs returns the IDs of the flagged recordings in the monitor directory.
+func flaggedIDs(entries []os.DirEntry) map[string]bool {
+	flagged := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), flagExt) {
+			flagged[strings.TrimSuffix(entry.Name(), flagExt)] = true
+		}
+	}
+	return flagged
+}
+
+// dayFlagged reports if the day directory contains flagged
+// recordings and if it contains anything that isn't flagged.
+func dayFlagged(dayPath string) (hasFlagged bool, hasUnflagged bool, err error) {
+	monitors, err := os.ReadDir(dayPath)
+	if err != nil {
+		return false, false, fmt.Errorf("read directory: %w", err)
+	}
+	for _, monitor := range monitors {
+		if !monitor.IsDir() {
+			hasUnflagged = true
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(dayPath, monitor.Name()))
+		if err != nil {
+			return false, false, fmt.Errorf("read directory: %w", err)
+		}
+		flagged := flaggedIDs(entries)
+		if len(flagged) != 0 {
+			hasFlagged = true
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !flagged[recordingIDFromFile(entry.Name())] {
+				hasUnflagged = true
+				break
+			}
+		}
+	}
+	return hasFlagged, hasUnflagged, nil
+}
+
+// removeDay deletes the recordings in the day directory that
+// aren't flagged and returns the number of bytes that were removed.
+func (s *Manager) removeDay(dayPath string) (int64, error) {
+	hasFlagged, _, err := dayFlagged(dayPath)
+	if err != nil {
+		return 0, err
+	}
+	if !hasFlagged {
+		size := diskUsageBytes(os.DirFS(dayPath))
+		if err := s.removeAll(dayPath); err != nil {
+			return 0, fmt.Errorf("remove directory: %w", err)
+		}
+		return size, nil
+	}
+
+	monitors, err := os.ReadDir(dayPath)
+	if err != nil {
+		return 0, fmt.Errorf("read directory: %w", err)
+	}
+	var removed int64
+	for _, monitor := range monitors {
+		if !monitor.IsDir() {
+			continue
+		}
+		size, err := s.removeMonitorDay(filepath.Join(dayPath, monitor.Name()))
+		if err != nil {
+			return 0, err
+		}
+		removed += size
+	}
+	return removed, nil
+}
+
+// removeMonitorDay deletes the recordings in the monitor directory of a
+// day that aren't flagged and returns the number of bytes that were removed.
+func (s *Manager) removeMonitorDay(path string) (int64, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return 0, fmt.Errorf("read directory: %w", err)
+	}
+	flagged := flaggedIDs(entries)
+	if len(flagged) == 0 {
+		size := diskUsageBytes(os.DirFS(path))
+		if err := s.removeAll(path); err != nil {
+			return 0, fmt.Errorf("remove directory: %w", err)
+		}
+		return size, nil
+	}
+
+	s.logf(log.LevelInfo, "keeping flagged recordings in %q", path)
+	var removed int64
+	for _, entry := range entries {
+		if !entry.IsDir() && flagged[recordingIDFromFile(entry.Name())] {
+			continue
+		}
+		entryPath := filepath.Join(path, entry.Name())
+		var size int64
+		if entry.IsDir() {
+			size = diskUsageBytes(os.DirFS(entryPath))
+		} else if info, err := entry.Info(); err == nil {
+			size = info.Size()
+		}
+		if err := s.removeAll(entryPath); err != nil {
+			return 0, fmt.Errorf("remove recording: %w", err)
+		}
+		removed += size
+	}
+	return removed, nil
+}
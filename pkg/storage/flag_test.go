@@ -0,0 +1,54 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagRecording(t *testing.T) {
+	recordingsDir := t.TempDir()
+	recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+	recID := "2000-01-01_02-02-02_m1"
+	recPath := filepath.Join(recDir, recID)
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	createFiles(t, recDir, []string{recID + ".json"})
+
+	require.False(t, IsRecordingFlagged(recPath))
+	require.NoError(t, FlagRecording(recordingsDir, recID, true))
+	require.True(t, IsRecordingFlagged(recPath))
+
+	// Flagging twice is a no-op.
+	require.NoError(t, FlagRecording(recordingsDir, recID, true))
+	require.True(t, IsRecordingFlagged(recPath))
+
+	require.NoError(t, FlagRecording(recordingsDir, recID, false))
+	require.False(t, IsRecordingFlagged(recPath))
+	require.NoError(t, FlagRecording(recordingsDir, recID, false))
+
+	t.Run("invalidIDErr", func(t *testing.T) {
+		err := FlagRecording(recordingsDir, "invalid", true)
+		require.ErrorIs(t, err, ErrInvalidRecordingID)
+	})
+	t.Run("recNotExistErr", func(t *testing.T) {
+		err := FlagRecording(recordingsDir, "2000-01-01_02-02-02_m2", true)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
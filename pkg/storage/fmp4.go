@@ -0,0 +1,337 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Minimal reader of the fMP4 segment files written by the recorder.
+
+// ErrInvalidBox invalid mp4 box.
+var ErrInvalidBox = errors.New("invalid mp4 box")
+
+// walkBoxes calls fn with the type and body of each box in buf.
+// The body is a sub slice of buf and can be modified in place.
+func walkBoxes(buf []byte, fn func(typ string, body []byte) error) error {
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("%w: truncated header", ErrInvalidBox)
+		}
+		size := int(binary.BigEndian.Uint32(buf))
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("%w: size %v", ErrInvalidBox, size)
+		}
+		if err := fn(string(buf[4:8]), buf[8:size]); err != nil {
+			return err
+		}
+		buf = buf[size:]
+	}
+	return nil
+}
+
+// trackTimescales returns the media timescale of each track in the init section.
+func trackTimescales(init []byte) (map[uint32]uint32, error) {
+	timescales := make(map[uint32]uint32)
+	err := walkBoxes(init, func(typ string, moov []byte) error {
+		if typ != "moov" {
+			return nil
+		}
+		return walkBoxes(moov, func(typ string, trak []byte) error {
+			if typ != "trak" {
+				return nil
+			}
+			var trackID, timescale uint32
+			err := walkBoxes(trak, func(typ string, body []byte) error {
+				switch typ {
+				case "tkhd":
+					// Version 1 has 64 bit creation and modification times.
+					offset := 12
+					if len(body) != 0 && body[0] == 1 {
+						offset = 20
+					}
+					if len(body) < offset+4 {
+						return fmt.Errorf("%w: tkhd", ErrInvalidBox)
+					}
+					trackID = binary.BigEndian.Uint32(body[offset:])
+				case "mdia":
+					return walkBoxes(body, func(typ string, mdhd []byte) error {
+						if typ != "mdhd" {
+							return nil
+						}
+						offset := 12
+						if len(mdhd) != 0 && mdhd[0] == 1 {
+							offset = 20
+						}
+						if len(mdhd) < offset+4 {
+							return fmt.Errorf("%w: mdhd", ErrInvalidBox)
+						}
+						timescale = binary.BigEndian.Uint32(mdhd[offset:])
+						return nil
+					})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			timescales[trackID] = timescale
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return timescales, nil
+}
+
+// shiftMoof adds the offset to the decode time of each track
+// fragment in the moof body. Decode times are clamped at zero.
+func shiftMoof(moof []byte, timescales map[uint32]uint32, offsetNano int64) error {
+	return walkBoxes(moof, func(typ string, traf []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+		var trackID uint32
+		return walkBoxes(traf, func(typ string, body []byte) error {
+			switch typ {
+			case "tfhd":
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfhd", ErrInvalidBox)
+				}
+				trackID = binary.BigEndian.Uint32(body[4:])
+			case "tfdt":
+				timescale, exist := timescales[trackID]
+				if !exist {
+					return fmt.Errorf("%w: unknown track %v", ErrInvalidBox, trackID)
+				}
+				offset := offsetNano * int64(timescale) / 1e9
+				if len(body) >= 12 && body[0] == 1 {
+					t := int64(binary.BigEndian.Uint64(body[4:])) + offset
+					if t < 0 {
+						t = 0
+					}
+					binary.BigEndian.PutUint64(body[4:], uint64(t))
+					return nil
+				}
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfdt", ErrInvalidBox)
+				}
+				t := int64(binary.BigEndian.Uint32(body[4:])) + offset
+				if t < 0 {
+					t = 0
+				}
+				binary.BigEndian.PutUint32(body[4:], uint32(t))
+			}
+			return nil
+		})
+	})
+}
+
+// copyFragments copies the boxes between the offsets from the
+// file to w. The decode time of each moof is shifted by offsetNano.
+func copyFragments(
+	w io.Writer,
+	file io.ReaderAt,
+	from int64,
+	to int64,
+	timescales map[uint32]uint32,
+	offsetNano int64,
+) error {
+	header := make([]byte, 8)
+	for pos := from; pos < to; {
+		if _, err := file.ReadAt(header, pos); err != nil {
+			return fmt.Errorf("read box header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		if size < 8 || pos+size > to {
+			return fmt.Errorf("%w: size %v", ErrInvalidBox, size)
+		}
+
+		if string(header[4:8]) == "moof" {
+			box := make([]byte, size)
+			if _, err := file.ReadAt(box, pos); err != nil {
+				return fmt.Errorf("read moof: %w", err)
+			}
+			if err := shiftMoof(box[8:], timescales, offsetNano); err != nil {
+				return err
+			}
+			if _, err := w.Write(box); err != nil {
+				return err
+			}
+		} else {
+			r := io.NewSectionReader(file, pos, size)
+			if _, err := io.Copy(w, r); err != nil {
+				return err
+			}
+		}
+		pos += size
+	}
+	return nil
+}
+
+// fragmentTimes decode range and first keyframe of a fragment
+// in nanoseconds, relative to the base time of the segment file.
+type fragmentTimes struct {
+	end          int64
+	keyframe     bool
+	keyframeTime int64
+}
+
+// parseMoof returns the times of the fragment. A fragment starts with a
+// keyframe if the first sample of a track with sample flags is a sync
+// sample. Audio tracks have no sample flags, fragments of files with
+// a single audio track always start with a keyframe.
+func parseMoof(moof []byte, timescales map[uint32]uint32) (*fragmentTimes, error) { //nolint:funlen
+	var times fragmentTimes
+	hasFlags := false
+	audioStart := int64(-1)
+	err := walkBoxes(moof, func(typ string, traf []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+		var trackID uint32
+		var baseTime int64
+		return walkBoxes(traf, func(typ string, body []byte) error {
+			switch typ {
+			case "tfhd":
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfhd", ErrInvalidBox)
+				}
+				trackID = binary.BigEndian.Uint32(body[4:])
+			case "tfdt":
+				if len(body) >= 12 && body[0] == 1 {
+					baseTime = int64(binary.BigEndian.Uint64(body[4:]))
+					return nil
+				}
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfdt", ErrInvalidBox)
+				}
+				baseTime = int64(binary.BigEndian.Uint32(body[4:]))
+			case "trun":
+				timescale := int64(timescales[trackID])
+				if timescale == 0 {
+					return fmt.Errorf("%w: unknown track %v", ErrInvalidBox, trackID)
+				}
+				duration, firstFlags, firstOffset, err := parseTrun(body)
+				if err != nil {
+					return err
+				}
+				if end := (baseTime + duration) * 1e9 / timescale; end > times.end {
+					times.end = end
+				}
+				const nonSyncSample = 1 << 16
+				if firstFlags != nil {
+					hasFlags = true
+					if *firstFlags&nonSyncSample == 0 {
+						times.keyframe = true
+						times.keyframeTime = (baseTime + firstOffset) * 1e9 / timescale
+					}
+				} else if start := baseTime * 1e9 / timescale; audioStart == -1 || start < audioStart {
+					audioStart = start
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(timescales) == 1 && !hasFlags && audioStart != -1 {
+		times.keyframe = true
+		times.keyframeTime = audioStart
+	}
+	return &times, nil
+}
+
+// parseTrun returns the total sample duration, the flags of the first sample
+// if present and the composition time offset of the first sample.
+func parseTrun(body []byte) (int64, *uint32, int64, error) {
+	if len(body) < 8 {
+		return 0, nil, 0, fmt.Errorf("%w: trun", ErrInvalidBox)
+	}
+	flags := uint32(body[1])<<16 | uint32(body[2])<<8 | uint32(body[3])
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	pos := 8
+
+	const (
+		dataOffsetPresent       = 0x01
+		firstSampleFlagsPresent = 0x04
+		durationPresent         = 0x100
+		sizePresent             = 0x200
+		flagsPresent            = 0x400
+		compositionPresent      = 0x800
+	)
+	var firstFlags *uint32
+	if flags&dataOffsetPresent != 0 {
+		pos += 4
+	}
+	if flags&firstSampleFlagsPresent != 0 {
+		if len(body) < pos+4 {
+			return 0, nil, 0, fmt.Errorf("%w: trun", ErrInvalidBox)
+		}
+		v := binary.BigEndian.Uint32(body[pos:])
+		firstFlags = &v
+		pos += 4
+	}
+
+	var duration, firstOffset int64
+	for i := 0; i < count; i++ {
+		read := func() (uint32, error) {
+			if len(body) < pos+4 {
+				return 0, fmt.Errorf("%w: trun entry", ErrInvalidBox)
+			}
+			v := binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+			return v, nil
+		}
+		if flags&durationPresent != 0 {
+			v, err := read()
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			duration += int64(v)
+		}
+		if flags&sizePresent != 0 {
+			if _, err := read(); err != nil {
+				return 0, nil, 0, err
+			}
+		}
+		if flags&flagsPresent != 0 {
+			v, err := read()
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			if i == 0 && firstFlags == nil {
+				firstFlags = &v
+			}
+		}
+		if flags&compositionPresent != 0 {
+			v, err := read()
+			if err != nil {
+				return 0, nil, 0, err
+			}
+			if i == 0 {
+				// Signed in version 1.
+				firstOffset = int64(int32(v))
+			}
+		}
+	}
+	return duration, firstFlags, firstOffset, nil
+}
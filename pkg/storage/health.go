@@ -0,0 +1,312 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SMART health status of a disk.
+const (
+	SMARTUnknown = ""
+	SMARTPassed  = "passed"
+	SMARTFailed  = "failed"
+)
+
+// DiskHealth status of the storage disk.
+type DiskHealth struct {
+	Time time.Time `json:"time"`
+
+	// Sizes in bytes, free is the space available to the NVR.
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	UsedPercent float64 `json:"usedPercent"`
+
+	Inodes            uint64  `json:"inodes"`
+	InodesFree        uint64  `json:"inodesFree"`
+	InodesUsedPercent float64 `json:"inodesUsedPercent"`
+
+	// Empty if the SMART status isn't checked.
+	SMART string `json:"smart"`
+
+	// Warning is true above the warning threshold or if the SMART
+	// check failed. Paused is true while continuous recording of
+	// low priority monitors is paused.
+	Warning bool `json:"warning"`
+	Paused  bool `json:"paused"`
+}
+
+// DiskStat file system statistics.
+type DiskStat struct {
+	Total      uint64
+	Free       uint64
+	Inodes     uint64
+	InodesFree uint64
+}
+
+type (
+	statFunc  func(path string) (DiskStat, error)
+	smartFunc func(ctx context.Context, device string) (string, error)
+)
+
+// The thresholds must be crossed by this amount in
+// the other direction before the state is cleared.
+const diskHysteresis = 1
+
+// DiskMonitor periodically checks the health of the storage disk.
+type DiskMonitor struct {
+	dir          string
+	device       string
+	warnPercent  float64
+	pausePercent float64
+
+	stat  statFunc
+	smart smartFunc
+
+	health DiskHealth
+	// If the usage is above the warning threshold.
+	usageWarning bool
+	// Error of the latest check by Run.
+	checkErr error
+	mu       sync.Mutex
+
+	logger log.ILogger
+}
+
+// NewDiskMonitor returns a disk monitor for the storage directory.
+func NewDiskMonitor(env ConfigEnv, logger log.ILogger) *DiskMonitor {
+	return &DiskMonitor{
+		dir:          env.StorageDir,
+		device:       env.SMARTDevice,
+		warnPercent:  float64(env.DiskWarnPercent),
+		pausePercent: float64(env.DiskPausePercent),
+		stat:         statfs,
+		smart:        smartctl,
+		logger:       logger,
+	}
+}
+
+// Health returns the result of the latest check.
+func (d *DiskMonitor) Health() DiskHealth {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.health
+}
+
+// Paused returns true if continuous recording on low
+// priority monitors should be paused. Nil safe.
+func (d *DiskMonitor) Paused() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.health.Paused
+}
+
+// Check updates the disk health and logs threshold crossings.
+func (d *DiskMonitor) Check(ctx context.Context) (DiskHealth, error) {
+	stat, err := d.stat(d.dir)
+	if err != nil {
+		return DiskHealth{}, fmt.Errorf("stat file system: %w", err)
+	}
+
+	smart := SMARTUnknown
+	if d.device != "" {
+		smart, err = d.smart(ctx, d.device)
+		if err != nil {
+			d.logf(log.LevelError, "could not check SMART status of %v: %v", d.device, err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev := d.health
+
+	health := DiskHealth{
+		Time:              time.Now(),
+		Total:             stat.Total,
+		Free:              stat.Free,
+		UsedPercent:       usedPercent(stat.Total, stat.Free),
+		Inodes:            stat.Inodes,
+		InodesFree:        stat.InodesFree,
+		InodesUsedPercent: usedPercent(stat.Inodes, stat.InodesFree),
+		SMART:             smart,
+	}
+	usage := math.Max(health.UsedPercent, health.InodesUsedPercent)
+
+	prevWarning := d.usageWarning
+	warning := exceeded(prevWarning, usage, d.warnPercent)
+	health.Warning = warning || smart == SMARTFailed
+	health.Paused = exceeded(prev.Paused, usage, d.pausePercent)
+	d.health = health
+	d.usageWarning = warning
+
+	switch {
+	case warning && !prevWarning:
+		d.logf(log.LevelWarning,
+			"storage disk is almost full: %.1f%% of space and %.1f%% of inodes used",
+			health.UsedPercent, health.InodesUsedPercent)
+	case !warning && prevWarning:
+		d.logf(log.LevelInfo, "storage disk usage is below %v%%", d.warnPercent)
+	}
+	if smart == SMARTFailed && prev.SMART != SMARTFailed {
+		d.logf(log.LevelError, "SMART health check of %v failed, replace the disk", d.device)
+	}
+	switch {
+	case health.Paused && !prev.Paused:
+		d.logf(log.LevelWarning,
+			"pausing continuous recording of low priority monitors: %.1f%% of disk used", usage)
+	case !health.Paused && prev.Paused:
+		d.logf(log.LevelInfo, "resuming continuous recording of low priority monitors")
+	}
+	return health, nil
+}
+
+// exceeded returns true if the value is above the threshold, a state
+// that is already active isn't cleared until it's below the hysteresis.
+func exceeded(active bool, value float64, threshold float64) bool {
+	if active {
+		return value >= threshold-diskHysteresis
+	}
+	return value >= threshold
+}
+
+func usedPercent(total uint64, free uint64) float64 {
+	if total == 0 || free > total {
+		return 0
+	}
+	return float64(total-free) / float64(total) * 100
+}
+
+// Run checks the disk health on an interval until context is canceled.
+func (d *DiskMonitor) Run(ctx context.Context, interval time.Duration) {
+	for {
+		_, err := d.Check(ctx)
+		if err != nil {
+			d.logf(log.LevelError, "could not check disk health: %v", err)
+		}
+		d.mu.Lock()
+		d.checkErr = err
+		d.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// HealthCheck returns the health of the storage disk, "storage".
+func (d *DiskMonitor) HealthCheck() map[string]health.Component {
+	d.mu.Lock()
+	h, checkErr := d.health, d.checkErr
+	d.mu.Unlock()
+
+	c := health.Component{Status: health.StatusOK, Critical: true}
+	usage := math.Max(h.UsedPercent, h.InodesUsedPercent)
+	switch {
+	case checkErr != nil:
+		c.Status = health.StatusFailed
+		c.Reason = checkErr.Error()
+	case h.SMART == SMARTFailed:
+		c.Status = health.StatusFailed
+		c.Reason = "SMART health check failed"
+	case h.Time.IsZero():
+		c.Status = health.StatusDegraded
+		c.Reason = "not checked yet"
+	case h.Paused:
+		c.Status = health.StatusDegraded
+		c.Reason = fmt.Sprintf(
+			"continuous recording of low priority monitors is paused: %.1f%% used", usage)
+	case h.Warning:
+		c.Status = health.StatusDegraded
+		c.Reason = fmt.Sprintf("disk is almost full: %.1f%% used", usage)
+	}
+	return map[string]health.Component{"storage": c}
+}
+
+func (d *DiskMonitor) logf(level log.Level, format string, a ...interface{}) {
+	d.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf(format, a...),
+	})
+}
+
+func statfs(path string) (DiskStat, error) {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(path, &s); err != nil {
+		return DiskStat{}, err
+	}
+	bsize := uint64(s.Bsize)
+	return DiskStat{
+		Total:      s.Blocks * bsize,
+		Free:       s.Bavail * bsize,
+		Inodes:     s.Files,
+		InodesFree: s.Ffree,
+	}, nil
+}
+
+// ErrSMARTUnknown unknown SMART status.
+var ErrSMARTUnknown = errors.New("unknown SMART status")
+
+// smartctl runs "smartctl -H" on the device. The exit status is a
+// bitmask that is non-zero for failing disks, the output is parsed instead.
+func smartctl(ctx context.Context, device string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "smartctl", "-H", device).Output()
+	status := parseSMART(out)
+	if status == SMARTUnknown {
+		if err != nil {
+			return SMARTUnknown, fmt.Errorf("smartctl: %w", err)
+		}
+		return SMARTUnknown, ErrSMARTUnknown
+	}
+	return status, nil
+}
+
+// parseSMART parses the output of "smartctl -H". ATA disks report
+// "PASSED" or "FAILED!" and SCSI disks "OK" or another status.
+func parseSMART(out []byte) string {
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		_, result, found := bytes.Cut(line, []byte("self-assessment test result:"))
+		if !found {
+			_, result, found = bytes.Cut(line, []byte("SMART Health Status:"))
+		}
+		if !found {
+			continue
+		}
+		result = bytes.TrimSpace(result)
+		if bytes.Equal(result, []byte("PASSED")) || bytes.Equal(result, []byte("OK")) {
+			return SMARTPassed
+		}
+		return SMARTFailed
+	}
+	return SMARTUnknown
+}
@@ -0,0 +1,207 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+type logRecorder []log.Entry
+
+func (l *logRecorder) Log(e log.Entry) {
+	*l = append(*l, e)
+}
+
+func (l *logRecorder) levels() []log.Level {
+	var levels []log.Level
+	for _, e := range *l {
+		levels = append(levels, e.Level)
+	}
+	*l = nil
+	return levels
+}
+
+func TestDiskMonitor(t *testing.T) {
+	newTestMonitor := func(stat *DiskStat, smart *string) (*DiskMonitor, *logRecorder) {
+		logs := &logRecorder{}
+		return &DiskMonitor{
+			device:       "/dev/sda",
+			warnPercent:  90,
+			pausePercent: 95,
+			stat: func(string) (DiskStat, error) {
+				return *stat, nil
+			},
+			smart: func(context.Context, string) (string, error) {
+				return *smart, nil
+			},
+			logger: logs,
+		}, logs
+	}
+
+	t.Run("thresholds", func(t *testing.T) {
+		stat := DiskStat{Total: 1000, Free: 500, Inodes: 1000, InodesFree: 1000}
+		smart := SMARTPassed
+		d, logs := newTestMonitor(&stat, &smart)
+
+		check := func() DiskHealth {
+			health, err := d.Check(context.Background())
+			require.NoError(t, err)
+			return health
+		}
+
+		health := check()
+		require.Equal(t, float64(50), health.UsedPercent)
+		require.Equal(t, float64(0), health.InodesUsedPercent)
+		require.Equal(t, SMARTPassed, health.SMART)
+		require.False(t, health.Warning)
+		require.False(t, health.Paused)
+		require.Empty(t, logs.levels())
+
+		// Inodes count towards the thresholds.
+		stat.InodesFree = 90
+		health = check()
+		require.True(t, health.Warning)
+		require.False(t, health.Paused)
+		require.Equal(t, []log.Level{log.LevelWarning}, logs.levels())
+
+		stat.Free = 40
+		health = check()
+		require.True(t, health.Paused)
+		require.True(t, d.Paused())
+		require.Equal(t, []log.Level{log.LevelWarning}, logs.levels())
+
+		// Within the hysteresis.
+		stat.Free, stat.InodesFree = 55, 105
+		health = check()
+		require.True(t, health.Warning)
+		require.True(t, health.Paused)
+		require.Empty(t, logs.levels())
+
+		stat.Free, stat.InodesFree = 500, 1000
+		health = check()
+		require.False(t, health.Warning)
+		require.False(t, health.Paused)
+		require.Equal(t, []log.Level{log.LevelInfo, log.LevelInfo}, logs.levels())
+	})
+	t.Run("smartFailed", func(t *testing.T) {
+		stat := DiskStat{Total: 100, Free: 50}
+		smart := SMARTFailed
+		d, logs := newTestMonitor(&stat, &smart)
+
+		health, err := d.Check(context.Background())
+		require.NoError(t, err)
+		require.True(t, health.Warning)
+		require.False(t, health.Paused)
+		require.Equal(t, []log.Level{log.LevelError}, logs.levels())
+
+		// Only logged once.
+		_, err = d.Check(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, logs.levels())
+	})
+	t.Run("statErr", func(t *testing.T) {
+		errMock := errors.New("mock")
+		d := &DiskMonitor{
+			stat: func(string) (DiskStat, error) {
+				return DiskStat{}, errMock
+			},
+		}
+		_, err := d.Check(context.Background())
+		require.ErrorIs(t, err, errMock)
+	})
+	t.Run("nilPaused", func(t *testing.T) {
+		var d *DiskMonitor
+		require.False(t, d.Paused())
+	})
+}
+
+func TestDiskMonitorHealthCheck(t *testing.T) {
+	cases := map[string]struct {
+		health   DiskHealth
+		checkErr error
+		status   health.Status
+		reason   string
+	}{
+		"ok":         {DiskHealth{Time: time.Now()}, nil, health.StatusOK, ""},
+		"notChecked": {DiskHealth{}, nil, health.StatusDegraded, "not checked yet"},
+		"warning": {
+			DiskHealth{Time: time.Now(), UsedPercent: 91, Warning: true},
+			nil, health.StatusDegraded, "disk is almost full: 91.0% used",
+		},
+		"paused": {
+			DiskHealth{Time: time.Now(), InodesUsedPercent: 96, Warning: true, Paused: true},
+			nil, health.StatusDegraded,
+			"continuous recording of low priority monitors is paused: 96.0% used",
+		},
+		"smartFailed": {
+			DiskHealth{Time: time.Now(), SMART: SMARTFailed, Warning: true},
+			nil, health.StatusFailed, "SMART health check failed",
+		},
+		"checkErr": {
+			DiskHealth{Time: time.Now()},
+			errors.New("mock"), health.StatusFailed, "mock",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			d := &DiskMonitor{health: tc.health, checkErr: tc.checkErr}
+			expected := map[string]health.Component{
+				"storage": {Status: tc.status, Reason: tc.reason, Critical: true},
+			}
+			require.Equal(t, expected, d.HealthCheck())
+		})
+	}
+}
+
+func TestStatfs(t *testing.T) {
+	stat, err := statfs(t.TempDir())
+	require.NoError(t, err)
+	require.NotZero(t, stat.Total)
+	require.LessOrEqual(t, stat.Free, stat.Total)
+}
+
+func TestParseSMART(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"ataPassed": {
+			"=== START OF READ SMART DATA SECTION ===\n" +
+				"SMART overall-health self-assessment test result: PASSED\n",
+			SMARTPassed,
+		},
+		"ataFailed": {
+			"SMART overall-health self-assessment test result: FAILED!\n",
+			SMARTFailed,
+		},
+		"scsiOK":      {"SMART Health Status: OK\n", SMARTPassed},
+		"scsiFailure": {"SMART Health Status: FAILURE PREDICTION THRESHOLD EXCEEDED\n", SMARTFailed},
+		"unknown":     {"smartctl: device not found\n", SMARTUnknown},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, parseSMART([]byte(tc.input)))
+		})
+	}
+}
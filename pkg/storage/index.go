@@ -0,0 +1,518 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keyframe byte offset of a fragment that starts with a keyframe.
+type Keyframe struct {
+	Time   time.Time `json:"time"`
+	Offset int64     `json:"offset"`
+}
+
+// SegmentInfo index entry of a fMP4 segment file.
+type SegmentInfo struct {
+	MonitorID string     `json:"monitorId"`
+	Path      string     `json:"path"` // Relative to the segments directory.
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Size      int64      `json:"size"`
+	Keyframes []Keyframe `json:"keyframes"`
+
+	// Storage tier of the file, 0 is the storage directory.
+	Tier int `json:"tier,omitempty"`
+
+	// Static markers are stored instead of segments without
+	// motion. They don't have a file and only mark the gap.
+	Static bool `json:"static,omitempty"`
+}
+
+// ErrIndexClosed index is closed.
+var ErrIndexClosed = errors.New("index is closed")
+
+const (
+	indexOpAdd    = "add"
+	indexOpRemove = "remove"
+	indexOpTier   = "tier"
+)
+
+type indexRecord struct {
+	Op      string       `json:"op"`
+	Segment *SegmentInfo `json:"segment,omitempty"`
+
+	// Set if the operation is remove or tier.
+	MonitorID string `json:"monitorId,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Tier      int    `json:"tier,omitempty"`
+}
+
+// Index is the embedded segment index database. The database is an
+// append-only log of JSON records that is replayed into memory on
+// open, the log is compacted when most of the records are obsolete.
+// Compaction keeps the log below twice the live records, so the
+// replay cost is bounded by the number of segments on disk.
+type Index struct {
+	path string
+	file *os.File
+	size int64 // Size of the valid records.
+
+	// Segments by monitor ID sorted by start time.
+	segments map[string][]SegmentInfo
+	records  int
+	dropped  int
+
+	mu sync.Mutex
+}
+
+// OpenIndex opens or creates the index database. An incomplete
+// trailing record from an unclean shutdown is discarded. Corrupt
+// records are dropped and the log is rewritten without them.
+func OpenIndex(path string) (*Index, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create index directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+
+	i := &Index{
+		path:     path,
+		file:     file,
+		segments: make(map[string][]SegmentInfo),
+	}
+	validSize, err := i.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("truncate index: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("seek index: %w", err)
+	}
+	i.size = validSize
+
+	if i.dropped != 0 || i.shouldCompact() {
+		if err := i.compact(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// replay applies all records and returns the size of the complete
+// records. Corrupt records in the middle of the log are counted and
+// skipped, they're left by a failed write that was followed by more.
+func (i *Index) replay(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	var validSize int64
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Incomplete record.
+			return validSize, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("read index: %w", err)
+		}
+
+		var record indexRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				// Partially written record.
+				return validSize, nil
+			}
+			i.dropped++
+			validSize += int64(len(raw))
+			continue
+		}
+		i.apply(record)
+		validSize += int64(len(raw))
+	}
+}
+
+func (i *Index) apply(record indexRecord) {
+	i.records++
+	switch record.Op {
+	case indexOpAdd:
+		if record.Segment != nil {
+			i.insert(*record.Segment)
+		}
+	case indexOpRemove:
+		i.remove(record.MonitorID, record.Path)
+	case indexOpTier:
+		segments := i.segments[record.MonitorID]
+		for j := range segments {
+			if segments[j].Path == record.Path {
+				segments[j].Tier = record.Tier
+			}
+		}
+	}
+}
+
+func (i *Index) insert(seg SegmentInfo) {
+	segments := i.segments[seg.MonitorID]
+	n := sort.Search(len(segments), func(j int) bool {
+		return segments[j].Start.After(seg.Start)
+	})
+	segments = append(segments, SegmentInfo{})
+	copy(segments[n+1:], segments[n:])
+	segments[n] = seg
+	i.segments[seg.MonitorID] = segments
+}
+
+func (i *Index) remove(monitorID string, path string) bool {
+	segments := i.segments[monitorID]
+	for j, seg := range segments {
+		if seg.Path == path {
+			i.segments[monitorID] = append(segments[:j], segments[j+1:]...)
+			if len(i.segments[monitorID]) == 0 {
+				delete(i.segments, monitorID)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Index) liveRecords() int {
+	n := 0
+	for _, segments := range i.segments {
+		n += len(segments)
+	}
+	return n
+}
+
+// Minimum number of records before the log is compacted.
+const indexCompactMinRecords = 1000
+
+func (i *Index) shouldCompact() bool {
+	return i.records > indexCompactMinRecords && i.records > 2*i.liveRecords()
+}
+
+// compact rewrites the log with only the live segments.
+func (i *Index) compact() error {
+	tmpPath := i.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create compacted index: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	records := 0
+	var size int64
+	for _, segments := range i.segments {
+		for j := range segments {
+			raw, err := marshalRecord(indexRecord{Op: indexOpAdd, Segment: &segments[j]})
+			if err != nil {
+				tmp.Close()
+				return err
+			}
+			if _, err := w.Write(raw); err != nil {
+				tmp.Close()
+				return fmt.Errorf("write compacted index: %w", err)
+			}
+			records++
+			size += int64(len(raw))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write compacted index: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync compacted index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close compacted index: %w", err)
+	}
+	if err := os.Rename(tmpPath, i.path); err != nil {
+		return fmt.Errorf("replace index: %w", err)
+	}
+
+	file, err := os.OpenFile(i.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("reopen index: %w", err)
+	}
+	i.file.Close()
+	i.file = file
+	i.size = size
+	i.records = records
+	return nil
+}
+
+func marshalRecord(record indexRecord) ([]byte, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("marshal index record: %w", err)
+	}
+	return append(raw, '\n'), nil
+}
+
+func (i *Index) write(record indexRecord) error {
+	if i.file == nil {
+		return ErrIndexClosed
+	}
+	raw, err := marshalRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := i.file.Write(raw); err != nil {
+		// Remove the partial record, otherwise the next
+		// record would be appended to it and both are lost.
+		if err2 := i.rollback(); err2 != nil {
+			return fmt.Errorf("write index: %w: %v", err, err2)
+		}
+		return fmt.Errorf("write index: %w", err)
+	}
+	i.size += int64(len(raw))
+	i.apply(record)
+	return nil
+}
+
+// rollback truncates the log to the last valid record.
+func (i *Index) rollback() error {
+	if err := i.file.Truncate(i.size); err != nil {
+		return fmt.Errorf("truncate index: %w", err)
+	}
+	if _, err := i.file.Seek(i.size, io.SeekStart); err != nil {
+		return fmt.Errorf("seek index: %w", err)
+	}
+	return nil
+}
+
+// Dropped returns the number of corrupt records that were dropped on open.
+func (i *Index) Dropped() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.dropped
+}
+
+// Add adds a segment to the index.
+func (i *Index) Add(seg SegmentInfo) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.write(indexRecord{Op: indexOpAdd, Segment: &seg})
+}
+
+// Remove removes a segment from the index. Removing
+// a segment that doesn't exist isn't an error.
+func (i *Index) Remove(monitorID string, path string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.removeLocked(monitorID, path)
+}
+
+func (i *Index) removeLocked(monitorID string, path string) error {
+	if !i.exist(monitorID, path) {
+		return nil
+	}
+	err := i.write(indexRecord{Op: indexOpRemove, MonitorID: monitorID, Path: path})
+	if err != nil {
+		return err
+	}
+	if i.shouldCompact() {
+		return i.compact()
+	}
+	return nil
+}
+
+func (i *Index) exist(monitorID string, path string) bool {
+	for _, seg := range i.segments[monitorID] {
+		if seg.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Exist returns true if the segment is in the index.
+func (i *Index) Exist(monitorID string, path string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.exist(monitorID, path)
+}
+
+// Get returns the segment with the path.
+func (i *Index) Get(monitorID string, path string) (SegmentInfo, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, seg := range i.segments[monitorID] {
+		if seg.Path == path {
+			return seg, true
+		}
+	}
+	return SegmentInfo{}, false
+}
+
+// Query returns the segments of the monitor that overlap
+// the time range from start to end, sorted by start time.
+// Static markers are excluded.
+func (i *Index) Query(monitorID string, start time.Time, end time.Time) []SegmentInfo {
+	return i.query(monitorID, start, end, false)
+}
+
+// QueryStatic returns the static markers of the monitor that
+// overlap the time range from start to end, sorted by start time.
+func (i *Index) QueryStatic(monitorID string, start time.Time, end time.Time) []SegmentInfo {
+	return i.query(monitorID, start, end, true)
+}
+
+func (i *Index) query(monitorID string, start time.Time, end time.Time, static bool) []SegmentInfo {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	segments := i.segments[monitorID]
+	var result []SegmentInfo
+	for _, seg := range segments {
+		if !seg.Start.Before(end) {
+			break
+		}
+		if seg.End.After(start) && seg.Static == static {
+			result = append(result, seg)
+		}
+	}
+	return result
+}
+
+// Oldest returns the segment in the storage tier with the earliest start time.
+func (i *Index) Oldest(tier int) (SegmentInfo, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var oldest SegmentInfo
+	found := false
+	for _, segments := range i.segments {
+		for _, seg := range segments {
+			if seg.Tier != tier || seg.Static {
+				continue
+			}
+			if !found || seg.Start.Before(oldest.Start) {
+				oldest = seg
+				found = true
+			}
+			break
+		}
+	}
+	return oldest, found
+}
+
+// Before returns the segments of all monitors that
+// start before t. Static markers are excluded.
+func (i *Index) Before(t time.Time) []SegmentInfo {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var result []SegmentInfo
+	for _, segments := range i.segments {
+		for _, seg := range segments {
+			if !seg.Start.Before(t) {
+				break
+			}
+			if seg.Static {
+				continue
+			}
+			result = append(result, seg)
+		}
+	}
+	return result
+}
+
+// SetTier sets the storage tier of a segment after its file has been moved.
+func (i *Index) SetTier(monitorID string, path string, tier int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.exist(monitorID, path) {
+		return nil
+	}
+	return i.write(indexRecord{Op: indexOpTier, MonitorID: monitorID, Path: path, Tier: tier})
+}
+
+// RemoveBefore removes all segments in the storage tier that start
+// before t and returns them so that the files can be deleted.
+func (i *Index) RemoveBefore(t time.Time, tier int) ([]SegmentInfo, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var removed []SegmentInfo
+	for _, segments := range i.segments {
+		for _, seg := range segments {
+			if !seg.Start.Before(t) {
+				break
+			}
+			if seg.Tier == tier {
+				removed = append(removed, seg)
+			}
+		}
+	}
+	for _, seg := range removed {
+		if err := i.removeLocked(seg.MonitorID, seg.Path); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// RemoveMonitorBefore removes the segments of the monitor
+// that start before t and returns them.
+func (i *Index) RemoveMonitorBefore(monitorID string, t time.Time) ([]SegmentInfo, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var removed []SegmentInfo
+	for _, seg := range i.segments[monitorID] {
+		if !seg.Start.Before(t) {
+			break
+		}
+		removed = append(removed, seg)
+	}
+	for _, seg := range removed {
+		if err := i.removeLocked(seg.MonitorID, seg.Path); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+// Close closes the database file.
+func (i *Index) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.file == nil {
+		return nil
+	}
+	err := i.file.Close()
+	i.file = nil
+	return err
+}
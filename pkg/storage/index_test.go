@@ -0,0 +1,290 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSegment(monitorID string, start int64, end int64) SegmentInfo {
+	return SegmentInfo{
+		MonitorID: monitorID,
+		Path:      monitorID + "/" + strconv.FormatInt(start, 10) + ".mp4",
+		Start:     time.Unix(start, 0).UTC(),
+		End:       time.Unix(end, 0).UTC(),
+		Size:      100,
+		Keyframes: []Keyframe{{Time: time.Unix(start, 0).UTC(), Offset: 10}},
+	}
+}
+
+func TestIndex(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		index, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+		require.NoError(t, err)
+		defer index.Close()
+
+		// Added out of order.
+		require.NoError(t, index.Add(testSegment("m1", 20, 30)))
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.Add(testSegment("m2", 5, 15)))
+
+		actual := index.Query("m1", time.Unix(5, 0), time.Unix(20, 0))
+		expected := []SegmentInfo{
+			testSegment("m1", 0, 10),
+			testSegment("m1", 10, 20),
+		}
+		require.Equal(t, expected, actual)
+
+		require.Empty(t, index.Query("m1", time.Unix(30, 0), time.Unix(40, 0)))
+		require.Empty(t, index.Query("m3", time.Unix(0, 0), time.Unix(40, 0)))
+	})
+	t.Run("static", func(t *testing.T) {
+		index, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+		require.NoError(t, err)
+		defer index.Close()
+
+		static := testSegment("m1", 0, 10)
+		static.Static = true
+		require.NoError(t, index.Add(static))
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+
+		start, end := time.Unix(0, 0), time.Unix(20, 0)
+		require.Equal(t, []SegmentInfo{testSegment("m1", 10, 20)}, index.Query("m1", start, end))
+		require.Equal(t, []SegmentInfo{static}, index.QueryStatic("m1", start, end))
+		require.Equal(t, []SegmentInfo{testSegment("m1", 10, 20)}, index.Before(end))
+
+		oldest, found := index.Oldest(0)
+		require.True(t, found)
+		require.Equal(t, testSegment("m1", 10, 20), oldest)
+
+		// Static markers are removed with the segments.
+		removed, err := index.RemoveBefore(end, 0)
+		require.NoError(t, err)
+		require.Len(t, removed, 2)
+		require.Empty(t, index.QueryStatic("m1", start, end))
+	})
+	t.Run("reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.Remove("m1", testSegment("m1", 0, 10).Path))
+		require.NoError(t, index.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+
+		actual := index.Query("m1", time.Unix(0, 0), time.Unix(20, 0))
+		require.Equal(t, []SegmentInfo{testSegment("m1", 10, 20)}, actual)
+	})
+	t.Run("partialRecord", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Close())
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"op":"add","segm`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+
+		actual := index.Query("m1", time.Unix(0, 0), time.Unix(20, 0))
+		expected := []SegmentInfo{
+			testSegment("m1", 0, 10),
+			testSegment("m1", 10, 20),
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		err := os.WriteFile(path, []byte("nil\n{}\n"), 0o600)
+		require.NoError(t, err)
+
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+		require.Equal(t, 1, index.Dropped())
+	})
+	t.Run("tornWrite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Close())
+
+		// A partial record followed by more records.
+		raw, err := marshalRecord(indexRecord{
+			Op:      indexOpAdd,
+			Segment: &SegmentInfo{MonitorID: "m1", Path: "x"},
+		})
+		require.NoError(t, err)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = file.Write(raw[:10])
+		require.NoError(t, err)
+		seg := testSegment("m1", 10, 20)
+		raw, err = marshalRecord(indexRecord{Op: indexOpAdd, Segment: &seg})
+		require.NoError(t, err)
+		_, err = file.Write(raw)
+		require.NoError(t, err)
+		seg = testSegment("m1", 20, 30)
+		raw, err = marshalRecord(indexRecord{Op: indexOpAdd, Segment: &seg})
+		require.NoError(t, err)
+		_, err = file.Write(raw)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		require.Equal(t, 1, index.Dropped())
+		require.NoError(t, index.Add(testSegment("m1", 30, 40)))
+		require.NoError(t, index.Close())
+
+		// The log was rewritten without the corrupt record.
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+		require.Equal(t, 0, index.Dropped())
+
+		actual := index.Query("m1", time.Unix(0, 0), time.Unix(40, 0))
+		expected := []SegmentInfo{
+			testSegment("m1", 0, 10),
+			testSegment("m1", 20, 30),
+			testSegment("m1", 30, 40),
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("writeRollback", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+
+		// Simulate a partial write.
+		_, err = index.file.WriteString(`{"op":"add","segm`)
+		require.NoError(t, err)
+		require.NoError(t, index.rollback())
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+		require.Equal(t, 0, index.Dropped())
+
+		actual := index.Query("m1", time.Unix(0, 0), time.Unix(20, 0))
+		expected := []SegmentInfo{
+			testSegment("m1", 0, 10),
+			testSegment("m1", 10, 20),
+		}
+		require.Equal(t, expected, actual)
+	})
+	t.Run("removeBefore", func(t *testing.T) {
+		index, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+		require.NoError(t, err)
+		defer index.Close()
+
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.Add(testSegment("m2", 5, 15)))
+
+		oldest, found := index.Oldest(0)
+		require.True(t, found)
+		require.Equal(t, testSegment("m1", 0, 10), oldest)
+
+		removed, err := index.RemoveBefore(time.Unix(10, 0), 0)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []SegmentInfo{
+			testSegment("m1", 0, 10),
+			testSegment("m2", 5, 15),
+		}, removed)
+
+		oldest, found = index.Oldest(0)
+		require.True(t, found)
+		require.Equal(t, testSegment("m1", 10, 20), oldest)
+	})
+	t.Run("tier", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+
+		require.NoError(t, index.Add(testSegment("m1", 0, 10)))
+		require.NoError(t, index.Add(testSegment("m1", 10, 20)))
+		require.NoError(t, index.SetTier("m1", testSegment("m1", 0, 10).Path, 1))
+		require.NoError(t, index.Close())
+
+		index, err = OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+
+		archived := testSegment("m1", 0, 10)
+		archived.Tier = 1
+		require.Equal(t, []SegmentInfo{archived}, index.Before(time.Unix(10, 0)))
+
+		oldest, found := index.Oldest(0)
+		require.True(t, found)
+		require.Equal(t, testSegment("m1", 10, 20), oldest)
+
+		removed, err := index.RemoveBefore(time.Unix(20, 0), 1)
+		require.NoError(t, err)
+		require.Equal(t, []SegmentInfo{archived}, removed)
+		require.Len(t, index.Query("m1", time.Unix(0, 0), time.Unix(20, 0)), 1)
+	})
+	t.Run("compact", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "index.db")
+		index, err := OpenIndex(path)
+		require.NoError(t, err)
+		defer index.Close()
+
+		for i := int64(0); i < indexCompactMinRecords; i++ {
+			seg := testSegment("m1", i, i+1)
+			require.NoError(t, index.Add(seg))
+			require.NoError(t, index.Remove("m1", seg.Path))
+		}
+		require.NoError(t, index.Add(testSegment("m1", 5000, 5001)))
+		require.Less(t, index.records, indexCompactMinRecords)
+
+		actual := index.Query("m1", time.Unix(0, 0), time.Unix(6000, 0))
+		require.Equal(t, []SegmentInfo{testSegment("m1", 5000, 5001)}, actual)
+	})
+	t.Run("closed", func(t *testing.T) {
+		index, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+		require.NoError(t, err)
+		require.NoError(t, index.Close())
+		require.ErrorIs(t, index.Add(testSegment("m1", 0, 10)), ErrIndexClosed)
+	})
+}
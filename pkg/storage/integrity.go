@@ -0,0 +1,396 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ledger entry types.
+const (
+	LedgerRecording = "recording"
+	LedgerSegment   = "segment"
+)
+
+// Ledger errors.
+var (
+	ErrNotInLedger   = errors.New("file is not in the integrity ledger")
+	ErrLedgerCorrupt = errors.New("integrity ledger is corrupt")
+)
+
+// LedgerEntry hash of a recording or segment file. Each entry includes
+// the chain hash of the previous entry of the monitor, removing or
+// modifying a entry breaks the chain of all the following entries.
+type LedgerEntry struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	// Recording ID or segment path relative to the segments directory.
+	Path string `json:"path"`
+
+	// Hex encoded SHA-256 of the file, see HashRecording and HashSegment.
+	SHA256 string `json:"sha256"`
+
+	// Chain is the hex encoded SHA-256 of
+	// "<prev>\n<type>\n<path>\n<sha256>\n".
+	Prev  string `json:"prev"`
+	Chain string `json:"chain"`
+
+	// Base64 encoded Ed25519 signature of the chain hex string.
+	// Empty if signing is disabled.
+	Signature string `json:"signature,omitempty"`
+}
+
+func (e LedgerEntry) chain() string {
+	hash := sha256.Sum256([]byte(e.Prev + "\n" + e.Type + "\n" + e.Path + "\n" + e.SHA256 + "\n"))
+	return hex.EncodeToString(hash[:])
+}
+
+// Ledger is the append-only integrity ledger. There is one JSON lines
+// file per monitor and month, the chain continues across the files.
+//
+//	integrity
+//	└── monitorID
+//	    ├── 2022-01.jsonl
+//	    └── 2022-02.jsonl
+type Ledger struct {
+	dir string
+	key ed25519.PrivateKey
+
+	// Chain of the last entry by monitor ID.
+	last map[string]string
+	mu   sync.Mutex
+}
+
+// NewLedger creates a ledger, entries are signed if key isn't nil.
+func NewLedger(dir string, key ed25519.PrivateKey) *Ledger {
+	return &Ledger{
+		dir:  dir,
+		key:  key,
+		last: make(map[string]string),
+	}
+}
+
+// LoadSigningKey reads the hex encoded Ed25519 seed,
+// a new key is generated if the file doesn't exist.
+func LoadSigningKey(keyPath string) (ed25519.PrivateKey, error) {
+	seed, err := loadKeyFile(keyPath, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// PublicKey returns the hex encoded public key, empty if signing is disabled.
+func (l *Ledger) PublicKey() string {
+	if l.key == nil {
+		return ""
+	}
+	return hex.EncodeToString(l.key.Public().(ed25519.PublicKey))
+}
+
+// Append adds the hash of the file to the ledger of the monitor.
+func (l *Ledger) Append(monitorID string, typ string, path string, hash string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev, err := l.lastChain(monitorID)
+	if err != nil {
+		return err
+	}
+	entry := LedgerEntry{
+		Time:   time.Now(),
+		Type:   typ,
+		Path:   path,
+		SHA256: hash,
+		Prev:   prev,
+	}
+	entry.Chain = entry.chain()
+	if l.key != nil {
+		entry.Signature = base64.StdEncoding.EncodeToString(
+			ed25519.Sign(l.key, []byte(entry.Chain)))
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path = l.filePath(monitorID, entry.Time)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	l.last[monitorID] = entry.Chain
+	return file.Close()
+}
+
+func (l *Ledger) filePath(monitorID string, t time.Time) string {
+	return filepath.Join(l.dir, monitorID, t.Format("2006-01")+".jsonl")
+}
+
+// files returns the ledger files of the monitor, oldest first.
+func (l *Ledger) files(monitorID string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(l.dir, monitorID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".jsonl") {
+			files = append(files, filepath.Join(l.dir, monitorID, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// lastChain returns the chain of the last entry, empty if the ledger is
+// empty. A incomplete trailing line from a unclean shutdown is removed.
+func (l *Ledger) lastChain(monitorID string) (string, error) {
+	if chain, exist := l.last[monitorID]; exist {
+		return chain, nil
+	}
+	files, err := l.files(monitorID)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	latest := files[len(files)-1]
+	if err := truncateIncompleteLine(latest); err != nil {
+		return "", err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		entries, err := readLedgerFile(files[i])
+		if err != nil {
+			return "", err
+		}
+		if len(entries) != 0 {
+			return entries[len(entries)-1].Chain, nil
+		}
+	}
+	return "", nil
+}
+
+func truncateIncompleteLine(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(raw) == 0 || raw[len(raw)-1] == '\n' {
+		return nil
+	}
+	return os.Truncate(path, int64(bytes.LastIndexByte(raw, '\n')+1))
+}
+
+func readLedgerFile(path string) ([]LedgerEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrLedgerCorrupt, filepath.Base(path), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verification result of a file.
+type Verification struct {
+	// All the checks passed.
+	Valid bool `json:"valid"`
+
+	// Current hash of the file.
+	SHA256 string       `json:"sha256"`
+	Entry  *LedgerEntry `json:"entry"`
+
+	// The current hash matches the entry.
+	HashMatch bool `json:"hashMatch"`
+
+	// The chain of the ledger is intact up to and including the entry.
+	ChainValid bool `json:"chainValid"`
+
+	Signed         bool `json:"signed"`
+	SignatureValid bool `json:"signatureValid"`
+}
+
+// Verify compares the current hash of the file to the ledger and
+// verifies the chain and signature of the entry. The entry is added
+// after the file is written, only the ledger files from the month of
+// t onwards are searched.
+func (l *Ledger) Verify(
+	monitorID string,
+	typ string,
+	path string,
+	hash string,
+	t time.Time,
+) (*Verification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	files, err := l.files(monitorID)
+	if err != nil {
+		return nil, err
+	}
+	first := l.filePath(monitorID, t)
+	for i, file := range files {
+		if file < first {
+			continue
+		}
+		entries, err := readLedgerFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		// The first entry links to the last entry of the previous file.
+		prev := ""
+		for j := i - 1; j >= 0 && prev == ""; j-- {
+			prevEntries, err := readLedgerFile(files[j])
+			if err != nil {
+				return nil, err
+			}
+			if len(prevEntries) != 0 {
+				prev = prevEntries[len(prevEntries)-1].Chain
+			}
+		}
+
+		chainValid := true
+		for _, entry := range entries {
+			if entry.Prev != prev || entry.chain() != entry.Chain {
+				chainValid = false
+			}
+			prev = entry.Chain
+			if entry.Type != typ || entry.Path != path {
+				continue
+			}
+			return l.verification(entry, hash, chainValid), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNotInLedger, path)
+}
+
+func (l *Ledger) verification(entry LedgerEntry, hash string, chainValid bool) *Verification {
+	v := &Verification{
+		SHA256:     hash,
+		Entry:      &entry,
+		HashMatch:  entry.SHA256 == hash,
+		ChainValid: chainValid,
+		Signed:     entry.Signature != "",
+	}
+	if v.Signed && l.key != nil {
+		signature, err := base64.StdEncoding.DecodeString(entry.Signature)
+		v.SignatureValid = err == nil && ed25519.Verify(
+			l.key.Public().(ed25519.PublicKey), []byte(entry.Chain), signature)
+	}
+	v.Valid = v.HashMatch && v.ChainValid && (!v.Signed || v.SignatureValid)
+	return v
+}
+
+// HashRecording returns the hex encoded SHA-256 of the meta file
+// followed by the decrypted mdat file, or of the decrypted mkv file.
+func HashRecording(recPath string, crypt *Crypt) (string, error) {
+	hash := sha256.New()
+
+	if IsMKVRecording(recPath) {
+		file, err := OpenRecordingFile(recPath+".mkv", crypt)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", fmt.Errorf("hash mkv: %w", err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	meta, err := os.Open(recPath + ".meta")
+	if err != nil {
+		return "", err
+	}
+	defer meta.Close()
+	if _, err := io.Copy(hash, meta); err != nil {
+		return "", fmt.Errorf("hash meta: %w", err)
+	}
+
+	mdat, err := OpenRecordingFile(recPath+".mdat", crypt)
+	if err != nil {
+		return "", err
+	}
+	defer mdat.Close()
+	if _, err := io.Copy(hash, mdat); err != nil {
+		return "", fmt.Errorf("hash mdat: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HashSegment returns the hex encoded SHA-256 of the
+// first size bytes of the decrypted segment file.
+func HashSegment(path string, size int64, crypt *Crypt) (string, error) {
+	file, err := OpenRecordingFile(path, crypt)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger(t *testing.T) {
+	now := time.Now()
+	t.Run("ok", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+		require.NoError(t, l.Append("m1", LedgerSegment, "b", "2"))
+
+		v, err := l.Verify("m1", LedgerSegment, "b", "2", now)
+		require.NoError(t, err)
+		require.True(t, v.Valid)
+		require.True(t, v.HashMatch)
+		require.True(t, v.ChainValid)
+		require.False(t, v.Signed)
+
+		// The chain continues after a restart.
+		l2 := NewLedger(l.dir, nil)
+		require.NoError(t, l2.Append("m1", LedgerRecording, "c", "3"))
+		v, err = l2.Verify("m1", LedgerRecording, "c", "3", now)
+		require.NoError(t, err)
+		require.True(t, v.Valid)
+		require.Equal(t, 3, len(readTestLedger(t, l, "m1", now)))
+	})
+	t.Run("modifiedFile", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+
+		v, err := l.Verify("m1", LedgerRecording, "a", "x", now)
+		require.NoError(t, err)
+		require.False(t, v.Valid)
+		require.False(t, v.HashMatch)
+		require.True(t, v.ChainValid)
+	})
+	t.Run("modifiedLedger", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+		require.NoError(t, l.Append("m1", LedgerRecording, "b", "2"))
+
+		// Replace the hash of the first entry to match a modified file.
+		path := l.filePath("m1", now)
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		raw = bytes.Replace(raw, []byte(`"sha256":"1"`), []byte(`"sha256":"x"`), 1)
+		require.NoError(t, os.WriteFile(path, raw, 0o600))
+
+		v, err := l.Verify("m1", LedgerRecording, "a", "x", now)
+		require.NoError(t, err)
+		require.True(t, v.HashMatch)
+		require.False(t, v.ChainValid)
+		require.False(t, v.Valid)
+
+		v, err = l.Verify("m1", LedgerRecording, "b", "2", now)
+		require.NoError(t, err)
+		require.False(t, v.ChainValid)
+	})
+	t.Run("removedPreviousMonth", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+
+		// Move the entry to the previous month and add a new entry.
+		prevMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local)
+		require.NoError(t, os.Rename(l.filePath("m1", now), l.filePath("m1", prevMonth)))
+		require.NoError(t, l.Append("m1", LedgerRecording, "b", "2"))
+
+		v, err := l.Verify("m1", LedgerRecording, "b", "2", now)
+		require.NoError(t, err)
+		require.True(t, v.Valid)
+
+		require.NoError(t, os.Remove(l.filePath("m1", prevMonth)))
+		v, err = l.Verify("m1", LedgerRecording, "b", "2", now)
+		require.NoError(t, err)
+		require.False(t, v.ChainValid)
+	})
+	t.Run("signed", func(t *testing.T) {
+		key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+		l := NewLedger(t.TempDir(), key)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+		require.Len(t, l.PublicKey(), 64)
+
+		v, err := l.Verify("m1", LedgerRecording, "a", "1", now)
+		require.NoError(t, err)
+		require.True(t, v.Signed)
+		require.True(t, v.SignatureValid)
+		require.True(t, v.Valid)
+
+		otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+		v, err = NewLedger(l.dir, otherKey).Verify("m1", LedgerRecording, "a", "1", now)
+		require.NoError(t, err)
+		require.False(t, v.SignatureValid)
+		require.False(t, v.Valid)
+	})
+	t.Run("incompleteLine", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		require.NoError(t, l.Append("m1", LedgerRecording, "a", "1"))
+
+		file, err := os.OpenFile(l.filePath("m1", now), os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"time":`)
+		require.NoError(t, err)
+		file.Close()
+
+		l2 := NewLedger(l.dir, nil)
+		require.NoError(t, l2.Append("m1", LedgerRecording, "b", "2"))
+		v, err := l2.Verify("m1", LedgerRecording, "b", "2", now)
+		require.NoError(t, err)
+		require.True(t, v.Valid)
+	})
+	t.Run("notInLedger", func(t *testing.T) {
+		l := NewLedger(t.TempDir(), nil)
+		_, err := l.Verify("m1", LedgerRecording, "a", "1", now)
+		require.ErrorIs(t, err, ErrNotInLedger)
+	})
+}
+
+func readTestLedger(t *testing.T, l *Ledger, monitorID string, now time.Time) []string {
+	t.Helper()
+	raw, err := os.ReadFile(l.filePath(monitorID, now))
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(raw)), "\n")
+}
+
+func TestHashRecording(t *testing.T) {
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "plain")
+	encryptedPath := filepath.Join(dir, "encrypted")
+
+	crypt := newTestCrypt(t)
+	for _, path := range []string{plainPath, encryptedPath} {
+		require.NoError(t, os.WriteFile(path+".meta", []byte("meta"), 0o600))
+	}
+	writeRecordingFile(t, plainPath+".mdat", nil, []byte("mdat"))
+	writeRecordingFile(t, encryptedPath+".mdat", crypt, []byte("mdat"))
+
+	// The hash is of the plain text.
+	plainHash, err := HashRecording(plainPath, nil)
+	require.NoError(t, err)
+	encryptedHash, err := HashRecording(encryptedPath, crypt)
+	require.NoError(t, err)
+	require.Equal(t, plainHash, encryptedHash)
+	require.Equal(t,
+		"dc88d77ead92fd9b8fa8fbfda735a6e1d9915fb453d63f9d20b59c4571a6adef", plainHash)
+
+	// Only the first size bytes of segments are hashed.
+	segHash, err := HashSegment(encryptedPath+".mdat", 2, crypt)
+	require.NoError(t, err)
+	require.NotEqual(t, plainHash, segHash)
+}
@@ -0,0 +1,214 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Prefetcher reads the next segments of a export into memory while the
+// current segment is written, so that playback from slow storage such
+// as spinning disks or NFS doesn't stall between segments. Each export
+// reads up to count segments ahead, the prefetched data of all exports
+// is limited to cacheSize bytes. Segments that don't fit in the cache
+// are read from the file when they're reached. A nil Prefetcher
+// doesn't prefetch.
+type Prefetcher struct {
+	count     int
+	cacheSize int64
+
+	mu   sync.Mutex
+	used int64
+}
+
+// NewPrefetcher creates a prefetcher, returns nil if count is zero.
+func NewPrefetcher(count int, cacheSize int64) *Prefetcher {
+	if count <= 0 {
+		return nil
+	}
+	return &Prefetcher{count: count, cacheSize: cacheSize}
+}
+
+// ExportSegments exports the segments like the function
+// with the same name, the next segments are read ahead.
+func (p *Prefetcher) ExportSegments(
+	w io.Writer,
+	segmentsDirs []string,
+	crypt *Crypt,
+	segments []SegmentInfo,
+	start time.Time,
+	end time.Time,
+) error {
+	return exportSegments(w, segmentsDirs, crypt, segments, start, end, p)
+}
+
+func (p *Prefetcher) reserve(size int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.used+size > p.cacheSize {
+		return false
+	}
+	p.used += size
+	return true
+}
+
+func (p *Prefetcher) release(size int64) {
+	p.mu.Lock()
+	p.used -= size
+	p.mu.Unlock()
+}
+
+// segmentFile is a opened or prefetched segment.
+type segmentFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+func openSegment(segmentsDirs []string, crypt *Crypt, seg SegmentInfo) (*RecordingFile, error) {
+	if seg.Tier >= len(segmentsDirs) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTier, seg.Path)
+	}
+	return OpenRecordingFile(filepath.Join(segmentsDirs[seg.Tier], seg.Path), crypt)
+}
+
+// prefetchedSegment is a segment in the cache, the
+// reservation is released when it's closed.
+type prefetchedSegment struct {
+	*bytes.Reader
+	release func()
+}
+
+func (s *prefetchedSegment) Close() error {
+	s.release()
+	return nil
+}
+
+// prefetchQueue reads the segments of a export in order, one at a
+// time, so that disks aren't seeking between the prefetched files.
+type prefetchQueue struct {
+	p            *Prefetcher
+	segmentsDirs []string
+	crypt        *Crypt
+	segments     []SegmentInfo
+
+	// The result of each segment, nil if it wasn't prefetched.
+	results []chan *prefetchedSegment
+
+	// Limits the segments that are read ahead.
+	ahead chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// start prefetches the segments after the first, returns
+// a queue that opens the segments directly if p is nil.
+func (p *Prefetcher) start(segmentsDirs []string, crypt *Crypt, segments []SegmentInfo) *prefetchQueue {
+	q := &prefetchQueue{
+		p:            p,
+		segmentsDirs: segmentsDirs,
+		crypt:        crypt,
+		segments:     segments,
+	}
+	if p == nil || len(segments) < 2 {
+		return q
+	}
+
+	q.results = make([]chan *prefetchedSegment, len(segments))
+	for i := range q.results {
+		q.results[i] = make(chan *prefetchedSegment, 1)
+	}
+	q.ahead = make(chan struct{}, p.count)
+	q.done = make(chan struct{})
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for i := 1; i < len(segments); i++ {
+			select {
+			case <-q.done:
+				return
+			case q.ahead <- struct{}{}:
+			}
+			q.results[i] <- q.prefetch(segments[i])
+		}
+	}()
+	return q
+}
+
+// prefetch returns nil if the segment doesn't fit in the
+// cache or can't be read, errors are returned by open.
+func (q *prefetchQueue) prefetch(seg SegmentInfo) *prefetchedSegment {
+	file, err := openSegment(q.segmentsDirs, q.crypt, seg)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	size := file.Size()
+	if !q.p.reserve(size) {
+		return nil
+	}
+	data := make([]byte, size)
+	n, err := file.ReadAt(data, 0)
+	if err != nil && !(errors.Is(err, io.EOF) && int64(n) == size) {
+		q.p.release(size)
+		return nil
+	}
+
+	var once sync.Once
+	return &prefetchedSegment{
+		Reader: bytes.NewReader(data),
+		release: func() {
+			once.Do(func() { q.p.release(size) })
+		},
+	}
+}
+
+// open returns the segment at index i, segments must be opened in order.
+func (q *prefetchQueue) open(i int) (segmentFile, error) {
+	if q.results != nil && i != 0 {
+		seg := <-q.results[i]
+		<-q.ahead
+		if seg != nil {
+			return seg, nil
+		}
+	}
+	return openSegment(q.segmentsDirs, q.crypt, q.segments[i])
+}
+
+// close stops the prefetching and releases the unused segments.
+func (q *prefetchQueue) close() {
+	if q.results == nil {
+		return
+	}
+	close(q.done)
+	q.wg.Wait()
+	for _, result := range q.results {
+		select {
+		case seg := <-result:
+			if seg != nil {
+				seg.Close()
+			}
+		default:
+		}
+	}
+}
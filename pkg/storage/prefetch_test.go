@@ -0,0 +1,88 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefetcherExportSegments(t *testing.T) {
+	dir := t.TempDir()
+	var segments []SegmentInfo
+	for i := int64(0); i < 5; i++ {
+		name := string(rune('a'+i)) + ".mp4"
+		segments = append(segments, writeTestSegment(t, dir, name, 100+i*2, 2))
+	}
+	start, end := time.Unix(100, 0), time.Unix(110, 0)
+
+	expected := &bytes.Buffer{}
+	require.NoError(t, ExportSegments(expected, []string{dir}, nil, segments, start, end))
+
+	cases := map[string]struct {
+		count     int
+		cacheSize int64
+	}{
+		"one":     {1, 1000000},
+		"all":     {10, 1000000},
+		"noCache": {2, 10},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p := NewPrefetcher(tc.count, tc.cacheSize)
+			buf := &bytes.Buffer{}
+			require.NoError(t, p.ExportSegments(buf, []string{dir}, nil, segments, start, end))
+			require.Equal(t, expected.Bytes(), buf.Bytes())
+			require.Equal(t, int64(0), p.used)
+		})
+	}
+	t.Run("disabled", func(t *testing.T) {
+		p := NewPrefetcher(-1, 1000000)
+		require.Nil(t, p)
+		buf := &bytes.Buffer{}
+		require.NoError(t, p.ExportSegments(buf, []string{dir}, nil, segments, start, end))
+		require.Equal(t, expected.Bytes(), buf.Bytes())
+	})
+	t.Run("released", func(t *testing.T) {
+		// The export stops at the changed stream,
+		// the prefetched segments are released.
+		path := filepath.Join(dir, "c.mp4")
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		raw[20]++
+		require.NoError(t, os.WriteFile(path, raw, 0o600))
+
+		p := NewPrefetcher(3, 1000000)
+		err = p.ExportSegments(&bytes.Buffer{}, []string{dir}, nil, segments, start, end)
+		require.ErrorIs(t, err, ErrExportStreamChanged)
+		require.Equal(t, int64(0), p.used)
+	})
+	t.Run("missingFile", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(dir, "d.mp4")))
+
+		p := NewPrefetcher(3, 1000000)
+		err := p.ExportSegments(&bytes.Buffer{}, []string{dir}, nil, segments[:2], start, end)
+		require.NoError(t, err)
+		err = p.ExportSegments(&bytes.Buffer{}, []string{dir}, nil, segments[3:], start, end)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.Equal(t, int64(0), p.used)
+	})
+}
@@ -0,0 +1,351 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"nvr/pkg/log"
+	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/mkv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Recovery errors.
+var (
+	ErrNoSamples   = errors.New("no complete samples")
+	ErrNoKeyframes = errors.New("no complete fragments with keyframes")
+)
+
+// RecoverRecordings finalizes the event recordings and continuous
+// segments that were being written when the process died. Must be
+// called before the monitors are started. Event recordings without
+// a data file get one without events and continuous segments that
+// are missing from the index are indexed. Files without any playable
+// samples are removed. Only the storage directory is scanned, the
+// archive tier only contains finished files.
+func (s *Manager) RecoverRecordings(crypt *Crypt) error {
+	recordingsDir := s.recordingsDirs()[0]
+	recoverFuncs := []struct {
+		ext string
+		fn  recoverRecordingFunc
+	}{
+		{".meta", recoverRecording},
+		{".mkv", recoverMKVRecording},
+	}
+	for _, r := range recoverFuncs {
+		ext, recoverFunc := r.ext, r.fn
+		err := walkFiles(recordingsDir, ext, func(path string) {
+			recPath := strings.TrimSuffix(path, ext)
+			if _, err := os.Stat(recPath + ".json"); !errors.Is(err, os.ErrNotExist) {
+				return
+			}
+			name := filepath.Base(recPath)
+			if err := recoverFunc(recPath, crypt); err != nil {
+				s.logf(log.LevelError, "could not recover recording: %v: %v", name, err)
+				if errors.Is(err, ErrNoSamples) {
+					s.removeRecording(recPath)
+				}
+				return
+			}
+			s.logf(log.LevelInfo, "recovered recording: %v", name)
+		})
+		if err != nil {
+			return fmt.Errorf("recover recordings: %w", err)
+		}
+	}
+
+	segmentsDir := s.segmentsDirs()[0]
+	err := walkFiles(segmentsDir, ".mp4", func(path string) {
+		relPath, err := filepath.Rel(segmentsDir, path)
+		if err != nil {
+			return
+		}
+		monitorID := filepath.Base(filepath.Dir(path))
+		if s.index.Exist(monitorID, relPath) {
+			return
+		}
+		seg, err := recoverSegment(segmentsDir, relPath, monitorID, crypt)
+		if err != nil {
+			s.logf(log.LevelError, "could not recover segment: %v: %v", relPath, err)
+			if errors.Is(err, ErrNoKeyframes) {
+				if err := os.Remove(path); err != nil {
+					s.logf(log.LevelError, "could not remove segment: %v", err)
+				}
+			}
+			return
+		}
+		if err := s.index.Add(*seg); err != nil {
+			s.logf(log.LevelError, "could not index segment: %v: %v", relPath, err)
+			return
+		}
+		s.logf(log.LevelInfo, "recovered segment: %v", relPath)
+	})
+	if err != nil {
+		return fmt.Errorf("recover segments: %w", err)
+	}
+	return nil
+}
+
+// walkFiles calls fn with the path of each file with the suffix.
+// A missing directory is not an error.
+func walkFiles(dir string, suffix string, fn func(path string)) error {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, suffix) {
+			fn(path)
+		}
+		return nil
+	})
+}
+
+func (s *Manager) removeRecording(recPath string) {
+	for _, ext := range []string{".meta", ".mdat", ".mkv", ".jpeg"} {
+		err := os.Remove(recPath + ext)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logf(log.LevelError, "could not remove file: %v", err)
+		}
+	}
+}
+
+type recoverRecordingFunc func(recPath string, crypt *Crypt) error
+
+// recoverRecording truncates the meta file to the samples that are
+// completely written to the mdat file and writes the data file.
+func recoverRecording(recPath string, crypt *Crypt) error {
+	if err := FinalizeRecordingFile(recPath+".mdat", crypt); err != nil {
+		return fmt.Errorf("finalize mdat: %w", err)
+	}
+	mdat, err := OpenRecordingFile(recPath+".mdat", crypt)
+	if err != nil {
+		return err
+	}
+	mdatSize := mdat.Size()
+	mdat.Close()
+
+	header, samples, err := readMetaFile(recPath + ".meta")
+	if err != nil {
+		return err
+	}
+
+	// Samples are written to the mdat file before the meta file.
+	n := 0
+	var end int64
+	for _, sample := range samples {
+		if int64(sample.Offset)+int64(sample.Size) > mdatSize {
+			break
+		}
+		if sample.Next > end {
+			end = sample.Next
+		}
+		n++
+	}
+	if n == 0 {
+		return ErrNoSamples
+	}
+
+	metaSize := int64(len(header.Marshal()))
+	for _, sample := range samples[:n] {
+		metaSize += int64(len(sample.Marshal()))
+	}
+	if err := os.Truncate(recPath+".meta", metaSize); err != nil {
+		return fmt.Errorf("truncate meta: %w", err)
+	}
+
+	return writeRecoveredData(recPath, time.Unix(0, header.StartTime), time.Unix(0, end))
+}
+
+// recoverMKVRecording writes the data file of a Matroska recording.
+// Players ignore the incomplete cluster at the end of the file.
+func recoverMKVRecording(recPath string, crypt *Crypt) error {
+	if err := FinalizeRecordingFile(recPath+".mkv", crypt); err != nil {
+		return fmt.Errorf("finalize mkv: %w", err)
+	}
+	file, err := OpenRecordingFile(recPath+".mkv", crypt)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start, end, err := mkv.ReadTimes(file, file.Size())
+	if errors.Is(err, mkv.ErrNoClusters) {
+		return ErrNoSamples
+	}
+	if err != nil {
+		return fmt.Errorf("read mkv: %w", err)
+	}
+	return writeRecoveredData(recPath, start, end)
+}
+
+func writeRecoveredData(recPath string, start time.Time, end time.Time) error {
+	// The events are only kept in memory until the recording is saved.
+	data := RecordingData{
+		Start:  start,
+		End:    end,
+		Events: Events{},
+	}
+	raw, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recPath+".json", raw, 0o600)
+}
+
+func readMetaFile(path string) (*customformat.Header, []customformat.Sample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, header, err := customformat.NewReader(file, int(stat.Size()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read meta: %w", err)
+	}
+	samples, err := reader.ReadAllSamples()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read samples: %w", err)
+	}
+	return header, samples, nil
+}
+
+// recoverSegment returns the index entry of the complete fragments in
+// the segment file. The base time of the file isn't stored, the start
+// time is parsed from the file name and has second precision.
+func recoverSegment(
+	segmentsDir string,
+	relPath string,
+	monitorID string,
+	crypt *Crypt,
+) (*SegmentInfo, error) {
+	path := filepath.Join(segmentsDir, relPath)
+	name := filepath.Base(relPath)
+	if len(name) < 19 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecordingID, name)
+	}
+	start, err := time.ParseInLocation("2006-01-02_15-04-05", name[:19], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("parse start time: %w", err)
+	}
+
+	if err := FinalizeRecordingFile(path, crypt); err != nil {
+		return nil, fmt.Errorf("finalize: %w", err)
+	}
+	file, err := OpenRecordingFile(path, crypt)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	size, end, keyframes, err := scanFragments(buf, start)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyframes) == 0 {
+		return nil, ErrNoKeyframes
+	}
+	return &SegmentInfo{
+		MonitorID: monitorID,
+		Path:      relPath,
+		Start:     start,
+		End:       end,
+		Size:      size,
+		Keyframes: keyframes,
+	}, nil
+}
+
+// scanFragments returns the size of the init section and the complete
+// moof and mdat pairs, the end time and the keyframes of the fragments.
+// The decode times in the file are relative to baseTime.
+func scanFragments(buf []byte, baseTime time.Time) (int64, time.Time, []Keyframe, error) {
+	// nextBox returns the type and size of the box at pos,
+	// false if the box is incomplete.
+	nextBox := func(pos int64) (string, int64, bool) {
+		if pos+8 > int64(len(buf)) {
+			return "", 0, false
+		}
+		size := int64(binary.BigEndian.Uint32(buf[pos:]))
+		if size < 8 || pos+size > int64(len(buf)) {
+			return "", 0, false
+		}
+		return string(buf[pos+4 : pos+8]), size, true
+	}
+
+	var pos int64
+	var timescales map[uint32]uint32
+	for timescales == nil {
+		typ, size, ok := nextBox(pos)
+		if !ok {
+			return 0, time.Time{}, nil, fmt.Errorf("%w: incomplete init", ErrInvalidBox)
+		}
+		pos += size
+		if typ == "moov" {
+			var err error
+			timescales, err = trackTimescales(buf[:pos])
+			if err != nil {
+				return 0, time.Time{}, nil, err
+			}
+		}
+	}
+
+	var end int64
+	var keyframes []Keyframe
+	for {
+		typ, moofSize, ok := nextBox(pos)
+		if !ok || typ != "moof" {
+			break
+		}
+		typ, mdatSize, ok := nextBox(pos + moofSize)
+		if !ok || typ != "mdat" {
+			break
+		}
+		times, err := parseMoof(buf[pos+8:pos+moofSize], timescales)
+		if err != nil {
+			break
+		}
+		if times.keyframe {
+			keyframes = append(keyframes, Keyframe{
+				Time:   baseTime.Add(time.Duration(times.keyframeTime)),
+				Offset: pos,
+			})
+		}
+		if times.end > end {
+			end = times.end
+		}
+		pos += moofSize + mdatSize
+	}
+	return pos, baseTime.Add(time.Duration(end)), keyframes, nil
+}
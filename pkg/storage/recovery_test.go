@@ -0,0 +1,218 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/customformat"
+	"nvr/pkg/video/hls"
+	"nvr/pkg/video/mkv"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCrashedRecording writes the samples without closing the mdat file.
+func writeCrashedRecording(t *testing.T, recPath string, crypt *Crypt, sampleSizes []int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(recPath), 0o700))
+
+	meta, err := os.Create(recPath + ".meta")
+	require.NoError(t, err)
+	defer meta.Close()
+	mdat, err := CreateRecordingFile(recPath+".mdat", crypt)
+	require.NoError(t, err)
+	defer mdat.file.Close()
+
+	w, err := customformat.NewWriter(meta, mdat, customformat.Header{
+		VideoSPS:  []byte{103, 0, 0, 0, 172, 217, 0},
+		VideoPPS:  []byte{2, 3, 4},
+		StartTime: int64(time.Second),
+	})
+	require.NoError(t, err)
+
+	var samples []*hls.VideoSample
+	for i, size := range sampleSizes {
+		dts := int64(i+1) * int64(time.Second)
+		samples = append(samples, &hls.VideoSample{
+			PTS:        dts,
+			DTS:        dts,
+			NextDTS:    dts + int64(time.Second),
+			AVCC:       make([]byte, size),
+			IdrPresent: true,
+		})
+	}
+	err = w.WriteSegment(&hls.Segment{
+		Parts: []*hls.MuxerPart{{VideoSamples: samples}},
+	})
+	require.NoError(t, err)
+}
+
+func readRecordingData(t *testing.T, recPath string) RecordingData {
+	t.Helper()
+	raw, err := os.ReadFile(recPath + ".json")
+	require.NoError(t, err)
+	var data RecordingData
+	require.NoError(t, json.Unmarshal(raw, &data))
+	return data
+}
+
+func TestRecoverRecordings(t *testing.T) {
+	crypt := newTestCrypt(t)
+	t.Run("recording", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		recPath := filepath.Join(
+			s.storageDir, "recordings", "2022", "01", "02", "m1", "2022-01-02_03-04-05_m1")
+
+		// The second sample is in the unwritten final chunk.
+		writeCrashedRecording(t, recPath, crypt, []int{40 * 1024, 40 * 1024})
+
+		require.NoError(t, s.RecoverRecordings(crypt))
+
+		data := readRecordingData(t, recPath)
+		require.True(t, time.Unix(1, 0).Equal(data.Start))
+		require.True(t, time.Unix(2, 0).Equal(data.End))
+		require.Empty(t, data.Events)
+
+		video, err := NewVideoReader(recPath, nil, crypt)
+		require.NoError(t, err)
+		defer video.Close()
+		n, err := new(bytes.Buffer).ReadFrom(video)
+		require.NoError(t, err)
+		require.Equal(t, video.Size(), n)
+	})
+	t.Run("saved", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		recPath := filepath.Join(
+			s.storageDir, "recordings", "2022", "01", "02", "m1", "2022-01-02_03-04-05_m1")
+		writeCrashedRecording(t, recPath, nil, []int{10})
+		require.NoError(t, os.WriteFile(recPath+".json", []byte("x"), 0o600))
+
+		require.NoError(t, s.RecoverRecordings(nil))
+
+		raw, err := os.ReadFile(recPath + ".json")
+		require.NoError(t, err)
+		require.Equal(t, []byte("x"), raw)
+	})
+	t.Run("empty", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		recPath := filepath.Join(
+			s.storageDir, "recordings", "2022", "01", "02", "m1", "2022-01-02_03-04-05_m1")
+		writeCrashedRecording(t, recPath, crypt, []int{10})
+
+		require.NoError(t, s.RecoverRecordings(crypt))
+
+		_, err := os.Stat(recPath + ".meta")
+		require.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Stat(recPath + ".mdat")
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("mkv", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		recPath := filepath.Join(
+			s.storageDir, "recordings", "2022", "01", "02", "m1", "2022-01-02_03-04-05_m1")
+		require.NoError(t, os.MkdirAll(filepath.Dir(recPath), 0o700))
+
+		file, err := CreateRecordingFile(recPath+".mkv", nil)
+		require.NoError(t, err)
+		w, err := mkv.NewWriter(file, nil, hls.StreamInfo{
+			VideoTrackExist: true,
+			VideoSPS:        []byte{103, 0, 0, 0, 172, 217, 0},
+			VideoPPS:        []byte{2, 3, 4},
+		}, int64(time.Second))
+		require.NoError(t, err)
+		var samples []*hls.VideoSample
+		for i := 0; i < 3; i++ {
+			pts := int64(i+1) * int64(time.Second)
+			samples = append(samples, &hls.VideoSample{
+				PTS:        pts,
+				DTS:        pts,
+				AVCC:       make([]byte, 10),
+				IdrPresent: true,
+			})
+		}
+		err = w.WriteSegment(&hls.Segment{
+			Parts: []*hls.MuxerPart{{VideoSamples: samples}},
+		})
+		require.NoError(t, err)
+		// Crash before the last cluster is written.
+		require.NoError(t, file.file.Close())
+
+		require.NoError(t, s.RecoverRecordings(nil))
+
+		data := readRecordingData(t, recPath)
+		require.True(t, time.Unix(1, 0).Equal(data.Start))
+		require.True(t, time.Unix(2, 0).Equal(data.End))
+	})
+	t.Run("segment", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		dir := t.TempDir()
+		writeTestSegment(t, dir, "x.mp4", 0, 3)
+		buf, err := os.ReadFile(filepath.Join(dir, "x.mp4"))
+		require.NoError(t, err)
+
+		relPath := filepath.Join("2022", "01", "02", "m1", "2022-01-02_03-04-05_m1.mp4")
+		path := filepath.Join(s.storageDir, "segments", relPath)
+		writeTestFile(t, path, 0)
+
+		// Cut the last fragment.
+		require.NoError(t, os.WriteFile(path, buf[:len(buf)-2], 0o600))
+
+		require.NoError(t, s.RecoverRecordings(nil))
+
+		start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.Local)
+		segments := s.index.Query("m1", start, start.Add(time.Hour))
+		require.Len(t, segments, 1)
+		seg := segments[0]
+		require.Equal(t, relPath, seg.Path)
+		require.Equal(t, start, seg.Start)
+		require.Equal(t, start.Add(2*time.Second), seg.End)
+		require.Len(t, seg.Keyframes, 2)
+		require.Equal(t, start.Add(time.Second), seg.Keyframes[1].Time)
+
+		// The recovered segment can be exported.
+		out := &bytes.Buffer{}
+		err = ExportSegments(out, []string{filepath.Join(s.storageDir, "segments")},
+			nil, segments, start, start.Add(time.Hour))
+		require.NoError(t, err)
+		_, payloads := exportedFragments(t, out.Bytes())
+		require.Len(t, payloads, 2)
+
+		// Indexed segments are skipped.
+		require.NoError(t, s.RecoverRecordings(nil))
+		require.Len(t, s.index.Query("m1", start, start.Add(time.Hour)), 1)
+	})
+	t.Run("segmentWithoutFragments", func(t *testing.T) {
+		s := newRetentionTestManager(t, nil)
+		init, err := hls.GenerateInit(testExportInfo)
+		require.NoError(t, err)
+
+		path := filepath.Join(
+			s.storageDir, "segments", "2022", "01", "02", "m1", "2022-01-02_03-04-05_m1.mp4")
+		writeTestFile(t, path, 0)
+		require.NoError(t, os.WriteFile(path, init, 0o600))
+
+		require.NoError(t, s.RecoverRecordings(nil))
+
+		_, err = os.Stat(path)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,235 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy retention limits of a single monitor. Zero is unlimited.
+type RetentionPolicy struct {
+	// Maximum age of event recordings.
+	EventMaxAge time.Duration
+
+	// Maximum age of continuous recording segments.
+	ContinuousMaxAge time.Duration
+
+	// Maximum share of the disk space in percent that the
+	// recordings and segments of the monitor can use.
+	MaxDiskShare float64
+}
+
+// RetentionFunc returns the retention policies by monitor ID.
+type RetentionFunc func() map[string]RetentionPolicy
+
+// applyRetention deletes the recordings and
+// segments that exceed the monitor policies.
+func (s *Manager) applyRetention(now time.Time) error {
+	if s.retention == nil {
+		return nil
+	}
+	for id, policy := range s.retention() {
+		if policy.EventMaxAge > 0 {
+			if err := s.pruneRecordingsBefore(id, now.Add(-policy.EventMaxAge)); err != nil {
+				return fmt.Errorf("%v: event retention: %w", id, err)
+			}
+			if s.events != nil {
+				if err := s.events.RemoveMonitorBefore(id, now.Add(-policy.EventMaxAge)); err != nil {
+					return fmt.Errorf("%v: event retention: %w", id, err)
+				}
+			}
+		}
+		if policy.ContinuousMaxAge > 0 && s.index != nil {
+			removed, err := s.index.RemoveMonitorBefore(id, now.Add(-policy.ContinuousMaxAge))
+			if err != nil {
+				return fmt.Errorf("%v: continuous retention: %w", id, err)
+			}
+			if err := s.removeSegmentFiles(removed); err != nil {
+				return fmt.Errorf("%v: continuous retention: %w", id, err)
+			}
+		}
+		if policy.MaxDiskShare > 0 {
+			diskSpace, err := s.disk.general.DiskSpace()
+			if err != nil {
+				return fmt.Errorf("disk space: %w", err)
+			}
+			limit := int64(float64(diskSpace) * policy.MaxDiskShare / 100)
+			if err := s.enforceDiskShare(id, limit); err != nil {
+				return fmt.Errorf("%v: disk share: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+type recordingDay struct {
+	day  time.Time
+	path string
+	base string // Recordings directory of the tier.
+}
+
+// monitorRecordingDays returns the recording directories
+// of the monitor in all storage tiers, oldest first.
+func (s *Manager) monitorRecordingDays(monitorID string) ([]recordingDay, error) {
+	var days []recordingDay
+	for _, base := range s.recordingsDirs() {
+		paths, err := filepath.Glob(filepath.Join(base, "*", "*", "*", monitorID))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			rel, err := filepath.Rel(base, filepath.Dir(path))
+			if err != nil {
+				continue
+			}
+			day, err := time.ParseInLocation("2006/01/02", filepath.ToSlash(rel), time.Local)
+			if err != nil {
+				continue
+			}
+			days = append(days, recordingDay{day: day, path: path, base: base})
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].day.Before(days[j].day)
+	})
+	return days, nil
+}
+
+const recordingTimeLayout = "2006-01-02_15-04-05"
+
+// pruneRecordingsBefore deletes the recordings of the monitor that
+// started before t. Days that ended before t are deleted entirely.
+func (s *Manager) pruneRecordingsBefore(monitorID string, t time.Time) error {
+	days, err := s.monitorRecordingDays(monitorID)
+	if err != nil {
+		return err
+	}
+	for _, d := range days {
+		if !d.day.Before(t) {
+			return nil
+		}
+		if !d.day.AddDate(0, 0, 1).After(t) {
+			s.logf(log.LevelInfo, "retention: deleting %q", d.path)
+			if _, err := s.removeMonitorDay(d.path); err != nil {
+				return err
+			}
+			removeEmptyParents(d.base, filepath.Dir(d.path))
+			continue
+		}
+
+		files, err := os.ReadDir(d.path)
+		if err != nil {
+			return fmt.Errorf("read directory: %w", err)
+		}
+		flagged := flaggedIDs(files)
+		for _, file := range files {
+			name := file.Name()
+			if len(name) < len(recordingTimeLayout) || flagged[recordingIDFromFile(name)] {
+				continue
+			}
+			start, err := time.ParseInLocation(
+				recordingTimeLayout, name[:len(recordingTimeLayout)], time.Local)
+			if err != nil || !start.Before(t) {
+				continue
+			}
+			if err := s.removeAll(filepath.Join(d.path, name)); err != nil {
+				return fmt.Errorf("remove recording: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// enforceDiskShare deletes the oldest recording days and segments
+// of the monitor until they use less than limit bytes combined.
+func (s *Manager) enforceDiskShare(monitorID string, limit int64) error {
+	type chunk struct {
+		start   time.Time
+		size    int64
+		day     *recordingDay
+		segment *SegmentInfo
+	}
+	var chunks []chunk
+	var total int64
+
+	days, err := s.monitorRecordingDays(monitorID)
+	if err != nil {
+		return err
+	}
+	for i := range days {
+		size := diskUsageBytes(os.DirFS(days[i].path))
+		chunks = append(chunks, chunk{start: days[i].day, size: size, day: &days[i]})
+		total += size
+	}
+
+	if s.index != nil {
+		segments := s.index.Query(monitorID, time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+		for i := range segments {
+			chunks = append(chunks, chunk{
+				start:   segments[i].Start,
+				size:    segments[i].Size,
+				segment: &segments[i],
+			})
+			total += segments[i].Size
+		}
+	}
+
+	if total <= limit {
+		return nil
+	}
+	s.logf(log.LevelInfo, "retention: %v is using %v, limit %v",
+		monitorID, formatDiskUsage(float64(total)), formatDiskUsage(float64(limit)))
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].start.Before(chunks[j].start)
+	})
+	for _, c := range chunks {
+		if total <= limit {
+			return nil
+		}
+		if c.day != nil {
+			removed, err := s.removeMonitorDay(c.day.path)
+			if err != nil {
+				return err
+			}
+			removeEmptyParents(c.day.base, filepath.Dir(c.day.path))
+			// Flagged recordings are kept.
+			total -= removed
+			continue
+		}
+		if err := s.index.Remove(monitorID, c.segment.Path); err != nil {
+			return err
+		}
+		if err := s.removeSegmentFiles([]SegmentInfo{*c.segment}); err != nil {
+			return err
+		}
+		total -= c.size
+	}
+	return nil
+}
+
+func (s *Manager) logf(level log.Level, format string, a ...interface{}) {
+	s.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf(format, a...),
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newRetentionTestManager(t *testing.T, policies map[string]RetentionPolicy) *Manager {
+	t.Helper()
+	tempDir := t.TempDir()
+	index, err := OpenIndex(filepath.Join(tempDir, "index.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { index.Close() })
+
+	return &Manager{
+		storageDir: tempDir,
+		disk: &disk{
+			storageDirFS: os.DirFS(tempDir),
+			general:      diskSpace1,
+		},
+		index: index,
+		retention: func() map[string]RetentionPolicy {
+			return policies
+		},
+		removeAll: os.RemoveAll,
+		logger:    log.NewDummyLogger(),
+	}
+}
+
+func writeTestFile(t *testing.T, path string, size int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0o600))
+}
+
+func (s *Manager) listFiles(t *testing.T) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(s.storageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == "index.db" {
+			return err
+		}
+		rel, err := filepath.Rel(s.storageDir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2000, 1, 10, 12, 0, 0, 0, time.Local)
+
+	t.Run("eventMaxAge", func(t *testing.T) {
+		m := newRetentionTestManager(t, map[string]RetentionPolicy{
+			"m1": {EventMaxAge: 24 * time.Hour},
+		})
+		rec := m.RecordingsDir()
+		writeTestFile(t, filepath.Join(rec, "2000/01/08/m1/2000-01-08_10-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_11-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_11-00-00_m1.json"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_13-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/08/m2/2000-01-08_10-00-00_m2.mp4"), 1)
+
+		require.NoError(t, m.applyRetention(now))
+		require.Equal(t, []string{
+			"recordings/2000/01/08/m2/2000-01-08_10-00-00_m2.mp4",
+			"recordings/2000/01/09/m1/2000-01-09_13-00-00_m1.mp4",
+		}, m.listFiles(t))
+	})
+	t.Run("continuousMaxAge", func(t *testing.T) {
+		m := newRetentionTestManager(t, map[string]RetentionPolicy{
+			"m1": {ContinuousMaxAge: 24 * time.Hour},
+		})
+		for _, seg := range []SegmentInfo{
+			{MonitorID: "m1", Path: "2000/01/09/m1/a.mp4", Start: now.Add(-25 * time.Hour), End: now},
+			{MonitorID: "m1", Path: "2000/01/09/m1/b.mp4", Start: now.Add(-23 * time.Hour), End: now},
+			{MonitorID: "m2", Path: "2000/01/09/m2/c.mp4", Start: now.Add(-25 * time.Hour), End: now},
+		} {
+			writeTestFile(t, filepath.Join(m.SegmentsDir(), seg.Path), 1)
+			require.NoError(t, m.index.Add(seg))
+		}
+
+		require.NoError(t, m.applyRetention(now))
+		require.Equal(t, []string{
+			"segments/2000/01/09/m1/b.mp4",
+			"segments/2000/01/09/m2/c.mp4",
+		}, m.listFiles(t))
+		require.Len(t, m.index.Query("m1", time.Time{}, now), 1)
+	})
+	t.Run("maxDiskShare", func(t *testing.T) {
+		// 1GB disk, 250 bytes.
+		m := newRetentionTestManager(t, map[string]RetentionPolicy{
+			"m1": {MaxDiskShare: 0.000025},
+		})
+		rec := m.RecordingsDir()
+		writeTestFile(t, filepath.Join(rec, "2000/01/07/m1/2000-01-07_10-00-00_m1.mp4"), 100)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_10-00-00_m1.mp4"), 100)
+		writeTestFile(t, filepath.Join(rec, "2000/01/07/m2/2000-01-07_10-00-00_m2.mp4"), 100)
+		seg := SegmentInfo{
+			MonitorID: "m1",
+			Path:      "2000/01/08/m1/a.mp4",
+			Start:     time.Date(2000, 1, 8, 0, 0, 0, 0, time.Local),
+			End:       time.Date(2000, 1, 8, 0, 1, 0, 0, time.Local),
+			Size:      100,
+		}
+		writeTestFile(t, filepath.Join(m.SegmentsDir(), seg.Path), 100)
+		require.NoError(t, m.index.Add(seg))
+
+		require.NoError(t, m.applyRetention(now))
+		require.Equal(t, []string{
+			"recordings/2000/01/07/m2/2000-01-07_10-00-00_m2.mp4",
+			"recordings/2000/01/09/m1/2000-01-09_10-00-00_m1.mp4",
+			"segments/2000/01/08/m1/a.mp4",
+		}, m.listFiles(t))
+	})
+	t.Run("flagged", func(t *testing.T) {
+		m := newRetentionTestManager(t, map[string]RetentionPolicy{
+			"m1": {EventMaxAge: 24 * time.Hour},
+		})
+		rec := m.RecordingsDir()
+		writeTestFile(t, filepath.Join(rec, "2000/01/08/m1/2000-01-08_10-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/08/m1/2000-01-08_10-00-00_m1.flag"), 0)
+		writeTestFile(t, filepath.Join(rec, "2000/01/08/m1/2000-01-08_11-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_11-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_11-00-00_m1.flag"), 0)
+		writeTestFile(t, filepath.Join(rec, "2000/01/09/m1/2000-01-09_11-30-00_m1.mp4"), 1)
+
+		require.NoError(t, m.applyRetention(now))
+		require.Equal(t, []string{
+			"recordings/2000/01/08/m1/2000-01-08_10-00-00_m1.flag",
+			"recordings/2000/01/08/m1/2000-01-08_10-00-00_m1.mp4",
+			"recordings/2000/01/09/m1/2000-01-09_11-00-00_m1.flag",
+			"recordings/2000/01/09/m1/2000-01-09_11-00-00_m1.mp4",
+		}, m.listFiles(t))
+	})
+	t.Run("noPolicies", func(t *testing.T) {
+		m := &Manager{}
+		require.NoError(t, m.applyRetention(now))
+	})
+}
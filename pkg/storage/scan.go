@@ -0,0 +1,448 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// Scan errors.
+var (
+	ErrInvalidSpeed  = errors.New("invalid speed")
+	ErrNoVideoTrack  = errors.New("no video track")
+	ErrNoVideoSample = errors.New("fragment has no video sample")
+	ErrSampleMissing = errors.New("sample size missing")
+)
+
+// Scan speed limits.
+const (
+	MinScanSpeed = 2
+	MaxScanSpeed = 64
+)
+
+// scanKeyframe keyframe and the time until the next one.
+type scanKeyframe struct {
+	seg      int
+	keyframe Keyframe
+	duration time.Duration
+}
+
+// ScanSegments writes the keyframes of the segments between start and
+// end to w as a fragmented MP4 that plays speed times faster than real
+// time. Each keyframe is shown until the next one, only the video track
+// is included. The fragments are generated from the segment index
+// without decoding. The scan is stopped if the stream parameters change.
+func ScanSegments(
+	w io.Writer,
+	segmentsDirs []string,
+	crypt *Crypt,
+	segments []SegmentInfo,
+	start time.Time,
+	end time.Time,
+	speed float64,
+) error {
+	if !(speed >= MinScanSpeed && speed <= MaxScanSpeed) {
+		return fmt.Errorf("%w: %v", ErrInvalidSpeed, speed)
+	}
+
+	var keyframes []scanKeyframe
+	for i, seg := range segments {
+		for j, kf := range seg.Keyframes {
+			if kf.Time.Before(start) || !kf.Time.Before(end) {
+				continue
+			}
+			next := seg.End
+			if j+1 < len(seg.Keyframes) {
+				next = seg.Keyframes[j+1].Time
+			}
+			keyframes = append(keyframes, scanKeyframe{
+				seg:      i,
+				keyframe: kf,
+				duration: next.Sub(kf.Time),
+			})
+		}
+	}
+	if len(keyframes) == 0 {
+		return ErrExportNoSegments
+	}
+
+	s := &scanner{
+		w:         w,
+		startTime: keyframes[0].keyframe.Time,
+		speed:     speed,
+	}
+	for len(keyframes) != 0 {
+		n := 1
+		for n < len(keyframes) && keyframes[n].seg == keyframes[0].seg {
+			n++
+		}
+		seg := segments[keyframes[0].seg]
+		if err := s.scanSegment(segmentsDirs, crypt, seg, keyframes[:n]); err != nil {
+			return err
+		}
+		keyframes = keyframes[n:]
+	}
+	return nil
+}
+
+type scanner struct {
+	w         io.Writer
+	startTime time.Time
+	speed     float64
+
+	firstInit  []byte
+	videoTrack uint32
+	timescale  uint32
+	sequence   uint32
+}
+
+func (s *scanner) scanSegment(
+	segmentsDirs []string,
+	crypt *Crypt,
+	seg SegmentInfo,
+	keyframes []scanKeyframe,
+) error {
+	if seg.Tier >= len(segmentsDirs) {
+		return fmt.Errorf("%w: %v", ErrInvalidTier, seg.Path)
+	}
+	file, err := OpenRecordingFile(filepath.Join(segmentsDirs[seg.Tier], seg.Path), crypt)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	init := make([]byte, seg.Keyframes[0].Offset)
+	if _, err := file.ReadAt(init, 0); err != nil {
+		return fmt.Errorf("read init: %w", err)
+	}
+	if s.firstInit == nil {
+		s.firstInit = init
+		s.videoTrack, s.timescale, err = videoTrack(init)
+		if err != nil {
+			return err
+		}
+		if _, err := s.w.Write(init); err != nil {
+			return err
+		}
+	} else if !bytes.Equal(init, s.firstInit) {
+		return fmt.Errorf("%w: %v", ErrExportStreamChanged, seg.Path)
+	}
+
+	for _, kf := range keyframes {
+		sample, err := readKeyframeSample(file, kf.keyframe.Offset, s.videoTrack)
+		if err != nil {
+			return fmt.Errorf("%v: %w", seg.Path, err)
+		}
+
+		decodeTime := s.scaled(kf.keyframe.Time.Sub(s.startTime))
+		duration := s.scaled(kf.duration)
+		if duration == 0 {
+			duration = 1
+		}
+		s.sequence++
+		fragment := scanFragment(s.sequence, s.videoTrack, decodeTime, uint32(duration), sample)
+		if _, err := s.w.Write(fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NearestKeyframe returns the keyframe closest to t and its segment.
+func NearestKeyframe(segments []SegmentInfo, t time.Time) (SegmentInfo, Keyframe, bool) {
+	var nearestSeg SegmentInfo
+	var nearest Keyframe
+	found := false
+	var minDiff time.Duration
+	for _, seg := range segments {
+		for _, kf := range seg.Keyframes {
+			diff := kf.Time.Sub(t)
+			if diff < 0 {
+				diff = -diff
+			}
+			if !found || diff < minDiff {
+				nearestSeg, nearest, minDiff, found = seg, kf, diff, true
+			}
+		}
+	}
+	return nearestSeg, nearest, found
+}
+
+// WriteKeyframe writes a MP4 file with the init section of the
+// segment and a single fragment containing the keyframe.
+func WriteKeyframe(
+	w io.Writer,
+	segmentsDirs []string,
+	crypt *Crypt,
+	seg SegmentInfo,
+	kf Keyframe,
+) error {
+	if seg.Tier >= len(segmentsDirs) {
+		return fmt.Errorf("%w: %v", ErrInvalidTier, seg.Path)
+	}
+	if len(seg.Keyframes) == 0 {
+		return fmt.Errorf("%w: %v", ErrNoVideoSample, seg.Path)
+	}
+	file, err := OpenRecordingFile(filepath.Join(segmentsDirs[seg.Tier], seg.Path), crypt)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	init := make([]byte, seg.Keyframes[0].Offset)
+	if _, err := file.ReadAt(init, 0); err != nil {
+		return fmt.Errorf("read init: %w", err)
+	}
+	trackID, timescale, err := videoTrack(init)
+	if err != nil {
+		return err
+	}
+	sample, err := readKeyframeSample(file, kf.Offset, trackID)
+	if err != nil {
+		return fmt.Errorf("%v: %w", seg.Path, err)
+	}
+
+	if _, err := w.Write(init); err != nil {
+		return err
+	}
+	_, err = w.Write(scanFragment(1, trackID, 0, timescale, sample))
+	return err
+}
+
+// scaled converts the duration to the timescale of the scan.
+func (s *scanner) scaled(d time.Duration) uint64 {
+	return uint64(float64(d) / s.speed * float64(s.timescale) / float64(time.Second))
+}
+
+// videoTrack returns the ID and timescale of the first video track.
+func videoTrack(init []byte) (uint32, uint32, error) {
+	timescales, err := trackTimescales(init)
+	if err != nil {
+		return 0, 0, err
+	}
+	var trackID uint32
+	found := false
+	err = walkBoxes(init, func(typ string, moov []byte) error {
+		if typ != "moov" {
+			return nil
+		}
+		return walkBoxes(moov, func(typ string, trak []byte) error {
+			if typ != "trak" || found {
+				return nil
+			}
+			var id uint32
+			var isVideo bool
+			err := walkBoxes(trak, func(typ string, body []byte) error {
+				switch typ {
+				case "tkhd":
+					offset := 12
+					if len(body) != 0 && body[0] == 1 {
+						offset = 20
+					}
+					if len(body) < offset+4 {
+						return fmt.Errorf("%w: tkhd", ErrInvalidBox)
+					}
+					id = binary.BigEndian.Uint32(body[offset:])
+				case "mdia":
+					return walkBoxes(body, func(typ string, hdlr []byte) error {
+						if typ == "hdlr" && len(hdlr) >= 12 && string(hdlr[8:12]) == "vide" {
+							isVideo = true
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if isVideo {
+				trackID, found = id, true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found || timescales[trackID] == 0 {
+		return 0, 0, ErrNoVideoTrack
+	}
+	return trackID, timescales[trackID], nil
+}
+
+// readKeyframeSample reads the first video
+// sample of the fragment that starts at moofPos.
+func readKeyframeSample(file io.ReaderAt, moofPos int64, trackID uint32) ([]byte, error) {
+	offset, size, err := keyframeSample(file, moofPos, trackID)
+	if err != nil {
+		return nil, err
+	}
+	sample := make([]byte, size)
+	if _, err := file.ReadAt(sample, offset); err != nil {
+		return nil, fmt.Errorf("read sample: %w", err)
+	}
+	return sample, nil
+}
+
+// keyframeSample returns the file offset and size of the
+// first video sample in the fragment that starts at moofPos.
+func keyframeSample(file io.ReaderAt, moofPos int64, trackID uint32) (int64, int64, error) {
+	header := make([]byte, 8)
+	if _, err := file.ReadAt(header, moofPos); err != nil {
+		return 0, 0, fmt.Errorf("read moof header: %w", err)
+	}
+	size := int64(binary.BigEndian.Uint32(header))
+	if string(header[4:]) != "moof" || size < 8 {
+		return 0, 0, fmt.Errorf("%w: expected moof", ErrInvalidBox)
+	}
+	moof := make([]byte, size)
+	if _, err := file.ReadAt(moof, moofPos); err != nil {
+		return 0, 0, fmt.Errorf("read moof: %w", err)
+	}
+
+	found := false
+	var offset, sampleSize int64
+	err := walkBoxes(moof[8:], func(typ string, traf []byte) error {
+		if typ != "traf" || found {
+			return nil
+		}
+		base := moofPos
+		isTrack := false
+		return walkBoxes(traf, func(typ string, body []byte) error {
+			switch typ {
+			case "tfhd":
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfhd", ErrInvalidBox)
+				}
+				isTrack = binary.BigEndian.Uint32(body[4:]) == trackID
+				const baseDataOffsetPresent = 0x01
+				if body[3]&baseDataOffsetPresent != 0 && len(body) >= 16 {
+					base = int64(binary.BigEndian.Uint64(body[8:]))
+				}
+			case "trun":
+				if !isTrack {
+					return nil
+				}
+				var err error
+				offset, sampleSize, err = firstTrunSample(body)
+				if err != nil {
+					return err
+				}
+				offset += base
+				found = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return 0, 0, ErrNoVideoSample
+	}
+	return offset, sampleSize, nil
+}
+
+// firstTrunSample returns the data offset and the size of the first sample.
+func firstTrunSample(body []byte) (int64, int64, error) {
+	if len(body) < 8 {
+		return 0, 0, fmt.Errorf("%w: trun", ErrInvalidBox)
+	}
+	flags := uint32(body[1])<<16 | uint32(body[2])<<8 | uint32(body[3])
+	count := binary.BigEndian.Uint32(body[4:])
+	pos := 8
+
+	const (
+		dataOffsetPresent       = 0x01
+		firstSampleFlagsPresent = 0x04
+		durationPresent         = 0x100
+		sizePresent             = 0x200
+	)
+	if flags&sizePresent == 0 || count == 0 {
+		return 0, 0, ErrSampleMissing
+	}
+	var dataOffset int64
+	if flags&dataOffsetPresent != 0 {
+		if len(body) < pos+4 {
+			return 0, 0, fmt.Errorf("%w: trun", ErrInvalidBox)
+		}
+		dataOffset = int64(int32(binary.BigEndian.Uint32(body[pos:])))
+		pos += 4
+	}
+	if flags&firstSampleFlagsPresent != 0 {
+		pos += 4
+	}
+	if flags&durationPresent != 0 {
+		pos += 4
+	}
+	if len(body) < pos+4 {
+		return 0, 0, fmt.Errorf("%w: trun entry", ErrInvalidBox)
+	}
+	return dataOffset, int64(binary.BigEndian.Uint32(body[pos:])), nil
+}
+
+// scanFragment returns a moof and mdat box pair with a single sync sample.
+func scanFragment(sequence uint32, trackID uint32, decodeTime uint64, duration uint32, sample []byte) []byte {
+	const (
+		mfhdSize = 16
+		tfhdSize = 16
+		tfdtSize = 20
+		trunSize = 32
+		trafSize = 8 + tfhdSize + tfdtSize + trunSize
+		moofSize = 8 + mfhdSize + trafSize
+	)
+	buf := make([]byte, 0, moofSize+8+len(sample))
+	u32 := func(v uint32) {
+		buf = append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	box := func(size uint32, typ string) {
+		u32(size)
+		buf = append(buf, typ...)
+	}
+
+	box(moofSize, "moof")
+	box(mfhdSize, "mfhd")
+	u32(0) // Version and flags.
+	u32(sequence)
+
+	box(trafSize, "traf")
+	box(tfhdSize, "tfhd")
+	u32(0x020000) // Default base is moof.
+	u32(trackID)
+
+	box(tfdtSize, "tfdt")
+	u32(1 << 24) // Version 1.
+	u32(uint32(decodeTime >> 32))
+	u32(uint32(decodeTime))
+
+	box(trunSize, "trun")
+	u32(0x000701) // Data offset, duration, size and flags present.
+	u32(1)        // Sample count.
+	u32(moofSize + 8)
+	u32(duration)
+	u32(uint32(len(sample)))
+	u32(0x02000000) // Sync sample that doesn't depend on others.
+
+	box(uint32(8+len(sample)), "mdat")
+	return append(buf, sample...)
+}
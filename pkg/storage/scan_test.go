@@ -0,0 +1,126 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// scanDurations returns the first sample duration of each trun.
+func scanDurations(t *testing.T, buf []byte) []uint32 {
+	t.Helper()
+	var durations []uint32
+	err := walkBoxes(buf, func(typ string, body []byte) error {
+		if typ != "moof" {
+			return nil
+		}
+		return walkBoxes(body, func(typ string, traf []byte) error {
+			if typ != "traf" {
+				return nil
+			}
+			return walkBoxes(traf, func(typ string, body []byte) error {
+				if typ == "trun" {
+					durations = append(durations, binary.BigEndian.Uint32(body[12:]))
+				}
+				return nil
+			})
+		})
+	})
+	require.NoError(t, err)
+	return durations
+}
+
+func TestScanSegments(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		dir := t.TempDir()
+		segments := []SegmentInfo{
+			writeTestSegment(t, dir, "a.mp4", 100, 3),
+			writeTestSegment(t, dir, "b.mp4", 103, 3),
+		}
+
+		buf := &bytes.Buffer{}
+		err := ScanSegments(buf, []string{dir}, nil, segments, time.Unix(101, 0), time.Unix(104, 5e8), 8)
+		require.NoError(t, err)
+
+		// Starts with the init section of the first segment.
+		init, err := os.ReadFile(filepath.Join(dir, "a.mp4"))
+		require.NoError(t, err)
+		init = init[:segments[0].Keyframes[0].Offset]
+		require.Equal(t, init, buf.Bytes()[:len(init)])
+
+		times, payloads := exportedFragments(t, buf.Bytes())
+		require.Equal(t, []uint64{0, 11250, 22500, 33750}, times)
+		expected := [][]byte{
+			{100, 1},
+			{100, 2},
+			{103, 0},
+			{103, 1},
+		}
+		require.Equal(t, expected, payloads)
+		require.Equal(t, []uint32{11250, 11250, 11250, 11250}, scanDurations(t, buf.Bytes()))
+	})
+	t.Run("streamChanged", func(t *testing.T) {
+		dir := t.TempDir()
+		segments := []SegmentInfo{
+			writeTestSegment(t, dir, "a.mp4", 100, 1),
+			writeTestSegment(t, dir, "b.mp4", 101, 1),
+		}
+		path := filepath.Join(dir, "b.mp4")
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		raw[20]++
+		require.NoError(t, os.WriteFile(path, raw, 0o600))
+
+		err = ScanSegments(&bytes.Buffer{}, []string{dir}, nil, segments, time.Unix(100, 0), time.Unix(102, 0), 8)
+		require.ErrorIs(t, err, ErrExportStreamChanged)
+	})
+	t.Run("invalidSpeed", func(t *testing.T) {
+		err := ScanSegments(&bytes.Buffer{}, nil, nil, nil, time.Unix(0, 0), time.Unix(1, 0), 1)
+		require.ErrorIs(t, err, ErrInvalidSpeed)
+
+		err = ScanSegments(&bytes.Buffer{}, nil, nil, nil, time.Unix(0, 0), time.Unix(1, 0), math.NaN())
+		require.ErrorIs(t, err, ErrInvalidSpeed)
+	})
+	t.Run("noKeyframes", func(t *testing.T) {
+		dir := t.TempDir()
+		segments := []SegmentInfo{writeTestSegment(t, dir, "a.mp4", 100, 1)}
+		err := ScanSegments(&bytes.Buffer{}, []string{dir}, nil, segments, time.Unix(200, 0), time.Unix(201, 0), 8)
+		require.ErrorIs(t, err, ErrExportNoSegments)
+	})
+}
+
+func TestNearestKeyframe(t *testing.T) {
+	at := func(ms int64) time.Time { return time.UnixMilli(ms) }
+	segments := []SegmentInfo{
+		{Path: "a", Keyframes: []Keyframe{{Time: at(0)}, {Time: at(1000)}}},
+		{Path: "b", Keyframes: []Keyframe{{Time: at(2000), Offset: 1}}},
+	}
+	seg, kf, found := NearestKeyframe(segments, at(1600))
+	require.True(t, found)
+	require.Equal(t, "b", seg.Path)
+	require.Equal(t, Keyframe{Time: at(2000), Offset: 1}, kf)
+
+	_, _, found = NearestKeyframe(nil, at(0))
+	require.False(t, found)
+}
@@ -0,0 +1,179 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix prefix of the environment variables that override env.yaml.
+const EnvPrefix = "NVR_"
+
+// Secret errors.
+var (
+	ErrSecretNotFound      = errors.New("secret not found")
+	ErrSecretUnterminated  = errors.New("unterminated secret reference")
+	ErrInvalidEnvOverride  = errors.New("invalid environment override")
+	errUnsupportedEnvField = errors.New("unsupported field type")
+)
+
+// LookupSecret returns the value of the environment variable or the
+// content of the file in name+"_FILE". The trailing newline of the
+// file is removed. The variable takes precedence over the file.
+func LookupSecret(name string) (string, bool, error) {
+	if value, exist := os.LookupEnv(name); exist {
+		return value, true, nil
+	}
+	path, exist := os.LookupEnv(name + "_FILE")
+	if !exist {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%v_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// ExpandSecrets replaces "${NAME}" references with the secret from
+// LookupSecret. "$${" is replaced with a literal "${".
+func ExpandSecrets(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i == -1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end == -1 {
+			return "", ErrSecretUnterminated
+		}
+		name := s[i+2 : i+end]
+		value, exist, err := LookupSecret(name)
+		if err != nil {
+			return "", err
+		}
+		if !exist {
+			return "", fmt.Errorf("%w: %v", ErrSecretNotFound, name)
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// EnvVarName converts camel case config keys to a environment variable
+// name, "acmeDNSHook" becomes "NVR_ACME_DNS_HOOK". The parts are joined.
+func EnvVarName(parts ...string) string {
+	for i, part := range parts {
+		parts[i] = upperSnakeCase(part)
+	}
+	return EnvPrefix + strings.Join(parts, "_")
+}
+
+func upperSnakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteRune('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// applyEnvOverrides sets the env.yaml fields from the environment,
+// "storageDir" is set by NVR_STORAGE_DIR or NVR_STORAGE_DIR_FILE.
+// Lists are comma separated.
+func applyEnvOverrides(env *ConfigEnv) error {
+	v := reflect.ValueOf(env).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("yaml")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := EnvVarName(key)
+		value, exist, err := LookupSecret(name)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			continue
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%w: %v: %v", ErrInvalidEnvOverride, name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() { //nolint:exhaustive
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return errUnsupportedEnvField
+	}
+	return nil
+}
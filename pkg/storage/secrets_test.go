@@ -0,0 +1,125 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnvVarName(t *testing.T) {
+	cases := map[string]string{
+		"port":             "NVR_PORT",
+		"storageDir":       "NVR_STORAGE_DIR",
+		"acmeDNSHook":      "NVR_ACME_DNS_HOOK",
+		"tlsPort":          "NVR_TLS_PORT",
+		"rtspRestreamPort": "NVR_RTSP_RESTREAM_PORT",
+		"webrtcPort":       "NVR_WEBRTC_PORT",
+		"hls2":             "NVR_HLS2",
+		"front-door":       "NVR_FRONT_DOOR",
+	}
+	for input, expected := range cases {
+		t.Run(input, func(t *testing.T) {
+			require.Equal(t, expected, EnvVarName(input))
+		})
+	}
+	require.Equal(t, "NVR_MONITOR_GARAGE_MAIN_INPUT", EnvVarName("monitor", "garage", "mainInput"))
+}
+
+func TestLookupSecret(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("b\n"), 0o600))
+
+	t.Setenv("NVR_TEST_A", "a")
+	t.Setenv("NVR_TEST_B_FILE", secretPath)
+	t.Setenv("NVR_TEST_C_FILE", filepath.Join(t.TempDir(), "nil"))
+
+	value, exist, err := LookupSecret("NVR_TEST_A")
+	require.NoError(t, err)
+	require.True(t, exist)
+	require.Equal(t, "a", value)
+
+	value, exist, err = LookupSecret("NVR_TEST_B")
+	require.NoError(t, err)
+	require.True(t, exist)
+	require.Equal(t, "b", value)
+
+	_, exist, err = LookupSecret("NVR_TEST_NIL")
+	require.NoError(t, err)
+	require.False(t, exist)
+
+	_, _, err = LookupSecret("NVR_TEST_C")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestExpandSecrets(t *testing.T) {
+	t.Setenv("NVR_TEST_PASS", "secret")
+
+	cases := map[string]struct {
+		input       string
+		expected    string
+		expectedErr error
+	}{
+		"none":         {"rtsp://x", "rtsp://x", nil},
+		"reference":    {"rtsp://admin:${NVR_TEST_PASS}@x", "rtsp://admin:secret@x", nil},
+		"multiple":     {"${NVR_TEST_PASS}${NVR_TEST_PASS}", "secretsecret", nil},
+		"escaped":      {"a$${NVR_TEST_PASS}", "a${NVR_TEST_PASS}", nil},
+		"dollar":       {"a$b", "a$b", nil},
+		"notFound":     {"${NVR_TEST_NIL}", "", ErrSecretNotFound},
+		"unterminated": {"${NVR_TEST_PASS", "", ErrSecretUnterminated},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ExpandSecrets(tc.input)
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	envPath, _, cancel := newTestEnv(t)
+	defer cancel()
+	homeDir := filepath.Dir(filepath.Dir(envPath))
+
+	envYAML, err := yaml.Marshal(ConfigEnv{
+		Port:      1,
+		GoBin:     filepath.Join(homeDir, "go"),
+		FFmpegBin: filepath.Join(homeDir, "ffmpeg"),
+	})
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		t.Setenv("NVR_PORT", "3000")
+		t.Setenv("NVR_PROFILING", "true")
+		t.Setenv("NVR_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.0.0/16")
+
+		env, err := NewConfigEnv(envPath, envYAML)
+		require.NoError(t, err)
+		require.Equal(t, 3000, env.Port)
+		require.True(t, env.Profiling)
+		require.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, env.TrustedProxies)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("NVR_PORT", "a")
+		_, err := NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrInvalidEnvOverride)
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"nvr/pkg/log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,19 +37,35 @@ import (
 type Manager struct {
 	storageDir   string
 	storageDirFS fs.FS
+	archive      Archive
 	disk         *disk
+	index        *Index
+	events       *EventStore
+	retention    RetentionFunc
 	removeAll    func(string) error
 
 	logger log.ILogger
 }
 
 // NewManager returns new manager.
-func NewManager(storageDir string, general *ConfigGeneral, log log.ILogger) *Manager {
+func NewManager(
+	storageDir string,
+	archive Archive,
+	general *ConfigGeneral,
+	index *Index,
+	events *EventStore,
+	retention RetentionFunc,
+	log log.ILogger,
+) *Manager {
 	storageDirFS := os.DirFS(storageDir)
 	return &Manager{
 		storageDir:   storageDir,
 		storageDirFS: storageDirFS,
+		archive:      archive,
 		disk:         newDisk(general, storageDirFS),
+		index:        index,
+		events:       events,
+		retention:    retention,
 		removeAll:    os.RemoveAll,
 
 		logger: log,
@@ -60,6 +77,11 @@ func (s *Manager) RecordingsDir() string {
 	return filepath.Join(s.storageDir, "recordings")
 }
 
+// SegmentsDir Returns path to continuous recording segments diectory.
+func (s *Manager) SegmentsDir() string {
+	return filepath.Join(s.storageDir, "segments")
+}
+
 // DiskUsageCached returns cached value and its age.
 func (s *Manager) DiskUsageCached() (DiskUsage, time.Duration) {
 	return s.disk.usageCached()
@@ -82,49 +104,125 @@ func (s *Manager) prune() error {
 		return nil
 	}
 
-	const dayDepth = 3
+	if err := s.pruneSegments(); err != nil {
+		return fmt.Errorf("prune segments: %w", err)
+	}
 
 	// Find the oldest day.
-	path := s.RecordingsDir()
-	for depth := 1; depth <= dayDepth; depth++ {
-		list, err := fs.ReadDir(os.DirFS(path), ".")
-		if err != nil {
-			return fmt.Errorf("read directory %v: %w", path, err)
-		}
+	path, err := s.oldestPrunableDay(s.RecordingsDir(), 0)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return nil
+	}
 
-		isDirEmpty := len(list) == 0
-		if isDirEmpty {
-			// Don't delete the recordings directory.
-			if depth == 1 {
-				return nil
-			}
+	s.logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "app",
+		Msg:   fmt.Sprintf("pruning storage: deleting %q", path),
+	})
 
-			if err := s.removeAll(path); err != nil {
-				return fmt.Errorf("remove empty directory: %w", err)
-			}
+	// Delete all files from that day
+	if _, err := s.removeDay(path); err != nil {
+		return err
+	}
+	return nil
+}
 
-			path = s.RecordingsDir()
-			depth = 0
+// oldestPrunableDay returns the oldest day directory that contains
+// recordings that aren't flagged. Empty directories are removed.
+func (s *Manager) oldestPrunableDay(dir string, depth int) (string, error) {
+	const dayDepth = 3
+
+	list, err := fs.ReadDir(os.DirFS(dir), ".")
+	if err != nil {
+		return "", fmt.Errorf("read directory %v: %w", dir, err)
+	}
+	for _, entry := range list {
+		path := filepath.Join(dir, entry.Name())
+		if depth == dayDepth-1 {
+			hasFlagged, hasUnflagged, err := dayFlagged(path)
+			if err != nil {
+				return "", err
+			}
+			if hasUnflagged || !hasFlagged {
+				return path, nil
+			}
 			continue
 		}
+		day, err := s.oldestPrunableDay(path, depth+1)
+		if err != nil || day != "" {
+			return day, err
+		}
+	}
 
-		firstFile := list[0].Name()
-		path = filepath.Join(path, firstFile)
+	// Don't delete the recordings directory.
+	if depth == 0 {
+		return "", nil
 	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		if err := s.removeAll(dir); err != nil {
+			return "", fmt.Errorf("remove empty directory: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// pruneSegments deletes all continuous recording
+// segments from the oldest day in the index.
+func (s *Manager) pruneSegments() error {
+	if s.index == nil {
+		return nil
+	}
+	oldest, exist := s.index.Oldest(0)
+	if !exist {
+		return nil
+	}
+	year, month, day := oldest.Start.Date()
+	dayEnd := time.Date(year, month, day+1, 0, 0, 0, 0, oldest.Start.Location())
 
 	s.logger.Log(log.Entry{
 		Level: log.LevelInfo,
 		Src:   "app",
-		Msg:   fmt.Sprintf("pruning storage: deleting %q", path),
+		Msg:   fmt.Sprintf("pruning storage: deleting segments before %v", dayEnd),
 	})
 
-	// Delete all files from that day
-	if err := s.removeAll(path); err != nil {
-		return fmt.Errorf("remove directory: %w", err)
+	removed, err := s.index.RemoveBefore(dayEnd, 0)
+	if err != nil {
+		return err
+	}
+	return s.removeSegmentFiles(removed)
+}
+
+// removeSegmentFiles deletes the files of segments
+// that have been removed from the index.
+func (s *Manager) removeSegmentFiles(segments []SegmentInfo) error {
+	dirs := s.segmentsDirs()
+	for _, seg := range segments {
+		if seg.Tier >= len(dirs) {
+			return fmt.Errorf("%w: %v", ErrInvalidTier, seg.Path)
+		}
+		path := filepath.Join(dirs[seg.Tier], seg.Path)
+		if err := s.removeAll(path); err != nil {
+			return fmt.Errorf("remove segment: %w", err)
+		}
+		removeEmptyParents(dirs[seg.Tier], filepath.Dir(path))
 	}
 	return nil
 }
 
+// removeEmptyParents removes dir and its parents until
+// a directory isn't empty or the base directory is reached.
+func removeEmptyParents(base string, dir string) {
+	for dir != base && strings.HasPrefix(dir, base) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
 // PurgeLoop runs Purge on an interval until context is canceled.
 func (s *Manager) PurgeLoop(ctx context.Context, duration time.Duration) {
 	for {
@@ -132,6 +230,20 @@ func (s *Manager) PurgeLoop(ctx context.Context, duration time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-time.After(duration):
+			if err := s.archiveOld(time.Now()); err != nil {
+				s.logger.Log(log.Entry{
+					Level: log.LevelError,
+					Src:   "app",
+					Msg:   fmt.Sprintf("could not archive recordings: %v", err),
+				})
+			}
+			if err := s.applyRetention(time.Now()); err != nil {
+				s.logger.Log(log.Entry{
+					Level: log.LevelError,
+					Src:   "app",
+					Msg:   fmt.Sprintf("could not apply retention policies: %v", err),
+				})
+			}
 			if err := s.prune(); err != nil {
 				s.logger.Log(log.Entry{
 					Level: log.LevelError,
@@ -298,6 +410,101 @@ type ConfigEnv struct {
 	StorageDir string `yaml:"storageDir"`
 	TempDir    string
 
+	// Live segments are moved to HLSSpillDir when the combined
+	// size of all in-memory segments exceeds HLSMemoryBudget in MB.
+	HLSMemoryBudget int    `yaml:"hlsMemoryBudget"`
+	HLSSpillDir     string `yaml:"hlsSpillDir"`
+
+	// Common encryption scheme of the live fMP4
+	// samples, "cenc" or "cbcs". Empty to disable.
+	HLSEncryption string `yaml:"hlsEncryption"`
+
+	// UDP port used by WebRTC live streams, 0 disables WebRTC.
+	// WebRTCAdditionalHosts are advertised to clients in addition to the
+	// local addresses, required if the NVR is behind NAT or in a container.
+	WebRTCPort            int      `yaml:"webrtcPort"`
+	WebRTCAdditionalHosts []string `yaml:"webrtcAdditionalHosts"`
+
+	// TCP port of the authenticated RTSP restream server,
+	// 0 disables the restream server. Readers use the
+	// same credentials as the web interface.
+	RTSPRestreamPort int `yaml:"rtspRestreamPort"`
+
+	// TCP port of the RTMP ingest server, 0 disables RTMP.
+	RTMPPort int `yaml:"rtmpPort"`
+
+	// Path prefix when the app is served under a sub-path, "/nvr".
+	// Forwarding headers are only trusted from TrustedProxies,
+	// IP addresses or CIDRs, defaults to the loopback addresses.
+	BasePath       string   `yaml:"basePath"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// TCP port of the built in HTTPS server, 0 disables it. TLSCertFile
+	// and TLSKeyFile are served and reloaded when they change, otherwise
+	// certificates for ACMEDomains are obtained from ACMEDirectory, Let's
+	// Encrypt by default. DNS-01 challenges are published by ACMEDNSHook
+	// if set, the HTTP-01 challenge is answered on the main port.
+	TLSPort       int      `yaml:"tlsPort"`
+	TLSCertFile   string   `yaml:"tlsCertFile"`
+	TLSKeyFile    string   `yaml:"tlsKeyFile"`
+	ACMEDomains   []string `yaml:"acmeDomains"`
+	ACMEEmail     string   `yaml:"acmeEmail"`
+	ACMEDirectory string   `yaml:"acmeDirectory"`
+	ACMEDNSHook   string   `yaml:"acmeDNSHook"`
+
+	// Recordings and segments older than ArchiveAfterDays
+	// are moved to ArchiveDir. Empty to disable.
+	ArchiveDir       string `yaml:"archiveDir"`
+	ArchiveAfterDays int    `yaml:"archiveAfterDays"`
+
+	// Encrypt event recordings and continuous segments with the key in
+	// RecordingKeyPath. Crypt is loaded by the app, nil if disabled.
+	EncryptRecordings bool   `yaml:"encryptRecordings"`
+	Crypt             *Crypt `yaml:"-"`
+
+	// Sign the integrity ledger entries with the key in IntegrityKeyPath.
+	SignRecordings bool `yaml:"signRecordings"`
+
+	// Size of the on-demand thumbnail cache in MB.
+	ThumbnailCacheSize int `yaml:"thumbnailCacheSize"`
+
+	// Disk health thresholds in percent of the space or inodes of the
+	// storage disk. A warning is logged above DiskWarnPercent and the
+	// continuous recording of low priority monitors is paused above
+	// DiskPausePercent. The SMART status of SMARTDevice is checked if set.
+	DiskWarnPercent  int    `yaml:"diskWarnPercent"`
+	DiskPausePercent int    `yaml:"diskPausePercent"`
+	SMARTDevice      string `yaml:"smartDevice"`
+
+	// Serve the pprof profiles and runtime
+	// trace under "/debug/pprof/" to admins.
+	Profiling bool `yaml:"profiling"`
+
+	// Format of the logs printed to stdout and LogFile, "text" or "json".
+	// LogFile is rotated when it's larger than LogFileMaxSize in MB or
+	// older than LogFileMaxAge in hours, LogFileBackups files are kept.
+	// LogLevels sets the initial maximum level of each log source.
+	LogFormat      string            `yaml:"logFormat"`
+	LogFile        string            `yaml:"logFile"`
+	LogFileMaxSize int               `yaml:"logFileMaxSize"`
+	LogFileMaxAge  int               `yaml:"logFileMaxAge"`
+	LogFileBackups int               `yaml:"logFileBackups"`
+	LogLevels      map[string]string `yaml:"logLevels"`
+
+	// Seconds to wait for the recordings to be finalized on shutdown.
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+
+	// Login sessions expire SessionLifetime hours after the login or
+	// SessionIdleTimeout minutes after the last request, -1 disables
+	// the idle timeout.
+	SessionLifetime    int `yaml:"sessionLifetime"`
+	SessionIdleTimeout int `yaml:"sessionIdleTimeout"`
+
+	// Number of segments that exports read ahead into memory, -1
+	// disables prefetching. Size of the prefetch cache in MB.
+	SegmentPrefetch          int `yaml:"segmentPrefetch"`
+	SegmentPrefetchCacheSize int `yaml:"segmentPrefetchCacheSize"`
+
 	HomeDir   string `yaml:"homeDir"`
 	ConfigDir string
 }
@@ -305,6 +512,24 @@ type ConfigEnv struct {
 // ErrPathNotAbsolute path is not absolute.
 var ErrPathNotAbsolute = errors.New("path is not absolute")
 
+// ErrInvalidSMARTDevice invalid SMART device.
+var ErrInvalidSMARTDevice = errors.New("device must be in /dev/")
+
+// ErrInvalidHLSEncryption invalid HLS encryption scheme.
+var ErrInvalidHLSEncryption = errors.New("must be 'cenc', 'cbcs' or empty")
+
+// ErrInvalidLogFormat invalid log format.
+var ErrInvalidLogFormat = errors.New("must be 'text' or 'json'")
+
+// ErrInvalidBasePath invalid base path.
+var ErrInvalidBasePath = errors.New("must be a path like '/nvr'")
+
+// ErrInvalidSessionLifetime invalid session lifetime.
+var ErrInvalidSessionLifetime = errors.New("must be positive")
+
+// ErrTLSNoCertificate TLS port without certificate.
+var ErrTLSNoCertificate = errors.New("tlsCertFile and tlsKeyFile or acmeDomains must be set")
+
 // NewConfigEnv return new environment configuration.
 func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	var env ConfigEnv
@@ -312,6 +537,9 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if err := yaml.Unmarshal(envYAML, &env); err != nil {
 		return nil, fmt.Errorf("unmarshal env.yaml: %w", err)
 	}
+	if err := applyEnvOverrides(&env); err != nil {
+		return nil, err
+	}
 
 	env.ConfigDir = filepath.Dir(envPath)
 	env.TempDir = filepath.Join(os.TempDir(), "nvr")
@@ -337,6 +565,9 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if env.StorageDir == "" {
 		env.StorageDir = filepath.Join(env.HomeDir, "storage")
 	}
+	if env.HLSSpillDir == "" {
+		env.HLSSpillDir = filepath.Join(env.TempDir, "hls")
+	}
 
 	if !dirExist(env.GoBin) {
 		return nil, fmt.Errorf("goBin '%v': %w", env.GoBin, os.ErrNotExist)
@@ -357,6 +588,91 @@ func NewConfigEnv(envPath string, envYAML []byte) (*ConfigEnv, error) {
 	if !filepath.IsAbs(env.StorageDir) {
 		return nil, fmt.Errorf("StorageDir '%v': %w", env.StorageDir, ErrPathNotAbsolute)
 	}
+	if !filepath.IsAbs(env.HLSSpillDir) {
+		return nil, fmt.Errorf("hlsSpillDir '%v': %w", env.HLSSpillDir, ErrPathNotAbsolute)
+	}
+	if env.ArchiveDir != "" && !filepath.IsAbs(env.ArchiveDir) {
+		return nil, fmt.Errorf("archiveDir '%v': %w", env.ArchiveDir, ErrPathNotAbsolute)
+	}
+	if env.ArchiveAfterDays == 0 {
+		env.ArchiveAfterDays = 7
+	}
+	if env.ThumbnailCacheSize == 0 {
+		env.ThumbnailCacheSize = 100
+	}
+	if env.DiskWarnPercent == 0 {
+		env.DiskWarnPercent = 90
+	}
+	if env.DiskPausePercent == 0 {
+		env.DiskPausePercent = 95
+	}
+	if env.SMARTDevice != "" && !strings.HasPrefix(env.SMARTDevice, "/dev/") {
+		return nil, fmt.Errorf("smartDevice '%v': %w", env.SMARTDevice, ErrInvalidSMARTDevice)
+	}
+
+	if env.BasePath != "" {
+		env.BasePath = "/" + strings.Trim(env.BasePath, "/")
+		if env.BasePath == "/" {
+			env.BasePath = ""
+		}
+		if strings.ContainsAny(env.BasePath, "?#") {
+			return nil, fmt.Errorf("basePath '%v': %w", env.BasePath, ErrInvalidBasePath)
+		}
+	}
+
+	if env.TLSPort != 0 {
+		hasFiles := env.TLSCertFile != "" && env.TLSKeyFile != ""
+		if !hasFiles && len(env.ACMEDomains) == 0 {
+			return nil, fmt.Errorf("tlsPort: %w", ErrTLSNoCertificate)
+		}
+	}
+	if env.ACMEDNSHook != "" && !filepath.IsAbs(env.ACMEDNSHook) {
+		return nil, fmt.Errorf("acmeDNSHook '%v': %w", env.ACMEDNSHook, ErrPathNotAbsolute)
+	}
+
+	switch env.HLSEncryption {
+	case "", "cenc", "cbcs":
+	default:
+		return nil, fmt.Errorf("hlsEncryption '%v': %w", env.HLSEncryption, ErrInvalidHLSEncryption)
+	}
+
+	switch env.LogFormat {
+	case "":
+		env.LogFormat = "text"
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("logFormat '%v': %w", env.LogFormat, ErrInvalidLogFormat)
+	}
+	if env.LogFile != "" && !filepath.IsAbs(env.LogFile) {
+		return nil, fmt.Errorf("logFile '%v': %w", env.LogFile, ErrPathNotAbsolute)
+	}
+	if env.LogFileMaxSize == 0 {
+		env.LogFileMaxSize = 10
+	}
+	if env.LogFileMaxAge == 0 {
+		env.LogFileMaxAge = 24
+	}
+	if env.LogFileBackups == 0 {
+		env.LogFileBackups = 5
+	}
+	if env.ShutdownTimeout == 0 {
+		env.ShutdownTimeout = 30
+	}
+	if env.SessionLifetime == 0 {
+		env.SessionLifetime = 24 * 7
+	}
+	if env.SessionIdleTimeout == 0 {
+		env.SessionIdleTimeout = 24 * 60
+	}
+	if env.SegmentPrefetch == 0 {
+		env.SegmentPrefetch = 2
+	}
+	if env.SegmentPrefetchCacheSize == 0 {
+		env.SegmentPrefetchCacheSize = 64
+	}
+	if env.SessionLifetime < 0 {
+		return nil, fmt.Errorf("sessionLifetime '%v': %w", env.SessionLifetime, ErrInvalidSessionLifetime)
+	}
 
 	return &env, nil
 }
@@ -366,6 +682,85 @@ func (env ConfigEnv) RecordingsDir() string {
 	return filepath.Join(env.StorageDir, "recordings")
 }
 
+// SegmentsDir return continuous recording segments directory.
+func (env ConfigEnv) SegmentsDir() string {
+	return filepath.Join(env.StorageDir, "segments")
+}
+
+// RecordingsDirs return the recordings directories of all storage tiers.
+func (env ConfigEnv) RecordingsDirs() []string {
+	return env.Archive().dirs(env.StorageDir, "recordings")
+}
+
+// SegmentsDirs return the segments directories of all storage tiers.
+// The index of the directory is the tier of the segment.
+func (env ConfigEnv) SegmentsDirs() []string {
+	return env.Archive().dirs(env.StorageDir, "segments")
+}
+
+// Archive returns the archive storage tier.
+func (env ConfigEnv) Archive() Archive {
+	return Archive{
+		Dir:   env.ArchiveDir,
+		After: time.Duration(env.ArchiveAfterDays) * 24 * time.Hour,
+	}
+}
+
+// SessionDurations returns the session lifetime and idle timeout,
+// the idle timeout is zero if it's disabled.
+func (env ConfigEnv) SessionDurations() (time.Duration, time.Duration) {
+	lifetime := time.Duration(env.SessionLifetime) * time.Hour
+	if env.SessionIdleTimeout < 0 {
+		return lifetime, 0
+	}
+	return lifetime, time.Duration(env.SessionIdleTimeout) * time.Minute
+}
+
+// IndexPath return path to the segment index database.
+func (env ConfigEnv) IndexPath() string {
+	return filepath.Join(env.StorageDir, "index.db")
+}
+
+// EventsPath return path to the event store database.
+func (env ConfigEnv) EventsPath() string {
+	return filepath.Join(env.StorageDir, "events.db")
+}
+
+// BookmarksPath return path to the bookmarks file.
+func (env ConfigEnv) BookmarksPath() string {
+	return filepath.Join(env.StorageDir, "bookmarks.json")
+}
+
+// CertsDir return directory of the ACME certificates and account keys.
+func (env ConfigEnv) CertsDir() string {
+	return filepath.Join(env.ConfigDir, "certs")
+}
+
+// RecordingKeyPath return path to the recording encryption key.
+func (env ConfigEnv) RecordingKeyPath() string {
+	return filepath.Join(env.ConfigDir, "recording.key")
+}
+
+// IntegrityDir return integrity ledger directory.
+func (env ConfigEnv) IntegrityDir() string {
+	return filepath.Join(env.StorageDir, "integrity")
+}
+
+// IntegrityKeyPath return path to the integrity ledger signing key.
+func (env ConfigEnv) IntegrityKeyPath() string {
+	return filepath.Join(env.ConfigDir, "integrity.key")
+}
+
+// ThumbnailsDir return on-demand thumbnail cache directory.
+func (env ConfigEnv) ThumbnailsDir() string {
+	return filepath.Join(env.StorageDir, "thumbnails")
+}
+
+// TimelapsesDir return timelapse video directory.
+func (env ConfigEnv) TimelapsesDir() string {
+	return filepath.Join(env.StorageDir, "timelapses")
+}
+
 // PrepareEnvironment prepares directories.
 func (env ConfigEnv) PrepareEnvironment() error {
 	err := os.MkdirAll(env.RecordingsDir(), 0o700)
@@ -457,6 +852,29 @@ func (general *ConfigGeneral) Set(newConfig map[string]string) error {
 	return nil
 }
 
+// Reload reads the config file again. Returns
+// true if the config changed since it was read.
+func (general *ConfigGeneral) Reload() (bool, error) {
+	defer general.mu.Unlock()
+	general.mu.Lock()
+
+	file, err := os.ReadFile(general.path)
+	if err != nil {
+		return false, err
+	}
+
+	config := map[string]string{}
+	if err := json.Unmarshal(file, &config); err != nil {
+		return false, fmt.Errorf("unmarshal general config: %w", err)
+	}
+	if reflect.DeepEqual(config, general.Config) {
+		return false, nil
+	}
+
+	general.Config = config
+	return true, nil
+}
+
 // DiskSpace returns configured disk space in bytes.
 func (general *ConfigGeneral) DiskSpace() (int64, error) {
 	defer general.mu.Unlock()
@@ -477,7 +895,8 @@ func (general *ConfigGeneral) DiskSpace() (int64, error) {
 }
 
 // DeleteRecording delete a recording by ID.
-// Will return os.ErrNotExist if the recording doesn't exists.
+// Will return os.ErrNotExist if the recording doesn't exists
+// and ErrRecordingFlagged if the recording is flagged.
 func DeleteRecording(recordingsDir, recID string) error {
 	// RecordingIDToPath will validate the ID.
 	recPath, err := RecordingIDToPath(recID)
@@ -487,6 +906,9 @@ func DeleteRecording(recordingsDir, recID string) error {
 
 	fullRecPath := filepath.Join(recordingsDir, recPath)
 	recDir := filepath.Dir(fullRecPath)
+	if IsRecordingFlagged(fullRecPath) {
+		return fmt.Errorf("%w: %v", ErrRecordingFlagged, recID)
+	}
 
 	var returnedError error
 	recordingExists := false
@@ -243,6 +243,88 @@ func TestPurge(t *testing.T) {
 			})
 		}
 	})
+	t.Run("segments", func(t *testing.T) {
+		tempDir := t.TempDir()
+		index, err := OpenIndex(filepath.Join(tempDir, "index.db"))
+		require.NoError(t, err)
+		defer index.Close()
+
+		m := &Manager{
+			storageDir: tempDir,
+			disk: &disk{
+				storageDirFS:   os.DirFS(tempDir),
+				general:        diskSpace1,
+				diskUsageBytes: highUsage,
+			},
+			index:     index,
+			removeAll: os.RemoveAll,
+			logger:    log.NewDummyLogger(),
+		}
+		require.NoError(t, os.Mkdir(m.RecordingsDir(), 0o700))
+
+		day1 := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+		day2 := day1.Add(24 * time.Hour)
+		for _, seg := range []SegmentInfo{
+			{MonitorID: "m1", Path: "2000/01/01/m1/a.mp4", Start: day1, End: day1.Add(time.Minute)},
+			{MonitorID: "m2", Path: "2000/01/01/m2/b.mp4", Start: day1, End: day1.Add(time.Minute)},
+			{MonitorID: "m1", Path: "2000/01/02/m1/c.mp4", Start: day2, End: day2.Add(time.Minute)},
+		} {
+			path := filepath.Join(m.SegmentsDir(), seg.Path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+			require.NoError(t, os.WriteFile(path, nil, 0o600))
+			require.NoError(t, index.Add(seg))
+		}
+
+		require.NoError(t, m.prune())
+
+		_, err = os.Stat(filepath.Join(m.SegmentsDir(), "2000/01/01"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Stat(filepath.Join(m.SegmentsDir(), "2000/01/02/m1/c.mp4"))
+		require.NoError(t, err)
+
+		oldest, exist := index.Oldest(0)
+		require.True(t, exist)
+		require.Equal(t, "2000/01/02/m1/c.mp4", oldest.Path)
+	})
+	t.Run("flagged", func(t *testing.T) {
+		tempDir := t.TempDir()
+		m := &Manager{
+			storageDir: tempDir,
+			disk: &disk{
+				storageDirFS:   os.DirFS(tempDir),
+				general:        diskSpace1,
+				diskUsageBytes: highUsage,
+			},
+			removeAll: os.RemoveAll,
+			logger:    log.NewDummyLogger(),
+		}
+		day1 := filepath.Join(m.RecordingsDir(), "2000", "01", "01", "m1")
+		day2 := filepath.Join(m.RecordingsDir(), "2000", "01", "02", "m1")
+		require.NoError(t, os.MkdirAll(day1, 0o700))
+		require.NoError(t, os.MkdirAll(day2, 0o700))
+		createFiles(t, day1, []string{
+			"2000-01-01_01-00-00_m1.flag",
+			"2000-01-01_01-00-00_m1.json",
+			"2000-01-01_01-00-00_m1.mp4",
+			"2000-01-01_02-00-00_m1.json",
+			"2000-01-01_02-00-00_m1.mp4",
+		})
+		createFiles(t, day2, []string{"2000-01-02_01-00-00_m1.mp4"})
+
+		require.NoError(t, m.prune())
+		require.Equal(t, []string{
+			"2000-01-01_01-00-00_m1.flag",
+			"2000-01-01_01-00-00_m1.json",
+			"2000-01-01_01-00-00_m1.mp4",
+		}, listDirectory(t, day1))
+		require.Equal(t, []string{"2000-01-02_01-00-00_m1.mp4"}, listDirectory(t, day2))
+
+		// The day only contains flagged recordings and is skipped.
+		require.NoError(t, m.prune())
+		require.Len(t, listDirectory(t, day1), 3)
+		_, err := os.Stat(day2)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
 	t.Run("usageErr", func(t *testing.T) {
 		m := &Manager{
 			storageDirFS: recordingTestFS,
@@ -390,8 +472,52 @@ func newTestEnv(t *testing.T) (string, *ConfigEnv, func()) {
 		FFmpegBin:  ffmpegBin,
 		StorageDir: filepath.Join(homeDir, "storage"),
 		TempDir:    filepath.Join(homeDir, "nvr"),
-		HomeDir:    homeDir,
-		ConfigDir:  configDir,
+
+		HLSMemoryBudget: 100,
+		HLSSpillDir:     filepath.Join(homeDir, "spill"),
+
+		WebRTCPort:            2023,
+		WebRTCAdditionalHosts: []string{"192.168.1.2"},
+
+		RTSPRestreamPort: 8554,
+		RTMPPort:         1935,
+
+		BasePath:       "/nvr",
+		TrustedProxies: []string{"10.0.0.0/8"},
+
+		TLSPort:     2443,
+		ACMEDomains: []string{"nvr.example.com"},
+		ACMEEmail:   "admin@example.com",
+		ACMEDNSHook: filepath.Join(homeDir, "dns-hook"),
+
+		ArchiveDir:       filepath.Join(homeDir, "archive"),
+		ArchiveAfterDays: 30,
+
+		ThumbnailCacheSize: 50,
+
+		DiskWarnPercent:  80,
+		DiskPausePercent: 90,
+		SMARTDevice:      "/dev/sda",
+
+		Profiling: true,
+
+		LogFormat:      "json",
+		LogFile:        filepath.Join(homeDir, "nvr.log"),
+		LogFileMaxSize: 20,
+		LogFileMaxAge:  48,
+		LogFileBackups: 3,
+		LogLevels:      map[string]string{"recorder": "info"},
+
+		ShutdownTimeout: 60,
+
+		SessionLifetime:    24,
+		SessionIdleTimeout: -1,
+
+		SegmentPrefetch:          4,
+		SegmentPrefetchCacheSize: 128,
+
+		HomeDir:   homeDir,
+		ConfigDir: configDir,
 	}
 
 	return envPath, env, cancelFunc
@@ -421,8 +547,37 @@ func TestNewConfigEnv(t *testing.T) {
 			FFmpegBin:  filepath.Join(homeDir, "ffmpeg"),
 			StorageDir: filepath.Join(homeDir, "storage"),
 			TempDir:    env.TempDir,
-			HomeDir:    homeDir,
-			ConfigDir:  filepath.Join(homeDir, "configs"),
+
+			HLSSpillDir: filepath.Join(env.TempDir, "hls"),
+
+			WebRTCAdditionalHosts: []string{},
+
+			TrustedProxies: []string{},
+			ACMEDomains:    []string{},
+
+			ArchiveAfterDays: 7,
+
+			ThumbnailCacheSize: 100,
+
+			DiskWarnPercent:  90,
+			DiskPausePercent: 95,
+
+			LogFormat:      "text",
+			LogFileMaxSize: 10,
+			LogFileMaxAge:  24,
+			LogFileBackups: 5,
+			LogLevels:      map[string]string{},
+
+			ShutdownTimeout: 30,
+
+			SessionLifetime:    168,
+			SessionIdleTimeout: 1440,
+
+			SegmentPrefetch:          2,
+			SegmentPrefetchCacheSize: 64,
+
+			HomeDir:   homeDir,
+			ConfigDir: filepath.Join(homeDir, "configs"),
 		}
 		require.Equal(t, *env, expected)
 	})
@@ -467,6 +622,30 @@ func TestNewConfigEnv(t *testing.T) {
 		_, err = NewConfigEnv(envPath, envYAML)
 		require.ErrorIs(t, err, os.ErrNotExist)
 	})
+	t.Run("logFormat", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.LogFormat = "xml"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrInvalidLogFormat)
+	})
+	t.Run("logFileAbs", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.LogFile = "nvr.log"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrPathNotAbsolute)
+	})
 	t.Run("storageAbs", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
@@ -503,6 +682,112 @@ func TestNewConfigEnv(t *testing.T) {
 		_, err = NewConfigEnv(envPath, envYAML)
 		require.ErrorIs(t, err, ErrPathNotAbsolute)
 	})
+	t.Run("hlsSpillDirAbs", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.HLSSpillDir = "."
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrPathNotAbsolute)
+	})
+	t.Run("archiveDirAbs", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.ArchiveDir = "."
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrPathNotAbsolute)
+	})
+	t.Run("hlsEncryption", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.HLSEncryption = "aes"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrInvalidHLSEncryption)
+	})
+	t.Run("basePath", func(t *testing.T) {
+		cases := map[string]string{
+			"nvr":   "/nvr",
+			"/nvr/": "/nvr",
+			"/a/b":  "/a/b",
+			"/":     "",
+		}
+		for input, expected := range cases {
+			envPath, testEnv, cancel := newTestEnv(t)
+			defer cancel()
+
+			testEnv.BasePath = input
+
+			envYAML, err := yaml.Marshal(testEnv)
+			require.NoError(t, err)
+
+			env, err := NewConfigEnv(envPath, envYAML)
+			require.NoError(t, err)
+			require.Equal(t, expected, env.BasePath)
+		}
+	})
+	t.Run("basePathInvalid", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.BasePath = "/nvr?a"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrInvalidBasePath)
+	})
+	t.Run("tlsNoCertificate", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.ACMEDomains = nil
+		testEnv.TLSCertFile = "/cert.pem"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrTLSNoCertificate)
+	})
+	t.Run("sessionLifetime", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.SessionLifetime = -1
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrInvalidSessionLifetime)
+	})
+	t.Run("acmeDNSHookAbs", func(t *testing.T) {
+		envPath, testEnv, cancel := newTestEnv(t)
+		defer cancel()
+
+		testEnv.ACMEDNSHook = "hook.sh"
+
+		envYAML, err := yaml.Marshal(testEnv)
+		require.NoError(t, err)
+
+		_, err = NewConfigEnv(envPath, envYAML)
+		require.ErrorIs(t, err, ErrPathNotAbsolute)
+	})
 	t.Run("homeDirAbs", func(t *testing.T) {
 		envPath, testEnv, cancel := newTestEnv(t)
 		defer cancel()
@@ -661,6 +946,41 @@ func TestGeneral(t *testing.T) {
 		err = general.Set(map[string]string{})
 		require.ErrorIs(t, err, os.ErrNotExist)
 	})
+	t.Run("reload", func(t *testing.T) {
+		tempDir, testGeneral, cancel := newTestGeneral(t)
+		defer cancel()
+
+		general, err := NewConfigGeneral(tempDir)
+		require.NoError(t, err)
+
+		changed, err := general.Reload()
+		require.NoError(t, err)
+		require.False(t, changed)
+		require.Equal(t, testGeneral.Config, general.Get())
+
+		err = os.WriteFile(general.path, []byte(`{"diskSpace": "2"}`), 0o600)
+		require.NoError(t, err)
+
+		changed, err = general.Reload()
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, map[string]string{"diskSpace": "2"}, general.Get())
+	})
+	t.Run("reloadUnmarshalErr", func(t *testing.T) {
+		tempDir, testGeneral, cancel := newTestGeneral(t)
+		defer cancel()
+
+		general, err := NewConfigGeneral(tempDir)
+		require.NoError(t, err)
+
+		err = os.WriteFile(general.path, []byte("{"), 0o600)
+		require.NoError(t, err)
+
+		_, err = general.Reload()
+		var e *json.SyntaxError
+		require.ErrorAs(t, err, &e)
+		require.Equal(t, testGeneral.Config, general.Get())
+	})
 }
 
 func TestDeleteRecording(t *testing.T) {
@@ -686,6 +1006,18 @@ func TestDeleteRecording(t *testing.T) {
 			listDirectory(t, recDir),
 		)
 	})
+	t.Run("flaggedErr", func(t *testing.T) {
+		recordingsDir := t.TempDir()
+		recDir := filepath.Join(recordingsDir, "2000", "01", "01", "m1")
+		recID := "2000-01-01_02-02-02_m1"
+		files := []string{recID + ".flag", recID + ".json", recID + ".mp4"}
+		require.NoError(t, os.MkdirAll(recDir, 0o700))
+		createFiles(t, recDir, files)
+
+		err := DeleteRecording(recordingsDir, recID)
+		require.ErrorIs(t, err, ErrRecordingFlagged)
+		require.Equal(t, files, listDirectory(t, recDir))
+	})
 	t.Run("invalidIDErr", func(t *testing.T) {
 		err := DeleteRecording(t.TempDir(), "invalid")
 		require.ErrorIs(t, err, ErrInvalidRecordingID)
@@ -0,0 +1,272 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Archive secondary storage tier on a slower or bigger mount, for
+// example a NFS or SMB share. The directory has the same layout
+// as the storage directory. Recordings are moved by day and
+// segments individually, the index keeps track of the segments.
+type Archive struct {
+	Dir   string
+	After time.Duration
+}
+
+func (a Archive) enabled() bool {
+	return a.Dir != "" && a.After > 0
+}
+
+// dirs returns the sub directory of every tier.
+func (a Archive) dirs(storageDir string, sub string) []string {
+	dirs := []string{filepath.Join(storageDir, sub)}
+	if a.Dir != "" {
+		dirs = append(dirs, filepath.Join(a.Dir, sub))
+	}
+	return dirs
+}
+
+// ErrInvalidTier invalid storage tier.
+var ErrInvalidTier = errors.New("invalid storage tier")
+
+func (s *Manager) recordingsDirs() []string {
+	return s.archive.dirs(s.storageDir, "recordings")
+}
+
+func (s *Manager) segmentsDirs() []string {
+	return s.archive.dirs(s.storageDir, "segments")
+}
+
+// FindRecordingDir returns the recordings directory that contains the
+// recording, or the first directory if none of them do. Recordings are
+// moved by day, a recording is always in a single directory.
+func FindRecordingDir(recordingsDirs []string, recPath string) string {
+	for _, dir := range recordingsDirs {
+		if dirExist(filepath.Dir(filepath.Join(dir, recPath))) {
+			return dir
+		}
+	}
+	return recordingsDirs[0]
+}
+
+// tieredFS read-only file system that merges the tiers.
+// Files are opened from the first tier that has them.
+type tieredFS []fs.FS
+
+// NewTieredFS returns a file system that merges
+// the directories of the file systems.
+func NewTieredFS(tiers ...fs.FS) fs.FS {
+	return tieredFS(tiers)
+}
+
+func (t tieredFS) Open(name string) (fs.File, error) {
+	var firstErr error
+	for _, tier := range t {
+		file, err := tier.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func (t tieredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]struct{})
+	found := false
+	for _, tier := range t {
+		tierEntries, err := fs.ReadDir(tier, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, entry := range tierEntries {
+			if _, exist := seen[entry.Name()]; exist {
+				continue
+			}
+			seen[entry.Name()] = struct{}{}
+			entries = append(entries, entry)
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// archiveOld moves the recording days and segments
+// that are older than the archive age to the archive.
+func (s *Manager) archiveOld(now time.Time) error {
+	if !s.archive.enabled() {
+		return nil
+	}
+	cutoff := now.Add(-s.archive.After)
+	if err := s.archiveRecordings(cutoff); err != nil {
+		return fmt.Errorf("recordings: %w", err)
+	}
+	if err := s.archiveSegments(cutoff); err != nil {
+		return fmt.Errorf("segments: %w", err)
+	}
+	return nil
+}
+
+// archiveRecordings moves the recording days that ended before cutoff.
+func (s *Manager) archiveRecordings(cutoff time.Time) error {
+	dirs := s.recordingsDirs()
+	paths, err := filepath.Glob(filepath.Join(dirs[0], "*", "*", "*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(dirs[0], path)
+		if err != nil {
+			return err
+		}
+		day, err := time.ParseInLocation("2006/01/02", filepath.ToSlash(rel), time.Local)
+		if err != nil {
+			continue
+		}
+		if day.AddDate(0, 0, 1).After(cutoff) {
+			return nil
+		}
+
+		s.logf(log.LevelInfo, "archiving %q", path)
+		if err := moveTree(path, filepath.Join(dirs[1], rel)); err != nil {
+			return err
+		}
+		removeEmptyParents(dirs[0], filepath.Dir(path))
+	}
+	return nil
+}
+
+// archiveSegments moves the segments that started before cutoff.
+func (s *Manager) archiveSegments(cutoff time.Time) error {
+	if s.index == nil {
+		return nil
+	}
+	dirs := s.segmentsDirs()
+	for _, seg := range s.index.Before(cutoff) {
+		if seg.Tier != 0 {
+			continue
+		}
+		src := filepath.Join(dirs[0], seg.Path)
+		dst := filepath.Join(dirs[1], seg.Path)
+		err := moveFile(src, dst)
+		// The file may have been moved before the index was updated.
+		if err != nil && !(errors.Is(err, os.ErrNotExist) && dirExist(dst)) {
+			return err
+		}
+		if err := s.index.SetTier(seg.MonitorID, seg.Path, 1); err != nil {
+			return err
+		}
+		removeEmptyParents(dirs[0], filepath.Dir(src))
+	}
+	return nil
+}
+
+// moveTree moves all files in the src directory to dst and removes src.
+func moveTree(src string, dst string) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return moveFile(path, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// moveFile renames the file, the file is copied
+// if the directories are on different devices.
+func moveFile(src string, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return fmt.Errorf("copy %v: %w", src, err)
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := dst + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// The modification time is used by the video cache.
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
@@ -0,0 +1,133 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveOld(t *testing.T) {
+	now := time.Date(2000, 1, 10, 12, 0, 0, 0, time.Local)
+
+	newManager := func(t *testing.T) *Manager {
+		t.Helper()
+		m := newRetentionTestManager(t, nil)
+		m.archive = Archive{Dir: t.TempDir(), After: 2 * 24 * time.Hour}
+		return m
+	}
+
+	t.Run("recordings", func(t *testing.T) {
+		m := newManager(t)
+		dirs := m.recordingsDirs()
+		writeTestFile(t, filepath.Join(dirs[0], "2000/01/07/m1/2000-01-07_10-00-00_m1.mp4"), 1)
+		writeTestFile(t, filepath.Join(dirs[0], "2000/01/07/m2/2000-01-07_10-00-00_m2.mp4"), 1)
+		writeTestFile(t, filepath.Join(dirs[0], "2000/01/08/m1/2000-01-08_10-00-00_m1.mp4"), 1)
+
+		require.NoError(t, m.archiveOld(now))
+		require.Equal(t, []string{
+			"recordings/2000/01/08/m1/2000-01-08_10-00-00_m1.mp4",
+		}, m.listFiles(t))
+		_, err := os.Stat(filepath.Join(dirs[1], "2000/01/07/m2/2000-01-07_10-00-00_m2.mp4"))
+		require.NoError(t, err)
+
+		recPath := "2000/01/07/m1/2000-01-07_10-00-00_m1"
+		require.Equal(t, dirs[1], FindRecordingDir(dirs, recPath))
+		recPath = "2000/01/08/m1/2000-01-08_10-00-00_m1"
+		require.Equal(t, dirs[0], FindRecordingDir(dirs, recPath))
+	})
+	t.Run("segments", func(t *testing.T) {
+		m := newManager(t)
+		dirs := m.segmentsDirs()
+		old := SegmentInfo{
+			MonitorID: "m1",
+			Path:      "2000/01/07/m1/a.mp4",
+			Start:     now.Add(-72 * time.Hour),
+			End:       now,
+		}
+		recent := SegmentInfo{
+			MonitorID: "m1",
+			Path:      "2000/01/09/m1/b.mp4",
+			Start:     now.Add(-24 * time.Hour),
+			End:       now,
+		}
+		for _, seg := range []SegmentInfo{old, recent} {
+			writeTestFile(t, filepath.Join(dirs[0], seg.Path), 1)
+			require.NoError(t, m.index.Add(seg))
+		}
+
+		require.NoError(t, m.archiveOld(now))
+		require.Equal(t, []string{"segments/2000/01/09/m1/b.mp4"}, m.listFiles(t))
+		_, err := os.Stat(filepath.Join(dirs[1], old.Path))
+		require.NoError(t, err)
+
+		old.Tier = 1
+		require.Equal(t, []SegmentInfo{old, recent}, m.index.Query("m1", time.Time{}, now))
+
+		// Deleting archived segments uses the archive directory.
+		require.NoError(t, m.removeSegmentFiles([]SegmentInfo{old}))
+		_, err = os.Stat(filepath.Join(dirs[1], old.Path))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		m := &Manager{logger: log.NewDummyLogger()}
+		require.NoError(t, m.archiveOld(now))
+	})
+}
+
+func TestTieredFS(t *testing.T) {
+	tiered := NewTieredFS(
+		fstest.MapFS{
+			"2000/01/02/m1/a.json": {Data: []byte("a")},
+			"2000/01/02/m1/b.json": {Data: []byte("b1")},
+		},
+		fstest.MapFS{
+			"2000/01/01/m1/c.json": {Data: []byte("c")},
+			"2000/01/02/m1/b.json": {Data: []byte("b2")},
+		},
+	)
+
+	entries, err := fs.ReadDir(tiered, "2000/01")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "01", entries[0].Name())
+	require.Equal(t, "02", entries[1].Name())
+
+	sub, err := fs.Sub(tiered, "2000/01/02/m1")
+	require.NoError(t, err)
+	entries, err = fs.ReadDir(sub, ".")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	data, err := fs.ReadFile(tiered, "2000/01/02/m1/b.json")
+	require.NoError(t, err)
+	require.Equal(t, "b1", string(data))
+
+	data, err = fs.ReadFile(tiered, "2000/01/01/m1/c.json")
+	require.NoError(t, err)
+	require.Equal(t, "c", string(data))
+
+	_, err = fs.ReadDir(tiered, "nil")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,220 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timeline recording coverage and events of a monitor in a time range.
+// Used by the frontend to render a scrub bar.
+type Timeline struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Time ranges where continuous segments or event
+	// recordings exist. Sorted and non-overlapping.
+	Coverage []TimelineRange `json:"coverage"`
+
+	// Time ranges without any recordings.
+	Gaps []TimelineRange `json:"gaps"`
+
+	// Time ranges where continuous recording was skipped
+	// because the scene was static. Also included in Gaps.
+	Static []TimelineRange `json:"static"`
+
+	Events []TimelineEvent `json:"events"`
+}
+
+// TimelineRange time range.
+type TimelineRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// TimelineEvent event marker.
+type TimelineEvent struct {
+	Time        time.Time     `json:"time"`
+	Duration    time.Duration `json:"duration"`
+	Labels      []string      `json:"labels"`
+	RecordingID string        `json:"recordingId"`
+}
+
+// timelineMergeGap ranges closer than this are merged, consecutive
+// segments are usually a few milliseconds apart.
+const timelineMergeGap = time.Second
+
+// NewTimeline builds the timeline between start and end from the
+// continuous segments, the static markers and the event recordings.
+func NewTimeline(
+	start time.Time,
+	end time.Time,
+	segments []SegmentInfo,
+	static []SegmentInfo,
+	recordings []Recording,
+) Timeline {
+	clip := func(ranges *[]TimelineRange, rangeStart time.Time, rangeEnd time.Time) {
+		if rangeStart.Before(start) {
+			rangeStart = start
+		}
+		if rangeEnd.After(end) {
+			rangeEnd = end
+		}
+		if rangeEnd.After(rangeStart) {
+			*ranges = append(*ranges, TimelineRange{Start: rangeStart, End: rangeEnd})
+		}
+	}
+
+	var ranges []TimelineRange
+	addRange := func(rangeStart time.Time, rangeEnd time.Time) {
+		clip(&ranges, rangeStart, rangeEnd)
+	}
+	for _, seg := range segments {
+		addRange(seg.Start, seg.End)
+	}
+
+	var staticRanges []TimelineRange
+	for _, seg := range static {
+		clip(&staticRanges, seg.Start, seg.End)
+	}
+
+	events := []TimelineEvent{}
+	for _, rec := range recordings {
+		if rec.Data == nil {
+			continue
+		}
+		addRange(rec.Data.Start, rec.Data.End)
+		for _, e := range rec.Data.Events {
+			if e.Time.Before(start) || !e.Time.Before(end) {
+				continue
+			}
+			events = append(events, TimelineEvent{
+				Time:        e.Time,
+				Duration:    e.Duration,
+				Labels:      eventLabels(e),
+				RecordingID: rec.ID,
+			})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	coverage := mergeRanges(ranges)
+	return Timeline{
+		Start:    start,
+		End:      end,
+		Coverage: coverage,
+		Gaps:     timelineGaps(start, end, coverage),
+		Static:   mergeRanges(staticRanges),
+		Events:   events,
+	}
+}
+
+// eventLabels returns the unique detection labels in order.
+func eventLabels(e Event) []string {
+	labels := []string{}
+	seen := make(map[string]bool)
+	for _, d := range e.Detections {
+		if d.Label == "" || seen[d.Label] {
+			continue
+		}
+		seen[d.Label] = true
+		labels = append(labels, d.Label)
+	}
+	return labels
+}
+
+func mergeRanges(ranges []TimelineRange) []TimelineRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start.Before(ranges[j].Start)
+	})
+	merged := []TimelineRange{}
+	for _, r := range ranges {
+		if len(merged) != 0 {
+			last := &merged[len(merged)-1]
+			if !r.Start.After(last.End.Add(timelineMergeGap)) {
+				if r.End.After(last.End) {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func timelineGaps(start time.Time, end time.Time, coverage []TimelineRange) []TimelineRange {
+	gaps := []TimelineRange{}
+	prev := start
+	for _, r := range coverage {
+		if r.Start.After(prev) {
+			gaps = append(gaps, TimelineRange{Start: prev, End: r.Start})
+		}
+		prev = r.End
+	}
+	if end.After(prev) {
+		gaps = append(gaps, TimelineRange{Start: prev, End: end})
+	}
+	return gaps
+}
+
+// RecordingsInRange returns the event recordings of the monitor, with
+// data, that overlap the time range. Recordings are stored by the local
+// date they started, the day before start is included for recordings
+// that started before midnight.
+func (c *Crawler) RecordingsInRange(
+	monitorID string,
+	start time.Time,
+	end time.Time,
+) ([]Recording, error) {
+	var recordings []Recording
+	day := start.Local().AddDate(0, 0, -1)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	for !day.After(end) {
+		dir := path.Join(day.Format("2006/01/02"), monitorID)
+		entries, err := fs.ReadDir(c.fs, dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+				continue
+			}
+			fileFS, err := fs.Sub(c.fs, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("file fs: %w", err)
+			}
+			data := readDataFile(fileFS)
+			if data == nil || !data.Start.Before(end) || !data.End.After(start) {
+				continue
+			}
+			recordings = append(recordings, Recording{
+				ID:   strings.TrimSuffix(entry.Name(), ".json"),
+				Data: data,
+			})
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return recordings, nil
+}
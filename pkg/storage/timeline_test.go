@@ -0,0 +1,134 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTimeline(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0).UTC() }
+	segments := []SegmentInfo{
+		{Start: at(90), End: at(110)},
+		{Start: time.Unix(110, 1e6).UTC(), End: at(120)},
+		{Start: at(150), End: at(160)},
+	}
+	recordings := []Recording{
+		{
+			ID: "rec1",
+			Data: &RecordingData{
+				Start: at(155),
+				End:   at(170),
+				Events: []Event{
+					{
+						Time:     at(165),
+						Duration: time.Second,
+						Detections: []Detection{
+							{Label: "person"},
+							{Label: "car"},
+							{Label: "person"},
+						},
+					},
+					{Time: at(158)},
+					{Time: at(300)},
+				},
+			},
+		},
+		{ID: "noData"},
+	}
+
+	static := []SegmentInfo{
+		{Start: at(120), End: at(130), Static: true},
+		{Start: at(130), End: at(140), Static: true},
+		{Start: at(190), End: at(210), Static: true},
+	}
+
+	actual := NewTimeline(at(100), at(200), segments, static, recordings)
+	expected := Timeline{
+		Start: at(100),
+		End:   at(200),
+		Coverage: []TimelineRange{
+			{Start: at(100), End: at(120)},
+			{Start: at(150), End: at(170)},
+		},
+		Gaps: []TimelineRange{
+			{Start: at(120), End: at(150)},
+			{Start: at(170), End: at(200)},
+		},
+		Static: []TimelineRange{
+			{Start: at(120), End: at(140)},
+			{Start: at(190), End: at(200)},
+		},
+		Events: []TimelineEvent{
+			{Time: at(158), Labels: []string{}, RecordingID: "rec1"},
+			{
+				Time:        at(165),
+				Duration:    time.Second,
+				Labels:      []string{"person", "car"},
+				RecordingID: "rec1",
+			},
+		},
+	}
+	require.Equal(t, expected, actual)
+
+	t.Run("empty", func(t *testing.T) {
+		actual := NewTimeline(at(100), at(200), nil, nil, nil)
+		expected := Timeline{
+			Start:    at(100),
+			End:      at(200),
+			Coverage: []TimelineRange{},
+			Gaps:     []TimelineRange{{Start: at(100), End: at(200)}},
+			Static:   []TimelineRange{},
+			Events:   []TimelineEvent{},
+		}
+		require.Equal(t, expected, actual)
+	})
+}
+
+func TestRecordingsInRange(t *testing.T) {
+	testFS := fstest.MapFS{
+		"2099/01/01/m1/2099-01-01_23-59-00_m1.json": {Data: []byte(
+			`{"start":"` + time.Date(2099, 1, 1, 23, 59, 0, 0, time.Local).Format(time.RFC3339) +
+				`","end":"` + time.Date(2099, 1, 2, 0, 1, 0, 0, time.Local).Format(time.RFC3339) + `"}`,
+		)},
+		"2099/01/01/m1/2099-01-01_10-00-00_m1.json": {Data: []byte(
+			`{"start":"` + time.Date(2099, 1, 1, 10, 0, 0, 0, time.Local).Format(time.RFC3339) +
+				`","end":"` + time.Date(2099, 1, 1, 10, 1, 0, 0, time.Local).Format(time.RFC3339) + `"}`,
+		)},
+		"2099/01/01/m1/2099-01-01_10-00-00_m1.jpeg": {},
+		"2099/01/02/m1/2099-01-02_00-30-00_m1.json": {Data: []byte(
+			`{"start":"` + time.Date(2099, 1, 2, 0, 30, 0, 0, time.Local).Format(time.RFC3339) +
+				`","end":"` + time.Date(2099, 1, 2, 0, 31, 0, 0, time.Local).Format(time.RFC3339) + `"}`,
+		)},
+		"2099/01/02/m2/2099-01-02_00-30-00_m2.json": {Data: []byte(`{}`)},
+	}
+	c := NewCrawler(testFS)
+
+	start := time.Date(2099, 1, 2, 0, 0, 0, 0, time.Local)
+	end := time.Date(2099, 1, 2, 1, 0, 0, 0, time.Local)
+	recordings, err := c.RecordingsInRange("m1", start, end)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, rec := range recordings {
+		ids = append(ids, rec.ID)
+	}
+	require.Equal(t, []string{"2099-01-01_23-59-00_m1", "2099-01-02_00-30-00_m1"}, ids)
+}
@@ -13,6 +13,9 @@ import (
 type Recording struct {
 	ID   string         `json:"id"`
 	Data *RecordingData `json:"data"`
+
+	// Flagged recordings are exempt from retention and pruning.
+	Flagged bool `json:"flagged"`
 }
 
 // RecordingData recording data marshaled to json and saved next to video and thumbnail.
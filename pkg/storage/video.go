@@ -25,9 +25,16 @@ type VideoReader struct {
 	modTime time.Time
 }
 
-// NewVideoReader creates a video reader.
-// Caller must call Close() when done.
-func NewVideoReader(recordingPath string, cache *VideoCache) (*VideoReader, error) {
+// IsMKVRecording returns true if the recording was saved as a
+// Matroska file instead of the custom format.
+func IsMKVRecording(recordingPath string) bool {
+	_, err := os.Stat(recordingPath + ".mkv")
+	return err == nil
+}
+
+// NewVideoReader creates a video reader, the mdat file is
+// decrypted if it's encrypted. Caller must call Close() when done.
+func NewVideoReader(recordingPath string, cache *VideoCache, crypt *Crypt) (*VideoReader, error) {
 	metaPath := recordingPath + ".meta"
 	mdatPath := recordingPath + ".mdat"
 
@@ -50,7 +57,7 @@ func NewVideoReader(recordingPath string, cache *VideoCache) (*VideoReader, erro
 		}
 	}
 
-	mdat, err := os.Open(mdatPath)
+	mdat, err := OpenRecordingFile(mdatPath, crypt)
 	if err != nil {
 		return nil, fmt.Errorf("open mdat file: %w", err)
 	}
@@ -114,6 +121,11 @@ func (r *VideoReader) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 
+	// The mdat file may be longer than the samples, for
+	// example if the recording was recovered after a crash.
+	if remaining := r.metaSize + r.mdatSize - r.i; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
 	pLen := int64(len(p))
 
 	// Read starts within meta.
@@ -40,7 +40,7 @@ func TestNewVideoReader(t *testing.T) {
 	err = os.WriteFile(mdatPath, []byte{0, 0, 0, 0}, 0o600)
 	require.NoError(t, err)
 
-	video, err := NewVideoReader(path, nil)
+	video, err := NewVideoReader(path, nil, nil)
 	require.NoError(t, err)
 	defer video.Close()
 
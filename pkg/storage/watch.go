@@ -0,0 +1,78 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchFiles polls the paths and calls onChange when a file is
+// created, modified or removed. The files directly inside directories
+// are also watched, sub-directories are not. Blocks until canceled.
+func WatchFiles(ctx context.Context, interval time.Duration, paths []string, onChange func()) {
+	prev := stampFiles(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		stamps := stampFiles(paths)
+		if !reflect.DeepEqual(prev, stamps) {
+			prev = stamps
+			onChange()
+		}
+	}
+}
+
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	stamp := func(path string) os.FileInfo {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return info
+	}
+	for _, path := range paths {
+		info := stamp(path)
+		if info == nil || !info.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				stamp(filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return stamps
+}
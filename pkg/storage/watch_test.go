@@ -0,0 +1,65 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.json")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		WatchFiles(ctx, time.Millisecond, []string{dir}, func() {
+			changed <- struct{}{}
+		})
+		close(done)
+	}()
+
+	waitForChange := func() {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, os.WriteFile(file, []byte("ab"), 0o600))
+	waitForChange()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.json"), nil, 0o600))
+	waitForChange()
+
+	require.NoError(t, os.Remove(file))
+	waitForChange()
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,268 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package thumbnail extracts JPEG frames from the continuous recording
+// segments on demand. The nearest keyframe is decoded by FFmpeg and the
+// result is kept in a size limited disk cache.
+package thumbnail
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Thumbnail errors.
+var (
+	ErrNoKeyframe   = errors.New("no keyframe near time")
+	ErrInvalidWidth = errors.New("invalid width")
+)
+
+// Width limits.
+const (
+	DefaultWidth = 320
+	MaxWidth     = 1920
+)
+
+// Keyframes further away than this aren't used.
+const maxKeyframeDistance = time.Minute
+
+// Maximum time to wait for FFmpeg.
+const decodeTimeout = 10 * time.Second
+
+// SegmentQueryFunc returns the indexed segments of
+// the monitor that overlap the time range.
+type SegmentQueryFunc func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo
+
+// DecodeFunc decodes the first frame of the MP4 input
+// and returns it as a JPEG scaled to the width.
+type DecodeFunc func(ctx context.Context, input io.Reader, width int) ([]byte, error)
+
+// Thumbnailer generates thumbnails from continuous recording segments.
+type Thumbnailer struct {
+	query        SegmentQueryFunc
+	segmentsDirs []string
+	crypt        *storage.Crypt
+	decode       DecodeFunc
+
+	cacheDir string
+	maxSize  int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // Most recently used first.
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// NewThumbnailer creates a thumbnailer with a disk cache of maxSize
+// bytes in cacheDir. Cached files from previous runs are loaded.
+func NewThumbnailer(
+	query SegmentQueryFunc,
+	segmentsDirs []string,
+	crypt *storage.Crypt,
+	decode DecodeFunc,
+	cacheDir string,
+	maxSize int64,
+) (*Thumbnailer, error) {
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	t := &Thumbnailer{
+		query:        query,
+		segmentsDirs: segmentsDirs,
+		crypt:        crypt,
+		decode:       decode,
+		cacheDir:     cacheDir,
+		maxSize:      maxSize,
+		lru:          list.New(),
+		entries:      make(map[string]*list.Element),
+	}
+	if err := t.loadCache(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadCache adds the existing files to the cache, newest first.
+func (t *Thumbnailer) loadCache() error {
+	dirEntries, err := os.ReadDir(t.cacheDir)
+	if err != nil {
+		return fmt.Errorf("read cache directory: %w", err)
+	}
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range dirEntries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jpeg") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{entry.Name(), info.Size(), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range files {
+		t.entries[f.name] = t.lru.PushBack(&cacheEntry{name: f.name, size: f.size})
+		t.size += f.size
+	}
+	t.evict()
+	return nil
+}
+
+// Thumbnail returns a JPEG of the keyframe nearest to the time.
+// Returns ErrNoKeyframe if no segment has a keyframe near it.
+func (t *Thumbnailer) Thumbnail(
+	ctx context.Context,
+	monitorID string,
+	at time.Time,
+	width int,
+) ([]byte, error) {
+	if width <= 0 || width > MaxWidth {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWidth, width)
+	}
+
+	segments := t.query(monitorID, at.Add(-maxKeyframeDistance), at.Add(maxKeyframeDistance))
+	seg, kf, found := storage.NearestKeyframe(segments, at)
+	if !found {
+		return nil, ErrNoKeyframe
+	}
+	diff := kf.Time.Sub(at)
+	if diff < -maxKeyframeDistance || diff > maxKeyframeDistance {
+		return nil, ErrNoKeyframe
+	}
+
+	// Keyed by the keyframe, nearby times share the same thumbnail.
+	name := monitorID + "_" + strconv.FormatInt(kf.Time.UnixNano(), 10) +
+		"_" + strconv.Itoa(width) + ".jpeg"
+	if jpeg, ok := t.cached(name); ok {
+		return jpeg, nil
+	}
+
+	input := &bytes.Buffer{}
+	if err := storage.WriteKeyframe(input, t.segmentsDirs, t.crypt, seg, kf); err != nil {
+		return nil, fmt.Errorf("keyframe: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, decodeTimeout)
+	defer cancel()
+	jpeg, err := t.decode(ctx, input, width)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	if err := t.store(name, jpeg); err != nil {
+		return nil, err
+	}
+	return jpeg, nil
+}
+
+func (t *Thumbnailer) cached(name string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elem, exist := t.entries[name]
+	if !exist {
+		return nil, false
+	}
+	path := filepath.Join(t.cacheDir, name)
+	jpeg, err := os.ReadFile(path)
+	if err != nil {
+		t.remove(elem)
+		return nil, false
+	}
+	t.lru.MoveToFront(elem)
+	// The modification time keeps the order across restarts.
+	now := time.Now()
+	os.Chtimes(path, now, now) //nolint:errcheck
+	return jpeg, true
+}
+
+func (t *Thumbnailer) store(name string, jpeg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exist := t.entries[name]; exist {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(t.cacheDir, name), jpeg, 0o600); err != nil {
+		return fmt.Errorf("write cache: %w", err)
+	}
+	t.entries[name] = t.lru.PushFront(&cacheEntry{name: name, size: int64(len(jpeg))})
+	t.size += int64(len(jpeg))
+	t.evict()
+	return nil
+}
+
+// evict removes the least recently used files until the cache fits.
+func (t *Thumbnailer) evict() {
+	for t.size > t.maxSize && t.lru.Len() != 0 {
+		elem := t.lru.Back()
+		os.Remove(filepath.Join(t.cacheDir, elem.Value.(*cacheEntry).name)) //nolint:errcheck
+		t.remove(elem)
+	}
+}
+
+func (t *Thumbnailer) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	t.lru.Remove(elem)
+	delete(t.entries, entry.name)
+	t.size -= entry.size
+}
+
+// NewFFmpegDecoder returns a DecodeFunc that runs the FFmpeg binary.
+func NewFFmpegDecoder(ffmpegBin string) DecodeFunc {
+	return func(ctx context.Context, input io.Reader, width int) ([]byte, error) {
+		args := []string{
+			"-loglevel", "error", "-threads", "1",
+			"-i", "-",
+			"-frames:v", "1",
+			"-vf", "scale=" + strconv.Itoa(width) + ":-2",
+			"-f", "image2", "-c:v", "mjpeg", "-",
+		}
+		cmd := exec.CommandContext(ctx, ffmpegBin, args...)
+		cmd.Stdin = input
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		return output, nil
+	}
+}
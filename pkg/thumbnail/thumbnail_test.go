@@ -0,0 +1,172 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"nvr/pkg/storage"
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestSegment writes a segment with a keyframe every second.
+func writeTestSegment(t *testing.T, dir string, start int64, seconds int) storage.SegmentInfo {
+	t.Helper()
+	info := hls.StreamInfo{VideoTrackExist: true, VideoSPS: []byte{0, 0, 0}}
+	init, err := hls.GenerateInit(info)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(init)
+	base := start * int64(time.Second)
+	var keyframes []storage.Keyframe
+	for i := 0; i < seconds; i++ {
+		dts := base + int64(i)*int64(time.Second)
+		keyframes = append(keyframes, storage.Keyframe{
+			Time:   time.Unix(0, dts),
+			Offset: int64(buf.Len()),
+		})
+		fragment, err := hls.GenerateFragment(base, info, []*hls.VideoSample{{
+			PTS:        dts,
+			DTS:        dts,
+			AVCC:       []byte{byte(i)},
+			IdrPresent: true,
+			NextDTS:    dts + int64(time.Second),
+		}}, nil)
+		require.NoError(t, err)
+		buf.Write(fragment)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.mp4"), buf.Bytes(), 0o600))
+
+	return storage.SegmentInfo{
+		MonitorID: "m1",
+		Path:      "a.mp4",
+		Start:     time.Unix(start, 0),
+		End:       time.Unix(start+int64(seconds), 0),
+		Size:      int64(buf.Len()),
+		Keyframes: keyframes,
+	}
+}
+
+// mockDecoder returns the last byte of the input, the
+// keyframe payload, followed by the width.
+func mockDecoder(calls *int) DecodeFunc {
+	return func(_ context.Context, input io.Reader, width int) ([]byte, error) {
+		*calls++
+		raw, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{raw[len(raw)-1]}, strconv.Itoa(width)...), nil
+	}
+}
+
+func newTestThumbnailer(t *testing.T, maxSize int64) (*Thumbnailer, *int, string) {
+	t.Helper()
+	segmentsDir := t.TempDir()
+	seg := writeTestSegment(t, segmentsDir, 100, 3)
+	query := func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo {
+		if monitorID != "m1" || !start.Before(seg.End) || !end.After(seg.Start) {
+			return nil
+		}
+		return []storage.SegmentInfo{seg}
+	}
+	calls := 0
+	cacheDir := t.TempDir()
+	thumbnailer, err := NewThumbnailer(
+		query, []string{segmentsDir}, nil, mockDecoder(&calls), cacheDir, maxSize)
+	require.NoError(t, err)
+	return thumbnailer, &calls, cacheDir
+}
+
+func TestThumbnail(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		thumbnailer, calls, _ := newTestThumbnailer(t, 1000)
+
+		jpeg, err := thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(101, 4e8), 320)
+		require.NoError(t, err)
+		require.Equal(t, append([]byte{1}, "320"...), jpeg)
+		require.Equal(t, 1, *calls)
+
+		// Nearest keyframe is cached.
+		jpeg, err = thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(100, 6e8), 320)
+		require.NoError(t, err)
+		require.Equal(t, append([]byte{1}, "320"...), jpeg)
+		require.Equal(t, 1, *calls)
+
+		_, err = thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(101, 0), 160)
+		require.NoError(t, err)
+		require.Equal(t, 2, *calls)
+	})
+	t.Run("noKeyframe", func(t *testing.T) {
+		thumbnailer, _, _ := newTestThumbnailer(t, 1000)
+		_, err := thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(1000, 0), 320)
+		require.ErrorIs(t, err, ErrNoKeyframe)
+		_, err = thumbnailer.Thumbnail(context.Background(), "m2", time.Unix(101, 0), 320)
+		require.ErrorIs(t, err, ErrNoKeyframe)
+	})
+	t.Run("invalidWidth", func(t *testing.T) {
+		thumbnailer, _, _ := newTestThumbnailer(t, 1000)
+		_, err := thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(101, 0), 0)
+		require.ErrorIs(t, err, ErrInvalidWidth)
+	})
+	t.Run("evict", func(t *testing.T) {
+		// Each thumbnail is 4 bytes.
+		thumbnailer, calls, cacheDir := newTestThumbnailer(t, 8)
+		for _, sec := range []int64{100, 101, 100, 102} {
+			_, err := thumbnailer.Thumbnail(context.Background(), "m1", time.Unix(sec, 0), 320)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 3, *calls)
+
+		entries, err := os.ReadDir(cacheDir)
+		require.NoError(t, err)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		require.ElementsMatch(t, []string{
+			"m1_100000000000_320.jpeg",
+			"m1_102000000000_320.jpeg",
+		}, names)
+	})
+	t.Run("loadCache", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		old := time.Now().Add(-time.Hour)
+		for i, name := range []string{"a.jpeg", "b.jpeg", "c.jpeg"} {
+			path := filepath.Join(cacheDir, name)
+			require.NoError(t, os.WriteFile(path, []byte("1234"), 0o600))
+			modTime := old.Add(time.Duration(i) * time.Minute)
+			require.NoError(t, os.Chtimes(path, modTime, modTime))
+		}
+		_, err := NewThumbnailer(nil, nil, nil, nil, cacheDir, 8)
+		require.NoError(t, err)
+
+		// The oldest file is removed.
+		_, err = os.Stat(filepath.Join(cacheDir, "a.jpeg"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Stat(filepath.Join(cacheDir, "c.jpeg"))
+		require.NoError(t, err)
+	})
+}
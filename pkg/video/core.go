@@ -16,8 +16,14 @@ type Server struct {
 	hlsAddress  string
 	pathManager *pathManager
 	rtspServer  *rtspServer
+	restream    *rtspServer
 	hlsServer   *hlsServer
+	webrtc      *webrtcServer
+	rtmp        *rtmpServer
+	push        *pushHandler
 	wg          *sync.WaitGroup
+
+	statsHistory *statsHistory
 }
 
 const readBufferCount = 2048
@@ -37,17 +43,45 @@ func NewServer(log *log.Logger, wg *sync.WaitGroup, env storage.ConfigEnv) *Serv
 		return "127.0.0.1:" + strconv.Itoa(env.HLSPort)
 	}()
 
-	hlsServer := newHLSServer(wg, readBufferCount, log)
+	spiller := hls.NewSpiller(env.HLSSpillDir, int64(env.HLSMemoryBudget)*int64(mb))
+
+	hlsServer := newHLSServer(wg, readBufferCount, log, spiller, env.HLSEncryption)
 	pathManager := newPathManager(wg, log, hlsServer)
+
+	var restream *rtspServer
+	if env.RTSPRestreamPort != 0 {
+		restreamAddress := ":" + strconv.Itoa(env.RTSPRestreamPort)
+		restream = newRTSPServer(wg, restreamAddress, readBufferCount, pathManager, log)
+		restream.restream = true
+	}
+
 	rtspServer := newRTSPServer(wg, rtspAddress, readBufferCount, pathManager, log)
 
+	var webrtc *webrtcServer
+	if env.WebRTCPort != 0 {
+		webrtcAddress := ":" + strconv.Itoa(env.WebRTCPort)
+		webrtc = newWebRTCServer(wg, log, webrtcAddress, env.WebRTCAdditionalHosts, pathManager)
+	}
+
+	var rtmp *rtmpServer
+	if env.RTMPPort != 0 {
+		rtmpAddress := ":" + strconv.Itoa(env.RTMPPort)
+		rtmp = newRTMPServer(wg, log, rtmpAddress, pathManager)
+	}
+
 	return &Server{
 		rtspAddress: rtspAddress,
 		hlsAddress:  hlsAddress,
 		pathManager: pathManager,
 		rtspServer:  rtspServer,
+		restream:    restream,
 		hlsServer:   hlsServer,
+		webrtc:      webrtc,
+		rtmp:        rtmp,
+		push:        newPushHandler(log, pathManager),
 		wg:          wg,
+
+		statsHistory: newStatsHistory(),
 	}
 }
 
@@ -65,6 +99,33 @@ func (s *Server) Start(ctx context.Context) error {
 		cancel()
 		return err
 	}
+
+	if s.restream != nil {
+		if err := s.restream.start(ctx2); err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	if s.webrtc != nil {
+		if err := s.webrtc.start(ctx2); err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	if s.rtmp != nil {
+		if err := s.rtmp.start(ctx2); err != nil {
+			cancel()
+			return err
+		}
+	}
+
+	s.wg.Add(1)
+	go func() {
+		s.sampleStats(ctx2)
+		s.wg.Done()
+	}()
 	return nil
 }
 
@@ -79,6 +140,8 @@ type IHLSMuxer interface {
 	StreamInfo() (*hls.StreamInfo, error)
 	WaitForSegFinalized()
 	NextSegment(prevID uint64) (*hls.Segment, error)
+	LatestPart() (*hls.MuxerPart, error)
+	Liveness() hls.Liveness
 }
 
 // ServerPath .
@@ -113,3 +176,62 @@ func (s *Server) PathExist(name string) bool {
 func (s *Server) HandleHLS() http.HandlerFunc {
 	return s.hlsServer.HandleRequest()
 }
+
+// EndStreams ends the HLS live streams before shutdown. The playlists
+// are frozen with EXT-X-ENDLIST so viewers stop instead of retrying,
+// the monitors can still read new segments to finalize the recordings.
+func (s *Server) EndStreams() {
+	s.hlsServer.endStreams()
+}
+
+// HandleMSE handle MSE websocket requests.
+func (s *Server) HandleMSE() http.HandlerFunc {
+	encrypted := s.hlsServer.encryption != ""
+	return newMSEHandler(s.hlsServer.logger, s.hlsServer.MuxerByPathName, encrypted).HandleRequest()
+}
+
+// HandlePush handle HTTP push requests.
+func (s *Server) HandlePush() http.HandlerFunc {
+	return s.push.HandleRequest()
+}
+
+// SetRTSPAuth sets the function used to authenticate
+// readers of the RTSP restream server. All requests
+// are rejected until the function is set.
+func (s *Server) SetRTSPAuth(auth RTSPAuthFunc) {
+	if s.restream != nil {
+		s.restream.setAuth(auth)
+	}
+}
+
+// PathStats returns the track statistics of a path.
+// Returns ErrPathNotExist if the path doesn't exist and
+// ErrPathNoOnePublishing if the path isn't published.
+func (s *Server) PathStats(name string) ([]TrackStats, error) {
+	return s.pathManager.pathStats(name)
+}
+
+// PathViewerStats returns the playback statistics of the HLS
+// viewers of a path, reported by the players with CMCD.
+// Returns ErrPathNotExist if the path doesn't exist and
+// ErrPathNoOnePublishing if the path doesn't have a muxer.
+func (s *Server) PathViewerStats(name string) (hls.ViewerStats, error) {
+	if !s.pathManager.pathExist(name) {
+		return hls.ViewerStats{}, ErrPathNotExist
+	}
+	muxer, err := s.hlsServer.MuxerByPathName(name)
+	if err != nil {
+		return hls.ViewerStats{}, ErrPathNoOnePublishing
+	}
+	return muxer.ViewerStats(), nil
+}
+
+// HandleWHEP handle WebRTC WHEP requests.
+func (s *Server) HandleWHEP() http.HandlerFunc {
+	if s.webrtc == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "WebRTC is disabled", http.StatusNotFound)
+		}
+	}
+	return s.webrtc.HandleRequest()
+}
@@ -57,3 +57,34 @@ func TestNewPath(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	require.False(t, p.PathExist("mypath"))
 }
+
+func TestPathConfPartDuration(t *testing.T) {
+	conf := PathConf{MonitorID: "x"}
+	require.NoError(t, conf.CheckAndFillMissing("x"))
+	require.Equal(t, defaultHLSPartDuration, conf.HLSPartDuration)
+	require.Equal(t, defaultHLSSegmentDuration, conf.HLSSegmentDuration)
+
+	// The segments are extended to hold the parts.
+	conf = PathConf{MonitorID: "x", HLSPartDuration: time.Second}
+	require.NoError(t, conf.CheckAndFillMissing("x"))
+	require.Equal(t, 3*time.Second, conf.HLSSegmentDuration)
+
+	conf = PathConf{MonitorID: "x", HLSPartDuration: time.Millisecond}
+	require.ErrorIs(t, conf.CheckAndFillMissing("x"), ErrInvalidPartDuration)
+}
+
+func TestPathConfBufferDuration(t *testing.T) {
+	conf := PathConf{
+		MonitorID:         "x",
+		HLSBufferDuration: 9 * time.Second,
+	}
+	require.NoError(t, conf.CheckAndFillMissing("x"))
+	require.Equal(t, 12, conf.HLSSegmentCount)
+
+	conf = PathConf{
+		MonitorID:         "x",
+		HLSBufferDuration: time.Millisecond,
+	}
+	require.NoError(t, conf.CheckAndFillMissing("x"))
+	require.Equal(t, defaultHLSSegmentCount, conf.HLSSegmentCount)
+}
@@ -130,7 +130,7 @@ func unmarshalArray(r io.Reader, value *[]byte) (int, error) {
 // ToStreamInfo converts header to stream info.
 func (h Header) ToStreamInfo() (*hls.StreamInfo, error) {
 	info := hls.StreamInfo{
-		VideoTrackExist:  true,
+		VideoTrackExist:  len(h.VideoSPS) != 0,
 		AudioTrackExist:  len(h.AudioConfig) != 0,
 		AudioTrackConfig: h.AudioConfig,
 	}
@@ -41,3 +41,13 @@ func TestHeaderToStreamInfo(t *testing.T) {
 	}
 	require.Equal(t, expected, *actual)
 }
+
+func TestHeaderToStreamInfoAudioOnly(t *testing.T) {
+	header := Header{AudioConfig: []byte{20, 10, 0, 0}}
+
+	actual, err := header.ToStreamInfo()
+	require.NoError(t, err)
+	require.False(t, actual.VideoTrackExist)
+	require.True(t, actual.AudioTrackExist)
+	require.Equal(t, 16000, actual.AudioClockRate)
+}
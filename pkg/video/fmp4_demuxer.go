@@ -0,0 +1,749 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/rtmp"
+	"sort"
+	"time"
+)
+
+// Errors.
+var (
+	ErrFMP4Invalid          = errors.New("invalid fMP4")
+	ErrFMP4BoxTooLarge      = errors.New("fMP4 box too large")
+	ErrFMP4NoTracks         = errors.New("fMP4 init has no H264 or AAC tracks")
+	ErrFMP4InitChanged      = errors.New("fMP4 init changed")
+	ErrFMP4UnsupportedCodec = errors.New("unsupported codec, only H264 and AAC are supported")
+)
+
+var fmp4MaxBoxSize = 32 * mb
+
+// fmp4Track is a H264 or AAC track of the init.
+type fmp4Track struct {
+	id        uint32
+	timescale uint32
+
+	// H264.
+	sps []byte
+	pps []byte
+
+	// AAC.
+	audioConfig *mpeg4audio.Config
+
+	// Sample defaults from the trex box.
+	defaultDuration uint32
+	defaultSize     uint32
+}
+
+func (t *fmp4Track) isVideo() bool {
+	return t.sps != nil
+}
+
+// fmp4Sample is a H264 access unit in AVCC format or a single AAC AU.
+type fmp4Sample struct {
+	track *fmp4Track
+	dts   time.Duration
+	pts   time.Duration
+	data  []byte
+}
+
+// fmp4Demuxer reads a fragmented MP4 stream. The stream starts
+// with the init, ftyp and moov, followed by moof and mdat pairs.
+type fmp4Demuxer struct {
+	r    io.Reader
+	pos  int64
+	init []byte
+
+	video *fmp4Track
+	audio *fmp4Track
+
+	// Tracks by ID, only H264 and AAC tracks are included.
+	tracks map[uint32]*fmp4Track
+
+	// Samples of the last moof. The offsets are
+	// relative to the start of the moof box.
+	moofStart int64
+	pending   []fmp4SampleRef
+}
+
+type fmp4SampleRef struct {
+	track    *fmp4Track
+	dts      int64
+	duration int64
+	cto      int64
+	offset   int64
+	size     int64
+}
+
+func newFMP4Demuxer(r io.Reader) *fmp4Demuxer {
+	return &fmp4Demuxer{r: r}
+}
+
+// readBox reads the next top level box and returns
+// the type, body and offset of the start of the box.
+func (d *fmp4Demuxer) readBox() (string, []byte, int64, error) {
+	start := d.pos
+	var header [8]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return "", nil, 0, err
+	}
+	headerSize := uint64(8)
+	size := uint64(binary.BigEndian.Uint32(header[:]))
+	typ := string(header[4:8])
+
+	if size == 1 {
+		var largeSize [8]byte
+		if _, err := io.ReadFull(d.r, largeSize[:]); err != nil {
+			return "", nil, 0, err
+		}
+		headerSize = 16
+		size = binary.BigEndian.Uint64(largeSize[:])
+	}
+	// Size zero extends to the end of the file and isn't
+	// valid in a stream since the end is unknown.
+	if size < headerSize {
+		return "", nil, 0, fmt.Errorf("%w: %v box size %v", ErrFMP4Invalid, typ, size)
+	}
+	if size > fmp4MaxBoxSize {
+		return "", nil, 0, fmt.Errorf("%w: %v %v", ErrFMP4BoxTooLarge, typ, size)
+	}
+
+	body := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		return "", nil, 0, err
+	}
+	d.pos += int64(size)
+	return typ, body, start, nil
+}
+
+// readInit reads boxes until the moov box and parses the tracks.
+func (d *fmp4Demuxer) readInit() error {
+	for {
+		typ, body, _, err := d.readBox()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case "moov":
+			return d.parseInit(body)
+		case "moof", "mdat":
+			return fmt.Errorf("%w: %v before moov", ErrFMP4Invalid, typ)
+		}
+	}
+}
+
+func (d *fmp4Demuxer) parseInit(moov []byte) error {
+	tracks, err := parseFMP4Moov(moov)
+	if err != nil {
+		return err
+	}
+	d.init = moov
+	d.tracks = make(map[uint32]*fmp4Track)
+	for _, track := range tracks {
+		switch {
+		case track.isVideo() && d.video == nil:
+			d.video = track
+		case !track.isVideo() && d.audio == nil:
+			d.audio = track
+		default:
+			continue
+		}
+		d.tracks[track.id] = track
+	}
+	if len(d.tracks) == 0 {
+		return ErrFMP4NoTracks
+	}
+	return nil
+}
+
+// readFragment reads boxes until a moof and mdat pair has been
+// read and returns the samples of the fragment in decode order.
+func (d *fmp4Demuxer) readFragment() ([]fmp4Sample, error) {
+	for {
+		typ, body, start, err := d.readBox()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case "moov":
+			// The init may be repeated but the tracks can't change.
+			if !bytes.Equal(body, d.init) {
+				return nil, ErrFMP4InitChanged
+			}
+
+		case "moof":
+			refs, err := parseFMP4Moof(body, start, d.tracks)
+			if err != nil {
+				return nil, err
+			}
+			d.moofStart = start
+			d.pending = refs
+
+		case "mdat":
+			if d.pending == nil {
+				continue
+			}
+			// Offset of the mdat body relative to the moof.
+			mdatOffset := d.pos - int64(len(body)) - d.moofStart
+			samples, err := resolveFMP4Samples(d.pending, body, mdatOffset)
+			if err != nil {
+				return nil, err
+			}
+			d.pending = nil
+			return samples, nil
+		}
+	}
+}
+
+func resolveFMP4Samples(refs []fmp4SampleRef, mdat []byte, mdatOffset int64) ([]fmp4Sample, error) {
+	samples := make([]fmp4Sample, 0, len(refs))
+	for _, ref := range refs {
+		start := ref.offset - mdatOffset
+		end := start + ref.size
+		if start < 0 || end > int64(len(mdat)) {
+			return nil, fmt.Errorf("%w: sample outside mdat", ErrFMP4Invalid)
+		}
+		dts := ticksToDuration(ref.dts, ref.track.timescale)
+		samples = append(samples, fmp4Sample{
+			track: ref.track,
+			dts:   dts,
+			pts:   dts + ticksToDuration(ref.cto, ref.track.timescale),
+			data:  mdat[start:end],
+		})
+	}
+	// Interleave the tracks.
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].dts < samples[j].dts
+	})
+	return samples, nil
+}
+
+func ticksToDuration(ticks int64, timescale uint32) time.Duration {
+	ts := int64(timescale)
+	return time.Duration(ticks/ts)*time.Second +
+		time.Duration(ticks%ts)*time.Second/time.Duration(ts)
+}
+
+// walkFMP4Boxes calls fn with the type and body of each box in buf.
+func walkFMP4Boxes(buf []byte, fn func(typ string, body []byte) error) error {
+	for len(buf) != 0 {
+		if len(buf) < 8 {
+			return fmt.Errorf("%w: truncated box header", ErrFMP4Invalid)
+		}
+		size := int(binary.BigEndian.Uint32(buf))
+		if size < 8 || size > len(buf) {
+			return fmt.Errorf("%w: box size %v", ErrFMP4Invalid, size)
+		}
+		if err := fn(string(buf[4:8]), buf[8:size]); err != nil {
+			return err
+		}
+		buf = buf[size:]
+	}
+	return nil
+}
+
+// parseFMP4Moov returns the H264 and AAC tracks. Tracks
+// that aren't video or audio, like subtitles, are ignored.
+func parseFMP4Moov(moov []byte) ([]*fmp4Track, error) {
+	var tracks []*fmp4Track
+	type trexDefaults struct{ duration, size uint32 }
+	trex := make(map[uint32]trexDefaults)
+
+	err := walkFMP4Boxes(moov, func(typ string, body []byte) error {
+		switch typ {
+		case "trak":
+			track, err := parseFMP4Trak(body)
+			if err != nil {
+				return err
+			}
+			if track != nil {
+				tracks = append(tracks, track)
+			}
+		case "mvex":
+			return walkFMP4Boxes(body, func(typ string, body []byte) error {
+				if typ != "trex" {
+					return nil
+				}
+				if len(body) < 24 {
+					return fmt.Errorf("%w: trex", ErrFMP4Invalid)
+				}
+				trex[binary.BigEndian.Uint32(body[4:])] = trexDefaults{
+					duration: binary.BigEndian.Uint32(body[12:]),
+					size:     binary.BigEndian.Uint32(body[16:]),
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, track := range tracks {
+		defaults := trex[track.id]
+		track.defaultDuration = defaults.duration
+		track.defaultSize = defaults.size
+	}
+	return tracks, nil
+}
+
+// parseFMP4Trak returns nil if the track isn't video or audio.
+func parseFMP4Trak(trak []byte) (*fmp4Track, error) { //nolint:funlen,gocognit
+	var track fmp4Track
+	var handler string
+	var sampleEntry string
+	var sampleEntryBody []byte
+
+	err := walkFMP4Boxes(trak, func(typ string, body []byte) error {
+		switch typ {
+		case "tkhd":
+			// Version 1 has 64 bit creation and modification times.
+			offset := 12
+			if len(body) != 0 && body[0] == 1 {
+				offset = 20
+			}
+			if len(body) < offset+4 {
+				return fmt.Errorf("%w: tkhd", ErrFMP4Invalid)
+			}
+			track.id = binary.BigEndian.Uint32(body[offset:])
+
+		case "mdia":
+			return walkFMP4Boxes(body, func(typ string, body []byte) error {
+				switch typ {
+				case "mdhd":
+					offset := 12
+					if len(body) != 0 && body[0] == 1 {
+						offset = 20
+					}
+					if len(body) < offset+4 {
+						return fmt.Errorf("%w: mdhd", ErrFMP4Invalid)
+					}
+					track.timescale = binary.BigEndian.Uint32(body[offset:])
+				case "hdlr":
+					if len(body) < 12 {
+						return fmt.Errorf("%w: hdlr", ErrFMP4Invalid)
+					}
+					handler = string(body[8:12])
+				case "minf":
+					return walkFMP4Boxes(body, func(typ string, body []byte) error {
+						if typ != "stbl" {
+							return nil
+						}
+						return walkFMP4Boxes(body, func(typ string, body []byte) error {
+							if typ != "stsd" {
+								return nil
+							}
+							// Full box header and entry count.
+							if len(body) < 8 {
+								return fmt.Errorf("%w: stsd", ErrFMP4Invalid)
+							}
+							return walkFMP4Boxes(body[8:], func(typ string, body []byte) error {
+								// Only the first sample entry is used.
+								if sampleEntry == "" {
+									sampleEntry = typ
+									sampleEntryBody = body
+								}
+								return nil
+							})
+						})
+					})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if handler != "vide" && handler != "soun" {
+		return nil, nil //nolint:nilnil
+	}
+	if track.timescale == 0 {
+		return nil, fmt.Errorf("%w: track %v: zero timescale", ErrFMP4Invalid, track.id)
+	}
+
+	switch sampleEntry {
+	case "avc1", "avc3":
+		// Visual sample entry fields.
+		if len(sampleEntryBody) < 78 {
+			return nil, fmt.Errorf("%w: %v", ErrFMP4Invalid, sampleEntry)
+		}
+		err := walkFMP4Boxes(sampleEntryBody[78:], func(typ string, body []byte) error {
+			if typ != "avcC" {
+				return nil
+			}
+			sps, pps, err := rtmp.ParseAVCConfig(body)
+			if err != nil {
+				return err
+			}
+			track.sps = sps
+			track.pps = pps
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if track.sps == nil || track.pps == nil {
+			return nil, fmt.Errorf("%w: track %v: missing avcC", ErrFMP4Invalid, track.id)
+		}
+
+	case "mp4a":
+		// Audio sample entry fields.
+		if len(sampleEntryBody) < 28 {
+			return nil, fmt.Errorf("%w: mp4a", ErrFMP4Invalid)
+		}
+		err := walkFMP4Boxes(sampleEntryBody[28:], func(typ string, body []byte) error {
+			if typ != "esds" {
+				return nil
+			}
+			buf, err := parseESDS(body)
+			if err != nil {
+				return err
+			}
+			var config mpeg4audio.Config
+			if err := config.Unmarshal(buf); err != nil {
+				return fmt.Errorf("audio config: %w", err)
+			}
+			track.audioConfig = &config
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if track.audioConfig == nil {
+			return nil, fmt.Errorf("%w: track %v: missing esds", ErrFMP4Invalid, track.id)
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrFMP4UnsupportedCodec, sampleEntry)
+	}
+	return &track, nil
+}
+
+// parseESDS returns the decoder specific info of the esds box.
+func parseESDS(body []byte) ([]byte, error) {
+	// Full box header.
+	if len(body) < 4 {
+		return nil, fmt.Errorf("%w: esds", ErrFMP4Invalid)
+	}
+	buf := body[4:]
+	for len(buf) != 0 {
+		tag, payload, rest, err := readDescriptor(buf)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case 0x03: // ES_Descriptor.
+			if len(payload) < 3 {
+				return nil, fmt.Errorf("%w: ES descriptor", ErrFMP4Invalid)
+			}
+			flags := payload[2]
+			payload = payload[3:]
+			skip := 0
+			if flags&0x80 != 0 { // streamDependenceFlag.
+				skip += 2
+			}
+			if flags&0x40 != 0 { // URL_Flag.
+				if len(payload) < 1 {
+					return nil, fmt.Errorf("%w: ES descriptor", ErrFMP4Invalid)
+				}
+				skip += 1 + int(payload[0])
+			}
+			if flags&0x20 != 0 { // OCRstreamFlag.
+				skip += 2
+			}
+			if len(payload) < skip {
+				return nil, fmt.Errorf("%w: ES descriptor", ErrFMP4Invalid)
+			}
+			buf = payload[skip:]
+
+		case 0x04: // DecoderConfigDescriptor.
+			if len(payload) < 13 {
+				return nil, fmt.Errorf("%w: decoder config descriptor", ErrFMP4Invalid)
+			}
+			buf = payload[13:]
+
+		case 0x05: // DecoderSpecificInfo.
+			return payload, nil
+
+		default:
+			buf = rest
+		}
+	}
+	return nil, fmt.Errorf("%w: esds: missing decoder specific info", ErrFMP4Invalid)
+}
+
+// readDescriptor reads a MPEG-4 descriptor and returns
+// the tag, payload and the data after the descriptor.
+func readDescriptor(buf []byte) (byte, []byte, []byte, error) {
+	if len(buf) < 2 {
+		return 0, nil, nil, fmt.Errorf("%w: truncated descriptor", ErrFMP4Invalid)
+	}
+	tag := buf[0]
+	pos := 1
+	size := 0
+	for i := 0; i < 4; i++ {
+		if pos >= len(buf) {
+			return 0, nil, nil, fmt.Errorf("%w: truncated descriptor", ErrFMP4Invalid)
+		}
+		b := buf[pos]
+		pos++
+		size = size<<7 | int(b&0x7F)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if len(buf) < pos+size {
+		return 0, nil, nil, fmt.Errorf("%w: descriptor size %v", ErrFMP4Invalid, size)
+	}
+	return tag, buf[pos : pos+size], buf[pos+size:], nil
+}
+
+// Track fragment header flags.
+const (
+	tfhdBaseDataOffset         = 0x01
+	tfhdSampleDescriptionIndex = 0x02
+	tfhdDefaultSampleDuration  = 0x08
+	tfhdDefaultSampleSize      = 0x10
+	tfhdDefaultSampleFlags     = 0x20
+	tfhdDefaultBaseIsMoof      = 0x20000
+)
+
+// Track fragment run flags.
+const (
+	trunDataOffset       = 0x01
+	trunFirstSampleFlags = 0x04
+	trunSampleDuration   = 0x100
+	trunSampleSize       = 0x200
+	trunSampleFlags      = 0x400
+	trunSampleCTO        = 0x800
+)
+
+// parseFMP4Moof returns the samples of the known tracks with the
+// data offsets relative to the start of the moof. moofStart is the
+// offset of the moof in the stream, used for absolute base offsets.
+func parseFMP4Moof( //nolint:funlen,gocognit
+	moof []byte,
+	moofStart int64,
+	tracks map[uint32]*fmp4Track,
+) ([]fmp4SampleRef, error) {
+	refs := []fmp4SampleRef{}
+
+	// The data of a track fragment without a base offset
+	// follows the data of the previous track fragment.
+	var prevEnd int64
+
+	err := walkFMP4Boxes(moof, func(typ string, traf []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+
+		var track *fmp4Track
+		var known bool
+		var base int64
+		var defaultDuration, defaultSize uint32
+		var baseDecodeTime int64
+		var trafRefs []fmp4SampleRef
+
+		err := walkFMP4Boxes(traf, func(typ string, body []byte) error {
+			switch typ {
+			case "tfhd":
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfhd", ErrFMP4Invalid)
+				}
+				flags := binary.BigEndian.Uint32(body) & 0xFFFFFF
+				track, known = tracks[binary.BigEndian.Uint32(body[4:])]
+				if !known {
+					return nil
+				}
+				defaultDuration = track.defaultDuration
+				defaultSize = track.defaultSize
+
+				pos := 8
+				field := func() (uint32, error) {
+					if len(body) < pos+4 {
+						return 0, fmt.Errorf("%w: tfhd", ErrFMP4Invalid)
+					}
+					v := binary.BigEndian.Uint32(body[pos:])
+					pos += 4
+					return v, nil
+				}
+
+				switch {
+				case flags&tfhdBaseDataOffset != 0:
+					if len(body) < pos+8 {
+						return fmt.Errorf("%w: tfhd", ErrFMP4Invalid)
+					}
+					base = int64(binary.BigEndian.Uint64(body[pos:])) - moofStart
+					pos += 8
+				case flags&tfhdDefaultBaseIsMoof != 0:
+					base = 0
+				default:
+					base = prevEnd
+				}
+				if flags&tfhdSampleDescriptionIndex != 0 {
+					if _, err := field(); err != nil {
+						return err
+					}
+				}
+				if flags&tfhdDefaultSampleDuration != 0 {
+					v, err := field()
+					if err != nil {
+						return err
+					}
+					defaultDuration = v
+				}
+				if flags&tfhdDefaultSampleSize != 0 {
+					v, err := field()
+					if err != nil {
+						return err
+					}
+					defaultSize = v
+				}
+				if flags&tfhdDefaultSampleFlags != 0 {
+					if _, err := field(); err != nil {
+						return err
+					}
+				}
+
+			case "tfdt":
+				if !known {
+					return nil
+				}
+				if len(body) < 8 {
+					return fmt.Errorf("%w: tfdt", ErrFMP4Invalid)
+				}
+				if body[0] == 1 {
+					if len(body) < 12 {
+						return fmt.Errorf("%w: tfdt", ErrFMP4Invalid)
+					}
+					baseDecodeTime = int64(binary.BigEndian.Uint64(body[4:]))
+				} else {
+					baseDecodeTime = int64(binary.BigEndian.Uint32(body[4:]))
+				}
+
+			case "trun":
+				if !known {
+					return nil
+				}
+				runRefs, end, err := parseTrun(body, base, defaultDuration, defaultSize)
+				if err != nil {
+					return err
+				}
+				trafRefs = append(trafRefs, runRefs...)
+				base = end
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !known {
+			return nil
+		}
+
+		dts := baseDecodeTime
+		for _, ref := range trafRefs {
+			ref.track = track
+			ref.dts = dts
+			dts += ref.duration
+			refs = append(refs, ref)
+		}
+		prevEnd = base
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// parseTrun returns the samples of the track run and the end
+// offset of the data. The data starts at base unless the run
+// has a data offset, which is relative to the start of the moof.
+func parseTrun(
+	body []byte,
+	base int64,
+	defaultDuration uint32,
+	defaultSize uint32,
+) ([]fmp4SampleRef, int64, error) {
+	if len(body) < 8 {
+		return nil, 0, fmt.Errorf("%w: trun", ErrFMP4Invalid)
+	}
+	version := body[0]
+	flags := binary.BigEndian.Uint32(body) & 0xFFFFFF
+	sampleCount := int(binary.BigEndian.Uint32(body[4:]))
+	pos := 8
+
+	field := func() (uint32, error) {
+		if len(body) < pos+4 {
+			return 0, fmt.Errorf("%w: trun", ErrFMP4Invalid)
+		}
+		v := binary.BigEndian.Uint32(body[pos:])
+		pos += 4
+		return v, nil
+	}
+
+	offset := base
+	if flags&trunDataOffset != 0 {
+		v, err := field()
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = int64(int32(v))
+	}
+	if flags&trunFirstSampleFlags != 0 {
+		if _, err := field(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	fieldsPerSample := 0
+	for _, f := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunSampleCTO} {
+		if flags&f != 0 {
+			fieldsPerSample++
+		}
+	}
+	if sampleCount*fieldsPerSample*4 > len(body)-pos {
+		return nil, 0, fmt.Errorf("%w: trun sample count %v", ErrFMP4Invalid, sampleCount)
+	}
+
+	refs := make([]fmp4SampleRef, 0, sampleCount)
+	for i := 0; i < sampleCount; i++ {
+		ref := fmp4SampleRef{
+			duration: int64(defaultDuration),
+			size:     int64(defaultSize),
+			offset:   offset,
+		}
+		if flags&trunSampleDuration != 0 {
+			v, _ := field()
+			ref.duration = int64(v)
+		}
+		if flags&trunSampleSize != 0 {
+			v, _ := field()
+			ref.size = int64(v)
+		}
+		if flags&trunSampleFlags != 0 {
+			field() //nolint:errcheck
+		}
+		if flags&trunSampleCTO != 0 {
+			v, _ := field()
+			if version == 0 {
+				ref.cto = int64(v)
+			} else {
+				ref.cto = int64(int32(v))
+			}
+		}
+		offset += ref.size
+		refs = append(refs, ref)
+	}
+	return refs, offset, nil
+}
@@ -0,0 +1,95 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/gortsplib/pkg/h264"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFMP4Demuxer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	muxer := newTestMSEMuxer(ctx)
+	writeTestMSEVideo(t, muxer, 0, 45)
+
+	init, err := readMuxerInit(muxer)
+	require.NoError(t, err)
+
+	part, err := muxer.LatestPart()
+	require.NoError(t, err)
+	content, err := io.ReadAll(part.Reader())
+	require.NoError(t, err)
+
+	// The init is repeated before the fragment.
+	stream := append(append(append([]byte{}, init...), init...), content...)
+	d := newFMP4Demuxer(bytes.NewReader(stream))
+	require.NoError(t, d.readInit())
+	require.NotNil(t, d.video)
+	require.Nil(t, d.audio)
+	require.Equal(t, testMSESPS, d.video.sps)
+	require.Equal(t, testMSEPPS, d.video.pps)
+
+	samples, err := d.readFragment()
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+
+	nalus, err := h264.AVCCUnmarshal(samples[0].data)
+	require.NoError(t, err)
+	require.True(t, h264.IDRPresent(nalus))
+	require.Equal(t, time.Second, samples[0].dts)
+	for i := 1; i < len(samples); i++ {
+		require.InDelta(t, time.Second/30, samples[i].dts-samples[i-1].dts, float64(time.Millisecond))
+	}
+
+	_, err = d.readFragment()
+	require.ErrorIs(t, err, io.EOF)
+
+	t.Run("initChanged", func(t *testing.T) {
+		changed := append([]byte{}, init...)
+		changed[len(changed)-1]++
+		d := newFMP4Demuxer(bytes.NewReader(append(append([]byte{}, init...), changed...)))
+		require.NoError(t, d.readInit())
+		_, err := d.readFragment()
+		require.ErrorIs(t, err, ErrFMP4InitChanged)
+	})
+	t.Run("fragmentBeforeInit", func(t *testing.T) {
+		d := newFMP4Demuxer(bytes.NewReader(content))
+		require.ErrorIs(t, d.readInit(), ErrFMP4Invalid)
+	})
+	t.Run("boxTooLarge", func(t *testing.T) {
+		d := newFMP4Demuxer(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 'm', 'd', 'a', 't'}))
+		require.ErrorIs(t, d.readInit(), ErrFMP4BoxTooLarge)
+	})
+}
+
+func TestParseESDS(t *testing.T) {
+	esds := []byte{
+		0, 0, 0, 0, // Full box header.
+		0x03, 0x19, // ES_Descriptor.
+		0x00, 0x01, 0x00,
+		0x04, 0x11, // DecoderConfigDescriptor.
+		0x40, 0x15, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x05, 0x02, 0x12, 0x10, // DecoderSpecificInfo.
+		0x06, 0x01, 0x02, // SLConfigDescriptor.
+	}
+	config, err := parseESDS(esds)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x12, 0x10}, config)
+
+	_, err = parseESDS(esds[:10])
+	require.ErrorIs(t, err, ErrFMP4Invalid)
+}
+
+func TestTicksToDuration(t *testing.T) {
+	require.Equal(t, 1500*time.Millisecond, ticksToDuration(135000, 90000))
+	require.Equal(t, 100*time.Hour, ticksToDuration(100*3600*90000, 90000))
+}
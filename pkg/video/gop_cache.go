@@ -0,0 +1,70 @@
+package video
+
+import (
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"sync"
+)
+
+// Maximum size of the cached GOP. Streams with very long keyframe
+// intervals aren't cached, readers wait for the next IDR instead.
+const gopCacheMaxSize = 16 * 1024 * 1024
+
+// gopCache keeps the H264 access units since the last IDR so new
+// readers can start decoding immediately instead of waiting for the
+// next keyframe. The cached data is shared and must not be modified.
+type gopCache struct {
+	mu      sync.Mutex
+	units   []*data
+	size    int
+	maxSize int
+}
+
+func newGOPCache() *gopCache {
+	return &gopCache{maxSize: gopCacheMaxSize}
+}
+
+// write adds a H264 access unit to the cache.
+func (c *gopCache) write(dat *data) {
+	if dat.h264NALUs == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h264.IDRPresent(dat.h264NALUs) {
+		c.units = c.units[:0]
+		c.size = 0
+	} else if len(c.units) == 0 {
+		// Can't be decoded without the IDR.
+		return
+	}
+
+	size := 0
+	for _, nalu := range dat.h264NALUs {
+		size += len(nalu)
+	}
+	if c.size+size > c.maxSize {
+		c.units = nil
+		c.size = 0
+		return
+	}
+
+	// Only the fields used by the readers are cached.
+	c.units = append(c.units, &data{
+		trackID:   dat.trackID,
+		pts:       dat.pts,
+		h264NALUs: dat.h264NALUs,
+	})
+	c.size += size
+}
+
+// get returns the cached access units, starting with an IDR.
+func (c *gopCache) get() []*data {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	units := make([]*data, len(c.units))
+	copy(units, c.units)
+	return units
+}
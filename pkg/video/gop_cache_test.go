@@ -0,0 +1,91 @@
+package video
+
+import (
+	"nvr/pkg/video/gortsplib"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGOPCache(t *testing.T) {
+	idr := func(pts int) *data {
+		return &data{pts: durationMs(pts), h264NALUs: [][]byte{{0x65, 0x88}}}
+	}
+	nonIDR := func(pts int) *data {
+		return &data{pts: durationMs(pts), h264NALUs: [][]byte{{0x41, 0x9a}}}
+	}
+	ptsList := func(units []*data) []int {
+		var list []int
+		for _, u := range units {
+			list = append(list, int(u.pts.Milliseconds()))
+		}
+		return list
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		c := newGOPCache()
+		// Dropped until the first IDR.
+		c.write(nonIDR(0))
+		require.Empty(t, c.get())
+
+		c.write(idr(1))
+		c.write(nonIDR(2))
+		c.write(&data{pts: durationMs(3)}) // Not a access unit.
+		require.Equal(t, []int{1, 2}, ptsList(c.get()))
+
+		// The cache is reset on every IDR.
+		c.write(idr(4))
+		c.write(nonIDR(5))
+		require.Equal(t, []int{4, 5}, ptsList(c.get()))
+	})
+	t.Run("maxSize", func(t *testing.T) {
+		c := newGOPCache()
+		c.maxSize = 3
+		c.write(idr(1))
+		c.write(nonIDR(2))
+		require.Empty(t, c.get())
+
+		// Not cached until the next IDR.
+		c.write(nonIDR(3))
+		require.Empty(t, c.get())
+		c.write(idr(4))
+		require.Equal(t, []int{4}, ptsList(c.get()))
+	})
+}
+
+type stubStartableReader struct {
+	started bool
+	data    []*data
+}
+
+func (r *stubStartableReader) onData(dat *data) {
+	if r.started {
+		r.data = append(r.data, dat)
+	}
+}
+func (r *stubStartableReader) close() {}
+func (r *stubStartableReader) start() { r.started = true }
+
+func TestStreamReaderStart(t *testing.T) {
+	tracks := gortsplib.Tracks{
+		&gortsplib.TrackH264{PayloadType: 96, SPS: []byte{0x67}, PPS: []byte{0x68}},
+	}
+	s := newStream(tracks, nil)
+	require.Equal(t, 0, s.videoTrackID)
+	s.gopCache.write(&data{h264NALUs: [][]byte{{0x65, 0x88}}})
+
+	r := &stubStartableReader{}
+	// Readers must be added before they're started.
+	s.readerStart(r)
+	require.False(t, r.started)
+
+	s.readerAdd(r)
+	s.readerStart(r)
+	require.True(t, r.started)
+	require.Len(t, r.data, 1)
+}
+
+func durationMs(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
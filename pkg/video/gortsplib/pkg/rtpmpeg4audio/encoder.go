@@ -0,0 +1,129 @@
+package rtpmpeg4audio
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const rtpVersion = 0x02
+
+func randUint32() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatal(err)
+	}
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// Encoder is a RTP/AAC encoder. Each AU is written to its own
+// packet, AUs larger than the payload max size are fragmented.
+type Encoder struct {
+	// payload type of packets.
+	PayloadType uint8
+
+	// sample rate of packets.
+	SampleRate int
+
+	// The number of bits on which the AU-size field is encoded in the AU-header.
+	SizeLength int
+
+	// The number of bits on which the AU-Index is encoded in the first AU-header.
+	IndexLength int
+
+	// SSRC of packets (optional).
+	SSRC *uint32
+
+	// initial sequence number of packets (optional).
+	InitialSequenceNumber *uint16
+
+	// initial timestamp of packets (optional).
+	InitialTimestamp *uint32
+
+	// maximum size of packet payloads (optional).
+	PayloadMaxSize int
+
+	sequenceNumber uint16
+}
+
+// Init initializes the encoder.
+func (e *Encoder) Init() {
+	if e.SSRC == nil {
+		v := randUint32()
+		e.SSRC = &v
+	}
+	if e.InitialSequenceNumber == nil {
+		v := uint16(randUint32())
+		e.InitialSequenceNumber = &v
+	}
+	if e.InitialTimestamp == nil {
+		v := randUint32()
+		e.InitialTimestamp = &v
+	}
+	if e.PayloadMaxSize == 0 {
+		e.PayloadMaxSize = 1460 // 1500 (UDP MTU) - 20 (IP header) - 8 (UDP header) - 12 (RTP header)
+	}
+
+	e.sequenceNumber = *e.InitialSequenceNumber
+}
+
+func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
+	return *e.InitialTimestamp + uint32(ts.Seconds()*float64(e.SampleRate))
+}
+
+func (e *Encoder) auHeadersLen() int {
+	n := e.SizeLength + e.IndexLength
+	if (n % 8) != 0 {
+		return (n / 8) + 1
+	}
+	return n / 8
+}
+
+// Encode encodes a AU into RTP/AAC packets.
+func (e *Encoder) Encode(au []byte, pts time.Duration) ([]*rtp.Packet, error) {
+	headerLen := 2 + e.auHeadersLen()
+	maxDataSize := e.PayloadMaxSize - headerLen
+
+	var rets []*rtp.Packet
+	ts := e.encodeTimestamp(pts)
+
+	for pos := 0; pos < len(au) || pos == 0; pos += maxDataSize {
+		end := pos + maxDataSize
+		if end > len(au) {
+			end = len(au)
+		}
+		chunk := au[pos:end]
+
+		payload := make([]byte, headerLen+len(chunk))
+
+		// AU-headers-length
+		binary.BigEndian.PutUint16(payload, uint16(e.SizeLength+e.IndexLength))
+
+		// AU-header, the size is the size of the fragment
+		// in fragmented packets and the AU-index is zero.
+		header := uint64(len(chunk)) << (e.auHeadersLen()*8 - e.SizeLength)
+		for i := 0; i < e.auHeadersLen(); i++ {
+			payload[2+i] = byte(header >> ((e.auHeadersLen() - 1 - i) * 8))
+		}
+
+		copy(payload[headerLen:], chunk)
+
+		rets = append(rets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        rtpVersion,
+				PayloadType:    e.PayloadType,
+				SequenceNumber: e.sequenceNumber,
+				Timestamp:      ts,
+				SSRC:           *e.SSRC,
+				Marker:         end == len(au),
+			},
+			Payload: payload,
+		})
+		e.sequenceNumber++
+	}
+
+	return rets, nil
+}
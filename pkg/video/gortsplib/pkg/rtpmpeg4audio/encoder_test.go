@@ -0,0 +1,53 @@
+package rtpmpeg4audio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	cases := map[string]struct {
+		au          []byte
+		packetCount int
+	}{
+		"single":     {bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 64), 1},
+		"fragmented": {bytes.Repeat([]byte{0x01, 0x02, 0x03, 0x04}, 512), 2},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := &Encoder{
+				PayloadType: 96,
+				SampleRate:  48000,
+				SizeLength:  13,
+				IndexLength: 3,
+			}
+			e.Init()
+
+			pkts, err := e.Encode(tc.au, 20*time.Millisecond)
+			require.NoError(t, err)
+			require.Len(t, pkts, tc.packetCount)
+			require.True(t, pkts[len(pkts)-1].Marker)
+
+			d := &Decoder{
+				SampleRate:       48000,
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+			d.Init()
+
+			for i, pkt := range pkts {
+				aus, _, err := d.Decode(pkt)
+				if i != len(pkts)-1 {
+					require.ErrorIs(t, err, ErrMorePacketsNeeded)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, [][]byte{tc.au}, aus)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package gortsplib
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"testing"
 
 	"nvr/pkg/video/gortsplib/pkg/base"
@@ -600,3 +601,55 @@ func TestServerSessionAutoClose(t *testing.T) {
 		})
 	}
 }
+
+type testServerHandlerOnRequest struct {
+	testServerHandler
+	onRequest func(*ServerConn, *base.Request) *base.Response
+}
+
+func (sh *testServerHandlerOnRequest) OnRequest(sc *ServerConn, req *base.Request) *base.Response {
+	return sh.onRequest(sc, req)
+}
+
+func TestServerOnRequest(t *testing.T) {
+	s := &Server{
+		handler: &testServerHandlerOnRequest{
+			onRequest: func(_ *ServerConn, req *base.Request) *base.Response {
+				return &base.Response{StatusCode: base.StatusUnauthorized}
+			},
+		},
+		rtspAddress: "localhost:8554",
+	}
+	err := s.Start()
+	require.NoError(t, err)
+	defer s.Close()
+
+	nconn, err := net.Dial("tcp", "localhost:8554")
+	require.NoError(t, err)
+	defer nconn.Close()
+	conn := conn.NewConn(nconn)
+
+	res, err := writeReqReadRes(conn, base.Request{
+		Method: base.Options,
+		URL:    mustParseURL("rtsp://localhost:8554/"),
+		Header: base.Header{
+			"CSeq": base.HeaderValue{"1"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	// The connection is kept open after the request is rejected.
+	for i := 2; i < 4; i++ {
+		res, err = writeReqReadRes(conn, base.Request{
+			Method: base.Describe,
+			URL:    mustParseURL("rtsp://localhost:8554/teststream"),
+			Header: base.Header{
+				"CSeq": base.HeaderValue{strconv.Itoa(i)},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, base.StatusUnauthorized, res.StatusCode)
+		require.Equal(t, base.HeaderValue{strconv.Itoa(i)}, res.Header["CSeq"])
+	}
+}
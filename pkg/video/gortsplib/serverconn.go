@@ -281,6 +281,12 @@ func (sc *ServerConn) handleRequest(req *base.Request) (*base.Response, error) {
 		}, liberrors.ErrServerCSeqMissing
 	}
 
+	if h, ok := sc.s.handler.(ServerHandlerOnRequest); ok && req.Method != base.Options {
+		if res := h.OnRequest(sc, req); res != nil {
+			return res, nil
+		}
+	}
+
 	sxID := getSessionID(req.Header)
 
 	switch req.Method {
@@ -19,6 +19,9 @@ type TrackMPEG4Audio struct {
 	IndexLength      int
 	IndexDeltaLength int
 
+	// Language tag from the "lang" attribute, empty if not provided.
+	Language string
+
 	trackBase
 }
 
@@ -56,6 +59,10 @@ func newTrackMPEG4AudioFromMediaDescription( //nolint:funlen
 		},
 	}
 
+	if lang, ok := md.Attribute("lang"); ok {
+		t.Language = strings.TrimSpace(lang)
+	}
+
 	for _, kv := range strings.Split(tmp[1], ";") {
 		kv = strings.Trim(kv, " ")
 
@@ -126,6 +133,7 @@ func (t *TrackMPEG4Audio) clone() Track {
 		SizeLength:       t.SizeLength,
 		IndexLength:      t.IndexLength,
 		IndexDeltaLength: t.IndexDeltaLength,
+		Language:         t.Language,
 		trackBase:        t.trackBase,
 	}
 }
@@ -144,7 +152,7 @@ func (t *TrackMPEG4Audio) MediaDescription() *psdp.MediaDescription {
 		sampleRate = t.Config.ExtensionSampleRate
 	}
 
-	return &psdp.MediaDescription{
+	md := &psdp.MediaDescription{
 		MediaName: psdp.MediaName{
 			Media:   "audio",
 			Protos:  []string{"RTP", "AVP"},
@@ -186,4 +194,13 @@ func (t *TrackMPEG4Audio) MediaDescription() *psdp.MediaDescription {
 			},
 		},
 	}
+
+	if t.Language != "" {
+		md.Attributes = append(md.Attributes, psdp.Attribute{
+			Key:   "lang",
+			Value: t.Language,
+		})
+	}
+
+	return md
 }
@@ -36,6 +36,7 @@ func TestTrackMPEG4AudioClone(t *testing.T) {
 		SizeLength:       13,
 		IndexLength:      3,
 		IndexDeltaLength: 3,
+		Language:         "en",
 	}
 
 	clone := track.clone()
@@ -78,3 +79,35 @@ func TestTrackMPEG4AudioMediaDescription(t *testing.T) {
 		},
 	}, track.MediaDescription())
 }
+
+func TestTrackMPEG4AudioLanguage(t *testing.T) {
+	md := &psdp.MediaDescription{
+		MediaName: psdp.MediaName{
+			Media:   "audio",
+			Protos:  []string{"RTP", "AVP"},
+			Formats: []string{"96"},
+		},
+		Attributes: []psdp.Attribute{
+			{
+				Key:   "rtpmap",
+				Value: "96 mpeg4-generic/48000/2",
+			},
+			{
+				Key:   "fmtp",
+				Value: "96 profile-level-id=1; mode=AAC-hbr; sizelength=13; indexlength=3; indexdeltalength=3; config=1190",
+			},
+			{
+				Key:   "lang",
+				Value: "sv",
+			},
+		},
+	}
+
+	track, err := newTrackMPEG4AudioFromMediaDescription("", 96, md)
+	require.NoError(t, err)
+	require.Equal(t, "sv", track.Language)
+
+	actual := track.MediaDescription()
+	require.Equal(t, psdp.Attribute{Key: "lang", Value: "sv"},
+		actual.Attributes[len(actual.Attributes)-1])
+}
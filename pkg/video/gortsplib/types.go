@@ -20,6 +20,14 @@ type ServerHandler interface {
 	OnPacketRTP(*PacketRTPCtx)
 }
 
+// ServerHandlerOnRequest can be implemented by a ServerHandler
+// to authorize requests before they are processed. The request
+// is rejected with the returned response if it isn't nil.
+// OPTIONS requests are not passed to the handler.
+type ServerHandlerOnRequest interface {
+	OnRequest(*ServerConn, *base.Request) *base.Response
+}
+
 // PacketRTPCtx is the context of a RTP packet.
 type PacketRTPCtx struct {
 	Session      *ServerSession
@@ -0,0 +1,153 @@
+package hls
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// sharedBuffer is a immutable rendered part that is shared by all
+// readers instead of being copied for each request. The owner and
+// every open reader hold a reference, onFree is called when the
+// last reference is released and the buffer can't be read anymore.
+type sharedBuffer struct {
+	buf []byte
+
+	// The slice is returned to the pool when the buffer is freed.
+	pool *bufferPool
+
+	mu     sync.Mutex
+	refs   int
+	onFree func()
+}
+
+func newSharedBuffer(buf []byte) *sharedBuffer {
+	return &sharedBuffer{buf: buf, refs: 1}
+}
+
+// newPooledSharedBuffer takes ownership of a slice from the pool.
+func newPooledSharedBuffer(buf []byte, pool *bufferPool) *sharedBuffer {
+	return &sharedBuffer{buf: buf, pool: pool, refs: 1}
+}
+
+// retain adds a reference, returns false if the buffer has been freed.
+func (b *sharedBuffer) retain() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.refs == 0 {
+		return false
+	}
+	b.refs++
+	return true
+}
+
+// release removes a reference.
+func (b *sharedBuffer) release() {
+	b.mu.Lock()
+	b.refs--
+	if b.refs != 0 {
+		b.mu.Unlock()
+		return
+	}
+	buf := b.buf
+	b.buf = nil
+	onFree := b.onFree
+	b.mu.Unlock()
+
+	if b.pool != nil {
+		b.pool.put(buf)
+	}
+	if onFree != nil {
+		onFree()
+	}
+}
+
+// setOnFree sets the function that is called when the buffer is freed.
+func (b *sharedBuffer) setOnFree(onFree func()) {
+	b.mu.Lock()
+	b.onFree = onFree
+	b.mu.Unlock()
+}
+
+// ErrBufferReleased the part was released before it was read.
+var ErrBufferReleased = errors.New("part buffer was released")
+
+// bufferReader reads shared buffers in order without copying them, the
+// content is written directly to the destination by WriteTo. The
+// references are released by Close or when all content has been read.
+type bufferReader struct {
+	bufs []*sharedBuffer
+	cur  int
+	pos  int
+	err  error
+}
+
+// newBufferReader retains the buffers, the reader returns
+// ErrBufferReleased if any of them has already been freed.
+func newBufferReader(bufs ...*sharedBuffer) *bufferReader {
+	for i, b := range bufs {
+		if !b.retain() {
+			for _, retained := range bufs[:i] {
+				retained.release()
+			}
+			return &bufferReader{err: ErrBufferReleased}
+		}
+	}
+	return &bufferReader{bufs: bufs}
+}
+
+func (r *bufferReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n := 0
+	for n < len(p) && r.cur < len(r.bufs) {
+		buf := r.bufs[r.cur].buf
+		copied := copy(p[n:], buf[r.pos:])
+		n += copied
+		r.pos += copied
+		if r.pos == len(buf) {
+			r.cur++
+			r.pos = 0
+		}
+	}
+	if r.cur == len(r.bufs) {
+		r.Close()
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, used by io.Copy.
+func (r *bufferReader) WriteTo(w io.Writer) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	defer r.Close()
+
+	var total int64
+	for ; r.cur < len(r.bufs); r.cur++ {
+		n, err := w.Write(r.bufs[r.cur].buf[r.pos:])
+		total += int64(n)
+		if err != nil {
+			r.pos += n
+			return total, err
+		}
+		r.pos = 0
+	}
+	return total, nil
+}
+
+// Close releases the buffers.
+func (r *bufferReader) Close() error {
+	if r.err != nil {
+		return nil
+	}
+	for _, b := range r.bufs {
+		b.release()
+	}
+	r.bufs = nil
+	r.cur = 0
+	r.err = io.EOF
+	return nil
+}
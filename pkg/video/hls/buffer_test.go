@@ -0,0 +1,105 @@
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedBuffer(t *testing.T) {
+	freed := 0
+	buf := newSharedBuffer([]byte{1, 2})
+	buf.setOnFree(func() { freed++ })
+
+	require.True(t, buf.retain())
+	buf.release()
+	require.Equal(t, 0, freed)
+
+	buf.release()
+	require.Equal(t, 1, freed)
+	require.Nil(t, buf.buf)
+	require.False(t, buf.retain())
+}
+
+func TestBufferReader(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		buf1 := newSharedBuffer([]byte{1, 2, 3})
+		buf2 := newSharedBuffer([]byte{4, 5})
+		r := newBufferReader(buf1, buf2)
+		require.Equal(t, 2, buf1.refs)
+
+		p := make([]byte, 2)
+		n, err := r.Read(p)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2}, p[:n])
+
+		n, err = r.Read(p)
+		require.NoError(t, err)
+		require.Equal(t, []byte{3, 4}, p[:n])
+
+		n, err = r.Read(p)
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, []byte{5}, p[:n])
+		require.Equal(t, 1, buf1.refs)
+		require.Equal(t, 1, buf2.refs)
+	})
+	t.Run("writeTo", func(t *testing.T) {
+		buf1 := newSharedBuffer([]byte{1, 2})
+		buf2 := newSharedBuffer([]byte{3})
+		r := newBufferReader(buf1, buf2)
+
+		var w bytes.Buffer
+		n, err := io.Copy(&w, r)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), n)
+		require.Equal(t, []byte{1, 2, 3}, w.Bytes())
+		require.Equal(t, 1, buf1.refs)
+	})
+	t.Run("writeErr", func(t *testing.T) {
+		buf := newSharedBuffer([]byte{1, 2})
+		r := newBufferReader(buf)
+
+		_, err := r.WriteTo(errWriter{})
+		require.ErrorIs(t, err, errTestWrite)
+		require.Equal(t, 1, buf.refs)
+	})
+	t.Run("close", func(t *testing.T) {
+		freed := false
+		buf := newSharedBuffer([]byte{1})
+		buf.setOnFree(func() { freed = true })
+		r := newBufferReader(buf)
+
+		// The reader keeps the buffer after the owner released it.
+		buf.release()
+		require.False(t, freed)
+
+		require.NoError(t, r.Close())
+		require.True(t, freed)
+		require.NoError(t, r.Close())
+
+		_, err := r.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+	t.Run("released", func(t *testing.T) {
+		buf1 := newSharedBuffer([]byte{1})
+		buf2 := newSharedBuffer([]byte{2})
+		buf2.release()
+
+		r := newBufferReader(buf1, buf2)
+		require.Equal(t, 1, buf1.refs)
+
+		_, err := r.Read(make([]byte, 1))
+		require.ErrorIs(t, err, ErrBufferReleased)
+	})
+}
+
+var errTestWrite = errors.New("write")
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errTestWrite
+}
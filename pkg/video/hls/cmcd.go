@@ -0,0 +1,270 @@
+package hls
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CMCDQueryKey query parameter of the Common Media Client Data.
+const CMCDQueryKey = "CMCD"
+
+// CMCD Common Media Client Data (CTA-5004) the player sends with each
+// playlist and media request. Only the keys used by the viewer
+// statistics are kept, unknown keys are ignored.
+type CMCD struct {
+	SessionID        string        // sid
+	ObjectType       string        // ot
+	BufferLength     time.Duration // bl
+	BufferStarvation bool          // bs
+	Startup          bool          // su
+	ThroughputKbps   int           // mtp
+	BitrateKbps      int           // br
+
+	// False if the request didn't have any CMCD.
+	Present bool
+}
+
+// ErrCMCDInvalid invalid CMCD.
+var ErrCMCDInvalid = errors.New("invalid CMCD")
+
+// ParseCMCD parses the decoded value of the CMCD query parameter.
+// Keys are separated by commas, a key without a value is true.
+//
+//	bl=21300,br=3200,bs,ot=v,sid="6e2fb550-c457-11e9-bb97-0800200c9a66"
+func ParseCMCD(query string) (CMCD, error) {
+	var c CMCD
+	if query == "" {
+		return c, nil
+	}
+	for query != "" {
+		var pair string
+		pair, query = nextCMCDPair(query)
+		key, value, hasValue := strings.Cut(pair, "=")
+		if key == "" {
+			return CMCD{}, ErrCMCDInvalid
+		}
+
+		var err error
+		switch key {
+		case "sid":
+			c.SessionID, err = unquoteCMCD(value)
+		case "ot":
+			c.ObjectType = value
+		case "bl":
+			var ms int
+			ms, err = strconv.Atoi(value)
+			c.BufferLength = time.Duration(ms) * time.Millisecond
+		case "bs":
+			c.BufferStarvation = !hasValue || value == "true"
+		case "su":
+			c.Startup = !hasValue || value == "true"
+		case "mtp":
+			c.ThroughputKbps, err = strconv.Atoi(value)
+		case "br":
+			c.BitrateKbps, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return CMCD{}, ErrCMCDInvalid
+		}
+	}
+	c.Present = true
+	return c, nil
+}
+
+// nextCMCDPair returns the first key-value pair and the rest,
+// commas inside quoted strings don't separate the pairs.
+func nextCMCDPair(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+func unquoteCMCD(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", ErrCMCDInvalid
+	}
+	s = s[1 : len(s)-1]
+	s = strings.ReplaceAll(s, `\"`, `"`)
+	return strings.ReplaceAll(s, `\\`, `\`), nil
+}
+
+// ViewerStats playback statistics reported by the HLS viewers of a muxer.
+// The buffer, latency and bitrate values are averaged over the current
+// viewers. Latency is the estimated delay behind the live edge, from
+// the wall clock time of the requested media and the buffer length.
+type ViewerStats struct {
+	Viewers         int    `json:"viewers"`
+	StarvingViewers int    `json:"starvingViewers"`
+	Requests        uint64 `json:"requests"`
+	Starvations     uint64 `json:"starvations"`
+	Startups        uint64 `json:"startups"`
+
+	BufferLengthMs    float64 `json:"bufferLengthMs"`
+	MinBufferLengthMs float64 `json:"minBufferLengthMs"`
+	LatencyMs         float64 `json:"latencyMs"`
+	MaxLatencyMs      float64 `json:"maxLatencyMs"`
+	ThroughputKbps    float64 `json:"throughputKbps"`
+	BitrateKbps       float64 `json:"bitrateKbps"`
+}
+
+// Clients that haven't sent a request for
+// viewerTimeout are no longer counted as viewers.
+const viewerTimeout = 30 * time.Second
+
+// viewerStats aggregates the CMCD of the viewers by session ID.
+// Players that don't send a session ID are counted as one viewer.
+type viewerStats struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	viewers     map[string]*viewer
+	requests    uint64
+	starvations uint64
+	startups    uint64
+}
+
+type viewer struct {
+	lastSeen       time.Time
+	lastStarvation time.Time
+	bufferLength   time.Duration
+	latency        time.Duration
+	throughputKbps int
+	bitrateKbps    int
+}
+
+func newViewerStats() *viewerStats {
+	return &viewerStats{
+		now:     time.Now,
+		viewers: make(map[string]*viewer),
+	}
+}
+
+// record adds the CMCD of a request. mediaStart is the wall clock
+// time of the requested media, zero for playlists and unknown media.
+func (s *viewerStats) record(c CMCD, mediaStart time.Time) {
+	if !c.Present {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	v, exist := s.viewers[c.SessionID]
+	if !exist {
+		v = &viewer{}
+		s.viewers[c.SessionID] = v
+	}
+	v.lastSeen = now
+	v.bufferLength = c.BufferLength
+	if c.ThroughputKbps != 0 {
+		v.throughputKbps = c.ThroughputKbps
+	}
+	if c.BitrateKbps != 0 {
+		v.bitrateKbps = c.BitrateKbps
+	}
+	if !mediaStart.IsZero() {
+		// The playback position is the buffer
+		// length behind the requested media.
+		if latency := now.Sub(mediaStart) + c.BufferLength; latency > 0 {
+			v.latency = latency
+		}
+	}
+
+	s.requests++
+	if c.BufferStarvation {
+		s.starvations++
+		v.lastStarvation = now
+	}
+	if c.Startup {
+		s.startups++
+	}
+}
+
+// prune must be called with lock held.
+func (s *viewerStats) prune(now time.Time) {
+	for id, v := range s.viewers {
+		if now.Sub(v.lastSeen) > viewerTimeout {
+			delete(s.viewers, id)
+		}
+	}
+}
+
+func (s *viewerStats) stats() ViewerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	stats := ViewerStats{
+		Viewers:     len(s.viewers),
+		Requests:    s.requests,
+		Starvations: s.starvations,
+		Startups:    s.startups,
+	}
+	if len(s.viewers) == 0 {
+		return stats
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	minBuffer := time.Duration(-1)
+	var bufferSum, latencySum, maxLatency time.Duration
+	var latencyCount, throughputSum, throughputCount, bitrateSum, bitrateCount int
+	for _, v := range s.viewers {
+		if !v.lastStarvation.IsZero() && now.Sub(v.lastStarvation) <= viewerTimeout {
+			stats.StarvingViewers++
+		}
+		bufferSum += v.bufferLength
+		if minBuffer == -1 || v.bufferLength < minBuffer {
+			minBuffer = v.bufferLength
+		}
+		if v.latency != 0 {
+			latencySum += v.latency
+			latencyCount++
+			if v.latency > maxLatency {
+				maxLatency = v.latency
+			}
+		}
+		if v.throughputKbps != 0 {
+			throughputSum += v.throughputKbps
+			throughputCount++
+		}
+		if v.bitrateKbps != 0 {
+			bitrateSum += v.bitrateKbps
+			bitrateCount++
+		}
+	}
+
+	stats.BufferLengthMs = ms(bufferSum) / float64(len(s.viewers))
+	stats.MinBufferLengthMs = ms(minBuffer)
+	if latencyCount != 0 {
+		stats.LatencyMs = ms(latencySum) / float64(latencyCount)
+		stats.MaxLatencyMs = ms(maxLatency)
+	}
+	if throughputCount != 0 {
+		stats.ThroughputKbps = float64(throughputSum) / float64(throughputCount)
+	}
+	if bitrateCount != 0 {
+		stats.BitrateKbps = float64(bitrateSum) / float64(bitrateCount)
+	}
+	return stats
+}
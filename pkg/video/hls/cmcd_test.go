@@ -0,0 +1,145 @@
+package hls
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCMCD(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected CMCD
+		err      error
+	}{
+		"empty": {"", CMCD{}, nil},
+		"full": {
+			`bl=21300,br=3200,bs,mtp=25400,ot=v,sid="6e2f,b5",su`,
+			CMCD{
+				SessionID:        "6e2f,b5",
+				ObjectType:       "v",
+				BufferLength:     21300 * time.Millisecond,
+				BufferStarvation: true,
+				Startup:          true,
+				ThroughputKbps:   25400,
+				BitrateKbps:      3200,
+				Present:          true,
+			},
+			nil,
+		},
+		"escaped":    {`sid="a\"b"`, CMCD{SessionID: `a"b`, Present: true}, nil},
+		"unknownKey": {`nor="seg2.mp4",bl=100`, CMCD{BufferLength: 100 * time.Millisecond, Present: true}, nil},
+		"invalidInt": {`bl=x`, CMCD{}, ErrCMCDInvalid},
+		"unquoted":   {`sid=abc`, CMCD{}, ErrCMCDInvalid},
+		"emptyKey":   {`=1`, CMCD{}, ErrCMCDInvalid},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c, err := ParseCMCD(tc.input)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.expected, c)
+		})
+	}
+}
+
+func TestViewerStats(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newViewerStats()
+	s.now = func() time.Time { return now }
+
+	require.Equal(t, ViewerStats{}, s.stats())
+
+	// Requests without CMCD are ignored.
+	s.record(CMCD{}, time.Time{})
+	require.Equal(t, ViewerStats{}, s.stats())
+
+	s.record(CMCD{
+		SessionID:      "a",
+		BufferLength:   2 * time.Second,
+		Startup:        true,
+		ThroughputKbps: 1000,
+		BitrateKbps:    500,
+		Present:        true,
+	}, now.Add(-3*time.Second))
+	s.record(CMCD{
+		SessionID:        "b",
+		BufferStarvation: true,
+		Present:          true,
+	}, time.Time{})
+
+	require.Equal(t, ViewerStats{
+		Viewers:           2,
+		StarvingViewers:   1,
+		Requests:          2,
+		Starvations:       1,
+		Startups:          1,
+		BufferLengthMs:    1000,
+		MinBufferLengthMs: 0,
+		LatencyMs:         5000,
+		MaxLatencyMs:      5000,
+		ThroughputKbps:    1000,
+		BitrateKbps:       500,
+	}, s.stats())
+
+	// The starvation is no longer current.
+	now = now.Add(20 * time.Second)
+	s.record(CMCD{SessionID: "b", BufferLength: time.Second, Present: true}, time.Time{})
+	now = now.Add(15 * time.Second)
+	stats := s.stats()
+	require.Equal(t, 1, stats.Viewers)
+	require.Equal(t, 0, stats.StarvingViewers)
+	require.Equal(t, uint64(1), stats.Starvations)
+	require.Equal(t, float64(1000), stats.BufferLengthMs)
+
+	// Viewers time out.
+	now = now.Add(time.Minute)
+	stats = s.stats()
+	require.Equal(t, 0, stats.Viewers)
+	require.Equal(t, uint64(3), stats.Requests)
+}
+
+func TestMuxerViewerStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const clockRate = 44100
+	m := NewMuxer(
+		ctx,
+		20,
+		time.Second,
+		200*time.Millisecond,
+		50000000,
+		func(log.Level, string, ...interface{}) {},
+		false,
+		nil,
+		true,
+		func() int { return clockRate },
+		func() (*StreamInfo, error) { return &StreamInfo{AudioTrackExist: true}, nil },
+		nil,
+		nil,
+		nil,
+	)
+
+	sampleDuration := time.Second * 1024 / clockRate
+	for pts := time.Duration(0); pts < 1500*time.Millisecond; pts += sampleDuration {
+		require.NoError(t, m.WriteAAC(time.Now(), pts, []byte{1, 2}))
+	}
+	seg, err := m.NextSegment(0)
+	require.NoError(t, err)
+
+	cmcd := CMCD{SessionID: "a", BufferLength: time.Hour, Present: true}
+	res := m.File(seg.name+".mp4", "", "", "", cmcd)
+	require.Equal(t, http.StatusOK, res.Status)
+	res = m.File("stream.m3u8", "", "", "", cmcd)
+	require.Equal(t, http.StatusOK, res.Status)
+
+	stats := m.ViewerStats()
+	require.Equal(t, 1, stats.Viewers)
+	require.Equal(t, uint64(2), stats.Requests)
+	require.GreaterOrEqual(t, stats.LatencyMs, float64(time.Hour/time.Millisecond))
+}
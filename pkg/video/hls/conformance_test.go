@@ -0,0 +1,645 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Low-Latency HLS conformance tests. The muxer is fed by a synthetic
+// H264 source and the output is checked against the rules in
+// draft-pantos-hls-rfc8216bis.
+
+const (
+	conformanceFPS             = 30
+	conformanceGOPSize         = 30
+	conformanceSegmentCount    = 14
+	conformanceSegmentDuration = time.Second
+	conformancePartDuration    = 200 * time.Millisecond
+)
+
+var (
+	// 352x288, pic_order_cnt_type 2. DTS is equal to PTS.
+	conformanceSPS = []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	conformancePPS = []byte{0x68, 0xee, 0x3c, 0x80}
+)
+
+type conformanceHarness struct {
+	t     *testing.T
+	m     *Muxer
+	frame int
+}
+
+func newConformanceHarness(ctx context.Context, t *testing.T) *conformanceHarness {
+	t.Helper()
+	m := NewMuxer(
+		ctx,
+		conformanceSegmentCount,
+		conformanceSegmentDuration,
+		conformancePartDuration,
+		50000000,
+		func(log.Level, string, ...interface{}) {},
+		true,
+		func() []byte { return conformanceSPS },
+		false,
+		nil,
+		func() (*StreamInfo, error) {
+			return &StreamInfo{
+				VideoTrackExist: true,
+				VideoSPS:        conformanceSPS,
+				VideoPPS:        conformancePPS,
+			}, nil
+		},
+		nil,
+		nil,
+		nil,
+	)
+	return &conformanceHarness{t: t, m: m}
+}
+
+// writeVideo writes frames from the synthetic source.
+// The first frame of every GOP is a IDR.
+func (h *conformanceHarness) writeVideo(duration time.Duration) {
+	h.t.Helper()
+	frames := int(duration * conformanceFPS / time.Second)
+	for i := 0; i < frames; i++ {
+		pts := time.Duration(h.frame) * time.Second / conformanceFPS
+
+		var nalus [][]byte
+		if h.frame%conformanceGOPSize == 0 {
+			nalus = [][]byte{conformanceSPS, conformancePPS, {0x65, 0x88, 0x84, 0x00}}
+		} else {
+			nalus = [][]byte{{0x41, 0x9a, 0x24, 0x00}}
+		}
+		require.NoError(h.t, h.m.WriteH264(time.Now(), pts, nalus))
+		h.frame++
+	}
+}
+
+func (h *conformanceHarness) get(name, msn, part, skip string) (int, []byte) {
+	h.t.Helper()
+	res := h.m.File(name, msn, part, skip, CMCD{})
+	if res.Body == nil {
+		return res.Status, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	require.NoError(h.t, err)
+	return res.Status, body
+}
+
+type conformanceResponse struct {
+	status int
+	body   []byte
+}
+
+// getAsync makes the request in a goroutine, used for blocking requests.
+func (h *conformanceHarness) getAsync(name, msn, part, skip string) chan conformanceResponse {
+	res := make(chan conformanceResponse, 1)
+	go func() {
+		r := h.m.File(name, msn, part, skip, CMCD{})
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+		}
+		res <- conformanceResponse{status: r.Status, body: body}
+	}()
+	return res
+}
+
+func (h *conformanceHarness) playlist(msn, part, skip string) *mediaPlaylist {
+	h.t.Helper()
+	status, body := h.get("stream.m3u8", msn, part, skip)
+	require.Equal(h.t, http.StatusOK, status)
+	return parseMediaPlaylist(h.t, body)
+}
+
+func requireBlocked(t *testing.T, res chan conformanceResponse) {
+	t.Helper()
+	select {
+	case r := <-res:
+		t.Fatalf("request returned %v, expected it to block", r.status)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func requireResponse(t *testing.T, res chan conformanceResponse) conformanceResponse {
+	t.Helper()
+	select {
+	case r := <-res:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return conformanceResponse{}
+	}
+}
+
+type mediaPlaylistPart struct {
+	uri         string
+	duration    float64
+	independent bool
+}
+
+type mediaPlaylistSegment struct {
+	uri      string
+	duration float64
+	gap      bool
+	parts    []mediaPlaylistPart
+}
+
+type mediaPlaylist struct {
+	version        int
+	targetDuration float64
+	canBlockReload bool
+	partHoldBack   float64
+	canSkipUntil   float64
+	partTarget     float64
+	mediaSequence  uint64
+	skipped        int
+	hasMap         bool
+	segments       []mediaPlaylistSegment
+
+	// Parts of the segment that is not yet complete.
+	nextParts    []mediaPlaylistPart
+	preloadHints []string
+}
+
+// lastMSN returns the media sequence number of the last segment.
+func (p *mediaPlaylist) lastMSN() uint64 {
+	return p.mediaSequence + uint64(p.skipped+len(p.segments)) - 1
+}
+
+// parseAttributes parses a attribute list, quotes are removed from values.
+func parseAttributes(t *testing.T, list string) map[string]string {
+	t.Helper()
+	attrs := make(map[string]string)
+	for list != "" {
+		i := strings.IndexByte(list, '=')
+		require.NotEqual(t, -1, i, "invalid attribute list")
+		key := list[:i]
+		list = list[i+1:]
+
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			require.NotEqual(t, -1, end, "unterminated quoted string")
+			value = list[1 : end+1]
+			list = list[end+2:]
+		} else {
+			end := strings.IndexByte(list, ',')
+			if end == -1 {
+				end = len(list)
+			}
+			value = list[:end]
+			list = list[end:]
+		}
+		list = strings.TrimPrefix(list, ",")
+
+		_, exist := attrs[key]
+		require.False(t, exist, "duplicate attribute %v", key)
+		attrs[key] = value
+	}
+	return attrs
+}
+
+func parseFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	v, err := strconv.ParseFloat(s, 64)
+	require.NoError(t, err)
+	return v
+}
+
+func parseMediaPlaylist(t *testing.T, raw []byte) *mediaPlaylist { //nolint:funlen
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n")
+	require.Equal(t, "#EXTM3U", lines[0])
+
+	p := &mediaPlaylist{}
+	var cur mediaPlaylistSegment
+	var extinf bool
+	for _, line := range lines[1:] {
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "":
+
+		case "#EXT-X-VERSION":
+			v, err := strconv.Atoi(value)
+			require.NoError(t, err)
+			p.version = v
+
+		case "#EXT-X-TARGETDURATION":
+			p.targetDuration = parseFloat(t, value)
+
+		case "#EXT-X-SERVER-CONTROL":
+			attrs := parseAttributes(t, value)
+			p.canBlockReload = attrs["CAN-BLOCK-RELOAD"] == "YES"
+			p.partHoldBack = parseFloat(t, attrs["PART-HOLD-BACK"])
+			p.canSkipUntil = parseFloat(t, attrs["CAN-SKIP-UNTIL"])
+
+		case "#EXT-X-PART-INF":
+			p.partTarget = parseFloat(t, parseAttributes(t, value)["PART-TARGET"])
+
+		case "#EXT-X-MEDIA-SEQUENCE":
+			v, err := strconv.ParseUint(value, 10, 64)
+			require.NoError(t, err)
+			p.mediaSequence = v
+
+		case "#EXT-X-SKIP":
+			v, err := strconv.Atoi(parseAttributes(t, value)["SKIPPED-SEGMENTS"])
+			require.NoError(t, err)
+			p.skipped = v
+
+		case "#EXT-X-MAP":
+			p.hasMap = true
+
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			_, err := time.Parse("2006-01-02T15:04:05.999Z07:00", value)
+			require.NoError(t, err)
+
+		case "#EXT-X-GAP":
+			cur.gap = true
+
+		case "#EXT-X-PART":
+			attrs := parseAttributes(t, value)
+			cur.parts = append(cur.parts, mediaPlaylistPart{
+				uri:         attrs["URI"],
+				duration:    parseFloat(t, attrs["DURATION"]),
+				independent: attrs["INDEPENDENT"] == "YES",
+			})
+
+		case "#EXTINF":
+			duration, _, _ := strings.Cut(value, ",")
+			cur.duration = parseFloat(t, duration)
+			extinf = true
+
+		case "#EXT-X-PRELOAD-HINT":
+			attrs := parseAttributes(t, value)
+			require.Equal(t, "PART", attrs["TYPE"])
+			p.preloadHints = append(p.preloadHints, attrs["URI"])
+
+		default:
+			require.False(t, strings.HasPrefix(line, "#"), "unexpected tag: %v", line)
+			require.True(t, extinf, "URI without EXTINF: %v", line)
+			cur.uri = line
+			p.segments = append(p.segments, cur)
+			cur = mediaPlaylistSegment{}
+			extinf = false
+		}
+	}
+	require.False(t, extinf, "EXTINF without URI")
+	require.False(t, cur.gap, "EXT-X-GAP without segment")
+	p.nextParts = cur.parts
+
+	return p
+}
+
+// requireConformance checks the rules that apply to every media playlist.
+func requireConformance(t *testing.T, p *mediaPlaylist) { //nolint:funlen
+	t.Helper()
+
+	// EXT-X-PART-INF and EXT-X-SKIP require version 9.
+	require.GreaterOrEqual(t, p.version, 9)
+	require.True(t, p.canBlockReload)
+
+	// The EXTINF duration of each Media Segment in a Playlist file, when
+	// rounded to the nearest integer, MUST be less than or equal to the
+	// Target Duration.
+	for _, seg := range p.segments {
+		require.LessOrEqual(t, math.Round(seg.duration), p.targetDuration, seg.uri)
+	}
+
+	// PART-HOLD-BACK MUST be at least twice the Part Target Duration.
+	require.GreaterOrEqual(t, p.partHoldBack, 2*p.partTarget)
+
+	// The Skip Boundary MUST be at least six times the Target Duration.
+	require.GreaterOrEqual(t, p.canSkipUntil, 6*p.targetDuration)
+
+	allParts := append([]mediaPlaylistPart(nil), p.nextParts...)
+	for _, seg := range p.segments {
+		if seg.gap {
+			require.Empty(t, seg.parts, "gap with parts")
+			continue
+		}
+		allParts = append(allParts, seg.parts...)
+
+		// The parts must add up to the parent segment.
+		if len(seg.parts) != 0 {
+			var sum float64
+			for _, part := range seg.parts {
+				sum += part.duration
+			}
+			require.InDelta(t, seg.duration, sum, 0.001, seg.uri)
+
+			// Segments start with a IDR.
+			require.True(t, seg.parts[0].independent, seg.uri)
+		}
+	}
+
+	// The duration of a Partial Segment MUST be less
+	// than or equal to the Part Target Duration.
+	partURIs := make(map[string]struct{})
+	for _, part := range allParts {
+		require.LessOrEqual(t, part.duration, p.partTarget, part.uri)
+		partURIs[part.uri] = struct{}{}
+	}
+
+	// A Playlist MUST NOT contain more than one EXT-X-PRELOAD-HINT
+	// tag with the same TYPE attribute. The hint must be the next part.
+	require.Len(t, p.preloadHints, 1)
+	require.NotContains(t, partURIs, p.preloadHints[0])
+
+	// Delta updates doesn't contain EXT-X-MAP.
+	require.Equal(t, p.skipped == 0, p.hasMap)
+}
+
+func partIDFromURI(t *testing.T, uri string) uint64 {
+	t.Helper()
+	id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(uri, "part"), ".mp4"), 10, 64)
+	require.NoError(t, err)
+	return id
+}
+
+func TestConformance(t *testing.T) { //nolint:funlen
+	t.Run("notFoundBeforeContent", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		status, _ := h.get("stream.m3u8", "", "", "")
+		require.Equal(t, http.StatusNotFound, status)
+		status, _ = h.get(KeyFileName, "", "", "")
+		require.Equal(t, http.StatusNotFound, status)
+	})
+	t.Run("playlist", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(1500 * time.Millisecond)
+		p := h.playlist("", "", "")
+		requireConformance(t, p)
+		require.Equal(t, uint64(0), p.mediaSequence)
+		require.Len(t, p.segments, 8)
+		for _, seg := range p.segments[:7] {
+			require.True(t, seg.gap)
+		}
+		require.Equal(t, "seg7.mp4", p.segments[7].uri)
+		require.Equal(t, 1.0, p.segments[7].duration)
+		require.Equal(t, 1.0, p.targetDuration)
+		require.NotEmpty(t, p.nextParts)
+
+		// Fill the playlist and check it on every segment.
+		for i := 0; i < 2*conformanceSegmentCount; i++ {
+			h.writeVideo(conformanceSegmentDuration)
+			p := h.playlist("", "", "")
+			requireConformance(t, p)
+			require.LessOrEqual(t, len(p.segments), conformanceSegmentCount)
+			require.Equal(t, "seg"+strconv.FormatUint(p.lastMSN(), 10)+".mp4",
+				p.segments[len(p.segments)-1].uri, "media sequence number mismatch")
+		}
+
+		status, body := h.get("init.mp4", "", "", "")
+		require.Equal(t, http.StatusOK, status)
+		require.NotEmpty(t, body)
+	})
+	t.Run("segmentsAndParts", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(3 * time.Second)
+		p := h.playlist("", "", "")
+		for _, seg := range p.segments {
+			if seg.gap {
+				continue
+			}
+			status, body := h.get(seg.uri, "", "", "")
+			require.Equal(t, http.StatusOK, status, seg.uri)
+
+			// The segment is the concatenation of its parts.
+			var parts []byte
+			for _, part := range seg.parts {
+				status, partBody := h.get(part.uri, "", "", "")
+				require.Equal(t, http.StatusOK, status, part.uri)
+				parts = append(parts, partBody...)
+			}
+			if len(seg.parts) != 0 {
+				require.Equal(t, parts, body, seg.uri)
+			}
+		}
+
+		for _, name := range []string{"seg999.mp4", "gap.mp4", "stream.txt", "foo.mp4"} {
+			status, _ := h.get(name, "", "", "")
+			require.Equal(t, http.StatusNotFound, status, name)
+		}
+	})
+	t.Run("blockingPart", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(1500 * time.Millisecond)
+		p := h.playlist("", "", "")
+		hint := p.preloadHints[0]
+
+		// The Server MUST hold a request for the preload hint part
+		// until the part is available.
+		res := h.getAsync(hint, "", "", "")
+		requireBlocked(t, res)
+
+		// Parts after the preload hint are not available.
+		nextID := partIDFromURI(t, hint) + 1
+		status, _ := h.get(partName(nextID)+".mp4", "", "", "")
+		require.Equal(t, http.StatusNotFound, status)
+
+		h.writeVideo(conformancePartDuration * 2)
+		r := requireResponse(t, res)
+		require.Equal(t, http.StatusOK, r.status)
+		require.NotEmpty(t, r.body)
+
+		// The hinted part is listed in the new playlist.
+		p = h.playlist("", "", "")
+		requireConformance(t, p)
+		var found bool
+		for _, part := range p.nextParts {
+			if part.uri == hint {
+				found = true
+			}
+		}
+		for _, seg := range p.segments {
+			for _, part := range seg.parts {
+				if part.uri == hint {
+					found = true
+				}
+			}
+		}
+		require.True(t, found, "preload hint %v not listed", hint)
+	})
+	t.Run("blockingReload", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(1500 * time.Millisecond)
+		p := h.playlist("", "", "")
+		nextMSN := p.lastMSN() + 1
+		nextPart := len(p.nextParts)
+
+		// Existing parts and segments are returned immediately.
+		r := requireResponse(t, h.getAsync("stream.m3u8",
+			strconv.FormatUint(p.lastMSN(), 10), "", ""))
+		require.Equal(t, http.StatusOK, r.status)
+		r = requireResponse(t, h.getAsync("stream.m3u8",
+			strconv.FormatUint(nextMSN, 10), "0", ""))
+		require.Equal(t, http.StatusOK, r.status)
+
+		// The Server MUST hold the request until the Playlist contains
+		// a Partial Segment with the Part Index or later.
+		msn := strconv.FormatUint(nextMSN, 10)
+		partRes := h.getAsync("stream.m3u8", msn, strconv.Itoa(nextPart), "")
+		// Without _HLS_part the request is held until the segment is complete.
+		segRes := h.getAsync("stream.m3u8", msn, "", "")
+		// Two requests for the same future part.
+		partRes2 := h.getAsync("stream.m3u8", msn, strconv.Itoa(nextPart), "YES")
+		requireBlocked(t, partRes)
+		requireBlocked(t, segRes)
+		requireBlocked(t, partRes2)
+
+		h.writeVideo(conformancePartDuration)
+		for _, res := range []chan conformanceResponse{partRes, partRes2} {
+			r := requireResponse(t, res)
+			require.Equal(t, http.StatusOK, r.status)
+			p := parseMediaPlaylist(t, r.body)
+			requireConformance(t, p)
+			require.True(t, p.lastMSN() >= nextMSN || len(p.nextParts) > nextPart)
+		}
+		requireBlocked(t, segRes)
+
+		h.writeVideo(conformanceSegmentDuration)
+		r = requireResponse(t, segRes)
+		require.Equal(t, http.StatusOK, r.status)
+		p = parseMediaPlaylist(t, r.body)
+		requireConformance(t, p)
+		require.GreaterOrEqual(t, p.lastMSN(), nextMSN)
+
+		// If the Client requests a Part Index greater than that of the
+		// final Partial Segment of the Parent Segment, the Server MUST
+		// treat the request as one for Part Index 0 of the following
+		// Parent Segment.
+		lastSeg := p.segments[len(p.segments)-1]
+		r = requireResponse(t, h.getAsync("stream.m3u8",
+			strconv.FormatUint(p.lastMSN(), 10), strconv.Itoa(len(lastSeg.parts)), ""))
+		require.Equal(t, http.StatusOK, r.status)
+	})
+	t.Run("badRequest", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(1500 * time.Millisecond)
+		p := h.playlist("", "", "")
+
+		// If the _HLS_msn is greater than the Media Sequence Number of
+		// the last Media Segment in the current Playlist plus two, then
+		// the server SHOULD immediately return Bad Request.
+		tooFar := strconv.FormatUint(p.lastMSN()+3, 10)
+		status, _ := h.get("stream.m3u8", tooFar, "", "")
+		require.Equal(t, http.StatusBadRequest, status)
+		status, _ = h.get("stream.m3u8", tooFar, "0", "")
+		require.Equal(t, http.StatusBadRequest, status)
+
+		// Plus two is held.
+		res := h.getAsync("stream.m3u8", strconv.FormatUint(p.lastMSN()+2, 10), "0", "")
+		requireBlocked(t, res)
+
+		cases := map[string]struct {
+			msn  string
+			part string
+		}{
+			"partWithoutMSN": {"", "0"},
+			"invalidMSN":     {"a", ""},
+			"negativeMSN":    {"-1", ""},
+			"invalidPart":    {"7", "b"},
+		}
+		for name, tc := range cases {
+			t.Run(name, func(t *testing.T) {
+				status, _ := h.get("stream.m3u8", tc.msn, tc.part, "")
+				require.Equal(t, http.StatusBadRequest, status)
+			})
+		}
+
+		// Held requests are released when the muxer is closed.
+		cancel()
+		require.Equal(t, http.StatusInternalServerError, requireResponse(t, res).status)
+	})
+	t.Run("skip", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		h := newConformanceHarness(ctx, t)
+
+		h.writeVideo(1500 * time.Millisecond)
+		for i := 0; i < conformanceSegmentCount; i++ {
+			full := h.playlist("", "", "")
+			for _, skip := range []string{"YES", "v2"} {
+				delta := h.playlist("", "", skip)
+				requireConformance(t, delta)
+				requireSkipBoundary(t, full, delta)
+			}
+			h.writeVideo(conformanceSegmentDuration)
+		}
+
+		full := h.playlist("", "", "")
+		delta := h.playlist("", "", "YES")
+		require.NotZero(t, delta.skipped, "nothing was skipped")
+
+		// Only the Skip Directive is a delta update.
+		p := h.playlist("", "", "NO")
+		require.Zero(t, p.skipped)
+		require.Equal(t, full, p)
+
+		// Blocking request with delta update.
+		res := h.getAsync("stream.m3u8",
+			strconv.FormatUint(full.lastMSN()+1, 10), "", "YES")
+		requireBlocked(t, res)
+		h.writeVideo(conformanceSegmentDuration)
+		r := requireResponse(t, res)
+		require.Equal(t, http.StatusOK, r.status)
+		delta = parseMediaPlaylist(t, r.body)
+		requireConformance(t, delta)
+		require.NotZero(t, delta.skipped)
+	})
+}
+
+// requireSkipBoundary checks that the delta update is the full
+// playlist with segments before the Skip Boundary removed.
+func requireSkipBoundary(t *testing.T, full *mediaPlaylist, delta *mediaPlaylist) {
+	t.Helper()
+	require.Equal(t, full.mediaSequence, delta.mediaSequence)
+	require.Equal(t, len(full.segments), delta.skipped+len(delta.segments))
+	require.Equal(t, full.segments[delta.skipped:], delta.segments)
+	require.Equal(t, full.nextParts, delta.nextParts)
+	require.Equal(t, full.preloadHints, delta.preloadHints)
+
+	// The Server MUST NOT skip Media Segments that start
+	// within the Skip Boundary from the end of the Playlist.
+	var fromEnd float64
+	for i := len(full.segments) - 1; i >= 0; i-- {
+		fromEnd += full.segments[i].duration
+		if i < delta.skipped {
+			require.Greater(t, fromEnd, delta.canSkipUntil,
+				"segment %v is within the skip boundary", i)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package hls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"nvr/pkg/video/mp4"
+	"sync/atomic"
+)
+
+// Common encryption schemes, ISO/IEC 23001-7.
+const (
+	// EncryptionCENC AES-CTR full sample and subsample encryption.
+	EncryptionCENC = "cenc"
+
+	// EncryptionCBCS AES-CBC pattern encryption with a constant IV.
+	EncryptionCBCS = "cbcs"
+)
+
+// KeyFileName name of the file that delivers the content key.
+const KeyFileName = "stream.key"
+
+const (
+	encryptionKeySize = 16
+	cencIVSize        = 8
+
+	// cbcs video pattern, 1 encrypted block followed by 9 clear blocks.
+	cbcsCryptByteBlock = 1
+	cbcsSkipByteBlock  = 9
+)
+
+// ErrInvalidEncryptionScheme invalid encryption scheme.
+var ErrInvalidEncryptionScheme = errors.New("invalid encryption scheme")
+
+// ValidateEncryptionScheme returns error if the scheme isn't supported.
+func ValidateEncryptionScheme(scheme string) error {
+	switch scheme {
+	case EncryptionCENC, EncryptionCBCS:
+		return nil
+	}
+	return fmt.Errorf("%w: '%v'", ErrInvalidEncryptionScheme, scheme)
+}
+
+// Encryptor encrypts the fMP4 samples of a muxer using common encryption.
+// The key is generated when the encryptor is created and
+// is delivered to clients through the key file.
+type Encryptor struct {
+	scheme     string
+	key        []byte
+	kid        [16]byte
+	constantIV []byte
+	block      cipher.Block
+
+	// Per sample IV, only used by cenc.
+	nextIV uint64
+}
+
+// NewEncryptor allocates a Encryptor with a random key.
+// Scheme must be "cenc" or "cbcs".
+func NewEncryptor(scheme string) (*Encryptor, error) {
+	if err := ValidateEncryptionScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	e := &Encryptor{
+		scheme: scheme,
+		key:    make([]byte, encryptionKeySize),
+	}
+	if _, err := rand.Read(e.key); err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	if _, err := rand.Read(e.kid[:]); err != nil {
+		return nil, fmt.Errorf("generate key id: %w", err)
+	}
+
+	if scheme == EncryptionCBCS {
+		e.constantIV = make([]byte, aes.BlockSize)
+		if _, err := rand.Read(e.constantIV); err != nil {
+			return nil, fmt.Errorf("generate iv: %w", err)
+		}
+	} else {
+		iv := make([]byte, 8)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, fmt.Errorf("generate iv: %w", err)
+		}
+		e.nextIV = binary.BigEndian.Uint64(iv)
+	}
+
+	var err error
+	e.block, err = aes.NewCipher(e.key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+
+	return e, nil
+}
+
+// playlistMethod returns the EXT-X-KEY method of the scheme.
+func (e *Encryptor) playlistMethod() string {
+	if e.scheme == EncryptionCENC {
+		return "SAMPLE-AES-CTR"
+	}
+	return "SAMPLE-AES"
+}
+
+func (e *Encryptor) keyTag() string {
+	return "#EXT-X-KEY:METHOD=" + e.playlistMethod() +
+		",URI=\"" + KeyFileName + "\",KEYFORMAT=\"identity\"\n"
+}
+
+func (e *Encryptor) ivSize() int {
+	if e.scheme == EncryptionCENC {
+		return cencIVSize
+	}
+	return 0
+}
+
+// sinf returns the protection scheme information box
+// of a sample entry with the original format.
+func (e *Encryptor) sinf(format mp4.BoxType, isVideo bool) mp4.Boxes {
+	/*
+	   sinf
+	   - frma
+	   - schm
+	   - schi
+	     - tenc
+	*/
+
+	tenc := &mp4.Tenc{
+		DefaultIsProtected:     1,
+		DefaultPerSampleIVSize: uint8(e.ivSize()),
+		DefaultKID:             e.kid,
+	}
+	if e.scheme == EncryptionCBCS {
+		tenc.FullBox.Version = 1
+		tenc.DefaultConstantIV = e.constantIV
+		// Tracks other than video use whole block full sample encryption.
+		if isVideo {
+			tenc.DefaultCryptByteBlock = cbcsCryptByteBlock
+			tenc.DefaultSkipByteBlock = cbcsSkipByteBlock
+		}
+	}
+
+	var schemeType [4]byte
+	copy(schemeType[:], e.scheme)
+
+	return mp4.Boxes{
+		Box: &mp4.Sinf{},
+		Children: []mp4.Boxes{
+			{Box: &mp4.Frma{DataFormat: format}},
+			{Box: &mp4.Schm{
+				SchemeType:    schemeType,
+				SchemeVersion: 0x00010000,
+			}},
+			{
+				Box:      &mp4.Schi{},
+				Children: []mp4.Boxes{{Box: tenc}},
+			},
+		},
+	}
+}
+
+// encryptedSampleEntry replaces the type of a sample entry with encv or enca.
+type encryptedSampleEntry struct {
+	mp4.ImmutableBox
+	typ mp4.BoxType
+}
+
+func (b *encryptedSampleEntry) Type() mp4.BoxType { return b.typ }
+
+// encryptSampleEntry converts the sample entry to a protected sample entry.
+func (e *Encryptor) encryptSampleEntry(entry *mp4.Boxes, isVideo bool) {
+	typ := mp4.BoxType{'e', 'n', 'c', 'a'}
+	if isVideo {
+		typ = mp4.BoxType{'e', 'n', 'c', 'v'}
+	}
+	entry.Children = append(entry.Children, e.sinf(entry.Box.Type(), isVideo))
+	entry.Box = &encryptedSampleEntry{
+		ImmutableBox: entry.Box,
+		typ:          typ,
+	}
+}
+
+func (e *Encryptor) genIV() []byte {
+	if e.scheme != EncryptionCENC {
+		return nil
+	}
+	iv := make([]byte, cencIVSize)
+	binary.BigEndian.PutUint64(iv, atomic.AddUint64(&e.nextIV, 1))
+	return iv
+}
+
+// encryptVideoSamples returns encrypted copies of the samples.
+// The samples themselves are left untouched since they are used by the recorder.
+func (e *Encryptor) encryptVideoSamples(
+	samples []*VideoSample,
+) ([]*VideoSample, []mp4.SencSample) {
+	encrypted := make([]*VideoSample, len(samples))
+	info := make([]mp4.SencSample, len(samples))
+	for i, sample := range samples {
+		s := *sample
+		s.AVCC = append([]byte(nil), sample.AVCC...)
+		info[i] = mp4.SencSample{
+			InitializationVector: e.genIV(),
+			Subsamples:           videoSubsamples(s.AVCC),
+		}
+		e.encrypt(s.AVCC, info[i], cbcsCryptByteBlock, cbcsSkipByteBlock)
+		encrypted[i] = &s
+	}
+	return encrypted, info
+}
+
+// encryptAudioSamples returns encrypted copies of the samples.
+func (e *Encryptor) encryptAudioSamples(
+	samples []*AudioSample,
+) ([]*AudioSample, []mp4.SencSample) {
+	encrypted := make([]*AudioSample, len(samples))
+	info := make([]mp4.SencSample, len(samples))
+	for i, sample := range samples {
+		s := *sample
+		s.AU = append([]byte(nil), sample.AU...)
+		info[i] = mp4.SencSample{InitializationVector: e.genIV()}
+		e.encrypt(s.AU, info[i], 0, 0)
+		encrypted[i] = &s
+	}
+	return encrypted, info
+}
+
+// encrypt encrypts the protected ranges of the sample in place. The whole
+// sample is protected if there are no subsamples. Crypt and skip byte blocks
+// is the cbcs pattern, 0 and 0 encrypts all blocks.
+func (e *Encryptor) encrypt(data []byte, info mp4.SencSample, cryptBlocks, skipBlocks int) {
+	ranges := info.Subsamples
+	if len(ranges) == 0 {
+		ranges = []mp4.SencSubsample{{BytesOfProtectedData: uint32(len(data))}}
+	}
+
+	if e.scheme == EncryptionCENC {
+		// The counter continues across all protected ranges of the sample.
+		counter := make([]byte, aes.BlockSize)
+		copy(counter, info.InitializationVector)
+		stream := cipher.NewCTR(e.block, counter)
+
+		pos := 0
+		for _, r := range ranges {
+			pos += int(r.BytesOfClearData)
+			protected := data[pos : pos+int(r.BytesOfProtectedData)]
+			stream.XORKeyStream(protected, protected)
+			pos += len(protected)
+		}
+		return
+	}
+
+	if cryptBlocks == 0 && skipBlocks == 0 {
+		cryptBlocks = 1
+	}
+
+	// The IV is reset at the start of every protected range and
+	// partial blocks at the end of a range are left unencrypted.
+	pos := 0
+	for _, r := range ranges {
+		pos += int(r.BytesOfClearData)
+		protected := data[pos : pos+int(r.BytesOfProtectedData)]
+		pos += len(protected)
+
+		mode := cipher.NewCBCEncrypter(e.block, e.constantIV)
+		for off := 0; off+aes.BlockSize <= len(protected); {
+			n := cryptBlocks * aes.BlockSize
+			if remaining := len(protected) - off; n > remaining {
+				n = remaining - remaining%aes.BlockSize
+			}
+			mode.CryptBlocks(protected[off:off+n], protected[off:off+n])
+			off += n + skipBlocks*aes.BlockSize
+		}
+	}
+}
+
+// videoSubsamples splits AVCC data into clear and protected ranges. The
+// length prefix, NALU header and non-VCL NALUs are left in the clear.
+// The protected part of every NALU is a multiple of the block size
+// which is valid for both cenc and cbcs.
+func videoSubsamples(avcc []byte) []mp4.SencSubsample {
+	var subsamples []mp4.SencSubsample
+	clear := 0
+	pos := 0
+	for pos+4 < len(avcc) {
+		size := int(binary.BigEndian.Uint32(avcc[pos:]))
+		end := pos + 4 + size
+		if end > len(avcc) || end < pos {
+			end = len(avcc)
+		}
+
+		typ := h264.NALUType(avcc[pos+4] & 0x1f)
+		isVCL := typ >= h264.NALUTypeNonIDR && typ <= h264.NALUTypeIDR
+
+		protected := ((end - pos - 5) / aes.BlockSize) * aes.BlockSize
+		if !isVCL || protected == 0 {
+			clear += end - pos
+			pos = end
+			continue
+		}
+
+		clear += end - pos - protected
+		subsamples = appendSubsample(subsamples, clear, protected)
+		clear = 0
+		pos = end
+	}
+
+	clear += len(avcc) - pos
+	if clear != 0 || len(subsamples) == 0 {
+		subsamples = appendSubsample(subsamples, clear, 0)
+	}
+	return subsamples
+}
+
+// appendSubsample splits clear ranges that doesn't fit in 16 bits.
+func appendSubsample(subsamples []mp4.SencSubsample, clear int, protected int) []mp4.SencSubsample {
+	for clear > 0xffff {
+		subsamples = append(subsamples, mp4.SencSubsample{BytesOfClearData: 0xffff})
+		clear -= 0xffff
+	}
+	return append(subsamples, mp4.SencSubsample{
+		BytesOfClearData:     uint16(clear),
+		BytesOfProtectedData: uint32(protected),
+	})
+}
+
+// sampleEncryptionBoxes returns the saiz, saio and senc boxes of
+// a traf. The saio offset must be set after the moof is complete.
+func (e *Encryptor) sampleEncryptionBoxes(info []mp4.SencSample, isVideo bool) []mp4.Boxes {
+	senc := &mp4.Senc{Samples: info}
+	if isVideo {
+		senc.FullBox.Flags = [3]byte{0, 0, mp4.SencUseSubsampleEncryption}
+	}
+
+	saiz := &mp4.Saiz{SampleCount: uint32(len(info))}
+	for _, sample := range info {
+		saiz.SampleInfoSize = append(saiz.SampleInfoSize, uint8(sample.FieldSize(senc.FullBox)))
+	}
+
+	// No auxiliary information when using a constant IV without subsamples.
+	if !isVideo && e.ivSize() == 0 {
+		return nil
+	}
+
+	return []mp4.Boxes{
+		{Box: saiz},
+		{Box: &mp4.Saio{OffsetV0: []uint32{0}}},
+		{Box: senc},
+	}
+}
+
+// setSaioOffsets points the saio boxes to the
+// sample data of the senc boxes in the same traf.
+func setSaioOffsets(moof mp4.Boxes) {
+	pos := 8 // Moof header.
+	for _, traf := range moof.Children {
+		childPos := pos + 8 // Traf header.
+		var saio *mp4.Saio
+		for _, child := range traf.Children {
+			switch b := child.Box.(type) {
+			case *mp4.Saio:
+				saio = b
+			case *mp4.Senc:
+				if saio != nil {
+					// Box header, FullBox and sample count.
+					saio.OffsetV0[0] = uint32(childPos + 16)
+				}
+			}
+			childPos += child.Size()
+		}
+		pos += traf.Size()
+	}
+}
@@ -0,0 +1,187 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"testing"
+
+	"nvr/pkg/video/mp4"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNALU(typ byte, size int) []byte {
+	nalu := make([]byte, 4+size)
+	binary.BigEndian.PutUint32(nalu, uint32(size))
+	nalu[4] = typ
+	for i := 5; i < len(nalu); i++ {
+		nalu[i] = byte(i)
+	}
+	return nalu
+}
+
+func TestVideoSubsamples(t *testing.T) {
+	cases := map[string]struct {
+		input    []byte
+		expected []mp4.SencSubsample
+	}{
+		"empty": {
+			nil,
+			[]mp4.SencSubsample{{BytesOfClearData: 0}},
+		},
+		"idr": {
+			newTestNALU(5, 40),
+			// 4 length, 1 header, 7 clear, 32 protected.
+			[]mp4.SencSubsample{{BytesOfClearData: 12, BytesOfProtectedData: 32}},
+		},
+		"smallIdr": {
+			newTestNALU(5, 10),
+			[]mp4.SencSubsample{{BytesOfClearData: 14}},
+		},
+		"spsAndIdr": {
+			append(newTestNALU(7, 10), newTestNALU(5, 17)...),
+			[]mp4.SencSubsample{{BytesOfClearData: 14 + 5, BytesOfProtectedData: 16}},
+		},
+		"idrAndSEI": {
+			append(newTestNALU(5, 17), newTestNALU(6, 10)...),
+			[]mp4.SencSubsample{
+				{BytesOfClearData: 5, BytesOfProtectedData: 16},
+				{BytesOfClearData: 14},
+			},
+		},
+		"largeClear": {
+			newTestNALU(6, 0x10000),
+			[]mp4.SencSubsample{
+				{BytesOfClearData: 0xffff},
+				{BytesOfClearData: 5},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, videoSubsamples(tc.input))
+		})
+	}
+}
+
+func TestEncryptor(t *testing.T) {
+	t.Run("invalidScheme", func(t *testing.T) {
+		_, err := NewEncryptor("aes")
+		require.ErrorIs(t, err, ErrInvalidEncryptionScheme)
+	})
+	t.Run("cenc", func(t *testing.T) {
+		enc, err := NewEncryptor(EncryptionCENC)
+		require.NoError(t, err)
+
+		avcc := newTestNALU(5, 40)
+		samples := []*VideoSample{{AVCC: avcc}}
+		encrypted, info := enc.encryptVideoSamples(samples)
+
+		require.Equal(t, newTestNALU(5, 40), samples[0].AVCC, "original modified")
+		require.Len(t, info[0].InitializationVector, 8)
+		require.Equal(t, avcc[:12], encrypted[0].AVCC[:12])
+		require.NotEqual(t, avcc[12:], encrypted[0].AVCC[12:])
+
+		// Decrypt.
+		iv := make([]byte, 16)
+		copy(iv, info[0].InitializationVector)
+		block, err := aes.NewCipher(enc.key)
+		require.NoError(t, err)
+		decrypted := append([]byte(nil), encrypted[0].AVCC...)
+		cipher.NewCTR(block, iv).XORKeyStream(decrypted[12:], decrypted[12:])
+		require.Equal(t, avcc, decrypted)
+
+		_, info2 := enc.encryptVideoSamples(samples)
+		require.NotEqual(t, info[0].InitializationVector, info2[0].InitializationVector)
+	})
+	t.Run("cbcs", func(t *testing.T) {
+		enc, err := NewEncryptor(EncryptionCBCS)
+		require.NoError(t, err)
+
+		// 1 header byte, 15 clear bytes and 11 blocks.
+		avcc := newTestNALU(1, 16*12)
+		encrypted, info := enc.encryptVideoSamples([]*VideoSample{{AVCC: avcc}})
+		require.Nil(t, info[0].InitializationVector)
+		require.Equal(t, []mp4.SencSubsample{
+			{BytesOfClearData: 20, BytesOfProtectedData: 16 * 11},
+		}, info[0].Subsamples)
+
+		actual := encrypted[0].AVCC
+		require.Equal(t, avcc[:20], actual[:20])
+		require.NotEqual(t, avcc[20:36], actual[20:36])
+		// 9 blocks are skipped.
+		require.Equal(t, avcc[36:180], actual[36:180])
+		require.NotEqual(t, avcc[180:196], actual[180:196])
+
+		block, err := aes.NewCipher(enc.key)
+		require.NoError(t, err)
+		mode := cipher.NewCBCDecrypter(block, enc.constantIV)
+		decrypted := append([]byte(nil), actual...)
+		mode.CryptBlocks(decrypted[20:36], decrypted[20:36])
+		mode.CryptBlocks(decrypted[180:196], decrypted[180:196])
+		require.Equal(t, avcc, decrypted)
+	})
+	t.Run("cbcsAudio", func(t *testing.T) {
+		enc, err := NewEncryptor(EncryptionCBCS)
+		require.NoError(t, err)
+
+		au := bytes.Repeat([]byte{1}, 40)
+		encrypted, _ := enc.encryptAudioSamples([]*AudioSample{{AU: au}})
+
+		actual := encrypted[0].AU
+		// Partial block is left in the clear.
+		require.Equal(t, au[32:], actual[32:])
+
+		block, err := aes.NewCipher(enc.key)
+		require.NoError(t, err)
+		mode := cipher.NewCBCDecrypter(block, enc.constantIV)
+		decrypted := append([]byte(nil), actual...)
+		mode.CryptBlocks(decrypted[:32], decrypted[:32])
+		require.Equal(t, au, decrypted)
+	})
+}
+
+func TestGenerateEncryptedPart(t *testing.T) {
+	enc, err := NewEncryptor(EncryptionCENC)
+	require.NoError(t, err)
+
+	avcc := newTestNALU(5, 40)
+	au := []byte{1, 2, 3, 4}
+	part, err := generatePart(
+		0,
+		true,
+		true,
+		func() int { return 44100 },
+		[]*VideoSample{{AVCC: avcc}},
+		[]*AudioSample{{AU: au}},
+		enc,
+	)
+	require.NoError(t, err)
+
+	// Find the saio offsets and make sure they point to the senc data.
+	var offsets []uint32
+	for i := 0; i+8 <= len(part); i++ {
+		if string(part[i+4:i+8]) == "saio" {
+			offsets = append(offsets, binary.BigEndian.Uint32(part[i+16:]))
+		}
+	}
+	require.Len(t, offsets, 2)
+
+	// Video, IV followed by 1 subsample.
+	iv := part[offsets[0] : offsets[0]+8]
+	require.Equal(t, []byte{0, 1}, part[offsets[0]+8:offsets[0]+10])
+	require.Equal(t, uint16(12), binary.BigEndian.Uint16(part[offsets[0]+10:]))
+	require.Equal(t, uint32(32), binary.BigEndian.Uint32(part[offsets[0]+12:]))
+
+	// Audio, IV only.
+	require.Equal(t, binary.BigEndian.Uint64(iv)+1,
+		binary.BigEndian.Uint64(part[offsets[1]:offsets[1]+8]))
+
+	// The samples are at the end of the mdat.
+	mdat := part[len(part)-len(avcc)-len(au):]
+	require.Equal(t, avcc[:12], mdat[:12])
+	require.NotEqual(t, avcc[12:], mdat[12:len(avcc)])
+	require.NotEqual(t, au, mdat[len(avcc):])
+}
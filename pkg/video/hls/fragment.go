@@ -0,0 +1,26 @@
+package hls
+
+// GenerateInit generates a unencrypted fMP4 initialization section
+// for the stream. The recorder uses it as the header of segment files.
+func GenerateInit(info StreamInfo) ([]byte, error) {
+	return generateInit(info, nil)
+}
+
+// GenerateFragment generates a unencrypted moof and mdat box pair from
+// the samples. The decode times are relative to startTime in UnixNano.
+func GenerateFragment(
+	startTime int64,
+	info StreamInfo,
+	videoSamples []*VideoSample,
+	audioSamples []*AudioSample,
+) ([]byte, error) {
+	return generatePart(
+		startTime,
+		info.VideoTrackExist,
+		info.AudioTrackExist,
+		func() int { return info.AudioClockRate },
+		videoSamples,
+		audioSamples,
+		nil,
+	)
+}
@@ -66,7 +66,7 @@ func (b *myEsds) Marshal(w *bitio.Writer) error {
 	return w.TryError
 }
 
-func initGenerateVideoTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:funlen
+func initGenerateVideoTrack(trackID int, info StreamInfo, enc *Encryptor) mp4.Boxes { //nolint:funlen
 	/*
 	   trak
 	   - tkhd
@@ -80,9 +80,10 @@ func initGenerateVideoTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:f
 	           - url
 	       - stbl
 	         - stsd
-	           - avc1
+	           - avc1 (encv)
 	             - avcC
 	             - btrt
+	             - sinf (if encrypted)
 	         - stts
 	         - stsc
 	         - stsz
@@ -92,52 +93,55 @@ func initGenerateVideoTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:f
 	width := info.VideoSPSP.Width()
 	height := info.VideoSPSP.Height()
 
-	stbl := mp4.Boxes{
-		Box: &mp4.Stbl{},
+	avc1 := mp4.Boxes{
+		Box: &mp4.Avc1{
+			SampleEntry: mp4.SampleEntry{
+				DataReferenceIndex: 1,
+			},
+			Width:           uint16(width),
+			Height:          uint16(height),
+			Horizresolution: 4718592,
+			Vertresolution:  4718592,
+			FrameCount:      1,
+			Depth:           24,
+			PreDefined3:     -1,
+		},
 		Children: []mp4.Boxes{
-			{
-				Box: &mp4.Stsd{EntryCount: 1},
-				Children: []mp4.Boxes{
+			{Box: &mp4.AvcC{
+				ConfigurationVersion:       1,
+				Profile:                    info.VideoSPSP.ProfileIdc,
+				ProfileCompatibility:       info.VideoSPS[2],
+				Level:                      info.VideoSPSP.LevelIdc,
+				LengthSizeMinusOne:         3,
+				NumOfSequenceParameterSets: 1,
+				SequenceParameterSets: []mp4.AVCParameterSet{
 					{
-						Box: &mp4.Avc1{
-							SampleEntry: mp4.SampleEntry{
-								DataReferenceIndex: 1,
-							},
-							Width:           uint16(width),
-							Height:          uint16(height),
-							Horizresolution: 4718592,
-							Vertresolution:  4718592,
-							FrameCount:      1,
-							Depth:           24,
-							PreDefined3:     -1,
-						},
-						Children: []mp4.Boxes{
-							{Box: &mp4.AvcC{
-								ConfigurationVersion:       1,
-								Profile:                    info.VideoSPSP.ProfileIdc,
-								ProfileCompatibility:       info.VideoSPS[2],
-								Level:                      info.VideoSPSP.LevelIdc,
-								LengthSizeMinusOne:         3,
-								NumOfSequenceParameterSets: 1,
-								SequenceParameterSets: []mp4.AVCParameterSet{
-									{
-										NALUnit: info.VideoSPS,
-									},
-								},
-								NumOfPictureParameterSets: 1,
-								PictureParameterSets: []mp4.AVCParameterSet{
-									{
-										NALUnit: info.VideoPPS,
-									},
-								},
-							}},
-							{Box: &mp4.Btrt{
-								MaxBitrate: 1000000,
-								AvgBitrate: 1000000,
-							}},
-						},
+						NALUnit: info.VideoSPS,
+					},
+				},
+				NumOfPictureParameterSets: 1,
+				PictureParameterSets: []mp4.AVCParameterSet{
+					{
+						NALUnit: info.VideoPPS,
 					},
 				},
+			}},
+			{Box: &mp4.Btrt{
+				MaxBitrate: 1000000,
+				AvgBitrate: 1000000,
+			}},
+		},
+	}
+	if enc != nil {
+		enc.encryptSampleEntry(&avc1, true)
+	}
+
+	stbl := mp4.Boxes{
+		Box: &mp4.Stbl{},
+		Children: []mp4.Boxes{
+			{
+				Box:      &mp4.Stsd{EntryCount: 1},
+				Children: []mp4.Boxes{avc1},
 			},
 			{Box: &mp4.Stts{}},
 			{Box: &mp4.Stsc{}},
@@ -210,7 +214,7 @@ func initGenerateVideoTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:f
 	return trak
 }
 
-func initGenerateAudioTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:funlen
+func initGenerateAudioTrack(trackID int, info StreamInfo, enc *Encryptor) mp4.Boxes { //nolint:funlen
 	/*
 	   trak
 	   - tkhd
@@ -224,15 +228,40 @@ func initGenerateAudioTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:f
 	           - url
 	       - stbl
 	         - stsd
-	           - mp4a
+	           - mp4a (enca)
 	             - esds
 	             - btrt
+	             - sinf (if encrypted)
 	         - stts
 	         - stsc
 	         - stsz
 	         - stco
 	*/
 
+	mp4a := mp4.Boxes{
+		Box: &mp4.Mp4a{
+			SampleEntry: mp4.SampleEntry{
+				DataReferenceIndex: 1,
+			},
+			ChannelCount: uint16(info.AudioChannelCount),
+			SampleSize:   16,
+			SampleRate:   uint32(info.AudioClockRate * 65536),
+		},
+		Children: []mp4.Boxes{
+			{Box: &myEsds{
+				ESID:   uint8(trackID),
+				config: info.AudioTrackConfig,
+			}},
+			{Box: &mp4.Btrt{
+				MaxBitrate: 128825,
+				AvgBitrate: 128825,
+			}},
+		},
+	}
+	if enc != nil {
+		enc.encryptSampleEntry(&mp4a, false)
+	}
+
 	minf := mp4.Boxes{
 		Box: &mp4.Minf{},
 		Children: []mp4.Boxes{
@@ -257,29 +286,8 @@ func initGenerateAudioTrack(trackID int, info StreamInfo) mp4.Boxes { //nolint:f
 				Box: &mp4.Stbl{},
 				Children: []mp4.Boxes{
 					{
-						Box: &mp4.Stsd{EntryCount: 1},
-						Children: []mp4.Boxes{
-							{
-								Box: &mp4.Mp4a{
-									SampleEntry: mp4.SampleEntry{
-										DataReferenceIndex: 1,
-									},
-									ChannelCount: uint16(info.AudioChannelCount),
-									SampleSize:   16,
-									SampleRate:   uint32(info.AudioClockRate * 65536),
-								},
-								Children: []mp4.Boxes{
-									{Box: &myEsds{
-										ESID:   uint8(trackID),
-										config: info.AudioTrackConfig,
-									}},
-									{Box: &mp4.Btrt{
-										MaxBitrate: 128825,
-										AvgBitrate: 128825,
-									}},
-								},
-							},
-						},
+						Box:      &mp4.Stsd{EntryCount: 1},
+						Children: []mp4.Boxes{mp4a},
 					},
 					{Box: &mp4.Stts{}},
 					{Box: &mp4.Stsc{}},
@@ -349,7 +357,9 @@ func initGenerateMvex(info StreamInfo) mp4.Boxes {
 	return mvex
 }
 
-func generateInit(info StreamInfo) ([]byte, error) {
+// generateInit generates the init segment, the sample
+// entries are protected if the encryptor isn't nil.
+func generateInit(info StreamInfo, enc *Encryptor) ([]byte, error) {
 	/*
 	   - ftyp
 	   - moov
@@ -389,12 +399,12 @@ func generateInit(info StreamInfo) ([]byte, error) {
 
 	trackID := 1
 	if info.VideoTrackExist {
-		videoTrak := initGenerateVideoTrack(trackID, info)
+		videoTrak := initGenerateVideoTrack(trackID, info, enc)
 		moov.Children = append(moov.Children, videoTrak)
 		trackID++
 	}
 	if info.AudioTrackExist {
-		audioTrak := initGenerateAudioTrack(trackID, info)
+		audioTrak := initGenerateAudioTrack(trackID, info, enc)
 		moov.Children = append(moov.Children, audioTrak)
 	}
 
@@ -27,6 +27,7 @@ func TestGenerateInit(t *testing.T) {
 			VideoSPSP:       spsp,
 			AudioTrackExist: true,
 		},
+		nil,
 	)
 	require.NoError(t, err)
 	expected := []byte{
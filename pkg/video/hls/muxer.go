@@ -10,6 +10,7 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/h264"
 	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,11 +27,20 @@ type Muxer struct {
 	segmenter  *segmenter
 	logf       logFunc
 	streamInfo StreamInfoFunc
+	renditions []AudioRendition
+	encryptor  *Encryptor
+
+	// Serializes writes to the segmenter.
+	writeMutex sync.Mutex
 
 	mutex        sync.Mutex
 	videoLastSPS []byte
 	videoLastPPS []byte
 	initContent  []byte
+
+	// UnixNano wall clock times.
+	lastFrame   int64
+	lastSegment int64
 }
 
 type logFunc func(log.Level, string, ...interface{})
@@ -51,14 +61,19 @@ func NewMuxer(
 	audioTrackExist bool,
 	audioClockRate audioClockRateFunc,
 	streamInfo StreamInfoFunc,
+	spiller *Spiller,
+	renditions []AudioRendition,
+	encryptor *Encryptor,
 ) *Muxer {
-	playlist := newPlaylist(ctx, segmentCount)
+	playlist := newPlaylist(ctx, segmentCount, spiller, encryptor, logf)
 	go playlist.start()
 
 	m := &Muxer{
 		playlist:   playlist,
 		logf:       logf,
 		streamInfo: streamInfo,
+		renditions: renditions,
+		encryptor:  encryptor,
 	}
 
 	m.segmenter = newSegmenter(
@@ -70,25 +85,80 @@ func NewMuxer(
 		videoSps,
 		audioTrackExist,
 		audioClockRate,
-		m.playlist.onSegmentFinalized,
+		m.onSegmentFinalized,
 		m.playlist.partFinalized,
+		m.playlist.onGapFinalized,
+		encryptor,
 	)
 	return m
 }
 
+// AudioRendition is a entry in the audio rendition group of the primary playlist.
+type AudioRendition struct {
+	Name     string
+	Language string // RFC 5646 language tag, optional.
+	Default  bool
+
+	// Relative URI of the media playlist. Empty if the
+	// audio is muxed into the main media playlist.
+	URI string
+}
+
 // OnSegmentFinalizedFunc is injected by core.
 type OnSegmentFinalizedFunc func([]SegmentOrGap)
 
+func (m *Muxer) onSegmentFinalized(segment *Segment) {
+	atomic.StoreInt64(&m.lastSegment, time.Now().UnixNano())
+	m.playlist.onSegmentFinalized(segment)
+}
+
+// Liveness wall clock times of the latest frame and finalized
+// segment, used to detect stalled inputs. Zero until the first.
+type Liveness struct {
+	LastFrame   time.Time `json:"lastFrame"`
+	LastSegment time.Time `json:"lastSegment"`
+}
+
+// Liveness returns the time of the latest frame and segment.
+func (m *Muxer) Liveness() Liveness {
+	unixTime := func(nano int64) time.Time {
+		if nano == 0 {
+			return time.Time{}
+		}
+		return time.Unix(0, nano)
+	}
+	return Liveness{
+		LastFrame:   unixTime(atomic.LoadInt64(&m.lastFrame)),
+		LastSegment: unixTime(atomic.LoadInt64(&m.lastSegment)),
+	}
+}
+
 // WriteH264 writes H264 NALUs, grouped by timestamp.
 func (m *Muxer) WriteH264(now time.Time, pts time.Duration, nalus [][]byte) error {
+	atomic.StoreInt64(&m.lastFrame, now.UnixNano())
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
 	return m.segmenter.writeH264(now, pts, nalus)
 }
 
 // WriteAAC writes AAC AUs, grouped by timestamp.
 func (m *Muxer) WriteAAC(now time.Time, pts time.Duration, au []byte) error {
+	atomic.StoreInt64(&m.lastFrame, now.UnixNano())
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
 	return m.segmenter.writeAAC(now, pts, au)
 }
 
+// WriteGap finalizes the current segment and inserts gaps covering the
+// duration. Should be called when the source stops delivering so that
+// players keep the timeline aligned instead of stalling. The next
+// segment starts at the next IDR. Nothing is inserted before the first segment.
+func (m *Muxer) WriteGap(duration time.Duration) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	return m.segmenter.writeGap(duration)
+}
+
 // StreamInfoFunc returns the stream information.
 type StreamInfoFunc func() (*StreamInfo, error)
 
@@ -110,12 +180,14 @@ type StreamInfo struct {
 	AudioType         mpeg4audio.ObjectType
 }
 
-// File returns a file reader.
+// File returns a file reader. The CMCD of
+// playlist and media requests is recorded.
 func (m *Muxer) File(
 	name string,
 	msn string,
 	part string,
 	skip string,
+	cmcd CMCD,
 ) *MuxerFileResponse {
 	info, err := m.streamInfo()
 	if err != nil {
@@ -124,7 +196,7 @@ func (m *Muxer) File(
 	}
 
 	if name == "index.m3u8" {
-		return primaryPlaylist(*info)
+		return primaryPlaylist(*info, m.playlist.bandwidth(), m.renditions)
 	}
 
 	if name == "init.mp4" {
@@ -135,7 +207,7 @@ func (m *Muxer) File(
 			(info.VideoTrackExist &&
 				(!bytes.Equal(m.videoLastSPS, info.VideoSPS) ||
 					!bytes.Equal(m.videoLastPPS, info.VideoPPS))) {
-			initContent, err := generateInit(*info)
+			initContent, err := generateInit(*info, m.encryptor)
 			if err != nil {
 				m.logf(log.LevelError, "generate init.mp4: %w", err)
 				return &MuxerFileResponse{Status: http.StatusInternalServerError}
@@ -154,7 +226,26 @@ func (m *Muxer) File(
 		}
 	}
 
-	return m.playlist.file(name, msn, part, skip)
+	if name == KeyFileName {
+		if m.encryptor == nil {
+			return &MuxerFileResponse{Status: http.StatusNotFound}
+		}
+		return &MuxerFileResponse{
+			Status: http.StatusOK,
+			Header: map[string]string{
+				"Content-Type":  "application/octet-stream",
+				"Cache-Control": "no-store",
+			},
+			Body: bytes.NewReader(m.encryptor.key),
+		}
+	}
+
+	return m.playlist.file(name, msn, part, skip, cmcd)
+}
+
+// ViewerStats returns the playback statistics of the viewers.
+func (m *Muxer) ViewerStats() ViewerStats {
+	return m.playlist.viewers.stats()
 }
 
 // StreamInfo return information about the stream.
@@ -162,6 +253,12 @@ func (m *Muxer) StreamInfo() (*StreamInfo, error) {
 	return m.streamInfo()
 }
 
+// End ends the live stream. The playlist is frozen with a EXT-X-ENDLIST
+// tag, but new segments are still available from NextSegment.
+func (m *Muxer) End() {
+	m.playlist.endStream()
+}
+
 // WaitForSegFinalized blocks until a new segment has been finalized.
 func (m *Muxer) WaitForSegFinalized() {
 	m.playlist.waitForSegFinalized()
@@ -173,6 +270,19 @@ func (m *Muxer) NextSegment(prevID uint64) (*Segment, error) {
 	return m.playlist.nextSegment(prevID)
 }
 
+// NextPart returns the part following prevID. Will wait for the part if
+// it isn't finalized yet. If the reader has fallen behind and the
+// part is no longer cached, the latest independent part is returned.
+func (m *Muxer) NextPart(prevID uint64) (*MuxerPart, error) {
+	return m.playlist.nextPart(prevID, false)
+}
+
+// LatestPart returns the latest independent part, used to start
+// reading parts. Will wait if there are no independent parts.
+func (m *Muxer) LatestPart() (*MuxerPart, error) {
+	return m.playlist.nextPart(0, true)
+}
+
 // VideoTimescale the number of time units that pass per second.
 const VideoTimescale = 90000
 
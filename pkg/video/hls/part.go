@@ -9,6 +9,7 @@ import (
 	"nvr/pkg/video/mp4"
 	"nvr/pkg/video/mp4/bitio"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -61,8 +62,7 @@ func generateVideoTraf( //nolint:funlen
 	muxerStartTime int64,
 	trackID int,
 	videoSamples []*VideoSample,
-	dataOffset int32,
-) mp4.Boxes {
+) (mp4.Boxes, *mp4.Trun) {
 	/*
 	   traf
 	   - tfhd
@@ -98,7 +98,6 @@ func generateVideoTraf( //nolint:funlen
 			Version: 1,
 			Flags:   [3]byte{0, byte(flags >> 8), byte(flags)},
 		},
-		DataOffset: dataOffset,
 	}
 
 	trun.Entries = make([]mp4.TrunEntry, len(videoSamples))
@@ -125,7 +124,7 @@ func generateVideoTraf( //nolint:funlen
 			{Box: tfdt},
 			{Box: trun},
 		},
-	}
+	}, trun
 }
 
 func generateAudioTraf(
@@ -133,8 +132,7 @@ func generateAudioTraf(
 	trackID int,
 	audioClockRate int,
 	audioSamples []*AudioSample,
-	dataOffset int32,
-) mp4.Boxes {
+) (mp4.Boxes, *mp4.Trun) {
 	/*
 	   traf
 	   - tfhd
@@ -168,8 +166,6 @@ func generateAudioTraf(
 			Version: 0,
 			Flags:   [3]byte{0, byte(flags >> 8), byte(flags)},
 		},
-		DataOffset: dataOffset,
-		Entries:    nil,
 	}
 
 	trun.Entries = make([]mp4.TrunEntry, len(audioSamples))
@@ -188,15 +184,40 @@ func generateAudioTraf(
 			{Box: tfdt},
 			{Box: trun},
 		},
-	}
+	}, trun
+}
+
+func generatePart(
+	muxerStartTime int64,
+	videoTrackExist bool,
+	audioTrackExist bool,
+	audioClockRate func() int,
+	videoSamples []*VideoSample,
+	audioSamples []*AudioSample,
+	enc *Encryptor,
+) ([]byte, error) {
+	return appendPart(
+		nil,
+		muxerStartTime,
+		videoTrackExist,
+		audioTrackExist,
+		audioClockRate,
+		videoSamples,
+		audioSamples,
+		enc,
+	)
 }
 
-func generatePart( //nolint:funlen
+// appendPart renders the part into dst, which is grown if it's too small.
+func appendPart(
+	dst []byte,
 	muxerStartTime int64,
+	videoTrackExist bool,
 	audioTrackExist bool,
 	audioClockRate func() int,
 	videoSamples []*VideoSample,
 	audioSamples []*AudioSample,
+	enc *Encryptor,
 ) ([]byte, error) {
 	/*
 	   moof
@@ -205,13 +226,27 @@ func generatePart( //nolint:funlen
 	     - tfhd
 	     - tfdt
 	     - trun
+	     - saiz (if encrypted)
+	     - saio (if encrypted)
+	     - senc (if encrypted)
 	   - traf (audio)
 	     - tfhd
 	     - tfdt
 	     - trun
+	     - saiz (if encrypted)
+	     - saio (if encrypted)
+	     - senc (if encrypted)
 	   mdat
 	*/
 
+	// The original samples are used by the recorder and
+	// must not be modified, encrypted copies are muxed instead.
+	var videoEncryption, audioEncryption []mp4.SencSample
+	if enc != nil {
+		videoSamples, videoEncryption = enc.encryptVideoSamples(videoSamples)
+		audioSamples, audioEncryption = enc.encryptAudioSamples(audioSamples)
+	}
+
 	moof := mp4.Boxes{
 		Box: &mp4.Moof{},
 		Children: []mp4.Boxes{
@@ -221,46 +256,35 @@ func generatePart( //nolint:funlen
 		},
 	}
 
-	mfhdOffset := 24
-	videoTrunSize := len(videoSamples)*16 + 20
-	audioOffset := mfhdOffset + videoTrunSize + 44
-
-	mdatOffset := audioOffset
-	if audioTrackExist && len(audioSamples) != 0 {
-		audioTrunOffset := audioOffset + 44
-		audioTrunSize := len(audioSamples)*8 + 20
-		mdatOffset = audioTrunOffset + audioTrunSize
-	}
-
+	var videoTrun, audioTrun *mp4.Trun
 	trackID := 1
-	videoDataOffset := int32(mdatOffset + 8)
-	traf := generateVideoTraf(
-		muxerStartTime,
-		trackID,
-		videoSamples,
-		videoDataOffset)
-	moof.Children = append(moof.Children, traf)
-	trackID++
-
-	dataSize := 0
-	for _, e := range videoSamples {
-		dataSize += len(e.AVCC)
-	}
-	videoDataSize := dataSize
-	if audioTrackExist {
-		for _, e := range audioSamples {
-			dataSize += len(e.AU)
+	if videoTrackExist {
+		if len(videoSamples) != 0 {
+			var traf mp4.Boxes
+			traf, videoTrun = generateVideoTraf(
+				muxerStartTime,
+				trackID,
+				videoSamples)
+			if enc != nil {
+				traf.Children = append(traf.Children,
+					enc.sampleEncryptionBoxes(videoEncryption, true)...)
+			}
+			moof.Children = append(moof.Children, traf)
 		}
+		trackID++
 	}
 
 	if audioTrackExist && len(audioSamples) != 0 {
-		audioDataOffset := int32(mdatOffset + 8 + videoDataSize)
-		traf := generateAudioTraf(
+		var traf mp4.Boxes
+		traf, audioTrun = generateAudioTraf(
 			muxerStartTime,
 			trackID,
 			audioClockRate(),
-			audioSamples,
-			audioDataOffset)
+			audioSamples)
+		if enc != nil {
+			traf.Children = append(traf.Children,
+				enc.sampleEncryptionBoxes(audioEncryption, false)...)
+		}
 		moof.Children = append(moof.Children, traf)
 	}
 
@@ -271,8 +295,27 @@ func generatePart( //nolint:funlen
 		},
 	}
 
+	if enc != nil {
+		setSaioOffsets(moof)
+	}
+
+	// The data offsets are relative to the start of the moof box.
+	dataOffset := int32(moof.Size() + 8)
+	if videoTrun != nil {
+		videoTrun.DataOffset = dataOffset
+	}
+	if audioTrun != nil {
+		for _, e := range videoSamples {
+			dataOffset += int32(len(e.AVCC))
+		}
+		audioTrun.DataOffset = dataOffset
+	}
+
 	size := moof.Size() + mdat.Size()
-	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if cap(dst) < size {
+		dst = make([]byte, 0, size)
+	}
+	buf := bytes.NewBuffer(dst[:0])
 
 	w := bitio.NewWriter(buf)
 
@@ -302,8 +345,20 @@ type MuxerPart struct {
 	isIndependent    bool
 	VideoSamples     []*VideoSample
 	AudioSamples     []*AudioSample
-	renderedContent  []byte
 	renderedDuration time.Duration
+	size             int // Rendered size in bytes.
+
+	// Parts are spilled and released while they're read.
+	mu sync.Mutex
+
+	// Shared by the readers, nil before the part is
+	// rendered and after it's moved to disk or released.
+	content *sharedBuffer
+
+	// Set if the content has been moved to disk.
+	spill *spilledContent
+
+	encryptor *Encryptor
 }
 
 type audioClockRateFunc func() int
@@ -314,6 +369,7 @@ func newPart(
 	audioClockRate audioClockRateFunc,
 	muxerStartTime int64,
 	id uint64,
+	encryptor *Encryptor,
 ) *MuxerPart {
 	p := &MuxerPart{
 		videoTrackExist: videoTrackExist,
@@ -321,6 +377,7 @@ func newPart(
 		audioClockRate:  audioClockRate,
 		muxerStartTime:  muxerStartTime,
 		id:              id,
+		encryptor:       encryptor,
 	}
 
 	if !videoTrackExist {
@@ -334,8 +391,100 @@ func (p *MuxerPart) name() string {
 	return partName(p.id)
 }
 
-func (p *MuxerPart) reader() io.Reader {
-	return bytes.NewReader(p.renderedContent)
+// ID returns the part ID, IDs are sequential across segments.
+func (p *MuxerPart) ID() uint64 {
+	return p.id
+}
+
+// IsIndependent returns true if the part starts with a IDR.
+func (p *MuxerPart) IsIndependent() bool {
+	return p.isIndependent
+}
+
+// Reader returns a reader of the rendered part. In-memory parts are
+// shared by all readers without copying, the reader holds a reference
+// to the content until it's closed. The reader must be closed if it
+// implements io.Closer, it implements io.WriterTo for io.Copy.
+func (p *MuxerPart) Reader() io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.spill != nil {
+		return p.spill.reader()
+	}
+	if p.content == nil {
+		if p.size == 0 {
+			return bytes.NewReader(nil)
+		}
+		return &bufferReader{err: ErrBufferReleased}
+	}
+	return newBufferReader(p.content)
+}
+
+// setContent sets the rendered content, the part holds the first reference.
+func (p *MuxerPart) setContent(content *sharedBuffer) {
+	p.content = content
+	p.size = len(content.buf)
+}
+
+// buffer returns the shared content, nil if it's not in memory.
+func (p *MuxerPart) buffer() *sharedBuffer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.content
+}
+
+// moveToDisk releases the content of the part, new readers read
+// the spilled file. Open readers can finish reading the content.
+func (p *MuxerPart) moveToDisk(spill *spilledContent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spill = spill
+	if p.content != nil {
+		p.content.release()
+		p.content = nil
+	}
+}
+
+// release releases the reference of the part to the content, the
+// content is freed when the last open reader is closed.
+func (p *MuxerPart) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.content != nil {
+		p.content.release()
+		p.content = nil
+	}
+}
+
+// startTime returns the wall clock time of the first sample.
+func (p *MuxerPart) startTime() time.Time {
+	switch {
+	case len(p.VideoSamples) != 0:
+		return time.Unix(0, p.VideoSamples[0].DTS)
+	case len(p.AudioSamples) != 0:
+		return time.Unix(0, p.AudioSamples[0].PTS)
+	default:
+		return time.Time{}
+	}
+}
+
+// sizeHint returns a estimate of the rendered size that
+// is slightly larger than the size of the boxes.
+func (p *MuxerPart) sizeHint() int {
+	const boxes = 1024
+	const perSample = 64
+	size := boxes
+	for _, sample := range p.VideoSamples {
+		size += len(sample.AVCC) + perSample
+	}
+	for _, sample := range p.AudioSamples {
+		size += len(sample.AU) + perSample
+	}
+	return size
+}
+
+func (p *MuxerPart) renderedSize() int {
+	return p.size
 }
 
 func (p *MuxerPart) duration() time.Duration {
@@ -356,16 +505,23 @@ func (p *MuxerPart) duration() time.Duration {
 
 func (p *MuxerPart) finalize() error {
 	if len(p.VideoSamples) > 0 || len(p.AudioSamples) > 0 {
-		var err error
-		p.renderedContent, err = generatePart(
+		// The buffer is returned to the pool when the
+		// part is released and the last reader is closed.
+		buf := partBufferPool.get(p.sizeHint())
+		content, err := appendPart(
+			buf,
 			p.muxerStartTime,
+			p.videoTrackExist,
 			p.audioTrackExist,
 			p.audioClockRate,
 			p.VideoSamples,
-			p.AudioSamples)
+			p.AudioSamples,
+			p.encryptor)
 		if err != nil {
+			partBufferPool.put(buf)
 			return err
 		}
+		p.setContent(newPooledSharedBuffer(content, partBufferPool))
 		p.renderedDuration = p.duration()
 	}
 
@@ -12,6 +12,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("minimal", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			true,
 			false,
 			func() int { return 0 },
 			[]*VideoSample{{
@@ -21,6 +22,7 @@ func TestGeneratePart(t *testing.T) {
 				NextDTS: 0,
 			}},
 			[]*AudioSample{},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
@@ -50,6 +52,7 @@ func TestGeneratePart(t *testing.T) {
 	t.Run("videoSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
+			true,
 			false,
 			func() int { return 0 },
 			[]*VideoSample{{
@@ -59,6 +62,7 @@ func TestGeneratePart(t *testing.T) {
 				NextDTS: 0,
 			}},
 			[]*AudioSample{},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
@@ -90,6 +94,7 @@ func TestGeneratePart(t *testing.T) {
 		actual, err := generatePart(
 			0,
 			true,
+			true,
 			func() int { return 0 },
 			[]*VideoSample{{
 				PTS:     0,
@@ -102,6 +107,7 @@ func TestGeneratePart(t *testing.T) {
 				NextPTS: 0,
 				AU:      []byte{'a', 'b', 'c', 'd'},
 			}},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
@@ -141,10 +147,49 @@ func TestGeneratePart(t *testing.T) {
 		}
 		require.Equal(t, expected, actual)
 	})
+	t.Run("audioOnly", func(t *testing.T) {
+		actual, err := generatePart(
+			0,
+			false,
+			true,
+			func() int { return 0 },
+			nil,
+			[]*AudioSample{{
+				PTS:     0,
+				NextPTS: 0,
+				AU:      []byte{'a', 'b', 'c', 'd'},
+			}},
+			nil,
+		)
+		require.NoError(t, err)
+		expected := []byte{
+			0, 0, 0, 0x60, 'm', 'o', 'o', 'f',
+			0, 0, 0, 0x10, 'm', 'f', 'h', 'd',
+			0, 0, 0, 0, // FullBox.
+			0, 0, 0, 0, // Sequence number.
+			0, 0, 0, 0x48, 't', 'r', 'a', 'f', // Audio traf.
+			0, 0, 0, 0x10, 't', 'f', 'h', 'd', // Audio tfhd.
+			0, 2, 0, 0, // Track id.
+			0, 0, 0, 1, // Sample size.
+			0, 0, 0, 0x14, 't', 'f', 'd', 't', // Audio tfdt.
+			1, 0, 0, 0, // Track id.
+			0, 0, 0, 0, 0, 0, 0, 0, // BaseMediaDecodeTime.
+			0, 0, 0, 0x1c, 't', 'r', 'u', 'n', // Audio trun.
+			0, 0, 3, 1, // FullBox.
+			0, 0, 0, 1, // Sample count.
+			0, 0, 0, 0x68, // Data offset.
+			0, 0, 0, 0, // Entry sample duration.
+			0, 0, 0, 4, // Entry sample size.
+			0, 0, 0, 0x0c, 'm', 'd', 'a', 't',
+			'a', 'b', 'c', 'd', // Audio Sample
+		}
+		require.Equal(t, expected, actual)
+	})
 	t.Run("videoAndAudioSample", func(t *testing.T) {
 		actual, err := generatePart(
 			0,
 			true,
+			true,
 			func() int { return 0 },
 			[]*VideoSample{{
 				PTS:     0,
@@ -157,6 +202,7 @@ func TestGeneratePart(t *testing.T) {
 				NextPTS: 0,
 				AU:      []byte{'e', 'f', 'g', 'h'},
 			}},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
@@ -201,6 +247,7 @@ func TestGeneratePart(t *testing.T) {
 		actual, err := generatePart(
 			0,
 			true,
+			true,
 			func() int { return 0 },
 			[]*VideoSample{
 				{
@@ -224,6 +271,7 @@ func TestGeneratePart(t *testing.T) {
 				},
 			},
 			[]*AudioSample{},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
@@ -278,6 +326,7 @@ func TestGeneratePart(t *testing.T) {
 		actual, err := generatePart(
 			muxerStartTime,
 			true,
+			true,
 			func() int { return 44100 },
 			[]*VideoSample{
 				videoSample1,
@@ -288,6 +337,7 @@ func TestGeneratePart(t *testing.T) {
 				PTS:     muxerStartTime + 700000000,
 				NextPTS: muxerStartTime + 800000000,
 			}},
+			nil,
 		)
 		require.NoError(t, err)
 		expected := []byte{
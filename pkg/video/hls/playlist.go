@@ -7,8 +7,10 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"nvr/pkg/log"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -69,7 +71,11 @@ func partTargetDuration(
 }
 
 type playlist struct {
-	ctx context.Context
+	ctx       context.Context
+	spiller   *Spiller
+	encryptor *Encryptor
+	logf      logFunc
+	viewers   *viewerStats
 
 	segmentCount int
 
@@ -82,24 +88,47 @@ type playlist struct {
 	nextSegmentParts   []*MuxerPart
 	nextPartID         uint64
 
+	// Set when the stream has ended, the playlist is
+	// frozen and always returned with EXT-X-ENDLIST.
+	ended         bool
+	endedPlaylist []byte
+
+	// Rendered after every change by the playlist goroutine and read
+	// by the non-blocking playlist requests without entering it.
+	snapshot atomic.Value // *playlistSnapshot
+
 	playlistsOnHold    map[blockingPlaylistRequest]struct{}
 	partsOnHold        map[blockingPartRequest]struct{}
 	segFinalOnHold     map[chan struct{}]struct{}
 	nextSegmentsOnHold map[nextSegmentRequest]struct{}
+	nextPartsOnHold    map[nextPartRequest]struct{}
 
-	chPlaylist         chan playlistRequest
 	chSegment          chan segmentRequest
 	chSegmentFinalized chan segmentFinalizedRequest
+	chGapFinalized     chan gapFinalizedRequest
 	chPartFinalized    chan partFinalizedRequest
 	chBlockingPlaylist chan blockingPlaylistRequest
 	chBlockingPart     chan blockingPartRequest
 	chWaitForSegFinal  chan chan struct{}
 	chNextSegment      chan nextSegmentRequest
+	chNextPart         chan nextPartRequest
+	chBandwidth        chan chan bandwidth
+	chEnd              chan chan struct{}
 }
 
-func newPlaylist(ctx context.Context, segmentCount int) *playlist {
+func newPlaylist(
+	ctx context.Context,
+	segmentCount int,
+	spiller *Spiller,
+	encryptor *Encryptor,
+	logf logFunc,
+) *playlist {
 	return &playlist{
 		ctx:            ctx,
+		spiller:        spiller,
+		encryptor:      encryptor,
+		logf:           logf,
+		viewers:        newViewerStats(),
 		segmentCount:   segmentCount,
 		segmentsByName: make(map[string]*Segment),
 		partsByName:    make(map[string]*MuxerPart),
@@ -108,15 +137,19 @@ func newPlaylist(ctx context.Context, segmentCount int) *playlist {
 		partsOnHold:        make(map[blockingPartRequest]struct{}),
 		segFinalOnHold:     make(map[chan struct{}]struct{}),
 		nextSegmentsOnHold: make(map[nextSegmentRequest]struct{}),
+		nextPartsOnHold:    make(map[nextPartRequest]struct{}),
 
-		chPlaylist:         make(chan playlistRequest),
 		chSegment:          make(chan segmentRequest),
 		chSegmentFinalized: make(chan segmentFinalizedRequest),
+		chGapFinalized:     make(chan gapFinalizedRequest),
 		chPartFinalized:    make(chan partFinalizedRequest),
 		chBlockingPlaylist: make(chan blockingPlaylistRequest),
 		chBlockingPart:     make(chan blockingPartRequest),
 		chWaitForSegFinal:  make(chan chan struct{}),
 		chNextSegment:      make(chan nextSegmentRequest),
+		chNextPart:         make(chan nextPartRequest),
+		chBandwidth:        make(chan chan bandwidth),
+		chEnd:              make(chan chan struct{}),
 	}
 }
 
@@ -127,27 +160,14 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			p.cleanup()
 			return
 
-		case req := <-p.chPlaylist:
-			if !p.hasContent() {
-				req.res <- &MuxerFileResponse{
-					Status: http.StatusNotFound,
-				}
-				continue
-			}
-			req.res <- &MuxerFileResponse{
-				Status: http.StatusOK,
-				Header: map[string]string{
-					"Content-Type": `audio/mpegURL`,
-				},
-				Body: bytes.NewReader(p.fullPlaylist(req.isDeltaUpdate)),
-			}
-
 		case req := <-p.chSegment:
 			segment, exist := p.segmentsByName[req.name]
 			if !exist {
+				p.viewers.record(req.cmcd, time.Time{})
 				req.res <- &MuxerFileResponse{Status: http.StatusNotFound}
 				continue
 			}
+			p.viewers.record(req.cmcd, segment.StartTime)
 			req.res <- &MuxerFileResponse{
 				Status: http.StatusOK,
 				Header: map[string]string{
@@ -160,6 +180,10 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			p.segmentFinalized(req.segment)
 			close(req.done)
 
+		case req := <-p.chGapFinalized:
+			p.gapFinalized(req.id, req.gap)
+			close(req.done)
+
 		case req := <-p.chPartFinalized:
 			part := req.part
 			p.partsByName[part.name()] = part
@@ -167,6 +191,13 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			p.nextSegmentParts = append(p.nextSegmentParts, part)
 			p.nextPartID = part.id + 1
 
+			for req := range p.nextPartsOnHold {
+				if next := p.findNextPart(req); next != nil {
+					req.res <- next
+					delete(p.nextPartsOnHold, req)
+				}
+			}
+
 			p.checkPending()
 			close(req.done)
 
@@ -176,12 +207,16 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			// exceeds the last Partial Segment in the current Playlist by the
 			// Advance Part Limit, then the server SHOULD immediately return Bad
 			// Request, such as HTTP 400.
+			if p.ended {
+				req.res <- p.endedPlaylistResponse()
+				continue
+			}
 			if req.msnint > (p.nextSegmentID + 1) {
 				req.res <- &MuxerFileResponse{Status: http.StatusBadRequest}
 				continue
 			}
 
-			if !p.hasContent() || !p.hasPart(req.msnint, req.partint) {
+			if !p.blockingPlaylistReady(req) {
 				p.playlistsOnHold[req] = struct{}{}
 				continue
 			}
@@ -190,29 +225,32 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 				Header: map[string]string{
 					"Content-Type": `audio/mpegURL`,
 				},
-				Body: bytes.NewReader(p.fullPlaylist(req.isDeltaUpdate)),
+				Body: p.mediaPlaylist(req.isDeltaUpdate),
 			}
 
 		case req := <-p.chBlockingPart:
 			base := strings.TrimSuffix(req.partName, ".mp4")
 			part, exist := p.partsByName[base]
 			if exist {
+				p.viewers.record(req.cmcd, part.startTime())
 				req.res <- &MuxerFileResponse{
 					Status: http.StatusOK,
 					Header: map[string]string{
 						"Content-Type": "video/mp4",
 					},
-					Body: part.reader(),
+					Body: part.Reader(),
 				}
 				continue
 			}
 
-			if req.partName == partName(p.nextPartID) {
+			if base == partName(p.nextPartID) && !p.ended {
+				req.partName = base
 				req.partID = p.nextPartID
 				p.partsOnHold[req] = struct{}{}
 				continue
 			}
 
+			p.viewers.record(req.cmcd, time.Time{})
 			req.res <- &MuxerFileResponse{Status: http.StatusNotFound}
 
 		case res := <-p.chWaitForSegFinal:
@@ -236,37 +274,85 @@ func (p *playlist) start() { //nolint:funlen,gocognit
 			} else {
 				p.nextSegmentsOnHold[req] = struct{}{}
 			}
+
+		case req := <-p.chNextPart:
+			if part := p.findNextPart(req); part != nil {
+				req.res <- part
+			} else {
+				p.nextPartsOnHold[req] = struct{}{}
+			}
+
+		case res := <-p.chBandwidth:
+			res <- p.segmentsBandwidth()
+
+		case done := <-p.chEnd:
+			p.end()
+			close(done)
 		}
 	}
 }
 
+// end freezes the playlist and answers the blocking requests with
+// EXT-X-ENDLIST, viewers stop reloading the playlist instead of
+// retrying after a error. The segments are still sent to the recorders.
+func (p *playlist) end() {
+	if p.ended || !p.hasContent() {
+		return
+	}
+	p.ended = true
+	p.endedPlaylist = p.fullPlaylist(false)
+	p.updateSnapshot()
+
+	for req := range p.playlistsOnHold {
+		req.res <- p.endedPlaylistResponse()
+		delete(p.playlistsOnHold, req)
+	}
+	for req := range p.partsOnHold {
+		req.res <- &MuxerFileResponse{Status: http.StatusNotFound}
+		delete(p.partsOnHold, req)
+	}
+}
+
+func (p *playlist) endedPlaylistResponse() *MuxerFileResponse {
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": `audio/mpegURL`,
+		},
+		Body: bytes.NewReader(p.endedPlaylist),
+	}
+}
+
 func (p *playlist) checkPending() {
+	p.updateSnapshot()
+
 	if p.hasContent() {
 		for req := range p.playlistsOnHold {
-			if !p.hasPart(req.msnint, req.partint) {
-				return
+			if !p.blockingPlaylistReady(req) {
+				continue
 			}
 			req.res <- &MuxerFileResponse{
 				Status: http.StatusOK,
 				Header: map[string]string{
 					"Content-Type": `audio/mpegURL`,
 				},
-				Body: bytes.NewReader(p.fullPlaylist(req.isDeltaUpdate)),
+				Body: p.mediaPlaylist(req.isDeltaUpdate),
 			}
 			delete(p.playlistsOnHold, req)
 		}
 	}
 	for req := range p.partsOnHold {
 		if p.nextPartID <= req.partID {
-			return
+			continue
 		}
 		part := p.partsByName[req.partName]
+		p.viewers.record(req.cmcd, part.startTime())
 		req.res <- &MuxerFileResponse{
 			Status: http.StatusOK,
 			Header: map[string]string{
 				"Content-Type": "video/mp4",
 			},
-			Body: part.reader(),
+			Body: part.Reader(),
 		}
 		delete(p.partsOnHold, req)
 	}
@@ -289,24 +375,57 @@ func (p *playlist) cleanup() {
 	for req := range p.nextSegmentsOnHold {
 		close(req.res)
 	}
+	for req := range p.nextPartsOnHold {
+		close(req.res)
+	}
+	for _, sog := range p.segments {
+		if seg, ok := sog.(*Segment); ok {
+			p.removeSpilled(seg)
+		}
+	}
+}
+
+func (p *playlist) removeSpilled(seg *Segment) {
+	if err := p.spiller.remove(seg); err != nil {
+		p.logf(log.LevelError, "remove spilled segment: %v", err)
+	}
 }
 
 func (p *playlist) hasContent() bool {
 	return len(p.segments) >= 1
 }
 
+// blockingPlaylistReady returns true if the playlist
+// contains the segment or part requested by _HLS_msn and _HLS_part.
+func (p *playlist) blockingPlaylistReady(req blockingPlaylistRequest) bool {
+	if !p.hasContent() {
+		return false
+	}
+
+	// Without _HLS_part the request is held until the
+	// segment is complete and listed in the playlist.
+	if !req.partPresent {
+		return req.msnint < p.nextSegmentID
+	}
+
+	return p.hasPart(req.msnint, req.partint)
+}
+
 func (p *playlist) hasPart(segmentID uint64, partID uint64) bool {
 	if !p.hasContent() {
 		return false
 	}
 
-	for _, sop := range p.segments {
-		seg, ok := sop.(*Segment)
-		if !ok {
+	for i, sop := range p.segments {
+		if segmentID != uint64(p.segmentDeleteCount+i) {
 			continue
 		}
 
-		if segmentID != seg.ID {
+		seg, ok := sop.(*Segment)
+		if !ok {
+			// Gaps have no parts.
+			segmentID++
+			partID = 0
 			continue
 		}
 
@@ -333,13 +452,17 @@ func (p *playlist) hasPart(segmentID uint64, partID uint64) bool {
 	return true
 }
 
-func (p *playlist) file(name, msn, part, skip string) *MuxerFileResponse {
+// file returns a playlist, segment or part. The CMCD of the
+// request is added to the viewer statistics, media requests
+// are added when they're served so the latency is known.
+func (p *playlist) file(name, msn, part, skip string, cmcd CMCD) *MuxerFileResponse {
 	switch {
 	case name == "stream.m3u8":
+		p.viewers.record(cmcd, time.Time{})
 		return p.playlistReader(msn, part, skip)
 
 	case strings.HasSuffix(name, ".mp4"):
-		return p.segmentReader(name)
+		return p.segmentReader(name, cmcd)
 
 	default:
 		return &MuxerFileResponse{Status: http.StatusNotFound}
@@ -350,14 +473,10 @@ type blockingPlaylistRequest struct {
 	isDeltaUpdate bool
 	msnint        uint64
 	partint       uint64
+	partPresent   bool
 	res           chan *MuxerFileResponse
 }
 
-type playlistRequest struct {
-	res           chan *MuxerFileResponse
-	isDeltaUpdate bool
-}
-
 func (p *playlist) playlistReader(msn, part, skip string) *MuxerFileResponse {
 	isDeltaUpdate := skip == "YES" || skip == "v2"
 
@@ -385,6 +504,7 @@ func (p *playlist) playlistReader(msn, part, skip string) *MuxerFileResponse {
 			isDeltaUpdate: isDeltaUpdate,
 			msnint:        msnint,
 			partint:       partint,
+			partPresent:   part != "",
 			res:           blockingPlaylistRes,
 		}
 		select {
@@ -400,59 +520,229 @@ func (p *playlist) playlistReader(msn, part, skip string) *MuxerFileResponse {
 		return &MuxerFileResponse{Status: http.StatusBadRequest}
 	}
 
-	playlistRes := make(chan *MuxerFileResponse)
-	playlistReq := playlistRequest{
-		isDeltaUpdate: isDeltaUpdate,
-		res:           playlistRes,
+	if p.ctx.Err() != nil {
+		return &MuxerFileResponse{Status: http.StatusInternalServerError}
+	}
+	snapshot, _ := p.snapshot.Load().(*playlistSnapshot)
+	if snapshot == nil {
+		return &MuxerFileResponse{Status: http.StatusNotFound}
+	}
+	content := snapshot.full
+	if isDeltaUpdate {
+		content = snapshot.delta
+	}
+	return &MuxerFileResponse{
+		Status: http.StatusOK,
+		Header: map[string]string{
+			"Content-Type": `audio/mpegURL`,
+		},
+		Body: bytes.NewReader(content),
+	}
+}
+
+// playlistSnapshot immutable rendered media playlist.
+type playlistSnapshot struct {
+	full  []byte
+	delta []byte
+}
+
+// updateSnapshot must be called from the playlist goroutine after
+// the playlist has changed. The ended playlist is never updated.
+func (p *playlist) updateSnapshot() {
+	switch {
+	case p.ended:
+		p.snapshot.Store(&playlistSnapshot{
+			full:  p.endedPlaylist,
+			delta: p.endedPlaylist,
+		})
+	case p.hasContent():
+		p.snapshot.Store(&playlistSnapshot{
+			full:  p.fullPlaylist(false),
+			delta: p.fullPlaylist(true),
+		})
 	}
+}
+
+// Used before the first segment is finalized.
+const defaultBandwidth = 200000
+
+// bandwidth of the segments in the playlist in bits per second.
+type bandwidth struct {
+	peak    int
+	average int
+}
+
+// segmentsBandwidth calculates the peak and average bitrate of the segments.
+// Size is measured from the rendered parts, so the result includes the fMP4 overhead.
+func (p *playlist) segmentsBandwidth() bandwidth {
+	var peak float64
+	var totalSize int
+	var totalDuration time.Duration
+
+	for _, sog := range p.segments {
+		seg, ok := sog.(*Segment)
+		if !ok || seg.RenderedDuration <= 0 {
+			continue
+		}
+
+		size := seg.renderedSize()
+		bitrate := float64(size*8) / seg.RenderedDuration.Seconds()
+		if bitrate > peak {
+			peak = bitrate
+		}
+		totalSize += size
+		totalDuration += seg.RenderedDuration
+	}
+
+	if totalDuration == 0 {
+		return bandwidth{peak: defaultBandwidth, average: defaultBandwidth}
+	}
+
+	return bandwidth{
+		peak:    int(math.Ceil(peak)),
+		average: int(math.Ceil(float64(totalSize*8) / totalDuration.Seconds())),
+	}
+}
+
+func (p *playlist) bandwidth() bandwidth {
+	res := make(chan bandwidth)
 	select {
 	case <-p.ctx.Done():
-		return &MuxerFileResponse{Status: http.StatusInternalServerError}
-	case p.chPlaylist <- playlistReq:
-		return <-playlistRes
+		return bandwidth{peak: defaultBandwidth, average: defaultBandwidth}
+	case p.chBandwidth <- res:
+		return <-res
+	}
+}
+
+const audioGroupID = "audio"
+
+func audioMediaTags(renditions []AudioRendition) string {
+	var tags string
+	for _, r := range renditions {
+		tags += "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"" + audioGroupID + "\"" +
+			",NAME=\"" + r.Name + "\""
+		if r.Language != "" {
+			tags += ",LANGUAGE=\"" + r.Language + "\""
+		}
+		if r.Default {
+			tags += ",DEFAULT=YES"
+		} else {
+			tags += ",DEFAULT=NO"
+		}
+		tags += ",AUTOSELECT=YES"
+		if r.URI != "" {
+			tags += ",URI=\"" + r.URI + "\""
+		}
+		tags += "\n"
+	}
+	return tags
+}
+
+// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
+func (info StreamInfo) codecs() []string {
+	var codecs []string
+
+	if info.VideoTrackExist {
+		sps := info.VideoSPS
+		if len(sps) >= 4 {
+			codecs = append(codecs, "avc1."+hex.EncodeToString(sps[1:4]))
+		}
+	}
+
+	if info.AudioTrackExist {
+		codecs = append(
+			codecs,
+			"mp4a.40."+strconv.FormatInt(int64(info.AudioType), 10),
+		)
 	}
+
+	return codecs
+}
+
+// MIMEType returns the MIME type of the fMP4 stream including the
+// codecs parameter, used by Media Source Extensions.
+func (info StreamInfo) MIMEType() string {
+	return `video/mp4; codecs="` + strings.Join(info.codecs(), ",") + `"`
 }
 
-func primaryPlaylist(info StreamInfo) *MuxerFileResponse {
+func primaryPlaylist(
+	info StreamInfo,
+	bw bandwidth,
+	renditions []AudioRendition,
+) *MuxerFileResponse {
 	return &MuxerFileResponse{
 		Status: http.StatusOK,
 		Header: map[string]string{
 			"Content-Type": `audio/mpegURL`,
 		},
 		Body: func() io.Reader {
-			var codecs []string
+			streamInf := "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.Itoa(bw.peak) +
+				",AVERAGE-BANDWIDTH=" + strconv.Itoa(bw.average) +
+				",CODECS=\"" + strings.Join(info.codecs(), ",") + "\""
 
+			if info.VideoTrackExist && info.VideoWidth > 0 && info.VideoHeight > 0 {
+				streamInf += ",RESOLUTION=" + strconv.Itoa(info.VideoWidth) +
+					"x" + strconv.Itoa(info.VideoHeight)
+			}
+
+			// The value is a decimal-floating-point describing the maximum frame
+			// rate for all the video in the Variant Stream, rounded to three
+			// decimal places.
 			if info.VideoTrackExist {
-				sps := info.VideoSPS
-				if len(sps) >= 4 {
-					codecs = append(codecs, "avc1."+hex.EncodeToString(sps[1:4]))
+				if fps := info.VideoSPSP.FPS(); fps > 0 {
+					streamInf += ",FRAME-RATE=" + strconv.FormatFloat(fps, 'f', 3, 64)
 				}
 			}
 
-			// https://developer.mozilla.org/en-US/docs/Web/Media/Formats/codecs_parameter
-			if info.AudioTrackExist {
-				codecs = append(
-					codecs,
-					"mp4a.40."+strconv.FormatInt(int64(info.AudioType), 10),
-				)
+			if len(renditions) != 0 {
+				streamInf += ",AUDIO=\"" + audioGroupID + "\""
 			}
 
 			return bytes.NewReader([]byte("#EXTM3U\n" +
 				"#EXT-X-VERSION:9\n" +
 				"#EXT-X-INDEPENDENT-SEGMENTS\n" +
 				"\n" +
-				"#EXT-X-STREAM-INF:BANDWIDTH=200000,CODECS=\"" + strings.Join(codecs, ",") + "\"\n" +
+				audioMediaTags(renditions) +
+				streamInf + "\n" +
 				"stream.m3u8\n"))
 		}(),
 	}
 }
 
-func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
-	cnt := "#EXTM3U\n"
-	cnt += "#EXT-X-VERSION:9\n"
+// mediaPlaylist renders the media playlist into a pooled buffer.
+func (p *playlist) mediaPlaylist(isDeltaUpdate bool) io.Reader {
+	buf := getPlaylistBuffer()
+	p.writePlaylist(buf, isDeltaUpdate)
+	return &playlistReader{buf: buf}
+}
+
+// fullPlaylist renders the media playlist into a exactly sized slice.
+func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte {
+	buf := getPlaylistBuffer()
+	defer putPlaylistBuffer(buf)
+	p.writePlaylist(buf, isDeltaUpdate)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// writePlaylist writes the media playlist. The tags are written piece
+// by piece, the playlist is rendered for every request.
+func (p *playlist) writePlaylist(buf *bytes.Buffer, isDeltaUpdate bool) { //nolint:funlen
+	// Scratch space for the formatted numbers.
+	var num [64]byte
+	writeUint := func(v uint64) {
+		buf.Write(strconv.AppendUint(num[:0], v, 10))
+	}
+	writeFloat := func(v float64, prec int) {
+		buf.Write(strconv.AppendFloat(num[:0], v, 'f', prec, 64))
+	}
+
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:9\n")
 
 	targetDuration := targetDuration(p.segments)
-	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n"
+	buf.WriteString("#EXT-X-TARGETDURATION:")
+	writeUint(uint64(targetDuration))
+	buf.WriteString("\n")
 
 	skipBoundary := float64(targetDuration * 6)
 
@@ -460,7 +750,7 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 
 	// The value is an enumerated-string whose value is YES if the server
 	// supports Blocking Playlist Reload
-	cnt += "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES"
+	buf.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES")
 
 	// The value is a decimal-floating-point number of seconds that
 	// indicates the server-recommended minimum distance from the end of
@@ -468,38 +758,66 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 	// they should seek when playing in Low-Latency Mode.  Its value MUST
 	// be at least twice the Part Target Duration.  Its value SHOULD be
 	// at least three times the Part Target Duration.
-	cnt += ",PART-HOLD-BACK=" + strconv.FormatFloat((partTargetDuration).Seconds()*2.5, 'f', 5, 64)
+	buf.WriteString(",PART-HOLD-BACK=")
+	writeFloat((partTargetDuration).Seconds()*2.5, 5)
 
 	// Indicates that the Server can produce Playlist Delta Updates in
 	// response to the _HLS_skip Delivery Directive.  Its value is the
 	// Skip Boundary, a decimal-floating-point number of seconds.  The
 	// Skip Boundary MUST be at least six times the Target Duration.
-	cnt += ",CAN-SKIP-UNTIL=" + strconv.FormatFloat(skipBoundary, 'f', -1, 64)
+	buf.WriteString(",CAN-SKIP-UNTIL=")
+	writeFloat(skipBoundary, -1)
 
-	cnt += "\n"
+	buf.WriteString("\n")
 
-	cnt += "#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(partTargetDuration.Seconds(), 'f', -1, 64) + "\n"
+	buf.WriteString("#EXT-X-PART-INF:PART-TARGET=")
+	writeFloat(partTargetDuration.Seconds(), -1)
+	buf.WriteString("\n")
 
-	cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.segmentDeleteCount), 10) + "\n"
+	buf.WriteString("#EXT-X-MEDIA-SEQUENCE:")
+	writeUint(uint64(p.segmentDeleteCount))
+	buf.WriteString("\n")
+
+	// The key applies to all segments and is
+	// therefore never skipped by delta updates.
+	if p.encryptor != nil {
+		buf.WriteString(p.encryptor.keyTag())
+	}
 
 	skipped := 0
 	if !isDeltaUpdate {
-		cnt += "#EXT-X-MAP:URI=\"init.mp4\"\n"
+		buf.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
 	} else {
+		// Segments that start within the Skip Boundary
+		// from the end of the playlist must not be skipped.
 		var curDuration time.Duration
 		shown := 0
-		for _, segment := range p.segments {
-			curDuration += segment.getRenderedDuration()
-			if curDuration.Seconds() >= skipBoundary {
+		for i := len(p.segments) - 1; i >= 0; i-- {
+			curDuration += p.segments[i].getRenderedDuration()
+			if curDuration.Seconds() > skipBoundary {
 				break
 			}
 			shown++
 		}
 		skipped = len(p.segments) - shown
-		cnt += "#EXT-X-SKIP:SKIPPED-SEGMENTS=" + strconv.FormatInt(int64(skipped), 10) + "\n"
+		buf.WriteString("#EXT-X-SKIP:SKIPPED-SEGMENTS=")
+		writeUint(uint64(skipped))
+		buf.WriteString("\n")
 	}
 
-	cnt += "\n"
+	buf.WriteString("\n")
+
+	writePart := func(part *MuxerPart) {
+		buf.WriteString("#EXT-X-PART:DURATION=")
+		writeFloat(part.renderedDuration.Seconds(), 5)
+		buf.WriteString(",URI=\"part")
+		writeUint(part.id)
+		buf.WriteString(".mp4\"")
+		if part.isIndependent {
+			buf.WriteString(",INDEPENDENT=YES")
+		}
+		buf.WriteString("\n")
+	}
 
 	for i, sog := range p.segments {
 		if i < skipped {
@@ -509,58 +827,62 @@ func (p *playlist) fullPlaylist(isDeltaUpdate bool) []byte { //nolint:funlen
 		switch seg := sog.(type) {
 		case *Segment:
 			if (len(p.segments) - i) <= 2 {
-				cnt += "#EXT-X-PROGRAM-DATE-TIME:" + seg.StartTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n"
+				buf.WriteString("#EXT-X-PROGRAM-DATE-TIME:")
+				buf.Write(seg.StartTime.AppendFormat(num[:0], "2006-01-02T15:04:05.999Z07:00"))
+				buf.WriteString("\n")
 			}
 
 			if (len(p.segments) - i) <= 2 {
 				for _, part := range seg.Parts {
-					cnt += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(part.renderedDuration.Seconds(), 'f', 5, 64) +
-						",URI=\"" + part.name() + ".mp4\""
-					if part.isIndependent {
-						cnt += ",INDEPENDENT=YES"
-					}
-					cnt += "\n"
+					writePart(part)
 				}
 			}
 
-			cnt += "#EXTINF:" + strconv.FormatFloat(seg.RenderedDuration.Seconds(), 'f', 5, 64) + ",\n" +
-				seg.name + ".mp4\n"
+			buf.WriteString("#EXTINF:")
+			writeFloat(seg.RenderedDuration.Seconds(), 5)
+			buf.WriteString(",\n")
+			buf.WriteString(seg.name)
+			buf.WriteString(".mp4\n")
 
 		case *Gap:
-			cnt += "#EXT-X-GAP\n" +
-				"#EXTINF:" + strconv.FormatFloat(seg.renderedDuration.Seconds(), 'f', 5, 64) + ",\n" +
-				"gap.mp4\n"
+			buf.WriteString("#EXT-X-GAP\n")
+			buf.WriteString("#EXTINF:")
+			writeFloat(seg.renderedDuration.Seconds(), 5)
+			buf.WriteString(",\n")
+			buf.WriteString("gap.mp4\n")
 		}
 	}
 
 	for _, part := range p.nextSegmentParts {
-		cnt += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(part.renderedDuration.Seconds(), 'f', 5, 64) +
-			",URI=\"" + part.name() + ".mp4\""
-		if part.isIndependent {
-			cnt += ",INDEPENDENT=YES"
-		}
-		cnt += "\n"
+		writePart(part)
+	}
+
+	if p.ended {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+		return
 	}
 
 	// preload hint must always be present
 	// otherwise hls.js goes into a loop
-	cnt += "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"" + partName(p.nextPartID) + ".mp4\"\n"
-
-	return []byte(cnt)
+	buf.WriteString("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part")
+	writeUint(p.nextPartID)
+	buf.WriteString(".mp4\"\n")
 }
 
 type segmentRequest struct {
 	name string
+	cmcd CMCD
 	res  chan *MuxerFileResponse
 }
 
 type blockingPartRequest struct {
 	partName string
 	partID   uint64
+	cmcd     CMCD
 	res      chan *MuxerFileResponse
 }
 
-func (p *playlist) segmentReader(fname string) *MuxerFileResponse {
+func (p *playlist) segmentReader(fname string, cmcd CMCD) *MuxerFileResponse {
 	switch {
 	case strings.HasPrefix(fname, "seg"):
 		base := strings.TrimSuffix(fname, ".mp4")
@@ -568,6 +890,7 @@ func (p *playlist) segmentReader(fname string) *MuxerFileResponse {
 		segmentRes := make(chan *MuxerFileResponse)
 		segmentReq := segmentRequest{
 			name: base,
+			cmcd: cmcd,
 			res:  segmentRes,
 		}
 		select {
@@ -581,6 +904,7 @@ func (p *playlist) segmentReader(fname string) *MuxerFileResponse {
 		blockingPartRes := make(chan *MuxerFileResponse)
 		blockingPartReq := blockingPartRequest{
 			partName: fname,
+			cmcd:     cmcd,
 			res:      blockingPartRes,
 		}
 		select {
@@ -614,19 +938,42 @@ func (p *playlist) onSegmentFinalized(segment *Segment) {
 }
 
 func (p *playlist) segmentFinalized(segment *Segment) {
+	if err := p.spiller.add(segment); err != nil {
+		p.logf(log.LevelError, "spill segment: %v", err)
+	}
+
+	p.segmentsByName[segment.name] = segment
+	p.appendSegmentOrGap(segment, segment.RenderedDuration)
+	p.nextSegmentID = segment.ID + 1
+	p.nextSegmentParts = p.nextSegmentParts[:0]
+
+	for done := range p.segFinalOnHold {
+		close(done)
+		delete(p.segFinalOnHold, done)
+	}
+	for req := range p.nextSegmentsOnHold {
+		if segment.ID > req.prevID {
+			req.res <- segment
+			delete(p.nextSegmentsOnHold, req)
+		}
+	}
+
+	p.checkPending()
+}
+
+// appendSegmentOrGap appends a entry and deletes the oldest entry if
+// the playlist is full. The first entry is preceded by initial gaps.
+func (p *playlist) appendSegmentOrGap(sog SegmentOrGap, duration time.Duration) {
 	// add initial gaps, required by iOS.
 	if len(p.segments) == 0 {
 		for i := 0; i < 7; i++ {
 			p.segments = append(p.segments, &Gap{
-				renderedDuration: segment.RenderedDuration,
+				renderedDuration: duration,
 			})
 		}
 	}
 
-	p.segmentsByName[segment.name] = segment
-	p.segments = append(p.segments, segment)
-	p.nextSegmentID = segment.ID + 1
-	p.nextSegmentParts = p.nextSegmentParts[:0]
+	p.segments = append(p.segments, sog)
 
 	if len(p.segments) > p.segmentCount {
 		toDelete := p.segments[0]
@@ -643,23 +990,41 @@ func (p *playlist) segmentFinalized(segment *Segment) {
 			p.parts = p.parts[len(toDeleteSeg.Parts):]
 
 			delete(p.segmentsByName, toDeleteSeg.name)
+			p.removeSpilled(toDeleteSeg)
 		}
 
 		p.segments[0] = nil // Free memory!
 		p.segments = p.segments[1:]
 		p.segmentDeleteCount++
 	}
+}
 
-	for done := range p.segFinalOnHold {
-		close(done)
-		delete(p.segFinalOnHold, done)
+type gapFinalizedRequest struct {
+	id   uint64
+	gap  *Gap
+	done chan struct{}
+}
+
+func (p *playlist) onGapFinalized(id uint64, gap *Gap) {
+	done := make(chan struct{})
+	req := gapFinalizedRequest{
+		id:   id,
+		gap:  gap,
+		done: done,
 	}
-	for req := range p.nextSegmentsOnHold {
-		if segment.ID > req.prevID {
-			req.res <- segment
-			delete(p.nextSegmentsOnHold, req)
-		}
+	select {
+	case <-p.ctx.Done():
+	case p.chGapFinalized <- req:
+		<-done
 	}
+}
+
+// gapFinalized appends a gap. Gaps doesn't release the
+// requests waiting for segments since they have no content.
+func (p *playlist) gapFinalized(id uint64, gap *Gap) {
+	p.appendSegmentOrGap(gap, gap.renderedDuration)
+	p.nextSegmentID = id + 1
+	p.nextSegmentParts = p.nextSegmentParts[:0]
 
 	p.checkPending()
 }
@@ -682,6 +1047,15 @@ func (p *playlist) partFinalized(part *MuxerPart) {
 	}
 }
 
+func (p *playlist) endStream() {
+	done := make(chan struct{})
+	select {
+	case <-p.ctx.Done():
+	case p.chEnd <- done:
+		<-done
+	}
+}
+
 func (p *playlist) waitForSegFinalized() {
 	res := make(chan struct{})
 	select {
@@ -713,3 +1087,53 @@ func (p *playlist) nextSegment(prevID uint64) (*Segment, error) {
 		return res, nil
 	}
 }
+
+type nextPartRequest struct {
+	prevID uint64
+	latest bool
+	res    chan *MuxerPart
+}
+
+// findNextPart returns the part following prevID. If the part has already
+// been deleted, or the latest part was requested, the latest independent
+// part is returned instead. Returns nil if the part doesn't exist yet.
+func (p *playlist) findNextPart(req nextPartRequest) *MuxerPart {
+	if len(p.parts) == 0 {
+		return nil
+	}
+
+	oldestID := p.parts[0].id
+	if req.latest || req.prevID+1 < oldestID {
+		for i := len(p.parts) - 1; i >= 0; i-- {
+			if p.parts[i].isIndependent {
+				return p.parts[i]
+			}
+		}
+		return nil
+	}
+
+	i := req.prevID + 1 - oldestID
+	if i >= uint64(len(p.parts)) {
+		return nil
+	}
+	return p.parts[i]
+}
+
+func (p *playlist) nextPart(prevID uint64, latest bool) (*MuxerPart, error) {
+	nextPartRes := make(chan *MuxerPart)
+	nextPartReq := nextPartRequest{
+		prevID: prevID,
+		latest: latest,
+		res:    nextPartRes,
+	}
+	select {
+	case <-p.ctx.Done():
+		return nil, context.Canceled
+	case p.chNextPart <- nextPartReq:
+		res := <-nextPartRes
+		if res == nil {
+			return nil, context.Canceled
+		}
+		return res, nil
+	}
+}
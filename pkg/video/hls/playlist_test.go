@@ -2,7 +2,14 @@ package hls
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib/pkg/h264"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,7 +18,7 @@ func TestNextSegment(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	playlist := newPlaylist(ctx, 3)
+	playlist := newPlaylist(ctx, 3, nil, nil, nil)
 	go playlist.start()
 
 	seg5 := &Segment{ID: 5}
@@ -52,3 +59,284 @@ func TestNextSegment(t *testing.T) {
 		<-done
 	})
 }
+
+func TestPlaylistEnd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newPlaylist(ctx, 3, nil, nil, nil)
+	go p.start()
+
+	p.onSegmentFinalized(&Segment{ID: 0, name: "seg0", RenderedDuration: time.Second})
+
+	readBody := func(res *MuxerFileResponse) string {
+		require.Equal(t, http.StatusOK, res.Status)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// Blocking request for the next segment.
+	blocking := make(chan *MuxerFileResponse)
+	go func() { blocking <- p.playlistReader("1", "", "") }()
+
+	p.endStream()
+	ended := readBody(<-blocking)
+	require.True(t, strings.HasSuffix(ended, "seg0.mp4\n#EXT-X-ENDLIST\n"), ended)
+	require.NotContains(t, ended, "PRELOAD-HINT")
+
+	// The playlist is frozen, but the recorders can read new segments.
+	seg1 := &Segment{ID: 1, name: "seg1", RenderedDuration: time.Second}
+	p.onSegmentFinalized(seg1)
+	require.Equal(t, ended, readBody(p.playlistReader("", "", "")))
+	require.Equal(t, ended, readBody(p.playlistReader("2", "", "")))
+
+	seg, err := p.nextSegment(0)
+	require.NoError(t, err)
+	require.Equal(t, seg1, seg)
+}
+
+func TestPlaylistSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The playlist goroutine isn't started, the
+	// snapshot is read without entering it.
+	p := newPlaylist(ctx, 3, nil, nil, nil)
+	require.Equal(t, http.StatusNotFound, p.playlistReader("", "", "").Status)
+
+	p.segmentFinalized(&Segment{ID: 0, name: "seg0", RenderedDuration: time.Second})
+
+	res := p.playlistReader("", "", "")
+	require.Equal(t, http.StatusOK, res.Status)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, string(p.fullPlaylist(false)), string(body))
+
+	res = p.playlistReader("", "", "YES")
+	require.Equal(t, http.StatusOK, res.Status)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "#EXT-X-SKIP:SKIPPED-SEGMENTS=")
+
+	cancel()
+	require.Equal(t, http.StatusInternalServerError, p.playlistReader("", "", "").Status)
+}
+
+func TestNextPart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playlist := newPlaylist(ctx, 3, nil, nil, nil)
+	go playlist.start()
+
+	part5 := &MuxerPart{id: 5, isIndependent: true}
+	part6 := &MuxerPart{id: 6}
+	part7 := &MuxerPart{id: 7, isIndependent: true}
+	part8 := &MuxerPart{id: 8}
+
+	playlist.partFinalized(part5)
+	playlist.partFinalized(part6)
+	playlist.partFinalized(part7)
+	playlist.partFinalized(part8)
+
+	cases := map[string]struct {
+		prevID   uint64
+		latest   bool
+		expected *MuxerPart
+	}{
+		"ok":     {4, false, part5},
+		"ok2":    {5, false, part6},
+		"ok3":    {7, false, part8},
+		"behind": {2, false, part7},
+		"latest": {0, true, part7},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			part, err := playlist.nextPart(tc.prevID, tc.latest)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, part)
+		})
+	}
+	t.Run("blocking", func(t *testing.T) {
+		part9 := &MuxerPart{id: 9}
+		done := make(chan struct{})
+		go func() {
+			part, err := playlist.nextPart(8, false)
+			require.NoError(t, err)
+			require.Equal(t, part9, part)
+			close(done)
+		}()
+
+		playlist.partFinalized(part9)
+		<-done
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		playlist := newPlaylist(ctx, 3, nil, nil, nil)
+		go playlist.start()
+
+		done := make(chan struct{})
+		go func() {
+			_, err := playlist.nextPart(0, true)
+			require.ErrorIs(t, err, context.Canceled)
+			close(done)
+		}()
+
+		cancel()
+		<-done
+	})
+}
+
+func TestPrimaryPlaylist(t *testing.T) {
+	sps := []byte{
+		103, 100, 0, 22, 172, 217, 64, 164,
+		59, 228, 136, 192, 68, 0, 0, 3,
+		0, 4, 0, 0, 3, 0, 96, 60,
+		88, 182, 88,
+	}
+	var spsp h264.SPS
+	require.NoError(t, spsp.Unmarshal(sps))
+
+	info := StreamInfo{
+		VideoTrackExist: true,
+		VideoSPS:        sps,
+		VideoSPSP:       spsp,
+		VideoWidth:      spsp.Width(),
+		VideoHeight:     spsp.Height(),
+		AudioTrackExist: true,
+		AudioType:       2,
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		res := primaryPlaylist(info, bandwidth{peak: 3000, average: 2000}, nil)
+		require.Equal(t, http.StatusOK, res.Status)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		expected := "#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=3000,AVERAGE-BANDWIDTH=2000," +
+			"CODECS=\"avc1.640016,mp4a.40.2\",RESOLUTION=650x450,FRAME-RATE=12.000\n" +
+			"stream.m3u8\n"
+		require.Equal(t, expected, string(body))
+	})
+	t.Run("mimeType", func(t *testing.T) {
+		require.Equal(t, `video/mp4; codecs="avc1.640016,mp4a.40.2"`, info.MIMEType())
+	})
+	t.Run("audioRenditions", func(t *testing.T) {
+		renditions := []AudioRendition{
+			{Name: "en", Language: "en", Default: true},
+			{Name: "Audio 1", URI: "audio1/stream.m3u8"},
+		}
+		res := primaryPlaylist(info, bandwidth{peak: 3000, average: 2000}, renditions)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		expected := "#EXTM3U\n" +
+			"#EXT-X-VERSION:9\n" +
+			"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			"\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"en\"," +
+			"LANGUAGE=\"en\",DEFAULT=YES,AUTOSELECT=YES\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"Audio 1\"," +
+			"DEFAULT=NO,AUTOSELECT=YES,URI=\"audio1/stream.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=3000,AVERAGE-BANDWIDTH=2000," +
+			"CODECS=\"avc1.640016,mp4a.40.2\",RESOLUTION=650x450,FRAME-RATE=12.000," +
+			"AUDIO=\"audio\"\n" +
+			"stream.m3u8\n"
+		require.Equal(t, expected, string(body))
+	})
+}
+
+func TestSegmentsBandwidth(t *testing.T) {
+	newSeg := func(duration time.Duration, partSizes ...int) *Segment {
+		seg := &Segment{RenderedDuration: duration}
+		for _, size := range partSizes {
+			part := &MuxerPart{}
+			part.setContent(newSharedBuffer(make([]byte, size)))
+			seg.Parts = append(seg.Parts, part)
+		}
+		return seg
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		p := newPlaylist(context.Background(), 3, nil, nil, nil)
+		expected := bandwidth{peak: defaultBandwidth, average: defaultBandwidth}
+		require.Equal(t, expected, p.segmentsBandwidth())
+	})
+	t.Run("ok", func(t *testing.T) {
+		p := newPlaylist(context.Background(), 3, nil, nil, nil)
+		p.segments = []SegmentOrGap{
+			&Gap{renderedDuration: time.Second},
+			newSeg(time.Second, 500, 500),
+			newSeg(2*time.Second, 1000),
+			newSeg(time.Second, 250),
+		}
+		// 1000B/1s=8000, 1000B/2s=4000, 250B/1s=2000
+		// Average: 2250B/4s=4500
+		expected := bandwidth{peak: 8000, average: 4500}
+		require.Equal(t, expected, p.segmentsBandwidth())
+	})
+}
+
+func TestWriteGap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const clockRate = 44100
+	m := NewMuxer(
+		ctx,
+		20,
+		time.Second,
+		200*time.Millisecond,
+		50000000,
+		func(log.Level, string, ...interface{}) {},
+		false,
+		nil,
+		true,
+		func() int { return clockRate },
+		func() (*StreamInfo, error) { return &StreamInfo{AudioTrackExist: true}, nil },
+		nil,
+		nil,
+		nil,
+	)
+
+	sampleDuration := time.Second * 1024 / clockRate
+	writeAudio := func(start, duration time.Duration) {
+		for pts := start; pts < start+duration; pts += sampleDuration {
+			require.NoError(t, m.WriteAAC(time.Time{}, pts, []byte{1, 2}))
+		}
+	}
+	readPlaylist := func() string {
+		res := m.File("stream.m3u8", "", "", "", CMCD{})
+		require.Equal(t, http.StatusOK, res.Status)
+		buf, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	// Before the first segment.
+	require.NoError(t, m.WriteGap(time.Second))
+
+	writeAudio(0, 1500*time.Millisecond)
+	require.NoError(t, m.WriteGap(2500*time.Millisecond))
+
+	playlist := readPlaylist()
+	require.Equal(t, 7+3, strings.Count(playlist, "#EXT-X-GAP"))
+	require.Contains(t, playlist, "seg8.mp4\n"+
+		"#EXT-X-GAP\n#EXTINF:1.00000,\ngap.mp4\n"+
+		"#EXT-X-GAP\n#EXTINF:1.00000,\ngap.mp4\n"+
+		"#EXT-X-GAP\n#EXTINF:0.50000,\ngap.mp4\n")
+
+	// The segment after the gap uses the next media sequence number.
+	writeAudio(4*time.Second, 1500*time.Millisecond)
+	seg, err := m.NextSegment(8)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), seg.ID)
+	require.Contains(t, readPlaylist(), "gap.mp4\n#EXTINF:1.")
+}
@@ -0,0 +1,97 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Buffers larger than maxPooledBufferSize are left to the garbage
+// collector, a single large part shouldn't be kept forever.
+const maxPooledBufferSize = 4 << 20
+
+// bufferPool reuses the byte slices of rendered parts.
+//
+// Ownership rules: a slice from get is owned by the caller until it's
+// passed to put or to a shared buffer created by newPooledSharedBuffer,
+// the shared buffer returns it when the last reference is released.
+// The slice must not be used after that and must only be put once.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+var partBufferPool = &bufferPool{}
+
+// get returns a empty slice with at least the requested capacity.
+// A pooled slice that is too small is put back for smaller parts,
+// audio only parts are much smaller than video parts.
+func (p *bufferPool) get(size int) []byte {
+	if v := p.pool.Get(); v != nil {
+		buf := *(v.(*[]byte))
+		if cap(buf) >= size {
+			return buf[:0]
+		}
+		p.pool.Put(v)
+	}
+	return make([]byte, 0, size)
+}
+
+func (p *bufferPool) put(buf []byte) {
+	if cap(buf) == 0 || cap(buf) > maxPooledBufferSize {
+		return
+	}
+	buf = buf[:0]
+	p.pool.Put(&buf)
+}
+
+// playlistBufferPool reuses the buffers that the media playlists are
+// rendered into. A buffer is owned by the playlistReader of the
+// response until the reader is closed.
+var playlistBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+func getPlaylistBuffer() *bytes.Buffer {
+	buf := playlistBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putPlaylistBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	playlistBufferPool.Put(buf)
+}
+
+// playlistReader reads a pooled playlist buffer, the
+// buffer is returned to the pool when it's closed.
+type playlistReader struct {
+	buf *bytes.Buffer
+}
+
+func (r *playlistReader) Read(p []byte) (int, error) {
+	if r.buf == nil {
+		return 0, io.EOF
+	}
+	return r.buf.Read(p)
+}
+
+// WriteTo implements io.WriterTo, used by io.Copy.
+func (r *playlistReader) WriteTo(w io.Writer) (int64, error) {
+	if r.buf == nil {
+		return 0, nil
+	}
+	return r.buf.WriteTo(w)
+}
+
+// Close returns the buffer to the pool.
+func (r *playlistReader) Close() error {
+	if r.buf != nil {
+		putPlaylistBuffer(r.buf)
+		r.buf = nil
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPool(t *testing.T) {
+	t.Run("capacity", func(t *testing.T) {
+		pool := &bufferPool{}
+		buf := pool.get(10)
+		require.Len(t, buf, 0)
+		require.GreaterOrEqual(t, cap(buf), 10)
+
+		pool.put(append(buf, 1, 2, 3))
+		buf = pool.get(100)
+		require.Len(t, buf, 0)
+		require.GreaterOrEqual(t, cap(buf), 100)
+	})
+	t.Run("undersized", func(t *testing.T) {
+		// sync.Pool may drop items at random, it does
+		// with the race detector, so a few tries are allowed.
+		survived := func() bool {
+			pool := &bufferPool{}
+			small := pool.get(10)
+			pool.put(small)
+
+			large := pool.get(1000)
+			require.GreaterOrEqual(t, cap(large), 1000)
+
+			return &small[:1][0] == &pool.get(10)[:1][0]
+		}
+		for i := 0; i < 20; i++ {
+			if survived() {
+				return
+			}
+		}
+		t.Fatal("the small buffer wasn't kept in the pool")
+	})
+	t.Run("sharedBuffer", func(t *testing.T) {
+		pool := &bufferPool{}
+		buf := newPooledSharedBuffer(append(pool.get(3), 1, 2, 3), pool)
+
+		r := newBufferReader(buf)
+		buf.release()
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2, 3}, content)
+		require.Nil(t, buf.buf)
+	})
+}
+
+func TestPlaylistReader(t *testing.T) {
+	buf := getPlaylistBuffer()
+	buf.WriteString("#EXTM3U\n")
+
+	r := &playlistReader{buf: buf}
+	var w bytes.Buffer
+	_, err := io.Copy(&w, r)
+	require.NoError(t, err)
+	require.Equal(t, "#EXTM3U\n", w.String())
+
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	_, err = r.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMediaPlaylist(t *testing.T) {
+	p := newBenchmarkPlaylist()
+	content, err := io.ReadAll(p.mediaPlaylist(false))
+	require.NoError(t, err)
+	require.Equal(t, string(p.fullPlaylist(false)), string(content))
+}
+
+func newBenchmarkSamples() []*VideoSample {
+	samples := make([]*VideoSample, 30)
+	for i := range samples {
+		samples[i] = &VideoSample{
+			PTS:     int64(i) * 33000000,
+			DTS:     int64(i) * 33000000,
+			AVCC:    make([]byte, 5000),
+			NextDTS: int64(i+1) * 33000000,
+		}
+	}
+	return samples
+}
+
+func newBenchmarkPlaylist() *playlist {
+	p := newPlaylist(context.Background(), 7, nil, nil, nil)
+	startTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		seg := &Segment{
+			ID:               uint64(i),
+			name:             "seg" + strconv.Itoa(i),
+			StartTime:        startTime.Add(time.Duration(i) * time.Second),
+			RenderedDuration: time.Second,
+		}
+		for j := 0; j < 5; j++ {
+			seg.Parts = append(seg.Parts, &MuxerPart{
+				id:               uint64(i*5 + j),
+				isIndependent:    j == 0,
+				renderedDuration: 200 * time.Millisecond,
+			})
+		}
+		p.segments = append(p.segments, seg)
+	}
+	p.nextPartID = 35
+	return p
+}
+
+// The pooled benchmarks should allocate a fraction of the unpooled bytes.
+//
+//	go test ./pkg/video/hls -run none -bench 'Part|Playlist' -benchmem
+func BenchmarkPartRender(b *testing.B) {
+	samples := newBenchmarkSamples()
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := generatePart(0, true, false, nil, samples, nil, nil)
+			require.NoError(b, err)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			part := &MuxerPart{
+				videoTrackExist: true,
+				VideoSamples:    samples,
+				audioClockRate:  func() int { return 0 },
+			}
+			require.NoError(b, part.finalize())
+			part.release()
+		}
+	})
+}
+
+func BenchmarkMediaPlaylist(b *testing.B) {
+	p := newBenchmarkPlaylist()
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := io.Copy(io.Discard, bytes.NewReader(p.fullPlaylist(false)))
+			require.NoError(b, err)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := p.mediaPlaylist(false)
+			_, err := io.Copy(io.Discard, r)
+			require.NoError(b, err)
+			r.(io.Closer).Close()
+		}
+	})
+}
@@ -7,36 +7,6 @@ import (
 	"time"
 )
 
-type partsReader struct {
-	parts   []*MuxerPart
-	curPart int
-	curPos  int
-}
-
-func (mbr *partsReader) Read(p []byte) (int, error) {
-	n := 0
-	lenp := len(p)
-
-	for {
-		if mbr.curPart >= len(mbr.parts) {
-			return n, io.EOF
-		}
-
-		copied := copy(p[n:], mbr.parts[mbr.curPart].renderedContent[mbr.curPos:])
-		mbr.curPos += copied
-		n += copied
-
-		if mbr.curPos == len(mbr.parts[mbr.curPart].renderedContent) {
-			mbr.curPart++
-			mbr.curPos = 0
-		}
-
-		if n == lenp {
-			return n, nil
-		}
-	}
-}
-
 // Segment .
 type Segment struct {
 	ID              uint64
@@ -49,12 +19,16 @@ type Segment struct {
 	audioClockRate  audioClockRateFunc
 	genPartID       func() uint64
 	onPartFinalized func(*MuxerPart)
+	encryptor       *Encryptor
 
 	name             string
 	size             uint64
 	Parts            []*MuxerPart
 	currentPart      *MuxerPart
 	RenderedDuration time.Duration
+
+	// Set by the spiller.
+	spillPath string
 }
 
 func newSegment(
@@ -68,6 +42,7 @@ func newSegment(
 	audioClockRate audioClockRateFunc,
 	genPartID func() uint64,
 	onPartFinalized func(*MuxerPart),
+	encryptor *Encryptor,
 ) *Segment {
 	s := &Segment{
 		ID:              id,
@@ -80,6 +55,7 @@ func newSegment(
 		audioClockRate:  audioClockRate,
 		genPartID:       genPartID,
 		onPartFinalized: onPartFinalized,
+		encryptor:       encryptor,
 		name:            "seg" + strconv.FormatUint(id, 10),
 	}
 
@@ -89,25 +65,59 @@ func newSegment(
 		s.audioClockRate,
 		s.muxerStartTime,
 		s.genPartID(),
+		s.encryptor,
 	)
 
 	return s
 }
 
 func (s *Segment) reader() io.Reader {
-	return &partsReader{parts: s.Parts}
+	if s.spillPath != "" {
+		return (&spilledContent{
+			path: s.spillPath,
+			size: s.renderedSize(),
+		}).reader()
+	}
+	bufs := make([]*sharedBuffer, 0, len(s.Parts))
+	for _, part := range s.Parts {
+		buf := part.buffer()
+		if buf == nil {
+			if part.renderedSize() == 0 {
+				continue
+			}
+			return &bufferReader{err: ErrBufferReleased}
+		}
+		bufs = append(bufs, buf)
+	}
+	return newBufferReader(bufs...)
 }
 
 func (s *Segment) getRenderedDuration() time.Duration {
 	return s.RenderedDuration
 }
 
+// renderedSize returns the combined size of all rendered parts in bytes.
+func (s *Segment) renderedSize() int {
+	size := 0
+	for _, part := range s.Parts {
+		size += part.renderedSize()
+	}
+	return size
+}
+
+// isEmpty returns true if no samples have been written to the segment.
+func (s *Segment) isEmpty() bool {
+	return len(s.Parts) == 0 &&
+		len(s.currentPart.VideoSamples) == 0 &&
+		len(s.currentPart.AudioSamples) == 0
+}
+
 func (s *Segment) finalize(nextVideoSample *VideoSample) error {
 	if err := s.currentPart.finalize(); err != nil {
 		return err
 	}
 
-	if s.currentPart.renderedContent != nil {
+	if s.currentPart.content != nil {
 		s.onPartFinalized(s.currentPart)
 		s.Parts = append(s.Parts, s.currentPart)
 	}
@@ -155,6 +165,7 @@ func (s *Segment) writeH264(sample *VideoSample, adjustedPartDuration time.Durat
 			s.audioClockRate,
 			s.muxerStartTime,
 			s.genPartID(),
+			s.encryptor,
 		)
 	}
 
@@ -187,6 +198,7 @@ func (s *Segment) writeAAC(sample *AudioSample, adjustedPartDuration time.Durati
 			s.audioClockRate,
 			s.muxerStartTime,
 			s.genPartID(),
+			s.encryptor,
 		)
 	}
 
@@ -41,6 +41,41 @@ func findCompatiblePartDuration(
 	return i
 }
 
+// alignPartDuration returns the duration closest to the target that
+// divides the keyframe interval into parts of a whole number of samples,
+// the parts are then aligned to the keyframes. The target is returned
+// if the closest duration is more than twice as long or short.
+func alignPartDuration(target, keyframeInterval, sampleDuration time.Duration) time.Duration {
+	if target <= 0 || keyframeInterval <= 0 || sampleDuration <= 0 {
+		return target
+	}
+
+	samples := int((keyframeInterval + sampleDuration/2) / sampleDuration)
+	targetSamples := float64(target) / float64(sampleDuration)
+
+	best := 0
+	bestRatio := 0.0
+	for n := 1; n <= samples; n++ {
+		if samples%n != 0 {
+			continue
+		}
+		ratio := float64(n) / targetSamples
+		if ratio < 1 {
+			ratio = 1 / ratio
+		}
+		if best == 0 || ratio < bestRatio {
+			best = n
+			bestRatio = ratio
+		}
+	}
+	if best == 0 || bestRatio > 2 {
+		return target
+	}
+	// Calculated from the interval, the rounded sample durations
+	// can add up to a few nanoseconds more than the interval.
+	return keyframeInterval * time.Duration(best) / time.Duration(samples)
+}
+
 type segmenter struct {
 	segmentDuration    time.Duration
 	partDuration       time.Duration
@@ -51,8 +86,11 @@ type segmenter struct {
 	audioClockRate     audioClockRateFunc
 	onSegmentFinalized func(*Segment)
 	onPartFinalized    func(*MuxerPart)
+	onGapFinalized     func(uint64, *Gap)
+	encryptor          *Encryptor
 
 	startDTS              time.Duration
+	startPTS              time.Duration
 	muxerStartTime        int64
 	videoFirstIDRReceived bool
 	videoDTSExtractor     *h264.DTSExtractor
@@ -64,11 +102,20 @@ type segmenter struct {
 	nextAudioSample       *AudioSample
 	firstSegmentFinalized bool
 	sampleDurations       map[time.Duration]struct{}
+	alignedPartDuration   time.Duration
 	adjustedPartDuration  time.Duration
+	prevIDRDTS            int64
+	prevIDRPresent        bool
+
+	// Set after a gap, the next segment must start with a IDR.
+	waitForIDR bool
 }
 
 type videoSPSFunc func() []byte
 
+// Required by iOS.
+const firstSegmentID = 7
+
 func newSegmenter(
 	muxerStartTime int64,
 	segmentDuration time.Duration,
@@ -80,6 +127,8 @@ func newSegmenter(
 	audioClockRate audioClockRateFunc,
 	onSegmentFinalized func(*Segment),
 	onPartFinalized func(*MuxerPart),
+	onGapFinalized func(uint64, *Gap),
+	encryptor *Encryptor,
 ) *segmenter {
 	return &segmenter{
 		segmentDuration:    segmentDuration,
@@ -91,9 +140,13 @@ func newSegmenter(
 		audioClockRate:     audioClockRate,
 		onSegmentFinalized: onSegmentFinalized,
 		onPartFinalized:    onPartFinalized,
+		onGapFinalized:     onGapFinalized,
+		encryptor:          encryptor,
 		muxerStartTime:     muxerStartTime,
-		nextSegmentID:      7, // Required by iOS.
+		nextSegmentID:      firstSegmentID,
 		sampleDurations:    make(map[time.Duration]struct{}),
+
+		alignedPartDuration: partDuration,
 	}
 }
 
@@ -119,12 +172,40 @@ func (m *segmenter) adjustPartDuration(du time.Duration) {
 	if _, ok := m.sampleDurations[du]; !ok {
 		m.sampleDurations[du] = struct{}{}
 		m.adjustedPartDuration = findCompatiblePartDuration(
-			m.partDuration,
+			m.alignedPartDuration,
 			m.sampleDurations,
 		)
 	}
 }
 
+// alignToKeyframes measures the keyframe interval when the next sample
+// is a IDR and aligns the part duration to it. The interval is measured
+// before the segment is finalized, the duration is fixed after that.
+func (m *segmenter) alignToKeyframes(sample *VideoSample, next *VideoSample) {
+	if !m.prevIDRPresent && sample.IdrPresent {
+		m.prevIDRDTS, m.prevIDRPresent = sample.DTS, true
+	}
+	if !next.IdrPresent {
+		return
+	}
+	prevDTS, prevPresent := m.prevIDRDTS, m.prevIDRPresent
+	m.prevIDRDTS, m.prevIDRPresent = next.DTS, true
+	if m.firstSegmentFinalized || !prevPresent {
+		return
+	}
+
+	interval := time.Duration(next.DTS - prevDTS)
+	aligned := alignPartDuration(m.partDuration, interval, sample.duration())
+	if aligned == m.alignedPartDuration {
+		return
+	}
+	m.alignedPartDuration = aligned
+	m.adjustedPartDuration = findCompatiblePartDuration(
+		m.alignedPartDuration,
+		m.sampleDurations,
+	)
+}
+
 func (m *segmenter) writeH264(now time.Time, pts time.Duration, nalus [][]byte) error {
 	idrPresent := false
 	nonIDRPresent := false
@@ -144,6 +225,13 @@ func (m *segmenter) writeH264(now time.Time, pts time.Duration, nalus [][]byte)
 		return nil
 	}
 
+	if m.waitForIDR {
+		if !idrPresent {
+			return nil
+		}
+		m.waitForIDR = false
+	}
+
 	avcc := h264.AVCCMarshal(nalus)
 
 	var dts time.Duration
@@ -158,25 +246,27 @@ func (m *segmenter) writeH264(now time.Time, pts time.Duration, nalus [][]byte)
 		m.videoSPS = m.videoSps()
 
 		var err error
-		dts, err = m.videoDTSExtractor.Extract(nalus, dts)
+		dts, err = m.videoDTSExtractor.Extract(nalus, pts)
 		if err != nil {
 			return err
 		}
 
 		m.startDTS = dts
-		dts = 0
-		pts -= m.startDTS
+		m.startPTS = pts
 	} else {
 		var err error
 		dts, err = m.videoDTSExtractor.Extract(nalus, pts)
 		if err != nil {
 			return err
 		}
-
-		pts -= m.startDTS
-		dts -= m.startDTS
 	}
 
+	// The timestamps are shifted separately so that the first IDR is
+	// presented at zero, frames that are presented before they are
+	// decoded, like B-frames, get negative composition offsets.
+	pts -= m.startPTS
+	dts -= m.startDTS
+
 	return m.writeH264Entry(now, &VideoSample{
 		PTS:        m.muxerStartTime + int64(pts),
 		DTS:        m.muxerStartTime + int64(dts),
@@ -207,10 +297,12 @@ func (m *segmenter) writeH264Entry(now time.Time, sample *VideoSample) error { /
 			m.audioClockRate,
 			m.genPartID,
 			m.onPartFinalized,
+			m.encryptor,
 		)
 	}
 
 	m.adjustPartDuration(sample.duration())
+	m.alignToKeyframes(sample, next)
 
 	err := m.currentSegment.writeH264(sample, m.adjustedPartDuration)
 	if err != nil {
@@ -245,6 +337,7 @@ func (m *segmenter) writeH264Entry(now time.Time, sample *VideoSample) error { /
 			m.audioClockRate,
 			m.genPartID,
 			m.onPartFinalized,
+			m.encryptor,
 		)
 
 		// if SPS changed, reset adjusted part duration
@@ -272,7 +365,7 @@ func (m *segmenter) writeAACEntry(now time.Time, sample *AudioSample) error { //
 			return nil
 		}
 
-		sample.PTS -= int64(m.startDTS)
+		sample.PTS -= int64(m.startPTS)
 	}
 
 	sample.PTS += m.muxerStartTime
@@ -292,7 +385,7 @@ func (m *segmenter) writeAACEntry(now time.Time, sample *AudioSample) error { //
 			m.currentSegment = newSegment(
 				m.genSegmentID(),
 				now,
-				time.Duration(sample.PTS),
+				time.Duration(sample.PTS-m.muxerStartTime),
 				m.muxerStartTime,
 				m.segmentMaxSize,
 				m.videoTrackExist,
@@ -300,6 +393,7 @@ func (m *segmenter) writeAACEntry(now time.Time, sample *AudioSample) error { //
 				m.audioClockRate,
 				m.genPartID,
 				m.onPartFinalized,
+				m.encryptor,
 			)
 		}
 	} else {
@@ -329,7 +423,7 @@ func (m *segmenter) writeAACEntry(now time.Time, sample *AudioSample) error { //
 		m.currentSegment = newSegment(
 			m.genSegmentID(),
 			now,
-			time.Duration(sample.NextPTS),
+			time.Duration(sample.NextPTS-m.muxerStartTime),
 			m.muxerStartTime,
 			m.segmentMaxSize,
 			m.videoTrackExist,
@@ -337,8 +431,63 @@ func (m *segmenter) writeAACEntry(now time.Time, sample *AudioSample) error { //
 			m.audioClockRate,
 			m.genPartID,
 			m.onPartFinalized,
+			m.encryptor,
 		)
 	}
 
 	return nil
 }
+
+// writeGap finalizes the current segment and appends gaps covering the
+// duration. Used when the source stops delivering to keep the timeline
+// of the players aligned. Nothing is done before the first segment.
+func (m *segmenter) writeGap(duration time.Duration) error {
+	if m.nextSegmentID == firstSegmentID {
+		return nil
+	}
+
+	// The ID of a empty segment is reused by the first gap since
+	// every ID must correspond to a entry in the playlist.
+	var reusedID *uint64
+	if m.currentSegment != nil {
+		if m.currentSegment.isEmpty() {
+			id := m.currentSegment.ID
+			reusedID = &id
+		} else {
+			// The queued sample is dropped, it marks the end of the segment.
+			err := m.currentSegment.finalize(m.nextVideoSample)
+			if err != nil {
+				return err
+			}
+			m.onSegmentFinalized(m.currentSegment)
+			m.firstSegmentFinalized = true
+		}
+	}
+	m.currentSegment = nil
+	m.nextVideoSample = nil
+	m.nextAudioSample = nil
+	m.waitForIDR = m.videoTrackExist
+
+	for duration > 0 {
+		gapDuration := m.segmentDuration
+		if duration < gapDuration {
+			gapDuration = duration
+		}
+		duration -= gapDuration
+
+		var id uint64
+		if reusedID != nil {
+			id = *reusedID
+			reusedID = nil
+		} else {
+			id = m.genSegmentID()
+		}
+		m.onGapFinalized(id, &Gap{renderedDuration: gapDuration})
+	}
+
+	// The ID must be used even if the duration is zero.
+	if reusedID != nil {
+		m.onGapFinalized(*reusedID, &Gap{})
+	}
+	return nil
+}
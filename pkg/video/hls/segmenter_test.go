@@ -0,0 +1,186 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlignPartDuration(t *testing.T) {
+	const frame = 40 * time.Millisecond
+	cases := map[string]struct {
+		target   time.Duration
+		interval time.Duration
+		expected time.Duration
+	}{
+		"exact":       {200 * time.Millisecond, time.Second, 200 * time.Millisecond},
+		"longer":      {300 * time.Millisecond, time.Second, 200 * time.Millisecond},
+		"interval":    {300 * time.Millisecond, 2 * time.Second, 400 * time.Millisecond},
+		"shortGOP":    {300 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond},
+		"allKeyframe": {300 * time.Millisecond, frame, 300 * time.Millisecond},
+		"noInterval":  {300 * time.Millisecond, 0, 300 * time.Millisecond},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual := alignPartDuration(tc.target, tc.interval, frame)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestSegmenterKeyframeAlignment(t *testing.T) {
+	const fps = 30
+	const gopSize = 30
+
+	var parts []*MuxerPart
+	m := newSegmenter(
+		0,
+		time.Second,
+		300*time.Millisecond,
+		50000000,
+		true,
+		func() []byte { return conformanceSPS },
+		false,
+		nil,
+		func(*Segment) {},
+		func(part *MuxerPart) { parts = append(parts, part) },
+		func(uint64, *Gap) {},
+		nil,
+	)
+
+	for i := 0; i < 4*fps; i++ {
+		pts := time.Duration(i) * time.Second / fps
+		nalus := [][]byte{{0x41, 0x9a, 0x24, 0x00}}
+		if i%gopSize == 0 {
+			nalus = [][]byte{conformanceSPS, conformancePPS, {0x65, 0x88, 0x84, 0x00}}
+		}
+		require.NoError(t, m.writeH264(time.Now(), pts, nalus))
+	}
+
+	// The 300ms target is aligned to 10 frames, the parts
+	// after the first segment start at every keyframe.
+	require.Greater(t, m.adjustedPartDuration, 330*time.Millisecond)
+	require.Less(t, m.adjustedPartDuration, 335*time.Millisecond)
+
+	var afterFirst []*MuxerPart
+	for _, part := range parts {
+		if part.VideoSamples[0].DTS >= int64(time.Second) {
+			afterFirst = append(afterFirst, part)
+		}
+	}
+	require.NotEmpty(t, afterFirst)
+	for i, part := range afterFirst {
+		require.Len(t, part.VideoSamples, 10)
+		require.Equal(t, i%3 == 0, part.isIndependent)
+	}
+}
+
+// IDR, P, B, b from a camera with max_num_reorder_frames=2.
+var (
+	bFramesSPS = []byte{
+		0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78,
+		0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03,
+		0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0x28, 0x3c,
+		0x60, 0xc6, 0x58,
+	}
+	bFramesPPS    = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	bFramesFrames = [][]byte{
+		{0x65, 0x88, 0x82, 0x00, 0x05, 0xbf, 0xfe, 0xf7},
+		{0x41, 0x9a, 0x24, 0x6c, 0x41, 0x4f, 0xfe, 0xd6},
+		{0x41, 0x9e, 0x42, 0x78, 0x82, 0x1f, 0x00, 0x00},
+		{0x01, 0x9e, 0x61, 0x74, 0x43, 0xff, 0x00, 0x00},
+	}
+)
+
+func TestSegmenterBFrames(t *testing.T) {
+	var samples []*VideoSample
+	m := newSegmenter(
+		0,
+		time.Second,
+		300*time.Millisecond,
+		50000000,
+		true,
+		func() []byte { return bFramesSPS },
+		false,
+		nil,
+		func(*Segment) {},
+		func(part *MuxerPart) { samples = append(samples, part.VideoSamples...) },
+		func(uint64, *Gap) {},
+		nil,
+	)
+
+	// The camera clock doesn't start at zero.
+	const start = 10 * time.Second
+	ptss := []time.Duration{
+		start,
+		start + 800*time.Millisecond,
+		start + 400*time.Millisecond,
+		start + 200*time.Millisecond,
+	}
+	for i, frame := range bFramesFrames {
+		nalus := [][]byte{frame}
+		if i == 0 {
+			nalus = [][]byte{bFramesSPS, bFramesPPS, frame}
+		}
+		require.NoError(t, m.writeH264(time.Now(), ptss[i], nalus))
+	}
+
+	samples = append(samples, m.currentSegment.currentPart.VideoSamples...)
+	samples = append(samples, m.nextVideoSample)
+	require.Len(t, samples, 4)
+
+	type timestamps struct{ pts, dts time.Duration }
+	var actual []timestamps
+	for _, s := range samples {
+		actual = append(actual, timestamps{time.Duration(s.PTS), time.Duration(s.DTS)})
+	}
+	// The first IDR is presented at zero and the
+	// B-frames are presented before they are decoded.
+	expected := []timestamps{
+		{0, 0},
+		{800 * time.Millisecond, 200 * time.Millisecond},
+		{400 * time.Millisecond, 400 * time.Millisecond},
+		{200 * time.Millisecond, 600 * time.Millisecond},
+	}
+	require.Equal(t, expected, actual)
+
+	_, trun := generateVideoTraf(0, 1, samples[:3])
+	var offsets []int32
+	for _, e := range trun.Entries {
+		offsets = append(offsets, e.SampleCompositionTimeOffsetV1)
+	}
+	require.Equal(t, []int32{0, 54000, 0}, offsets)
+
+	samples[3].NextDTS = samples[3].DTS
+	_, trun = generateVideoTraf(0, 1, samples[3:])
+	require.Equal(t, int32(-36000), trun.Entries[0].SampleCompositionTimeOffsetV1)
+}
+
+func TestSegmenterAudioOnly(t *testing.T) {
+	var starts []time.Duration
+	m := newSegmenter(
+		int64(5*time.Second),
+		time.Second,
+		300*time.Millisecond,
+		50000000,
+		false,
+		nil,
+		true,
+		func() int { return 44100 },
+		func(seg *Segment) { starts = append(starts, seg.startDTS) },
+		func(*MuxerPart) {},
+		func(uint64, *Gap) {},
+		nil,
+	)
+
+	for i := 0; i < 35; i++ {
+		pts := time.Duration(i) * 100 * time.Millisecond
+		require.NoError(t, m.writeAAC(time.Now(), pts, []byte{0x01, 0x02}))
+	}
+
+	// The start times are relative to the muxer start time.
+	expected := []time.Duration{0, time.Second, 2 * time.Second}
+	require.Equal(t, expected, starts)
+	require.Equal(t, 3*time.Second, m.currentSegment.startDTS)
+}
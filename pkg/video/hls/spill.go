@@ -0,0 +1,167 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Spiller moves finalized segments from memory to disk once the combined
+// size of the in-memory segments exceeds the memory budget. The memory
+// of a segment is counted until its last reader is closed.
+// A single spiller is shared by all muxers.
+type Spiller struct {
+	dir    string
+	budget int64
+
+	mu     sync.Mutex
+	used   int64
+	nextID uint64
+}
+
+// NewSpiller allocates a Spiller. Budget is in bytes, 0 disables spilling.
+func NewSpiller(dir string, budget int64) *Spiller {
+	return &Spiller{
+		dir:    dir,
+		budget: budget,
+	}
+}
+
+func (s *Spiller) enabled() bool {
+	return s != nil && s.budget > 0
+}
+
+// add accounts for the segment, if the segment doesn't
+// fit in the memory budget it will be written to disk.
+func (s *Spiller) add(seg *Segment) error {
+	if !s.enabled() {
+		return nil
+	}
+
+	size := int64(seg.renderedSize())
+
+	s.mu.Lock()
+	if s.used+size <= s.budget {
+		s.used += size
+		s.mu.Unlock()
+		for _, part := range seg.Parts {
+			partSize := int64(part.renderedSize())
+			if buf := part.buffer(); buf != nil {
+				buf.setOnFree(func() { s.release(partSize) })
+			}
+		}
+		return nil
+	}
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	return s.spill(seg, id)
+}
+
+func (s *Spiller) spill(seg *Segment, id uint64) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("create spill directory: %w", err)
+	}
+
+	path := filepath.Join(s.dir, seg.name+"_"+strconv.FormatUint(id, 10)+".mp4")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("create spill file: %w", err)
+	}
+	defer file.Close()
+
+	// The segment holds a reference to each part while it's written.
+	reader := seg.reader()
+	_, err = io.Copy(file, reader)
+	if closer, ok := reader.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("write spill file: %w", err)
+	}
+
+	// Free memory after the whole segment has been written.
+	var offset int64
+	for _, part := range seg.Parts {
+		size := part.renderedSize()
+		part.moveToDisk(&spilledContent{
+			path:   path,
+			offset: offset,
+			size:   size,
+		})
+		offset += int64(size)
+	}
+	seg.spillPath = path
+
+	return nil
+}
+
+// remove releases the parts of the segment or deletes its file. Readers
+// that already opened the parts or the file can finish reading them.
+func (s *Spiller) remove(seg *Segment) error {
+	if seg.spillPath != "" {
+		return os.Remove(seg.spillPath)
+	}
+	for _, part := range seg.Parts {
+		part.release()
+	}
+	return nil
+}
+
+func (s *Spiller) release(size int64) {
+	s.mu.Lock()
+	s.used -= size
+	s.mu.Unlock()
+}
+
+// spilledContent is the location of a rendered part on disk.
+type spilledContent struct {
+	path   string
+	offset int64
+	size   int
+}
+
+func (c *spilledContent) reader() *spilledReader {
+	return &spilledReader{content: *c}
+}
+
+// ErrSpilledRemoved the spilled file was removed before it was opened.
+var ErrSpilledRemoved = errors.New("spilled segment was removed")
+
+// spilledReader opens the file on the first read.
+type spilledReader struct {
+	content spilledContent
+
+	file   *os.File
+	reader io.Reader
+}
+
+func (r *spilledReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		file, err := os.Open(r.content.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return 0, ErrSpilledRemoved
+			}
+			return 0, err
+		}
+		r.file = file
+		r.reader = io.NewSectionReader(
+			file, r.content.offset, int64(r.content.size))
+	}
+	return r.reader.Read(p)
+}
+
+// Close closes the underlying file if it was opened.
+func (r *spilledReader) Close() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package hls
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSegment(name string, parts ...[]byte) *Segment {
+	seg := &Segment{name: name}
+	for _, content := range parts {
+		part := &MuxerPart{}
+		part.setContent(newSharedBuffer(content))
+		seg.Parts = append(seg.Parts, part)
+	}
+	return seg
+}
+
+func TestSpiller(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		var spiller *Spiller
+		seg := newTestSegment("seg1", []byte{1, 2})
+		require.NoError(t, spiller.add(seg))
+		require.Empty(t, seg.spillPath)
+		require.NoError(t, spiller.remove(seg))
+	})
+	t.Run("ok", func(t *testing.T) {
+		spiller := NewSpiller(t.TempDir(), 4)
+
+		seg1 := newTestSegment("seg1", []byte{1, 2}, []byte{3})
+		require.NoError(t, spiller.add(seg1))
+		require.Empty(t, seg1.spillPath)
+		require.Equal(t, int64(3), spiller.used)
+
+		seg2 := newTestSegment("seg2", []byte{4, 5}, []byte{6, 7})
+		require.NoError(t, spiller.add(seg2))
+		require.NotEmpty(t, seg2.spillPath)
+		require.Nil(t, seg2.Parts[0].content)
+		require.Equal(t, int64(3), spiller.used)
+
+		content, err := io.ReadAll(seg2.reader())
+		require.NoError(t, err)
+		require.Equal(t, []byte{4, 5, 6, 7}, content)
+
+		content, err = io.ReadAll(seg2.Parts[1].Reader())
+		require.NoError(t, err)
+		require.Equal(t, []byte{6, 7}, content)
+		require.Equal(t, 4, seg2.renderedSize())
+
+		// Reader opened before the file is removed.
+		reader := seg2.reader()
+		buf := make([]byte, 1)
+		_, err = reader.Read(buf)
+		require.NoError(t, err)
+
+		require.NoError(t, spiller.remove(seg2))
+		_, err = os.Stat(seg2.spillPath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		content, err = io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, []byte{5, 6, 7}, content)
+		require.NoError(t, reader.(io.Closer).Close())
+
+		_, err = seg2.reader().Read(buf)
+		require.ErrorIs(t, err, ErrSpilledRemoved)
+
+		require.NoError(t, spiller.remove(seg1))
+		require.Equal(t, int64(0), spiller.used)
+	})
+	t.Run("openReader", func(t *testing.T) {
+		spiller := NewSpiller(t.TempDir(), 4)
+
+		seg := newTestSegment("seg1", []byte{1, 2}, []byte{3})
+		require.NoError(t, spiller.add(seg))
+		require.Equal(t, int64(3), spiller.used)
+
+		reader := seg.Parts[0].Reader()
+		require.NoError(t, spiller.remove(seg))
+
+		// The memory is accounted until the reader is closed.
+		require.Equal(t, int64(2), spiller.used)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2}, content)
+		require.Equal(t, int64(0), spiller.used)
+
+		_, err = seg.Parts[0].Reader().Read(make([]byte, 1))
+		require.ErrorIs(t, err, ErrBufferReleased)
+	})
+}
@@ -11,7 +11,9 @@ import (
 	"nvr/pkg/video/gortsplib/pkg/ringbuffer"
 	"nvr/pkg/video/gortsplib/pkg/rtpmpeg4audio"
 	"nvr/pkg/video/hls"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,12 +26,20 @@ type HLSMuxer struct {
 	path            *path
 	pathConf        PathConf
 	muxerClose      muxerCloseFunc
+	spiller         *hls.Spiller
+	encryption      string
 
 	ctx        context.Context
 	ctxCancel  func()
 	ringBuffer *ringbuffer.RingBuffer
 	muxer      *hls.Muxer
 
+	// Muxers for the additional audio renditions by sub directory.
+	audioMuxers map[string]*hls.Muxer
+
+	// UnixNano time of the last received data.
+	lastData int64
+
 	// in
 	chRequest chan *hlsMuxerRequest
 }
@@ -40,6 +50,8 @@ func newHLSMuxer(
 	wg *sync.WaitGroup,
 	path *path,
 	muxerClose muxerCloseFunc,
+	spiller *hls.Spiller,
+	encryption string,
 ) *HLSMuxer {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
@@ -49,6 +61,8 @@ func newHLSMuxer(
 		path:            path,
 		pathConf:        *path.conf,
 		muxerClose:      muxerClose,
+		spiller:         spiller,
+		encryption:      encryption,
 		ctx:             ctx,
 		ctxCancel:       ctxCancel,
 		chRequest:       make(chan *hlsMuxerRequest),
@@ -59,6 +73,14 @@ func (m *HLSMuxer) close() {
 	m.ctxCancel()
 }
 
+// end ends the live stream of all renditions.
+func (m *HLSMuxer) end() {
+	m.muxer.End()
+	for _, muxer := range m.audioMuxers {
+		muxer.End()
+	}
+}
+
 func (m *HLSMuxer) logf(format string, a ...interface{}) {
 	m.path.logf(log.LevelError, "HLS: "+format, a...)
 }
@@ -72,13 +94,14 @@ func (m *HLSMuxer) start(tracks gortsplib.Tracks) error {
 }
 
 func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
-	videoTrack, videoTrackID, audioTrack,
-		audioTrackID, aacDecoder, err := parseTracks(tracks)
+	parsed, err := parseTracks(tracks)
 	if err != nil {
 		return err
 	}
 
-	m.muxer = m.createMuxer(videoTrack, audioTrack)
+	if err := m.createMuxers(parsed); err != nil {
+		return err
+	}
 
 	m.ringBuffer, err = ringbuffer.New(uint64(m.readBufferCount))
 	if err != nil {
@@ -87,15 +110,12 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 
 	innerErr := make(chan error)
 	go func() {
-		innerErr <- m.runInner(
-			videoTrack,
-			videoTrackID,
-			audioTrack,
-			audioTrackID,
-			aacDecoder,
-		)
+		innerErr <- m.runInner(parsed)
 	}()
 
+	m.wg.Add(1)
+	go m.runGapWriter()
+
 	m.wg.Add(1)
 	go func() {
 		defer m.wg.Done()
@@ -118,7 +138,11 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 				return
 
 			case req := <-m.chRequest:
-				req.res <- m.handleRequest(req)
+				// Blocking requests are held by the playlist,
+				// they must not block the other requests.
+				go func() {
+					req.res <- m.handleRequest(req)
+				}()
 
 			case err := <-innerErr:
 				cleanup()
@@ -133,59 +157,148 @@ func (m *HLSMuxer) run(tracks gortsplib.Tracks) error {
 	return nil
 }
 
-func parseTracks(tracks gortsplib.Tracks) (
-	*gortsplib.TrackH264, int,
-	*gortsplib.TrackMPEG4Audio, int,
-	*rtpmpeg4audio.Decoder, error,
-) {
-	var videoTrack *gortsplib.TrackH264
-	videoTrackID := -1
-	var audioTrack *gortsplib.TrackMPEG4Audio
-	audioTrackID := -1
-	var aacDecoder *rtpmpeg4audio.Decoder
+type hlsAudioTrack struct {
+	track      *gortsplib.TrackMPEG4Audio
+	trackID    int
+	aacDecoder *rtpmpeg4audio.Decoder
+
+	// Audio only muxer for additional audio tracks.
+	// Nil for the main audio track.
+	muxer *hls.Muxer
+}
+
+type hlsTracks struct {
+	video   *gortsplib.TrackH264
+	videoID int
+
+	// The first audio track is muxed together with the video,
+	// additional tracks are muxed into separate renditions.
+	audio []*hlsAudioTrack
+}
+
+func parseTracks(tracks gortsplib.Tracks) (*hlsTracks, error) {
+	parsed := &hlsTracks{videoID: -1}
 
 	for i, track := range tracks {
 		switch tt := track.(type) {
 		case *gortsplib.TrackH264:
-			if videoTrack != nil {
-				return nil, 0, nil, 0, nil,
-					fmt.Errorf("can't encode track %d with HLS: %w", i+1, ErrTooManyTracks)
+			if parsed.video != nil {
+				return nil, fmt.Errorf("can't encode track %d with HLS: %w", i+1, ErrTooManyTracks)
 			}
 
-			videoTrack = tt
-			videoTrackID = i
+			parsed.video = tt
+			parsed.videoID = i
 
 		case *gortsplib.TrackMPEG4Audio:
-			if audioTrack != nil {
-				return nil, 0, nil, 0, nil,
-					fmt.Errorf("can't encode track %d with HLS: %w", i+1, ErrTooManyTracks)
+			if len(parsed.audio) >= maxAudioTracks {
+				return nil, fmt.Errorf("can't encode track %d with HLS: %w", i+1, ErrTooManyTracks)
 			}
 
-			audioTrack = tt
-			audioTrackID = i
-			aacDecoder = &rtpmpeg4audio.Decoder{
+			aacDecoder := &rtpmpeg4audio.Decoder{
 				SampleRate:       tt.Config.SampleRate,
 				SizeLength:       tt.SizeLength,
 				IndexLength:      tt.IndexLength,
 				IndexDeltaLength: tt.IndexDeltaLength,
 			}
 			aacDecoder.Init()
+
+			parsed.audio = append(parsed.audio, &hlsAudioTrack{
+				track:      tt,
+				trackID:    i,
+				aacDecoder: aacDecoder,
+			})
+		}
+	}
+
+	if parsed.video == nil && len(parsed.audio) == 0 {
+		return nil, ErrNoTracks
+	}
+
+	return parsed, nil
+}
+
+const maxAudioTracks = 8
+
+// audioRenditionDir returns the sub directory
+// of the additional audio rendition by index.
+func audioRenditionDir(index int) string {
+	return "audio" + strconv.Itoa(index)
+}
+
+// audioLanguage returns the language of the audio track. The language from
+// the SDP takes precedence over the language from the path config.
+func (m *HLSMuxer) audioLanguage(index int, track *gortsplib.TrackMPEG4Audio) string {
+	if track.Language != "" {
+		return track.Language
+	}
+	if index < len(m.pathConf.AudioLanguages) {
+		return m.pathConf.AudioLanguages[index]
+	}
+	return ""
+}
+
+func (m *HLSMuxer) audioRenditions(tracks *hlsTracks) []hls.AudioRendition {
+	if len(tracks.audio) < 2 {
+		return nil
+	}
+
+	renditions := make([]hls.AudioRendition, len(tracks.audio))
+	for i, audio := range tracks.audio {
+		lang := m.audioLanguage(i, audio.track)
+		name := lang
+		if name == "" {
+			name = "Audio " + strconv.Itoa(i)
+		}
+
+		r := hls.AudioRendition{
+			Name:     name,
+			Language: lang,
+		}
+		if i == 0 {
+			r.Default = true
+		} else {
+			r.URI = audioRenditionDir(i) + "/stream.m3u8"
 		}
+		renditions[i] = r
 	}
+	return renditions
+}
 
-	if videoTrack == nil && audioTrack == nil {
-		return nil, 0, nil, 0, nil, ErrNoTracks
+func (m *HLSMuxer) createMuxers(tracks *hlsTracks) error {
+	var mainAudio *gortsplib.TrackMPEG4Audio
+	if len(tracks.audio) != 0 {
+		mainAudio = tracks.audio[0].track
 	}
 
-	return videoTrack, videoTrackID, audioTrack, audioTrackID, aacDecoder, nil
+	var err error
+	m.muxer, err = m.createMuxer(tracks.video, mainAudio, m.audioRenditions(tracks), "")
+	if err != nil {
+		return err
+	}
+
+	m.audioMuxers = make(map[string]*hls.Muxer)
+	for i, audio := range tracks.audio {
+		if i == 0 {
+			continue
+		}
+		dir := audioRenditionDir(i)
+		audio.muxer, err = m.createMuxer(nil, audio.track, nil, dir+": ")
+		if err != nil {
+			return err
+		}
+		m.audioMuxers[dir] = audio.muxer
+	}
+	return nil
 }
 
 func (m *HLSMuxer) createMuxer(
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackMPEG4Audio,
-) *hls.Muxer {
+	renditions []hls.AudioRendition,
+	logPrefix string,
+) (*hls.Muxer, error) {
 	muxerLogFunc := func(level log.Level, format string, a ...interface{}) {
-		m.path.logf(level, "HLS: "+format, a...)
+		m.path.logf(level, "HLS: "+logPrefix+format, a...)
 	}
 	videoTrackExist := videoTrack != nil
 	audioTrackExist := audioTrack != nil
@@ -218,6 +331,16 @@ func (m *HLSMuxer) createMuxer(
 		return &info, nil
 	}
 
+	// Every muxer has its own key.
+	var encryptor *hls.Encryptor
+	if m.encryption != "" {
+		var err error
+		encryptor, err = hls.NewEncryptor(m.encryption)
+		if err != nil {
+			return nil, fmt.Errorf("new encryptor: %w", err)
+		}
+	}
+
 	return hls.NewMuxer(
 		m.ctx,
 		m.path.hlsSegmentCount(),
@@ -230,7 +353,10 @@ func (m *HLSMuxer) createMuxer(
 		audioTrackExist,
 		audioTrack.ClockRate,
 		streamInfo,
-	)
+		m.spiller,
+		renditions,
+		encryptor,
+	), nil
 }
 
 // Errors.
@@ -239,13 +365,7 @@ var (
 	ErrNoTracks      = errors.New("the stream doesn't contain an H264 track or an AAC track")
 )
 
-func (m *HLSMuxer) runInner( //nolint:gocognit
-	videoTrack *gortsplib.TrackH264,
-	videoTrackID int,
-	audioTrack *gortsplib.TrackMPEG4Audio,
-	audioTrackID int,
-	aacDecoder *rtpmpeg4audio.Decoder,
-) error {
+func (m *HLSMuxer) runInner(tracks *hlsTracks) error {
 	var videoInitialPTS *time.Duration
 	for {
 		item, ok := m.ringBuffer.Pull()
@@ -253,8 +373,9 @@ func (m *HLSMuxer) runInner( //nolint:gocognit
 			return context.Canceled
 		}
 		data := item.(*data) //nolint:forcetypeassert
+		atomic.StoreInt64(&m.lastData, time.Now().UnixNano())
 
-		if videoTrack != nil && data.trackID == videoTrackID {
+		if tracks.video != nil && data.trackID == tracks.videoID {
 			if data.h264NALUs == nil {
 				continue
 			}
@@ -272,34 +393,103 @@ func (m *HLSMuxer) runInner( //nolint:gocognit
 			if err != nil {
 				return fmt.Errorf("unable to write segment: %w", err)
 			}
-		} else if audioTrack != nil && data.trackID == audioTrackID {
-			aus, pts, err := aacDecoder.Decode(data.rtpPacket)
-			if err != nil {
-				if !errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
-					return fmt.Errorf("unable to decode audio track: %w", err)
-				}
+			continue
+		}
+
+		for _, audio := range tracks.audio {
+			if data.trackID != audio.trackID {
+				continue
+			}
+			if err := m.writeAudio(audio, data); err != nil {
+				return err
+			}
+			break
+		}
+	}
+}
+
+// runGapWriter inserts gaps into the muxers when the source stops
+// delivering data for longer than the segment duration.
+func (m *HLSMuxer) runGapWriter() {
+	defer m.wg.Done()
+
+	segmentDuration := m.path.hlsSegmentDuration()
+	ticker := time.NewTicker(segmentDuration / 2)
+	defer ticker.Stop()
+
+	atomic.StoreInt64(&m.lastData, time.Now().UnixNano())
+
+	// End of the previous gap.
+	var gapEnd time.Time
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			start := time.Unix(0, atomic.LoadInt64(&m.lastData))
+			if start.Before(gapEnd) {
+				start = gapEnd
+			}
+			duration := now.Sub(start)
+			if duration < segmentDuration {
 				continue
 			}
 
-			for i, au := range aus {
-				err = m.muxer.WriteAAC(
-					time.Now(),
-					pts+time.Duration(i)*mpeg4audio.SamplesPerAccessUnit*
-						time.Second/time.Duration(audioTrack.ClockRate()),
-					au)
-				if err != nil {
-					return fmt.Errorf("write aac: %w", err)
-				}
+			m.path.logf(log.LevelDebug, "HLS: no data for %v, inserting gap", duration)
+			if err := m.writeGap(duration); err != nil {
+				m.logf("write gap: %v", err)
 			}
+			gapEnd = now
 		}
 	}
 }
 
+func (m *HLSMuxer) writeGap(duration time.Duration) error {
+	if err := m.muxer.WriteGap(duration); err != nil {
+		return err
+	}
+	for _, muxer := range m.audioMuxers {
+		if err := muxer.WriteGap(duration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HLSMuxer) writeAudio(audio *hlsAudioTrack, data *data) error {
+	aus, pts, err := audio.aacDecoder.Decode(data.rtpPacket)
+	if err != nil {
+		if !errors.Is(err, rtpmpeg4audio.ErrMorePacketsNeeded) {
+			return fmt.Errorf("unable to decode audio track: %w", err)
+		}
+		return nil
+	}
+
+	muxer := m.muxer
+	if audio.muxer != nil {
+		muxer = audio.muxer
+	}
+
+	for i, au := range aus {
+		err = muxer.WriteAAC(
+			time.Now(),
+			pts+time.Duration(i)*mpeg4audio.SamplesPerAccessUnit*
+				time.Second/time.Duration(audio.track.ClockRate()),
+			au)
+		if err != nil {
+			return fmt.Errorf("write aac: %w", err)
+		}
+	}
+	return nil
+}
+
 type hlsMuxerRequest struct {
 	path string
-	file string
-	req  *http.Request
-	res  chan *hls.MuxerFileResponse
+	// Sub directory of a additional rendition, empty for the main rendition.
+	rendition string
+	file      string
+	req       *http.Request
+	res       chan *hls.MuxerFileResponse
 }
 
 func (m *HLSMuxer) handleRequest(req *hlsMuxerRequest) *hls.MuxerFileResponse {
@@ -323,7 +513,18 @@ func (m *HLSMuxer) handleRequest(req *hlsMuxerRequest) *hls.MuxerFileResponse {
 		return ""
 	}()
 
-	return m.muxer.File(req.file, msn, part, skip)
+	// Invalid client data doesn't fail the request.
+	cmcd, _ := hls.ParseCMCD(p.Get(hls.CMCDQueryKey))
+
+	if req.rendition == "" {
+		return m.muxer.File(req.file, msn, part, skip, cmcd)
+	}
+
+	muxer, exist := m.audioMuxers[req.rendition]
+	if !exist {
+		return &hls.MuxerFileResponse{Status: http.StatusNotFound}
+	}
+	return muxer.File(req.file, msn, part, skip, cmcd)
 }
 
 // onRequest is called by hlsserver.Server (forwarded from ServeHTTP).
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"nvr/pkg/log"
@@ -19,6 +18,8 @@ import (
 type hlsServer struct {
 	readBufferCount int
 	logger          *log.Logger
+	spiller         *hls.Spiller
+	encryption      string
 
 	ctx       context.Context
 	ctxCancel func()
@@ -31,16 +32,21 @@ type hlsServer struct {
 	chRequest            chan *hlsMuxerRequest
 	chMuxerbyPathName    chan muxerByPathNameRequest
 	chMuxerClose         chan *HLSMuxer
+	chEndStreams         chan chan struct{}
 }
 
 func newHLSServer(
 	wg *sync.WaitGroup,
 	readBufferCount int,
 	logger *log.Logger,
+	spiller *hls.Spiller,
+	encryption string,
 ) *hlsServer {
 	return &hlsServer{
 		readBufferCount:      readBufferCount,
 		logger:               logger,
+		spiller:              spiller,
+		encryption:           encryption,
 		wg:                   wg,
 		muxers:               make(map[string]*HLSMuxer),
 		chPathSourceReady:    make(chan pathSourceReadyRequest),
@@ -48,6 +54,7 @@ func newHLSServer(
 		chRequest:            make(chan *hlsMuxerRequest),
 		chMuxerbyPathName:    make(chan muxerByPathNameRequest),
 		chMuxerClose:         make(chan *HLSMuxer),
+		chEndStreams:         make(chan chan struct{}),
 	}
 }
 
@@ -80,7 +87,7 @@ func (s *hlsServer) start(ctx context.Context, address string) error {
 func (s *hlsServer) startServer(ln net.Listener) {
 	mux := http.NewServeMux()
 	mux.Handle("/hls/", s.HandleRequest())
-	server := http.Server{Handler: mux}
+	server := http.Server{Handler: mux, ConnContext: ConnContext}
 
 	go func() {
 		for {
@@ -128,6 +135,8 @@ func (s *hlsServer) run() {
 				s.wg,
 				req.path,
 				s.muxerClose,
+				s.spiller,
+				s.encryption,
 			)
 
 			if err := m.start(req.tracks); err != nil {
@@ -151,6 +160,14 @@ func (s *hlsServer) run() {
 				m.onRequest(req)
 				continue
 			}
+
+			// Additional renditions are in a sub directory of the path.
+			m, exist = s.muxers[gopath.Dir(req.path)]
+			if exist {
+				req.rendition = gopath.Base(req.path)
+				m.onRequest(req)
+				continue
+			}
 			req.res <- &hls.MuxerFileResponse{Status: http.StatusNotFound}
 
 		case req := <-s.chMuxerbyPathName:
@@ -166,6 +183,12 @@ func (s *hlsServer) run() {
 			if exist {
 				delete(s.muxers, c.path.name)
 			}
+
+		case done := <-s.chEndStreams:
+			for _, m := range s.muxers {
+				m.end()
+			}
+			close(done)
 		}
 	}
 }
@@ -201,7 +224,8 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 		dir, fname := func() (string, string) {
 			if strings.HasSuffix(pa, ".ts") ||
 				strings.HasSuffix(pa, ".m3u8") ||
-				strings.HasSuffix(pa, ".mp4") {
+				strings.HasSuffix(pa, ".mp4") ||
+				strings.HasSuffix(pa, ".key") {
 				return gopath.Dir(pa), gopath.Base(pa)
 			}
 			return pa, ""
@@ -215,7 +239,8 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 
 		dir = strings.TrimSuffix(dir, "/")
 
-		cres := make(chan *hls.MuxerFileResponse)
+		// Buffered, the response is never blocked by the client.
+		cres := make(chan *hls.MuxerFileResponse, 1)
 		hreq := &hlsMuxerRequest{
 			path: dir,
 			file: fname,
@@ -225,17 +250,32 @@ func (s *hlsServer) HandleRequest() http.HandlerFunc { //nolint:funlen
 
 		select {
 		case <-s.ctx.Done():
+			return
+		case <-r.Context().Done():
+			return
 		case s.chRequest <- hreq:
-			res := <-cres
+		}
 
-			for k, v := range res.Header {
-				w.Header().Set(k, v)
-			}
-			w.WriteHeader(res.Status)
+		var res *hls.MuxerFileResponse
+		select {
+		case res = <-cres:
+		case <-r.Context().Done():
+			// The client has disconnected while the request was blocked.
+			go func() {
+				if res := <-cres; res.Body != nil {
+					closeBody(res.Body)
+				}
+			}()
+			return
+		}
 
-			if res.Body != nil {
-				io.Copy(w, res.Body) //nolint:errcheck
-			}
+		for k, v := range res.Header {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(res.Status)
+
+		if res.Body != nil {
+			writeBody(r, w, res.Body, hlsWriteTimeout) //nolint:errcheck
 		}
 	}
 }
@@ -284,6 +324,20 @@ func (s *hlsServer) muxerClose(c *HLSMuxer) {
 	}
 }
 
+// endStreams ends the live streams of all muxers.
+func (s *hlsServer) endStreams() {
+	if s.ctx == nil {
+		// Not started.
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case <-s.ctx.Done():
+	case s.chEndStreams <- done:
+		<-done
+	}
+}
+
 type muxerByPathNameRequest struct {
 	pathName string
 	res      chan *HLSMuxer
@@ -0,0 +1,101 @@
+package video
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Slow clients are disconnected if a chunk of the response
+// can't be sent within hlsWriteTimeout.
+const (
+	hlsWriteTimeout   = 10 * time.Second
+	hlsWriteChunkSize = 64 * 1024
+)
+
+type connContextKey struct{}
+
+// ConnContext stores the connection in the request context, the HLS
+// responses use it to set write deadlines. Used as http.Server.ConnContext.
+//
+// The connection is only used for HTTP/1 requests. An HTTP/2 connection
+// is shared by all streams, the deadline of one response would apply to
+// the others and the writes are buffered by the stream anyway.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+func connFromContext(ctx context.Context) net.Conn {
+	c, _ := ctx.Value(connContextKey{}).(net.Conn)
+	return c
+}
+
+// deadlineWriter writes the response in chunks and extends the
+// write deadline of the connection before each chunk. A stalled
+// client fails the write instead of holding the response forever.
+type deadlineWriter struct {
+	w       io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		end := n + hlsWriteChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+			return n, err
+		}
+		written, err := w.w.Write(p[n:end])
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeBody copies the body to the response and closes it. The write
+// deadline is only used for HTTP/1 requests with the connection stored
+// in the context.
+func writeBody(r *http.Request, w io.Writer, body io.Reader, timeout time.Duration) error {
+	if closer, ok := body.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var conn net.Conn
+	if r.ProtoMajor == 1 {
+		conn = connFromContext(r.Context())
+	}
+	if conn == nil {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	dw := &deadlineWriter{w: w, conn: conn, timeout: timeout}
+	_, err := io.Copy(dw, body)
+	if err == nil {
+		// The buffered end of the response is flushed
+		// within the deadline.
+		if flusher, ok := w.(http.Flusher); ok {
+			conn.SetWriteDeadline(time.Now().Add(timeout)) //nolint:errcheck
+			flusher.Flush()
+		}
+	}
+
+	// The connection can be reused by requests without deadlines.
+	conn.SetWriteDeadline(time.Time{}) //nolint:errcheck
+	return err
+}
+
+// closeBody closes the body of a response that won't be sent.
+func closeBody(body io.Reader) {
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,140 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func newTestBody(content []byte) *testBody {
+	return &testBody{
+		Reader: bytes.NewReader(content),
+		closed: make(chan struct{}),
+	}
+}
+
+func (b *testBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func newConnRequest(conn net.Conn) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	return r.WithContext(ConnContext(r.Context(), conn))
+}
+
+func TestWriteBody(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		content := make([]byte, 3*hlsWriteChunkSize)
+		content[len(content)-1] = 1
+		read := make(chan []byte)
+		go func() {
+			buf, _ := io.ReadAll(client)
+			read <- buf
+		}()
+
+		body := newTestBody(content)
+		r := newConnRequest(server)
+		require.NoError(t, writeBody(r, server, body, time.Second))
+		<-body.closed
+		server.Close()
+		require.Equal(t, content, <-read)
+	})
+	t.Run("stalledClient", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		// The client never reads.
+		body := newTestBody([]byte{1, 2, 3})
+		r := newConnRequest(server)
+		err := writeBody(r, server, body, 10*time.Millisecond)
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		<-body.closed
+	})
+	t.Run("noConn", func(t *testing.T) {
+		var w bytes.Buffer
+		body := newTestBody([]byte{1, 2, 3})
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, writeBody(r, &w, body, time.Second))
+		require.Equal(t, []byte{1, 2, 3}, w.Bytes())
+		<-body.closed
+	})
+}
+
+func TestWriteBodyHTTP2(t *testing.T) {
+	content := make([]byte, 3*hlsWriteChunkSize)
+	content[len(content)-1] = 1
+
+	// The deadline would fail every HTTP/1 write, HTTP/2
+	// streams share the connection and must not use it.
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeBody(r, w, newTestBody(content), time.Nanosecond) //nolint:errcheck
+	}))
+	s.EnableHTTP2 = true
+	s.Config.ConnContext = ConnContext
+	s.StartTLS()
+	defer s.Close()
+
+	// Requests after the first one reuse the connection.
+	for i := 0; i < 3; i++ {
+		res, err := s.Client().Get(s.URL)
+		require.NoError(t, err)
+		require.Equal(t, 2, res.ProtoMajor)
+		buf, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, content, buf)
+	}
+}
+
+func TestHLSServerClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &hlsServer{
+		ctx:       ctx,
+		chRequest: make(chan *hlsMuxerRequest),
+	}
+
+	reqCtx, reqCancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/hls/x/stream.m3u8", nil).WithContext(reqCtx)
+	done := make(chan struct{})
+	go func() {
+		s.HandleRequest()(httptest.NewRecorder(), r)
+		close(done)
+	}()
+
+	// The request is blocked until the client disconnects.
+	req := <-s.chRequest
+	reqCancel()
+	<-done
+
+	// The late response doesn't block and the body is released.
+	body := newTestBody(nil)
+	req.res <- &hls.MuxerFileResponse{Status: http.StatusOK, Body: body}
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("body wasn't closed")
+	}
+}
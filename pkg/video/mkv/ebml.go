@@ -0,0 +1,155 @@
+// Package mkv writes H264 and AAC recordings in the Matroska container.
+package mkv
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Element IDs.
+const (
+	idEBML               = 0x1A45DFA3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42F7
+	idEBMLMaxIDLength    = 0x42F2
+	idEBMLMaxSizeLength  = 0x42F3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment      = 0x18538067
+	idSeekHead     = 0x114D9B74
+	idSeek         = 0x4DBB
+	idSeekID       = 0x53AB
+	idSeekPosition = 0x53AC
+	idVoid         = 0xEC
+
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idMuxingApp      = 0x4D80
+	idWritingApp     = 0x5741
+	idDateUTC        = 0x4461
+
+	idTracks            = 0x1654AE6B
+	idTrackEntry        = 0xAE
+	idTrackNumber       = 0xD7
+	idTrackUID          = 0x73C5
+	idTrackType         = 0x83
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63A2
+	idVideo             = 0xE0
+	idPixelWidth        = 0xB0
+	idPixelHeight       = 0xBA
+	idAudio             = 0xE1
+	idSamplingFrequency = 0xB5
+	idChannels          = 0x9F
+
+	idCluster     = 0x1F43B675
+	idTimestamp   = 0xE7
+	idSimpleBlock = 0xA3
+
+	idCues               = 0x1C53BB6B
+	idCuePoint           = 0xBB
+	idCueTime            = 0xB3
+	idCueTrackPositions  = 0xB7
+	idCueTrack           = 0xF7
+	idCueClusterPosition = 0xF1
+)
+
+// Track types.
+const (
+	trackTypeVideo = 1
+	trackTypeAudio = 2
+)
+
+// unknownSize is the 8 byte size of elements that are still being written.
+var unknownSize = []byte{0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+func appendID(buf []byte, id uint32) []byte {
+	switch {
+	case id > 0xffffff:
+		return append(buf, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xffff:
+		return append(buf, byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xff:
+		return append(buf, byte(id>>8), byte(id))
+	default:
+		return append(buf, byte(id))
+	}
+}
+
+// appendSize appends the size as a variable length integer
+// of the minimum length. All ones is reserved for unknown.
+func appendSize(buf []byte, size uint64) []byte {
+	length := 1
+	for size >= 1<<(7*length)-1 && length < 8 {
+		length++
+	}
+	return appendFixedSize(buf, size, length)
+}
+
+func appendFixedSize(buf []byte, size uint64, length int) []byte {
+	size |= 1 << (7 * length)
+	for i := length - 1; i >= 0; i-- {
+		buf = append(buf, byte(size>>(8*i)))
+	}
+	return buf
+}
+
+func element(id uint32, data []byte) []byte {
+	buf := make([]byte, 0, len(data)+12)
+	buf = appendID(buf, id)
+	buf = appendSize(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func master(id uint32, children ...[]byte) []byte {
+	var data []byte
+	for _, child := range children {
+		data = append(data, child...)
+	}
+	return element(id, data)
+}
+
+func uintElement(id uint32, v uint64) []byte {
+	length := 1
+	for length < 8 && v >= 1<<(8*length) {
+		length++
+	}
+	data := make([]byte, length)
+	for i := 0; i < length; i++ {
+		data[length-1-i] = byte(v >> (8 * i))
+	}
+	return element(id, data)
+}
+
+// fixedUintElement encodes the value in 8 bytes so
+// that the length doesn't change when it's updated.
+func fixedUintElement(id uint32, v uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return element(id, data)
+}
+
+func intElement(id uint32, v int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v))
+	return element(id, data)
+}
+
+func floatElement(id uint32, v float64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	return element(id, data)
+}
+
+func stringElement(id uint32, v string) []byte {
+	return element(id, []byte(v))
+}
+
+// voidElement returns a void element of exactly size bytes, size must be at least 9.
+func voidElement(size int) []byte {
+	buf := appendID(nil, idVoid)
+	buf = appendFixedSize(buf, uint64(size-9), 8)
+	return append(buf, make([]byte, size-9)...)
+}
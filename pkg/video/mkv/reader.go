@@ -0,0 +1,185 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Reader errors.
+var (
+	ErrInvalidFile = errors.New("invalid matroska file")
+	ErrNoClusters  = errors.New("no complete clusters")
+)
+
+// elementHeader reads the ID and size of the element at pos.
+// The size is -1 if it's unknown.
+func elementHeader(r io.ReaderAt, pos int64) (uint32, int64, int, error) {
+	buf := make([]byte, 12)
+	n, err := r.ReadAt(buf, pos)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, 0, err
+	}
+	buf = buf[:n]
+
+	idLen := vintLength(buf)
+	if idLen == 0 || idLen > 4 || len(buf) < idLen {
+		return 0, 0, 0, ErrInvalidFile
+	}
+	var id uint32
+	for _, b := range buf[:idLen] {
+		id = id<<8 | uint32(b)
+	}
+
+	size, sizeLen := readVint(buf[idLen:])
+	if sizeLen == 0 {
+		return 0, 0, 0, ErrInvalidFile
+	}
+	return id, size, idLen + sizeLen, nil
+}
+
+func vintLength(buf []byte) int {
+	if len(buf) == 0 {
+		return 0
+	}
+	for i := 0; i < 8; i++ {
+		if buf[0]&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// readVint returns the value and length of a variable length integer.
+// The value is -1 if all the value bits are set.
+func readVint(buf []byte) (int64, int) {
+	length := vintLength(buf)
+	if length == 0 || len(buf) < length {
+		return 0, 0
+	}
+	v := uint64(buf[0] & (0xff >> length))
+	allOnes := v == uint64(0xff>>length)
+	for _, b := range buf[1:length] {
+		v = v<<8 | uint64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	if allOnes {
+		return -1, length
+	}
+	return int64(v), length
+}
+
+// children calls fn with the ID and data of each element in buf.
+func children(buf []byte, fn func(id uint32, data []byte)) error {
+	for len(buf) > 0 {
+		idLen := vintLength(buf)
+		if idLen == 0 || idLen > 4 || len(buf) < idLen {
+			return ErrInvalidFile
+		}
+		var id uint32
+		for _, b := range buf[:idLen] {
+			id = id<<8 | uint32(b)
+		}
+		size, sizeLen := readVint(buf[idLen:])
+		start := idLen + sizeLen
+		if sizeLen == 0 || size < 0 || int64(len(buf)-start) < size {
+			return ErrInvalidFile
+		}
+		fn(id, buf[start:start+int(size)])
+		buf = buf[start+int(size):]
+	}
+	return nil
+}
+
+func parseUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// ReadTimes returns the start time and the time of the last block in a
+// file created by Writer. Incomplete clusters at the end of the file
+// are ignored, the file may have been cut short by a crash.
+func ReadTimes(r io.ReaderAt, size int64) (time.Time, time.Time, error) {
+	id, headerSize, headerLen, err := elementHeader(r, 0)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if id != idEBML || headerSize < 0 {
+		return time.Time{}, time.Time{}, ErrInvalidFile
+	}
+	pos := int64(headerLen) + headerSize
+
+	id, segmentSize, headerLen, err := elementHeader(r, pos)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if id != idSegment {
+		return time.Time{}, time.Time{}, ErrInvalidFile
+	}
+	pos += int64(headerLen)
+	end := size
+	if segmentSize >= 0 && pos+segmentSize < end {
+		end = pos + segmentSize
+	}
+
+	var start time.Time
+	var last int64
+	foundCluster := false
+	for pos < end {
+		id, elementSize, headerLen, err := elementHeader(r, pos)
+		if err != nil || elementSize < 0 {
+			break
+		}
+		dataPos := pos + int64(headerLen)
+		if dataPos+elementSize > end {
+			break
+		}
+		pos = dataPos + elementSize
+		if id != idInfo && id != idCluster {
+			continue
+		}
+
+		data := make([]byte, elementSize)
+		if _, err := r.ReadAt(data, dataPos); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("read element: %w", err)
+		}
+		if id == idInfo {
+			err = children(data, func(id uint32, data []byte) {
+				if id == idDateUTC && len(data) == 8 {
+					date := int64(binary.BigEndian.Uint64(data))
+					start = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(date))
+				}
+			})
+		} else {
+			var clusterTime int64
+			err = children(data, func(id uint32, data []byte) {
+				switch id {
+				case idTimestamp:
+					clusterTime = int64(parseUint(data))
+				case idSimpleBlock:
+					_, trackLen := readVint(data)
+					if trackLen == 0 || len(data) < trackLen+2 {
+						return
+					}
+					relative := int64(int16(binary.BigEndian.Uint16(data[trackLen:])))
+					if t := clusterTime + relative; t > last {
+						last = t
+					}
+					foundCluster = true
+				}
+			})
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if !foundCluster {
+		return time.Time{}, time.Time{}, ErrNoClusters
+	}
+	return start, start.Add(time.Duration(last * timestampScale)), nil
+}
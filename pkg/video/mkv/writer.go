@@ -0,0 +1,357 @@
+package mkv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nvr/pkg/video/hls"
+	"sort"
+	"time"
+)
+
+// Matroska layout.
+//   EBML header
+//   Segment, size is unknown until the writer is closed.
+//     Reserved space: SeekHead, Cues and Void.
+//     Info
+//     Tracks
+//     Cluster, one per video keyframe.
+//     ...
+//     Cues, only if they didn't fit in the reserved space.
+//
+// The Cues are rewritten in the reserved space after every cluster if
+// the output is seekable, a file that is cut short by a power failure
+// can be played and seeked up to the last written cluster. Timestamps
+// are in milliseconds relative to the start time.
+
+const (
+	timestampScale = int64(time.Millisecond)
+
+	// Space for about 2000 cue points, one per cluster.
+	reservedSize = 64 * 1024
+
+	// Block timestamps are signed 16 bit integers relative to the cluster.
+	maxClusterDuration = 30000
+
+	audioClusterDuration = 5000
+)
+
+// Writer errors.
+var (
+	ErrNoTracks   = errors.New("no tracks")
+	ErrInvalidSPS = errors.New("invalid sps")
+)
+
+// Writer writes HLS segments to a Matroska file.
+type Writer struct {
+	w  io.Writer
+	wa io.WriterAt // Nil if the output can't be updated in place.
+
+	startTime  int64 // UnixNano.
+	videoTrack uint64
+	audioTrack uint64
+
+	pos        int64 // Bytes written.
+	segmentPos int64 // Position of the segment data.
+	frontLen   int   // Length of the front SeekHead.
+	infoPos    int64 // Positions are relative to the segment data.
+	tracksPos  int64
+	frontFull  bool
+
+	cluster     []byte
+	clusterTime int64
+	clusterKey  bool
+	cues        []cuePoint
+}
+
+type cuePoint struct {
+	time  int64
+	track uint64
+	pos   int64
+}
+
+// NewWriter writes the header and returns a Writer. The reserved space
+// is updated using wa if it's not nil. Timestamps are relative to
+// startTime in UnixNano.
+func NewWriter(w io.Writer, wa io.WriterAt, info hls.StreamInfo, startTime int64) (*Writer, error) {
+	mw := &Writer{
+		w:         w,
+		wa:        wa,
+		startTime: startTime,
+	}
+
+	var tracks [][]byte
+	if info.VideoTrackExist {
+		if len(info.VideoSPS) < 4 {
+			return nil, ErrInvalidSPS
+		}
+		mw.videoTrack = uint64(len(tracks) + 1)
+		tracks = append(tracks, videoTrackEntry(mw.videoTrack, info))
+	}
+	if info.AudioTrackExist {
+		mw.audioTrack = uint64(len(tracks) + 1)
+		tracks = append(tracks, audioTrackEntry(mw.audioTrack, info))
+	}
+	if len(tracks) == 0 {
+		return nil, ErrNoTracks
+	}
+
+	header := master(idEBML,
+		uintElement(idEBMLVersion, 1),
+		uintElement(idEBMLReadVersion, 1),
+		uintElement(idEBMLMaxIDLength, 4),
+		uintElement(idEBMLMaxSizeLength, 8),
+		stringElement(idDocType, "matroska"),
+		uintElement(idDocTypeVersion, 4),
+		uintElement(idDocTypeReadVersion, 2),
+	)
+	header = appendID(header, idSegment)
+	header = append(header, unknownSize...)
+	mw.segmentPos = int64(len(header))
+
+	dateUTC := time.Unix(0, startTime).Sub(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))
+	segmentInfo := master(idInfo,
+		uintElement(idTimestampScale, uint64(timestampScale)),
+		stringElement(idMuxingApp, "OS-NVR"),
+		stringElement(idWritingApp, "OS-NVR"),
+		intElement(idDateUTC, int64(dateUTC)),
+	)
+	mw.infoPos = reservedSize
+	mw.tracksPos = reservedSize + int64(len(segmentInfo))
+
+	front, _ := mw.front(nil, false)
+	header = append(header, front...)
+	header = append(header, segmentInfo...)
+	header = append(header, master(idTracks, tracks...)...)
+
+	if err := mw.write(header); err != nil {
+		return nil, fmt.Errorf("write header: %w", err)
+	}
+	return mw, nil
+}
+
+func videoTrackEntry(number uint64, info hls.StreamInfo) []byte {
+	// AVCDecoderConfigurationRecord.
+	sps, pps := info.VideoSPS, info.VideoPPS
+	private := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1, byte(len(sps) >> 8), byte(len(sps))}
+	private = append(private, sps...)
+	private = append(private, 1, byte(len(pps)>>8), byte(len(pps)))
+	private = append(private, pps...)
+
+	return master(idTrackEntry,
+		uintElement(idTrackNumber, number),
+		uintElement(idTrackUID, number),
+		uintElement(idTrackType, trackTypeVideo),
+		stringElement(idCodecID, "V_MPEG4/ISO/AVC"),
+		element(idCodecPrivate, private),
+		master(idVideo,
+			uintElement(idPixelWidth, uint64(info.VideoSPSP.Width())),
+			uintElement(idPixelHeight, uint64(info.VideoSPSP.Height())),
+		),
+	)
+}
+
+func audioTrackEntry(number uint64, info hls.StreamInfo) []byte {
+	return master(idTrackEntry,
+		uintElement(idTrackNumber, number),
+		uintElement(idTrackUID, number),
+		uintElement(idTrackType, trackTypeAudio),
+		stringElement(idCodecID, "A_AAC"),
+		element(idCodecPrivate, info.AudioTrackConfig),
+		master(idAudio,
+			floatElement(idSamplingFrequency, float64(info.AudioClockRate)),
+			uintElement(idChannels, uint64(info.AudioChannelCount)),
+		),
+	)
+}
+
+// front returns the reserved space with a SeekHead, the cues if they
+// fit and a Void element. False is returned if the cues didn't fit.
+func (w *Writer) front(cues []byte, cuesInFront bool) ([]byte, bool) {
+	seek := func(id uint32, pos int64) []byte {
+		return master(idSeek,
+			element(idSeekID, appendID(nil, id)),
+			fixedUintElement(idSeekPosition, uint64(pos)),
+		)
+	}
+	seeks := [][]byte{
+		seek(idInfo, w.infoPos),
+		seek(idTracks, w.tracksPos),
+	}
+	if cues != nil {
+		// The length of the SeekHead with a cues entry is
+		// fixed, the cues are placed right after it.
+		cuesPos := w.pos - w.segmentPos
+		if cuesInFront {
+			cuesPos = int64(len(master(idSeekHead, append(seeks, seek(idCues, 0))...)))
+		}
+		seeks = append(seeks, seek(idCues, cuesPos))
+	}
+	buf := master(idSeekHead, seeks...)
+	if cuesInFront {
+		buf = append(buf, cues...)
+	}
+	if len(buf)+9 > reservedSize {
+		return nil, false
+	}
+	return append(buf, voidElement(reservedSize-len(buf))...), true
+}
+
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.pos += int64(n)
+	return err
+}
+
+type sample struct {
+	dts   int64
+	video *hls.VideoSample
+	audio *hls.AudioSample
+}
+
+// WriteSegment writes the samples of the segment in decoding order.
+func (w *Writer) WriteSegment(segment *hls.Segment) error {
+	var samples []sample
+	for _, part := range segment.Parts {
+		if w.videoTrack != 0 {
+			for _, s := range part.VideoSamples {
+				samples = append(samples, sample{dts: s.DTS, video: s})
+			}
+		}
+		if w.audioTrack != 0 {
+			for _, s := range part.AudioSamples {
+				samples = append(samples, sample{dts: s.PTS, audio: s})
+			}
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].dts < samples[j].dts
+	})
+
+	for _, s := range samples {
+		var err error
+		if s.video != nil {
+			err = w.writeBlock(w.videoTrack, s.video.PTS, s.video.IdrPresent, s.video.AVCC)
+		} else {
+			err = w.writeBlock(w.audioTrack, s.audio.PTS, w.videoTrack == 0, s.audio.AU)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeBlock(track uint64, pts int64, keyframe bool, data []byte) error {
+	timestamp := (pts - w.startTime) / timestampScale
+	if timestamp < 0 {
+		timestamp = 0
+	}
+
+	// Clusters start at video keyframes. Audio only recordings
+	// start a new cluster every few seconds instead.
+	relative := timestamp - w.clusterTime
+	newCluster := w.cluster == nil ||
+		(keyframe && track == w.videoTrack) ||
+		(w.videoTrack == 0 && relative >= audioClusterDuration) ||
+		relative > maxClusterDuration ||
+		relative < -maxClusterDuration
+	if newCluster {
+		if err := w.flushCluster(); err != nil {
+			return err
+		}
+		w.clusterTime = timestamp
+		w.clusterKey = keyframe
+		w.cluster = uintElement(idTimestamp, uint64(timestamp))
+		relative = 0
+	}
+
+	var flags byte
+	if keyframe {
+		flags = 0x80
+	}
+	block := appendSize(nil, track)
+	block = append(block, byte(uint16(relative)>>8), byte(relative), flags)
+	block = append(block, data...)
+	w.cluster = append(w.cluster, element(idSimpleBlock, block)...)
+	return nil
+}
+
+// flushCluster writes the current cluster and updates the cues.
+func (w *Writer) flushCluster() error {
+	if w.cluster == nil {
+		return nil
+	}
+	pos := w.pos - w.segmentPos
+	if err := w.write(element(idCluster, w.cluster)); err != nil {
+		return fmt.Errorf("write cluster: %w", err)
+	}
+	if w.clusterKey {
+		track := w.videoTrack
+		if track == 0 {
+			track = w.audioTrack
+		}
+		w.cues = append(w.cues, cuePoint{time: w.clusterTime, track: track, pos: pos})
+	}
+	w.cluster = nil
+
+	if w.wa == nil || w.frontFull {
+		return nil
+	}
+	front, ok := w.front(w.marshalCues(), true)
+	if !ok {
+		w.frontFull = true
+		return nil
+	}
+	if _, err := w.wa.WriteAt(front, w.segmentPos); err != nil {
+		return fmt.Errorf("update cues: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) marshalCues() []byte {
+	points := make([][]byte, 0, len(w.cues))
+	for _, cue := range w.cues {
+		points = append(points, master(idCuePoint,
+			uintElement(idCueTime, uint64(cue.time)),
+			master(idCueTrackPositions,
+				uintElement(idCueTrack, cue.track),
+				uintElement(idCueClusterPosition, uint64(cue.pos)),
+			),
+		))
+	}
+	return master(idCues, points...)
+}
+
+// Close writes the last cluster, the cues if they didn't fit in
+// the reserved space and the segment size if the output is seekable.
+// The underlying writer isn't closed.
+func (w *Writer) Close() error {
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+	if w.wa != nil && !w.frontFull {
+		return w.writeSegmentSize()
+	}
+
+	end := w.marshalCues()
+	front, _ := w.front(end, false)
+	if err := w.write(end); err != nil {
+		return fmt.Errorf("write cues: %w", err)
+	}
+	if w.wa == nil {
+		return nil
+	}
+	if _, err := w.wa.WriteAt(front, w.segmentPos); err != nil {
+		return fmt.Errorf("update seek head: %w", err)
+	}
+	return w.writeSegmentSize()
+}
+
+func (w *Writer) writeSegmentSize() error {
+	size := appendFixedSize(nil, uint64(w.pos-w.segmentPos), 8)
+	if _, err := w.wa.WriteAt(size, w.segmentPos-8); err != nil {
+		return fmt.Errorf("write segment size: %w", err)
+	}
+	return nil
+}
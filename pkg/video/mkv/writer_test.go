@@ -0,0 +1,157 @@
+package mkv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"nvr/pkg/video/hls"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fileBuffer in memory file that supports WriteAt.
+type fileBuffer struct {
+	buf []byte
+}
+
+func (f *fileBuffer) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	return len(p), nil
+}
+
+func (f *fileBuffer) WriteAt(p []byte, off int64) (int, error) {
+	copy(f.buf[off:], p)
+	return len(p), nil
+}
+
+var testInfo = hls.StreamInfo{
+	VideoTrackExist:   true,
+	VideoSPS:          []byte{103, 100, 0, 31},
+	VideoPPS:          []byte{104, 1},
+	AudioTrackExist:   true,
+	AudioTrackConfig:  []byte{18, 16},
+	AudioChannelCount: 2,
+	AudioClockRate:    44100,
+}
+
+var testStart = time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// testSegment returns a segment with a keyframe every second.
+func testSegment(start time.Duration, duration time.Duration) *hls.Segment {
+	var videoSamples []*hls.VideoSample
+	var audioSamples []*hls.AudioSample
+	for t := start; t < start+duration; t += 100 * time.Millisecond {
+		ts := testStart.Add(t).UnixNano()
+		videoSamples = append(videoSamples, &hls.VideoSample{
+			PTS:        ts,
+			DTS:        ts,
+			NextDTS:    ts + int64(100*time.Millisecond),
+			IdrPresent: t%time.Second == 0,
+			AVCC:       []byte{0, 0, 0, 1, 5},
+		})
+		audioSamples = append(audioSamples, &hls.AudioSample{
+			PTS:     ts + int64(50*time.Millisecond),
+			NextPTS: ts + int64(150*time.Millisecond),
+			AU:      []byte{1, 2},
+		})
+	}
+	return &hls.Segment{
+		Parts: []*hls.MuxerPart{{
+			VideoSamples: videoSamples,
+			AudioSamples: audioSamples,
+		}},
+	}
+}
+
+func writeTestFile(t *testing.T, file *fileBuffer, seekable bool) {
+	t.Helper()
+	var wa io.WriterAt
+	if seekable {
+		wa = file
+	}
+	w, err := NewWriter(file, wa, testInfo, testStart.UnixNano())
+	require.NoError(t, err)
+	require.NoError(t, w.WriteSegment(testSegment(0, 2*time.Second)))
+	require.NoError(t, w.WriteSegment(testSegment(2*time.Second, 2*time.Second)))
+	require.NoError(t, w.Close())
+}
+
+// topLevel returns the IDs and positions of the segment children.
+func topLevel(t *testing.T, buf []byte) map[uint32]int64 {
+	t.Helper()
+	r := bytes.NewReader(buf)
+	_, size, headerLen, err := elementHeader(r, 0)
+	require.NoError(t, err)
+	pos := int64(headerLen) + size
+	_, _, headerLen, err = elementHeader(r, pos)
+	require.NoError(t, err)
+	pos += int64(headerLen)
+
+	elements := make(map[uint32]int64)
+	for pos < int64(len(buf)) {
+		id, size, headerLen, err := elementHeader(r, pos)
+		require.NoError(t, err)
+		if _, exist := elements[id]; !exist {
+			elements[id] = pos
+		}
+		pos += int64(headerLen) + size
+	}
+	return elements
+}
+
+func TestWriter(t *testing.T) {
+	t.Run("seekable", func(t *testing.T) {
+		file := &fileBuffer{}
+		writeTestFile(t, file, true)
+
+		require.Equal(t, []byte{0x1a, 0x45, 0xdf, 0xa3}, file.buf[:4])
+		elements := topLevel(t, file.buf)
+		require.Contains(t, elements, uint32(idSeekHead))
+		require.Contains(t, elements, uint32(idCues))
+		require.Contains(t, elements, uint32(idCluster))
+		require.Less(t, elements[idCues], elements[idInfo], "cues should be in the reserved space")
+
+		// Segment size.
+		size, _ := readVint(file.buf[elements[idSeekHead]-8:])
+		require.Equal(t, int64(len(file.buf))-elements[idSeekHead], size)
+
+		start, end, err := ReadTimes(bytes.NewReader(file.buf), int64(len(file.buf)))
+		require.NoError(t, err)
+		require.True(t, testStart.Equal(start))
+		require.Equal(t, 3950*time.Millisecond, end.Sub(start))
+	})
+	t.Run("notSeekable", func(t *testing.T) {
+		file := &fileBuffer{}
+		writeTestFile(t, file, false)
+
+		elements := topLevel(t, file.buf)
+		require.Greater(t, elements[idCues], elements[idCluster], "cues should be at the end")
+
+		start, end, err := ReadTimes(bytes.NewReader(file.buf), int64(len(file.buf)))
+		require.NoError(t, err)
+		require.Equal(t, 3950*time.Millisecond, end.Sub(start))
+	})
+	t.Run("truncated", func(t *testing.T) {
+		file := &fileBuffer{}
+		writeTestFile(t, file, false)
+
+		elements := topLevel(t, file.buf)
+		truncated := file.buf[:elements[idCues]-10]
+		start, end, err := ReadTimes(bytes.NewReader(truncated), int64(len(truncated)))
+		require.NoError(t, err)
+		require.Equal(t, 2950*time.Millisecond, end.Sub(start))
+	})
+	t.Run("noClusters", func(t *testing.T) {
+		file := &fileBuffer{}
+		_, err := NewWriter(file, file, testInfo, testStart.UnixNano())
+		require.NoError(t, err)
+		_, _, err = ReadTimes(bytes.NewReader(file.buf), int64(len(file.buf)))
+		require.ErrorIs(t, err, ErrNoClusters)
+	})
+	t.Run("noTracks", func(t *testing.T) {
+		_, err := NewWriter(&fileBuffer{}, nil, hls.StreamInfo{}, 0)
+		require.ErrorIs(t, err, ErrNoTracks)
+	})
+}
@@ -294,6 +294,28 @@ func (*Free) Size() int { return 0 }
 // Marshal is never called.
 func (*Free) Marshal(w *bitio.Writer) error { return nil }
 
+/*************************** frma ****************************/
+
+// TypeFrma BoxType.
+func TypeFrma() BoxType { return [4]byte{'f', 'r', 'm', 'a'} }
+
+// Frma is ISOBMFF frma box type.
+type Frma struct {
+	DataFormat [4]byte // Original format.
+}
+
+// Type returns the BoxType.
+func (*Frma) Type() BoxType { return TypeFrma() }
+
+// Size returns the marshaled size in bytes.
+func (*Frma) Size() int { return 4 }
+
+// Marshal box to writer.
+func (b *Frma) Marshal(w *bitio.Writer) error {
+	_, err := w.Write(b.DataFormat[:])
+	return err
+}
+
 /*************************** ftyp ****************************/
 
 // TypeFtyp BoxType.
@@ -899,6 +921,244 @@ func (b *AvcC) Marshal(w *bitio.Writer) error {
 	return w.TryError
 }
 
+/*************************** saio ****************************/
+
+// AuxInfoTypePresent saio and saiz flag.
+const AuxInfoTypePresent = 0x000001
+
+// TypeSaio BoxType.
+func TypeSaio() BoxType { return [4]byte{'s', 'a', 'i', 'o'} }
+
+// Saio is ISOBMFF saio box type.
+type Saio struct {
+	FullBox
+	AuxInfoType          [4]byte
+	AuxInfoTypeParameter uint32
+	OffsetV0             []uint32
+	OffsetV1             []uint64
+}
+
+// Type returns the BoxType.
+func (*Saio) Type() BoxType { return TypeSaio() }
+
+// Size returns the marshaled size in bytes.
+func (b *Saio) Size() int {
+	total := b.FullBox.FieldSize() + 4
+	if b.FullBox.CheckFlag(AuxInfoTypePresent) {
+		total += 8
+	}
+	if b.FullBox.Version == 0 {
+		total += len(b.OffsetV0) * 4
+	} else {
+		total += len(b.OffsetV1) * 8
+	}
+	return total
+}
+
+// Marshal box to writer.
+func (b *Saio) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	if b.FullBox.CheckFlag(AuxInfoTypePresent) {
+		w.TryWrite(b.AuxInfoType[:])
+		w.TryWriteUint32(b.AuxInfoTypeParameter)
+	}
+	if b.FullBox.Version == 0 {
+		w.TryWriteUint32(uint32(len(b.OffsetV0)))
+		for _, offset := range b.OffsetV0 {
+			w.TryWriteUint32(offset)
+		}
+	} else {
+		w.TryWriteUint32(uint32(len(b.OffsetV1)))
+		for _, offset := range b.OffsetV1 {
+			w.TryWriteUint64(offset)
+		}
+	}
+	return w.TryError
+}
+
+/*************************** saiz ****************************/
+
+// TypeSaiz BoxType.
+func TypeSaiz() BoxType { return [4]byte{'s', 'a', 'i', 'z'} }
+
+// Saiz is ISOBMFF saiz box type.
+type Saiz struct {
+	FullBox
+	AuxInfoType           [4]byte
+	AuxInfoTypeParameter  uint32
+	DefaultSampleInfoSize uint8
+	SampleCount           uint32
+	SampleInfoSize        []uint8 // Only used if DefaultSampleInfoSize is 0.
+}
+
+// Type returns the BoxType.
+func (*Saiz) Type() BoxType { return TypeSaiz() }
+
+// Size returns the marshaled size in bytes.
+func (b *Saiz) Size() int {
+	total := b.FullBox.FieldSize() + 5
+	if b.FullBox.CheckFlag(AuxInfoTypePresent) {
+		total += 8
+	}
+	if b.DefaultSampleInfoSize == 0 {
+		total += len(b.SampleInfoSize)
+	}
+	return total
+}
+
+// Marshal box to writer.
+func (b *Saiz) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	if b.FullBox.CheckFlag(AuxInfoTypePresent) {
+		w.TryWrite(b.AuxInfoType[:])
+		w.TryWriteUint32(b.AuxInfoTypeParameter)
+	}
+	w.TryWriteByte(b.DefaultSampleInfoSize)
+	w.TryWriteUint32(b.SampleCount)
+	if b.DefaultSampleInfoSize == 0 {
+		w.TryWrite(b.SampleInfoSize)
+	}
+	return w.TryError
+}
+
+/*************************** schi ****************************/
+
+// TypeSchi BoxType.
+func TypeSchi() BoxType { return [4]byte{'s', 'c', 'h', 'i'} }
+
+// Schi is ISOBMFF schi box type.
+type Schi struct{}
+
+// Type returns the BoxType.
+func (*Schi) Type() BoxType { return TypeSchi() }
+
+// Size returns the marshaled size in bytes.
+func (*Schi) Size() int { return 0 }
+
+// Marshal is never called.
+func (*Schi) Marshal(w *bitio.Writer) error { return nil }
+
+/*************************** schm ****************************/
+
+// TypeSchm BoxType.
+func TypeSchm() BoxType { return [4]byte{'s', 'c', 'h', 'm'} }
+
+// Schm is ISOBMFF schm box type.
+type Schm struct {
+	FullBox
+	SchemeType    [4]byte
+	SchemeVersion uint32
+}
+
+// Type returns the BoxType.
+func (*Schm) Type() BoxType { return TypeSchm() }
+
+// Size returns the marshaled size in bytes.
+func (b *Schm) Size() int {
+	return b.FullBox.FieldSize() + 8
+}
+
+// Marshal box to writer.
+func (b *Schm) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	w.TryWrite(b.SchemeType[:])
+	w.TryWriteUint32(b.SchemeVersion)
+	return w.TryError
+}
+
+/*************************** senc ****************************/
+
+// TypeSenc BoxType.
+func TypeSenc() BoxType { return [4]byte{'s', 'e', 'n', 'c'} }
+
+// Senc is ISO/IEC 23001-7 senc box type.
+type Senc struct {
+	FullBox
+	Samples []SencSample
+}
+
+// SencSample .
+type SencSample struct {
+	InitializationVector []byte
+	Subsamples           []SencSubsample
+}
+
+// SencSubsample .
+type SencSubsample struct {
+	BytesOfClearData     uint16
+	BytesOfProtectedData uint32
+}
+
+// SencUseSubsampleEncryption senc flag.
+const SencUseSubsampleEncryption = 0x000002
+
+// Type returns the BoxType.
+func (*Senc) Type() BoxType { return TypeSenc() }
+
+// Size returns the marshaled size in bytes.
+func (b *Senc) Size() int {
+	total := b.FullBox.FieldSize() + 4
+	for _, sample := range b.Samples {
+		total += sample.FieldSize(b.FullBox)
+	}
+	return total
+}
+
+// FieldSize returns the marshaled size of the sample in bytes.
+func (s *SencSample) FieldSize(b FullBox) int {
+	total := len(s.InitializationVector)
+	if b.CheckFlag(SencUseSubsampleEncryption) {
+		total += 2 + len(s.Subsamples)*6
+	}
+	return total
+}
+
+// Marshal box to writer.
+func (b *Senc) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	w.TryWriteUint32(uint32(len(b.Samples)))
+	for _, sample := range b.Samples {
+		w.TryWrite(sample.InitializationVector)
+		if b.FullBox.CheckFlag(SencUseSubsampleEncryption) {
+			w.TryWriteUint16(uint16(len(sample.Subsamples)))
+			for _, subsample := range sample.Subsamples {
+				w.TryWriteUint16(subsample.BytesOfClearData)
+				w.TryWriteUint32(subsample.BytesOfProtectedData)
+			}
+		}
+	}
+	return w.TryError
+}
+
+/*************************** sinf ****************************/
+
+// TypeSinf BoxType.
+func TypeSinf() BoxType { return [4]byte{'s', 'i', 'n', 'f'} }
+
+// Sinf is ISOBMFF sinf box type.
+type Sinf struct{}
+
+// Type returns the BoxType.
+func (*Sinf) Type() BoxType { return TypeSinf() }
+
+// Size returns the marshaled size in bytes.
+func (*Sinf) Size() int { return 0 }
+
+// Marshal is never called.
+func (*Sinf) Marshal(w *bitio.Writer) error { return nil }
+
 /*************************** smhd ****************************/
 
 // TypeSmhd BoxType.
@@ -1184,6 +1444,62 @@ func (b *Stts) Marshal(w *bitio.Writer) error {
 	return nil
 }
 
+/*************************** tenc ****************************/
+
+// TypeTenc BoxType.
+func TypeTenc() BoxType { return [4]byte{'t', 'e', 'n', 'c'} }
+
+// Tenc is ISO/IEC 23001-7 tenc box type.
+type Tenc struct {
+	FullBox
+	DefaultCryptByteBlock  uint8 // Version 1 only.
+	DefaultSkipByteBlock   uint8 // Version 1 only.
+	DefaultIsProtected     uint8
+	DefaultPerSampleIVSize uint8
+	DefaultKID             [16]byte
+
+	// Only used if DefaultIsProtected is 1 and DefaultPerSampleIVSize is 0.
+	DefaultConstantIV []byte
+}
+
+// Type returns the BoxType.
+func (*Tenc) Type() BoxType { return TypeTenc() }
+
+func (b *Tenc) hasConstantIV() bool {
+	return b.DefaultIsProtected == 1 && b.DefaultPerSampleIVSize == 0
+}
+
+// Size returns the marshaled size in bytes.
+func (b *Tenc) Size() int {
+	total := b.FullBox.FieldSize() + 20
+	if b.hasConstantIV() {
+		total += 1 + len(b.DefaultConstantIV)
+	}
+	return total
+}
+
+// Marshal box to writer.
+func (b *Tenc) Marshal(w *bitio.Writer) error {
+	err := b.FullBox.MarshalField(w)
+	if err != nil {
+		return err
+	}
+	w.TryWriteByte(0) // Reserved.
+	if b.FullBox.Version == 0 {
+		w.TryWriteByte(0) // Reserved.
+	} else {
+		w.TryWriteByte(b.DefaultCryptByteBlock<<4 | b.DefaultSkipByteBlock&0x0f)
+	}
+	w.TryWriteByte(b.DefaultIsProtected)
+	w.TryWriteByte(b.DefaultPerSampleIVSize)
+	w.TryWrite(b.DefaultKID[:])
+	if b.hasConstantIV() {
+		w.TryWriteByte(uint8(len(b.DefaultConstantIV)))
+		w.TryWrite(b.DefaultConstantIV)
+	}
+	return w.TryError
+}
+
 /*************************** tfdt ****************************/
 
 // TypeTfdt BoxType.
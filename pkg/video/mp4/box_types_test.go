@@ -202,6 +202,11 @@ func TestBoxTypes(t *testing.T) {
 				0x00, 0x00, 0x01, // flags
 			},
 		},
+		{
+			name: "frma",
+			src:  &Frma{DataFormat: [4]byte{'a', 'v', 'c', '1'}},
+			bin:  []byte{'a', 'v', 'c', '1'},
+		},
 		{
 			name: "ftyp",
 			src: &Ftyp{
@@ -669,6 +674,126 @@ func TestBoxTypes(t *testing.T) {
 			},
 		},
 
+		{
+			name: "saio: version 0",
+			src: &Saio{
+				FullBox:  FullBox{Version: 0},
+				OffsetV0: []uint32{0x01234567},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x00, 0x00, 0x00, 0x01, // entry count
+				0x01, 0x23, 0x45, 0x67, // offset
+			},
+		},
+		{
+			name: "saio: version 1 aux info type",
+			src: &Saio{
+				FullBox: FullBox{
+					Version: 1,
+					Flags:   [3]byte{0x00, 0x00, 0x01},
+				},
+				AuxInfoType:          [4]byte{'c', 'e', 'n', 'c'},
+				AuxInfoTypeParameter: 0x01234567,
+				OffsetV1:             []uint64{0x0123456789abcdef},
+			},
+			bin: []byte{
+				1,                // version
+				0x00, 0x00, 0x01, // flags
+				'c', 'e', 'n', 'c', // aux info type
+				0x01, 0x23, 0x45, 0x67, // aux info type parameter
+				0x00, 0x00, 0x00, 0x01, // entry count
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // offset
+			},
+		},
+		{
+			name: "saiz: default sample info size",
+			src: &Saiz{
+				DefaultSampleInfoSize: 0x08,
+				SampleCount:           0x01234567,
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x08,                   // default sample info size
+				0x01, 0x23, 0x45, 0x67, // sample count
+			},
+		},
+		{
+			name: "saiz: sample info size array",
+			src: &Saiz{
+				SampleCount:    2,
+				SampleInfoSize: []uint8{0x01, 0x23},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x00,                   // default sample info size
+				0x00, 0x00, 0x00, 0x02, // sample count
+				0x01, 0x23, // sample info size
+			},
+		},
+		{
+			name: "schi",
+			src:  &Schi{},
+			bin:  []byte{},
+		},
+		{
+			name: "schm",
+			src: &Schm{
+				SchemeType:    [4]byte{'c', 'b', 'c', 's'},
+				SchemeVersion: 0x00010000,
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				'c', 'b', 'c', 's', // scheme type
+				0x00, 0x01, 0x00, 0x00, // scheme version
+			},
+		},
+		{
+			name: "senc: no subsamples",
+			src: &Senc{
+				Samples: []SencSample{
+					{InitializationVector: []byte{0x01, 0x23, 0x45, 0x67}},
+				},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x00, 0x00, 0x00, 0x01, // sample count
+				0x01, 0x23, 0x45, 0x67, // initialization vector
+			},
+		},
+		{
+			name: "senc: subsamples",
+			src: &Senc{
+				FullBox: FullBox{
+					Flags: [3]byte{0x00, 0x00, 0x02},
+				},
+				Samples: []SencSample{
+					{
+						Subsamples: []SencSubsample{
+							{BytesOfClearData: 0x0123, BytesOfProtectedData: 0x456789ab},
+						},
+					},
+				},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x02, // flags
+				0x00, 0x00, 0x00, 0x01, // sample count
+				0x00, 0x01, // subsample count
+				0x01, 0x23, // bytes of clear data
+				0x45, 0x67, 0x89, 0xab, // bytes of protected data
+			},
+		},
+		{
+			name: "sinf",
+			src:  &Sinf{},
+			bin:  []byte{},
+		},
 		{
 			name: "smhd",
 			src: &Smhd{
@@ -822,6 +947,49 @@ func TestBoxTypes(t *testing.T) {
 				0x67, 0x89, 0xab, 0xcd, // sample delta
 			},
 		},
+		{
+			name: "tenc: version 0",
+			src: &Tenc{
+				DefaultIsProtected:     1,
+				DefaultPerSampleIVSize: 8,
+				DefaultKID: [16]byte{
+					0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+					0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+				},
+			},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x00, 0x00, // reserved
+				0x01,                                           // default is protected
+				0x08,                                           // default per sample iv size
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // default kid
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+			},
+		},
+		{
+			name: "tenc: version 1 constant iv",
+			src: &Tenc{
+				FullBox:                FullBox{Version: 1},
+				DefaultCryptByteBlock:  1,
+				DefaultSkipByteBlock:   9,
+				DefaultIsProtected:     1,
+				DefaultPerSampleIVSize: 0,
+				DefaultConstantIV:      []byte{0x01, 0x23, 0x45, 0x67},
+			},
+			bin: []byte{
+				1,                // version
+				0x00, 0x00, 0x00, // flags
+				0x00,                                           // reserved
+				0x19,                                           // default crypt and skip byte block
+				0x01,                                           // default is protected
+				0x00,                                           // default per sample iv size
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // default kid
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x04,                   // default constant iv size
+				0x01, 0x23, 0x45, 0x67, // default constant iv
+			},
+		},
 		{
 			name: "tfdt: version 0",
 			src: &Tfdt{
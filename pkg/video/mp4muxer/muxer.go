@@ -157,6 +157,10 @@ func (m *muxer) writeAudioSample(sample customformat.Sample) {
 
 	m.mdatPos += sample.Size
 	m.audioStsz = append(m.audioStsz, sample.Size)
+
+	if !m.info.VideoTrackExist {
+		m.endTime = sample.Next
+	}
 }
 
 const (
@@ -210,6 +214,10 @@ func (m *muxer) writeMetadata() error {
 }
 
 func (m *muxer) generateVideoTrak(duration time.Duration) mp4.Boxes {
+	if !m.info.VideoTrackExist {
+		return mp4.Boxes{Box: &mp4.Free{}}
+	}
+
 	/*
 	   trak
 	   - tkhd
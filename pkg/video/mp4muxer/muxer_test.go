@@ -326,3 +326,21 @@ func TestGenerateMP4(t *testing.T) {
 	}
 	require.Equal(t, expected, buf.Bytes())
 }
+
+func TestGenerateMP4AudioOnly(t *testing.T) {
+	samples := []customformat.Sample{
+		{IsAudioSample: true, PTS: 10000, Next: 20000, Size: 2},
+		{IsAudioSample: true, PTS: 20000, Next: 30000, Size: 3},
+	}
+	info := hls.StreamInfo{
+		AudioTrackExist: true,
+		AudioClockRate:  48000,
+	}
+
+	buf := &bytes.Buffer{}
+	mdatSize, err := GenerateMP4(buf, 10000, samples, info)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), mdatSize)
+	require.False(t, bytes.Contains(buf.Bytes(), []byte("vide")))
+	require.True(t, bytes.Contains(buf.Bytes(), []byte("soun")))
+}
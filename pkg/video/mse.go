@@ -0,0 +1,189 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/video/hls"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrMSEEncrypted MSE is not supported for encrypted streams.
+var ErrMSEEncrypted = errors.New("MSE is not supported when HLS encryption is enabled")
+
+// ErrMSEInit failed to generate init.
+var ErrMSEInit = errors.New("failed to generate init")
+
+type mseMuxerByPathNameFunc func(string) (*hls.Muxer, error)
+
+// mseHandler streams the fMP4 init and parts of the
+// HLS muxer over a websocket for Media Source Extensions.
+//
+// The first message is a text message with the MIME type, followed by
+// a binary message with the init and then one binary message per part.
+// The MIME type and init are sent again if the init changes.
+type mseHandler struct {
+	logger          log.ILogger
+	muxerByPathName mseMuxerByPathNameFunc
+	encrypted       bool
+}
+
+func newMSEHandler(
+	logger log.ILogger,
+	muxerByPathName mseMuxerByPathNameFunc,
+	encrypted bool,
+) *mseHandler {
+	return &mseHandler{
+		logger:          logger,
+		muxerByPathName: muxerByPathName,
+		encrypted:       encrypted,
+	}
+}
+
+type mseInitMessage struct {
+	MimeType string `json:"mimeType"`
+}
+
+const mseWriteTimeout = 10 * time.Second
+
+func (h *mseHandler) HandleRequest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// "/api/monitor/<id>/mse"
+		pathName := strings.TrimPrefix(r.URL.Path, "/api/monitor/")
+		if !strings.HasSuffix(pathName, "/mse") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		pathName = strings.TrimSuffix(pathName, "/mse")
+		if pathName == "" || strings.Contains(pathName, "/") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if h.encrypted {
+			http.Error(w, ErrMSEEncrypted.Error(), http.StatusNotFound)
+			return
+		}
+
+		muxer, err := h.muxerByPathName(pathName)
+		if err != nil {
+			http.Error(w, "stream not found", http.StatusNotFound)
+			return
+		}
+
+		upgrader := websocket.Upgrader{}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		// Control frames are only processed while reading.
+		go func() {
+			for {
+				if _, _, err := c.NextReader(); err != nil {
+					c.Close()
+					return
+				}
+			}
+		}()
+
+		if err := h.stream(c, muxer); err != nil {
+			h.logger.Log(log.Entry{
+				Level:     log.LevelDebug,
+				Src:       "app",
+				MonitorID: strings.TrimSuffix(pathName, "_sub"),
+				Msg:       fmt.Sprintf("mse: %v", err),
+			})
+		}
+	}
+}
+
+func (h *mseHandler) stream(c *websocket.Conn, muxer *hls.Muxer) error {
+	part, err := muxer.LatestPart()
+	if err != nil {
+		return fmt.Errorf("latest part: %w", err)
+	}
+
+	var prevInit []byte
+	for {
+		if part.IsIndependent() {
+			init, err := readMuxerInit(muxer)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(init, prevInit) {
+				if err := h.writeInit(c, muxer, init); err != nil {
+					return err
+				}
+				prevInit = init
+			}
+		}
+
+		if err := writePart(c, part); err != nil {
+			return err
+		}
+
+		part, err = muxer.NextPart(part.ID())
+		if err != nil {
+			return fmt.Errorf("next part: %w", err)
+		}
+	}
+}
+
+func (h *mseHandler) writeInit(c *websocket.Conn, muxer *hls.Muxer, init []byte) error {
+	info, err := muxer.StreamInfo()
+	if err != nil {
+		return fmt.Errorf("stream info: %w", err)
+	}
+
+	c.SetWriteDeadline(time.Now().Add(mseWriteTimeout)) //nolint:errcheck
+	if err := c.WriteJSON(mseInitMessage{MimeType: info.MIMEType()}); err != nil {
+		return fmt.Errorf("write mime type: %w", err)
+	}
+	if err := c.WriteMessage(websocket.BinaryMessage, init); err != nil {
+		return fmt.Errorf("write init: %w", err)
+	}
+	return nil
+}
+
+func readMuxerInit(muxer *hls.Muxer) ([]byte, error) {
+	res := muxer.File("init.mp4", "", "", "", hls.CMCD{})
+	if res.Status != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrMSEInit, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func writePart(c *websocket.Conn, part *hls.MuxerPart) error {
+	reader := part.Reader()
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// The part is copied directly from the shared buffer to the message.
+	c.SetWriteDeadline(time.Now().Add(mseWriteTimeout)) //nolint:errcheck
+	w, err := c.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		w.Close()
+		return fmt.Errorf("write part: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("write part: %w", err)
+	}
+	return nil
+}
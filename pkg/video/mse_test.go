@@ -0,0 +1,156 @@
+package video
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/hls"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// 352x288, pic_order_cnt_type 2.
+	testMSESPS = []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	testMSEPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+)
+
+func newTestMSEMuxer(ctx context.Context) *hls.Muxer {
+	return hls.NewMuxer(
+		ctx,
+		7,
+		time.Second,
+		200*time.Millisecond,
+		50000000,
+		func(log.Level, string, ...interface{}) {},
+		true,
+		func() []byte { return testMSESPS },
+		false,
+		nil,
+		func() (*hls.StreamInfo, error) {
+			return &hls.StreamInfo{
+				VideoTrackExist: true,
+				VideoSPS:        testMSESPS,
+				VideoPPS:        testMSEPPS,
+			}, nil
+		},
+		nil,
+		nil,
+		nil,
+	)
+}
+
+// writeTestMSEVideo writes 30fps video with a IDR every second.
+func writeTestMSEVideo(t *testing.T, m *hls.Muxer, start int, frames int) {
+	t.Helper()
+	for i := start; i < start+frames; i++ {
+		pts := time.Duration(i) * time.Second / 30
+
+		var nalus [][]byte
+		if i%30 == 0 {
+			nalus = [][]byte{testMSESPS, testMSEPPS, {0x65, 0x88, 0x84, 0x00}}
+		} else {
+			nalus = [][]byte{{0x41, 0x9a, 0x24, 0x00}}
+		}
+		require.NoError(t, m.WriteH264(time.Now(), pts, nalus))
+	}
+}
+
+func TestMSEHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	muxer := newTestMSEMuxer(ctx)
+	writeTestMSEVideo(t, muxer, 0, 45)
+
+	muxerByPathName := func(pathName string) (*hls.Muxer, error) {
+		if pathName != "x" {
+			return nil, context.Canceled
+		}
+		return muxer, nil
+	}
+
+	newTestServer := func(encrypted bool) *httptest.Server {
+		h := newMSEHandler(log.NewDummyLogger(), muxerByPathName, encrypted)
+		return httptest.NewServer(h.HandleRequest())
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		server := newTestServer(false)
+		defer server.Close()
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/monitor/x/mse"
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer c.Close()
+
+		var msg mseInitMessage
+		require.NoError(t, c.ReadJSON(&msg))
+		require.Equal(t, `video/mp4; codecs="avc1.64000c"`, msg.MimeType)
+
+		typ, init, err := c.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, websocket.BinaryMessage, typ)
+		require.Equal(t, "ftyp", string(init[4:8]))
+
+		// The first part is the latest independent part.
+		typ, part, err := c.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, websocket.BinaryMessage, typ)
+		require.Equal(t, "moof", string(part[4:8]))
+
+		// Parts are pushed as they are finalized.
+		done := make(chan struct{})
+		go func() {
+			_, part, err := c.ReadMessage()
+			require.NoError(t, err)
+			require.Equal(t, "moof", string(part[4:8]))
+			close(done)
+		}()
+		writeTestMSEVideo(t, muxer, 45, 15)
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		server := newTestServer(false)
+		defer server.Close()
+
+		cases := map[string]string{
+			"noSuffix":    "/api/monitor/x",
+			"missingID":   "/api/monitor//mse",
+			"nested":      "/api/monitor/x/y/mse",
+			"missingPath": "/api/monitor/y/mse",
+		}
+		for name, path := range cases {
+			t.Run(name, func(t *testing.T) {
+				res, err := http.Get(server.URL + path)
+				require.NoError(t, err)
+				res.Body.Close()
+				require.Equal(t, http.StatusNotFound, res.StatusCode)
+			})
+		}
+	})
+	t.Run("encrypted", func(t *testing.T) {
+		server := newTestServer(true)
+		defer server.Close()
+
+		res, err := http.Get(server.URL + "/api/monitor/x/mse")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
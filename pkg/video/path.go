@@ -16,6 +16,11 @@ type pathHLSServer interface {
 	pathSourceNotReady(pathName string)
 }
 
+// pathSource is a publisher, RTSP session, RTMP or HTTP push connection.
+type pathSource interface {
+	close()
+}
+
 type path struct {
 	name      string
 	conf      *PathConf
@@ -23,7 +28,7 @@ type path struct {
 	hlsServer pathHLSServer
 	logger    log.ILogger
 
-	source      *rtspSession
+	source      pathSource
 	sourceReady bool
 	stream      *stream
 	readers     map[*rtspSession]struct{}
@@ -125,7 +130,7 @@ func (pa *path) streamGet() (*stream, error) {
 }
 
 // publisherAdd is called by a publisher through pathManager.
-func (pa *path) publisherAdd(session *rtspSession) (*path, error) {
+func (pa *path) publisherAdd(source pathSource) (*path, error) {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 	if pa.canceled {
@@ -135,11 +140,37 @@ func (pa *path) publisherAdd(session *rtspSession) (*path, error) {
 	if pa.source != nil {
 		return nil, ErrPathBusy
 	}
-	pa.source = session
+	pa.source = source
 
 	return pa, nil
 }
 
+// publisherRemove is called by a publisher that doesn't own the path.
+// Unlike close, the path can be published to again.
+func (pa *path) publisherRemove(source pathSource) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	if pa.canceled || pa.source != source {
+		return
+	}
+
+	if pa.sourceReady {
+		pa.hlsServer.pathSourceNotReady(pa.name)
+		pa.sourceReady = false
+	}
+	pa.source = nil
+
+	if pa.stream != nil {
+		pa.stream.close()
+		pa.stream = nil
+	}
+
+	for r := range pa.readers {
+		r.close()
+		delete(pa.readers, r)
+	}
+}
+
 // publisherStart is called by a publisher.
 func (pa *path) publisherStart(tracks gortsplib.Tracks) (*stream, error) {
 	pa.mu.Lock()
@@ -253,6 +284,22 @@ type PathConf struct {
 	HLSSegmentDuration time.Duration
 	HLSPartDuration    time.Duration
 	HLSSegmentMaxSize  uint64
+
+	// Minimum duration of segments kept in the playlist. The
+	// segment count is increased to hold the buffer if needed.
+	HLSBufferDuration time.Duration
+
+	// Languages of the audio tracks by index. Used
+	// when the language isn't provided by the SDP.
+	AudioLanguages []string
+
+	// Stream key required to publish to the path using
+	// RTMP. RTMP publishing is disabled if empty.
+	RTMPKey string
+
+	// Stream key required to publish to the path using
+	// HTTP push. HTTP push publishing is disabled if empty.
+	PushKey string
 }
 
 // Errors.
@@ -269,6 +316,15 @@ const (
 	defaultHLSPartDuration    = 300 * time.Millisecond
 )
 
+// Range of the HLS part duration.
+const (
+	MinHLSPartDuration = 100 * time.Millisecond
+	MaxHLSPartDuration = 2 * time.Second
+)
+
+// ErrInvalidPartDuration part duration out of range.
+var ErrInvalidPartDuration = errors.New("invalid part duration")
+
 var mb = uint64(1000000)
 
 var defaultHLSsegmentMaxSize = 50 * mb
@@ -293,15 +349,29 @@ func (pconf *PathConf) CheckAndFillMissing(name string) error {
 	if pconf.HLSSegmentCount == 0 {
 		pconf.HLSSegmentCount = defaultHLSSegmentCount
 	}
-	if pconf.HLSSegmentDuration == 0 {
-		pconf.HLSSegmentDuration = defaultHLSSegmentDuration
-	}
 	if pconf.HLSPartDuration == 0 {
 		pconf.HLSPartDuration = defaultHLSPartDuration
 	}
+	if pconf.HLSPartDuration < MinHLSPartDuration || pconf.HLSPartDuration > MaxHLSPartDuration {
+		return fmt.Errorf("%w: %v", ErrInvalidPartDuration, pconf.HLSPartDuration)
+	}
+	if pconf.HLSSegmentDuration == 0 {
+		// Segments should hold at least a few parts.
+		pconf.HLSSegmentDuration = defaultHLSSegmentDuration
+		if 3*pconf.HLSPartDuration > pconf.HLSSegmentDuration {
+			pconf.HLSSegmentDuration = 3 * pconf.HLSPartDuration
+		}
+	}
 	if pconf.HLSSegmentMaxSize == 0 {
 		pconf.HLSSegmentMaxSize = defaultHLSsegmentMaxSize
 	}
+	if pconf.HLSBufferDuration > 0 {
+		// The current segment isn't finalized and can't be read.
+		count := int(pconf.HLSBufferDuration/pconf.HLSSegmentDuration) + 2
+		if count > pconf.HLSSegmentCount {
+			pconf.HLSSegmentCount = count
+		}
+	}
 
 	return nil
 }
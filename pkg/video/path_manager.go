@@ -2,6 +2,7 @@ package video
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
@@ -155,6 +156,83 @@ func (pm *pathManager) publisherAdd(
 	return path.publisherAdd(session)
 }
 
+// ErrRTMPInvalidKey invalid stream key.
+var ErrRTMPInvalidKey = errors.New("invalid stream key")
+
+// rtmpPublisherAdd is called by a rtmp publisher.
+func (pm *pathManager) rtmpPublisherAdd(
+	name string,
+	key string,
+	conn pathSource,
+) (*path, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	path, exist := pm.paths[name]
+	if !exist {
+		return nil, ErrPathNotExist
+	}
+
+	conf := pm.pathConfs[name]
+	if conf.RTMPKey == "" ||
+		subtle.ConstantTimeCompare([]byte(conf.RTMPKey), []byte(key)) != 1 {
+		return nil, ErrRTMPInvalidKey
+	}
+	return path.publisherAdd(conn)
+}
+
+// ErrPushInvalidKey invalid HTTP push stream key.
+var ErrPushInvalidKey = errors.New("invalid push stream key")
+
+// pushPublisherAdd is called by a HTTP push publisher.
+func (pm *pathManager) pushPublisherAdd(
+	name string,
+	key string,
+	conn pathSource,
+) (*path, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	path, exist := pm.paths[name]
+	if !exist {
+		return nil, ErrPathNotExist
+	}
+
+	conf := pm.pathConfs[name]
+	if conf.PushKey == "" ||
+		subtle.ConstantTimeCompare([]byte(conf.PushKey), []byte(key)) != 1 {
+		return nil, ErrPushInvalidKey
+	}
+	return path.publisherAdd(conn)
+}
+
+func (pm *pathManager) pathNames() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	names := make([]string, 0, len(pm.paths))
+	for name := range pm.paths {
+		names = append(names, name)
+	}
+	return names
+}
+
+// pathStats returns the track statistics of a path.
+func (pm *pathManager) pathStats(name string) ([]TrackStats, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	path, exist := pm.paths[name]
+	if !exist {
+		return nil, ErrPathNotExist
+	}
+
+	stream, err := path.streamGet()
+	if err != nil {
+		return nil, err
+	}
+	return stream.stats(), nil
+}
+
 // readerAdd is called by a rtsp reader.
 func (pm *pathManager) readerAdd(
 	name string,
@@ -170,6 +248,18 @@ func (pm *pathManager) readerAdd(
 	return path.readerAdd(session)
 }
 
+// streamByName is called by the WebRTC server.
+func (pm *pathManager) streamByName(name string) (*stream, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	path, exist := pm.paths[name]
+	if !exist {
+		return nil, ErrPathNotExist
+	}
+	return path.streamGet()
+}
+
 func (pm *pathManager) pathLogfByName(name string) log.Func {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -0,0 +1,277 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"nvr/pkg/video/gortsplib/pkg/rtph264"
+	"nvr/pkg/video/gortsplib/pkg/rtpmpeg4audio"
+	"strings"
+	"time"
+)
+
+// PushKeyHeader is the header of the HTTP push stream key.
+const PushKeyHeader = "X-Stream-Key"
+
+type pushPathManager interface {
+	pushPublisherAdd(name string, key string, conn pathSource) (*path, error)
+}
+
+// pushHandler accepts fMP4 streams uploaded by HTTP push publishers.
+// The request body is the init followed by the fragments, usually
+// sent with chunked transfer encoding. A publisher that has been
+// idle for readTimeout is removed from the path.
+type pushHandler struct {
+	logger      log.ILogger
+	pathManager pushPathManager
+}
+
+func newPushHandler(logger log.ILogger, pathManager pushPathManager) *pushHandler {
+	return &pushHandler{
+		logger:      logger,
+		pathManager: pathManager,
+	}
+}
+
+func (h *pushHandler) HandleRequest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// "/api/push/<path-name>"
+		pathName := strings.TrimPrefix(r.URL.Path, "/api/push/")
+		if pathName == "" || strings.Contains(pathName, "/") {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		c := &pushConn{cancel: cancel, logf: h.logf}
+		path, err := h.pathManager.pushPublisherAdd(pathName, r.Header.Get(PushKeyHeader), c)
+		switch {
+		case errors.Is(err, ErrPathNotExist):
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case errors.Is(err, ErrPushInvalidKey):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrPathBusy):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		defer path.publisherRemove(c)
+
+		// The path is released when the publisher is closed
+		// or goes idle, even if the read is still blocked.
+		go func() {
+			<-ctx.Done()
+			path.publisherRemove(c)
+		}()
+
+		pathLogf := path.logf
+		c.logf = func(level log.Level, format string, a ...interface{}) {
+			pathLogf(level, "HTTP push: %s", fmt.Sprintf(format, a...))
+		}
+		c.logf(log.LevelInfo, "publisher connected from %v", r.RemoteAddr)
+
+		err = c.run(ctx, path, r.Body)
+		switch {
+		case err == nil, errors.Is(err, io.EOF):
+			c.logf(log.LevelDebug, "closed")
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, context.Canceled):
+			c.logf(log.LevelDebug, "closed")
+		default:
+			c.logf(log.LevelError, "closed: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
+func (h *pushHandler) logf(level log.Level, format string, a ...interface{}) {
+	h.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf("HTTP push: %v", fmt.Sprintf(format, a...)),
+	})
+}
+
+type pushConn struct {
+	cancel context.CancelFunc
+	logf   log.Func
+}
+
+// close is called by path.
+func (c *pushConn) close() {
+	c.cancel()
+}
+
+func (c *pushConn) run(ctx context.Context, path *path, body io.Reader) error {
+	idle := time.AfterFunc(readTimeout, c.cancel)
+	defer idle.Stop()
+
+	demuxer := newFMP4Demuxer(body)
+	if err := demuxer.readInit(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	tracks := newPushTracks(demuxer.video, demuxer.audio)
+	stream, err := path.publisherStart(tracks.tracks())
+	if err != nil {
+		return err
+	}
+	w := newPushWriter(stream, tracks)
+
+	for {
+		idle.Reset(readTimeout)
+		samples, err := demuxer.readFragment()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, sample := range samples {
+			if err := w.writeSample(sample); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type pushTracks struct {
+	video   *gortsplib.TrackH264
+	videoID int
+	audio   *gortsplib.TrackMPEG4Audio
+	audioID int
+}
+
+func newPushTracks(video *fmp4Track, audio *fmp4Track) pushTracks {
+	var tracks pushTracks
+	if video != nil {
+		tracks.video = &gortsplib.TrackH264{
+			PayloadType: 96,
+			SPS:         video.sps,
+			PPS:         video.pps,
+		}
+	}
+	if audio != nil {
+		tracks.audio = &gortsplib.TrackMPEG4Audio{
+			PayloadType:      97,
+			Config:           audio.audioConfig,
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+		}
+		if video != nil {
+			tracks.audioID = 1
+		}
+	}
+	return tracks
+}
+
+func (t pushTracks) tracks() gortsplib.Tracks {
+	var tracks gortsplib.Tracks
+	if t.video != nil {
+		tracks = append(tracks, t.video)
+	}
+	if t.audio != nil {
+		tracks = append(tracks, t.audio)
+	}
+	return tracks
+}
+
+// pushWriter converts the fMP4 samples into RTP packets and writes them to the stream.
+type pushWriter struct {
+	stream       *stream
+	tracks       pushTracks
+	videoEncoder *rtph264.Encoder
+	audioEncoder *rtpmpeg4audio.Encoder
+}
+
+func newPushWriter(stream *stream, tracks pushTracks) *pushWriter {
+	w := &pushWriter{stream: stream, tracks: tracks}
+	if tracks.video != nil {
+		w.videoEncoder = &rtph264.Encoder{PayloadType: tracks.video.PayloadType}
+		w.videoEncoder.Init()
+	}
+	if tracks.audio != nil {
+		w.audioEncoder = &rtpmpeg4audio.Encoder{
+			PayloadType: tracks.audio.PayloadType,
+			SampleRate:  tracks.audio.Config.SampleRate,
+			SizeLength:  tracks.audio.SizeLength,
+			IndexLength: tracks.audio.IndexLength,
+		}
+		w.audioEncoder.Init()
+	}
+	return w
+}
+
+func (w *pushWriter) writeSample(sample fmp4Sample) error {
+	if sample.track.isVideo() {
+		return w.writeVideo(sample)
+	}
+	return w.writeAudio(sample)
+}
+
+func (w *pushWriter) writeVideo(sample fmp4Sample) error {
+	nalus, err := h264.AVCCUnmarshal(sample.data)
+	if err != nil {
+		return fmt.Errorf("unmarshal video: %w", err)
+	}
+	if len(nalus) == 0 {
+		return nil
+	}
+
+	pkts, err := w.videoEncoder.Encode(nalus, sample.pts)
+	if err != nil {
+		return fmt.Errorf("encode video: %w", err)
+	}
+
+	ptsEqualsDTS := h264.IDRPresent(nalus)
+	for i, pkt := range pkts {
+		dat := &data{
+			trackID:      w.tracks.videoID,
+			rtpPacket:    pkt,
+			ptsEqualsDTS: ptsEqualsDTS,
+		}
+		// The NALUs are attached to the last packet.
+		if i == len(pkts)-1 {
+			dat.h264NALUs = nalus
+			dat.pts = sample.pts
+		}
+		w.stream.writeData(dat)
+	}
+	return nil
+}
+
+func (w *pushWriter) writeAudio(sample fmp4Sample) error {
+	pkts, err := w.audioEncoder.Encode(sample.data, sample.pts)
+	if err != nil {
+		return fmt.Errorf("encode audio: %w", err)
+	}
+	for _, pkt := range pkts {
+		w.stream.writeData(&data{
+			trackID:      w.tracks.audioID,
+			rtpPacket:    pkt,
+			ptsEqualsDTS: true,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package video
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nvr/pkg/log"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPublisherAdd(t *testing.T) {
+	confs := map[string]*PathConf{
+		"mypath": {PushKey: "key"},
+		"nokey":  {RTMPKey: "key"},
+	}
+	pm := &pathManager{
+		pathConfs: confs,
+		paths: map[string]*path{
+			"mypath": {name: "mypath", conf: confs["mypath"]},
+			"nokey":  {name: "nokey", conf: confs["nokey"]},
+		},
+	}
+	source := &pushConn{}
+
+	_, err := pm.pushPublisherAdd("x", "key", source)
+	require.ErrorIs(t, err, ErrPathNotExist)
+
+	_, err = pm.pushPublisherAdd("nokey", "key", source)
+	require.ErrorIs(t, err, ErrPushInvalidKey)
+
+	_, err = pm.pushPublisherAdd("mypath", "wrong", source)
+	require.ErrorIs(t, err, ErrPushInvalidKey)
+
+	pa, err := pm.pushPublisherAdd("mypath", "key", source)
+	require.NoError(t, err)
+	require.Equal(t, source, pa.source)
+
+	_, err = pm.pushPublisherAdd("mypath", "key", &pushConn{})
+	require.ErrorIs(t, err, ErrPathBusy)
+
+	pa.publisherRemove(source)
+	require.Nil(t, pa.source)
+}
+
+func TestPushHandler(t *testing.T) {
+	conf := &PathConf{MonitorID: "x", PushKey: "key"}
+	pa := &path{name: "x", conf: conf, logger: log.NewDummyLogger()}
+	pm := &pathManager{
+		pathConfs: map[string]*PathConf{"x": conf},
+		paths:     map[string]*path{"x": pa},
+	}
+	h := newPushHandler(log.NewDummyLogger(), pm)
+
+	cases := map[string]struct {
+		method   string
+		path     string
+		key      string
+		body     string
+		expected int
+	}{
+		"method":     {http.MethodGet, "/api/push/x", "key", "", http.StatusMethodNotAllowed},
+		"notFound":   {http.MethodPost, "/api/push/y", "key", "", http.StatusNotFound},
+		"subPath":    {http.MethodPost, "/api/push/x/y", "key", "", http.StatusNotFound},
+		"invalidKey": {http.MethodPost, "/api/push/x", "wrong", "", http.StatusUnauthorized},
+		"invalidBody": {
+			http.MethodPost, "/api/push/x", "key",
+			"\x00\x00\x00\x00mdat", http.StatusBadRequest,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			r.Header.Set(PushKeyHeader, tc.key)
+			w := httptest.NewRecorder()
+			h.HandleRequest().ServeHTTP(w, r)
+			require.Equal(t, tc.expected, w.Code)
+		})
+	}
+
+	t.Run("busy", func(t *testing.T) {
+		source := &pushConn{}
+		_, err := pa.publisherAdd(source)
+		require.NoError(t, err)
+		defer pa.publisherRemove(source)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/push/x", nil)
+		r.Header.Set(PushKeyHeader, "key")
+		w := httptest.NewRecorder()
+		h.HandleRequest().ServeHTTP(w, r)
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+}
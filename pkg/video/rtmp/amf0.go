@@ -0,0 +1,268 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// AMF0 markers.
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0a
+	amf0Date        = 0x0b
+	amf0LongString  = 0x0c
+)
+
+// ObjectEntry is a key value pair of a AMF0 object.
+type ObjectEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// Object is a AMF0 object or ECMA array. The order of the entries is kept.
+type Object []ObjectEntry
+
+// Get returns the value of the key.
+func (o Object) Get(key string) (interface{}, bool) {
+	for _, e := range o {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// StringValue returns the string value of the key.
+func (o Object) StringValue(key string) (string, bool) {
+	v, ok := o.Get(key)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// Errors.
+var (
+	ErrAMF0Short       = errors.New("AMF0 buffer is too short")
+	ErrAMF0InvalidType = errors.New("unsupported AMF0 type")
+)
+
+// AMF0Unmarshal decodes all the values in the buffer. Numbers are
+// returned as float64, null and undefined are returned as nil.
+func AMF0Unmarshal(buf []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(buf) > 0 {
+		v, n, err := amf0UnmarshalValue(buf)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		buf = buf[n:]
+	}
+	return values, nil
+}
+
+func amf0UnmarshalValue(buf []byte) (interface{}, int, error) { //nolint:funlen,gocognit
+	if len(buf) < 1 {
+		return nil, 0, ErrAMF0Short
+	}
+
+	switch buf[0] {
+	case amf0Number:
+		if len(buf) < 9 {
+			return nil, 0, ErrAMF0Short
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[1:])), 9, nil
+
+	case amf0Boolean:
+		if len(buf) < 2 {
+			return nil, 0, ErrAMF0Short
+		}
+		return buf[1] != 0, 2, nil
+
+	case amf0String:
+		s, n, err := amf0UnmarshalString(buf[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return s, 1 + n, nil
+
+	case amf0LongString:
+		if len(buf) < 5 {
+			return nil, 0, ErrAMF0Short
+		}
+		l := int(binary.BigEndian.Uint32(buf[1:]))
+		if len(buf) < 5+l {
+			return nil, 0, ErrAMF0Short
+		}
+		return string(buf[5 : 5+l]), 5 + l, nil
+
+	case amf0Object:
+		obj, n, err := amf0UnmarshalObject(buf[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return obj, 1 + n, nil
+
+	case amf0ECMAArray:
+		// The count is a hint and is ignored, the array is terminated like a object.
+		if len(buf) < 5 {
+			return nil, 0, ErrAMF0Short
+		}
+		obj, n, err := amf0UnmarshalObject(buf[5:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return obj, 5 + n, nil
+
+	case amf0StrictArray:
+		if len(buf) < 5 {
+			return nil, 0, ErrAMF0Short
+		}
+		count := int(binary.BigEndian.Uint32(buf[1:]))
+		pos := 5
+		var arr []interface{}
+		for i := 0; i < count; i++ {
+			v, n, err := amf0UnmarshalValue(buf[pos:])
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			pos += n
+		}
+		return arr, pos, nil
+
+	case amf0Date:
+		if len(buf) < 11 {
+			return nil, 0, ErrAMF0Short
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[1:])), 11, nil
+
+	case amf0Null, amf0Undefined:
+		return nil, 1, nil
+
+	default:
+		return nil, 0, fmt.Errorf("%w: 0x%02x", ErrAMF0InvalidType, buf[0])
+	}
+}
+
+func amf0UnmarshalString(buf []byte) (string, int, error) {
+	if len(buf) < 2 {
+		return "", 0, ErrAMF0Short
+	}
+	l := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < 2+l {
+		return "", 0, ErrAMF0Short
+	}
+	return string(buf[2 : 2+l]), 2 + l, nil
+}
+
+func amf0UnmarshalObject(buf []byte) (Object, int, error) {
+	obj := Object{}
+	pos := 0
+	for {
+		if len(buf[pos:]) >= 3 &&
+			buf[pos] == 0 && buf[pos+1] == 0 && buf[pos+2] == amf0ObjectEnd {
+			return obj, pos + 3, nil
+		}
+
+		key, n, err := amf0UnmarshalString(buf[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+
+		v, n, err := amf0UnmarshalValue(buf[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+
+		obj = append(obj, ObjectEntry{Key: key, Value: v})
+	}
+}
+
+// AMF0Marshal encodes the values. Supported types are float64, int,
+// bool, string, Object and nil which is encoded as null.
+func AMF0Marshal(values ...interface{}) ([]byte, error) {
+	var buf []byte
+	for _, v := range values {
+		var err error
+		buf, err = amf0AppendValue(buf, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func amf0AppendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		buf = append(buf, amf0Number)
+		return appendUint64(buf, math.Float64bits(v)), nil
+
+	case int:
+		return amf0AppendValue(buf, float64(v))
+
+	case bool:
+		if v {
+			return append(buf, amf0Boolean, 1), nil
+		}
+		return append(buf, amf0Boolean, 0), nil
+
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf = append(buf, amf0LongString)
+			buf = appendUint32(buf, uint32(len(v)))
+			return append(buf, v...), nil
+		}
+		buf = append(buf, amf0String)
+		return amf0AppendString(buf, v), nil
+
+	case Object:
+		buf = append(buf, amf0Object)
+		for _, e := range v {
+			buf = amf0AppendString(buf, e.Key)
+			var err error
+			buf, err = amf0AppendValue(buf, e.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, 0, 0, amf0ObjectEnd), nil
+
+	case nil:
+		return append(buf, amf0Null), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrAMF0InvalidType, v)
+	}
+}
+
+func amf0AppendString(buf []byte, s string) []byte {
+	buf = appendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
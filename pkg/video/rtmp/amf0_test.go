@@ -0,0 +1,57 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMF0(t *testing.T) {
+	t.Run("roundTrip", func(t *testing.T) {
+		values := []interface{}{
+			"connect",
+			float64(1),
+			Object{
+				{Key: "app", Value: "live"},
+				{Key: "fpad", Value: false},
+				{Key: "nested", Value: Object{{Key: "a", Value: "b"}}},
+			},
+			nil,
+		}
+		buf, err := AMF0Marshal(values...)
+		require.NoError(t, err)
+
+		decoded, err := AMF0Unmarshal(buf)
+		require.NoError(t, err)
+		require.Equal(t, values, decoded)
+	})
+	t.Run("ecmaArray", func(t *testing.T) {
+		buf := []byte{
+			0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a',
+			0x08, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x05, 'w', 'i', 'd', 't', 'h',
+			0x00, 0x40, 0x94, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x09,
+		}
+		decoded, err := AMF0Unmarshal(buf)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{
+			"onMetaData",
+			Object{{Key: "width", Value: float64(1280)}},
+		}, decoded)
+	})
+	t.Run("errors", func(t *testing.T) {
+		cases := map[string][]byte{
+			"shortNumber": {0x00, 0x01},
+			"shortString": {0x02, 0x00, 0x05, 'a'},
+			"badType":     {0x0d},
+			"openObject":  {0x03, 0x00, 0x01, 'a', 0x05},
+		}
+		for name, buf := range cases {
+			t.Run(name, func(t *testing.T) {
+				_, err := AMF0Unmarshal(buf)
+				require.Error(t, err)
+			})
+		}
+	})
+}
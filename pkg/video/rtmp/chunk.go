@@ -0,0 +1,300 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Message types.
+const (
+	MessageTypeSetChunkSize     = 1
+	MessageTypeAbort            = 2
+	MessageTypeAcknowledgement  = 3
+	MessageTypeUserControl      = 4
+	MessageTypeWindowAckSize    = 5
+	MessageTypeSetPeerBandwidth = 6
+	MessageTypeAudio            = 8
+	MessageTypeVideo            = 9
+	MessageTypeDataAMF3         = 15
+	MessageTypeCommandAMF3      = 17
+	MessageTypeDataAMF0         = 18
+	MessageTypeCommandAMF0      = 20
+)
+
+// Message is a RTMP message.
+type Message struct {
+	ChunkStreamID   uint32
+	Timestamp       uint32 // Milliseconds.
+	Type            uint8
+	MessageStreamID uint32
+	Body            []byte
+}
+
+const (
+	defaultChunkSize = 128
+	maxChunkSize     = 0xFFFFFF
+	maxMessageSize   = 8 * 1024 * 1024
+
+	extendedTimestamp = 0xFFFFFF
+)
+
+// Errors.
+var (
+	ErrChunkSizeInvalid   = errors.New("invalid chunk size")
+	ErrMessageTooBig      = errors.New("message is too big")
+	ErrChunkWithoutHeader = errors.New("chunk received without a previous header")
+)
+
+type chunkStreamState struct {
+	timestamp       uint32
+	timestampDelta  uint32
+	extended        bool
+	length          uint32
+	typ             uint8
+	messageStreamID uint32
+
+	// Partially received message.
+	buf []byte
+	// True if a header has been received.
+	initialized bool
+}
+
+// chunkReader reassembles messages from chunks.
+type chunkReader struct {
+	r         io.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStreamState
+	buf       [11]byte
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStreamState),
+	}
+}
+
+func (r *chunkReader) setChunkSize(size uint32) error {
+	if size < 1 || size > maxChunkSize {
+		return fmt.Errorf("%w: %d", ErrChunkSizeInvalid, size)
+	}
+	r.chunkSize = size
+	return nil
+}
+
+func (r *chunkReader) abort(chunkStreamID uint32) {
+	if s, exist := r.streams[chunkStreamID]; exist {
+		s.buf = nil
+	}
+}
+
+func (r *chunkReader) readBasicHeader() (uint8, uint32, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:1]); err != nil {
+		return 0, 0, err
+	}
+	format := r.buf[0] >> 6
+	csid := uint32(r.buf[0] & 0x3f)
+
+	switch csid {
+	case 0:
+		if _, err := io.ReadFull(r.r, r.buf[:1]); err != nil {
+			return 0, 0, err
+		}
+		csid = 64 + uint32(r.buf[0])
+	case 1:
+		if _, err := io.ReadFull(r.r, r.buf[:2]); err != nil {
+			return 0, 0, err
+		}
+		csid = 64 + uint32(r.buf[0]) + uint32(r.buf[1])*256
+	}
+	return format, csid, nil
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// readMessage reads chunks until a message is complete.
+func (r *chunkReader) readMessage() (*Message, error) {
+	for {
+		msg, err := r.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			return msg, nil
+		}
+	}
+}
+
+// readChunk reads a single chunk, returns the
+// message if it was completed by the chunk.
+func (r *chunkReader) readChunk() (*Message, error) { //nolint:funlen,gocognit
+	format, csid, err := r.readBasicHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	s, exist := r.streams[csid]
+	if !exist {
+		s = &chunkStreamState{}
+		r.streams[csid] = s
+	}
+	if format != 0 && !s.initialized {
+		return nil, ErrChunkWithoutHeader
+	}
+
+	// Chunks of type 3 that starts a new message reuses
+	// the delta of the previous message.
+	newMessage := s.buf == nil
+
+	switch format {
+	case 0:
+		if _, err := io.ReadFull(r.r, r.buf[:11]); err != nil {
+			return nil, err
+		}
+		s.timestamp = uint24(r.buf[0:3])
+		s.timestampDelta = 0
+		s.length = uint24(r.buf[3:6])
+		s.typ = r.buf[6]
+		s.messageStreamID = binary.LittleEndian.Uint32(r.buf[7:11])
+		s.extended = s.timestamp == extendedTimestamp
+		s.initialized = true
+
+	case 1:
+		if _, err := io.ReadFull(r.r, r.buf[:7]); err != nil {
+			return nil, err
+		}
+		s.timestampDelta = uint24(r.buf[0:3])
+		s.length = uint24(r.buf[3:6])
+		s.typ = r.buf[6]
+		s.extended = s.timestampDelta == extendedTimestamp
+
+	case 2:
+		if _, err := io.ReadFull(r.r, r.buf[:3]); err != nil {
+			return nil, err
+		}
+		s.timestampDelta = uint24(r.buf[0:3])
+		s.extended = s.timestampDelta == extendedTimestamp
+	}
+
+	if s.extended {
+		if _, err := io.ReadFull(r.r, r.buf[:4]); err != nil {
+			return nil, err
+		}
+		ext := binary.BigEndian.Uint32(r.buf[:4])
+		if format == 0 {
+			s.timestamp = ext
+		} else if format != 3 || newMessage {
+			s.timestampDelta = ext
+		}
+	}
+
+	if format != 0 && newMessage {
+		s.timestamp += s.timestampDelta
+	}
+
+	if s.length > maxMessageSize {
+		return nil, fmt.Errorf("%w: %d", ErrMessageTooBig, s.length)
+	}
+
+	if newMessage {
+		s.buf = make([]byte, 0, s.length)
+	}
+
+	n := s.length - uint32(len(s.buf))
+	if n > r.chunkSize {
+		n = r.chunkSize
+	}
+	start := len(s.buf)
+	s.buf = s.buf[:start+int(n)]
+	if _, err := io.ReadFull(r.r, s.buf[start:]); err != nil {
+		return nil, err
+	}
+
+	if uint32(len(s.buf)) < s.length {
+		return nil, nil
+	}
+
+	msg := &Message{
+		ChunkStreamID:   csid,
+		Timestamp:       s.timestamp,
+		Type:            s.typ,
+		MessageStreamID: s.messageStreamID,
+		Body:            s.buf,
+	}
+	s.buf = nil
+	return msg, nil
+}
+
+// chunkWriter splits messages into chunks.
+type chunkWriter struct {
+	w         io.Writer
+	chunkSize uint32
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:         w,
+		chunkSize: defaultChunkSize,
+	}
+}
+
+func appendBasicHeader(buf []byte, format uint8, csid uint32) []byte {
+	switch {
+	case csid < 64:
+		return append(buf, format<<6|byte(csid))
+	case csid < 64+256:
+		return append(buf, format<<6, byte(csid-64))
+	default:
+		v := csid - 64
+		return append(buf, format<<6|1, byte(v), byte(v>>8))
+	}
+}
+
+// writeMessage writes the message using a type 0 chunk
+// followed by type 3 chunks. Extended timestamps are used
+// if the timestamp doesn't fit in 24 bits.
+func (w *chunkWriter) writeMessage(msg *Message) error {
+	ts := msg.Timestamp
+	extended := ts >= extendedTimestamp
+	if extended {
+		ts = extendedTimestamp
+	}
+
+	buf := appendBasicHeader(nil, 0, msg.ChunkStreamID)
+	buf = append(buf,
+		byte(ts>>16), byte(ts>>8), byte(ts),
+		byte(len(msg.Body)>>16), byte(len(msg.Body)>>8), byte(len(msg.Body)),
+		msg.Type,
+	)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], msg.MessageStreamID)
+	if extended {
+		buf = appendUint32(buf, msg.Timestamp)
+	}
+
+	body := msg.Body
+	for {
+		n := uint32(len(body))
+		if n > w.chunkSize {
+			n = w.chunkSize
+		}
+		buf = append(buf, body[:n]...)
+		body = body[n:]
+		if len(body) == 0 {
+			break
+		}
+
+		buf = appendBasicHeader(buf, 3, msg.ChunkStreamID)
+		if extended {
+			buf = appendUint32(buf, msg.Timestamp)
+		}
+	}
+
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,83 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunk(t *testing.T) {
+	cases := map[string]*Message{
+		"single": {
+			ChunkStreamID: 3,
+			Timestamp:     1000,
+			Type:          MessageTypeCommandAMF0,
+			Body:          bytes.Repeat([]byte{1}, 100),
+		},
+		"multiple": {
+			ChunkStreamID:   6,
+			Timestamp:       2000,
+			Type:            MessageTypeVideo,
+			MessageStreamID: 1,
+			Body:            bytes.Repeat([]byte{2}, 1000),
+		},
+		"extendedTimestamp": {
+			ChunkStreamID:   7,
+			Timestamp:       0x1000000,
+			Type:            MessageTypeAudio,
+			MessageStreamID: 1,
+			Body:            bytes.Repeat([]byte{3}, 300),
+		},
+		"twoByteChunkStreamID": {
+			ChunkStreamID: 100,
+			Type:          MessageTypeAudio,
+			Body:          []byte{4},
+		},
+		"threeByteChunkStreamID": {
+			ChunkStreamID: 1000,
+			Type:          MessageTypeAudio,
+			Body:          []byte{5},
+		},
+	}
+	for name, msg := range cases {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, newChunkWriter(&buf).writeMessage(msg))
+
+			decoded, err := newChunkReader(&buf).readMessage()
+			require.NoError(t, err)
+			require.Equal(t, msg, decoded)
+			require.Zero(t, buf.Len())
+		})
+	}
+	t.Run("headerCompression", func(t *testing.T) {
+		// Type 0, then type 1 and 3 chunks with time deltas of 40ms.
+		buf := []byte{
+			0x06,
+			0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x09, 0x01, 0x00, 0x00, 0x00,
+			0xaa,
+			0x46,
+			0x00, 0x00, 0x28, 0x00, 0x00, 0x01, 0x09,
+			0xbb,
+			0xc6,
+			0xcc,
+		}
+		r := newChunkReader(bytes.NewReader(buf))
+
+		expected := []*Message{
+			{ChunkStreamID: 6, Timestamp: 10, Type: 9, MessageStreamID: 1, Body: []byte{0xaa}},
+			{ChunkStreamID: 6, Timestamp: 50, Type: 9, MessageStreamID: 1, Body: []byte{0xbb}},
+			{ChunkStreamID: 6, Timestamp: 90, Type: 9, MessageStreamID: 1, Body: []byte{0xcc}},
+		}
+		for _, e := range expected {
+			msg, err := r.readMessage()
+			require.NoError(t, err)
+			require.Equal(t, e, msg)
+		}
+	})
+	t.Run("withoutHeader", func(t *testing.T) {
+		_, err := newChunkReader(bytes.NewReader([]byte{0xc6})).readMessage()
+		require.ErrorIs(t, err, ErrChunkWithoutHeader)
+	})
+}
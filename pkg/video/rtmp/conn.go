@@ -0,0 +1,338 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Chunk stream IDs used by the server.
+const (
+	chunkStreamControl = 2
+	chunkStreamCommand = 3
+	chunkStreamStatus  = 5
+)
+
+const (
+	// The message stream ID returned by createStream.
+	publishStreamID = 1
+
+	serverChunkSize  = 4096
+	serverWindowSize = 2500000
+)
+
+// Errors.
+var (
+	ErrPlayNotSupported   = errors.New("play is not supported, the server only accepts publishers")
+	ErrPublishBeforeConn  = errors.New("publish before connect")
+	ErrCommandInvalid     = errors.New("invalid command")
+	ErrPublisherStopped   = errors.New("publisher stopped")
+	ErrPublishNameMissing = errors.New("publish name is missing")
+)
+
+// Conn is a server side RTMP connection that accepts a publisher.
+type Conn struct {
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	reader *chunkReader
+	writer *chunkWriter
+
+	bytesRead  *countingReader
+	ackWindow  uint32
+	lastAckPos uint64
+}
+
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += uint64(n)
+	return n, err
+}
+
+// NewConn allocates a Conn.
+func NewConn(rw io.ReadWriter) *Conn {
+	counter := &countingReader{r: rw}
+	br := bufio.NewReaderSize(counter, serverChunkSize)
+	bw := bufio.NewWriterSize(rw, serverChunkSize)
+	return &Conn{
+		br:        br,
+		bw:        bw,
+		reader:    newChunkReader(br),
+		writer:    newChunkWriter(bw),
+		bytesRead: counter,
+	}
+}
+
+// Accept performs the handshake and reads commands until the client
+// starts publishing. Returns the app and the publish name, the
+// publish name is the stream key. Query strings are removed.
+func (c *Conn) Accept() (string, string, error) { //nolint:funlen,gocognit
+	if err := serverHandshake(c.br, c.bw); err != nil {
+		return "", "", fmt.Errorf("handshake: %w", err)
+	}
+
+	var app string
+	connected := false
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return "", "", err
+		}
+		if msg.Type != MessageTypeCommandAMF0 && msg.Type != MessageTypeCommandAMF3 {
+			continue
+		}
+
+		name, txID, args, err := parseCommand(msg)
+		if err != nil {
+			return "", "", err
+		}
+
+		switch name {
+		case "connect":
+			if len(args) < 1 {
+				return "", "", fmt.Errorf("%w: connect without command object", ErrCommandInvalid)
+			}
+			obj, _ := args[0].(Object)
+			app, _ = obj.StringValue("app")
+			app = trimQuery(strings.TrimSuffix(app, "/"))
+
+			if err := c.writeConnectResult(txID); err != nil {
+				return "", "", err
+			}
+			connected = true
+
+		case "createStream":
+			err := c.writeCommand(chunkStreamCommand, 0, "_result", txID, nil, publishStreamID)
+			if err != nil {
+				return "", "", err
+			}
+
+		case "publish":
+			if !connected {
+				return "", "", ErrPublishBeforeConn
+			}
+			// Null, publish name, publish type.
+			if len(args) < 2 {
+				return "", "", ErrPublishNameMissing
+			}
+			streamName, _ := args[1].(string)
+
+			err := c.writeCommand(chunkStreamStatus, publishStreamID,
+				"onStatus", 0, nil, Object{
+					{Key: "level", Value: "status"},
+					{Key: "code", Value: "NetStream.Publish.Start"},
+					{Key: "description", Value: "publish start"},
+				})
+			if err != nil {
+				return "", "", err
+			}
+			return app, trimQuery(streamName), nil
+
+		case "play":
+			return "", "", ErrPlayNotSupported
+		}
+	}
+}
+
+func trimQuery(s string) string {
+	if i := strings.IndexByte(s, '?'); i != -1 {
+		return s[:i]
+	}
+	return s
+}
+
+func (c *Conn) writeConnectResult(txID float64) error {
+	winAckSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(winAckSize, serverWindowSize)
+	err := c.writer.writeMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          MessageTypeWindowAckSize,
+		Body:          winAckSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	peerBandwidth := make([]byte, 5)
+	binary.BigEndian.PutUint32(peerBandwidth, serverWindowSize)
+	peerBandwidth[4] = 2 // Dynamic limit type.
+	err = c.writer.writeMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          MessageTypeSetPeerBandwidth,
+		Body:          peerBandwidth,
+	})
+	if err != nil {
+		return err
+	}
+
+	chunkSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunkSize, serverChunkSize)
+	err = c.writer.writeMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          MessageTypeSetChunkSize,
+		Body:          chunkSize,
+	})
+	if err != nil {
+		return err
+	}
+	c.writer.chunkSize = serverChunkSize
+
+	return c.writeCommand(chunkStreamCommand, 0,
+		"_result", txID,
+		Object{
+			{Key: "fmsVer", Value: "FMS/3,0,1,123"},
+			{Key: "capabilities", Value: 31},
+		},
+		Object{
+			{Key: "level", Value: "status"},
+			{Key: "code", Value: "NetConnection.Connect.Success"},
+			{Key: "description", Value: "Connection succeeded."},
+			{Key: "objectEncoding", Value: 0},
+		},
+	)
+}
+
+func (c *Conn) writeCommand(
+	chunkStreamID uint32,
+	messageStreamID uint32,
+	values ...interface{},
+) error {
+	body, err := AMF0Marshal(values...)
+	if err != nil {
+		return err
+	}
+	err = c.writer.writeMessage(&Message{
+		ChunkStreamID:   chunkStreamID,
+		Type:            MessageTypeCommandAMF0,
+		MessageStreamID: messageStreamID,
+		Body:            body,
+	})
+	if err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// parseCommand returns the name, transaction ID and arguments of a command.
+func parseCommand(msg *Message) (string, float64, []interface{}, error) {
+	body := msg.Body
+	// AMF3 commands are prefixed by a zero and encoded as AMF0.
+	if msg.Type == MessageTypeCommandAMF3 && len(body) >= 1 {
+		body = body[1:]
+	}
+
+	values, err := AMF0Unmarshal(body)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("%w: %v", ErrCommandInvalid, err)
+	}
+	if len(values) < 2 {
+		return "", 0, nil, ErrCommandInvalid
+	}
+
+	name, ok := values[0].(string)
+	if !ok {
+		return "", 0, nil, ErrCommandInvalid
+	}
+	txID, _ := values[1].(float64)
+
+	return name, txID, values[2:], nil
+}
+
+// ReadMessage returns the next audio, video or data message.
+// Returns ErrPublisherStopped if the client stops publishing.
+func (c *Conn) ReadMessage() (*Message, error) {
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case MessageTypeAudio, MessageTypeVideo, MessageTypeDataAMF0:
+			return msg, nil
+
+		case MessageTypeCommandAMF0, MessageTypeCommandAMF3:
+			name, _, _, err := parseCommand(msg)
+			if err != nil {
+				return nil, err
+			}
+			if name == "FCUnpublish" || name == "deleteStream" || name == "closeStream" {
+				return nil, ErrPublisherStopped
+			}
+		}
+	}
+}
+
+// readMessage reads the next message and handles protocol control messages.
+func (c *Conn) readMessage() (*Message, error) {
+	for {
+		msg, err := c.reader.readMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.sendAck(); err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case MessageTypeSetChunkSize:
+			if len(msg.Body) < 4 {
+				return nil, ErrChunkSizeInvalid
+			}
+			size := binary.BigEndian.Uint32(msg.Body) & 0x7FFFFFFF
+			if err := c.reader.setChunkSize(size); err != nil {
+				return nil, err
+			}
+
+		case MessageTypeAbort:
+			if len(msg.Body) >= 4 {
+				c.reader.abort(binary.BigEndian.Uint32(msg.Body))
+			}
+
+		case MessageTypeWindowAckSize:
+			if len(msg.Body) >= 4 {
+				c.ackWindow = binary.BigEndian.Uint32(msg.Body)
+			}
+
+		case MessageTypeAcknowledgement,
+			MessageTypeUserControl,
+			MessageTypeSetPeerBandwidth:
+
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// sendAck acknowledges the received bytes if the
+// window size set by the client has been reached.
+func (c *Conn) sendAck() error {
+	if c.ackWindow == 0 {
+		return nil
+	}
+	pos := c.bytesRead.n
+	if pos-c.lastAckPos < uint64(c.ackWindow) {
+		return nil
+	}
+	c.lastAckPos = pos
+
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, uint32(pos))
+	err := c.writer.writeMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          MessageTypeAcknowledgement,
+		Body:          body,
+	})
+	if err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
@@ -0,0 +1,230 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a minimal publishing client.
+type testClient struct {
+	t      *testing.T
+	nconn  net.Conn
+	br     *bufio.Reader
+	reader *chunkReader
+	writer *chunkWriter
+}
+
+func newTestClient(t *testing.T, address string) *testClient {
+	t.Helper()
+	nconn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+
+	br := bufio.NewReader(nconn)
+	return &testClient{
+		t:      t,
+		nconn:  nconn,
+		br:     br,
+		reader: newChunkReader(br),
+		writer: newChunkWriter(nconn),
+	}
+}
+
+func (c *testClient) handshake() {
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = handshakeVersion
+	_, err := c.nconn.Write(c0c1)
+	require.NoError(c.t, err)
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	_, err = io.ReadFull(c.br, s0s1s2)
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte(handshakeVersion), s0s1s2[0])
+
+	_, err = c.nconn.Write(s0s1s2[1 : 1+handshakeSize])
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) writeCommand(messageStreamID uint32, values ...interface{}) {
+	body, err := AMF0Marshal(values...)
+	require.NoError(c.t, err)
+	err = c.writer.writeMessage(&Message{
+		ChunkStreamID:   chunkStreamCommand,
+		Type:            MessageTypeCommandAMF0,
+		MessageStreamID: messageStreamID,
+		Body:            body,
+	})
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) setChunkSize(size uint32) {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, size)
+	err := c.writer.writeMessage(&Message{
+		ChunkStreamID: chunkStreamControl,
+		Type:          MessageTypeSetChunkSize,
+		Body:          body,
+	})
+	require.NoError(c.t, err)
+	c.writer.chunkSize = size
+}
+
+// readCommand reads messages until a command is received.
+func (c *testClient) readCommand() []interface{} {
+	for {
+		msg, err := c.reader.readMessage()
+		require.NoError(c.t, err)
+
+		if msg.Type == MessageTypeSetChunkSize {
+			require.NoError(c.t, c.reader.setChunkSize(binary.BigEndian.Uint32(msg.Body)))
+			continue
+		}
+		if msg.Type != MessageTypeCommandAMF0 {
+			continue
+		}
+		values, err := AMF0Unmarshal(msg.Body)
+		require.NoError(c.t, err)
+		return values
+	}
+}
+
+func (c *testClient) publish(app string, streamName string) {
+	c.handshake()
+
+	c.writeCommand(0, "connect", 1, Object{
+		{Key: "app", Value: app},
+		{Key: "type", Value: "nonprivate"},
+	})
+	res := c.readCommand()
+	require.Equal(c.t, "_result", res[0])
+	status, _ := res[3].(Object).StringValue("code")
+	require.Equal(c.t, "NetConnection.Connect.Success", status)
+
+	c.writeCommand(0, "releaseStream", 2, nil, streamName)
+	c.writeCommand(0, "FCPublish", 3, nil, streamName)
+	c.writeCommand(0, "createStream", 4, nil)
+	res = c.readCommand()
+	require.Equal(c.t, []interface{}{"_result", float64(4), nil, float64(1)}, res)
+
+	c.writeCommand(1, "publish", 5, nil, streamName, "live")
+	res = c.readCommand()
+	require.Equal(c.t, "onStatus", res[0])
+	status, _ = res[3].(Object).StringValue("code")
+	require.Equal(c.t, "NetStream.Publish.Start", status)
+}
+
+type testServer struct {
+	ln    net.Listener
+	conns chan net.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{ln: ln, conns: make(chan net.Conn)}
+	go func() {
+		for {
+			nconn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- nconn
+		}
+	}()
+	return s
+}
+
+func TestConn(t *testing.T) {
+	t.Run("publish", func(t *testing.T) {
+		s := newTestServer(t)
+		defer s.ln.Close()
+
+		client := newTestClient(t, s.ln.Addr().String())
+		defer client.nconn.Close()
+
+		type acceptResult struct {
+			app, key string
+			err      error
+		}
+		acceptRes := make(chan acceptResult)
+		var conn *Conn
+		go func() {
+			nconn := <-s.conns
+			conn = NewConn(nconn)
+			app, key, err := conn.Accept()
+			acceptRes <- acceptResult{app, key, err}
+		}()
+
+		client.publish("live", "key?token=x")
+		res := <-acceptRes
+		require.NoError(t, res.err)
+		require.Equal(t, "live", res.app)
+		require.Equal(t, "key", res.key)
+
+		// Messages larger than the default chunk size.
+		client.setChunkSize(4096)
+		video := make([]byte, 3000)
+		err := client.writer.writeMessage(&Message{
+			ChunkStreamID:   6,
+			Timestamp:       40,
+			Type:            MessageTypeVideo,
+			MessageStreamID: 1,
+			Body:            video,
+		})
+		require.NoError(t, err)
+
+		msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, uint8(MessageTypeVideo), msg.Type)
+		require.Equal(t, uint32(40), msg.Timestamp)
+		require.Equal(t, video, msg.Body)
+
+		client.writeCommand(1, "FCUnpublish", 6, nil, "key")
+		_, err = conn.ReadMessage()
+		require.ErrorIs(t, err, ErrPublisherStopped)
+	})
+	t.Run("play", func(t *testing.T) {
+		s := newTestServer(t)
+		defer s.ln.Close()
+
+		client := newTestClient(t, s.ln.Addr().String())
+		defer client.nconn.Close()
+
+		acceptErr := make(chan error)
+		go func() {
+			_, _, err := NewConn(<-s.conns).Accept()
+			acceptErr <- err
+		}()
+
+		client.handshake()
+		client.writeCommand(0, "connect", 1, Object{{Key: "app", Value: "live"}})
+		client.readCommand()
+		client.writeCommand(0, "play", 2, nil, "key")
+		require.ErrorIs(t, <-acceptErr, ErrPlayNotSupported)
+	})
+	t.Run("badVersion", func(t *testing.T) {
+		s := newTestServer(t)
+		defer s.ln.Close()
+
+		client := newTestClient(t, s.ln.Addr().String())
+		defer client.nconn.Close()
+
+		acceptErr := make(chan error)
+		go func() {
+			_, _, err := NewConn(<-s.conns).Accept()
+			acceptErr <- err
+		}()
+
+		c0c1 := make([]byte, 1+handshakeSize)
+		c0c1[0] = 6
+		_, err := client.nconn.Write(c0c1)
+		require.NoError(t, err)
+		require.ErrorIs(t, <-acceptErr, ErrHandshakeVersion)
+	})
+}
@@ -0,0 +1,216 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FLV codec IDs.
+const (
+	CodecH264 = 7
+	CodecAAC  = 10
+)
+
+// AVC and AAC packet types.
+const (
+	PacketTypeSequenceHeader = 0
+	PacketTypeData           = 1
+	PacketTypeEndOfSequence  = 2
+)
+
+// Errors.
+var (
+	ErrTagShort          = errors.New("tag is too short")
+	ErrAVCConfigInvalid  = errors.New("invalid AVC decoder configuration record")
+	ErrAVCConfigNoParams = errors.New("AVC decoder configuration record without SPS or PPS")
+)
+
+// VideoTag is the body of a video message.
+type VideoTag struct {
+	Keyframe   bool
+	Codec      uint8
+	PacketType uint8
+
+	// Difference between the PTS and DTS in milliseconds.
+	CompositionTime int32
+
+	Data []byte
+}
+
+// Unmarshal decodes the tag.
+func (t *VideoTag) Unmarshal(body []byte) error {
+	if len(body) < 1 {
+		return ErrTagShort
+	}
+	t.Keyframe = (body[0] >> 4) == 1
+	t.Codec = body[0] & 0x0F
+
+	if t.Codec != CodecH264 {
+		t.Data = body[1:]
+		return nil
+	}
+
+	if len(body) < 5 {
+		return ErrTagShort
+	}
+	t.PacketType = body[1]
+	// SI24.
+	t.CompositionTime = int32(uint32(body[2])<<24|uint32(body[3])<<16|uint32(body[4])<<8) >> 8
+	t.Data = body[5:]
+	return nil
+}
+
+// AudioTag is the body of a audio message.
+type AudioTag struct {
+	Codec      uint8
+	PacketType uint8
+	Data       []byte
+}
+
+// Unmarshal decodes the tag.
+func (t *AudioTag) Unmarshal(body []byte) error {
+	if len(body) < 1 {
+		return ErrTagShort
+	}
+	t.Codec = body[0] >> 4
+
+	if t.Codec != CodecAAC {
+		t.Data = body[1:]
+		return nil
+	}
+
+	if len(body) < 2 {
+		return ErrTagShort
+	}
+	t.PacketType = body[1]
+	t.Data = body[2:]
+	return nil
+}
+
+// ParseAVCConfig returns the first SPS and PPS
+// of a AVC decoder configuration record.
+func ParseAVCConfig(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 6 || buf[0] != 1 {
+		return nil, nil, ErrAVCConfigInvalid
+	}
+	pos := 5
+
+	spsCount := int(buf[pos] & 0x1F)
+	pos++
+	var sps []byte
+	for i := 0; i < spsCount; i++ {
+		nalu, n, err := readParameterSet(buf[pos:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if sps == nil {
+			sps = nalu
+		}
+		pos += n
+	}
+
+	if len(buf) < pos+1 {
+		return nil, nil, ErrAVCConfigInvalid
+	}
+	ppsCount := int(buf[pos])
+	pos++
+	var pps []byte
+	for i := 0; i < ppsCount; i++ {
+		nalu, n, err := readParameterSet(buf[pos:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if pps == nil {
+			pps = nalu
+		}
+		pos += n
+	}
+
+	if sps == nil || pps == nil {
+		return nil, nil, ErrAVCConfigNoParams
+	}
+	return sps, pps, nil
+}
+
+func readParameterSet(buf []byte) ([]byte, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, ErrAVCConfigInvalid
+	}
+	l := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < 2+l {
+		return nil, 0, ErrAVCConfigInvalid
+	}
+	return buf[2 : 2+l], 2 + l, nil
+}
+
+// MarshalAVCConfig encodes a AVC decoder configuration record.
+func MarshalAVCConfig(sps []byte, pps []byte) ([]byte, error) {
+	if len(sps) < 4 {
+		return nil, fmt.Errorf("%w: SPS is too short", ErrAVCConfigInvalid)
+	}
+	buf := []byte{
+		1,
+		sps[1], sps[2], sps[3],
+		0xFF, // 4 byte NALU length.
+		0xE1, // 1 SPS.
+	}
+	buf = appendUint16(buf, uint16(len(sps)))
+	buf = append(buf, sps...)
+	buf = append(buf, 1) // 1 PPS.
+	buf = appendUint16(buf, uint16(len(pps)))
+	buf = append(buf, pps...)
+	return buf, nil
+}
+
+// Metadata is the stream metadata sent by the publisher.
+type Metadata struct {
+	VideoCodec uint8 // Zero if unknown.
+	AudioCodec uint8 // Zero if unknown.
+	HasVideo   bool
+	HasAudio   bool
+}
+
+// ParseMetadata parses a data message. Returns false
+// if the message isn't a onMetaData message.
+func ParseMetadata(body []byte) (*Metadata, bool, error) {
+	values, err := AMF0Unmarshal(body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(values) >= 1 && values[0] == "@setDataFrame" {
+		values = values[1:]
+	}
+	if len(values) < 2 || values[0] != "onMetaData" {
+		return nil, false, nil
+	}
+	obj, ok := values[1].(Object)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var m Metadata
+	if v, ok := obj.Get("videocodecid"); ok {
+		m.HasVideo = true
+		m.VideoCodec = codecID(v, "avc1", CodecH264)
+	}
+	if v, ok := obj.Get("audiocodecid"); ok {
+		m.HasAudio = true
+		m.AudioCodec = codecID(v, "mp4a", CodecAAC)
+	}
+	return &m, true, nil
+}
+
+// The codec ID can be a number or a FourCC string.
+func codecID(v interface{}, fourCC string, id uint8) uint8 {
+	switch v := v.(type) {
+	case float64:
+		return uint8(v)
+	case string:
+		if v == fourCC {
+			return id
+		}
+	}
+	return 0
+}
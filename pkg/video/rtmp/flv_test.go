@@ -0,0 +1,87 @@
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoTag(t *testing.T) {
+	var tag VideoTag
+	require.NoError(t, tag.Unmarshal([]byte{0x17, 0x01, 0xff, 0xff, 0xd8, 0xaa}))
+	require.Equal(t, VideoTag{
+		Keyframe:        true,
+		Codec:           CodecH264,
+		PacketType:      PacketTypeData,
+		CompositionTime: -40,
+		Data:            []byte{0xaa},
+	}, tag)
+
+	require.ErrorIs(t, tag.Unmarshal([]byte{0x17, 0x01}), ErrTagShort)
+}
+
+func TestAudioTag(t *testing.T) {
+	var tag AudioTag
+	require.NoError(t, tag.Unmarshal([]byte{0xaf, 0x00, 0x12, 0x10}))
+	require.Equal(t, AudioTag{
+		Codec:      CodecAAC,
+		PacketType: PacketTypeSequenceHeader,
+		Data:       []byte{0x12, 0x10},
+	}, tag)
+}
+
+func TestAVCConfig(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x0c, 0xac}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	buf, err := MarshalAVCConfig(sps, pps)
+	require.NoError(t, err)
+
+	sps2, pps2, err := ParseAVCConfig(buf)
+	require.NoError(t, err)
+	require.Equal(t, sps, sps2)
+	require.Equal(t, pps, pps2)
+
+	_, _, err = ParseAVCConfig(buf[:8])
+	require.ErrorIs(t, err, ErrAVCConfigInvalid)
+}
+
+func TestParseMetadata(t *testing.T) {
+	cases := map[string]struct {
+		values   []interface{}
+		expected *Metadata
+		ok       bool
+	}{
+		"obs": {
+			[]interface{}{"@setDataFrame", "onMetaData", Object{
+				{Key: "videocodecid", Value: float64(7)},
+				{Key: "audiocodecid", Value: float64(10)},
+			}},
+			&Metadata{VideoCodec: CodecH264, AudioCodec: CodecAAC, HasVideo: true, HasAudio: true},
+			true,
+		},
+		"fourCC": {
+			[]interface{}{"onMetaData", Object{
+				{Key: "videocodecid", Value: "avc1"},
+			}},
+			&Metadata{VideoCodec: CodecH264, HasVideo: true},
+			true,
+		},
+		"other": {
+			[]interface{}{"onTextData", Object{}},
+			nil,
+			false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf, err := AMF0Marshal(tc.values...)
+			require.NoError(t, err)
+
+			m, ok, err := ParseMetadata(buf)
+			require.NoError(t, err)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.expected, m)
+		})
+	}
+}
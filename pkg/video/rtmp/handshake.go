@@ -0,0 +1,47 @@
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	handshakeVersion = 3
+	handshakeSize    = 1536
+)
+
+// ErrHandshakeVersion unsupported RTMP version.
+var ErrHandshakeVersion = errors.New("unsupported RTMP version")
+
+// serverHandshake performs the simple handshake. The zero version
+// field in S1 tells clients that the digest isn't used.
+func serverHandshake(br *bufio.Reader, bw *bufio.Writer) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(br, c0c1); err != nil {
+		return fmt.Errorf("read C0 and C1: %w", err)
+	}
+	if c0c1[0] != handshakeVersion {
+		return fmt.Errorf("%w: %d", ErrHandshakeVersion, c0c1[0])
+	}
+
+	s1 := make([]byte, handshakeSize)
+	if _, err := rand.Read(s1[8:]); err != nil {
+		return err
+	}
+
+	bw.WriteByte(handshakeVersion) //nolint:errcheck
+	bw.Write(s1)                   //nolint:errcheck
+	bw.Write(c0c1[1:])             //nolint:errcheck
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write S0, S1 and S2: %w", err)
+	}
+
+	c2 := make([]byte, handshakeSize)
+	if _, err := io.ReadFull(br, c2); err != nil {
+		return fmt.Errorf("read C2: %w", err)
+	}
+	return nil
+}
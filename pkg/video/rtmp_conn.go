@@ -0,0 +1,306 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/gortsplib/pkg/rtph264"
+	"nvr/pkg/video/gortsplib/pkg/rtpmpeg4audio"
+	"nvr/pkg/video/rtmp"
+	"time"
+)
+
+// ErrRTMPUnsupportedCodec only H264 and AAC are supported.
+var ErrRTMPUnsupportedCodec = errors.New("unsupported codec, only H264 and AAC are supported")
+
+type rtmpConn struct {
+	nconn       net.Conn
+	conn        *rtmp.Conn
+	pathManager rtmpServerPathManager
+	logf        log.Func
+}
+
+func newRTMPConn(
+	nconn net.Conn,
+	pathManager rtmpServerPathManager,
+	logf log.Func,
+) *rtmpConn {
+	return &rtmpConn{
+		nconn:       nconn,
+		conn:        rtmp.NewConn(nconn),
+		pathManager: pathManager,
+		logf:        logf,
+	}
+}
+
+// close is called by rtmpServer and path.
+func (c *rtmpConn) close() {
+	c.nconn.Close()
+}
+
+func (c *rtmpConn) run() {
+	err := c.runInner()
+	c.nconn.Close()
+
+	if err != nil && !errors.Is(err, rtmp.ErrPublisherStopped) &&
+		!errors.Is(err, context.Canceled) {
+		c.logf(log.LevelError, "closed: %v", err)
+	} else {
+		c.logf(log.LevelDebug, "closed")
+	}
+}
+
+func (c *rtmpConn) runInner() error {
+	c.nconn.SetReadDeadline(time.Now().Add(readTimeout)) //nolint:errcheck
+	app, key, err := c.conn.Accept()
+	if err != nil {
+		return err
+	}
+
+	path, err := c.pathManager.rtmpPublisherAdd(app, key, c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", app, err)
+	}
+	defer path.publisherRemove(c)
+
+	pathLogf := path.logf
+	c.logf = func(level log.Level, format string, a ...interface{}) {
+		pathLogf(level, "RTMP: %s", fmt.Sprintf(format, a...))
+	}
+	c.logf(log.LevelInfo, "publisher connected from %v", c.nconn.RemoteAddr())
+
+	tracks, err := c.readTracks()
+	if err != nil {
+		return err
+	}
+
+	stream, err := path.publisherStart(tracks.tracks())
+	if err != nil {
+		return err
+	}
+
+	return c.readMedia(stream, tracks)
+}
+
+type rtmpTracks struct {
+	video   *gortsplib.TrackH264
+	videoID int
+	audio   *gortsplib.TrackMPEG4Audio
+	audioID int
+}
+
+func (t rtmpTracks) tracks() gortsplib.Tracks {
+	var tracks gortsplib.Tracks
+	if t.video != nil {
+		tracks = append(tracks, t.video)
+	}
+	if t.audio != nil {
+		tracks = append(tracks, t.audio)
+	}
+	return tracks
+}
+
+// readTracks reads messages until the sequence headers of the tracks
+// announced by the metadata has been received. Only video is
+// expected if the publisher doesn't send metadata.
+func (c *rtmpConn) readTracks() (*rtmpTracks, error) { //nolint:funlen,gocognit
+	c.nconn.SetReadDeadline(time.Now().Add(readTimeout)) //nolint:errcheck
+
+	var tracks rtmpTracks
+	var metadata *rtmp.Metadata
+
+	ready := func() bool {
+		wantVideo := metadata == nil || metadata.HasVideo
+		wantAudio := metadata != nil && metadata.HasAudio
+		return (tracks.video != nil || tracks.audio != nil) &&
+			(!wantVideo || tracks.video != nil) &&
+			(!wantAudio || tracks.audio != nil)
+	}
+
+	for !ready() {
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msg.Type {
+		case rtmp.MessageTypeDataAMF0:
+			m, ok, err := rtmp.ParseMetadata(msg.Body)
+			if err != nil || !ok {
+				continue
+			}
+			if (m.HasVideo && m.VideoCodec != rtmp.CodecH264) ||
+				(m.HasAudio && m.AudioCodec != rtmp.CodecAAC) {
+				return nil, ErrRTMPUnsupportedCodec
+			}
+			metadata = m
+
+		case rtmp.MessageTypeVideo:
+			var tag rtmp.VideoTag
+			if err := tag.Unmarshal(msg.Body); err != nil {
+				return nil, err
+			}
+			if tag.Codec != rtmp.CodecH264 {
+				return nil, ErrRTMPUnsupportedCodec
+			}
+			if tag.PacketType != rtmp.PacketTypeSequenceHeader || tracks.video != nil {
+				continue
+			}
+			sps, pps, err := rtmp.ParseAVCConfig(tag.Data)
+			if err != nil {
+				return nil, err
+			}
+			tracks.video = &gortsplib.TrackH264{
+				PayloadType: 96,
+				SPS:         sps,
+				PPS:         pps,
+			}
+
+		case rtmp.MessageTypeAudio:
+			var tag rtmp.AudioTag
+			if err := tag.Unmarshal(msg.Body); err != nil {
+				return nil, err
+			}
+			if tag.Codec != rtmp.CodecAAC {
+				return nil, ErrRTMPUnsupportedCodec
+			}
+			if tag.PacketType != rtmp.PacketTypeSequenceHeader || tracks.audio != nil {
+				continue
+			}
+			var config mpeg4audio.Config
+			if err := config.Unmarshal(tag.Data); err != nil {
+				return nil, fmt.Errorf("audio config: %w", err)
+			}
+			tracks.audio = &gortsplib.TrackMPEG4Audio{
+				PayloadType:      97,
+				Config:           &config,
+				SizeLength:       13,
+				IndexLength:      3,
+				IndexDeltaLength: 3,
+			}
+		}
+	}
+
+	if tracks.video != nil {
+		tracks.audioID = 1
+	}
+	return &tracks, nil
+}
+
+// readMedia converts the FLV tags into RTP packets and writes them to the stream.
+func (c *rtmpConn) readMedia(stream *stream, tracks *rtmpTracks) error { //nolint:funlen,gocognit
+	var videoEncoder *rtph264.Encoder
+	if tracks.video != nil {
+		videoEncoder = &rtph264.Encoder{PayloadType: tracks.video.PayloadType}
+		videoEncoder.Init()
+	}
+
+	var audioEncoder *rtpmpeg4audio.Encoder
+	if tracks.audio != nil {
+		audioEncoder = &rtpmpeg4audio.Encoder{
+			PayloadType: tracks.audio.PayloadType,
+			SampleRate:  tracks.audio.Config.SampleRate,
+			SizeLength:  tracks.audio.SizeLength,
+			IndexLength: tracks.audio.IndexLength,
+		}
+		audioEncoder.Init()
+	}
+
+	// Parameters from a new sequence header, prepended to the
+	// next NALUs so that the stream updates the track.
+	var pendingParams [][]byte
+
+	for {
+		c.nconn.SetReadDeadline(time.Now().Add(readTimeout)) //nolint:errcheck
+		msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case rtmp.MessageTypeVideo:
+			if videoEncoder == nil {
+				continue
+			}
+			var tag rtmp.VideoTag
+			if err := tag.Unmarshal(msg.Body); err != nil {
+				return err
+			}
+
+			switch tag.PacketType {
+			case rtmp.PacketTypeSequenceHeader:
+				sps, pps, err := rtmp.ParseAVCConfig(tag.Data)
+				if err != nil {
+					return err
+				}
+				pendingParams = [][]byte{sps, pps}
+
+			case rtmp.PacketTypeData:
+				nalus, err := h264.AVCCUnmarshal(tag.Data)
+				if err != nil {
+					return fmt.Errorf("unmarshal video: %w", err)
+				}
+				if len(nalus) == 0 {
+					continue
+				}
+				if pendingParams != nil {
+					nalus = append(pendingParams, nalus...)
+					pendingParams = nil
+				}
+
+				dts := time.Duration(msg.Timestamp) * time.Millisecond
+				pts := dts + time.Duration(tag.CompositionTime)*time.Millisecond
+
+				pkts, err := videoEncoder.Encode(nalus, pts)
+				if err != nil {
+					return fmt.Errorf("encode video: %w", err)
+				}
+
+				ptsEqualsDTS := h264.IDRPresent(nalus)
+				for i, pkt := range pkts {
+					dat := &data{
+						trackID:      tracks.videoID,
+						rtpPacket:    pkt,
+						ptsEqualsDTS: ptsEqualsDTS,
+					}
+					// The NALUs are attached to the last packet.
+					if i == len(pkts)-1 {
+						dat.h264NALUs = nalus
+						dat.pts = pts
+					}
+					stream.writeData(dat)
+				}
+			}
+
+		case rtmp.MessageTypeAudio:
+			if audioEncoder == nil {
+				continue
+			}
+			var tag rtmp.AudioTag
+			if err := tag.Unmarshal(msg.Body); err != nil {
+				return err
+			}
+			if tag.PacketType != rtmp.PacketTypeData {
+				continue
+			}
+
+			pts := time.Duration(msg.Timestamp) * time.Millisecond
+			pkts, err := audioEncoder.Encode(tag.Data, pts)
+			if err != nil {
+				return fmt.Errorf("encode audio: %w", err)
+			}
+			for _, pkt := range pkts {
+				stream.writeData(&data{
+					trackID:      tracks.audioID,
+					rtpPacket:    pkt,
+					ptsEqualsDTS: true,
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package video
+
+import (
+	"net"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+	"nvr/pkg/video/rtmp"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestRTMPMessage writes a message using the default chunk size.
+func writeTestRTMPMessage(t *testing.T, w net.Conn, typ uint8, body []byte) {
+	t.Helper()
+	const csID = 6
+	const chunkSize = 128
+
+	buf := []byte{
+		csID,
+		0, 0, 0, // Timestamp.
+		byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body)),
+		typ,
+		1, 0, 0, 0, // Message stream ID, little endian.
+	}
+	for i := 0; i < len(body); i += chunkSize {
+		if i != 0 {
+			buf = append(buf, 0xC0|csID)
+		}
+		end := i + chunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+		buf = append(buf, body[i:end]...)
+	}
+	_, err := w.Write(buf)
+	require.NoError(t, err)
+}
+
+func newTestRTMPConn(t *testing.T) (*rtmpConn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	logf := func(log.Level, string, ...interface{}) {}
+	return newRTMPConn(server, nil, logf), client
+}
+
+func TestRTMPConnReadTracks(t *testing.T) {
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	avcConfig, err := rtmp.MarshalAVCConfig(sps, pps)
+	require.NoError(t, err)
+	videoHeader := append([]byte{0x17, rtmp.PacketTypeSequenceHeader, 0, 0, 0}, avcConfig...)
+
+	audioConfig := mpeg4audio.Config{
+		Type:         mpeg4audio.ObjectTypeAACLC,
+		SampleRate:   44100,
+		ChannelCount: 2,
+	}
+	audioConfigBytes, err := audioConfig.Marshal()
+	require.NoError(t, err)
+	audioHeader := append([]byte{0xAF, rtmp.PacketTypeSequenceHeader}, audioConfigBytes...)
+
+	metadata := func(t *testing.T, videoCodec interface{}) []byte {
+		t.Helper()
+		body, err := rtmp.AMF0Marshal("@setDataFrame", "onMetaData", rtmp.Object{
+			{Key: "videocodecid", Value: videoCodec},
+			{Key: "audiocodecid", Value: float64(rtmp.CodecAAC)},
+		})
+		require.NoError(t, err)
+		return body
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		c, client := newTestRTMPConn(t)
+		go func() {
+			writeTestRTMPMessage(t, client, rtmp.MessageTypeDataAMF0, metadata(t, "avc1"))
+			writeTestRTMPMessage(t, client, rtmp.MessageTypeVideo, videoHeader)
+			writeTestRTMPMessage(t, client, rtmp.MessageTypeAudio, audioHeader)
+		}()
+
+		tracks, err := c.readTracks()
+		require.NoError(t, err)
+		require.Equal(t, sps, tracks.video.SPS)
+		require.Equal(t, pps, tracks.video.PPS)
+		require.Equal(t, 0, tracks.videoID)
+		require.Equal(t, 44100, tracks.audio.Config.SampleRate)
+		require.Equal(t, 1, tracks.audioID)
+		require.Len(t, tracks.tracks(), 2)
+	})
+	t.Run("videoOnly", func(t *testing.T) {
+		c, client := newTestRTMPConn(t)
+		go writeTestRTMPMessage(t, client, rtmp.MessageTypeVideo, videoHeader)
+
+		tracks, err := c.readTracks()
+		require.NoError(t, err)
+		require.NotNil(t, tracks.video)
+		require.Nil(t, tracks.audio)
+		require.Len(t, tracks.tracks(), 1)
+	})
+	t.Run("unsupportedMetadata", func(t *testing.T) {
+		c, client := newTestRTMPConn(t)
+		go writeTestRTMPMessage(t, client, rtmp.MessageTypeDataAMF0, metadata(t, "hvc1"))
+
+		_, err := c.readTracks()
+		require.ErrorIs(t, err, ErrRTMPUnsupportedCodec)
+	})
+	t.Run("unsupportedVideo", func(t *testing.T) {
+		c, client := newTestRTMPConn(t)
+		// Codec ID 12, HEVC.
+		go writeTestRTMPMessage(t, client, rtmp.MessageTypeVideo, []byte{0x1C, 0, 0, 0, 0})
+
+		_, err := c.readTracks()
+		require.ErrorIs(t, err, ErrRTMPUnsupportedCodec)
+	})
+}
+
+func TestRTMPPublisherAdd(t *testing.T) {
+	confs := map[string]*PathConf{
+		"mypath": {RTMPKey: "key"},
+		"nokey":  {},
+	}
+	pm := &pathManager{
+		pathConfs: confs,
+		paths: map[string]*path{
+			"mypath": {name: "mypath", conf: confs["mypath"]},
+			"nokey":  {name: "nokey", conf: confs["nokey"]},
+		},
+	}
+	source := &rtmpConn{}
+
+	_, err := pm.rtmpPublisherAdd("x", "key", source)
+	require.ErrorIs(t, err, ErrPathNotExist)
+
+	_, err = pm.rtmpPublisherAdd("nokey", "", source)
+	require.ErrorIs(t, err, ErrRTMPInvalidKey)
+
+	_, err = pm.rtmpPublisherAdd("mypath", "wrong", source)
+	require.ErrorIs(t, err, ErrRTMPInvalidKey)
+
+	pa, err := pm.rtmpPublisherAdd("mypath", "key", source)
+	require.NoError(t, err)
+	require.Equal(t, source, pa.source)
+
+	_, err = pm.rtmpPublisherAdd("mypath", "key", &rtmpConn{})
+	require.ErrorIs(t, err, ErrPathBusy)
+
+	pa.publisherRemove(source)
+	require.Nil(t, pa.source)
+}
@@ -0,0 +1,117 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"nvr/pkg/log"
+	"sync"
+)
+
+type rtmpServerPathManager interface {
+	rtmpPublisherAdd(name string, key string, conn pathSource) (*path, error)
+}
+
+// rtmpServer accepts RTMP publishers. The app is the path name
+// and the publish name is the stream key of the path.
+type rtmpServer struct {
+	wg          *sync.WaitGroup
+	logger      log.ILogger
+	address     string
+	pathManager rtmpServerPathManager
+
+	ln     net.Listener
+	mu     sync.Mutex
+	conns  map[*rtmpConn]struct{}
+	closed bool
+}
+
+func newRTMPServer(
+	wg *sync.WaitGroup,
+	logger log.ILogger,
+	address string,
+	pathManager rtmpServerPathManager,
+) *rtmpServer {
+	return &rtmpServer{
+		wg:          wg,
+		logger:      logger,
+		address:     address,
+		pathManager: pathManager,
+		conns:       make(map[*rtmpConn]struct{}),
+	}
+}
+
+func (s *rtmpServer) start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+
+	s.logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "app",
+		Msg:   fmt.Sprintf("RTMP: listener opened on %v", s.address),
+	})
+
+	s.wg.Add(1)
+	go s.run()
+
+	go func() {
+		<-ctx.Done()
+		s.ln.Close()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		for c := range s.conns {
+			c.close()
+		}
+	}()
+
+	return nil
+}
+
+func (s *rtmpServer) run() {
+	defer s.wg.Done()
+	for {
+		nconn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newRTMPConn(nconn, s.pathManager, s.logf)
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nconn.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			c.run()
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *rtmpServer) logf(level log.Level, format string, a ...interface{}) {
+	s.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf("RTMP: %v", fmt.Sprintf(format, a...)),
+	})
+}
+
+// Testing.
+func (s *rtmpServer) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"nvr/pkg/log"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/base"
@@ -19,6 +22,10 @@ type rtspServer struct {
 	pathManager *pathManager
 	logger      *log.Logger
 
+	// Restream servers only accept authenticated readers.
+	restream bool
+	auth     RTSPAuthFunc
+
 	ctx      context.Context
 	wg       *sync.WaitGroup
 	srv      *gortsplib.Server
@@ -26,6 +33,9 @@ type rtspServer struct {
 	sessions map[*gortsplib.ServerSession]*rtspSession
 }
 
+// ErrRTSPRestreamPublish publishing to the restream server.
+var ErrRTSPRestreamPublish = errors.New("publishing is not allowed on the restream server")
+
 const (
 	readTimeout  = 10 * time.Second
 	writeTimeout = 10 * time.Second
@@ -130,6 +140,51 @@ func (s *rtspServer) newSessionID() string {
 	}
 }
 
+// RTSPAuthFunc returns true if the request is authenticated. The request
+// only contains the "Authorization" header, remote address and the path.
+type RTSPAuthFunc func(*http.Request) bool
+
+// OnRequest implements gortsplib.ServerHandlerOnRequest.
+func (s *rtspServer) OnRequest(sc *gortsplib.ServerConn, req *base.Request) *base.Response {
+	if !s.restream {
+		return nil
+	}
+
+	r := &http.Request{
+		Header:     make(http.Header),
+		RemoteAddr: sc.NetConn().RemoteAddr().String(),
+		URL:        &url.URL{},
+	}
+	if req.URL != nil {
+		if path, ok := req.URL.RTSPPath(); ok {
+			r.URL.Path = "/" + path
+		}
+	}
+	if v, ok := req.Header["Authorization"]; ok && len(v) == 1 {
+		r.Header.Set("Authorization", v[0])
+	}
+
+	s.mu.RLock()
+	auth := s.auth
+	s.mu.RUnlock()
+
+	if auth == nil || !auth(r) {
+		return &base.Response{
+			StatusCode: base.StatusUnauthorized,
+			Header: base.Header{
+				"WWW-Authenticate": base.HeaderValue{`Basic realm="NVR"`},
+			},
+		}
+	}
+	return nil
+}
+
+func (s *rtspServer) setAuth(auth RTSPAuthFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = auth
+}
+
 // OnConnClose implements gortsplib.ServerHandler.
 func (s *rtspServer) OnConnClose(sc *gortsplib.ServerConn, err error) {
 	session := sc.Session()
@@ -190,6 +245,9 @@ func (s *rtspServer) OnAnnounce(
 	path string,
 	tracks gortsplib.Tracks,
 ) (*base.Response, error) {
+	if s.restream {
+		return &base.Response{StatusCode: base.StatusForbidden}, ErrRTSPRestreamPublish
+	}
 	s.mu.RLock()
 	se := s.sessions[session]
 	s.mu.RUnlock()
@@ -0,0 +1,76 @@
+package video
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib/pkg/base"
+	"nvr/pkg/video/gortsplib/pkg/conn"
+	"nvr/pkg/video/gortsplib/pkg/url"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTSPRestream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	logger := log.NewLogger(&wg, nil)
+	require.NoError(t, logger.Start(ctx))
+
+	const address = "127.0.0.1:18554"
+	pathManager := newPathManager(&wg, logger, nil)
+	s := newRTSPServer(&wg, address, readBufferCount, pathManager, logger)
+	s.restream = true
+	require.NoError(t, s.start(ctx))
+
+	nconn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer nconn.Close()
+	c := conn.NewConn(nconn)
+
+	u, err := url.Parse("rtsp://" + address + "/mypath")
+	require.NoError(t, err)
+
+	cseq := 0
+	request := func(method base.Method, authorization string) *base.Response {
+		cseq++
+		req := &base.Request{
+			Method: method,
+			URL:    u,
+			Header: base.Header{"CSeq": base.HeaderValue{strconv.Itoa(cseq)}},
+		}
+		if authorization != "" {
+			req.Header["Authorization"] = base.HeaderValue{authorization}
+		}
+		require.NoError(t, c.WriteRequest(req))
+		res, err := c.ReadResponse()
+		require.NoError(t, err)
+		return res
+	}
+
+	// Auth function not set.
+	res := request(base.Describe, "")
+	require.Equal(t, base.StatusUnauthorized, res.StatusCode)
+	require.Equal(t, base.HeaderValue{`Basic realm="NVR"`}, res.Header["WWW-Authenticate"])
+
+	s.setAuth(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Basic valid"
+	})
+
+	res = request(base.Options, "")
+	require.Equal(t, base.StatusOK, res.StatusCode)
+
+	res = request(base.Describe, "Basic invalid")
+	require.Equal(t, base.StatusUnauthorized, res.StatusCode)
+
+	res = request(base.Describe, "Basic valid")
+	require.Equal(t, base.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,148 @@
+package video
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StatsSample track statistics of a path at a point in time.
+// The counters are reset when the source reconnects.
+type StatsSample struct {
+	Time time.Time `json:"time"`
+
+	// False if the path isn't published, for example
+	// if the camera is offline or the process crashed.
+	Online bool         `json:"online"`
+	Tracks []TrackStats `json:"tracks"`
+}
+
+// ResolutionChange the video resolution changed at the time of the sample.
+type ResolutionChange struct {
+	Time   time.Time `json:"time"`
+	Width  int       `json:"width"`
+	Height int       `json:"height"`
+}
+
+// StatsHistory samples of a path, oldest first.
+type StatsHistory struct {
+	Samples           []StatsSample      `json:"samples"`
+	ResolutionChanges []ResolutionChange `json:"resolutionChanges"`
+}
+
+// Statistics history sample interval and retention.
+const (
+	StatsHistoryInterval = 10 * time.Second
+	StatsHistoryDuration = time.Hour
+)
+
+// statsHistory keeps the samples of every path, the history of a
+// path is kept until it expires so that it survives monitor restarts.
+type statsHistory struct {
+	mu      sync.Mutex
+	samples map[string][]StatsSample
+}
+
+func newStatsHistory() *statsHistory {
+	return &statsHistory{samples: make(map[string][]StatsSample)}
+}
+
+func (h *statsHistory) add(name string, sample StatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples[name] = append(h.samples[name], sample)
+}
+
+// prune removes samples older than the retention.
+func (h *statsHistory) prune(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limit := now.Add(-StatsHistoryDuration)
+	for name, samples := range h.samples {
+		i := 0
+		for i < len(samples) && samples[i].Time.Before(limit) {
+			i++
+		}
+		if i == len(samples) {
+			delete(h.samples, name)
+			continue
+		}
+		h.samples[name] = samples[i:]
+	}
+}
+
+func (h *statsHistory) get(name string) (StatsHistory, bool) {
+	h.mu.Lock()
+	samples, exist := h.samples[name]
+	samples = append([]StatsSample{}, samples...)
+	h.mu.Unlock()
+	if !exist {
+		return StatsHistory{}, false
+	}
+	return StatsHistory{
+		Samples:           samples,
+		ResolutionChanges: resolutionChanges(samples),
+	}, true
+}
+
+// resolutionChanges compares the video tracks of the online samples.
+func resolutionChanges(samples []StatsSample) []ResolutionChange {
+	changes := []ResolutionChange{}
+	var width, height int
+	for _, sample := range samples {
+		for _, track := range sample.Tracks {
+			if track.Media != "video" || track.Width == 0 {
+				continue
+			}
+			if width != 0 && (track.Width != width || track.Height != height) {
+				changes = append(changes, ResolutionChange{
+					Time:   sample.Time,
+					Width:  track.Width,
+					Height: track.Height,
+				})
+			}
+			width, height = track.Width, track.Height
+			break
+		}
+	}
+	return changes
+}
+
+// sampleStats samples every path until the context is canceled.
+func (s *Server) sampleStats(ctx context.Context) {
+	ticker := time.NewTicker(StatsHistoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, name := range s.pathManager.pathNames() {
+				stats, err := s.pathManager.pathStats(name)
+				s.statsHistory.add(name, StatsSample{
+					Time:   now,
+					Online: err == nil,
+					Tracks: stats,
+				})
+			}
+			s.statsHistory.prune(now)
+		}
+	}
+}
+
+// PathStatsHistory returns the statistics history of the path
+// from the last hour. Returns ErrPathNotExist if the path
+// doesn't exist and doesn't have any samples.
+func (s *Server) PathStatsHistory(name string) (StatsHistory, error) {
+	history, exist := s.statsHistory.get(name)
+	if exist {
+		return history, nil
+	}
+	if !s.PathExist(name) {
+		return StatsHistory{}, ErrPathNotExist
+	}
+	return StatsHistory{
+		Samples:           []StatsSample{},
+		ResolutionChanges: []ResolutionChange{},
+	}, nil
+}
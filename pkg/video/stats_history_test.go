@@ -0,0 +1,43 @@
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsHistory(t *testing.T) {
+	start := time.Unix(0, 0).UTC()
+	video := func(width, height int) []TrackStats {
+		return []TrackStats{
+			{Media: "audio"},
+			{Media: "video", Width: width, Height: height},
+		}
+	}
+
+	h := newStatsHistory()
+	h.add("m1", StatsSample{Time: start, Online: true, Tracks: video(640, 480)})
+	h.add("m1", StatsSample{Time: start.Add(10 * time.Second)})
+	h.add("m1", StatsSample{Time: start.Add(20 * time.Second), Online: true, Tracks: video(640, 480)})
+	h.add("m1", StatsSample{Time: start.Add(30 * time.Second), Online: true, Tracks: video(1280, 720)})
+	h.add("m2", StatsSample{Time: start})
+
+	history, exist := h.get("m1")
+	require.True(t, exist)
+	require.Len(t, history.Samples, 4)
+	require.Equal(t, []ResolutionChange{
+		{Time: start.Add(30 * time.Second), Width: 1280, Height: 720},
+	}, history.ResolutionChanges)
+
+	_, exist = h.get("x")
+	require.False(t, exist)
+
+	h.prune(start.Add(StatsHistoryDuration + 25*time.Second))
+	history, _ = h.get("m1")
+	require.Len(t, history.Samples, 1)
+	require.Empty(t, history.ResolutionChanges)
+
+	_, exist = h.get("m2")
+	require.False(t, exist)
+}
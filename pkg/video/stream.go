@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"nvr/pkg/video/gortsplib"
 	"nvr/pkg/video/gortsplib/pkg/h264"
+	"sync"
 	"time"
 
 	"github.com/pion/rtp"
@@ -23,28 +24,107 @@ type data struct {
 	h264NALUs    [][]byte
 }
 
+// streamReader receives the data written to the stream.
+type streamReader interface {
+	onData(*data)
+	close()
+}
+
 type stream struct {
 	rtspStream   *gortsplib.ServerStream
 	hlsMuxer     *HLSMuxer
 	streamTracks []streamTrack
+	trackStats   []*trackStats
+
+	// Track ID of the first H264 track, -1 if there isn't one.
+	videoTrackID int
+	gopCache     *gopCache
+
+	readersMu sync.RWMutex
+	readers   map[streamReader]struct{}
 }
 
 func newStream(tracks gortsplib.Tracks, hlsMuxer *HLSMuxer) *stream {
 	s := &stream{
-		rtspStream: gortsplib.NewServerStream(tracks),
-		hlsMuxer:   hlsMuxer,
+		rtspStream:   gortsplib.NewServerStream(tracks),
+		hlsMuxer:     hlsMuxer,
+		videoTrackID: -1,
+		gopCache:     newGOPCache(),
+		readers:      make(map[streamReader]struct{}),
 	}
 
 	s.streamTracks = make([]streamTrack, len(s.rtspStream.Tracks()))
+	s.trackStats = make([]*trackStats, len(s.rtspStream.Tracks()))
 	for i, track := range s.rtspStream.Tracks() {
 		s.streamTracks[i] = newStreamTrack(track, s.writeDataInner)
+		s.trackStats[i] = newTrackStats(trackMedia(track), track.ClockRate())
+		if h264Track, isH264 := track.(*gortsplib.TrackH264); isH264 {
+			s.trackStats[i].sps = h264Track.SafeSPS
+			if s.videoTrackID == -1 {
+				s.videoTrackID = i
+			}
+		}
 	}
 
 	return s
 }
 
+func trackMedia(track gortsplib.Track) string {
+	switch track.(type) {
+	case *gortsplib.TrackH264:
+		return "video"
+	case *gortsplib.TrackMPEG4Audio:
+		return "audio"
+	}
+	return "application"
+}
+
 func (s *stream) close() {
 	s.rtspStream.Close()
+
+	s.readersMu.Lock()
+	readers := s.readers
+	s.readers = make(map[streamReader]struct{})
+	s.readersMu.Unlock()
+
+	// Readers may remove themselves when closed.
+	for r := range readers {
+		r.close()
+	}
+}
+
+func (s *stream) readerAdd(r streamReader) {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	s.readers[r] = struct{}{}
+}
+
+// startableReader is a reader that drops data until it's started.
+type startableReader interface {
+	streamReader
+	start()
+}
+
+// readerStart starts a reader that was added before it could receive
+// data and writes the cached GOP to it, this allows the reader to start
+// decoding without waiting for the next IDR. Holding the lock prevents
+// new data from being written to the reader before the cached data.
+func (s *stream) readerStart(r startableReader) {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	if _, exist := s.readers[r]; !exist {
+		return
+	}
+	r.start()
+	for _, dat := range s.gopCache.get() {
+		r.onData(dat)
+	}
+}
+
+func (s *stream) readerRemove(r streamReader) {
+	s.readersMu.Lock()
+	defer s.readersMu.Unlock()
+	delete(s.readers, r)
 }
 
 func (s *stream) tracks() gortsplib.Tracks {
@@ -52,15 +132,37 @@ func (s *stream) tracks() gortsplib.Tracks {
 }
 
 func (s *stream) writeData(data *data) {
+	if data.rtpPacket != nil {
+		s.trackStats[data.trackID].update(data.rtpPacket, time.Now())
+	}
 	s.streamTracks[data.trackID](data)
 }
 
+// stats returns the reception statistics of the tracks.
+func (s *stream) stats() []TrackStats {
+	stats := make([]TrackStats, len(s.trackStats))
+	for i, t := range s.trackStats {
+		stats[i] = t.stats()
+	}
+	return stats
+}
+
 func (s *stream) writeDataInner(data *data) {
 	// forward to RTSP readers
 	s.rtspStream.WritePacketRTP(data.trackID, data.rtpPacket, data.ptsEqualsDTS)
 
 	// forward to hls muxer.
 	s.hlsMuxer.readerData(data)
+
+	// forward to other readers.
+	s.readersMu.RLock()
+	if data.trackID == s.videoTrackID {
+		s.gopCache.write(data)
+	}
+	for r := range s.readers {
+		r.onData(data)
+	}
+	s.readersMu.RUnlock()
 }
 
 type streamTrack func(*data)
@@ -0,0 +1,162 @@
+package video
+
+import (
+	"bytes"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// TrackStats are the RTP reception statistics of a track. Packet
+// loss and jitter are calculated like a RTCP receiver report,
+// RFC 3550 appendix A.3 and A.8, for all packets received so far.
+type TrackStats struct {
+	Media           string  `json:"media"`
+	ClockRate       int     `json:"clockRate"`
+	PacketsReceived uint64  `json:"packetsReceived"`
+	PacketsLost     int64   `json:"packetsLost"`
+	FractionLost    float64 `json:"fractionLost"`
+	JitterMs        float64 `json:"jitterMs"`
+	BitrateKbps     float64 `json:"bitrateKbps"`
+
+	// Video only, the resolution is read from the latest SPS.
+	FrameRate float64 `json:"frameRate,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+}
+
+// How often the bitrate and frame rate are updated.
+const streamStatsBitrateInterval = 2 * time.Second
+
+type trackStats struct {
+	media     string
+	clockRate int
+
+	mu       sync.Mutex
+	started  bool
+	start    time.Time
+	baseSeq  uint16
+	maxSeq   uint16
+	cycles   int64
+	received uint64
+
+	// Interarrival jitter in clock rate units.
+	jitter      float64
+	prevTransit int64
+
+	bitrateStart  time.Time
+	bitrateBytes  int
+	bitrate       float64
+	bitrateFrames int
+	frameRate     float64
+
+	// Returns the current SPS of H264 tracks.
+	sps           func() []byte
+	spsRaw        []byte
+	width, height int
+}
+
+func newTrackStats(media string, clockRate int) *trackStats {
+	return &trackStats{media: media, clockRate: clockRate}
+}
+
+func (s *trackStats) update(pkt *rtp.Packet, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		s.started = true
+		s.start = now
+		s.baseSeq = pkt.SequenceNumber
+		s.maxSeq = pkt.SequenceNumber
+		s.bitrateStart = now
+	}
+
+	s.received++
+	s.updateSeq(pkt.SequenceNumber)
+
+	// The arrival time in clock rate units.
+	arrival := int64(now.Sub(s.start).Seconds() * float64(s.clockRate))
+	transit := arrival - int64(pkt.Timestamp)
+	if s.received > 1 {
+		d := transit - s.prevTransit
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (float64(d) - s.jitter) / 16
+	}
+	s.prevTransit = transit
+
+	s.bitrateBytes += len(pkt.Payload)
+	if s.media == "video" && pkt.Marker {
+		// The marker is set on the last packet of each frame.
+		s.bitrateFrames++
+	}
+	if elapsed := now.Sub(s.bitrateStart); elapsed >= streamStatsBitrateInterval {
+		s.bitrate = float64(s.bitrateBytes*8) / elapsed.Seconds() / 1000
+		s.frameRate = float64(s.bitrateFrames) / elapsed.Seconds()
+		s.bitrateStart = now
+		s.bitrateBytes = 0
+		s.bitrateFrames = 0
+	}
+}
+
+// updateSeq extends the sequence number.
+// Reordered and duplicate packets are ignored.
+func (s *trackStats) updateSeq(seq uint16) {
+	delta := seq - s.maxSeq
+	if delta != 0 && delta < 0x8000 {
+		if seq < s.maxSeq {
+			s.cycles++
+		}
+		s.maxSeq = seq
+	}
+}
+
+func (s *trackStats) stats() TrackStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := TrackStats{
+		Media:           s.media,
+		ClockRate:       s.clockRate,
+		PacketsReceived: s.received,
+		BitrateKbps:     s.bitrate,
+		FrameRate:       s.frameRate,
+	}
+	stats.Width, stats.Height = s.resolution()
+	if !s.started {
+		return stats
+	}
+
+	expected := s.cycles<<16 + int64(s.maxSeq) - int64(s.baseSeq) + 1
+	stats.PacketsLost = expected - int64(s.received)
+	if stats.PacketsLost > 0 {
+		stats.FractionLost = float64(stats.PacketsLost) / float64(expected)
+	}
+	if s.clockRate != 0 {
+		stats.JitterMs = s.jitter / float64(s.clockRate) * 1000
+	}
+	return stats
+}
+
+// resolution parses the SPS if it has changed.
+func (s *trackStats) resolution() (int, int) {
+	if s.sps == nil {
+		return 0, 0
+	}
+	raw := s.sps()
+	if bytes.Equal(raw, s.spsRaw) {
+		return s.width, s.height
+	}
+	s.spsRaw = raw
+	s.width, s.height = 0, 0
+
+	var sps h264.SPS
+	if err := sps.Unmarshal(raw); err == nil {
+		s.width, s.height = sps.Width(), sps.Height()
+	}
+	return s.width, s.height
+}
@@ -0,0 +1,107 @@
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackStats(t *testing.T) {
+	newPacket := func(seq uint16, ts uint32) *rtp.Packet {
+		return &rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: seq, Timestamp: ts},
+			Payload: make([]byte, 1000),
+		}
+	}
+
+	t.Run("loss", func(t *testing.T) {
+		s := newTrackStats("video", 90000)
+		start := time.Unix(0, 0)
+		for i, seq := range []uint16{10, 11, 13, 14} {
+			now := start.Add(time.Duration(i) * time.Second / 30)
+			s.update(newPacket(seq, uint32(i*3000)), now)
+		}
+		stats := s.stats()
+		require.Equal(t, uint64(4), stats.PacketsReceived)
+		require.Equal(t, int64(1), stats.PacketsLost)
+		require.Equal(t, 0.2, stats.FractionLost)
+		require.InDelta(t, 0, stats.JitterMs, 0.01)
+	})
+	t.Run("seqWrap", func(t *testing.T) {
+		s := newTrackStats("video", 90000)
+		now := time.Unix(0, 0)
+		for _, seq := range []uint16{65534, 65535, 0, 2} {
+			s.update(newPacket(seq, 0), now)
+		}
+		stats := s.stats()
+		require.Equal(t, int64(1), stats.PacketsLost)
+	})
+	t.Run("reordered", func(t *testing.T) {
+		s := newTrackStats("video", 90000)
+		now := time.Unix(0, 0)
+		for _, seq := range []uint16{1, 3, 2} {
+			s.update(newPacket(seq, 0), now)
+		}
+		stats := s.stats()
+		require.Equal(t, int64(0), stats.PacketsLost)
+	})
+	t.Run("jitter", func(t *testing.T) {
+		s := newTrackStats("audio", 1000)
+		start := time.Unix(0, 0)
+		s.update(newPacket(1, 0), start)
+		// Arrives 16ms late.
+		s.update(newPacket(2, 20), start.Add(36*time.Millisecond))
+		require.Equal(t, float64(1), s.stats().JitterMs)
+	})
+	t.Run("bitrate", func(t *testing.T) {
+		s := newTrackStats("video", 90000)
+		start := time.Unix(0, 0)
+		s.update(newPacket(1, 0), start)
+		s.update(newPacket(2, 0), start.Add(time.Second))
+		require.Equal(t, float64(0), s.stats().BitrateKbps)
+		s.update(newPacket(3, 0), start.Add(2*time.Second))
+		require.Equal(t, float64(12), s.stats().BitrateKbps)
+	})
+	t.Run("frameRate", func(t *testing.T) {
+		s := newTrackStats("video", 90000)
+		start := time.Unix(0, 0)
+		for i := 0; i <= 60; i++ {
+			pkt := newPacket(uint16(i), 0)
+			// Two packets per frame.
+			pkt.Marker = i%2 == 1
+			s.update(pkt, start.Add(time.Duration(i)*time.Second/30))
+		}
+		require.Equal(t, float64(15), s.stats().FrameRate)
+	})
+	t.Run("resolution", func(t *testing.T) {
+		sps352x288 := []byte{
+			0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+			0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+			0x00, 0x03, 0x00, 0x3d, 0x08,
+		}
+		sps1280x720 := []byte{
+			0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50,
+			0x05, 0xbb, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+			0x00, 0x80, 0x00, 0x00, 0x1e, 0x07, 0x8c, 0x18,
+			0xcb,
+		}
+		sps := sps352x288
+		s := newTrackStats("video", 90000)
+		s.sps = func() []byte { return sps }
+
+		stats := s.stats()
+		require.Equal(t, 352, stats.Width)
+		require.Equal(t, 288, stats.Height)
+
+		sps = sps1280x720
+		stats = s.stats()
+		require.Equal(t, 1280, stats.Width)
+		require.Equal(t, 720, stats.Height)
+
+		sps = []byte{0x67}
+		stats = s.stats()
+		require.Equal(t, 0, stats.Width)
+	})
+}
@@ -0,0 +1,447 @@
+package video
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/h264"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+type webrtcPathManager interface {
+	streamByName(name string) (*stream, error)
+}
+
+// webrtcServer serves the H264 track of the
+// paths to WebRTC clients using WHEP signaling.
+// https://datatracker.ietf.org/doc/draft-murillo-whep
+type webrtcServer struct {
+	wg              *sync.WaitGroup
+	logger          log.ILogger
+	address         string
+	additionalHosts []string
+	pathManager     webrtcPathManager
+
+	api *webrtc.API
+
+	mu       sync.Mutex
+	sessions map[string]*webrtcSession
+}
+
+func newWebRTCServer(
+	wg *sync.WaitGroup,
+	logger log.ILogger,
+	address string,
+	additionalHosts []string,
+	pathManager webrtcPathManager,
+) *webrtcServer {
+	return &webrtcServer{
+		wg:              wg,
+		logger:          logger,
+		address:         address,
+		additionalHosts: additionalHosts,
+		pathManager:     pathManager,
+		sessions:        make(map[string]*webrtcSession),
+	}
+}
+
+func (s *webrtcServer) start(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return err
+	}
+	s.logger.Log(log.Entry{
+		Level: log.LevelInfo,
+		Src:   "app",
+		Msg:   fmt.Sprintf("WebRTC: listener opened on %v (UDP)", s.address),
+	})
+
+	var settings webrtc.SettingEngine
+	settings.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	settings.SetNetworkTypes([]webrtc.NetworkType{
+		webrtc.NetworkTypeUDP4,
+		webrtc.NetworkTypeUDP6,
+	})
+	if len(s.additionalHosts) != 0 {
+		settings.SetNAT1To1IPs(s.additionalHosts, webrtc.ICECandidateTypeHost)
+	}
+
+	var mediaEngine webrtc.MediaEngine
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		conn.Close()
+		return fmt.Errorf("register codecs: %w", err)
+	}
+
+	var interceptors interceptor.Registry
+	if err := webrtc.RegisterDefaultInterceptors(&mediaEngine, &interceptors); err != nil {
+		conn.Close()
+		return fmt.Errorf("register interceptors: %w", err)
+	}
+
+	s.api = webrtc.NewAPI(
+		webrtc.WithSettingEngine(settings),
+		webrtc.WithMediaEngine(&mediaEngine),
+		webrtc.WithInterceptorRegistry(&interceptors),
+	)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+
+		s.mu.Lock()
+		sessions := s.sessions
+		s.sessions = make(map[string]*webrtcSession)
+		s.mu.Unlock()
+
+		for _, session := range sessions {
+			session.close()
+		}
+		conn.Close()
+	}()
+
+	return nil
+}
+
+// Maximum size of the SDP offer.
+const whepMaxOfferSize = 64 * 1024
+
+// Time allowed for ICE candidate gathering.
+const webrtcGatherTimeout = 5 * time.Second
+
+// HandleRequest handles WHEP requests.
+//
+// POST   /whep/<path>         SDP offer, responds with the answer.
+// DELETE /whep/<path>/<id>    Stops the session.
+func (s *webrtcServer) HandleRequest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Remove leading prefix "/whep/"
+		if len(r.URL.Path) <= 6 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		pa := r.URL.Path[6:]
+
+		switch r.Method {
+		case http.MethodPost:
+			s.handleOffer(w, r, pa)
+
+		case http.MethodDelete:
+			s.handleDelete(w, pa)
+
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			// Trickle ICE using PATCH is not supported.
+			w.Header().Set("Allow", "POST, DELETE, OPTIONS")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *webrtcServer) handleOffer(w http.ResponseWriter, r *http.Request, pathName string) {
+	if r.Header.Get("Content-Type") != "application/sdp" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, whepMaxOfferSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	stream, err := s.pathManager.streamByName(pathName)
+	if err != nil {
+		if errors.Is(err, ErrPathNotExist) || errors.Is(err, ErrPathNoOnePublishing) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, err := s.newSession(pathName, stream, string(offer))
+	if err != nil {
+		s.logf(pathName, log.LevelError, "WebRTC: %v", err)
+		if errors.Is(err, ErrWebRTCNoH264Track) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.Error(w, "could not create session", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/whep/"+pathName+"/"+session.id)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, session.answer) //nolint:errcheck
+}
+
+func (s *webrtcServer) handleDelete(w http.ResponseWriter, pa string) {
+	i := strings.LastIndexByte(pa, '/')
+	if i == -1 {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	pathName, id := pa[:i], pa[i+1:]
+
+	s.mu.Lock()
+	session, exist := s.sessions[id]
+	s.mu.Unlock()
+
+	if !exist || session.pathName != pathName {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	session.close()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *webrtcServer) logf(pathName string, level log.Level, format string, a ...interface{}) {
+	s.logger.Log(log.Entry{
+		Level: level,
+		Src:   "app",
+		Msg:   fmt.Sprintf("%v: %v", pathName, fmt.Sprintf(format, a...)),
+	})
+}
+
+// ErrWebRTCNoH264Track the path doesn't have a H264 track.
+var ErrWebRTCNoH264Track = errors.New("path doesn't have a H264 track")
+
+func findH264Track(tracks gortsplib.Tracks) (*gortsplib.TrackH264, int, error) {
+	for i, track := range tracks {
+		if t, ok := track.(*gortsplib.TrackH264); ok {
+			return t, i, nil
+		}
+	}
+	return nil, 0, ErrWebRTCNoH264Track
+}
+
+// h264FmtpLine returns the format parameters of the SPS.
+// Packetization mode 1 is required by browsers.
+func h264FmtpLine(sps []byte) string {
+	profileLevelID := "42e01f"
+	if len(sps) >= 4 {
+		profileLevelID = hex.EncodeToString(sps[1:4])
+	}
+	return "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID
+}
+
+func newWebRTCSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *webrtcServer) newSession( //nolint:funlen
+	pathName string,
+	stream *stream,
+	offer string,
+) (*webrtcSession, error) {
+	h264Track, h264TrackID, err := findH264Track(stream.tracks())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newWebRTCSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, fmt.Errorf("new peer connection: %w", err)
+	}
+
+	session := &webrtcSession{
+		id:           id,
+		pathName:     pathName,
+		pc:           pc,
+		stream:       stream,
+		videoTrackID: h264TrackID,
+		onClose:      s.sessionClose,
+	}
+
+	session.videoTrack, err = webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: h264FmtpLine(h264Track.SafeSPS()),
+		},
+		"video",
+		"nvr",
+	)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("new track: %w", err)
+	}
+
+	sender, err := pc.AddTrack(session.videoTrack)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("add track: %w", err)
+	}
+
+	// Read incoming RTCP packets, this is
+	// required for the interceptors to work.
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("set remote description: %w", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("create answer: %w", err)
+	}
+
+	// Trickle ICE isn't supported, the answer must contain all candidates.
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("set local description: %w", err)
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(webrtcGatherTimeout):
+		pc.Close()
+		return nil, ErrWebRTCGatherTimeout
+	}
+	session.answer = pc.LocalDescription().SDP
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// Samples written before this are dropped.
+			stream.readerStart(session)
+		case webrtc.PeerConnectionStateFailed,
+			webrtc.PeerConnectionStateDisconnected,
+			webrtc.PeerConnectionStateClosed:
+			session.close()
+		}
+	})
+
+	s.mu.Lock()
+	s.sessions[id] = session
+	s.mu.Unlock()
+
+	stream.readerAdd(session)
+
+	return session, nil
+}
+
+// ErrWebRTCGatherTimeout ICE candidate gathering timed out.
+var ErrWebRTCGatherTimeout = errors.New("ICE candidate gathering timed out")
+
+func (s *webrtcServer) sessionClose(session *webrtcSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session.id)
+}
+
+// numSessions returns the number of active sessions.
+func (s *webrtcServer) numSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+type webrtcSession struct {
+	id       string
+	pathName string
+	answer   string
+
+	pc           *webrtc.PeerConnection
+	stream       *stream
+	videoTrack   *webrtc.TrackLocalStaticSample
+	videoTrackID int
+	onClose      func(*webrtcSession)
+
+	// Only accessed by the stream.
+	started     bool
+	idrReceived bool
+	prevPTS     time.Duration
+
+	closeOnce sync.Once
+}
+
+// onData is called by the stream.
+func (s *webrtcSession) onData(dat *data) {
+	if !s.started || dat.trackID != s.videoTrackID || dat.h264NALUs == nil {
+		return
+	}
+
+	// The decoder can't start before the first IDR.
+	if !s.idrReceived {
+		if !h264.IDRPresent(dat.h264NALUs) {
+			return
+		}
+		s.idrReceived = true
+		s.prevPTS = dat.pts
+	}
+
+	// The duration of the previous sample is used since the
+	// duration of the current sample isn't known yet.
+	duration := dat.pts - s.prevPTS
+	if duration < 0 {
+		duration = 0
+	}
+	s.prevPTS = dat.pts
+
+	buf, err := h264.AnnexBMarshal(dat.h264NALUs)
+	if err != nil {
+		return
+	}
+
+	// Errors are returned if the track isn't connected yet.
+	s.videoTrack.WriteSample(media.Sample{ //nolint:errcheck
+		Data:     buf,
+		Duration: duration,
+	})
+}
+
+// start is called by the stream when the peer connection is connected.
+func (s *webrtcSession) start() {
+	s.started = true
+}
+
+// close is called by the stream, the server or the peer connection.
+func (s *webrtcSession) close() {
+	s.closeOnce.Do(func() {
+		s.stream.readerRemove(s)
+		s.onClose(s)
+		go s.pc.Close()
+	})
+}
@@ -0,0 +1,174 @@
+package video
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nvr/pkg/log"
+	"nvr/pkg/video/gortsplib"
+	"nvr/pkg/video/gortsplib/pkg/mpeg4audio"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+type stubWebRTCPathManager struct {
+	stream *stream
+}
+
+func (m stubWebRTCPathManager) streamByName(name string) (*stream, error) {
+	if name != "mypath" {
+		return nil, ErrPathNotExist
+	}
+	if m.stream == nil {
+		return nil, ErrPathNoOnePublishing
+	}
+	return m.stream, nil
+}
+
+func newTestOffer(t *testing.T) string {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	_, err = pc.AddTransceiverFromKind(
+		webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly},
+	)
+	require.NoError(t, err)
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	return offer.SDP
+}
+
+func TestWebRTCServer(t *testing.T) { //nolint:funlen
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	tracks := gortsplib.Tracks{
+		&gortsplib.TrackMPEG4Audio{
+			PayloadType: 97,
+			Config:      &mpeg4audio.Config{SampleRate: 44100, ChannelCount: 2},
+		},
+		&gortsplib.TrackH264{PayloadType: 96, SPS: sps, PPS: []byte{0x68, 0xee, 0x3c, 0x80}},
+	}
+	stream := newStream(tracks, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	s := newWebRTCServer(
+		&wg,
+		log.NewDummyLogger(),
+		"127.0.0.1:0",
+		nil,
+		stubWebRTCPathManager{stream: stream},
+	)
+	require.NoError(t, s.start(ctx))
+
+	post := func(path, contentType, body string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		s.HandleRequest()(w, r)
+		return w.Result()
+	}
+	del := func(path string) int {
+		w := httptest.NewRecorder()
+		s.HandleRequest()(w, httptest.NewRequest(http.MethodDelete, path, nil))
+		return w.Code
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		res := post("/whep/mypath", "application/sdp", newTestOffer(t))
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		require.Equal(t, "application/sdp", res.Header.Get("Content-Type"))
+
+		answer, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(answer), "H264")
+		require.Contains(t, string(answer), "a=sendonly")
+
+		location := res.Header.Get("Location")
+		require.True(t, strings.HasPrefix(location, "/whep/mypath/"))
+		require.Equal(t, 1, s.numSessions())
+
+		session := s.sessions[location[13:]]
+		require.Contains(t, stream.readers, session)
+
+		// Data is dropped before the peer connection is connected.
+		session.onData(&data{trackID: 1, pts: 2, h264NALUs: [][]byte{sps, {0x65, 0x88}}})
+		require.False(t, session.idrReceived)
+		stream.readerStart(session)
+
+		// Non IDR before the first IDR is dropped.
+		session.onData(&data{trackID: 1, pts: 0, h264NALUs: [][]byte{{0x41, 0x9a}}})
+		require.False(t, session.idrReceived)
+		session.onData(&data{trackID: 0, pts: 1})
+		require.False(t, session.idrReceived)
+		session.onData(&data{trackID: 1, pts: 2, h264NALUs: [][]byte{sps, {0x65, 0x88}}})
+		require.True(t, session.idrReceived)
+		session.onData(&data{trackID: 1, pts: 3, h264NALUs: [][]byte{{0x41, 0x9a}}})
+		require.Equal(t, time.Duration(3), session.prevPTS)
+
+		require.Equal(t, http.StatusNotFound, del("/whep/otherpath/"+location[13:]))
+		require.Equal(t, http.StatusOK, del(location))
+		require.Equal(t, http.StatusNotFound, del(location))
+		require.Equal(t, 0, s.numSessions())
+		require.NotContains(t, stream.readers, session)
+	})
+	t.Run("streamClose", func(t *testing.T) {
+		stream := newStream(tracks, nil)
+		s.pathManager = stubWebRTCPathManager{stream: stream}
+		defer func() { s.pathManager = stubWebRTCPathManager{stream: stream} }()
+
+		res := post("/whep/mypath", "application/sdp", newTestOffer(t))
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		require.Equal(t, 1, s.numSessions())
+
+		stream.close()
+		require.Equal(t, 0, s.numSessions())
+	})
+	t.Run("errors", func(t *testing.T) {
+		offer := newTestOffer(t)
+		require.Equal(t, http.StatusUnsupportedMediaType,
+			post("/whep/mypath", "text/plain", offer).StatusCode)
+		require.Equal(t, http.StatusNotFound,
+			post("/whep/nil", "application/sdp", offer).StatusCode)
+		require.Equal(t, http.StatusBadRequest,
+			post("/whep/mypath", "application/sdp", "invalid").StatusCode)
+
+		w := httptest.NewRecorder()
+		s.HandleRequest()(w, httptest.NewRequest(http.MethodPatch, "/whep/mypath/x", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		s.pathManager = stubWebRTCPathManager{}
+		require.Equal(t, http.StatusNotFound,
+			post("/whep/mypath", "application/sdp", offer).StatusCode)
+
+		s.pathManager = stubWebRTCPathManager{stream: newStream(tracks[:1], nil)}
+		require.Equal(t, http.StatusNotFound,
+			post("/whep/mypath", "application/sdp", offer).StatusCode)
+	})
+}
+
+func TestH264FmtpLine(t *testing.T) {
+	require.Equal(t,
+		"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64000c",
+		h264FmtpLine([]byte{0x67, 0x64, 0x00, 0x0c, 0xac}))
+	require.Equal(t,
+		"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		h264FmtpLine(nil))
+}
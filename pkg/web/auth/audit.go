@@ -0,0 +1,177 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Audit event types.
+const (
+	AuditLogin       = "login"
+	AuditLoginFailed = "loginFailed"
+	AuditLockout     = "lockout"
+	AuditAPI         = "api"
+)
+
+// AuditEvent audit log entry.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	Method   string    `json:"method,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// AuditQuery audit log query. Zero values match all events.
+type AuditQuery struct {
+	Types    []string
+	Username string
+	Start    time.Time
+	End      time.Time
+	Limit    int
+}
+
+func (q AuditQuery) match(e AuditEvent) bool {
+	if len(q.Types) != 0 && !containsString(q.Types, e.Type) {
+		return false
+	}
+	if q.Username != "" && e.Username != q.Username {
+		return false
+	}
+	if !q.Start.IsZero() && e.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && !e.Time.Before(q.End) {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// auditMaxSize the log is rotated when it exceeds
+// this size, one rotated file is kept.
+const auditMaxSize = 10 * 1024 * 1024
+
+// AuditLog persistent JSON lines log of security relevant events.
+type AuditLog struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// NewAuditLog creates a audit log that's stored at path.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path, maxSize: auditMaxSize}
+}
+
+// Add appends the event, the time is set if it's zero.
+func (l *AuditLog) Add(e AuditEvent) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, err := os.Stat(l.path); err == nil && info.Size() > l.maxSize {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Query returns the matching events, newest first.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []AuditEvent
+	for _, path := range []string{l.path + ".1", l.path} {
+		fileEvents, err := readAuditFile(path, q)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fileEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+	return events, nil
+}
+
+func readAuditFile(path string, q AuditQuery) ([]AuditEvent, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // Skip partially written lines.
+		}
+		if q.match(e) {
+			events = append(events, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %v: %w", path, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	l := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+
+	events, err := l.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	start := time.Unix(1000, 0).UTC()
+	add := func(offset int, typ, username string) {
+		require.NoError(t, l.Add(AuditEvent{
+			Time:     start.Add(time.Duration(offset) * time.Second),
+			Type:     typ,
+			Username: username,
+		}))
+	}
+	add(0, AuditLoginFailed, "a")
+	add(1, AuditLogin, "a")
+	add(2, AuditAPI, "b")
+	add(3, AuditLogin, "b")
+
+	cases := map[string]struct {
+		query    AuditQuery
+		expected []int
+	}{
+		"all":      {AuditQuery{}, []int{3, 2, 1, 0}},
+		"types":    {AuditQuery{Types: []string{AuditLogin}}, []int{3, 1}},
+		"username": {AuditQuery{Username: "a"}, []int{1, 0}},
+		"start":    {AuditQuery{Start: start.Add(2 * time.Second)}, []int{3, 2}},
+		"end":      {AuditQuery{End: start.Add(2 * time.Second)}, []int{1, 0}},
+		"limit":    {AuditQuery{Limit: 1}, []int{3}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			events, err := l.Query(tc.query)
+			require.NoError(t, err)
+
+			var offsets []int
+			for _, e := range events {
+				offsets = append(offsets, int(e.Time.Sub(start).Seconds()))
+			}
+			require.Equal(t, tc.expected, offsets)
+		})
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := NewAuditLog(path)
+	l.maxSize = 1
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Add(AuditEvent{
+			Time: time.Unix(int64(i), 0),
+			Type: AuditLogin,
+		}))
+	}
+
+	// Only one rotated file is kept.
+	events, err := l.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, int64(2), events[0].Time.Unix())
+
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(rotated), "\n"))
+}
+
+func TestAuditLogPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte(`{"type":"login"}`+"\n"+`{"ty`), 0o600))
+
+	events, err := NewAuditLog(path).Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
@@ -33,13 +33,26 @@ type Account struct {
 	Password []byte `json:"password"` // Hashed password.
 	IsAdmin  bool   `json:"isAdmin"`
 	Token    string `json:"-"` // CSRF token.
+
+	Role     Role     `json:"role,omitempty"`
+	Monitors []string `json:"monitors,omitempty"` // Empty allows all monitors.
+	Groups   []string `json:"groups,omitempty"`   // Allows the monitors in the groups.
+
+	// Two-factor authentication is enabled if the secret is set.
+	TOTPSecret    string   `json:"totpSecret,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // Hashed.
 }
 
 // AccountObfuscated Account without sensitive information.
 type AccountObfuscated struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"isAdmin"`
+	ID       string   `json:"id"`
+	Username string   `json:"username"`
+	IsAdmin  bool     `json:"isAdmin"`
+	Role     Role     `json:"role,omitempty"`
+	Monitors []string `json:"monitors,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+
+	TOTPEnabled bool `json:"totpEnabled"`
 }
 
 // ValidateResponse ValidateRequest response.
@@ -50,10 +63,13 @@ type ValidateResponse struct {
 
 // SetUserRequest set user details request.
 type SetUserRequest struct {
-	ID            string `json:"id"`
-	Username      string `json:"username"`
-	PlainPassword string `json:"plainPassword,omitempty"`
-	IsAdmin       bool   `json:"isAdmin"`
+	ID            string   `json:"id"`
+	Username      string   `json:"username"`
+	PlainPassword string   `json:"plainPassword,omitempty"`
+	IsAdmin       bool     `json:"isAdmin"`
+	Role          Role     `json:"role,omitempty"`
+	Monitors      []string `json:"monitors,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
 }
 
 // NewAuthenticatorFunc function to create authenticator.
@@ -74,6 +90,14 @@ type Authenticator interface {
 	// UserDelete deletes a user by id.
 	UserDelete(string) error
 
+	// TOTPEnroll starts the two-factor enrollment of a user by id.
+	TOTPEnroll(string) (TOTPEnrollment, error)
+	// TOTPConfirm enables two-factor authentication if the code
+	// matches the enrollment. Returns the recovery codes.
+	TOTPConfirm(id string, code string) ([]string, error)
+	// TOTPDisable disables two-factor authentication for a user by id.
+	TOTPDisable(string) error
+
 	// Handler wrappers.
 	// User blocks unauthenticated requests.
 	User(http.Handler) http.Handler
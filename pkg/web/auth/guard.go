@@ -0,0 +1,314 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"nvr/pkg/log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Login throttling limits. Clients are locked out when the
+// failures within the window reach the limit of the IP or
+// the account. The account limit is higher to make it harder
+// to lock out users on purpose from a few addresses.
+const (
+	throttleWindow     = 15 * time.Minute
+	ipMaxFailures      = 10
+	accountMaxFailures = 20
+	lockoutDuration    = 15 * time.Minute
+
+	// A new login is logged after this time without requests.
+	loginIdleTimeout = time.Hour
+)
+
+type failures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// Guard throttles failed logins and writes the audit log.
+type Guard struct {
+	audit  *AuditLog
+	logger *log.Logger
+
+	ips      map[string]*failures
+	accounts map[string]*failures
+	lastSeen map[string]time.Time // By username and IP.
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewGuard creates a guard.
+func NewGuard(audit *AuditLog, logger *log.Logger) *Guard {
+	return &Guard{
+		audit:    audit,
+		logger:   logger,
+		ips:      make(map[string]*failures),
+		accounts: make(map[string]*failures),
+		lastSeen: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// ClientIP returns the "X-Real-Ip" header that's resolved from
+// trusted proxies by web.Proxy, or the host of the remote address.
+func ClientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-Ip"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestUsername returns the username of the basic auth credentials.
+func requestUsername(r *http.Request) string {
+	if _, ok := bearerToken(r); ok {
+		return "token"
+	}
+	username, _, _ := r.BasicAuth()
+	return strings.ToLower(username)
+}
+
+// accountKey returns the account that the failures of the request
+// count against. Tokens are only throttled by IP, otherwise invalid
+// tokens would lock out all the valid tokens under a shared key.
+func accountKey(r *http.Request) string {
+	if _, ok := bearerToken(r); ok {
+		return ""
+	}
+	return requestUsername(r)
+}
+
+func (g *Guard) record(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = g.now()
+	}
+	if err := g.audit.Add(e); err != nil {
+		g.logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "auth",
+			Msg:   fmt.Sprintf("audit log: %v", err),
+		})
+	}
+}
+
+// Locked returns the remaining time if the IP or account of the request is locked out.
+func (g *Guard) Locked(r *http.Request) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var remaining time.Duration
+	check := func(f *failures) {
+		if f != nil && now.Before(f.lockedUntil) && f.lockedUntil.Sub(now) > remaining {
+			remaining = f.lockedUntil.Sub(now)
+		}
+	}
+	check(g.ips[ClientIP(r)])
+	if account := accountKey(r); account != "" {
+		check(g.accounts[account])
+	}
+	return remaining, remaining > 0
+}
+
+// Failed records a failed login.
+func (g *Guard) Failed(r *http.Request) {
+	ip := ClientIP(r)
+	username := requestUsername(r)
+	g.record(AuditEvent{
+		Type:     AuditLoginFailed,
+		Username: username,
+		IP:       ip,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+	})
+
+	g.mu.Lock()
+	now := g.now()
+	g.prune(now)
+	var lockouts []string
+	if g.fail(g.ips, ip, ipMaxFailures, now) {
+		lockouts = append(lockouts, "ip")
+	}
+	if account := accountKey(r); account != "" && g.fail(g.accounts, account, accountMaxFailures, now) {
+		lockouts = append(lockouts, "account")
+	}
+	g.mu.Unlock()
+
+	for _, kind := range lockouts {
+		g.record(AuditEvent{
+			Type:     AuditLockout,
+			Username: username,
+			IP:       ip,
+			Detail:   fmt.Sprintf("%v locked for %v", kind, lockoutDuration),
+		})
+	}
+}
+
+// fail returns true if the key was locked out.
+func (g *Guard) fail(m map[string]*failures, key string, max int, now time.Time) bool {
+	f, exist := m[key]
+	if !exist {
+		f = &failures{first: now}
+		m[key] = f
+	}
+	if now.Sub(f.first) > throttleWindow {
+		f.count = 0
+		f.first = now
+	}
+	f.count++
+	if f.count < max {
+		return false
+	}
+	f.count = 0
+	f.lockedUntil = now.Add(lockoutDuration)
+	return true
+}
+
+// prune removes expired entries. Must be called with lock held.
+func (g *Guard) prune(now time.Time) {
+	for _, m := range []map[string]*failures{g.ips, g.accounts} {
+		for key, f := range m {
+			if now.Sub(f.first) > throttleWindow && now.After(f.lockedUntil) {
+				delete(m, key)
+			}
+		}
+	}
+	for key, t := range g.lastSeen {
+		if now.Sub(t) > loginIdleTimeout {
+			delete(g.lastSeen, key)
+		}
+	}
+}
+
+// Succeeded records a authenticated request. The failures of the
+// IP and account are reset and a login is logged if the user
+// didn't make any requests from the IP recently.
+func (g *Guard) Succeeded(r *http.Request, username string) {
+	ip := ClientIP(r)
+	key := username + " " + ip
+
+	g.mu.Lock()
+	now := g.now()
+	delete(g.ips, ip)
+	delete(g.accounts, accountKey(r))
+	last, seen := g.lastSeen[key]
+	g.lastSeen[key] = now
+	newLogin := !seen || now.Sub(last) > loginIdleTimeout
+	if newLogin {
+		g.prune(now)
+	}
+	g.mu.Unlock()
+
+	if newLogin {
+		g.record(AuditEvent{Type: AuditLogin, Username: username, IP: ip})
+	}
+}
+
+// WithGuard returns a authenticator that blocks locked out clients,
+// records failed logins and writes requests wrapped by CSRF,
+// the state changing API calls, to the audit log.
+func WithGuard(a Authenticator, g *Guard) Authenticator {
+	return &guardAuthenticator{Authenticator: a, guard: g}
+}
+
+type guardAuthenticator struct {
+	Authenticator
+	guard *Guard
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (a *guardAuthenticator) wrap(next http.Handler, fallback func(http.Handler) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if remaining, locked := a.guard.Locked(r); locked {
+			w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+			http.Error(w, "too many failed logins, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		// The next handler receives the original writer
+		// to not break websockets and server-sent events.
+		authenticated := false
+		handler := fallback(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			authenticated = true
+			a.guard.Succeeded(r, a.ValidateRequest(r).User.Username)
+			next.ServeHTTP(w, r)
+		}))
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+
+		// Valid users are also unauthorized on admin pages.
+		if !authenticated && rec.status == http.StatusUnauthorized &&
+			r.Header.Get("Authorization") != "" && !a.ValidateRequest(r).IsValid {
+			a.guard.Failed(r)
+		}
+	})
+}
+
+func (a *guardAuthenticator) User(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.User)
+}
+
+func (a *guardAuthenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.Admin)
+}
+
+func (a *guardAuthenticator) CSRF(next http.Handler) http.Handler {
+	handler := a.Authenticator.CSRF(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		a.guard.record(AuditEvent{
+			Type:     AuditAPI,
+			Username: a.ValidateRequest(r).User.Username,
+			IP:       ClientIP(r),
+			Method:   r.Method,
+			Path:     r.URL.RequestURI(),
+			Status:   rec.status,
+		})
+	})
+}
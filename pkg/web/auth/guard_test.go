@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type basicStubAuthenticator struct {
+	Authenticator
+}
+
+// Users "admin" and "user" with the password "pass" and the token "good".
+func (basicStubAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	if token, ok := bearerToken(r); ok {
+		if token != "good" {
+			return ValidateResponse{}
+		}
+		return ValidateResponse{IsValid: true, User: Account{Username: "user"}}
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || (username != "admin" && username != "user") || password != "pass" {
+		return ValidateResponse{}
+	}
+	return ValidateResponse{
+		IsValid: true,
+		User:    Account{Username: username, IsAdmin: username == "admin"},
+	}
+}
+
+func (a basicStubAuthenticator) User(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.ValidateRequest(r).IsValid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a basicStubAuthenticator) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if res := a.ValidateRequest(r); !res.IsValid || !res.User.IsAdmin {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a basicStubAuthenticator) CSRF(next http.Handler) http.Handler {
+	return next
+}
+
+func newTestGuard(t *testing.T) (*Guard, *AuditLog, *time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logger := &log.Logger{Ctx: ctx}
+
+	audit := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	g := NewGuard(audit, logger)
+	now := time.Unix(1000, 0)
+	g.now = func() time.Time { return now }
+	return g, audit, &now
+}
+
+func TestGuard(t *testing.T) {
+	g, audit, now := newTestGuard(t)
+	a := WithGuard(basicStubAuthenticator{}, g)
+	handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(username, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "1.2.3.4:5678"
+		r.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, request("admin", "pass").Code)
+	for i := 0; i < ipMaxFailures-1; i++ {
+		require.Equal(t, http.StatusUnauthorized, request("admin", "x").Code)
+	}
+
+	// A successful login resets the counter.
+	require.Equal(t, http.StatusOK, request("admin", "pass").Code)
+	for i := 0; i < ipMaxFailures; i++ {
+		require.Equal(t, http.StatusUnauthorized, request("admin", "x").Code)
+	}
+
+	w := request("admin", "pass")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "901", w.Header().Get("Retry-After"))
+
+	*now = now.Add(lockoutDuration)
+	require.Equal(t, http.StatusOK, request("admin", "pass").Code)
+
+	// Repeated successful requests are logged as a single login.
+	logins, err := audit.Query(AuditQuery{Types: []string{AuditLogin}})
+	require.NoError(t, err)
+	require.Len(t, logins, 1)
+	require.Equal(t, "admin", logins[0].Username)
+	require.Equal(t, "1.2.3.4", logins[0].IP)
+
+	lockouts, err := audit.Query(AuditQuery{Types: []string{AuditLockout}})
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1)
+
+	failed, err := audit.Query(AuditQuery{Types: []string{AuditLoginFailed}})
+	require.NoError(t, err)
+	require.Len(t, failed, 2*ipMaxFailures-1)
+}
+
+func TestGuardNonAdmin(t *testing.T) {
+	g, audit, _ := newTestGuard(t)
+	a := WithGuard(basicStubAuthenticator{}, g)
+	handler := a.Admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(username string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "1.2.3.4:5678"
+		r.SetBasicAuth(username, "pass")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Valid users without admin access aren't failed logins.
+	for i := 0; i < accountMaxFailures+5; i++ {
+		require.Equal(t, http.StatusUnauthorized, request("user").Code)
+	}
+	require.Equal(t, http.StatusOK, request("admin").Code)
+
+	failed, err := audit.Query(AuditQuery{Types: []string{AuditLoginFailed}})
+	require.NoError(t, err)
+	require.Empty(t, failed)
+}
+
+func TestGuardTokens(t *testing.T) {
+	g, _, _ := newTestGuard(t)
+	a := WithGuard(basicStubAuthenticator{}, g)
+	handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(ip, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":5678"
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Enough failures to lock a account without locking the IPs.
+	for i := 0; i < accountMaxFailures; i++ {
+		ip := "1.1.1." + strconv.Itoa(i%3)
+		require.Equal(t, http.StatusUnauthorized, request(ip, "bad"+strconv.Itoa(i)).Code)
+	}
+
+	// Invalid tokens don't lock out valid tokens from other IPs.
+	require.Equal(t, http.StatusOK, request("2.2.2.2", "good").Code)
+}
+
+func TestGuardCSRF(t *testing.T) {
+	g, audit, _ := newTestGuard(t)
+	a := WithGuard(basicStubAuthenticator{}, g)
+	handler := a.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+
+	r := httptest.NewRequest(http.MethodDelete, "/api/monitor/delete?id=1", nil)
+	r.RemoteAddr = "5.6.7.8:1234"
+	r.SetBasicAuth("admin", "pass")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	events, err := audit.Query(AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	e := events[0]
+	e.Time = time.Time{}
+	require.Equal(t, AuditEvent{
+		Type:     AuditAPI,
+		Username: "admin",
+		IP:       "5.6.7.8",
+		Method:   http.MethodDelete,
+		Path:     "/api/monitor/delete?id=1",
+		Status:   http.StatusBadRequest,
+	}, e)
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+	require.Equal(t, "1.2.3.4", ClientIP(r))
+
+	// The header is resolved by web.Proxy.
+	r.Header.Set("X-Real-Ip", "5.6.7.8")
+	require.Equal(t, "5.6.7.8", ClientIP(r))
+}
@@ -0,0 +1,240 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role limits what a non-admin account can do.
+// Admins are never limited by their role.
+type Role string
+
+// Roles.
+const (
+	// RoleLive only allows live view.
+	RoleLive Role = "live"
+
+	// RoleRecordings allows live view, recordings and events.
+	RoleRecordings Role = "recordings"
+
+	// RoleFull allows everything a non-admin account can do,
+	// including two-way audio. Accounts without a role are full.
+	RoleFull Role = "full"
+)
+
+// ErrInvalidRole invalid role.
+var ErrInvalidRole = errors.New("invalid role")
+
+// Validate returns ErrInvalidRole if the role is unknown.
+func (r Role) Validate() error {
+	switch r {
+	case "", RoleLive, RoleRecordings, RoleFull:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidRole, r)
+}
+
+// allows returns false if the role denies the request.
+// Requests that require admin are blocked by the Admin
+// middleware, only the user level requests are checked.
+func (r Role) allows(req *http.Request) bool {
+	if r == "" || r == RoleFull {
+		return true
+	}
+	if isTalk(req) {
+		return false
+	}
+	switch RequiredScope(req) {
+	case ScopeRecordingsRead, ScopeRecordingsWrite, ScopeEventsRead:
+		return r == RoleRecordings
+	}
+	return true
+}
+
+func isTalk(r *http.Request) bool {
+	return r.URL.Path == "/api/monitor/talk" || r.URL.Path == "/api/v1/monitor/talk"
+}
+
+// RoleAllows returns true if the role of the account allows the request.
+func (a Account) RoleAllows(r *http.Request) bool {
+	return a.IsAdmin || a.Role.allows(r)
+}
+
+// Limited returns true if the account is limited to specific monitors.
+func (a Account) Limited() bool {
+	return !a.IsAdmin && (len(a.Monitors) != 0 || len(a.Groups) != 0)
+}
+
+// MonitorAllowed returns true if the account can access the monitor.
+// Sub stream IDs are allowed if the main stream is allowed. The
+// monitors of the groups must be added by WithRoles.
+func (a Account) MonitorAllowed(id string) bool {
+	if !a.Limited() || id == "" {
+		return true
+	}
+	for _, m := range a.Monitors {
+		if id == m || id == m+"_sub" {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestMonitors returns the IDs of the monitors referenced in
+// the path or the "id" and "monitor" query parameters of the request.
+// The "monitors" list parameter is not included.
+func RequestMonitors(r *http.Request) []string {
+	path := unversioned(r.URL.Path)
+	query := r.URL.Query()
+
+	var ids []string
+	add := func(id string) {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	firstElem := func(p string) string {
+		id, _, _ := strings.Cut(p, "/")
+		return id
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/hls/"):
+		add(firstElem(strings.TrimPrefix(path, "/hls/")))
+	case strings.HasPrefix(path, "/whep/"):
+		add(firstElem(strings.TrimPrefix(path, "/whep/")))
+	case strings.HasPrefix(path, "/api/monitor/"):
+		if p := strings.TrimPrefix(path, "/api/monitor/"); strings.Contains(p, "/") {
+			add(firstElem(p))
+		} else {
+			// "/api/monitor/stats?id=x"
+			add(query.Get("id"))
+		}
+	case strings.HasPrefix(path, "/api/recording/"):
+		// "/api/recording/<action>/<recording-id>".
+		p := strings.TrimPrefix(path, "/api/recording/")
+		if _, recID, found := strings.Cut(p, "/"); found && len(recID) > 20 {
+			add(recID[20:])
+		}
+	}
+	add(query.Get("monitor"))
+	return ids
+}
+
+// GroupMonitorsFunc returns the IDs of the monitors in the monitor group.
+type GroupMonitorsFunc func(groupID string) []string
+
+// WithRoles returns a authenticator that enforces the role and
+// monitors of the account on requests wrapped by User. The
+// "monitors" query parameter of requests from accounts limited to
+// specific monitors is replaced by the allowed monitors, handlers
+// that accept the parameter only return data from those monitors.
+// The monitors of the groups of the account are resolved on every
+// request, so changes to the groups apply immediately.
+func WithRoles(a Authenticator, groupMonitors GroupMonitorsFunc) Authenticator {
+	return &roleAuthenticator{Authenticator: a, groupMonitors: groupMonitors}
+}
+
+type roleAuthenticator struct {
+	Authenticator
+	groupMonitors GroupMonitorsFunc
+}
+
+// ValidateRequest adds the monitors of the groups to the account.
+func (a *roleAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	res := a.Authenticator.ValidateRequest(r)
+	if res.IsValid && len(res.User.Groups) != 0 && a.groupMonitors != nil {
+		res.User.Monitors = a.expandGroups(res.User)
+	}
+	return res
+}
+
+func (a *roleAuthenticator) expandGroups(account Account) []string {
+	monitors := append([]string{}, account.Monitors...)
+	seen := make(map[string]struct{}, len(monitors))
+	for _, id := range monitors {
+		seen[id] = struct{}{}
+	}
+	for _, groupID := range account.Groups {
+		for _, id := range a.groupMonitors(groupID) {
+			if _, exist := seen[id]; !exist {
+				seen[id] = struct{}{}
+				monitors = append(monitors, id)
+			}
+		}
+	}
+	return monitors
+}
+
+func (a *roleAuthenticator) User(next http.Handler) http.Handler {
+	return a.Authenticator.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := a.ValidateRequest(r)
+		if !res.IsValid || a.AuthDisabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		account := res.User
+		if !account.RoleAllows(r) {
+			http.Error(w, fmt.Sprintf("not allowed for role: %v", account.Role), http.StatusForbidden)
+			return
+		}
+		for _, id := range RequestMonitors(r) {
+			if !account.MonitorAllowed(id) {
+				http.Error(w, fmt.Sprintf("monitor not allowed: %v", id), http.StatusForbidden)
+				return
+			}
+		}
+		if !account.Limited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		monitors, ok := allowedMonitors(r.URL.Query().Get("monitors"), account)
+		if !ok {
+			http.Error(w, "monitors not allowed", http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		query.Set("monitors", monitors)
+		r2 := r.Clone(r.Context())
+		r2.URL.RawQuery = query.Encode()
+		next.ServeHTTP(w, r2)
+	}))
+}
+
+// allowedMonitors returns the requested monitors that the account can
+// access, or all the allowed monitors if none were requested. Returns
+// false if none of the requested monitors are allowed.
+func allowedMonitors(requested string, account Account) (string, bool) {
+	if requested == "" {
+		// Accounts limited to empty groups can't access any monitor.
+		return strings.Join(account.Monitors, ","), len(account.Monitors) != 0
+	}
+	var allowed []string
+	for _, id := range strings.Split(requested, ",") {
+		if account.MonitorAllowed(strings.TrimSpace(id)) {
+			allowed = append(allowed, id)
+		}
+	}
+	if len(allowed) == 0 {
+		return "", false
+	}
+	return strings.Join(allowed, ","), true
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleValidate(t *testing.T) {
+	require.NoError(t, Role("").Validate())
+	require.NoError(t, RoleLive.Validate())
+	require.ErrorIs(t, Role("x").Validate(), ErrInvalidRole)
+}
+
+func TestRoleAllows(t *testing.T) {
+	cases := map[string]struct {
+		role     Role
+		path     string
+		expected bool
+	}{
+		"liveHLS":         {RoleLive, "/hls/m1/stream.m3u8", true},
+		"liveList":        {RoleLive, "/api/monitor/list", true},
+		"livePage":        {RoleLive, "/recordings", true},
+		"liveRecording":   {RoleLive, "/api/recording/video/x", false},
+		"liveEvents":      {RoleLive, "/api/events", false},
+		"liveTalk":        {RoleLive, "/api/monitor/talk", false},
+		"recordingsVideo": {RoleRecordings, "/api/v1/recording/video/x", true},
+		"recordingsTalk":  {RoleRecordings, "/api/v1/monitor/talk", false},
+		"fullTalk":        {RoleFull, "/api/monitor/talk", true},
+		"emptyTalk":       {"", "/api/monitor/talk", true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			require.Equal(t, tc.expected, Account{Role: tc.role}.RoleAllows(r))
+		})
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	require.True(t, Account{Role: RoleLive, IsAdmin: true}.RoleAllows(r))
+}
+
+func TestMonitorAllowed(t *testing.T) {
+	a := Account{Monitors: []string{"m1"}}
+	require.True(t, a.MonitorAllowed("m1"))
+	require.True(t, a.MonitorAllowed("m1_sub"))
+	require.True(t, a.MonitorAllowed(""))
+	require.False(t, a.MonitorAllowed("m2"))
+	require.True(t, Account{}.MonitorAllowed("m2"))
+	require.True(t, Account{Monitors: []string{"m1"}, IsAdmin: true}.MonitorAllowed("m2"))
+	require.False(t, Account{Groups: []string{"g1"}}.MonitorAllowed("m2"))
+}
+
+func TestRequestMonitors(t *testing.T) {
+	cases := map[string]struct {
+		path     string
+		expected []string
+	}{
+		"hls":       {"/hls/m1_sub/index.m3u8", []string{"m1_sub"}},
+		"whep":      {"/whep/m1", []string{"m1"}},
+		"mse":       {"/api/v1/monitor/m1/mse", []string{"m1"}},
+		"stats":     {"/api/monitor/stats?id=m1", []string{"m1"}},
+		"list":      {"/api/monitor/list", nil},
+		"recording": {"/api/recording/video/2000-01-01_02-02-02_m1", []string{"m1"}},
+		"export":    {"/api/recording/export?monitor=m1", []string{"m1"}},
+		"events":    {"/api/events?monitors=m1", nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			require.Equal(t, tc.expected, RequestMonitors(r))
+		})
+	}
+}
+
+type stubRoleAuthenticator struct {
+	Authenticator
+	account Account
+}
+
+func (stubRoleAuthenticator) AuthDisabled() bool { return false }
+
+func (a stubRoleAuthenticator) ValidateRequest(*http.Request) ValidateResponse {
+	return ValidateResponse{IsValid: true, User: a.account}
+}
+
+func (stubRoleAuthenticator) User(next http.Handler) http.Handler {
+	return next
+}
+
+func TestWithRoles(t *testing.T) {
+	account := Account{Role: RoleRecordings, Monitors: []string{"m1", "m2"}}
+	a := WithRoles(stubRoleAuthenticator{account: account}, nil)
+
+	var gotMonitors string
+	handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMonitors = r.URL.Query().Get("monitors")
+	}))
+
+	cases := map[string]struct {
+		path             string
+		expectedCode     int
+		expectedMonitors string
+	}{
+		"allowed":       {"/hls/m1/index.m3u8", http.StatusOK, "m1,m2"},
+		"monitorDenied": {"/hls/m3/index.m3u8", http.StatusForbidden, ""},
+		"roleDenied":    {"/api/monitor/talk?id=m1", http.StatusForbidden, ""},
+		"filtered":      {"/api/events?monitors=m1,m3", http.StatusOK, "m1"},
+		"allDenied":     {"/api/events?monitors=m3", http.StatusForbidden, ""},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			gotMonitors = ""
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedMonitors, gotMonitors)
+		})
+	}
+}
+
+func TestWithRolesGroups(t *testing.T) {
+	groups := map[string][]string{"g1": {"m1", "m2"}, "g2": {"m2", "m3"}}
+	groupMonitors := func(id string) []string { return groups[id] }
+
+	newHandler := func(account Account) (http.Handler, *string) {
+		a := WithRoles(stubRoleAuthenticator{account: account}, groupMonitors)
+		var gotMonitors string
+		handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotMonitors = r.URL.Query().Get("monitors")
+		}))
+		return handler, &gotMonitors
+	}
+
+	cases := map[string]struct {
+		account          Account
+		path             string
+		expectedCode     int
+		expectedMonitors string
+	}{
+		"group":        {Account{Groups: []string{"g1"}}, "/hls/m2/index.m3u8", http.StatusOK, "m1,m2"},
+		"groupDenied":  {Account{Groups: []string{"g1"}}, "/hls/m3/index.m3u8", http.StatusForbidden, ""},
+		"merged":       {Account{Monitors: []string{"m4"}, Groups: []string{"g1", "g2"}}, "/api/events", http.StatusOK, "m4,m1,m2,m3"},
+		"emptyGroup":   {Account{Groups: []string{"nil"}}, "/api/events", http.StatusForbidden, ""},
+		"adminIgnored": {Account{IsAdmin: true, Groups: []string{"g1"}}, "/hls/m3/index.m3u8", http.StatusOK, ""},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			handler, gotMonitors := newHandler(tc.account)
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedMonitors, *gotMonitors)
+		})
+	}
+
+	a := WithRoles(stubRoleAuthenticator{account: Account{Groups: []string{"g2"}}}, groupMonitors)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, []string{"m2", "m3"}, a.ValidateRequest(r).User.Monitors)
+}
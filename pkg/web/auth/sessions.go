@@ -0,0 +1,652 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionCookie name of the session cookie.
+const SessionCookie = "nvr_session"
+
+// loginCookie is set on the response that prompts for a new login after
+// a session ended, the browser sends it back with the new credentials.
+const loginCookie = "nvr_login"
+
+// loginTimeout time to enter the credentials after the prompt.
+const loginTimeout = 10 * time.Minute
+
+// LastSeen is only saved to the file if it
+// changed by more than sessionSaveInterval.
+const sessionSaveInterval = time.Minute
+
+// Session server-side login session of a browser or device.
+// Only the SHA-256 hash of the cookie value is stored.
+//
+// Revoked and expired sessions are kept as ended sessions for the
+// lifetime, requests of the same user, IP and user agent that only
+// send the credentials are prompted to log in again.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Hash      string    `json:"hash"`
+	Ended     time.Time `json:"ended,omitempty"`
+}
+
+// SessionInfo Session without the hash. Current is true
+// if it's the session of the request that listed it.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Expires   time.Time `json:"expires"`
+	Current   bool      `json:"current"`
+}
+
+// ErrSessionNotExist session does not exist.
+var ErrSessionNotExist = errors.New("session does not exist")
+
+// SessionStore stores the sessions in a file. Sessions expire
+// Lifetime after they were created or IdleTimeout after the
+// last request, a zero IdleTimeout disables the idle timeout.
+type SessionStore struct {
+	path        string
+	lifetime    time.Duration
+	idleTimeout time.Duration
+
+	sessions map[string]Session
+	saved    map[string]time.Time // LastSeen of the saved file.
+
+	// Pending login prompts by cookie hash, not saved.
+	logins map[string]pendingLogin
+
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+type pendingLogin struct {
+	ip        string
+	userAgent string
+	expires   time.Time
+}
+
+// NewSessionStore reads the sessions from the file
+// at path, the file is created on the first save.
+func NewSessionStore(path string, lifetime, idleTimeout time.Duration) (*SessionStore, error) {
+	s := &SessionStore{
+		path:        path,
+		lifetime:    lifetime,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]Session),
+		saved:       make(map[string]time.Time),
+		logins:      make(map[string]pendingLogin),
+		now:         time.Now,
+	}
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read sessions: %w", err)
+	}
+	if err := json.Unmarshal(file, &s.sessions); err != nil {
+		return nil, fmt.Errorf("unmarshal sessions: %w", err)
+	}
+	for id, session := range s.sessions {
+		s.saved[id] = session.LastSeen
+	}
+	return s, nil
+}
+
+func (s *SessionStore) expires(session Session) time.Time {
+	expires := session.Created.Add(s.lifetime)
+	if s.idleTimeout != 0 {
+		if idle := session.LastSeen.Add(s.idleTimeout); idle.Before(expires) {
+			return idle
+		}
+	}
+	return expires
+}
+
+func (s *SessionStore) active(session Session, now time.Time) bool {
+	return session.Ended.IsZero() && now.Before(s.expires(session))
+}
+
+// endTime returns the time the session ended or expired. Expired
+// sessions are only marked as ended when pruned.
+func (s *SessionStore) endTime(session Session) time.Time {
+	if !session.Ended.IsZero() {
+		return session.Ended
+	}
+	return s.expires(session)
+}
+
+// end must be called with lock held.
+func (s *SessionStore) end(id string, session Session, ended time.Time) {
+	session.Ended = ended.UTC()
+	s.sessions[id] = session
+}
+
+// prune ends expired sessions and removes ended sessions after
+// the lifetime, returns true if any changed. Must be called with lock held.
+func (s *SessionStore) prune(now time.Time) bool {
+	pruned := false
+	for id, session := range s.sessions {
+		if session.Ended.IsZero() && !now.Before(s.expires(session)) {
+			s.end(id, session, s.expires(session))
+			pruned = true
+			continue
+		}
+		if !session.Ended.IsZero() && !now.Before(session.Ended.Add(s.lifetime)) {
+			delete(s.sessions, id)
+			delete(s.saved, id)
+			pruned = true
+		}
+	}
+	for hash, login := range s.logins {
+		if !now.Before(login.expires) {
+			delete(s.logins, hash)
+		}
+	}
+	return pruned
+}
+
+// Create creates a session for the account and returns
+// the cookie value, it's only available when created.
+func (s *SessionStore) Create(account Account, ip string, userAgent string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.prune(now)
+
+	raw := GenToken()
+	session := Session{
+		ID:        genSessionID(),
+		UserID:    account.ID,
+		Username:  account.Username,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   now.UTC(),
+		LastSeen:  now.UTC(),
+		Hash:      hashToken(raw),
+	}
+	s.sessions[session.ID] = session
+	s.saved[session.ID] = session.LastSeen
+	if err := s.save(); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// Touch updates the last request time of a session of the
+// account with the same IP and user agent that doesn't have
+// a cookie. Returns false if there isn't such a session.
+func (s *SessionStore) Touch(account Account, ip string, userAgent string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, session := range s.sessions {
+		if session.UserID == account.ID && session.IP == ip &&
+			session.UserAgent == userAgent && s.active(session, now) {
+			s.touch(id, session, now)
+			return true
+		}
+	}
+	return false
+}
+
+// Ended returns true if a session of the account with the same IP and
+// user agent ended within the lifetime. Requests that only send the
+// credentials must log in again after a login prompt. Ended sessions
+// are pruned by Create and List, this doesn't write to the file.
+func (s *SessionStore) Ended(account Account, ip string, userAgent string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, session := range s.sessions {
+		if session.UserID != account.ID || session.IP != ip ||
+			session.UserAgent != userAgent || s.active(session, now) {
+			continue
+		}
+		if now.Before(s.endTime(session).Add(s.lifetime)) {
+			return true
+		}
+	}
+	return false
+}
+
+// newLogin returns the value of a new login cookie.
+func (s *SessionStore) newLogin(ip string, userAgent string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw := GenToken()
+	s.logins[hashToken(raw)] = pendingLogin{
+		ip:        ip,
+		userAgent: userAgent,
+		expires:   s.now().Add(loginTimeout),
+	}
+	return raw
+}
+
+// useLogin returns true if the login cookie is from a prompt
+// of the same IP and user agent. The cookie can only be used once.
+func (s *SessionStore) useLogin(raw string, ip string, userAgent string) bool {
+	if raw == "" {
+		return false
+	}
+	hash := hashToken(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, exist := s.logins[hash]
+	if !exist {
+		return false
+	}
+	delete(s.logins, hash)
+	return login.ip == ip && login.userAgent == userAgent &&
+		s.now().Before(login.expires)
+}
+
+// Validate returns true if the cookie belongs to a unexpired
+// session of the user and updates the last request time.
+func (s *SessionStore) Validate(raw string, userID string) bool {
+	hash := []byte(hashToken(raw))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, session := range s.sessions {
+		if subtle.ConstantTimeCompare(hash, []byte(session.Hash)) != 1 {
+			continue
+		}
+		if session.UserID != userID || !s.active(session, now) {
+			return false
+		}
+		s.touch(id, session, now)
+		return true
+	}
+	return false
+}
+
+// cookieUser returns the user id of the unexpired session of the cookie.
+func (s *SessionStore) cookieUser(raw string) (string, bool) {
+	hash := []byte(hashToken(raw))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, session := range s.sessions {
+		if subtle.ConstantTimeCompare(hash, []byte(session.Hash)) == 1 {
+			return session.UserID, s.active(session, now)
+		}
+	}
+	return "", false
+}
+
+// touch must be called with lock held.
+func (s *SessionStore) touch(id string, session Session, now time.Time) {
+	session.LastSeen = now.UTC()
+	s.sessions[id] = session
+	if now.Sub(s.saved[id]) > sessionSaveInterval {
+		s.saved[id] = session.LastSeen
+		s.save() //nolint:errcheck
+	}
+}
+
+// List returns the unexpired sessions of the user, all sessions if
+// userID is empty. The most recently used sessions are first.
+func (s *SessionStore) List(userID string, currentCookie string) []SessionInfo {
+	currentHash := []byte(hashToken(currentCookie))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.prune(now) {
+		s.save() //nolint:errcheck
+	}
+
+	list := []SessionInfo{}
+	for _, session := range s.sessions {
+		if !session.Ended.IsZero() || (userID != "" && session.UserID != userID) {
+			continue
+		}
+		list = append(list, SessionInfo{
+			ID:        session.ID,
+			UserID:    session.UserID,
+			Username:  session.Username,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			Created:   session.Created,
+			LastSeen:  session.LastSeen,
+			Expires:   s.expires(session).UTC(),
+			Current: currentCookie != "" &&
+				subtle.ConstantTimeCompare(currentHash, []byte(session.Hash)) == 1,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// Get returns the session by id, ended sessions don't exist.
+func (s *SessionStore) Get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, exist := s.sessions[id]
+	if !session.Ended.IsZero() {
+		return Session{}, false
+	}
+	return session, exist
+}
+
+// Revoke ends a session by id.
+func (s *SessionStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, exist := s.sessions[id]
+	if !exist || !session.Ended.IsZero() {
+		return ErrSessionNotExist
+	}
+	s.end(id, session, s.now())
+	return s.save()
+}
+
+// RevokeUser deletes all sessions of the user, including the ended
+// sessions. Used when the password is changed or the user is deleted.
+func (s *SessionStore) RevokeUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+			delete(s.saved, id)
+		}
+	}
+	return s.save()
+}
+
+// logoutCookie ends the session of the cookie.
+func (s *SessionStore) logoutCookie(raw string) error {
+	hash := []byte(hashToken(raw))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if subtle.ConstantTimeCompare(hash, []byte(session.Hash)) == 1 {
+			if !session.Ended.IsZero() {
+				return nil
+			}
+			s.end(id, session, s.now())
+			return s.save()
+		}
+	}
+	return nil
+}
+
+// save must be called with lock held.
+func (s *SessionStore) save() error {
+	raw, err := json.MarshalIndent(s.sessions, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal sessions: %w", err)
+	}
+	if err := os.WriteFile(s.path, raw, 0o600); err != nil {
+		return fmt.Errorf("write sessions: %w", err)
+	}
+	return nil
+}
+
+func genSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b) //nolint:errcheck
+	return hex.EncodeToString(b)
+}
+
+type sessionUserKey struct{}
+
+// SessionUser returns the user id of the unexpired session of the
+// cookie of the request, set by the session authenticator. Two-factor
+// authentication doesn't have to be repeated within a session, the
+// code was verified when the session was created.
+func SessionUser(r *http.Request) (string, bool) {
+	userID, ok := r.Context().Value(sessionUserKey{}).(string)
+	return userID, ok
+}
+
+// SessionCookieValue returns the value of the session cookie of the request.
+func SessionCookieValue(r *http.Request) string {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// WithSessions returns a authenticator that tracks the logins
+// of each browser or device in the session store. Requests
+// with the cookie of a revoked or expired session are rejected
+// until the user logs in again, sessions are an additional
+// check and never authenticate a request by themselves. Clients
+// can't bypass the check by dropping the cookie, see Ended. API
+// token requests and disabled authentication aren't tracked.
+// Must wrap the guard, so rejected sessions aren't failed logins.
+func WithSessions(a Authenticator, sessions *SessionStore) Authenticator {
+	return &sessionAuthenticator{Authenticator: a, sessions: sessions}
+}
+
+type sessionAuthenticator struct {
+	Authenticator
+	sessions *SessionStore
+}
+
+// tracked returns false if the request isn't tracked by a session.
+func (a *sessionAuthenticator) tracked(r *http.Request) bool {
+	_, isToken := bearerToken(r)
+	return !isToken && !a.AuthDisabled()
+}
+
+// withSessionUser stores the session user of the cookie in the context.
+func (a *sessionAuthenticator) withSessionUser(r *http.Request) *http.Request {
+	if _, exist := SessionUser(r); exist {
+		return r
+	}
+	raw := SessionCookieValue(r)
+	if raw == "" {
+		return r
+	}
+	userID, active := a.sessions.cookieUser(raw)
+	if !active {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), sessionUserKey{}, userID))
+}
+
+func (a *sessionAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	r = a.withSessionUser(r)
+	res := a.Authenticator.ValidateRequest(r)
+	if !res.IsValid || !a.tracked(r) {
+		return res
+	}
+	raw := SessionCookieValue(r)
+	if raw != "" && !a.sessions.Validate(raw, res.User.ID) {
+		return ValidateResponse{}
+	}
+	return res
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// setLoginCookie marks the next request as a new login.
+func (a *sessionAuthenticator) setLoginCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    a.sessions.newLogin(ClientIP(r), r.UserAgent()),
+		Path:     "/",
+		MaxAge:   int(loginTimeout / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// promptLogin rejects the request and the browser prompts for a new login.
+func (a *sessionAuthenticator) promptLogin(
+	w http.ResponseWriter, r *http.Request, msg string,
+) {
+	a.setLoginCookie(w, r)
+	w.Header().Set("WWW-Authenticate", `Basic realm=""`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+func loginCookieValue(r *http.Request) string {
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (a *sessionAuthenticator) wrap(next http.Handler, fallback func(http.Handler) http.Handler) http.Handler {
+	handler := fallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.tracked(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		account := a.Authenticator.ValidateRequest(r).User
+		ip := ClientIP(r)
+		userAgent := r.UserAgent()
+
+		if raw := SessionCookieValue(r); raw != "" {
+			if a.sessions.Validate(raw, account.ID) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The browser forgets the credentials and
+			// prompts for a new login on this response.
+			clearCookie(w, SessionCookie)
+			a.promptLogin(w, r, "session expired or revoked")
+			return
+		}
+
+		// Clients that don't store cookies reuse the session.
+		if !a.sessions.Touch(account, ip, userAgent) {
+			if a.sessions.Ended(account, ip, userAgent) {
+				if !a.sessions.useLogin(loginCookieValue(r), ip, userAgent) {
+					a.promptLogin(w, r, "session expired or revoked")
+					return
+				}
+				clearCookie(w, loginCookie)
+			}
+
+			raw, err := a.sessions.Create(account, ip, userAgent)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     SessionCookie,
+				Value:    raw,
+				Path:     "/",
+				Expires:  time.Now().Add(a.sessions.lifetime),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, a.withSessionUser(r))
+	})
+}
+
+func (a *sessionAuthenticator) User(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.User)
+}
+
+func (a *sessionAuthenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.Admin)
+}
+
+// UserSet revokes the sessions of the user if the password is changed.
+func (a *sessionAuthenticator) UserSet(req SetUserRequest) error {
+	if err := a.Authenticator.UserSet(req); err != nil {
+		return err
+	}
+	if req.PlainPassword != "" {
+		return a.sessions.RevokeUser(req.ID)
+	}
+	return nil
+}
+
+// UserDelete revokes the sessions of the deleted user.
+func (a *sessionAuthenticator) UserDelete(id string) error {
+	if err := a.Authenticator.UserDelete(id); err != nil {
+		return err
+	}
+	return a.sessions.RevokeUser(id)
+}
+
+// Logout ends the session of the request, the next
+// login from the browser creates a new session.
+func (a *sessionAuthenticator) Logout() http.Handler {
+	logout := a.Authenticator.Logout()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if raw := SessionCookieValue(r); raw != "" {
+			a.sessions.logoutCookie(raw) //nolint:errcheck
+			clearCookie(w, SessionCookie)
+			a.setLoginCookie(w, r)
+		}
+		logout.ServeHTTP(w, r)
+	})
+}
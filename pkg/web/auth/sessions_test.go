@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSessionStore(t *testing.T) (*SessionStore, *time.Time) {
+	s, err := NewSessionStore(
+		filepath.Join(t.TempDir(), "sessions.json"), 24*time.Hour, time.Hour)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestSessionStore(t *testing.T) {
+	t.Run("validate", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		raw, err := s.Create(Account{ID: "1", Username: "a"}, "1.2.3.4", "firefox")
+		require.NoError(t, err)
+
+		require.True(t, s.Validate(raw, "1"))
+		require.False(t, s.Validate(raw, "2"))
+		require.False(t, s.Validate("x", "1"))
+
+		*now = now.Add(59 * time.Minute)
+		require.True(t, s.Validate(raw, "1"))
+	})
+	t.Run("idleTimeout", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		raw, err := s.Create(Account{ID: "1"}, "", "")
+		require.NoError(t, err)
+
+		*now = now.Add(time.Hour)
+		require.False(t, s.Validate(raw, "1"))
+	})
+	t.Run("lifetime", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		raw, err := s.Create(Account{ID: "1"}, "", "")
+		require.NoError(t, err)
+
+		for i := 0; i < 24; i++ {
+			require.True(t, s.Validate(raw, "1"))
+			*now = now.Add(59 * time.Minute)
+		}
+		*now = now.Add(time.Hour)
+		require.False(t, s.Validate(raw, "1"))
+	})
+	t.Run("touch", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		account := Account{ID: "1"}
+		require.False(t, s.Touch(account, "1.2.3.4", "curl"))
+
+		_, err := s.Create(account, "1.2.3.4", "curl")
+		require.NoError(t, err)
+		require.True(t, s.Touch(account, "1.2.3.4", "curl"))
+		require.False(t, s.Touch(account, "1.2.3.4", "firefox"))
+		require.False(t, s.Touch(Account{ID: "2"}, "1.2.3.4", "curl"))
+	})
+	t.Run("list", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		raw1, err := s.Create(Account{ID: "1", Username: "a"}, "1.2.3.4", "firefox")
+		require.NoError(t, err)
+		*now = now.Add(time.Minute)
+		_, err = s.Create(Account{ID: "1", Username: "a"}, "1.2.3.5", "chrome")
+		require.NoError(t, err)
+		_, err = s.Create(Account{ID: "2", Username: "b"}, "1.2.3.6", "curl")
+		require.NoError(t, err)
+
+		list := s.List("1", raw1)
+		require.Len(t, list, 2)
+		require.Equal(t, "chrome", list[0].UserAgent)
+		require.False(t, list[0].Current)
+		require.Equal(t, "firefox", list[1].UserAgent)
+		require.True(t, list[1].Current)
+		require.Equal(t, time.Unix(1000, 0).Add(time.Hour).UTC(), list[1].Expires)
+
+		require.Len(t, s.List("", ""), 3)
+	})
+	t.Run("revoke", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		raw, err := s.Create(Account{ID: "1"}, "", "")
+		require.NoError(t, err)
+
+		id := s.List("1", "")[0].ID
+		require.NoError(t, s.Revoke(id))
+		require.False(t, s.Validate(raw, "1"))
+		require.ErrorIs(t, s.Revoke(id), ErrSessionNotExist)
+	})
+	t.Run("revokeUser", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		_, err := s.Create(Account{ID: "1"}, "", "a")
+		require.NoError(t, err)
+		_, err = s.Create(Account{ID: "1"}, "", "b")
+		require.NoError(t, err)
+		raw, err := s.Create(Account{ID: "2"}, "", "")
+		require.NoError(t, err)
+
+		require.NoError(t, s.RevokeUser("1"))
+		require.Empty(t, s.List("1", ""))
+		require.True(t, s.Validate(raw, "2"))
+	})
+	t.Run("ended", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		account := Account{ID: "1"}
+		_, err := s.Create(account, "1.2.3.4", "firefox")
+		require.NoError(t, err)
+		_, err = s.Create(account, "1.2.3.4", "chrome")
+		require.NoError(t, err)
+		require.False(t, s.Ended(account, "1.2.3.4", "firefox"))
+
+		for _, session := range s.List("1", "") {
+			if session.UserAgent == "firefox" {
+				require.NoError(t, s.Revoke(session.ID))
+			}
+		}
+		require.False(t, s.Touch(account, "1.2.3.4", "firefox"))
+		require.Len(t, s.List("1", ""), 1)
+		require.True(t, s.Ended(account, "1.2.3.4", "firefox"))
+		require.False(t, s.Ended(account, "1.2.3.5", "firefox"))
+		require.False(t, s.Ended(Account{ID: "2"}, "1.2.3.4", "firefox"))
+
+		// Expired sessions end before they are pruned.
+		*now = now.Add(time.Hour)
+		require.True(t, s.Ended(account, "1.2.3.4", "chrome"))
+		require.Empty(t, s.List("1", ""))
+
+		// Ended sessions are removed after the lifetime.
+		*now = now.Add(24 * time.Hour)
+		require.False(t, s.Ended(account, "1.2.3.4", "firefox"))
+		require.False(t, s.Ended(account, "1.2.3.4", "chrome"))
+	})
+	t.Run("endedReadOnly", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		_, err := s.Create(Account{ID: "1"}, "1.2.3.4", "firefox")
+		require.NoError(t, err)
+		info, err := os.Stat(s.path)
+		require.NoError(t, err)
+
+		// The expired session isn't pruned on this path.
+		*now = now.Add(time.Hour)
+		require.True(t, s.Ended(Account{ID: "1"}, "1.2.3.4", "firefox"))
+		info2, err := os.Stat(s.path)
+		require.NoError(t, err)
+		require.Equal(t, info.ModTime(), info2.ModTime())
+	})
+	t.Run("login", func(t *testing.T) {
+		s, now := newTestSessionStore(t)
+		raw := s.newLogin("1.2.3.4", "firefox")
+		require.False(t, s.useLogin("", "1.2.3.4", "firefox"))
+		require.True(t, s.useLogin(raw, "1.2.3.4", "firefox"))
+		require.False(t, s.useLogin(raw, "1.2.3.4", "firefox"))
+
+		raw = s.newLogin("1.2.3.4", "firefox")
+		require.False(t, s.useLogin(raw, "1.2.3.5", "firefox"))
+
+		raw = s.newLogin("1.2.3.4", "firefox")
+		*now = now.Add(loginTimeout)
+		require.False(t, s.useLogin(raw, "1.2.3.4", "firefox"))
+	})
+	t.Run("persist", func(t *testing.T) {
+		s, _ := newTestSessionStore(t)
+		raw, err := s.Create(Account{ID: "1"}, "", "")
+		require.NoError(t, err)
+
+		_, err = s.Create(Account{ID: "1"}, "", "revoked")
+		require.NoError(t, err)
+		for _, session := range s.List("1", "") {
+			if session.UserAgent == "revoked" {
+				require.NoError(t, s.Revoke(session.ID))
+			}
+		}
+
+		s2, err := NewSessionStore(s.path, 24*time.Hour, 0)
+		require.NoError(t, err)
+		s2.now = s.now
+		require.True(t, s2.Validate(raw, "1"))
+		require.True(t, s2.Ended(Account{ID: "1"}, "", "revoked"))
+	})
+}
+
+type sessionStubAuthenticator struct {
+	basicStubAuthenticator
+}
+
+func (sessionStubAuthenticator) AuthDisabled() bool { return false }
+
+func TestWithSessions(t *testing.T) {
+	s, _ := newTestSessionStore(t)
+	a := WithSessions(sessionStubAuthenticator{}, s)
+	handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(password string, cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("admin", password)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("pass", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	raw := cookies[0].Value
+
+	require.Len(t, s.List("", ""), 1)
+	require.Equal(t, http.StatusOK, request("pass", raw).Code)
+	require.Equal(t, http.StatusUnauthorized, request("wrong", raw).Code)
+
+	// Requests without cookie reuse the session.
+	w = request("pass", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Result().Cookies())
+	require.Len(t, s.List("", ""), 1)
+
+	require.NoError(t, s.Revoke(s.List("", "")[0].ID))
+	w = request("pass", raw)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "pass")
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: raw})
+	require.False(t, a.ValidateRequest(r).IsValid)
+
+	// The revoked client can't skip the login prompt by dropping the cookie.
+	w = request("pass", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	require.Empty(t, s.List("", ""))
+
+	var login *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == loginCookie {
+			login = cookie
+		}
+	}
+	require.NotNil(t, login)
+
+	// The client isn't banned, it can log in again after the prompt.
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "pass")
+	r.AddCookie(login)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, s.List("", ""), 1)
+}
+
+func TestWithSessionsLogin(t *testing.T) {
+	s, now := newTestSessionStore(t)
+	a := WithSessions(sessionStubAuthenticator{}, s)
+	handler := a.User(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("admin", "pass")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	findCookie := func(w *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	require.Equal(t, http.StatusOK, request().Code)
+	require.Len(t, s.List("", ""), 1)
+
+	// The browser dropped the cookie of the expired session.
+	*now = now.Add(time.Hour)
+	w := request()
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	login := findCookie(w, loginCookie)
+	require.NotNil(t, login)
+	require.Empty(t, s.List("", ""))
+
+	// The credentials are entered in the login prompt.
+	w = request(login)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, findCookie(w, SessionCookie))
+	require.Equal(t, -1, findCookie(w, loginCookie).MaxAge)
+	require.Len(t, s.List("", ""), 1)
+
+	// The login cookie can only be used once.
+	*now = now.Add(time.Hour)
+	require.Equal(t, http.StatusUnauthorized, request(login).Code)
+}
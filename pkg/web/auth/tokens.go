@@ -0,0 +1,437 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"nvr/pkg/log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope API token permission.
+type Scope string
+
+// Scopes.
+const (
+	ScopeRecordingsRead  Scope = "recordings:read"
+	ScopeRecordingsWrite Scope = "recordings:write"
+	ScopeMonitorsRead    Scope = "monitors:read"
+	ScopeMonitorsWrite   Scope = "monitors:write"
+	ScopeLiveView        Scope = "live:view"
+	ScopeEventsRead      Scope = "events:read"
+	ScopeArmingWrite     Scope = "arming:write"
+
+	// ScopeAdmin allows every request.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes all scopes.
+var Scopes = []Scope{
+	ScopeRecordingsRead,
+	ScopeRecordingsWrite,
+	ScopeMonitorsRead,
+	ScopeMonitorsWrite,
+	ScopeLiveView,
+	ScopeEventsRead,
+	ScopeArmingWrite,
+	ScopeAdmin,
+}
+
+// RequiredScope returns the scope required for the request,
+// empty if any token is allowed. Unknown paths require admin.
+func RequiredScope(r *http.Request) Scope {
+	return requiredScope(unversioned(r.URL.Path), r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+// unversioned returns "/api/x" for "/api/v1/x".
+func unversioned(path string) string {
+	if strings.HasPrefix(path, "/api/v1/") {
+		return "/api/" + strings.TrimPrefix(path, "/api/v1/")
+	}
+	return path
+}
+
+func requiredScope(path string, read bool) Scope { //nolint:gocyclo
+	switch {
+	case strings.HasPrefix(path, "/api/federation/node/"):
+		// "/api/federation/node/<node>/<path>" requires the scope of the remote path.
+		_, remotePath, _ := strings.Cut(strings.TrimPrefix(path, "/api/federation/node/"), "/")
+		remotePath = unversioned("/" + remotePath)
+		if strings.HasPrefix(remotePath, "/api/federation/") {
+			return ScopeAdmin
+		}
+		return requiredScope(remotePath, read)
+	case path == "/api/openapi.json":
+		return ""
+	case strings.HasPrefix(path, "/hls/"),
+		strings.HasPrefix(path, "/whep/"),
+		path == "/api/monitor/talk",
+		strings.HasPrefix(path, "/api/monitor/") && strings.HasSuffix(path, "/mse"),
+		strings.HasPrefix(path, "/api/monitor/") && strings.HasSuffix(path, "/snapshot.jpg"):
+		return ScopeLiveView
+	case strings.HasPrefix(path, "/api/recording/"):
+		if read {
+			return ScopeRecordingsRead
+		}
+		return ScopeRecordingsWrite
+	case path == "/api/bookmarks":
+		return ScopeRecordingsRead
+	case strings.HasPrefix(path, "/api/bookmark/"):
+		return ScopeRecordingsWrite
+	case strings.HasPrefix(path, "/api/segment/"),
+		strings.HasPrefix(path, "/api/integrity/"),
+		strings.HasPrefix(path, "/api/monitor/") && strings.HasSuffix(path, "/timeline"),
+		strings.HasPrefix(path, "/api/monitor/") && strings.HasSuffix(path, "/thumbnail"):
+		return ScopeRecordingsRead
+	case path == "/api/events", path == "/api/events/stream":
+		return ScopeEventsRead
+	case strings.HasPrefix(path, "/api/arming/"):
+		return ScopeArmingWrite
+	case path == "/api/arming",
+		path == "/api/monitor/list",
+		path == "/api/monitor/stats",
+		strings.HasPrefix(path, "/api/monitor/") && strings.HasSuffix(path, "/stats"),
+		path == "/api/group/configs",
+		path == "/api/federation/nodes",
+		strings.HasPrefix(path, "/api/system/"):
+		return ScopeMonitorsRead
+	case path == "/api/monitor/configs", // Includes the camera credentials.
+		path == "/api/monitor/set",
+		path == "/api/monitor/delete",
+		path == "/api/monitor/restart",
+		path == "/api/group/set",
+		path == "/api/group/delete",
+		path == "/api/group/monitors",
+		path == "/api/group/layout",
+		strings.HasPrefix(path, "/api/onvif/"):
+		return ScopeMonitorsWrite
+	}
+	return ScopeAdmin
+}
+
+// APIToken long-lived token for scripts and integrations,
+// only the SHA-256 hash of the token is stored.
+type APIToken struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []Scope   `json:"scopes"`
+	Created time.Time `json:"created"`
+	Hash    string    `json:"hash"`
+}
+
+// APITokenObfuscated APIToken without the hash.
+type APITokenObfuscated struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []Scope   `json:"scopes"`
+	Created time.Time `json:"created"`
+}
+
+// Allows returns true if the token has the scope or the admin scope.
+func (t APIToken) Allows(scope Scope) bool {
+	if scope == "" {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTokenRequest set token request.
+type SetTokenRequest struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Token errors.
+var (
+	ErrTokenIDMissing   = errors.New("id missing")
+	ErrTokenNameMissing = errors.New("name missing")
+	ErrInvalidScope     = errors.New("invalid scope")
+	ErrTokenNotExist    = errors.New("token does not exist")
+)
+
+// TokenPrefix prefix of API tokens.
+const TokenPrefix = "nvr_"
+
+// TokenStore stores the API tokens in a file.
+type TokenStore struct {
+	path   string
+	tokens map[string]APIToken
+	mu     sync.Mutex
+}
+
+// NewTokenStore reads the tokens from the file
+// at path, the file is created on the first save.
+func NewTokenStore(path string) (*TokenStore, error) {
+	s := &TokenStore{
+		path:   path,
+		tokens: make(map[string]APIToken),
+	}
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read tokens: %w", err)
+	}
+	if err := json.Unmarshal(file, &s.tokens); err != nil {
+		return nil, fmt.Errorf("unmarshal tokens: %w", err)
+	}
+	return s, nil
+}
+
+// List returns the tokens without the hashes.
+func (s *TokenStore) List() map[string]APITokenObfuscated {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make(map[string]APITokenObfuscated, len(s.tokens))
+	for id, t := range s.tokens {
+		list[id] = APITokenObfuscated{
+			ID:      t.ID,
+			Name:    t.Name,
+			Scopes:  t.Scopes,
+			Created: t.Created,
+		}
+	}
+	return list
+}
+
+// Set creates a token if the ID doesn't exist, otherwise the name and
+// scopes are updated. The token is only returned when it's created.
+func (s *TokenStore) Set(req SetTokenRequest) (string, error) {
+	if req.ID == "" {
+		return "", ErrTokenIDMissing
+	}
+	if req.Name == "" {
+		return "", ErrTokenNameMissing
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exist := s.tokens[req.ID]
+	var raw string
+	if !exist {
+		raw = TokenPrefix + GenToken()
+		token = APIToken{
+			ID:      req.ID,
+			Created: time.Now().UTC(),
+			Hash:    hashToken(raw),
+		}
+	}
+	token.Name = req.Name
+	token.Scopes = scopes
+
+	tokens := make(map[string]APIToken, len(s.tokens)+1)
+	for id, t := range s.tokens {
+		tokens[id] = t
+	}
+	tokens[req.ID] = token
+	if err := s.save(tokens); err != nil {
+		return "", err
+	}
+	s.tokens = tokens
+	return raw, nil
+}
+
+func parseScopes(scopes []Scope) ([]Scope, error) {
+	unique := make(map[Scope]struct{})
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range Scopes {
+			if scope == s {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		unique[scope] = struct{}{}
+	}
+	parsed := []Scope{}
+	for scope := range unique {
+		parsed = append(parsed, scope)
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i] < parsed[j] })
+	return parsed, nil
+}
+
+// Delete revokes a token.
+func (s *TokenStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exist := s.tokens[id]; !exist {
+		return ErrTokenNotExist
+	}
+	tokens := make(map[string]APIToken, len(s.tokens))
+	for tokenID, t := range s.tokens {
+		if tokenID != id {
+			tokens[tokenID] = t
+		}
+	}
+	if err := s.save(tokens); err != nil {
+		return err
+	}
+	s.tokens = tokens
+	return nil
+}
+
+// Validate returns the token if it exists.
+func (s *TokenStore) Validate(raw string) (APIToken, bool) {
+	if !strings.HasPrefix(raw, TokenPrefix) {
+		return APIToken{}, false
+	}
+	hash := []byte(hashToken(raw))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t, true
+		}
+	}
+	return APIToken{}, false
+}
+
+func (s *TokenStore) save(tokens map[string]APIToken) error {
+	raw, err := json.MarshalIndent(tokens, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshal tokens: %w", err)
+	}
+	if err := os.WriteFile(s.path, raw, 0o600); err != nil {
+		return fmt.Errorf("write tokens: %w", err)
+	}
+	return nil
+}
+
+func hashToken(raw string) string {
+	hash := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(hash[:])
+}
+
+// WithTokens returns a authenticator that also accepts API tokens
+// in the "Authorization: Bearer <token>" header. Token requests are
+// limited to the scopes of the token and don't need a CSRF token.
+func WithTokens(a Authenticator, tokens *TokenStore, logger *log.Logger) Authenticator {
+	return &tokenAuthenticator{
+		Authenticator: a,
+		tokens:        tokens,
+		logger:        logger,
+	}
+}
+
+type tokenAuthenticator struct {
+	Authenticator
+	tokens *TokenStore
+	logger *log.Logger
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// validateToken returns false as the second value if the token doesn't exist.
+func (a *tokenAuthenticator) validateToken(r *http.Request, raw string) (ValidateResponse, bool) {
+	token, exist := a.tokens.Validate(raw)
+	if !exist {
+		return ValidateResponse{}, false
+	}
+	if !token.Allows(RequiredScope(r)) {
+		return ValidateResponse{}, true
+	}
+	return ValidateResponse{
+		IsValid: true,
+		User: Account{
+			ID:       "token_" + token.ID,
+			Username: token.Name,
+			IsAdmin:  token.Allows(ScopeAdmin),
+		},
+	}, true
+}
+
+func (a *tokenAuthenticator) ValidateRequest(r *http.Request) ValidateResponse {
+	raw, ok := bearerToken(r)
+	if !ok || a.AuthDisabled() {
+		return a.Authenticator.ValidateRequest(r)
+	}
+	res, _ := a.validateToken(r, raw)
+	return res
+}
+
+// checkToken writes the error response and returns false if
+// the token doesn't exist or is missing the required scope.
+func (a *tokenAuthenticator) checkToken(w http.ResponseWriter, r *http.Request, raw string) bool {
+	res, exist := a.validateToken(r, raw)
+	if !exist {
+		LogFailedLogin(a.logger, r, "token")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	if !res.IsValid {
+		http.Error(w, fmt.Sprintf("token requires scope: %v", RequiredScope(r)), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (a *tokenAuthenticator) wrap(next http.Handler, fallback func(http.Handler) http.Handler) http.Handler {
+	fallbackHandler := fallback(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := bearerToken(r)
+		if !ok || a.AuthDisabled() {
+			fallbackHandler.ServeHTTP(w, r)
+			return
+		}
+		if a.checkToken(w, r, raw) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *tokenAuthenticator) User(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.User)
+}
+
+func (a *tokenAuthenticator) Admin(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.Admin)
+}
+
+func (a *tokenAuthenticator) CSRF(next http.Handler) http.Handler {
+	return a.wrap(next, a.Authenticator.CSRF)
+}
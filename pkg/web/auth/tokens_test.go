@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"nvr/pkg/log"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := NewTokenStore(path)
+	require.NoError(t, err)
+	require.Empty(t, s.List())
+
+	raw, err := s.Set(SetTokenRequest{
+		ID:     "1",
+		Name:   "a",
+		Scopes: []Scope{ScopeLiveView, ScopeRecordingsRead, ScopeLiveView},
+	})
+	require.NoError(t, err)
+	require.Contains(t, raw, TokenPrefix)
+
+	token, exist := s.Validate(raw)
+	require.True(t, exist)
+	require.Equal(t, []Scope{ScopeLiveView, ScopeRecordingsRead}, token.Scopes)
+
+	_, exist = s.Validate(raw + "x")
+	require.False(t, exist)
+
+	// Updating doesn't change the token.
+	raw2, err := s.Set(SetTokenRequest{ID: "1", Name: "b", Scopes: []Scope{ScopeAdmin}})
+	require.NoError(t, err)
+	require.Empty(t, raw2)
+
+	// Persisted.
+	s2, err := NewTokenStore(path)
+	require.NoError(t, err)
+	token, exist = s2.Validate(raw)
+	require.True(t, exist)
+	require.Equal(t, "b", token.Name)
+	require.Equal(t, []Scope{ScopeAdmin}, token.Scopes)
+	require.Equal(t, map[string]APITokenObfuscated{
+		"1": {ID: "1", Name: "b", Scopes: []Scope{ScopeAdmin}, Created: token.Created},
+	}, s2.List())
+
+	require.NoError(t, s.Delete("1"))
+	_, exist = s.Validate(raw)
+	require.False(t, exist)
+	require.ErrorIs(t, s.Delete("1"), ErrTokenNotExist)
+}
+
+func TestTokenStoreSetErrors(t *testing.T) {
+	cases := map[string]struct {
+		input SetTokenRequest
+		err   error
+	}{
+		"missingID":    {SetTokenRequest{Name: "a"}, ErrTokenIDMissing},
+		"missingName":  {SetTokenRequest{ID: "1"}, ErrTokenNameMissing},
+		"invalidScope": {SetTokenRequest{ID: "1", Name: "a", Scopes: []Scope{"x"}}, ErrInvalidScope},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+			require.NoError(t, err)
+			_, err = s.Set(tc.input)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	cases := map[string]struct {
+		method   string
+		path     string
+		expected Scope
+	}{
+		"openapi":        {http.MethodGet, "/api/v1/openapi.json", ""},
+		"hls":            {http.MethodGet, "/hls/x/index.m3u8", ScopeLiveView},
+		"snapshot":       {http.MethodGet, "/api/v1/monitor/x/snapshot.jpg", ScopeLiveView},
+		"recordingRead":  {http.MethodGet, "/api/recording/video/x", ScopeRecordingsRead},
+		"recordingWrite": {http.MethodDelete, "/api/recording/delete/x", ScopeRecordingsWrite},
+		"bookmarks":      {http.MethodGet, "/api/v1/bookmarks", ScopeRecordingsRead},
+		"bookmarkSet":    {http.MethodPut, "/api/bookmark/set", ScopeRecordingsWrite},
+		"events":         {http.MethodGet, "/api/events/stream", ScopeEventsRead},
+		"armingSet":      {http.MethodPut, "/api/arming/set", ScopeArmingWrite},
+		"armingGet":      {http.MethodGet, "/api/arming", ScopeMonitorsRead},
+		"monitorList":    {http.MethodGet, "/api/v1/monitor/list", ScopeMonitorsRead},
+		"statsHistory":   {http.MethodGet, "/api/v1/monitor/x/stats", ScopeMonitorsRead},
+		"monitorSet":     {http.MethodPut, "/api/monitor/set", ScopeMonitorsWrite},
+		"users":          {http.MethodGet, "/api/users", ScopeAdmin},
+		"tokens":         {http.MethodGet, "/api/tokens", ScopeAdmin},
+		"nodes":          {http.MethodGet, "/api/v1/federation/nodes", ScopeMonitorsRead},
+		"remoteHLS":      {http.MethodGet, "/api/federation/node/a/hls/x/index.m3u8", ScopeLiveView},
+		"remoteVideo":    {http.MethodGet, "/api/federation/node/a/api/v1/recording/video/x", ScopeRecordingsRead},
+		"remoteDelete":   {http.MethodDelete, "/api/federation/node/a/api/recording/delete/x", ScopeRecordingsWrite},
+		"remoteUsers":    {http.MethodGet, "/api/federation/node/a/api/users", ScopeAdmin},
+		"remoteNested":   {http.MethodGet, "/api/federation/node/a/api/federation/nodes", ScopeAdmin},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			require.Equal(t, tc.expected, RequiredScope(r))
+		})
+	}
+}
+
+func TestAPITokenAllows(t *testing.T) {
+	token := APIToken{Scopes: []Scope{ScopeLiveView}}
+	require.True(t, token.Allows(""))
+	require.True(t, token.Allows(ScopeLiveView))
+	require.False(t, token.Allows(ScopeMonitorsRead))
+
+	admin := APIToken{Scopes: []Scope{ScopeAdmin}}
+	require.True(t, admin.Allows(ScopeMonitorsWrite))
+}
+
+type stubAuthenticator struct {
+	Authenticator
+}
+
+func (stubAuthenticator) AuthDisabled() bool { return false }
+
+func (stubAuthenticator) ValidateRequest(*http.Request) ValidateResponse {
+	return ValidateResponse{}
+}
+
+func (stubAuthenticator) Admin(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "session", http.StatusTeapot)
+	})
+}
+
+func TestWithTokens(t *testing.T) {
+	tokens, err := NewTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	raw, err := tokens.Set(SetTokenRequest{
+		ID:     "1",
+		Name:   "a",
+		Scopes: []Scope{ScopeMonitorsRead},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	logger := &log.Logger{Ctx: ctx}
+
+	a := WithTokens(stubAuthenticator{}, tokens, logger)
+	handler := a.Admin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := map[string]struct {
+		path     string
+		token    string
+		expected int
+	}{
+		"session":      {"/api/monitor/list", "", http.StatusTeapot},
+		"ok":           {"/api/monitor/list", raw, http.StatusOK},
+		"invalid":      {"/api/monitor/list", "nvr_x", http.StatusUnauthorized},
+		"missingScope": {"/api/monitor/set", raw, http.StatusForbidden},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tc.expected, w.Code)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/monitor/list", nil)
+	r.Header.Set("Authorization", "Bearer "+raw)
+	res := a.ValidateRequest(r)
+	require.True(t, res.IsValid)
+	require.Equal(t, "token_1", res.User.ID)
+	require.False(t, res.User.IsAdmin)
+}
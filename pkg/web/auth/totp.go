@@ -0,0 +1,174 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	stdLog "log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters used by all common authenticator apps.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpModulo = 1000000 // 10^totpDigits.
+
+	// Codes from the previous and next
+	// period are accepted to allow clock drift.
+	totpSkew = 1
+
+	// TOTPIssuer is shown in the authenticator app.
+	TOTPIssuer = "OS-NVR"
+
+	recoveryCodeCount = 10
+)
+
+// TOTP errors.
+var (
+	ErrTOTPEnabled      = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled  = errors.New("two-factor enrollment not started")
+	ErrTOTPInvalidCode  = errors.New("invalid two-factor code")
+	ErrTOTPNotSupported = errors.New("two-factor authentication is not supported")
+	ErrTOTPNotEnabled   = errors.New("two-factor authentication is not enabled")
+)
+
+var errTOTPInvalidSecret = errors.New("invalid secret")
+
+// TOTPEnrollment secret that has to be confirmed
+// with a code before it's enabled.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// uri, can be shown as a QR code.
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenTOTPSecret generates a random base32 encoded secret.
+func GenTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		stdLog.Fatalf("failed to generate secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// NewTOTPEnrollment creates a enrollment for the username.
+func NewTOTPEnrollment(username string) TOTPEnrollment {
+	secret := GenTOTPSecret()
+	label := url.PathEscape(TOTPIssuer + ":" + username)
+	query := url.Values{
+		"secret": {secret},
+		"issuer": {TOTPIssuer},
+		"digits": {fmt.Sprint(totpDigits)},
+		"period": {fmt.Sprint(totpPeriod)},
+	}
+	return TOTPEnrollment{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + query.Encode(),
+	}
+}
+
+// totpCode returns the code of the time step, RFC 4226 section 5.3.
+func totpCode(secret string, step uint64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errTOTPInvalidSecret, err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], step)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulo), nil
+}
+
+// TOTPCode returns the current code of the secret.
+func TOTPCode(secret string, now time.Time) (string, error) {
+	return totpCode(secret, uint64(now.Unix())/totpPeriod)
+}
+
+// ValidateTOTP returns the time step of the code if it's valid at the
+// time. The step can be used to reject codes that were already used.
+func ValidateTOTP(secret string, code string, now time.Time) (uint64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := uint64(now.Unix()) / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + uint64(i)
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenRecoveryCodes returns the raw codes and the hashes that are stored.
+// Each code is 8 hex characters with a dash in the middle, "1a2b-3c4d".
+func GenRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			stdLog.Fatalf("failed to generate recovery code: %v", err)
+		}
+		raw := hex.EncodeToString(b)
+		codes[i] = raw[:4] + "-" + raw[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// RecoveryCodeLength length of a recovery code including the dash.
+const RecoveryCodeLength = 9
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseRecoveryCode returns the hashes without the code
+// and true if the code matched one of the hashes.
+func UseRecoveryCode(hashes []string, code string) ([]string, bool) {
+	hash := hashRecoveryCode(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			remaining := append([]string{}, hashes[:i]...)
+			return append(remaining, hashes[i+1:]...), true
+		}
+	}
+	return hashes, false
+}
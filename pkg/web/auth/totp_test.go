@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 appendix B secret "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	cases := map[string]struct {
+		time     int64
+		expected string
+	}{
+		"59":         {59, "287082"},
+		"1111111109": {1111111109, "081804"},
+		"1234567890": {1234567890, "005924"},
+		"2000000000": {2000000000, "279037"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			code, err := TOTPCode(rfcSecret, time.Unix(tc.time, 0))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code)
+		})
+	}
+	_, err := TOTPCode("1", time.Now())
+	require.ErrorIs(t, err, errTOTPInvalidSecret)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step, valid := ValidateTOTP(rfcSecret, "081804", now)
+	require.True(t, valid)
+	require.Equal(t, uint64(1111111109/30), step)
+
+	// Clock drift.
+	_, valid = ValidateTOTP(rfcSecret, "081804", now.Add(30*time.Second))
+	require.True(t, valid)
+	_, valid = ValidateTOTP(rfcSecret, "081804", now.Add(90*time.Second))
+	require.False(t, valid)
+
+	_, valid = ValidateTOTP(rfcSecret, "81804", now)
+	require.False(t, valid)
+}
+
+func TestNewTOTPEnrollment(t *testing.T) {
+	e := NewTOTPEnrollment("a b")
+	require.Len(t, e.Secret, 32)
+	require.True(t, strings.HasPrefix(e.URI, "otpauth://totp/OS-NVR:a%20b?"))
+	require.Contains(t, e.URI, "secret="+e.Secret)
+
+	code, err := TOTPCode(e.Secret, time.Now())
+	require.NoError(t, err)
+	_, valid := ValidateTOTP(e.Secret, code, time.Now())
+	require.True(t, valid)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes := GenRecoveryCodes()
+	require.Len(t, codes, 10)
+	require.Len(t, codes[0], RecoveryCodeLength)
+
+	remaining, valid := UseRecoveryCode(hashes, strings.ToUpper(codes[3]))
+	require.True(t, valid)
+	require.Len(t, remaining, 9)
+	require.Len(t, hashes, 10, "input must not be modified")
+
+	_, valid = UseRecoveryCode(remaining, codes[3])
+	require.False(t, valid)
+}
@@ -0,0 +1,224 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package certs provides the certificates of the built in HTTPS server.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"nvr/pkg/log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config certificate configuration. CertFile and KeyFile are served if set,
+// otherwise certificates for Domains are obtained from the ACME Directory.
+// DNS-01 challenges are used if DNSHook is set, HTTP-01 and TLS-ALPN-01
+// challenges otherwise. Certificates and account keys are stored in CacheDir.
+type Config struct {
+	CertFile  string
+	KeyFile   string
+	Domains   []string
+	Email     string
+	Directory string
+	DNSHook   string
+	CacheDir  string
+}
+
+// ErrNoCertificate neither certificate files nor domains are set.
+var ErrNoCertificate = errors.New("no certificate files or ACME domains")
+
+// reloadInterval how often the certificate files are checked for changes.
+const reloadInterval = 10 * time.Second
+
+// Manager provides the certificates.
+type Manager struct {
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	nextProtos     []string
+	httpHandler    func(http.Handler) http.Handler
+	run            func(context.Context)
+}
+
+// NewManager returns a certificate manager for the config.
+func NewManager(c Config, logger *log.Logger) (*Manager, error) {
+	logf := func(level log.Level, format string, a ...interface{}) {
+		logger.Log(log.Entry{
+			Level: level,
+			Src:   "app",
+			Msg:   "certs: " + fmt.Sprintf(format, a...),
+		})
+	}
+
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		f := &fileCert{certFile: c.CertFile, keyFile: c.KeyFile, logf: logf}
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+		return &Manager{
+			getCertificate: f.getCertificate,
+			run:            f.run,
+		}, nil
+
+	case len(c.Domains) != 0 && c.DNSHook != "":
+		if err := os.MkdirAll(c.CacheDir, 0o700); err != nil {
+			return nil, err
+		}
+		d := newDNSCert(c, logf)
+		if err := d.loadCache(); err != nil {
+			logf(log.LevelWarning, "could not load cached certificate: %v", err)
+		}
+		return &Manager{
+			getCertificate: d.getCertificate,
+			run:            d.run,
+		}, nil
+
+	case len(c.Domains) != 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(c.CacheDir),
+			HostPolicy: autocert.HostWhitelist(c.Domains...),
+			Email:      c.Email,
+		}
+		if c.Directory != "" {
+			m.Client = &acme.Client{DirectoryURL: c.Directory}
+		}
+		return &Manager{
+			getCertificate: m.GetCertificate,
+			nextProtos:     []string{acme.ALPNProto},
+			httpHandler:    m.HTTPHandler,
+			run:            func(context.Context) {},
+		}, nil
+	}
+	return nil, ErrNoCertificate
+}
+
+// TLSConfig returns the server TLS config.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.getCertificate,
+		NextProtos:     append([]string{"h2", "http/1.1"}, m.nextProtos...),
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// HTTPHandler returns a handler that answers HTTP-01
+// challenges and passes other requests to fallback.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	if m.httpHandler == nil {
+		return fallback
+	}
+	return m.httpHandler(fallback)
+}
+
+// Run reloads or renews the certificates until the context is canceled.
+func (m *Manager) Run(ctx context.Context) {
+	m.run(ctx)
+}
+
+// fileCert user provided certificate that's reloaded when the files change.
+type fileCert struct {
+	certFile string
+	keyFile  string
+	logf     func(log.Level, string, ...interface{})
+
+	cert    *tls.Certificate
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func (f *fileCert) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cert, nil
+}
+
+// filesModTime returns the latest modification time of the files.
+func (f *fileCert) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{f.certFile, f.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (f *fileCert) load() error {
+	modTime, err := f.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	f.mu.Lock()
+	f.cert = &cert
+	f.modTime = modTime
+	f.mu.Unlock()
+	return nil
+}
+
+// reload loads the files if they have changed. The
+// current certificate is kept if the new one is invalid.
+func (f *fileCert) reload() error {
+	modTime, err := f.filesModTime()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	changed := !modTime.Equal(f.modTime)
+	f.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	if err := f.load(); err != nil {
+		// Don't retry until the files change again.
+		f.mu.Lock()
+		f.modTime = modTime
+		f.mu.Unlock()
+		return err
+	}
+	f.logf(log.LevelInfo, "reloaded certificate %v", f.certFile)
+	return nil
+}
+
+func (f *fileCert) run(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				f.logf(log.LevelError, "could not reload certificate: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
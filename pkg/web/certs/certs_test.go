@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"nvr/pkg/log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *log.Logger {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return &log.Logger{Ctx: ctx}
+}
+
+// newTestCert returns a PEM encoded self-signed certificate and key.
+func newTestCert(t *testing.T, domain string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func serverName(t *testing.T, m *Manager) string {
+	cert, err := m.TLSConfig().GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestNewManager(t *testing.T) {
+	t.Run("noCertificate", func(t *testing.T) {
+		_, err := NewManager(Config{}, newTestLogger())
+		require.ErrorIs(t, err, ErrNoCertificate)
+	})
+	t.Run("missingFile", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := NewManager(Config{
+			CertFile: filepath.Join(dir, "cert.pem"),
+			KeyFile:  filepath.Join(dir, "key.pem"),
+		}, newTestLogger())
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+	t.Run("acme", func(t *testing.T) {
+		m, err := NewManager(Config{
+			Domains:  []string{"a.example.com"},
+			CacheDir: t.TempDir(),
+		}, newTestLogger())
+		require.NoError(t, err)
+		require.Contains(t, m.TLSConfig().NextProtos, "acme-tls/1")
+	})
+}
+
+func TestFileCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeCert := func(domain string, modTime time.Time) {
+		certPEM, keyPEM := newTestCert(t, domain, time.Now().Add(time.Hour))
+		require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+		require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+		require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	}
+	writeCert("a", time.Unix(1000, 0))
+
+	m, err := NewManager(Config{CertFile: certFile, KeyFile: keyFile}, newTestLogger())
+	require.NoError(t, err)
+	require.Equal(t, "a", serverName(t, m))
+
+	f := &fileCert{certFile: certFile, keyFile: keyFile, logf: func(log.Level, string, ...interface{}) {}}
+	require.NoError(t, f.load())
+
+	writeCert("b", time.Unix(2000, 0))
+	require.NoError(t, f.reload())
+	cert, err := f.getCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, "b", leaf.Subject.CommonName)
+
+	// The current certificate is kept if the new one is invalid.
+	require.NoError(t, os.WriteFile(keyFile, []byte("x"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, time.Unix(3000, 0), time.Unix(3000, 0)))
+	require.Error(t, f.reload())
+	cert2, err := f.getCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert, cert2)
+
+	// Not retried until the files change again.
+	require.NoError(t, f.reload())
+}
+
+func TestDNSCertNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	cases := map[string]struct {
+		domains  []string
+		notAfter time.Time
+		cached   bool
+		expected bool
+	}{
+		"noCertificate": {[]string{"a.example.com"}, now, false, true},
+		"valid":         {[]string{"a.example.com"}, now.Add(60 * 24 * time.Hour), true, false},
+		"expiresSoon":   {[]string{"a.example.com"}, now.Add(10 * 24 * time.Hour), true, true},
+		"newDomain":     {[]string{"a.example.com", "b.example.com"}, now.Add(60 * 24 * time.Hour), true, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			d := newDNSCert(Config{Domains: tc.domains, CacheDir: t.TempDir()}, nil)
+			if tc.cached {
+				certPEM, keyPEM := newTestCert(t, "a.example.com", tc.notAfter)
+				err := os.WriteFile(d.certPath(), append(keyPEM, certPEM...), 0o600)
+				require.NoError(t, err)
+			}
+			require.NoError(t, d.loadCache())
+			require.Equal(t, tc.expected, d.needsRenewal(now))
+
+			_, err := d.getCertificate(nil)
+			if tc.cached {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrNoCertificateYet)
+			}
+		})
+	}
+}
+
+func TestDNSCertAccountKey(t *testing.T) {
+	d := newDNSCert(Config{CacheDir: t.TempDir()}, nil)
+	key, err := d.loadAccountKey()
+	require.NoError(t, err)
+
+	key2, err := d.loadAccountKey()
+	require.NoError(t, err)
+	require.True(t, key.Equal(key2))
+}
@@ -0,0 +1,332 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"nvr/pkg/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// DNS-01 errors.
+var (
+	ErrNoDNSChallenge    = errors.New("dns-01 challenge not offered")
+	ErrNoCertificateYet  = errors.New("certificate not obtained yet")
+	ErrInvalidAccountKey = errors.New("invalid account key")
+)
+
+const (
+	// Certificates are renewed when they expire within renewBefore.
+	renewBefore   = 30 * 24 * time.Hour
+	checkInterval = 12 * time.Hour
+	retryInterval = time.Hour
+	obtainTimeout = 10 * time.Minute
+)
+
+// dnsCert certificate for all domains obtained using DNS-01 challenges.
+// The hook is called with "present" or "cleanup", the record name and
+// its value. It must not return until the TXT record is published.
+type dnsCert struct {
+	config  Config
+	logf    func(log.Level, string, ...interface{})
+	runHook func(ctx context.Context, action, name, value string) error
+	client  *acme.Client
+
+	cert *tls.Certificate
+	mu   sync.Mutex
+}
+
+func newDNSCert(c Config, logf func(log.Level, string, ...interface{})) *dnsCert {
+	return &dnsCert{
+		config: c,
+		logf:   logf,
+		runHook: func(ctx context.Context, action, name, value string) error {
+			cmd := exec.CommandContext(ctx, c.DNSHook, action, name, value)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("dns hook %v: %w: %s", action, err, out)
+			}
+			return nil
+		},
+	}
+}
+
+func (d *dnsCert) certPath() string {
+	return filepath.Join(d.config.CacheDir, "dns01.pem")
+}
+
+func (d *dnsCert) accountKeyPath() string {
+	return filepath.Join(d.config.CacheDir, "dns01_account.key")
+}
+
+func (d *dnsCert) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cert == nil {
+		return nil, ErrNoCertificateYet
+	}
+	return d.cert, nil
+}
+
+// parseKeyAndCerts parses a PEM encoded private key followed by the chain.
+func parseKeyAndCerts(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (d *dnsCert) loadCache() error {
+	data, err := os.ReadFile(d.certPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := parseKeyAndCerts(data)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.cert = cert
+	d.mu.Unlock()
+	return nil
+}
+
+// needsRenewal returns true if there is no certificate, it expires
+// soon or it doesn't cover all the domains in the config.
+func (d *dnsCert) needsRenewal(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cert == nil {
+		return true
+	}
+	if d.cert.Leaf.NotAfter.Sub(now) < renewBefore {
+		return true
+	}
+	for _, domain := range d.config.Domains {
+		if d.cert.Leaf.VerifyHostname(domain) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dnsCert) run(ctx context.Context) {
+	for {
+		interval := checkInterval
+		if d.needsRenewal(time.Now()) {
+			if err := d.obtain(ctx); err != nil {
+				d.logf(log.LevelError, "could not obtain certificate: %v", err)
+				interval = retryInterval
+			} else {
+				d.logf(log.LevelInfo, "obtained certificate for %v",
+					strings.Join(d.config.Domains, ", "))
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *dnsCert) obtain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, obtainTimeout)
+	defer cancel()
+
+	client, err := d.acmeClient(ctx)
+	if err != nil {
+		return fmt.Errorf("account: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(d.config.Domains...))
+	if err != nil {
+		return fmt.Errorf("authorize order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := d.authorize(ctx, client, url); err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: d.config.Domains[0]},
+		DNSNames: d.config.Domains,
+	}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("create certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, der := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	cert, err := parseKeyAndCerts(data)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := d.certPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, d.certPath()); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.cert = cert
+	d.mu.Unlock()
+	return nil
+}
+
+// authorize completes the DNS-01 challenge of the authorization.
+func (d *dnsCert) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%v: %w", authz.Identifier.Value, ErrNoDNSChallenge)
+	}
+
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	// Wildcard domains use the record of the base domain.
+	name := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+
+	if err := d.runHook(ctx, "present", name, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.runHook(ctx, "cleanup", name, value); err != nil {
+			d.logf(log.LevelWarning, "%v", err)
+		}
+	}()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("wait authorization: %w", err)
+	}
+	return nil
+}
+
+// acmeClient returns a client with a registered account.
+// The account key is generated on the first run.
+func (d *dnsCert) acmeClient(ctx context.Context) (*acme.Client, error) {
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	key, err := d.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{Key: key, DirectoryURL: d.config.Directory}
+
+	account := &acme.Account{}
+	if d.config.Email != "" {
+		account.Contact = []string{"mailto:" + d.config.Email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	d.client = client
+	return client, nil
+}
+
+func (d *dnsCert) loadAccountKey() (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(d.accountKeyPath())
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, ErrInvalidAccountKey
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(d.accountKeyPath(), data, 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// APIPrefix prefix of the versioned API.
+const APIPrefix = "/api/v1"
+
+// Endpoint describes a API operation in the OpenAPI document.
+type Endpoint struct {
+	Method string
+
+	// Path relative to APIPrefix, path parameters are in braces.
+	// "/recording/video/{id}"
+	Path    string
+	Summary string
+
+	// Admin is true if the endpoint requires a admin.
+	Admin bool
+
+	// CSRF is true if the "X-CSRF-TOKEN" header is required.
+	CSRF bool
+
+	// Query parameters.
+	Query []string
+
+	// Body is true if the endpoint accepts a JSON body.
+	Body bool
+
+	// Response content type, defaults to JSON.
+	// "none" if the endpoint doesn't return a body.
+	Response string
+}
+
+// API registers the API routes and generates the OpenAPI document from
+// the endpoints. Every route is also served under APIPrefix, the
+// unversioned paths are kept for the web interface and old clients.
+type API struct {
+	router    *http.ServeMux
+	endpoints []Endpoint
+	basePath  string
+	mu        sync.Mutex
+}
+
+// NewAPI registers the versioned routes on the router.
+func NewAPI(router *http.ServeMux) *API {
+	api := &API{router: router}
+	router.Handle(APIPrefix+"/", api.versioned())
+	return api
+}
+
+// Handle registers the handler for the unversioned
+// pattern, "/api/monitor/list", and the endpoints.
+func (a *API) Handle(pattern string, handler http.Handler, endpoints ...Endpoint) {
+	a.router.Handle(pattern, handler)
+	a.mu.Lock()
+	a.endpoints = append(a.endpoints, endpoints...)
+	a.mu.Unlock()
+}
+
+// SetBasePath sets the path prefix of the server URL in
+// the document when the app is served under a sub-path.
+func (a *API) SetBasePath(basePath string) {
+	a.mu.Lock()
+	a.basePath = basePath
+	a.mu.Unlock()
+}
+
+// versioned rewrites "/api/v1/x" to "/api/x", the
+// handlers and the auth are shared with the old paths.
+func (a *API) versioned() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/api/" + strings.TrimPrefix(r.URL.Path, APIPrefix+"/")
+		r2.URL.RawPath = ""
+		a.router.ServeHTTP(w, r2)
+	})
+}
+
+// OpenAPIHandler serves the OpenAPI document.
+func (a *API) OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(a.OpenAPI()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// OpenAPIDocument OpenAPI 3.0 document.
+type OpenAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Servers    []openAPIServer                  `json:"servers"`
+	Components openAPIComponents                `json:"components"`
+	Security   []map[string][]string            `json:"security"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// Operation OpenAPI operation.
+type Operation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+
+	// Required account type, "user" or "admin".
+	Auth string `json:"x-auth"`
+}
+
+type openAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required,omitempty"`
+	Schema   openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type string `json:"type,omitempty"`
+}
+
+type openAPIBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIMedia struct {
+	Schema openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+// OpenAPI generates the document from the registered endpoints.
+func (a *API) OpenAPI() OpenAPIDocument {
+	a.mu.Lock()
+	endpoints := append([]Endpoint{}, a.endpoints...)
+	basePath := a.basePath
+	a.mu.Unlock()
+
+	doc := OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "OS-NVR", Version: "1"},
+		Servers: []openAPIServer{{URL: basePath + APIPrefix}},
+		Components: openAPIComponents{
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"basicAuth": {Type: "http", Scheme: "basic"},
+			},
+		},
+		Security: []map[string][]string{{"basicAuth": {}}},
+		Paths:    make(map[string]map[string]*Operation),
+	}
+	for _, e := range endpoints {
+		if doc.Paths[e.Path] == nil {
+			doc.Paths[e.Path] = make(map[string]*Operation)
+		}
+		doc.Paths[e.Path][strings.ToLower(e.Method)] = e.operation()
+	}
+	return doc
+}
+
+func (e Endpoint) operation() *Operation {
+	op := &Operation{
+		Summary:     e.Summary,
+		OperationID: operationID(e.Method, e.Path),
+		Auth:        "user",
+		Responses: map[string]openAPIResponse{
+			"401": {Description: "Unauthorized"},
+		},
+	}
+	if e.Admin {
+		op.Auth = "admin"
+	}
+
+	segments := strings.Split(strings.Trim(e.Path, "/"), "/")
+	op.Tags = []string{segments[0]}
+	for _, s := range segments {
+		if strings.HasPrefix(s, "{") {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:     strings.Trim(s, "{}"),
+				In:       "path",
+				Required: true,
+				Schema:   openAPISchema{Type: "string"},
+			})
+		}
+	}
+	query := append([]string{}, e.Query...)
+	sort.Strings(query)
+	for _, name := range query {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:   name,
+			In:     "query",
+			Schema: openAPISchema{Type: "string"},
+		})
+	}
+	if e.CSRF {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     "X-CSRF-TOKEN",
+			In:       "header",
+			Required: true,
+			Schema:   openAPISchema{Type: "string"},
+		})
+	}
+
+	if e.Body {
+		op.RequestBody = &openAPIBody{
+			Required: true,
+			Content: map[string]openAPIMedia{
+				jsonContentType: {},
+			},
+		}
+	}
+
+	ok := openAPIResponse{Description: "OK"}
+	switch e.Response {
+	case "":
+		ok.Content = map[string]openAPIMedia{
+			jsonContentType: {},
+		}
+	case "none":
+	default:
+		ok.Content = map[string]openAPIMedia{
+			e.Response: {Schema: openAPISchema{Type: "string"}},
+		}
+	}
+	op.Responses["200"] = ok
+	return op
+}
+
+// operationID returns a camel case ID, "GET /monitor/{id}/timeline"
+// becomes "getMonitorIdTimeline".
+func operationID(method string, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,116 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	router := http.NewServeMux()
+	api := NewAPI(router)
+	api.Handle("/api/recording/delete/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path)) //nolint:errcheck
+	}),
+		Endpoint{
+			Method:   http.MethodDelete,
+			Path:     "/recording/delete/{id}",
+			Summary:  "Delete a recording.",
+			Admin:    true,
+			CSRF:     true,
+			Response: "none",
+		},
+	)
+	api.Handle("/api/events", http.NotFoundHandler(),
+		Endpoint{
+			Method:  http.MethodGet,
+			Path:    "/events",
+			Summary: "Query events.",
+			Query:   []string{"start", "end"},
+		},
+	)
+
+	t.Run("versioned", func(t *testing.T) {
+		for _, path := range []string{"/api/recording/delete/x", "/api/v1/recording/delete/x"} {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, "/api/recording/delete/x", rec.Body.String())
+		}
+	})
+	t.Run("notFound", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/x", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+	t.Run("openAPI", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.OpenAPIHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		require.Equal(t, "3.0.3", doc["openapi"])
+		require.Equal(t, []interface{}{map[string]interface{}{"url": "/api/v1"}}, doc["servers"])
+
+		paths := doc["paths"].(map[string]interface{})
+		require.Len(t, paths, 2)
+
+		del := paths["/recording/delete/{id}"].(map[string]interface{})["delete"]
+		expected := map[string]interface{}{
+			"summary":     "Delete a recording.",
+			"operationId": "deleteRecordingDeleteId",
+			"tags":        []interface{}{"recording"},
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name": "id", "in": "path", "required": true,
+					"schema": map[string]interface{}{"type": "string"},
+				},
+				map[string]interface{}{
+					"name": "X-CSRF-TOKEN", "in": "header", "required": true,
+					"schema": map[string]interface{}{"type": "string"},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "OK"},
+				"401": map[string]interface{}{"description": "Unauthorized"},
+			},
+			"x-auth": "admin",
+		}
+		require.Equal(t, expected, del)
+
+		get := paths["/events"].(map[string]interface{})["get"].(map[string]interface{})
+		require.Equal(t, "user", get["x-auth"])
+		params := get["parameters"].([]interface{})
+		require.Equal(t, "end", params[0].(map[string]interface{})["name"])
+		require.Equal(t, "start", params[1].(map[string]interface{})["name"])
+	})
+	t.Run("method", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.OpenAPIHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestOperationID(t *testing.T) {
+	require.Equal(t, "getMonitorIdTimeline", operationID(http.MethodGet, "/monitor/{id}/timeline"))
+	require.Equal(t, "getSystemTimeZone", operationID(http.MethodGet, "/system/time-zone"))
+}
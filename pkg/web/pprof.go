@@ -0,0 +1,42 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// Sample rates of the block and mutex profiles.
+const (
+	blockProfileRate     = 10000 // Nanoseconds.
+	mutexProfileFraction = 100
+)
+
+// RegisterProfiling registers the net/http/pprof handlers under
+// "/debug/pprof/" and enables the block and mutex profiles.
+// The runtime trace is captured from "/debug/pprof/trace".
+func RegisterProfiling(router *http.ServeMux, admin func(http.Handler) http.Handler) {
+	runtime.SetBlockProfileRate(blockProfileRate)
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+
+	router.Handle("/debug/pprof/", admin(http.HandlerFunc(pprof.Index)))
+	router.Handle("/debug/pprof/cmdline", admin(http.HandlerFunc(pprof.Cmdline)))
+	router.Handle("/debug/pprof/profile", admin(http.HandlerFunc(pprof.Profile)))
+	router.Handle("/debug/pprof/symbol", admin(http.HandlerFunc(pprof.Symbol)))
+	router.Handle("/debug/pprof/trace", admin(http.HandlerFunc(pprof.Trace)))
+}
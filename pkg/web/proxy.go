@@ -0,0 +1,203 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidTrustedProxy invalid trusted proxy.
+var ErrInvalidTrustedProxy = errors.New("must be a IP address or CIDR")
+
+// DefaultTrustedProxies are trusted if none are configured.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// ParseTrustedProxies parses IP addresses and CIDRs.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("%q: %w", proxy, ErrInvalidTrustedProxy)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", proxy, ErrInvalidTrustedProxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Proxy resolves the client address of requests from trusted
+// reverse proxies and serves the app under a base path.
+type Proxy struct {
+	next     http.Handler
+	basePath string
+	trusted  []*net.IPNet
+}
+
+// NewProxy returns a handler that replaces the "X-Real-Ip" header and the
+// remote address with the client address from the "X-Forwarded-For" header.
+// The forwarding headers are only trusted if the request is
+// from one of the trusted proxies, they're removed otherwise.
+//
+// Requests are also accepted without the base path prefix,
+// for reverse proxies that strip it before forwarding.
+func NewProxy(next http.Handler, basePath string, trustedProxies []string) (*Proxy, error) {
+	if len(trustedProxies) == 0 {
+		trustedProxies = DefaultTrustedProxies
+	}
+	trusted, err := ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{
+		next:     next,
+		basePath: basePath,
+		trusted:  trusted,
+	}, nil
+}
+
+func (p *Proxy) isTrusted(ip net.IP) bool {
+	for _, ipNet := range p.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var forwardingHeaders = []string{"X-Real-Ip", "X-Forwarded-For", "X-Forwarded-Proto"}
+
+// resolveClient rightmost untrusted address
+// in "X-Forwarded-For" is the client.
+func (p *Proxy) resolveClient(r *http.Request) {
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	remote := net.ParseIP(host)
+	if err != nil || remote == nil || !p.isTrusted(remote) {
+		for _, header := range forwardingHeaders {
+			r.Header.Del(header)
+		}
+		return
+	}
+
+	var client net.IP
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !p.isTrusted(ip) {
+			break
+		}
+	}
+	if client == nil {
+		client = net.ParseIP(r.Header.Get("X-Real-Ip"))
+	}
+	if client == nil {
+		r.Header.Del("X-Real-Ip")
+		return
+	}
+	r.Header.Set("X-Real-Ip", client.String())
+	r.RemoteAddr = net.JoinHostPort(client.String(), port)
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.resolveClient(r)
+	if p.basePath == "" {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	path := r.URL.Path
+	if path == p.basePath || path == p.basePath+"/" {
+		http.Redirect(w, r, p.basePath+"/live", http.StatusFound)
+		return
+	}
+	if strings.HasPrefix(path, p.basePath+"/") {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, p.basePath)
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, p.basePath)
+		r = r2
+	}
+	p.next.ServeHTTP(&basePathWriter{ResponseWriter: w, basePath: p.basePath}, r)
+}
+
+// basePathWriter adds the base path to absolute redirects.
+type basePathWriter struct {
+	http.ResponseWriter
+	basePath    string
+	wroteHeader bool
+}
+
+func (w *basePathWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		location := w.Header().Get("Location")
+		if strings.HasPrefix(location, "/") &&
+			!strings.HasPrefix(location, "//") &&
+			!strings.HasPrefix(location, w.basePath+"/") {
+			w.Header().Set("Location", w.basePath+location)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *basePathWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for server-sent events.
+func (w *basePathWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ErrHijackNotSupported the response writer can't be hijacked.
+var ErrHijackNotSupported = errors.New("hijack not supported")
+
+// Hijack implements http.Hijacker for websockets.
+func (w *basePathWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrHijackNotSupported
+	}
+	return h.Hijack()
+}
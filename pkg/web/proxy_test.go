@@ -0,0 +1,133 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.Equal(t, "10.0.0.1/32", nets[0].String())
+	require.Equal(t, "::1/128", nets[2].String())
+
+	_, err = ParseTrustedProxies([]string{"x"})
+	require.ErrorIs(t, err, ErrInvalidTrustedProxy)
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	require.ErrorIs(t, err, ErrInvalidTrustedProxy)
+}
+
+func TestProxyClientIP(t *testing.T) {
+	cases := map[string]struct {
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+		realIP     string
+	}{
+		"direct": {
+			remoteAddr: "1.2.3.4:5",
+			expected:   "1.2.3.4:5",
+		},
+		"untrusted": {
+			remoteAddr: "1.2.3.4:5",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6", "X-Real-Ip": "6.6.6.6"},
+			expected:   "1.2.3.4:5",
+		},
+		"forwarded": {
+			remoteAddr: "10.0.0.1:5",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"},
+			expected:   "1.2.3.4:5",
+			realIP:     "1.2.3.4",
+		},
+		"realIP": {
+			remoteAddr: "10.0.0.1:5",
+			headers:    map[string]string{"X-Real-Ip": "1.2.3.4"},
+			expected:   "1.2.3.4:5",
+			realIP:     "1.2.3.4",
+		},
+		"onlyProxies": {
+			remoteAddr: "10.0.0.1:5",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			expected:   "10.0.0.3:5",
+			realIP:     "10.0.0.3",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var remoteAddr, realIP string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				remoteAddr = r.RemoteAddr
+				realIP = r.Header.Get("X-Real-Ip")
+			})
+			p, err := NewProxy(next, "", []string{"10.0.0.0/8"})
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			p.ServeHTTP(httptest.NewRecorder(), r)
+			require.Equal(t, tc.expected, remoteAddr)
+			require.Equal(t, tc.realIP, realIP)
+		})
+	}
+}
+
+func TestProxyBasePath(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hls/x" {
+			w.Header().Set("Location", "/hls/x/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte(r.URL.Path)) //nolint:errcheck
+	})
+	p, err := NewProxy(next, "/nvr", nil)
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		path     string
+		code     int
+		body     string
+		location string
+	}{
+		"stripped":    {"/nvr/live", http.StatusOK, "/live", ""},
+		"unprefixed":  {"/live", http.StatusOK, "/live", ""},
+		"root":        {"/nvr/", http.StatusFound, "", "/nvr/live"},
+		"rootNoSlash": {"/nvr", http.StatusFound, "", "/nvr/live"},
+		"redirect":    {"/nvr/hls/x", http.StatusMovedPermanently, "", "/nvr/hls/x/"},
+		"otherPrefix": {"/nvrx/live", http.StatusOK, "/nvrx/live", ""},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+			require.Equal(t, tc.code, w.Code)
+			require.Equal(t, tc.location, w.Header().Get("Location"))
+			if tc.body != "" {
+				require.Equal(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
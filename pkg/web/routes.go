@@ -16,21 +16,33 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"nvr/pkg/arming"
+	"nvr/pkg/feed"
 	"nvr/pkg/group"
+	"nvr/pkg/health"
 	"nvr/pkg/log"
 	"nvr/pkg/monitor"
+	"nvr/pkg/onvif"
 	"nvr/pkg/storage"
+	"nvr/pkg/thumbnail"
+	"nvr/pkg/video"
+	"nvr/pkg/video/hls"
 	"nvr/pkg/web/auth"
 	"nvr/web/static"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/gorilla/websocket"
@@ -69,6 +81,48 @@ func TimeZone(timeZone string) http.Handler {
 	})
 }
 
+// DiskHealth returns the latest health check of the storage disk.
+func DiskHealth(health func() storage.DiskHealth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(health())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Health handles the liveness and readiness endpoints. The status code is
+// 503 if the status is failed. The components, that may contain monitor
+// IDs and error messages, are only included for authenticated requests.
+func Health(report func() health.Report, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		rep := report()
+		if !a.ValidateRequest(r).IsValid {
+			rep.Components = nil
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if rep.Status == health.StatusFailed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
 // General handler returns general configuration in json format.
 func General(general *storage.ConfigGeneral) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -114,6 +168,134 @@ func GeneralSet(general *storage.ConfigGeneral) http.Handler {
 	})
 }
 
+// ConfigReloadResult result of a config reload.
+type ConfigReloadResult struct {
+	General  bool                 `json:"general"`
+	Monitors monitor.ReloadResult `json:"monitors"`
+}
+
+// ConfigReload handler to reload the config files from disk.
+func ConfigReload(reload func() (ConfigReloadResult, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := reload()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not reload config: %v", err),
+				http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// ConfigProblem invalid setting found by the config check.
+type ConfigProblem struct {
+	Config string `json:"config"`
+	Error  string `json:"error"`
+}
+
+// ConfigCheck handler that validates the config files on disk without applying them.
+func ConfigCheck(check func() []ConfigProblem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		problems := check()
+		response := struct {
+			Valid    bool            `json:"valid"`
+			Problems []ConfigProblem `json:"problems"`
+		}{
+			Valid:    len(problems) == 0,
+			Problems: problems,
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// ConfigExport handler that downloads the config directory as a archive.
+func ConfigExport(configDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The archive is buffered to respond with a error instead of a partial file.
+		now := time.Now()
+		var b bytes.Buffer
+		if err := storage.ExportConfig(&b, configDir, now); err != nil {
+			http.Error(w, fmt.Sprintf("could not export config: %v", err),
+				http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="`+storage.ConfigBackupName(now)+`"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(b.Bytes()) //nolint:errcheck
+	})
+}
+
+// ConfigRestoreResult result of a config restore.
+type ConfigRestoreResult struct {
+	Restored bool               `json:"restored"`
+	Files    []string           `json:"files"`
+	Removed  []string           `json:"removed"`
+	Problems []ConfigProblem    `json:"problems"`
+	Reload   ConfigReloadResult `json:"reload"`
+}
+
+// ConfigRestoreFunc checks and restores a config backup.
+type ConfigRestoreFunc func(backup *storage.ConfigBackup, force bool) (ConfigRestoreResult, error)
+
+// ConfigRestore handler that restores a archive from ConfigExport. The
+// backup isn't restored if it has problems unless "force=true" is set.
+func ConfigRestore(restore ConfigRestoreFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		backup, err := storage.ReadConfigBackup(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid backup: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		force := r.URL.Query().Get("force") == "true"
+		result, err := restore(backup, force)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not restore config: %v", err),
+				http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if !result.Restored {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
 // Users returns a censored user list in json format.
 func Users(a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -156,6 +338,11 @@ func UserSet(a auth.Authenticator) http.Handler {
 			}
 		}
 
+		if err := req.Role.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		err = a.UserSet(req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -186,114 +373,91 @@ func UserDelete(a auth.Authenticator) http.Handler {
 	})
 }
 
-// MonitorList returns a censored monitor list.
-func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
+// TOTPEnroll starts the two-factor enrollment of the requesting user.
+func TOTPEnroll(a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+		if r.Method != http.MethodPost {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(monitorInfo())
+		enrollment, err := a.TOTPEnroll(a.ValidateRequest(r).User.ID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	})
-}
-
-// MonitorConfigs returns monitor configurations in json format.
-func MonitorConfigs(c *monitor.Manager) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(c.MonitorConfigs())
-		if err != nil {
+		if err := json.NewEncoder(w).Encode(enrollment); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-// MonitorRestart handler to restart monitor.
-func MonitorRestart(m *monitor.Manager) http.Handler {
+// TOTPConfirm enables two-factor authentication for the requesting
+// user and responds with the recovery codes.
+func TOTPConfirm(a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		id := r.URL.Query().Get("id")
-		if id == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
-			return
-		}
-
-		err := m.RestartMonitor(id)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("could not restart monitor: %v", err),
-				http.StatusInternalServerError)
+		var req struct {
+			Code string `json:"code"`
 		}
-	})
-}
-
-// MonitorSet handler to set monitor configuration.
-func MonitorSet(m *monitor.Manager) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPut {
-			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var c monitor.RawConfig
-		err := json.NewDecoder(r.Body).Decode(&c)
+		codes, err := a.TOTPConfirm(a.ValidateRequest(r).User.ID, req.Code)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := checkIDandName(c); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+		res := struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}{RecoveryCodes: codes}
 
-		err = m.MonitorSet(c["id"], c)
-		if err != nil {
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-// MonitorDelete handler to delete monitor.
-func MonitorDelete(m *monitor.Manager) http.Handler {
+// TOTPDisable disables two-factor authentication for the requesting
+// user, or the user in the "id" query parameter if the user is admin.
+func TOTPDisable(a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
+		user := a.ValidateRequest(r).User
 		id := r.URL.Query().Get("id")
 		if id == "" {
-			http.Error(w, "id missing", http.StatusBadRequest)
+			id = user.ID
+		}
+		if id != user.ID && !user.IsAdmin {
+			http.Error(w, "only admins can disable other users", http.StatusForbidden)
 			return
 		}
 
-		err := m.MonitorDelete(id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := a.TOTPDisable(id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	})
 }
 
-// GroupConfigs returns group configurations in json format.
-func GroupConfigs(m *group.Manager) http.Handler {
+// Tokens returns the API tokens without the hashes.
+func Tokens(s *auth.TokenStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -301,7 +465,7 @@ func GroupConfigs(m *group.Manager) http.Handler {
 		}
 
 		w.Header().Set("Content-Type", jsonContentType)
-		err := json.NewEncoder(w).Encode(m.Configs())
+		err := json.NewEncoder(w).Encode(s.List())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -309,74 +473,46 @@ func GroupConfigs(m *group.Manager) http.Handler {
 	})
 }
 
-// GroupSet handler to set group configuration.
-func GroupSet(m *group.Manager) http.Handler {
+// TokenSet creates or updates a API token. The
+// token is only in the response when it's created.
+func TokenSet(s *auth.TokenStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		var g group.Config
-		err := json.NewDecoder(r.Body).Decode(&g)
-		if err != nil {
+		var req auth.SetTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := checkIDandNameGroup(g); err != nil {
+		token, err := s.Set(req)
+		if errors.Is(err, auth.ErrTokenIDMissing) ||
+			errors.Is(err, auth.ErrTokenNameMissing) ||
+			errors.Is(err, auth.ErrInvalidScope) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		if err = m.GroupSet(g["id"], g); err != nil {
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(struct {
+			Token string `json:"token,omitempty"`
+		}{token})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-// Errors.
-var (
-	ErrEmptyValue     = errors.New("value cannot be empty")
-	ErrContainsSpaces = errors.New("value cannot contain spaces")
-	ErrIDTooLong      = errors.New("id cannot be longer than 24 bytes")
-)
-
-func checkIDandName(c monitor.RawConfig) error {
-	switch {
-	case c["id"] == "":
-		return fmt.Errorf("id: %w", ErrEmptyValue)
-	case containsSpaces(c["id"]):
-		return fmt.Errorf("id: %w", ErrContainsSpaces)
-	case c["name"] == "":
-		return fmt.Errorf("name: %w", ErrEmptyValue)
-	case containsSpaces(c["name"]):
-		return fmt.Errorf("name: %w", ErrContainsSpaces)
-	case len(c["id"]) > 24:
-		return ErrIDTooLong
-	default:
-		return nil
-	}
-}
-
-func checkIDandNameGroup(input map[string]string) error {
-	switch {
-	case input["id"] == "":
-		return fmt.Errorf("id: %w", ErrEmptyValue)
-	case containsSpaces(input["id"]):
-		return fmt.Errorf("id: %w", ErrContainsSpaces)
-	case input["name"] == "":
-		return fmt.Errorf("name: %w", ErrEmptyValue)
-	case containsSpaces(input["name"]):
-		return fmt.Errorf("name. %w", ErrContainsSpaces)
-	default:
-		return nil
-	}
-}
-
-// GroupDelete handler to delete group.
-func GroupDelete(m *group.Manager) http.Handler {
+// TokenDelete revokes a API token.
+func TokenDelete(s *auth.TokenStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -389,7 +525,11 @@ func GroupDelete(m *group.Manager) http.Handler {
 			return
 		}
 
-		err := m.GroupDelete(id)
+		err := s.Delete(id)
+		if errors.Is(err, auth.ErrTokenNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -397,190 +537,2006 @@ func GroupDelete(m *group.Manager) http.Handler {
 	})
 }
 
-// RecordingDelete deletes a recording.
-func RecordingDelete(recordingsDir string) http.Handler {
+// Sessions returns the login sessions of the user, admins get
+// the sessions of all users or of the user in the "user" query.
+func Sessions(a auth.Authenticator, s *auth.SessionStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodDelete {
+		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/delete/")
+		user := a.ValidateRequest(r).User
+		userID := user.ID
+		if user.IsAdmin {
+			userID = r.URL.Query().Get("user")
+		}
 
-		err := storage.DeleteRecording(recordingsDir, recID)
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(s.List(userID, auth.SessionCookieValue(r)))
 		if err != nil {
-			if errors.Is(err, storage.ErrInvalidRecordingID) {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			if errors.Is(err, os.ErrNotExist) {
-				http.Error(w, "", http.StatusNotFound)
-				return
-			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-// RecordingThumbnail serves thumbnail by exact recording ID.
-func RecordingThumbnail(recordingsDir string) http.Handler {
+// SessionRevoke revokes a login session, only
+// admins can revoke the sessions of other users.
+func SessionRevoke(a auth.Authenticator, s *auth.SessionStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		recID := r.URL.Path[25:] // Trim "/api/recording/thumbnail/"
-		recPath, err := storage.RecordingIDToPath(recID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
 			return
 		}
 
-		thumbPath := filepath.Join(recordingsDir, recPath+".jpeg")
-
-		// ServeFile will sanitize ".."
-		http.ServeFile(w, r, thumbPath)
-	})
-}
+		// Sessions of other users don't exist for non-admins.
+		user := a.ValidateRequest(r).User
+		session, exist := s.Get(id)
+		if !exist || (session.UserID != user.ID && !user.IsAdmin) {
+			http.Error(w, auth.ErrSessionNotExist.Error(), http.StatusNotFound)
+			return
+		}
 
-// RecordingVideo serves video by exact recording ID.
-func RecordingVideo(logger *log.Logger, recordingsDir string) http.Handler {
-	videoReaderCache := storage.NewVideoCache()
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := s.Revoke(id)
+		if errors.Is(err, auth.ErrSessionNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorList returns a censored monitor list.
+func MonitorList(monitorInfo func() monitor.RawConfigs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitors := monitorInfo()
+		if ids := parseCSVParam(r.URL.Query(), "monitors"); len(ids) != 0 {
+			filtered := make(monitor.RawConfigs)
+			for _, id := range ids {
+				if m, exist := monitors[id]; exist {
+					filtered[id] = m
+				}
+			}
+			monitors = filtered
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(monitors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorConfigs returns monitor configurations in json format.
+func MonitorConfigs(c *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(c.MonitorConfigs())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorRestart handler to restart monitor.
+func MonitorRestart(m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		err := m.RestartMonitor(id)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not restart monitor: %v", err),
+				http.StatusInternalServerError)
+		}
+	})
+}
+
+// Arming returns the arming state.
+func Arming(m *arming.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(m.State()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// ArmingSet sets the global mode, or the mode of a single monitor if
+// "monitorId" is set. A empty monitor mode follows the global mode.
+//
+//	{"mode": "night"}
+//	{"monitorId": "garage", "mode": "away"}
+func ArmingSet(m *arming.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			MonitorID string      `json:"monitorId"`
+			Mode      arming.Mode `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if req.MonitorID == "" {
+			err = m.SetMode(req.Mode, "api")
+		} else {
+			err = m.SetMonitorMode(req.MonitorID, req.Mode, "api")
+		}
+		if errors.Is(err, arming.ErrInvalidMode) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// ArmingSchedule replaces the arming schedule.
+//
+//	[{"days": ["mon"], "time": "22:00", "mode": "night"}]
+func ArmingSchedule(m *arming.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var switches []arming.Switch
+		if err := json.NewDecoder(r.Body).Decode(&switches); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, s := range switches {
+			if err := s.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := m.SetSchedule(switches, "api"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorStatsFunc returns the track statistics of a video server path.
+type MonitorStatsFunc func(pathName string) ([]video.TrackStats, error)
+
+// MonitorStats returns the stream statistics of the main
+// and sub stream of a monitor. A stream is null if it
+// isn't available, for example if the camera is offline.
+func MonitorStats(stats MonitorStatsFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		main, err := stats(id)
+		if errors.Is(err, video.ErrPathNotExist) {
+			http.Error(w, "monitor not running", http.StatusNotFound)
+			return
+		}
+		sub, _ := stats(id + "_sub")
+
+		res := struct {
+			Main []video.TrackStats `json:"main"`
+			Sub  []video.TrackStats `json:"sub"`
+		}{Main: main, Sub: sub}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorStatsHistoryFunc returns the statistics history of a video server path.
+type MonitorStatsHistoryFunc func(pathName string) (video.StatsHistory, error)
+
+// MonitorStatsHistory returns the stream statistics from the last hour of
+// the main and sub stream of a monitor, "/api/monitor/<id>/stats". The
+// sub stream is null if the monitor doesn't have one.
+func MonitorStatsHistory(history MonitorStatsHistoryFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := monitorIDFromPath(r.URL.Path)
+		if monitorID == "" {
+			http.Error(w, "monitor missing", http.StatusBadRequest)
+			return
+		}
+
+		main, err := history(monitorID)
+		if errors.Is(err, video.ErrPathNotExist) {
+			http.Error(w, "monitor not running", http.StatusNotFound)
+			return
+		}
+		res := struct {
+			Interval float64             `json:"interval"`
+			Main     video.StatsHistory  `json:"main"`
+			Sub      *video.StatsHistory `json:"sub"`
+		}{
+			Interval: video.StatsHistoryInterval.Seconds(),
+			Main:     main,
+		}
+		if sub, err := history(monitorID + "_sub"); err == nil {
+			res.Sub = &sub
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorViewersFunc returns the HLS viewer statistics of a video server path.
+type MonitorViewersFunc func(pathName string) (hls.ViewerStats, error)
+
+// MonitorViewers returns the playback statistics reported by the HLS
+// viewers of the main and sub stream of a monitor,
+// "/api/monitor/<id>/viewers". A stream is null if it isn't available.
+func MonitorViewers(stats MonitorViewersFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := monitorIDFromPath(r.URL.Path)
+		if monitorID == "" {
+			http.Error(w, "monitor missing", http.StatusBadRequest)
+			return
+		}
+
+		main, err := stats(monitorID)
+		if errors.Is(err, video.ErrPathNotExist) {
+			http.Error(w, "monitor not running", http.StatusNotFound)
+			return
+		}
+		res := struct {
+			Main *hls.ViewerStats `json:"main"`
+			Sub  *hls.ViewerStats `json:"sub"`
+		}{}
+		if err == nil {
+			res.Main = &main
+		}
+		if sub, err := stats(monitorID + "_sub"); err == nil {
+			res.Sub = &sub
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorPaths routes "/api/monitor/<id>/<name>" requests to the handler
+// of the name. The handlers read the monitor ID from the path.
+func MonitorPaths(handlers map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/monitor/")
+		i := strings.LastIndex(path, "/")
+		if i == -1 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		handler, exist := handlers[path[i+1:]]
+		if !exist {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// monitorIDFromPath returns the ID in a "/api/monitor/<id>/<name>" path.
+func monitorIDFromPath(path string) string {
+	path = strings.TrimPrefix(path, "/api/monitor/")
+	id, _, found := strings.Cut(path, "/")
+	if !found {
+		return ""
+	}
+	return id
+}
+
+// RecordingsInRangeFunc returns the event recordings
+// of the monitor that overlap the time range.
+type RecordingsInRangeFunc func(
+	monitorID string, start time.Time, end time.Time) ([]storage.Recording, error)
+
+// MonitorTimeline responds with the recording coverage, gaps and
+// events of the monitor between start and end as JSON.
+func MonitorTimeline(
+	querySegments SegmentQueryFunc,
+	queryStatic SegmentQueryFunc,
+	queryRecordings RecordingsInRangeFunc,
+	logger log.ILogger,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := monitorIDFromPath(r.URL.Path)
+		if monitorID == "" {
+			http.Error(w, "monitor missing", http.StatusBadRequest)
+			return
+		}
+		start, end, ok := parseTimeRange(w, r)
+		if !ok {
+			return
+		}
+
+		recordings, err := queryRecordings(monitorID, start, end)
+		if err != nil {
+			logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: monitorID,
+				Msg:       fmt.Sprintf("timeline: could not query recordings: %v", err),
+			})
+			http.Error(w, "could not query recordings", http.StatusInternalServerError)
+			return
+		}
+		timeline := storage.NewTimeline(
+			start,
+			end,
+			querySegments(monitorID, start, end),
+			queryStatic(monitorID, start, end),
+			recordings,
+		)
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(timeline); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// ThumbnailFunc returns a JPEG of the monitor at the time.
+type ThumbnailFunc func(ctx context.Context, monitorID string, at time.Time, width int) ([]byte, error)
+
+// MonitorThumbnail responds with a JPEG extracted from the
+// continuous recording at the keyframe nearest to the time.
+func MonitorThumbnail(getThumbnail ThumbnailFunc, logger log.ILogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := monitorIDFromPath(r.URL.Path)
+		if monitorID == "" {
+			http.Error(w, "monitor missing", http.StatusBadRequest)
+			return
+		}
+		at, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("time"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid time: %v", err), http.StatusBadRequest)
+			return
+		}
+		width := thumbnail.DefaultWidth
+		if raw := r.URL.Query().Get("width"); raw != "" {
+			width, err = strconv.Atoi(raw)
+			if err != nil || width <= 0 || width > thumbnail.MaxWidth {
+				http.Error(w, fmt.Sprintf("invalid width: %q", raw), http.StatusBadRequest)
+				return
+			}
+		}
+
+		jpeg, err := getThumbnail(r.Context(), monitorID, at, width)
+		if errors.Is(err, thumbnail.ErrNoKeyframe) {
+			http.Error(w, "no recording at time", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: monitorID,
+				Msg:       fmt.Sprintf("thumbnail: %v", err),
+			})
+			http.Error(w, "could not generate thumbnail", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Write(jpeg) //nolint:errcheck
+	})
+}
+
+// SnapshotFunc returns the current frame of the monitor as a JPEG.
+type SnapshotFunc func(ctx context.Context, monitorID string) ([]byte, error)
+
+// MonitorSnapshot responds with a JPEG of the latest keyframe of the
+// main input. The snapshot may be up to monitor.SnapshotMaxAge old.
+func MonitorSnapshot(getSnapshot SnapshotFunc, logger log.ILogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := monitorIDFromPath(r.URL.Path)
+		if monitorID == "" {
+			http.Error(w, "monitor missing", http.StatusBadRequest)
+			return
+		}
+
+		jpeg, err := getSnapshot(r.Context(), monitorID)
+		switch {
+		case errors.Is(err, monitor.ErrMonitorNotExist):
+			http.Error(w, "monitor doesn't exist", http.StatusNotFound)
+			return
+		case errors.Is(err, monitor.ErrMonitorNotRunning),
+			errors.Is(err, monitor.ErrInputNotOnline):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, monitor.ErrNoVideoTrack):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			if r.Context().Err() != nil {
+				return
+			}
+			logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: monitorID,
+				Msg:       fmt.Sprintf("snapshot: %v", err),
+			})
+			http.Error(w, "could not generate snapshot", http.StatusInternalServerError)
+			return
+		}
+
+		maxAge := int(monitor.SnapshotMaxAge / time.Second)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+		w.Write(jpeg) //nolint:errcheck
+	})
+}
+
+// MonitorSet handler to set monitor configuration.
+func MonitorSet(m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var c monitor.RawConfig
+		err := json.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := checkIDandName(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = m.MonitorSet(c["id"], c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// OnvifDiscoverFunc discovers ONVIF devices on the network.
+type OnvifDiscoverFunc func(context.Context, time.Duration) ([]onvif.Device, error)
+
+// OnvifDiscover returns the ONVIF devices on the local network.
+func OnvifDiscover(discover OnvifDiscoverFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		devices, err := discover(r.Context(), onvif.DefaultDiscoveryTimeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(devices); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// OnvifProbeFunc fetches the details of a ONVIF device.
+type OnvifProbeFunc func(ctx context.Context, xaddr, username, password string) (*onvif.DeviceDetails, error)
+
+type onvifRequest struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	XAddr    string `json:"xaddr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// OnvifProbe returns the device information and media profiles of a ONVIF device.
+func OnvifProbe(probe OnvifProbeFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req onvifRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		details, err := probe(r.Context(), req.XAddr, req.Username, req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(details); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// OnvifProvision probes a ONVIF device and creates and starts a
+// monitor from its media profiles. Existing monitors aren't replaced.
+func OnvifProvision(probe OnvifProbeFunc, m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req onvifRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := checkIDandName(monitor.RawConfig{"id": req.ID, "name": req.Name})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exist := m.MonitorConfigs()[req.ID]; exist {
+			http.Error(w, "monitor already exists", http.StatusConflict)
+			return
+		}
+
+		details, err := probe(r.Context(), req.XAddr, req.Username, req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		c, err := details.MonitorConfig(req.ID, req.Name, req.Username, req.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := m.MonitorSet(req.ID, c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := m.RestartMonitor(req.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// MonitorDelete handler to delete monitor.
+func MonitorDelete(m *monitor.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		err := m.MonitorDelete(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// GroupConfigs returns group configurations in json format.
+func GroupConfigs(m *group.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err := json.NewEncoder(w).Encode(m.Configs())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// GroupSet handler to set group configuration.
+func GroupSet(m *group.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var g group.Config
+		err := json.NewDecoder(r.Body).Decode(&g)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := checkIDandNameGroup(g); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := g.Layout(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = m.GroupSet(g["id"], g); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// Errors.
+var (
+	ErrEmptyValue     = errors.New("value cannot be empty")
+	ErrContainsSpaces = errors.New("value cannot contain spaces")
+	ErrIDTooLong      = errors.New("id cannot be longer than 24 bytes")
+)
+
+func checkIDandName(c monitor.RawConfig) error {
+	switch {
+	case c["id"] == "":
+		return fmt.Errorf("id: %w", ErrEmptyValue)
+	case containsSpaces(c["id"]):
+		return fmt.Errorf("id: %w", ErrContainsSpaces)
+	case c["name"] == "":
+		return fmt.Errorf("name: %w", ErrEmptyValue)
+	case containsSpaces(c["name"]):
+		return fmt.Errorf("name: %w", ErrContainsSpaces)
+	case len(c["id"]) > 24:
+		return ErrIDTooLong
+	default:
+		return nil
+	}
+}
+
+func checkIDandNameGroup(input map[string]string) error {
+	switch {
+	case input["id"] == "":
+		return fmt.Errorf("id: %w", ErrEmptyValue)
+	case containsSpaces(input["id"]):
+		return fmt.Errorf("id: %w", ErrContainsSpaces)
+	case input["name"] == "":
+		return fmt.Errorf("name: %w", ErrEmptyValue)
+	case containsSpaces(input["name"]):
+		return fmt.Errorf("name. %w", ErrContainsSpaces)
+	default:
+		return nil
+	}
+}
+
+// GroupDelete handler to delete group.
+func GroupDelete(m *group.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		err := m.GroupDelete(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// GroupMonitorsRequest adds and removes monitors from a group.
+type GroupMonitorsRequest struct {
+	ID     string   `json:"id"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// GroupMonitors handler to update the monitors of a group,
+// responds with the new group configuration.
+func GroupMonitors(m *group.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req GroupMonitorsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, err := m.UpdateMonitors(req.ID, req.Add, req.Remove)
+		switch {
+		case errors.Is(err, group.ErrGroupNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// GroupLayout handler to set the view layout of a group.
+func GroupLayout(m *group.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		var layout group.Layout
+		if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err := m.SetLayout(id, layout)
+		switch {
+		case errors.Is(err, group.ErrGroupNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, group.ErrInvalidLayout),
+			errors.Is(err, group.ErrMonitorNotMember):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RecordingDelete deletes a recording.
+func RecordingDelete(recordingsDirs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/delete/")
+
+		recordingsDir := recordingsDirs[0]
+		if recPath, err := storage.RecordingIDToPath(recID); err == nil {
+			recordingsDir = storage.FindRecordingDir(recordingsDirs, recPath)
+		}
+		err := storage.DeleteRecording(recordingsDir, recID)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidRecordingID) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, storage.ErrRecordingFlagged) {
+				http.Error(w, "recording is flagged", http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// RecordingFlag flags the recording on PUT and clears the flag on DELETE.
+// Flagged recordings are exempt from retention and pruning.
+func RecordingFlag(recordingsDirs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var flagged bool
+		switch r.Method {
+		case http.MethodPut:
+			flagged = true
+		case http.MethodDelete:
+			flagged = false
+		default:
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/flag/")
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, recPath)
+
+		err = storage.FlagRecording(recordingsDir, recID, flagged)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// RecordingThumbnail serves thumbnail by exact recording ID.
+func RecordingThumbnail(recordingsDirs ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := r.URL.Path[25:] // Trim "/api/recording/thumbnail/"
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, recPath)
+		thumbPath := filepath.Join(recordingsDir, recPath+".jpeg")
+
+		// ServeFile will sanitize ".."
+		http.ServeFile(w, r, thumbPath)
+	})
+}
+
+// RecordingVideo serves video by exact recording ID. Range requests
+// are supported so that players can seek and interrupted downloads can
+// be resumed. Encrypted recordings are decrypted with crypt.
+func RecordingVideo(logger *log.Logger, crypt *storage.Crypt, recordingsDirs ...string) http.Handler {
+	videoReaderCache := storage.NewVideoCache()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := r.URL.Path[21:] // Trim "/api/recording/video/"
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, recPath)
+		path := filepath.Join(recordingsDir, recPath)
+		// Sanitize path.
+		if containsDotDot(path) {
+			http.Error(w, "invalid recording ID", http.StatusBadRequest)
+			return
+		}
+
+		mp4Path := path + ".mp4"
+
+		info, err := os.Stat(mp4Path)
+		if err == nil { // File exist.
+			setVideoHeaders(w, recID+".mp4", info.ModTime(), info.Size())
+			http.ServeFile(w, r, mp4Path)
+			return
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "stat mp4 file", http.StatusInternalServerError)
+			return
+		}
+
+		if storage.IsMKVRecording(path) {
+			serveMKV(w, r, logger, path+".mkv", recID+".mkv", crypt)
+			return
+		}
+
+		video, err := storage.NewVideoReader(path, videoReaderCache, crypt)
+		if err != nil {
+			logger.Log(log.Entry{
+				Level: log.LevelError,
+				Src:   "app",
+				Msg:   fmt.Sprintf("video request: %v", err),
+			})
+			http.Error(w, "see logs for details", http.StatusInternalServerError)
+			return
+		}
+		defer video.Close()
+
+		setVideoHeaders(w, recID+".mp4", video.ModTime(), video.Size())
+		ServeMP4Content(w, r, video.ModTime(), video.Size(), video)
+	})
+}
+
+// setVideoHeaders sets a strong ETag derived from the modification
+// time and size, download managers use it in the If-Range header
+// to resume downloads. The file name is used when saving the video.
+func setVideoHeaders(w http.ResponseWriter, name string, modTime time.Time, size int64) {
+	w.Header().Set("Etag", fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+}
+
+// serveMKV serves the decrypted Matroska file.
+func serveMKV(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *log.Logger,
+	path string,
+	name string,
+	crypt *storage.Crypt,
+) {
+	file, err := storage.OpenRecordingFile(path, crypt)
+	if err != nil {
+		logger.Log(log.Entry{
+			Level: log.LevelError,
+			Src:   "app",
+			Msg:   fmt.Sprintf("video request: %v", err),
+		})
+		http.Error(w, "see logs for details", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	w.Header().Set("Content-Type", "video/x-matroska")
+	setVideoHeaders(w, name, modTime, file.Size())
+	http.ServeContent(w, r, "", modTime, file)
+}
+
+func containsDotDot(v string) bool {
+	if !strings.Contains(v, "..") {
+		return false
+	}
+	for _, ent := range strings.FieldsFunc(v, isSlashRune) {
+		if ent == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
+
+// SegmentQueryFunc returns the indexed segments of
+// the monitor that overlap the time range.
+type SegmentQueryFunc func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo
+
+// RecordingExport streams the continuous recording segments of a
+// monitor between start and end as a single MP4 download. The
+// next segments are read ahead by the prefetcher, it may be nil.
+func RecordingExport(
+	query SegmentQueryFunc,
+	segmentsDirs []string,
+	crypt *storage.Crypt,
+	prefetcher *storage.Prefetcher,
+	logger log.ILogger,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID, start, end, ok := parseSegmentRange(w, r)
+		if !ok {
+			return
+		}
+
+		segments := query(monitorID, start, end)
+		if len(segments) == 0 {
+			http.Error(w, "no recordings in range", http.StatusNotFound)
+			return
+		}
+
+		filename := monitorID + "_" + start.Format("2006-01-02_15-04-05") + ".mp4"
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+		// The status can't be changed after the first write.
+		err := prefetcher.ExportSegments(w, segmentsDirs, crypt, segments, start, end)
+		if errors.Is(err, storage.ErrExportNoSegments) {
+			http.Error(w, "no recordings in range", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: monitorID,
+				Msg:       fmt.Sprintf("recording export: %v", err),
+			})
+		}
+	})
+}
+
+// parseSegmentRange parses the monitor, start and end query parameters.
+// Responds with an error and returns false if they are invalid.
+func parseSegmentRange(w http.ResponseWriter, r *http.Request) (string, time.Time, time.Time, bool) {
+	monitorID := r.URL.Query().Get("monitor")
+	if monitorID == "" {
+		http.Error(w, "monitor missing", http.StatusBadRequest)
+		return "", time.Time{}, time.Time{}, false
+	}
+	start, end, ok := parseTimeRange(w, r)
+	return monitorID, start, end, ok
+}
+
+// parseTimeRange parses the RFC 3339 start and end query parameters.
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	start, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("start"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid start: %v", err), http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("end"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid end: %v", err), http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	if !end.After(start) {
+		http.Error(w, "end must be after start", http.StatusBadRequest)
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// RecordingScan streams the keyframes of the continuous recording
+// segments in the time range as a fragmented MP4 that plays at the
+// requested speed. Used for fast scrubbing through long periods.
+func RecordingScan(
+	query SegmentQueryFunc,
+	segmentsDirs []string,
+	crypt *storage.Crypt,
+	logger log.ILogger,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID, start, end, ok := parseSegmentRange(w, r)
+		if !ok {
+			return
+		}
+		speed := 8.0
+		if raw := r.URL.Query().Get("speed"); raw != "" {
+			var err error
+			speed, err = strconv.ParseFloat(raw, 64)
+			if err != nil || !(speed >= storage.MinScanSpeed && speed <= storage.MaxScanSpeed) {
+				http.Error(w, fmt.Sprintf("invalid speed: %q, must be between %v and %v",
+					raw, storage.MinScanSpeed, storage.MaxScanSpeed), http.StatusBadRequest)
+				return
+			}
+		}
+
+		segments := query(monitorID, start, end)
+		if len(segments) == 0 {
+			http.Error(w, "no recordings in range", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "video/mp4")
+
+		// The status can't be changed after the first write.
+		err := storage.ScanSegments(w, segmentsDirs, crypt, segments, start, end, speed)
+		if errors.Is(err, storage.ErrExportNoSegments) {
+			http.Error(w, "no keyframes in range", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Log(log.Entry{
+				Level:     log.LevelError,
+				Src:       "app",
+				MonitorID: monitorID,
+				Msg:       fmt.Sprintf("recording scan: %v", err),
+			})
+		}
+	})
+}
+
+// RecordingVerify compares the hash of the recording to the
+// integrity ledger and responds with the verification as JSON.
+func RecordingVerify(
+	ledger *storage.Ledger,
+	crypt *storage.Crypt,
+	recordingsDirs ...string,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		recID := strings.TrimPrefix(r.URL.Path, "/api/recording/verify/")
+		recPath, err := storage.RecordingIDToPath(recID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start, err := time.ParseInLocation("2006-01-02_15-04-05", recID[:19], time.Local)
+		if err != nil || containsDotDot(recPath) {
+			http.Error(w, "invalid recording ID", http.StatusBadRequest)
+			return
+		}
+		monitorID := recID[20:]
+
+		recordingsDir := storage.FindRecordingDir(recordingsDirs, recPath)
+		hash, err := storage.HashRecording(filepath.Join(recordingsDir, recPath), crypt)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("hash recording: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		verification, err := ledger.Verify(monitorID, storage.LedgerRecording, recID, hash, start)
+		serveVerification(w, verification, err)
+	})
+}
+
+// SegmentVerify compares the hash of the continuous recording segment
+// to the integrity ledger and responds with the verification as JSON.
+func SegmentVerify(
+	ledger *storage.Ledger,
+	index *storage.Index,
+	crypt *storage.Crypt,
+	segmentsDirs []string,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		monitorID := r.URL.Query().Get("monitor")
+		path := r.URL.Query().Get("path")
+		seg, exist := index.Get(monitorID, path)
+		if !exist {
+			http.Error(w, "segment not found", http.StatusNotFound)
+			return
+		}
+		if seg.Tier >= len(segmentsDirs) {
+			http.Error(w, "invalid segment tier", http.StatusInternalServerError)
+			return
+		}
+
+		segPath := filepath.Join(segmentsDirs[seg.Tier], seg.Path)
+		hash, err := storage.HashSegment(segPath, seg.Size, crypt)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("hash segment: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		verification, err := ledger.Verify(monitorID, storage.LedgerSegment, seg.Path, hash, seg.Start)
+		serveVerification(w, verification, err)
+	})
+}
+
+func serveVerification(w http.ResponseWriter, v *storage.Verification, err error) {
+	if errors.Is(err, storage.ErrNotInLedger) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("verify: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, "could not encode json", http.StatusInternalServerError)
+	}
+}
+
+// IntegrityKey serves the hex encoded public key of the
+// integrity ledger signatures. 404 if signing is disabled.
+func IntegrityKey(ledger *storage.Ledger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		key := ledger.PublicKey()
+		if key == "" {
+			http.Error(w, "signing is disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, key)
+	})
+}
+
+// RecordingQuery handles recording query.
+func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler { //nolint:funlen
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		limit := query.Get("limit")
+		if limit == "" {
+			http.Error(w, "limit missing", http.StatusBadRequest)
+			return
+		}
+
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		time := query.Get("time")
+		if time == "" {
+			http.Error(w, "time missing", http.StatusBadRequest)
+			return
+		}
+		if len(time) < 19 {
+			http.Error(w, "time value to short", http.StatusBadRequest)
+			return
+		}
+		reverse := query.Get("reverse")
+
+		monitorsCSV := query.Get("monitors")
+
+		var monitors []string
+		if monitorsCSV != "" {
+			monitors = strings.Split(monitorsCSV, ",")
+		}
+
+		var data bool
+		if query.Get("data") == "true" {
+			data = true
+		}
+
+		q := &storage.CrawlerQuery{
+			Time:        time,
+			Limit:       limitInt,
+			Reverse:     reverse == "true",
+			Monitors:    monitors,
+			IncludeData: data,
+		}
+
+		recordings, err := crawler.RecordingByQuery(q)
+		if err != nil {
+			logger.Log(log.Entry{
+				Level: log.LevelError,
+				Src:   "app",
+				Msg:   fmt.Sprintf("crawler: could not process recording query: %v", err),
+			})
+			http.Error(w, "could not process recording query", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(recordings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// BackchannelURLFunc returns the backchannel url of a monitor.
+type BackchannelURLFunc func(monitorID string) (string, error)
+
+// Backchannel audio output of a camera.
+type Backchannel interface {
+	Codec() string
+	WritePCM([]int16) error
+	Close() error
+}
+
+// BackchannelDialFunc connects to the backchannel of a camera.
+type BackchannelDialFunc func(ctx context.Context, url string) (Backchannel, error)
+
+// talkInfo first websocket message.
+type talkInfo struct {
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sampleRate"`
+}
+
+// Maximum size of a single audio message, 1 second.
+const talkMaxMessageSize = 2 * onvif.BackchannelSampleRate
+
+// MonitorTalk opens a websocket that forwards audio to the camera
+// backchannel. The client sends binary messages with 16 bit
+// little-endian mono PCM samples at 8000Hz.
+func MonitorTalk(getURL BackchannelURLFunc, dial BackchannelDialFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		recID := r.URL.Path[21:] // Trim "/api/recording/video/"
-		recPath, err := storage.RecordingIDToPath(recID)
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "id missing", http.StatusBadRequest)
+			return
+		}
+
+		backchannelURL, err := getURL(id)
+		switch {
+		case errors.Is(err, monitor.ErrMonitorNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b, err := dial(r.Context(), backchannelURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer b.Close()
+
+		// The upgrader rejects cross origin requests.
+		upgrader := websocket.Upgrader{}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetReadLimit(talkMaxMessageSize)
+
+		info := talkInfo{Codec: b.Codec(), SampleRate: onvif.BackchannelSampleRate}
+		if err := c.WriteJSON(info); err != nil {
+			return
+		}
+
+		for {
+			msgType, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType != websocket.BinaryMessage {
+				continue
+			}
+			samples := make([]int16, len(msg)/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(msg[i*2:]))
+			}
+			if err := b.WritePCM(samples); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// LogFeed opens a websocket with system logs.
+func LogFeed(logger *log.Logger, a auth.Authenticator) http.Handler { //nolint:funlen,gocognit
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+
+		levels, err := parseLogLevels(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sources := parseCSVParam(query, "sources")
+		monitors := parseCSVParam(query, "monitors")
+
+		q := log.Query{
+			Levels:   levels,
+			Sources:  sources,
+			Monitors: monitors,
+		}
+
+		upgrader := websocket.Upgrader{}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer c.Close()
+
+		feed, cancel := logger.Subscribe()
+		defer cancel()
+
+		for {
+			var entry log.Entry
+			select {
+			case entry = <-feed:
+			case <-logger.Ctx.Done():
+				return
+			}
+
+			if !log.LevelInLevels(entry.Level, q.Levels) {
+				continue
+			}
+			if !log.StringInStrings(entry.Src, q.Sources) {
+				continue
+			}
+			if !log.StringInStrings(entry.MonitorID, q.Monitors) {
+				continue
+			}
+
+			// Validate auth before each message.
+			auth := a.ValidateRequest(r)
+			if !auth.IsValid || !auth.User.IsAdmin {
+				return
+			}
+
+			if err := c.WriteJSON(entry); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	})
+}
+
+// eventStreamPing keepalive interval of the event stream, proxies
+// usually close idle connections after a minute.
+const eventStreamPing = 30 * time.Second
+
+// EventStream streams live events, monitor states and system notices. The
+// stream is a websocket if the request is a websocket upgrade, otherwise
+// server-sent events are used. Notices are only sent to admins.
+func EventStream(f *feed.Feed, validate func(*http.Request) auth.ValidateResponse) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		filter := feed.Filter{
+			Types:    parseCSVParam(query, "types"),
+			Monitors: parseCSVParam(query, "monitors"),
+		}
+
+		// Subscribe before the response is sent to not miss any messages.
+		messages, cancel := f.Subscribe()
+		defer cancel()
+		stream := eventStream{
+			r:        r,
+			messages: messages,
+			filter:   filter,
+			validate: validate,
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			stream.websocket(w)
+			return
+		}
+		stream.sse(w)
+	})
+}
+
+type eventStream struct {
+	r        *http.Request
+	messages <-chan feed.Message
+	filter   feed.Filter
+	validate func(*http.Request) auth.ValidateResponse
+}
+
+func (s eventStream) websocket(w http.ResponseWriter) {
+	// The upgrader rejects cross origin requests.
+	upgrader := websocket.Upgrader{}
+	c, err := upgrader.Upgrade(w, s.r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	// The client doesn't send anything, reading
+	// is only used to detect when it's closed.
+	ctx, cancel := context.WithCancel(s.r.Context())
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	s.run(ctx,
+		func(msg feed.Message) error {
+			return c.WriteJSON(msg)
+		},
+		func() error {
+			return c.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		},
+	)
+}
+
+func (s eventStream) sse(w http.ResponseWriter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.run(s.r.Context(),
+		func(msg feed.Message) error {
+			raw, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", msg.Type, raw); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+		func() error {
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+	)
+}
+
+// run sends the messages until the context is canceled or a
+// write fails. The auth is validated before each message.
+func (s eventStream) run(ctx context.Context, send func(feed.Message) error, ping func() error) {
+	ticker := time.NewTicker(eventStreamPing)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return
+			}
+		case msg := <-s.messages:
+			if !s.filter.Match(msg) {
+				continue
+			}
+			auth := s.validate(s.r)
+			if !auth.IsValid {
+				return
+			}
+			if msg.Type == feed.TypeNotice && !auth.User.IsAdmin {
+				continue
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// LogQuery handles log queries.
+func LogQuery(logStore *log.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+
+		limit := query.Get("limit")
+		if limit == "" {
+			http.Error(w, "limit missing", http.StatusBadRequest)
+			return
+		}
+
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		levels, err := parseLogLevels(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sources := parseCSVParam(query, "sources")
+		monitors := parseCSVParam(query, "monitors")
+
+		time := query.Get("time")
+		timeInt, err := strconv.Atoi(time)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not convert time to int: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		q := log.Query{
+			Levels:   levels,
+			Sources:  sources,
+			Monitors: monitors,
+			Time:     log.UnixMicro(timeInt),
+			Limit:    limitInt,
+		}
+
+		logs, err := logStore.Query(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		err = json.NewEncoder(w).Encode(logs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// parseLogLevels parses the "levels" list of numeric log levels.
+func parseLogLevels(query url.Values) ([]log.Level, error) {
+	levelsCSV := query.Get("levels")
+	if levelsCSV == "" {
+		return nil, nil
+	}
+	var levels []log.Level
+	for _, levelStr := range strings.Split(levelsCSV, ",") {
+		levelInt, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid levels list: %v %w", levelsCSV, err)
+		}
+		levels = append(levels, log.Level(levelInt))
+	}
+	return levels, nil
+}
+
+// Log tail limits.
+const (
+	defaultLogTailLimit = 100
+	maxLogTailLimit     = 10000
+)
+
+// LogTail responds with the most recent logs that match the query,
+// oldest first. One entry per line in the text or JSON log format.
+//
+//	/api/log/tail?monitors=a,b&sources=monitor&levels=16,24&limit=100&format=json
+func LogTail(logStore *log.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+
+		limit := defaultLogTailLimit
+		if raw := query.Get("limit"); raw != "" {
+			var err error
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxLogTailLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %v", maxLogTailLimit),
+					http.StatusBadRequest)
+				return
+			}
+		}
+
+		levels, err := parseLogLevels(query)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		path := filepath.Join(recordingsDir, recPath)
-		// Sanitize path.
-		if containsDotDot(path) {
-			http.Error(w, "invalid recording ID", http.StatusBadRequest)
+
+		format := log.Format(query.Get("format"))
+		switch format {
+		case "", log.FormatText:
+			format = log.FormatText
+		case log.FormatJSON:
+		default:
+			http.Error(w, "format must be 'text' or 'json'", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := logStore.Query(log.Query{
+			Levels:   levels,
+			Sources:  parseCSVParam(query, "sources"),
+			Monitors: parseCSVParam(query, "monitors"),
+			Time:     log.UnixMicro(time.Now().UnixMicro()),
+			Limit:    limit,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		mp4Path := path + ".mp4"
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for i := len(entries) - 1; i >= 0; i-- {
+			if err := format.Encode(w, entries[i]); err != nil {
+				return
+			}
+		}
+	})
+}
 
-		_, err = os.Stat(mp4Path)
-		if err == nil { // File exist.
-			http.ServeFile(w, r, mp4Path)
+// LogLevels responds with the maximum level of each log source.
+func LogLevels(logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
-		if !errors.Is(err, os.ErrNotExist) {
-			http.Error(w, "stat mp4 file", http.StatusInternalServerError)
+		writeLogLevels(w, logger)
+	})
+}
+
+// LogLevelsSet sets the maximum level of the log sources in the request
+// body until the app is restarted. Nothing is changed if one is invalid.
+func LogLevelsSet(logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		video, err := storage.NewVideoReader(path, videoReaderCache)
-		if err != nil {
-			logger.Log(log.Entry{
-				Level: log.LevelError,
-				Src:   "app",
-				Msg:   fmt.Sprintf("video request: %v", err),
-			})
-			http.Error(w, "see logs for details", http.StatusInternalServerError)
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		defer video.Close()
 
-		ServeMP4Content(w, r, video.ModTime(), video.Size(), video)
+		current := logger.Levels()
+		levels := make(map[string]log.Level, len(req))
+		for src, name := range req {
+			level, err := log.ParseLevel(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, exist := current[src]; !exist {
+				http.Error(w, fmt.Sprintf("%v: %q", log.ErrUnknownSource, src), http.StatusBadRequest)
+				return
+			}
+			levels[src] = level
+		}
+		for src, level := range levels {
+			if err := logger.SetLevel(src, level); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		writeLogLevels(w, logger)
 	})
 }
 
-func containsDotDot(v string) bool {
-	if !strings.Contains(v, "..") {
-		return false
+func writeLogLevels(w http.ResponseWriter, logger *log.Logger) {
+	levels := make(map[string]string)
+	for src, level := range logger.Levels() {
+		levels[src] = level.String()
 	}
-	for _, ent := range strings.FieldsFunc(v, isSlashRune) {
-		if ent == ".." {
-			return true
-		}
+	w.Header().Set("Content-Type", jsonContentType)
+	if err := json.NewEncoder(w).Encode(levels); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
-	return false
 }
 
-func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
+// Event query limits.
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
 
-// RecordingQuery handles recording query.
-func RecordingQuery(crawler *storage.Crawler, logger *log.Logger) http.Handler { //nolint:funlen
+// Events responds with the events in the event store that match the
+// query parameters as JSON, newest first. The events are paginated by
+// limit and offset. Counts per hour or day are returned instead if
+// aggregate is set, day boundaries are in the location.
+//
+//	/api/events?monitors=a,b&labels=person&minScore=50&start=x&end=y&limit=100&offset=0
+//	/api/events?aggregate=hour&start=x&end=y
+func Events(store *storage.EventStore, loc *time.Location) http.Handler { //nolint:funlen
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 		query := r.URL.Query()
-		limit := query.Get("limit")
-		if limit == "" {
-			http.Error(w, "limit missing", http.StatusBadRequest)
-			return
-		}
 
-		limitInt, err := strconv.Atoi(limit)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
-			return
+		q := storage.EventQuery{
+			Monitors: parseCSVParam(query, "monitors"),
+			Labels:   parseCSVParam(query, "labels"),
+			Reverse:  query.Get("order") == "asc",
+			Limit:    defaultEventLimit,
 		}
 
-		time := query.Get("time")
-		if time == "" {
-			http.Error(w, "time missing", http.StatusBadRequest)
-			return
-		}
-		if len(time) < 19 {
-			http.Error(w, "time value to short", http.StatusBadRequest)
-			return
+		var err error
+		if raw := query.Get("minScore"); raw != "" {
+			q.MinScore, err = strconv.ParseFloat(raw, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid minScore: %q", raw), http.StatusBadRequest)
+				return
+			}
 		}
-		reverse := query.Get("reverse")
-
-		monitorsCSV := query.Get("monitors")
-
-		var monitors []string
-		if monitorsCSV != "" {
-			monitors = strings.Split(monitorsCSV, ",")
+		for key, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+			if raw := query.Get(key); raw != "" {
+				*t, err = time.Parse(time.RFC3339Nano, raw)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %v: %v", key, err), http.StatusBadRequest)
+					return
+				}
+			}
 		}
-
-		var data bool
-		if query.Get("data") == "true" {
-			data = true
+		if raw := query.Get("limit"); raw != "" {
+			q.Limit, err = strconv.Atoi(raw)
+			if err != nil || q.Limit <= 0 || q.Limit > maxEventLimit {
+				http.Error(w, fmt.Sprintf("invalid limit: %q", raw), http.StatusBadRequest)
+				return
+			}
 		}
-
-		q := &storage.CrawlerQuery{
-			Time:        time,
-			Limit:       limitInt,
-			Reverse:     reverse == "true",
-			Monitors:    monitors,
-			IncludeData: data,
+		if raw := query.Get("offset"); raw != "" {
+			q.Offset, err = strconv.Atoi(raw)
+			if err != nil || q.Offset < 0 {
+				http.Error(w, fmt.Sprintf("invalid offset: %q", raw), http.StatusBadRequest)
+				return
+			}
 		}
 
-		recordings, err := crawler.RecordingByQuery(q)
-		if err != nil {
-			logger.Log(log.Entry{
-				Level: log.LevelError,
-				Src:   "app",
-				Msg:   fmt.Sprintf("crawler: could not process recording query: %v", err),
-			})
-			http.Error(w, "could not process recording query", http.StatusInternalServerError)
-			return
+		var res interface{}
+		if interval := query.Get("aggregate"); interval != "" {
+			counts, err := store.Aggregate(q, interval, loc)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			res = struct {
+				Counts []storage.EventCount `json:"counts"`
+			}{counts}
+		} else {
+			events, total := store.Query(q)
+			res = struct {
+				Events []storage.EventRecord `json:"events"`
+				Total  int                   `json:"total"`
+			}{events, total}
 		}
 
 		w.Header().Set("Content-Type", jsonContentType)
-		err = json.NewEncoder(w).Encode(recordings)
-		if err != nil {
+		if err := json.NewEncoder(w).Encode(res); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-// LogFeed opens a websocket with system logs.
-func LogFeed(logger *log.Logger, a auth.Authenticator) http.Handler { //nolint:funlen,gocognit
+func parseCSVParam(query url.Values, key string) []string {
+	CSV := query.Get(key)
+	var monitors []string
+	if CSV != "" {
+		monitors = strings.Split(CSV, ",")
+	}
+	return monitors
+}
+
+// Bookmarks responds with the bookmarks that match the query
+// parameters as JSON, newest first, and all the tags in use.
+// Bookmarks must have all the tags, q matches the name or note.
+//
+//	/api/bookmarks?monitors=a,b&tags=x,y&q=text&start=x&end=y&limit=100&offset=0
+func Bookmarks(store *storage.BookmarkStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
@@ -588,146 +2544,189 @@ func LogFeed(logger *log.Logger, a auth.Authenticator) http.Handler { //nolint:f
 		}
 		query := r.URL.Query()
 
-		levelsCSV := query.Get("levels")
-		var levels []log.Level
-		if levelsCSV != "" {
-			for _, levelStr := range strings.Split(levelsCSV, ",") {
-				levelInt, err := strconv.Atoi(levelStr)
+		q := storage.BookmarkQuery{
+			Monitors: parseCSVParam(query, "monitors"),
+			Tags:     parseCSVParam(query, "tags"),
+			Text:     query.Get("q"),
+			Reverse:  query.Get("order") == "asc",
+			Limit:    defaultEventLimit,
+		}
+
+		var err error
+		for key, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+			if raw := query.Get(key); raw != "" {
+				*t, err = time.Parse(time.RFC3339Nano, raw)
 				if err != nil {
-					http.Error(w,
-						fmt.Sprintf("invalid levels list: %v %v", levelsCSV, err),
-						http.StatusBadRequest)
+					http.Error(w, fmt.Sprintf("invalid %v: %v", key, err), http.StatusBadRequest)
+					return
 				}
-				levels = append(levels, log.Level(levelInt))
+			}
+		}
+		if raw := query.Get("limit"); raw != "" {
+			q.Limit, err = strconv.Atoi(raw)
+			if err != nil || q.Limit <= 0 || q.Limit > maxEventLimit {
+				http.Error(w, fmt.Sprintf("invalid limit: %q", raw), http.StatusBadRequest)
+				return
+			}
+		}
+		if raw := query.Get("offset"); raw != "" {
+			q.Offset, err = strconv.Atoi(raw)
+			if err != nil || q.Offset < 0 {
+				http.Error(w, fmt.Sprintf("invalid offset: %q", raw), http.StatusBadRequest)
+				return
 			}
 		}
 
-		sources := parseCSVParam(query, "sources")
-		monitors := parseCSVParam(query, "monitors")
+		bookmarks, total := store.Query(q)
+		res := struct {
+			Bookmarks []storage.Bookmark `json:"bookmarks"`
+			Total     int                `json:"total"`
+			Tags      []string           `json:"tags"`
+		}{bookmarks, total, store.Tags()}
 
-		q := log.Query{
-			Levels:   levels,
-			Sources:  sources,
-			Monitors: monitors,
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+	})
+}
 
-		upgrader := websocket.Upgrader{}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// BookmarkSet creates the bookmark in the request body if the ID is
+// zero, otherwise the bookmark is updated. Responds with the saved
+// bookmark. The account must be allowed to access the monitor.
+func BookmarkSet(store *storage.BookmarkStore, a auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
-		defer c.Close()
 
-		feed, cancel := logger.Subscribe()
-		defer cancel()
+		var b storage.Bookmark
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		for {
-			var entry log.Entry
-			select {
-			case entry = <-feed:
-			case <-logger.Ctx.Done():
+		account := a.ValidateRequest(r).User
+		if !account.MonitorAllowed(b.MonitorID) {
+			http.Error(w, fmt.Sprintf("monitor not allowed: %v", b.MonitorID), http.StatusForbidden)
+			return
+		}
+		if b.ID != 0 {
+			old, err := store.Get(b.ID)
+			if errors.Is(err, storage.ErrBookmarkNotExist) {
+				http.Error(w, err.Error(), http.StatusNotFound)
 				return
 			}
-
-			if !log.LevelInLevels(entry.Level, q.Levels) {
-				continue
-			}
-			if !log.StringInStrings(entry.Src, q.Sources) {
-				continue
-			}
-
-			// Validate auth before each message.
-			auth := a.ValidateRequest(r)
-			if !auth.IsValid || !auth.User.IsAdmin {
+			if !account.MonitorAllowed(old.MonitorID) {
+				http.Error(w, fmt.Sprintf("monitor not allowed: %v", old.MonitorID), http.StatusForbidden)
 				return
 			}
+		}
+		b.CreatedBy = account.Username
 
-			if err := c.WriteJSON(entry); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		saved, err := store.Set(b)
+		switch {
+		case errors.Is(err, storage.ErrInvalidBookmark):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, storage.ErrBookmarkNotExist):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+		if err := json.NewEncoder(w).Encode(saved); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	})
 }
 
-// LogQuery handles log queries.
-func LogQuery(logStore *log.Store) http.Handler {
+// BookmarkDelete deletes the bookmark with the id query parameter.
+func BookmarkDelete(store *storage.BookmarkStore, a auth.Authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+		if r.Method != http.MethodDelete {
 			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
-		query := r.URL.Query()
 
-		limit := query.Get("limit")
-		if limit == "" {
-			http.Error(w, "limit missing", http.StatusBadRequest)
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
 			return
 		}
-
-		limitInt, err := strconv.Atoi(limit)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert limit to int: %v", err), http.StatusBadRequest)
+		b, err := store.Get(id)
+		if errors.Is(err, storage.ErrBookmarkNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-
-		levelsCSV := query.Get("levels")
-		var levels []log.Level
-		if levelsCSV != "" {
-			for _, levelStr := range strings.Split(levelsCSV, ",") {
-				levelInt, err := strconv.Atoi(levelStr)
-				if err != nil {
-					http.Error(w,
-						fmt.Sprintf("invalid levels list: %v %v", levelsCSV, err),
-						http.StatusBadRequest)
-				}
-				levels = append(levels, log.Level(levelInt))
-			}
+		if !a.ValidateRequest(r).User.MonitorAllowed(b.MonitorID) {
+			http.Error(w, fmt.Sprintf("monitor not allowed: %v", b.MonitorID), http.StatusForbidden)
+			return
+		}
+		if err := store.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+	})
+}
 
-		sources := parseCSVParam(query, "sources")
-		monitors := parseCSVParam(query, "monitors")
+const defaultAuditLimit = 100
 
-		time := query.Get("time")
-		timeInt, err := strconv.Atoi(time)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("could not convert time to int: %v", err), http.StatusBadRequest)
+// AuditQuery handles audit log queries, newest events first.
+func AuditQuery(audit *auth.AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
+		query := r.URL.Query()
 
-		q := log.Query{
-			Levels:   levels,
-			Sources:  sources,
-			Monitors: monitors,
-			Time:     log.UnixMicro(timeInt),
-			Limit:    limitInt,
+		q := auth.AuditQuery{
+			Types:    parseCSVParam(query, "types"),
+			Username: query.Get("username"),
+			Limit:    defaultAuditLimit,
+		}
+		var err error
+		if raw := query.Get("limit"); raw != "" {
+			q.Limit, err = strconv.Atoi(raw)
+			if err != nil || q.Limit < 1 {
+				http.Error(w, fmt.Sprintf("invalid limit: %q", raw), http.StatusBadRequest)
+				return
+			}
+		}
+		for key, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+			if raw := query.Get(key); raw != "" {
+				*t, err = time.Parse(time.RFC3339Nano, raw)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %v: %v", key, err), http.StatusBadRequest)
+					return
+				}
+			}
 		}
 
-		logs, err := logStore.Query(q)
+		events, err := audit.Query(q)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if events == nil {
+			events = []auth.AuditEvent{}
+		}
 
 		w.Header().Set("Content-Type", jsonContentType)
-		err = json.NewEncoder(w).Encode(logs)
-		if err != nil {
+		if err := json.NewEncoder(w).Encode(events); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
 
-func parseCSVParam(query url.Values, key string) []string {
-	CSV := query.Get(key)
-	var monitors []string
-	if CSV != "" {
-		monitors = strings.Split(CSV, ",")
-	}
-	return monitors
-}
-
 // LogSources handles list of log sources.
 func LogSources(l *log.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,33 @@
 package web
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"nvr/pkg/feed"
+	"nvr/pkg/health"
+	"nvr/pkg/log"
+	"nvr/pkg/monitor"
+	"nvr/pkg/onvif"
+	"nvr/pkg/storage"
+	"nvr/pkg/thumbnail"
+	"nvr/pkg/video"
+	"nvr/pkg/video/hls"
+	"nvr/pkg/web/auth"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 )
 
@@ -24,3 +48,928 @@ func TestParseCSVParam(t *testing.T) {
 		})
 	}
 }
+
+type stubAuthenticator struct {
+	auth.Authenticator
+	valid bool
+	user  auth.Account
+}
+
+func (a stubAuthenticator) ValidateRequest(*http.Request) auth.ValidateResponse {
+	return auth.ValidateResponse{IsValid: a.valid, User: a.user}
+}
+
+func TestHealth(t *testing.T) {
+	report := health.Report{
+		Status: health.StatusFailed,
+		Components: map[string]health.Component{
+			"storage": {Status: health.StatusFailed, Reason: "a", Critical: true},
+		},
+	}
+	cases := map[string]struct {
+		status   health.Status
+		valid    bool
+		code     int
+		expected string
+	}{
+		"ok":     {health.StatusOK, false, http.StatusOK, `{"status":"ok"}`},
+		"failed": {health.StatusFailed, false, http.StatusServiceUnavailable, `{"status":"failed"}`},
+		"authenticated": {
+			health.StatusFailed, true, http.StatusServiceUnavailable,
+			`{"status":"failed","components":{"storage":{"status":"failed","reason":"a","critical":true}}}`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rep := report
+			rep.Status = tc.status
+			handler := Health(func() health.Report { return rep }, stubAuthenticator{valid: tc.valid})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			require.Equal(t, tc.code, w.Code)
+			require.JSONEq(t, tc.expected, w.Body.String())
+		})
+	}
+}
+
+func TestMonitorStats(t *testing.T) {
+	stats := func(pathName string) ([]video.TrackStats, error) {
+		switch pathName {
+		case "1":
+			return []video.TrackStats{{Media: "video", PacketsReceived: 1}}, nil
+		case "1_sub":
+			return nil, video.ErrPathNoOnePublishing
+		}
+		return nil, video.ErrPathNotExist
+	}
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/monitor/stats?id="+id, nil)
+		w := httptest.NewRecorder()
+		MonitorStats(stats).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("1")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res map[string][]video.TrackStats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, map[string][]video.TrackStats{
+			"main": {{Media: "video", PacketsReceived: 1}},
+			"sub":  nil,
+		}, res)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, request("2").Code)
+	})
+	t.Run("idMissing", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, request("").Code)
+	})
+}
+
+func TestMonitorStatsHistory(t *testing.T) {
+	sample := video.StatsSample{
+		Time:   time.Unix(10, 0).UTC(),
+		Online: true,
+		Tracks: []video.TrackStats{{Media: "video", FrameRate: 15}},
+	}
+	history := func(pathName string) (video.StatsHistory, error) {
+		if pathName == "1" {
+			return video.StatsHistory{
+				Samples:           []video.StatsSample{sample},
+				ResolutionChanges: []video.ResolutionChange{},
+			}, nil
+		}
+		return video.StatsHistory{}, video.ErrPathNotExist
+	}
+
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		MonitorStatsHistory(history).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("/api/monitor/1/stats")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Interval float64             `json:"interval"`
+			Main     video.StatsHistory  `json:"main"`
+			Sub      *video.StatsHistory `json:"sub"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, float64(10), res.Interval)
+		require.Equal(t, []video.StatsSample{sample}, res.Main.Samples)
+		require.Nil(t, res.Sub)
+	})
+	t.Run("notExist", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, request("/api/monitor/2/stats").Code)
+	})
+	t.Run("idMissing", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, request("/api/monitor/stats").Code)
+	})
+}
+
+func TestMonitorViewers(t *testing.T) {
+	stats := func(pathName string) (hls.ViewerStats, error) {
+		switch pathName {
+		case "1":
+			return hls.ViewerStats{Viewers: 2, Starvations: 1}, nil
+		case "2":
+			return hls.ViewerStats{}, video.ErrPathNoOnePublishing
+		}
+		return hls.ViewerStats{}, video.ErrPathNotExist
+	}
+
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		MonitorViewers(stats).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("/api/monitor/1/viewers")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Main *hls.ViewerStats `json:"main"`
+			Sub  *hls.ViewerStats `json:"sub"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		require.Equal(t, &hls.ViewerStats{Viewers: 2, Starvations: 1}, res.Main)
+		require.Nil(t, res.Sub)
+	})
+	t.Run("notPublishing", func(t *testing.T) {
+		w := request("/api/monitor/2/viewers")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `{"main":null,"sub":null}`+"\n", w.Body.String())
+	})
+	t.Run("notExist", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, request("/api/monitor/3/viewers").Code)
+	})
+}
+
+func TestOnvifProbe(t *testing.T) {
+	probe := func(_ context.Context, xaddr, username, password string) (*onvif.DeviceDetails, error) {
+		if password != "pass" {
+			return nil, onvif.ErrSOAPFault
+		}
+		return &onvif.DeviceDetails{XAddr: xaddr}, nil
+	}
+
+	request := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/onvif/probe", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		OnvifProbe(probe).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request(`{"xaddr":"http://x","username":"admin","password":"pass"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var details onvif.DeviceDetails
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&details))
+		require.Equal(t, "http://x", details.XAddr)
+	})
+	t.Run("probeErr", func(t *testing.T) {
+		w := request(`{"xaddr":"http://x","username":"admin","password":"wrong"}`)
+		require.Equal(t, http.StatusBadGateway, w.Code)
+	})
+	t.Run("invalidBody", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, request("{").Code)
+	})
+}
+
+type stubBackchannel struct {
+	samples chan []int16
+	closed  chan struct{}
+}
+
+func (b *stubBackchannel) Codec() string { return "PCMU" }
+
+func (b *stubBackchannel) WritePCM(samples []int16) error {
+	b.samples <- samples
+	return nil
+}
+
+func (b *stubBackchannel) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestMonitorTalk(t *testing.T) {
+	getURL := func(id string) (string, error) {
+		switch id {
+		case "1":
+			return "rtsp://x", nil
+		case "2":
+			return "", monitor.ErrBackchannelDisabled
+		}
+		return "", monitor.ErrMonitorNotExist
+	}
+	b := &stubBackchannel{
+		samples: make(chan []int16, 1),
+		closed:  make(chan struct{}),
+	}
+	dial := func(_ context.Context, url string) (Backchannel, error) {
+		require.Equal(t, "rtsp://x", url)
+		return b, nil
+	}
+	server := httptest.NewServer(MonitorTalk(getURL, dial))
+	defer server.Close()
+
+	t.Run("ok", func(t *testing.T) {
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?id=1"
+		c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+
+		var info talkInfo
+		require.NoError(t, c.ReadJSON(&info))
+		require.Equal(t, talkInfo{Codec: "PCMU", SampleRate: 8000}, info)
+
+		msg := []byte{1, 0, 0xff, 0xff}
+		require.NoError(t, c.WriteMessage(websocket.BinaryMessage, msg))
+		require.Equal(t, []int16{1, -1}, <-b.samples)
+
+		c.Close()
+		select {
+		case <-b.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("backchannel wasn't closed")
+		}
+	})
+	t.Run("notExist", func(t *testing.T) {
+		res, err := http.Get(server.URL + "?id=3")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+	t.Run("disabled", func(t *testing.T) {
+		res, err := http.Get(server.URL + "?id=2")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestRecordingVideo(t *testing.T) {
+	const recID = "2000-01-01_00-00-00_m1"
+	dir := t.TempDir()
+	recPath := filepath.Join(dir, "2000/01/01/m1", recID)
+	require.NoError(t, os.MkdirAll(filepath.Dir(recPath), 0o700))
+
+	meta := []byte{
+		0,    // Version.
+		0, 7, // Video sps size.
+		103, 0, 0, 0, 172, 217, 0, // Video sps.
+		0, 3, // Video pps size.
+		2, 3, 4, // Video pps.
+		0, 0, // Audio config size.
+		0, 0, 0, 0, 0, 0, 0, 0, // Start time.
+
+		// Sample.
+		0,                      // Flags.
+		0, 0, 0, 0, 0, 0, 0, 0, // PTS.
+		0, 0, 0, 0, 0, 0, 0, 0, // DTS.
+		0, 0, 0, 0, 0, 0, 0, 0, // Next dts.
+		0, 0, 0, 0, // Offset.
+		0, 0, 0, 4, // Size.
+	}
+	require.NoError(t, os.WriteFile(recPath+".meta", meta, 0o600))
+	require.NoError(t, os.WriteFile(recPath+".mdat", []byte{0, 0, 0, 1}, 0o600))
+
+	handler := RecordingVideo(nil, nil, dir)
+	request := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/recording/video/"+recID, nil)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	head := request(http.MethodHead, nil)
+	require.Equal(t, http.StatusOK, head.Code)
+	require.Equal(t, "bytes", head.Header().Get("Accept-Ranges"))
+	require.Equal(t, `inline; filename="`+recID+`.mp4"`, head.Header().Get("Content-Disposition"))
+	etag := head.Header().Get("Etag")
+	require.NotEmpty(t, etag)
+	size, err := strconv.Atoi(head.Header().Get("Content-Length"))
+	require.NoError(t, err)
+	require.Empty(t, head.Body.Bytes())
+
+	full := request(http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, full.Code)
+	require.Len(t, full.Body.Bytes(), size)
+
+	t.Run("resume", func(t *testing.T) {
+		w := request(http.MethodGet, map[string]string{"Range": "bytes=100-", "If-Range": etag})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "bytes 100-"+strconv.Itoa(size-1)+"/"+strconv.Itoa(size),
+			w.Header().Get("Content-Range"))
+		require.Equal(t, full.Body.Bytes()[100:], w.Body.Bytes())
+	})
+	t.Run("mp4File", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(recPath+".mp4", []byte("0123456789"), 0o600))
+		defer os.Remove(recPath + ".mp4")
+
+		w := request(http.MethodGet, map[string]string{"Range": "bytes=8-"})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "89", w.Body.String())
+		require.NotEmpty(t, w.Header().Get("Etag"))
+		require.NotEqual(t, etag, w.Header().Get("Etag"))
+	})
+	t.Run("method", func(t *testing.T) {
+		require.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost, nil).Code)
+	})
+}
+
+func TestRecordingExport(t *testing.T) {
+	dir := t.TempDir()
+	info := hls.StreamInfo{VideoTrackExist: true, VideoSPS: []byte{0, 0, 0}}
+	init, err := hls.GenerateInit(info)
+	require.NoError(t, err)
+	fragment, err := hls.GenerateFragment(0, info, []*hls.VideoSample{{
+		AVCC:       []byte{1},
+		IdrPresent: true,
+		NextDTS:    int64(time.Second),
+	}}, nil)
+	require.NoError(t, err)
+	file := append(init, fragment...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.mp4"), file, 0o600))
+
+	query := func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo {
+		if monitorID != "1" {
+			return nil
+		}
+		return []storage.SegmentInfo{{
+			MonitorID: "1",
+			Path:      "a.mp4",
+			Start:     time.Unix(0, 0),
+			End:       time.Unix(1, 0),
+			Size:      int64(len(file)),
+			Keyframes: []storage.Keyframe{{Time: time.Unix(0, 0), Offset: int64(len(init))}},
+		}}
+	}
+
+	request := func(params string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/recording/export?"+params, nil)
+		w := httptest.NewRecorder()
+		RecordingExport(query, []string{dir}, nil, nil, log.NewDummyLogger()).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+		require.Equal(t,
+			`attachment; filename="1_1970-01-01_00-00-00.mp4"`,
+			w.Header().Get("Content-Disposition"),
+		)
+		require.Equal(t, file, w.Body.Bytes())
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := request("monitor=2&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("monitorMissing", func(t *testing.T) {
+		w := request("start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("invalidStart", func(t *testing.T) {
+		w := request("monitor=1&start=x&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("endBeforeStart", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:01Z&end=1970-01-01T00:00:00Z")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestRecordingScan(t *testing.T) {
+	dir := t.TempDir()
+	info := hls.StreamInfo{VideoTrackExist: true, VideoSPS: []byte{0, 0, 0}}
+	init, err := hls.GenerateInit(info)
+	require.NoError(t, err)
+	fragment, err := hls.GenerateFragment(0, info, []*hls.VideoSample{{
+		AVCC:       []byte{1},
+		IdrPresent: true,
+		NextDTS:    int64(time.Second),
+	}}, nil)
+	require.NoError(t, err)
+	file := append(init, fragment...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.mp4"), file, 0o600))
+
+	query := func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo {
+		if monitorID != "1" {
+			return nil
+		}
+		return []storage.SegmentInfo{{
+			MonitorID: "1",
+			Path:      "a.mp4",
+			Start:     time.Unix(0, 0),
+			End:       time.Unix(1, 0),
+			Size:      int64(len(file)),
+			Keyframes: []storage.Keyframe{{Time: time.Unix(0, 0), Offset: int64(len(init))}},
+		}}
+	}
+
+	request := func(params string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/recording/scan?"+params, nil)
+		w := httptest.NewRecorder()
+		RecordingScan(query, []string{dir}, nil, log.NewDummyLogger()).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z&speed=16")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+		require.Equal(t, init, w.Body.Bytes()[:len(init)])
+		require.Greater(t, w.Body.Len(), len(init))
+	})
+	t.Run("defaultSpeed", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("invalidSpeed", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z&speed=1")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("nanSpeed", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z&speed=NaN")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := request("monitor=2&start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("noKeyframes", func(t *testing.T) {
+		w := request("monitor=1&start=1970-01-01T00:00:00.5Z&end=1970-01-01T00:00:01Z")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestMonitorTimeline(t *testing.T) {
+	querySegments := func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo {
+		if monitorID != "1" {
+			return nil
+		}
+		return []storage.SegmentInfo{{Start: time.Unix(10, 0), End: time.Unix(20, 0)}}
+	}
+	queryStatic := func(monitorID string, start time.Time, end time.Time) []storage.SegmentInfo {
+		return []storage.SegmentInfo{{Start: time.Unix(20, 0), End: time.Unix(25, 0), Static: true}}
+	}
+	queryRecordings := func(monitorID string, start time.Time, end time.Time) ([]storage.Recording, error) {
+		if monitorID == "err" {
+			return nil, errors.New("mock")
+		}
+		return nil, nil
+	}
+	handler := MonitorPaths(map[string]http.Handler{
+		"timeline": MonitorTimeline(querySegments, queryStatic, queryRecordings, log.NewDummyLogger()),
+	})
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("/api/monitor/1/timeline?start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:30Z")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, jsonContentType, w.Header().Get("Content-Type"))
+
+		var timeline storage.Timeline
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &timeline))
+		require.Len(t, timeline.Coverage, 1)
+		require.Len(t, timeline.Gaps, 2)
+		require.Len(t, timeline.Static, 1)
+		require.True(t, timeline.Coverage[0].Start.Equal(time.Unix(10, 0)))
+	})
+	t.Run("invalidStart", func(t *testing.T) {
+		w := request("/api/monitor/1/timeline?start=x&end=1970-01-01T00:00:30Z")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("queryErr", func(t *testing.T) {
+		w := request("/api/monitor/err/timeline?start=1970-01-01T00:00:00Z&end=1970-01-01T00:00:30Z")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+	t.Run("unknownPath", func(t *testing.T) {
+		w := request("/api/monitor/1/x")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("noID", func(t *testing.T) {
+		w := request("/api/monitor/timeline")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestMonitorThumbnail(t *testing.T) {
+	getThumbnail := func(_ context.Context, monitorID string, at time.Time, width int) ([]byte, error) {
+		switch monitorID {
+		case "none":
+			return nil, thumbnail.ErrNoKeyframe
+		case "err":
+			return nil, errors.New("mock")
+		}
+		return []byte(monitorID + at.UTC().Format(time.RFC3339) + strconv.Itoa(width)), nil
+	}
+	handler := MonitorPaths(map[string]http.Handler{
+		"thumbnail": MonitorThumbnail(getThumbnail, log.NewDummyLogger()),
+	})
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("/api/monitor/1/thumbnail?time=1970-01-01T00:00:10Z&width=160")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		require.Equal(t, "11970-01-01T00:00:10Z160", w.Body.String())
+	})
+	t.Run("defaultWidth", func(t *testing.T) {
+		w := request("/api/monitor/1/thumbnail?time=1970-01-01T00:00:10Z")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "11970-01-01T00:00:10Z320", w.Body.String())
+	})
+	t.Run("invalidWidth", func(t *testing.T) {
+		w := request("/api/monitor/1/thumbnail?time=1970-01-01T00:00:10Z&width=9999")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("invalidTime", func(t *testing.T) {
+		w := request("/api/monitor/1/thumbnail?time=x")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := request("/api/monitor/none/thumbnail?time=1970-01-01T00:00:10Z")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("err", func(t *testing.T) {
+		w := request("/api/monitor/err/thumbnail?time=1970-01-01T00:00:10Z")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestMonitorSnapshot(t *testing.T) {
+	getSnapshot := func(_ context.Context, monitorID string) ([]byte, error) {
+		switch monitorID {
+		case "none":
+			return nil, monitor.ErrMonitorNotExist
+		case "offline":
+			return nil, monitor.ErrInputNotOnline
+		case "err":
+			return nil, errors.New("mock")
+		}
+		return []byte(monitorID), nil
+	}
+	handler := MonitorPaths(map[string]http.Handler{
+		"snapshot.jpg": MonitorSnapshot(getSnapshot, log.NewDummyLogger()),
+	})
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		w := request("/api/monitor/1/snapshot.jpg")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		require.Equal(t, "private, max-age=2", w.Header().Get("Cache-Control"))
+		require.Equal(t, "1", w.Body.String())
+	})
+	t.Run("notFound", func(t *testing.T) {
+		w := request("/api/monitor/none/snapshot.jpg")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("offline", func(t *testing.T) {
+		w := request("/api/monitor/offline/snapshot.jpg")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+	t.Run("err", func(t *testing.T) {
+		w := request("/api/monitor/err/snapshot.jpg")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestBookmarks(t *testing.T) {
+	store, err := storage.NewBookmarkStore(filepath.Join(t.TempDir(), "bookmarks.json"))
+	require.NoError(t, err)
+	a := stubAuthenticator{
+		valid: true,
+		user:  auth.Account{Username: "u", Monitors: []string{"m1"}},
+	}
+	set := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/api/bookmark/set", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		BookmarkSet(store, a).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("set", func(t *testing.T) {
+		w := set(`{"monitorId":"m1","time":"2022-01-02T03:04:05Z","name":"a","tags":["x"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var b storage.Bookmark
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &b))
+		require.Equal(t, uint64(1), b.ID)
+		require.Equal(t, "u", b.CreatedBy)
+	})
+	t.Run("setMonitorNotAllowed", func(t *testing.T) {
+		w := set(`{"monitorId":"m2","time":"2022-01-02T03:04:05Z","name":"a"}`)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("setInvalid", func(t *testing.T) {
+		w := set(`{"monitorId":"m1","name":"a"}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("setNotExist", func(t *testing.T) {
+		w := set(`{"id":9,"monitorId":"m1","time":"2022-01-02T03:04:05Z","name":"a"}`)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("query", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/bookmarks?tags=x&q=A", nil)
+		w := httptest.NewRecorder()
+		Bookmarks(store).ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Bookmarks []storage.Bookmark
+			Total     int
+			Tags      []string
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Len(t, res.Bookmarks, 1)
+		require.Equal(t, 1, res.Total)
+		require.Equal(t, []string{"x"}, res.Tags)
+	})
+	t.Run("queryInvalidLimit", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/bookmarks?limit=0", nil)
+		w := httptest.NewRecorder()
+		Bookmarks(store).ServeHTTP(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("delete", func(t *testing.T) {
+		del := func(id string) int {
+			r := httptest.NewRequest(http.MethodDelete, "/api/bookmark/delete?id="+id, nil)
+			w := httptest.NewRecorder()
+			BookmarkDelete(store, a).ServeHTTP(w, r)
+			return w.Code
+		}
+		require.Equal(t, http.StatusBadRequest, del("x"))
+		require.Equal(t, http.StatusNotFound, del("9"))
+		require.Equal(t, http.StatusOK, del("1"))
+	})
+}
+
+func TestRecordingVerify(t *testing.T) {
+	dir := t.TempDir()
+	const recID = "2022-01-02_03-04-05_m1"
+	recDir := filepath.Join(dir, "2022", "01", "02", "m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	recPath := filepath.Join(recDir, recID)
+	require.NoError(t, os.WriteFile(recPath+".meta", []byte("meta"), 0o600))
+	require.NoError(t, os.WriteFile(recPath+".mdat", []byte("mdat"), 0o600))
+
+	ledger := storage.NewLedger(t.TempDir(), nil)
+	hash, err := storage.HashRecording(recPath, nil)
+	require.NoError(t, err)
+	require.NoError(t, ledger.Append("m1", storage.LedgerRecording, recID, hash))
+
+	request := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/recording/verify/"+id, nil)
+		w := httptest.NewRecorder()
+		RecordingVerify(ledger, nil, dir).ServeHTTP(w, r)
+		return w
+	}
+	verify := func() storage.Verification {
+		w := request(recID)
+		require.Equal(t, http.StatusOK, w.Code)
+		var v storage.Verification
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v))
+		return v
+	}
+
+	v := verify()
+	require.True(t, v.Valid)
+	require.Equal(t, hash, v.SHA256)
+
+	require.NoError(t, os.WriteFile(recPath+".mdat", []byte("mdaX"), 0o600))
+	v = verify()
+	require.False(t, v.Valid)
+	require.False(t, v.HashMatch)
+
+	t.Run("notFound", func(t *testing.T) {
+		w := request("2022-01-02_03-04-05_m2")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+	t.Run("invalidID", func(t *testing.T) {
+		w := request("x")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestRecordingFlag(t *testing.T) {
+	dir := t.TempDir()
+	const recID = "2022-01-02_03-04-05_m1"
+	recDir := filepath.Join(dir, "2022", "01", "02", "m1")
+	require.NoError(t, os.MkdirAll(recDir, 0o700))
+	recPath := filepath.Join(recDir, recID)
+	require.NoError(t, os.WriteFile(recPath+".json", []byte("{}"), 0o600))
+
+	request := func(h http.Handler, method string, path string) int {
+		r := httptest.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	flag := func(method string, id string) int {
+		return request(RecordingFlag(dir), method, "/api/recording/flag/"+id)
+	}
+	remove := func() int {
+		return request(RecordingDelete(dir), http.MethodDelete, "/api/recording/delete/"+recID)
+	}
+
+	require.Equal(t, http.StatusOK, flag(http.MethodPut, recID))
+	require.FileExists(t, recPath+".flag")
+	require.Equal(t, http.StatusConflict, remove())
+
+	require.Equal(t, http.StatusOK, flag(http.MethodDelete, recID))
+	require.NoFileExists(t, recPath+".flag")
+	require.Equal(t, http.StatusOK, remove())
+
+	t.Run("notFound", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, flag(http.MethodPut, "2022-01-02_03-04-05_m2"))
+	})
+	t.Run("invalidID", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, flag(http.MethodPut, "x"))
+	})
+	t.Run("invalidMethod", func(t *testing.T) {
+		require.Equal(t, http.StatusMethodNotAllowed, flag(http.MethodGet, recID))
+	})
+}
+
+func TestEvents(t *testing.T) {
+	store, err := storage.OpenEventStore(filepath.Join(t.TempDir(), "events.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	for i, label := range []string{"person", "car", "person"} {
+		_, err := store.Add("m1", storage.Event{
+			Time:       time.Unix(int64(i)*3600, 0).UTC(),
+			Detections: []storage.Detection{{Label: label, Score: 50}},
+		})
+		require.NoError(t, err)
+	}
+
+	request := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/events?"+query, nil)
+		w := httptest.NewRecorder()
+		Events(store, time.UTC).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("query", func(t *testing.T) {
+		w := request("labels=person&limit=1")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, jsonContentType, w.Header().Get("Content-Type"))
+
+		var res struct {
+			Events []storage.EventRecord `json:"events"`
+			Total  int                   `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Equal(t, 2, res.Total)
+		require.Len(t, res.Events, 1)
+		require.Equal(t, uint64(3), res.Events[0].ID)
+	})
+	t.Run("aggregate", func(t *testing.T) {
+		w := request("aggregate=day&start=1970-01-01T00:00:00Z")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Counts []storage.EventCount `json:"counts"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		require.Len(t, res.Counts, 1)
+		require.Equal(t, 3, res.Counts[0].Count)
+		require.Equal(t, map[string]int{"person": 2, "car": 1}, res.Counts[0].Labels)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{
+			"limit=0", "limit=x", "offset=-1", "minScore=x", "start=x", "aggregate=week",
+		} {
+			require.Equal(t, http.StatusBadRequest, request(query).Code, query)
+		}
+	})
+}
+
+func TestEventStream(t *testing.T) {
+	f := feed.New()
+	var admin, invalid int32
+	validate := func(*http.Request) auth.ValidateResponse {
+		return auth.ValidateResponse{
+			IsValid: atomic.LoadInt32(&invalid) == 0,
+			User:    auth.Account{IsAdmin: atomic.LoadInt32(&admin) == 1},
+		}
+	}
+	server := httptest.NewServer(EventStream(f, validate))
+	defer server.Close()
+
+	t.Run("websocket", func(t *testing.T) {
+		wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?monitors=a"
+		c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.NoError(t, err)
+		defer c.Close()
+
+		f.Publish(feed.Message{Type: feed.TypeEvent, MonitorID: "b"})
+		f.Publish(feed.Message{Type: feed.TypeNotice, Data: "x"})
+		f.Publish(feed.Message{Type: feed.TypeEvent, MonitorID: "a", Data: "y"})
+
+		var msg map[string]interface{}
+		require.NoError(t, c.ReadJSON(&msg))
+		require.Equal(t, "event", msg["type"])
+		require.Equal(t, "a", msg["monitorId"])
+		require.Equal(t, "y", msg["data"])
+	})
+	t.Run("sse", func(t *testing.T) {
+		atomic.StoreInt32(&admin, 1)
+		res, err := http.Get(server.URL + "?types=notice")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		f.Publish(feed.Message{Type: feed.TypeEvent, MonitorID: "a"})
+		f.Publish(feed.Message{Type: feed.TypeNotice, Time: time.Unix(1, 0).UTC(), Data: "x"})
+
+		r := bufio.NewReader(res.Body)
+		event, err := r.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "event: notice\n", event)
+		data, err := r.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, `data: {"type":"notice","time":"1970-01-01T00:00:01Z","data":"x"}`+"\n", data)
+	})
+	t.Run("invalidAuth", func(t *testing.T) {
+		res, err := http.Get(server.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		atomic.StoreInt32(&invalid, 1)
+		f.Publish(feed.Message{Type: feed.TypeArming})
+		_, err = io.ReadAll(res.Body)
+		require.NoError(t, err)
+	})
+	t.Run("method", func(t *testing.T) {
+		res, err := http.Post(server.URL, "", nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
+
+func TestLogLevelsSet(t *testing.T) {
+	logger := log.NewLogger(nil, []string{"addon"})
+	handler := LogLevelsSet(logger)
+
+	cases := map[string]struct {
+		body     string
+		code     int
+		expected string
+	}{
+		"ok":      {`{"addon":"warning"}`, http.StatusOK, "warning"},
+		"level":   {`{"addon":"x"}`, http.StatusBadRequest, "warning"},
+		"source":  {`{"addon":"info","nil":"info"}`, http.StatusBadRequest, "warning"},
+		"invalid": {`{`, http.StatusBadRequest, "warning"},
+	}
+	for _, name := range []string{"ok", "level", "source", "invalid"} {
+		tc := cases[name]
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/api/log/levels/set", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tc.code, w.Code)
+			require.Equal(t, tc.expected, logger.Levels()["addon"].String())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	LogLevels(logger).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/log/levels", nil))
+	var levels map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &levels))
+	require.Equal(t, "warning", levels["addon"])
+	require.Equal(t, "debug", levels["app"])
+}
@@ -47,6 +47,7 @@ func ServeMP4Content( //nolint:funlen,gocognit
 	if !haveType {
 		w.Header().Set("Content-Type", "video/mp4")
 	}
+	contentType := w.Header().Get("Content-Type")
 
 	code := http.StatusOK
 
@@ -54,6 +55,8 @@ func ServeMP4Content( //nolint:funlen,gocognit
 	sendSize := size
 	var sendContent io.Reader = content
 	if size >= 0 { //nolint:nestif
+		// Also set on errors so that clients know that ranges are supported.
+		w.Header().Set("Accept-Ranges", "bytes")
 		ranges, err := parseRange(rangeReq, size)
 		if err != nil {
 			if errors.Is(err, errNoOverlap) {
@@ -91,7 +94,7 @@ func ServeMP4Content( //nolint:funlen,gocognit
 			code = http.StatusPartialContent
 			w.Header().Set("Content-Range", ra.contentRange(size))
 		case len(ranges) > 1:
-			sendSize = rangesMIMESize(ranges, size)
+			sendSize = rangesMIMESize(ranges, contentType, size)
 			code = http.StatusPartialContent
 
 			pr, pw := io.Pipe()
@@ -101,7 +104,7 @@ func ServeMP4Content( //nolint:funlen,gocognit
 			defer pr.Close() // cause writing goroutine to fail and exit if CopyN doesn't finish.
 			go func() {
 				for _, ra := range ranges {
-					part, err := mw.CreatePart(ra.mimeHeader(size))
+					part, err := mw.CreatePart(ra.mimeHeader(contentType, size))
 					if err != nil {
 						pw.CloseWithError(err)
 						return
@@ -120,7 +123,6 @@ func ServeMP4Content( //nolint:funlen,gocognit
 			}()
 		}
 
-		w.Header().Set("Accept-Ranges", "bytes")
 		if w.Header().Get("Content-Encoding") == "" {
 			w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
 		}
@@ -390,10 +392,10 @@ func (r httpRange) contentRange(size int64) string {
 	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
 }
 
-func (r httpRange) mimeHeader(size int64) textproto.MIMEHeader {
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
 	return textproto.MIMEHeader{
 		"Content-Range": {r.contentRange(size)},
-		"Content-Type":  {"video/mp4"},
+		"Content-Type":  {contentType},
 	}
 }
 
@@ -483,12 +485,12 @@ func (w *countingWriter) Write(p []byte) (int, error) {
 
 // rangesMIMESize returns the number of bytes it takes to encode the
 // provided ranges as a multipart response.
-func rangesMIMESize(ranges []httpRange, contentSize int64) int64 {
+func rangesMIMESize(ranges []httpRange, contentType string, contentSize int64) int64 {
 	var encSize int64
 	var w countingWriter
 	mw := multipart.NewWriter(&w)
 	for _, ra := range ranges {
-		mw.CreatePart(ra.mimeHeader(contentSize)) //nolint:errcheck
+		mw.CreatePart(ra.mimeHeader(contentType, contentSize)) //nolint:errcheck
 		encSize += ra.length
 	}
 	mw.Close()
@@ -0,0 +1,116 @@
+// Copyright 2020-2022 The OS-NVR Authors.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeMP4Content(t *testing.T) {
+	content := []byte("0123456789")
+	modTime := time.Unix(1000, 0)
+	const etag = `"abc"`
+
+	serve := func(method string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("Etag", etag)
+		ServeMP4Content(w, r, modTime, int64(len(content)), bytes.NewReader(content))
+		return w
+	}
+
+	t.Run("full", func(t *testing.T) {
+		w := serve(http.MethodGet, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		require.Equal(t, "10", w.Header().Get("Content-Length"))
+		require.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+		require.Equal(t, content, w.Body.Bytes())
+	})
+	t.Run("head", func(t *testing.T) {
+		w := serve(http.MethodHead, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		require.Equal(t, "10", w.Header().Get("Content-Length"))
+		require.Empty(t, w.Body.Bytes())
+	})
+	t.Run("range", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"Range": "bytes=2-4"})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+		require.Equal(t, "3", w.Header().Get("Content-Length"))
+		require.Equal(t, "234", w.Body.String())
+	})
+	t.Run("resume", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"Range": "bytes=7-", "If-Range": etag})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "789", w.Body.String())
+	})
+	t.Run("resumeChanged", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"Range": "bytes=7-", "If-Range": `"old"`})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, content, w.Body.Bytes())
+	})
+	t.Run("suffix", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"Range": "bytes=-2"})
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "89", w.Body.String())
+	})
+	t.Run("notSatisfiable", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"Range": "bytes=20-"})
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		require.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	})
+	t.Run("notModified", func(t *testing.T) {
+		w := serve(http.MethodGet, map[string]string{"If-None-Match": etag})
+		require.Equal(t, http.StatusNotModified, w.Code)
+	})
+	t.Run("multipart", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Range", "bytes=0-1,8-9")
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "video/x-matroska")
+		ServeMP4Content(w, r, modTime, int64(len(content)), bytes.NewReader(content))
+		require.Equal(t, http.StatusPartialContent, w.Code)
+
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/byteranges", mediaType)
+
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for _, expected := range []string{"01", "89"} {
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			require.Equal(t, "video/x-matroska", part.Header.Get("Content-Type"))
+			body, err := io.ReadAll(part)
+			require.NoError(t, err)
+			require.Equal(t, expected, string(body))
+		}
+	})
+}
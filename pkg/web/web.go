@@ -125,6 +125,8 @@ func (templater *Templater) Render(page string) http.Handler {
 			tls := r.Header["X-Forwarded-Proto"]
 			if len(tls) != 0 {
 				data["tls"] = tls[0]
+			} else if r.TLS != nil {
+				data["tls"] = "https"
 			}
 		}
 
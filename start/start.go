@@ -54,6 +54,7 @@ type configEnv struct {
 
 func start() error {
 	envFlag := flag.String("env", "", "path to env.yaml")
+	checkFlag := flag.Bool("check-config", false, "validate the configuration and exit")
 	flag.Parse()
 
 	if *envFlag == "" {
@@ -96,12 +97,16 @@ func start() error {
 		return err
 	}
 
-	return startMain(*env, main, envPath)
+	return startMain(*env, main, envPath, *checkFlag)
 }
 
-func startMain(env configEnv, main string, envPath string) error {
+func startMain(env configEnv, main string, envPath string, checkConfig bool) error {
 	// go run ./start/build/nvr.go -env ./config/env.yaml
-	cmd := exec.Command(env.GoBin, "run", main, "-env", envPath)
+	args := []string{"run", main, "-env", envPath}
+	if checkConfig {
+		args = append(args, "-check-config")
+	}
+	cmd := exec.Command(env.GoBin, args...)
 	cmd.Dir = env.HomeDir
 
 	// Give parent file descriptors and environment to child process.
@@ -308,6 +313,128 @@ homeDir: {{ .homeDir }}
 # Directory where recordings will be stored.
 storageDir: {{ .homeDir }}/storage
 
+# Storage tier on a slower or bigger mount, for example a NFS or SMB
+# share. Recordings and continuous segments older than archiveAfterDays
+# are moved here and remain available for playback and exports. The
+# archive isn't pruned when it runs out of space, use the monitor
+# retention settings to limit it.
+#archiveDir: /mnt/nas/nvr
+#archiveAfterDays: 7
+
+# Encrypt event recordings and continuous segments on disk using
+# AES-GCM. The key is generated on the first start and stored in
+# "configs/recording.key", recordings can't be recovered without it.
+# Keep a backup of the key somewhere other than the recording disk.
+# Thumbnails and existing recordings are not encrypted.
+#encryptRecordings: true
+
+# The SHA-256 of each recording and continuous segment is added to a
+# hash chain in "storage/integrity". Enable to also sign the entries
+# with the Ed25519 key in "configs/integrity.key", the public key
+# is available at "/api/integrity/key".
+#signRecordings: true
+
+# Maximum size in MB of the cache of thumbnails extracted
+# from continuous recordings for hover previews.
+#thumbnailCacheSize: 100
+
+# Disk health of the storage disk is checked every minute. A warning is
+# logged when the used space or inodes exceed diskWarnPercent. Continuous
+# recording of monitors with a low recording priority is paused above
+# diskPausePercent. Set smartDevice to also check the SMART health status
+# of the disk, requires smartctl from smartmontools.
+#diskWarnPercent: 90
+#diskPausePercent: 95
+#smartDevice: /dev/sda
+
+# Maximum amount of memory in MB used by live segments across all
+# monitors. Segments exceeding this are moved to hlsSpillDir,
+# preferably a tmpfs. 0 keeps all segments in memory.
+#hlsMemoryBudget: 0
+#hlsSpillDir: /tmp/nvr/hls
+
+# Encrypt live fMP4 samples using common encryption, "cenc" or "cbcs".
+# Intended for installations that proxy HLS through a CDN. The keys
+# are served by the NVR itself, the player must support SAMPLE-AES.
+#hlsEncryption: cbcs
+
+# UDP port for low latency WebRTC live streams. Only the video is
+# streamed since browsers doesn't support AAC over WebRTC.
+# Clients must be able to reach this port, list the external
+# addresses of the host if the NVR is behind NAT or in a container.
+#webrtcPort: 2023
+#webrtcAdditionalHosts:
+#  - 192.168.1.2
+
+# TCP port for the authenticated RTSP restream server. Other NVRs,
+# Home Assistant or VLC can read the monitors from
+# rtsp://<user>:<pass>@<host>:<port>/<monitor-id> without
+# connecting to the cameras again. Publishing is not allowed.
+#rtspRestreamPort: 8554
+
+# TCP port for RTMP ingest. Monitors with the "rtmp" input source
+# accept publishers on rtmp://<host>:<port>/<monitor-id> using the
+# main input as the stream key. Only H264 and AAC are supported.
+#rtmpPort: 1935
+
+# Serve pprof profiles and runtime traces to admins under
+# /debug/pprof/ to debug performance problems.
+#profiling: true
+
+# Print logs as "text" or one "json" object per line. The logs are
+# also written to logFile if set, the file is rotated when it's larger
+# than logFileMaxSize MB or older than logFileMaxAge hours.
+#logFormat: text
+#logFile: /var/log/nvr.log
+#logFileMaxSize: 10
+#logFileMaxAge: 24
+#logFileBackups: 5
+
+# Maximum level of each log source, "error", "warning", "info" or "debug".
+#logLevels:
+#  recorder: info
+
+# Seconds to wait for the open recordings to be finalized on shutdown.
+#shutdownTimeout: 30
+
+# Login sessions expire after sessionLifetime hours or after
+# sessionIdleTimeout minutes without requests, -1 disables the
+# idle timeout. The user has to log in again when it expires.
+#sessionLifetime: 168
+#sessionIdleTimeout: 1440
+
+# Recording exports read the next segments into memory while the current
+# segment is sent, so that playback from slow storage like spinning disks
+# or NFS doesn't stall. Number of segments to read ahead, -1 disables it,
+# and the size of the prefetch cache shared by all exports in MB.
+#segmentPrefetch: 2
+#segmentPrefetchCacheSize: 64
+
+# Serve the app under a sub-path, for example https://example.com/nvr/
+# The reverse proxy may forward the requests with or without the prefix.
+#basePath: /nvr
+
+# X-Forwarded-For, X-Real-Ip and X-Forwarded-Proto headers are only
+# trusted from these addresses, the loopback addresses by default.
+#trustedProxies:
+#  - 127.0.0.1
+#  - 192.168.1.0/24
+
+# Built in HTTPS server, removes the need for a reverse proxy.
+# Provide a certificate, it's reloaded when the files change.
+#tlsPort: 443
+#tlsCertFile: /etc/ssl/nvr/cert.pem
+#tlsKeyFile: /etc/ssl/nvr/key.pem
+#
+# Or obtain certificates from Let's Encrypt. The HTTP-01 challenge
+# requires port 80 to be forwarded to the main port. Set acmeDNSHook
+# to use DNS-01 instead, the script is called with "present" or
+# "cleanup", the TXT record name and its value.
+#acmeDomains:
+#  - nvr.example.com
+#acmeEmail: admin@example.com
+#acmeDNSHook: /home/_nvr/dns-hook.sh
+
 
 addons: # Uncomment to enable.
 
@@ -318,11 +445,35 @@ addons: # Uncomment to enable.
   #
   # No authentication.
   #- nvr/addons/auth/none
+  #
+  # OpenID Connect login alongside basic auth.
+  # Documentation ../addons/auth/oidc/README.md
+  #- nvr/addons/auth/oidc
 
   # Object detection. https://github.com/snowzach/doods2
   # Documentation ../addons/doods2/README.md
   #- nvr/addons/doods2
 
+  # ONNX object detection.
+  # Run YOLOv8 and YOLO-NAS models locally on the CPU or GPU.
+  # Documentation ../addons/onnx/README.md
+  #- nvr/addons/onnx
+
+  # EdgeTPU object detection.
+  # Run SSD and EfficientDet models on a Google Coral.
+  # Documentation ../addons/edgetpu/README.md
+  #- nvr/addons/edgetpu
+
+  # Remote object detection.
+  # Send frames to a gRPC detection server on another machine.
+  # Documentation ../addons/remotedetector/README.md
+  #- nvr/addons/remotedetector
+
+  # Audio event detection.
+  # Trigger on sounds like glass breaking or alarms.
+  # Documentation ../addons/audiodetector/README.md
+  #- nvr/addons/audiodetector
+
   # Motion detection.
   # Documentation ../addons/motion/README.md
   #- nvr/addons/motion
@@ -336,10 +487,55 @@ addons: # Uncomment to enable.
   #- nvr/addons/status
 
   # Watchdog.
-  # Detect and restart frozen processes.
+  # Restart monitors that stop receiving frames or segments without crashing.
   #- nvr/addons/watchdog
 
   # Timeline.
   # Works best with a Chromium based browser.
   #- nvr/addons/timeline
+
+  # Animated previews.
+  # Animated WebP preview of event recordings on hover.
+  # Documentation ../addons/preview/README.md
+  #- nvr/addons/preview
+
+  # Timelapse.
+  # Daily or weekly timelapses from continuous recordings.
+  # Documentation ../addons/timelapse/README.md
+  #- nvr/addons/timelapse
+
+  # S3 upload.
+  # Off-site backup of recordings to S3 compatible storage.
+  # Documentation ../addons/s3/README.md
+  #- nvr/addons/s3
+
+  # Auto export.
+  # Export event clips matching rules to a folder, S3 or a webhook.
+  # Documentation ../addons/autoexport/README.md
+  #- nvr/addons/autoexport
+
+  # ONVIF events.
+  # Trigger recordings with the camera's own motion detection.
+  # Documentation ../addons/onvifevents/README.md
+  #- nvr/addons/onvifevents
+
+  # Notifications.
+  # Send events to webhooks, ntfy, Gotify, Pushover, email and Telegram.
+  # Documentation ../addons/notify/README.md
+  #- nvr/addons/notify
+
+  # MQTT.
+  # Publish events and accept commands over MQTT, with Home Assistant discovery.
+  # Documentation ../addons/mqtt/README.md
+  #- nvr/addons/mqtt
+
+  # Log shipping.
+  # Forward the log to syslog or Grafana Loki.
+  # Documentation ../addons/logship/README.md
+  #- nvr/addons/logship
+
+  # Federation.
+  # Proxy the monitors, recordings and events of other OS-NVR instances.
+  # Documentation ../addons/federation/README.md
+  #- nvr/addons/federation
 `
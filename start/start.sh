@@ -8,6 +8,8 @@ example: $(basename "$0") --goBin /usr/bin/go --env /home/_nvr/os-nvr/configs/en
             path to Golang binary.
         --env
             path to env.yaml.
+        --check-config
+            validate the configuration and exit.
         -h, --help
             Show this help text.
 "
@@ -15,6 +17,7 @@ example: $(basename "$0") --goBin /usr/bin/go --env /home/_nvr/os-nvr/configs/en
 # Parse arguments
 go_bin=""
 env=""
+check_config=""
 for arg in "$@"; do
 	case $arg in
 	--goBin)
@@ -35,6 +38,10 @@ for arg in "$@"; do
 		env="${arg#*=}"
 		shift
 		;;
+	--check-config)
+		check_config="--check-config"
+		shift
+		;;
 	-h | --help)
 		printf "%s" "$usage"
 		shift
@@ -72,4 +79,5 @@ if [ "$env" = "" ]; then
 fi
 
 # Start program.
-$go_bin run ./start/start.go --env "$env"
+# shellcheck disable=SC2086
+$go_bin run ./start/start.go --env "$env" $check_config
@@ -13,7 +13,8 @@ import (
 )
 
 const usage = `convert recordings into mp4 files
-example: rec2mp4 ./storage/recordings"`
+usage: rec2mp4 <recordings> [recording.key]
+example: rec2mp4 ./storage/recordings ./configs/recording.key"`
 
 func main() {
 	if err := run(); err != nil {
@@ -23,11 +24,24 @@ func main() {
 
 func run() error { //nolint:funlen
 	args := os.Args
-	if len(args) != 2 {
+	if len(args) != 2 && len(args) != 3 {
 		fmt.Println(usage)
 		return nil
 	}
 
+	// The key is required to convert encrypted recordings.
+	var crypt *storage.Crypt
+	if len(args) == 3 {
+		if _, err := os.Stat(args[2]); err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+		var err error
+		crypt, err = storage.LoadCrypt(args[2])
+		if err != nil {
+			return err
+		}
+	}
+
 	var recordings []string
 
 	path := args[1]
@@ -74,7 +88,7 @@ func run() error { //nolint:funlen
 		go func(recording string) {
 			chResults <- result{
 				recording: recording,
-				err:       convert(recording),
+				err:       convert(recording, crypt),
 			}
 		}(recording)
 	}
@@ -96,8 +110,8 @@ type result struct {
 	err       error
 }
 
-func convert(recording string) error {
-	video, err := storage.NewVideoReader(recording, nil)
+func convert(recording string, crypt *storage.Crypt) error {
+	video, err := storage.NewVideoReader(recording, nil, crypt)
 	if err != nil {
 		return fmt.Errorf("create video reader: %w", err)
 	}
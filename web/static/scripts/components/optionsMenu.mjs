@@ -470,6 +470,10 @@ function newGroupPicker(groups) {
 		const selectedGroup = groups[nameToID[selected]];
 		const groupMonitors = JSON.parse(selectedGroup["monitors"]);
 		content.setMonitors(groupMonitors);
+		if (content.setLayout) {
+			const layout = selectedGroup["layout"];
+			content.setLayout(layout ? JSON.parse(layout) : undefined);
+		}
 		content.reset();
 	};
 
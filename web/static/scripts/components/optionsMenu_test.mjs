@@ -201,6 +201,23 @@ describe("optionsDate", () => {
 		document.querySelector(".js-reset").click();
 		expect(month).toBe(1);
 	});
+	test("layout", () => {
+		const [group, element] = setup();
+		let layout;
+		const content = {
+			setMonitors() {},
+			setLayout(input) {
+				layout = input;
+			},
+			reset() {},
+		};
+		group.init(element, content);
+		document.querySelector(".select-one-item[data='group1']").click();
+		expect(layout).toEqual({ columns: 2, tiles: [] });
+
+		document.querySelector(".select-one-item[data='group2']").click();
+		expect(layout).toBeUndefined();
+	});
 	test("popup", () => {
 		setup();
 		const $popup = document.querySelector(".options-popup");
@@ -286,6 +303,7 @@ describe("optionsGroup", () => {
 				id: "a",
 				name: "group1",
 				monitors: JSON.stringify(["1"]),
+				layout: JSON.stringify({ columns: 2, tiles: [] }),
 			},
 			b: {
 				id: "b",
@@ -339,6 +357,23 @@ describe("optionsGroup", () => {
 		expect(setMonitorsCalled).toBe(true);
 		expect(resetCalled).toBe(true);
 	});
+	test("layout", () => {
+		const [group, element] = setup();
+		let layout;
+		const content = {
+			setMonitors() {},
+			setLayout(input) {
+				layout = input;
+			},
+			reset() {},
+		};
+		group.init(element, content);
+		document.querySelector(".select-one-item[data='group1']").click();
+		expect(layout).toEqual({ columns: 2, tiles: [] });
+
+		document.querySelector(".select-one-item[data='group2']").click();
+		expect(layout).toBeUndefined();
+	});
 	test("popup", () => {
 		setup();
 		const $popup = document.querySelector(".options-popup");
@@ -14,7 +14,7 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 import { fromUTC } from "../libs/time.mjs";
-import { fetchDelete } from "../libs/common.mjs";
+import { fetchDelete, fetchPut } from "../libs/common.mjs";
 
 function newPlayer(data, isAdmin, token) {
 	const d = data;
@@ -89,12 +89,18 @@ function newPlayer(data, isAdmin, token) {
 						? `
 				<button class="js-delete player-options-btn">
 					<img src="static/icons/feather/trash-2.svg">
+				</button>
+				<button class="js-flag player-options-btn${d.flagged ? " player-flagged" : ""}">
+					<img src="static/icons/feather/flag.svg">
 				</button>`
 						: ""
 				}
 				<a download href="${d.videoPath}" class="player-options-btn">
 					<img src="static/icons/feather/download.svg">
 				</a>
+				<button class="js-bookmark player-options-btn">
+					<img src="static/icons/feather/bookmark.svg">
+				</button>
 				<button class="js-fullscreen player-options-btn">
 					<img src="${iconMaximizePath}">
 				</button>
@@ -187,6 +193,26 @@ function newPlayer(data, isAdmin, token) {
 			}
 		});
 
+		// Bookmark the current time.
+		const $bookmark = $popup.querySelector(".js-bookmark");
+		$bookmark.addEventListener("click", async (event) => {
+			event.stopPropagation();
+			const name = prompt("bookmark name");
+			if (!name) {
+				return;
+			}
+			const tags = prompt("tags, comma separated (optional)") || "";
+			const time = new Date(d.start + $video.currentTime * 1000);
+			const bookmark = {
+				monitorId: d.id.slice(20),
+				recordingId: d.id,
+				time: time.toISOString(),
+				name: name,
+				tags: tags.split(","),
+			};
+			await fetchPut(d.bookmarkPath, bookmark, token, "could not save bookmark");
+		});
+
 		// Delete
 		if (isAdmin) {
 			const $delete = element.querySelector(".js-delete");
@@ -199,6 +225,20 @@ function newPlayer(data, isAdmin, token) {
 
 				element.remove();
 			});
+
+			// Flagged recordings are never deleted by retention or pruning.
+			const $flag = element.querySelector(".js-flag");
+			$flag.addEventListener("click", async (event) => {
+				event.stopPropagation();
+				const ok = d.flagged
+					? await fetchDelete(d.flagPath, token, "could not unflag recording")
+					: await fetchPut(d.flagPath, {}, token, "could not flag recording");
+				if (!ok) {
+					return;
+				}
+				d.flagged = !d.flagged;
+				$flag.classList.toggle("player-flagged", d.flagged);
+			});
 		}
 	};
 
@@ -118,6 +118,9 @@ describe("newPlayer", () => {
 							<a download="" href="C"class="player-options-btn">
 								<img src="static/icons/feather/download.svg">
 							</a>
+							<button class="js-bookmark player-options-btn">
+								<img src="static/icons/feather/bookmark.svg">
+							</button>
 							<button class="js-fullscreen player-options-btn">
 								<img src="static/icons/feather/maximize.svg">
 							</button>
@@ -178,9 +181,15 @@ describe("newPlayer", () => {
 			<button class="js-delete player-options-btn">
 				<img src="static/icons/feather/trash-2.svg">
 			</button>
+			<button class="js-flag player-options-btn">
+				<img src="static/icons/feather/flag.svg">
+			</button>
 			<a download="" href="C"class="player-options-btn">
 				<img src="static/icons/feather/download.svg">
 			</a>
+			<button class="js-bookmark player-options-btn">
+				<img src="static/icons/feather/bookmark.svg">
+			</button>
 			<button class="js-fullscreen player-options-btn">
 				<img src="static/icons/feather/maximize.svg">
 			</button>`.replace(/\s/g, "");
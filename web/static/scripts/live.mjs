@@ -32,6 +32,26 @@ function newViewer($parent, monitors, hls) {
 		return false;
 	};
 
+	// Tiles of the group layout are placed first.
+	let layout;
+	const tileOf = (monitor) => {
+		if (!layout || !layout.tiles) {
+			return;
+		}
+		return layout.tiles.find((tile) => tile.monitor === monitor["id"]);
+	};
+	const orderByLayout = (monitors) => {
+		if (!layout || !layout.tiles) {
+			return monitors;
+		}
+		const ids = layout.tiles.map((tile) => tile.monitor);
+		const index = (monitor) => {
+			const i = ids.indexOf(monitor["id"]);
+			return i === -1 ? ids.length : i;
+		};
+		return [...monitors].sort((a, b) => index(a) - index(b));
+	};
+
 	const sortedMonitors = sortByName(monitors);
 	let preferLowRes = false;
 	let feeds = [];
@@ -40,6 +60,9 @@ function newViewer($parent, monitors, hls) {
 		setMonitors(input) {
 			selectedMonitors = input;
 		},
+		setLayout(input) {
+			layout = input;
+		},
 		setPreferLowRes(bool) {
 			preferLowRes = bool;
 		},
@@ -48,13 +71,15 @@ function newViewer($parent, monitors, hls) {
 				feed.destroy();
 			}
 			feeds = [];
-			for (const monitor of Object.values(sortedMonitors)) {
+			const tiles = [];
+			for (const monitor of orderByLayout(Object.values(sortedMonitors))) {
 				if (!isMonitorSelected(monitor)) {
 					continue;
 				}
 				if (monitor["enable"] !== "true") {
 					continue;
 				}
+				tiles.push(tileOf(monitor));
 
 				const recordingsPath = toAbsolutePath("recordings");
 				const buttons = [
@@ -74,6 +99,20 @@ function newViewer($parent, monitors, hls) {
 			for (const feed of feeds) {
 				feed.init($parent);
 			}
+
+			// The columns of the layout override the grid size.
+			if (layout && layout.columns > 0) {
+				$parent.style.setProperty("--gridsize", layout.columns);
+			} else {
+				$parent.style.removeProperty("--gridsize");
+			}
+			for (const [i, tile] of tiles.entries()) {
+				const $item = $parent.children[i];
+				if (tile && $item) {
+					$item.style.gridColumn = `span ${tile.width}`;
+					$item.style.gridRow = `span ${tile.height}`;
+				}
+			}
 		},
 	};
 }
@@ -129,7 +168,7 @@ function init() {
 	const buttons = [
 		newOptionsBtn.gridSize(),
 		resBtn(),
-		newOptionsBtn.group(monitors, groups),
+		newOptionsBtn.group(groups),
 	];
 	const optionsMenu = newOptionsMenu(buttons);
 	$options.innerHTML = optionsMenu.html;
@@ -39,6 +39,9 @@ async function newViewer(monitorNameByID, $parent, timeZone, isAdmin, token) {
 			d.videoPath = toAbsolutePath(`api/recording/video/${d.id}`);
 			d.thumbPath = toAbsolutePath(`api/recording/thumbnail/${d.id}`);
 			d.deletePath = toAbsolutePath(`api/recording/delete/${d.id}`);
+			d.flagPath = toAbsolutePath(`api/recording/flag/${d.id}`);
+			d.bookmarkPath = toAbsolutePath("api/bookmark/set");
+			d.flagged = rec.flagged === true;
 			d.name = await monitorNameByID(d.id.slice(20));
 			d.timeZone = timeZone;
 
@@ -659,16 +659,22 @@ function newUser(token, fields) {
 		form.reset();
 
 		let id = navElement.attributes.data.value;
-		let username, isAdmin, title;
+		let username, isAdmin, role, monitors, groups, title;
 
 		if (id === "") {
 			id = randomString(16);
 			title = "Add";
 			username = "";
 			isAdmin = "false";
+			role = "full";
+			monitors = [];
+			groups = [];
 		} else {
 			username = users[id]["username"];
 			isAdmin = String(users[id]["isAdmin"]);
+			role = users[id]["role"] || "full";
+			monitors = users[id]["monitors"] || [];
+			groups = users[id]["groups"] || [];
 			title = username;
 		}
 
@@ -676,6 +682,9 @@ function newUser(token, fields) {
 		form.fields.id.value = id;
 		form.fields.username.set(username);
 		form.fields.isAdmin.set(isAdmin);
+		form.fields.role.set(role);
+		form.fields.monitors.set(JSON.stringify(monitors));
+		form.fields.groups.set(JSON.stringify(groups));
 	};
 
 	const renderUserList = (users) => {
@@ -721,6 +730,9 @@ function newUser(token, fields) {
 			id: form.fields.id.value,
 			username: form.fields.username.value(),
 			isAdmin: form.fields.isAdmin.value() === "true",
+			role: form.fields.role.value(),
+			monitors: JSON.parse(form.fields.monitors.value()),
+			groups: JSON.parse(form.fields.groups.value()),
 			plainPassword: form.fields.password.value(),
 		};
 
@@ -783,6 +795,260 @@ function newUser(token, fields) {
 	};
 }
 
+function newToken(token, fields, scopes) {
+	const name = "tokens";
+	const title = "API tokens";
+	const icon = "static/icons/feather/key.svg";
+
+	const category = newCategory(name, title);
+	const form = newForm(fields);
+	form.addButton("save");
+	form.addButton("delete");
+	category.setForm(form);
+
+	const tokenLoad = (navElement, tokens) => {
+		form.reset();
+
+		let id = navElement.attributes.data.value;
+		let tokenName, tokenScopes, title;
+
+		if (id === "") {
+			id = randomString(16);
+			title = "Add";
+			tokenName = "";
+			tokenScopes = [];
+		} else {
+			tokenName = tokens[id]["name"];
+			tokenScopes = tokens[id]["scopes"];
+			title = tokenName;
+		}
+
+		category.setTitle(title);
+		form.fields.id.value = id;
+		form.fields.name.set(tokenName);
+		for (const scope of scopes) {
+			form.fields[scope].set(String(tokenScopes.includes(scope)));
+		}
+	};
+
+	const renderTokenList = (tokens) => {
+		let html = "";
+
+		for (const t of sortByName(tokens)) {
+			html += `
+				<li
+					class="settings-category-nav-item js-nav"
+					data="${t.id}"
+				>
+					<span>${t.name}</span>
+				</li>`;
+		}
+
+		html += `
+			<button class="settings-add-btn js-nav" data="">
+				<span>Add</span>
+			</button>`;
+
+		category.setNav(html);
+		category.onNav((element) => {
+			tokenLoad(element, tokens);
+		});
+	};
+
+	const load = async () => {
+		category.closeSubcategory();
+		const tokens = await fetchGet("api/tokens", "could not get tokens");
+		renderTokenList(tokens);
+	};
+
+	const saveToken = async (form) => {
+		const err = form.validate();
+		if (err !== "") {
+			alert(`invalid form: ${err}`);
+			return;
+		}
+		const req = {
+			id: form.fields.id.value,
+			name: form.fields.name.value(),
+			scopes: scopes.filter((scope) => form.fields[scope].value() === "true"),
+		};
+
+		const response = await fetch("api/token/set", {
+			body: JSON.stringify(req),
+			headers: {
+				"Content-Type": "application/json",
+				"X-CSRF-TOKEN": token,
+			},
+			method: "put",
+		});
+		if (response.status !== 200) {
+			alert(`could not save token: ${response.status}, ${await response.text()}`);
+			return;
+		}
+
+		// The token is only returned once.
+		const res = await response.json();
+		if (res.token) {
+			prompt("Copy the token, it will not be shown again.", res.token);
+		}
+
+		load();
+	};
+
+	const deleteToken = async (id) => {
+		const params = new URLSearchParams({ id: id });
+
+		const ok = await fetchDelete(
+			"api/token/delete?" + params,
+			token,
+			"could not revoke token"
+		);
+		if (!ok) {
+			return;
+		}
+
+		load();
+	};
+
+	const init = () => {
+		category.init();
+		form.buttons()["save"].onClick(() => {
+			saveToken(form);
+		});
+
+		form.buttons()["delete"].onClick(() => {
+			if (confirm("revoke token?")) {
+				deleteToken(form.fields.id.value);
+			}
+		});
+	};
+
+	return {
+		name() {
+			return name;
+		},
+		title() {
+			return title;
+		},
+		icon() {
+			return icon;
+		},
+		html() {
+			return category.html();
+		},
+		init($parent) {
+			init($parent);
+		},
+		open() {
+			category.open();
+			load();
+		},
+	};
+}
+
+function newSession(token, fields) {
+	const name = "sessions";
+	const title = "Sessions";
+	const icon = "static/icons/feather/activity.svg";
+
+	const category = newCategory(name, title);
+	const form = newForm(fields);
+	form.addButton("delete");
+	category.setForm(form);
+
+	const sessionLoad = (navElement, sessions) => {
+		form.reset();
+
+		const id = navElement.attributes.data.value;
+		const s = sessions[id];
+
+		category.setTitle(s.username);
+		form.fields.id.value = id;
+		form.fields.username.set(s.username);
+		form.fields.ip.set(s.ip);
+		form.fields.userAgent.set(s.userAgent);
+		form.fields.created.set(s.created);
+		form.fields.lastSeen.set(s.lastSeen);
+		form.fields.expires.set(s.expires);
+	};
+
+	const renderSessionList = (sessions) => {
+		let html = "";
+
+		for (const s of Object.values(sessions)) {
+			const current = s.current ? " (current)" : "";
+			html += `
+				<li
+					class="settings-category-nav-item js-nav"
+					data="${s.id}"
+				>
+					<span>${s.username} - ${s.ip}${current}</span>
+				</li>`;
+		}
+
+		category.setNav(html);
+		category.onNav((element) => {
+			sessionLoad(element, sessions);
+		});
+	};
+
+	const load = async () => {
+		category.closeSubcategory();
+		const list = await fetchGet("api/sessions", "could not get sessions");
+		const sessions = {};
+		for (const s of list) {
+			sessions[s.id] = s;
+		}
+		renderSessionList(sessions);
+	};
+
+	const revokeSession = async (id) => {
+		const params = new URLSearchParams({ id: id });
+
+		const ok = await fetchDelete(
+			"api/session/revoke?" + params,
+			token,
+			"could not revoke session"
+		);
+		if (!ok) {
+			return;
+		}
+
+		load();
+	};
+
+	const init = () => {
+		category.init();
+		form.buttons()["delete"].onClick(() => {
+			if (confirm("revoke session?")) {
+				revokeSession(form.fields.id.value);
+			}
+		});
+	};
+
+	return {
+		name() {
+			return name;
+		},
+		title() {
+			return title;
+		},
+		icon() {
+			return icon;
+		},
+		html() {
+			return category.html();
+		},
+		init($parent) {
+			init($parent);
+		},
+		open() {
+			category.open();
+			load();
+		},
+	};
+}
+
 function randomString(length) {
 	var charSet = "234565789abcdefghjkmnpqrstuvwxyz";
 	var output = "";
@@ -793,6 +1059,17 @@ function randomString(length) {
 }
 
 function newSelectMonitor(id) {
+	const fetchList = () => fetchGet("api/monitor/list", "could not fetch monitor list");
+	return newSelectList(id, "Monitors", fetchList);
+}
+
+function newSelectGroup(id) {
+	const fetchList = () => fetchGet("api/group/configs", "could not fetch group config");
+	return newSelectList(id, "Groups", fetchList);
+}
+
+// Field to select items from a object of items with "id" and "name" keys.
+function newSelectList(id, label, fetchList) {
 	const newField = (id, name) => {
 		let $checkbox;
 		return {
@@ -817,7 +1094,7 @@ function newSelectMonitor(id) {
 		};
 	};
 
-	const modal = newModal("Monitors");
+	const modal = newModal(label);
 
 	let value;
 	let fields = {};
@@ -826,17 +1103,13 @@ function newSelectMonitor(id) {
 		if (isRendered) {
 			return;
 		}
-		const monitorsList = await fetchGet(
-			"api/monitor/list",
-			"could not fetch monitor list"
-		);
+		const list = await fetchList();
 
 		fields = {};
 		let html = "";
-		const sortedMonitors = sortByName(monitorsList);
-		for (const monitor of sortedMonitors) {
-			const id = monitor["id"];
-			const field = newField(id, monitor["name"]);
+		for (const item of sortByName(list)) {
+			const id = item["id"];
+			const field = newField(id, item["name"]);
 			html += field.html;
 			fields[id] = field;
 		}
@@ -867,7 +1140,7 @@ function newSelectMonitor(id) {
 	return {
 		html: `
 			<li id="${id}" class="form-field-flex">
-				<label class="form-field-label" for="${id}">Monitors</label>
+				<label class="form-field-label" for="${id}">${label}</label>
 				<button class="form-field-edit-btn color3">
 					<img src="static/icons/feather/edit-3.svg"/>
 				</button>
@@ -902,4 +1175,14 @@ function newSelectMonitor(id) {
 	};
 }
 
-export { newRenderer, newGeneral, newMonitor, newGroup, newUser, newSelectMonitor };
+export {
+	newRenderer,
+	newGeneral,
+	newMonitor,
+	newGroup,
+	newUser,
+	newToken,
+	newSession,
+	newSelectMonitor,
+	newSelectGroup,
+};
@@ -32,7 +32,10 @@ import {
 	newMonitor,
 	newGroup,
 	newUser,
+	newToken,
+	newSession,
 	newSelectMonitor,
+	newSelectGroup,
 } from "./static/scripts/settings.mjs";
 
 // Globals.
@@ -69,9 +72,19 @@ if (isAdmin) {
 		),
 		name: fieldTemplate.text("Name", "my_monitor"),
 		enable: fieldTemplate.toggle("Enable monitor", "true"),
+		inputSource: fieldTemplate.select(
+			"Input source",
+			["ffmpeg", "rtmp", "push", "srt", "mjpeg", "file"],
+			"ffmpeg"
+		),
 		inputOptions: newSelectCustomField([], ["", "-rtsp_transport tcp"], {
 			label: "Input options",
 		}),
+		rtspTransport: fieldTemplate.select(
+			"RTSP transport",
+			["default", "tcp", "udp", "multicast"],
+			"default"
+		),
 		mainInput: newField(
 			[inputRules.notEmpty],
 			{
@@ -92,6 +105,160 @@ if (isAdmin) {
 				placeholder: "rtsp//x.x.x.x/sub (optional)",
 			}
 		),
+		mainInputBackups: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "Main input backups",
+				placeholder: "rtsp://x.x.x.y/main (optional)",
+			}
+		),
+		subInputBackups: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "Sub input backups",
+				placeholder: "rtsp://x.x.x.y/sub (optional)",
+			}
+		),
+		failbackInterval: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Fail-back interval (sec)",
+				placeholder: "60",
+			}
+		),
+		detectStream: fieldTemplate.select(
+			"Detect stream",
+			["auto", "main", "sub"],
+			"auto"
+		),
+		rtspsCA: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "RTSPS CA file",
+				placeholder: "/path/to/ca.pem (optional)",
+			}
+		),
+		rtspsFingerprint: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "RTSPS certificate fingerprint",
+				placeholder: "SHA-256 (optional)",
+			}
+		),
+		srtMode: fieldTemplate.select("SRT mode", ["caller", "listener"], "caller"),
+		srtPassphrase: newField(
+			[],
+			{
+				input: "text",
+			},
+			{
+				label: "SRT passphrase",
+				placeholder: "(optional)",
+			}
+		),
+		srtLatency: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "1",
+			},
+			{
+				label: "SRT latency (ms)",
+				placeholder: "120",
+			}
+		),
+		v4l2InputFormat: newSelectCustomField([], ["", "mjpeg", "h264", "yuyv422"], {
+			label: "V4L2 input format",
+		}),
+		v4l2Resolution: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "text",
+			},
+			{
+				label: "V4L2 resolution",
+				placeholder: "1280x720",
+			}
+		),
+		v4l2FrameRate: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "1",
+				step: "1",
+			},
+			{
+				label: "V4L2 frame rate",
+				placeholder: "30",
+			}
+		),
+		reconnectMinDelay: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Reconnect min delay (sec)",
+				placeholder: "1",
+			}
+		),
+		reconnectMaxDelay: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "any",
+			},
+			{
+				label: "Reconnect max delay (sec)",
+				placeholder: "60",
+			}
+		),
+		reconnectJitter: newField(
+			[inputRules.noSpaces],
+			{
+				errorField: true,
+				input: "number",
+				min: "0",
+				step: "1",
+			},
+			{
+				label: "Reconnect jitter (%)",
+				placeholder: "20",
+			}
+		),
+		hwaccelType: fieldTemplate.select(
+			"Hardware acceleration type",
+			["none", "vaapi", "nvenc", "v4l2m2m"],
+			"none"
+		),
+		hwaccelDevice: fieldTemplate.text("Hardware acceleration device", "/dev/dri/renderD128", ""),
 		hwaccel: newField(
 			[],
 			{
@@ -105,6 +272,7 @@ if (isAdmin) {
 			"Video encoder",
 			[
 				"copy",
+				"hardware",
 				"libx264 -preset veryfast",
 				"libx264 -preset medium",
 				"libx264 -preset veryslow",
@@ -116,8 +284,28 @@ if (isAdmin) {
 			["none", "copy", "aac"],
 			"none"
 		),
+		audioLanguages: fieldTemplate.text("Audio languages", "eng,swe", ""),
+		recordAudio: fieldTemplate.toggle("Record audio", "true"),
+		audioOnly: fieldTemplate.toggle("Audio only", "false"),
+		backchannel: fieldTemplate.toggle("Two-way audio", "false"),
+		hlsPartDuration: fieldTemplate.text("Live part duration (ms)", "300", ""),
 		alwaysRecord: fieldTemplate.toggle("Always record", "false"),
 		videoLength: fieldTemplate.text("Video length (min)", "15", "15"),
+		preEventBuffer: fieldTemplate.text("Pre-event buffer (sec)", "5", ""),
+		eventCooldown: fieldTemplate.text("Event cooldown (sec)", "10", ""),
+		maxRecordingDuration: fieldTemplate.text("Max recording duration (min)", "60", ""),
+		recordingContainer: fieldTemplate.select("Recording container", ["mp4", "mkv"], "mp4"),
+		continuousRecording: fieldTemplate.toggle("Continuous recording", "false"),
+		segmentLength: fieldTemplate.integer("Segment length (sec)", "60", "60"),
+		skipStaticSegments: fieldTemplate.toggle("Skip static segments", "false"),
+		eventRetention: fieldTemplate.text("Event retention (days)", "90", ""),
+		continuousRetention: fieldTemplate.text("Continuous retention (days)", "7", ""),
+		maxDiskShare: fieldTemplate.text("Max disk share (%)", "25", ""),
+		recordingPriority: fieldTemplate.select("Recording priority", ["normal", "low"], "normal"),
+		armDisarmed: fieldTemplate.select("Disarmed", ["ignore", "record", "alert"], "record"),
+		armHome: fieldTemplate.select("Armed home", ["ignore", "record", "alert"], "record"),
+		armAway: fieldTemplate.select("Armed away", ["ignore", "record", "alert"], "alert"),
+		armNight: fieldTemplate.select("Armed night", ["ignore", "record", "alert"], "alert"),
 		timestampOffset: fieldTemplate.integer("Timestamp offset (ms)", "500", "500"),
 		logLevel: fieldTemplate.select(
 			"Log level",
@@ -164,11 +352,50 @@ if (isAdmin) {
 			}
 		),
 		isAdmin: fieldTemplate.toggle("Admin"),
+		role: fieldTemplate.select("Role", ["full", "recordings", "live"], "full"),
+		monitors: newSelectMonitor("settings-user-monitors"),
+		groups: newSelectGroup("settings-user-groups"),
 		password: newPasswordField(),
 	};
 	const user = newUser(csrfToken, userFields);
 	renderer.addCategory(user);
 
+	const tokenScopes = [
+		"recordings:read",
+		"recordings:write",
+		"monitors:read",
+		"monitors:write",
+		"live:view",
+		"events:read",
+		"arming:write",
+		"admin",
+	];
+	const tokenFields = {
+		id: {
+			value: "",
+		},
+		name: fieldTemplate.text("Name", "home-assistant"),
+	};
+	for (const scope of tokenScopes) {
+		tokenFields[scope] = fieldTemplate.toggle(scope, "false");
+	}
+	const apiToken = newToken(csrfToken, tokenFields, tokenScopes);
+	renderer.addCategory(apiToken);
+
+	const sessionFields = {
+		id: {
+			value: "",
+		},
+		username: fieldTemplate.text("Username", ""),
+		ip: fieldTemplate.text("IP", ""),
+		userAgent: fieldTemplate.text("User agent", ""),
+		created: fieldTemplate.text("Created", ""),
+		lastSeen: fieldTemplate.text("Last seen", ""),
+		expires: fieldTemplate.text("Expires", ""),
+	};
+	const session = newSession(csrfToken, sessionFields);
+	renderer.addCategory(session);
+
 	renderer.render();
 	renderer.init();
 }